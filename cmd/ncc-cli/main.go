@@ -0,0 +1,20 @@
+// Command ncc-cli runs security checks, device imports, result exports and
+// rule validation against the desktop app's database without its UI, for
+// CI pipelines and scheduled jobs. Run ncc-cli help for the commands.
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"invictux-demo/internal/cli"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := cli.Run(ctx, os.Args[1:], os.Getenv, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
@@ -0,0 +1,257 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+)
+
+// localUserID is recorded as the actor of audit entries. The desktop app has
+// a single local user.
+const localUserID = "local"
+
+// Limits for GetRecentActivity
+const (
+	defaultActivityLimit = 20
+	maxActivityLimit     = 200
+)
+
+// ActivityType identifies the source of an activity feed entry
+type ActivityType string
+
+const (
+	ActivityDeviceAdded   ActivityType = "device_added"
+	ActivityDeviceUpdated ActivityType = "device_updated"
+	ActivityCheckRun      ActivityType = "check_run"
+	ActivityAudit         ActivityType = "audit"
+)
+
+// ActivityItem is one entry of the recent activity feed
+type ActivityItem struct {
+	Type        ActivityType `json:"type"`
+	Timestamp   time.Time    `json:"timestamp"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	EntityType  string       `json:"entityType,omitempty"`
+	EntityID    string       `json:"entityId,omitempty"`
+}
+
+// GetRecentActivity returns up to limit of the most recent device changes,
+// check runs and audit entries, newest first. Each source is queried for at
+// most limit entries and the results are merged here.
+func (a *App) GetRecentActivity(limit int) ([]ActivityItem, error) {
+	if limit <= 0 {
+		limit = defaultActivityLimit
+	}
+	if limit > maxActivityLimit {
+		limit = maxActivityLimit
+	}
+
+	items := []ActivityItem{}
+
+	if a.deviceManager != nil {
+		devices, err := a.deviceManager.GetRecentlyChangedDevices(limit)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, deviceActivity(devices)...)
+	}
+
+	if a.resultStore != nil {
+		runs, err := a.resultStore.GetRecentRuns(limit)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, a.checkRunActivity(runs)...)
+	}
+
+	if a.auditLogger != nil {
+		entries, err := a.auditLogger.GetAuditLog("", limit)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, auditActivity(entries)...)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Timestamp.After(items[j].Timestamp)
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items, nil
+}
+
+// deviceActivity describes the latest add or edit of each device
+func deviceActivity(devices []device.Device) []ActivityItem {
+	items := make([]ActivityItem, 0, len(devices))
+	for _, dev := range devices {
+		item := ActivityItem{
+			Type:        ActivityDeviceAdded,
+			Timestamp:   dev.CreatedAt,
+			Title:       fmt.Sprintf("Device %s added", dev.Name),
+			Description: fmt.Sprintf("%s %s at %s", dev.Vendor, dev.DeviceType, dev.IPAddress),
+			EntityType:  security.EntityDevice,
+			EntityID:    dev.ID,
+		}
+		// AddDevice stamps both times together, so any gap means a later edit
+		if dev.UpdatedAt.Sub(dev.CreatedAt) > time.Second {
+			item.Type = ActivityDeviceUpdated
+			item.Timestamp = dev.UpdatedAt
+			item.Title = fmt.Sprintf("Device %s updated", dev.Name)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// checkRunActivity describes each check run with its outcome counts
+func (a *App) checkRunActivity(runs []checker.RunSummary) []ActivityItem {
+	items := make([]ActivityItem, 0, len(runs))
+	for _, run := range runs {
+		item := ActivityItem{
+			Type:      ActivityCheckRun,
+			Timestamp: run.FinishedAt,
+			Title:     fmt.Sprintf("Security checks run on %d devices", run.DeviceCount),
+			Description: fmt.Sprintf("%d passed, %d failed, %d warnings, %d errors",
+				run.Passed, run.Failed, run.Warnings, run.Errors),
+			EntityType: security.EntityCheckRun,
+			EntityID:   run.RunID,
+		}
+		if run.DeviceID != "" {
+			item.Title = "Security checks run on a removed device"
+			if a.deviceManager != nil {
+				if dev, err := a.deviceManager.GetDevice(run.DeviceID); err == nil {
+					item.Title = fmt.Sprintf("Security checks run on %s", dev.Name)
+				}
+			}
+		}
+		if run.Label != "" {
+			item.Title = fmt.Sprintf("%s (%s)", item.Title, run.Label)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// auditActivity describes audit entries not already covered by device activity
+func auditActivity(entries []security.AuditEntry) []ActivityItem {
+	items := make([]ActivityItem, 0, len(entries))
+	for _, entry := range entries {
+		// Device adds and edits are reported from the devices themselves
+		if entry.EntityType == security.EntityDevice &&
+			(entry.ActionType == security.ActionCreate || entry.ActionType == security.ActionUpdate) {
+			continue
+		}
+		title := entry.Details
+		if title == "" {
+			title = fmt.Sprintf("%s %s", entry.EntityType, entry.ActionType)
+		}
+		items = append(items, ActivityItem{
+			Type:        ActivityAudit,
+			Timestamp:   entry.Timestamp,
+			Title:       title,
+			Description: fmt.Sprintf("%s by %s", entry.ActionType, entry.UserID),
+			EntityType:  entry.EntityType,
+			EntityID:    entry.EntityID,
+		})
+	}
+	return items
+}
+
+// QueryAuditLog returns one page of the audit entries matching q, for
+// compliance reviews such as every device change by a user in a date range
+func (a *App) QueryAuditLog(q security.AuditQuery) (*security.AuditPage, error) {
+	if a.auditLogger == nil {
+		return &security.AuditPage{Entries: []security.AuditEntry{}}, nil
+	}
+	return a.auditLogger.QueryAuditLog(q)
+}
+
+// recordAudit writes an audit entry for the local user. Audit failures are
+// logged and never fail the operation being audited.
+func (a *App) recordAudit(action, entityType, entityID, details string) {
+	if a.auditLogger == nil {
+		return
+	}
+
+	err := a.auditLogger.Log(security.AuditEntry{
+		UserID:     localUserID,
+		ActionType: action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Details:    details,
+	})
+	if err != nil {
+		log.Printf("Failed to record audit entry: %v", err)
+	}
+}
+
+// saveCheckResults persists the results of a check run. Storage failures are
+// logged so the caller still receives the results; the full output files of
+// results that were not saved are deleted.
+func (a *App) saveCheckResults(results []checker.CheckResult) {
+	if a.resultStore == nil {
+		checker.DiscardFullEvidence(results)
+		return
+	}
+
+	if err := a.resultStore.SaveResults(results); err != nil {
+		log.Printf("Failed to save check results: %v", err)
+		checker.DiscardFullEvidence(results)
+	}
+}
+
+// saveComplianceSnapshots records each device's compliance score after a
+// bulk run. Storage failures are logged like those of the results.
+func (a *App) saveComplianceSnapshots(results map[string][]checker.CheckResult) {
+	if a.resultStore == nil {
+		return
+	}
+
+	if err := a.resultStore.SaveComplianceSnapshots(results, time.Now()); err != nil {
+		log.Printf("Failed to save compliance snapshots: %v", err)
+	}
+}
+
+// recordInventory stores the model and OS version a check run found
+func (a *App) recordInventory(deviceID string, inventory checker.DeviceInventory) error {
+	if a.deviceManager == nil {
+		return fmt.Errorf("device manager not initialized")
+	}
+	return a.deviceManager.UpdateDeviceMetadata(deviceID, inventory.Model, inventory.OSVersion)
+}
+
+// emitStatusChange tells the frontend a check changed status. It does
+// nothing outside the Wails runtime, and stays quiet about sandbox devices.
+func (a *App) emitStatusChange(deviceID, checkName string, old, new checker.CheckStatus) {
+	if a.emitEvent == nil {
+		return
+	}
+	if a.deviceManager != nil {
+		if dev, err := a.deviceManager.GetDevice(deviceID); err == nil && dev.IsSandbox {
+			return
+		}
+	}
+	a.emitEvent(CheckStatusChangeEvent, CheckStatusChange{
+		DeviceID: deviceID, CheckName: checkName, OldStatus: old, NewStatus: new,
+	})
+}
+
+// saveRunMetadata stores the label and note a run was triggered with.
+// Storage failures are logged like those of the results.
+func (a *App) saveRunMetadata(runID string, opts checker.CheckOptions) {
+	if a.resultStore == nil {
+		return
+	}
+
+	if err := a.resultStore.SaveRunMetadata(runID, opts.Label, opts.Note); err != nil {
+		log.Printf("Failed to save run metadata: %v", err)
+	}
+}
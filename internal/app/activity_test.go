@@ -0,0 +1,156 @@
+package app
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDB returns a migrated in-memory database
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, database.RunMigrations(db))
+	return db
+}
+
+// newActivityTestApp returns an app backed by a migrated in-memory database
+func newActivityTestApp(t *testing.T) *App {
+	db := newTestDB(t)
+
+	return &App{
+		deviceManager: device.NewManager(db),
+		resultStore:   checker.NewResultStore(db),
+		auditLogger:   security.NewAuditLogger(db),
+	}
+}
+
+func TestApp_GetRecentActivity(t *testing.T) {
+	a := newActivityTestApp(t)
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+	a.recordAudit(security.ActionCreate, security.EntityDevice, router.ID, "Added device Core Router")
+
+	spare := &device.Device{Name: "Spare Switch", IPAddress: "10.0.0.2", DeviceType: string(device.TypeSwitch),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(spare))
+
+	time.Sleep(10 * time.Millisecond)
+	a.saveCheckResults([]checker.CheckResult{
+		{ID: "res1", DeviceID: router.ID, CheckName: "SSH", CheckType: "configuration",
+			Severity: string(checker.SeverityHigh), Status: string(checker.StatusPass),
+			CheckedAt: time.Now(), RunID: "run1"},
+		{ID: "res2", DeviceID: router.ID, CheckName: "Telnet", CheckType: "configuration",
+			Severity: string(checker.SeverityHigh), Status: string(checker.StatusFail),
+			CheckedAt: time.Now(), RunID: "run1"},
+	})
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, a.DeleteDevice(spare.ID))
+
+	items, err := a.GetRecentActivity(10)
+	require.NoError(t, err)
+
+	// Deletion, check run, then the router add. The deleted device is gone and
+	// the router's create audit entry is covered by its device entry.
+	require.Len(t, items, 3)
+
+	assert.Equal(t, ActivityAudit, items[0].Type)
+	assert.Equal(t, "Deleted device Spare Switch", items[0].Title)
+	assert.Equal(t, spare.ID, items[0].EntityID)
+
+	assert.Equal(t, ActivityCheckRun, items[1].Type)
+	assert.Equal(t, "Security checks run on Core Router", items[1].Title)
+	assert.Equal(t, "1 passed, 1 failed, 0 warnings, 0 errors", items[1].Description)
+
+	assert.Equal(t, ActivityDeviceAdded, items[2].Type)
+	assert.Equal(t, router.ID, items[2].EntityID)
+
+	limited, err := a.GetRecentActivity(1)
+	require.NoError(t, err)
+	assert.Len(t, limited, 1)
+	assert.Equal(t, ActivityAudit, limited[0].Type)
+}
+
+func TestApp_GetRecentActivityUninitialized(t *testing.T) {
+	a := &App{}
+
+	items, err := a.GetRecentActivity(0)
+	require.NoError(t, err)
+	assert.NotNil(t, items)
+	assert.Empty(t, items)
+}
+
+func TestApp_EmitsCheckStatusChanges(t *testing.T) {
+	a := newActivityTestApp(t)
+	a.resultStore.SetStatusTransitionHook(a.emitStatusChange)
+	var changes []CheckStatusChange
+	a.emitEvent = func(name string, data ...interface{}) {
+		require.Equal(t, CheckStatusChangeEvent, name)
+		changes = append(changes, data[0].(CheckStatusChange))
+	}
+
+	result := func(status checker.CheckStatus, checkedAt time.Time) checker.CheckResult {
+		return checker.CheckResult{ID: string(status) + checkedAt.String(), DeviceID: "device1", CheckName: "SSH Version",
+			Severity: string(checker.SeverityHigh), Status: string(status), CheckedAt: checkedAt}
+	}
+	base := time.Now().Add(-time.Hour)
+	a.saveCheckResults([]checker.CheckResult{result(checker.StatusPass, base)})
+	a.saveCheckResults([]checker.CheckResult{result(checker.StatusPass, base.Add(time.Minute))})
+	assert.Empty(t, changes, "the status did not change")
+
+	a.saveCheckResults([]checker.CheckResult{result(checker.StatusFail, base.Add(2*time.Minute))})
+	assert.Equal(t, []CheckStatusChange{{DeviceID: "device1", CheckName: "SSH Version",
+		OldStatus: checker.StatusPass, NewStatus: checker.StatusFail}}, changes)
+
+	// Outside the Wails runtime nothing is emitted
+	a.emitEvent = nil
+	a.saveCheckResults([]checker.CheckResult{result(checker.StatusPass, base.Add(3*time.Minute))})
+	assert.Len(t, changes, 1)
+}
+
+func TestApp_GetCheckResultEvidence(t *testing.T) {
+	a := newActivityTestApp(t)
+	dir := t.TempDir()
+
+	full := filepath.Join(dir, "snippet.txt")
+	require.NoError(t, os.WriteFile(full, []byte("line 1\nline 2\nline 3\n"), 0600))
+	result := func(id, evidence, path string) checker.CheckResult {
+		return checker.CheckResult{ID: id, DeviceID: "device1", CheckName: "SSH Version " + id,
+			Severity: string(checker.SeverityHigh), Status: string(checker.StatusPass), CheckedAt: time.Now(),
+			Evidence: evidence, EvidenceFullPath: path}
+	}
+	a.saveCheckResults([]checker.CheckResult{result("snippet", "line 2", full), result("whole", "line 1", "")})
+
+	evidence, err := a.GetCheckResultEvidence("snippet")
+	require.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2\nline 3\n", evidence)
+	evidence, err = a.GetCheckResultEvidence("whole")
+	require.NoError(t, err)
+	assert.Equal(t, "line 1", evidence)
+	_, err = a.GetCheckResultEvidence("missing")
+	assert.ErrorIs(t, err, checker.ErrResultNotFound)
+
+	// The files of results that could not be saved are deleted
+	unsaved := filepath.Join(dir, "unsaved.txt")
+	require.NoError(t, os.WriteFile(unsaved, []byte("full output"), 0600))
+	a.saveCheckResults([]checker.CheckResult{result("snippet", "line 2", unsaved)})
+	assert.NoFileExists(t, unsaved)
+	assert.FileExists(t, full)
+}
@@ -2,13 +2,25 @@ package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"invictux-demo/internal/checker"
 	"invictux-demo/internal/database"
 	"invictux-demo/internal/device"
+	"invictux-demo/internal/keystore"
+	"invictux-demo/internal/ratelimit"
+	"invictux-demo/internal/rotation"
 	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // App struct represents the main application
@@ -16,67 +28,132 @@ type App struct {
 	ctx               context.Context
 	db                *database.DB
 	deviceManager     *device.Manager
+	ruleManager       *checker.RuleManager
 	checkEngine       *checker.Engine
 	scanner           *device.ConnectivityScanner
+	sshClient         *ssh.SSHClient
+	resultStore       *checker.ResultStore
+	snapshotStore     *checker.SnapshotStore
+	postureStore      *checker.PostureStore
+	connectionMetrics *checker.ConnectionMetricsStore
+	overrideManager   *checker.OverrideManager
+	macroManager      *checker.MacroManager
+	auditLogger       *security.AuditLogger
+	rotationManager   *rotation.RotationManager
 	encryptionManager *security.EncryptionManager
 	sessionManager    *security.SessionManager
+	keyStore          keystore.Store
+	passphrasePrompt  PassphrasePrompt
 	environment       string
+	dataDir           string
+	simulationMode    bool
+	locale            string
+
+	// startupStatus is the outcome of the last startup or repair
+	startupMutex  sync.RWMutex
+	startupStatus *StartupStatus
+
+	// emitEvent sends an event to the frontend; nil outside the Wails runtime
+	emitEvent func(name string, data ...interface{})
+
+	// interactive tracks keyboard-interactive logins and their sessions
+	interactive interactiveState
+
+	// rateLimiter bounds how often the frontend may call expensive bindings
+	rateLimiter *ratelimit.AppRateLimiter
+
+	// storage tracks the size of the data directory against its limits
+	storage storageState
+
+	// session is the scoped session whose role gates the bindings
+	session sessionState
+
+	// passphrase is the encryption passphrase submitted by the frontend
+	passphrase passphraseState
 }
 
-// NewApp creates a new App application struct
+// AppVersion is the application version. main sets it to productVersion in
+// wails.json; builds that do not, such as tests, report "dev".
+var AppVersion = "dev"
+
+// DataDirEnvVar names the environment variable that overrides the default
+// data directory
+const DataDirEnvVar = "NCC_DATA_DIR"
+
+// CheckResultEvent is emitted with each checker.CheckResult of a run started
+// by StreamDeviceChecks
+const CheckResultEvent = "check:result"
+
+// CheckStatusChangeEvent is emitted with a CheckStatusChange whenever a saved
+// result changes the status of a check on a device
+const CheckStatusChangeEvent = "check:statusChange"
+
+// CheckStatusChange is a check on a device changing status between its last
+// stored result and a new one
+type CheckStatusChange struct {
+	DeviceID  string              `json:"deviceId"`
+	CheckName string              `json:"checkName"`
+	OldStatus checker.CheckStatus `json:"oldStatus"`
+	NewStatus checker.CheckStatus `json:"newStatus"`
+}
+
+// NewApp creates a new App application struct. Its data directory comes from
+// DataDirEnvVar, or the default data directory when that is unset.
 func NewApp(env string) *App {
+	return NewAppWithDataDir(env, "")
+}
+
+// NewAppWithDataDir creates an App keeping its database in dir. An empty dir
+// behaves like NewApp.
+func NewAppWithDataDir(env, dir string) *App {
 	return &App{
 		environment: env,
+		keyStore:    keystore.NewKeyringStore(),
+		dataDir:     dir,
 	}
 }
 
 // Startup is called at application startup
 func (a *App) Startup(ctx context.Context) {
 	a.ctx = ctx
+	a.emitEvent = func(name string, data ...interface{}) {
+		runtime.EventsEmit(ctx, name, data...)
+	}
 
 	// Initialize database
-	dataDir, err := database.GetDataDir()
+	dataDir, err := a.resolveDataDir()
 	if err != nil {
-		log.Printf("Failed to get data directory: %v", err)
+		a.setStartupStatus(&StartupStatus{
+			DatabaseStatus:        DatabaseUnavailable,
+			ExpectedSchemaVersion: database.LatestSchemaVersion(),
+			Errors:                []string{fmt.Sprintf("failed to get data directory: %v", err)},
+		})
 		return
 	}
+	a.dataDir = dataDir
 
-	a.db, err = database.NewSQLiteDB(dataDir)
-	if err != nil {
-		log.Printf("Failed to initialize database: %v", err)
-		return
+	if status := a.initialize(ctx); status.Ready {
+		a.finishStartup(ctx)
 	}
+}
 
-	// Run database migrations
-	if err := database.RunMigrations(a.db.DB); err != nil {
-		log.Printf("Failed to run migrations: %v", err)
-		return
-	}
+// finishStartup starts the background work of an app that is ready
+func (a *App) finishStartup(ctx context.Context) {
+	log.Printf("Network Configuration Checker initialized successfully in %s mode\n", a.environment)
+	a.reportRuleHealth()
+	a.startStorageSampler(ctx)
+}
 
-	// Initialize security components
-	// TODO: In production, this should be configurable or derived from user input
-	encryptionKey := "default-app-key-change-in-production"
-	if a.environment == "staging" {
-		log.Println("Using staging encryption key.")
-		encryptionKey = "staging-app-key-for-testing-only"
+// resolveDataDir returns the data directory given to the constructor, else
+// the one named by DataDirEnvVar, else the default
+func (a *App) resolveDataDir() (string, error) {
+	if a.dataDir != "" {
+		return a.dataDir, nil
 	}
-	a.encryptionManager = security.NewEncryptionManager(encryptionKey)
-	a.sessionManager = security.NewSessionManager(30 * time.Minute) // 30 minute session timeout
-
-	// Initialize components
-	a.deviceManager = device.NewManager(a.db.DB)
-
-	// Initialize rule manager and load predefined rules
-	ruleManager := checker.NewRuleManager(a.db.DB)
-	if err := ruleManager.LoadPredefinedRules(); err != nil {
-		log.Printf("Failed to load predefined rules: %v", err)
-		// Continue anyway, rules can be loaded later
+	if dir := strings.TrimSpace(os.Getenv(DataDirEnvVar)); dir != "" {
+		return dir, nil
 	}
-
-	a.checkEngine = checker.NewEngine(ruleManager)
-	a.scanner = device.NewConnectivityScanner()
-
-	log.Printf("Network Configuration Checker initialized successfully in %s mode\n", a.environment)
+	return database.GetDataDir()
 }
 
 // GetEnvironment returns the current application environment (production, staging, etc.)
@@ -96,24 +173,101 @@ func (a *App) BeforeClose(ctx context.Context) (prevent bool) {
 
 // Shutdown is called at application termination
 func (a *App) Shutdown(ctx context.Context) {
+	a.stopStorageSampler()
+	a.closeInteractiveSessions()
+	if a.sshClient != nil {
+		a.sshClient.Close()
+	}
 	if a.db != nil {
 		a.db.Close()
 	}
 	log.Println("Network Configuration Checker shutdown complete")
 }
 
+// requestTimeout bounds the database work of one call from the frontend
+const requestTimeout = 30 * time.Second
+
+// appContext returns the context the app was started with, or the
+// background context before Startup
+func (a *App) appContext() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+	return a.ctx
+}
+
+// requestContext returns the context the database work of one call runs
+// under. It ends with the app's context or after requestTimeout.
+func (a *App) requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(a.appContext(), requestTimeout)
+}
+
 // Device Management Methods
 
 // GetDevices returns all network devices
-func (a *App) GetDevices() ([]device.Device, error) {
+func (a *App) GetDevices() ([]device.DeviceDTO, error) {
+	if a.deviceManager == nil {
+		return []device.DeviceDTO{}, nil
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	devices, err := a.deviceManager.GetAllDevicesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return device.ToDTOs(devices), nil
+}
+
+// GetDevicesIfChanged returns the device list only if it changed since the
+// client's change token, otherwise a NotModified response with the same token
+func (a *App) GetDevicesIfChanged(clientToken string) (*device.DeviceListResponse, error) {
 	if a.deviceManager == nil {
-		return []device.Device{}, nil
+		return &device.DeviceListResponse{Devices: []device.DeviceDTO{}}, nil
+	}
+	return a.deviceManager.GetDevicesIfChanged(clientToken)
+}
+
+// GetDevicesPage returns one page of devices for cursor pagination
+func (a *App) GetDevicesPage(cursor string, limit int) (*device.DevicePage, error) {
+	if a.deviceManager == nil {
+		return &device.DevicePage{Devices: []device.DeviceDTO{}}, nil
+	}
+	return a.deviceManager.GetDevicesPage(cursor, limit)
+}
+
+// SearchDevices returns one page of devices matching a global search
+func (a *App) SearchDevices(req device.DeviceSearchRequest) (*device.DevicePage, error) {
+	if a.deviceManager == nil {
+		return &device.DevicePage{Devices: []device.DeviceDTO{}}, nil
+	}
+	return a.deviceManager.SearchDevices(req)
+}
+
+// DeviceMetadata holds the values the device and rule forms offer, as the
+// backend validates them
+type DeviceMetadata struct {
+	Vendors     []device.Vendor     `json:"vendors"`
+	DeviceTypes []device.DeviceType `json:"deviceTypes"`
+	Severities  []checker.Severity  `json:"severities"`
+}
+
+// GetDeviceMetadata returns the valid vendors, device types and severity
+// levels, so the UI's dropdowns follow validation as vendors are added
+func (a *App) GetDeviceMetadata() DeviceMetadata {
+	return DeviceMetadata{
+		Vendors:     device.ValidVendors(),
+		DeviceTypes: device.ValidDeviceTypes(),
+		Severities:  append([]checker.Severity{}, checker.Severities...),
 	}
-	return a.deviceManager.GetAllDevices()
 }
 
 // AddDevice adds a new network device
 func (a *App) AddDevice(dev device.Device) error {
+	if err := a.requireRole(security.RoleAdmin, "AddDevice"); err != nil {
+		return err
+	}
 	if a.deviceManager == nil {
 		return nil
 	}
@@ -126,80 +280,1042 @@ func (a *App) AddDevice(dev device.Device) error {
 		log.Printf("Connectivity issues for device %s: %v", dev.Name, result.Error)
 	}
 
-	return a.deviceManager.AddDevice(&dev)
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	if err := a.deviceManager.AddDeviceContext(ctx, &dev); err != nil {
+		return err
+	}
+
+	a.recordAudit(security.ActionCreate, security.EntityDevice, dev.ID, fmt.Sprintf("Added device %s", dev.Name))
+	return nil
+}
+
+// ImportFromNetBox adds the devices listed by a NetBox instance. The import
+// is audited with its counts; imported devices need a password before they
+// can be checked.
+func (a *App) ImportFromNetBox(apiURL, apiToken string) (*device.ImportResult, error) {
+	if err := a.requireRole(security.RoleAdmin, "ImportFromNetBox"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if err := a.allowCall(ratelimit.MethodImportFromNetBox, ""); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil {
+		return nil, fmt.Errorf("device manager not initialized")
+	}
+
+	result, err := a.deviceManager.ImportFromNetBox(apiURL, apiToken, device.DeviceFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Imported > 0 {
+		a.recordAudit(security.ActionCreate, security.EntityDevice, "",
+			fmt.Sprintf("Imported %d devices from NetBox (%d skipped, %d failed)",
+				result.Imported, result.Skipped, len(result.Failed)))
+	}
+	return result, nil
+}
+
+// DeviceUpdateResult reports the outcome of a device update. When another
+// window saved the device first, Conflict is set and Current holds the stored
+// values so the UI can offer a merge.
+type DeviceUpdateResult struct {
+	Device   device.DeviceDTO  `json:"device"`
+	Conflict bool              `json:"conflict"`
+	Current  *device.DeviceDTO `json:"current,omitempty"`
+	Message  string            `json:"message,omitempty"`
 }
 
 // UpdateDevice updates an existing device
-func (a *App) UpdateDevice(dev device.Device) error {
+func (a *App) UpdateDevice(dev device.Device) (*DeviceUpdateResult, error) {
+	if err := a.requireRole(security.RoleAdmin, "UpdateDevice"); err != nil {
+		return nil, err
+	}
 	if a.deviceManager == nil {
-		return nil
+		return &DeviceUpdateResult{Device: dev.ToDTO()}, nil
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	if err := a.deviceManager.UpdateDeviceContext(ctx, &dev); err != nil {
+		var deviceErr *device.DeviceError
+		if errors.As(err, &deviceErr) && deviceErr.Type == device.ErrorTypeConflict {
+			result := &DeviceUpdateResult{
+				Device:   dev.ToDTO(),
+				Conflict: true,
+				Message:  deviceErr.Message,
+			}
+			if deviceErr.Current != nil {
+				current := deviceErr.Current.ToDTO()
+				result.Current = &current
+			}
+			return result, nil
+		}
+		return nil, err
 	}
-	return a.deviceManager.UpdateDevice(&dev)
+
+	a.recordAudit(security.ActionUpdate, security.EntityDevice, dev.ID, fmt.Sprintf("Updated device %s", dev.Name))
+	return &DeviceUpdateResult{Device: dev.ToDTO()}, nil
 }
 
 // DeleteDevice removes a device
 func (a *App) DeleteDevice(deviceID string) error {
+	if err := a.requireRole(security.RoleAdmin, "DeleteDevice"); err != nil {
+		return err
+	}
 	if a.deviceManager == nil {
 		return nil
 	}
-	return a.deviceManager.DeleteDevice(deviceID)
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	// Look the device up first so the audit entry can name it
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if err := a.deviceManager.DeleteDeviceContext(ctx, deviceID); err != nil {
+		return err
+	}
+	// The device's enable secret goes with it
+	if a.db != nil {
+		if _, err := a.db.ExecContext(ctx, "DELETE FROM app_settings WHERE key = ?", enableSecretKey(deviceID)); err != nil {
+			log.Printf("Failed to delete the enable secret of device %s: %v", dev.Name, err)
+		}
+	}
+
+	a.recordAudit(security.ActionDelete, security.EntityDevice, deviceID, fmt.Sprintf("Deleted device %s", dev.Name))
+	return nil
 }
 
-// TestDeviceConnectivity tests if a device is reachable
-func (a *App) TestDeviceConnectivity(deviceID string) error {
-	if a.deviceManager == nil || a.scanner == nil {
+// SetDeviceMaintenanceWindow replaces the weekly maintenance windows of a
+// device. Checks skip the device while it is inside one of them.
+func (a *App) SetDeviceMaintenanceWindow(deviceID string, windows []device.MaintenanceWindow) error {
+	if err := a.requireRole(security.RoleAdmin, "SetDeviceMaintenanceWindow"); err != nil {
+		return err
+	}
+	if a.deviceManager == nil {
 		return nil
 	}
+	ctx, cancel := a.requestContext()
+	defer cancel()
 
-	dev, err := a.deviceManager.GetDevice(deviceID)
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
 	if err != nil {
 		return err
 	}
 
-	result, err := a.scanner.TestConnectivity(dev)
+	if err := a.deviceManager.SetMaintenanceWindows(deviceID, windows); err != nil {
+		return err
+	}
+
+	a.recordAudit(security.ActionUpdate, security.EntityDevice, deviceID,
+		fmt.Sprintf("Set %d maintenance windows on device %s", len(windows), dev.Name))
+	return nil
+}
+
+// KeyboardResponseInput is a keyboard-interactive response as the frontend
+// submits it, before the response is encrypted
+type KeyboardResponseInput struct {
+	Prompt    string `json:"prompt"`
+	Response  string `json:"response"`
+	AllowEcho bool   `json:"allowEcho"`
+}
+
+// SetDeviceKeyboardResponses replaces the responses answering a device's
+// keyboard-interactive prompts, such as a one-time passcode or a second
+// secret. The responses are encrypted like the password; an empty list
+// clears them.
+func (a *App) SetDeviceKeyboardResponses(deviceID string, responses []KeyboardResponseInput) error {
+	if err := a.requireRole(security.RoleAdmin, "SetDeviceKeyboardResponses"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.deviceManager == nil || a.encryptionManager == nil {
+		return fmt.Errorf("device manager not initialized")
+	}
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
 	if err != nil {
 		return err
 	}
-	if result.Error != nil {
-		return result.Error
+
+	stored := make([]device.KeyboardResponse, len(responses))
+	for i, response := range responses {
+		if response.Response == "" {
+			return fmt.Errorf("keyboard response for prompt %q has no response", response.Prompt)
+		}
+		encrypted, err := a.encryptionManager.Encrypt(response.Response)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt keyboard response: %w", err)
+		}
+		stored[i] = device.KeyboardResponse{
+			Prompt:            strings.TrimSpace(response.Prompt),
+			AllowEcho:         response.AllowEcho,
+			ResponseEncrypted: encrypted,
+		}
+	}
+
+	if err := a.deviceManager.SetKeyboardResponses(deviceID, stored); err != nil {
+		return err
 	}
+
+	a.recordAudit(security.ActionUpdate, security.EntityDevice, deviceID,
+		fmt.Sprintf("Set %d keyboard-interactive responses on device %s", len(responses), dev.Name))
 	return nil
 }
 
+// SetDevicesSandbox marks devices as sandbox devices, or clears the mark.
+// Sandbox devices are still checked but are left out of scores, reports,
+// open findings and status change notifications. It returns how many
+// devices changed.
+func (a *App) SetDevicesSandbox(deviceIDs []string, sandbox bool) (int, error) {
+	if err := a.requireRole(security.RoleAdmin, "SetDevicesSandbox"); err != nil {
+		return 0, err
+	}
+	if a.deviceManager == nil {
+		return 0, nil
+	}
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	changed, err := a.deviceManager.SetSandboxContext(ctx, deviceIDs, sandbox)
+	if err != nil {
+		return 0, err
+	}
+
+	if changed > 0 {
+		details := fmt.Sprintf("Marked %d devices as sandbox devices: %s", changed, strings.Join(deviceIDs, ", "))
+		if !sandbox {
+			details = fmt.Sprintf("Cleared the sandbox mark of %d devices: %s", changed, strings.Join(deviceIDs, ", "))
+		}
+		a.recordAudit(security.ActionUpdate, security.EntityDevice, "", details)
+	}
+	return changed, nil
+}
+
+// GetDeviceStats returns aggregate device statistics for analytics
+func (a *App) GetDeviceStats() (*device.DeviceStats, error) {
+	if a.deviceManager == nil {
+		return &device.DeviceStats{
+			ByType:   map[string]int{},
+			ByVendor: map[string]int{},
+			ByStatus: map[string]int{},
+		}, nil
+	}
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	return a.deviceManager.GetDeviceStatsContext(ctx)
+}
+
+// TestDeviceConnectivity tests if a device is reachable and whether its stored
+// credentials are accepted. An SSH handshake is only attempted when the SSH port
+// is open, and no command is run on the device.
+func (a *App) TestDeviceConnectivity(deviceID string) (*ConnectionTestResult, error) {
+	if err := a.requireRole(security.RoleOperator, "TestDeviceConnectivity"); err != nil {
+		return nil, err
+	}
+	if err := a.allowCall(ratelimit.MethodTestDeviceConnectivity, deviceID); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.scanner == nil {
+		return &ConnectionTestResult{DeviceID: deviceID, Category: ConnectionUnknown}, nil
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	scan, err := a.scanner.TestConnectivity(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ConnectionTestResult{
+		DeviceID:    dev.ID,
+		Reachable:   scan.NetworkReachable,
+		SSHPortOpen: scan.SSHPortOpen,
+		TestedAt:    scan.TestedAt,
+	}
+
+	switch {
+	case !scan.NetworkReachable:
+		result.setCategory(ConnectionUnreachable)
+		return result, nil
+	case !scan.SSHPortOpen:
+		result.setCategory(ConnectionPortClosed)
+		return result, nil
+	case a.sshClient == nil:
+		result.setCategory(ConnectionUnknown)
+		return result, nil
+	}
+
+	connInfo, err := a.deviceConnectionInfo(dev)
+	if err != nil {
+		result.setCategory(ConnectionCredentials)
+		return result, nil
+	}
+
+	authCtx, authCancel := context.WithTimeout(ctx, a.scanner.GetTimeout())
+	defer authCancel()
+
+	authMethod, err := a.sshClient.ProbeAuthentication(authCtx, connInfo)
+	if err != nil {
+		result.setCategory(categorizeSSHError(err))
+		return result, nil
+	}
+
+	result.AuthOK = true
+	result.AuthMethod = authMethod
+	result.setCategory(ConnectionOK)
+	return result, nil
+}
+
+// PortScanReport is the outcome of a device port scan and the port exposure
+// check evaluated from it
+type PortScanReport struct {
+	Scan  *device.PortScanResult `json:"scan"`
+	Check checker.CheckResult    `json:"check"`
+}
+
+// ScanDevicePorts probes TCP ports of a device and checks that the SSH port
+// is the only one open. An empty port list scans device.DefaultScanPorts.
+func (a *App) ScanDevicePorts(deviceID string, ports []int) (*PortScanReport, error) {
+	if err := a.requireRole(security.RoleOperator, "ScanDevicePorts"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if err := a.requireStorage(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.scanner == nil {
+		return nil, fmt.Errorf("device scanner not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(a.appContext(), a.scanner.GetTimeout())
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	scan, err := a.scanner.ScanPorts(ctx, dev, ports)
+	if err != nil {
+		return nil, err
+	}
+
+	check := checker.EvaluatePortExposure(scan, []int{dev.SSHPort})
+	check.Message = check.RenderMessage(a.GetLocale())
+	a.saveCheckResults([]checker.CheckResult{check})
+	a.refreshStorage()
+
+	return &PortScanReport{Scan: scan, Check: check}, nil
+}
+
+// decryptDevicePassword returns the plaintext password stored for a device
+func (a *App) decryptDevicePassword(dev *device.Device) (string, error) {
+	if a.encryptionManager == nil || len(dev.PasswordEncrypted) == 0 {
+		return "", fmt.Errorf("no stored credentials for device %s", dev.Name)
+	}
+	return a.encryptionManager.Decrypt(dev.PasswordEncrypted)
+}
+
+// deviceAuthMethods are tried in order when logging in to a device, since
+// some only accept keyboard-interactive logins answered with the password
+var deviceAuthMethods = []ssh.AuthMethod{ssh.AuthPassword, ssh.AuthKeyboard}
+
+// deviceConnectionInfo returns the SSH connection details for a device with
+// its stored password and keyboard-interactive responses decrypted
+func (a *App) deviceConnectionInfo(dev *device.Device) (*ssh.ConnectionInfo, error) {
+	password, err := a.decryptDevicePassword(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]ssh.KeyboardResponse, len(dev.KeyboardResponses))
+	for i, stored := range dev.KeyboardResponses {
+		response, err := a.encryptionManager.Decrypt(stored.ResponseEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt keyboard response for device %s: %w", dev.Name, err)
+		}
+		responses[i] = ssh.KeyboardResponse{Prompt: stored.Prompt, Response: response, AllowEcho: stored.AllowEcho}
+	}
+
+	return &ssh.ConnectionInfo{
+		Host:              dev.IPAddress,
+		Port:              dev.SSHPort,
+		Username:          dev.Username,
+		Password:          password,
+		AuthMethod:        ssh.AuthPassword,
+		AuthMethods:       deviceAuthMethods,
+		KeyboardResponses: responses,
+		HostKeyCallback:   checker.DeviceHostKeyCallback(dev),
+	}, nil
+}
+
 // Security Check Methods
 
-// RunSecurityCheck runs security checks on a device
-func (a *App) RunSecurityCheck(deviceID string) ([]checker.CheckResult, error) {
+// RunSecurityCheck runs security checks on a device. The optional label
+// and note describe the run, for example "post-change CHG-5521".
+func (a *App) RunSecurityCheck(deviceID, label, note string) ([]checker.CheckResult, error) {
+	if err := a.requireRole(security.RoleOperator, "RunSecurityCheck"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if err := a.requireStorage(); err != nil {
+		return nil, err
+	}
+	if err := a.allowCall(ratelimit.MethodRunSecurityCheck, deviceID); err != nil {
+		return nil, err
+	}
 	if a.deviceManager == nil || a.checkEngine == nil {
 		return []checker.CheckResult{}, nil
 	}
 
-	dev, err := a.deviceManager.GetDevice(deviceID)
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := a.checkOptions()
+	opts.Label, opts.Note = label, note
+	results, err := a.checkEngine.RunChecksWithOptions(dev, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	a.saveCheckResults(results)
+	if len(results) > 0 {
+		a.saveRunMetadata(results[0].RunID, opts)
+	}
+	a.refreshStorage()
+	return results, nil
+}
+
+// StreamDeviceChecks runs security checks on a device and emits each
+// result as a CheckResultEvent as soon as it is ready. It returns when the
+// run ends, after the results have been saved.
+func (a *App) StreamDeviceChecks(deviceID string) error {
+	if err := a.requireRole(security.RoleOperator, "StreamDeviceChecks"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if err := a.requireStorage(); err != nil {
+		return err
+	}
+	if err := a.allowCall(ratelimit.MethodRunSecurityCheck, deviceID); err != nil {
+		return err
+	}
+	if a.deviceManager == nil || a.checkEngine == nil {
+		return fmt.Errorf("check engine not initialized")
+	}
+	if a.emitEvent == nil {
+		return fmt.Errorf("streaming checks needs the frontend")
+	}
+
+	ctx := a.appContext()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
 	if err != nil {
+		return err
+	}
+
+	opts := a.checkOptions()
+	stream, errs := a.checkEngine.RunChecksStreamWithOptions(ctx, dev, opts)
+	var results []checker.CheckResult
+	for result := range stream {
+		a.emitEvent(CheckResultEvent, result)
+		results = append(results, result)
+	}
+
+	// Whatever finished before a failure is kept
+	a.saveCheckResults(results)
+	if len(results) > 0 {
+		a.saveRunMetadata(results[0].RunID, opts)
+	}
+	a.refreshStorage()
+	return <-errs
+}
+
+// RunBulkSecurityChecks runs security checks on all devices as one run
+// with an optional label and note
+func (a *App) RunBulkSecurityChecks(label, note string) (map[string][]checker.CheckResult, error) {
+	if err := a.requireRole(security.RoleOperator, "RunBulkSecurityChecks"); err != nil {
 		return nil, err
 	}
+	opts := a.checkOptions()
+	opts.Label, opts.Note = label, note
+	return a.runBulkSecurityChecks(opts)
+}
 
-	return a.checkEngine.RunChecks(dev)
+// RunIncrementalSecurityChecks runs security checks on all devices as one
+// run, carrying forward the previous results of devices whose config has
+// not changed since their last incremental run. forceFull evaluates every
+// rule while still recording the configs.
+func (a *App) RunIncrementalSecurityChecks(label, note string, forceFull bool) (map[string][]checker.CheckResult, error) {
+	if err := a.requireRole(security.RoleOperator, "RunIncrementalSecurityChecks"); err != nil {
+		return nil, err
+	}
+	opts := a.checkOptions()
+	opts.Label, opts.Note = label, note
+	opts.Incremental, opts.ForceFull = true, forceFull
+	return a.runBulkSecurityChecks(opts)
 }
 
-// RunBulkSecurityChecks runs security checks on all devices
-func (a *App) RunBulkSecurityChecks() (map[string][]checker.CheckResult, error) {
+// runBulkSecurityChecks runs one bulk run with opts and saves its results
+func (a *App) runBulkSecurityChecks(opts checker.CheckOptions) (map[string][]checker.CheckResult, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if err := a.requireStorage(); err != nil {
+		return nil, err
+	}
+	if err := a.allowCall(ratelimit.MethodRunBulkSecurityChecks, ""); err != nil {
+		return nil, err
+	}
 	if a.deviceManager == nil || a.checkEngine == nil {
 		return make(map[string][]checker.CheckResult), nil
 	}
 
-	devices, err := a.deviceManager.GetAllDevices()
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	devices, err := a.deviceManager.GetAllDevicesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := a.checkEngine.RunBulkChecksWithOptions(devices, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every device shares the run ID of the bulk run
+	runID := ""
+	for _, deviceResults := range results {
+		a.saveCheckResults(deviceResults)
+		if runID == "" && len(deviceResults) > 0 {
+			runID = deviceResults[0].RunID
+		}
+	}
+	if runID != "" {
+		a.saveRunMetadata(runID, opts)
+	}
+	a.saveComplianceSnapshots(results)
+	a.refreshStorage()
+	return results, nil
+}
+
+// GetDeviceComplianceTrend returns the compliance score of a device after
+// each bulk run of the last days, oldest first
+func (a *App) GetDeviceComplianceTrend(deviceID string, days int) ([]checker.ComplianceSnapshot, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.resultStore == nil {
+		return nil, fmt.Errorf("result store not initialized")
+	}
+	return a.resultStore.GetComplianceTrend(deviceID, days)
+}
+
+// GetOpenFindings returns the findings still failing across the fleet,
+// grouped by fingerprint with when each was first and last seen, most
+// severe first. Sandbox devices are left out unless includeSandbox is set.
+func (a *App) GetOpenFindings(includeSandbox bool) ([]checker.OpenFinding, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.resultStore == nil {
+		return nil, fmt.Errorf("result store not initialized")
+	}
+	return a.resultStore.GetOpenFindings(includeSandbox)
+}
+
+// UpdateRunMetadata replaces the label and note of a past check run
+func (a *App) UpdateRunMetadata(runID, label, note string) (*checker.RunMetadata, error) {
+	if err := a.requireRole(security.RoleOperator, "UpdateRunMetadata"); err != nil {
+		return nil, err
+	}
+	if a.resultStore == nil {
+		return nil, fmt.Errorf("result store not initialized")
+	}
+
+	metadata, err := a.resultStore.UpdateRunMetadata(runID, label, note)
+	if err != nil {
+		return nil, err
+	}
+
+	a.recordAudit(security.ActionUpdate, security.EntityCheckRun, runID,
+		fmt.Sprintf("Updated check run label to %q", metadata.Label))
+	return metadata, nil
+}
+
+// FindRuns returns the check runs whose label or note contains the query
+// text and that took place within its date range, newest first
+func (a *App) FindRuns(query checker.RunQuery) ([]checker.RunSummary, error) {
+	if a.resultStore == nil {
+		return []checker.RunSummary{}, nil
+	}
+	return a.resultStore.FindRuns(query)
+}
+
+// GetSlowestChecks returns up to limit checks with the highest average
+// duration across the fleet, slowest first
+func (a *App) GetSlowestChecks(limit int) ([]checker.CheckDuration, error) {
+	if a.resultStore == nil {
+		return []checker.CheckDuration{}, nil
+	}
+	return a.resultStore.GetSlowestChecks(limit)
+}
+
+// AddCheckResultComment attaches an analyst note to a stored check result
+func (a *App) AddCheckResultComment(checkResultID, body string) error {
+	if err := a.requireRole(security.RoleOperator, "AddCheckResultComment"); err != nil {
+		return err
+	}
+	if a.resultStore == nil {
+		return fmt.Errorf("result store not initialized")
+	}
+
+	_, err := a.resultStore.AddComment(checkResultID, localUserID, body)
+	return err
+}
+
+// GetCheckResultComments returns the notes on a check result, oldest first
+func (a *App) GetCheckResultComments(checkResultID string) ([]checker.CheckComment, error) {
+	if a.resultStore == nil {
+		return []checker.CheckComment{}, nil
+	}
+	return a.resultStore.GetComments(checkResultID)
+}
+
+// GetCheckResultEvidence returns the full command output a stored check
+// result was evaluated against. Results whose full output was not kept in
+// a file return their stored evidence.
+func (a *App) GetCheckResultEvidence(checkResultID string) (string, error) {
+	if err := a.requireReady(); err != nil {
+		return "", err
+	}
+	if a.resultStore == nil {
+		return "", fmt.Errorf("result store not initialized")
+	}
+
+	result, err := a.resultStore.GetResult(checkResultID)
+	if err != nil {
+		return "", err
+	}
+	if output, ok := result.FullEvidence(); ok {
+		return output, nil
+	}
+	return result.Evidence, nil
+}
+
+// Config Snapshot Methods
+
+// CaptureConfigSnapshot fetches and archives a device's full config now
+func (a *App) CaptureConfigSnapshot(deviceID string) (*checker.ConfigSnapshot, error) {
+	if err := a.requireRole(security.RoleOperator, "CaptureConfigSnapshot"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.checkEngine == nil {
+		return nil, fmt.Errorf("check engine not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
 	if err != nil {
 		return nil, err
 	}
+	return a.checkEngine.CaptureConfigSnapshot(dev, a.checkOptions())
+}
+
+// GetLatestConfigSnapshot returns the most recently archived config of a device
+func (a *App) GetLatestConfigSnapshot(deviceID string) (*checker.ConfigSnapshot, error) {
+	if a.snapshotStore == nil {
+		return nil, fmt.Errorf("snapshot store not initialized")
+	}
+	return a.snapshotStore.GetLatest(deviceID)
+}
+
+// GetConfigSnapshotHistory returns the archived configs of a device, newest first
+func (a *App) GetConfigSnapshotHistory(deviceID string, limit int) ([]checker.ConfigSnapshot, error) {
+	if a.snapshotStore == nil {
+		return []checker.ConfigSnapshot{}, nil
+	}
+	return a.snapshotStore.GetHistory(deviceID, limit)
+}
+
+// GetSSHPostureReport groups devices by the SSH algorithms they last
+// negotiated and lists those using weak ones
+func (a *App) GetSSHPostureReport() (*checker.SSHPostureReport, error) {
+	if a.checkEngine == nil {
+		return checker.BuildSSHPostureReport(nil, checker.DefaultWeakSSHAlgorithms()), nil
+	}
+	return a.checkEngine.GetSSHPostureReport()
+}
+
+// Security Rule Methods
+
+// GetSecurityRules returns the enabled security rules checked on devices of
+// a vendor
+func (a *App) GetSecurityRules(vendor string) ([]checker.SecurityRule, error) {
+	if a.checkEngine == nil {
+		return nil, fmt.Errorf("check engine not initialized")
+	}
+	return a.checkEngine.GetSecurityRules(vendor), nil
+}
+
+// PreviewDeviceCommands returns the literal commands a check run would send
+// to a device, in the order it sends them, for approval before the run.
+// Nothing is sent to the device.
+func (a *App) PreviewDeviceCommands(deviceID string) ([]string, error) {
+	return a.previewDeviceCommands(deviceID, checker.CheckOptions{})
+}
 
-	return a.checkEngine.RunBulkChecks(devices)
+// PreviewIncrementalDeviceCommands is PreviewDeviceCommands for an
+// incremental run, which starts by fetching the device's config
+func (a *App) PreviewIncrementalDeviceCommands(deviceID string) ([]string, error) {
+	return a.previewDeviceCommands(deviceID, checker.CheckOptions{Incremental: true})
+}
+
+// previewDeviceCommands lists the commands a run with opts would send to a device
+func (a *App) previewDeviceCommands(deviceID string, opts checker.CheckOptions) ([]string, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.checkEngine == nil {
+		return nil, fmt.Errorf("application not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return a.checkEngine.CommandsForDevice(dev, opts)
+}
+
+// GetPredefinedRuleTemplates returns the built-in rules as shipped, for use
+// as templates of new rules. Stored copies may since have been edited.
+func (a *App) GetPredefinedRuleTemplates() []checker.SecurityRule {
+	return checker.GetPredefinedRules()
+}
+
+// GetAllSecurityRules returns every stored security rule of every vendor,
+// including disabled ones
+func (a *App) GetAllSecurityRules() ([]checker.SecurityRule, error) {
+	if a.ruleManager == nil {
+		return nil, fmt.Errorf("rule manager not initialized")
+	}
+	rules, err := a.ruleManager.GetAllRules()
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []checker.SecurityRule{}
+	}
+	return rules, nil
+}
+
+// GetRuleCategories returns the categories rules can be grouped by
+func (a *App) GetRuleCategories() []checker.RuleCategory {
+	return append([]checker.RuleCategory{}, checker.RuleCategories...)
+}
+
+// GetRulesByCategory returns the stored security rules of a category
+func (a *App) GetRulesByCategory(categoryID string) ([]checker.SecurityRule, error) {
+	if a.ruleManager == nil {
+		return nil, fmt.Errorf("rule manager not initialized")
+	}
+	rules, err := a.ruleManager.GetRulesByCategory(categoryID)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		rules = []checker.SecurityRule{}
+	}
+	return rules, nil
+}
+
+// PreviewRuleEdit shows the changes a find-and-replace across the commands
+// and patterns of the rules matching filter would make, without saving them
+func (a *App) PreviewRuleEdit(filter checker.RuleFilter, find, replace string, target checker.BulkEditTarget, regexMode bool) (*checker.BulkEditResult, error) {
+	if a.ruleManager == nil {
+		return nil, fmt.Errorf("rule manager not initialized")
+	}
+	return a.ruleManager.BulkEditRules(filter, find, replace, target, regexMode, true)
+}
+
+// ApplyRuleEdit saves the edits of a PreviewRuleEdit result exactly as
+// reviewed. Nothing is saved when a rule changed since the preview or any
+// edited rule would become invalid.
+func (a *App) ApplyRuleEdit(edits []checker.RuleEdit) (*checker.BulkEditResult, error) {
+	if err := a.requireRole(security.RoleAdmin, "ApplyRuleEdit"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.ruleManager == nil {
+		return nil, fmt.Errorf("rule manager not initialized")
+	}
+
+	result, err := a.ruleManager.ApplyRuleEdits(edits)
+	if err != nil {
+		return result, err
+	}
+
+	if len(result.Edits) > 0 {
+		changes := 0
+		for _, edit := range result.Edits {
+			changes += len(edit.Changes)
+		}
+		a.recordAudit(security.ActionUpdate, security.EntityRule, "",
+			fmt.Sprintf("Applied %d edited commands and patterns across %d rules: %s", changes,
+				len(result.Edits), strings.Join(result.RuleIDs(), ", ")))
+	}
+	return result, nil
+}
+
+// Rule Maintenance Methods
+
+// ValidateSecurityRule checks a rule before it is saved. Rules without a
+// command, referencing an unknown macro or with a pattern Go cannot compile
+// are rejected; the returned warnings list commands that look like they
+// change the device, with macros expanded, for the author to confirm.
+func (a *App) ValidateSecurityRule(rule checker.SecurityRule) ([]checker.CommandWarning, error) {
+	var macros map[string]string
+	if a.macroManager != nil {
+		var err error
+		if macros, err = a.macroManager.Commands(); err != nil {
+			return nil, err
+		}
+	}
+
+	warnings, err := checker.ValidateRuleCommands(rule, macros)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := checker.ValidateRulePatterns(rule); err != nil {
+		return nil, err
+	}
+	if warnings == nil {
+		warnings = []checker.CommandWarning{}
+	}
+	return warnings, nil
+}
+
+// ValidateRulePatterns checks that a rule's patterns compile and returns
+// warnings for those slow to match a large config
+func (a *App) ValidateRulePatterns(rule checker.SecurityRule) ([]checker.PatternWarning, error) {
+	warnings, err := checker.ValidateRulePatterns(rule)
+	if err != nil {
+		return nil, err
+	}
+	if warnings == nil {
+		warnings = []checker.PatternWarning{}
+	}
+	return warnings, nil
+}
+
+// AnalyzeRuleDuplicates reports clusters of duplicate, near-duplicate and
+// shadowed security rules with a suggested rule to keep in each
+func (a *App) AnalyzeRuleDuplicates() (*checker.RuleDuplicateReport, error) {
+	if a.ruleManager == nil {
+		return nil, fmt.Errorf("rule manager not initialized")
+	}
+	return checker.NewRuleDeduplicator(a.ruleManager).Analyze()
+}
+
+// MergeSecurityRules deletes the removed rules in favor of the kept one and
+// moves their result history onto it
+func (a *App) MergeSecurityRules(keepID string, removeIDs []string) error {
+	if err := a.requireRole(security.RoleAdmin, "MergeSecurityRules"); err != nil {
+		return err
+	}
+	if a.ruleManager == nil {
+		return fmt.Errorf("rule manager not initialized")
+	}
+
+	if err := a.ruleManager.MergeRules(keepID, removeIDs); err != nil {
+		return err
+	}
+
+	a.recordAudit(security.ActionUpdate, security.EntityRule, keepID,
+		fmt.Sprintf("Merged %d duplicate rules into rule %s", len(removeIDs), keepID))
+	return nil
+}
+
+// RestorePredefinedRule discards the user's changes to a predefined rule,
+// so upgrades of the rule apply to it again
+func (a *App) RestorePredefinedRule(id string) (*checker.SecurityRule, error) {
+	if err := a.requireRole(security.RoleAdmin, "RestorePredefinedRule"); err != nil {
+		return nil, err
+	}
+	if a.ruleManager == nil {
+		return nil, fmt.Errorf("rule manager not initialized")
+	}
+
+	rule, err := a.ruleManager.RestorePredefinedRule(id)
+	if err != nil {
+		return nil, err
+	}
+
+	a.recordAudit(security.ActionUpdate, security.EntityRule, id, fmt.Sprintf("Restored predefined rule %s", rule.Name))
+	return rule, nil
+}
+
+// Severity Override Methods
+
+// CreateSeverityOverride changes the severity of a rule's future results on
+// a device or on every device tagged with a group
+func (a *App) CreateSeverityOverride(override checker.SeverityOverride) (*checker.SeverityOverride, error) {
+	if err := a.requireRole(security.RoleAdmin, "CreateSeverityOverride"); err != nil {
+		return nil, err
+	}
+	if a.overrideManager == nil {
+		return nil, fmt.Errorf("override manager not initialized")
+	}
+
+	if err := a.overrideManager.CreateOverride(&override); err != nil {
+		return nil, err
+	}
+
+	a.recordAudit(security.ActionCreate, security.EntitySeverityOverride, override.ID,
+		fmt.Sprintf("Set rule %s to %s for %s %s: %s", override.RuleID, override.Severity,
+			override.Scope, override.Target, override.Reason))
+	return &override, nil
+}
+
+// UpdateSeverityOverride changes the severity, reason and expiry of an override
+func (a *App) UpdateSeverityOverride(override checker.SeverityOverride) error {
+	if err := a.requireRole(security.RoleAdmin, "UpdateSeverityOverride"); err != nil {
+		return err
+	}
+	if a.overrideManager == nil {
+		return fmt.Errorf("override manager not initialized")
+	}
+
+	if err := a.overrideManager.UpdateOverride(override); err != nil {
+		return err
+	}
+
+	a.recordAudit(security.ActionUpdate, security.EntitySeverityOverride, override.ID,
+		fmt.Sprintf("Changed override to %s: %s", override.Severity, override.Reason))
+	return nil
+}
+
+// DeleteSeverityOverride removes an override; later results use the rule's
+// severity again
+func (a *App) DeleteSeverityOverride(id string) error {
+	if err := a.requireRole(security.RoleAdmin, "DeleteSeverityOverride"); err != nil {
+		return err
+	}
+	if a.overrideManager == nil {
+		return fmt.Errorf("override manager not initialized")
+	}
+
+	override, err := a.overrideManager.GetOverride(id)
+	if err != nil {
+		return err
+	}
+	if err := a.overrideManager.DeleteOverride(id); err != nil {
+		return err
+	}
+
+	a.recordAudit(security.ActionDelete, security.EntitySeverityOverride, id,
+		fmt.Sprintf("Removed override of rule %s for %s %s", override.RuleID, override.Scope, override.Target))
+	return nil
+}
+
+// GetSeverityOverrides returns every severity override, expired ones included
+func (a *App) GetSeverityOverrides() ([]checker.SeverityOverride, error) {
+	if a.overrideManager == nil {
+		return nil, fmt.Errorf("override manager not initialized")
+	}
+	return a.overrideManager.GetAllOverrides()
+}
+
+// Credential Rotation Methods
+
+// RotateDeviceCredentials changes the password of the selected devices,
+// verifying each change before the stored credential is replaced. The run
+// stops starting new devices after timeLimitMinutes and can be resumed.
+func (a *App) RotateDeviceCredentials(deviceIDs []string, newPassword string, concurrency, timeLimitMinutes int) (*rotation.Report, error) {
+	if err := a.requireRole(security.RoleAdmin, "RotateDeviceCredentials"); err != nil {
+		return nil, err
+	}
+	if a.rotationManager == nil {
+		return nil, fmt.Errorf("credential rotation not initialized")
+	}
+
+	return a.rotationManager.Start(a.ctx, rotation.Request{
+		DeviceIDs:   deviceIDs,
+		NewPassword: newPassword,
+		Concurrency: concurrency,
+		TimeLimit:   time.Duration(timeLimitMinutes) * time.Minute,
+	})
+}
+
+// ResumeCredentialRotation continues an interrupted rotation run
+func (a *App) ResumeCredentialRotation(runID string, concurrency, timeLimitMinutes int) (*rotation.Report, error) {
+	if err := a.requireRole(security.RoleAdmin, "ResumeCredentialRotation"); err != nil {
+		return nil, err
+	}
+	if a.rotationManager == nil {
+		return nil, fmt.Errorf("credential rotation not initialized")
+	}
+	return a.rotationManager.Resume(a.ctx, runID, concurrency, time.Duration(timeLimitMinutes)*time.Minute)
+}
+
+// GetCredentialRotationReport returns the per-device report of a rotation run
+func (a *App) GetCredentialRotationReport(runID string) (*rotation.Report, error) {
+	if a.rotationManager == nil {
+		return nil, fmt.Errorf("credential rotation not initialized")
+	}
+	return a.rotationManager.GetReport(runID)
 }
 
 // Security and Settings Methods
 
 // EncryptPassword encrypts a password for secure storage
 func (a *App) EncryptPassword(password string) ([]byte, error) {
+	if err := a.requireRole(security.RoleAdmin, "EncryptPassword"); err != nil {
+		return nil, err
+	}
 	if a.encryptionManager == nil {
 		return nil, nil
 	}
@@ -208,33 +1324,46 @@ func (a *App) EncryptPassword(password string) ([]byte, error) {
 
 // DecryptPassword decrypts a stored password
 func (a *App) DecryptPassword(encryptedPassword []byte) (string, error) {
+	if err := a.requireRole(security.RoleAdmin, "DecryptPassword"); err != nil {
+		return "", err
+	}
 	if a.encryptionManager == nil {
 		return "", nil
 	}
 	return a.encryptionManager.Decrypt(encryptedPassword)
 }
 
-// CreateSession creates a new user session
+// CreateSession creates a new user session with the active session's role,
+// so it never grants more than the caller already has. Use
+// CreateScopedSession to choose the role.
 func (a *App) CreateSession(userID string) (*security.Session, error) {
 	if a.sessionManager == nil {
 		return nil, nil
 	}
-	return a.sessionManager.CreateSession(userID)
+	return a.sessionManager.CreateSessionWithRole(userID, a.currentRole())
 }
 
-// ValidateSession validates an existing session
+// ValidateSession validates an existing session and returns a copy of it,
+// including its role. Validating never changes the active session's role.
 func (a *App) ValidateSession(sessionID string) (*security.Session, error) {
 	if a.sessionManager == nil {
 		return nil, nil
 	}
-	return a.sessionManager.ValidateSession(sessionID)
+	session, err := a.sessionManager.ValidateSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	copied := *session
+	return &copied, nil
 }
 
-// DestroySession destroys a user session
+// DestroySession destroys a user session. Destroying the active scoped
+// session leaves the app read-only until a new one is started.
 func (a *App) DestroySession(sessionID string) {
 	if a.sessionManager != nil {
 		a.sessionManager.DestroySession(sessionID)
 	}
+	a.endActiveSession(sessionID)
 }
 
 // GetDatabaseStats returns database statistics
@@ -267,8 +1396,59 @@ func (a *App) PerformDatabaseHealthCheck() error {
 
 // BackupDatabase creates a backup of the database
 func (a *App) BackupDatabase(backupPath string) error {
+	if err := a.requireRole(security.RoleAdmin, "BackupDatabase"); err != nil {
+		return err
+	}
 	if a.db == nil {
 		return nil
 	}
 	return a.db.Backup(backupPath)
 }
+
+// BackupDatabaseCompressed creates a gzip-compressed backup of the database.
+// The path should end in database.CompressedBackupExtension.
+func (a *App) BackupDatabaseCompressed(path string) error {
+	if err := a.requireRole(security.RoleAdmin, "BackupDatabaseCompressed"); err != nil {
+		return err
+	}
+	if a.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return a.db.BackupCompressed(path)
+}
+
+// RestoreDatabaseFromBackup replaces the database with a compressed backup
+// and restarts every component on the restored data. It must not be called
+// while checks are running.
+func (a *App) RestoreDatabaseFromBackup(path string) error {
+	if err := a.requireRole(security.RoleAdmin, "RestoreDatabaseFromBackup"); err != nil {
+		return err
+	}
+	if a.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	if err := a.db.RestoreFromCompressed(path); err != nil {
+		return err
+	}
+
+	ctx := a.appContext()
+
+	// A backup from an older release is migrated like any other database
+	a.resetDatabaseComponents()
+	status := a.initialize(ctx)
+	if !status.Ready {
+		return &NotReadyError{Code: ErrCodeAppNotReady, Reason: "restored database could not be opened", Status: status}
+	}
+
+	if a.simulationMode {
+		if err := a.loadSimulator(); err != nil {
+			log.Printf("Simulation mode turned off after restore: %v", err)
+			a.simulationMode = false
+		}
+	}
+
+	a.recordAudit(security.ActionUpdate, security.EntityDatabase, "",
+		fmt.Sprintf("Restored database from backup %s", filepath.Base(path)))
+	return nil
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	durationType  = reflect.TypeOf(time.Duration(0))
+)
+
+// bridgeProblems walks a type crossing the Wails bridge and reports fields
+// that would not survive JSON: error values marshal to {}, durations to bare
+// nanoseconds and structs without exported fields to {}
+func bridgeProblems(t reflect.Type, path string, seen map[reflect.Type]bool) []string {
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		return nil
+	}
+
+	switch {
+	case t == durationType:
+		return []string{path + ": time.Duration without a JSON encoding"}
+	case t.Kind() == reflect.Interface && t.Implements(errorType):
+		return []string{path + ": error value"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return bridgeProblems(t.Elem(), path, seen)
+	case reflect.Map:
+		return bridgeProblems(t.Elem(), path+"[]", seen)
+	case reflect.Chan, reflect.Func:
+		return []string{path + ": " + t.Kind().String() + " cannot cross the bridge"}
+	case reflect.Struct:
+		var problems []string
+		exported := 0
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() || strings.Split(field.Tag.Get("json"), ",")[0] == "-" {
+				continue
+			}
+			exported++
+			problems = append(problems, bridgeProblems(field.Type, path+"."+field.Name, seen)...)
+		}
+		if exported == 0 {
+			problems = append(problems, path+": struct without exported fields")
+		}
+		return problems
+	}
+	return nil
+}
+
+// TestApp_BridgeTypesSerialize checks every type in the App's exported method
+// signatures, which Wails passes to and from the frontend as JSON
+func TestApp_BridgeTypesSerialize(t *testing.T) {
+	appType := reflect.TypeOf(&App{})
+	for i := 0; i < appType.NumMethod(); i++ {
+		method := appType.Method(i)
+		var types []reflect.Type
+		for in := 1; in < method.Type.NumIn(); in++ {
+			types = append(types, method.Type.In(in))
+		}
+		for out := 0; out < method.Type.NumOut(); out++ {
+			// A returned error is turned into a rejected promise by Wails
+			if method.Type.Out(out) != errorType {
+				types = append(types, method.Type.Out(out))
+			}
+		}
+
+		for _, typ := range types {
+			// context.Context is supplied by the runtime, not the frontend
+			if typ.String() == "context.Context" {
+				continue
+			}
+			for _, problem := range bridgeProblems(typ, method.Name+" "+typ.String(), map[reflect.Type]bool{}) {
+				t.Error(problem)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+)
+
+// ConnectionCategory is a user-facing classification of a connection test outcome
+type ConnectionCategory string
+
+const (
+	ConnectionOK          ConnectionCategory = "ok"
+	ConnectionUnreachable ConnectionCategory = "unreachable"
+	ConnectionPortClosed  ConnectionCategory = "port_closed"
+	ConnectionTimeout     ConnectionCategory = "timeout"
+	ConnectionAuthFailed  ConnectionCategory = "auth_failed"
+	ConnectionHostKey     ConnectionCategory = "host_key_mismatch"
+	ConnectionCredentials ConnectionCategory = "credentials_unavailable"
+	ConnectionInvalid     ConnectionCategory = "invalid_settings"
+	ConnectionProtocol    ConnectionCategory = "protocol_error"
+	ConnectionUnknown     ConnectionCategory = "unknown"
+)
+
+// connectionMessages holds the user-facing message for each category
+var connectionMessages = map[ConnectionCategory]string{
+	ConnectionOK:          "Device is reachable and accepted the credentials",
+	ConnectionUnreachable: "Device is not reachable on the network",
+	ConnectionPortClosed:  "Device is reachable but the SSH port is closed",
+	ConnectionTimeout:     "Connection to the device timed out",
+	ConnectionAuthFailed:  "Device rejected the username or password",
+	ConnectionHostKey:     "Device host key does not match the key seen before",
+	ConnectionCredentials: "Stored credentials for the device could not be read",
+	ConnectionInvalid:     "Device connection settings are invalid",
+	ConnectionProtocol:    "Device does not speak a compatible SSH protocol",
+	ConnectionUnknown:     "Connection test could not be completed",
+}
+
+// ConnectionTestResult is the structured outcome of a device connection test
+type ConnectionTestResult struct {
+	DeviceID    string             `json:"deviceId"`
+	Reachable   bool               `json:"reachable"`
+	SSHPortOpen bool               `json:"sshPortOpen"`
+	AuthOK      bool               `json:"authOk"`
+	AuthMethod  string             `json:"authMethod,omitempty"`
+	Category    ConnectionCategory `json:"category"`
+	Message     string             `json:"message"`
+	TestedAt    time.Time          `json:"testedAt"`
+}
+
+// setCategory sets the category and its user-facing message
+func (r *ConnectionTestResult) setCategory(category ConnectionCategory) {
+	r.Category = category
+	r.Message = connectionMessages[category]
+}
+
+// categorizeSSHError maps an SSH error kind to a user-facing category
+func categorizeSSHError(err error) ConnectionCategory {
+	switch ssh.ErrorKindOf(err) {
+	case ssh.ErrorKindAuth:
+		return ConnectionAuthFailed
+	case ssh.ErrorKindHostKey:
+		return ConnectionHostKey
+	case ssh.ErrorKindTimeout:
+		return ConnectionTimeout
+	case ssh.ErrorKindUnreachable:
+		return ConnectionUnreachable
+	case ssh.ErrorKindConfig:
+		return ConnectionInvalid
+	case ssh.ErrorKindProtocol:
+		return ConnectionProtocol
+	default:
+		return ConnectionUnknown
+	}
+}
+
+// TestConnectivityMatrix tests the reachability of the given devices, or of
+// every device when the list is empty, stores each result and returns them
+// keyed by device ID
+func (a *App) TestConnectivityMatrix(deviceIDs []string) (map[string]*device.ConnectivityResult, error) {
+	if err := a.requireRole(security.RoleOperator, "TestConnectivityMatrix"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.scanner == nil {
+		return nil, fmt.Errorf("device scanner not initialized")
+	}
+
+	var devices []*device.Device
+	if len(deviceIDs) == 0 {
+		all, err := a.deviceManager.GetAllDevices()
+		if err != nil {
+			return nil, err
+		}
+		for i := range all {
+			devices = append(devices, &all[i])
+		}
+	} else {
+		for _, id := range deviceIDs {
+			dev, err := a.deviceManager.GetDevice(id)
+			if err != nil {
+				return nil, err
+			}
+			devices = append(devices, dev)
+		}
+	}
+
+	ctx := a.appContext()
+
+	results := a.scanner.TestConnectivityMatrix(ctx, devices)
+	if err := a.deviceManager.SaveConnectivityMatrix(results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ExportConnectivityMatrix writes the stored connectivity matrix to path as
+// CSV for the inventory reachability report
+func (a *App) ExportConnectivityMatrix(path string) error {
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.deviceManager == nil {
+		return fmt.Errorf("device manager not initialized")
+	}
+
+	results, err := a.deviceManager.GetConnectivityMatrix()
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := device.WriteConnectivityMatrixCSV(&out, results); err != nil {
+		return err
+	}
+	if err := writeExportFile(path, out.Bytes()); err != nil {
+		return fmt.Errorf("failed to write connectivity matrix: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/security"
+)
+
+// GetDeviceConnectionMetrics returns where connections to a device spend
+// their time: per-phase percentiles over every recorded connection and the
+// last attempts made since the app started
+func (a *App) GetDeviceConnectionMetrics(deviceID string) (*checker.DeviceConnectionMetrics, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.connectionMetrics == nil {
+		return nil, fmt.Errorf("connection metrics not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	phases, err := a.connectionMetrics.Get(dev.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := &checker.DeviceConnectionMetrics{DeviceID: dev.ID, Phases: phases}
+	if a.sshClient != nil {
+		metrics.Recent = a.sshClient.RecentConnectionTimings(dev.IPAddress, dev.SSHPort)
+	}
+	if a.checkEngine != nil {
+		metrics.Backoff = a.checkEngine.RetryBackoff(dev)
+	}
+	return metrics, nil
+}
+
+// GetSlowestHandshakes lists the devices with the slowest SSH handshakes
+// across the fleet, ranked by their 95th percentile
+func (a *App) GetSlowestHandshakes(limit int) ([]checker.SlowHandshake, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.connectionMetrics == nil {
+		return nil, fmt.Errorf("connection metrics not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	slowest, err := a.connectionMetrics.SlowestHandshakes(limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range slowest {
+		if dev, err := a.deviceManager.GetDeviceContext(ctx, slowest[i].DeviceID); err == nil {
+			slowest[i].DeviceName = dev.Name
+		}
+	}
+	return slowest, nil
+}
+
+// adaptiveRetryKey is the app_settings key holding whether connection
+// retries adapt their delay to each device
+const adaptiveRetryKey = "adaptive_retry"
+
+// SetAdaptiveRetry sets whether connection retries adapt their delay to each
+// device's recent latency and failures, rather than waiting a fixed delay,
+// and keeps it across restarts
+func (a *App) SetAdaptiveRetry(enabled bool) error {
+	if err := a.requireRole(security.RoleAdmin, "SetAdaptiveRetry"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.db == nil || a.checkEngine == nil {
+		return fmt.Errorf("check engine not initialized")
+	}
+
+	if err := a.saveSetting(adaptiveRetryKey, strconv.FormatBool(enabled)); err != nil {
+		return err
+	}
+	a.applyAdaptiveRetry(enabled)
+	return nil
+}
+
+// GetAdaptiveRetry reports whether connection retries adapt their delay to
+// each device
+func (a *App) GetAdaptiveRetry() bool {
+	if a.checkEngine == nil {
+		return false
+	}
+	return a.checkEngine.AdaptiveRetry()
+}
+
+// loadAdaptiveRetry applies the saved adaptive retry setting; adaptive
+// retry stays on when nothing was saved
+func (a *App) loadAdaptiveRetry() {
+	value, ok, err := a.getSetting(adaptiveRetryKey)
+	if err != nil {
+		log.Printf("Failed to load adaptive retry setting: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Ignoring invalid saved adaptive retry setting %q", value)
+		return
+	}
+	a.applyAdaptiveRetry(enabled)
+}
+
+// applyAdaptiveRetry switches adaptive retry on the engine's SSH client and
+// the one the app connects with directly
+func (a *App) applyAdaptiveRetry(enabled bool) {
+	a.checkEngine.SetAdaptiveRetry(enabled)
+	if a.sshClient != nil {
+		a.sshClient.SetAdaptiveRetry(enabled)
+	}
+}
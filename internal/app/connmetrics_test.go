@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_ConnectionMetrics(t *testing.T) {
+	a := newActivityTestApp(t)
+	a.connectionMetrics = checker.NewConnectionMetricsStore(newTestDB(t))
+	a.sshClient = ssh.NewSSHClient(nil)
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+	require.NoError(t, a.connectionMetrics.Record(router.ID, ssh.PhaseTimings{Dial: 2 * time.Millisecond,
+		Banner: 3 * time.Millisecond, KeyExchange: 40 * time.Millisecond, Auth: 900 * time.Millisecond}))
+
+	metrics, err := a.GetDeviceConnectionMetrics(router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, router.ID, metrics.DeviceID)
+	require.Len(t, metrics.Phases, 5, "dial, banner, key exchange, auth and handshake")
+	assert.Equal(t, checker.PhaseHandshake, metrics.Phases[4].Phase)
+	assert.Empty(t, metrics.Recent, "nothing was attempted since startup")
+	assert.Nil(t, metrics.Backoff, "no check engine to report it")
+
+	a.checkEngine = checker.NewEngine(nil)
+	metrics, err = a.GetDeviceConnectionMetrics(router.ID)
+	require.NoError(t, err)
+	require.NotNil(t, metrics.Backoff)
+	assert.Equal(t, "10.0.0.1:22", metrics.Backoff.Host)
+	assert.True(t, metrics.Backoff.Adaptive)
+	assert.Equal(t, int64(2000), metrics.Backoff.BaseDelayMs, "RetryDelay until the host was attempted")
+
+	_, err = a.GetDeviceConnectionMetrics("missing")
+	assert.Error(t, err)
+
+	slowest, err := a.GetSlowestHandshakes(5)
+	require.NoError(t, err)
+	require.Len(t, slowest, 1)
+	assert.Equal(t, "Core Router", slowest[0].DeviceName)
+	assert.Equal(t, int64(943), slowest[0].MaxMs)
+}
+
+func TestApp_AdaptiveRetrySetting(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+	assert.True(t, a.GetAdaptiveRetry(), "adaptive retry defaults on")
+
+	require.NoError(t, a.SetAdaptiveRetry(false))
+	assert.False(t, a.GetAdaptiveRetry())
+	assert.False(t, a.sshClient.AdaptiveRetry())
+
+	// A rebuilt engine picks up the saved setting
+	a.resetDatabaseComponents()
+	require.True(t, a.initialize(context.Background()).Ready)
+	assert.False(t, a.GetAdaptiveRetry())
+}
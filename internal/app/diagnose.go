@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ratelimit"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+)
+
+// DiagnosisStatus is the outcome of one step of a device diagnosis
+type DiagnosisStatus string
+
+const (
+	DiagnosisPass DiagnosisStatus = "pass"
+	DiagnosisFail DiagnosisStatus = "fail"
+	// DiagnosisSkipped steps did not run because an earlier step failed
+	DiagnosisSkipped DiagnosisStatus = "skipped"
+)
+
+// The steps of a device diagnosis, in the order they run
+const (
+	DiagnosisStepAddress      = "address"
+	DiagnosisStepReachability = "reachability"
+	DiagnosisStepSSHPort      = "ssh_port"
+	DiagnosisStepHostKey      = "host_key"
+	DiagnosisStepAuth         = "auth"
+)
+
+// reverseLookupTimeout bounds the reverse DNS lookup of a device address,
+// which is only informational
+const reverseLookupTimeout = 2 * time.Second
+
+// lookupAddr finds the host names of an address; tests replace it
+var lookupAddr = net.DefaultResolver.LookupAddr
+
+// DiagnosisStep is the result of one step of a device diagnosis. Failed
+// steps carry the connection category explaining them.
+type DiagnosisStep struct {
+	Name       string             `json:"name"`
+	Status     DiagnosisStatus    `json:"status"`
+	Category   ConnectionCategory `json:"category,omitempty"`
+	Message    string             `json:"message"`
+	Detail     string             `json:"detail,omitempty"`
+	DurationMs int64              `json:"durationMs"`
+}
+
+// DiagnosisReport is the outcome of DiagnoseDevice: every step in order and
+// the first one that failed, if any. Error is set instead when the device
+// could not be diagnosed at all.
+type DiagnosisReport struct {
+	DeviceID     string          `json:"deviceId"`
+	DeviceName   string          `json:"deviceName,omitempty"`
+	OK           bool            `json:"ok"`
+	Steps        []DiagnosisStep `json:"steps"`
+	FirstFailure *DiagnosisStep  `json:"firstFailure,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	DiagnosedAt  time.Time       `json:"diagnosedAt"`
+}
+
+// fail marks a step failed for the reason category describes
+func (s *DiagnosisStep) fail(category ConnectionCategory, detail string) {
+	s.Status = DiagnosisFail
+	s.Category = category
+	s.Message = connectionMessages[category]
+	s.Detail = detail
+}
+
+// runStep runs a diagnosis step that passes with message unless run fails
+// it. Once a step has failed the remaining ones are skipped.
+func (r *DiagnosisReport) runStep(name, message string, run func(step *DiagnosisStep)) {
+	step := DiagnosisStep{Name: name, Status: DiagnosisSkipped}
+	if r.FirstFailure != nil {
+		step.Message = fmt.Sprintf("Not run because the %s step failed", r.FirstFailure.Name)
+		r.Steps = append(r.Steps, step)
+		return
+	}
+
+	started := time.Now()
+	step.Status = DiagnosisPass
+	step.Message = message
+	run(&step)
+	step.DurationMs = time.Since(started).Milliseconds()
+
+	r.Steps = append(r.Steps, step)
+	if step.Status == DiagnosisFail {
+		failed := step
+		r.FirstFailure = &failed
+	}
+}
+
+// DiagnoseDevice works out why a device does not connect, one step at a
+// time: its address, whether it is reachable, whether its SSH port is open,
+// whether its host key is accepted and whether it accepts the stored
+// credentials. Steps after the first failure are skipped. Nothing is run on
+// the device.
+func (a *App) DiagnoseDevice(deviceID string) *DiagnosisReport {
+	report := &DiagnosisReport{DeviceID: deviceID, Steps: []DiagnosisStep{}, DiagnosedAt: time.Now()}
+
+	if err := a.requireRole(security.RoleOperator, "DiagnoseDevice"); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	// A diagnosis makes the same connections as a connection test, so it
+	// shares its rate limit
+	if err := a.allowCall(ratelimit.MethodTestDeviceConnectivity, deviceID); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	if a.deviceManager == nil || a.scanner == nil || a.sshClient == nil {
+		report.Error = "device diagnosis is not available until the app has started"
+		return report
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.DeviceName = dev.Name
+
+	report.runStep(DiagnosisStepAddress, "Device address is valid", func(step *DiagnosisStep) {
+		address, err := device.NormalizeHost(dev.IPAddress)
+		if err != nil {
+			step.fail(ConnectionInvalid, err.Error())
+			return
+		}
+		step.Detail = address
+		if names := reverseLookup(ctx, address); len(names) > 0 {
+			step.Detail = fmt.Sprintf("%s resolves to %s", address, strings.Join(names, ", "))
+		}
+	})
+
+	var scan *device.ConnectivityResult
+	report.runStep(DiagnosisStepReachability, "Device is reachable on the network", func(step *DiagnosisStep) {
+		scan, err = a.scanner.TestConnectivityWithContext(ctx, dev)
+		switch {
+		case err != nil:
+			step.fail(ConnectionInvalid, err.Error())
+		case !scan.NetworkReachable:
+			step.fail(scanCategory(scan, ConnectionUnreachable), scanDetail(scan))
+		}
+	})
+
+	report.runStep(DiagnosisStepSSHPort, fmt.Sprintf("SSH port %d is open", dev.SSHPort), func(step *DiagnosisStep) {
+		if !scan.SSHPortOpen {
+			step.fail(scanCategory(scan, ConnectionPortClosed), scanDetail(scan))
+		}
+	})
+
+	report.runStep(DiagnosisStepHostKey, "Device host key is accepted", func(step *DiagnosisStep) {
+		connInfo := &ssh.ConnectionInfo{
+			Host:            dev.IPAddress,
+			Port:            dev.SSHPort,
+			Username:        dev.Username,
+			HostKeyCallback: checker.DeviceHostKeyCallback(dev),
+		}
+		hostKeyCtx, hostKeyCancel := context.WithTimeout(ctx, a.scanner.GetTimeout())
+		defer hostKeyCancel()
+
+		fingerprint, err := a.sshClient.CheckHostKey(hostKeyCtx, connInfo)
+		if err != nil {
+			step.fail(categorizeSSHError(err), err.Error())
+			return
+		}
+		step.Detail = fmt.Sprintf("%s, %s policy", fingerprint, dev.EffectiveHostKeyPolicy())
+	})
+
+	report.runStep(DiagnosisStepAuth, connectionMessages[ConnectionOK], func(step *DiagnosisStep) {
+		connInfo, err := a.deviceConnectionInfo(dev)
+		if err != nil {
+			step.fail(ConnectionCredentials, err.Error())
+			return
+		}
+		authCtx, authCancel := context.WithTimeout(ctx, a.scanner.GetTimeout())
+		defer authCancel()
+
+		method, err := a.sshClient.ProbeAuthentication(authCtx, connInfo)
+		if err != nil {
+			step.fail(categorizeSSHError(err), err.Error())
+			return
+		}
+		step.Detail = fmt.Sprintf("Logged in as %s with %s", dev.Username, method)
+	})
+
+	report.OK = report.FirstFailure == nil
+	return report
+}
+
+// reverseLookup returns the host names of address, or none when the lookup
+// fails or takes too long
+func reverseLookup(ctx context.Context, address string) []string {
+	ctx, cancel := context.WithTimeout(ctx, reverseLookupTimeout)
+	defer cancel()
+
+	names, err := lookupAddr(ctx, address)
+	if err != nil {
+		return nil
+	}
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".")
+	}
+	return names
+}
+
+// scanCategory returns the category of a failed connectivity scan,
+// telling timeouts apart from fallback
+func scanCategory(scan *device.ConnectivityResult, fallback ConnectionCategory) ConnectionCategory {
+	if scan.ErrorCode == device.ConnectivityErrorTimeout {
+		return ConnectionTimeout
+	}
+	return fallback
+}
+
+// scanDetail returns the error of a connectivity scan, if any
+func scanDetail(scan *device.ConnectivityResult) string {
+	if scan.Error == nil {
+		return ""
+	}
+	return scan.Error.Error()
+}
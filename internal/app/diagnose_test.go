@@ -0,0 +1,57 @@
+package app
+
+import (
+	"testing"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_DiagnoseDevice(t *testing.T) {
+	a := newRolesTestApp(t)
+
+	t.Run("unknown device", func(t *testing.T) {
+		report := a.DiagnoseDevice("missing")
+		assert.False(t, report.OK)
+		assert.NotEmpty(t, report.Error)
+		assert.Empty(t, report.Steps)
+	})
+
+	t.Run("first failure skips the remaining steps", func(t *testing.T) {
+		// Devices can no longer be saved with a loopback address, but older
+		// ones may have been
+		_, err := a.db.Exec(`INSERT INTO devices (id, name, ip_address, device_type, vendor, username, password_encrypted, ssh_port, snmp_community, tags)
+			VALUES ('loopback', 'Lab Switch', '127.0.0.1', ?, ?, 'admin', x'00', 22, '', '')`,
+			string(device.TypeSwitch), string(device.VendorCisco))
+		require.NoError(t, err)
+
+		report := a.DiagnoseDevice("loopback")
+		require.Empty(t, report.Error)
+		assert.False(t, report.OK)
+		assert.Equal(t, "Lab Switch", report.DeviceName)
+
+		require.Len(t, report.Steps, 5)
+		assert.Equal(t, DiagnosisStepAddress, report.Steps[0].Name)
+		assert.Equal(t, DiagnosisFail, report.Steps[0].Status)
+		assert.Equal(t, ConnectionInvalid, report.Steps[0].Category)
+		for _, step := range report.Steps[1:] {
+			assert.Equal(t, DiagnosisSkipped, step.Status, step.Name)
+		}
+
+		require.NotNil(t, report.FirstFailure)
+		assert.Equal(t, DiagnosisStepAddress, report.FirstFailure.Name)
+	})
+
+	t.Run("viewers cannot diagnose", func(t *testing.T) {
+		_, err := a.CreateScopedSession("viewer", testAdminPassphrase)
+		require.NoError(t, err)
+
+		report := a.DiagnoseDevice("loopback")
+		assert.Contains(t, report.Error, "DiagnoseDevice")
+		assert.Empty(t, report.Steps)
+		assert.Equal(t, security.RoleViewer, a.currentRole())
+	})
+}
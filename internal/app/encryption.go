@@ -0,0 +1,289 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/keystore"
+	"invictux-demo/internal/rotation"
+	"invictux-demo/internal/security"
+)
+
+// Keychain entry holding the derived encryption key
+const (
+	keystoreService    = "invictux"
+	encryptionKeyEntry = "encryption_key"
+)
+
+// EncryptionPassphraseEvent is emitted to the frontend with a
+// PassphraseRequest when startup needs the encryption passphrase. The
+// frontend answers through SubmitEncryptionPassphrase.
+const EncryptionPassphraseEvent = "encryption:passphraseRequired"
+
+// MinPassphraseLength is the shortest passphrase accepted when one is chosen
+const MinPassphraseLength = 12
+
+// encryptionKeyCheckKey is the app_settings key holding a known value
+// encrypted with the current key, so a mistyped passphrase is caught at
+// startup instead of when a device password fails to decrypt
+const encryptionKeyCheckKey = "encryption_key_check"
+
+// encryptionKeyCheckValue is the value encryptionKeyCheckKey encrypts
+const encryptionKeyCheckValue = "invictux encryption key check"
+
+// Legacy passphrases, public in the source of releases before the
+// passphrase prompt. They are only used to re-encrypt data those releases
+// stored.
+const (
+	legacyPassphrase        = "default-app-key-change-in-production"
+	legacyStagingPassphrase = "staging-app-key-for-testing-only"
+)
+
+var (
+	// ErrPassphraseRequired is returned by startup until the encryption
+	// passphrase has been given through SubmitEncryptionPassphrase
+	ErrPassphraseRequired = errors.New("encryption passphrase required")
+	// ErrWrongPassphrase is returned when the passphrase given does not
+	// decrypt the stored data
+	ErrWrongPassphrase = errors.New("encryption passphrase is incorrect")
+)
+
+// PassphrasePrompt asks the user for the passphrase the encryption key is derived from
+type PassphrasePrompt func(ctx context.Context) (string, error)
+
+// PassphraseRequest tells the frontend which passphrase to ask for. On the
+// first run the user chooses one of at least MinLength characters;
+// afterwards they enter the one chosen. Error explains a rejected attempt.
+type PassphraseRequest struct {
+	FirstRun  bool   `json:"firstRun"`
+	MinLength int    `json:"minLength"`
+	Error     string `json:"error,omitempty"`
+}
+
+// passphraseState holds the passphrase submitted by the frontend until
+// startup reads it
+type passphraseState struct {
+	mu        sync.Mutex
+	submitted string
+}
+
+// initEncryption loads the encryption key from the OS keychain. Otherwise
+// the key is derived from the user's passphrase, checked against the stored
+// data and, on first run, stored in the keychain. When the keychain is
+// unavailable the key is derived for this session only.
+func (a *App) initEncryption(ctx context.Context) error {
+	firstRun := false
+
+	if a.keyStore != nil {
+		stored, err := a.keyStore.Get(keystoreService, encryptionKeyEntry)
+		switch {
+		case err == nil:
+			key, err := base64.StdEncoding.DecodeString(stored)
+			if err != nil {
+				return fmt.Errorf("stored encryption key is not valid base64: %w", err)
+			}
+			manager, err := security.NewEncryptionManagerWithKey(key)
+			if err != nil {
+				return fmt.Errorf("stored encryption key is invalid: %w", err)
+			}
+			// Releases before the passphrase prompt stored the key of the
+			// public legacy passphrase, which must be replaced
+			if !manager.MatchesPassphrase(a.legacyPassphrase()) {
+				a.encryptionManager = manager
+				return nil
+			}
+			log.Println("Stored encryption key is the legacy default, asking for a passphrase")
+			firstRun = true
+		case errors.Is(err, keystore.ErrNotFound):
+			firstRun = true
+		default:
+			log.Printf("OS keychain unavailable, encryption key will not be stored: %v", err)
+		}
+	}
+
+	prompt := a.passphrasePrompt
+	if prompt == nil {
+		prompt = a.submittedPassphrase
+	}
+
+	passphrase, err := prompt(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get encryption passphrase: %w", err)
+	}
+
+	key := security.DeriveKey(passphrase)
+	manager, err := security.NewEncryptionManagerWithKey(key)
+	if err != nil {
+		return err
+	}
+	if err := a.verifyEncryptionKey(ctx, manager); err != nil {
+		return err
+	}
+	a.encryptionManager = manager
+
+	if firstRun {
+		if err := a.keyStore.Set(keystoreService, encryptionKeyEntry, base64.StdEncoding.EncodeToString(key)); err != nil {
+			log.Printf("Failed to store encryption key in OS keychain: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// submittedPassphrase is the passphrase prompt of the desktop app. It
+// returns the passphrase given to SubmitEncryptionPassphrase, or
+// ErrPassphraseRequired while there is none, which leaves startup degraded
+// until the frontend answers EncryptionPassphraseEvent.
+func (a *App) submittedPassphrase(ctx context.Context) (string, error) {
+	a.passphrase.mu.Lock()
+	defer a.passphrase.mu.Unlock()
+
+	passphrase := a.passphrase.submitted
+	a.passphrase.submitted = ""
+	if passphrase == "" {
+		return "", ErrPassphraseRequired
+	}
+	return passphrase, nil
+}
+
+// verifyEncryptionKey checks a key derived from the user's passphrase
+// against the key check value stored with the data. The first key set up
+// re-encrypts the data the legacy key encrypted, then stores the check
+// value. Apps without a database have nothing to check.
+func (a *App) verifyEncryptionKey(ctx context.Context, manager *security.EncryptionManager) error {
+	if a.db == nil {
+		return nil
+	}
+
+	check, ok, err := a.getSetting(encryptionKeyCheckKey)
+	if err != nil {
+		return err
+	}
+	if ok {
+		ciphertext, err := base64.StdEncoding.DecodeString(check)
+		if err != nil {
+			return fmt.Errorf("stored encryption key check is not valid base64: %w", err)
+		}
+		if plaintext, err := manager.Decrypt(ciphertext); err != nil || plaintext != encryptionKeyCheckValue {
+			return ErrWrongPassphrase
+		}
+		return nil
+	}
+
+	if err := a.reencryptLegacyData(ctx, manager); err != nil {
+		return fmt.Errorf("failed to re-encrypt data stored with the legacy key: %w", err)
+	}
+	ciphertext, err := manager.Encrypt(encryptionKeyCheckValue)
+	if err != nil {
+		return err
+	}
+	return a.saveSetting(encryptionKeyCheckKey, base64.StdEncoding.EncodeToString(ciphertext))
+}
+
+// reencryptLegacyData re-encrypts with manager the device passwords,
+// keyboard responses and rotation passwords that releases before the
+// passphrase prompt encrypted with the legacy key. Values manager already
+// decrypts are left alone, so an interrupted migration can run again.
+func (a *App) reencryptLegacyData(ctx context.Context, manager *security.EncryptionManager) error {
+	legacy := security.NewEncryptionManager(a.legacyPassphrase())
+	reencrypt := func(ciphertext []byte) ([]byte, bool) {
+		updated, changed, err := manager.Reencrypt(ciphertext, legacy)
+		if err != nil {
+			log.Printf("Leaving a stored secret that neither key decrypts: %v", err)
+		}
+		return updated, changed
+	}
+
+	// Encryption is set up before the device manager
+	devices, err := device.NewManager(a.db.DB).ReencryptSecretsContext(ctx, reencrypt)
+	if err != nil {
+		return err
+	}
+	rotations, err := rotation.ReencryptPasswordsContext(ctx, a.db.DB, reencrypt)
+	if err != nil {
+		return err
+	}
+	if devices > 0 || rotations > 0 {
+		log.Printf("Re-encrypted the secrets of %d devices and %d rotations with the new encryption key", devices, rotations)
+	}
+	return nil
+}
+
+// legacyPassphrase returns the passphrase releases before the passphrase
+// prompt derived the key from in this environment
+func (a *App) legacyPassphrase() string {
+	if a.environment == "staging" {
+		return legacyStagingPassphrase
+	}
+	return legacyPassphrase
+}
+
+// passphraseRequest describes the passphrase startup needs after err
+func (a *App) passphraseRequest(err error) *PassphraseRequest {
+	request := &PassphraseRequest{FirstRun: true, MinLength: MinPassphraseLength}
+	if a.db != nil {
+		if _, ok, checkErr := a.getSetting(encryptionKeyCheckKey); checkErr == nil && ok {
+			request.FirstRun = false
+		}
+	}
+	if errors.Is(err, ErrWrongPassphrase) {
+		request.Error = ErrWrongPassphrase.Error()
+	}
+	return request
+}
+
+// SubmitEncryptionPassphrase answers EncryptionPassphraseEvent and retries
+// startup with the passphrase. A passphrase chosen on the first run must
+// have at least MinPassphraseLength characters. It fails with a
+// NotReadyError, carrying the startup status, when the app still cannot
+// start, for example because the passphrase is wrong.
+func (a *App) SubmitEncryptionPassphrase(passphrase string) (*StartupStatus, error) {
+	if a.encryptionManager != nil {
+		return nil, fmt.Errorf("encryption is already set up")
+	}
+	if strings.TrimSpace(passphrase) == "" {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+	if a.passphraseRequest(nil).FirstRun && len(passphrase) < MinPassphraseLength {
+		return nil, fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+	}
+
+	a.passphrase.mu.Lock()
+	a.passphrase.submitted = passphrase
+	a.passphrase.mu.Unlock()
+
+	ctx := a.appContext()
+	status := a.initialize(ctx)
+	if !status.Ready {
+		reason := "app could not start"
+		if len(status.Errors) > 0 {
+			reason = status.Errors[0]
+		}
+		return status, &NotReadyError{Code: ErrCodeAppNotReady, Reason: reason, Status: status}
+	}
+	a.finishStartup(ctx)
+	return status, nil
+}
+
+// ClearStoredKey removes the encryption key from the OS keychain. The next
+// start asks for the passphrase again.
+func (a *App) ClearStoredKey() error {
+	if err := a.requireRole(security.RoleAdmin, "ClearStoredKey"); err != nil {
+		return err
+	}
+	if a.keyStore == nil {
+		return nil
+	}
+
+	err := a.keyStore.Delete(keystoreService, encryptionKeyEntry)
+	if errors.Is(err, keystore.ErrNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,226 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/keystore"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKeyStore is an in-memory keystore that records calls
+type fakeKeyStore struct {
+	values  map[string]string
+	getErr  error
+	gets    int
+	sets    int
+	deletes int
+}
+
+func newFakeKeyStore() *fakeKeyStore {
+	return &fakeKeyStore{values: make(map[string]string)}
+}
+
+func (s *fakeKeyStore) Get(service, key string) (string, error) {
+	s.gets++
+	if s.getErr != nil {
+		return "", s.getErr
+	}
+	value, ok := s.values[service+"/"+key]
+	if !ok {
+		return "", keystore.ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *fakeKeyStore) Set(service, key, value string) error {
+	s.sets++
+	s.values[service+"/"+key] = value
+	return nil
+}
+
+func (s *fakeKeyStore) Delete(service, key string) error {
+	s.deletes++
+	if _, ok := s.values[service+"/"+key]; !ok {
+		return keystore.ErrNotFound
+	}
+	delete(s.values, service+"/"+key)
+	return nil
+}
+
+// countingPrompt returns a passphrase prompt that counts how often it is asked
+func countingPrompt(passphrase string, calls *int) PassphrasePrompt {
+	return func(ctx context.Context) (string, error) {
+		*calls++
+		return passphrase, nil
+	}
+}
+
+func TestApp_InitEncryption(t *testing.T) {
+	store := newFakeKeyStore()
+	prompts := 0
+
+	// First run: nothing stored, so the user is asked and the key is stored
+	first := &App{keyStore: store, passphrasePrompt: countingPrompt("user passphrase", &prompts)}
+	require.NoError(t, first.initEncryption(context.Background()))
+	assert.Equal(t, 1, store.gets)
+	assert.Equal(t, 1, store.sets)
+	assert.Equal(t, 1, prompts)
+
+	ciphertext, err := first.encryptionManager.Encrypt("device-password")
+	require.NoError(t, err)
+
+	// Subsequent run: the key comes from the keystore without asking
+	second := &App{keyStore: store, passphrasePrompt: countingPrompt("ignored", &prompts)}
+	require.NoError(t, second.initEncryption(context.Background()))
+	assert.Equal(t, 2, store.gets)
+	assert.Equal(t, 1, store.sets)
+	assert.Equal(t, 1, prompts)
+
+	plaintext, err := second.encryptionManager.Decrypt(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "device-password", plaintext)
+}
+
+func TestApp_InitEncryptionKeychainUnavailable(t *testing.T) {
+	store := newFakeKeyStore()
+	store.getErr = errors.New("secret service not running")
+	prompts := 0
+
+	a := &App{keyStore: store, passphrasePrompt: countingPrompt("user passphrase", &prompts)}
+	require.NoError(t, a.initEncryption(context.Background()))
+	assert.NotNil(t, a.encryptionManager)
+	assert.Equal(t, 1, prompts)
+	assert.Equal(t, 0, store.sets)
+}
+
+func TestApp_SubmitEncryptionPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	a, events := newStartupTestApp(t, dir)
+	a.passphrasePrompt = nil
+
+	// Startup waits for the frontend to choose a passphrase
+	status := a.initialize(context.Background())
+	assert.False(t, status.Ready)
+	require.NotNil(t, status.Passphrase)
+	assert.True(t, status.Passphrase.FirstRun)
+	assert.Equal(t, MinPassphraseLength, status.Passphrase.MinLength)
+	assert.Equal(t, []string{EncryptionPassphraseEvent, StartupErrorEvent}, *events)
+	assert.Nil(t, a.encryptionManager)
+
+	_, err := a.SubmitEncryptionPassphrase("too short")
+	assert.ErrorContains(t, err, "at least")
+
+	status, err = a.SubmitEncryptionPassphrase("correct horse battery")
+	require.NoError(t, err)
+	assert.True(t, status.Ready)
+	assert.Nil(t, status.Passphrase)
+	assert.False(t, a.encryptionManager.MatchesPassphrase(legacyPassphrase))
+	_, err = a.SubmitEncryptionPassphrase("correct horse battery")
+	assert.Error(t, err, "encryption is set up once")
+
+	ciphertext, err := a.encryptionManager.Encrypt("device-password")
+	require.NoError(t, err)
+	a.db.Close()
+
+	// Without the keychain entry the next start asks for the same passphrase
+	next, _ := newStartupTestApp(t, dir)
+	next.passphrasePrompt = nil
+	status = next.initialize(context.Background())
+	require.NotNil(t, status.Passphrase)
+	assert.False(t, status.Passphrase.FirstRun)
+
+	status, err = next.SubmitEncryptionPassphrase("battery horse correct")
+	var notReady *NotReadyError
+	require.ErrorAs(t, err, &notReady)
+	require.NotNil(t, status.Passphrase)
+	assert.Equal(t, ErrWrongPassphrase.Error(), status.Passphrase.Error)
+	assert.Nil(t, next.encryptionManager)
+
+	_, err = next.SubmitEncryptionPassphrase("correct horse battery")
+	require.NoError(t, err)
+	plaintext, err := next.encryptionManager.Decrypt(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "device-password", plaintext)
+}
+
+func TestApp_InitEncryptionReplacesLegacyKey(t *testing.T) {
+	// A release before the passphrase prompt stored a device password, a
+	// keyboard response and a rotation password with the legacy key, and
+	// the legacy key in the keychain
+	legacy := security.NewEncryptionManager(legacyPassphrase)
+	encrypt := func(plaintext string) []byte {
+		ciphertext, err := legacy.Encrypt(plaintext)
+		require.NoError(t, err)
+		return ciphertext
+	}
+	dir := prepareDatabase(t, func(db *database.DB) {
+		manager := device.NewManager(db.DB)
+		dev := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+			Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: encrypt("device-password"), SSHPort: 22}
+		require.NoError(t, manager.AddDevice(dev))
+		require.NoError(t, manager.SetKeyboardResponses(dev.ID, []device.KeyboardResponse{
+			{Prompt: "token", ResponseEncrypted: encrypt("123456")},
+		}))
+		_, err := db.Exec(`INSERT INTO credential_rotations (id, status, new_password_encrypted, created_by)
+			VALUES ('rotation1', 'interrupted', ?, 'local')`, encrypt("new-password"))
+		require.NoError(t, err)
+	})
+
+	prompts := 0
+	a, _ := newStartupTestApp(t, dir)
+	a.passphrasePrompt = countingPrompt("correct horse battery", &prompts)
+	store := a.keyStore.(*fakeKeyStore)
+	store.values[keystoreService+"/"+encryptionKeyEntry] = base64.StdEncoding.EncodeToString(security.DeriveKey(legacyPassphrase))
+
+	require.True(t, a.initialize(context.Background()).Ready)
+	assert.Equal(t, 1, prompts, "the legacy key is not used as the key")
+	assert.Equal(t, base64.StdEncoding.EncodeToString(security.DeriveKey("correct horse battery")),
+		store.values[keystoreService+"/"+encryptionKeyEntry])
+
+	devices, err := a.deviceManager.GetAllDevices()
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	password, err := a.encryptionManager.Decrypt(devices[0].PasswordEncrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "device-password", password)
+	require.Len(t, devices[0].KeyboardResponses, 1)
+	response, err := a.encryptionManager.Decrypt(devices[0].KeyboardResponses[0].ResponseEncrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "123456", response)
+
+	var rotationPassword []byte
+	require.NoError(t, a.db.QueryRow(`SELECT new_password_encrypted FROM credential_rotations WHERE id = 'rotation1'`).Scan(&rotationPassword))
+	newPassword, err := a.encryptionManager.Decrypt(rotationPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "new-password", newPassword)
+
+	_, err = legacy.Decrypt(devices[0].PasswordEncrypted)
+	assert.Error(t, err, "nothing is left under the legacy key")
+}
+
+func TestApp_ClearStoredKey(t *testing.T) {
+	store := newFakeKeyStore()
+	prompts := 0
+
+	a := &App{keyStore: store, passphrasePrompt: countingPrompt("user passphrase", &prompts)}
+	require.NoError(t, a.initEncryption(context.Background()))
+
+	require.NoError(t, a.ClearStoredKey())
+	assert.Empty(t, store.values)
+
+	// Clearing again is not an error
+	require.NoError(t, a.ClearStoredKey())
+
+	// The next start asks for the passphrase again
+	next := &App{keyStore: store, passphrasePrompt: countingPrompt("user passphrase", &prompts)}
+	require.NoError(t, next.initEncryption(context.Background()))
+	assert.Equal(t, 2, prompts)
+}
@@ -0,0 +1,493 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/report"
+	"invictux-demo/internal/security"
+)
+
+// Report formats written by GenerateReport
+const (
+	ReportFormatCEF = "cef"
+	ReportFormatPDF = "pdf"
+)
+
+// ReportRequest selects the format, devices and destination of a report.
+// An empty device list reports every device. Sandbox devices are left out,
+// even when listed, unless IncludeSandbox is set. PageSize, Title and
+// Operator only apply to PDF reports.
+type ReportRequest struct {
+	Format         string   `json:"format"`
+	DeviceIDs      []string `json:"deviceIds"`
+	Path           string   `json:"path"`
+	IncludeSandbox bool     `json:"includeSandbox,omitempty"`
+	PageSize       string   `json:"pageSize,omitempty"`
+	Title          string   `json:"title,omitempty"`
+	Operator       string   `json:"operator,omitempty"`
+}
+
+// GenerateReport writes the results of the latest check run of each device
+// to req.Path in req.Format. Nothing is written when the report fails.
+func (a *App) GenerateReport(req ReportRequest) error {
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.deviceManager == nil || a.resultStore == nil {
+		return fmt.Errorf("result store not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	devices, results, err := a.latestResults(ctx, req.DeviceIDs, req.IncludeSandbox)
+	if err != nil {
+		return err
+	}
+
+	labels, err := a.runLabels(results)
+	if err != nil {
+		return err
+	}
+
+	generator := report.NewGenerator(a.GetLocale()).WithRunLabels(labels)
+	if strings.EqualFold(req.Format, ReportFormatPDF) {
+		skipped, err := a.runSkippedRules(ctx, labels, devices)
+		if err != nil {
+			return err
+		}
+		generator.WithSkippedRules(skipped)
+	}
+
+	var out bytes.Buffer
+	switch strings.ToLower(req.Format) {
+	case ReportFormatCEF:
+		err = generator.GenerateCEF(results, devices, &out)
+	case ReportFormatPDF:
+		err = generator.GeneratePDF(results, devices, report.PDFOptions{
+			Title:       req.Title,
+			Operator:    req.Operator,
+			PageSize:    req.PageSize,
+			GeneratedAt: time.Now(),
+		}, &out)
+	default:
+		return fmt.Errorf("unsupported report format %q", req.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeExportFile(req.Path, out.Bytes()); err != nil {
+		return fmt.Errorf("failed to write %s report: %w", req.Format, err)
+	}
+	return nil
+}
+
+// writeExportFile writes an export readable only by the user, since
+// exports carry device addresses and findings. A file already at path is
+// restricted too.
+func writeExportFile(path string, data []byte) error {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// runLabels returns the operator labels of the runs results came from, by
+// run ID
+func (a *App) runLabels(results []checker.CheckResult) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, result := range results {
+		if _, seen := labels[result.RunID]; seen || result.RunID == "" {
+			continue
+		}
+		metadata, err := a.resultStore.GetRunMetadata(result.RunID)
+		switch {
+		case err == nil:
+			labels[result.RunID] = metadata.Label
+		case errors.Is(err, checker.ErrRunNotFound):
+			labels[result.RunID] = ""
+		default:
+			return nil, fmt.Errorf("failed to load metadata of run %s: %w", result.RunID, err)
+		}
+	}
+	return labels, nil
+}
+
+// runSkippedRules returns the rules the runs did not evaluate on devices,
+// so a report can say what it does not cover
+func (a *App) runSkippedRules(ctx context.Context, runs map[string]string, devices []device.Device) ([]checker.SkippedRule, error) {
+	if a.ruleManager == nil {
+		return nil, nil
+	}
+
+	reported := make(map[string]bool, len(devices))
+	for _, dev := range devices {
+		reported[dev.ID] = true
+	}
+	runIDs := make([]string, 0, len(runs))
+	for runID := range runs {
+		runIDs = append(runIDs, runID)
+	}
+	sort.Strings(runIDs)
+
+	var skipped []checker.SkippedRule
+	for _, runID := range runIDs {
+		records, err := a.ruleManager.GetRunSkippedRulesContext(ctx, runID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load skipped rules of run %s: %w", runID, err)
+		}
+		for _, record := range records {
+			if reported[record.DeviceID] {
+				skipped = append(skipped, record)
+			}
+		}
+	}
+	return skipped, nil
+}
+
+// ExportCEFReport writes the results of the latest check run of each device
+// to path as CEF events for a SIEM. An empty device list exports every
+// device except sandbox devices.
+func (a *App) ExportCEFReport(deviceIDs []string, path string) error {
+	return a.GenerateReport(ReportRequest{Format: ReportFormatCEF, DeviceIDs: deviceIDs, Path: path})
+}
+
+// DeviceExportProgressEvent is emitted with a device.ExportProgress after
+// each batch an ExportDevicesStreaming call writes
+const DeviceExportProgressEvent = "devices:exportProgress"
+
+// ExportDevicesStreaming writes every device to path as CSV or JSON a batch
+// at a time, emitting DeviceExportProgressEvent as it goes, and returns the
+// footer written next to the finished file. An export interrupted by an
+// error or by the app closing continues where it stopped when it is run
+// again with resume and the same path and format; without resume it starts
+// over. Credentials are never exported.
+func (a *App) ExportDevicesStreaming(path, format string, resume bool) (*device.DeviceExportFooter, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil {
+		return nil, fmt.Errorf("device manager not initialized")
+	}
+
+	// Large fleets can take longer than one request, so the export only
+	// ends with the app
+	footer, err := a.deviceManager.ExportDevices(a.appContext(), path, device.DeviceExportOptions{
+		Format: format,
+		Resume: resume,
+		Progress: func(progress device.ExportProgress) {
+			if a.emitEvent != nil {
+				a.emitEvent(DeviceExportProgressEvent, progress)
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.recordAudit(security.ActionExport, security.EntityDevice, "",
+		fmt.Sprintf("Exported %d devices as %s to %s (sha256 %s)", footer.Rows, footer.Format, path, footer.SHA256))
+	return footer, nil
+}
+
+// GetComplianceSummary counts and scores the results of the latest check
+// run of each device, weighting failures by severity with the default
+// weights. An empty device list summarizes every device. Sandbox devices
+// are left out unless includeSandbox is set.
+func (a *App) GetComplianceSummary(deviceIDs []string, includeSandbox bool) (*checker.ComplianceSummary, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.resultStore == nil {
+		return nil, fmt.Errorf("result store not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	_, results, err := a.latestResults(ctx, deviceIDs, includeSandbox)
+	if err != nil {
+		return nil, err
+	}
+	summary := checker.SummarizeCompliance(results, nil)
+	return &summary, nil
+}
+
+// bundleRunSlack widens a run's span when matching state observed by it: the
+// SSH posture is seen on connecting before the first result and the config
+// snapshot is archived after the last
+const bundleRunSlack = 5 * time.Minute
+
+// ExportDeviceEvidenceBundle writes a zip of the evidence a device produced
+// in a run to path, for attaching to vendor support cases. Secrets in the
+// evidence are redacted unless includeSecrets is set. Every export is
+// audited, noting whether secrets were included. Only admins may include
+// secrets.
+func (a *App) ExportDeviceEvidenceBundle(deviceID, runID, path string, includeSecrets bool) error {
+	if includeSecrets {
+		if err := a.requireRole(security.RoleAdmin, "ExportDeviceEvidenceBundle"); err != nil {
+			return err
+		}
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.deviceManager == nil || a.resultStore == nil {
+		return fmt.Errorf("result store not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	results, err := a.resultStore.GetRunResults(runID, dev.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load results of run %s: %w", runID, err)
+	}
+
+	bundle := report.EvidenceBundle{
+		Device:         *dev,
+		RunID:          runID,
+		AppVersion:     AppVersion,
+		Results:        results,
+		Commands:       make(map[string]string),
+		FullOutputs:    make(map[string]string),
+		IncludeSecrets: includeSecrets,
+		GeneratedAt:    time.Now(),
+	}
+	if metadata, err := a.resultStore.GetRunMetadata(runID); err == nil {
+		bundle.Run = metadata
+	}
+
+	// The commands the run sent, not those the rules hold now. Results
+	// saved before commands were recorded have none.
+	for _, result := range results {
+		if result.Command != "" {
+			bundle.Commands[result.CheckName] = result.Command
+		}
+	}
+
+	// The connectivity matrix keeps the latest probe of each device; the
+	// bundle notes when it was taken
+	if matrix, err := a.deviceManager.GetConnectivityMatrix(); err == nil {
+		bundle.Connectivity = matrix[dev.ID]
+	}
+
+	// Only state observed during the run belongs in its bundle
+	started := results[0].CheckedAt.Add(-bundleRunSlack)
+	finished := results[len(results)-1].CheckedAt.Add(bundleRunSlack)
+	during := func(t time.Time) bool { return !t.Before(started) && !t.After(finished) }
+
+	if a.snapshotStore != nil && a.checkEngine != nil {
+		command := a.checkEngine.SnapshotCommand(dev.Vendor)
+		if snapshot, err := a.snapshotStore.GetLatest(dev.ID); err == nil && command != "" && during(snapshot.LastSeenAt) {
+			bundle.FullOutputs[command] = snapshot.Config
+		}
+	}
+	if a.postureStore != nil {
+		if posture, err := a.postureStore.Get(dev.ID); err == nil && during(posture.ObservedAt) {
+			bundle.Posture = posture
+		}
+	}
+
+	var out bytes.Buffer
+	if err := report.NewGenerator(a.GetLocale()).GenerateEvidenceBundle(bundle, &out); err != nil {
+		return err
+	}
+	if err := writeExportFile(path, out.Bytes()); err != nil {
+		return fmt.Errorf("failed to write evidence bundle: %w", err)
+	}
+
+	secrets := "secrets redacted"
+	if includeSecrets {
+		secrets = "secrets included"
+	}
+	a.recordAudit(security.ActionExport, security.EntityDevice, dev.ID,
+		fmt.Sprintf("Exported evidence bundle of run %s for device %s to %s (%s)", runID, dev.Name, path, secrets))
+	return nil
+}
+
+// ExportSCAP writes a check run to path as an XCCDF 1.2 document for SCAP
+// compliance tooling, with every rule as an XCCDF Rule and each device's
+// results and skipped rules as a TestResult. Rule IDs in the document are
+// derived from rule IDs, so they stay the same across exports. Results of
+// sandbox devices are left out unless includeSandbox is set.
+func (a *App) ExportSCAP(runID, path string, includeSandbox bool) error {
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.deviceManager == nil || a.resultStore == nil || a.ruleManager == nil {
+		return fmt.Errorf("result store not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	results, err := a.resultStore.GetAllRunResults(runID)
+	if err != nil {
+		return fmt.Errorf("failed to load results of run %s: %w", runID, err)
+	}
+	if !includeSandbox {
+		if results, err = a.withoutSandboxResults(ctx, results); err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return fmt.Errorf("run %s only checked sandbox devices", runID)
+		}
+	}
+	rules, err := a.ruleManager.GetAllRulesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	export := report.SCAPExport{
+		RunID:       runID,
+		AppVersion:  AppVersion,
+		Rules:       rules,
+		Results:     results,
+		GeneratedAt: time.Now(),
+	}
+
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if seen[result.DeviceID] {
+			continue
+		}
+		seen[result.DeviceID] = true
+
+		dev, err := a.deviceManager.GetDeviceContext(ctx, result.DeviceID)
+		if err != nil {
+			// Results of deleted devices are exported without device details
+			continue
+		}
+		export.Devices = append(export.Devices, *dev)
+	}
+
+	// Only the skips of exported devices, leaving out sandbox devices
+	skipped, err := a.ruleManager.GetRunSkippedRulesContext(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to load skipped rules of run %s: %w", runID, err)
+	}
+	for _, skip := range skipped {
+		if seen[skip.DeviceID] {
+			export.Skipped = append(export.Skipped, skip)
+		}
+	}
+
+	labels, err := a.runLabels(results)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := report.NewGenerator(a.GetLocale()).WithRunLabels(labels).GenerateXCCDF(export, &out); err != nil {
+		return err
+	}
+	if err := writeExportFile(path, out.Bytes()); err != nil {
+		return fmt.Errorf("failed to write XCCDF document: %w", err)
+	}
+
+	a.recordAudit(security.ActionExport, security.EntityCheckRun, runID,
+		fmt.Sprintf("Exported run %s as XCCDF to %s (%d results)", runID, path, len(results)))
+	return nil
+}
+
+// GenerateRuleCatalog writes an HTML catalog of every enabled rule to
+// path, with the rationale and references auditors ask for
+func (a *App) GenerateRuleCatalog(path string) error {
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.ruleManager == nil {
+		return fmt.Errorf("rule manager not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	rules, err := a.ruleManager.GetAllRulesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	var out bytes.Buffer
+	if err := report.NewGenerator(a.GetLocale()).GenerateRuleCatalog(rules,
+		report.RuleCatalogOptions{GeneratedAt: time.Now()}, &out); err != nil {
+		return err
+	}
+	if err := writeExportFile(path, out.Bytes()); err != nil {
+		return fmt.Errorf("failed to write rule catalog: %w", err)
+	}
+	return nil
+}
+
+// latestResults returns the devices and the results of each device's most
+// recent check run. An empty device list selects every device. Sandbox
+// devices are dropped unless includeSandbox is set.
+func (a *App) latestResults(ctx context.Context, deviceIDs []string, includeSandbox bool) ([]device.Device, []checker.CheckResult, error) {
+	var devices []device.Device
+	if len(deviceIDs) == 0 {
+		all, err := a.deviceManager.GetAllDevicesContext(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		devices = all
+	} else {
+		for _, id := range deviceIDs {
+			dev, err := a.deviceManager.GetDeviceContext(ctx, id)
+			if err != nil {
+				return nil, nil, err
+			}
+			devices = append(devices, *dev)
+		}
+	}
+	if !includeSandbox {
+		devices = device.WithoutSandbox(devices)
+	}
+
+	var results []checker.CheckResult
+	for _, dev := range devices {
+		deviceResults, err := a.resultStore.GetLatestRunResults(dev.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load results of device %s: %w", dev.Name, err)
+		}
+		results = append(results, deviceResults...)
+	}
+	return devices, results, nil
+}
+
+// withoutSandboxResults drops the results of sandbox devices. Results of
+// deleted devices are kept.
+func (a *App) withoutSandboxResults(ctx context.Context, results []checker.CheckResult) ([]checker.CheckResult, error) {
+	sandbox := make(map[string]bool)
+	kept := make([]checker.CheckResult, 0, len(results))
+	for _, result := range results {
+		isSandbox, ok := sandbox[result.DeviceID]
+		if !ok {
+			dev, err := a.deviceManager.GetDeviceContext(ctx, result.DeviceID)
+			var deviceErr *device.DeviceError
+			if err != nil && !(errors.As(err, &deviceErr) && deviceErr.Type == device.ErrorTypeNotFound) {
+				return nil, err
+			}
+			isSandbox = dev != nil && dev.IsSandbox
+			sandbox[result.DeviceID] = isSandbox
+		}
+		if !isSandbox {
+			kept = append(kept, result)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,333 @@
+package app
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/report"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_ExportCEFReport(t *testing.T) {
+	a := newActivityTestApp(t)
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	result := func(id, runID, name string, status checker.CheckStatus, at time.Time) checker.CheckResult {
+		return checker.CheckResult{ID: id, DeviceID: router.ID, RunID: runID, CheckName: name, CheckType: "configuration",
+			Severity: string(checker.SeverityHigh), Status: string(status), Message: "checked", CheckedAt: at}
+	}
+	earlier := time.Now().Add(-time.Hour)
+	require.NoError(t, a.resultStore.SaveResults([]checker.CheckResult{
+		result("old", "run1", "Old Check", checker.StatusFail, earlier),
+		result("ssh", "run2", "SSH Version 2", checker.StatusPass, time.Now()),
+		result("banner", "run2", "Login Banner", checker.StatusFail, time.Now()),
+	}))
+	require.NoError(t, a.resultStore.SaveRunMetadata("run2", "post-change CHG-5521", ""))
+
+	// An earlier export left readable by others is restricted
+	path := filepath.Join(t.TempDir(), "report.cef")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	require.NoError(t, a.ExportCEFReport([]string{router.ID}, path))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2, "only the latest run is exported")
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, "CEF:0|Invictux|"))
+		assert.Contains(t, line, "src=10.0.0.1")
+		assert.Contains(t, line, "flexString1=post-change CHG-5521")
+	}
+
+	assert.Error(t, a.ExportCEFReport([]string{"missing"}, path))
+
+	summary, err := a.GetComplianceSummary(nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Total, "only the latest run is summarized")
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, 50.0, summary.WeightedScore)
+
+	_, err = (&App{}).GetComplianceSummary(nil, false)
+	assert.Error(t, err)
+}
+
+func TestApp_GenerateReport(t *testing.T) {
+	db := newTestDB(t)
+	a := &App{
+		deviceManager: device.NewManager(db),
+		resultStore:   checker.NewResultStore(db),
+		ruleManager:   checker.NewRuleManager(db),
+		auditLogger:   security.NewAuditLogger(db),
+	}
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+	require.NoError(t, a.resultStore.SaveResults([]checker.CheckResult{
+		{ID: "banner", DeviceID: router.ID, RunID: "run1", CheckName: "Login Banner", CheckType: "configuration",
+			Severity: string(checker.SeverityHigh), Status: string(checker.StatusFail), Message: "checked", CheckedAt: time.Now()},
+	}))
+	require.NoError(t, a.ruleManager.SaveSkippedRulesContext(context.Background(), []checker.SkippedRule{
+		{RunID: "run1", DeviceID: router.ID, RuleID: "ntp", RuleName: "NTP Servers", Reason: checker.SkipReasonMaintenance},
+		{RunID: "run0", DeviceID: router.ID, RuleID: "snmp", RuleName: "SNMP Community", Reason: checker.SkipReasonMaintenance},
+	}))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.pdf")
+	require.NoError(t, a.GenerateReport(ReportRequest{Format: ReportFormatPDF, Path: path, PageSize: "Letter",
+		Operator: "Jordan Lee"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "%PDF-"))
+	assert.Contains(t, string(data), "(Login Banner) Tj")
+	assert.Contains(t, string(data), "/MediaBox [0 0 612 792]")
+	assert.Contains(t, string(data), "(NTP Servers) Tj", "rules the run skipped are listed")
+	assert.NotContains(t, string(data), "(SNMP Community) Tj", "skipped by an earlier run")
+
+	badSize := filepath.Join(dir, "bad.pdf")
+	assert.Error(t, a.GenerateReport(ReportRequest{Format: ReportFormatPDF, Path: badSize, PageSize: "A3"}))
+	assert.NoFileExists(t, badSize, "failed reports leave no file")
+	assert.Error(t, a.GenerateReport(ReportRequest{Format: "docx", Path: filepath.Join(dir, "report.docx")}))
+}
+
+func TestApp_ConnectivityMatrix(t *testing.T) {
+	a := newActivityTestApp(t)
+	a.scanner = device.NewConnectivityScanner()
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	// A cancelled app context records every device without touching the network
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.ctx = ctx
+
+	results, err := a.TestConnectivityMatrix(nil)
+	require.NoError(t, err)
+	require.Contains(t, results, router.ID)
+	assert.Equal(t, device.ConnectivityErrorTimeout, results[router.ID].ErrorCode)
+
+	_, err = a.TestConnectivityMatrix([]string{"missing"})
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "matrix.csv")
+	require.NoError(t, a.ExportConnectivityMatrix(path))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "device_id,device_name,ip_address"))
+	assert.True(t, strings.HasPrefix(lines[1], router.ID+",Core Router,10.0.0.1,22,false,false,0,timeout,context canceled,"))
+}
+
+func TestApp_ExportDeviceEvidenceBundle(t *testing.T) {
+	a := newActivityTestApp(t)
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+	require.NoError(t, a.resultStore.SaveResults([]checker.CheckResult{
+		{ID: "enc", DeviceID: router.ID, RunID: "run1", CheckName: "Check Service Password Encryption",
+			CheckType: "configuration", Severity: string(checker.SeverityHigh), Status: string(checker.StatusFail),
+			Message: "checked", Evidence: "username admin password 0 hunter2", CheckedAt: time.Now(),
+			Command: "show running-config | include password"},
+	}))
+	require.NoError(t, a.resultStore.SaveRunMetadata("run1", "CHG-77", ""))
+	require.NoError(t, a.deviceManager.SaveConnectivityMatrix(map[string]*device.ConnectivityResult{
+		router.ID: {Device: router, NetworkReachable: true, SSHPortOpen: true,
+			ResponseTime: 12 * time.Millisecond, TestedAt: time.Now()},
+	}))
+
+	dir := t.TempDir()
+	redacted := filepath.Join(dir, "redacted.zip")
+	require.NoError(t, a.ExportDeviceEvidenceBundle(router.ID, "run1", redacted, false))
+	withSecrets := filepath.Join(dir, "secrets.zip")
+	require.NoError(t, a.ExportDeviceEvidenceBundle(router.ID, "run1", withSecrets, true))
+
+	read := func(path string) map[string]string {
+		archive, err := zip.OpenReader(path)
+		require.NoError(t, err)
+		defer archive.Close()
+		files := make(map[string]string)
+		for _, f := range archive.File {
+			r, err := f.Open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(r)
+			r.Close()
+			require.NoError(t, err)
+			files[f.Name] = string(content)
+		}
+		return files
+	}
+
+	files := read(redacted)
+	rule := files["rules/001-check-service-password-encryption.txt"]
+	assert.NotContains(t, rule, "hunter2")
+	assert.Contains(t, rule, "Command: show running-config | include password")
+	assert.Contains(t, files[report.BundleConnectivityFile], "SSH port open: true")
+	assert.Contains(t, files[report.BundleManifestFile], `"appVersion": "`+AppVersion+`"`)
+	assert.Contains(t, files[report.BundleManifestFile], `"label": "CHG-77"`)
+	assert.Contains(t, read(withSecrets)["rules/001-check-service-password-encryption.txt"], "hunter2")
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityDevice, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "exports are audited either way")
+	for _, entry := range entries {
+		assert.Equal(t, security.ActionExport, entry.ActionType)
+		assert.Equal(t, router.ID, entry.EntityID)
+	}
+	details := entries[0].Details + "\n" + entries[1].Details
+	assert.Contains(t, details, "secrets redacted")
+	assert.Contains(t, details, "secrets included")
+
+	assert.Error(t, a.ExportDeviceEvidenceBundle(router.ID, "missing", redacted, false))
+	assert.Error(t, a.ExportDeviceEvidenceBundle("missing", "run1", redacted, false))
+}
+
+func TestApp_ExportSCAP(t *testing.T) {
+	db := newTestDB(t)
+	a := &App{
+		deviceManager: device.NewManager(db),
+		resultStore:   checker.NewResultStore(db),
+		ruleManager:   checker.NewRuleManager(db),
+		auditLogger:   security.NewAuditLogger(db),
+	}
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+	require.NoError(t, a.ruleManager.CreateRule(checker.SecurityRule{ID: "cisco-ssh-v2", Name: "SSH Version 2",
+		Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2", Severity: string(checker.SeverityHigh),
+		Remediation: "ip ssh version 2", Enabled: true, CreatedAt: time.Now()}))
+
+	now := time.Now()
+	require.NoError(t, a.resultStore.SaveResults([]checker.CheckResult{
+		{ID: "ssh", DeviceID: router.ID, RunID: "run1", CheckName: "SSH Version 2", CheckType: "configuration",
+			Severity: string(checker.SeverityHigh), Status: string(checker.StatusFail), Message: "checked", CheckedAt: now},
+	}))
+	require.NoError(t, a.resultStore.SaveRunMetadata("run1", "post-change CHG-5521", ""))
+	// A run started at the same time skips rules of its own
+	require.NoError(t, a.ruleManager.SaveSkippedRules([]checker.SkippedRule{
+		{RunID: "run0", DeviceID: router.ID, RuleID: "old-rule", RuleName: "Old", Reason: checker.SkipReasonDisabled,
+			SkippedAt: now},
+		{RunID: "run1", DeviceID: router.ID, RuleID: "banner", RuleName: "Login Banner",
+			Reason: checker.SkipReasonMaintenance, SkippedAt: now},
+	}))
+
+	path := filepath.Join(t.TempDir(), "run1.xccdf.xml")
+	require.NoError(t, os.WriteFile(path, nil, 0644))
+	require.NoError(t, a.ExportSCAP("run1", path, false))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	document := string(data)
+	assert.Contains(t, document, `<Benchmark xmlns="http://checklists.nist.gov/xccdf/1.2"`)
+	assert.Contains(t, document, `<rule-result idref="xccdf_com.invictux_rule_cisco-ssh-v2"`)
+	assert.Contains(t, document, "<fixtext>ip ssh version 2</fixtext>")
+	assert.Contains(t, document, `idref="xccdf_com.invictux_rule_banner"`)
+	assert.NotContains(t, document, "old-rule", "skips from other runs are left out")
+	assert.Contains(t, document, "<target-address>10.0.0.1</target-address>")
+	assert.Contains(t, document, "Run run1 (post-change CHG-5521) on Core Router")
+
+	assert.Error(t, a.ExportSCAP("missing", path, false))
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityCheckRun, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "run1", entries[0].EntityID)
+}
+
+func TestApp_GenerateRuleCatalog(t *testing.T) {
+	db := newTestDB(t)
+	a := &App{ruleManager: checker.NewRuleManager(db)}
+
+	require.NoError(t, a.ruleManager.CreateRule(checker.SecurityRule{ID: "cisco-ssh-v2", Name: "SSH Version 2",
+		Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2", Severity: string(checker.SeverityHigh),
+		Rationale: "SSH version 1 has known weaknesses.", References: []string{"https://example.com/ssh"},
+		Enabled: true, CreatedAt: time.Now()}))
+	require.NoError(t, a.ruleManager.CreateRule(checker.SecurityRule{ID: "cisco-old", Name: "Old Rule",
+		Vendor: "cisco", Command: "show clock", ExpectedPattern: ".", Severity: string(checker.SeverityLow),
+		CreatedAt: time.Now()}))
+
+	path := filepath.Join(t.TempDir(), "catalog.html")
+	require.NoError(t, a.GenerateRuleCatalog(path))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	document := string(data)
+	assert.Contains(t, document, `id="rule-cisco-ssh-v2"`)
+	assert.Contains(t, document, "SSH version 1 has known weaknesses.")
+	assert.NotContains(t, document, "cisco-old")
+}
+
+func TestApp_ExportDevicesStreaming(t *testing.T) {
+	a := newActivityTestApp(t)
+	var progress []device.ExportProgress
+	a.emitEvent = func(name string, data ...interface{}) {
+		if name == DeviceExportProgressEvent {
+			progress = append(progress, data[0].(device.ExportProgress))
+		}
+	}
+
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		require.NoError(t, a.deviceManager.AddDevice(&device.Device{Name: fmt.Sprintf("Switch %d", i), IPAddress: ip,
+			DeviceType: string(device.TypeSwitch), Vendor: string(device.VendorCisco), Username: "admin",
+			PasswordEncrypted: []byte("x"), SSHPort: 22}))
+	}
+
+	path := filepath.Join(t.TempDir(), "devices.csv")
+	footer, err := a.ExportDevicesStreaming(path, "csv", false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, footer.Rows)
+	assert.Equal(t, []device.ExportProgress{{Rows: 3, Total: 3}}, progress)
+
+	verified, err := device.VerifyDeviceExport(path)
+	require.NoError(t, err)
+	assert.Equal(t, footer.SHA256, verified.SHA256)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// A finished export has nothing to resume
+	_, err = a.ExportDevicesStreaming(path, "csv", true)
+	assert.Error(t, err)
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityDevice, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, security.ActionExport, entries[0].ActionType)
+	assert.Contains(t, entries[0].Details, "Exported 3 devices as csv")
+
+	_, err = a.ExportDevicesStreaming(path, "xlsx", false)
+	assert.Error(t, err)
+}
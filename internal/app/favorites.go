@@ -0,0 +1,206 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/ratelimit"
+	"invictux-demo/internal/security"
+)
+
+// favoriteRulesKey is the app_settings key holding the IDs of the favorite
+// rules as a JSON array
+const favoriteRulesKey = "favorite_rules"
+
+// FavoriteRule adds a rule to the favorites used for quick ad-hoc checks.
+// Favoriting a rule twice keeps it once.
+func (a *App) FavoriteRule(ruleID string) error {
+	if err := a.requireRole(security.RoleAdmin, "FavoriteRule"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.db == nil || a.ruleManager == nil {
+		return fmt.Errorf("rule manager not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	if _, err := a.ruleManager.GetRuleContext(ctx, ruleID); err != nil {
+		return err
+	}
+
+	ids, err := a.favoriteRuleIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == ruleID {
+			return nil
+		}
+	}
+	return a.saveFavoriteRuleIDs(append(ids, ruleID))
+}
+
+// UnfavoriteRule removes a rule from the favorites. Rules that are not a
+// favorite are ignored.
+func (a *App) UnfavoriteRule(ruleID string) error {
+	if err := a.requireRole(security.RoleAdmin, "UnfavoriteRule"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	ids, err := a.favoriteRuleIDs()
+	if err != nil {
+		return err
+	}
+	kept := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != ruleID {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) == len(ids) {
+		return nil
+	}
+	return a.saveFavoriteRuleIDs(kept)
+}
+
+// GetFavoriteRules returns the favorite rules in the order they were
+// favorited. Favorites whose rule has since been deleted are left out.
+func (a *App) GetFavoriteRules() ([]checker.SecurityRule, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.db == nil || a.ruleManager == nil {
+		return nil, fmt.Errorf("rule manager not initialized")
+	}
+
+	ids, err := a.favoriteRuleIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	rules, err := a.ruleManager.GetAllRulesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]checker.SecurityRule, len(rules))
+	for _, rule := range rules {
+		byID[rule.ID] = rule
+	}
+
+	favorites := make([]checker.SecurityRule, 0, len(ids))
+	for _, id := range ids {
+		if rule, ok := byID[id]; ok {
+			favorites = append(favorites, rule)
+		}
+	}
+	return favorites, nil
+}
+
+// RunFavoriteRulesOnDevice runs only the favorite rules on a device and
+// saves the results like any other run
+func (a *App) RunFavoriteRulesOnDevice(deviceID string) ([]checker.CheckResult, error) {
+	if err := a.requireRole(security.RoleOperator, "RunFavoriteRulesOnDevice"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if err := a.requireStorage(); err != nil {
+		return nil, err
+	}
+	if err := a.allowCall(ratelimit.MethodRunSecurityCheck, deviceID); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.checkEngine == nil {
+		return nil, fmt.Errorf("check engine not initialized")
+	}
+
+	ids, err := a.favoriteRuleIDs()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no favorite rules to run")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := a.checkOptions()
+	opts.RuleIDs = ids
+	results, err := a.checkEngine.RunChecksWithOptions(dev, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	a.saveCheckResults(results)
+	a.refreshStorage()
+	return results, nil
+}
+
+// favoriteRuleIDs reads the saved favorite rule IDs
+func (a *App) favoriteRuleIDs() ([]string, error) {
+	value, ok, err := a.getSetting(favoriteRulesKey)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var ids []string
+	if err := json.Unmarshal([]byte(value), &ids); err != nil {
+		return nil, fmt.Errorf("failed to decode favorite rules: %w", err)
+	}
+	return ids, nil
+}
+
+// saveFavoriteRuleIDs stores the favorite rule IDs
+func (a *App) saveFavoriteRuleIDs(ids []string) error {
+	encoded, err := json.Marshal(ids)
+	if err != nil {
+		return fmt.Errorf("failed to encode favorite rules: %w", err)
+	}
+	return a.saveSetting(favoriteRulesKey, string(encoded))
+}
+
+// moveFavoriteRules points the favorites among the rules moved, a map of
+// old to new rule IDs, at their new IDs
+func (a *App) moveFavoriteRules(moved map[string]string) error {
+	if len(moved) == 0 {
+		return nil
+	}
+
+	ids, err := a.favoriteRuleIDs()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for i, id := range ids {
+		if newID, ok := moved[id]; ok {
+			ids[i] = newID
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return a.saveFavoriteRuleIDs(ids)
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commandLog records the commands sent through an SSH client
+type commandLog struct {
+	ssh.SSHClientInterface
+	mu       sync.Mutex
+	commands []string
+}
+
+func (c *commandLog) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	c.mu.Lock()
+	c.commands = append(c.commands, command)
+	c.mu.Unlock()
+	return c.SSHClientInterface.ExecuteCommand(ctx, conn, command)
+}
+
+func (c *commandLog) ExecuteCommands(ctx context.Context, conn *ssh.SSHConnection, commands []string) ([]*ssh.CommandResult, error) {
+	c.mu.Lock()
+	c.commands = append(c.commands, commands...)
+	c.mu.Unlock()
+	return c.SSHClientInterface.ExecuteCommands(ctx, conn, commands)
+}
+
+func TestApp_FavoriteRules(t *testing.T) {
+	db := &database.DB{DB: newTestDB(t)}
+	network := &commandLog{SSHClientInterface: ssh.NewSimulatedClient([]*ssh.SessionFixture{{Host: "10.0.0.1", Port: 22,
+		Commands: []ssh.RecordedCommand{
+			{Command: "show ip ssh", Output: "SSH Enabled - version 2.0"},
+			{Command: "show line vty 0 4", Output: "transport input telnet"},
+			{Command: "show ntp status", Output: "Clock is synchronized"},
+		}}}, ssh.SimulationConfig{FailUnknownCommands: true})}
+	rules := checker.NewRuleManager(db.DB)
+	a := &App{
+		db:            db,
+		ruleManager:   rules,
+		deviceManager: device.NewManager(db.DB),
+		checkEngine:   checker.NewEngineWithSSHClient(rules, network),
+		resultStore:   checker.NewResultStore(db.DB),
+	}
+
+	for _, rule := range []checker.SecurityRule{
+		{ID: "r1", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2"},
+		{ID: "r2", Name: "Telnet Disabled", Vendor: "generic", Command: "show line vty 0 4", ExpectedPattern: "transport input ssh"},
+		{ID: "r3", Name: "NTP Synchronized", Vendor: "generic", Command: "show ntp status", ExpectedPattern: "synchronized"},
+	} {
+		rule.Severity, rule.Enabled = string(checker.SeverityHigh), true
+		require.NoError(t, rules.CreateRule(rule))
+	}
+
+	favorites, err := a.GetFavoriteRules()
+	require.NoError(t, err)
+	assert.Empty(t, favorites)
+
+	for _, id := range []string{"r3", "r1", "r2", "r1"} {
+		require.NoError(t, a.FavoriteRule(id))
+	}
+	assert.Error(t, a.FavoriteRule("missing"))
+	require.NoError(t, a.UnfavoriteRule("r2"))
+	require.NoError(t, a.UnfavoriteRule("r2"), "unfavoriting twice is harmless")
+
+	favorites, err = a.GetFavoriteRules()
+	require.NoError(t, err)
+	require.Len(t, favorites, 2)
+	assert.Equal(t, "r3", favorites[0].ID, "favorites keep the order they were added in")
+	assert.Equal(t, "r1", favorites[1].ID)
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	results, err := a.RunFavoriteRulesOnDevice(router.ID)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	names := []string{results[0].CheckName, results[1].CheckName}
+	assert.ElementsMatch(t, []string{"SSH v2", "NTP Synchronized"}, names)
+	assert.ElementsMatch(t, []string{"show ip ssh", "show ntp status"}, network.commands)
+
+	stored, err := a.resultStore.GetDeviceResults(router.ID, 10)
+	require.NoError(t, err)
+	assert.Len(t, stored, 2)
+
+	// A deleted rule drops out of the favorites
+	require.NoError(t, rules.DeleteRule("r3"))
+	favorites, err = a.GetFavoriteRules()
+	require.NoError(t, err)
+	require.Len(t, favorites, 1)
+	assert.Equal(t, "r1", favorites[0].ID)
+
+	require.NoError(t, a.UnfavoriteRule("r1"))
+	require.NoError(t, a.UnfavoriteRule("r3"))
+	_, err = a.RunFavoriteRulesOnDevice(router.ID)
+	assert.Error(t, err, "nothing to run")
+}
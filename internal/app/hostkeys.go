@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+)
+
+// hostKeyPinTimeout bounds the connection made to pin a device's host key
+const hostKeyPinTimeout = 30 * time.Second
+
+// PinDeviceHostKey connects to a device and pins the host key it presents.
+// Later connections to the device are refused when it presents another key,
+// until the pin is cleared with ClearDeviceHostKeyPin.
+func (a *App) PinDeviceHostKey(deviceID string) error {
+	if err := a.requireRole(security.RoleAdmin, "PinDeviceHostKey"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.deviceManager == nil || a.sshClient == nil {
+		return fmt.Errorf("SSH client not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(a.appContext(), hostKeyPinTimeout)
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	connInfo, err := a.deviceConnectionInfo(dev)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := a.sshClient.ReadHostKeyFingerprint(ctx, connInfo)
+	if err != nil {
+		return err
+	}
+
+	if err := a.saveSetting(device.HostKeyPinKey(dev.ID), fingerprint); err != nil {
+		return err
+	}
+	a.recordAudit(security.ActionUpdate, security.EntityDevice, dev.ID,
+		fmt.Sprintf("Pinned host key %s for device %s", fingerprint, dev.Name))
+	return nil
+}
+
+// ClearDeviceHostKeyPin removes a device's host key pin, for when its key
+// legitimately changed. The key trusted for the device is forgotten too, so
+// the next connection trusts the key it presents, as on first use.
+func (a *App) ClearDeviceHostKeyPin(deviceID string) error {
+	if err := a.requireRole(security.RoleAdmin, "ClearDeviceHostKeyPin"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.deviceManager == nil || a.db == nil {
+		return fmt.Errorf("device manager not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.db.ExecContext(ctx, "DELETE FROM app_settings WHERE key = ?", device.HostKeyPinKey(dev.ID)); err != nil {
+		return fmt.Errorf("failed to clear host key pin: %w", err)
+	}
+	ssh.ForgetHostKey(dev.IPAddress, dev.SSHPort)
+
+	a.recordAudit(security.ActionDelete, security.EntityDevice, dev.ID,
+		fmt.Sprintf("Cleared host key pin for device %s", dev.Name))
+	return nil
+}
+
+// hostKeyPin looks up host key pins for the app's SSH client. The device
+// manager is read on each call, since it is rebuilt when the database is
+// reopened.
+func (a *App) hostKeyPin(address string) (string, error) {
+	if a.deviceManager == nil || a.db == nil {
+		return "", nil
+	}
+	return a.deviceManager.HostKeyPin(address)
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_DeviceHostKeyPin(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	pin, err := a.hostKeyPin("10.0.0.1:22")
+	require.NoError(t, err)
+	assert.Empty(t, pin, "devices start unpinned")
+
+	require.NoError(t, a.saveSetting(device.HostKeyPinKey(router.ID), "SHA256:pinned"))
+	pin, err = a.hostKeyPin("10.0.0.1:22")
+	require.NoError(t, err)
+	assert.Equal(t, "SHA256:pinned", pin)
+
+	for _, address := range []string{"10.0.0.1:2222", "10.0.0.9:22"} {
+		pin, err = a.hostKeyPin(address)
+		require.NoError(t, err)
+		assert.Empty(t, pin, address)
+	}
+	_, err = a.hostKeyPin("10.0.0.1")
+	assert.Error(t, err)
+
+	require.NoError(t, a.ClearDeviceHostKeyPin(router.ID))
+	_, ok, err := a.getSetting(device.HostKeyPinKey(router.ID))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	pin, err = a.hostKeyPin("10.0.0.1:22")
+	require.NoError(t, err)
+	assert.Empty(t, pin, "a cleared pin is trusted on first use again")
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityDevice, 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Equal(t, "Cleared host key pin for device Core Router", entries[0].Details)
+
+	// Credentials that cannot be decrypted pin nothing
+	assert.Error(t, a.PinDeviceHostKey(router.ID))
+	_, ok, err = a.getSetting(device.HostKeyPinKey(router.ID))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Error(t, a.PinDeviceHostKey("missing"))
+	assert.Error(t, a.ClearDeviceHostKeyPin("missing"))
+	assert.Error(t, (&App{}).PinDeviceHostKey(router.ID))
+	assert.Error(t, (&App{}).ClearDeviceHostKeyPin(router.ID))
+}
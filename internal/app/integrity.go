@@ -0,0 +1,103 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/security"
+)
+
+// DatabaseRecoveryEvent is emitted to the frontend with the RecoveryReport
+// when startup found the database corrupt and tried to recover it
+const DatabaseRecoveryEvent = "database:recovery"
+
+// verifyDatabaseFile quick-checks the database file before it is opened and
+// recovers it from a backup or by salvaging its rows when it is corrupt.
+// It returns false when a corrupt database could not be recovered.
+func (a *App) verifyDatabaseFile(status *StartupStatus) bool {
+	integrity, recovery, err := database.CheckAndRecover(a.dataDir, database.DefaultRecoveryOptions(a.dataDir))
+	status.Integrity = integrity
+	status.Recovery = recovery
+
+	if recovery != nil {
+		log.Printf("Database failed its integrity check: %s", strings.Join(integrity.Problems, "; "))
+		for _, step := range recovery.Steps {
+			log.Printf("Database recovery: %s", describeRecoveryStep(step))
+		}
+		if a.emitEvent != nil {
+			a.emitEvent(DatabaseRecoveryEvent, recovery)
+		}
+	}
+
+	if err == nil {
+		return true
+	}
+	if recovery == nil {
+		// Opening the database reports why the file could not be checked
+		log.Printf("Failed to check database integrity: %v", err)
+		return true
+	}
+
+	status.DatabaseStatus = DatabaseCorrupt
+	status.Errors = append(status.Errors, err.Error())
+	return false
+}
+
+// auditRecovery records every step of a startup recovery in the audit log
+// of the recovered database
+func (a *App) auditRecovery(recovery *database.RecoveryReport) {
+	if recovery == nil {
+		return
+	}
+
+	a.recordAudit(security.ActionUpdate, security.EntityDatabase, "",
+		fmt.Sprintf("Database failed its integrity check: %s", strings.Join(recovery.Integrity.Problems, "; ")))
+	for _, step := range recovery.Steps {
+		a.recordAudit(security.ActionUpdate, security.EntityDatabase, "", "Database recovery: "+describeRecoveryStep(step))
+	}
+}
+
+// describeRecoveryStep formats a recovery step for the log and audit log
+func describeRecoveryStep(step database.RecoveryStep) string {
+	outcome := "done"
+	if !step.OK {
+		outcome = "failed"
+	}
+	if step.Detail == "" {
+		return fmt.Sprintf("%s %s", step.Action, outcome)
+	}
+	return fmt.Sprintf("%s %s (%s)", step.Action, outcome, step.Detail)
+}
+
+// CheckDatabaseIntegrity runs a full integrity check of the open database,
+// including its indexes, and keeps the result in the startup status. It
+// also runs while the app is degraded. A corrupt database is recovered on
+// the next start.
+func (a *App) CheckDatabaseIntegrity() (*database.IntegrityReport, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	// A full check reads the whole file, so it is not bound by the request timeout
+	report, err := a.db.CheckIntegrity(a.appContext(), database.IntegrityFull)
+	if err != nil {
+		return nil, err
+	}
+
+	a.startupMutex.Lock()
+	if a.startupStatus != nil {
+		updated := *a.startupStatus
+		updated.Integrity = report
+		a.startupStatus = &updated
+	}
+	a.startupMutex.Unlock()
+
+	if !report.OK {
+		log.Printf("Database failed its integrity check: %s", strings.Join(report.Problems, "; "))
+		a.recordAudit(security.ActionUpdate, security.EntityDatabase, "",
+			fmt.Sprintf("Database failed its integrity check: %s", strings.Join(report.Problems, "; ")))
+	}
+	return report, nil
+}
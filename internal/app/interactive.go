@@ -0,0 +1,331 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/ratelimit"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+
+	"github.com/google/uuid"
+)
+
+// SSHChallengeEvent is emitted with an SSHChallenge when a device asks a
+// keyboard-interactive question, such as a Duo or SecurID passcode. The
+// frontend replies with AnswerSSHChallenge or CancelSSHChallenge.
+const SSHChallengeEvent = "ssh:challenge"
+
+// sshChallengeTimeout bounds how long a login waits for the user to answer
+const sshChallengeTimeout = 2 * time.Minute
+
+// interactiveSessionIdleTimeout closes an interactive session left unused
+// this long, so a second factor does not keep a device login open forever
+const interactiveSessionIdleTimeout = 15 * time.Minute
+
+// SSHChallenge is one round of keyboard-interactive questions from a device
+type SSHChallenge struct {
+	ID          string   `json:"id"`
+	DeviceID    string   `json:"deviceId"`
+	User        string   `json:"user"`
+	Instruction string   `json:"instruction"`
+	Questions   []string `json:"questions"`
+}
+
+// challengeReply carries the frontend's answers; nil answers cancel
+type challengeReply struct {
+	answers []string
+}
+
+// interactiveSession is an SSH connection opened with interactive login.
+// idle closes it after interactiveSessionIdleTimeout without use; users
+// counts the runs using it, which hold the timer off.
+type interactiveSession struct {
+	deviceID string
+	conn     *ssh.SSHConnection
+	idle     *time.Timer
+	users    int
+}
+
+// interactiveState holds pending challenges and open interactive sessions
+type interactiveState struct {
+	mutex      sync.Mutex
+	challenges map[string]chan challengeReply
+	sessions   map[string]*interactiveSession
+}
+
+// ConnectWithInteractiveAuth logs in to a device whose second factor is
+// asked over keyboard-interactive. Each question round is sent to the
+// frontend as an SSHChallengeEvent. The returned token names the open
+// session for RunSecurityCheckWithSession until CloseInteractiveSession, or
+// until it has been unused for interactiveSessionIdleTimeout.
+func (a *App) ConnectWithInteractiveAuth(deviceID string) (string, error) {
+	if err := a.requireRole(security.RoleOperator, "ConnectWithInteractiveAuth"); err != nil {
+		return "", err
+	}
+	if err := a.requireReady(); err != nil {
+		return "", err
+	}
+	if a.deviceManager == nil || a.sshClient == nil {
+		return "", fmt.Errorf("SSH client not initialized")
+	}
+	if a.emitEvent == nil {
+		return "", fmt.Errorf("interactive login needs the frontend")
+	}
+
+	ctx, cancel := context.WithTimeout(a.appContext(), sshChallengeTimeout)
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return "", err
+	}
+	connInfo, err := a.deviceConnectionInfo(dev)
+	if err != nil {
+		return "", err
+	}
+
+	// The stored password and keyboard responses are tried first; anything
+	// the device asks that they do not answer goes to the user
+	connInfo.InteractiveAnswerFn = a.interactiveAnswerFn(ctx, dev.ID)
+	conn, err := a.sshClient.Connect(ctx, connInfo)
+	if err != nil {
+		return "", err
+	}
+
+	token := uuid.New().String()
+	a.interactive.mutex.Lock()
+	if a.interactive.sessions == nil {
+		a.interactive.sessions = make(map[string]*interactiveSession)
+	}
+	a.interactive.sessions[token] = &interactiveSession{
+		deviceID: dev.ID,
+		conn:     conn,
+		idle:     time.AfterFunc(interactiveSessionIdleTimeout, func() { a.expireInteractiveSession(token) }),
+	}
+	a.interactive.mutex.Unlock()
+
+	return token, nil
+}
+
+// RunSecurityCheckWithSession runs security checks on the device of a
+// session opened by ConnectWithInteractiveAuth, over that session, so the
+// user is not asked for a second factor again. The session stays open. The
+// optional label and note describe the run.
+func (a *App) RunSecurityCheckWithSession(token, label, note string) ([]checker.CheckResult, error) {
+	if err := a.requireRole(security.RoleOperator, "RunSecurityCheckWithSession"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if err := a.requireStorage(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.checkEngine == nil || a.sshClient == nil {
+		return nil, fmt.Errorf("check engine not initialized")
+	}
+
+	session, release, err := a.useInteractiveSession(token)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := a.allowCall(ratelimit.MethodRunSecurityCheck, session.deviceID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, session.deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := a.checkOptions()
+	opts.Label, opts.Note = label, note
+	opts.Client = &sessionClient{SSHClientInterface: a.sshClient, conn: session.conn}
+	results, err := a.checkEngine.RunChecksWithOptions(dev, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	a.saveCheckResults(results)
+	if len(results) > 0 {
+		a.saveRunMetadata(results[0].RunID, opts)
+	}
+	a.refreshStorage()
+	return results, nil
+}
+
+// AnswerSSHChallenge answers a pending SSHChallenge, one answer per question
+func (a *App) AnswerSSHChallenge(challengeID string, answers []string) error {
+	if err := a.requireRole(security.RoleOperator, "AnswerSSHChallenge"); err != nil {
+		return err
+	}
+	if answers == nil {
+		answers = []string{}
+	}
+	return a.replyChallenge(challengeID, challengeReply{answers: answers})
+}
+
+// CancelSSHChallenge abandons the login waiting on a challenge
+func (a *App) CancelSSHChallenge(challengeID string) error {
+	if err := a.requireRole(security.RoleOperator, "CancelSSHChallenge"); err != nil {
+		return err
+	}
+	return a.replyChallenge(challengeID, challengeReply{})
+}
+
+// CloseInteractiveSession disconnects a session opened by
+// ConnectWithInteractiveAuth
+func (a *App) CloseInteractiveSession(token string) error {
+	if err := a.requireRole(security.RoleOperator, "CloseInteractiveSession"); err != nil {
+		return err
+	}
+	a.interactive.mutex.Lock()
+	session, ok := a.interactive.sessions[token]
+	delete(a.interactive.sessions, token)
+	a.interactive.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("interactive session %s not found", token)
+	}
+	session.idle.Stop()
+	return a.sshClient.Disconnect(session.conn)
+}
+
+// useInteractiveSession returns the open session named by token and holds
+// off its idle timeout until release is called
+func (a *App) useInteractiveSession(token string) (*interactiveSession, func(), error) {
+	a.interactive.mutex.Lock()
+	defer a.interactive.mutex.Unlock()
+
+	session, ok := a.interactive.sessions[token]
+	if !ok {
+		return nil, nil, fmt.Errorf("interactive session %s not found or expired", token)
+	}
+	session.users++
+	session.idle.Stop()
+
+	release := func() {
+		a.interactive.mutex.Lock()
+		defer a.interactive.mutex.Unlock()
+		session.users--
+		if session.users == 0 {
+			session.idle.Reset(interactiveSessionIdleTimeout)
+		}
+	}
+	return session, release, nil
+}
+
+// expireInteractiveSession closes a session whose idle timeout passed,
+// unless a run started using it meanwhile
+func (a *App) expireInteractiveSession(token string) {
+	a.interactive.mutex.Lock()
+	session, ok := a.interactive.sessions[token]
+	if ok && session.users > 0 {
+		ok = false
+	}
+	if ok {
+		delete(a.interactive.sessions, token)
+	}
+	a.interactive.mutex.Unlock()
+
+	if ok && a.sshClient != nil {
+		a.sshClient.Disconnect(session.conn)
+	}
+}
+
+// closeInteractiveSessions disconnects every open interactive session
+func (a *App) closeInteractiveSessions() {
+	a.interactive.mutex.Lock()
+	sessions := a.interactive.sessions
+	a.interactive.sessions = nil
+	a.interactive.mutex.Unlock()
+
+	for _, session := range sessions {
+		session.idle.Stop()
+		if a.sshClient != nil {
+			a.sshClient.Disconnect(session.conn)
+		}
+	}
+}
+
+// sessionClient runs a check run over an interactive session. Every
+// connect gets the session's connection, which stays open when the run
+// disconnects from it; commands go through the app's SSH client.
+type sessionClient struct {
+	ssh.SSHClientInterface
+	conn *ssh.SSHConnection
+}
+
+func (c *sessionClient) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	return c.conn, nil
+}
+
+func (c *sessionClient) Disconnect(conn *ssh.SSHConnection) error {
+	return nil
+}
+
+func (c *sessionClient) Close() error {
+	return nil
+}
+
+// interactiveAnswerFn forwards each question round to the frontend and
+// waits for the reply until ctx ends
+func (a *App) interactiveAnswerFn(ctx context.Context, deviceID string) ssh.InteractiveAnswerFunc {
+	return func(user, instruction string, questions []string) ([]string, error) {
+		challenge := SSHChallenge{
+			ID:          uuid.New().String(),
+			DeviceID:    deviceID,
+			User:        user,
+			Instruction: instruction,
+			Questions:   questions,
+		}
+
+		replies := make(chan challengeReply, 1)
+		a.interactive.mutex.Lock()
+		if a.interactive.challenges == nil {
+			a.interactive.challenges = make(map[string]chan challengeReply)
+		}
+		a.interactive.challenges[challenge.ID] = replies
+		a.interactive.mutex.Unlock()
+
+		defer func() {
+			a.interactive.mutex.Lock()
+			delete(a.interactive.challenges, challenge.ID)
+			a.interactive.mutex.Unlock()
+		}()
+
+		a.emitEvent(SSHChallengeEvent, challenge)
+
+		select {
+		case reply := <-replies:
+			if reply.answers == nil {
+				return nil, fmt.Errorf("login cancelled")
+			}
+			return reply.answers, nil
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no answer to the login challenge: %w", ctx.Err())
+		}
+	}
+}
+
+// replyChallenge hands a reply to the login waiting on a challenge
+func (a *App) replyChallenge(challengeID string, reply challengeReply) error {
+	a.interactive.mutex.Lock()
+	replies, ok := a.interactive.challenges[challengeID]
+	delete(a.interactive.challenges, challengeID)
+	a.interactive.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("SSH challenge %s not found or expired", challengeID)
+	}
+	replies <- reply
+	return nil
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_InteractiveAnswerFn(t *testing.T) {
+	a := &App{}
+	var challenges []SSHChallenge
+	a.emitEvent = func(name string, data ...interface{}) {
+		require.Equal(t, SSHChallengeEvent, name)
+		challenge := data[0].(SSHChallenge)
+		challenges = append(challenges, challenge)
+		// The frontend answers from another goroutine
+		go func() {
+			if challenge.Instruction == "cancel" {
+				assert.NoError(t, a.CancelSSHChallenge(challenge.ID))
+				return
+			}
+			assert.NoError(t, a.AnswerSSHChallenge(challenge.ID, []string{"123456", "yes"}))
+		}()
+	}
+
+	answerFn := a.interactiveAnswerFn(context.Background(), "device1")
+	answers, err := answerFn("admin", "Duo two-factor login", []string{"Passcode: ", "Trust this device? "})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"123456", "yes"}, answers)
+
+	require.Len(t, challenges, 1)
+	assert.Equal(t, "device1", challenges[0].DeviceID)
+	assert.Equal(t, "admin", challenges[0].User)
+	assert.Equal(t, []string{"Passcode: ", "Trust this device? "}, challenges[0].Questions)
+
+	// An answered challenge cannot be answered again
+	assert.Error(t, a.AnswerSSHChallenge(challenges[0].ID, []string{"again"}))
+
+	_, err = answerFn("admin", "cancel", []string{"Passcode: "})
+	assert.Error(t, err)
+}
+
+func TestApp_InteractiveAnswerFnTimeout(t *testing.T) {
+	a := &App{}
+	a.emitEvent = func(name string, data ...interface{}) {}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := a.interactiveAnswerFn(ctx, "device1")("admin", "", []string{"Passcode: "})
+	assert.Error(t, err)
+	assert.Empty(t, a.interactive.challenges, "expired challenges are dropped")
+}
+
+func TestApp_ConnectWithInteractiveAuthRequiresFrontend(t *testing.T) {
+	a := newActivityTestApp(t)
+	_, err := a.ConnectWithInteractiveAuth("device1")
+	assert.Error(t, err)
+	assert.Error(t, a.CloseInteractiveSession("missing"))
+}
+
+func TestApp_SetDeviceKeyboardResponses(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+
+	password, err := a.EncryptPassword("device-password")
+	require.NoError(t, err)
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: password, SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	require.NoError(t, a.SetDeviceKeyboardResponses(router.ID, []KeyboardResponseInput{
+		{Prompt: " verification code ", Response: "424242"},
+		{Prompt: "^domain", Response: "CORP", AllowEcho: true},
+	}))
+
+	stored, err := a.deviceManager.GetDevice(router.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.KeyboardResponses, 2)
+	assert.Equal(t, "verification code", stored.KeyboardResponses[0].Prompt)
+	assert.NotContains(t, string(stored.KeyboardResponses[0].ResponseEncrypted), "424242", "responses are encrypted")
+
+	connInfo, err := a.deviceConnectionInfo(stored)
+	require.NoError(t, err)
+	assert.Equal(t, "device-password", connInfo.Password)
+	assert.Equal(t, []ssh.AuthMethod{ssh.AuthPassword, ssh.AuthKeyboard}, connInfo.AuthMethods)
+	require.Len(t, connInfo.KeyboardResponses, 2)
+	assert.Equal(t, "424242", connInfo.KeyboardResponses[0].Response)
+	assert.Equal(t, "CORP", connInfo.KeyboardResponses[1].Response)
+	assert.True(t, connInfo.KeyboardResponses[1].AllowEcho)
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityDevice, 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Equal(t, "Set 2 keyboard-interactive responses on device Core Router", entries[0].Details)
+
+	assert.Error(t, a.SetDeviceKeyboardResponses(router.ID, []KeyboardResponseInput{{Prompt: "code"}}))
+	assert.Error(t, a.SetDeviceKeyboardResponses(router.ID, []KeyboardResponseInput{{Prompt: "code[", Response: "1"}}))
+	assert.Error(t, a.SetDeviceKeyboardResponses("missing", nil))
+
+	require.NoError(t, a.SetDeviceKeyboardResponses(router.ID, nil))
+	stored, err = a.deviceManager.GetDevice(router.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.KeyboardResponses)
+}
+
+func TestApp_InteractiveSessionRuns(t *testing.T) {
+	db := &database.DB{DB: newTestDB(t)}
+	simulated := ssh.NewSimulatedClient([]*ssh.SessionFixture{{Host: "10.0.0.1", Port: 22,
+		Commands: []ssh.RecordedCommand{{Command: "show ip ssh", Output: "SSH Enabled - version 2.0"}}}},
+		ssh.SimulationConfig{FailUnknownCommands: true})
+	rules := checker.NewRuleManager(db.DB)
+	a := &App{
+		db:            db,
+		ruleManager:   rules,
+		deviceManager: device.NewManager(db.DB),
+		checkEngine:   checker.NewEngine(rules),
+		resultStore:   checker.NewResultStore(db.DB),
+	}
+	require.NoError(t, rules.CreateRule(checker.SecurityRule{ID: "r1", Name: "SSH v2", Vendor: "generic",
+		Command: "show ip ssh", ExpectedPattern: "version 2", Severity: string(checker.SeverityHigh), Enabled: true}))
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	// The interactive login happened earlier; the run only uses its connection
+	conn, err := simulated.Connect(context.Background(), &ssh.ConnectionInfo{Host: "10.0.0.1", Port: 22, Username: "admin"})
+	require.NoError(t, err)
+	network := &commandLog{SSHClientInterface: simulated}
+	client := &sessionClient{SSHClientInterface: network, conn: conn}
+
+	opts := a.checkOptions()
+	opts.Client = client
+	results, err := a.checkEngine.RunChecksWithOptions(router, opts, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, string(checker.StatusPass), results[0].Status)
+	assert.Equal(t, []string{"show ip ssh"}, network.commands)
+
+	same, err := client.Connect(context.Background(), &ssh.ConnectionInfo{Host: "10.0.0.2"})
+	require.NoError(t, err)
+	assert.Same(t, conn, same, "every connect gets the session's connection")
+
+	a.sshClient = ssh.NewSSHClient(ssh.DefaultClientConfig())
+	_, err = a.RunSecurityCheckWithSession("missing", "", "")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestApp_InteractiveSessionIdleTimeout(t *testing.T) {
+	a := &App{}
+	a.interactive.sessions = map[string]*interactiveSession{
+		"token": {deviceID: "device1", conn: ssh.NewConnectionForTesting(ssh.Handshake{}),
+			idle: time.AfterFunc(time.Hour, func() {})},
+	}
+
+	// A session in use outlives its idle timeout
+	session, release, err := a.useInteractiveSession("token")
+	require.NoError(t, err)
+	assert.Equal(t, "device1", session.deviceID)
+	a.expireInteractiveSession("token")
+	_, stillOpen := a.interactive.sessions["token"]
+	assert.True(t, stillOpen)
+
+	release()
+	a.expireInteractiveSession("token")
+	_, _, err = a.useInteractiveSession("token")
+	assert.ErrorContains(t, err, "expired")
+	assert.Error(t, a.CloseInteractiveSession("token"))
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+
+	"invictux-demo/internal/catalog"
+	"invictux-demo/internal/security"
+)
+
+// SetLocale sets the locale newly produced result messages are rendered in
+func (a *App) SetLocale(locale string) error {
+	if err := a.requireRole(security.RoleAdmin, "SetLocale"); err != nil {
+		return err
+	}
+	if !catalog.HasLocale(locale) {
+		return fmt.Errorf("unsupported locale %q (supported: %v)", locale, catalog.Locales())
+	}
+
+	a.locale = locale
+	if a.checkEngine != nil {
+		a.checkEngine.SetLocale(locale)
+	}
+	return nil
+}
+
+// GetLocale returns the locale result messages are rendered in
+func (a *App) GetLocale() string {
+	if a.locale == "" {
+		return catalog.DefaultLocale
+	}
+	return a.locale
+}
+
+// GetSupportedLocales returns the locales result messages can be rendered in
+func (a *App) GetSupportedLocales() []string {
+	return catalog.Locales()
+}
+
+// RenderMessage renders a catalog message in a locale, for exports and
+// reports of stored results. An empty locale uses the app's locale.
+func (a *App) RenderMessage(id string, params map[string]string, locale string) string {
+	if locale == "" {
+		locale = a.GetLocale()
+	}
+	return catalog.Render(id, params, locale)
+}
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"testing"
+
+	"invictux-demo/internal/catalog"
+	"invictux-demo/internal/checker"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_SetLocale(t *testing.T) {
+	a := &App{checkEngine: checker.NewEngine(nil)}
+	assert.Equal(t, catalog.DefaultLocale, a.GetLocale())
+
+	assert.Error(t, a.SetLocale("fr"))
+	assert.Equal(t, catalog.DefaultLocale, a.GetLocale())
+
+	assert.NoError(t, a.SetLocale("es-MX"))
+	assert.Equal(t, "es-MX", a.GetLocale())
+	assert.Equal(t, "es-MX", a.checkEngine.GetLocale())
+
+	params := map[string]string{"error": "EOF"}
+	assert.Equal(t, "Falló la ejecución del comando: EOF", a.RenderMessage(catalog.MsgCommandFailed, params, ""))
+	assert.Equal(t, "Command execution failed: EOF", a.RenderMessage(catalog.MsgCommandFailed, params, "en"))
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"fmt"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/security"
+)
+
+// CreateCommandMacro stores a command template rule commands can reference
+// as @name
+func (a *App) CreateCommandMacro(macro checker.CommandMacro) (*checker.CommandMacro, error) {
+	if err := a.requireRole(security.RoleAdmin, "CreateCommandMacro"); err != nil {
+		return nil, err
+	}
+	if a.macroManager == nil {
+		return nil, fmt.Errorf("macro manager not initialized")
+	}
+
+	if err := a.macroManager.CreateMacro(&macro); err != nil {
+		return nil, err
+	}
+
+	a.recordAudit(security.ActionCreate, security.EntityCommandMacro, macro.Name,
+		fmt.Sprintf("Created macro @%s: %s", macro.Name, macro.Command))
+	a.reportRuleHealth()
+	return &macro, nil
+}
+
+// UpdateCommandMacro changes the command and description of a macro; every
+// rule referencing it runs the new command from its next check. The rules
+// are validated again, so a macro turned risky flags the rules using it.
+func (a *App) UpdateCommandMacro(macro checker.CommandMacro) error {
+	if err := a.requireRole(security.RoleAdmin, "UpdateCommandMacro"); err != nil {
+		return err
+	}
+	if a.macroManager == nil {
+		return fmt.Errorf("macro manager not initialized")
+	}
+
+	if err := a.macroManager.UpdateMacro(macro); err != nil {
+		return err
+	}
+
+	a.recordAudit(security.ActionUpdate, security.EntityCommandMacro, macro.Name,
+		fmt.Sprintf("Changed macro @%s to: %s", macro.Name, macro.Command))
+	a.reportRuleHealth()
+	return nil
+}
+
+// DeleteCommandMacro removes a macro. Rules still referencing it fail until
+// they are changed.
+func (a *App) DeleteCommandMacro(name string) error {
+	if err := a.requireRole(security.RoleAdmin, "DeleteCommandMacro"); err != nil {
+		return err
+	}
+	if a.macroManager == nil {
+		return fmt.Errorf("macro manager not initialized")
+	}
+
+	if err := a.macroManager.DeleteMacro(name); err != nil {
+		return err
+	}
+
+	a.recordAudit(security.ActionDelete, security.EntityCommandMacro, name,
+		fmt.Sprintf("Removed macro @%s", name))
+	a.reportRuleHealth()
+	return nil
+}
+
+// ValidateCommandMacro checks a macro before it is saved. Invalid macros are
+// rejected; the returned warnings list command lines that look like they
+// change the device, for the author to confirm.
+func (a *App) ValidateCommandMacro(macro checker.CommandMacro) ([]checker.CommandWarning, error) {
+	if err := macro.Validate(); err != nil {
+		return nil, err
+	}
+	warnings := macro.Warnings()
+	if warnings == nil {
+		warnings = []checker.CommandWarning{}
+	}
+	return warnings, nil
+}
+
+// GetCommandMacros returns every command macro ordered by name
+func (a *App) GetCommandMacros() ([]checker.CommandMacro, error) {
+	if a.macroManager == nil {
+		return nil, fmt.Errorf("macro manager not initialized")
+	}
+	return a.macroManager.GetAllMacros()
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"testing"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_CommandMacros(t *testing.T) {
+	a := &App{}
+	_, err := a.GetCommandMacros()
+	assert.Error(t, err)
+
+	a = newActivityTestApp(t)
+	a.macroManager = checker.NewMacroManager(newTestDB(t))
+
+	created, err := a.CreateCommandMacro(checker.CommandMacro{Name: "vty_section", Command: "show running-config | section line vty"})
+	require.NoError(t, err)
+	assert.False(t, created.CreatedAt.IsZero())
+	_, err = a.CreateCommandMacro(checker.CommandMacro{Name: "bad name", Command: "show version"})
+	assert.Error(t, err)
+
+	require.NoError(t, a.UpdateCommandMacro(checker.CommandMacro{Name: "vty_section", Command: "show run | section vty"}))
+	macros, err := a.GetCommandMacros()
+	require.NoError(t, err)
+	require.Len(t, macros, 1)
+	assert.Equal(t, "show run | section vty", macros[0].Command)
+
+	warnings, err := a.ValidateCommandMacro(checker.CommandMacro{Name: "save", Command: "write memory"})
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Equal(t, checker.VariantMacro+"save", warnings[0].Variant)
+	_, err = a.ValidateCommandMacro(checker.CommandMacro{Name: "bad name", Command: "show version"})
+	assert.Error(t, err)
+
+	// Rules are validated with their macros expanded
+	warnings, err = a.ValidateSecurityRule(checker.SecurityRule{Command: "@vty_section", ExpectedPattern: "vty"})
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	_, err = a.ValidateSecurityRule(checker.SecurityRule{Command: "@missing", ExpectedPattern: "vty"})
+	assert.ErrorContains(t, err, "unknown command macro @missing")
+
+	require.NoError(t, a.DeleteCommandMacro("vty_section"))
+	assert.ErrorIs(t, a.DeleteCommandMacro("vty_section"), checker.ErrMacroNotFound)
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityCommandMacro, 10)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
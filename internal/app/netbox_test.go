@@ -0,0 +1,48 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_ImportFromNetBox(t *testing.T) {
+	a := newActivityTestApp(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"count": 1, "next": null, "results": [{
+			"name": "edge-rtr-01",
+			"device_type": {"manufacturer": {"slug": "juniper-networks"}},
+			"role": {"slug": "router"},
+			"primary_ip": {"address": "10.30.0.1/24"},
+			"tags": []
+		}]}`)
+	}))
+	defer server.Close()
+
+	result, err := a.ImportFromNetBox(server.URL, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Imported)
+
+	devices, err := a.deviceManager.GetAllDevices()
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "10.30.0.1", devices[0].IPAddress)
+	assert.Equal(t, string(device.VendorJuniper), devices[0].Vendor)
+	assert.Equal(t, device.DefaultImportUsername, devices[0].Username)
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityDevice, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Contains(t, entries[0].Details, "Imported 1 devices from NetBox")
+
+	_, err = (&App{}).ImportFromNetBox(server.URL, "secret")
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"invictux-demo/internal/ratelimit"
+	"invictux-demo/internal/security"
+)
+
+// rateLimitsKey is the app_settings key holding the configured rate limits
+// as a JSON object of ratelimit.Limit by method
+const rateLimitsKey = "rate_limits"
+
+// allowCall takes a token for a call of a rate-limited binding, returning a
+// *ratelimit.ErrRateLimited when the binding is called too often. Apps
+// without a limiter are not limited.
+func (a *App) allowCall(method, key string) error {
+	if a.rateLimiter == nil {
+		return nil
+	}
+	return a.rateLimiter.Allow(method, key)
+}
+
+// loadRateLimits creates the rate limiter with the default limits and
+// applies the saved ones. Unusable saved limits are ignored.
+func (a *App) loadRateLimits() {
+	if a.rateLimiter == nil {
+		a.rateLimiter = ratelimit.NewAppRateLimiter(ratelimit.DefaultLimits())
+	}
+
+	value, ok, err := a.getSetting(rateLimitsKey)
+	if err != nil {
+		log.Printf("Failed to load rate limits: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	var limits map[string]ratelimit.Limit
+	if err := json.Unmarshal([]byte(value), &limits); err != nil {
+		log.Printf("Ignoring invalid saved rate limits: %v", err)
+		return
+	}
+	defaults := ratelimit.DefaultLimits()
+	for method, limit := range limits {
+		if _, known := defaults[method]; !known {
+			continue
+		}
+		if err := a.rateLimiter.SetLimit(method, limit); err != nil {
+			log.Printf("Ignoring invalid saved rate limit of %s: %v", method, err)
+		}
+	}
+}
+
+// SetRateLimit changes the limit of a rate-limited binding and saves it. A
+// zero interval removes the limit.
+func (a *App) SetRateLimit(method string, limit ratelimit.Limit) error {
+	if err := a.requireRole(security.RoleAdmin, "SetRateLimit"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.db == nil || a.rateLimiter == nil {
+		return fmt.Errorf("rate limiter not initialized")
+	}
+	if _, known := ratelimit.DefaultLimits()[method]; !known {
+		return fmt.Errorf("%s is not rate limited", method)
+	}
+
+	if err := a.rateLimiter.SetLimit(method, limit); err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(a.rateLimiter.Limits())
+	if err != nil {
+		return fmt.Errorf("failed to encode rate limits: %w", err)
+	}
+	return a.saveSetting(rateLimitsKey, string(encoded))
+}
+
+// GetRateLimitStatus reports the tokens left and next refill of every
+// rate-limited binding, keyed by method or by "method:key" for the
+// per-device buckets in use
+func (a *App) GetRateLimitStatus() map[string]ratelimit.RateLimitStatus {
+	if a.rateLimiter == nil {
+		return map[string]ratelimit.RateLimitStatus{}
+	}
+	return a.rateLimiter.Status()
+}
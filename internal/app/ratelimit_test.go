@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"invictux-demo/internal/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRateLimitTestApp returns a started app with the default rate limits
+func newRateLimitTestApp(t *testing.T) *App {
+	t.Helper()
+
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+	require.NotNil(t, a.rateLimiter)
+	return a
+}
+
+func TestApp_RateLimit_RapidCalls(t *testing.T) {
+	a := newRateLimitTestApp(t)
+
+	_, err := a.RunBulkSecurityChecks("", "")
+	require.NoError(t, err)
+	_, err = a.RunBulkSecurityChecks("", "")
+	var limited *ratelimit.ErrRateLimited
+	require.True(t, errors.As(err, &limited), "got %v", err)
+	assert.Equal(t, ratelimit.MethodRunBulkSecurityChecks, limited.Method)
+	assert.Greater(t, limited.RetryAfter.Seconds(), 50.0)
+
+	// Checks are limited per device; the first call fails on the missing device
+	_, err = a.RunSecurityCheck("missing", "", "")
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &limited))
+	_, err = a.RunSecurityCheck("missing", "", "")
+	require.True(t, errors.As(err, &limited), "got %v", err)
+	assert.Equal(t, "missing", limited.Key)
+	_, err = a.RunSecurityCheck("other", "", "")
+	assert.False(t, errors.As(err, &limited), "another device has its own bucket")
+	// Streamed and favorite runs share the device's bucket
+	_, err = a.RunFavoriteRulesOnDevice("missing")
+	assert.True(t, errors.As(err, &limited), "got %v", err)
+	err = a.StreamDeviceChecks("other")
+	assert.True(t, errors.As(err, &limited), "got %v", err)
+
+	for i := 0; i < 5; i++ {
+		_, err = a.TestDeviceConnectivity("missing")
+		require.False(t, errors.As(err, &limited), "call %d of the burst", i+1)
+	}
+	_, err = a.TestDeviceConnectivity("missing")
+	assert.True(t, errors.As(err, &limited), "got %v", err)
+
+	_, err = a.ImportFromNetBox("http://127.0.0.1:1", "token")
+	require.Error(t, err)
+	_, err = a.ImportFromNetBox("http://127.0.0.1:1", "token")
+	assert.True(t, errors.As(err, &limited), "got %v", err)
+
+	status := a.GetRateLimitStatus()
+	assert.Less(t, status[ratelimit.MethodRunBulkSecurityChecks].Tokens, 1.0)
+	assert.False(t, status[ratelimit.MethodRunBulkSecurityChecks].NextRefill.IsZero())
+	assert.Contains(t, status, ratelimit.MethodRunSecurityCheck+":missing")
+}
+
+func TestApp_SetRateLimit(t *testing.T) {
+	a := newRateLimitTestApp(t)
+
+	require.NoError(t, a.SetRateLimit(ratelimit.MethodRunBulkSecurityChecks, ratelimit.Limit{}))
+	for i := 0; i < 3; i++ {
+		_, err := a.RunBulkSecurityChecks("", "")
+		require.NoError(t, err, "a zero interval removes the limit")
+	}
+	require.NoError(t, a.SetRateLimit(ratelimit.MethodImportFromNetBox, ratelimit.Limit{IntervalMs: 1000, Burst: 3}))
+
+	assert.Error(t, a.SetRateLimit("GetDevices", ratelimit.Limit{IntervalMs: 1000, Burst: 1}))
+	assert.Error(t, a.SetRateLimit(ratelimit.MethodRunSecurityCheck, ratelimit.Limit{IntervalMs: 1000}))
+
+	// The limits are saved and applied on the next start
+	a.rateLimiter = nil
+	a.loadRateLimits()
+	limits := a.rateLimiter.Limits()
+	assert.Equal(t, ratelimit.Limit{}, limits[ratelimit.MethodRunBulkSecurityChecks])
+	assert.Equal(t, ratelimit.Limit{IntervalMs: 1000, Burst: 3}, limits[ratelimit.MethodImportFromNetBox])
+	assert.Equal(t, ratelimit.DefaultLimits()[ratelimit.MethodRunSecurityCheck], limits[ratelimit.MethodRunSecurityCheck])
+	assert.NotContains(t, a.GetRateLimitStatus(), ratelimit.MethodRunBulkSecurityChecks, "unlimited methods have no bucket")
+}
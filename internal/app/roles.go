@@ -0,0 +1,226 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"invictux-demo/internal/security"
+)
+
+// app_settings keys of the admin passphrase hash, which scoped sessions
+// are started with, and of the role of the last scoped session
+const (
+	adminPassphraseKey = "admin_passphrase_hash"
+	sessionRoleKey     = "session_role"
+)
+
+// ErrAdminPassphraseNotSet is returned by CreateScopedSession until an
+// admin passphrase has been set with SetAdminPassphrase
+var ErrAdminPassphraseNotSet = errors.New("admin passphrase not set")
+
+// ErrCodePermissionDenied is the code of the error returned by bindings the
+// active session's role may not call
+const ErrCodePermissionDenied = "PERMISSION_DENIED"
+
+// PermissionDeniedError is returned by a binding the active session's role
+// may not call
+type PermissionDeniedError struct {
+	Code     string        `json:"code"`
+	Role     security.Role `json:"role"`
+	Required security.Role `json:"required"`
+	Action   string        `json:"action"`
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("permission denied: %s requires the %s role, session is %s", e.Action, e.Required, e.Role)
+}
+
+// sessionState is the scoped session the app runs under, if any
+type sessionState struct {
+	mu sync.RWMutex
+	// active is a copy of the session, so validating a session cannot
+	// change the role it was started with
+	active *security.Session
+	// restored is the role of the scoped session the app ran under before
+	// it restarted, if any
+	restored security.Role
+}
+
+// currentRole returns the role of the active scoped session. Without one
+// the app keeps the role of the last scoped session, even across restarts,
+// and the single local user is an admin only when none was ever started,
+// as before roles existed. Once a scoped session has expired or ended the
+// app stays read-only until an admin starts a new one, so a shared display
+// never falls back to admin.
+func (a *App) currentRole() security.Role {
+	a.session.mu.RLock()
+	defer a.session.mu.RUnlock()
+
+	if a.session.active == nil {
+		if a.session.restored != "" {
+			return a.session.restored
+		}
+		return security.RoleAdmin
+	}
+	if !time.Now().Before(a.session.active.ExpiresAt) {
+		return security.RoleViewer
+	}
+	return a.session.active.Role
+}
+
+// requireRole returns a PermissionDeniedError when the active session's
+// role does not allow action, which needs the required role
+func (a *App) requireRole(required security.Role, action string) error {
+	role := a.currentRole()
+	if role.Allows(required) {
+		return nil
+	}
+	return &PermissionDeniedError{
+		Code:     ErrCodePermissionDenied,
+		Role:     role,
+		Required: required,
+		Action:   action,
+	}
+}
+
+// CreateScopedSession starts a session with role and makes it the session
+// the app runs under. The admin passphrase set with SetAdminPassphrase is
+// required whatever the role, so a viewer display cannot promote itself.
+// Until the session expires or a new one is started, the app only allows
+// what role does, and a restart keeps the role.
+func (a *App) CreateScopedSession(role, adminPassphrase string) (*security.Session, error) {
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.sessionManager == nil || a.db == nil {
+		return nil, fmt.Errorf("sessions are not initialized")
+	}
+
+	parsed, err := security.ParseRole(role)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok, err := a.getSetting(adminPassphraseKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrAdminPassphraseNotSet
+	}
+	if !security.VerifyPassphrase(hash, adminPassphrase) {
+		return nil, security.ErrInvalidCredentials
+	}
+
+	session, err := a.sessionManager.CreateSessionWithRole(localUserID, parsed)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.saveSetting(sessionRoleKey, string(parsed)); err != nil {
+		return nil, err
+	}
+	active := *session
+
+	a.session.mu.Lock()
+	a.session.active = &active
+	a.session.mu.Unlock()
+
+	// The session ID is a credential, so it stays out of the audit log
+	a.recordAudit(security.ActionCreate, security.EntitySession, "", fmt.Sprintf("Started %s session", parsed))
+
+	copied := *session
+	return &copied, nil
+}
+
+// endActiveSession expires the active scoped session when sessionID is it
+func (a *App) endActiveSession(sessionID string) {
+	a.session.mu.Lock()
+	defer a.session.mu.Unlock()
+
+	if a.session.active != nil && a.session.active.ID == sessionID {
+		a.session.active.ExpiresAt = time.Now()
+	}
+}
+
+// SetAdminPassphrase sets the passphrase scoped sessions are started with,
+// which must differ from the encryption passphrase. Changing it requires
+// the current one.
+func (a *App) SetAdminPassphrase(currentPassphrase, newPassphrase string) error {
+	if err := a.requireRole(security.RoleAdmin, "SetAdminPassphrase"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.db == nil || a.encryptionManager == nil {
+		return fmt.Errorf("sessions are not initialized")
+	}
+
+	if len(newPassphrase) < MinPassphraseLength {
+		return fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+	}
+	if a.encryptionManager.MatchesPassphrase(newPassphrase) {
+		return fmt.Errorf("admin passphrase must differ from the encryption passphrase")
+	}
+
+	current, ok, err := a.getSetting(adminPassphraseKey)
+	if err != nil {
+		return err
+	}
+	if ok && !security.VerifyPassphrase(current, currentPassphrase) {
+		return security.ErrInvalidCredentials
+	}
+
+	hash, err := security.HashPassphrase(newPassphrase)
+	if err != nil {
+		return err
+	}
+	if err := a.saveSetting(adminPassphraseKey, hash); err != nil {
+		return err
+	}
+
+	action := security.ActionCreate
+	if ok {
+		action = security.ActionUpdate
+	}
+	a.recordAudit(action, security.EntitySession, "", "Set admin passphrase")
+	return nil
+}
+
+// HasAdminPassphrase reports whether an admin passphrase has been set, so
+// scoped sessions can be started
+func (a *App) HasAdminPassphrase() (bool, error) {
+	if err := a.requireReady(); err != nil {
+		return false, err
+	}
+	if a.db == nil {
+		return false, nil
+	}
+	_, ok, err := a.getSetting(adminPassphraseKey)
+	return ok, err
+}
+
+// loadSessionRole restores the role of the scoped session the app ran
+// under before it restarted. An unreadable or invalid value restores the
+// least privileged role rather than admin.
+func (a *App) loadSessionRole() {
+	value, ok, err := a.getSetting(sessionRoleKey)
+	if err != nil {
+		log.Printf("Failed to load session role, staying read-only: %v", err)
+		value, ok = string(security.RoleViewer), true
+	}
+	if !ok {
+		return
+	}
+	role, err := security.ParseRole(value)
+	if err != nil {
+		log.Printf("Ignoring invalid saved session role %q, staying read-only", value)
+		role = security.RoleViewer
+	}
+
+	a.session.mu.Lock()
+	a.session.restored = role
+	a.session.mu.Unlock()
+}
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAdminPassphrase is the admin passphrase of newRolesTestApp
+const testAdminPassphrase = "test admin passphrase"
+
+// newRolesTestApp returns a ready app whose admin passphrase is
+// testAdminPassphrase
+func newRolesTestApp(t *testing.T) *App {
+	t.Helper()
+
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+	require.NoError(t, a.SetAdminPassphrase("", testAdminPassphrase))
+	return a
+}
+
+// assertDenied checks err is a PermissionDeniedError for action
+func assertDenied(t *testing.T, err error, role security.Role, action string) {
+	t.Helper()
+
+	var denied *PermissionDeniedError
+	require.True(t, errors.As(err, &denied), "expected permission denied, got %v", err)
+	assert.Equal(t, ErrCodePermissionDenied, denied.Code)
+	assert.Equal(t, role, denied.Role)
+	assert.Equal(t, action, denied.Action)
+}
+
+// assertNotDenied checks err, if any, is not a PermissionDeniedError
+func assertNotDenied(t *testing.T, err error) {
+	t.Helper()
+
+	var denied *PermissionDeniedError
+	assert.False(t, errors.As(err, &denied), "unexpected permission denied: %v", err)
+}
+
+func TestApp_CreateScopedSession(t *testing.T) {
+	a := newRolesTestApp(t)
+
+	_, err := a.CreateScopedSession("viewer", "wrong passphrase")
+	assert.ErrorIs(t, err, security.ErrInvalidCredentials)
+	_, err = a.CreateScopedSession("viewer", "test passphrase")
+	assert.ErrorIs(t, err, security.ErrInvalidCredentials, "the encryption passphrase is not the admin's")
+	_, err = a.CreateScopedSession("root", testAdminPassphrase)
+	assert.ErrorIs(t, err, security.ErrInvalidRole)
+	assert.Equal(t, security.RoleAdmin, a.currentRole(), "failed attempts change nothing")
+
+	session, err := a.CreateScopedSession(" Viewer ", testAdminPassphrase)
+	require.NoError(t, err)
+	assert.Equal(t, security.RoleViewer, session.Role)
+	assert.Equal(t, security.RoleViewer, a.currentRole())
+
+	validated, err := a.ValidateSession(session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, security.RoleViewer, validated.Role)
+
+	// The returned copies do not reach the active session
+	session.Role = security.RoleAdmin
+	validated.Role = security.RoleAdmin
+	assert.Equal(t, security.RoleViewer, a.currentRole())
+}
+
+func TestApp_ViewerRole(t *testing.T) {
+	a := newRolesTestApp(t)
+	dev := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(dev))
+
+	_, err := a.CreateScopedSession("viewer", testAdminPassphrase)
+	require.NoError(t, err)
+
+	assertDenied(t, a.AddDevice(device.Device{Name: "Edge"}), security.RoleViewer, "AddDevice")
+	_, err = a.RunSecurityCheck(dev.ID, "", "")
+	assertDenied(t, err, security.RoleViewer, "RunSecurityCheck")
+	assertDenied(t, a.SetLocale("es"), security.RoleViewer, "SetLocale")
+	assertDenied(t, a.ExportDeviceEvidenceBundle(dev.ID, "run", t.TempDir()+"/bundle.zip", true),
+		security.RoleViewer, "ExportDeviceEvidenceBundle")
+	_, err = a.DecryptPassword([]byte("x"))
+	assertDenied(t, err, security.RoleViewer, "DecryptPassword")
+
+	devices, err := a.GetDevices()
+	require.NoError(t, err)
+	assert.Len(t, devices, 1)
+	_, err = a.GetComplianceSummary(nil, false)
+	assert.NoError(t, err)
+	assertNotDenied(t, a.ExportDeviceEvidenceBundle(dev.ID, "missing", t.TempDir()+"/bundle.zip", false))
+}
+
+func TestApp_OperatorRole(t *testing.T) {
+	a := newRolesTestApp(t)
+
+	_, err := a.CreateScopedSession("operator", testAdminPassphrase)
+	require.NoError(t, err)
+
+	_, err = a.RunSecurityCheck("missing", "", "")
+	require.Error(t, err)
+	assertNotDenied(t, err)
+	_, err = a.UpdateRunMetadata("missing", "label", "")
+	assertNotDenied(t, err)
+
+	_, err = a.CreateSeverityOverride(checker.SeverityOverride{})
+	assertDenied(t, err, security.RoleOperator, "CreateSeverityOverride")
+	assertDenied(t, a.SetLocale("es"), security.RoleOperator, "SetLocale")
+	assertDenied(t, a.BackupDatabase(t.TempDir()+"/backup.db"), security.RoleOperator, "BackupDatabase")
+}
+
+func TestApp_AuditRecordsRole(t *testing.T) {
+	a := newRolesTestApp(t)
+
+	require.NoError(t, a.AddDevice(device.Device{Name: "Core Router", IPAddress: "10.0.0.1",
+		DeviceType: string(device.TypeRouter), Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}))
+	_, err := a.CreateScopedSession("operator", testAdminPassphrase)
+	require.NoError(t, err)
+
+	page, err := a.QueryAuditLog(security.AuditQuery{})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 3, "setting the admin passphrase is recorded too")
+
+	session := page.Entries[0]
+	assert.Equal(t, security.EntitySession, session.EntityType)
+	assert.Equal(t, "Started operator session", session.Details)
+	assert.Empty(t, session.EntityID, "session IDs stay out of the log")
+	assert.Equal(t, string(security.RoleOperator), session.Role)
+
+	added := page.Entries[1]
+	assert.Equal(t, security.EntityDevice, added.EntityType)
+	assert.Equal(t, string(security.RoleAdmin), added.Role)
+
+	page, err = a.QueryAuditLog(security.AuditQuery{Role: string(security.RoleOperator)})
+	require.NoError(t, err)
+	assert.Equal(t, 1, page.Total)
+}
+
+func TestApp_SessionsCannotEscalate(t *testing.T) {
+	a := newRolesTestApp(t)
+
+	admin, err := a.CreateScopedSession("admin", testAdminPassphrase)
+	require.NoError(t, err)
+	viewer, err := a.CreateScopedSession("viewer", testAdminPassphrase)
+	require.NoError(t, err)
+
+	// Validating the earlier admin session leaves the viewer in charge
+	validated, err := a.ValidateSession(admin.ID)
+	require.NoError(t, err)
+	assert.Equal(t, security.RoleAdmin, validated.Role)
+	assert.Equal(t, security.RoleViewer, a.currentRole())
+
+	// Sessions created without a role get the caller's
+	created, err := a.CreateSession("noc")
+	require.NoError(t, err)
+	assert.Equal(t, security.RoleViewer, created.Role)
+	assert.Equal(t, security.RoleViewer, a.currentRole())
+
+	// Ending the viewer session does not fall back to admin
+	a.DestroySession(viewer.ID)
+	assert.Equal(t, security.RoleViewer, a.currentRole())
+	assertDenied(t, a.SetLocale("es"), security.RoleViewer, "SetLocale")
+	_, err = a.ValidateSession(viewer.ID)
+	assert.Error(t, err)
+}
+
+func TestApp_SetAdminPassphrase(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+
+	_, err := a.CreateScopedSession("viewer", "test passphrase")
+	assert.ErrorIs(t, err, ErrAdminPassphraseNotSet)
+	set, err := a.HasAdminPassphrase()
+	require.NoError(t, err)
+	assert.False(t, set)
+
+	assert.Error(t, a.SetAdminPassphrase("", "short"))
+	assert.ErrorContains(t, a.SetAdminPassphrase("", "test passphrase"), "encryption passphrase")
+	require.NoError(t, a.SetAdminPassphrase("", testAdminPassphrase))
+	set, err = a.HasAdminPassphrase()
+	require.NoError(t, err)
+	assert.True(t, set)
+
+	stored, _, err := a.getSetting(adminPassphraseKey)
+	require.NoError(t, err)
+	assert.NotContains(t, stored, testAdminPassphrase)
+
+	// Changing it takes the current one
+	assert.ErrorIs(t, a.SetAdminPassphrase("wrong passphrase", "new admin passphrase"), security.ErrInvalidCredentials)
+	require.NoError(t, a.SetAdminPassphrase(testAdminPassphrase, "new admin passphrase"))
+	_, err = a.CreateScopedSession("operator", testAdminPassphrase)
+	assert.ErrorIs(t, err, security.ErrInvalidCredentials)
+	_, err = a.CreateScopedSession("operator", "new admin passphrase")
+	require.NoError(t, err)
+	assertDenied(t, a.SetAdminPassphrase("new admin passphrase", "another passphrase"),
+		security.RoleOperator, "SetAdminPassphrase")
+}
+
+func TestApp_SessionRoleSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := newStartupTestApp(t, dir)
+	require.True(t, a.initialize(context.Background()).Ready)
+	require.NoError(t, a.SetAdminPassphrase("", testAdminPassphrase))
+	_, err := a.CreateScopedSession("viewer", testAdminPassphrase)
+	require.NoError(t, err)
+	require.NoError(t, a.db.Close())
+	a.db = nil
+
+	restarted, _ := newStartupTestApp(t, dir)
+	require.True(t, restarted.initialize(context.Background()).Ready)
+	assert.Equal(t, security.RoleViewer, restarted.currentRole(), "a restart does not restore admin")
+	assertDenied(t, restarted.SetLocale("es"), security.RoleViewer, "SetLocale")
+
+	// Only an admin session gives admin back
+	_, err = restarted.CreateScopedSession("admin", testAdminPassphrase)
+	require.NoError(t, err)
+	assert.Equal(t, security.RoleAdmin, restarted.currentRole())
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/security"
+)
+
+// RulesHealthEvent is emitted with the checker.RuleHealthReport of the rule
+// validation run once startup completes
+const RulesHealthEvent = "rules:health"
+
+// excludeBrokenRulesKey is the app_settings key holding whether check runs
+// skip rules that need attention
+const excludeBrokenRulesKey = "exclude_broken_rules"
+
+// ValidateAllRules checks every enabled rule and flags the broken ones as
+// needing attention, clearing the flag on rules that were fixed
+func (a *App) ValidateAllRules() (*checker.RuleHealthReport, error) {
+	if err := a.requireRole(security.RoleAdmin, "ValidateAllRules"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.ruleManager == nil {
+		return nil, fmt.Errorf("rule manager not initialized")
+	}
+	return a.ruleManager.ValidateAllRules()
+}
+
+// AcknowledgeRuleCommand accepts a risky command of a rule as intended and
+// validates the rules again, so a rule flagged only for that command no
+// longer needs attention
+func (a *App) AcknowledgeRuleCommand(ruleID, command string) (*checker.RuleHealthReport, error) {
+	if err := a.requireRole(security.RoleAdmin, "AcknowledgeRuleCommand"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.ruleManager == nil {
+		return nil, fmt.Errorf("rule manager not initialized")
+	}
+
+	if err := a.ruleManager.AcknowledgeRuleCommand(ruleID, command); err != nil {
+		return nil, err
+	}
+	a.recordAudit(security.ActionUpdate, security.EntityRule, ruleID,
+		fmt.Sprintf("Acknowledged risky command %q", command))
+	return a.ruleManager.ValidateAllRules()
+}
+
+// SetExcludeBrokenRules sets whether check runs skip rules that need
+// attention and keeps it across restarts
+func (a *App) SetExcludeBrokenRules(exclude bool) error {
+	if err := a.requireRole(security.RoleAdmin, "SetExcludeBrokenRules"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.db == nil || a.checkEngine == nil {
+		return fmt.Errorf("check engine not initialized")
+	}
+
+	if err := a.saveSetting(excludeBrokenRulesKey, strconv.FormatBool(exclude)); err != nil {
+		return err
+	}
+	a.checkEngine.SetExcludeBrokenRules(exclude)
+	return nil
+}
+
+// GetExcludeBrokenRules reports whether check runs skip rules that need attention
+func (a *App) GetExcludeBrokenRules() bool {
+	if a.checkEngine == nil {
+		return false
+	}
+	return a.checkEngine.ExcludesBrokenRules()
+}
+
+// loadExcludeBrokenRules applies the saved exclusion setting to the engine
+func (a *App) loadExcludeBrokenRules() {
+	value, ok, err := a.getSetting(excludeBrokenRulesKey)
+	if err != nil {
+		log.Printf("Failed to load broken rule exclusion: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	exclude, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Ignoring invalid saved broken rule exclusion %q", value)
+		return
+	}
+	a.checkEngine.SetExcludeBrokenRules(exclude)
+}
+
+// reportRuleHealth validates the rules after startup and sends the report
+// to the frontend. A failed validation is only logged.
+func (a *App) reportRuleHealth() {
+	report, err := a.ValidateAllRules()
+	if err != nil {
+		log.Printf("Failed to validate rules: %v", err)
+		return
+	}
+	if !report.Healthy() {
+		log.Printf("%d of %d enabled rules need attention", len(report.Broken), report.CheckedRules)
+	}
+	if len(report.Warnings) > 0 {
+		log.Printf("%d of %d enabled rules are not documented", len(report.Warnings), report.CheckedRules)
+	}
+	if a.emitEvent != nil {
+		a.emitEvent(RulesHealthEvent, report)
+	}
+}
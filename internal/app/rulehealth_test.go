@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"invictux-demo/internal/checker"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_ReportRuleHealth(t *testing.T) {
+	a, events := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+
+	report, err := a.ValidateAllRules()
+	require.NoError(t, err)
+	assert.True(t, report.Healthy(), "predefined rules are valid: %+v", report.Broken)
+	assert.Positive(t, report.CheckedRules)
+
+	require.NoError(t, a.ruleManager.CreateRule(checker.SecurityRule{ID: "broken", Name: "Broken", Vendor: "cisco",
+		Command: "show ip ssh", ExpectedPattern: "version (2", Severity: string(checker.SeverityLow), Enabled: true}))
+
+	*events = nil
+	a.reportRuleHealth()
+	assert.Equal(t, []string{RulesHealthEvent}, *events)
+
+	rule, err := a.ruleManager.GetRule("broken")
+	require.NoError(t, err)
+	assert.True(t, rule.NeedsAttention)
+}
+
+func TestApp_ExcludeBrokenRulesSetting(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+	assert.False(t, a.GetExcludeBrokenRules())
+
+	require.NoError(t, a.SetExcludeBrokenRules(true))
+	assert.True(t, a.GetExcludeBrokenRules())
+
+	// A rebuilt engine picks up the saved setting
+	a.resetDatabaseComponents()
+	require.True(t, a.initialize(context.Background()).Ready)
+	assert.True(t, a.GetExcludeBrokenRules())
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_SecurityRuleBindings(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+
+	cisco, err := a.GetSecurityRules("cisco")
+	require.NoError(t, err)
+	require.NotEmpty(t, cisco)
+	for _, rule := range cisco {
+		assert.Contains(t, []string{"cisco", "generic"}, rule.Vendor)
+		assert.True(t, rule.Enabled)
+	}
+
+	templates := a.GetPredefinedRuleTemplates()
+	require.NotEmpty(t, templates)
+	vendors := make(map[string]bool)
+	for _, rule := range templates {
+		vendors[rule.Vendor] = true
+		assert.NotEmpty(t, rule.Category, "predefined rule %s has a category", rule.Name)
+	}
+	assert.Equal(t, map[string]bool{"cisco": true, "generic": true}, vendors)
+
+	require.NoError(t, a.ruleManager.CreateRule(checker.SecurityRule{ID: "juniper-ssh", Name: "Juniper SSH",
+		Vendor: "juniper", Command: "show configuration system services", ExpectedPattern: "ssh",
+		Severity: string(checker.SeverityMedium), Category: checker.CategoryManagementAccess}))
+
+	all, err := a.GetAllSecurityRules()
+	require.NoError(t, err)
+	assert.Len(t, all, len(templates)+1)
+	vendors = make(map[string]bool)
+	for _, rule := range all {
+		vendors[rule.Vendor] = true
+	}
+	assert.True(t, vendors["juniper"], "rules of every vendor are returned")
+
+	access, err := a.GetRulesByCategory(checker.CategoryManagementAccess)
+	require.NoError(t, err)
+	require.NotEmpty(t, access)
+	var juniper bool
+	for _, rule := range access {
+		assert.Equal(t, checker.CategoryManagementAccess, rule.Category)
+		juniper = juniper || rule.Vendor == "juniper"
+	}
+	assert.True(t, juniper)
+
+	_, err = a.GetRulesByCategory("unknown")
+	assert.Error(t, err)
+	assert.Len(t, a.GetRuleCategories(), len(checker.RuleCategories))
+}
+
+func TestApp_SecurityRuleBindingsNotInitialized(t *testing.T) {
+	a := &App{}
+
+	_, err := a.GetSecurityRules("cisco")
+	assert.Error(t, err)
+	_, err = a.GetAllSecurityRules()
+	assert.Error(t, err)
+	_, err = a.GetRulesByCategory(checker.CategorySystem)
+	assert.Error(t, err)
+	assert.NotEmpty(t, a.GetPredefinedRuleTemplates(), "templates need no database")
+}
+
+func TestApp_RuleEditPreviewAndApply(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+
+	filter := checker.RuleFilter{Vendor: "cisco"}
+	preview, err := a.PreviewRuleEdit(filter, "show running-config", "show running-config all", checker.BulkEditCommand, false)
+	require.NoError(t, err)
+	require.NotEmpty(t, preview.Edits)
+	assert.False(t, preview.Applied)
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityRule, 10)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "a preview is not audited")
+
+	applied, err := a.ApplyRuleEdit(preview.Edits)
+	require.NoError(t, err)
+	assert.True(t, applied.Applied)
+	assert.Equal(t, preview.Edits, applied.Edits)
+
+	entries, err = a.auditLogger.GetAuditLog(security.EntityRule, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	for _, id := range applied.RuleIDs() {
+		assert.Contains(t, entries[0].Details, id)
+	}
+
+	_, err = a.ApplyRuleEdit(preview.Edits)
+	assert.ErrorIs(t, err, checker.ErrStaleRuleEdit, "a preview applies once")
+
+	risky, err := a.PreviewRuleEdit(filter, "show", "reload\nshow", checker.BulkEditCommand, false)
+	require.NoError(t, err)
+	_, err = a.ApplyRuleEdit(risky.Edits)
+	assert.Error(t, err, "edits leaving rules invalid are refused")
+}
+
+func TestApp_GetDeviceMetadata(t *testing.T) {
+	metadata := (&App{}).GetDeviceMetadata()
+	assert.Len(t, metadata.Vendors, len(device.ValidVendors()))
+	assert.Len(t, metadata.DeviceTypes, len(device.ValidDeviceTypes()))
+	assert.Len(t, metadata.Severities, len(checker.Severities))
+	for _, vendor := range metadata.Vendors {
+		assert.NoError(t, device.ValidateVendor(string(vendor)))
+	}
+	for _, deviceType := range metadata.DeviceTypes {
+		assert.NoError(t, device.ValidateDeviceType(string(deviceType)))
+	}
+
+	// The UI gets its own copy of the severity levels
+	metadata.Severities[0] = "Urgent"
+	assert.Equal(t, checker.SeverityCritical, checker.Severities[0])
+}
+
+func TestApp_PreviewDeviceCommands(t *testing.T) {
+	_, err := (&App{}).PreviewDeviceCommands("d1")
+	assert.Error(t, err)
+
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+	dev := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(dev))
+
+	commands, err := a.PreviewDeviceCommands(dev.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, commands)
+	seen := make(map[string]bool)
+	for _, command := range commands {
+		assert.False(t, seen[command], "%q is listed once", command)
+		seen[command] = true
+	}
+	for _, rule := range a.checkEngine.GetSecurityRules(dev.Vendor) {
+		assert.True(t, seen[rule.CommandFor(dev.DeviceType)], "command of rule %s is listed", rule.Name)
+	}
+
+	// An incremental run starts by fetching the config
+	incremental, err := a.PreviewIncrementalDeviceCommands(dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, a.checkEngine.SnapshotCommand(dev.Vendor), incremental[0])
+
+	_, err = a.PreviewDeviceCommands("missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_SandboxDevicesExcluded(t *testing.T) {
+	db := newTestDB(t)
+	a := &App{
+		deviceManager: device.NewManager(db),
+		resultStore:   checker.NewResultStore(db),
+		ruleManager:   checker.NewRuleManager(db),
+		auditLogger:   security.NewAuditLogger(db),
+	}
+	a.resultStore.SetStatusTransitionHook(a.emitStatusChange)
+	var changes []CheckStatusChange
+	a.emitEvent = func(name string, data ...interface{}) {
+		changes = append(changes, data[0].(CheckStatusChange))
+	}
+
+	newDevice := func(name, ip string) *device.Device {
+		dev := &device.Device{Name: name, IPAddress: ip, DeviceType: string(device.TypeRouter),
+			Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+		require.NoError(t, a.deviceManager.AddDevice(dev))
+		return dev
+	}
+	prod := newDevice("Core Router", "10.0.0.1")
+	lab := newDevice("Lab Honeypot", "172.16.0.1")
+	changed, err := a.SetDevicesSandbox([]string{lab.ID}, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	base := time.Now().Add(-time.Hour)
+	results := func(runID string, labStatus checker.CheckStatus, at time.Time) []checker.CheckResult {
+		result := func(dev *device.Device, status checker.CheckStatus) checker.CheckResult {
+			return checker.CheckResult{ID: runID + dev.ID, DeviceID: dev.ID, RunID: runID, CheckName: "SSH Version 2",
+				CheckType: "configuration", Severity: string(checker.SeverityHigh), Status: string(status),
+				Message: "checked", CheckedAt: at, FindingCategory: checker.FindingRemoteAccess, FindingKey: "ssh-v1-allowed"}
+		}
+		return []checker.CheckResult{result(prod, checker.StatusPass), result(lab, labStatus)}
+	}
+	a.saveCheckResults(results("run1", checker.StatusPass, base))
+	a.saveCheckResults(results("run2", checker.StatusFail, base.Add(time.Minute)))
+
+	// The lab device failing everything changes neither the score nor
+	// raises a status change
+	summary, err := a.GetComplianceSummary(nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Total)
+	assert.Equal(t, 0, summary.Failed)
+	assert.Equal(t, 100.0, summary.WeightedScore)
+	assert.Empty(t, changes)
+
+	summary, err = a.GetComplianceSummary(nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Total)
+	assert.Equal(t, 1, summary.Failed)
+
+	findings, err := a.GetOpenFindings(false)
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+	findings, err = a.GetOpenFindings(true)
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	assert.Equal(t, lab.ID, findings[0].DeviceID)
+
+	// Reports leave the lab device out, even when it is listed
+	path := filepath.Join(t.TempDir(), "report.cef")
+	require.NoError(t, a.ExportCEFReport([]string{prod.ID, lab.ID}, path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "src=10.0.0.1")
+	assert.NotContains(t, string(data), "172.16.0.1")
+
+	require.NoError(t, a.GenerateReport(ReportRequest{Format: ReportFormatCEF, Path: path, IncludeSandbox: true}))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 2)
+	assert.Contains(t, string(data), "src=172.16.0.1")
+
+	scap := filepath.Join(t.TempDir(), "run2.xccdf.xml")
+	require.NoError(t, a.ExportSCAP("run2", scap, false))
+	data, err = os.ReadFile(scap)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "172.16.0.1")
+	require.NoError(t, a.ExportSCAP("run2", scap, true))
+	data, err = os.ReadFile(scap)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "172.16.0.1")
+
+	// The lab device's results stay queryable on their own
+	latest, err := a.resultStore.GetLatestRunResults(lab.ID)
+	require.NoError(t, err)
+	require.Len(t, latest, 1)
+	assert.Equal(t, string(checker.StatusFail), latest[0].Status)
+
+	// Once out of the sandbox, the device is reported again
+	changed, err = a.SetDevicesSandbox([]string{lab.ID}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	a.saveCheckResults(results("run3", checker.StatusPass, base.Add(2*time.Minute)))
+	assert.Equal(t, []CheckStatusChange{{DeviceID: lab.ID, CheckName: "SSH Version 2",
+		OldStatus: checker.StatusFail, NewStatus: checker.StatusPass}}, changes)
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityDevice, 10)
+	require.NoError(t, err)
+	var details []string
+	for _, entry := range entries {
+		details = append(details, entry.Details)
+	}
+	assert.ElementsMatch(t, []string{"Marked 1 devices as sandbox devices: " + lab.ID,
+		"Cleared the sandbox mark of 1 devices: " + lab.ID}, details)
+}
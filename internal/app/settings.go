@@ -0,0 +1,99 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+
+	"invictux-demo/internal/security"
+)
+
+// scanConcurrencyKey is the app_settings key holding the check engine's
+// worker count
+const scanConcurrencyKey = "scan_concurrency"
+
+// Scan concurrency limits and the engine's default
+const (
+	MinScanConcurrency     = 1
+	MaxScanConcurrency     = 100
+	DefaultScanConcurrency = 5
+)
+
+// SetScanConcurrency sets how many devices the check engine scans at once
+// and keeps it across restarts
+func (a *App) SetScanConcurrency(n int) error {
+	if err := a.requireRole(security.RoleAdmin, "SetScanConcurrency"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if n < MinScanConcurrency || n > MaxScanConcurrency {
+		return fmt.Errorf("scan concurrency must be between %d and %d", MinScanConcurrency, MaxScanConcurrency)
+	}
+	if a.db == nil || a.checkEngine == nil {
+		return fmt.Errorf("check engine not initialized")
+	}
+
+	if err := a.saveSetting(scanConcurrencyKey, strconv.Itoa(n)); err != nil {
+		return err
+	}
+	a.checkEngine.SetWorkerCount(n)
+	return nil
+}
+
+// GetScanConcurrency returns how many devices the check engine scans at once
+func (a *App) GetScanConcurrency() int {
+	if a.checkEngine == nil {
+		return DefaultScanConcurrency
+	}
+	workers, _, _ := a.checkEngine.GetWorkerCountRange()
+	return workers
+}
+
+// loadScanConcurrency applies the saved scan concurrency to the engine. A
+// missing or unusable value leaves the engine default.
+func (a *App) loadScanConcurrency() {
+	value, ok, err := a.getSetting(scanConcurrencyKey)
+	if err != nil {
+		log.Printf("Failed to load scan concurrency: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < MinScanConcurrency || n > MaxScanConcurrency {
+		log.Printf("Ignoring invalid saved scan concurrency %q", value)
+		return
+	}
+	a.checkEngine.SetWorkerCount(n)
+}
+
+// getSetting reads an app_settings value; ok is false when it is not set
+func (a *App) getSetting(key string) (value string, ok bool, err error) {
+	err = a.db.QueryRow("SELECT value FROM app_settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read setting %s: %w", key, err)
+	}
+	return value, true, nil
+}
+
+// saveSetting stores an app_settings value
+func (a *App) saveSetting(key, value string) error {
+	query := `
+		INSERT INTO app_settings (key, value, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`
+
+	if _, err := a.db.Exec(query, key, value); err != nil {
+		return fmt.Errorf("failed to save setting %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"testing"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSettingsTestApp returns an app with a check engine on db
+func newSettingsTestApp(db *database.DB) *App {
+	return &App{db: db, checkEngine: checker.NewEngine(checker.NewRuleManager(db.DB))}
+}
+
+func TestApp_ScanConcurrency(t *testing.T) {
+	db := &database.DB{DB: newTestDB(t)}
+
+	a := newSettingsTestApp(db)
+	assert.Equal(t, DefaultScanConcurrency, a.GetScanConcurrency())
+
+	require.NoError(t, a.SetScanConcurrency(12))
+	assert.Equal(t, 12, a.GetScanConcurrency())
+
+	assert.Error(t, a.SetScanConcurrency(0))
+	assert.Error(t, a.SetScanConcurrency(MaxScanConcurrency+1))
+	assert.Equal(t, 12, a.GetScanConcurrency(), "rejected values change nothing")
+
+	// A restarted app picks the saved value up
+	restarted := newSettingsTestApp(db)
+	restarted.loadScanConcurrency()
+	assert.Equal(t, 12, restarted.GetScanConcurrency())
+}
+
+func TestApp_LoadScanConcurrencyIgnoresInvalidValue(t *testing.T) {
+	db := &database.DB{DB: newTestDB(t)}
+
+	a := newSettingsTestApp(db)
+	require.NoError(t, a.saveSetting(scanConcurrencyKey, "500"))
+	a.loadScanConcurrency()
+	assert.Equal(t, DefaultScanConcurrency, a.GetScanConcurrency())
+}
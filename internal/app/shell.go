@@ -0,0 +1,191 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+)
+
+// shellSessionsKey is the app_settings key holding the vendors whose check
+// runs keep one interactive shell open, as a JSON array
+const shellSessionsKey = "shell_sessions"
+
+// enableSecretKeyPrefix starts the app_settings key holding a device's
+// encrypted enable secret
+const enableSecretKeyPrefix = "enable_secret_"
+
+// enableSecretKey returns the app_settings key of a device's enable secret
+func enableSecretKey(deviceID string) string {
+	return enableSecretKeyPrefix + deviceID
+}
+
+// SetShellSessions turns interactive shell sessions on or off for a
+// vendor's devices and keeps the choice across restarts. Only vendors with
+// default shell settings can use them.
+func (a *App) SetShellSessions(vendor string, enabled bool) error {
+	if err := a.requireRole(security.RoleAdmin, "SetShellSessions"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.db == nil || a.checkEngine == nil {
+		return fmt.Errorf("check engine not initialized")
+	}
+
+	vendor = strings.ToLower(strings.TrimSpace(vendor))
+	if _, ok := ssh.DefaultShellConfigs()[vendor]; !ok {
+		return fmt.Errorf("vendor %s does not support shell sessions", vendor)
+	}
+
+	vendors := make(map[string]bool)
+	for _, v := range a.GetShellSessionVendors() {
+		vendors[v] = true
+	}
+	if enabled {
+		vendors[vendor] = true
+	} else {
+		delete(vendors, vendor)
+	}
+	list := make([]string, 0, len(vendors))
+	for v := range vendors {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := a.saveSetting(shellSessionsKey, string(encoded)); err != nil {
+		return err
+	}
+
+	if enabled {
+		if err := a.checkEngine.EnableShellSessions(vendor, ssh.ShellConfig{}); err != nil {
+			return err
+		}
+	} else {
+		a.checkEngine.DisableShellSessions(vendor)
+	}
+	return nil
+}
+
+// GetShellSessionVendors returns the vendors whose check runs keep an
+// interactive shell open, in name order
+func (a *App) GetShellSessionVendors() []string {
+	vendors := []string{}
+	if a.checkEngine == nil {
+		return vendors
+	}
+	for vendor := range ssh.DefaultShellConfigs() {
+		if a.checkEngine.ShellSessionsEnabled(vendor) {
+			vendors = append(vendors, vendor)
+		}
+	}
+	sort.Strings(vendors)
+	return vendors
+}
+
+// loadShellSessions applies the saved shell session vendors to the engine.
+// A missing or unusable value leaves shell sessions off.
+func (a *App) loadShellSessions() {
+	value, ok, err := a.getSetting(shellSessionsKey)
+	if err != nil {
+		log.Printf("Failed to load shell session vendors: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	var vendors []string
+	if err := json.Unmarshal([]byte(value), &vendors); err != nil {
+		log.Printf("Ignoring invalid saved shell session vendors %q", value)
+		return
+	}
+	for _, vendor := range vendors {
+		if err := a.checkEngine.EnableShellSessions(vendor, ssh.ShellConfig{}); err != nil {
+			log.Printf("Ignoring saved shell sessions of vendor %s: %v", vendor, err)
+		}
+	}
+}
+
+// SetDeviceEnableSecret stores the secret that takes a device's shell into
+// privileged mode, encrypted. An empty secret clears it, after which shells
+// asking for one are not used on the device.
+func (a *App) SetDeviceEnableSecret(deviceID, secret string) error {
+	if err := a.requireRole(security.RoleAdmin, "SetDeviceEnableSecret"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.deviceManager == nil || a.db == nil || a.encryptionManager == nil {
+		return fmt.Errorf("device manager not initialized")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if secret == "" {
+		if _, err := a.db.ExecContext(ctx, "DELETE FROM app_settings WHERE key = ?", enableSecretKey(dev.ID)); err != nil {
+			return fmt.Errorf("failed to clear enable secret: %w", err)
+		}
+		a.recordAudit(security.ActionDelete, security.EntityDevice, dev.ID,
+			fmt.Sprintf("Cleared enable secret for device %s", dev.Name))
+		return nil
+	}
+
+	ciphertext, err := a.encryptionManager.Encrypt(secret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt enable secret: %w", err)
+	}
+	if err := a.saveSetting(enableSecretKey(dev.ID), base64.StdEncoding.EncodeToString(ciphertext)); err != nil {
+		return err
+	}
+	a.recordAudit(security.ActionUpdate, security.EntityDevice, dev.ID,
+		fmt.Sprintf("Set enable secret for device %s", dev.Name))
+	return nil
+}
+
+// HasDeviceEnableSecret reports whether an enable secret is stored for a
+// device, without revealing it
+func (a *App) HasDeviceEnableSecret(deviceID string) (bool, error) {
+	if err := a.requireReady(); err != nil {
+		return false, err
+	}
+	if a.db == nil {
+		return false, nil
+	}
+	_, ok, err := a.getSetting(enableSecretKey(deviceID))
+	return ok, err
+}
+
+// deviceEnableSecret looks up enable secrets for the check engine
+func (a *App) deviceEnableSecret(dev *device.Device) (string, error) {
+	if a.db == nil || a.encryptionManager == nil {
+		return "", nil
+	}
+	value, ok, err := a.getSetting(enableSecretKey(dev.ID))
+	if err != nil || !ok {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("stored enable secret is not valid base64: %w", err)
+	}
+	return a.encryptionManager.Decrypt(ciphertext)
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_ShellSessions(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := newStartupTestApp(t, dir)
+	require.True(t, a.initialize(context.Background()).Ready)
+
+	assert.Empty(t, a.GetShellSessionVendors(), "shell sessions start off")
+	require.NoError(t, a.SetShellSessions("Cisco", true))
+	require.NoError(t, a.SetShellSessions("juniper", true))
+	require.NoError(t, a.SetShellSessions("juniper", false))
+	assert.Equal(t, []string{"cisco"}, a.GetShellSessionVendors())
+	assert.Error(t, a.SetShellSessions("acme", true), "vendors without shell settings are refused")
+
+	// The choice is applied again on the next start
+	restarted, _ := newStartupTestApp(t, dir)
+	require.True(t, restarted.initialize(context.Background()).Ready)
+	assert.Equal(t, []string{"cisco"}, restarted.GetShellSessionVendors())
+}
+
+func TestApp_DeviceEnableSecret(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	secret, err := a.deviceEnableSecret(router)
+	require.NoError(t, err)
+	assert.Empty(t, secret)
+	has, err := a.HasDeviceEnableSecret(router.ID)
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	require.NoError(t, a.SetDeviceEnableSecret(router.ID, "en4ble"))
+	stored, _, err := a.getSetting(enableSecretKey(router.ID))
+	require.NoError(t, err)
+	assert.NotContains(t, stored, "en4ble", "the secret is stored encrypted")
+	secret, err = a.deviceEnableSecret(router)
+	require.NoError(t, err)
+	assert.Equal(t, "en4ble", secret)
+	has, err = a.HasDeviceEnableSecret(router.ID)
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	require.NoError(t, a.SetDeviceEnableSecret(router.ID, ""))
+	secret, err = a.deviceEnableSecret(router)
+	require.NoError(t, err)
+	assert.Empty(t, secret)
+
+	// Deleting the device deletes its secret
+	require.NoError(t, a.SetDeviceEnableSecret(router.ID, "en4ble"))
+	require.NoError(t, a.DeleteDevice(router.ID))
+	_, ok, err := a.getSetting(enableSecretKey(router.ID))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Error(t, a.SetDeviceEnableSecret("missing", "en4ble"))
+}
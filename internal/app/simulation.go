@@ -0,0 +1,175 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+)
+
+// fixtureDirName is the directory under the data directory that holds
+// recorded device sessions
+const fixtureDirName = "fixtures"
+
+// unknownCommandOutput answers commands that were not recorded for a device
+// while simulating
+const unknownCommandOutput = "% Invalid input detected"
+
+// recordSessionTimeout bounds recording every check command of one device
+const recordSessionTimeout = 2 * time.Minute
+
+// fixtureDir returns the directory recorded device sessions are stored in
+func (a *App) fixtureDir() string {
+	return filepath.Join(a.dataDir, fixtureDirName)
+}
+
+// checkOptions returns the options check runs use under the current settings
+func (a *App) checkOptions() checker.CheckOptions {
+	return checker.CheckOptions{Simulate: a.simulationMode}
+}
+
+// SetSimulationMode switches check runs between real devices and the
+// sessions recorded with RecordDeviceSession
+func (a *App) SetSimulationMode(enabled bool) error {
+	if err := a.requireRole(security.RoleAdmin, "SetSimulationMode"); err != nil {
+		return err
+	}
+	if a.checkEngine == nil {
+		return fmt.Errorf("check engine not initialized")
+	}
+
+	if enabled {
+		if err := a.loadSimulator(); err != nil {
+			return err
+		}
+	}
+
+	a.simulationMode = enabled
+	return nil
+}
+
+// IsSimulationMode reports whether check runs use recorded sessions
+func (a *App) IsSimulationMode() bool {
+	return a.simulationMode
+}
+
+// loadSimulator reloads the engine's simulated client from the fixture directory
+func (a *App) loadSimulator() error {
+	simulator, err := ssh.NewSimulatedClientFromDir(a.fixtureDir(), ssh.SimulationConfig{
+		UnknownCommandOutput: unknownCommandOutput,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load recorded sessions: %w", err)
+	}
+
+	a.checkEngine.SetSimulator(simulator)
+	return nil
+}
+
+// RecordDeviceSession runs every command a check run may send to a device,
+// shell setup and config commands included, against the real device and
+// stores the redacted output as a fixture for simulation mode. It returns
+// the path of the fixture file.
+func (a *App) RecordDeviceSession(deviceID string) (string, error) {
+	if err := a.requireRole(security.RoleOperator, "RecordDeviceSession"); err != nil {
+		return "", err
+	}
+	if a.deviceManager == nil || a.checkEngine == nil || a.sshClient == nil {
+		return "", fmt.Errorf("application not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(a.appContext(), recordSessionTimeout)
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return "", err
+	}
+
+	// Incremental runs replay the config command too
+	commands, err := a.checkEngine.CommandsForDevice(dev, checker.CheckOptions{Incremental: true})
+	if err != nil {
+		return "", err
+	}
+	if len(commands) == 0 {
+		return "", fmt.Errorf("no security rules found for vendor: %s", dev.Vendor)
+	}
+
+	connInfo, err := a.deviceConnectionInfo(dev)
+	if err != nil {
+		return "", err
+	}
+
+	recorder := ssh.NewRecordingClient(a.sshClient)
+
+	conn, err := recorder.Connect(ctx, connInfo)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %s: %w", dev.Name, err)
+	}
+
+	_, err = recorder.ExecuteCommands(ctx, conn, commands)
+	recorder.Disconnect(conn)
+	if err != nil {
+		return "", err
+	}
+
+	paths, err := recorder.SaveFixtures(a.fixtureDir())
+	if err != nil {
+		return "", err
+	}
+	if len(paths) != 1 {
+		return "", fmt.Errorf("expected one recorded session, got %d", len(paths))
+	}
+
+	// Serve the new recording straight away when already simulating
+	if a.simulationMode {
+		if err := a.loadSimulator(); err != nil {
+			return "", err
+		}
+	}
+
+	return paths[0], nil
+}
+
+// SaveDeviceOutputSnapshot writes the command output the latest live runs
+// captured from the given devices to path as a dry-run cache, a snapshot
+// that simulated runs can replay offline. Every device must have been
+// checked live since the app started.
+func (a *App) SaveDeviceOutputSnapshot(deviceIDs []string, path string) error {
+	if err := a.requireRole(security.RoleOperator, "SaveDeviceOutputSnapshot"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if a.deviceManager == nil || a.checkEngine == nil {
+		return fmt.Errorf("check engine not initialized")
+	}
+	if len(deviceIDs) == 0 {
+		return fmt.Errorf("no devices selected")
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	hosts := make([]string, 0, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, dev.IPAddress)
+	}
+
+	if err := a.checkEngine.SaveCurrentOutputsToFile(path, hosts...); err != nil {
+		return err
+	}
+
+	a.recordAudit(security.ActionExport, security.EntityDevice, "",
+		fmt.Sprintf("Saved the command output of %d devices as a dry-run snapshot", len(hosts)))
+	return nil
+}
@@ -0,0 +1,288 @@
+package app
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_SimulationMode(t *testing.T) {
+	db := newTestDB(t)
+	a := &App{
+		deviceManager: device.NewManager(db),
+		checkEngine:   checker.NewEngine(checker.NewRuleManager(db)),
+		resultStore:   checker.NewResultStore(db),
+		dataDir:       t.TempDir(),
+	}
+	require.NoError(t, a.checkEngine.LoadCustomRules([]checker.SecurityRule{
+		{ID: "r1", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(checker.SeverityHigh), Enabled: true},
+	}))
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	// No recordings yet
+	assert.Error(t, a.SetSimulationMode(true))
+	assert.False(t, a.IsSimulationMode())
+
+	_, err := ssh.SaveFixture(filepath.Join(a.dataDir, fixtureDirName), &ssh.SessionFixture{
+		Version:  ssh.FixtureFormatVersion,
+		Host:     router.IPAddress,
+		Port:     router.SSHPort,
+		Commands: []ssh.RecordedCommand{{Command: "show ip ssh", Output: "SSH Enabled - version 2.0"}},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, a.SetSimulationMode(true))
+	assert.True(t, a.IsSimulationMode())
+
+	results, err := a.RunSecurityCheck(router.ID, "", "")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, string(checker.StatusPass), results[0].Status)
+	assert.Equal(t, "SSH Enabled - version 2.0", results[0].Evidence)
+
+	stored, err := a.resultStore.GetDeviceResults(router.ID, 10)
+	require.NoError(t, err)
+	assert.Len(t, stored, 1)
+
+	require.NoError(t, a.SetSimulationMode(false))
+	assert.False(t, a.IsSimulationMode())
+}
+
+func TestApp_RunMetadata(t *testing.T) {
+	db := newTestDB(t)
+	a := &App{
+		deviceManager: device.NewManager(db),
+		checkEngine:   checker.NewEngine(checker.NewRuleManager(db)),
+		resultStore:   checker.NewResultStore(db),
+		auditLogger:   security.NewAuditLogger(db),
+		dataDir:       t.TempDir(),
+	}
+	require.NoError(t, a.checkEngine.LoadCustomRules([]checker.SecurityRule{
+		{ID: "r1", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(checker.SeverityHigh), Enabled: true},
+	}))
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+	_, err := ssh.SaveFixture(filepath.Join(a.dataDir, fixtureDirName), &ssh.SessionFixture{
+		Version:  ssh.FixtureFormatVersion,
+		Host:     router.IPAddress,
+		Port:     router.SSHPort,
+		Commands: []ssh.RecordedCommand{{Command: "show ip ssh", Output: "SSH Enabled - version 2.0"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, a.SetSimulationMode(true))
+
+	// Metadata given at trigger time is stored with the run
+	results, err := a.RunSecurityCheck(router.ID, "pre-change", "")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	preRun := results[0].RunID
+
+	bulk, err := a.RunBulkSecurityChecks("post-change CHG-5521", "Core upgrade done")
+	require.NoError(t, err)
+	require.Len(t, bulk[router.ID], 1)
+	postRun := bulk[router.ID][0].RunID
+
+	// Only bulk runs snapshot the compliance score
+	trend, err := a.GetDeviceComplianceTrend(router.ID, 7)
+	require.NoError(t, err)
+	require.Len(t, trend, 1)
+	assert.Equal(t, 100.0, trend[0].Score)
+	assert.Equal(t, 1, trend[0].PassCount)
+
+	_, err = a.RunSecurityCheck(router.ID, strings.Repeat("x", checker.MaxRunLabelLength+1), "")
+	assert.Error(t, err)
+
+	found, err := a.FindRuns(checker.RunQuery{Text: "change"})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, postRun, found[0].RunID)
+	assert.Equal(t, "post-change CHG-5521", found[0].Label)
+	assert.Equal(t, "Core upgrade done", found[0].Note)
+
+	// Post-hoc edits are audited
+	metadata, err := a.UpdateRunMetadata(preRun, "pre-change CHG-5521", "Baseline")
+	require.NoError(t, err)
+	assert.Equal(t, "pre-change CHG-5521", metadata.Label)
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityCheckRun, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, security.ActionUpdate, entries[0].ActionType)
+	assert.Equal(t, preRun, entries[0].EntityID)
+
+	found, err = a.FindRuns(checker.RunQuery{Text: "CHG-5521"})
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	_, err = a.UpdateRunMetadata("missing", "label", "")
+	assert.ErrorIs(t, err, checker.ErrRunNotFound)
+
+	// The activity feed shows the label
+	items, err := a.GetRecentActivity(10)
+	require.NoError(t, err)
+	var titles []string
+	for _, item := range items {
+		titles = append(titles, item.Title)
+	}
+	assert.Contains(t, titles, "Security checks run on Core Router (post-change CHG-5521)")
+}
+
+func TestApp_StreamDeviceChecks(t *testing.T) {
+	db := newTestDB(t)
+	a := &App{
+		deviceManager: device.NewManager(db),
+		checkEngine:   checker.NewEngine(checker.NewRuleManager(db)),
+		resultStore:   checker.NewResultStore(db),
+		dataDir:       t.TempDir(),
+	}
+	require.NoError(t, a.checkEngine.LoadCustomRules([]checker.SecurityRule{
+		{ID: "r1", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(checker.SeverityHigh), Enabled: true},
+		{ID: "r2", Name: "AAA", Vendor: "generic", Command: "show run | i aaa", ExpectedPattern: "aaa new-model",
+			Severity: string(checker.SeverityMedium), Enabled: true},
+	}))
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	_, err := ssh.SaveFixture(filepath.Join(a.dataDir, fixtureDirName), &ssh.SessionFixture{
+		Version: ssh.FixtureFormatVersion,
+		Host:    router.IPAddress,
+		Port:    router.SSHPort,
+		Commands: []ssh.RecordedCommand{
+			{Command: "show ip ssh", Output: "SSH Enabled - version 2.0"},
+			{Command: "show run | i aaa", Output: ""},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, a.SetSimulationMode(true))
+
+	assert.Error(t, a.StreamDeviceChecks(router.ID), "streaming needs the frontend")
+
+	var streamed []checker.CheckResult
+	a.emitEvent = func(name string, data ...interface{}) {
+		require.Equal(t, CheckResultEvent, name)
+		streamed = append(streamed, data[0].(checker.CheckResult))
+	}
+	require.NoError(t, a.StreamDeviceChecks(router.ID))
+
+	require.Len(t, streamed, 2)
+	statuses := map[string]string{}
+	for _, result := range streamed {
+		statuses[result.CheckName] = result.Status
+	}
+	assert.Equal(t, map[string]string{"SSH v2": string(checker.StatusPass), "AAA": string(checker.StatusFail)}, statuses)
+
+	stored, err := a.resultStore.GetDeviceResults(router.ID, 10)
+	require.NoError(t, err)
+	assert.Len(t, stored, 2, "streamed results are saved")
+}
+
+func TestApp_GetOpenFindings(t *testing.T) {
+	db := newTestDB(t)
+	a := &App{
+		deviceManager: device.NewManager(db),
+		checkEngine:   checker.NewEngine(checker.NewRuleManager(db)),
+		resultStore:   checker.NewResultStore(db),
+		dataDir:       t.TempDir(),
+	}
+	require.NoError(t, a.checkEngine.LoadCustomRules([]checker.SecurityRule{
+		{ID: "r1", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(checker.SeverityHigh), Enabled: true, FindingCategory: checker.FindingRemoteAccess},
+		{ID: "r2", Name: "AAA", Vendor: "generic", Command: "show run | i aaa", ExpectedPattern: "aaa new-model",
+			Severity: string(checker.SeverityMedium), Enabled: true,
+			FindingCategory: checker.FindingAuthentication, FindingKey: "aaa-disabled"},
+	}))
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+	_, err := ssh.SaveFixture(filepath.Join(a.dataDir, fixtureDirName), &ssh.SessionFixture{
+		Version: ssh.FixtureFormatVersion,
+		Host:    router.IPAddress,
+		Port:    router.SSHPort,
+		Commands: []ssh.RecordedCommand{
+			{Command: "show ip ssh", Output: "SSH Enabled - version 2.0"},
+			{Command: "show run | i aaa", Output: ""},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, a.SetSimulationMode(true))
+
+	findings, err := a.GetOpenFindings(false)
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+
+	results, err := a.RunSecurityCheck(router.ID, "", "")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	findings, err = a.GetOpenFindings(false)
+	require.NoError(t, err)
+	require.Len(t, findings, 1, "only the failing check is an open finding")
+	assert.Equal(t, checker.FindingFingerprint(router.IPAddress, checker.FindingAuthentication, "aaa-disabled"), findings[0].Fingerprint)
+	assert.Equal(t, router.ID, findings[0].DeviceID)
+	assert.Equal(t, checker.FindingAuthentication, findings[0].FindingCategory)
+	assert.False(t, findings[0].FirstSeen.IsZero())
+	assert.Equal(t, findings[0].FirstSeen, findings[0].LastSeen)
+}
+
+func TestApp_SaveDeviceOutputSnapshot(t *testing.T) {
+	db := newTestDB(t)
+	// A simulated client stands in for the network
+	network := ssh.NewSimulatedClient([]*ssh.SessionFixture{{Host: "10.0.0.1", Port: 22,
+		Commands: []ssh.RecordedCommand{{Command: "show ip ssh", Output: "SSH Enabled - version 2.0"}}}},
+		ssh.SimulationConfig{FailUnknownCommands: true})
+	a := &App{
+		deviceManager: device.NewManager(db),
+		checkEngine:   checker.NewEngineWithSSHClient(checker.NewRuleManager(db), network),
+		resultStore:   checker.NewResultStore(db),
+		auditLogger:   security.NewAuditLogger(db),
+		dataDir:       t.TempDir(),
+	}
+	require.NoError(t, a.checkEngine.LoadCustomRules([]checker.SecurityRule{
+		{ID: "r1", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(checker.SeverityHigh), Enabled: true},
+	}))
+
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.Error(t, a.SaveDeviceOutputSnapshot([]string{router.ID}, path), "the device has not been checked")
+	assert.Error(t, a.SaveDeviceOutputSnapshot(nil, path))
+
+	live, err := a.RunSecurityCheck(router.ID, "", "")
+	require.NoError(t, err)
+	require.NoError(t, a.SaveDeviceOutputSnapshot([]string{router.ID}, path))
+
+	entries, err := a.auditLogger.GetAuditLog(security.EntityDevice, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, security.ActionExport, entries[0].ActionType)
+
+	require.NoError(t, a.checkEngine.LoadDryRunCacheFromFile(path))
+	a.simulationMode = true
+	replayed, err := a.RunSecurityCheck(router.ID, "", "")
+	require.NoError(t, err)
+	require.Len(t, replayed, 1)
+	assert.Equal(t, live[0].Status, replayed[0].Status)
+	assert.Equal(t, live[0].Evidence, replayed[0].Evidence)
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+)
+
+// SNMPPollDevice reads a device's uptime over SNMP with its community,
+// which works even when SSH is disabled. A device that answers is recorded
+// as online.
+func (a *App) SNMPPollDevice(deviceID string) (*device.SNMPPollResult, error) {
+	if err := a.requireRole(security.RoleOperator, "SNMPPollDevice"); err != nil {
+		return nil, err
+	}
+	if err := a.requireReady(); err != nil {
+		return nil, err
+	}
+	if a.deviceManager == nil || a.scanner == nil {
+		return nil, fmt.Errorf("device scanner not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(a.appContext(), a.scanner.GetTimeout())
+	defer cancel()
+
+	dev, err := a.deviceManager.GetDeviceContext(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := a.scanner.SNMPPoll(ctx, dev)
+	if err != nil {
+		return nil, err
+	}
+	if result.Reachable {
+		if err := a.deviceManager.UpdateDeviceStatusContext(ctx, dev.ID, dev.Status, result.PolledAt); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApp_SNMPPollDevice(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+	a.scanner.SetTimeout(100 * time.Millisecond)
+	a.scanner.SetMaxRetries(0)
+
+	router := &device.Device{Name: "Core Router", IPAddress: "192.0.2.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	result, err := a.SNMPPollDevice(router.ID)
+	require.NoError(t, err)
+	assert.False(t, result.Reachable)
+	assert.Equal(t, device.ConnectivityErrorNoCommunity, result.ErrorCode)
+
+	router.SNMPCommunity = "monitor"
+	require.NoError(t, a.deviceManager.UpdateDevice(router))
+	result, err = a.SNMPPollDevice(router.ID)
+	require.NoError(t, err)
+	assert.False(t, result.Reachable, "nothing answers SNMP here")
+	assert.Error(t, result.Error)
+
+	stored, err := a.deviceManager.GetDevice(router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, string(device.StatusOffline), stored.Status, "unanswered polls leave the status alone")
+
+	_, err = a.SNMPPollDevice("missing")
+	assert.Error(t, err)
+	_, err = (&App{}).SNMPPollDevice(router.ID)
+	assert.Error(t, err)
+}
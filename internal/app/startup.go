@@ -0,0 +1,376 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/rotation"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+)
+
+// evidenceDirName is the directory under the data directory that holds the
+// full command output of results whose evidence is a snippet
+const evidenceDirName = "evidence"
+
+// StartupErrorEvent is emitted to the frontend with the StartupStatus when
+// startup or a repair leaves the app degraded
+const StartupErrorEvent = "startup:error"
+
+// ErrCodeAppNotReady is the code of the error returned by blocked bindings
+const ErrCodeAppNotReady = "app_not_ready"
+
+// Database states reported by StartupStatus
+const (
+	DatabaseOK              = "ok"
+	DatabaseUnavailable     = "unavailable"
+	DatabaseMigrationFailed = "migration_failed"
+	DatabaseSchemaDrift     = "schema_drift"
+	DatabaseCorrupt         = "corrupt"
+	DatabaseError           = "error"
+)
+
+// StartupStatus describes whether the app started fully. When Ready is
+// false the app runs degraded: check execution is blocked until
+// RepairDatabase succeeds. Integrity is the last integrity check of the
+// database and Recovery describes a recovery startup ran on a corrupt one.
+type StartupStatus struct {
+	Ready                 bool     `json:"ready"`
+	DatabaseStatus        string   `json:"databaseStatus"`
+	SchemaVersion         int      `json:"schemaVersion"`
+	ExpectedSchemaVersion int      `json:"expectedSchemaVersion"`
+	MigrationError        string   `json:"migrationError,omitempty"`
+	SchemaDrift           []string `json:"schemaDrift,omitempty"`
+	Errors                []string `json:"errors,omitempty"`
+	// Passphrase is set while startup waits for the encryption passphrase
+	Passphrase *PassphraseRequest `json:"passphrase,omitempty"`
+	RuleCount  int                `json:"ruleCount"`
+	// RuleReconciliation is what loading the predefined rules changed
+	RuleReconciliation *checker.PredefinedRulesReport `json:"ruleReconciliation,omitempty"`
+	Integrity          *database.IntegrityReport      `json:"integrity,omitempty"`
+	Recovery           *database.RecoveryReport       `json:"recovery,omitempty"`
+	DeviceCount        int                            `json:"deviceCount"`
+	CheckedAt          time.Time                      `json:"checkedAt"`
+}
+
+// NotReadyError is returned by bindings that cannot run while the app is degraded
+type NotReadyError struct {
+	Code   string         `json:"code"`
+	Reason string         `json:"reason"`
+	Status *StartupStatus `json:"status"`
+}
+
+func (e *NotReadyError) Error() string {
+	return fmt.Sprintf("app not ready: %s", e.Reason)
+}
+
+// initialize opens the database in the data directory, verifies its schema
+// and sets up every component. Failures leave the app degraded and are
+// reported through GetStartupStatus and StartupErrorEvent instead of being
+// only logged. It is safe to call again to retry.
+func (a *App) initialize(ctx context.Context) *StartupStatus {
+	status := &StartupStatus{
+		DatabaseStatus:        DatabaseOK,
+		ExpectedSchemaVersion: database.LatestSchemaVersion(),
+	}
+	defer a.setStartupStatus(status)
+
+	if a.db == nil {
+		if !a.verifyDatabaseFile(status) {
+			return status
+		}
+		db, err := database.NewSQLiteDB(a.dataDir)
+		if err != nil {
+			status.DatabaseStatus = DatabaseUnavailable
+			status.Errors = append(status.Errors, fmt.Sprintf("failed to open database: %v", err))
+			return status
+		}
+		a.db = db
+	} else {
+		// The open database is not checked again; keep the last check
+		a.startupMutex.RLock()
+		if a.startupStatus != nil {
+			status.Integrity = a.startupStatus.Integrity
+		}
+		a.startupMutex.RUnlock()
+	}
+
+	if err := database.RunMigrations(a.db.DB); err != nil {
+		status.DatabaseStatus = DatabaseMigrationFailed
+		status.MigrationError = err.Error()
+	}
+
+	version, err := database.SchemaVersion(a.db.DB)
+	if err != nil {
+		status.Errors = append(status.Errors, err.Error())
+	}
+	status.SchemaVersion = version
+
+	drift, err := database.VerifySchema(a.db.DB)
+	if err != nil {
+		status.DatabaseStatus = DatabaseError
+		status.Errors = append(status.Errors, err.Error())
+	}
+	for _, missing := range drift {
+		status.SchemaDrift = append(status.SchemaDrift, missing.String())
+	}
+	if len(drift) > 0 && status.DatabaseStatus == DatabaseOK {
+		status.DatabaseStatus = DatabaseSchemaDrift
+	}
+
+	if status.DatabaseStatus != DatabaseOK {
+		return status
+	}
+
+	if err := a.initComponents(ctx, status); err != nil {
+		status.Errors = append(status.Errors, err.Error())
+		return status
+	}
+
+	a.auditRecovery(status.Recovery)
+	status.Ready = true
+	return status
+}
+
+// initComponents creates the components that are not set up yet and
+// (re)loads the predefined rules, reporting what that changed in status
+func (a *App) initComponents(ctx context.Context, status *StartupStatus) error {
+	if a.encryptionManager == nil {
+		if err := a.initEncryption(ctx); err != nil {
+			if errors.Is(err, ErrPassphraseRequired) || errors.Is(err, ErrWrongPassphrase) {
+				status.Passphrase = a.passphraseRequest(err)
+				if a.emitEvent != nil {
+					a.emitEvent(EncryptionPassphraseEvent, status.Passphrase)
+				}
+			}
+			return fmt.Errorf("failed to initialize encryption: %w", err)
+		}
+	}
+
+	if a.sessionManager == nil {
+		a.sessionManager = security.NewSessionManager(30 * time.Minute) // 30 minute session timeout
+		a.loadSessionRole()
+	}
+	if a.auditLogger == nil {
+		a.auditLogger = security.NewAuditLogger(a.db.DB)
+	}
+	a.auditLogger.SetRoleSource(func() string { return string(a.currentRole()) })
+	if a.deviceManager == nil {
+		a.deviceManager = device.NewManager(a.db.DB)
+	}
+	if a.ruleManager == nil {
+		a.ruleManager = checker.NewRuleManager(a.db.DB)
+	}
+
+	reconciled, err := a.ruleManager.ReconcilePredefinedRules()
+	if err != nil {
+		return fmt.Errorf("failed to load predefined rules: %w", err)
+	}
+	if reconciled.Changed() {
+		log.Printf("Predefined rules reconciled: %s", reconciled)
+		status.RuleReconciliation = reconciled
+	}
+	if err := a.moveFavoriteRules(reconciled.Adopted); err != nil {
+		log.Printf("Failed to move favorite rules to their new IDs: %v", err)
+	}
+
+	// The engine connects with the app's SSH client, so checks enforce the
+	// host key pins too
+	if a.sshClient == nil {
+		a.sshClient = ssh.NewSSHClient(nil)
+	}
+	a.sshClient.SetHostKeyPins(a.hostKeyPin)
+
+	if a.checkEngine == nil {
+		a.checkEngine = checker.NewEngineWithSSHClient(a.ruleManager, a.sshClient)
+		if a.locale != "" {
+			a.checkEngine.SetLocale(a.locale)
+		}
+		a.checkEngine.SetInventoryHook(a.recordInventory)
+		a.loadScanConcurrency()
+		a.loadShellSessions()
+		a.checkEngine.SetEnableSecretFunc(a.deviceEnableSecret)
+		a.loadExcludeBrokenRules()
+		if a.dataDir != "" {
+			if err := a.checkEngine.SetEvidenceDir(filepath.Join(a.dataDir, evidenceDirName)); err != nil {
+				log.Printf("Failed to set up evidence directory, keeping full output: %v", err)
+			}
+		}
+	}
+	if a.resultStore == nil {
+		a.resultStore = checker.NewResultStore(a.db.DB)
+		a.resultStore.SetStatusTransitionHook(a.emitStatusChange)
+		a.checkEngine.SetResultStore(a.resultStore)
+		// No check is running yet, so any unreferenced file is from a run
+		// that was never saved
+		if dir := a.checkEngine.EvidenceDir(); dir != "" {
+			if removed, err := a.resultStore.RemoveOrphanedEvidence(dir); err != nil {
+				log.Printf("Failed to remove orphaned evidence files: %v", err)
+			} else if removed > 0 {
+				log.Printf("Removed %d evidence files of runs that were not saved", removed)
+			}
+		}
+	}
+	if a.snapshotStore == nil {
+		a.snapshotStore = checker.NewSnapshotStore(a.db.DB)
+		a.checkEngine.SetSnapshotStore(a.snapshotStore)
+	}
+	if a.postureStore == nil {
+		a.postureStore = checker.NewPostureStore(a.db.DB)
+		a.checkEngine.SetPostureStore(a.postureStore)
+	}
+	if a.connectionMetrics == nil {
+		a.connectionMetrics = checker.NewConnectionMetricsStore(a.db.DB)
+		a.checkEngine.SetConnectionMetricsStore(a.connectionMetrics)
+	}
+	if a.overrideManager == nil {
+		a.overrideManager = checker.NewOverrideManager(a.db.DB)
+		a.checkEngine.SetOverrideManager(a.overrideManager)
+	}
+	if a.macroManager == nil {
+		a.macroManager = checker.NewMacroManager(a.db.DB)
+		a.checkEngine.SetMacroManager(a.macroManager)
+	}
+	if a.scanner == nil {
+		a.scanner = device.NewConnectivityScanner()
+	}
+	a.loadAdaptiveRetry()
+	a.loadRateLimits()
+	if a.rotationManager == nil {
+		a.rotationManager = rotation.NewRotationManager(a.db.DB, a.deviceManager, a.encryptionManager,
+			a.sshClient, a.auditLogger, localUserID)
+		a.rotationManager.SetHostKeyCallbacks(checker.DeviceHostKeyCallback)
+	}
+
+	return nil
+}
+
+// resetDatabaseComponents drops the components holding the database pool,
+// so initComponents rebuilds them on a reopened database. Encryption, the
+// SSH client and sessions do not use the database and are kept.
+func (a *App) resetDatabaseComponents() {
+	a.auditLogger = nil
+	a.deviceManager = nil
+	a.ruleManager = nil
+	a.checkEngine = nil
+	a.resultStore = nil
+	a.snapshotStore = nil
+	a.postureStore = nil
+	a.connectionMetrics = nil
+	a.overrideManager = nil
+	a.macroManager = nil
+	a.rotationManager = nil
+}
+
+// setStartupStatus records the outcome of startup or a repair and reports
+// a degraded app to the frontend
+func (a *App) setStartupStatus(status *StartupStatus) {
+	status.CheckedAt = time.Now()
+	a.countRulesAndDevices(status)
+
+	a.startupMutex.Lock()
+	a.startupStatus = status
+	a.startupMutex.Unlock()
+
+	if status.Ready {
+		return
+	}
+
+	log.Printf("Application started in degraded mode: database %s, errors: %v, schema drift: %v, migration error: %s",
+		status.DatabaseStatus, status.Errors, status.SchemaDrift, status.MigrationError)
+	if a.emitEvent != nil {
+		a.emitEvent(StartupErrorEvent, status)
+	}
+}
+
+// countRulesAndDevices fills in the rule and device counts when the
+// components are available
+func (a *App) countRulesAndDevices(status *StartupStatus) {
+	if a.ruleManager != nil {
+		if rules, err := a.ruleManager.GetAllRules(); err == nil {
+			status.RuleCount = len(rules)
+		}
+	}
+	if a.deviceManager != nil {
+		if stats, err := a.deviceManager.GetDeviceStats(); err == nil {
+			status.DeviceCount = stats.TotalCount
+		}
+	}
+}
+
+// GetStartupStatus reports whether the app started fully, with the database
+// state, schema versions, any errors and the current rule and device counts
+func (a *App) GetStartupStatus() *StartupStatus {
+	a.startupMutex.RLock()
+	current := a.startupStatus
+	a.startupMutex.RUnlock()
+
+	if current == nil {
+		return &StartupStatus{
+			DatabaseStatus:        DatabaseUnavailable,
+			ExpectedSchemaVersion: database.LatestSchemaVersion(),
+			Errors:                []string{"application has not started"},
+		}
+	}
+
+	status := *current
+	if status.Ready {
+		a.countRulesAndDevices(&status)
+	}
+	return &status
+}
+
+// RepairDatabase repairs the database schema, re-runs migrations and
+// reloads the predefined rules, then reports the new startup status
+func (a *App) RepairDatabase() (*StartupStatus, error) {
+	if err := a.requireRole(security.RoleAdmin, "RepairDatabase"); err != nil {
+		return nil, err
+	}
+	ctx := a.appContext()
+
+	// A database that failed to open is opened by initialize and repaired
+	// on the next attempt
+	var repairErr error
+	if a.db != nil {
+		repairErr = database.RepairSchema(a.db.DB)
+	}
+
+	status := a.initialize(ctx)
+	if !status.Ready {
+		reason := "database repair did not succeed"
+		if repairErr != nil {
+			reason = repairErr.Error()
+		}
+		return status, &NotReadyError{Code: ErrCodeAppNotReady, Reason: reason, Status: status}
+	}
+
+	a.recordAudit(security.ActionUpdate, security.EntityDatabase, "", "Repaired database")
+	return status, nil
+}
+
+// requireReady returns a NotReadyError while the app is degraded. Apps that
+// have not been started are not blocked.
+func (a *App) requireReady() error {
+	a.startupMutex.RLock()
+	status := a.startupStatus
+	a.startupMutex.RUnlock()
+
+	if status == nil || status.Ready {
+		return nil
+	}
+
+	reason := fmt.Sprintf("database %s", status.DatabaseStatus)
+	if status.DatabaseStatus == DatabaseOK && len(status.Errors) > 0 {
+		reason = status.Errors[0]
+	}
+
+	copied := *status
+	return &NotReadyError{Code: ErrCodeAppNotReady, Reason: reason, Status: &copied}
+}
@@ -0,0 +1,396 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStartupTestApp returns an unstarted app using dir as its data directory
+// and recording the events it emits
+func newStartupTestApp(t *testing.T, dir string) (*App, *[]string) {
+	t.Helper()
+
+	var events []string
+	calls := 0
+	a := &App{
+		dataDir:          dir,
+		keyStore:         newFakeKeyStore(),
+		passphrasePrompt: countingPrompt("test passphrase", &calls),
+	}
+	a.emitEvent = func(name string, data ...interface{}) {
+		events = append(events, name)
+	}
+	t.Cleanup(func() {
+		if a.db != nil {
+			a.db.Close()
+		}
+	})
+	return a, &events
+}
+
+// prepareDatabase migrates a database in a new data directory and lets
+// damage break it before the app starts
+func prepareDatabase(t *testing.T, damage func(db *database.DB)) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := database.NewSQLiteDB(dir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, database.RunMigrations(db.DB))
+	damage(db)
+	return dir
+}
+
+func TestApp_StartupReady(t *testing.T) {
+	a, events := newStartupTestApp(t, t.TempDir())
+
+	status := a.initialize(context.Background())
+	assert.True(t, status.Ready)
+	assert.Equal(t, DatabaseOK, status.DatabaseStatus)
+	assert.Equal(t, database.LatestSchemaVersion(), status.SchemaVersion)
+	assert.Equal(t, status.ExpectedSchemaVersion, status.SchemaVersion)
+	assert.Greater(t, status.RuleCount, 0)
+	assert.Empty(t, *events)
+	assert.NoError(t, a.requireReady())
+
+	current := a.GetStartupStatus()
+	assert.True(t, current.Ready)
+	assert.Equal(t, 0, current.DeviceCount)
+}
+
+func TestApp_StartupAdoptsLegacyRules(t *testing.T) {
+	// A rule and favorite stored by a release before predefined rules had
+	// stable IDs
+	dir := prepareDatabase(t, func(db *database.DB) {
+		_, err := db.Exec(`INSERT INTO security_rules (id, name, description, vendor, command, expected_pattern, severity)
+			VALUES ('legacy-ssh', 'Check SSH vs Telnet Configuration', '', 'cisco', 'show ip ssh', 'SSH Enabled', 'High')`)
+		require.NoError(t, err)
+		_, err = db.Exec(`INSERT INTO app_settings (key, value) VALUES ('favorite_rules', '["legacy-ssh"]')`)
+		require.NoError(t, err)
+	})
+	a, _ := newStartupTestApp(t, dir)
+
+	status := a.initialize(context.Background())
+	require.True(t, status.Ready)
+	require.NotNil(t, status.RuleReconciliation)
+	require.Len(t, status.RuleReconciliation.Adopted, 1)
+	stableID := status.RuleReconciliation.Adopted["legacy-ssh"]
+	assert.NotEmpty(t, stableID)
+	assert.Len(t, status.RuleReconciliation.Created, len(checker.GetPredefinedRules())-1)
+
+	favorites, err := a.GetFavoriteRules()
+	require.NoError(t, err)
+	require.Len(t, favorites, 1)
+	assert.Equal(t, stableID, favorites[0].ID)
+
+	// Nothing changes on the next startup
+	assert.Nil(t, a.initialize(context.Background()).RuleReconciliation)
+}
+
+func TestApp_StartupMissingTable(t *testing.T) {
+	dir := prepareDatabase(t, func(db *database.DB) {
+		_, err := db.Exec("DROP TABLE security_rules")
+		require.NoError(t, err)
+	})
+	a, events := newStartupTestApp(t, dir)
+
+	status := a.initialize(context.Background())
+	assert.False(t, status.Ready)
+	assert.Equal(t, DatabaseSchemaDrift, status.DatabaseStatus)
+	assert.Equal(t, []string{"missing table security_rules"}, status.SchemaDrift)
+	assert.Equal(t, []string{StartupErrorEvent}, *events)
+
+	// Check execution is blocked with a structured error
+	_, err := a.RunSecurityCheck("any", "", "")
+	var notReady *NotReadyError
+	require.True(t, errors.As(err, &notReady))
+	assert.Equal(t, ErrCodeAppNotReady, notReady.Code)
+	assert.Equal(t, DatabaseSchemaDrift, notReady.Status.DatabaseStatus)
+
+	_, err = a.RunBulkSecurityChecks("", "")
+	assert.True(t, errors.As(err, &notReady))
+	_, err = a.ScanDevicePorts("any", nil)
+	assert.True(t, errors.As(err, &notReady))
+
+	// Repair recreates the table and finishes startup
+	repaired, err := a.RepairDatabase()
+	require.NoError(t, err)
+	assert.True(t, repaired.Ready)
+	assert.Empty(t, repaired.SchemaDrift)
+	assert.Greater(t, repaired.RuleCount, 0)
+	assert.True(t, a.GetStartupStatus().Ready)
+	assert.NoError(t, a.requireReady())
+
+	_, err = a.RunBulkSecurityChecks("", "")
+	assert.NoError(t, err)
+}
+
+func TestApp_StartupFailedMigration(t *testing.T) {
+	latest := database.LatestSchemaVersion()
+	var failing int
+	for _, migration := range database.GetMigrations() {
+		if migration.Name == "add_check_results_message_columns" {
+			failing = migration.Version
+		}
+	}
+	dir := prepareDatabase(t, func(db *database.DB) {
+		// The message columns migration half-applied: one of its columns
+		// exists but it was never recorded, so running it again fails
+		_, err := db.Exec("DELETE FROM schema_migrations WHERE version >= ?", failing)
+		require.NoError(t, err)
+		_, err = db.Exec("ALTER TABLE check_results DROP COLUMN message_params")
+		require.NoError(t, err)
+	})
+	a, events := newStartupTestApp(t, dir)
+
+	status := a.initialize(context.Background())
+	assert.False(t, status.Ready)
+	assert.Equal(t, DatabaseMigrationFailed, status.DatabaseStatus)
+	assert.Contains(t, status.MigrationError, "duplicate column")
+	assert.Equal(t, failing-1, status.SchemaVersion)
+	assert.Equal(t, []string{"missing column check_results.message_params"}, status.SchemaDrift)
+	assert.Equal(t, []string{StartupErrorEvent}, *events)
+
+	_, err := a.RunSecurityCheck("any", "", "")
+	var notReady *NotReadyError
+	require.True(t, errors.As(err, &notReady))
+	assert.Contains(t, err.Error(), "migration_failed")
+
+	repaired, err := a.RepairDatabase()
+	require.NoError(t, err)
+	assert.True(t, repaired.Ready)
+	assert.Equal(t, latest, repaired.SchemaVersion)
+	assert.Empty(t, repaired.MigrationError)
+}
+
+func TestApp_GetStartupStatusBeforeStartup(t *testing.T) {
+	a := &App{}
+	status := a.GetStartupStatus()
+	assert.False(t, status.Ready)
+	assert.Equal(t, DatabaseUnavailable, status.DatabaseStatus)
+
+	// Apps that were never started are not blocked
+	assert.NoError(t, a.requireReady())
+}
+
+func TestApp_DataDir(t *testing.T) {
+	t.Setenv(DataDirEnvVar, "")
+	defaultDir, err := database.GetDataDir()
+	require.NoError(t, err)
+	dir, err := NewApp("test").resolveDataDir()
+	require.NoError(t, err)
+	assert.Equal(t, defaultDir, dir, "the default is kept when nothing is set")
+
+	envDir := t.TempDir()
+	t.Setenv(DataDirEnvVar, envDir)
+	dir, err = NewApp("test").resolveDataDir()
+	require.NoError(t, err)
+	assert.Equal(t, envDir, dir)
+
+	// The constructor's directory wins over the environment
+	customDir := t.TempDir()
+	a := NewAppWithDataDir("test", customDir)
+	dir, err = a.resolveDataDir()
+	require.NoError(t, err)
+	assert.Equal(t, customDir, dir)
+}
+
+func TestApp_StartupUsesDataDir(t *testing.T) {
+	dir := t.TempDir()
+	a := NewAppWithDataDir("test", dir)
+	calls := 0
+	a.keyStore = newFakeKeyStore()
+	a.passphrasePrompt = countingPrompt("test passphrase", &calls)
+	t.Cleanup(func() {
+		if a.db != nil {
+			a.db.Close()
+		}
+	})
+
+	status := a.initialize(context.Background())
+	require.True(t, status.Ready)
+	assert.Equal(t, dir, a.db.GetDataDir())
+	assert.FileExists(t, filepath.Join(dir, "network_checker.db"))
+}
+
+func TestApp_RestoreDatabaseFromBackup(t *testing.T) {
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+
+	newDevice := func(name, ip string) device.Device {
+		return device.Device{Name: name, IPAddress: ip, DeviceType: string(device.TypeRouter),
+			Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	}
+	require.NoError(t, a.AddDevice(newDevice("Core Router", "10.0.0.1")))
+
+	backupPath := filepath.Join(t.TempDir(), "backup"+database.CompressedBackupExtension)
+	require.NoError(t, a.BackupDatabaseCompressed(backupPath))
+	require.NoError(t, a.AddDevice(newDevice("Edge Router", "10.0.0.2")))
+
+	require.NoError(t, a.RestoreDatabaseFromBackup(backupPath))
+	assert.True(t, a.GetStartupStatus().Ready)
+
+	devices, err := a.GetDevices()
+	require.NoError(t, err)
+	require.Len(t, devices, 1, "devices added after the backup are gone")
+	assert.Equal(t, "Core Router", devices[0].Name)
+
+	// Components work on the restored database
+	require.NoError(t, a.AddDevice(newDevice("Edge Router", "10.0.0.2")))
+	page, err := a.QueryAuditLog(security.AuditQuery{EntityType: security.EntityDatabase})
+	require.NoError(t, err)
+	require.NotEmpty(t, page.Entries)
+	assert.Contains(t, page.Entries[0].Details, "Restored database")
+
+	assert.Error(t, a.RestoreDatabaseFromBackup(filepath.Join(t.TempDir(), "missing.db.gz")))
+}
+
+func TestApp_RequestContext(t *testing.T) {
+	a := newActivityTestApp(t)
+	router := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(router))
+
+	// Before Startup calls run under the background context
+	ctx, cancel := a.requestContext()
+	deadline, ok := ctx.Deadline()
+	cancel()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(requestTimeout), deadline, time.Second)
+
+	devices, err := a.GetDevices()
+	require.NoError(t, err)
+	assert.Len(t, devices, 1)
+
+	// Once the app's context ends its device queries stop
+	appCtx, stop := context.WithCancel(context.Background())
+	a.ctx = appCtx
+	stop()
+
+	_, err = a.GetDevices()
+	assert.ErrorContains(t, err, context.Canceled.Error())
+	assert.ErrorContains(t, a.DeleteDevice(router.ID), context.Canceled.Error())
+
+	a.ctx = nil
+	stored, err := a.deviceManager.GetDevice(router.ID)
+	require.NoError(t, err)
+	assert.Equal(t, router.Name, stored.Name)
+}
+
+// damageDevicesTable overwrites the root page of the devices table in the
+// closed database in dir
+func damageDevicesTable(t *testing.T, dir string) {
+	t.Helper()
+
+	path := filepath.Join(dir, "network_checker.db")
+	conn, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	var rootPage, pageSize int64
+	require.NoError(t, conn.QueryRow(`SELECT rootpage FROM sqlite_master WHERE name = 'devices'`).Scan(&rootPage))
+	require.NoError(t, conn.QueryRow(`PRAGMA page_size`).Scan(&pageSize))
+	require.NoError(t, conn.Close())
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteAt(bytes.Repeat([]byte{0xA5}, int(pageSize)), (rootPage-1)*pageSize)
+	require.NoError(t, err)
+}
+
+// restartApp closes the app's database so the next initialize opens it again
+func restartApp(t *testing.T, a *App) {
+	t.Helper()
+
+	require.NoError(t, a.db.Close())
+	a.db = nil
+	a.resetDatabaseComponents()
+}
+
+func TestApp_StartupRecoversCorruptDatabase(t *testing.T) {
+	dir := t.TempDir()
+	a, events := newStartupTestApp(t, dir)
+	require.True(t, a.initialize(context.Background()).Ready)
+	require.NoError(t, a.SetAdaptiveRetry(false))
+	require.NoError(t, a.AddDevice(device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}))
+
+	restartApp(t, a)
+	damageDevicesTable(t, dir)
+
+	status := a.initialize(context.Background())
+	require.True(t, status.Ready, "errors: %v", status.Errors)
+	assert.Equal(t, DatabaseOK, status.DatabaseStatus)
+	require.NotNil(t, status.Integrity)
+	assert.False(t, status.Integrity.OK)
+	require.NotNil(t, status.Recovery)
+	assert.True(t, status.Recovery.Recovered)
+	assert.Equal(t, database.RecoverySalvage, status.Recovery.Method)
+	assert.Equal(t, []string{"devices"}, status.Recovery.DamagedTables)
+	assert.FileExists(t, status.Recovery.PreservedPath)
+	assert.Contains(t, *events, DatabaseRecoveryEvent)
+	assert.NotContains(t, *events, StartupErrorEvent)
+
+	// Settings survive the salvage and the lost devices are gone
+	assert.False(t, a.GetAdaptiveRetry())
+	devices, err := a.GetDevices()
+	require.NoError(t, err)
+	assert.Empty(t, devices)
+
+	// Every step is in the audit log of the recovered database
+	page, err := a.QueryAuditLog(security.AuditQuery{EntityType: security.EntityDatabase})
+	require.NoError(t, err)
+	var recoverySteps int
+	for _, entry := range page.Entries {
+		if strings.HasPrefix(entry.Details, "Database recovery: ") {
+			recoverySteps++
+		}
+	}
+	assert.Equal(t, len(status.Recovery.Steps), recoverySteps)
+
+	// An on-demand check replaces the startup check in the status
+	report, err := a.CheckDatabaseIntegrity()
+	require.NoError(t, err)
+	assert.True(t, report.OK)
+	assert.Equal(t, database.IntegrityFull, report.Mode)
+	current := a.GetStartupStatus()
+	assert.Equal(t, report, current.Integrity)
+	assert.True(t, current.Recovery.Recovered)
+}
+
+func TestApp_StartupUnrecoverableDatabase(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "network_checker.db"), bytes.Repeat([]byte{0x5A}, 8192), 0600))
+
+	a, events := newStartupTestApp(t, dir)
+	status := a.initialize(context.Background())
+	assert.False(t, status.Ready)
+	assert.Equal(t, DatabaseCorrupt, status.DatabaseStatus)
+	require.NotNil(t, status.Recovery)
+	assert.False(t, status.Recovery.Recovered)
+	assert.NotEmpty(t, status.Errors)
+	assert.Equal(t, []string{DatabaseRecoveryEvent, StartupErrorEvent}, *events)
+
+	var notReady *NotReadyError
+	require.True(t, errors.As(a.requireReady(), &notReady))
+	assert.Equal(t, DatabaseCorrupt, notReady.Status.DatabaseStatus)
+
+	_, err := a.CheckDatabaseIntegrity()
+	assert.Error(t, err, "no database is open")
+}
@@ -0,0 +1,391 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/security"
+)
+
+// app_settings keys holding the storage limits in bytes
+const (
+	storageSoftLimitKey = "storage_soft_limit_bytes"
+	storageHardLimitKey = "storage_hard_limit_bytes"
+)
+
+// Storage limit defaults and the smallest limit accepted
+const (
+	DefaultStorageSoftLimit int64 = 2 << 30
+	DefaultStorageHardLimit int64 = 4 << 30
+	MinStorageLimit         int64 = 1 << 20
+)
+
+// storageDeleteBatch is how many runs enforcing the storage limit deletes
+// before estimating the usage again
+const storageDeleteBatch = 10
+
+// storageSampleInterval is how often the data directory is measured
+// besides after each run
+const storageSampleInterval = 10 * time.Minute
+
+// StorageWarningEvent is emitted with the StorageStatus whenever usage
+// moves to a degraded or full state
+const StorageWarningEvent = "storage:warning"
+
+// ErrCodeStorageFull is the code of the error returned by runs blocked at
+// the hard storage limit
+const ErrCodeStorageFull = "storage_full"
+
+// Storage states reported by StorageStatus
+const (
+	StorageOK       = "ok"
+	StorageDegraded = "degraded"
+	StorageFull     = "full"
+)
+
+// StorageLimits bound the size of the data directory. Above the soft
+// limit the app is degraded and warns; at the hard limit old evidence and
+// runs are deleted down to the soft limit, and new runs are refused while
+// that is not enough.
+type StorageLimits struct {
+	SoftLimitBytes int64 `json:"softLimitBytes"`
+	HardLimitBytes int64 `json:"hardLimitBytes"`
+}
+
+// StorageEnforcement is what reaching the hard limit deleted
+type StorageEnforcement struct {
+	EvidenceTrimmed int       `json:"evidenceTrimmed"`
+	RunsDeleted     []string  `json:"runsDeleted"`
+	EnforcedAt      time.Time `json:"enforcedAt"`
+}
+
+// StorageStatus is the last measurement of the data directory against the
+// limits. LastEnforcement describes the last time the hard limit was hit.
+type StorageStatus struct {
+	State           string              `json:"state"`
+	Usage           database.DiskUsage  `json:"usage"`
+	Limits          StorageLimits       `json:"limits"`
+	LastEnforcement *StorageEnforcement `json:"lastEnforcement,omitempty"`
+	SampledAt       time.Time           `json:"sampledAt"`
+}
+
+// StorageUsage is the storage status with the database broken down by table
+type StorageUsage struct {
+	Status *StorageStatus             `json:"status"`
+	Tables *database.TableUsageReport `json:"tables"`
+}
+
+// StorageFullError is returned by runs refused because the data directory
+// is at the hard limit and nothing more could be deleted
+type StorageFullError struct {
+	Code   string         `json:"code"`
+	Reason string         `json:"reason"`
+	Status *StorageStatus `json:"status"`
+}
+
+func (e *StorageFullError) Error() string {
+	return fmt.Sprintf("storage full: %s", e.Reason)
+}
+
+// storageState tracks the storage status and its sampler
+type storageState struct {
+	// sampling serializes measurements, as each may delete data
+	sampling sync.Mutex
+
+	mu     sync.RWMutex
+	status *StorageStatus
+	stop   chan struct{}
+}
+
+// SetStorageLimits sets the soft and hard limits of the data directory in
+// bytes, keeps them across restarts and measures usage against them
+func (a *App) SetStorageLimits(softLimitBytes, hardLimitBytes int64) error {
+	if err := a.requireRole(security.RoleAdmin, "SetStorageLimits"); err != nil {
+		return err
+	}
+	if err := a.requireReady(); err != nil {
+		return err
+	}
+	if softLimitBytes < MinStorageLimit {
+		return fmt.Errorf("storage soft limit must be at least %d bytes", MinStorageLimit)
+	}
+	if hardLimitBytes <= softLimitBytes {
+		return fmt.Errorf("storage hard limit must be above the soft limit")
+	}
+	if a.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	if err := a.saveSetting(storageSoftLimitKey, strconv.FormatInt(softLimitBytes, 10)); err != nil {
+		return err
+	}
+	if err := a.saveSetting(storageHardLimitKey, strconv.FormatInt(hardLimitBytes, 10)); err != nil {
+		return err
+	}
+	_, err := a.sampleStorage()
+	return err
+}
+
+// GetStorageLimits returns the limits of the data directory
+func (a *App) GetStorageLimits() StorageLimits {
+	limits := StorageLimits{SoftLimitBytes: DefaultStorageSoftLimit, HardLimitBytes: DefaultStorageHardLimit}
+	if a.db == nil {
+		return limits
+	}
+	limits.SoftLimitBytes = a.loadStorageLimit(storageSoftLimitKey, limits.SoftLimitBytes)
+	limits.HardLimitBytes = a.loadStorageLimit(storageHardLimitKey, limits.HardLimitBytes)
+	if limits.HardLimitBytes <= limits.SoftLimitBytes {
+		log.Printf("Ignoring saved storage limits, hard limit %d is not above soft limit %d",
+			limits.HardLimitBytes, limits.SoftLimitBytes)
+		return StorageLimits{SoftLimitBytes: DefaultStorageSoftLimit, HardLimitBytes: DefaultStorageHardLimit}
+	}
+	return limits
+}
+
+// loadStorageLimit reads a saved limit. A missing or unusable value leaves
+// the default.
+func (a *App) loadStorageLimit(key string, fallback int64) int64 {
+	value, ok, err := a.getSetting(key)
+	if err != nil {
+		log.Printf("Failed to load storage limit: %v", err)
+		return fallback
+	}
+	if !ok {
+		return fallback
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < MinStorageLimit {
+		log.Printf("Ignoring invalid saved storage limit %s=%q", key, value)
+		return fallback
+	}
+	return limit
+}
+
+// GetStorageUsage measures the data directory and breaks the database down
+// by table, with the current limits
+func (a *App) GetStorageUsage() (*StorageUsage, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	status, err := a.sampleStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	tables, err := a.db.TableUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &StorageUsage{Status: status, Tables: tables}, nil
+}
+
+// sampleStorage measures the data directory against the limits. At the hard
+// limit it first drops the evidence of old runs and then deletes the oldest
+// runs, until usage is below the soft limit or nothing more may be deleted.
+// Moving to a degraded or full state, or deleting data, emits a
+// StorageWarningEvent.
+func (a *App) sampleStorage() (*StorageStatus, error) {
+	a.storage.sampling.Lock()
+	defer a.storage.sampling.Unlock()
+
+	limits := a.GetStorageLimits()
+	usage, err := a.db.DiskUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	a.storage.mu.RLock()
+	previous := a.storage.status
+	a.storage.mu.RUnlock()
+
+	status := &StorageStatus{Limits: limits}
+	if previous != nil {
+		status.LastEnforcement = previous.LastEnforcement
+	}
+
+	if usage.TotalBytes >= limits.HardLimitBytes {
+		enforcement, enforced, err := a.enforceStorageQuota(limits)
+		if err != nil {
+			log.Printf("Failed to enforce storage limit: %v", err)
+		}
+		if enforcement != nil {
+			status.LastEnforcement = enforcement
+		}
+		if enforced != nil {
+			usage = enforced
+		}
+	}
+
+	status.Usage = *usage
+	status.SampledAt = time.Now()
+	switch {
+	case usage.TotalBytes >= limits.HardLimitBytes:
+		status.State = StorageFull
+	case usage.TotalBytes >= limits.SoftLimitBytes:
+		status.State = StorageDegraded
+	default:
+		status.State = StorageOK
+	}
+
+	a.storage.mu.Lock()
+	a.storage.status = status
+	a.storage.mu.Unlock()
+
+	// Deleting data is reported even when it brought usage back below the
+	// soft limit
+	enforced := status.LastEnforcement != nil && (previous == nil || status.LastEnforcement != previous.LastEnforcement)
+	changed := previous == nil || previous.State != status.State
+	if (changed && status.State != StorageOK) || enforced {
+		log.Printf("Data directory uses %d bytes, storage is %s (soft limit %d, hard limit %d)",
+			usage.TotalBytes, status.State, limits.SoftLimitBytes, limits.HardLimitBytes)
+		if a.emitEvent != nil {
+			a.emitEvent(StorageWarningEvent, *status)
+		}
+	}
+	return status, nil
+}
+
+// enforceStorageQuota deletes data until the data directory is below the
+// soft limit: first the evidence of results outside each device's latest
+// run, then the oldest runs, storageDeleteBatch at a time. Progress is
+// judged by the estimated compacted size, and the database is compacted
+// once at the end so the deleted rows are given back. It returns what was
+// deleted, if anything, and the usage it left.
+func (a *App) enforceStorageQuota(limits StorageLimits) (*StorageEnforcement, *database.DiskUsage, error) {
+	if a.resultStore == nil {
+		return nil, nil, nil
+	}
+
+	ctx, cancel := a.requestContext()
+	defer cancel()
+
+	enforcement := &StorageEnforcement{EnforcedAt: time.Now()}
+	trimmed, err := a.resultStore.TrimEvidence()
+	if err != nil {
+		return nil, nil, err
+	}
+	enforcement.EvidenceTrimmed = trimmed
+
+	var deleteErr error
+	for {
+		estimate, err := a.db.CompactedUsage(ctx)
+		if err != nil {
+			deleteErr = err
+			break
+		}
+		if estimate.TotalBytes < limits.SoftLimitBytes {
+			break
+		}
+		runIDs, err := a.resultStore.DeleteOldestRuns(storageDeleteBatch)
+		enforcement.RunsDeleted = append(enforcement.RunsDeleted, runIDs...)
+		if err != nil || len(runIDs) == 0 {
+			deleteErr = err
+			break
+		}
+	}
+
+	if enforcement.EvidenceTrimmed == 0 && len(enforcement.RunsDeleted) == 0 {
+		return nil, nil, deleteErr
+	}
+	log.Printf("Storage limit reached: trimmed the evidence of %d results and deleted %d runs",
+		enforcement.EvidenceTrimmed, len(enforcement.RunsDeleted))
+	if len(enforcement.RunsDeleted) > 0 {
+		a.recordAudit(security.ActionDelete, security.EntityCheckRun, "",
+			fmt.Sprintf("Storage limit reached, deleted runs %s", strings.Join(enforcement.RunsDeleted, ", ")))
+	}
+
+	if err := a.db.Compact(ctx); err != nil {
+		return enforcement, nil, err
+	}
+	usage, err := a.db.DiskUsage()
+	if err != nil {
+		return enforcement, nil, err
+	}
+	return enforcement, usage, deleteErr
+}
+
+// refreshStorage measures the data directory after a run saved its
+// results. Failures are logged, as the run itself succeeded.
+func (a *App) refreshStorage() {
+	if a.db == nil {
+		return
+	}
+	if _, err := a.sampleStorage(); err != nil {
+		log.Printf("Failed to measure storage: %v", err)
+	}
+}
+
+// requireStorage returns a StorageFullError while the data directory is at
+// the hard limit. A full directory is measured again first, in case space
+// was freed since.
+func (a *App) requireStorage() error {
+	a.storage.mu.RLock()
+	status := a.storage.status
+	a.storage.mu.RUnlock()
+
+	if status == nil || status.State != StorageFull || a.db == nil {
+		return nil
+	}
+	status, err := a.sampleStorage()
+	if err != nil {
+		return err
+	}
+	if status.State != StorageFull {
+		return nil
+	}
+
+	return &StorageFullError{
+		Code: ErrCodeStorageFull,
+		Reason: fmt.Sprintf("data directory uses %d of %d bytes and nothing more can be deleted automatically",
+			status.Usage.TotalBytes, status.Limits.HardLimitBytes),
+		Status: status,
+	}
+}
+
+// startStorageSampler measures the data directory now and then every
+// storageSampleInterval until ctx ends or stopStorageSampler is called
+func (a *App) startStorageSampler(ctx context.Context) {
+	a.stopStorageSampler()
+	a.refreshStorage()
+
+	stop := make(chan struct{})
+	a.storage.mu.Lock()
+	a.storage.stop = stop
+	a.storage.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(storageSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.refreshStorage()
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stopStorageSampler stops the sampler, if one is running
+func (a *App) stopStorageSampler() {
+	a.storage.mu.Lock()
+	defer a.storage.mu.Unlock()
+	if a.storage.stop != nil {
+		close(a.storage.stop)
+		a.storage.stop = nil
+	}
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storageTest is an app started in a new data directory with one device
+type storageTest struct {
+	app      *App
+	deviceID string
+	// warnings are the storage warnings the app emitted
+	warnings []StorageStatus
+	// baseline is the size of the compacted data directory after startup
+	baseline int64
+}
+
+func newStorageTest(t *testing.T) *storageTest {
+	t.Helper()
+
+	a, _ := newStartupTestApp(t, t.TempDir())
+	require.True(t, a.initialize(context.Background()).Ready)
+	st := &storageTest{app: a}
+	a.emitEvent = func(name string, data ...interface{}) {
+		if name == StorageWarningEvent {
+			st.warnings = append(st.warnings, data[0].(StorageStatus))
+		}
+	}
+
+	dev := &device.Device{Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22}
+	require.NoError(t, a.deviceManager.AddDevice(dev))
+	st.deviceID = dev.ID
+
+	require.NoError(t, a.db.Compact(context.Background()))
+	usage, err := a.db.DiskUsage()
+	require.NoError(t, err)
+	st.baseline = usage.TotalBytes
+	return st
+}
+
+// saveRun saves a one-result run of the device checked days ago, with a
+// message of messageBytes and a full output file of evidenceBytes, and
+// returns the path of the file
+func (st *storageTest) saveRun(t *testing.T, runID string, days, messageBytes, evidenceBytes int) string {
+	t.Helper()
+	a := st.app
+
+	result := checker.CheckResult{ID: runID + "-result", DeviceID: st.deviceID, CheckName: "Check", CheckType: "configuration",
+		Severity: string(checker.SeverityHigh), Status: string(checker.StatusPass), Message: strings.Repeat("m", messageBytes),
+		Evidence: "snippet", RunID: runID, CheckedAt: time.Now().Add(-time.Duration(days) * 24 * time.Hour)}
+	if evidenceBytes > 0 {
+		result.EvidenceFullPath = filepath.Join(a.dataDir, evidenceDirName, result.ID+".txt")
+		require.NoError(t, os.WriteFile(result.EvidenceFullPath, make([]byte, evidenceBytes), 0600))
+	}
+	require.NoError(t, a.resultStore.SaveResults([]checker.CheckResult{result}))
+	require.NoError(t, a.db.Compact(context.Background()))
+	return result.EvidenceFullPath
+}
+
+func TestApp_StorageLimits(t *testing.T) {
+	a := newStorageTest(t).app
+
+	assert.Equal(t, StorageLimits{SoftLimitBytes: DefaultStorageSoftLimit, HardLimitBytes: DefaultStorageHardLimit},
+		a.GetStorageLimits())
+
+	require.NoError(t, a.SetStorageLimits(10<<20, 20<<20))
+	assert.Equal(t, StorageLimits{SoftLimitBytes: 10 << 20, HardLimitBytes: 20 << 20}, a.GetStorageLimits())
+
+	assert.Error(t, a.SetStorageLimits(MinStorageLimit-1, 20<<20))
+	assert.Error(t, a.SetStorageLimits(20<<20, 20<<20))
+	assert.Equal(t, StorageLimits{SoftLimitBytes: 10 << 20, HardLimitBytes: 20 << 20}, a.GetStorageLimits(),
+		"rejected limits change nothing")
+}
+
+func TestApp_StorageWarning(t *testing.T) {
+	st := newStorageTest(t)
+	a := st.app
+	require.NoError(t, a.SetStorageLimits(st.baseline+(1<<20), st.baseline+(8<<20)))
+	assert.Empty(t, st.warnings)
+
+	st.saveRun(t, "run1", 2, 2<<20, 0)
+	status, err := a.sampleStorage()
+	require.NoError(t, err)
+	assert.Equal(t, StorageDegraded, status.State)
+	require.Len(t, st.warnings, 1)
+	assert.Equal(t, StorageDegraded, st.warnings[0].State)
+	assert.Nil(t, status.LastEnforcement, "nothing is deleted below the hard limit")
+
+	// The warning is emitted once per state change
+	_, err = a.sampleStorage()
+	require.NoError(t, err)
+	assert.Len(t, st.warnings, 1)
+	assert.NoError(t, a.requireStorage(), "a degraded app still runs checks")
+
+	usage, err := a.GetStorageUsage()
+	require.NoError(t, err)
+	assert.Equal(t, StorageDegraded, usage.Status.State)
+	assert.Equal(t, a.GetStorageLimits(), usage.Status.Limits)
+	require.NotEmpty(t, usage.Tables.Tables)
+	largest := usage.Tables.Tables[0]
+	assert.Equal(t, "check_results", largest.Name)
+	assert.Equal(t, int64(1), largest.Rows)
+	assert.GreaterOrEqual(t, largest.Bytes, int64(2<<20))
+	assert.Less(t, largest.Bytes, usage.Status.Usage.DatabaseBytes)
+}
+
+func TestApp_StorageEnforcementOrder(t *testing.T) {
+	st := newStorageTest(t)
+	a := st.app
+	require.NoError(t, a.SetStorageLimits(st.baseline+(1<<20), st.baseline+(3<<20)))
+
+	// Trimming the evidence files alone does not get below the hard limit,
+	// deleting the old runs gets below the soft limit
+	run1File := st.saveRun(t, "run1", 3, 3<<19, 1<<20)
+	run2File := st.saveRun(t, "run2", 2, 3<<19, 1<<20)
+	latestFile := st.saveRun(t, "run3", 1, 0, 1<<10)
+
+	status, err := a.sampleStorage()
+	require.NoError(t, err)
+	require.NotNil(t, status.LastEnforcement)
+	assert.Equal(t, 2, status.LastEnforcement.EvidenceTrimmed)
+	assert.Equal(t, []string{"run1", "run2"}, status.LastEnforcement.RunsDeleted)
+	assert.Equal(t, StorageOK, status.State)
+	assert.Less(t, status.Usage.TotalBytes, status.Limits.SoftLimitBytes)
+	require.Len(t, st.warnings, 1, "deleting runs is reported")
+	assert.Equal(t, []string{"run1", "run2"}, st.warnings[0].LastEnforcement.RunsDeleted)
+
+	assert.NoFileExists(t, run1File)
+	assert.NoFileExists(t, run2File)
+	assert.FileExists(t, latestFile, "the latest run keeps its evidence")
+
+	for _, runID := range []string{"run1", "run2"} {
+		_, err = a.resultStore.GetRunResults(runID, st.deviceID)
+		assert.ErrorIs(t, err, checker.ErrRunNotFound)
+	}
+	latest, err := a.resultStore.GetRunResults("run3", st.deviceID)
+	require.NoError(t, err)
+	assert.Len(t, latest, 1)
+
+	page, err := a.QueryAuditLog(security.AuditQuery{EntityType: security.EntityCheckRun})
+	require.NoError(t, err)
+	require.NotEmpty(t, page.Entries)
+	assert.Contains(t, page.Entries[0].Details, "run1, run2")
+}
+
+func TestApp_StorageFullBlocksRuns(t *testing.T) {
+	st := newStorageTest(t)
+	a := st.app
+	require.NoError(t, a.SetStorageLimits(st.baseline+(1<<20), st.baseline+(2<<20)))
+
+	// Backups are not deleted automatically
+	backup := filepath.Join(a.dataDir, "backups", "manual.db")
+	require.NoError(t, os.MkdirAll(filepath.Dir(backup), 0755))
+	require.NoError(t, os.WriteFile(backup, make([]byte, 3<<20), 0600))
+
+	status, err := a.sampleStorage()
+	require.NoError(t, err)
+	assert.Equal(t, StorageFull, status.State)
+	require.Len(t, st.warnings, 1)
+	assert.Equal(t, StorageFull, st.warnings[0].State)
+
+	_, err = a.RunSecurityCheck(st.deviceID, "", "")
+	var full *StorageFullError
+	require.True(t, errors.As(err, &full), "got %v", err)
+	assert.Equal(t, ErrCodeStorageFull, full.Code)
+	assert.Equal(t, StorageFull, full.Status.State)
+	_, err = a.RunBulkSecurityChecks("", "")
+	assert.True(t, errors.As(err, &full))
+
+	// Freeing space lets runs through again
+	require.NoError(t, os.Remove(backup))
+	assert.NoError(t, a.requireStorage())
+	assert.Equal(t, StorageOK, a.storage.status.State)
+}
@@ -0,0 +1,166 @@
+// Package catalog renders user-facing result messages from message IDs and
+// parameters using per-locale templates.
+package catalog
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultLocale is the locale every message ID must have a template in
+const DefaultLocale = "en"
+
+// Message IDs produced by the check engine
+const (
+	MsgCheckPassed           = "check.passed"
+	MsgPatternMismatch       = "check.pattern_mismatch"
+	MsgNoPattern             = "check.no_pattern"
+	MsgInvalidPattern        = "check.invalid_pattern"
+	MsgPatternTimeout        = "check.pattern_timeout"
+	MsgNoSectionPattern      = "check.no_section_pattern"
+	MsgInvalidSectionPattern = "check.invalid_section_pattern"
+	MsgNoSections            = "check.no_sections"
+	MsgSectionsFailed        = "check.sections_failed"
+	MsgSectionsPassed        = "check.sections_passed"
+	MsgSSHConnectFailed      = "check.ssh_connect_failed"
+	MsgSessionWaitTimeout    = "check.session_wait_timeout"
+	MsgCommandFailed         = "check.command_failed"
+	MsgExecutionFailed       = "check.execution_failed"
+	MsgUnexpectedPorts       = "check.unexpected_ports"
+	MsgAllowedPortsOnly      = "check.allowed_ports_only"
+	MsgMaintenanceWindow     = "check.maintenance_window"
+	MsgWeakSSHNegotiation    = "check.weak_ssh_negotiation"
+	MsgSSHNegotiationOK      = "check.ssh_negotiation_ok"
+	MsgExitCodeMismatch      = "check.exit_code_mismatch"
+	MsgNotApplicable         = "check.not_applicable"
+	MsgPrerequisiteFailed    = "check.prerequisite_failed"
+)
+
+// MessageIDs lists every message ID the application renders
+var MessageIDs = []string{
+	MsgCheckPassed,
+	MsgPatternMismatch,
+	MsgNoPattern,
+	MsgInvalidPattern,
+	MsgPatternTimeout,
+	MsgNoSectionPattern,
+	MsgInvalidSectionPattern,
+	MsgNoSections,
+	MsgSectionsFailed,
+	MsgSectionsPassed,
+	MsgSSHConnectFailed,
+	MsgSessionWaitTimeout,
+	MsgCommandFailed,
+	MsgExecutionFailed,
+	MsgUnexpectedPorts,
+	MsgAllowedPortsOnly,
+	MsgMaintenanceWindow,
+	MsgWeakSSHNegotiation,
+	MsgSSHNegotiationOK,
+	MsgExitCodeMismatch,
+	MsgNotApplicable,
+	MsgPrerequisiteFailed,
+}
+
+// Params are the named values interpolated into a message template
+type Params map[string]string
+
+// Message is a message ID with its parameters
+type Message struct {
+	ID     string
+	Params Params
+}
+
+// NewMessage creates a message
+func NewMessage(id string, params Params) Message {
+	return Message{ID: id, Params: params}
+}
+
+// Render renders the message in a locale
+func (m Message) Render(locale string) string {
+	return Render(m.ID, m.Params, locale)
+}
+
+//go:embed messages/*.json
+var messageFiles embed.FS
+
+// catalogs maps a locale to its templates by message ID
+var catalogs = mustLoadCatalogs()
+
+// mustLoadCatalogs reads the embedded catalogs. They ship with the binary,
+// so a broken file is a build defect.
+func mustLoadCatalogs() map[string]map[string]string {
+	entries, err := messageFiles.ReadDir("messages")
+	if err != nil {
+		panic(fmt.Sprintf("catalog: failed to read embedded catalogs: %v", err))
+	}
+
+	loaded := make(map[string]map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := messageFiles.ReadFile(path.Join("messages", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("catalog: failed to read %s: %v", entry.Name(), err))
+		}
+
+		var templates map[string]string
+		if err := json.Unmarshal(data, &templates); err != nil {
+			panic(fmt.Sprintf("catalog: invalid catalog %s: %v", entry.Name(), err))
+		}
+
+		loaded[strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))] = templates
+	}
+
+	return loaded
+}
+
+// Locales returns the locales that have a catalog
+func Locales() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// HasLocale reports whether a catalog exists for a locale
+func HasLocale(locale string) bool {
+	_, ok := catalogs[normalizeLocale(locale)]
+	return ok
+}
+
+// Render renders a message in a locale. Regional locales such as es-MX use
+// their language's catalog, and a message missing from the locale's catalog
+// falls back to English. An unknown ID renders as the ID itself.
+func Render(id string, params Params, locale string) string {
+	template, ok := catalogs[normalizeLocale(locale)][id]
+	if !ok {
+		template, ok = catalogs[DefaultLocale][id]
+	}
+	if !ok {
+		return id
+	}
+
+	if len(params) == 0 {
+		return template
+	}
+
+	replacements := make([]string, 0, len(params)*2)
+	for name, value := range params {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+// normalizeLocale reduces a locale tag such as es-MX or es_MX to its language
+func normalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "-_"); i > 0 {
+		locale = locale[:i]
+	}
+	return locale
+}
@@ -0,0 +1,117 @@
+package catalog
+
+import (
+	"regexp"
+	"testing"
+)
+
+// placeholderPattern matches a {name} placeholder in a template
+var placeholderPattern = regexp.MustCompile(`\{[a-z]+\}`)
+
+func TestCatalogs_CoverEveryMessageID(t *testing.T) {
+	for _, id := range MessageIDs {
+		if _, ok := catalogs[DefaultLocale][id]; !ok {
+			t.Errorf("Message %s has no %s template", id, DefaultLocale)
+		}
+	}
+
+	known := make(map[string]bool, len(MessageIDs))
+	for _, id := range MessageIDs {
+		known[id] = true
+	}
+
+	for _, locale := range Locales() {
+		for id, template := range catalogs[locale] {
+			if !known[id] {
+				t.Errorf("Catalog %s has a template for unknown message %s", locale, id)
+				continue
+			}
+
+			// A translation must use the same placeholders as the English text
+			want := placeholderSet(catalogs[DefaultLocale][id])
+			got := placeholderSet(template)
+			if len(want) != len(got) {
+				t.Errorf("Catalog %s message %s has placeholders %v, expected %v", locale, id, got, want)
+				continue
+			}
+			for name := range want {
+				if !got[name] {
+					t.Errorf("Catalog %s message %s is missing placeholder %s", locale, id, name)
+				}
+			}
+		}
+	}
+}
+
+func placeholderSet(template string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range placeholderPattern.FindAllString(template, -1) {
+		set[name] = true
+	}
+	return set
+}
+
+func TestLocales(t *testing.T) {
+	locales := Locales()
+	if len(locales) != 2 || locales[0] != "en" || locales[1] != "es" {
+		t.Errorf("Expected en and es catalogs, got %v", locales)
+	}
+
+	for _, locale := range []string{"en", "es", "es-MX", "ES_mx"} {
+		if !HasLocale(locale) {
+			t.Errorf("Expected locale %s to be supported", locale)
+		}
+	}
+	if HasLocale("fr") {
+		t.Error("Expected locale fr to be unsupported")
+	}
+}
+
+func TestRender(t *testing.T) {
+	params := Params{"failed": "2", "total": "5", "pattern": "^ transport input ssh$", "sections": "line vty 0 4, line vty 5 15"}
+
+	tests := []struct {
+		name     string
+		id       string
+		params   Params
+		locale   string
+		expected string
+	}{
+		{"english interpolation", MsgSectionsFailed, params, "en",
+			"2 of 5 sections do not match expected pattern ^ transport input ssh$: line vty 0 4, line vty 5 15"},
+		{"spanish interpolation", MsgSectionsFailed, params, "es",
+			"2 de 5 secciones no coinciden con el patrón esperado ^ transport input ssh$: line vty 0 4, line vty 5 15"},
+		{"regional locale", MsgCheckPassed, nil, "es-MX", "La verificación de configuración fue exitosa"},
+		{"unknown locale", MsgCheckPassed, nil, "fr", "Configuration check passed"},
+		{"empty locale", MsgCheckPassed, nil, "", "Configuration check passed"},
+		{"unknown message", "check.unknown", nil, "en", "check.unknown"},
+		{"values are not expanded", MsgPatternMismatch, Params{"pattern": "{error}"}, "en",
+			"Configuration does not match expected pattern: {error}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.id, tt.params, tt.locale); got != tt.expected {
+				t.Errorf("Render() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRender_FallsBackToEnglish(t *testing.T) {
+	original := catalogs["es"]
+	defer func() { catalogs["es"] = original }()
+
+	partial := make(map[string]string, len(original))
+	for id, template := range original {
+		if id != MsgCommandFailed {
+			partial[id] = template
+		}
+	}
+	catalogs["es"] = partial
+
+	got := NewMessage(MsgCommandFailed, Params{"error": "EOF"}).Render("es")
+	if got != "Command execution failed: EOF" {
+		t.Errorf("Expected English fallback, got %q", got)
+	}
+}
@@ -0,0 +1,208 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/google/uuid"
+)
+
+// DefaultBatchMarkerCommand prints a batch marker on CLIs that have echo
+const DefaultBatchMarkerCommand = "echo %s"
+
+// batchMarkerPrefix starts every batch marker so markers are easy to spot in
+// recorded output
+const batchMarkerPrefix = "INVICTUX-BATCH-"
+
+// batchUnsafeVendors cannot take newline-separated command batches. JunOS,
+// for one, treats the exec string as a single command.
+var batchUnsafeVendors = map[string]bool{
+	string(device.VendorJuniper): true,
+}
+
+// EnableCommandBatching makes the engine send a vendor's read-only rule
+// commands to a device as one batch, separated by marker lines, instead of
+// one round-trip per rule. markerCommand is a format string with a single %s
+// that makes the device print the marker on its own line; empty uses
+// DefaultBatchMarkerCommand. Batching is off for every vendor by default and
+// must not be changed while checks are running.
+func (e *Engine) EnableCommandBatching(vendor, markerCommand string) error {
+	if batchUnsafeVendors[vendor] {
+		return fmt.Errorf("vendor %s does not support command batching", vendor)
+	}
+	if markerCommand == "" {
+		markerCommand = DefaultBatchMarkerCommand
+	}
+	if strings.Count(markerCommand, "%s") != 1 {
+		return fmt.Errorf("marker command must contain exactly one %%s")
+	}
+
+	if e.batchMarkerCommands == nil {
+		e.batchMarkerCommands = make(map[string]string)
+	}
+	e.batchMarkerCommands[vendor] = markerCommand
+	return nil
+}
+
+// DisableCommandBatching returns a vendor to one round-trip per rule
+func (e *Engine) DisableCommandBatching(vendor string) {
+	delete(e.batchMarkerCommands, vendor)
+}
+
+// CommandBatchingEnabled reports whether rule commands are batched for a vendor
+func (e *Engine) CommandBatchingEnabled(vendor string) bool {
+	_, enabled := e.batchMarkerCommands[vendor]
+	return enabled && !batchUnsafeVendors[vendor]
+}
+
+// runBatch fetches the output of a device's batchable rule commands in one
+// round-trip. It returns the output per command, or nil when batching is off
+// or the batch fails, in which case every rule runs on its own.
+func (e *Engine) runBatch(client ssh.SSHClientInterface, device *device.Device, rules []SecurityRule) map[string]string {
+	if !e.CommandBatchingEnabled(device.Vendor) {
+		return nil
+	}
+	// Commands sent to a device shell gain nothing from batching
+	if _, isShell := client.(*deviceShell); isShell {
+		return nil
+	}
+
+	var commands []string
+	seen := make(map[string]bool)
+	add := func(raw string) {
+		// Rules whose macros cannot be expanded report the error on their own
+		command, err := e.expandCommand(raw)
+		if err != nil || !isReadOnlyCommand(command) || seen[command] {
+			return
+		}
+		seen[command] = true
+		commands = append(commands, command)
+	}
+	for _, rule := range rules {
+		// Dependents only send their commands once their prerequisites passed
+		if !rule.Enabled || e.excludedAsBroken(rule) || len(rule.DependsOn) > 0 {
+			continue
+		}
+		effective, _ := rule.ForDevice(device.Vendor, device.DeviceType)
+		// Preconditions are matched against combined output, so they batch
+		// even for rules that run their own command
+		if effective.Precondition != nil {
+			add(effective.Precondition.Command)
+		}
+		if !rule.needsOwnCommand() {
+			add(effective.Command)
+		}
+	}
+
+	// A single command gains nothing from batching
+	if len(commands) < 2 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	release, ok := e.acquireSession(ctx, client)
+	if !ok {
+		return nil
+	}
+	defer release()
+
+	conn, err := e.connect(ctx, client, device)
+	if err != nil {
+		return nil
+	}
+	defer client.Disconnect(conn)
+
+	markers := make([]string, len(commands))
+	lines := make([]string, 0, len(commands)*2)
+	for i, command := range commands {
+		markers[i] = e.batchMarker()
+		lines = append(lines, command, fmt.Sprintf(e.batchMarkerCommands[device.Vendor], markers[i]))
+	}
+
+	cmdResult, err := e.executeCommand(ctx, client, conn, device, strings.Join(lines, "\n"))
+	timings := conn.Timings()
+	if cmdResult != nil {
+		timings = timings.Merge(cmdResult.Timings)
+	}
+	e.recordTimings(device, timings)
+	if err != nil {
+		log.Printf("Command batch failed on %s, running commands individually: %v", device.Name, err)
+		return nil
+	}
+
+	outputs, err := splitBatchOutput(cmdResult.Output, markers)
+	if err != nil {
+		log.Printf("Could not split command batch output from %s, running commands individually: %v", device.Name, err)
+		return nil
+	}
+
+	batched := make(map[string]string, len(commands))
+	for i, command := range commands {
+		batched[command] = outputs[i]
+		e.captureOutput(client, device, command, outputs[i])
+	}
+	return batched
+}
+
+// batchMarker returns a new marker unlikely to appear in device output
+func (e *Engine) batchMarker() string {
+	if e.newBatchMarker != nil {
+		return e.newBatchMarker()
+	}
+	return batchMarkerPrefix + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// splitBatchOutput splits combined batch output into the output of each
+// command. Command i's output is every line after marker i-1 up to marker i.
+// Each marker must appear exactly once, on its own line and in order;
+// anything else means the output cannot be attributed safely.
+func splitBatchOutput(output string, markers []string) ([]string, error) {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+
+	for _, marker := range markers {
+		if n := strings.Count(output, marker); n != 1 {
+			return nil, fmt.Errorf("marker %s appears %d times in batch output", marker, n)
+		}
+	}
+
+	outputs := make([]string, 0, len(markers))
+	start := 0
+	for _, marker := range markers {
+		end := -1
+		for i := start; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == marker {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("marker %s missing or out of order in batch output", marker)
+		}
+
+		outputs = append(outputs, strings.Join(lines[start:end], "\n"))
+		start = end + 1
+	}
+
+	return outputs, nil
+}
+
+// isReadOnlyCommand reports whether a command only displays state and can be
+// batched with others
+func isReadOnlyCommand(command string) bool {
+	if strings.ContainsAny(command, "\n\r;") {
+		return false
+	}
+
+	fields := strings.Fields(strings.ToLower(command))
+	if len(fields) == 0 {
+		return false
+	}
+	return fields[0] == "show" || fields[0] == "display"
+}
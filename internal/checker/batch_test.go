@@ -0,0 +1,191 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// shellSSHClient answers newline-separated command batches the way a device
+// shell does: each line runs in turn and echo prints its argument
+type shellSSHClient struct {
+	stubSSHClient
+}
+
+func (s *shellSSHClient) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	s.mu.Lock()
+	s.executed = append(s.executed, command)
+	s.mu.Unlock()
+
+	var output []string
+	for _, line := range strings.Split(command, "\n") {
+		if marker, ok := strings.CutPrefix(line, "echo "); ok {
+			output = append(output, marker)
+			continue
+		}
+		output = append(output, s.outputs[line])
+	}
+	return &ssh.CommandResult{Command: command, Output: strings.Join(output, "\n"), ExecutedAt: time.Now()}, nil
+}
+
+func setupBatchEngine(t *testing.T, outputs map[string]string) (*Engine, *shellSSHClient) {
+	t.Helper()
+
+	client := &shellSSHClient{stubSSHClient: stubSSHClient{outputs: outputs}}
+	engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+
+	err := engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "Uptime", Vendor: "generic", Command: "show version", ExpectedPattern: "uptime",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "r2", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r3", Name: "Users", Vendor: "generic", Command: "show users all", ExpectedPattern: "admin",
+			Severity: string(SeverityMedium), Enabled: true},
+		{ID: "r4", Name: "Uptime days", Vendor: "generic", Command: "show version", ExpectedPattern: "days",
+			Severity: string(SeverityLow), Enabled: true},
+	})
+	assert.NoError(t, err)
+
+	return engine, client
+}
+
+func batchTestDevice(vendor string) *device.Device {
+	return &device.Device{ID: "d1", Name: "One", IPAddress: "192.168.1.50", DeviceType: string(device.TypeRouter),
+		Vendor: vendor, Username: "admin", SSHPort: 22}
+}
+
+func TestEngine_CommandBatching(t *testing.T) {
+	outputs := map[string]string{
+		// Blank lines, a trailing newline and a line that looks like a marker
+		"show version":   "Cisco IOS\n\nuptime is 5 days\n",
+		"show ip ssh":    "SSH Enabled - version 2.0\nINVICTUX-BATCH-not-a-marker",
+		"show users all": "",
+	}
+
+	t.Run("off by default", func(t *testing.T) {
+		engine, client := setupBatchEngine(t, outputs)
+		assert.False(t, engine.CommandBatchingEnabled("cisco"))
+
+		_, err := engine.RunChecks(batchTestDevice("cisco"))
+		assert.NoError(t, err)
+		assert.Len(t, client.executed, 4)
+	})
+
+	t.Run("one round-trip with identical results", func(t *testing.T) {
+		engine, client := setupBatchEngine(t, outputs)
+		unbatched, err := engine.RunChecks(batchTestDevice("cisco"))
+		assert.NoError(t, err)
+
+		client.executed = nil
+		assert.NoError(t, engine.EnableCommandBatching("cisco", ""))
+		assert.True(t, engine.CommandBatchingEnabled("cisco"))
+
+		batched, err := engine.RunChecks(batchTestDevice("cisco"))
+		assert.NoError(t, err)
+		assert.Len(t, client.executed, 1)
+		assert.Equal(t, comparableResults(t, unbatched), comparableResults(t, batched))
+
+		// Other vendors keep one round-trip per rule
+		client.executed = nil
+		_, err = engine.RunChecks(batchTestDevice("huawei"))
+		assert.NoError(t, err)
+		assert.Len(t, client.executed, 4)
+
+		engine.DisableCommandBatching("cisco")
+		assert.False(t, engine.CommandBatchingEnabled("cisco"))
+	})
+
+	t.Run("bulk runs batch per device", func(t *testing.T) {
+		engine, client := setupBatchEngine(t, outputs)
+		assert.NoError(t, engine.EnableCommandBatching("cisco", ""))
+
+		devices := []device.Device{*batchTestDevice("cisco"), *batchTestDevice("cisco")}
+		devices[1].ID = "d2"
+		devices[1].IPAddress = "192.168.1.51"
+
+		results, err := engine.RunBulkChecks(devices)
+		assert.NoError(t, err)
+		assert.Len(t, results["d2"], 4)
+		assert.Len(t, client.executed, 2)
+	})
+
+	t.Run("broken rules stay out of the batch", func(t *testing.T) {
+		engine, client := setupBatchEngine(t, outputs)
+		assert.NoError(t, engine.EnableCommandBatching("cisco", ""))
+		assert.NoError(t, engine.LoadCustomRules([]SecurityRule{
+			{ID: "r5", Name: "Clock", Vendor: "generic", Command: "show clock", ExpectedPattern: "UTC",
+				Severity: string(SeverityLow), Enabled: true},
+		}))
+		_, err := engine.ruleManager.(*RuleManager).db.Exec("UPDATE security_rules SET needs_attention = TRUE WHERE id = 'r5'")
+		assert.NoError(t, err)
+		engine.SetExcludeBrokenRules(true)
+
+		_, err = engine.RunChecks(batchTestDevice("cisco"))
+		assert.NoError(t, err)
+		assert.Len(t, client.executed, 1)
+		assert.NotContains(t, strings.Join(client.executed, "\n"), "show clock")
+	})
+
+	t.Run("marker collision falls back", func(t *testing.T) {
+		engine, client := setupBatchEngine(t, outputs)
+		assert.NoError(t, engine.EnableCommandBatching("cisco", ""))
+
+		// The device output already contains the marker, so the batch cannot
+		// be split safely
+		var n int
+		engine.newBatchMarker = func() string {
+			n++
+			if n == 1 {
+				return "INVICTUX-BATCH-not-a-marker"
+			}
+			return fmt.Sprintf("marker-%d", n)
+		}
+
+		results, err := engine.RunChecks(batchTestDevice("cisco"))
+		assert.NoError(t, err)
+		assert.Len(t, client.executed, 5)
+		for _, result := range results {
+			assert.NotEqual(t, string(StatusError), result.Status)
+		}
+	})
+
+	t.Run("unsafe vendor refused", func(t *testing.T) {
+		engine, _ := setupBatchEngine(t, outputs)
+		assert.Error(t, engine.EnableCommandBatching("juniper", ""))
+		assert.False(t, engine.CommandBatchingEnabled("juniper"))
+		assert.Error(t, engine.EnableCommandBatching("cisco", "echo"))
+	})
+}
+
+func TestSplitBatchOutput(t *testing.T) {
+	markers := []string{"M1", "M2"}
+
+	outputs, err := splitBatchOutput("a\r\n\r\nb\r\nM1\r\n  M2  \r\n", markers)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a\n\nb", ""}, outputs)
+
+	_, err = splitBatchOutput("a\nM2\nM1\n", markers)
+	assert.Error(t, err, "markers out of order")
+
+	_, err = splitBatchOutput("a\nM1\nb\n", markers)
+	assert.Error(t, err, "missing marker")
+
+	_, err = splitBatchOutput("a M1\nM1\nM2\n", markers)
+	assert.Error(t, err, "marker inside output")
+}
+
+func TestIsReadOnlyCommand(t *testing.T) {
+	assert.True(t, isReadOnlyCommand("show running-config"))
+	assert.True(t, isReadOnlyCommand("display current-configuration"))
+	assert.False(t, isReadOnlyCommand("configure terminal"))
+	assert.False(t, isReadOnlyCommand("show version; reload"))
+	assert.False(t, isReadOnlyCommand("show version\nreload"))
+	assert.False(t, isReadOnlyCommand(""))
+}
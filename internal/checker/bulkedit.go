@@ -0,0 +1,319 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// BulkEditTarget selects the rule fields a bulk edit rewrites
+type BulkEditTarget string
+
+const (
+	BulkEditCommand BulkEditTarget = "command"
+	BulkEditPattern BulkEditTarget = "pattern"
+	BulkEditBoth    BulkEditTarget = "both"
+)
+
+// Fields a bulk edit changes, as named in RuleFieldChange
+const (
+	RuleFieldCommand = "command"
+	RuleFieldPattern = "expectedPattern"
+)
+
+// RuleFilter selects the rules a bulk edit applies to. Empty fields match
+// every rule.
+type RuleFilter struct {
+	Vendor   string   `json:"vendor"`
+	Category string   `json:"category"`
+	RuleIDs  []string `json:"ruleIds"`
+}
+
+// matches reports whether a rule is selected by the filter
+func (f RuleFilter) matches(rule SecurityRule) bool {
+	if f.Vendor != "" && rule.Vendor != f.Vendor {
+		return false
+	}
+	if f.Category != "" && rule.Category != f.Category {
+		return false
+	}
+	if len(f.RuleIDs) == 0 {
+		return true
+	}
+	for _, id := range f.RuleIDs {
+		if id == rule.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleFieldChange is the text of one rule field before and after an edit.
+// Variant names the command variant the field belongs to, using the
+// CheckResult.CommandVariant labels.
+type RuleFieldChange struct {
+	Variant string `json:"variant"`
+	Field   string `json:"field"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+}
+
+// RuleEdit lists the changes a bulk edit makes to one rule. Issues holds the
+// problems the edit would introduce, leaving the rule unable to run.
+type RuleEdit struct {
+	RuleID   string            `json:"ruleId"`
+	RuleName string            `json:"ruleName"`
+	Vendor   string            `json:"vendor"`
+	Changes  []RuleFieldChange `json:"changes"`
+	Issues   []RuleIssue       `json:"issues,omitempty"`
+}
+
+// BulkEditResult is the outcome of a bulk edit. Edits lists only the rules
+// the edit changes; Applied is false for previews and refused edits.
+type BulkEditResult struct {
+	Edits   []RuleEdit `json:"edits"`
+	Invalid int        `json:"invalid"`
+	Applied bool       `json:"applied"`
+}
+
+// RuleIDs returns the IDs of the edited rules
+func (r BulkEditResult) RuleIDs() []string {
+	ids := make([]string, len(r.Edits))
+	for i, edit := range r.Edits {
+		ids[i] = edit.RuleID
+	}
+	return ids
+}
+
+// ErrStaleRuleEdit is returned when a rule changed after the edit applied
+// to it was previewed
+var ErrStaleRuleEdit = errors.New("rule changed since the edit was previewed")
+
+// BulkEditRules replaces find with replace in the commands, expected
+// patterns or both of the rules matching filter, including their device
+// type and vendor overrides. In regex mode find is a regular expression and
+// replace may refer to its groups as $1; otherwise both are literal text.
+//
+// With dryRun the edits are only previewed. Otherwise they are applied as
+// by ApplyRuleEdits.
+func (rm *RuleManager) BulkEditRules(filter RuleFilter, find, replace string, target BulkEditTarget, regexMode, dryRun bool) (*BulkEditResult, error) {
+	if find == "" {
+		return nil, fmt.Errorf("text to find cannot be empty")
+	}
+	if target != BulkEditCommand && target != BulkEditPattern && target != BulkEditBoth {
+		return nil, fmt.Errorf("invalid bulk edit target %q", target)
+	}
+
+	rewrite := func(text string) string { return strings.ReplaceAll(text, find, replace) }
+	if regexMode {
+		re, err := regexp.Compile(find)
+		if err != nil {
+			return nil, fmt.Errorf("invalid search pattern: %w", err)
+		}
+		rewrite = func(text string) string { return re.ReplaceAllString(text, replace) }
+	}
+
+	rules, err := rm.GetAllRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+	macros, err := NewMacroManager(rm.db).Commands()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BulkEditResult{Edits: []RuleEdit{}}
+	for _, rule := range rules {
+		if !filter.matches(rule) {
+			continue
+		}
+
+		updated := rule.editableCopy()
+		edit := RuleEdit{RuleID: rule.ID, RuleName: rule.Name, Vendor: rule.Vendor}
+		for _, field := range updated.editableFields() {
+			if (field.name == RuleFieldCommand && target == BulkEditPattern) ||
+				(field.name == RuleFieldPattern && target == BulkEditCommand) {
+				continue
+			}
+			// Empty override patterns inherit the base pattern
+			before := field.get()
+			if before == "" {
+				continue
+			}
+			if after := rewrite(before); after != before {
+				field.set(after)
+				edit.Changes = append(edit.Changes, RuleFieldChange{Variant: field.variant, Field: field.name, Before: before, After: after})
+			}
+		}
+		if len(edit.Changes) == 0 {
+			continue
+		}
+
+		edit.Issues = newRuleIssues(CheckRuleHealth(rule, macros), CheckRuleHealth(updated, macros))
+		if len(edit.Issues) > 0 {
+			result.Invalid++
+		}
+		result.Edits = append(result.Edits, edit)
+	}
+
+	if dryRun || len(result.Edits) == 0 {
+		return result, nil
+	}
+	if result.Invalid > 0 {
+		return result, fmt.Errorf("bulk edit refused: %d edited rules would be invalid", result.Invalid)
+	}
+	return rm.ApplyRuleEdits(result.Edits)
+}
+
+// ApplyRuleEdits saves the changes of previewed edits exactly as shown. An
+// edit whose rule no longer holds the Before text fails with
+// ErrStaleRuleEdit. The edits are applied in one transaction, and only if
+// no edited rule is left with an issue it did not have before, such as a
+// pattern that no longer compiles or a command that now writes to the
+// device; a refused edit changes no rule.
+func (rm *RuleManager) ApplyRuleEdits(edits []RuleEdit) (*BulkEditResult, error) {
+	rules, err := rm.GetAllRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+	macros, err := NewMacroManager(rm.db).Commands()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]SecurityRule, len(rules))
+	for _, rule := range rules {
+		byID[rule.ID] = rule
+	}
+
+	result := &BulkEditResult{Edits: make([]RuleEdit, 0, len(edits))}
+	edited := make([]SecurityRule, 0, len(edits))
+	for _, edit := range edits {
+		rule, ok := byID[edit.RuleID]
+		if !ok {
+			return nil, fmt.Errorf("rule with ID %s not found", edit.RuleID)
+		}
+
+		updated := rule.editableCopy()
+		fields := updated.editableFields()
+		for _, change := range edit.Changes {
+			field, ok := findEditableField(fields, change)
+			if !ok || field.get() != change.Before {
+				return nil, fmt.Errorf("%w: %s %s of rule %s", ErrStaleRuleEdit, change.Variant, change.Field, rule.Name)
+			}
+			field.set(change.After)
+		}
+
+		edit.Issues = newRuleIssues(CheckRuleHealth(rule, macros), CheckRuleHealth(updated, macros))
+		if len(edit.Issues) > 0 {
+			result.Invalid++
+		}
+		result.Edits = append(result.Edits, edit)
+		edited = append(edited, updated)
+	}
+
+	if len(edited) == 0 {
+		return result, nil
+	}
+	if result.Invalid > 0 {
+		return result, fmt.Errorf("bulk edit refused: %d edited rules would be invalid", result.Invalid)
+	}
+
+	defer rm.rulesChanged()
+
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, rule := range edited {
+		overrides, err := encodeCommandOverrides(rule.CommandOverrides)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("UPDATE security_rules SET command = ?, expected_pattern = ?, command_overrides = ?, user_modified = predefined WHERE id = ?",
+			rule.Command, rule.ExpectedPattern, overrides, rule.ID); err != nil {
+			return nil, fmt.Errorf("failed to update rule %s: %w", rule.ID, err)
+		}
+		for _, override := range rule.VendorOverrides {
+			if _, err := tx.Exec("UPDATE rule_vendor_overrides SET command = ?, expected_pattern = ? WHERE rule_id = ? AND vendor = ?",
+				override.Command, nullableString(override.ExpectedPattern), rule.ID, override.Vendor); err != nil {
+				return nil, fmt.Errorf("failed to update %s override of rule %s: %w", override.Vendor, rule.ID, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	result.Applied = true
+	return result, nil
+}
+
+// editableField is a rule field a bulk edit can rewrite
+type editableField struct {
+	variant string
+	name    string
+	get     func() string
+	set     func(string)
+}
+
+// editableCopy returns a copy of the rule whose overrides can be edited
+// without changing the original
+func (r SecurityRule) editableCopy() SecurityRule {
+	updated := r
+	updated.CommandOverrides = maps.Clone(r.CommandOverrides)
+	updated.VendorOverrides = slices.Clone(r.VendorOverrides)
+	return updated
+}
+
+// editableFields lists the commands and patterns of the rule a bulk edit
+// rewrites, in a stable order
+func (r *SecurityRule) editableFields() []editableField {
+	fields := []editableField{
+		{VariantBase, RuleFieldCommand, func() string { return r.Command }, func(s string) { r.Command = s }},
+		{VariantBase, RuleFieldPattern, func() string { return r.ExpectedPattern }, func(s string) { r.ExpectedPattern = s }},
+	}
+	for _, deviceType := range slices.Sorted(maps.Keys(r.CommandOverrides)) {
+		fields = append(fields, editableField{VariantDeviceType + deviceType, RuleFieldCommand,
+			func() string { return r.CommandOverrides[deviceType] },
+			func(s string) { r.CommandOverrides[deviceType] = s }})
+	}
+	for i := range r.VendorOverrides {
+		override := &r.VendorOverrides[i]
+		fields = append(fields,
+			editableField{VariantVendor + override.Vendor, RuleFieldCommand,
+				func() string { return override.Command }, func(s string) { override.Command = s }},
+			editableField{VariantVendor + override.Vendor, RuleFieldPattern,
+				func() string { return override.ExpectedPattern }, func(s string) { override.ExpectedPattern = s }})
+	}
+	return fields
+}
+
+// findEditableField returns the field a change applies to
+func findEditableField(fields []editableField, change RuleFieldChange) (editableField, bool) {
+	for _, field := range fields {
+		if field.variant == change.Variant && field.name == change.Field {
+			return field, true
+		}
+	}
+	return editableField{}, false
+}
+
+// newRuleIssues returns the issues in after that are not in before
+func newRuleIssues(before, after []RuleIssue) []RuleIssue {
+	existing := make(map[RuleIssue]bool, len(before))
+	for _, issue := range before {
+		existing[issue] = true
+	}
+	var added []RuleIssue
+	for _, issue := range after {
+		if !existing[issue] {
+			added = append(added, issue)
+		}
+	}
+	return added
+}
@@ -0,0 +1,175 @@
+package checker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupBulkEditRules stores rules for bulk edit tests
+func setupBulkEditRules(t *testing.T) *RuleManager {
+	rm := setupTestRuleManager(t)
+	for _, rule := range []SecurityRule{
+		{ID: "ssh-version", Name: "SSH Version", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "ip ssh version 2",
+			Severity: string(SeverityHigh), Enabled: true, Category: CategoryManagementAccess,
+			CommandOverrides: map[string]string{"switch": "show ip ssh"}},
+		{ID: "ssh-timeout", Name: "SSH Timeout", Vendor: "cisco", Command: "show run | include ip ssh time-out",
+			ExpectedPattern: `ip ssh time-out (\d+)`, Severity: string(SeverityLow), Enabled: true},
+		{ID: "juniper-ssh", Name: "Juniper SSH", Vendor: "juniper", Command: "show ip ssh",
+			ExpectedPattern: "ssh", Severity: string(SeverityMedium), Enabled: true},
+		{ID: "banner", Name: "Banner", Vendor: "cisco", Command: "show banner motd",
+			ExpectedPattern: "Authorized", Severity: string(SeverityLow), Enabled: true},
+	} {
+		require.NoError(t, rm.CreateRule(rule))
+	}
+	return rm
+}
+
+func TestRuleManager_BulkEditRules_Literal(t *testing.T) {
+	rm := setupBulkEditRules(t)
+	before, err := rm.GetAllRules()
+	require.NoError(t, err)
+
+	preview, err := rm.BulkEditRules(RuleFilter{Vendor: "cisco"}, "ip ssh", "ip ssh server", BulkEditCommand, false, true)
+	require.NoError(t, err)
+	assert.False(t, preview.Applied)
+	assert.Zero(t, preview.Invalid)
+	assert.Equal(t, []string{"ssh-timeout", "ssh-version"}, preview.RuleIDs())
+	assert.Equal(t, []RuleFieldChange{
+		{Variant: VariantBase, Field: RuleFieldCommand, Before: "show ip ssh", After: "show ip ssh server"},
+		{Variant: VariantDeviceType + "switch", Field: RuleFieldCommand, Before: "show ip ssh", After: "show ip ssh server"},
+	}, preview.Edits[1].Changes, "device type overrides are edited too")
+
+	unchanged, err := rm.GetAllRules()
+	require.NoError(t, err)
+	assert.Equal(t, before, unchanged, "a preview changes nothing")
+
+	applied, err := rm.BulkEditRules(RuleFilter{Vendor: "cisco"}, "ip ssh", "ip ssh server", BulkEditCommand, false, false)
+	require.NoError(t, err)
+	assert.True(t, applied.Applied)
+	assert.Equal(t, preview.Edits, applied.Edits, "the preview shows what is applied")
+
+	after, err := rm.GetAllRules()
+	require.NoError(t, err)
+	require.Len(t, after, len(before))
+	for i, rule := range after {
+		expected := before[i]
+		for _, edit := range preview.Edits {
+			if edit.RuleID == rule.ID {
+				expected.Command = edit.Changes[0].After
+			}
+		}
+		if rule.ID == "ssh-version" {
+			expected.CommandOverrides = map[string]string{"switch": "show ip ssh server"}
+		}
+		assert.Equal(t, expected, rule, "only the commands of edited rules change")
+	}
+
+	// Literal mode does not treat the text as a pattern
+	result, err := rm.BulkEditRules(RuleFilter{}, `(\d+)`, "N", BulkEditPattern, false, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh-timeout"}, result.RuleIDs())
+	rule, err := rm.GetRule("ssh-timeout")
+	require.NoError(t, err)
+	assert.Equal(t, "ip ssh time-out N", rule.ExpectedPattern)
+}
+
+func TestRuleManager_BulkEditRules_Regex(t *testing.T) {
+	rm := setupBulkEditRules(t)
+
+	result, err := rm.BulkEditRules(RuleFilter{}, `^show ip (\w+)$`, "show $1 server", BulkEditBoth, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh-version", "juniper-ssh"}, result.RuleIDs())
+
+	rule, err := rm.GetRule("juniper-ssh")
+	require.NoError(t, err)
+	assert.Equal(t, "show ssh server", rule.Command)
+	assert.Equal(t, "ssh", rule.ExpectedPattern, "patterns not matching are kept")
+
+	result, err = rm.BulkEditRules(RuleFilter{RuleIDs: []string{"banner"}, Category: CategoryManagementAccess},
+		"motd", "login", BulkEditCommand, true, false)
+	require.NoError(t, err)
+	assert.Empty(t, result.Edits, "the filter must match every criterion")
+
+	_, err = rm.BulkEditRules(RuleFilter{}, "(", "x", BulkEditBoth, true, true)
+	assert.Error(t, err)
+	_, err = rm.BulkEditRules(RuleFilter{}, "", "x", BulkEditBoth, false, true)
+	assert.Error(t, err)
+	_, err = rm.BulkEditRules(RuleFilter{}, "x", "y", "name", false, true)
+	assert.Error(t, err)
+}
+
+func TestRuleManager_BulkEditRules_RefusesInvalidEdits(t *testing.T) {
+	rm := setupBulkEditRules(t)
+	before, err := rm.GetAllRules()
+	require.NoError(t, err)
+
+	// Every pattern mentioning ssh stops compiling
+	preview, err := rm.BulkEditRules(RuleFilter{}, "ssh", "ssh (", BulkEditPattern, false, true)
+	require.NoError(t, err)
+	assert.Equal(t, 3, preview.Invalid)
+	for _, edit := range preview.Edits {
+		require.NotEmpty(t, edit.Issues)
+		assert.Equal(t, RuleIssueInvalidPattern, edit.Issues[0].Kind)
+	}
+
+	result, err := rm.BulkEditRules(RuleFilter{Vendor: "cisco"}, "show", "configure terminal\nshow", BulkEditCommand, false, false)
+	require.Error(t, err)
+	assert.False(t, result.Applied)
+	assert.Equal(t, 3, result.Invalid)
+	assert.Equal(t, RuleIssueRiskyCommand, result.Edits[0].Issues[0].Kind)
+
+	after, err := rm.GetAllRules()
+	require.NoError(t, err)
+	assert.Equal(t, before, after, "a refused edit changes no rule")
+}
+
+func TestRuleManager_BulkEditRules_VendorOverrides(t *testing.T) {
+	rm := setupBulkEditRules(t)
+	require.NoError(t, rm.SetVendorOverride("banner", VendorOverride{Vendor: "juniper", Command: "show system login message",
+		ExpectedPattern: "Authorized only"}))
+
+	result, err := rm.BulkEditRules(RuleFilter{RuleIDs: []string{"banner"}}, "Authorized", "Authorised", BulkEditPattern, false, false)
+	require.NoError(t, err)
+	require.Len(t, result.Edits, 1)
+	assert.Equal(t, []RuleFieldChange{
+		{Variant: VariantBase, Field: RuleFieldPattern, Before: "Authorized", After: "Authorised"},
+		{Variant: VariantVendor + "juniper", Field: RuleFieldPattern, Before: "Authorized only", After: "Authorised only"},
+	}, result.Edits[0].Changes)
+
+	overrides, err := rm.GetVendorOverrides("banner")
+	require.NoError(t, err)
+	assert.Equal(t, []VendorOverride{{Vendor: "juniper", Command: "show system login message", ExpectedPattern: "Authorised only"}}, overrides)
+}
+
+func TestRuleManager_ApplyRuleEdits(t *testing.T) {
+	rm := setupBulkEditRules(t)
+
+	preview, err := rm.BulkEditRules(RuleFilter{RuleIDs: []string{"banner", "ssh-timeout"}}, "show", "display", BulkEditCommand, false, true)
+	require.NoError(t, err)
+	require.Len(t, preview.Edits, 2)
+
+	// A rule changed after the preview refuses the whole edit
+	rule, err := rm.GetRule("banner")
+	require.NoError(t, err)
+	rule.Command = "show banner login"
+	require.NoError(t, rm.UpdateRule(*rule))
+
+	_, err = rm.ApplyRuleEdits(preview.Edits)
+	assert.ErrorIs(t, err, ErrStaleRuleEdit)
+	unchanged, err := rm.GetRule("ssh-timeout")
+	require.NoError(t, err)
+	assert.Equal(t, "show run | include ip ssh time-out", unchanged.Command)
+
+	// The edit of the untouched rule still applies as shown
+	applied, err := rm.ApplyRuleEdits(preview.Edits[1:])
+	require.NoError(t, err)
+	assert.True(t, applied.Applied)
+	edited, err := rm.GetRule("ssh-timeout")
+	require.NoError(t, err)
+	assert.Equal(t, "display run | include ip ssh time-out", edited.Command)
+
+	_, err = rm.ApplyRuleEdits([]RuleEdit{{RuleID: "missing"}})
+	assert.Error(t, err)
+}
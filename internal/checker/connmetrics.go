@@ -0,0 +1,328 @@
+package checker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+)
+
+// PhaseHandshake is the aggregate of the banner, key exchange and auth
+// phases, which slowest-handshake listings rank devices by
+const PhaseHandshake = "handshake"
+
+// connectionMetricBuckets are the upper bounds in milliseconds of the
+// histogram buckets phase timings are counted in. Longer timings go to one
+// more overflow bucket.
+var connectionMetricBuckets = []int64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// PhaseStats summarizes the recorded timings of one phase. Percentiles are
+// estimated from histogram buckets as the upper bound of the bucket holding
+// the percentile, capped at the slowest timing seen, so they may overstate
+// the true value by up to one bucket but never understate it.
+type PhaseStats struct {
+	Phase     string `json:"phase"`
+	Samples   int64  `json:"samples"`
+	AverageMs int64  `json:"averageMs"`
+	P50Ms     int64  `json:"p50Ms"`
+	P95Ms     int64  `json:"p95Ms"`
+	MaxMs     int64  `json:"maxMs"`
+}
+
+// DeviceConnectionMetrics is the connection timing history of a device.
+// Recent holds the last attempts kept in memory by the SSH client, while
+// Phases aggregates every recorded connection. Backoff is how check runs
+// currently wait between connection attempts to the device.
+type DeviceConnectionMetrics struct {
+	DeviceID string                  `json:"deviceId"`
+	Phases   []PhaseStats            `json:"phases"`
+	Recent   []ssh.ConnectionAttempt `json:"recent"`
+	Backoff  *ssh.HostBackoff        `json:"backoff,omitempty"`
+}
+
+// adaptiveRetrier is implemented by SSH clients whose retry delays adapt to
+// each host, as ssh.SSHClient does
+type adaptiveRetrier interface {
+	SetAdaptiveRetry(enabled bool)
+	AdaptiveRetry() bool
+	RetryBackoff(host string, port int) ssh.HostBackoff
+}
+
+// SetAdaptiveRetry switches the engine's SSH client between retry delays
+// adapted to each host and a fixed delay. Clients without adaptive retry
+// are left as they are.
+func (e *Engine) SetAdaptiveRetry(enabled bool) {
+	if client, ok := e.sshClient.(adaptiveRetrier); ok {
+		client.SetAdaptiveRetry(enabled)
+	}
+}
+
+// AdaptiveRetry reports whether the engine's SSH client adapts its retry
+// delays to each host
+func (e *Engine) AdaptiveRetry() bool {
+	client, ok := e.sshClient.(adaptiveRetrier)
+	return ok && client.AdaptiveRetry()
+}
+
+// RetryBackoff returns how the engine's SSH client waits between connection
+// attempts to a device, or nil when the client does not report it
+func (e *Engine) RetryBackoff(dev *device.Device) *ssh.HostBackoff {
+	client, ok := e.sshClient.(adaptiveRetrier)
+	if !ok {
+		return nil
+	}
+	backoff := client.RetryBackoff(dev.IPAddress, dev.SSHPort)
+	return &backoff
+}
+
+// SlowHandshake ranks a device in a slowest-handshakes listing. DeviceName
+// is left for callers to fill in, as the store only knows device IDs.
+type SlowHandshake struct {
+	DeviceID   string `json:"deviceId"`
+	DeviceName string `json:"deviceName"`
+	PhaseStats
+}
+
+// phaseHistogram counts the timings of one phase of one device
+type phaseHistogram struct {
+	Samples int64
+	TotalMs int64
+	MaxMs   int64
+	Buckets []int64
+}
+
+// add counts one timing
+func (h *phaseHistogram) add(d time.Duration) {
+	if len(h.Buckets) != len(connectionMetricBuckets)+1 {
+		buckets := make([]int64, len(connectionMetricBuckets)+1)
+		copy(buckets, h.Buckets)
+		h.Buckets = buckets
+	}
+
+	ms := d.Milliseconds()
+	bucket := sort.Search(len(connectionMetricBuckets), func(i int) bool {
+		return connectionMetricBuckets[i] >= ms
+	})
+	h.Buckets[bucket]++
+	h.Samples++
+	h.TotalMs += ms
+	if ms > h.MaxMs {
+		h.MaxMs = ms
+	}
+}
+
+// percentile estimates the timing below which p of the samples fall
+func (h *phaseHistogram) percentile(p float64) int64 {
+	if h.Samples == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p * float64(h.Samples)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, count := range h.Buckets {
+		seen += count
+		if seen < rank {
+			continue
+		}
+		if i < len(connectionMetricBuckets) && connectionMetricBuckets[i] < h.MaxMs {
+			return connectionMetricBuckets[i]
+		}
+		return h.MaxMs
+	}
+	return h.MaxMs
+}
+
+// stats summarizes the histogram
+func (h *phaseHistogram) stats(phase string) PhaseStats {
+	stats := PhaseStats{Phase: phase, Samples: h.Samples, MaxMs: h.MaxMs}
+	if h.Samples > 0 {
+		stats.AverageMs = h.TotalMs / h.Samples
+		stats.P50Ms = h.percentile(0.50)
+		stats.P95Ms = h.percentile(0.95)
+	}
+	return stats
+}
+
+// ConnectionMetricsStore keeps per-device histograms of connection phase
+// timings, updated as each connection is recorded
+type ConnectionMetricsStore struct {
+	db *sql.DB
+}
+
+// NewConnectionMetricsStore creates a new connection metrics store
+func NewConnectionMetricsStore(db *sql.DB) *ConnectionMetricsStore {
+	return &ConnectionMetricsStore{db: db}
+}
+
+// Record adds the timed phases of a connection to the device's histograms.
+// Phases that were not timed are left out, and the handshake is recorded as
+// PhaseHandshake when any of its phases was timed.
+func (cs *ConnectionMetricsStore) Record(deviceID string, timings ssh.PhaseTimings) error {
+	if strings.TrimSpace(deviceID) == "" {
+		return fmt.Errorf("device ID cannot be empty")
+	}
+
+	phases := make(map[string]time.Duration)
+	for _, phase := range ssh.Phases {
+		if d := timings.Get(phase); d > 0 {
+			phases[phase] = d
+		}
+	}
+	if d := timings.Handshake(); d > 0 {
+		phases[PhaseHandshake] = d
+	}
+	if len(phases) == 0 {
+		return nil
+	}
+
+	tx, err := cs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for phase, d := range phases {
+		var histogram phaseHistogram
+		var buckets string
+		err := tx.QueryRow(`
+			SELECT samples, total_ms, max_ms, buckets FROM device_connection_metrics
+			WHERE device_id = ? AND phase = ?
+		`, deviceID, phase).Scan(&histogram.Samples, &histogram.TotalMs, &histogram.MaxMs, &buckets)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to read %s timings: %w", phase, err)
+		}
+		if buckets != "" {
+			if err := json.Unmarshal([]byte(buckets), &histogram.Buckets); err != nil {
+				return fmt.Errorf("failed to decode %s timings: %w", phase, err)
+			}
+		}
+
+		histogram.add(d)
+		encoded, err := json.Marshal(histogram.Buckets)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO device_connection_metrics (device_id, phase, samples, total_ms, max_ms, buckets, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(device_id, phase) DO UPDATE SET
+				samples = excluded.samples,
+				total_ms = excluded.total_ms,
+				max_ms = excluded.max_ms,
+				buckets = excluded.buckets,
+				updated_at = excluded.updated_at
+		`, deviceID, phase, histogram.Samples, histogram.TotalMs, histogram.MaxMs, string(encoded), time.Now()); err != nil {
+			return fmt.Errorf("failed to save %s timings: %w", phase, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get returns the phase statistics of a device, in phase order followed by
+// the handshake. Devices without recorded connections have none.
+func (cs *ConnectionMetricsStore) Get(deviceID string) ([]PhaseStats, error) {
+	histograms, err := cs.query(`WHERE device_id = ?`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []PhaseStats{}
+	for _, phase := range append(append([]string{}, ssh.Phases...), PhaseHandshake) {
+		for _, h := range histograms {
+			if h.phase == phase {
+				stats = append(stats, h.stats(phase))
+			}
+		}
+	}
+	return stats, nil
+}
+
+// SlowestHandshakes returns the devices with the slowest 95th percentile
+// handshake, slowest first
+func (cs *ConnectionMetricsStore) SlowestHandshakes(limit int) ([]SlowHandshake, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	histograms, err := cs.query(`WHERE phase = ?`, PhaseHandshake)
+	if err != nil {
+		return nil, err
+	}
+
+	slowest := make([]SlowHandshake, 0, len(histograms))
+	for _, h := range histograms {
+		slowest = append(slowest, SlowHandshake{DeviceID: h.deviceID, PhaseStats: h.stats(PhaseHandshake)})
+	}
+	sort.SliceStable(slowest, func(i, j int) bool {
+		if slowest[i].P95Ms != slowest[j].P95Ms {
+			return slowest[i].P95Ms > slowest[j].P95Ms
+		}
+		return slowest[i].MaxMs > slowest[j].MaxMs
+	})
+	if len(slowest) > limit {
+		slowest = slowest[:limit]
+	}
+	return slowest, nil
+}
+
+// storedHistogram is a histogram read back with the device it belongs to
+type storedHistogram struct {
+	deviceID string
+	phase    string
+	phaseHistogram
+}
+
+// query reads histograms matching a WHERE clause
+func (cs *ConnectionMetricsStore) query(where string, args ...interface{}) ([]storedHistogram, error) {
+	rows, err := cs.db.Query(`
+		SELECT device_id, phase, samples, total_ms, max_ms, buckets
+		FROM device_connection_metrics `+where+`
+		ORDER BY device_id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query connection metrics: %w", err)
+	}
+	defer rows.Close()
+
+	var histograms []storedHistogram
+	for rows.Next() {
+		var h storedHistogram
+		var buckets string
+		if err := rows.Scan(&h.deviceID, &h.phase, &h.Samples, &h.TotalMs, &h.MaxMs, &buckets); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(buckets), &h.Buckets); err != nil {
+			return nil, fmt.Errorf("failed to decode %s timings: %w", h.phase, err)
+		}
+		histograms = append(histograms, h)
+	}
+	return histograms, rows.Err()
+}
+
+// SetConnectionMetricsStore records the phase timings of every connection
+// the engine makes when set
+func (e *Engine) SetConnectionMetricsStore(store *ConnectionMetricsStore) {
+	e.connectionMetrics = store
+}
+
+// recordTimings adds the phase timings of a connection to the device's
+// metrics. Failures are logged, as they must not fail the check.
+func (e *Engine) recordTimings(device *device.Device, timings ssh.PhaseTimings) {
+	if e.connectionMetrics == nil || timings.IsZero() {
+		return
+	}
+	if err := e.connectionMetrics.Record(device.ID, timings); err != nil {
+		log.Printf("Failed to record connection timings of device %s: %v", device.ID, err)
+	}
+}
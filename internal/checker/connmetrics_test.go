@@ -0,0 +1,142 @@
+package checker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPhaseHistogram_Percentiles(t *testing.T) {
+	var h phaseHistogram
+	for ms := 1; ms <= 100; ms++ {
+		h.add(time.Duration(ms) * time.Millisecond)
+	}
+	stats := h.stats(ssh.PhaseAuth)
+	assert.Equal(t, PhaseStats{Phase: ssh.PhaseAuth, Samples: 100, AverageMs: 50, P50Ms: 50, P95Ms: 100, MaxMs: 100}, stats)
+
+	// Estimates never exceed the slowest timing seen
+	var small phaseHistogram
+	small.add(3 * time.Millisecond)
+	small.add(4 * time.Millisecond)
+	assert.Equal(t, int64(4), small.percentile(0.50))
+	assert.Equal(t, int64(4), small.percentile(0.95))
+
+	// Timings past the last bucket are reported as the maximum
+	var slow phaseHistogram
+	for i := 0; i < 19; i++ {
+		slow.add(200 * time.Millisecond)
+	}
+	slow.add(90 * time.Second)
+	assert.Equal(t, int64(250), slow.percentile(0.95))
+	assert.Equal(t, int64(90000), slow.percentile(1))
+
+	assert.Equal(t, PhaseStats{Phase: ssh.PhaseDial}, (&phaseHistogram{}).stats(ssh.PhaseDial))
+}
+
+func TestConnectionMetricsStore(t *testing.T) {
+	store := NewConnectionMetricsStore(setupTestDB(t))
+
+	require.NoError(t, store.Record("router", ssh.PhaseTimings{Dial: 4 * time.Millisecond,
+		Banner: 20 * time.Millisecond, KeyExchange: 30 * time.Millisecond, Auth: 700 * time.Millisecond}))
+	require.NoError(t, store.Record("router", ssh.PhaseTimings{Dial: 8 * time.Millisecond,
+		Banner: 10 * time.Millisecond, KeyExchange: 40 * time.Millisecond, Auth: 100 * time.Millisecond,
+		Session: 3 * time.Millisecond, Command: 90 * time.Millisecond}))
+	require.NoError(t, store.Record("router", ssh.PhaseTimings{}), "untimed connections are ignored")
+	require.NoError(t, store.Record("switch", ssh.PhaseTimings{Dial: 2 * time.Millisecond,
+		Banner: 5 * time.Millisecond, KeyExchange: 5 * time.Millisecond, Auth: 5 * time.Millisecond}))
+	assert.Error(t, store.Record("", ssh.PhaseTimings{Dial: time.Millisecond}))
+
+	stats, err := store.Get("router")
+	require.NoError(t, err)
+	var phases []string
+	for _, s := range stats {
+		phases = append(phases, s.Phase)
+	}
+	assert.Equal(t, []string{ssh.PhaseDial, ssh.PhaseBanner, ssh.PhaseKeyExchange, ssh.PhaseAuth,
+		ssh.PhaseSession, ssh.PhaseCommand, PhaseHandshake}, phases)
+
+	auth := stats[3]
+	assert.Equal(t, int64(2), auth.Samples)
+	assert.Equal(t, int64(400), auth.AverageMs)
+	assert.Equal(t, int64(100), auth.P50Ms)
+	assert.Equal(t, int64(700), auth.P95Ms)
+	assert.Equal(t, int64(700), auth.MaxMs)
+
+	assert.Equal(t, int64(1), stats[4].Samples, "session was timed once")
+	handshake := stats[6]
+	assert.Equal(t, int64(2), handshake.Samples)
+	assert.Equal(t, int64(750), handshake.MaxMs)
+
+	none, err := store.Get("unknown")
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	slowest, err := store.SlowestHandshakes(10)
+	require.NoError(t, err)
+	require.Len(t, slowest, 2)
+	assert.Equal(t, "router", slowest[0].DeviceID)
+	assert.Equal(t, "switch", slowest[1].DeviceID)
+	assert.Equal(t, int64(15), slowest[1].MaxMs)
+
+	slowest, err = store.SlowestHandshakes(1)
+	require.NoError(t, err)
+	assert.Len(t, slowest, 1)
+}
+
+// timedSSHClient returns connections and command results carrying fixed
+// phase timings
+type timedSSHClient struct {
+	stubSSHClient
+	connect ssh.PhaseTimings
+	command ssh.PhaseTimings
+}
+
+func (c *timedSSHClient) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	return ssh.NewTimedConnectionForTesting(ssh.Handshake{}, c.connect), nil
+}
+
+func (c *timedSSHClient) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	result, err := c.stubSSHClient.ExecuteCommand(ctx, conn, command)
+	result.Timings = c.command
+	return result, err
+}
+
+func TestEngine_RecordsConnectionTimings(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &timedSSHClient{
+		stubSSHClient: stubSSHClient{outputs: map[string]string{"show version": "Cisco IOS"}},
+		connect:       ssh.PhaseTimings{Dial: 5 * time.Millisecond, Banner: 10 * time.Millisecond, Auth: 2 * time.Second},
+		command:       ssh.PhaseTimings{Session: time.Millisecond, Command: 30 * time.Millisecond},
+	}
+	engine := NewEngineWithSSHClient(rm, client)
+	store := NewConnectionMetricsStore(rm.db)
+	engine.SetConnectionMetricsStore(store)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "rule1", Name: "Version Check", Vendor: "cisco", Command: "show version", ExpectedPattern: "IOS",
+			Severity: string(SeverityHigh), Enabled: true},
+	}))
+
+	dev := &device.Device{ID: "device1", Name: "Test Device", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22}
+	results, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, client.connect.Merge(client.command), results[0].Phases, "the result carries its phase timings")
+
+	stats, err := store.Get(dev.ID)
+	require.NoError(t, err)
+	recorded := make(map[string]PhaseStats)
+	for _, s := range stats {
+		recorded[s.Phase] = s
+	}
+	assert.NotContains(t, recorded, ssh.PhaseKeyExchange, "untimed phases are not recorded")
+	assert.Equal(t, int64(2000), recorded[ssh.PhaseAuth].MaxMs)
+	assert.Equal(t, int64(30), recorded[ssh.PhaseCommand].MaxMs)
+	assert.Equal(t, int64(2010), recorded[PhaseHandshake].MaxMs)
+}
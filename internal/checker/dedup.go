@@ -0,0 +1,374 @@
+package checker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RuleDuplicateKind classifies a cluster of redundant rules
+type RuleDuplicateKind string
+
+const (
+	// DuplicateExact rules share vendor, command, pattern and match mode
+	DuplicateExact RuleDuplicateKind = "exact"
+	// DuplicateNear rules differ only in whitespace or pattern anchors
+	DuplicateNear RuleDuplicateKind = "near"
+	// DuplicateShadowed vendor rules repeat a generic rule that already runs
+	// on that vendor's devices
+	DuplicateShadowed RuleDuplicateKind = "shadowed"
+)
+
+// mergedRulesKey is the app_settings key holding the predefined rules merged
+// away by MergeRules, as a JSON map of "vendor/name" and, for rules with a
+// stable ID, of that ID to the kept rule ID, so LoadPredefinedRules does not
+// recreate them
+const mergedRulesKey = "merged_rules"
+
+// RuleCluster is a group of rules that check the same thing. Merging
+// RemoveIDs into CanonicalID removes the redundancy.
+type RuleCluster struct {
+	Kind        RuleDuplicateKind `json:"kind"`
+	CanonicalID string            `json:"canonicalId"`
+	RemoveIDs   []string          `json:"removeIds"`
+	Rules       []SecurityRule    `json:"rules"`
+	Reason      string            `json:"reason"`
+}
+
+// RuleDuplicateReport lists the redundant rule clusters found by a
+// RuleDeduplicator
+type RuleDuplicateReport struct {
+	RulesAnalyzed int           `json:"rulesAnalyzed"`
+	Clusters      []RuleCluster `json:"clusters"`
+	AnalyzedAt    time.Time     `json:"analyzedAt"`
+}
+
+// RuleDeduplicator finds duplicate and shadowed security rules
+type RuleDeduplicator struct {
+	ruleManager *RuleManager
+}
+
+// NewRuleDeduplicator creates a deduplicator over the rules of a rule manager
+func NewRuleDeduplicator(ruleManager *RuleManager) *RuleDeduplicator {
+	return &RuleDeduplicator{ruleManager: ruleManager}
+}
+
+// Analyze groups the stored rules into clusters of exact duplicates, near
+// duplicates and vendor rules shadowed by a generic rule. Rules related to
+// each other in several ways end up in one cluster, classified by its
+// strongest relation: shadowed, then near, then exact.
+func (d *RuleDeduplicator) Analyze() (*RuleDuplicateReport, error) {
+	rules, err := d.ruleManager.GetAllRules()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RuleDuplicateReport{
+		RulesAnalyzed: len(rules),
+		Clusters:      findRuleClusters(rules),
+		AnalyzedAt:    time.Now(),
+	}, nil
+}
+
+// findRuleClusters links every related pair of rules and returns the
+// connected groups of more than one rule
+func findRuleClusters(rules []SecurityRule) []RuleCluster {
+	parent := make([]int, len(rules))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	kinds := make(map[int]RuleDuplicateKind)
+	type relation struct {
+		rule int
+		kind RuleDuplicateKind
+	}
+	var relations []relation
+	for i := range rules {
+		for j := i + 1; j < len(rules); j++ {
+			kind, ok := ruleRelation(rules[i], rules[j])
+			if !ok {
+				continue
+			}
+			parent[find(i)] = find(j)
+			relations = append(relations, relation{rule: i, kind: kind})
+		}
+	}
+	for _, rel := range relations {
+		root := find(rel.rule)
+		kinds[root] = strongerKind(kinds[root], rel.kind)
+	}
+
+	groups := make(map[int][]SecurityRule)
+	var roots []int
+	for i := range rules {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], rules[i])
+	}
+
+	clusters := []RuleCluster{}
+	for _, root := range roots {
+		members := groups[root]
+		if len(members) < 2 {
+			continue
+		}
+		clusters = append(clusters, newRuleCluster(kinds[root], members))
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].CanonicalID < clusters[j].CanonicalID
+	})
+	return clusters
+}
+
+// ruleRelation reports how two rules duplicate each other, if they do
+func ruleRelation(a, b SecurityRule) (RuleDuplicateKind, bool) {
+	if a.Vendor == b.Vendor {
+		if ruleKey(a, false) == ruleKey(b, false) {
+			return DuplicateExact, true
+		}
+		if ruleKey(a, true) == ruleKey(b, true) {
+			return DuplicateNear, true
+		}
+		return "", false
+	}
+
+	generic, specific := a, b
+	if specific.Vendor == "generic" {
+		generic, specific = b, a
+	}
+	if generic.Vendor != "generic" || len(specific.VendorOverrides) > 0 {
+		return "", false
+	}
+
+	// Compare with what the generic rule runs on the vendor's devices
+	effective, _ := generic.ForDevice(specific.Vendor, "")
+	effective.VendorOverrides = nil
+	if ruleKey(effective, false) == ruleKey(specific, false) {
+		return DuplicateShadowed, true
+	}
+	return "", false
+}
+
+// ruleKey describes what a rule checks. The near form collapses whitespace
+// and drops pattern anchors.
+func ruleKey(rule SecurityRule, near bool) string {
+	command, pattern := rule.Command, rule.ExpectedPattern
+	if near {
+		command = strings.Join(strings.Fields(command), " ")
+		pattern = strings.Join(strings.Fields(pattern), " ")
+		pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, `\A`), "^")
+		pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, `\z`), "$")
+	}
+
+	overrides, _ := json.Marshal(rule.CommandOverrides)
+	vendorOverrides := make([]string, 0, len(rule.VendorOverrides))
+	for _, override := range rule.VendorOverrides {
+		vendorOverrides = append(vendorOverrides, override.Vendor+"\x00"+override.Command+"\x00"+override.ExpectedPattern)
+	}
+	sort.Strings(vendorOverrides)
+
+	exitCode := ""
+	if rule.ExpectedExitCode != nil {
+		exitCode = strconv.Itoa(*rule.ExpectedExitCode)
+	}
+
+	precondition := ""
+	if rule.Precondition != nil {
+		precondition = rule.Precondition.Command + "\x00" + rule.Precondition.Pattern
+	}
+
+	return strings.Join([]string{command, pattern, fmt.Sprint(rule.AllMatch), rule.SectionPattern,
+		string(overrides), strings.Join(vendorOverrides, "\x01"), exitCode, rule.streamOf(), precondition}, "\x02")
+}
+
+// strongerKind returns the kind that describes a cluster holding both
+func strongerKind(a, b RuleDuplicateKind) RuleDuplicateKind {
+	rank := map[RuleDuplicateKind]int{DuplicateExact: 1, DuplicateNear: 2, DuplicateShadowed: 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// newRuleCluster picks the rule to keep: a generic rule when vendor rules are
+// shadowed, then enabled rules, predefined rules, the newest rule version and
+// finally the oldest rule
+func newRuleCluster(kind RuleDuplicateKind, rules []SecurityRule) RuleCluster {
+	predefined := make(map[string]bool)
+	for _, rule := range GetPredefinedRules() {
+		predefined[rule.Vendor+"/"+rule.Name] = true
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if kind == DuplicateShadowed && (a.Vendor == "generic") != (b.Vendor == "generic") {
+			return a.Vendor == "generic"
+		}
+		if a.Enabled != b.Enabled {
+			return a.Enabled
+		}
+		if pa, pb := predefined[a.Vendor+"/"+a.Name], predefined[b.Vendor+"/"+b.Name]; pa != pb {
+			return pa
+		}
+		if a.RuleVersion != b.RuleVersion {
+			return a.RuleVersion > b.RuleVersion
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+
+	cluster := RuleCluster{Kind: kind, CanonicalID: rules[0].ID, Rules: rules}
+	for _, rule := range rules[1:] {
+		cluster.RemoveIDs = append(cluster.RemoveIDs, rule.ID)
+	}
+
+	switch kind {
+	case DuplicateShadowed:
+		cluster.Reason = fmt.Sprintf("generic rule %q already runs the same check on these vendors", rules[0].Name)
+	case DuplicateNear:
+		cluster.Reason = fmt.Sprintf("same check as %q apart from whitespace or pattern anchors", rules[0].Name)
+	default:
+		cluster.Reason = fmt.Sprintf("same vendor, command, pattern and match mode as %q", rules[0].Name)
+	}
+	return cluster
+}
+
+// MergeRules deletes the rules in removeIDs in favor of keepID. Stored
+// results and skipped-rule records of the removed rules are repointed to
+// the kept rule by ID, so their history stays attached to it; results keep
+// the check name they were recorded under. Predefined rules that
+// are merged away are not recreated by LoadPredefinedRules.
+func (rm *RuleManager) MergeRules(keepID string, removeIDs []string) error {
+	defer rm.rulesChanged()
+
+	if len(removeIDs) == 0 {
+		return fmt.Errorf("no rules to merge")
+	}
+
+	keep, err := rm.GetRule(keepID)
+	if err != nil {
+		return err
+	}
+
+	removed := make([]*SecurityRule, 0, len(removeIDs))
+	removing := make(map[string]bool, len(removeIDs))
+	for _, id := range removeIDs {
+		if id == keepID {
+			return fmt.Errorf("rule %s cannot be merged into itself", id)
+		}
+		if removing[id] {
+			continue
+		}
+		rule, err := rm.GetRule(id)
+		if err != nil {
+			return err
+		}
+		removing[id] = true
+		removed = append(removed, rule)
+	}
+
+	predefined := make(map[string]bool)
+	for _, rule := range GetPredefinedRules() {
+		predefined[rule.Vendor+"/"+rule.Name] = true
+	}
+
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	merged, err := readMergedRules(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range removed {
+		// Results keep the name they were checked under
+		if _, err := tx.Exec("UPDATE check_results SET rule_id = ? WHERE rule_id = ?", keep.ID, rule.ID); err != nil {
+			return fmt.Errorf("failed to repoint results of rule %s: %w", rule.Name, err)
+		}
+		if _, err := tx.Exec("UPDATE skipped_rules SET rule_id = ? WHERE rule_id = ?", keep.ID, rule.ID); err != nil {
+			return fmt.Errorf("failed to repoint skipped records of rule %s: %w", rule.Name, err)
+		}
+		if _, err := tx.Exec("DELETE FROM rule_vendor_overrides WHERE rule_id = ?", rule.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM rule_command_acknowledgements WHERE rule_id = ?", rule.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM security_rules WHERE id = ?", rule.ID); err != nil {
+			return fmt.Errorf("failed to delete rule %s: %w", rule.Name, err)
+		}
+		if rule.Predefined {
+			merged[rule.ID] = keep.ID
+		}
+		if rule.Predefined || predefined[rule.Vendor+"/"+rule.Name] {
+			merged[rule.Vendor+"/"+rule.Name] = keep.ID
+		}
+	}
+
+	if err := saveMergedRules(tx, merged); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// saveMergedRules stores the predefined rules merged away within a transaction
+func saveMergedRules(tx *sql.Tx, merged map[string]string) error {
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("failed to encode merged rules: %w", err)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO app_settings (key, value, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`, mergedRulesKey, string(data)); err != nil {
+		return fmt.Errorf("failed to save merged rules: %w", err)
+	}
+	return nil
+}
+
+// queryRower is satisfied by *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// readMergedRules returns the predefined rules merged away, keyed by
+// "vendor/name" and by stable ID
+func readMergedRules(db queryRower) (map[string]string, error) {
+	merged := make(map[string]string)
+
+	var value string
+	err := db.QueryRow("SELECT value FROM app_settings WHERE key = ?", mergedRulesKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return merged, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read merged rules: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(value), &merged); err != nil {
+		return nil, fmt.Errorf("invalid merged rules: %w", err)
+	}
+	return merged, nil
+}
@@ -0,0 +1,153 @@
+package checker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// duplicateFixtures returns rules with one exact pair, one near pair, one
+// vendor rule shadowed by a generic rule and rules that only look related
+func duplicateFixtures() []SecurityRule {
+	base := time.Now().Add(-time.Hour)
+	rule := func(id, name, vendor, command, pattern string, offset time.Duration) SecurityRule {
+		return SecurityRule{ID: id, Name: name, Vendor: vendor, Command: command, ExpectedPattern: pattern,
+			Severity: string(SeverityHigh), Enabled: true, CreatedAt: base.Add(offset)}
+	}
+
+	return []SecurityRule{
+		rule("ssh-a", "SSH version", "cisco", "show ip ssh", `version 2`, 0),
+		rule("ssh-b", "SSH version (imported)", "cisco", "show ip ssh", `version 2`, time.Minute),
+		rule("banner-a", "Login banner", "cisco", "show running-config | include banner", `banner (login|motd)`, 0),
+		rule("banner-b", "Banner", "cisco", "show running-config  |  include banner", `^banner (login|motd)$`, time.Minute),
+		rule("uptime-generic", "Uptime", "generic", "show version | include uptime", `.*uptime.*`, time.Minute),
+		rule("uptime-cisco", "Cisco uptime", "cisco", "show version | include uptime", `.*uptime.*`, 0),
+		// Same check for another vendor is not a duplicate
+		rule("ssh-juniper", "SSH version", "juniper", "show ip ssh", `version 2`, 0),
+		// Same command with a different pattern is not a duplicate
+		rule("ssh-v1", "SSH version 1", "cisco", "show ip ssh", `version 1`, 0),
+	}
+}
+
+func TestRuleDeduplicator_Analyze(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	for _, rule := range duplicateFixtures() {
+		require.NoError(t, rm.CreateRule(rule))
+	}
+	// The generic rule runs a different command on juniper, so it does not
+	// shadow juniper rules using its base command
+	require.NoError(t, rm.CreateRule(SecurityRule{ID: "ntp-generic", Name: "NTP", Vendor: "generic",
+		Command: "show ntp", ExpectedPattern: "synced", Severity: string(SeverityLow), Enabled: true,
+		VendorOverrides: []VendorOverride{{Vendor: "juniper", Command: "show ntp associations"}}}))
+	require.NoError(t, rm.CreateRule(SecurityRule{ID: "ntp-juniper", Name: "Juniper NTP", Vendor: "juniper",
+		Command: "show ntp", ExpectedPattern: "synced", Severity: string(SeverityLow), Enabled: true}))
+
+	report, err := NewRuleDeduplicator(rm).Analyze()
+	require.NoError(t, err)
+	assert.Equal(t, 10, report.RulesAnalyzed)
+
+	clusters := make(map[RuleDuplicateKind]RuleCluster)
+	for _, cluster := range report.Clusters {
+		clusters[cluster.Kind] = cluster
+	}
+	require.Len(t, report.Clusters, 3)
+
+	exact := clusters[DuplicateExact]
+	assert.Equal(t, "ssh-a", exact.CanonicalID, "the older rule is kept")
+	assert.Equal(t, []string{"ssh-b"}, exact.RemoveIDs)
+
+	near := clusters[DuplicateNear]
+	assert.Equal(t, "banner-a", near.CanonicalID)
+	assert.Equal(t, []string{"banner-b"}, near.RemoveIDs)
+
+	shadowed := clusters[DuplicateShadowed]
+	assert.Equal(t, "uptime-generic", shadowed.CanonicalID, "the generic rule is kept")
+	assert.Equal(t, []string{"uptime-cisco"}, shadowed.RemoveIDs)
+	assert.NotEmpty(t, shadowed.Reason)
+}
+
+func TestRuleManager_MergeRules(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	for _, rule := range duplicateFixtures() {
+		require.NoError(t, rm.CreateRule(rule))
+	}
+	require.NoError(t, rm.SetVendorOverride("ssh-b", VendorOverride{Vendor: "arista", Command: "show management ssh"}))
+
+	store := NewResultStore(rm.db)
+	old := newTestResult("device1", "run1", StatusFail, time.Now().Add(-time.Minute))
+	old.CheckName, old.RuleID = "SSH version (imported)", "ssh-b"
+	other := newTestResult("device1", "run1", StatusPass, time.Now().Add(-time.Minute))
+	other.CheckName, other.RuleID = "Login banner", "banner-a"
+	require.NoError(t, store.SaveResults([]CheckResult{old, other}))
+	require.NoError(t, rm.SaveSkippedRules([]SkippedRule{
+		{DeviceID: "device1", RuleID: "ssh-b", RuleName: "SSH version (imported)", Reason: SkipReasonDisabled, SkippedAt: time.Now()},
+	}))
+
+	generation := rm.Generation()
+	require.NoError(t, rm.MergeRules("ssh-a", []string{"ssh-b"}))
+	assert.NotEqual(t, generation, rm.Generation())
+
+	_, err := rm.GetRule("ssh-b")
+	assert.Error(t, err)
+	overrides, err := rm.GetVendorOverrides("ssh-b")
+	require.NoError(t, err)
+	assert.Empty(t, overrides)
+
+	// History of the removed rule now belongs to the kept rule, under the
+	// name it was recorded with
+	results, err := store.GetDeviceResults("device1", 10)
+	require.NoError(t, err)
+	byID := make(map[string]CheckResult)
+	for _, result := range results {
+		byID[result.ID] = result
+	}
+	assert.Equal(t, "ssh-a", byID[old.ID].RuleID)
+	assert.Equal(t, "SSH version (imported)", byID[old.ID].CheckName)
+	assert.Equal(t, "banner-a", byID[other.ID].RuleID)
+	assert.Equal(t, "Login banner", byID[other.ID].CheckName)
+
+	skipped, err := rm.GetSkippedRules("device1")
+	require.NoError(t, err)
+	require.Len(t, skipped, 1)
+	assert.Equal(t, "ssh-a", skipped[0].RuleID)
+	assert.Equal(t, "SSH version (imported)", skipped[0].RuleName)
+
+	report, err := NewRuleDeduplicator(rm).Analyze()
+	require.NoError(t, err)
+	for _, cluster := range report.Clusters {
+		assert.NotEqual(t, DuplicateExact, cluster.Kind)
+	}
+
+	// Invalid merges change nothing
+	assert.Error(t, rm.MergeRules("ssh-a", nil))
+	assert.Error(t, rm.MergeRules("ssh-a", []string{"ssh-a"}))
+	assert.Error(t, rm.MergeRules("ssh-a", []string{"missing"}))
+	assert.Error(t, rm.MergeRules("missing", []string{"banner-b"}))
+	_, err = rm.GetRule("banner-b")
+	assert.NoError(t, err)
+}
+
+func TestRuleManager_MergeRulesKeepsPredefinedRulesDeleted(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	require.NoError(t, rm.LoadPredefinedRules())
+
+	predefined, err := rm.findRule("Check Login Banner", "cisco")
+	require.NoError(t, err)
+	require.NotNil(t, predefined)
+
+	copied, err := rm.CloneRule(predefined.ID, "cisco")
+	require.NoError(t, err)
+	assert.False(t, copied.Predefined, "clones of predefined rules are custom rules")
+	assert.Zero(t, copied.SourceVersion)
+	stored, err := rm.GetRule(copied.ID)
+	require.NoError(t, err)
+	assert.Equal(t, stored, copied)
+	require.NoError(t, rm.MergeRules(copied.ID, []string{predefined.ID}))
+
+	require.NoError(t, rm.LoadPredefinedRules())
+	recreated, err := rm.findRule("Check Login Banner", "cisco")
+	require.NoError(t, err)
+	assert.Nil(t, recreated)
+}
@@ -0,0 +1,265 @@
+package checker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/catalog"
+	"invictux-demo/internal/device"
+
+	"github.com/google/uuid"
+)
+
+// ErrDependencyCycle is returned when saving rules whose dependencies
+// would lead back to the rule itself
+var ErrDependencyCycle = errors.New("rule dependencies form a cycle")
+
+// normalizeDependsOn trims a rule's prerequisite IDs, dropping blank and
+// repeated ones
+func normalizeDependsOn(ids []string) []string {
+	var kept []string
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// encodeDependsOn serializes a rule's prerequisite IDs for storage, using
+// NULL when there are none
+func encodeDependsOn(ids []string) (interface{}, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dependencies: %w", err)
+	}
+	return string(data), nil
+}
+
+// checkDependencies rejects a rule whose prerequisites, together with those
+// of the stored rules, lead back to the rule. Prerequisites that are not
+// stored are allowed, so related rules can be saved in any order.
+func (rm *RuleManager) checkDependencies(rule SecurityRule) error {
+	// A rule nothing is required of cannot close a cycle
+	if len(rule.DependsOn) == 0 {
+		return nil
+	}
+
+	graph, err := rm.dependencyGraph()
+	if err != nil {
+		return err
+	}
+	graph[rule.ID] = rule.DependsOn
+	return cycleError(graph)
+}
+
+// dependencyGraph maps the ID of every stored rule with prerequisites to
+// their IDs
+func (rm *RuleManager) dependencyGraph() (map[string][]string, error) {
+	rows, err := rm.db.Query("SELECT id, depends_on FROM security_rules WHERE depends_on IS NOT NULL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule dependencies: %w", err)
+	}
+	defer rows.Close()
+
+	graph := make(map[string][]string)
+	for rows.Next() {
+		var id, encoded string
+		if err := rows.Scan(&id, &encoded); err != nil {
+			return nil, err
+		}
+		var dependsOn []string
+		if err := json.Unmarshal([]byte(encoded), &dependsOn); err != nil {
+			return nil, fmt.Errorf("invalid dependencies for rule %s: %w", id, err)
+		}
+		graph[id] = dependsOn
+	}
+	return graph, rows.Err()
+}
+
+// ValidateRuleDependencies rejects a set of rules, such as the stored rules
+// followed by those being imported, whose dependencies form a cycle. A rule
+// listed twice is checked with its last copy.
+func ValidateRuleDependencies(rules []SecurityRule) error {
+	graph := make(map[string][]string, len(rules))
+	for _, rule := range rules {
+		if rule.ID != "" {
+			graph[rule.ID] = normalizeDependsOn(rule.DependsOn)
+		}
+	}
+	return cycleError(graph)
+}
+
+// cycleError wraps ErrDependencyCycle with the IDs along a cycle of graph,
+// or returns nil when there is none
+func cycleError(graph map[string][]string) error {
+	if cycle := dependencyCycle(graph); cycle != nil {
+		return fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// dependencyCycle returns the IDs along a cycle of graph, which maps rule
+// IDs to the IDs of their prerequisites, starting and ending with the same
+// rule. It returns nil when there is no cycle.
+func dependencyCycle(graph map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(graph))
+	var path []string
+
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		switch state[id] {
+		case done:
+			return nil
+		case visiting:
+			for i, seen := range path {
+				if seen == id {
+					return append(append([]string(nil), path[i:]...), id)
+				}
+			}
+		}
+
+		state[id] = visiting
+		path = append(path, id)
+		for _, prerequisite := range graph[id] {
+			if cycle := visit(prerequisite); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = done
+		return nil
+	}
+
+	// Visit in a fixed order so the same cycle is reported every time
+	ids := make([]string, 0, len(graph))
+	for id := range graph {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if cycle := visit(id); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// orderByDependencies returns the rules of a run with every rule after the
+// rules of the run it depends on, keeping their order otherwise.
+// Prerequisites missing from the run are ignored, as is a dependency
+// closing a cycle, which saving the rules rejects.
+func orderByDependencies(rules []SecurityRule) []SecurityRule {
+	index := make(map[string]int, len(rules))
+	dependent := false
+	for i, rule := range rules {
+		index[rule.ID] = i
+		dependent = dependent || len(rule.DependsOn) > 0
+	}
+	if !dependent {
+		return rules
+	}
+
+	ordered := make([]SecurityRule, 0, len(rules))
+	visited := make([]bool, len(rules))
+	var visit func(i int)
+	visit = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		for _, id := range rules[i].DependsOn {
+			if j, ok := index[id]; ok {
+				visit(j)
+			}
+		}
+		ordered = append(ordered, rules[i])
+	}
+	for i := range rules {
+		visit(i)
+	}
+	return ordered
+}
+
+// prerequisiteTracker remembers how the rules of a device run ended, so
+// rules whose prerequisites did not pass are not evaluated
+type prerequisiteTracker struct {
+	names    map[string]string
+	statuses map[string]CheckStatus
+}
+
+// newPrerequisiteTracker returns a tracker for a run of rules, or nil when
+// none of them has prerequisites
+func newPrerequisiteTracker(rules []SecurityRule) *prerequisiteTracker {
+	for _, rule := range rules {
+		if len(rule.DependsOn) > 0 {
+			return &prerequisiteTracker{
+				names:    make(map[string]string, len(rules)),
+				statuses: make(map[string]CheckStatus, len(rules)),
+			}
+		}
+	}
+	return nil
+}
+
+// record notes the status a rule's result ended with
+func (t *prerequisiteTracker) record(rule SecurityRule, result CheckResult) {
+	if t == nil {
+		return
+	}
+	t.names[rule.ID] = rule.Name
+	t.statuses[rule.ID] = CheckStatus(result.Status)
+}
+
+// failed returns the name and status of the first prerequisite of rule
+// that failed or errored, or was itself not applicable, so a chain of
+// dependents stops at the first broken link. Prerequisites that did not
+// run in this run are ignored.
+func (t *prerequisiteTracker) failed(rule SecurityRule) (string, CheckStatus, bool) {
+	if t == nil {
+		return "", "", false
+	}
+	for _, id := range rule.DependsOn {
+		switch status := t.statuses[id]; status {
+		case StatusFail, StatusError, StatusNotApplicable:
+			return t.names[id], status, true
+		}
+	}
+	return "", "", false
+}
+
+// prerequisiteResult reports a rule as not applicable because the named
+// prerequisite ended with status. Nothing is sent to the device for it.
+func (e *Engine) prerequisiteResult(device *device.Device, rule SecurityRule, prerequisite string,
+	status CheckStatus) CheckResult {
+	result := CheckResult{
+		ID:        uuid.New().String(),
+		DeviceID:  device.ID,
+		CheckName: rule.Name,
+		CheckType: "configuration",
+		Severity:  rule.Severity,
+		Status:    string(StatusNotApplicable),
+		CheckedAt: time.Now(),
+	}
+	rule.setFinding(&result)
+	e.setMessage(&result, catalog.NewMessage(catalog.MsgPrerequisiteFailed, catalog.Params{
+		"rule":   prerequisite,
+		"status": string(status),
+	}))
+	return result
+}
@@ -0,0 +1,180 @@
+package checker
+
+import (
+	"context"
+	"testing"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dependencyRule is an enabled cisco rule passing when its command prints "ok"
+func dependencyRule(id, name, command string, dependsOn ...string) SecurityRule {
+	return SecurityRule{ID: id, Name: name, Vendor: "cisco", Command: command, ExpectedPattern: "ok",
+		Severity: string(SeverityMedium), Enabled: true, DependsOn: dependsOn}
+}
+
+func TestRuleManager_DependsOn(t *testing.T) {
+	rm := setupTestRuleManager(t)
+
+	require.NoError(t, rm.CreateRule(dependencyRule("ssh", "SSH enabled", "show ip ssh")))
+	require.NoError(t, rm.CreateRule(dependencyRule("ssh-v2", "SSH version 2", "show ip ssh version",
+		" ssh ", "ssh", "", "later")))
+
+	stored, err := rm.GetRule("ssh-v2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ssh", "later"}, stored.DependsOn, "IDs are trimmed and deduplicated")
+
+	// Prerequisites may be saved after their dependents
+	require.NoError(t, rm.CreateRule(dependencyRule("later", "Later", "show later")))
+
+	stored.DependsOn = nil
+	require.NoError(t, rm.UpdateRule(*stored))
+	stored, err = rm.GetRule("ssh-v2")
+	require.NoError(t, err)
+	assert.Empty(t, stored.DependsOn)
+}
+
+func TestRuleManager_RejectsDependencyCycles(t *testing.T) {
+	rm := setupTestRuleManager(t)
+
+	require.NoError(t, rm.CreateRule(dependencyRule("a", "A", "show a")))
+	require.NoError(t, rm.CreateRule(dependencyRule("b", "B", "show b", "a")))
+	require.NoError(t, rm.CreateRule(dependencyRule("c", "C", "show c", "b")))
+
+	err := rm.UpdateRule(dependencyRule("a", "A", "show a", "c"))
+	assert.ErrorIs(t, err, ErrDependencyCycle)
+	assert.Contains(t, err.Error(), "a -> c -> b -> a")
+
+	assert.ErrorIs(t, rm.CreateRule(dependencyRule("self", "Self", "show self", "self")), ErrDependencyCycle)
+
+	stored, err := rm.GetRule("a")
+	require.NoError(t, err)
+	assert.Empty(t, stored.DependsOn, "the rejected update changes nothing")
+	_, err = rm.GetRule("self")
+	assert.Error(t, err)
+}
+
+func TestEngine_LoadCustomRulesRejectsDependencyCycles(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	engine := NewEngine(rm)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{dependencyRule("a", "A", "show a")}))
+
+	// The cycle only closes with the stored rule, and is found before any
+	// of the imported rules is created
+	err := engine.LoadCustomRules([]SecurityRule{
+		dependencyRule("b", "B", "show b", "c"),
+		dependencyRule("c", "C", "show c", "a"),
+		dependencyRule("a", "A", "show a", "b"),
+	})
+	assert.ErrorIs(t, err, ErrDependencyCycle)
+	rules, err := rm.GetAllRules()
+	require.NoError(t, err)
+	assert.Len(t, rules, 1)
+
+	// Dependents may come before their prerequisites
+	assert.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		dependencyRule("c", "C", "show c", "b"),
+		dependencyRule("b", "B", "show b", "a"),
+	}))
+}
+
+func TestOrderByDependencies(t *testing.T) {
+	rules := []SecurityRule{
+		dependencyRule("c", "C", "show c", "b"),
+		dependencyRule("x", "X", "show x"),
+		dependencyRule("b", "B", "show b", "a", "missing"),
+		dependencyRule("a", "A", "show a"),
+	}
+
+	var order []string
+	for _, rule := range orderByDependencies(rules) {
+		order = append(order, rule.ID)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "x"}, order)
+	assert.Equal(t, "c", rules[0].ID, "the given rules are left alone")
+}
+
+func TestEngine_DependentsOfFailedPrerequisites(t *testing.T) {
+	client := &stubSSHClient{outputs: map[string]string{
+		"show ip ssh":      "SSH disabled",
+		"show logging":     "logging ok",
+		"show ssh version": "version 2 ok",
+		"show ssh timeout": "timeout 60 ok",
+		"show ntp":         "ntp ok",
+		"show ntp status":  "synchronized ok",
+	}}
+	rm := setupTestRuleManager(t)
+	engine := NewEngineWithSSHClient(rm, client)
+	// Names sort dependents before their prerequisites, so the run has to
+	// reorder them
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		dependencyRule("ssh", "Z SSH enabled", "show ip ssh"),
+		dependencyRule("logging", "Z Logging", "show logging"),
+		dependencyRule("ssh-v2", "B SSH version 2", "show ssh version", "ssh"),
+		dependencyRule("ssh-timeout", "A SSH timeout", "show ssh timeout", "ssh-v2"),
+		dependencyRule("ntp", "C NTP", "show ntp", "logging", "ssh"),
+		dependencyRule("ntp-logged", "D NTP logged", "show ntp status", "logging"),
+	}))
+
+	dev := &device.Device{ID: "d1", Name: "Edge", IPAddress: "192.168.1.80", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", SSHPort: 22}
+
+	assertRun := func(t *testing.T, results []CheckResult) {
+		t.Helper()
+		byName := resultsByName(results)
+		assert.Equal(t, string(StatusFail), byName["Z SSH enabled"].Status)
+		assert.Equal(t, string(StatusPass), byName["Z Logging"].Status)
+		assert.Equal(t, string(StatusPass), byName["D NTP logged"].Status)
+
+		// The chain stops at its first broken link
+		assert.Equal(t, string(StatusNotApplicable), byName["B SSH version 2"].Status)
+		assert.Equal(t, "Not applicable: prerequisite Z SSH enabled ended FAIL", byName["B SSH version 2"].Message)
+		assert.Equal(t, string(StatusNotApplicable), byName["A SSH timeout"].Status)
+		assert.Equal(t, "Not applicable: prerequisite B SSH version 2 ended NOT_APPLICABLE",
+			byName["A SSH timeout"].Message)
+
+		// One failed prerequisite is enough, whatever the others did
+		assert.Equal(t, string(StatusNotApplicable), byName["C NTP"].Status)
+		assert.Contains(t, byName["C NTP"].Message, "Z SSH enabled")
+
+		// Skipped dependents never reach the device
+		assert.ElementsMatch(t, []string{"show ip ssh", "show logging", "show ntp status"}, client.executed)
+	}
+
+	var last *CheckProgress
+	results, err := engine.RunChecksWithProgress(dev, func(p *CheckProgress) {
+		copied := *p
+		last = &copied
+	})
+	require.NoError(t, err)
+	assertRun(t, results)
+	require.NotNil(t, last)
+	assert.Equal(t, 6, last.Total)
+	assert.Equal(t, 3, last.NotApplicable)
+
+	summary := SummarizeCompliance(results, nil)
+	assert.Equal(t, 3, summary.NotApplicable)
+	assert.InDelta(t, 66.67, summary.Score, 0.01, "not applicable results are not scored")
+
+	t.Run("bulk", func(t *testing.T) {
+		client.executed = nil
+		bulk, err := engine.RunBulkChecksContext(context.Background(), []device.Device{*dev}, CheckOptions{}, nil)
+		require.NoError(t, err)
+		assertRun(t, bulk.DeviceResults[dev.ID])
+		assert.Equal(t, 3, bulk.Progress[dev.ID].NotApplicable)
+	})
+
+	t.Run("passing prerequisites", func(t *testing.T) {
+		client.executed = nil
+		client.outputs["show ip ssh"] = "SSH ok"
+		results, err := engine.RunChecks(dev)
+		require.NoError(t, err)
+		for _, result := range results {
+			assert.Equal(t, string(StatusPass), result.Status, result.CheckName)
+		}
+		assert.Len(t, client.executed, 6)
+	})
+}
@@ -0,0 +1,122 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+)
+
+// DryRunCache maps a device IP address to the output of each command run
+// on it, as stored in dry-run cache files:
+//
+//	{"192.168.1.1": {"show version": "Cisco IOS ..."}}
+type DryRunCache map[string]map[string]string
+
+// LoadDryRunCacheFromFile reads a dry-run cache file and serves it to
+// simulated runs in place of recorded sessions, whatever SSH port a device
+// uses. Commands missing from the cache fail rather than getting made-up
+// output.
+func (e *Engine) LoadDryRunCacheFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read dry-run cache: %w", err)
+	}
+
+	var cache DryRunCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return fmt.Errorf("failed to decode dry-run cache %s: %w", path, err)
+	}
+	if len(cache) == 0 {
+		return fmt.Errorf("dry-run cache %s holds no devices", path)
+	}
+
+	fixtures := make([]*ssh.SessionFixture, 0, len(cache))
+	for host, outputs := range cache {
+		fixture := &ssh.SessionFixture{Version: ssh.FixtureFormatVersion, Host: host}
+		for command, output := range outputs {
+			fixture.Commands = append(fixture.Commands, ssh.RecordedCommand{Command: command, Output: output})
+		}
+		fixtures = append(fixtures, fixture)
+	}
+
+	e.SetSimulator(ssh.NewSimulatedClient(fixtures, ssh.SimulationConfig{FailUnknownCommands: true}))
+	return nil
+}
+
+// SaveCurrentOutputsToFile writes the command output captured from devices
+// by live runs to path as a dry-run cache, so a run can be replayed offline.
+// Giving hosts limits the file to those IP addresses. Secrets in the output
+// are redacted, and commands that failed were not captured.
+func (e *Engine) SaveCurrentOutputsToFile(path string, hosts ...string) error {
+	cache := e.CapturedOutputs()
+	if len(hosts) > 0 {
+		selected := make(DryRunCache, len(hosts))
+		for _, host := range hosts {
+			outputs, ok := cache[host]
+			if !ok {
+				return fmt.Errorf("no command output captured from %s", host)
+			}
+			selected[host] = outputs
+		}
+		cache = selected
+	}
+	if len(cache) == 0 {
+		return fmt.Errorf("no command output captured")
+	}
+
+	for _, outputs := range cache {
+		for command, output := range outputs {
+			outputs[command] = ssh.RedactOutput(output)
+		}
+	}
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode dry-run cache: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write dry-run cache: %w", err)
+	}
+	return nil
+}
+
+// CapturedOutputs returns a copy of the command output captured by live
+// runs, the latest output of each command winning
+func (e *Engine) CapturedOutputs() DryRunCache {
+	e.captureMutex.Lock()
+	defer e.captureMutex.Unlock()
+
+	cache := make(DryRunCache, len(e.capturedOutputs))
+	for host, outputs := range e.capturedOutputs {
+		copied := make(map[string]string, len(outputs))
+		for command, output := range outputs {
+			copied[command] = output
+		}
+		cache[host] = copied
+	}
+	return cache
+}
+
+// captureOutput keeps the output of a command that ran cleanly on a device,
+// unless it came from the simulator rather than the device
+func (e *Engine) captureOutput(client ssh.SSHClientInterface, device *device.Device, command, output string) {
+	if e.simulator != nil && client == e.simulator {
+		return
+	}
+
+	e.captureMutex.Lock()
+	defer e.captureMutex.Unlock()
+
+	if e.capturedOutputs == nil {
+		e.capturedOutputs = make(DryRunCache)
+	}
+	outputs, ok := e.capturedOutputs[device.IPAddress]
+	if !ok {
+		outputs = make(map[string]string)
+		e.capturedOutputs[device.IPAddress] = outputs
+	}
+	outputs[command] = output
+}
@@ -0,0 +1,143 @@
+package checker
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+	"invictux-demo/internal/ssh/sshtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDryRunServer starts an SSH server answering the dry-run rules'
+// commands as a device would. The engine logs in as admin with the
+// placeholder password until it decrypts device passwords.
+func newDryRunServer(t *testing.T) *sshtest.Server {
+	t.Helper()
+	server, err := sshtest.NewServer()
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	server.AddUser("admin", "placeholder")
+	server.SetCommandResponse("show version", "Cisco IOS Software, uptime is 5 days")
+	server.SetCommandResponse("show ip ssh", "SSH Enabled - version 1.99")
+	server.SetCommandResponse("show running-config", "hostname core\nip ssh version 2")
+	return server
+}
+
+// ruleResults returns the results of rules, without the SSH posture found
+// when connecting or how long the connection took, which a replay does not
+// reproduce
+func ruleResults(results []CheckResult) []CheckResult {
+	var rules []CheckResult
+	for _, result := range results {
+		if result.CheckType == "ssh_posture" {
+			continue
+		}
+		result.Duration = 0
+		result.Phases = ssh.PhaseTimings{}
+		rules = append(rules, result)
+	}
+	return rules
+}
+
+func TestEngine_DryRunCacheRoundTrip(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	live := ssh.NewSSHClient(nil)
+	defer live.Close()
+	engine := NewEngineWithSSHClient(rm, live)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "Uptime", Vendor: "generic", Command: "show version", ExpectedPattern: "uptime",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "r2", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r3", Name: "Hostname", Vendor: "generic", Command: "show running-config", ExpectedPattern: "hostname",
+			Severity: string(SeverityMedium), Enabled: true},
+	}))
+
+	// The cache is keyed by address, so the devices reach their servers
+	// under different names for the loopback address
+	one, two := newDryRunServer(t), newDryRunServer(t)
+	devices := []device.Device{
+		{ID: "d1", Name: "One", IPAddress: one.GetAddress(), DeviceType: string(device.TypeRouter),
+			Vendor: "cisco", Username: "admin", SSHPort: one.GetPort()},
+		{ID: "d2", Name: "Two", IPAddress: "localhost", DeviceType: string(device.TypeSwitch),
+			Vendor: "cisco", Username: "admin", SSHPort: two.GetPort()},
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.Error(t, engine.SaveCurrentOutputsToFile(path), "nothing has been captured yet")
+
+	recorded, err := engine.RunBulkChecks(devices)
+	require.NoError(t, err)
+	for _, dev := range devices {
+		require.Len(t, ruleResults(recorded[dev.ID]), 3, dev.Name)
+		for _, result := range recorded[dev.ID] {
+			require.NotEqual(t, string(StatusError), result.Status, result.Message)
+		}
+	}
+
+	require.NoError(t, engine.SaveCurrentOutputsToFile(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var cache DryRunCache
+	require.NoError(t, json.Unmarshal(data, &cache))
+	assert.Len(t, cache, 2)
+	assert.Equal(t, "Cisco IOS Software, uptime is 5 days", cache["localhost"]["show version"])
+
+	// Only the selected devices are written
+	single := filepath.Join(t.TempDir(), "single.json")
+	require.NoError(t, engine.SaveCurrentOutputsToFile(single, one.GetAddress()))
+	data, err = os.ReadFile(single)
+	require.NoError(t, err)
+	cache = nil
+	require.NoError(t, json.Unmarshal(data, &cache))
+	assert.Len(t, cache, 1)
+	assert.Error(t, engine.SaveCurrentOutputsToFile(single, "192.168.1.99"))
+
+	// Replaying the snapshot needs neither device and gives the same results
+	require.NoError(t, engine.LoadDryRunCacheFromFile(path))
+	live.Close()
+	one.Close()
+	two.Close()
+	replayed, err := engine.RunBulkChecksWithOptions(devices, CheckOptions{Simulate: true}, nil)
+	require.NoError(t, err)
+	for _, dev := range devices {
+		assert.Equal(t, comparableResults(t, ruleResults(recorded[dev.ID])),
+			comparableResults(t, ruleResults(replayed[dev.ID])), dev.Name)
+	}
+
+	// Secrets are kept in memory but never reach the file
+	engine.captureOutput(live, &device.Device{IPAddress: "192.168.1.42"}, "show running-config",
+		"hostname edge\nenable secret 5 $1$abc")
+	require.NoError(t, engine.SaveCurrentOutputsToFile(path))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	cache = nil
+	require.NoError(t, json.Unmarshal(data, &cache))
+	assert.Len(t, cache, 3)
+	assert.Equal(t, "hostname edge\nenable secret 5 "+ssh.RedactedValue, cache["192.168.1.42"]["show running-config"])
+	assert.Equal(t, "hostname edge\nenable secret 5 $1$abc", engine.CapturedOutputs()["192.168.1.42"]["show running-config"])
+}
+
+func TestEngine_LoadDryRunCacheFromFile_Invalid(t *testing.T) {
+	engine := NewEngineWithSSHClient(&fakeRuleManager{}, &stubSSHClient{})
+	dir := t.TempDir()
+
+	assert.Error(t, engine.LoadDryRunCacheFromFile(filepath.Join(dir, "missing.json")))
+
+	for name, content := range map[string]string{"broken.json": "{", "empty.json": "{}"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		assert.Error(t, engine.LoadDryRunCacheFromFile(path), name)
+	}
+
+	_, err := engine.RunChecksWithOptions(&device.Device{ID: "d1", IPAddress: "192.168.1.40", Vendor: "cisco"},
+		CheckOptions{Simulate: true}, nil)
+	assert.Error(t, err, "no cache was loaded")
+}
@@ -3,10 +3,15 @@ package checker
 import (
 	"context"
 	"fmt"
+	"log"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"invictux-demo/internal/catalog"
 	"invictux-demo/internal/device"
 	"invictux-demo/internal/ssh"
 
@@ -16,64 +21,249 @@ import (
 // Engine handles security check execution
 type Engine struct {
 	sshClient   ssh.SSHClientInterface
-	ruleManager *RuleManager
+	simulator   ssh.SSHClientInterface
+	ruleManager RuleManagerInterface
 	workerCount int
 	timeout     time.Duration
+
+	// clientFactory, when set, supplies the SSH client of each run instead
+	// of sshClient
+	clientFactory func() ssh.SSHClientInterface
+
+	// maxWorkers enables the adaptive worker pool, which grows from
+	// workerCount up to maxWorkers while jobs are queued; zero is fixed size
+	maxWorkers    int
+	activeWorkers atomic.Int32
+
+	// MaxConcurrentSSHSessions caps how many SSH sessions the engine keeps
+	// open at once across all workers; zero or less is no cap beyond the
+	// worker count. Set it before starting checks.
+	MaxConcurrentSSHSessions int
+
+	// sessionSlots is the semaphore enforcing MaxConcurrentSSHSessions,
+	// shared by all workers
+	sessionMutex sync.Mutex
+	sessionSlots chan struct{}
+
+	// locale selects the catalog result messages are rendered from
+	locale atomic.Value
+
+	// batchMarkerCommands holds the marker command of each vendor whose rule
+	// commands are batched; newBatchMarker overrides marker generation in tests
+	batchMarkerCommands map[string]string
+	newBatchMarker      func() string
+
+	// snapshotStore archives each device's full config per run when set;
+	// snapshotCommands overrides the vendor default config commands
+	snapshotStore    *SnapshotStore
+	snapshotCommands map[string]string
+
+	// inventoryHook is told the model and OS version read from the
+	// version command output of each run
+	inventoryHook InventoryFunc
+
+	// rulesCache maps a vendor to its *rulesCacheEntry so bulk runs read
+	// each vendor's rules once; rulesCacheTTL is a time.Duration, zero never
+	// expires
+	rulesCache    sync.Map
+	rulesCacheTTL atomic.Int64
+
+	// postures holds the last SSH handshake seen per device, persisted to
+	// postureStore when set; weakSSHAlgorithms overrides the default weak set
+	postureMutex      sync.Mutex
+	postures          map[string]*postureCapture
+	postureStore      *PostureStore
+	weakSSHAlgorithms []string
+
+	// resultStore keeps the change indicators incremental runs compare
+	// against and the results they carry forward; nil runs every rule
+	resultStore *ResultStore
+
+	// connectionMetrics records the phase timings of each connection when set
+	connectionMetrics *ConnectionMetricsStore
+
+	// pagerConfigs maps a vendor to the pager its CLI shows; nil uses
+	// ssh.DefaultPagerConfigs
+	pagerConfigs map[string]ssh.VendorPagerConfig
+
+	// shellConfigs holds the shell of each vendor whose device runs keep
+	// one interactive shell open
+	shellConfigs map[string]ssh.ShellConfig
+	// enableSecret looks up the secret answering a shell's enable prompt
+	enableSecret EnableSecretFunc
+
+	// overrideManager supplies severity overrides applied to results after
+	// evaluation; nil leaves every result at its rule's severity
+	overrideManager *OverrideManager
+
+	// macroManager supplies the command macros expanded in rule commands
+	// before they are sent
+	macroManager *MacroManager
+
+	// excludeBrokenRules skips rules flagged by ValidateAllRules
+	excludeBrokenRules atomic.Bool
+
+	// excludeTags lists device tags bulk runs refuse to check
+	excludeTags []string
+
+	// patternTimeout bounds the evaluation of one rule's patterns, zero is
+	// unbounded; matchPattern replaces regexp matching in tests
+	patternTimeout time.Duration
+	matchPattern   func(regex *regexp.Regexp, text string) bool
+
+	// evidenceDir, when set, holds the full command output of results whose
+	// evidence is a snippet
+	evidenceDir string
+
+	// maxEvidenceBytes caps the evidence kept on a result, zero is unbounded
+	maxEvidenceBytes int
+
+	// capturedOutputs holds the command output of live runs per device IP,
+	// for saving as a dry-run cache
+	captureMutex    sync.Mutex
+	capturedOutputs DryRunCache
 }
 
 // CheckJob represents a security check job for a device
 type CheckJob struct {
-	Device *device.Device
-	Rules  []SecurityRule
+	Device  *device.Device
+	Rules   []SecurityRule
+	Skipped []SkippedRule
+	RunID   string
+	Client  ssh.SSHClientInterface
+	Options CheckOptions
+
+	// Overrides are the severity overrides applying to the device
+	Overrides map[string]SeverityOverride
+}
+
+// CheckOptions selects how a check run reaches devices and how it is labeled
+type CheckOptions struct {
+	// Simulate answers commands from recorded fixtures instead of the network
+	Simulate bool `json:"simulate"`
+
+	// Label and Note describe the run, for example the change it follows.
+	// They are validated before the run starts and stored by the caller.
+	Label string `json:"label,omitempty"`
+	Note  string `json:"note,omitempty"`
+
+	// RuleIDs restricts the run to these rules. The other rules are left
+	// out of the run without a skip record. Empty runs every applicable rule.
+	RuleIDs []string `json:"ruleIds,omitempty"`
+
+	// Incremental carries forward the previous results of a device whose
+	// config has not changed since its last incremental run, evaluating
+	// only the rules that changed and those reading live state rather than
+	// the config. ForceFull evaluates every rule but still
+	// records the config for the next incremental run.
+	Incremental bool `json:"incremental,omitempty"`
+	ForceFull   bool `json:"forceFull,omitempty"`
+
+	// Client, when set, runs the checks over this SSH client instead of
+	// the engine's, for example one holding an interactive login open
+	Client ssh.SSHClientInterface `json:"-"`
 }
 
-// CheckProgress represents the progress of security checks
+// CheckProgress represents the progress of security checks. NotApplicable
+// counts the rules reported as not applicable because a prerequisite did
+// not pass, which are part of Total.
 type CheckProgress struct {
-	DeviceID    string    `json:"deviceId"`
-	DeviceName  string    `json:"deviceName"`
-	Status      string    `json:"status"`
-	Progress    int       `json:"progress"`
-	Total       int       `json:"total"`
-	CurrentRule string    `json:"currentRule"`
-	Error       string    `json:"error,omitempty"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	DeviceID      string    `json:"deviceId"`
+	DeviceName    string    `json:"deviceName"`
+	Status        string    `json:"status"`
+	Progress      int       `json:"progress"`
+	Total         int       `json:"total"`
+	Skipped       int       `json:"skipped"`
+	NotApplicable int       `json:"notApplicable"`
+	CurrentRule   string    `json:"currentRule"`
+	Error         string    `json:"error,omitempty"`
+	ErrorCode     string    `json:"errorCode,omitempty"`
+	UpdatedAt     time.Time `json:"updatedAt"`
 }
 
-// BulkCheckResult represents the result of bulk security checks
+// BulkCheckResult represents the result of bulk security checks. Errors is
+// keyed by device ID.
 type BulkCheckResult struct {
 	DeviceResults map[string][]CheckResult  `json:"deviceResults"`
 	Progress      map[string]*CheckProgress `json:"progress"`
-	Errors        map[string]error          `json:"errors"`
+	Errors        map[string]CheckError     `json:"errors"`
 }
 
 // ProgressCallback is called to report progress updates
 type ProgressCallback func(progress *CheckProgress)
 
 // NewEngine creates a new security check engine
-func NewEngine(ruleManager *RuleManager) *Engine {
+func NewEngine(ruleManager RuleManagerInterface) *Engine {
 	return &Engine{
 		sshClient:   ssh.NewSSHClient(nil), // Use default config
 		ruleManager: ruleManager,
 		workerCount: 5, // Default worker pool size
 		timeout:     30 * time.Second,
+
+		patternTimeout:   DefaultPatternTimeout,
+		maxEvidenceBytes: DefaultMaxEvidenceBytes,
 	}
 }
 
 // NewEngineWithSSHClient creates a new engine with a custom SSH client
-func NewEngineWithSSHClient(ruleManager *RuleManager, sshClient ssh.SSHClientInterface) *Engine {
+func NewEngineWithSSHClient(ruleManager RuleManagerInterface, sshClient ssh.SSHClientInterface) *Engine {
 	return &Engine{
 		sshClient:   sshClient,
 		ruleManager: ruleManager,
 		workerCount: 5,
 		timeout:     30 * time.Second,
+
+		patternTimeout:   DefaultPatternTimeout,
+		maxEvidenceBytes: DefaultMaxEvidenceBytes,
 	}
 }
 
-// SetWorkerCount sets the number of workers for parallel processing
+// SetWorkerCount sets the number of workers for parallel processing and
+// switches the engine back to a fixed-size worker pool
 func (e *Engine) SetWorkerCount(count int) {
 	if count > 0 {
 		e.workerCount = count
+		e.maxWorkers = 0
+	}
+}
+
+// sessionSemaphore returns the semaphore sized to MaxConcurrentSSHSessions,
+// or nil when sessions are not capped. Changing the cap starts a new
+// semaphore; sessions holding a slot of the old one release it there.
+func (e *Engine) sessionSemaphore() chan struct{} {
+	e.sessionMutex.Lock()
+	defer e.sessionMutex.Unlock()
+
+	max := e.MaxConcurrentSSHSessions
+	if max <= 0 {
+		return nil
+	}
+	if cap(e.sessionSlots) != max {
+		e.sessionSlots = make(chan struct{}, max)
+	}
+	return e.sessionSlots
+}
+
+// SetLocale sets the locale new result messages are rendered in. Stored
+// results keep their message ID, so they can be re-rendered later.
+func (e *Engine) SetLocale(locale string) {
+	e.locale.Store(locale)
+}
+
+// GetLocale returns the locale result messages are rendered in
+func (e *Engine) GetLocale() string {
+	if locale, ok := e.locale.Load().(string); ok && locale != "" {
+		return locale
 	}
+	return catalog.DefaultLocale
+}
+
+// setMessage records a catalog message on a result and renders it in the
+// engine's locale
+func (e *Engine) setMessage(result *CheckResult, msg catalog.Message) {
+	result.MessageID = msg.ID
+	result.MessageParams = msg.Params
+	result.Message = msg.Render(e.GetLocale())
 }
 
 // SetTimeout sets the timeout for security checks
@@ -81,6 +271,52 @@ func (e *Engine) SetTimeout(timeout time.Duration) {
 	e.timeout = timeout
 }
 
+// SetPatternTimeout bounds how long evaluating one rule's patterns against
+// a command's output may take before the result is an error. Zero or less
+// removes the bound. It must not be called while checks are running.
+func (e *Engine) SetPatternTimeout(timeout time.Duration) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	e.patternTimeout = timeout
+}
+
+// SetSimulator sets the client that simulated runs read device output from
+func (e *Engine) SetSimulator(client ssh.SSHClientInterface) {
+	e.simulator = client
+}
+
+// SetSSHClientFactory makes every run get its SSH client from factory,
+// for example so tests can hand each run its own mock. A nil factory, or
+// one returning nil, falls back to the engine's client.
+func (e *Engine) SetSSHClientFactory(factory func() ssh.SSHClientInterface) {
+	e.clientFactory = factory
+}
+
+// networkClient returns the client a run connects to devices with
+func (e *Engine) networkClient() ssh.SSHClientInterface {
+	if e.clientFactory != nil {
+		if client := e.clientFactory(); client != nil {
+			return client
+		}
+	}
+	return e.sshClient
+}
+
+// clientFor returns the SSH client a run with the given options uses
+func (e *Engine) clientFor(opts CheckOptions) (ssh.SSHClientInterface, error) {
+	if !opts.Simulate {
+		if opts.Client != nil {
+			return opts.Client, nil
+		}
+		return e.networkClient(), nil
+	}
+	if e.simulator == nil {
+		return nil, fmt.Errorf("simulation requested but no recorded sessions are loaded")
+	}
+	return e.simulator, nil
+}
+
 // RunChecks executes security checks on a device
 func (e *Engine) RunChecks(device *device.Device) ([]CheckResult, error) {
 	return e.RunChecksWithProgress(device, nil)
@@ -88,10 +324,117 @@ func (e *Engine) RunChecks(device *device.Device) ([]CheckResult, error) {
 
 // RunChecksWithProgress executes security checks on a device with progress reporting
 func (e *Engine) RunChecksWithProgress(device *device.Device, progressCallback ProgressCallback) ([]CheckResult, error) {
+	return e.RunChecksWithOptions(device, CheckOptions{}, progressCallback)
+}
+
+// RunChecksWithOptions executes security checks on a device with the given
+// options and progress reporting
+func (e *Engine) RunChecksWithOptions(device *device.Device, opts CheckOptions, progressCallback ProgressCallback) ([]CheckResult, error) {
+	return e.runDeviceChecks(context.Background(), device, opts, progressCallback, nil)
+}
+
+// RunChecksStream executes security checks on a device and sends each result
+// as soon as its rule has been evaluated, instead of after the whole run.
+// Both channels are closed when the run ends; the error channel carries at
+// most one error. Cancelling ctx stops the run before its next rule.
+func (e *Engine) RunChecksStream(ctx context.Context, dev *device.Device) (<-chan CheckResult, <-chan error) {
+	return e.RunChecksStreamWithOptions(ctx, dev, CheckOptions{})
+}
+
+// RunChecksStreamWithOptions is RunChecksStream with the given options
+func (e *Engine) RunChecksStreamWithOptions(ctx context.Context, dev *device.Device, opts CheckOptions) (<-chan CheckResult, <-chan error) {
+	results := make(chan CheckResult)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(results)
+
+		_, err := e.runDeviceChecks(ctx, dev, opts, nil, func(result CheckResult) bool {
+			select {
+			case results <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return results, errs
+}
+
+// RerunFailedChecks runs again the rules of a device whose previous result
+// failed, errored or was not applicable for a failed prerequisite, matching
+// results to rules by check name, to verify a remediation without running
+// the whole rule set. Previous results of other devices are ignored. Only
+// the re-run checks are returned; nothing runs when none of the previous
+// checks failed.
+func (e *Engine) RerunFailedChecks(ctx context.Context, device *device.Device, previous []CheckResult) ([]CheckResult, error) {
+	failed := make(map[string]bool)
+	for _, result := range previous {
+		if result.DeviceID != "" && result.DeviceID != device.ID {
+			continue
+		}
+		switch CheckStatus(result.Status) {
+		case StatusFail, StatusError, StatusNotApplicable:
+			failed[result.CheckName] = true
+		}
+	}
+
+	var ruleIDs []string
+	for _, rule := range e.securityRules(ctx, device.Vendor) {
+		if failed[rule.Name] {
+			ruleIDs = append(ruleIDs, rule.ID)
+		}
+	}
+	if len(ruleIDs) == 0 {
+		return []CheckResult{}, nil
+	}
+
+	results, err := e.runDeviceChecks(ctx, device, CheckOptions{RuleIDs: ruleIDs}, nil, nil)
+	// The SSH posture check comes with every connection; keep it only when
+	// it failed before
+	rerun := make([]CheckResult, 0, len(results))
+	for _, result := range results {
+		if failed[result.CheckName] {
+			rerun = append(rerun, result)
+		}
+	}
+	return rerun, err
+}
+
+// runDeviceChecks executes security checks on a device. Each result is
+// passed to emit, when set, as soon as it is ready; emit returns false to
+// stop the run, which then ends with ctx's error.
+func (e *Engine) runDeviceChecks(ctx context.Context, device *device.Device, opts CheckOptions,
+	progressCallback ProgressCallback, emit func(CheckResult) bool) ([]CheckResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	client, err := e.clientFor(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []CheckResult
+	runID := uuid.New().String()
+	started := time.Now()
 
 	// Get applicable rules for this device
-	applicableRules := e.GetSecurityRules(device.Vendor)
+	applicableRules := e.securityRules(ctx, device.Vendor)
+
+	// Record every rule that will not be evaluated so the run is auditable
+	skipped := e.skippedRules(ctx, device)
+	applicableRules, skipped = opts.restrictRules(applicableRules, skipped)
+	overrides := e.severityOverridesFor(device)
+	applicableRules, skipped = suppressRules(device, applicableRules, skipped, overrides)
+	applicableRules = orderByDependencies(applicableRules)
+	defer func() {
+		e.recordSkippedRules(ctx, runID, skipped)
+	}()
 
 	// Initialize progress tracking
 	progress := &CheckProgress{
@@ -100,6 +443,7 @@ func (e *Engine) RunChecksWithProgress(device *device.Device, progressCallback P
 		Status:     "running",
 		Progress:   0,
 		Total:      len(applicableRules),
+		Skipped:    len(skipped),
 		UpdatedAt:  time.Now(),
 	}
 
@@ -117,9 +461,26 @@ func (e *Engine) RunChecksWithProgress(device *device.Device, progressCallback P
 		return results, fmt.Errorf("no security rules found for vendor: %s", device.Vendor)
 	}
 
+	// Keep one shell open for the whole run when the vendor's CLI asks for it
+	if shell := e.openDeviceShell(client, device); shell != nil {
+		defer shell.close()
+		client = shell
+	}
+
+	incremental := e.startIncremental(client, device, applicableRules, opts)
+	outputs := e.commandOutputs(client, device, incremental.pending(applicableRules))
+	incremental.seed(outputs)
+	prerequisites := newPrerequisiteTracker(applicableRules)
+	var circuit connectCircuit
+
 	// Execute each rule
 	for i, rule := range applicableRules {
-		if !rule.Enabled {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		if reason := e.runSkipReason(rule, &circuit); reason != "" {
+			skipped = append(skipped, newSkippedRule(device, rule, reason))
+			progress.Skipped = len(skipped)
 			continue
 		}
 
@@ -131,7 +492,15 @@ func (e *Engine) RunChecksWithProgress(device *device.Device, progressCallback P
 			progressCallback(progress)
 		}
 
-		result, err := e.executeRule(device, rule)
+		var result CheckResult
+		var err error
+		prerequisite, status, blocked := prerequisites.failed(rule)
+		if blocked {
+			result = e.prerequisiteResult(device, rule, prerequisite, status)
+			progress.NotApplicable++
+		} else {
+			result, err = e.executeOrCarryRule(client, device, rule, outputs, incremental)
+		}
 		if err != nil {
 			// Create error result
 			result = CheckResult{
@@ -141,13 +510,41 @@ func (e *Engine) RunChecksWithProgress(device *device.Device, progressCallback P
 				CheckType: "configuration",
 				Severity:  rule.Severity,
 				Status:    string(StatusError),
-				Message:   fmt.Sprintf("Check execution failed: %s", err.Error()),
 				Evidence:  "",
 				CheckedAt: time.Now(),
 			}
+			rule.setFinding(&result)
+			e.setMessage(&result, catalog.NewMessage(catalog.MsgExecutionFailed, catalog.Params{"error": err.Error()}))
+		}
+		result.RunID = runID
+		applySeverityOverride(&result, rule.ID, overrides)
+		// Skipped dependents are evaluated again once their prerequisite passes
+		if !blocked {
+			incremental.record(rule, result)
+			circuit.record(result)
+		}
+		prerequisites.record(rule, result)
+		if result.MessageID == catalog.MsgMaintenanceWindow {
+			skipped = append(skipped, newSkippedRule(device, rule, SkipReasonMaintenance))
+			progress.Skipped = len(skipped)
 		}
 
 		results = append(results, result)
+		if emit != nil && !emit(result) {
+			return results, ctx.Err()
+		}
+	}
+
+	incremental.finish(runID)
+	e.archiveConfig(client, device, outputs)
+	e.recordInventory(client, device, outputs)
+
+	if result, ok := e.postureResult(device, started); ok {
+		result.RunID = runID
+		results = append(results, result)
+		if emit != nil && !emit(result) {
+			return results, ctx.Err()
+		}
 	}
 
 	// Update final progress
@@ -163,9 +560,18 @@ func (e *Engine) RunChecksWithProgress(device *device.Device, progressCallback P
 	return results, nil
 }
 
-// executeRule executes a single security rule against a device
-func (e *Engine) executeRule(device *device.Device, rule SecurityRule) (CheckResult, error) {
-	result := CheckResult{
+// executeRule executes a single security rule against a device. Output
+// already fetched earlier in the run, by a command batch or another rule, is
+// evaluated without reconnecting. The result's Duration covers everything
+// from waiting for a session to evaluating the output.
+func (e *Engine) executeRule(client ssh.SSHClientInterface, device *device.Device, rule SecurityRule,
+	outputs map[string]string) (result CheckResult, err error) {
+	started := time.Now()
+	defer func() {
+		result.Duration = time.Since(started)
+	}()
+
+	result = CheckResult{
 		ID:        uuid.New().String(),
 		DeviceID:  device.ID,
 		CheckName: rule.Name,
@@ -176,84 +582,469 @@ func (e *Engine) executeRule(device *device.Device, rule SecurityRule) (CheckRes
 		Evidence:  "",
 		CheckedAt: time.Now(),
 	}
+	rule.setFinding(&result)
+
+	// Leave devices under maintenance alone
+	if device.IsInMaintenance() {
+		result.Status = string(StatusWarning)
+		e.setMessage(&result, catalog.NewMessage(catalog.MsgMaintenanceWindow, nil))
+		return result, nil
+	}
+
+	// Resolve the most specific variant of the rule for this device
+	effective, variant := rule.ForDevice(device.Vendor, device.DeviceType)
+	result.CommandVariant = variant
+
+	command, err := e.expandCommand(effective.Command)
+	if err != nil {
+		e.setMessage(&result, catalog.NewMessage(catalog.MsgCommandFailed, catalog.Params{"error": err.Error()}))
+		return result, nil
+	}
+	effective.Command = command
+	result.Command = command
+
+	// The precondition decides whether the rule applies before its own
+	// command runs; its output is shared like that of rule commands
+	var precondition *RulePrecondition
+	if effective.Precondition != nil {
+		expanded, err := e.expandCommand(effective.Precondition.Command)
+		if err != nil {
+			e.setMessage(&result, catalog.NewMessage(catalog.MsgCommandFailed, catalog.Params{"error": err.Error()}))
+			return result, nil
+		}
+		precondition = &RulePrecondition{Command: expanded, Pattern: effective.Precondition.Pattern}
+	}
+	applies := func(output string) bool {
+		holds, failure := e.preconditionHolds(output, precondition)
+		if failure != nil {
+			e.setMessage(&result, *failure)
+		} else if !holds {
+			e.setNotApplicable(&result, precondition)
+		}
+		return holds
+	}
 
-	// Create connection info for the advanced SSH client
-	connInfo := &ssh.ConnectionInfo{
-		Host:       device.IPAddress,
-		Port:       device.SSHPort,
-		Username:   device.Username,
-		Password:   "placeholder", // TODO: Decrypt device.PasswordEncrypted
-		AuthMethod: ssh.AuthPassword,
+	preconditionOutput, preconditionShared := "", precondition == nil
+	if precondition != nil {
+		preconditionOutput, preconditionShared = outputs[precondition.Command]
+	}
+	if preconditionShared {
+		if precondition != nil && !applies(preconditionOutput) {
+			return result, nil
+		}
+		// Shared output only holds the combined stream of commands that
+		// exited cleanly, so rules looking at more run their command
+		// themselves
+		if output, ok := outputs[effective.Command]; ok && !effective.needsOwnCommand() {
+			e.applyOutput(&result, output, effective)
+			return result, nil
+		}
 	}
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
 	defer cancel()
 
+	// Wait for a free session slot before connecting
+	release, ok := e.acquireSession(ctx, client)
+	if !ok {
+		e.setMessage(&result, catalog.NewMessage(catalog.MsgSessionWaitTimeout,
+			catalog.Params{"timeout": e.timeout.String()}))
+		return result, nil
+	}
+	defer release()
+
 	// Connect to device via SSH
-	conn, err := e.sshClient.Connect(ctx, connInfo)
+	conn, err := e.connect(ctx, client, device)
 	if err != nil {
-		result.Message = fmt.Sprintf("SSH connection failed: %s", err.Error())
+		e.setMessage(&result, catalog.NewMessage(catalog.MsgSSHConnectFailed, catalog.Params{"error": err.Error()}))
 		return result, nil // Return result with error status, don't fail the entire check
 	}
-	defer e.sshClient.Disconnect(conn)
+	defer client.Disconnect(conn)
+	result.Phases = connectionTimings(client, conn)
+
+	if !preconditionShared {
+		preResult, err := e.executeCommand(ctx, client, conn, device, precondition.Command)
+		if preResult != nil {
+			result.Phases = result.Phases.Merge(preResult.Timings)
+		}
+		if err != nil {
+			e.recordTimings(device, result.Phases)
+			e.setMessage(&result, catalog.NewMessage(catalog.MsgCommandFailed,
+				catalog.Params{"error": fmt.Sprintf("precondition: %v", err)}))
+			return result, nil
+		}
+		if outputs != nil {
+			outputs[precondition.Command] = preResult.Output
+		}
+		if !applies(preResult.Output) {
+			e.recordTimings(device, result.Phases)
+			return result, nil
+		}
+		if output, ok := outputs[effective.Command]; ok && !effective.needsOwnCommand() {
+			e.recordTimings(device, result.Phases)
+			e.applyOutput(&result, output, effective)
+			return result, nil
+		}
+	}
 
 	// Execute the command
-	cmdResult, err := e.sshClient.ExecuteCommand(ctx, conn, rule.Command)
-	if err != nil {
-		result.Message = fmt.Sprintf("Command execution failed: %s", err.Error())
+	cmdResult, err := e.executeCommand(ctx, client, conn, device, effective.Command)
+	if cmdResult != nil {
+		result.Phases = result.Phases.Merge(cmdResult.Timings)
+	}
+	e.recordTimings(device, result.Phases)
+	// A nonzero exit status is only a failure to run the command for rules
+	// that do not expect one
+	if err != nil && !(effective.ExpectedExitCode != nil && cmdResult.NonZeroExit()) {
+		e.setMessage(&result, catalog.NewMessage(catalog.MsgCommandFailed, catalog.Params{"error": err.Error()}))
 		return result, nil
 	}
 
-	result.Evidence = cmdResult.Output
+	if err == nil {
+		e.recordSnapshotOutput(outputs, device.Vendor, effective.Command, cmdResult.Output)
+		e.recordVersionOutput(outputs, device.Vendor, effective.Command, cmdResult.Output)
+		e.captureOutput(client, device, effective.Command, cmdResult.Output)
+	}
+	e.applyCommandResult(&result, cmdResult, effective)
+	return result, nil
+}
+
+// commandOutputs returns the command outputs shared by the rules of one
+// device run, seeded with the batched output when batching is enabled
+func (e *Engine) commandOutputs(client ssh.SSHClientInterface, device *device.Device, rules []SecurityRule) map[string]string {
+	// Devices under maintenance are not contacted at all
+	if device.IsInMaintenance() {
+		return make(map[string]string)
+	}
+	if outputs := e.runBatch(client, device, rules); outputs != nil {
+		return outputs
+	}
+	return make(map[string]string)
+}
+
+// applyOutput records combined command output as evidence and evaluates it
+func (e *Engine) applyOutput(result *CheckResult, output string, rule SecurityRule) {
+	e.recordEvidence(result, output, rule)
+	result.EvidenceStream = StreamCombined
 
 	// Evaluate the result against expected pattern
-	status, message := e.evaluateRuleResult(cmdResult.Output, rule)
+	status, message := e.evaluateRule(output, rule)
 	result.Status = string(status)
-	result.Message = message
+	e.setMessage(result, message)
+}
 
-	return result, nil
+// deviceConnectionInfo builds the SSH connection info for a device
+func deviceConnectionInfo(device *device.Device) *ssh.ConnectionInfo {
+	return &ssh.ConnectionInfo{
+		Host:            device.IPAddress,
+		Port:            device.SSHPort,
+		Username:        device.Username,
+		Password:        "placeholder", // TODO: Decrypt device.PasswordEncrypted
+		AuthMethod:      ssh.AuthPassword,
+		HostKeyCallback: DeviceHostKeyCallback(device),
+	}
+}
+
+// DeviceHostKeyCallback returns the host key verification of a device's
+// host key policy. It is nil for TOFU, leaving verification to the SSH
+// client's trust on first use and host key pins.
+func DeviceHostKeyCallback(dev *device.Device) ssh.HostKeyCallback {
+	switch dev.EffectiveHostKeyPolicy() {
+	case device.HostKeyPolicyStrict:
+		return ssh.StrictHostKeyCallback(dev.HostKeyFingerprint)
+	case device.HostKeyPolicyInsecure:
+		return ssh.InsecureHostKeyCallback()
+	default:
+		return nil
+	}
+}
+
+// acquireSession waits for a free SSH session slot for a command sent with
+// client. The returned function releases the slot; ok is false when ctx
+// ends first.
+func (e *Engine) acquireSession(ctx context.Context, client ssh.SSHClientInterface) (release func(), ok bool) {
+	// An open device shell holds its slot for the whole run
+	if shell, isShell := client.(*deviceShell); isShell && shell.open() {
+		return func() {}, true
+	}
+
+	slots := e.sessionSemaphore()
+	if slots == nil {
+		return func() {}, true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// applyCommandResult records the stream the rule targets as evidence and
+// evaluates it together with the command's exit status
+func (e *Engine) applyCommandResult(result *CheckResult, cmdResult *ssh.CommandResult, rule SecurityRule) {
+	result.EvidenceStream = rule.streamOf()
+	e.recordEvidence(result, commandStream(cmdResult, result.EvidenceStream), rule)
+
+	status, message := e.evaluateCommandResult(cmdResult, rule)
+	result.Status = string(status)
+	e.setMessage(result, message)
+}
+
+// commandStream returns one stream of a command's output. Clients that do
+// not capture the streams separately only fill Output, which then stands in
+// for standard output.
+func commandStream(cmdResult *ssh.CommandResult, stream string) string {
+	switch stream {
+	case StreamStdout:
+		if cmdResult.Stdout == "" && cmdResult.Stderr == "" {
+			return cmdResult.Output
+		}
+		return cmdResult.Stdout
+	case StreamStderr:
+		return cmdResult.Stderr
+	}
+	return cmdResult.Output
+}
+
+// evaluateCommandResult evaluates a command's exit status and the stream the
+// rule targets. A rule expecting an exit status fails on any other status;
+// without an expected pattern the status alone decides the result.
+func (e *Engine) evaluateCommandResult(cmdResult *ssh.CommandResult, rule SecurityRule) (CheckStatus, catalog.Message) {
+	if rule.ExpectedExitCode != nil {
+		if cmdResult.ExitCode != *rule.ExpectedExitCode {
+			return StatusFail, catalog.NewMessage(catalog.MsgExitCodeMismatch, catalog.Params{
+				"actual":   strconv.Itoa(cmdResult.ExitCode),
+				"expected": strconv.Itoa(*rule.ExpectedExitCode),
+			})
+		}
+		if rule.ExpectedPattern == "" {
+			return StatusPass, catalog.NewMessage(catalog.MsgCheckPassed, nil)
+		}
+	}
+	return e.evaluateRule(commandStream(cmdResult, rule.streamOf()), rule)
 }
 
-// evaluateRuleResult evaluates command output against rule expectations
+// evaluateRuleResult evaluates command output against rule expectations and
+// renders the outcome in the default locale
 func (e *Engine) evaluateRuleResult(output string, rule SecurityRule) (CheckStatus, string) {
+	status, message := e.evaluateRule(output, rule)
+	return status, message.Render(catalog.DefaultLocale)
+}
+
+// evaluateRule evaluates command output against rule expectations. The
+// evaluation runs under the engine's pattern timeout; a match still running
+// at the deadline is abandoned to finish in the background and the rule
+// reports an error instead of holding up the run.
+func (e *Engine) evaluateRule(output string, rule SecurityRule) (CheckStatus, catalog.Message) {
+	if e.patternTimeout <= 0 {
+		return e.evaluatePatterns(output, rule)
+	}
+
+	type evaluation struct {
+		status  CheckStatus
+		message catalog.Message
+	}
+	done := make(chan evaluation, 1)
+	go func() {
+		status, message := e.evaluatePatterns(output, rule)
+		done <- evaluation{status, message}
+	}()
+
+	timer := time.NewTimer(e.patternTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		return result.status, result.message
+	case <-timer.C:
+		return StatusError, catalog.NewMessage(catalog.MsgPatternTimeout, catalog.Params{
+			"pattern": rule.ExpectedPattern,
+			"timeout": e.patternTimeout.String(),
+		})
+	}
+}
+
+// evaluatePatterns matches command output against a rule's patterns
+func (e *Engine) evaluatePatterns(output string, rule SecurityRule) (CheckStatus, catalog.Message) {
 	if rule.ExpectedPattern == "" {
-		return StatusWarning, "No expected pattern defined for rule"
+		return StatusWarning, catalog.NewMessage(catalog.MsgNoPattern, nil)
 	}
 
 	// Compile regex pattern
-	regex, err := regexp.Compile(rule.ExpectedPattern)
+	regex, err := CompilePattern(rule.ExpectedPattern)
 	if err != nil {
-		return StatusError, fmt.Sprintf("Invalid regex pattern: %s", err.Error())
+		return StatusError, catalog.NewMessage(catalog.MsgInvalidPattern, catalog.Params{"error": err.Error()})
+	}
+
+	if rule.AllMatch {
+		return e.evaluateSections(output, rule, regex)
 	}
 
 	// Check if pattern matches
-	if regex.MatchString(output) {
-		return StatusPass, "Configuration check passed"
+	if e.match(regex, output) {
+		return StatusPass, catalog.NewMessage(catalog.MsgCheckPassed, nil)
 	}
 
 	// Pattern doesn't match - this could be a security issue
-	return StatusFail, fmt.Sprintf("Configuration does not match expected pattern: %s", rule.ExpectedPattern)
+	return StatusFail, catalog.NewMessage(catalog.MsgPatternMismatch, catalog.Params{"pattern": rule.ExpectedPattern})
+}
+
+// evaluateSections checks that every section of the output matches the expected pattern
+func (e *Engine) evaluateSections(output string, rule SecurityRule, regex *regexp.Regexp) (CheckStatus, catalog.Message) {
+	if rule.SectionPattern == "" {
+		return StatusError, catalog.NewMessage(catalog.MsgNoSectionPattern, nil)
+	}
+
+	sectionRegex, err := CompilePattern(rule.SectionPattern)
+	if err != nil {
+		return StatusError, catalog.NewMessage(catalog.MsgInvalidSectionPattern, catalog.Params{"error": err.Error()})
+	}
+
+	sections := splitSections(output, sectionRegex)
+	if len(sections) == 0 {
+		return StatusWarning, catalog.NewMessage(catalog.MsgNoSections, catalog.Params{"pattern": rule.SectionPattern})
+	}
+
+	var failing []string
+	for _, section := range sections {
+		if !e.match(regex, section) {
+			failing = append(failing, strings.TrimSpace(strings.SplitN(section, "\n", 2)[0]))
+		}
+	}
+
+	if len(failing) > 0 {
+		return StatusFail, catalog.NewMessage(catalog.MsgSectionsFailed, catalog.Params{
+			"failed":   strconv.Itoa(len(failing)),
+			"total":    strconv.Itoa(len(sections)),
+			"pattern":  rule.ExpectedPattern,
+			"sections": strings.Join(failing, ", "),
+		})
+	}
+
+	return StatusPass, catalog.NewMessage(catalog.MsgSectionsPassed, catalog.Params{"total": strconv.Itoa(len(sections))})
+}
+
+// match reports whether a rule pattern matches text
+func (e *Engine) match(regex *regexp.Regexp, text string) bool {
+	if e.matchPattern != nil {
+		return e.matchPattern(regex, text)
+	}
+	return regex.MatchString(text)
+}
+
+// splitSections splits output into sections. Each section starts at a line
+// matching the section pattern and runs up to the next such line; lines
+// before the first section header are ignored.
+func splitSections(output string, sectionRegex *regexp.Regexp) []string {
+	var sections []string
+	var current []string
+
+	for _, line := range strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n") {
+		if sectionRegex.MatchString(line) {
+			if current != nil {
+				sections = append(sections, strings.Join(current, "\n"))
+			}
+			current = []string{line}
+			continue
+		}
+		if current != nil {
+			current = append(current, line)
+		}
+	}
+
+	if current != nil {
+		sections = append(sections, strings.Join(current, "\n"))
+	}
+
+	return sections
+}
+
+// ProgressSkippedExcluded is the progress status of a device a bulk run
+// left alone because of an excluded tag
+const ProgressSkippedExcluded = "skipped (excluded)"
+
+// SetExcludeTags makes bulk runs skip devices carrying any of the tags, for
+// example ["production"] to rehearse a scan without touching production.
+// Tags match exactly against each comma-separated device tag. It must not be
+// called while checks are running.
+func (e *Engine) SetExcludeTags(tags []string) {
+	e.excludeTags = nil
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			e.excludeTags = append(e.excludeTags, tag)
+		}
+	}
 }
 
-// RunBulkChecks executes checks on multiple devices with parallel processing
+// ExcludeTags returns the device tags bulk runs skip
+func (e *Engine) ExcludeTags() []string {
+	return append([]string(nil), e.excludeTags...)
+}
+
+// excludedTag returns the first tag of the device that bulk runs exclude
+func (e *Engine) excludedTag(dev *device.Device) (string, bool) {
+	for _, tag := range dev.TagList() {
+		for _, excluded := range e.excludeTags {
+			if tag == excluded {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
+
+// RunBulkChecks executes checks on multiple devices with parallel processing.
+// Devices carrying an excluded tag are skipped; see SetExcludeTags.
 func (e *Engine) RunBulkChecks(devices []device.Device) (map[string][]CheckResult, error) {
 	return e.RunBulkChecksWithProgress(devices, nil)
 }
 
 // RunBulkChecksWithProgress executes checks on multiple devices with progress reporting
 func (e *Engine) RunBulkChecksWithProgress(devices []device.Device, progressCallback ProgressCallback) (map[string][]CheckResult, error) {
+	return e.RunBulkChecksWithOptions(devices, CheckOptions{}, progressCallback)
+}
+
+// RunBulkChecksWithOptions executes checks on multiple devices with the given
+// options and progress reporting
+func (e *Engine) RunBulkChecksWithOptions(devices []device.Device, opts CheckOptions, progressCallback ProgressCallback) (map[string][]CheckResult, error) {
+	bulk, err := e.RunBulkChecksContext(context.Background(), devices, opts, progressCallback)
+	if err != nil {
+		return nil, err
+	}
+	return bulk.DeviceResults, nil
+}
+
+// RunBulkChecksContext executes checks on multiple devices like
+// RunBulkChecksWithOptions and also returns each device's progress and
+// error. Cancelling ctx stops every device before its next rule; the
+// results finished by then are returned with ctx's error.
+func (e *Engine) RunBulkChecksContext(parent context.Context, devices []device.Device, opts CheckOptions, progressCallback ProgressCallback) (*BulkCheckResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	client, err := e.clientFor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	bulk := &BulkCheckResult{
+		DeviceResults: make(map[string][]CheckResult),
+		Progress:      make(map[string]*CheckProgress),
+		Errors:        make(map[string]CheckError),
+	}
 	if len(devices) == 0 {
-		return make(map[string][]CheckResult), nil
+		return bulk, nil
 	}
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), e.timeout*time.Duration(len(devices)))
+	ctx, cancel := context.WithTimeout(parent, e.timeout*time.Duration(len(devices)))
 	defer cancel()
 
 	// Initialize result structures
-	results := make(map[string][]CheckResult)
-	progress := make(map[string]*CheckProgress)
+	results := bulk.DeviceResults
+	progress := bulk.Progress
 	errors := make(map[string]error)
 
 	// Mutex for thread-safe access to shared data
@@ -264,18 +1055,53 @@ func (e *Engine) RunBulkChecksWithProgress(devices []device.Device, progressCall
 
 	// Create worker pool
 	var wg sync.WaitGroup
-	for i := 0; i < e.workerCount; i++ {
+	if minWorkers, maxWorkers, adaptive := e.GetWorkerCountRange(); adaptive {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			e.worker(ctx, jobs, &mu, results, progress, errors, progressCallback)
+			e.runAdaptivePool(ctx, jobs, minWorkers, maxWorkers, func(job CheckJob) bool {
+				return e.handleJob(ctx, job, &mu, results, progress, errors, progressCallback)
+			})
 		}()
+	} else {
+		for i := 0; i < e.workerCount; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				e.worker(ctx, jobs, &mu, results, progress, errors, progressCallback)
+			}()
+		}
 	}
 
+	// Every device checked by this call shares one run ID
+	runID := uuid.New().String()
+
 	// Send jobs to workers
 	for _, dev := range devices {
 		deviceCopy := dev // Create copy to avoid race conditions
-		applicableRules := e.GetSecurityRules(deviceCopy.Vendor)
+
+		// Excluded devices are reported but never contacted
+		if tag, excluded := e.excludedTag(&deviceCopy); excluded {
+			mu.Lock()
+			progress[deviceCopy.ID] = &CheckProgress{
+				DeviceID:   deviceCopy.ID,
+				DeviceName: deviceCopy.Name,
+				Status:     ProgressSkippedExcluded,
+				Error:      fmt.Sprintf("device is tagged %q, which is excluded from bulk runs", tag),
+				UpdatedAt:  time.Now(),
+			}
+			mu.Unlock()
+			if progressCallback != nil {
+				progressCallback(progress[deviceCopy.ID])
+			}
+			continue
+		}
+
+		applicableRules, skipped := opts.restrictRules(
+			e.securityRules(ctx, deviceCopy.Vendor), e.skippedRules(ctx, &deviceCopy))
+		overrides := e.severityOverridesFor(&deviceCopy)
+		applicableRules, skipped = suppressRules(&deviceCopy, applicableRules, skipped, overrides)
+		applicableRules = orderByDependencies(applicableRules)
 
 		// Initialize progress for this device
 		mu.Lock()
@@ -285,6 +1111,7 @@ func (e *Engine) RunBulkChecksWithProgress(devices []device.Device, progressCall
 			Status:     "queued",
 			Progress:   0,
 			Total:      len(applicableRules),
+			Skipped:    len(skipped),
 			UpdatedAt:  time.Now(),
 		}
 		mu.Unlock()
@@ -294,8 +1121,13 @@ func (e *Engine) RunBulkChecksWithProgress(devices []device.Device, progressCall
 		}
 
 		jobs <- CheckJob{
-			Device: &deviceCopy,
-			Rules:  applicableRules,
+			Device:    &deviceCopy,
+			Rules:     applicableRules,
+			Skipped:   skipped,
+			RunID:     runID,
+			Client:    client,
+			Options:   opts,
+			Overrides: overrides,
 		}
 	}
 	close(jobs)
@@ -303,7 +1135,13 @@ func (e *Engine) RunBulkChecksWithProgress(devices []device.Device, progressCall
 	// Wait for all workers to complete
 	wg.Wait()
 
-	return results, nil
+	for id, err := range errors {
+		bulk.Errors[id] = NewCheckError(err)
+	}
+	if err := parent.Err(); err != nil {
+		return bulk, err
+	}
+	return bulk, nil
 }
 
 // worker processes security check jobs from the job channel
@@ -312,57 +1150,83 @@ func (e *Engine) worker(ctx context.Context, jobs <-chan CheckJob, mu *sync.Mute
 	errors map[string]error, progressCallback ProgressCallback) {
 
 	for job := range jobs {
-		select {
-		case <-ctx.Done():
-			// Context cancelled, stop processing
-			mu.Lock()
-			if prog, exists := progress[job.Device.ID]; exists {
-				prog.Status = "cancelled"
-				prog.Error = "Operation cancelled due to timeout"
-				prog.UpdatedAt = time.Now()
-			}
-			mu.Unlock()
+		if !e.handleJob(ctx, job, mu, results, progress, errors, progressCallback) {
 			return
-		default:
-			// Process the job
-			deviceResults, err := e.runChecksForJob(job, mu, progress, progressCallback)
+		}
+	}
+}
 
-			mu.Lock()
-			if err != nil {
-				errors[job.Device.ID] = err
-				if prog, exists := progress[job.Device.ID]; exists {
-					prog.Status = "error"
-					prog.Error = err.Error()
-					prog.UpdatedAt = time.Now()
-				}
-			} else {
-				results[job.Device.ID] = deviceResults
-				if prog, exists := progress[job.Device.ID]; exists {
-					prog.Status = "completed"
-					prog.Progress = prog.Total
-					prog.CurrentRule = ""
-					prog.UpdatedAt = time.Now()
-				}
-			}
-			mu.Unlock()
+// handleJob runs one job and records its outcome. It returns false when the
+// run was cancelled and the worker should stop.
+func (e *Engine) handleJob(ctx context.Context, job CheckJob, mu *sync.Mutex,
+	results map[string][]CheckResult, progress map[string]*CheckProgress,
+	errors map[string]error, progressCallback ProgressCallback) bool {
 
-			// Report final progress
-			if progressCallback != nil {
-				mu.Lock()
-				if prog, exists := progress[job.Device.ID]; exists {
-					progressCallback(prog)
-				}
-				mu.Unlock()
-			}
+	select {
+	case <-ctx.Done():
+		// Context cancelled, stop processing
+		mu.Lock()
+		if prog, exists := progress[job.Device.ID]; exists {
+			prog.Status = "cancelled"
+			prog.Error = "Operation cancelled due to timeout"
+			prog.ErrorCode = ErrorCodeTimeout
+			prog.UpdatedAt = time.Now()
+		}
+		mu.Unlock()
+		return false
+	default:
+	}
+
+	// Process the job
+	deviceResults, err := e.runChecksForJob(ctx, job, mu, progress, progressCallback)
+
+	mu.Lock()
+	if err != nil {
+		// Rules finished before a cancellation are kept
+		if len(deviceResults) > 0 {
+			results[job.Device.ID] = deviceResults
+		}
+		errors[job.Device.ID] = err
+		if prog, exists := progress[job.Device.ID]; exists {
+			prog.Status = "error"
+			prog.Error = err.Error()
+			prog.ErrorCode = ErrorCode(err)
+			prog.UpdatedAt = time.Now()
+		}
+	} else {
+		results[job.Device.ID] = deviceResults
+		if prog, exists := progress[job.Device.ID]; exists {
+			prog.Status = "completed"
+			prog.Progress = prog.Total
+			prog.CurrentRule = ""
+			prog.UpdatedAt = time.Now()
+		}
+	}
+	mu.Unlock()
+
+	// Report final progress
+	if progressCallback != nil {
+		mu.Lock()
+		if prog, exists := progress[job.Device.ID]; exists {
+			progressCallback(prog)
 		}
+		mu.Unlock()
 	}
+
+	return true
 }
 
 // runChecksForJob executes security checks for a specific job
-func (e *Engine) runChecksForJob(job CheckJob, mu *sync.Mutex,
+func (e *Engine) runChecksForJob(ctx context.Context, job CheckJob, mu *sync.Mutex,
 	progress map[string]*CheckProgress, progressCallback ProgressCallback) ([]CheckResult, error) {
 
 	var results []CheckResult
+	started := time.Now()
+
+	skipped := append([]SkippedRule(nil), job.Skipped...)
+	defer func() {
+		e.recordSkippedRules(ctx, job.RunID, skipped)
+	}()
 
 	// Update progress to running
 	mu.Lock()
@@ -380,9 +1244,28 @@ func (e *Engine) runChecksForJob(job CheckJob, mu *sync.Mutex,
 		mu.Unlock()
 	}
 
+	client := job.Client
+	if client == nil {
+		client = e.networkClient()
+	}
+	incremental := e.startIncremental(client, job.Device, job.Rules, job.Options)
+	outputs := e.commandOutputs(client, job.Device, incremental.pending(job.Rules))
+	incremental.seed(outputs)
+	prerequisites := newPrerequisiteTracker(job.Rules)
+	var circuit connectCircuit
+
 	// Execute each rule
 	for i, rule := range job.Rules {
-		if !rule.Enabled {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		if reason := e.runSkipReason(rule, &circuit); reason != "" {
+			skipped = append(skipped, newSkippedRule(job.Device, rule, reason))
+			mu.Lock()
+			if prog, exists := progress[job.Device.ID]; exists {
+				prog.Skipped = len(skipped)
+			}
+			mu.Unlock()
 			continue
 		}
 
@@ -403,7 +1286,19 @@ func (e *Engine) runChecksForJob(job CheckJob, mu *sync.Mutex,
 			mu.Unlock()
 		}
 
-		result, err := e.executeRule(job.Device, rule)
+		var result CheckResult
+		var err error
+		prerequisite, status, blocked := prerequisites.failed(rule)
+		if blocked {
+			result = e.prerequisiteResult(job.Device, rule, prerequisite, status)
+			mu.Lock()
+			if prog, exists := progress[job.Device.ID]; exists {
+				prog.NotApplicable++
+			}
+			mu.Unlock()
+		} else {
+			result, err = e.executeOrCarryRule(client, job.Device, rule, outputs, incremental)
+		}
 		if err != nil {
 			// Create error result but continue with other rules
 			result = CheckResult{
@@ -413,25 +1308,140 @@ func (e *Engine) runChecksForJob(job CheckJob, mu *sync.Mutex,
 				CheckType: "configuration",
 				Severity:  rule.Severity,
 				Status:    string(StatusError),
-				Message:   fmt.Sprintf("Check execution failed: %s", err.Error()),
 				Evidence:  "",
 				CheckedAt: time.Now(),
 			}
+			rule.setFinding(&result)
+			e.setMessage(&result, catalog.NewMessage(catalog.MsgExecutionFailed, catalog.Params{"error": err.Error()}))
+		}
+		result.RunID = job.RunID
+		applySeverityOverride(&result, rule.ID, job.Overrides)
+		if !blocked {
+			incremental.record(rule, result)
+			circuit.record(result)
+		}
+		prerequisites.record(rule, result)
+		if result.MessageID == catalog.MsgMaintenanceWindow {
+			skipped = append(skipped, newSkippedRule(job.Device, rule, SkipReasonMaintenance))
+			mu.Lock()
+			if prog, exists := progress[job.Device.ID]; exists {
+				prog.Skipped = len(skipped)
+			}
+			mu.Unlock()
 		}
 
 		results = append(results, result)
 	}
 
+	incremental.finish(job.RunID)
+	e.archiveConfig(client, job.Device, outputs)
+	e.recordInventory(client, job.Device, outputs)
+
+	if result, ok := e.postureResult(job.Device, started); ok {
+		result.RunID = job.RunID
+		results = append(results, result)
+	}
+
 	return results, nil
 }
 
-// GetSecurityRules returns security rules for a specific vendor
+// CommandsForDevice returns the commands a check run with opts may send to
+// a device, fully expanded and in the order they are first sent, without
+// connecting. The shell setup commands come first when the vendor's runs
+// keep a shell open, then the config command an incremental run compares.
+// A rule's precondition command comes before its own, prerequisites come
+// before their dependents, and a command several rules share is listed
+// once. The config command archived after the run comes last when config
+// snapshots are on. Rules a run skips are left out, and nothing is sent to
+// devices under maintenance. It fails when a rule's macros cannot be
+// expanded, so nothing is approved or recorded that differs from what runs.
+func (e *Engine) CommandsForDevice(device *device.Device, opts CheckOptions) ([]string, error) {
+	if device == nil {
+		return nil, fmt.Errorf("device is required")
+	}
+
+	commands := []string{}
+	if device.IsInMaintenance() {
+		return commands, nil
+	}
+
+	seen := make(map[string]bool)
+	add := func(command string) {
+		if command != "" && !seen[command] {
+			seen[command] = true
+			commands = append(commands, command)
+		}
+	}
+	expand := func(raw string) error {
+		command, err := e.expandCommand(raw)
+		if err != nil {
+			return err
+		}
+		add(command)
+		return nil
+	}
+
+	if config, enabled := e.shellConfigs[device.Vendor]; enabled {
+		add(config.EnableCommand)
+		for _, command := range config.SetupCommands {
+			add(command)
+		}
+	}
+	snapshot := e.snapshotCommand(device.Vendor)
+	if opts.Incremental && e.resultStore != nil {
+		add(snapshot)
+	}
+
+	rules, _ := opts.restrictRules(e.GetSecurityRules(device.Vendor), nil)
+	rules, _ = suppressRules(device, rules, nil, e.severityOverridesFor(device))
+	for _, rule := range orderByDependencies(rules) {
+		if !rule.Enabled || e.excludedAsBroken(rule) {
+			continue
+		}
+		effective, _ := rule.ForDevice(device.Vendor, device.DeviceType)
+		if effective.Precondition != nil {
+			if err := expand(effective.Precondition.Command); err != nil {
+				return nil, fmt.Errorf("precondition of rule %s: %w", rule.Name, err)
+			}
+		}
+		if err := expand(effective.Command); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+
+	if e.snapshotStore != nil {
+		add(snapshot)
+	}
+	return commands, nil
+}
+
+// PreviewCommands returns the commands a check run with default options
+// sends to a device, for approval before the run. It fails when a rule's
+// macros cannot be expanded.
+func (e *Engine) PreviewCommands(device *device.Device) ([]string, error) {
+	return e.CommandsForDevice(device, CheckOptions{})
+}
+
+// GetSecurityRules returns the enabled security rules for a specific vendor.
+// Rules are cached per vendor until they change or the cache expires.
 func (e *Engine) GetSecurityRules(vendorType string) []SecurityRule {
+	return e.securityRules(context.Background(), vendorType)
+}
+
+// securityRules is GetSecurityRules with the context of the run asking
+func (e *Engine) securityRules(ctx context.Context, vendorType string) []SecurityRule {
 	if e.ruleManager == nil {
 		return []SecurityRule{}
 	}
 
-	rules, err := e.ruleManager.GetRulesByVendor(vendorType)
+	if rules, ok := e.cachedRules(vendorType); ok {
+		return rules
+	}
+
+	// Read the generation first so a change made during the query leaves
+	// the entry stale rather than caching outdated rules as current
+	generation := e.ruleManager.Generation()
+	rules, err := e.ruleManager.GetRulesByVendorContext(ctx, vendorType)
 	if err != nil {
 		// Log error and return empty slice
 		return []SecurityRule{}
@@ -445,21 +1455,156 @@ func (e *Engine) GetSecurityRules(vendorType string) []SecurityRule {
 		}
 	}
 
+	e.cacheRules(vendorType, enabledRules, generation)
 	return enabledRules
 }
 
-// LoadCustomRules loads custom security rules into the database
+// GetSkippedRules returns the rules that will not be evaluated against a device and why
+func (e *Engine) GetSkippedRules(device *device.Device) []SkippedRule {
+	return e.skippedRules(context.Background(), device)
+}
+
+// skippedRules is GetSkippedRules with the context of the run asking
+func (e *Engine) skippedRules(ctx context.Context, device *device.Device) []SkippedRule {
+	if e.ruleManager == nil {
+		return []SkippedRule{}
+	}
+
+	rules, err := e.ruleManager.GetAllRulesContext(ctx)
+	if err != nil {
+		return []SkippedRule{}
+	}
+
+	var skipped []SkippedRule
+	for _, rule := range rules {
+		switch {
+		case !rule.AppliesToVendor(device.Vendor):
+			skipped = append(skipped, newSkippedRule(device, rule, SkipReasonVendor))
+		case !rule.Enabled:
+			skipped = append(skipped, newSkippedRule(device, rule, SkipReasonDisabled))
+		case e.excludedAsBroken(rule):
+			skipped = append(skipped, newSkippedRule(device, rule, SkipReasonNeedsAttention))
+		}
+	}
+
+	return skipped
+}
+
+// restrictRules keeps the rules of a device's run that RuleIDs selects and
+// the skip records of those rules. A run of a few rules records nothing
+// about the rules it was not asked to run.
+func (o CheckOptions) restrictRules(rules []SecurityRule, skipped []SkippedRule) ([]SecurityRule, []SkippedRule) {
+	if len(o.RuleIDs) == 0 {
+		return rules, skipped
+	}
+
+	wanted := make(map[string]bool, len(o.RuleIDs))
+	for _, id := range o.RuleIDs {
+		wanted[id] = true
+	}
+	var selected []SecurityRule
+	for _, rule := range rules {
+		if wanted[rule.ID] {
+			selected = append(selected, rule)
+		}
+	}
+	var kept []SkippedRule
+	for _, skip := range skipped {
+		if wanted[skip.RuleID] {
+			kept = append(kept, skip)
+		}
+	}
+	return selected, kept
+}
+
+// CircuitBreakerThreshold is the number of consecutive failed connections
+// to a device after which the rest of its run is skipped as circuit-open
+const CircuitBreakerThreshold = 3
+
+// connectCircuit counts the consecutive failed connections of a device run
+type connectCircuit struct {
+	failures int
+}
+
+// open reports whether the device has refused enough connections in a row
+// that its remaining rules are not worth trying
+func (c *connectCircuit) open() bool {
+	return c.failures >= CircuitBreakerThreshold
+}
+
+// record counts an evaluated rule's result towards opening the circuit
+func (c *connectCircuit) record(result CheckResult) {
+	if result.MessageID == catalog.MsgSSHConnectFailed {
+		c.failures++
+	} else {
+		c.failures = 0
+	}
+}
+
+// runSkipReason returns why a rule of a running device run is skipped, or
+// an empty reason when it is evaluated
+func (e *Engine) runSkipReason(rule SecurityRule, circuit *connectCircuit) SkipReason {
+	switch {
+	case !rule.Enabled:
+		return SkipReasonDisabled
+	case e.excludedAsBroken(rule):
+		return SkipReasonNeedsAttention
+	case circuit.open():
+		return SkipReasonCircuitOpen
+	}
+	return ""
+}
+
+// newSkippedRule builds a skip record for a rule on a device
+func newSkippedRule(device *device.Device, rule SecurityRule, reason SkipReason) SkippedRule {
+	return SkippedRule{
+		DeviceID:  device.ID,
+		RuleID:    rule.ID,
+		RuleName:  rule.Name,
+		Reason:    reason,
+		SkippedAt: time.Now(),
+	}
+}
+
+// recordSkippedRules persists the skip records of a run without failing
+// it. The records are kept even for a cancelled run, so only ctx's values
+// are used.
+func (e *Engine) recordSkippedRules(ctx context.Context, runID string, skipped []SkippedRule) {
+	if e.ruleManager == nil || len(skipped) == 0 {
+		return
+	}
+	for i := range skipped {
+		skipped[i].RunID = runID
+	}
+
+	if err := e.ruleManager.SaveSkippedRulesContext(context.WithoutCancel(ctx), skipped); err != nil {
+		log.Printf("Failed to record skipped rules: %v", err)
+	}
+}
+
+// LoadCustomRules loads custom security rules into the database. Rules
+// whose dependencies would form a cycle with each other or the stored
+// rules are rejected before any is created.
 func (e *Engine) LoadCustomRules(rules []SecurityRule) error {
 	if e.ruleManager == nil {
 		return fmt.Errorf("rule manager not initialized")
 	}
 
+	stored, err := e.ruleManager.GetAllRulesContext(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to read stored rules: %w", err)
+	}
+	if err := ValidateRuleDependencies(append(stored, rules...)); err != nil {
+		return err
+	}
+
 	for _, rule := range rules {
 		if err := e.ruleManager.CreateRule(rule); err != nil {
 			return fmt.Errorf("failed to create rule %s: %w", rule.Name, err)
 		}
 	}
 
+	e.InvalidateRulesCache()
 	return nil
 }
 
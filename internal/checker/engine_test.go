@@ -3,51 +3,155 @@ package checker
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
-// MockSSHClient is a mock implementation of SSHClient for testing
+// MockSSHClient is a testify mock of ssh.SSHClientInterface
 type MockSSHClient struct {
 	mock.Mock
 }
 
-func (m *MockSSHClient) Connect(device *device.Device) (*MockSession, error) {
-	args := m.Called(device)
+var _ ssh.SSHClientInterface = (*MockSSHClient)(nil)
+
+func (m *MockSSHClient) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	args := m.Called(ctx, connInfo)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(*MockSession), args.Error(1)
+	return args.Get(0).(*ssh.SSHConnection), args.Error(1)
 }
 
-func (m *MockSSHClient) ExecuteCommand(session *MockSession, command string) (string, error) {
-	args := m.Called(session, command)
-	return args.String(0), args.Error(1)
+func (m *MockSSHClient) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	args := m.Called(ctx, conn, command)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*ssh.CommandResult), args.Error(1)
 }
 
-func (m *MockSSHClient) Disconnect(session *MockSession) {
-	m.Called(session)
+func (m *MockSSHClient) ExecuteCommands(ctx context.Context, conn *ssh.SSHConnection, commands []string) ([]*ssh.CommandResult, error) {
+	args := m.Called(ctx, conn, commands)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*ssh.CommandResult), args.Error(1)
 }
 
-func (m *MockSSHClient) SetTimeout(timeout time.Duration) {
-	m.Called(timeout)
+func (m *MockSSHClient) Disconnect(conn *ssh.SSHConnection) error {
+	return m.Called(conn).Error(0)
 }
 
-// MockSession represents a mock SSH session
-type MockSession struct {
-	mock.Mock
+func (m *MockSSHClient) Close() error {
+	return m.Called().Error(0)
 }
 
-func (m *MockSession) Close() error {
+func (m *MockSSHClient) GetConnectionStats() map[string]ssh.ConnectionStats {
 	args := m.Called()
-	return args.Error(0)
+	if args.Get(0) == nil {
+		return nil
+	}
+	return args.Get(0).(map[string]ssh.ConnectionStats)
+}
+
+// stubSSHClient is a minimal ssh.SSHClientInterface returning canned command output
+type stubSSHClient struct {
+	outputs  map[string]string
+	mu       sync.Mutex
+	executed []string
+}
+
+func (s *stubSSHClient) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	return &ssh.SSHConnection{}, nil
+}
+
+func (s *stubSSHClient) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	s.mu.Lock()
+	s.executed = append(s.executed, command)
+	s.mu.Unlock()
+	return &ssh.CommandResult{Command: command, Output: s.outputs[command], ExecutedAt: time.Now()}, nil
+}
+
+func (s *stubSSHClient) ExecuteCommands(ctx context.Context, conn *ssh.SSHConnection, commands []string) ([]*ssh.CommandResult, error) {
+	results := make([]*ssh.CommandResult, 0, len(commands))
+	for _, command := range commands {
+		result, _ := s.ExecuteCommand(ctx, conn, command)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *stubSSHClient) Disconnect(conn *ssh.SSHConnection) error {
+	return nil
+}
+
+func (s *stubSSHClient) Close() error {
+	return nil
+}
+
+func (s *stubSSHClient) GetConnectionStats() map[string]ssh.ConnectionStats {
+	return map[string]ssh.ConnectionStats{}
+}
+
+// fakeRuleManager is an in-memory RuleManagerInterface for engine tests
+// that do not need a database
+type fakeRuleManager struct {
+	mu         sync.Mutex
+	rules      []SecurityRule
+	skipped    []SkippedRule
+	generation uint64
+}
+
+var _ RuleManagerInterface = (*RuleManager)(nil)
+
+func (f *fakeRuleManager) GetRulesByVendorContext(ctx context.Context, vendor string) ([]SecurityRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rules []SecurityRule
+	for _, rule := range f.rules {
+		if rule.AppliesToVendor(vendor) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (f *fakeRuleManager) GetAllRulesContext(ctx context.Context) ([]SecurityRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]SecurityRule(nil), f.rules...), nil
+}
+
+func (f *fakeRuleManager) CreateRule(rule SecurityRule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule)
+	f.generation++
+	return nil
+}
+
+func (f *fakeRuleManager) SaveSkippedRulesContext(ctx context.Context, skipped []SkippedRule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.skipped = append(f.skipped, skipped...)
+	return nil
+}
+
+func (f *fakeRuleManager) Generation() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.generation
 }
 
 // setupTestRuleManager creates a test rule manager with in-memory database
@@ -259,6 +363,121 @@ func TestEngine_evaluateRuleResult(t *testing.T) {
 }
 
 // TestEngine_RunChecks tests running security checks on a single device
+// slowSSHClient delays every command it executes
+type slowSSHClient struct {
+	stubSSHClient
+	delay time.Duration
+}
+
+func (s *slowSSHClient) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	time.Sleep(s.delay)
+	return s.stubSSHClient.ExecuteCommand(ctx, conn, command)
+}
+
+func TestEngine_CheckDuration(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &slowSSHClient{stubSSHClient: stubSSHClient{outputs: map[string]string{"show version": "Cisco IOS"}},
+		delay: 20 * time.Millisecond}
+	engine := NewEngineWithSSHClient(rm, client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "rule1", Name: "Version Check", Vendor: "cisco", Command: "show version", ExpectedPattern: "IOS",
+			Severity: string(SeverityHigh), Enabled: true},
+	}))
+
+	dev := &device.Device{ID: "device1", Name: "Test Device", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22}
+	results, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.GreaterOrEqual(t, results[0].Duration, 20*time.Millisecond, "the duration covers the command")
+}
+
+// gatedSSHClient runs each command only once the test releases it
+type gatedSSHClient struct {
+	stubSSHClient
+	release chan struct{}
+}
+
+func (g *gatedSSHClient) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	<-g.release
+	return g.stubSSHClient.ExecuteCommand(ctx, conn, command)
+}
+
+func newStreamTestEngine(t *testing.T) (*Engine, *gatedSSHClient, *device.Device) {
+	rm := setupTestRuleManager(t)
+	client := &gatedSSHClient{
+		stubSSHClient: stubSSHClient{outputs: map[string]string{
+			"show version":     "Cisco IOS",
+			"show ip ssh":      "SSH Enabled - version 2.0",
+			"show run | i aaa": "aaa new-model",
+		}},
+		release: make(chan struct{}),
+	}
+	engine := NewEngineWithSSHClient(rm, client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "a", Name: "A Version", Vendor: "cisco", Command: "show version", ExpectedPattern: "IOS",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "b", Name: "B SSH", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "c", Name: "C AAA", Vendor: "cisco", Command: "show run | i aaa", ExpectedPattern: "aaa new-model",
+			Severity: string(SeverityMedium), Enabled: true},
+	}))
+
+	dev := &device.Device{ID: "device1", Name: "Test Device", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22}
+	return engine, client, dev
+}
+
+// receiveResult waits briefly for the next streamed result
+func receiveResult(t *testing.T, results <-chan CheckResult) CheckResult {
+	t.Helper()
+	select {
+	case result, ok := <-results:
+		require.True(t, ok, "stream closed early")
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result streamed")
+		return CheckResult{}
+	}
+}
+
+func TestEngine_RunChecksStream(t *testing.T) {
+	engine, client, dev := newStreamTestEngine(t)
+	results, errs := engine.RunChecksStream(context.Background(), dev)
+
+	// Each result arrives while the following rules are still blocked
+	var names []string
+	for i := 0; i < 3; i++ {
+		client.release <- struct{}{}
+		result := receiveResult(t, results)
+		assert.Equal(t, string(StatusPass), result.Status)
+		assert.NotEmpty(t, result.RunID)
+		names = append(names, result.CheckName)
+	}
+	assert.Equal(t, []string{"A Version", "B SSH", "C AAA"}, names)
+
+	_, open := <-results
+	assert.False(t, open, "the result channel is closed after the run")
+	assert.NoError(t, <-errs)
+}
+
+func TestEngine_RunChecksStreamCancel(t *testing.T) {
+	engine, client, dev := newStreamTestEngine(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	results, errs := engine.RunChecksStream(ctx, dev)
+
+	client.release <- struct{}{}
+	assert.Equal(t, "A Version", receiveResult(t, results).CheckName)
+
+	// Cancelling ends the run before the rules still to come; closing the
+	// gate lets a command already in flight finish
+	cancel()
+	close(client.release)
+	for range results {
+	}
+	assert.ErrorIs(t, <-errs, context.Canceled)
+}
+
 func TestEngine_RunChecks(t *testing.T) {
 	// Create test device
 	testDevice := &device.Device{
@@ -292,7 +511,7 @@ func TestEngine_RunChecks(t *testing.T) {
 		},
 	}
 
-	t.Run("Successful checks", func(t *testing.T) {
+	t.Run("Unknown vendor", func(t *testing.T) {
 		rm := setupTestRuleManager(t)
 		engine := NewEngine(rm)
 
@@ -300,8 +519,6 @@ func TestEngine_RunChecks(t *testing.T) {
 		err := engine.LoadCustomRules(rules)
 		assert.NoError(t, err)
 
-		// This test would require mocking the SSH client
-		// For now, test that it returns an error when no rules are found
 		testDevice.Vendor = "unknown"
 		results, err := engine.RunChecks(testDevice)
 		assert.Error(t, err)
@@ -325,6 +542,48 @@ func TestEngine_RunChecks(t *testing.T) {
 	})
 }
 
+func TestEngine_RunChecks_Success(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	engine := NewEngine(rm)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "rule1", Name: "Version Check", Vendor: "cisco", Command: "show version", ExpectedPattern: "IOS",
+			Severity: string(SeverityHigh), Enabled: true},
+	}))
+
+	testDevice := &device.Device{ID: "device1", Name: "Test Device", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22}
+	conn := &ssh.SSHConnection{}
+
+	// Each run gets a fresh mock from the factory
+	var clients []*MockSSHClient
+	engine.SetSSHClientFactory(func() ssh.SSHClientInterface {
+		client := &MockSSHClient{}
+		client.On("Connect", mock.Anything, mock.MatchedBy(func(info *ssh.ConnectionInfo) bool {
+			return info.Host == testDevice.IPAddress && info.Port == testDevice.SSHPort && info.Username == testDevice.Username
+		})).Return(conn, nil).Once()
+		client.On("ExecuteCommand", mock.Anything, conn, "show version").
+			Return(&ssh.CommandResult{Command: "show version", Output: "Cisco IOS Software, Version 15.2"}, nil).Once()
+		client.On("Disconnect", conn).Return(nil).Once()
+		clients = append(clients, client)
+		return client
+	})
+
+	for run := 0; run < 2; run++ {
+		results, err := engine.RunChecks(testDevice)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, string(StatusPass), results[0].Status)
+		assert.Equal(t, "Version Check", results[0].CheckName)
+		assert.Equal(t, testDevice.ID, results[0].DeviceID)
+		assert.Equal(t, "Cisco IOS Software, Version 15.2", results[0].Evidence)
+	}
+
+	require.Len(t, clients, 2, "the factory is called once per run")
+	for _, client := range clients {
+		client.AssertExpectations(t)
+	}
+}
+
 // TestEngine_RunBulkChecks tests running security checks on multiple devices
 func TestEngine_RunBulkChecks(t *testing.T) {
 	t.Run("Empty device list", func(t *testing.T) {
@@ -382,6 +641,45 @@ func TestEngine_RunBulkChecks(t *testing.T) {
 	})
 }
 
+func TestEngine_ExcludeTags(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &stubSSHClient{outputs: map[string]string{"show version": "Cisco IOS"}}
+	engine := NewEngineWithSSHClient(rm, client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "rule1", Name: "Version Check", Vendor: "cisco", Command: "show version", ExpectedPattern: "IOS",
+			Severity: string(SeverityHigh), Enabled: true},
+	}))
+	engine.SetExcludeTags([]string{" production ", ""})
+	assert.Equal(t, []string{"production"}, engine.ExcludeTags())
+
+	devices := []device.Device{
+		{ID: "prod", Name: "Prod", IPAddress: "192.168.1.1", Vendor: "cisco", Username: "admin", SSHPort: 22,
+			Tags: "core, production"},
+		{ID: "lab", Name: "Lab", IPAddress: "192.168.1.2", Vendor: "cisco", Username: "admin", SSHPort: 22,
+			Tags: "lab,production-like"},
+	}
+
+	var mu sync.Mutex
+	statuses := make(map[string]string)
+	results, err := engine.RunBulkChecksWithProgress(devices, func(progress *CheckProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		statuses[progress.DeviceID] = progress.Status
+	})
+	require.NoError(t, err)
+
+	assert.NotContains(t, results, "prod")
+	assert.Len(t, results["lab"], 1, "tags only match exactly")
+	assert.Equal(t, ProgressSkippedExcluded, statuses["prod"])
+	assert.Equal(t, "completed", statuses["lab"])
+	assert.Equal(t, []string{"show version"}, client.executed, "excluded devices are never contacted")
+
+	engine.SetExcludeTags(nil)
+	results, err = engine.RunBulkChecks(devices)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
 // TestEngine_RunChecksWithProgress tests progress reporting
 func TestEngine_RunChecksWithProgress(t *testing.T) {
 	rm := setupTestRuleManager(t)
@@ -496,6 +794,312 @@ func TestEngine_worker(t *testing.T) {
 	})
 }
 
+// TestEngine_SkippedRules tests that skipped rules are recorded with a reason
+func TestEngine_SkippedRules(t *testing.T) {
+	rules := []SecurityRule{
+		{ID: "rule1", Name: "Cisco Rule", Vendor: "cisco", Command: "show version", ExpectedPattern: "IOS", Severity: string(SeverityHigh), Enabled: true},
+		{ID: "rule2", Name: "Disabled Rule", Vendor: "cisco", Command: "show clock", ExpectedPattern: ".*", Severity: string(SeverityLow), Enabled: false},
+		{ID: "rule3", Name: "Juniper Rule", Vendor: "juniper", Command: "show version", ExpectedPattern: "JUNOS", Severity: string(SeverityHigh), Enabled: true},
+		{ID: "rule4", Name: "Generic Rule", Vendor: "generic", Command: "show users", ExpectedPattern: ".*", Severity: string(SeverityLow), Enabled: true},
+	}
+
+	testDevice := &device.Device{
+		ID:        "device1",
+		Name:      "Test Device",
+		IPAddress: "192.168.1.1",
+		Vendor:    "cisco",
+		Username:  "admin",
+		SSHPort:   22,
+	}
+
+	expected := map[string]SkipReason{
+		"Disabled Rule": SkipReasonDisabled,
+		"Juniper Rule":  SkipReasonVendor,
+	}
+
+	t.Run("Single device run", func(t *testing.T) {
+		rm := setupTestRuleManager(t)
+		engine := NewEngineWithSSHClient(rm, &stubSSHClient{outputs: map[string]string{"show version": "Cisco IOS"}})
+		assert.NoError(t, engine.LoadCustomRules(rules))
+
+		var lastProgress CheckProgress
+		results, err := engine.RunChecksWithProgress(testDevice, func(p *CheckProgress) {
+			lastProgress = *p
+		})
+		assert.NoError(t, err)
+		assert.Len(t, results, 2)
+		assert.Equal(t, 2, lastProgress.Skipped)
+
+		skipped, err := rm.GetSkippedRules(testDevice.ID)
+		assert.NoError(t, err)
+		assert.Len(t, skipped, 2)
+		for _, skip := range skipped {
+			assert.Equal(t, expected[skip.RuleName], skip.Reason)
+			assert.Equal(t, testDevice.ID, skip.DeviceID)
+		}
+	})
+
+	t.Run("Bulk run", func(t *testing.T) {
+		rm := setupTestRuleManager(t)
+		engine := NewEngineWithSSHClient(rm, &stubSSHClient{outputs: map[string]string{"show version": "Cisco IOS"}})
+		assert.NoError(t, engine.LoadCustomRules(rules))
+
+		results, err := engine.RunBulkChecks([]device.Device{*testDevice})
+		assert.NoError(t, err)
+		assert.Len(t, results[testDevice.ID], 2)
+
+		skipped, err := rm.GetSkippedRules(testDevice.ID)
+		assert.NoError(t, err)
+		assert.Len(t, skipped, 2)
+		for _, skip := range skipped {
+			assert.Equal(t, expected[skip.RuleName], skip.Reason)
+		}
+	})
+
+	t.Run("No applicable rules still records skips", func(t *testing.T) {
+		rm := setupTestRuleManager(t)
+		engine := NewEngineWithSSHClient(rm, &stubSSHClient{})
+		assert.NoError(t, engine.LoadCustomRules(rules[:3]))
+
+		aristaDevice := *testDevice
+		aristaDevice.ID = "device2"
+		aristaDevice.Vendor = "arista"
+
+		_, err := engine.RunChecks(&aristaDevice)
+		assert.Error(t, err)
+
+		skipped, err := rm.GetSkippedRules(aristaDevice.ID)
+		assert.NoError(t, err)
+		assert.Len(t, skipped, 3)
+		for _, skip := range skipped {
+			assert.Equal(t, SkipReasonVendor, skip.Reason)
+		}
+	})
+}
+
+// TestEngine_SkippedRulesRunReasons tests the skip reasons decided while a
+// run is under way, and that every record carries the run's ID
+func TestEngine_SkippedRulesRunReasons(t *testing.T) {
+	rules := []SecurityRule{
+		{ID: "ssh", Name: "SSH version", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "telnet", Name: "Telnet disabled", Vendor: "cisco", Command: "show running-config | include telnet",
+			ExpectedPattern: "transport input ssh", Severity: string(SeverityHigh), Enabled: true},
+		{ID: "ntp", Name: "NTP", Vendor: "cisco", Command: "show ntp status", ExpectedPattern: "synchronized",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "aaa", Name: "AAA", Vendor: "cisco", Command: "show aaa servers", ExpectedPattern: ".*",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "banner", Name: "Banner", Vendor: "cisco", Command: "show banner motd", ExpectedPattern: ".*",
+			Severity: string(SeverityLow), Enabled: true},
+	}
+	lab := device.Device{ID: "lab1", Name: "Lab Router", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22, Tags: "lab"}
+
+	// runs checks a device on its own and in a bulk run, returning the skip
+	// records of each run by run ID
+	runs := func(t *testing.T, engine *Engine, rm *RuleManager, dev device.Device) map[string][]SkippedRule {
+		single, err := engine.RunChecks(&dev)
+		require.NoError(t, err)
+		bulk, err := engine.RunBulkChecks([]device.Device{dev})
+		require.NoError(t, err)
+		require.NotEmpty(t, single)
+		require.NotEmpty(t, bulk[dev.ID])
+
+		byRun := make(map[string][]SkippedRule)
+		for _, runID := range []string{single[0].RunID, bulk[dev.ID][0].RunID} {
+			skipped, err := rm.GetRunSkippedRulesContext(context.Background(), runID)
+			require.NoError(t, err)
+			byRun[runID] = skipped
+		}
+		assert.Len(t, byRun, 2, "each run has its own ID")
+		return byRun
+	}
+	reasons := func(skipped []SkippedRule) map[string]SkipReason {
+		byRule := make(map[string]SkipReason)
+		for _, skip := range skipped {
+			byRule[skip.RuleID] = skip.Reason
+		}
+		return byRule
+	}
+
+	t.Run("suppressed", func(t *testing.T) {
+		rm := setupTestRuleManager(t)
+		engine := NewEngineWithSSHClient(rm, &stubSSHClient{})
+		require.NoError(t, engine.LoadCustomRules(rules))
+		om := NewOverrideManager(rm.db)
+		engine.SetOverrideManager(om)
+		require.NoError(t, om.CreateOverride(&SeverityOverride{Scope: OverrideScopeGroup, Target: "lab",
+			RuleID: "telnet", Severity: OverrideSuppress, Reason: "Telnet is used in class exercises"}))
+
+		for runID, skipped := range runs(t, engine, rm, lab) {
+			assert.Equal(t, map[string]SkipReason{"telnet": SkipReasonSuppressed}, reasons(skipped), runID)
+		}
+	})
+
+	t.Run("maintenance window", func(t *testing.T) {
+		rm := setupTestRuleManager(t)
+		engine := NewEngineWithSSHClient(rm, &stubSSHClient{})
+		require.NoError(t, engine.LoadCustomRules(rules))
+
+		now := time.Now()
+		dev := lab
+		dev.MaintenanceWindows = []device.MaintenanceWindow{
+			{DayOfWeek: int(now.Weekday()), StartHour: now.Hour(), EndHour: (now.Hour() + 2) % 24},
+		}
+		for runID, skipped := range runs(t, engine, rm, dev) {
+			assert.Len(t, skipped, len(rules), runID)
+			for _, skip := range skipped {
+				assert.Equal(t, SkipReasonMaintenance, skip.Reason)
+				assert.Equal(t, runID, skip.RunID)
+			}
+		}
+	})
+
+	t.Run("circuit open", func(t *testing.T) {
+		rm := setupTestRuleManager(t)
+		client := new(MockSSHClient)
+		client.On("Connect", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("connection refused"))
+		client.On("GetConnectionStats").Return(map[string]ssh.ConnectionStats{}).Maybe()
+		engine := NewEngineWithSSHClient(rm, client)
+		require.NoError(t, engine.LoadCustomRules(rules))
+
+		for runID, skipped := range runs(t, engine, rm, lab) {
+			byRule := reasons(skipped)
+			assert.Len(t, byRule, len(rules)-CircuitBreakerThreshold, runID)
+			for _, reason := range byRule {
+				assert.Equal(t, SkipReasonCircuitOpen, reason)
+			}
+		}
+		client.AssertNumberOfCalls(t, "Connect", 2*CircuitBreakerThreshold)
+	})
+}
+
+// TestEngine_CommandOverrides tests that the device type selects the rule command
+func TestEngine_CommandOverrides(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &stubSSHClient{outputs: map[string]string{
+		"show version | include uptime": "uptime is 5 days",
+		"get system status":             "Uptime: 5 days",
+	}}
+	engine := NewEngineWithSSHClient(rm, client)
+
+	err := engine.LoadCustomRules([]SecurityRule{
+		{
+			ID:               "uptime",
+			Name:             "Check System Uptime",
+			Vendor:           "generic",
+			Command:          "show version | include uptime",
+			ExpectedPattern:  "(?i)uptime",
+			Severity:         string(SeverityLow),
+			Enabled:          true,
+			CommandOverrides: map[string]string{string(device.TypeFirewall): "get system status"},
+		},
+	})
+	assert.NoError(t, err)
+
+	firewall := &device.Device{ID: "fw1", Name: "Firewall", IPAddress: "192.168.1.10",
+		DeviceType: string(device.TypeFirewall), Vendor: "fortinet", Username: "admin", SSHPort: 22}
+	router := &device.Device{ID: "r1", Name: "Router", IPAddress: "192.168.1.11",
+		DeviceType: string(device.TypeRouter), Vendor: "cisco", Username: "admin", SSHPort: 22}
+
+	results, err := engine.RunChecks(firewall)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, string(StatusPass), results[0].Status)
+
+	results, err = engine.RunChecks(router)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, string(StatusPass), results[0].Status)
+
+	assert.Equal(t, []string{"get system status", "show version | include uptime"}, client.executed)
+}
+
+func TestEngine_VendorOverrides(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &stubSSHClient{outputs: map[string]string{
+		"show version | include uptime": "uptime is 5 days",
+		"show system uptime":            "System booted: 2024-01-01",
+	}}
+	engine := NewEngineWithSSHClient(rm, client)
+
+	err := engine.LoadCustomRules([]SecurityRule{
+		{
+			ID:              "uptime",
+			Name:            "Check System Uptime",
+			Vendor:          "generic",
+			Command:         "show version | include uptime",
+			ExpectedPattern: "uptime",
+			Severity:        string(SeverityLow),
+			Enabled:         true,
+			VendorOverrides: []VendorOverride{
+				{Vendor: "juniper", Command: "show system uptime", ExpectedPattern: "System booted"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	juniper := &device.Device{ID: "j1", Name: "Juniper", IPAddress: "192.168.1.20",
+		DeviceType: string(device.TypeRouter), Vendor: "juniper", Username: "admin", SSHPort: 22}
+	cisco := &device.Device{ID: "c1", Name: "Cisco", IPAddress: "192.168.1.21",
+		DeviceType: string(device.TypeRouter), Vendor: "cisco", Username: "admin", SSHPort: 22}
+
+	results, err := engine.RunChecks(juniper)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, string(StatusPass), results[0].Status)
+	assert.Equal(t, "vendor:juniper", results[0].CommandVariant)
+	assert.Equal(t, "show system uptime", results[0].Command)
+
+	results, err = engine.RunChecks(cisco)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, string(StatusPass), results[0].Status)
+	assert.Equal(t, VariantBase, results[0].CommandVariant)
+
+	assert.Equal(t, []string{"show system uptime", "show version | include uptime"}, client.executed)
+}
+
+func TestEngine_RunIDs(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &stubSSHClient{outputs: map[string]string{"show version": "uptime is 5 days"}}
+	engine := NewEngineWithSSHClient(rm, client)
+
+	err := engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "Rule 1", Vendor: "generic", Command: "show version", ExpectedPattern: "uptime",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "r2", Name: "Rule 2", Vendor: "generic", Command: "show version", ExpectedPattern: "days",
+			Severity: string(SeverityLow), Enabled: true},
+	})
+	assert.NoError(t, err)
+
+	devices := []device.Device{
+		{ID: "d1", Name: "One", IPAddress: "192.168.1.30", DeviceType: string(device.TypeRouter),
+			Vendor: "cisco", Username: "admin", SSHPort: 22},
+		{ID: "d2", Name: "Two", IPAddress: "192.168.1.31", DeviceType: string(device.TypeRouter),
+			Vendor: "cisco", Username: "admin", SSHPort: 22},
+	}
+
+	// A single-device run shares one run ID across its results
+	single, err := engine.RunChecks(&devices[0])
+	assert.NoError(t, err)
+	assert.Len(t, single, 2)
+	assert.NotEmpty(t, single[0].RunID)
+	assert.Equal(t, single[0].RunID, single[1].RunID)
+
+	// A bulk run shares one run ID across all devices
+	bulk, err := engine.RunBulkChecks(devices)
+	assert.NoError(t, err)
+	bulkRunID := bulk["d1"][0].RunID
+	assert.NotEmpty(t, bulkRunID)
+	assert.NotEqual(t, single[0].RunID, bulkRunID)
+	for _, results := range bulk {
+		for _, result := range results {
+			assert.Equal(t, bulkRunID, result.RunID)
+		}
+	}
+}
+
 // TestCheckProgress tests the CheckProgress struct
 func TestCheckProgress(t *testing.T) {
 	now := time.Now()
@@ -554,8 +1158,8 @@ func TestBulkCheckResult(t *testing.T) {
 		},
 	}
 
-	errors := map[string]error{
-		"device2": assert.AnError,
+	errors := map[string]CheckError{
+		"device2": NewCheckError(assert.AnError),
 	}
 
 	result := BulkCheckResult{
@@ -569,6 +1173,36 @@ func TestBulkCheckResult(t *testing.T) {
 	assert.Equal(t, errors, result.Errors)
 }
 
+// TestBulkCheckResult_JSON checks that error messages and codes survive the
+// round trip to the frontend
+func TestBulkCheckResult_JSON(t *testing.T) {
+	authErr := &ssh.SSHError{Kind: ssh.ErrorKindAuth, Host: "10.0.0.2", Err: fmt.Errorf("unable to authenticate")}
+	result := BulkCheckResult{
+		Progress: map[string]*CheckProgress{
+			"device2": {DeviceID: "device2", Status: "error", Error: authErr.Error(), ErrorCode: ErrorCode(authErr)},
+		},
+		Errors: map[string]CheckError{
+			"device2": NewCheckError(authErr),
+			"device3": NewCheckError(fmt.Errorf("run failed: %w", context.DeadlineExceeded)),
+		},
+	}
+
+	data, err := json.Marshal(result)
+	require.NoError(t, err)
+
+	var decoded struct {
+		Progress map[string]map[string]interface{} `json:"progress"`
+		Errors   map[string]map[string]string      `json:"errors"`
+	}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	assert.Equal(t, map[string]string{"message": "unable to authenticate", "code": "auth"}, decoded.Errors["device2"])
+	assert.Equal(t, ErrorCodeTimeout, decoded.Errors["device3"]["code"])
+	assert.Contains(t, decoded.Errors["device3"]["message"], "run failed")
+	assert.Equal(t, "unable to authenticate", decoded.Progress["device2"]["error"])
+	assert.Equal(t, "auth", decoded.Progress["device2"]["errorCode"])
+}
+
 // Benchmark tests for performance
 func BenchmarkEngine_GetSecurityRules(b *testing.B) {
 	// Create test database
@@ -578,22 +1212,9 @@ func BenchmarkEngine_GetSecurityRules(b *testing.B) {
 	}
 	defer db.Close()
 
-	// Create table
-	createTableSQL := `
-		CREATE TABLE security_rules (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			description TEXT,
-			vendor TEXT NOT NULL,
-			command TEXT NOT NULL,
-			expected_pattern TEXT,
-			severity TEXT NOT NULL,
-			enabled BOOLEAN DEFAULT TRUE,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-	`
-	if _, err := db.Exec(createTableSQL); err != nil {
-		b.Fatalf("Failed to create test table: %v", err)
+	// Create tables
+	if _, err := db.Exec(testRulesSchema); err != nil {
+		b.Fatalf("Failed to create test tables: %v", err)
 	}
 
 	rm := NewRuleManager(db)
@@ -629,10 +1250,22 @@ func BenchmarkEngine_GetSecurityRules(b *testing.B) {
 		b.Fatalf("Failed to load rules: %v", err)
 	}
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
+	// uncached reads the database on every call, as every job did before
+	// rules were cached
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			engine.InvalidateRulesCache()
+			_ = engine.GetSecurityRules("cisco")
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
 		_ = engine.GetSecurityRules("cisco")
-	}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_ = engine.GetSecurityRules("cisco")
+		}
+	})
 }
 
 func BenchmarkEngine_evaluateRuleResult(b *testing.B) {
@@ -643,22 +1276,9 @@ func BenchmarkEngine_evaluateRuleResult(b *testing.B) {
 	}
 	defer db.Close()
 
-	// Create table
-	createTableSQL := `
-		CREATE TABLE security_rules (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			description TEXT,
-			vendor TEXT NOT NULL,
-			command TEXT NOT NULL,
-			expected_pattern TEXT,
-			severity TEXT NOT NULL,
-			enabled BOOLEAN DEFAULT TRUE,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-	`
-	if _, err := db.Exec(createTableSQL); err != nil {
-		b.Fatalf("Failed to create test table: %v", err)
+	// Create tables
+	if _, err := db.Exec(testRulesSchema); err != nil {
+		b.Fatalf("Failed to create test tables: %v", err)
 	}
 
 	rm := NewRuleManager(db)
@@ -674,3 +1294,476 @@ func BenchmarkEngine_evaluateRuleResult(b *testing.B) {
 		_, _ = engine.evaluateRuleResult(output, rule)
 	}
 }
+
+func TestEngine_SimulatedRunMatchesRecording(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	live := &stubSSHClient{outputs: map[string]string{
+		"show version":   "uptime is 5 days",
+		"show ip ssh":    "SSH Enabled - version 1.99",
+		"show users all": "admin vty 0",
+		"show privilege": "Current privilege level is 15",
+	}}
+	recorder := ssh.NewRecordingClient(live)
+	engine := NewEngineWithSSHClient(rm, recorder)
+
+	err := engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "Uptime", Vendor: "generic", Command: "show version", ExpectedPattern: "uptime",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "r2", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r3", Name: "Users", Vendor: "generic", Command: "show users all", ExpectedPattern: "admin",
+			Severity: string(SeverityMedium), Enabled: true,
+			Precondition: &RulePrecondition{Command: "show privilege", Pattern: "level 15"}},
+	})
+	assert.NoError(t, err)
+
+	dev := &device.Device{ID: "d1", Name: "One", IPAddress: "192.168.1.40", DeviceType: string(device.TypeRouter),
+		Vendor: "cisco", Username: "admin", SSHPort: 22}
+	commands, err := engine.CommandsForDevice(dev, CheckOptions{})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"show version", "show ip ssh", "show privilege", "show users all"},
+		commands, "precondition commands are recorded too")
+
+	// Simulating before any session is loaded is an error, not a silent live run
+	_, err = engine.RunChecksWithOptions(dev, CheckOptions{Simulate: true}, nil)
+	assert.Error(t, err)
+
+	recorded, err := engine.RunChecks(dev)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	_, err = recorder.SaveFixtures(dir)
+	assert.NoError(t, err)
+
+	simulator, err := ssh.NewSimulatedClientFromDir(dir, ssh.SimulationConfig{FailUnknownCommands: true})
+	assert.NoError(t, err)
+	engine.SetSimulator(simulator)
+
+	var progressUpdates int
+	replayed, err := engine.RunChecksWithOptions(dev, CheckOptions{Simulate: true}, func(*CheckProgress) {
+		progressUpdates++
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, progressUpdates)
+	assert.Equal(t, comparableResults(t, recorded), comparableResults(t, replayed))
+
+	bulk, err := engine.RunBulkChecksWithOptions([]device.Device{*dev}, CheckOptions{Simulate: true}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, comparableResults(t, recorded), comparableResults(t, bulk["d1"]))
+}
+
+// comparableResults encodes results without the fields that differ between runs
+func comparableResults(t *testing.T, results []CheckResult) string {
+	t.Helper()
+
+	stripped := make([]CheckResult, len(results))
+	for i, result := range results {
+		result.ID = ""
+		result.RunID = ""
+		result.CheckedAt = time.Time{}
+		stripped[i] = result
+	}
+
+	data, err := json.Marshal(stripped)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+// countingSSHClient tracks how many sessions are open at once
+type countingSSHClient struct {
+	stubSSHClient
+	delay  time.Duration
+	mu     sync.Mutex
+	active int
+	peak   int
+}
+
+func (c *countingSSHClient) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	c.mu.Lock()
+	c.active++
+	if c.active > c.peak {
+		c.peak = c.active
+	}
+	c.mu.Unlock()
+	return &ssh.SSHConnection{}, nil
+}
+
+func (c *countingSSHClient) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	time.Sleep(c.delay)
+	return c.stubSSHClient.ExecuteCommand(ctx, conn, command)
+}
+
+func (c *countingSSHClient) Disconnect(conn *ssh.SSHConnection) error {
+	c.mu.Lock()
+	c.active--
+	c.mu.Unlock()
+	return nil
+}
+
+func TestEngine_MaxConcurrentSSHSessions(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &countingSSHClient{
+		stubSSHClient: stubSSHClient{outputs: map[string]string{"show version": "uptime is 5 days"}},
+		delay:         10 * time.Millisecond,
+	}
+	engine := NewEngineWithSSHClient(rm, client)
+	engine.SetWorkerCount(10)
+	engine.MaxConcurrentSSHSessions = 3
+
+	err := engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "Rule 1", Vendor: "generic", Command: "show version", ExpectedPattern: "uptime",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "r2", Name: "Rule 2", Vendor: "generic", Command: "show version", ExpectedPattern: "days",
+			Severity: string(SeverityLow), Enabled: true},
+	})
+	assert.NoError(t, err)
+
+	var devices []device.Device
+	for i := 0; i < 20; i++ {
+		devices = append(devices, device.Device{ID: fmt.Sprintf("d%d", i), Name: fmt.Sprintf("Device %d", i),
+			IPAddress: fmt.Sprintf("10.0.0.%d", i+1), DeviceType: string(device.TypeRouter),
+			Vendor: "cisco", Username: "admin", SSHPort: 22})
+	}
+
+	results, err := engine.RunBulkChecks(devices)
+	assert.NoError(t, err)
+	assert.Len(t, results, 20)
+	for _, deviceResults := range results {
+		for _, result := range deviceResults {
+			assert.Equal(t, string(StatusPass), result.Status)
+		}
+	}
+
+	assert.LessOrEqual(t, client.peak, 3)
+	assert.Greater(t, client.peak, 1)
+	assert.Equal(t, 0, client.active)
+
+	// Without a cap the workers alone bound the sessions
+	engine.MaxConcurrentSSHSessions = 0
+	client.peak = 0
+	_, err = engine.RunBulkChecks(devices)
+	assert.NoError(t, err)
+	assert.Greater(t, client.peak, 3)
+}
+
+func TestEngine_MaintenanceWindow(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &stubSSHClient{outputs: map[string]string{"show ip ssh": "SSH Enabled - version 2.0"}}
+	engine := NewEngineWithSSHClient(rm, client)
+
+	err := engine.LoadCustomRules([]SecurityRule{
+		{ID: "ssh", Name: "SSH version", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+	})
+	assert.NoError(t, err)
+
+	// A window around the current hour, wide enough not to end mid-test
+	now := time.Now()
+	window := device.MaintenanceWindow{DayOfWeek: int(now.Weekday()), StartHour: now.Hour(), EndHour: (now.Hour() + 2) % 24}
+	testDevice := &device.Device{ID: "r1", Name: "Router", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22, MaintenanceWindows: []device.MaintenanceWindow{window}}
+
+	results, err := engine.RunChecks(testDevice)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, string(StatusWarning), results[0].Status)
+	assert.Equal(t, "Device is in maintenance window, check skipped", results[0].Message)
+
+	bulk, err := engine.RunBulkChecks([]device.Device{*testDevice})
+	assert.NoError(t, err)
+	assert.Len(t, bulk[testDevice.ID], 1)
+	for _, result := range bulk[testDevice.ID] {
+		assert.Equal(t, string(StatusWarning), result.Status)
+	}
+	assert.Empty(t, client.executed, "devices under maintenance are not contacted")
+
+	// Outside the window the check runs as usual
+	testDevice.MaintenanceWindows = nil
+	results, err = engine.RunChecks(testDevice)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, string(StatusPass), results[0].Status)
+}
+
+func TestEngine_FakeRuleManager(t *testing.T) {
+	rules := &fakeRuleManager{}
+	client := &stubSSHClient{outputs: map[string]string{"show ip ssh": "SSH Enabled - version 2.0"}}
+	engine := NewEngineWithSSHClient(rules, client)
+
+	assert.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "ssh", Name: "SSH version", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "ntp", Name: "NTP", Vendor: "juniper", Command: "show ntp", ExpectedPattern: "synced",
+			Severity: string(SeverityLow), Enabled: true},
+	}))
+
+	router := &device.Device{ID: "r1", Name: "Router", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22}
+	results, err := engine.RunChecks(router)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, string(StatusPass), results[0].Status)
+
+	// The juniper rule is recorded as skipped for the cisco device
+	assert.Len(t, rules.skipped, 1)
+	assert.Equal(t, "ntp", rules.skipped[0].RuleID)
+	assert.Equal(t, SkipReasonVendor, rules.skipped[0].Reason)
+}
+
+// streamSSHClient answers commands with separate streams and exit statuses,
+// returning an error for nonzero statuses like the network client
+type streamSSHClient struct {
+	stubSSHClient
+	results map[string]ssh.CommandResult
+}
+
+func (c *streamSSHClient) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	c.mu.Lock()
+	c.executed = append(c.executed, command)
+	c.mu.Unlock()
+
+	result := c.results[command]
+	result.Command = command
+	result.Output = result.Stdout + result.Stderr
+	if result.ExitCode != 0 {
+		result.Error = fmt.Sprintf("Process exited with status %d", result.ExitCode)
+		return &result, fmt.Errorf("%s", result.Error)
+	}
+	return &result, nil
+}
+
+func TestEngine_ExitCodesAndStreams(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &streamSSHClient{results: map[string]ssh.CommandResult{
+		"login test olduser": {Stderr: "% Authentication failed\n", ExitCode: 1},
+		"show version":       {Stdout: "Cisco IOS 15.2\n", Stderr: "% command deprecated\n"},
+	}}
+	engine := NewEngineWithSSHClient(rm, client)
+
+	failing := 1
+	succeeding := 0
+	rules := []SecurityRule{
+		{ID: "exit-only", Name: "Removed account cannot log in", Vendor: "cisco", Command: "login test olduser",
+			ExpectedExitCode: &failing, Severity: string(SeverityHigh), Enabled: true},
+		{ID: "exit-mismatch", Name: "Login succeeds", Vendor: "cisco", Command: "login test olduser",
+			ExpectedExitCode: &succeeding, Severity: string(SeverityHigh), Enabled: true},
+		{ID: "stderr", Name: "Login error text", Vendor: "cisco", Command: "login test olduser",
+			ExpectedExitCode: &failing, StreamTarget: StreamStderr, ExpectedPattern: "Authentication failed",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "stdout", Name: "Version on stdout", Vendor: "cisco", Command: "show version",
+			StreamTarget: StreamStdout, ExpectedPattern: `\A[^%]*\z`, Severity: string(SeverityLow), Enabled: true},
+		{ID: "legacy", Name: "Legacy combined", Vendor: "cisco", Command: "show version",
+			ExpectedPattern: "IOS 15.2\n% command deprecated", Severity: string(SeverityLow), Enabled: true},
+		{ID: "legacy-exit", Name: "Legacy nonzero exit", Vendor: "cisco", Command: "login test olduser",
+			ExpectedPattern: "failed", Severity: string(SeverityLow), Enabled: true},
+	}
+	require.NoError(t, engine.LoadCustomRules(rules))
+
+	dev := &device.Device{ID: "device1", Name: "Test Device", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22}
+	results, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	byName := make(map[string]CheckResult)
+	for _, result := range results {
+		byName[result.CheckName] = result
+	}
+	require.Len(t, byName, len(rules))
+
+	assert.Equal(t, string(StatusPass), byName["Removed account cannot log in"].Status, "the exit status alone decides")
+	assert.Equal(t, string(StatusFail), byName["Login succeeds"].Status)
+	assert.Equal(t, "Command exited with status 1, expected 0", byName["Login succeeds"].Message)
+
+	stderr := byName["Login error text"]
+	assert.Equal(t, string(StatusPass), stderr.Status)
+	assert.Equal(t, StreamStderr, stderr.EvidenceStream)
+	assert.Equal(t, "% Authentication failed\n", stderr.Evidence)
+
+	stdout := byName["Version on stdout"]
+	assert.Equal(t, string(StatusPass), stdout.Status, "standard error is not part of standard output")
+	assert.Equal(t, StreamStdout, stdout.EvidenceStream)
+	assert.Equal(t, "Cisco IOS 15.2\n", stdout.Evidence)
+
+	legacy := byName["Legacy combined"]
+	assert.Equal(t, string(StatusPass), legacy.Status, "rules without a target match the combined output")
+	assert.Equal(t, StreamCombined, legacy.EvidenceStream)
+	assert.Equal(t, "Cisco IOS 15.2\n% command deprecated\n", legacy.Evidence)
+
+	assert.Equal(t, string(StatusError), byName["Legacy nonzero exit"].Status,
+		"a nonzero exit status still fails the command for rules not expecting one")
+
+	// The settings survive a round trip through the database
+	stored, err := rm.GetAllRules()
+	require.NoError(t, err)
+	storedByID := make(map[string]SecurityRule)
+	for _, rule := range stored {
+		storedByID[rule.ID] = rule
+	}
+	require.NotNil(t, storedByID["stderr"].ExpectedExitCode)
+	assert.Equal(t, 1, *storedByID["stderr"].ExpectedExitCode)
+	assert.Equal(t, StreamStderr, storedByID["stderr"].StreamTarget)
+	assert.Nil(t, storedByID["legacy"].ExpectedExitCode)
+	assert.Empty(t, storedByID["legacy"].StreamTarget)
+	assert.Error(t, rm.CreateRule(SecurityRule{ID: "bad", Name: "Bad", Vendor: "cisco", Command: "show version",
+		StreamTarget: "stdin", Severity: string(SeverityLow)}))
+
+	store := NewResultStore(rm.db)
+	require.NoError(t, store.SaveResults([]CheckResult{stderr}))
+	saved, err := store.GetDeviceResults(dev.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, saved, 1)
+	assert.Equal(t, StreamStderr, saved[0].EvidenceStream)
+}
+
+func TestEngine_RunChecksWithOptions_RuleIDs(t *testing.T) {
+	rules := &fakeRuleManager{rules: []SecurityRule{
+		{ID: "r1", Name: "SSH Version", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2", Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r2", Name: "Telnet Disabled", Vendor: "generic", Command: "show line vty 0 4", ExpectedPattern: "transport input ssh", Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r3", Name: "NTP Configured", Vendor: "generic", Command: "show ntp status", ExpectedPattern: "synchronized", Severity: string(SeverityLow), Enabled: true},
+		{ID: "r4", Name: "AAA Enabled", Vendor: "generic", Command: "show aaa", ExpectedPattern: "new-model", Severity: string(SeverityLow)},
+	}}
+	client := &stubSSHClient{outputs: map[string]string{}}
+	engine := NewEngineWithSSHClient(rules, client)
+
+	dev := &device.Device{ID: "d1", Name: "Router", IPAddress: "192.168.1.1", Vendor: "generic", Username: "admin", SSHPort: 22}
+	results, err := engine.RunChecksWithOptions(dev, CheckOptions{RuleIDs: []string{"r3", "r1"}}, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "SSH Version", results[0].CheckName, "rules keep their order")
+	assert.Equal(t, "NTP Configured", results[1].CheckName)
+	for _, command := range client.executed {
+		assert.NotContains(t, command, "show line vty", "filtered rules are not dispatched")
+	}
+
+	// Not even the disabled rule is recorded, as it was not asked for
+	assert.Empty(t, rules.skipped, "rules left out of the run are not recorded as skipped")
+
+	_, err = engine.RunChecksWithOptions(dev, CheckOptions{}, nil)
+	require.NoError(t, err)
+	require.Len(t, rules.skipped, 1)
+	assert.Equal(t, SkipReasonDisabled, rules.skipped[0].Reason)
+
+	bulk, err := engine.RunBulkChecksWithOptions([]device.Device{*dev}, CheckOptions{RuleIDs: []string{"r2"}}, nil)
+	require.NoError(t, err)
+	require.Len(t, bulk["d1"], 1)
+	assert.Equal(t, "Telnet Disabled", bulk["d1"][0].CheckName)
+}
+
+func TestEngine_RerunFailedChecks(t *testing.T) {
+	rules := &fakeRuleManager{rules: []SecurityRule{
+		{ID: "r1", Name: "SSH Version", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2", Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r2", Name: "Telnet Disabled", Vendor: "generic", Command: "show line vty 0 4", ExpectedPattern: "transport input ssh", Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r3", Name: "NTP Configured", Vendor: "generic", Command: "show ntp status", ExpectedPattern: "synchronized", Severity: string(SeverityLow), Enabled: true},
+	}}
+	client := &stubSSHClient{outputs: map[string]string{
+		"show ip ssh":       "SSH Enabled - version 2.0",
+		"show line vty 0 4": "transport input ssh",
+		"show ntp status":   "%NTP is not enabled.",
+	}}
+	engine := NewEngineWithSSHClient(rules, client)
+	dev := &device.Device{ID: "d1", Name: "Router", IPAddress: "192.168.1.1", Vendor: "generic", Username: "admin", SSHPort: 22}
+
+	previous := []CheckResult{
+		{DeviceID: "d1", CheckName: "SSH Version", Status: string(StatusPass)},
+		{DeviceID: "d1", CheckName: "Telnet Disabled", Status: string(StatusFail)},
+		{DeviceID: "d1", CheckName: "NTP Configured", Status: string(StatusError)},
+		{DeviceID: "d2", CheckName: "SSH Version", Status: string(StatusFail)},
+		{DeviceID: "d1", CheckName: "Removed Rule", Status: string(StatusFail)},
+	}
+	results, err := engine.RerunFailedChecks(context.Background(), dev, previous)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "Telnet Disabled", results[0].CheckName)
+	assert.Equal(t, string(StatusPass), results[0].Status, "the remediated check passes")
+	assert.Equal(t, "NTP Configured", results[1].CheckName)
+	assert.Equal(t, string(StatusFail), results[1].Status)
+	assert.Equal(t, results[0].RunID, results[1].RunID, "the re-run is one run")
+	for _, command := range client.executed {
+		assert.NotContains(t, command, "show ip ssh", "passing checks are not re-run")
+	}
+
+	client.executed = nil
+	results, err = engine.RerunFailedChecks(context.Background(), dev, previous[:1])
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	assert.Empty(t, client.executed, "nothing runs when no check failed")
+}
+
+func TestEngine_CommandsForDevice(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	engine := NewEngine(rm)
+	macros := NewMacroManager(rm.db)
+	engine.SetMacroManager(macros)
+	require.NoError(t, macros.CreateMacro(&CommandMacro{Name: "vty", Command: "show running-config | section line vty"}))
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "A VTY SSH only", Vendor: "cisco", Command: "@vty", ExpectedPattern: "ssh",
+			Severity: string(SeverityHigh), Enabled: true, DependsOn: []string{"r3"}},
+		{ID: "r2", Name: "B SNMP ACL", Vendor: "cisco", Command: "show snmp community", ExpectedPattern: "acl",
+			Severity: string(SeverityMedium), Enabled: true,
+			Precondition:     &RulePrecondition{Command: "show running-config | include snmp", Pattern: "snmp-server"},
+			CommandOverrides: map[string]string{string(device.TypeSwitch): "show snmp community detail"}},
+		{ID: "r3", Name: "C SSH enabled", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "Enabled",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r4", Name: "D Same command", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "r5", Name: "E Disabled", Vendor: "cisco", Command: "show disabled", ExpectedPattern: "x",
+			Severity: string(SeverityLow), Enabled: false},
+		{ID: "r6", Name: "F Other vendor", Vendor: "juniper", Command: "show system", ExpectedPattern: "x",
+			Severity: string(SeverityLow), Enabled: true},
+	}))
+
+	dev := &device.Device{ID: "d1", Name: "Access", IPAddress: "192.168.1.90", DeviceType: string(device.TypeSwitch),
+		Vendor: string(device.VendorCisco), Username: "admin", SSHPort: 22}
+	commands, err := engine.CommandsForDevice(dev, CheckOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"show ip ssh",
+		"show running-config | section line vty",
+		"show running-config | include snmp",
+		"show snmp community detail",
+	}, commands)
+
+	// Runs of selected rules send only theirs
+	commands, err = engine.CommandsForDevice(dev, CheckOptions{RuleIDs: []string{"r4"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"show ip ssh"}, commands)
+
+	// Shell setup comes first, then the config an incremental run compares,
+	// and the config archived after the run is not listed twice
+	require.NoError(t, engine.EnableShellSessions("cisco", ssh.ShellConfig{}))
+	engine.SetResultStore(NewResultStore(rm.db))
+	engine.SetSnapshotStore(NewSnapshotStore(rm.db))
+	commands, err = engine.CommandsForDevice(dev, CheckOptions{Incremental: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"enable",
+		"terminal length 0",
+		"show running-config",
+		"show ip ssh",
+		"show running-config | section line vty",
+		"show running-config | include snmp",
+		"show snmp community detail",
+	}, commands)
+
+	commands, err = engine.CommandsForDevice(dev, CheckOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "terminal length 0", commands[1])
+	assert.Equal(t, "show running-config", commands[len(commands)-1], "snapshots are taken after the rules")
+
+	preview, err := engine.PreviewCommands(dev)
+	require.NoError(t, err)
+	assert.Equal(t, commands, preview, "previews list the commands of a run with default options")
+
+	// Devices under maintenance are sent nothing
+	now := time.Now()
+	window := device.MaintenanceWindow{DayOfWeek: int(now.Weekday()), StartHour: now.Hour(), EndHour: (now.Hour() + 2) % 24}
+	inMaintenance := *dev
+	inMaintenance.MaintenanceWindows = []device.MaintenanceWindow{window}
+	commands, err = engine.CommandsForDevice(&inMaintenance, CheckOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, commands)
+
+	// A command the run could not expand is not approved as written
+	require.NoError(t, macros.DeleteMacro("vty"))
+	_, err = engine.CommandsForDevice(dev, CheckOptions{})
+	assert.ErrorContains(t, err, "A VTY SSH only")
+}
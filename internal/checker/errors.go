@@ -0,0 +1,48 @@
+package checker
+
+import (
+	"context"
+	"errors"
+
+	"invictux-demo/internal/ssh"
+)
+
+// Error codes reported to the frontend alongside an error message. SSH
+// failures use their ssh.ErrorKind as the code.
+const (
+	ErrorCodeTimeout     = "timeout"
+	ErrorCodeCancelled   = "cancelled"
+	ErrorCodeCheckFailed = "check_failed"
+)
+
+// CheckError is an error in a form that survives JSON, since error values
+// marshal to {} on their way to the frontend
+type CheckError struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+// Error returns the error message
+func (e CheckError) Error() string {
+	return e.Message
+}
+
+// NewCheckError returns the message and code of err
+func NewCheckError(err error) CheckError {
+	return CheckError{Message: err.Error(), Code: ErrorCode(err)}
+}
+
+// ErrorCode classifies err for the frontend
+func ErrorCode(err error) string {
+	if kind := ssh.ErrorKindOf(err); kind != "" {
+		return string(kind)
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorCodeTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorCodeCancelled
+	default:
+		return ErrorCodeCheckFailed
+	}
+}
@@ -0,0 +1,163 @@
+package checker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultEvidenceLines is the evidence window of rules that do not set
+// EvidenceLines
+const DefaultEvidenceLines = 5
+
+// DefaultMaxEvidenceBytes caps the evidence of a result unless
+// SetMaxEvidenceBytes changes it; it fits a full running config
+const DefaultMaxEvidenceBytes = 64 * 1024
+
+// EvidenceTruncatedMarker ends evidence cut short by the evidence cap
+const EvidenceTruncatedMarker = "[truncated]"
+
+// evidenceLines returns the number of lines shown around a match in the
+// rule's evidence
+func (r SecurityRule) evidenceLines() int {
+	if r.EvidenceLines > 0 {
+		return r.EvidenceLines
+	}
+	return DefaultEvidenceLines
+}
+
+// SetEvidenceDir makes results keep a snippet of the command output as
+// evidence, writing the full output to a file in dir whenever the snippet
+// leaves some of it out. Without a directory results keep the full output.
+// It must not be called while checks are running.
+func (e *Engine) SetEvidenceDir(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create evidence directory: %w", err)
+		}
+	}
+	e.evidenceDir = dir
+	return nil
+}
+
+// EvidenceDir returns the directory full command output is written to, or
+// empty when results keep the full output
+func (e *Engine) EvidenceDir() string {
+	return e.evidenceDir
+}
+
+// SetMaxEvidenceBytes caps the evidence kept on each result at max bytes,
+// ending longer evidence with EvidenceTruncatedMarker after the cut. Rules are
+// still evaluated against the full output. Zero or less removes the cap.
+// It must not be called while checks are running.
+func (e *Engine) SetMaxEvidenceBytes(max int) {
+	if max < 0 {
+		max = 0
+	}
+	e.maxEvidenceBytes = max
+}
+
+// MaxEvidenceBytes returns the evidence cap, zero when evidence is unbounded
+func (e *Engine) MaxEvidenceBytes() int {
+	return e.maxEvidenceBytes
+}
+
+// recordEvidence stores the output a rule was evaluated against on its
+// result: the full output, or a snippet with the full output in a file
+// when an evidence directory is set. Either is then cut to the evidence cap.
+func (e *Engine) recordEvidence(result *CheckResult, output string, rule SecurityRule) {
+	result.Evidence = output
+	if e.evidenceDir != "" {
+		snippet := e.extractEvidence(output, rule)
+		// Keep the full output rather than lose what the snippet leaves out
+		if lineCount(snippet) != lineCount(output) && e.writeFullEvidence(result, output) {
+			result.Evidence = snippet
+		}
+	}
+
+	if e.maxEvidenceBytes > 0 && len(result.Evidence) > e.maxEvidenceBytes {
+		if result.EvidenceFullPath == "" && e.evidenceDir != "" {
+			e.writeFullEvidence(result, output)
+		}
+		result.Evidence = truncateEvidence(result.Evidence, e.maxEvidenceBytes)
+	}
+}
+
+// writeFullEvidence writes the full output of a result to the evidence
+// directory and reports whether it was written
+func (e *Engine) writeFullEvidence(result *CheckResult, output string) bool {
+	path := filepath.Join(e.evidenceDir, result.ID+".txt")
+	if err := os.WriteFile(path, []byte(output), 0600); err != nil {
+		return false
+	}
+	result.EvidenceFullPath = path
+	return true
+}
+
+// FullEvidence returns the full command output written to the evidence
+// directory for the result. It reports false when none was written or the
+// file is gone, leaving the stored Evidence as all there is.
+func (r CheckResult) FullEvidence() (string, bool) {
+	if r.EvidenceFullPath == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(r.EvidenceFullPath)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// truncateEvidence keeps the first max bytes of evidence, backing off to
+// the start of a UTF-8 character, and appends the marker on its own line
+func truncateEvidence(evidence string, max int) string {
+	keep := max
+	for keep > 0 && !utf8.RuneStart(evidence[keep]) {
+		keep--
+	}
+	return evidence[:keep] + "\n" + EvidenceTruncatedMarker
+}
+
+// extractEvidence returns the lines of output worth showing for a rule.
+// When the pattern matches, that is the rule's evidence window of lines
+// centered on the first match; otherwise it is the first twice that many
+// lines, showing what was found instead.
+func (e *Engine) extractEvidence(output string, rule SecurityRule) string {
+	window := rule.evidenceLines()
+
+	first, last := 0, 2*window-1
+	if regex, err := CompilePattern(rule.ExpectedPattern); err == nil && rule.ExpectedPattern != "" {
+		if loc := regex.FindStringIndex(output); loc != nil {
+			matchStart := strings.Count(output[:loc[0]], "\n")
+			matchEnd := matchStart + strings.Count(strings.TrimSuffix(output[loc[0]:loc[1]], "\n"), "\n")
+			before := (window - 1) / 2
+			first = matchStart - before
+			if first < 0 {
+				first = 0
+			}
+			last = matchEnd + window - 1 - before
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(output)+1)
+	var lines []string
+	for n := 0; scanner.Scan() && n <= last; n++ {
+		if n >= first {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lineCount counts lines the way bufio.ScanLines splits them, a final
+// newline ending the last line rather than starting another
+func lineCount(s string) int {
+	if s == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(s, "\n"), "\n") + 1
+}
@@ -0,0 +1,155 @@
+package checker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// numberedLines returns n lines of output, "line 1" through "line n"
+func numberedLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	return lines
+}
+
+func TestEngine_ExtractEvidence_MatchInMiddle(t *testing.T) {
+	engine := NewEngineWithSSHClient(&fakeRuleManager{}, &stubSSHClient{})
+	lines := numberedLines(20)
+	lines[10] = "ip ssh version 2"
+	output := strings.Join(lines, "\n") + "\n"
+
+	snippet := engine.extractEvidence(output, SecurityRule{ExpectedPattern: `ip ssh version 2`})
+	assert.Equal(t, strings.Join(lines[8:13], "\n"), snippet)
+
+	wide := engine.extractEvidence(output, SecurityRule{ExpectedPattern: `ip ssh version 2`, EvidenceLines: 9})
+	assert.Equal(t, strings.Join(lines[6:15], "\n"), wide)
+
+	multiline := engine.extractEvidence(output, SecurityRule{ExpectedPattern: `(?s)ip ssh version 2\n[^\n]+`, EvidenceLines: 3})
+	assert.Equal(t, strings.Join(lines[9:13], "\n"), multiline, "a match across lines keeps every matched line")
+
+	lines[1] = "ip ssh version 2"
+	start := engine.extractEvidence(strings.Join(lines, "\n"), SecurityRule{ExpectedPattern: `ip ssh version 2`})
+	assert.Equal(t, strings.Join(lines[0:4], "\n"), start, "the window is clipped at the start of the output")
+}
+
+func TestEngine_ExtractEvidence_NoMatch(t *testing.T) {
+	engine := NewEngineWithSSHClient(&fakeRuleManager{}, &stubSSHClient{})
+	lines := numberedLines(30)
+	output := strings.Join(lines, "\r\n")
+
+	snippet := engine.extractEvidence(output, SecurityRule{ExpectedPattern: `transport input ssh`})
+	assert.Equal(t, strings.Join(lines[:10], "\n"), snippet)
+
+	short := engine.extractEvidence("only line", SecurityRule{ExpectedPattern: `missing`})
+	assert.Equal(t, "only line", short)
+}
+
+func TestEngine_RecordEvidence(t *testing.T) {
+	engine := NewEngineWithSSHClient(&fakeRuleManager{}, &stubSSHClient{})
+	lines := numberedLines(20)
+	lines[10] = "ip ssh version 2"
+	output := strings.Join(lines, "\n")
+	rule := SecurityRule{ExpectedPattern: `ip ssh version 2`}
+
+	result := &CheckResult{ID: "result-1"}
+	engine.recordEvidence(result, output, rule)
+	assert.Equal(t, output, result.Evidence, "without an evidence directory the full output is kept")
+	assert.Empty(t, result.EvidenceFullPath)
+
+	dir := filepath.Join(t.TempDir(), "evidence")
+	require.NoError(t, engine.SetEvidenceDir(dir))
+	assert.Equal(t, dir, engine.EvidenceDir())
+
+	result = &CheckResult{ID: "result-2"}
+	engine.recordEvidence(result, output, rule)
+	assert.Equal(t, strings.Join(lines[8:13], "\n"), result.Evidence)
+	assert.Equal(t, filepath.Join(dir, "result-2.txt"), result.EvidenceFullPath)
+	full, err := os.ReadFile(result.EvidenceFullPath)
+	require.NoError(t, err)
+	assert.Equal(t, output, string(full))
+
+	short := "ip ssh version 2\n"
+	result = &CheckResult{ID: "result-3"}
+	engine.recordEvidence(result, short, rule)
+	assert.Equal(t, short, result.Evidence, "output that fits the snippet is kept whole")
+	assert.Empty(t, result.EvidenceFullPath)
+	assert.NoFileExists(t, filepath.Join(dir, "result-3.txt"))
+}
+
+func TestEngine_RunChecks_EvidenceSnippet(t *testing.T) {
+	lines := numberedLines(40)
+	lines[25] = "ip ssh version 2"
+	client := &stubSSHClient{outputs: map[string]string{"show ip ssh": strings.Join(lines, "\n")}}
+	engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+	require.NoError(t, engine.SetEvidenceDir(t.TempDir()))
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "rule1", Name: "SSH Version", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "ip ssh version 2",
+			Severity: string(SeverityHigh), Enabled: true, EvidenceLines: 3},
+	}))
+
+	dev := &device.Device{ID: "d1", Name: "Router", IPAddress: "192.168.1.1", Vendor: "generic",
+		Username: "admin", SSHPort: 22}
+	results, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, string(StatusPass), results[0].Status)
+	assert.Equal(t, strings.Join(lines[24:27], "\n"), results[0].Evidence)
+	assert.FileExists(t, results[0].EvidenceFullPath)
+}
+
+func TestEngine_RunChecks_MaxEvidenceBytes(t *testing.T) {
+	// The pattern only matches past the cap, so evaluation must see the full output
+	output := strings.Repeat("x", 200) + "\nip ssh version 2\n"
+	client := &stubSSHClient{outputs: map[string]string{"show ip ssh": output}}
+	engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+	assert.Equal(t, DefaultMaxEvidenceBytes, engine.MaxEvidenceBytes())
+	engine.SetMaxEvidenceBytes(64)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "rule1", Name: "SSH Version", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "ip ssh version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+	}))
+
+	dev := &device.Device{ID: "d1", Name: "Router", IPAddress: "192.168.1.1", Vendor: "generic",
+		Username: "admin", SSHPort: 22}
+	results, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, string(StatusPass), results[0].Status)
+	assert.Equal(t, strings.Repeat("x", 64)+"\n"+EvidenceTruncatedMarker, results[0].Evidence)
+
+	engine.SetMaxEvidenceBytes(0)
+	results, err = engine.RunChecks(dev)
+	require.NoError(t, err)
+	assert.Equal(t, output, results[0].Evidence, "without a cap the full output is kept")
+}
+
+func TestEngine_RecordEvidence_TruncatedKeepsFullFile(t *testing.T) {
+	engine := NewEngineWithSSHClient(&fakeRuleManager{}, &stubSSHClient{})
+	require.NoError(t, engine.SetEvidenceDir(t.TempDir()))
+	engine.SetMaxEvidenceBytes(10)
+
+	// One long line is its own snippet, so only the cap shortens it
+	output := strings.Repeat("a", 50)
+	result := &CheckResult{ID: "result-1"}
+	engine.recordEvidence(result, output, SecurityRule{ExpectedPattern: "a"})
+	assert.Equal(t, strings.Repeat("a", 10)+"\n"+EvidenceTruncatedMarker, result.Evidence)
+	full, err := os.ReadFile(result.EvidenceFullPath)
+	require.NoError(t, err)
+	assert.Equal(t, output, string(full))
+}
+
+func TestTruncateEvidence_UTF8(t *testing.T) {
+	// "é" takes two bytes; cutting after its first byte backs off before it
+	assert.Equal(t, "ab\n"+EvidenceTruncatedMarker, truncateEvidence("abé", 3))
+	assert.Equal(t, "abé\n"+EvidenceTruncatedMarker, truncateEvidence("abéd", 4))
+}
@@ -0,0 +1,219 @@
+package checker
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/device"
+)
+
+// Finding categories of the vendor-neutral taxonomy rules place their
+// findings in
+const (
+	FindingRemoteAccess    = "remote-access"
+	FindingAuthentication  = "authentication"
+	FindingSNMP            = "snmp"
+	FindingLogging         = "logging"
+	FindingManagementPlane = "management-plane"
+	FindingFirmware        = "firmware"
+)
+
+// FindingCategories lists the finding categories
+var FindingCategories = []string{
+	FindingRemoteAccess,
+	FindingAuthentication,
+	FindingSNMP,
+	FindingLogging,
+	FindingManagementPlane,
+	FindingFirmware,
+}
+
+// uncategorizedFinding stands in for the category of rules without one in
+// fingerprints
+const uncategorizedFinding = "uncategorized"
+
+// IsFindingCategory reports whether category is in the finding taxonomy
+func IsFindingCategory(category string) bool {
+	for _, known := range FindingCategories {
+		if known == category {
+			return true
+		}
+	}
+	return false
+}
+
+// findingKey returns the key fingerprinting the rule's findings: its
+// FindingKey, or its ID, which also survives renames, when it has none
+func (r SecurityRule) findingKey() string {
+	if key := normalizeFindingKey(r.FindingKey); key != "" {
+		return key
+	}
+	return normalizeFindingKey(r.ID)
+}
+
+// setFinding labels a result of the rule with the rule's ID and what it finds
+func (r SecurityRule) setFinding(result *CheckResult) {
+	result.RuleID = r.ID
+	result.FindingCategory = r.FindingCategory
+	result.FindingKey = r.findingKey()
+}
+
+// normalizeFindingKey lowercases s and joins its runs of letters and digits
+// with single dashes, so "Telnet Enabled" and "telnet_enabled" are the same
+func normalizeFindingKey(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// FindingFingerprint returns the fingerprint of a finding on the device at
+// address: the hex SHA-256 of the canonical IP address, the normalized
+// category and the normalized finding key joined by newlines. It does not
+// depend on rule names, vendors or run times, so other scanners reporting
+// the same finding can compute the same value.
+func FindingFingerprint(address, category, key string) string {
+	canonical := strings.ToLower(strings.TrimSpace(address))
+	if ip, err := device.NormalizeHost(address); err == nil {
+		canonical = ip
+	}
+	category = normalizeFindingKey(category)
+	if category == "" {
+		category = uncategorizedFinding
+	}
+
+	sum := sha256.Sum256([]byte(canonical + "\n" + category + "\n" + normalizeFindingKey(key)))
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprintResults sets the fingerprint of results that name a finding
+// and do not have one yet, looking up the address of each device. Results
+// of devices that are no longer stored are fingerprinted by device ID.
+func fingerprintResults(tx *sql.Tx, results []CheckResult) error {
+	addresses := make(map[string]string)
+	for i := range results {
+		result := &results[i]
+		if result.FindingKey == "" || result.Fingerprint != "" {
+			continue
+		}
+
+		address, ok := addresses[result.DeviceID]
+		if !ok {
+			err := tx.QueryRow("SELECT ip_address FROM devices WHERE id = ?", result.DeviceID).Scan(&address)
+			if err == sql.ErrNoRows {
+				address = "device:" + result.DeviceID
+			} else if err != nil {
+				return fmt.Errorf("failed to get address of device %s: %w", result.DeviceID, err)
+			}
+			addresses[result.DeviceID] = address
+		}
+		result.Fingerprint = FindingFingerprint(address, result.FindingCategory, result.FindingKey)
+	}
+	return nil
+}
+
+// OpenFinding is a finding whose latest result failed. FirstSeen is the
+// first failure since the finding last passed, or since it was first
+// checked, and LastSeen the latest failure; Occurrences counts the failures
+// in between.
+type OpenFinding struct {
+	Fingerprint     string    `json:"fingerprint"`
+	DeviceID        string    `json:"deviceId"`
+	CheckName       string    `json:"checkName"`
+	FindingCategory string    `json:"findingCategory,omitempty"`
+	FindingKey      string    `json:"findingKey"`
+	Severity        string    `json:"severity"`
+	FirstSeen       time.Time `json:"firstSeen"`
+	LastSeen        time.Time `json:"lastSeen"`
+	Occurrences     int       `json:"occurrences"`
+
+	// LatestResultID is the result that last reported the finding
+	LatestResultID string `json:"latestResultId"`
+}
+
+// GetOpenFindings groups the stored results by fingerprint and returns the
+// findings whose latest passing or failing result failed, most severe
+// first. A finding that passes is resolved; if it fails again it reopens
+// with a new FirstSeen. Errors and skipped checks neither open nor resolve
+// findings. Findings of sandbox devices are left out unless includeSandbox
+// is set.
+func (rs *ResultStore) GetOpenFindings(includeSandbox bool) ([]OpenFinding, error) {
+	rows, err := rs.db.Query(`
+		SELECT c.id, c.fingerprint, c.device_id, c.check_name, COALESCE(c.finding_category, ''),
+			COALESCE(c.finding_key, ''), c.severity, c.status, c.checked_at
+		FROM check_results c
+		LEFT JOIN devices d ON d.id = c.device_id
+		WHERE c.fingerprint IS NOT NULL AND c.fingerprint != '' AND c.status IN (?, ?)
+			AND (? OR COALESCE(d.is_sandbox, 0) = 0)
+		ORDER BY c.fingerprint, c.checked_at, c.rowid
+	`, string(StatusPass), string(StatusFail), includeSandbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query findings: %w", err)
+	}
+	defer rows.Close()
+
+	open := make(map[string]*OpenFinding)
+	for rows.Next() {
+		var id, status string
+		var finding OpenFinding
+		var checkedAt time.Time
+		if err := rows.Scan(&id, &finding.Fingerprint, &finding.DeviceID, &finding.CheckName,
+			&finding.FindingCategory, &finding.FindingKey, &finding.Severity, &status, &checkedAt); err != nil {
+			return nil, fmt.Errorf("failed to read finding: %w", err)
+		}
+
+		if status == string(StatusPass) {
+			delete(open, finding.Fingerprint)
+			continue
+		}
+
+		current, ok := open[finding.Fingerprint]
+		if !ok {
+			finding.FirstSeen = checkedAt
+			current = &finding
+			open[finding.Fingerprint] = current
+		} else {
+			// The latest failure names the finding as it is now
+			first, occurrences := current.FirstSeen, current.Occurrences
+			*current = finding
+			current.FirstSeen, current.Occurrences = first, occurrences
+		}
+		current.LastSeen = checkedAt
+		current.LatestResultID = id
+		current.Occurrences++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read findings: %w", err)
+	}
+
+	findings := make([]OpenFinding, 0, len(open))
+	for _, finding := range open {
+		findings = append(findings, *finding)
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if rankA, rankB := SeverityRank(a.Severity), SeverityRank(b.Severity); rankA != rankB {
+			return rankA < rankB
+		}
+		if !a.FirstSeen.Equal(b.FirstSeen) {
+			return a.FirstSeen.Before(b.FirstSeen)
+		}
+		return a.Fingerprint < b.Fingerprint
+	})
+	return findings, nil
+}
@@ -0,0 +1,220 @@
+package checker
+
+import (
+	"testing"
+	"time"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindingFingerprint(t *testing.T) {
+	fingerprint := FindingFingerprint("10.0.0.1", FindingRemoteAccess, "telnet-enabled")
+	assert.Len(t, fingerprint, 64)
+
+	// Pasted addresses and differently written keys name the same finding
+	assert.Equal(t, fingerprint, FindingFingerprint(" 10.0.0.1/32 ", "Remote Access", "Telnet_Enabled"))
+	assert.Equal(t, FindingFingerprint("2001:db8::1", FindingSNMP, "x"), FindingFingerprint("2001:DB8:0::0001", FindingSNMP, "x"))
+
+	assert.NotEqual(t, fingerprint, FindingFingerprint("10.0.0.2", FindingRemoteAccess, "telnet-enabled"))
+	assert.NotEqual(t, fingerprint, FindingFingerprint("10.0.0.1", FindingAuthentication, "telnet-enabled"))
+	assert.NotEqual(t, fingerprint, FindingFingerprint("10.0.0.1", FindingRemoteAccess, "ssh-disabled"))
+	assert.NotEqual(t, FindingFingerprint("10.0.0.1", "", "x"), FindingFingerprint("10.0.0.1", FindingFirmware, "x"),
+		"uncategorized findings do not collide with categorized ones")
+}
+
+func TestNormalizeFindingKey(t *testing.T) {
+	tests := map[string]string{
+		"telnet-enabled":      "telnet-enabled",
+		"Telnet Enabled":      "telnet-enabled",
+		"  telnet__enabled! ": "telnet-enabled",
+		"SNMPv2 community":    "snmpv2-community",
+		"---":                 "",
+	}
+	for input, want := range tests {
+		assert.Equal(t, want, normalizeFindingKey(input), input)
+	}
+}
+
+func TestGetPredefinedRules_FindingCategories(t *testing.T) {
+	keys := make(map[string]string)
+	for _, rule := range GetPredefinedRules() {
+		assert.True(t, IsFindingCategory(rule.FindingCategory), "rule %q has finding category %q", rule.Name, rule.FindingCategory)
+		assert.Equal(t, normalizeFindingKey(rule.FindingKey), rule.FindingKey, "rule %q has an unnormalized finding key", rule.Name)
+		if other, ok := keys[rule.FindingKey]; ok {
+			t.Errorf("Rules %q and %q share finding key %q", other, rule.Name, rule.FindingKey)
+		}
+		keys[rule.FindingKey] = rule.Name
+	}
+}
+
+func TestRuleManager_FindingCategory(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	rm := NewRuleManager(db)
+
+	rule := SecurityRule{ID: "r1", Name: "Telnet", Vendor: "cisco", Command: "show run", ExpectedPattern: "ssh",
+		Severity: string(SeverityHigh), Enabled: true, FindingCategory: FindingRemoteAccess, FindingKey: "Telnet Enabled"}
+	require.NoError(t, rm.CreateRule(rule))
+
+	stored, err := rm.GetRule("r1")
+	require.NoError(t, err)
+	assert.Equal(t, FindingRemoteAccess, stored.FindingCategory)
+	assert.Equal(t, "telnet-enabled", stored.FindingKey)
+
+	stored.FindingCategory = "network"
+	assert.Error(t, rm.UpdateRule(*stored), "finding categories outside the taxonomy are rejected")
+	rule.ID, rule.FindingCategory = "r2", "network"
+	assert.Error(t, rm.CreateRule(rule))
+}
+
+func TestRuleManager_LoadPredefinedRulesSetsFindingCategory(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	rm := NewRuleManager(db)
+
+	predefined := GetPredefinedRules()[0]
+	stored := predefined
+	stored.FindingCategory, stored.FindingKey = "", ""
+	require.NoError(t, rm.CreateRule(stored))
+
+	require.NoError(t, rm.loadPredefinedRules([]SecurityRule{predefined}))
+	loaded, err := rm.GetRule(predefined.ID)
+	require.NoError(t, err)
+	assert.Equal(t, predefined.FindingCategory, loaded.FindingCategory)
+	assert.Equal(t, predefined.FindingKey, loaded.FindingKey)
+}
+
+func TestEngine_FingerprintStableAcrossRenames(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	_, err := db.Exec("INSERT INTO devices (id, ip_address) VALUES ('d1', '192.168.1.50')")
+	require.NoError(t, err)
+	store := NewResultStore(db)
+
+	client := &stubSSHClient{outputs: map[string]string{"show ip ssh": "SSH Enabled - version 1.99"}}
+	rm := NewRuleManager(db)
+	engine := NewEngineWithSSHClient(rm, client)
+	rule := SecurityRule{ID: "r1", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: `version 2\.`,
+		Severity: string(SeverityHigh), Enabled: true, FindingCategory: FindingRemoteAccess, FindingKey: "ssh-v1-allowed"}
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{rule}))
+
+	run := func() CheckResult {
+		t.Helper()
+		results, err := engine.RunChecks(&device.Device{ID: "d1", Name: "One", IPAddress: "192.168.1.50",
+			DeviceType: string(device.TypeRouter), Vendor: "cisco", Username: "admin", SSHPort: 22})
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.NoError(t, store.SaveResults(results))
+		return results[0]
+	}
+
+	first := run()
+	assert.Equal(t, FindingRemoteAccess, first.FindingCategory)
+	assert.Equal(t, FindingFingerprint("192.168.1.50", FindingRemoteAccess, "ssh-v1-allowed"), first.Fingerprint)
+
+	// Re-running and renaming the rule leave the fingerprint alone
+	assert.Equal(t, first.Fingerprint, run().Fingerprint)
+	rule.Name = "SSH version 2 only"
+	require.NoError(t, rm.UpdateRule(rule))
+	renamed := run()
+	assert.Equal(t, "SSH version 2 only", renamed.CheckName)
+	assert.Equal(t, first.Fingerprint, renamed.Fingerprint)
+
+	// Rules without a finding key are fingerprinted by their ID
+	rule.FindingKey = ""
+	require.NoError(t, rm.UpdateRule(rule))
+	assert.Equal(t, FindingFingerprint("192.168.1.50", FindingRemoteAccess, "r1"), run().Fingerprint)
+
+	stored, err := store.GetDeviceResults("d1", 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, stored)
+	assert.NotEmpty(t, stored[0].Fingerprint, "fingerprints are stored with the results")
+}
+
+func TestResultStore_GetOpenFindings(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	_, err := db.Exec("INSERT INTO devices (id, ip_address) VALUES ('d1', '10.0.0.1'), ('d2', '10.0.0.2')")
+	require.NoError(t, err)
+	store := NewResultStore(db)
+
+	start := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	result := func(deviceID, name, key string, status CheckStatus, day int) CheckResult {
+		r := newTestResult(deviceID, "run", status, start.AddDate(0, 0, day))
+		r.CheckName, r.FindingCategory, r.FindingKey = name, FindingRemoteAccess, key
+		return r
+	}
+	save := func(results ...CheckResult) {
+		t.Helper()
+		require.NoError(t, store.SaveResults(results))
+	}
+
+	save(result("d1", "Telnet", "telnet-enabled", StatusFail, 0),
+		result("d2", "Telnet", "telnet-enabled", StatusFail, 0),
+		result("d1", "HTTP", "http-server-enabled", StatusFail, 0))
+	// The telnet finding on d1 keeps failing under a new rule name
+	save(result("d1", "Telnet VTY", "telnet-enabled", StatusFail, 1),
+		result("d2", "Telnet", "telnet-enabled", StatusPass, 1),
+		result("d1", "HTTP", "http-server-enabled", StatusError, 1))
+	// The HTTP finding is resolved, then reopens
+	save(result("d1", "HTTP", "http-server-enabled", StatusPass, 2))
+	save(result("d1", "HTTP", "http-server-enabled", StatusFail, 3))
+	// Results that name no finding are not fingerprinted
+	save(newTestResult("d1", "run", StatusFail, start))
+
+	findings, err := store.GetOpenFindings(false)
+	require.NoError(t, err)
+	require.Len(t, findings, 2)
+
+	telnet, http := findings[0], findings[1]
+	assert.Equal(t, FindingFingerprint("10.0.0.1", FindingRemoteAccess, "telnet-enabled"), telnet.Fingerprint)
+	assert.Equal(t, "Telnet VTY", telnet.CheckName, "the latest failure names the finding")
+	assert.Equal(t, 2, telnet.Occurrences)
+	assert.True(t, telnet.FirstSeen.Equal(start), "first seen %v", telnet.FirstSeen)
+	assert.True(t, telnet.LastSeen.Equal(start.AddDate(0, 0, 1)), "last seen %v", telnet.LastSeen)
+
+	assert.Equal(t, "d1", http.DeviceID)
+	assert.Equal(t, "http-server-enabled", http.FindingKey)
+	assert.Equal(t, 1, http.Occurrences)
+	assert.True(t, http.FirstSeen.Equal(start.AddDate(0, 0, 3)), "a reopened finding is first seen again, got %v", http.FirstSeen)
+}
+
+func TestResultStore_FingerprintOfRemovedDevice(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	store := NewResultStore(db)
+
+	r := newTestResult("gone", "run", StatusFail, time.Now())
+	r.FindingCategory, r.FindingKey = FindingSNMP, "snmp-default-community"
+	results := []CheckResult{r}
+	require.NoError(t, store.SaveResults(results))
+	assert.Equal(t, FindingFingerprint("device:gone", FindingSNMP, "snmp-default-community"), results[0].Fingerprint)
+}
+
+func TestResultStore_GetOpenFindingsSandbox(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	_, err := db.Exec("INSERT INTO devices (id, ip_address, is_sandbox) VALUES ('prod', '10.0.0.1', 0), ('lab', '10.0.0.2', 1)")
+	require.NoError(t, err)
+	store := NewResultStore(db)
+
+	var results []CheckResult
+	for _, deviceID := range []string{"prod", "lab"} {
+		r := newTestResult(deviceID, "run", StatusFail, time.Now())
+		r.FindingCategory, r.FindingKey = FindingRemoteAccess, "telnet-enabled"
+		results = append(results, r)
+	}
+	require.NoError(t, store.SaveResults(results))
+
+	findings, err := store.GetOpenFindings(false)
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	assert.Equal(t, "prod", findings[0].DeviceID)
+
+	findings, err = store.GetOpenFindings(true)
+	require.NoError(t, err)
+	assert.Len(t, findings, 2)
+}
@@ -0,0 +1,79 @@
+package checker
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// connInfoClient records the connection info the engine connects with
+type connInfoClient struct {
+	stubSSHClient
+	connInfos []*ssh.ConnectionInfo
+}
+
+func (c *connInfoClient) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	c.mu.Lock()
+	c.connInfos = append(c.connInfos, connInfo)
+	c.mu.Unlock()
+	return c.stubSSHClient.Connect(ctx, connInfo)
+}
+
+func newHostKey(t *testing.T) gossh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := gossh.NewPublicKey(public)
+	require.NoError(t, err)
+	return key
+}
+
+func TestDeviceHostKeyCallback(t *testing.T) {
+	key, other := newHostKey(t), newHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	defer ssh.ForgetHostKey("10.0.0.1", 22)
+
+	assert.Nil(t, DeviceHostKeyCallback(&device.Device{}), "TOFU is left to the client")
+	assert.Nil(t, DeviceHostKeyCallback(&device.Device{HostKeyPolicy: string(device.HostKeyPolicyTOFU)}))
+
+	strict := DeviceHostKeyCallback(&device.Device{HostKeyPolicy: string(device.HostKeyPolicyStrict),
+		HostKeyFingerprint: ssh.HostKeyFingerprint(key)})
+	require.NotNil(t, strict)
+	assert.NoError(t, strict("10.0.0.1:22", remote, key))
+	assert.ErrorIs(t, strict("10.0.0.1:22", remote, other), ssh.ErrHostKeyMismatch)
+
+	insecure := DeviceHostKeyCallback(&device.Device{HostKeyPolicy: string(device.HostKeyPolicyInsecure)})
+	require.NotNil(t, insecure)
+	assert.NoError(t, insecure("10.0.0.1:22", remote, other))
+}
+
+func TestEngine_ConnectsWithDeviceHostKeyPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	client := &connInfoClient{stubSSHClient: stubSSHClient{outputs: map[string]string{"show ip ssh": "version 2"}}}
+	engine := NewEngineWithSSHClient(NewRuleManager(db), client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{{ID: "ssh", Name: "SSH v2", Vendor: "cisco",
+		Command: "show ip ssh", ExpectedPattern: "version 2", Severity: string(SeverityHigh), Enabled: true}}))
+
+	dev := &device.Device{ID: "edge", Name: "Edge", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: "cisco", Username: "admin", SSHPort: 22, HostKeyPolicy: string(device.HostKeyPolicyInsecure)}
+	_, err := engine.RunChecksWithOptions(dev, CheckOptions{}, nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, client.connInfos)
+	assert.NotNil(t, client.connInfos[0].HostKeyCallback)
+
+	client.connInfos = nil
+	dev.HostKeyPolicy = string(device.HostKeyPolicyTOFU)
+	_, err = engine.RunChecksWithOptions(dev, CheckOptions{}, nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, client.connInfos)
+	assert.Nil(t, client.connInfos[0].HostKeyCallback)
+}
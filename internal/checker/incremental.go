@@ -0,0 +1,326 @@
+package checker
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/catalog"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/google/uuid"
+)
+
+// changeIndicator is what the last incremental run of a device saw: the
+// hash of its full config and the state of each rule it evaluated
+type changeIndicator struct {
+	RunID      string
+	Indicator  string
+	RuleStates map[string]ruleState
+}
+
+// ruleState identifies what a rule checked in a run and the name its
+// result was saved under
+type ruleState struct {
+	Signature string `json:"signature"`
+	CheckName string `json:"checkName"`
+}
+
+// SetResultStore enables incremental runs, which compare each device's
+// config with the one its last incremental run recorded in store and carry
+// its results forward. nil makes every run evaluate all rules.
+func (e *Engine) SetResultStore(store *ResultStore) {
+	e.resultStore = store
+}
+
+// getChangeIndicator returns the indicator recorded for a device, or nil
+// when none was
+func (rs *ResultStore) getChangeIndicator(deviceID string) (*changeIndicator, error) {
+	indicator := &changeIndicator{}
+	var states string
+	err := rs.db.QueryRow(`
+		SELECT run_id, indicator, rule_states
+		FROM device_change_indicators
+		WHERE device_id = ?
+	`, deviceID).Scan(&indicator.RunID, &indicator.Indicator, &states)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(states), &indicator.RuleStates); err != nil {
+		return nil, fmt.Errorf("failed to decode rule states: %w", err)
+	}
+	return indicator, nil
+}
+
+// saveChangeIndicator replaces the indicator recorded for a device
+func (rs *ResultStore) saveChangeIndicator(deviceID string, indicator changeIndicator) error {
+	states, err := json.Marshal(indicator.RuleStates)
+	if err != nil {
+		return fmt.Errorf("failed to encode rule states: %w", err)
+	}
+	_, err = rs.db.Exec(`
+		INSERT INTO device_change_indicators (device_id, run_id, indicator, rule_states, recorded_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET
+			run_id = excluded.run_id,
+			indicator = excluded.indicator,
+			rule_states = excluded.rule_states,
+			recorded_at = excluded.recorded_at
+	`, deviceID, indicator.RunID, indicator.Indicator, string(states), time.Now())
+	return err
+}
+
+// incrementalRun tracks one device's incremental run: the rules whose
+// previous result is carried forward and the state recorded for the next
+// run. Its methods treat a nil run as a full, unrecorded one.
+type incrementalRun struct {
+	store      *ResultStore
+	device     *device.Device
+	command    string
+	output     string
+	indicator  string
+	signatures map[string]string
+	carried    map[string]CheckResult
+	states     map[string]ruleState
+}
+
+// startIncremental fetches the device's config through the snapshot
+// command and decides which rules keep their previous result. It returns
+// nil when the run is not incremental or the config cannot be fetched, in
+// which case every rule is evaluated and nothing is recorded.
+func (e *Engine) startIncremental(client ssh.SSHClientInterface, device *device.Device, rules []SecurityRule,
+	opts CheckOptions) *incrementalRun {
+	if !opts.Incremental || e.resultStore == nil || device.IsInMaintenance() {
+		return nil
+	}
+	command := e.snapshotCommand(device.Vendor)
+	if command == "" {
+		return nil
+	}
+
+	output, err := e.fetchConfig(client, device, command)
+	if err != nil {
+		log.Printf("Failed to fetch config of device %s, running every rule: %v", device.ID, err)
+		return nil
+	}
+	e.captureOutput(client, device, command, output)
+
+	sum := sha256.Sum256([]byte(output))
+	run := &incrementalRun{
+		store:      e.resultStore,
+		device:     device,
+		command:    command,
+		output:     output,
+		indicator:  hex.EncodeToString(sum[:]),
+		signatures: make(map[string]string, len(rules)),
+		carried:    make(map[string]CheckResult),
+		states:     make(map[string]ruleState, len(rules)),
+	}
+	for _, rule := range rules {
+		if e.readsConfig(device, rule, command) {
+			run.signatures[rule.ID] = e.ruleSignature(device, rule)
+		}
+	}
+	if opts.ForceFull {
+		return run
+	}
+
+	previous, err := e.resultStore.getChangeIndicator(device.ID)
+	if err != nil {
+		log.Printf("Failed to read change indicator of device %s, running every rule: %v", device.ID, err)
+		return run
+	}
+	if previous == nil || previous.Indicator != run.indicator {
+		return run
+	}
+	results, err := e.resultStore.GetRunResults(previous.RunID, device.ID)
+	if err != nil {
+		if !errors.Is(err, ErrRunNotFound) {
+			log.Printf("Failed to read results of run %s, running every rule: %v", previous.RunID, err)
+		}
+		return run
+	}
+
+	byName := make(map[string]CheckResult, len(results))
+	for _, result := range results {
+		if _, ok := byName[result.CheckName]; !ok {
+			byName[result.CheckName] = result
+		}
+	}
+	for _, rule := range rules {
+		state, ok := previous.RuleStates[rule.ID]
+		signature := run.signatures[rule.ID]
+		if !ok || signature == "" || state.Signature != signature {
+			continue
+		}
+		if result, ok := byName[state.CheckName]; ok && result.Status != string(StatusError) {
+			run.carried[rule.ID] = result
+		}
+	}
+	return run
+}
+
+// readsConfig reports whether a rule, and its precondition, only read the
+// config the snapshot command fetches, whole or through an output filter
+// such as "show running-config | include ssh". An unchanged config then
+// means an unchanged result. Rules reading live state, like "show ip ssh",
+// are evaluated on every run.
+func (e *Engine) readsConfig(device *device.Device, rule SecurityRule, snapshot string) bool {
+	effective, _ := rule.ForDevice(device.Vendor, device.DeviceType)
+	commands := []string{effective.Command}
+	if effective.Precondition != nil {
+		commands = append(commands, effective.Precondition.Command)
+	}
+	for _, command := range commands {
+		expanded, err := e.expandCommand(command)
+		if err != nil {
+			return false
+		}
+		expanded = strings.TrimSpace(expanded)
+		if expanded != snapshot && !strings.HasPrefix(expanded, snapshot+" |") {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleSignature hashes what a rule checks on a device, after resolving its
+// variant and expanding its macros, so any edit to the rule or the macros
+// it uses makes it run again. It is empty when the macros do not expand.
+func (e *Engine) ruleSignature(device *device.Device, rule SecurityRule) string {
+	effective, _ := rule.ForDevice(device.Vendor, device.DeviceType)
+	command, err := e.expandCommand(effective.Command)
+	if err != nil {
+		return ""
+	}
+	effective.Command = command
+	if effective.Precondition != nil {
+		expanded, err := e.expandCommand(effective.Precondition.Command)
+		if err != nil {
+			return ""
+		}
+		effective.Precondition = &RulePrecondition{Command: expanded, Pattern: effective.Precondition.Pattern}
+	}
+	sum := sha256.Sum256([]byte(ruleKey(effective, false)))
+	return hex.EncodeToString(sum[:])
+}
+
+// pending returns the rules that are evaluated rather than carried forward
+func (r *incrementalRun) pending(rules []SecurityRule) []SecurityRule {
+	if r == nil || len(r.carried) == 0 {
+		return rules
+	}
+	pending := make([]SecurityRule, 0, len(rules))
+	for _, rule := range rules {
+		if _, ok := r.carried[rule.ID]; !ok {
+			pending = append(pending, rule)
+		}
+	}
+	return pending
+}
+
+// seed shares the fetched config with the rules and the run's snapshot
+func (r *incrementalRun) seed(outputs map[string]string) {
+	if r == nil || outputs == nil {
+		return
+	}
+	if _, ok := outputs[r.command]; !ok {
+		outputs[r.command] = r.output
+	}
+}
+
+// previousResult returns the result carried forward for a rule
+func (r *incrementalRun) previousResult(rule SecurityRule) (CheckResult, bool) {
+	if r == nil {
+		return CheckResult{}, false
+	}
+	result, ok := r.carried[rule.ID]
+	return result, ok
+}
+
+// record notes a rule's result for the next run. Errors are left out so
+// the rule runs again.
+func (r *incrementalRun) record(rule SecurityRule, result CheckResult) {
+	if r == nil || result.Status == string(StatusError) || r.signatures[rule.ID] == "" {
+		return
+	}
+	r.states[rule.ID] = ruleState{Signature: r.signatures[rule.ID], CheckName: result.CheckName}
+}
+
+// finish saves the run's indicator once all rules ran. Failures are only
+// logged, leaving the next run to evaluate every rule if needed.
+func (r *incrementalRun) finish(runID string) {
+	if r == nil {
+		return
+	}
+	indicator := changeIndicator{RunID: runID, Indicator: r.indicator, RuleStates: r.states}
+	if err := r.store.saveChangeIndicator(r.device.ID, indicator); err != nil {
+		log.Printf("Failed to save change indicator of device %s: %v", r.device.ID, err)
+	}
+}
+
+// executeOrCarryRule carries a rule's previous result forward when the
+// incremental run kept it and evaluates the rule otherwise
+func (e *Engine) executeOrCarryRule(client ssh.SSHClientInterface, device *device.Device, rule SecurityRule,
+	outputs map[string]string, incremental *incrementalRun) (CheckResult, error) {
+	if previous, ok := incremental.previousResult(rule); ok {
+		return e.carryForward(previous, rule), nil
+	}
+	return e.executeRule(client, device, rule, outputs)
+}
+
+// carryForward copies a previous result into the current run. The copy
+// takes the rule's current name, severity and finding, and keeps when and
+// in which run the rule was actually evaluated.
+func (e *Engine) carryForward(previous CheckResult, rule SecurityRule) CheckResult {
+	result := previous
+	result.ID = uuid.New().String()
+	result.CheckName = rule.Name
+	result.Severity = rule.Severity
+	result.OriginalSeverity = ""
+	result.OverrideReason = ""
+	rule.setFinding(&result)
+	result.Fingerprint = ""
+	result.CheckedAt = time.Now()
+	result.Duration = 0
+	result.Phases = ssh.PhaseTimings{}
+	result.Comments = nil
+
+	evaluatedAt := previous.CheckedAt
+	if previous.EvaluatedAt != nil {
+		evaluatedAt = *previous.EvaluatedAt
+	}
+	result.EvaluatedAt = &evaluatedAt
+	if result.CarriedFromRunID == "" {
+		result.CarriedFromRunID = previous.RunID
+	}
+	result.CarriedForward = true
+
+	if previous.MessageID != "" {
+		e.setMessage(&result, catalog.NewMessage(previous.MessageID, previous.MessageParams))
+	}
+	return result
+}
+
+// CarriedForwardNote describes where a carried forward result comes from,
+// for reports. It is empty for results evaluated in their own run.
+func (r CheckResult) CarriedForwardNote() string {
+	if !r.CarriedForward {
+		return ""
+	}
+	note := "Carried forward from run " + r.CarriedFromRunID
+	if r.EvaluatedAt != nil {
+		note += ", evaluated at " + r.EvaluatedAt.UTC().Format(time.RFC3339)
+	}
+	return note
+}
@@ -0,0 +1,281 @@
+package checker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const runningConfig = "hostname edge-01\nip ssh version 2\nno service telnet\n"
+
+// Commands of the incremental rules, which read the running config
+const (
+	sshConfigCommand    = "show running-config | include ip ssh"
+	telnetConfigCommand = "show running-config | include telnet"
+)
+
+func incrementalRules() []SecurityRule {
+	return []SecurityRule{
+		{ID: "ssh", Name: "SSH v2", Vendor: "cisco", Command: sshConfigCommand, ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "telnet", Name: "Telnet disabled", Vendor: "cisco", Command: telnetConfigCommand, ExpectedPattern: "disabled",
+			Severity: string(SeverityMedium), Enabled: true},
+	}
+}
+
+// setupIncrementalEngine returns an engine with result storage checking a
+// Cisco device through client
+func setupIncrementalEngine(t *testing.T, client ssh.SSHClientInterface) (*Engine, *ResultStore, *RuleManager) {
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	rm := NewRuleManager(db)
+	store := NewResultStore(db)
+	engine := NewEngineWithSSHClient(rm, client)
+	engine.SetResultStore(store)
+	require.NoError(t, engine.LoadCustomRules(incrementalRules()))
+	return engine, store, rm
+}
+
+func incrementalDevice() *device.Device {
+	return &device.Device{ID: "edge", Name: "Edge", IPAddress: "10.0.0.1", DeviceType: string(device.TypeRouter),
+		Vendor: "cisco", Username: "admin", SSHPort: 22}
+}
+
+// runAndSave runs the checks of a device and saves the results like the
+// app does
+func runAndSave(t *testing.T, engine *Engine, store *ResultStore, opts CheckOptions) []CheckResult {
+	results, err := engine.RunChecksWithOptions(incrementalDevice(), opts, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.SaveResults(results))
+	return results
+}
+
+func TestEngine_IncrementalUnchangedConfig(t *testing.T) {
+	client := &stubSSHClient{outputs: map[string]string{
+		"show running-config": runningConfig,
+		sshConfigCommand:      "SSH Enabled - version 2.0",
+		telnetConfigCommand:   "telnet enabled",
+	}}
+	engine, store, _ := setupIncrementalEngine(t, client)
+	incremental := CheckOptions{Incremental: true}
+
+	first := runAndSave(t, engine, store, incremental)
+	assert.ElementsMatch(t, []string{"show running-config", sshConfigCommand, telnetConfigCommand}, client.executed)
+	for _, result := range first {
+		assert.False(t, result.CarriedForward)
+	}
+
+	client.executed = nil
+	second := runAndSave(t, engine, store, incremental)
+	assert.Equal(t, []string{"show running-config"}, client.executed, "no rule command runs on an unchanged config")
+
+	require.Len(t, second, 2)
+	before := resultsByName(first)
+	for _, result := range second {
+		original := before[result.CheckName]
+		assert.True(t, result.CarriedForward, result.CheckName)
+		assert.NotEqual(t, first[0].RunID, result.RunID, "carried results belong to the new run")
+		assert.NotEqual(t, original.ID, result.ID)
+		assert.Equal(t, original.RunID, result.CarriedFromRunID)
+		assert.Equal(t, original.Status, result.Status)
+		assert.Equal(t, original.Message, result.Message)
+		assert.Equal(t, original.Evidence, result.Evidence)
+		require.NotNil(t, result.EvaluatedAt)
+		assert.True(t, original.CheckedAt.Equal(*result.EvaluatedAt), "the original evaluation time is kept")
+		assert.True(t, result.CheckedAt.After(original.CheckedAt))
+	}
+
+	stored, err := store.GetRunResults(second[0].RunID, "edge")
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	for _, result := range stored {
+		assert.True(t, result.CarriedForward)
+		assert.Equal(t, first[0].RunID, result.CarriedFromRunID)
+		require.NotNil(t, result.EvaluatedAt)
+		assert.WithinDuration(t, before[result.CheckName].CheckedAt, *result.EvaluatedAt, time.Millisecond)
+	}
+
+	// Carrying a carried result forward again still points at the run
+	// that evaluated it
+	third := runAndSave(t, engine, store, incremental)
+	for _, result := range third {
+		assert.True(t, result.CarriedForward)
+		assert.Equal(t, first[0].RunID, result.CarriedFromRunID)
+		assert.WithinDuration(t, before[result.CheckName].CheckedAt, *result.EvaluatedAt, time.Millisecond)
+	}
+	assert.Equal(t, []string{"show running-config", "show running-config"}, client.executed)
+}
+
+func TestEngine_IncrementalLiveState(t *testing.T) {
+	client := &stubSSHClient{outputs: map[string]string{
+		"show running-config":                    runningConfig,
+		sshConfigCommand:                         "ip ssh version 2",
+		telnetConfigCommand:                      "telnet enabled",
+		"show ntp status":                        "Clock is synchronized",
+		"show ip interface brief":                "GigabitEthernet0/0 up up",
+		"show running-config | section line vty": "line vty 0 4\n transport input ssh",
+	}}
+	engine, store, _ := setupIncrementalEngine(t, client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "ntp", Name: "NTP synchronized", Vendor: "cisco", Command: "show ntp status",
+			ExpectedPattern: "synchronized", Severity: string(SeverityLow), Enabled: true},
+		{ID: "vty", Name: "VTY transport", Vendor: "cisco", Command: "show running-config | section line vty",
+			ExpectedPattern: "transport input ssh", Severity: string(SeverityLow), Enabled: true,
+			Precondition: &RulePrecondition{Command: "show ip interface brief", Pattern: "up"}},
+	}))
+	incremental := CheckOptions{Incremental: true}
+	runAndSave(t, engine, store, incremental)
+
+	// The clock drifts without any change to the config
+	client.outputs["show ntp status"] = "Clock is not in sync"
+	client.executed = nil
+	byName := resultsByName(runAndSave(t, engine, store, incremental))
+	assert.ElementsMatch(t, []string{"show running-config", "show ntp status", "show ip interface brief",
+		"show running-config | section line vty"}, client.executed, "rules reading live state run every time")
+	assert.True(t, byName["SSH v2"].CarriedForward)
+	assert.False(t, byName["NTP synchronized"].CarriedForward)
+	assert.Equal(t, string(StatusFail), byName["NTP synchronized"].Status)
+	assert.False(t, byName["VTY transport"].CarriedForward, "a live precondition makes the rule run again")
+}
+
+func TestEngine_IncrementalChangedConfig(t *testing.T) {
+	client := &stubSSHClient{outputs: map[string]string{
+		"show running-config": runningConfig,
+		sshConfigCommand:      "SSH Enabled - version 2.0",
+		telnetConfigCommand:   "telnet enabled",
+	}}
+	engine, store, _ := setupIncrementalEngine(t, client)
+	incremental := CheckOptions{Incremental: true}
+	runAndSave(t, engine, store, incremental)
+
+	client.outputs["show running-config"] = runningConfig + "no service telnet-zeroidle\n"
+	client.outputs[telnetConfigCommand] = "telnet disabled"
+	client.executed = nil
+	results := runAndSave(t, engine, store, incremental)
+	assert.ElementsMatch(t, []string{"show running-config", sshConfigCommand, telnetConfigCommand}, client.executed)
+	for _, result := range results {
+		assert.False(t, result.CarriedForward, result.CheckName)
+		assert.Nil(t, result.EvaluatedAt)
+	}
+	assert.Equal(t, string(StatusPass), resultsByName(results)["Telnet disabled"].Status)
+}
+
+func TestEngine_IncrementalChangedRule(t *testing.T) {
+	client := &stubSSHClient{outputs: map[string]string{
+		"show running-config": runningConfig,
+		sshConfigCommand:      "SSH Enabled - version 2.0",
+		telnetConfigCommand:   "telnet enabled",
+	}}
+	engine, store, rm := setupIncrementalEngine(t, client)
+	incremental := CheckOptions{Incremental: true}
+	runAndSave(t, engine, store, incremental)
+
+	rule, err := rm.GetRule("telnet")
+	require.NoError(t, err)
+	rule.ExpectedPattern = "enabled"
+	require.NoError(t, rm.UpdateRule(*rule))
+
+	client.executed = nil
+	results := runAndSave(t, engine, store, incremental)
+	assert.ElementsMatch(t, []string{"show running-config", telnetConfigCommand}, client.executed,
+		"only the edited rule runs again")
+	byName := resultsByName(results)
+	assert.True(t, byName["SSH v2"].CarriedForward)
+	assert.False(t, byName["Telnet disabled"].CarriedForward)
+	assert.Equal(t, string(StatusPass), byName["Telnet disabled"].Status)
+}
+
+func TestEngine_IncrementalIndicatorUnavailable(t *testing.T) {
+	client := &streamSSHClient{results: map[string]ssh.CommandResult{
+		"show running-config": {Stdout: runningConfig},
+		sshConfigCommand:      {Stdout: "SSH Enabled - version 2.0"},
+		telnetConfigCommand:   {Stdout: "telnet enabled"},
+	}}
+	engine, store, _ := setupIncrementalEngine(t, client)
+	incremental := CheckOptions{Incremental: true}
+	first := runAndSave(t, engine, store, incremental)
+
+	// The config cannot be read, so every rule runs and the last recorded
+	// indicator stays in place
+	client.results["show running-config"] = ssh.CommandResult{Stderr: "% Authorization failed", ExitCode: 1}
+	client.executed = nil
+	results := runAndSave(t, engine, store, incremental)
+	assert.ElementsMatch(t, []string{"show running-config", sshConfigCommand, telnetConfigCommand}, client.executed)
+	for _, result := range results {
+		assert.False(t, result.CarriedForward, result.CheckName)
+	}
+
+	indicator, err := store.getChangeIndicator("edge")
+	require.NoError(t, err)
+	require.NotNil(t, indicator)
+	assert.Equal(t, first[0].RunID, indicator.RunID)
+}
+
+func TestEngine_IncrementalForceFull(t *testing.T) {
+	client := &stubSSHClient{outputs: map[string]string{
+		"show running-config": runningConfig,
+		sshConfigCommand:      "SSH Enabled - version 2.0",
+		telnetConfigCommand:   "telnet enabled",
+	}}
+	engine, store, _ := setupIncrementalEngine(t, client)
+
+	// Plain runs neither fetch nor record the config
+	runAndSave(t, engine, store, CheckOptions{})
+	assert.ElementsMatch(t, []string{sshConfigCommand, telnetConfigCommand}, client.executed)
+	indicator, err := store.getChangeIndicator("edge")
+	require.NoError(t, err)
+	assert.Nil(t, indicator)
+
+	runAndSave(t, engine, store, CheckOptions{Incremental: true})
+	client.executed = nil
+	forced := runAndSave(t, engine, store, CheckOptions{Incremental: true, ForceFull: true})
+	assert.ElementsMatch(t, []string{"show running-config", sshConfigCommand, telnetConfigCommand}, client.executed)
+	for _, result := range forced {
+		assert.False(t, result.CarriedForward, result.CheckName)
+	}
+
+	// The forced run becomes the one later runs carry forward from
+	client.executed = nil
+	results := runAndSave(t, engine, store, CheckOptions{Incremental: true})
+	assert.Equal(t, []string{"show running-config"}, client.executed)
+	for _, result := range results {
+		assert.Equal(t, forced[0].RunID, result.CarriedFromRunID)
+	}
+}
+
+func TestEngine_IncrementalBulk(t *testing.T) {
+	client := &stubSSHClient{outputs: map[string]string{
+		"show running-config": runningConfig,
+		sshConfigCommand:      "SSH Enabled - version 2.0",
+		telnetConfigCommand:   "telnet enabled",
+	}}
+	engine, store, _ := setupIncrementalEngine(t, client)
+	devices := []device.Device{*incrementalDevice()}
+
+	for i := 0; i < 2; i++ {
+		client.executed = nil
+		bulk, err := engine.RunBulkChecksContext(context.Background(), devices, CheckOptions{Incremental: true}, nil)
+		require.NoError(t, err)
+		results := bulk.DeviceResults["edge"]
+		require.Len(t, results, 2)
+		require.NoError(t, store.SaveResults(results))
+		for _, result := range results {
+			assert.Equal(t, i == 1, result.CarriedForward)
+		}
+	}
+	assert.Equal(t, []string{"show running-config"}, client.executed)
+}
+
+func TestCheckResult_CarriedForwardNote(t *testing.T) {
+	assert.Empty(t, CheckResult{}.CarriedForwardNote())
+
+	evaluated := time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)
+	result := CheckResult{CarriedForward: true, CarriedFromRunID: "run1", EvaluatedAt: &evaluated}
+	assert.Equal(t, "Carried forward from run run1, evaluated at 2024-03-01T02:00:00Z", result.CarriedForwardNote())
+}
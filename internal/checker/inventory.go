@@ -0,0 +1,160 @@
+package checker
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+)
+
+// DeviceInventory is the hardware model and software version a device
+// reports in its version command output
+type DeviceInventory struct {
+	Model     string `json:"model"`
+	OSVersion string `json:"osVersion"`
+}
+
+// InventoryFunc is told the inventory found in a device's version command
+// output during a check run
+type InventoryFunc func(deviceID string, inventory DeviceInventory) error
+
+// versionPatterns says how to read the inventory of a vendor: the command
+// printing it and patterns whose first non-empty group is the model and
+// the OS version
+type versionPatterns struct {
+	command   string
+	model     *regexp.Regexp
+	osVersion *regexp.Regexp
+}
+
+// defaultVersionPatterns holds the version command of each vendor whose
+// output the inventory is read from
+var defaultVersionPatterns = map[string]versionPatterns{
+	"cisco": {
+		command: "show version",
+		// "cisco ISR4451-X/K9 (2RU) processor" or "cisco Nexus9000 C93180YC-EX chassis"
+		model: regexp.MustCompile(`(?mi)^\s*cisco\s+(.+?)\s+(?:\([^)]*\)\s+processor|chassis)`),
+		// "Cisco IOS XE Software, Version 16.09.04" or "NXOS: version 9.3(8)"
+		osVersion: regexp.MustCompile(`(?i)(?:,\s*Version|NXOS:\s*version|system:\s*version)\s+([^\s,]+)`),
+	},
+	"arista": {
+		command:   "show version",
+		model:     regexp.MustCompile(`(?m)^\s*Arista\s+(\S+)`),
+		osVersion: regexp.MustCompile(`(?mi)^\s*Software image version:\s*(\S+)`),
+	},
+	"juniper": {
+		command:   "show version",
+		model:     regexp.MustCompile(`(?mi)^\s*Model:\s*(\S+)`),
+		osVersion: regexp.MustCompile(`(?mi)^\s*Junos:\s*(\S+)|JUNOS .*?\[([^\]]+)\]`),
+	},
+	"huawei": {
+		command:   "display version",
+		model:     regexp.MustCompile(`(?mi)^\s*HUAWEI\s+(\S+)\s+(?:uptime|Routing Switch)`),
+		osVersion: regexp.MustCompile(`(?i)VRP \(R\) software,\s*Version\s+([^\s,]+)`),
+	},
+	"fortinet": {
+		command:   "get system status",
+		model:     regexp.MustCompile(`(?m)^\s*Version:\s*(\S+)\s+v`),
+		osVersion: regexp.MustCompile(`(?m)^\s*Version:\s*\S+\s+v([^\s,]+)`),
+	},
+	"palo_alto": {
+		command:   "show system info",
+		model:     regexp.MustCompile(`(?m)^\s*model:\s*(\S+)`),
+		osVersion: regexp.MustCompile(`(?m)^\s*sw-version:\s*(\S+)`),
+	},
+	"mikrotik": {
+		command:   "/system resource print",
+		model:     regexp.MustCompile(`(?m)^\s*board-name:\s*(.+?)\s*$`),
+		osVersion: regexp.MustCompile(`(?m)^\s*version:\s*(\S+)`),
+	},
+}
+
+// VersionCommand returns the command whose output the inventory of a
+// vendor's devices is read from, or an empty string when there is none
+func VersionCommand(vendor string) string {
+	return defaultVersionPatterns[vendor].command
+}
+
+// ExtractInventory reads the model and OS version from a vendor's version
+// command output. Either is empty when the output does not show it.
+func ExtractInventory(vendor, output string) DeviceInventory {
+	patterns, ok := defaultVersionPatterns[vendor]
+	if !ok {
+		return DeviceInventory{}
+	}
+	return DeviceInventory{
+		Model:     firstGroup(patterns.model, output),
+		OSVersion: firstGroup(patterns.osVersion, output),
+	}
+}
+
+// firstGroup returns the first non-empty group of the first match
+func firstGroup(pattern *regexp.Regexp, output string) string {
+	match := pattern.FindStringSubmatch(output)
+	if len(match) < 2 {
+		return ""
+	}
+	for _, group := range match[1:] {
+		if group = strings.TrimSpace(group); group != "" {
+			return group
+		}
+	}
+	return ""
+}
+
+// SetInventoryHook makes check runs report the inventory found in the
+// output of the vendor's version command, when a rule ran it. Runs that do
+// not run it leave the inventory alone. nil stops the reports.
+func (e *Engine) SetInventoryHook(fn InventoryFunc) {
+	e.inventoryHook = fn
+}
+
+// recordVersionOutput keeps the output of the vendor's version command so
+// the inventory can be read from it after the run
+func (e *Engine) recordVersionOutput(outputs map[string]string, vendor, command, output string) {
+	if outputs == nil || e.inventoryHook == nil {
+		return
+	}
+	if version := VersionCommand(vendor); version != "" && version == command {
+		outputs[command] = output
+	}
+}
+
+// recordInventory reports the inventory found at the end of a check run
+// when it differs from what the device has. A part the output does not
+// show keeps its current value, and simulated output is never reported.
+// Failures are only logged so they never fail the run.
+func (e *Engine) recordInventory(client ssh.SSHClientInterface, device *device.Device, outputs map[string]string) {
+	if e.inventoryHook == nil || device.IsInMaintenance() {
+		return
+	}
+	if e.simulator != nil && client == e.simulator {
+		return
+	}
+	command := VersionCommand(device.Vendor)
+	if command == "" {
+		return
+	}
+	output, ok := outputs[command]
+	if !ok {
+		return
+	}
+
+	found := ExtractInventory(device.Vendor, output)
+	inventory := DeviceInventory{Model: device.Model, OSVersion: device.OSVersion}
+	if found.Model != "" {
+		inventory.Model = found.Model
+	}
+	if found.OSVersion != "" {
+		inventory.OSVersion = found.OSVersion
+	}
+	if inventory.Model == device.Model && inventory.OSVersion == device.OSVersion {
+		return
+	}
+
+	if err := e.inventoryHook(device.ID, inventory); err != nil {
+		log.Printf("Failed to record inventory of device %s: %v", device.ID, err)
+	}
+}
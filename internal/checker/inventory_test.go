@@ -0,0 +1,156 @@
+package checker
+
+import (
+	"fmt"
+	"testing"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractInventory(t *testing.T) {
+	tests := []struct {
+		name   string
+		vendor string
+		output string
+		want   DeviceInventory
+	}{
+		{
+			name:   "cisco ios xe",
+			vendor: "cisco",
+			output: "Cisco IOS XE Software, Version 16.09.04\n" +
+				"Cisco IOS Software [Fuji], ISR Software (X86_64_LINUX_IOSD-UNIVERSALK9-M), Version 16.9.4, RELEASE SOFTWARE (fc2)\n" +
+				"router uptime is 5 weeks, 2 days\n" +
+				"cisco ISR4451-X/K9 (2RU) processor with 1795979K/6147K bytes of memory.\n",
+			want: DeviceInventory{Model: "ISR4451-X/K9", OSVersion: "16.09.04"},
+		},
+		{
+			name:   "cisco ios",
+			vendor: "cisco",
+			output: "Cisco IOS Software, C2960X Software (C2960X-UNIVERSALK9-M), Version 15.2(7)E2, RELEASE SOFTWARE (fc3)\n" +
+				"cisco WS-C2960X-48FPD-L (APM86XXX) processor (revision D0) with 524288K bytes of memory.\n",
+			want: DeviceInventory{Model: "WS-C2960X-48FPD-L", OSVersion: "15.2(7)E2"},
+		},
+		{
+			name:   "cisco nx-os",
+			vendor: "cisco",
+			output: "Software\n  BIOS: version 07.69\n  NXOS: version 9.3(8)\nHardware\n" +
+				"  cisco Nexus9000 C93180YC-EX chassis\n",
+			want: DeviceInventory{Model: "Nexus9000 C93180YC-EX", OSVersion: "9.3(8)"},
+		},
+		{
+			name:   "arista",
+			vendor: "arista",
+			output: "Arista DCS-7050SX-64-R\nHardware version: 01.11\n" +
+				"Software image version: 4.25.3M\nArchitecture: i686\n",
+			want: DeviceInventory{Model: "DCS-7050SX-64-R", OSVersion: "4.25.3M"},
+		},
+		{
+			name:   "juniper",
+			vendor: "juniper",
+			output: "Hostname: edge1\nModel: mx240\nJunos: 20.4R3.8\n",
+			want:   DeviceInventory{Model: "mx240", OSVersion: "20.4R3.8"},
+		},
+		{
+			name:   "juniper legacy",
+			vendor: "juniper",
+			output: "Hostname: edge1\nModel: srx340\nJUNOS Software Release [18.4R2.7]\n",
+			want:   DeviceInventory{Model: "srx340", OSVersion: "18.4R2.7"},
+		},
+		{
+			name:   "huawei",
+			vendor: "huawei",
+			output: "Huawei Versatile Routing Platform Software\n" +
+				"VRP (R) software, Version 8.180 (CE6850 V200R005C10SPC800)\n" +
+				"HUAWEI CE6850-48S6Q-HI uptime is 100 days, 2 hours\n",
+			want: DeviceInventory{Model: "CE6850-48S6Q-HI", OSVersion: "8.180"},
+		},
+		{
+			name:   "fortinet",
+			vendor: "fortinet",
+			output: "Version: FortiGate-100F v7.0.12,build0523,230612 (GA.M)\nSerial-Number: FG100F\n",
+			want:   DeviceInventory{Model: "FortiGate-100F", OSVersion: "7.0.12"},
+		},
+		{
+			name:   "palo alto",
+			vendor: "palo_alto",
+			output: "hostname: fw1\nmodel: PA-3220\nsw-version: 10.1.6\n",
+			want:   DeviceInventory{Model: "PA-3220", OSVersion: "10.1.6"},
+		},
+		{
+			name:   "mikrotik",
+			vendor: "mikrotik",
+			output: "             uptime: 3w2d\n            version: 6.48.6 (stable)\n         board-name: CCR1036-8G-2S+\n",
+			want:   DeviceInventory{Model: "CCR1036-8G-2S+", OSVersion: "6.48.6"},
+		},
+		{
+			name:   "model only",
+			vendor: "juniper",
+			output: "Model: ex4300-48t\n",
+			want:   DeviceInventory{Model: "ex4300-48t"},
+		},
+		{
+			name:   "unknown vendor",
+			vendor: "other",
+			output: "Model: box\nVersion 1.0\n",
+			want:   DeviceInventory{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ExtractInventory(tt.vendor, tt.output))
+		})
+	}
+}
+
+func TestEngine_RecordsInventory(t *testing.T) {
+	client := &stubSSHClient{outputs: map[string]string{
+		"show version": "Cisco IOS XE Software, Version 17.06.05\ncisco C9300-48P (X86) processor with 1K bytes\nuptime is 5 days",
+		"show users":   "admin vty 0",
+	}}
+	engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "Uptime", Vendor: "cisco", Command: "show version", ExpectedPattern: "uptime",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "r2", Name: "Users", Vendor: "cisco", Command: "show users", ExpectedPattern: "admin",
+			Severity: string(SeverityLow), Enabled: true},
+	}))
+
+	var recorded []DeviceInventory
+	var hookErr error
+	engine.SetInventoryHook(func(deviceID string, inventory DeviceInventory) error {
+		assert.Equal(t, "d1", deviceID)
+		recorded = append(recorded, inventory)
+		return hookErr
+	})
+
+	dev := &device.Device{ID: "d1", Name: "Access", IPAddress: "192.168.1.70", DeviceType: string(device.TypeSwitch),
+		Vendor: string(device.VendorCisco), Username: "admin", SSHPort: 22, Model: "C9300-48P"}
+	_, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, DeviceInventory{Model: "C9300-48P", OSVersion: "17.06.05"}, recorded[0])
+
+	// Nothing new to report
+	dev.OSVersion = "17.06.05"
+	_, err = engine.RunChecks(dev)
+	require.NoError(t, err)
+	assert.Len(t, recorded, 1)
+
+	// A failing hook does not fail the run
+	dev.OSVersion = "17.03.01"
+	hookErr = fmt.Errorf("database is locked")
+	results, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Len(t, recorded, 2)
+
+	// Runs that do not run the version command leave the inventory alone
+	dev.OSVersion = "17.01.01"
+	_, err = engine.RunChecksWithOptions(dev, CheckOptions{RuleIDs: []string{"r2"}}, nil)
+	require.NoError(t, err)
+	assert.Len(t, recorded, 2)
+}
@@ -0,0 +1,237 @@
+package checker
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxMacroNameLength limits the name of a command macro
+const MaxMacroNameLength = 64
+
+// ErrMacroNotFound is returned when a command macro does not exist
+var ErrMacroNotFound = errors.New("command macro not found")
+
+// macroNamePattern matches a valid macro name
+var macroNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// macroReference matches an @name reference starting a word of a command
+var macroReference = regexp.MustCompile(`(^|\s)@([a-z0-9_]+)`)
+
+// CommandMacro is a named command template that rule commands reference as
+// @name, so vendor command syntax shared by many rules is kept in one place.
+// References are expanded just before a command is sent to a device.
+type CommandMacro struct {
+	Name        string    `json:"name"`
+	Command     string    `json:"command"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Validate checks the macro's name and command. Macros cannot reference
+// other macros, so expanding a command never recurses.
+func (m CommandMacro) Validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("macro name cannot be empty")
+	}
+	if len(m.Name) > MaxMacroNameLength {
+		return fmt.Errorf("macro name cannot exceed %d characters", MaxMacroNameLength)
+	}
+	if !macroNamePattern.MatchString(m.Name) {
+		return fmt.Errorf("macro name %q may only contain lowercase letters, digits and underscores", m.Name)
+	}
+	if strings.TrimSpace(m.Command) == "" {
+		return fmt.Errorf("macro command cannot be empty")
+	}
+	if refs := MacroReferences(m.Command); len(refs) > 0 {
+		return fmt.Errorf("macro command cannot reference other macros (found @%s)", refs[0])
+	}
+	return nil
+}
+
+// Warnings returns the lines of the macro's command that look like they
+// change the device, for the author to confirm. Every rule referencing the
+// macro sends them.
+func (m CommandMacro) Warnings() []CommandWarning {
+	return commandWarnings(m.Command, VariantMacro+m.Name)
+}
+
+// MacroReferences returns the names of the macros a command references, in
+// order of first appearance
+func MacroReferences(command string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range macroReference.FindAllStringSubmatch(command, -1) {
+		if name := match[2]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ExpandMacros replaces every @name reference in a command with the command
+// of the macro of that name. Referencing an unknown macro is an error, so a
+// rule never sends an unexpanded reference to a device.
+func ExpandMacros(command string, macros map[string]string) (string, error) {
+	var expanded strings.Builder
+	last := 0
+	for _, loc := range macroReference.FindAllStringSubmatchIndex(command, -1) {
+		name := command[loc[4]:loc[5]]
+		macro, ok := macros[name]
+		if !ok {
+			return "", fmt.Errorf("unknown command macro @%s", name)
+		}
+		expanded.WriteString(command[last : loc[4]-1])
+		expanded.WriteString(macro)
+		last = loc[5]
+	}
+	expanded.WriteString(command[last:])
+	return expanded.String(), nil
+}
+
+// MacroManager stores command macros
+type MacroManager struct {
+	db *sql.DB
+}
+
+// NewMacroManager creates a new command macro manager
+func NewMacroManager(db *sql.DB) *MacroManager {
+	return &MacroManager{db: db}
+}
+
+// CreateMacro stores a new macro and fills in its timestamps
+func (mm *MacroManager) CreateMacro(macro *CommandMacro) error {
+	macro.Command = strings.TrimSpace(macro.Command)
+	macro.Description = strings.TrimSpace(macro.Description)
+	if err := macro.Validate(); err != nil {
+		return err
+	}
+
+	macro.CreatedAt = time.Now()
+	macro.UpdatedAt = macro.CreatedAt
+
+	query := `
+		INSERT INTO command_macros (name, command, description, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	if _, err := mm.db.Exec(query, macro.Name, macro.Command, macro.Description,
+		macro.CreatedAt, macro.UpdatedAt); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("a command macro named %s already exists", macro.Name)
+		}
+		return fmt.Errorf("failed to create command macro: %w", err)
+	}
+	return nil
+}
+
+// UpdateMacro replaces the command and description of a macro. Rules
+// referencing it run the new command from their next check.
+func (mm *MacroManager) UpdateMacro(macro CommandMacro) error {
+	existing, err := mm.GetMacro(macro.Name)
+	if err != nil {
+		return err
+	}
+	existing.Command = strings.TrimSpace(macro.Command)
+	existing.Description = strings.TrimSpace(macro.Description)
+	existing.UpdatedAt = time.Now()
+	if err := existing.Validate(); err != nil {
+		return err
+	}
+
+	query := `UPDATE command_macros SET command = ?, description = ?, updated_at = ? WHERE name = ?`
+	if _, err := mm.db.Exec(query, existing.Command, existing.Description, existing.UpdatedAt, existing.Name); err != nil {
+		return fmt.Errorf("failed to update command macro: %w", err)
+	}
+	return nil
+}
+
+// DeleteMacro removes a macro. Rules still referencing it fail with an
+// unknown macro error until they are changed.
+func (mm *MacroManager) DeleteMacro(name string) error {
+	result, err := mm.db.Exec("DELETE FROM command_macros WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete command macro: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrMacroNotFound
+	}
+	return nil
+}
+
+// GetMacro returns a macro by name
+func (mm *MacroManager) GetMacro(name string) (*CommandMacro, error) {
+	macros, err := mm.query("WHERE name = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(macros) == 0 {
+		return nil, ErrMacroNotFound
+	}
+	return &macros[0], nil
+}
+
+// GetAllMacros returns every macro ordered by name
+func (mm *MacroManager) GetAllMacros() ([]CommandMacro, error) {
+	return mm.query("")
+}
+
+// Commands returns the command of every macro keyed by name, as used by
+// ExpandMacros
+func (mm *MacroManager) Commands() (map[string]string, error) {
+	macros, err := mm.query("")
+	if err != nil {
+		return nil, err
+	}
+	commands := make(map[string]string, len(macros))
+	for _, macro := range macros {
+		commands[macro.Name] = macro.Command
+	}
+	return commands, nil
+}
+
+// query returns the macros matching a filter ordered by name
+func (mm *MacroManager) query(filter string, args ...interface{}) ([]CommandMacro, error) {
+	rows, err := mm.db.Query(`
+		SELECT name, command, description, created_at, updated_at
+		FROM command_macros `+filter+`
+		ORDER BY name`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query command macros: %w", err)
+	}
+	defer rows.Close()
+
+	var macros []CommandMacro
+	for rows.Next() {
+		var macro CommandMacro
+		if err := rows.Scan(&macro.Name, &macro.Command, &macro.Description,
+			&macro.CreatedAt, &macro.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan command macro: %w", err)
+		}
+		macros = append(macros, macro)
+	}
+	return macros, rows.Err()
+}
+
+// SetMacroManager sets where the engine reads command macros from. Without
+// one, rule commands are sent as written.
+func (e *Engine) SetMacroManager(macros *MacroManager) {
+	e.macroManager = macros
+}
+
+// expandCommand expands the macro references of a rule command. Commands
+// without references are returned without reading the macros.
+func (e *Engine) expandCommand(command string) (string, error) {
+	if e.macroManager == nil || !strings.Contains(command, "@") {
+		return command, nil
+	}
+	macros, err := e.macroManager.Commands()
+	if err != nil {
+		return "", fmt.Errorf("failed to load command macros: %w", err)
+	}
+	return ExpandMacros(command, macros)
+}
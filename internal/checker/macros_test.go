@@ -0,0 +1,154 @@
+package checker
+
+import (
+	"testing"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandMacros(t *testing.T) {
+	macros := map[string]string{
+		"vty_section": "show running-config | section line vty",
+		"ver":         "show version",
+	}
+
+	expanded, err := ExpandMacros("@vty_section", macros)
+	require.NoError(t, err)
+	assert.Equal(t, "show running-config | section line vty", expanded)
+
+	expanded, err = ExpandMacros("@ver\n@vty_section | include transport", macros)
+	require.NoError(t, err)
+	assert.Equal(t, "show version\nshow running-config | section line vty | include transport", expanded)
+
+	expanded, err = ExpandMacros("show run | include user@ver", macros)
+	require.NoError(t, err)
+	assert.Equal(t, "show run | include user@ver", expanded, "@ inside a word is not a reference")
+
+	_, err = ExpandMacros("@missing", macros)
+	assert.EqualError(t, err, "unknown command macro @missing")
+
+	assert.Equal(t, []string{"ver", "vty_section"}, MacroReferences("@ver; @vty_section; @ver"))
+	assert.Empty(t, MacroReferences("show version"))
+}
+
+func TestCommandMacro_Validate(t *testing.T) {
+	assert.NoError(t, CommandMacro{Name: "vty_section", Command: "show running-config | section line vty"}.Validate())
+	assert.Error(t, CommandMacro{Command: "show version"}.Validate())
+	assert.Error(t, CommandMacro{Name: "Vty-Section", Command: "show version"}.Validate())
+	assert.Error(t, CommandMacro{Name: "empty", Command: "  "}.Validate())
+	assert.Error(t, CommandMacro{Name: "nested", Command: "@vty_section | include ssh"}.Validate(),
+		"macros cannot reference macros")
+}
+
+func TestCommandMacro_Warnings(t *testing.T) {
+	assert.Empty(t, CommandMacro{Name: "vty_section", Command: "show running-config | section line vty"}.Warnings())
+
+	warnings := CommandMacro{Name: "save", Command: "show version\nwrite memory"}.Warnings()
+	require.Len(t, warnings, 1)
+	assert.Equal(t, VariantMacro+"save", warnings[0].Variant)
+	assert.Equal(t, "write memory", warnings[0].Command)
+}
+
+func TestValidateRuleCommands_ExpandsMacros(t *testing.T) {
+	macros := map[string]string{"save": "write memory", "ver": "show version"}
+
+	warnings, err := ValidateRuleCommands(SecurityRule{Command: "@ver",
+		CommandOverrides: map[string]string{"switch": "@save"}}, macros)
+	require.NoError(t, err)
+	require.Len(t, warnings, 1, "the macro body is checked, not the reference")
+	assert.Equal(t, "write memory", warnings[0].Command)
+	assert.Equal(t, VariantDeviceType+"switch", warnings[0].Variant)
+
+	_, err = ValidateRuleCommands(SecurityRule{Command: "@missing"}, macros)
+	assert.ErrorContains(t, err, "unknown command macro @missing")
+}
+
+func TestRuleManager_ValidateAllRulesExpandsMacros(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	mm := NewMacroManager(rm.db)
+	require.NoError(t, mm.CreateMacro(&CommandMacro{Name: "config", Command: "show running-config"}))
+	require.NoError(t, rm.CreateRule(SecurityRule{ID: "r1", Name: "Hostname", Vendor: "cisco", Command: "@config",
+		ExpectedPattern: "hostname", Severity: string(SeverityLow), Enabled: true}))
+
+	report, err := rm.ValidateAllRules()
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+
+	// Changing the macro changes what the rule sends
+	require.NoError(t, mm.UpdateMacro(CommandMacro{Name: "config", Command: "copy running-config startup-config"}))
+	report, err = rm.ValidateAllRules()
+	require.NoError(t, err)
+	require.Len(t, report.Broken, 1)
+	assert.Equal(t, RuleIssueRiskyCommand, report.Broken[0].Issues[0].Kind)
+	assert.Equal(t, "copy running-config startup-config", report.Broken[0].Issues[0].Command)
+}
+
+func TestMacroManager(t *testing.T) {
+	mm := NewMacroManager(setupTestDB(t))
+
+	macro := &CommandMacro{Name: "vty_section", Command: " show running-config | section line vty ", Description: "VTY lines"}
+	require.NoError(t, mm.CreateMacro(macro))
+	assert.Equal(t, "show running-config | section line vty", macro.Command)
+	assert.False(t, macro.CreatedAt.IsZero())
+	assert.Error(t, mm.CreateMacro(&CommandMacro{Name: "vty_section", Command: "show version"}), "names are unique")
+	require.NoError(t, mm.CreateMacro(&CommandMacro{Name: "aaa", Command: "show running-config | include aaa"}))
+
+	all, err := mm.GetAllMacros()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, "aaa", all[0].Name)
+
+	require.NoError(t, mm.UpdateMacro(CommandMacro{Name: "vty_section", Command: "show run | section vty"}))
+	updated, err := mm.GetMacro("vty_section")
+	require.NoError(t, err)
+	assert.Equal(t, "show run | section vty", updated.Command)
+	assert.Empty(t, updated.Description)
+	assert.ErrorIs(t, mm.UpdateMacro(CommandMacro{Name: "missing", Command: "show version"}), ErrMacroNotFound)
+
+	commands, err := mm.Commands()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"aaa": "show running-config | include aaa", "vty_section": "show run | section vty"}, commands)
+
+	require.NoError(t, mm.DeleteMacro("aaa"))
+	assert.ErrorIs(t, mm.DeleteMacro("aaa"), ErrMacroNotFound)
+	_, err = mm.GetMacro("aaa")
+	assert.ErrorIs(t, err, ErrMacroNotFound)
+}
+
+func TestEngine_ExpandsCommandMacros(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &stubSSHClient{outputs: map[string]string{
+		"show running-config | section line vty": "line vty 0 4\n transport input ssh",
+	}}
+	engine := NewEngineWithSSHClient(rm, client)
+	macros := NewMacroManager(rm.db)
+	engine.SetMacroManager(macros)
+	require.NoError(t, macros.CreateMacro(&CommandMacro{Name: "vty_section", Command: "show running-config | section line vty"}))
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "rule1", Name: "VTY SSH Only", Vendor: "cisco", Command: "@vty_section", ExpectedPattern: "transport input ssh",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "rule2", Name: "Unknown Macro", Vendor: "cisco", Command: "@missing", ExpectedPattern: "x",
+			Severity: string(SeverityLow), Enabled: true},
+	}))
+
+	dev := &device.Device{ID: "device1", Name: "Test Device", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22}
+	_, err := engine.CommandsForDevice(dev, CheckOptions{})
+	assert.ErrorContains(t, err, "Unknown Macro", "commands that cannot be expanded are not recorded as written")
+
+	results, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	byName := make(map[string]CheckResult)
+	for _, result := range results {
+		byName[result.CheckName] = result
+	}
+	assert.Equal(t, string(StatusPass), byName["VTY SSH Only"].Status)
+	assert.Equal(t, string(StatusError), byName["Unknown Macro"].Status)
+	assert.Contains(t, byName["Unknown Macro"].Message, "unknown command macro @missing")
+	assert.Equal(t, []string{"show running-config | section line vty"}, client.executed,
+		"unknown macros are never sent to the device")
+}
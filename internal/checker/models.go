@@ -1,6 +1,14 @@
 package checker
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/catalog"
+	"invictux-demo/internal/ssh"
+)
 
 // CheckResult represents the result of a security check
 type CheckResult struct {
@@ -13,6 +21,107 @@ type CheckResult struct {
 	Message   string    `json:"message" db:"message"`
 	Evidence  string    `json:"evidence" db:"evidence"`
 	CheckedAt time.Time `json:"checkedAt" db:"checked_at"`
+
+	// EvidenceStream names the output stream Evidence was taken from:
+	// StreamStdout, StreamStderr or StreamCombined
+	EvidenceStream string `json:"evidenceStream,omitempty" db:"evidence_stream"`
+
+	// EvidenceFullPath is the file holding the full command output when
+	// Evidence is a snippet of it
+	EvidenceFullPath string `json:"evidenceFullPath,omitempty" db:"evidence_full_path"`
+
+	// CommandVariant records which command variant of the rule was executed
+	CommandVariant string `json:"commandVariant,omitempty" db:"command_variant"`
+
+	// Command is the command sent for the check, with macros expanded. It
+	// is empty on results stored before commands were recorded.
+	Command string `json:"command,omitempty" db:"command"`
+
+	// RunID groups the results produced by one check run
+	RunID string `json:"runId,omitempty" db:"run_id"`
+
+	// RuleID is the rule that produced the result. CheckName keeps the
+	// rule's name at the time; RuleID follows the rule through renames and
+	// merges.
+	RuleID string `json:"ruleId,omitempty" db:"rule_id"`
+
+	// MessageID and MessageParams identify Message in the message catalog so
+	// the result can be rendered in another locale
+	MessageID     string         `json:"messageId,omitempty" db:"message_id"`
+	MessageParams catalog.Params `json:"messageParams,omitempty" db:"message_params"`
+
+	// OriginalSeverity is the rule's severity when a SeverityOverride set
+	// Severity, and OverrideReason explains the override
+	OriginalSeverity string `json:"originalSeverity,omitempty" db:"original_severity"`
+	OverrideReason   string `json:"overrideReason,omitempty" db:"severity_override_reason"`
+
+	// Duration is how long the check took, from connecting through
+	// evaluating the output. It is stored and encoded in milliseconds.
+	Duration time.Duration `json:"-" db:"duration_ms"`
+
+	// Phases breaks down where the check's connection and command spent
+	// their time. It is not stored.
+	Phases ssh.PhaseTimings `json:"phases"`
+
+	// FindingCategory and FindingKey identify what the result found
+	// independently of the rule's name; see SecurityRule. Fingerprint is set
+	// by ResultStore.SaveResults from them and the device's address.
+	FindingCategory string `json:"findingCategory,omitempty" db:"finding_category"`
+	FindingKey      string `json:"findingKey,omitempty" db:"finding_key"`
+	Fingerprint     string `json:"fingerprint,omitempty" db:"fingerprint"`
+
+	// CarriedForward marks a result an incremental run copied from an
+	// earlier run instead of evaluating the rule again, because neither the
+	// device's config nor the rule had changed. EvaluatedAt is when the rule
+	// was last actually evaluated and CarriedFromRunID the run that did it.
+	CarriedForward   bool       `json:"carriedForward,omitempty" db:"carried_forward"`
+	EvaluatedAt      *time.Time `json:"evaluatedAt,omitempty" db:"evaluated_at"`
+	CarriedFromRunID string     `json:"carriedFromRunId,omitempty" db:"carried_from_run_id"`
+
+	// Comments holds analyst notes. It is only filled when loaded through
+	// ResultStore.GetComments.
+	Comments []CheckComment `json:"comments,omitempty"`
+}
+
+// MarshalJSON encodes the check duration in milliseconds
+func (r CheckResult) MarshalJSON() ([]byte, error) {
+	type result CheckResult
+	return json.Marshal(struct {
+		result
+		DurationMs int64 `json:"durationMs"`
+	}{result: result(r), DurationMs: r.Duration.Milliseconds()})
+}
+
+// UnmarshalJSON decodes a result encoded by MarshalJSON
+func (r *CheckResult) UnmarshalJSON(data []byte) error {
+	type result CheckResult
+	decoded := struct {
+		*result
+		DurationMs int64 `json:"durationMs"`
+	}{result: (*result)(r)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	r.Duration = time.Duration(decoded.DurationMs) * time.Millisecond
+	return nil
+}
+
+// RenderMessage renders the result's message in a locale. Results stored
+// before messages had IDs keep their original text.
+func (r CheckResult) RenderMessage(locale string) string {
+	if r.MessageID == "" {
+		return r.Message
+	}
+	return catalog.Render(r.MessageID, r.MessageParams, locale)
+}
+
+// CheckComment is an analyst note attached to a check result
+type CheckComment struct {
+	ID            string    `json:"id" db:"id"`
+	CheckResultID string    `json:"checkResultId" db:"check_result_id"`
+	AuthorID      string    `json:"authorId" db:"author_id"`
+	Body          string    `json:"body" db:"body"`
+	CreatedAt     time.Time `json:"createdAt" db:"created_at"`
 }
 
 // SecurityRule represents a security check rule
@@ -26,6 +135,211 @@ type SecurityRule struct {
 	Severity        string    `json:"severity" db:"severity"`
 	Enabled         bool      `json:"enabled" db:"enabled"`
 	CreatedAt       time.Time `json:"createdAt" db:"created_at"`
+
+	// CommandOverrides maps a device type to the command used instead of Command
+	CommandOverrides map[string]string `json:"commandOverrides,omitempty" db:"command_overrides"`
+
+	// RuleVersion is bumped when a predefined rule changes so stored copies get updated
+	RuleVersion int `json:"ruleVersion" db:"rule_version"`
+
+	// VendorOverrides carry vendor-specific command and pattern variants of the rule
+	VendorOverrides []VendorOverride `json:"vendorOverrides,omitempty"`
+
+	// AllMatch splits the output into sections starting at lines matching
+	// SectionPattern and passes only if every section matches ExpectedPattern
+	AllMatch       bool   `json:"allMatch,omitempty" db:"all_match"`
+	SectionPattern string `json:"sectionPattern,omitempty" db:"section_pattern"`
+
+	// NeedsAttention is set by ValidateAllRules when the rule is broken,
+	// for example by a pattern that does not compile
+	NeedsAttention bool `json:"needsAttention" db:"needs_attention"`
+
+	// ExpectedExitCode, when set, is the exit status the command must end
+	// with, so a rule can require a command to fail. Rules without it treat
+	// a nonzero exit status as a failed command.
+	ExpectedExitCode *int `json:"expectedExitCode,omitempty" db:"expected_exit_code"`
+
+	// StreamTarget selects the output ExpectedPattern is matched against:
+	// StreamStdout, StreamStderr or StreamCombined. Empty means combined.
+	StreamTarget string `json:"streamTarget,omitempty" db:"stream_target"`
+
+	// Category groups the rule with rules checking the same area, one of
+	// the RuleCategories IDs. Empty leaves the rule uncategorized.
+	Category string `json:"category,omitempty" db:"category"`
+
+	// Remediation tells the operator how to fix a device failing the rule
+	Remediation string `json:"remediation,omitempty" db:"remediation"`
+
+	// Rationale explains why the check matters, and References cite the
+	// standards and guides requiring it, as URLs or citation strings
+	Rationale  string   `json:"rationale,omitempty" db:"rationale"`
+	References []string `json:"references,omitempty" db:"rule_references"`
+
+	// EvidenceLines is how many lines of output around the match a result's
+	// evidence snippet shows; zero uses DefaultEvidenceLines
+	EvidenceLines int `json:"evidenceLines,omitempty" db:"evidence_lines"`
+
+	// FindingCategory places what the rule finds in the vendor-neutral
+	// taxonomy of FindingCategories, and FindingKey names the finding within
+	// it, such as "telnet-enabled". Together they fingerprint the rule's
+	// findings across renames and scanners; rules without a key use their ID.
+	FindingCategory string `json:"findingCategory,omitempty" db:"finding_category"`
+	FindingKey      string `json:"findingKey,omitempty" db:"finding_key"`
+
+	// Precondition, when set, decides whether the rule applies to a device
+	// at all, such as only checking SNMPv3 settings where SNMP is configured
+	Precondition *RulePrecondition `json:"precondition,omitempty"`
+
+	// DependsOn lists the IDs of rules this one is meaningless without.
+	// Where one of them fails or errors, the rule is reported as not
+	// applicable instead of being evaluated. Rules missing from a run do
+	// not hold it back.
+	DependsOn []string `json:"dependsOn,omitempty" db:"depends_on"`
+
+	// Predefined is set on rules shipped with the app, which
+	// LoadPredefinedRules keeps up to date. SourceVersion is the shipped
+	// RuleVersion last applied to the rule, and UserModified is set once
+	// the user edits it, after which upgrades leave it alone.
+	Predefined    bool `json:"predefined" db:"predefined"`
+	SourceVersion int  `json:"sourceVersion,omitempty" db:"source_version"`
+	UserModified  bool `json:"userModified" db:"user_modified"`
+}
+
+// RulePrecondition is a command run before a rule and a pattern its output
+// must match for the rule to apply. Where it does not match, the rule is
+// reported as not applicable instead of failing.
+type RulePrecondition struct {
+	Command string `json:"command" db:"precondition_command"`
+	Pattern string `json:"pattern" db:"precondition_pattern"`
+}
+
+// Rule categories
+const (
+	CategoryAuthentication   = "authentication"
+	CategoryManagementAccess = "management_access"
+	CategoryNetworkServices  = "network_services"
+	CategorySystem           = "system"
+)
+
+// RuleCategory names a category rules can belong to
+type RuleCategory struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// RuleCategories lists the known rule categories
+var RuleCategories = []RuleCategory{
+	{ID: CategoryAuthentication, Name: "Authentication"},
+	{ID: CategoryManagementAccess, Name: "Management Access"},
+	{ID: CategoryNetworkServices, Name: "Network Services"},
+	{ID: CategorySystem, Name: "System"},
+}
+
+// IsRuleCategory reports whether id is a known rule category
+func IsRuleCategory(id string) bool {
+	for _, category := range RuleCategories {
+		if category.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Output streams a rule's pattern can be matched against
+const (
+	StreamStdout   = "stdout"
+	StreamStderr   = "stderr"
+	StreamCombined = "combined"
+)
+
+// isStreamTarget reports whether target is a known stream or empty
+func isStreamTarget(target string) bool {
+	switch target {
+	case "", StreamStdout, StreamStderr, StreamCombined:
+		return true
+	}
+	return false
+}
+
+// streamOf returns the rule's stream target, defaulting to combined
+func (r SecurityRule) streamOf() string {
+	if r.StreamTarget == "" {
+		return StreamCombined
+	}
+	return r.StreamTarget
+}
+
+// needsOwnCommand reports whether the rule looks at more than the combined
+// output, so output shared with other rules or fetched in a batch cannot be
+// evaluated for it
+func (r SecurityRule) needsOwnCommand() bool {
+	return r.ExpectedExitCode != nil || r.streamOf() != StreamCombined
+}
+
+// VendorOverride replaces a rule's command, and optionally its pattern, for one vendor
+type VendorOverride struct {
+	Vendor          string `json:"vendor" db:"vendor"`
+	Command         string `json:"command" db:"command"`
+	ExpectedPattern string `json:"expectedPattern,omitempty" db:"expected_pattern"`
+}
+
+// Command variant labels reported in CheckResult.CommandVariant
+const (
+	VariantBase       = "base"
+	VariantVendor     = "vendor:"
+	VariantDeviceType = "device-type:"
+)
+
+// VendorOverrideFor returns the override defined for a vendor, if any
+func (r SecurityRule) VendorOverrideFor(vendor string) (VendorOverride, bool) {
+	for _, override := range r.VendorOverrides {
+		if override.Vendor == vendor {
+			return override, true
+		}
+	}
+	return VendorOverride{}, false
+}
+
+// AppliesToVendor reports whether the rule targets the vendor directly,
+// generically, or through a vendor override
+func (r SecurityRule) AppliesToVendor(vendor string) bool {
+	if r.Vendor == vendor || r.Vendor == "generic" {
+		return true
+	}
+	_, ok := r.VendorOverrideFor(vendor)
+	return ok
+}
+
+// ForDevice resolves the rule variant for a device. A vendor override is the
+// most specific, followed by a device type override, then the base command.
+// The returned rule carries the effective command and pattern, and the label
+// names the variant that was chosen.
+func (r SecurityRule) ForDevice(vendor, deviceType string) (SecurityRule, string) {
+	effective := r
+
+	if override, ok := r.VendorOverrideFor(vendor); ok && override.Command != "" {
+		effective.Command = override.Command
+		if override.ExpectedPattern != "" {
+			effective.ExpectedPattern = override.ExpectedPattern
+		}
+		return effective, VariantVendor + vendor
+	}
+
+	if command, ok := r.CommandOverrides[deviceType]; ok && command != "" {
+		effective.Command = command
+		return effective, VariantDeviceType + deviceType
+	}
+
+	return effective, VariantBase
+}
+
+// CommandFor returns the command to run for the given device type,
+// falling back to the rule's default command when no override is defined
+func (r SecurityRule) CommandFor(deviceType string) string {
+	if command, ok := r.CommandOverrides[deviceType]; ok && command != "" {
+		return command
+	}
+	return r.Command
 }
 
 // CheckStatus represents the status of a security check
@@ -36,6 +350,11 @@ const (
 	StatusFail    CheckStatus = "FAIL"
 	StatusWarning CheckStatus = "WARNING"
 	StatusError   CheckStatus = "ERROR"
+
+	// StatusNotApplicable marks a rule that was not evaluated because a
+	// rule it depends on did not pass or its precondition does not match
+	// the device
+	StatusNotApplicable CheckStatus = "NOT_APPLICABLE"
 )
 
 // Severity levels for security checks
@@ -46,4 +365,51 @@ const (
 	SeverityHigh     Severity = "High"
 	SeverityMedium   Severity = "Medium"
 	SeverityLow      Severity = "Low"
+	SeverityInfo     Severity = "Info"
 )
+
+// Severities lists the severity levels from most to least severe
+var Severities = []Severity{SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow, SeverityInfo}
+
+// SeverityRank orders a severity among Severities, 0 being the most
+// severe, ignoring case. Unknown severities rank after every known one.
+func SeverityRank(severity string) int {
+	for i, s := range Severities {
+		if strings.EqualFold(string(s), severity) {
+			return i
+		}
+	}
+	return len(Severities)
+}
+
+// ParseSeverity returns the severity level named by s, ignoring case
+func ParseSeverity(s string) (Severity, error) {
+	rank := SeverityRank(strings.TrimSpace(s))
+	if rank == len(Severities) {
+		return "", fmt.Errorf("unknown severity %q", s)
+	}
+	return Severities[rank], nil
+}
+
+// SkipReason explains why a rule was not evaluated against a device
+type SkipReason string
+
+const (
+	SkipReasonDisabled       SkipReason = "disabled"
+	SkipReasonVendor         SkipReason = "not-applicable-vendor"
+	SkipReasonSuppressed     SkipReason = "suppressed"
+	SkipReasonFiltered       SkipReason = "filtered"
+	SkipReasonMaintenance    SkipReason = "maintenance-window"
+	SkipReasonCircuitOpen    SkipReason = "circuit-open"
+	SkipReasonNeedsAttention SkipReason = "needs-attention"
+)
+
+// SkippedRule records a rule that was not evaluated during a device run
+type SkippedRule struct {
+	RunID     string     `json:"runId,omitempty" db:"run_id"`
+	DeviceID  string     `json:"deviceId" db:"device_id"`
+	RuleID    string     `json:"ruleId" db:"rule_id"`
+	RuleName  string     `json:"ruleName" db:"rule_name"`
+	Reason    SkipReason `json:"reason" db:"reason"`
+	SkippedAt time.Time  `json:"skippedAt" db:"skipped_at"`
+}
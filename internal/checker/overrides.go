@@ -0,0 +1,299 @@
+package checker
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/device"
+
+	"github.com/google/uuid"
+)
+
+// Severity override scopes. A group is a device tag.
+const (
+	OverrideScopeDevice = "device"
+	OverrideScopeGroup  = "group"
+)
+
+// OverrideSuppress is the severity of an override that suppresses a rule:
+// the rule is skipped on the devices the override covers and produces no
+// result
+const OverrideSuppress = "suppress"
+
+// MaxOverrideReasonLength limits the reason given for a severity override
+const MaxOverrideReasonLength = 500
+
+// ErrOverrideNotFound is returned when a severity override does not exist
+var ErrOverrideNotFound = errors.New("severity override not found")
+
+// SeverityOverride changes the severity of a rule's results on one device or
+// on every device in a group, for example to downgrade a finding that a lab
+// intentionally keeps. A device override wins over a group override. The
+// override stops applying after ExpiresAt; nil never expires.
+type SeverityOverride struct {
+	ID        string     `json:"id"`
+	Scope     string     `json:"scope"`
+	Target    string     `json:"target"`
+	RuleID    string     `json:"ruleId"`
+	Severity  string     `json:"severity"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// ActiveAt reports whether the override applies at t
+func (o SeverityOverride) ActiveAt(t time.Time) bool {
+	return o.ExpiresAt == nil || t.Before(*o.ExpiresAt)
+}
+
+// Validate checks the override's scope, target, rule, severity and reason
+func (o SeverityOverride) Validate() error {
+	if o.Scope != OverrideScopeDevice && o.Scope != OverrideScopeGroup {
+		return fmt.Errorf("override scope must be %q or %q", OverrideScopeDevice, OverrideScopeGroup)
+	}
+	if strings.TrimSpace(o.Target) == "" {
+		return fmt.Errorf("override target cannot be empty")
+	}
+	if strings.TrimSpace(o.RuleID) == "" {
+		return fmt.Errorf("override rule ID cannot be empty")
+	}
+	if o.Severity != OverrideSuppress && !isSeverity(o.Severity) {
+		return fmt.Errorf("invalid override severity %q", o.Severity)
+	}
+	if strings.TrimSpace(o.Reason) == "" {
+		return fmt.Errorf("override reason cannot be empty")
+	}
+	if len(o.Reason) > MaxOverrideReasonLength {
+		return fmt.Errorf("override reason cannot exceed %d characters", MaxOverrideReasonLength)
+	}
+	return nil
+}
+
+// isSeverity reports whether severity is one of the known severity levels
+func isSeverity(severity string) bool {
+	switch Severity(severity) {
+	case SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow, SeverityInfo:
+		return true
+	}
+	return false
+}
+
+// OverrideManager stores severity overrides
+type OverrideManager struct {
+	db *sql.DB
+}
+
+// NewOverrideManager creates a new severity override manager
+func NewOverrideManager(db *sql.DB) *OverrideManager {
+	return &OverrideManager{db: db}
+}
+
+// CreateOverride stores a new override and fills in its ID and creation time
+func (om *OverrideManager) CreateOverride(override *SeverityOverride) error {
+	override.Target = strings.TrimSpace(override.Target)
+	override.Reason = strings.TrimSpace(override.Reason)
+	if err := override.Validate(); err != nil {
+		return err
+	}
+
+	override.ID = uuid.New().String()
+	override.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO severity_overrides (id, scope, target, rule_id, severity, reason, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	if _, err := om.db.Exec(query, override.ID, override.Scope, override.Target, override.RuleID,
+		override.Severity, override.Reason, override.ExpiresAt, override.CreatedAt); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("an override of rule %s already exists for %s %s", override.RuleID, override.Scope, override.Target)
+		}
+		return fmt.Errorf("failed to create severity override: %w", err)
+	}
+	return nil
+}
+
+// UpdateOverride replaces the severity, reason and expiry of an override
+func (om *OverrideManager) UpdateOverride(override SeverityOverride) error {
+	override.Reason = strings.TrimSpace(override.Reason)
+
+	existing, err := om.GetOverride(override.ID)
+	if err != nil {
+		return err
+	}
+	existing.Severity = override.Severity
+	existing.Reason = override.Reason
+	existing.ExpiresAt = override.ExpiresAt
+	if err := existing.Validate(); err != nil {
+		return err
+	}
+
+	query := `UPDATE severity_overrides SET severity = ?, reason = ?, expires_at = ? WHERE id = ?`
+	if _, err := om.db.Exec(query, existing.Severity, existing.Reason, existing.ExpiresAt, existing.ID); err != nil {
+		return fmt.Errorf("failed to update severity override: %w", err)
+	}
+	return nil
+}
+
+// DeleteOverride removes an override
+func (om *OverrideManager) DeleteOverride(id string) error {
+	result, err := om.db.Exec("DELETE FROM severity_overrides WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete severity override: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrOverrideNotFound
+	}
+	return nil
+}
+
+// GetOverride returns an override by ID
+func (om *OverrideManager) GetOverride(id string) (*SeverityOverride, error) {
+	overrides, err := om.query("WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(overrides) == 0 {
+		return nil, ErrOverrideNotFound
+	}
+	return &overrides[0], nil
+}
+
+// GetAllOverrides returns every override, expired ones included, newest first
+func (om *OverrideManager) GetAllOverrides() ([]SeverityOverride, error) {
+	return om.query("")
+}
+
+// OverridesForDevice returns the overrides that apply to a device at t,
+// keyed by rule ID. A device override wins over a group override; between
+// groups the newest override wins.
+func (om *OverrideManager) OverridesForDevice(dev *device.Device, t time.Time) (map[string]SeverityOverride, error) {
+	tags := dev.TagList()
+	filter := "WHERE (scope = ? AND target = ?)"
+	args := []interface{}{OverrideScopeDevice, dev.ID}
+	if len(tags) > 0 {
+		filter += " OR (scope = ? AND target IN (?" + strings.Repeat(", ?", len(tags)-1) + "))"
+		args = append(args, OverrideScopeGroup)
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+	}
+
+	overrides, err := om.query(filter, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Overrides are newest first, so the first group override of a rule is kept
+	applicable := make(map[string]SeverityOverride)
+	for _, override := range overrides {
+		if !override.ActiveAt(t) {
+			continue
+		}
+		current, exists := applicable[override.RuleID]
+		if !exists || (override.Scope == OverrideScopeDevice && current.Scope != OverrideScopeDevice) {
+			applicable[override.RuleID] = override
+		}
+	}
+	return applicable, nil
+}
+
+// query returns the overrides matching a filter, newest first
+func (om *OverrideManager) query(filter string, args ...interface{}) ([]SeverityOverride, error) {
+	rows, err := om.db.Query(`
+		SELECT id, scope, target, rule_id, severity, reason, expires_at, created_at
+		FROM severity_overrides `+filter+`
+		ORDER BY created_at DESC, id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query severity overrides: %w", err)
+	}
+	defer rows.Close()
+
+	var overrides []SeverityOverride
+	for rows.Next() {
+		var override SeverityOverride
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&override.ID, &override.Scope, &override.Target, &override.RuleID,
+			&override.Severity, &override.Reason, &expiresAt, &override.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan severity override: %w", err)
+		}
+		if expiresAt.Valid {
+			expires := expiresAt.Time
+			override.ExpiresAt = &expires
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, rows.Err()
+}
+
+// SetOverrideManager sets where the engine reads severity overrides from.
+// Without one, results keep their rule's severity.
+func (e *Engine) SetOverrideManager(overrides *OverrideManager) {
+	e.overrideManager = overrides
+}
+
+// severityOverridesFor returns the overrides that apply to a device now.
+// Failing to read them only leaves results at their rule's severity.
+func (e *Engine) severityOverridesFor(dev *device.Device) map[string]SeverityOverride {
+	if e.overrideManager == nil {
+		return nil
+	}
+	overrides, err := e.overrideManager.OverridesForDevice(dev, time.Now())
+	if err != nil {
+		log.Printf("Failed to load severity overrides for device %s: %v", dev.ID, err)
+		return nil
+	}
+	return overrides
+}
+
+// applySeverityOverride sets the effective severity of a rule's result,
+// keeping the rule's severity in OriginalSeverity
+func applySeverityOverride(result *CheckResult, ruleID string, overrides map[string]SeverityOverride) {
+	override, ok := overrides[ruleID]
+	if !ok || override.Severity == result.Severity || override.Severity == OverrideSuppress {
+		return
+	}
+	result.OriginalSeverity = result.Severity
+	result.Severity = override.Severity
+	result.OverrideReason = override.Reason
+}
+
+// suppressRules removes the rules an override suppresses from a device's
+// run, adding them to skipped as suppressed
+func suppressRules(dev *device.Device, rules []SecurityRule, skipped []SkippedRule,
+	overrides map[string]SeverityOverride) ([]SecurityRule, []SkippedRule) {
+	kept := make([]SecurityRule, 0, len(rules))
+	for _, rule := range rules {
+		if override, ok := overrides[rule.ID]; ok && override.Severity == OverrideSuppress {
+			skipped = append(skipped, newSkippedRule(dev, rule, SkipReasonSuppressed))
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	return kept, skipped
+}
+
+// CalculateComplianceScore returns the percentage of scored results that
+// passed, from 0 to 100. Info results, such as findings downgraded by an
+// override, and results not applicable for a failed prerequisite are not
+// scored. With nothing scored the score is 100.
+func CalculateComplianceScore(results []CheckResult) float64 {
+	scored, passed := 0, 0
+	for _, result := range results {
+		if strings.EqualFold(result.Severity, string(SeverityInfo)) || result.Status == string(StatusNotApplicable) {
+			continue
+		}
+		scored++
+		if result.Status == string(StatusPass) {
+			passed++
+		}
+	}
+	if scored == 0 {
+		return 100
+	}
+	return float64(passed) * 100 / float64(scored)
+}
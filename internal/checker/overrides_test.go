@@ -0,0 +1,165 @@
+package checker
+
+import (
+	"testing"
+	"time"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverrideManager_Precedence(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	om := NewOverrideManager(rm.db)
+
+	lab := &device.Device{ID: "lab1", Tags: "lab, training"}
+	group := &SeverityOverride{Scope: OverrideScopeGroup, Target: "lab", RuleID: "telnet",
+		Severity: string(SeverityInfo), Reason: "Telnet is used in class exercises"}
+	require.NoError(t, om.CreateOverride(group))
+	assert.NotEmpty(t, group.ID)
+
+	overrides, err := om.OverridesForDevice(lab, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, string(SeverityInfo), overrides["telnet"].Severity)
+
+	// A device override wins over the group override
+	require.NoError(t, om.CreateOverride(&SeverityOverride{Scope: OverrideScopeDevice, Target: "lab1", RuleID: "telnet",
+		Severity: string(SeverityLow), Reason: "Instructor console"}))
+	overrides, err = om.OverridesForDevice(lab, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, string(SeverityLow), overrides["telnet"].Severity)
+	assert.Equal(t, OverrideScopeDevice, overrides["telnet"].Scope)
+
+	// Devices outside the group are not affected
+	overrides, err = om.OverridesForDevice(&device.Device{ID: "core1", Tags: "production"}, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, overrides)
+
+	// Only one override per rule and target
+	assert.Error(t, om.CreateOverride(&SeverityOverride{Scope: OverrideScopeGroup, Target: "lab", RuleID: "telnet",
+		Severity: string(SeverityLow), Reason: "duplicate"}))
+}
+
+func TestOverrideManager_Expiry(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	om := NewOverrideManager(rm.db)
+
+	expires := time.Now().Add(time.Hour)
+	override := &SeverityOverride{Scope: OverrideScopeDevice, Target: "lab1", RuleID: "telnet",
+		Severity: string(SeverityInfo), Reason: "Until the lab is rebuilt", ExpiresAt: &expires}
+	require.NoError(t, om.CreateOverride(override))
+
+	lab := &device.Device{ID: "lab1"}
+	overrides, err := om.OverridesForDevice(lab, time.Now())
+	require.NoError(t, err)
+	assert.Contains(t, overrides, "telnet")
+
+	overrides, err = om.OverridesForDevice(lab, expires.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, overrides, "expired overrides stop applying")
+
+	// Expired overrides are still listed
+	all, err := om.GetAllOverrides()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.NotNil(t, all[0].ExpiresAt)
+	assert.WithinDuration(t, expires, *all[0].ExpiresAt, time.Second)
+
+	override.Severity = string(SeverityLow)
+	override.ExpiresAt = nil
+	require.NoError(t, om.UpdateOverride(*override))
+	overrides, err = om.OverridesForDevice(lab, expires.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, string(SeverityLow), overrides["telnet"].Severity)
+
+	require.NoError(t, om.DeleteOverride(override.ID))
+	assert.ErrorIs(t, om.DeleteOverride(override.ID), ErrOverrideNotFound)
+	_, err = om.GetOverride(override.ID)
+	assert.ErrorIs(t, err, ErrOverrideNotFound)
+}
+
+func TestSeverityOverride_Validate(t *testing.T) {
+	valid := SeverityOverride{Scope: OverrideScopeGroup, Target: "lab", RuleID: "telnet",
+		Severity: string(SeverityInfo), Reason: "lab"}
+	assert.NoError(t, valid.Validate())
+
+	suppress := valid
+	suppress.Severity = OverrideSuppress
+	assert.NoError(t, suppress.Validate())
+
+	for name, change := range map[string]func(*SeverityOverride){
+		"scope":    func(o *SeverityOverride) { o.Scope = "site" },
+		"target":   func(o *SeverityOverride) { o.Target = " " },
+		"rule":     func(o *SeverityOverride) { o.RuleID = "" },
+		"severity": func(o *SeverityOverride) { o.Severity = "Urgent" },
+		"reason":   func(o *SeverityOverride) { o.Reason = "" },
+	} {
+		override := valid
+		change(&override)
+		assert.Error(t, override.Validate(), name)
+	}
+}
+
+func TestEngine_SeverityOverride(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &stubSSHClient{outputs: map[string]string{
+		"show running-config | include telnet": "transport input telnet",
+		"show ip ssh":                          "SSH Enabled - version 2.0",
+	}}
+	engine := NewEngineWithSSHClient(rm, client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "telnet", Name: "Telnet disabled", Vendor: "cisco", Command: "show running-config | include telnet",
+			ExpectedPattern: "transport input ssh", Severity: string(SeverityHigh), Enabled: true},
+		{ID: "ssh", Name: "SSH version", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+	}))
+
+	lab := &device.Device{ID: "lab1", Name: "Lab Router", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22, Tags: "lab"}
+
+	before, err := engine.RunChecks(lab)
+	require.NoError(t, err)
+	assert.Equal(t, 50.0, CalculateComplianceScore(before))
+
+	om := NewOverrideManager(rm.db)
+	engine.SetOverrideManager(om)
+	require.NoError(t, om.CreateOverride(&SeverityOverride{Scope: OverrideScopeGroup, Target: "lab", RuleID: "telnet",
+		Severity: string(SeverityInfo), Reason: "Telnet is used in class exercises"}))
+
+	after, err := engine.RunChecks(lab)
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, CalculateComplianceScore(after), "the downgraded finding no longer counts")
+
+	byName := make(map[string]CheckResult)
+	for _, result := range after {
+		byName[result.CheckName] = result
+	}
+	telnet := byName["Telnet disabled"]
+	assert.Equal(t, string(StatusFail), telnet.Status, "the finding is kept")
+	assert.Equal(t, string(SeverityInfo), telnet.Severity)
+	assert.Equal(t, string(SeverityHigh), telnet.OriginalSeverity)
+	assert.Equal(t, "Telnet is used in class exercises", telnet.OverrideReason)
+	assert.Empty(t, byName["SSH version"].OriginalSeverity)
+
+	bulk, err := engine.RunBulkChecks([]device.Device{*lab})
+	require.NoError(t, err)
+	for _, result := range bulk[lab.ID] {
+		if result.CheckName == "Telnet disabled" {
+			assert.Equal(t, string(SeverityInfo), result.Severity)
+		}
+	}
+
+	// The original severity is kept in storage for audit
+	store := NewResultStore(rm.db)
+	require.NoError(t, store.SaveResults(after))
+	stored, err := store.GetDeviceResults(lab.ID, 10)
+	require.NoError(t, err)
+	for _, result := range stored {
+		if result.CheckName == "Telnet disabled" {
+			assert.Equal(t, string(SeverityHigh), result.OriginalSeverity)
+			assert.Equal(t, "Telnet is used in class exercises", result.OverrideReason)
+		}
+	}
+}
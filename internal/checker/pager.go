@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+)
+
+// pagingClient is implemented by SSH clients that can answer a vendor CLI's
+// pager while a command runs
+type pagingClient interface {
+	ExecuteCommandWithPager(ctx context.Context, conn *ssh.SSHConnection, command string, pager ssh.VendorPagerConfig) (*ssh.CommandResult, error)
+}
+
+// SetPagerConfigs sets the pager of each vendor's CLI. Commands sent to a
+// vendor with a pager have its prompts answered and stripped from their
+// output. nil restores ssh.DefaultPagerConfigs and an empty map turns pager
+// handling off. It must not be called while checks are running.
+func (e *Engine) SetPagerConfigs(configs map[string]ssh.VendorPagerConfig) error {
+	if configs == nil {
+		e.pagerConfigs = nil
+		return nil
+	}
+
+	copied := make(map[string]ssh.VendorPagerConfig, len(configs))
+	for vendor, config := range configs {
+		if config.PromptPattern == "" {
+			return fmt.Errorf("pager of vendor %s has no prompt pattern", vendor)
+		}
+		if _, err := regexp.Compile(config.PromptPattern); err != nil {
+			return fmt.Errorf("pager of vendor %s has an invalid prompt pattern: %w", vendor, err)
+		}
+		copied[vendor] = config
+	}
+	e.pagerConfigs = copied
+	return nil
+}
+
+// PagerConfigs returns the pager of each vendor's CLI
+func (e *Engine) PagerConfigs() map[string]ssh.VendorPagerConfig {
+	if e.pagerConfigs == nil {
+		return ssh.DefaultPagerConfigs()
+	}
+	copied := make(map[string]ssh.VendorPagerConfig, len(e.pagerConfigs))
+	for vendor, config := range e.pagerConfigs {
+		copied[vendor] = config
+	}
+	return copied
+}
+
+// executeCommand runs a command on a device, answering its vendor's pager
+// when one is configured and the client can, and decodes the output from
+// the device's output charset. Other clients return the output as the
+// device sent it.
+func (e *Engine) executeCommand(ctx context.Context, client ssh.SSHClientInterface, conn *ssh.SSHConnection,
+	device *device.Device, command string) (*ssh.CommandResult, error) {
+	result, err := e.runCommand(ctx, client, conn, device, command)
+	if result != nil {
+		decodeCommandOutput(result, device.OutputCharset)
+	}
+	return result, err
+}
+
+// runCommand runs a command through the vendor's pager when there is one
+func (e *Engine) runCommand(ctx context.Context, client ssh.SSHClientInterface, conn *ssh.SSHConnection,
+	device *device.Device, command string) (*ssh.CommandResult, error) {
+	configs := e.pagerConfigs
+	if configs == nil {
+		configs = ssh.DefaultPagerConfigs()
+	}
+	if pager, ok := configs[device.Vendor]; ok {
+		if paging, ok := client.(pagingClient); ok {
+			return paging.ExecuteCommandWithPager(ctx, conn, command, pager)
+		}
+	}
+	return client.ExecuteCommand(ctx, conn, command)
+}
+
+// decodeCommandOutput transcodes every output stream of a command to UTF-8
+func decodeCommandOutput(result *ssh.CommandResult, charset string) {
+	result.Output = device.DecodeOutput(result.Output, charset)
+	result.Stdout = device.DecodeOutput(result.Stdout, charset)
+	result.Stderr = device.DecodeOutput(result.Stderr, charset)
+}
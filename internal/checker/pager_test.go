@@ -0,0 +1,90 @@
+package checker
+
+import (
+	"context"
+	"testing"
+	"unicode/utf8"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagingSSHClient records the pager each command ran with
+type pagingSSHClient struct {
+	stubSSHClient
+	pagers []ssh.VendorPagerConfig
+}
+
+func (p *pagingSSHClient) ExecuteCommandWithPager(ctx context.Context, conn *ssh.SSHConnection, command string,
+	pager ssh.VendorPagerConfig) (*ssh.CommandResult, error) {
+	p.mu.Lock()
+	p.pagers = append(p.pagers, pager)
+	p.mu.Unlock()
+	return p.stubSSHClient.ExecuteCommand(ctx, conn, command)
+}
+
+func TestEngine_PagerConfigs(t *testing.T) {
+	client := &pagingSSHClient{stubSSHClient: stubSSHClient{outputs: map[string]string{"show version": "Cisco IOS"}}}
+	engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "rule1", Name: "Version Check", Vendor: "generic", Command: "show version", ExpectedPattern: "IOS",
+			Severity: string(SeverityHigh), Enabled: true},
+	}))
+	assert.Equal(t, ssh.DefaultPagerConfigs(), engine.PagerConfigs())
+
+	run := func(vendor string) {
+		t.Helper()
+		dev := &device.Device{ID: "device-" + vendor, Name: "Device", IPAddress: "192.168.1.1", Vendor: vendor,
+			Username: "admin", SSHPort: 22}
+		results, err := engine.RunChecks(dev)
+		require.NoError(t, err)
+		require.NotEmpty(t, results)
+		assert.Equal(t, string(StatusPass), results[0].Status)
+	}
+
+	run("cisco")
+	require.Len(t, client.pagers, 1, "cisco output is paged by default")
+	assert.Equal(t, "--More--", client.pagers[0].PromptPattern)
+
+	run("generic")
+	assert.Len(t, client.pagers, 1, "vendors without a pager run the command directly")
+
+	require.NoError(t, engine.SetPagerConfigs(map[string]ssh.VendorPagerConfig{}))
+	run("cisco")
+	assert.Len(t, client.pagers, 1, "an empty map turns pager handling off")
+
+	assert.Error(t, engine.SetPagerConfigs(map[string]ssh.VendorPagerConfig{"cisco": {PromptPattern: "("}}))
+	assert.Error(t, engine.SetPagerConfigs(map[string]ssh.VendorPagerConfig{"cisco": {}}))
+
+	require.NoError(t, engine.SetPagerConfigs(nil))
+	assert.Equal(t, ssh.DefaultPagerConfigs(), engine.PagerConfigs())
+}
+
+func TestEngine_DecodesOutputCharset(t *testing.T) {
+	// "用户 admin" in GBK
+	gbk := "\xd3\xc3\xbb\xa7 admin"
+	client := &stubSSHClient{outputs: map[string]string{"display users": gbk}}
+	engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "rule1", Name: "Users", Vendor: "generic", Command: "display users", ExpectedPattern: "用户 admin",
+			Severity: string(SeverityMedium), Enabled: true},
+	}))
+
+	dev := &device.Device{ID: "d1", Name: "Huawei", IPAddress: "192.168.1.1", Vendor: "generic",
+		Username: "admin", SSHPort: 22}
+	results, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, string(StatusFail), results[0].Status, "undecoded output does not match")
+	assert.True(t, utf8.ValidString(results[0].Evidence), "invalid UTF-8 is replaced")
+
+	dev.OutputCharset = "gbk"
+	results, err = engine.RunChecks(dev)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, string(StatusPass), results[0].Status)
+	assert.Equal(t, "用户 admin", results[0].Evidence)
+}
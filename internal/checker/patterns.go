@@ -0,0 +1,293 @@
+package checker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPatternTimeout bounds how long one rule's patterns may take to
+// evaluate against a command's output
+const DefaultPatternTimeout = 5 * time.Second
+
+// slowPatternThreshold is the compile and match time against the worst-case
+// input above which ValidateRulePatterns warns; tests lower it
+var slowPatternThreshold = 250 * time.Millisecond
+
+// worstCaseInputSize is the size of the synthetic output patterns are timed
+// against, about as large as the biggest running configs seen
+const worstCaseInputSize = 1 << 20
+
+// PatternError reports a construct from Perl-compatible regular expressions
+// that Go's RE2 syntax does not support
+type PatternError struct {
+	Pattern string
+	// Construct names the unsupported syntax and Text is where it appears
+	Construct string
+	Text      string
+	// Suggestion is a way to write the pattern in RE2, when one exists
+	Suggestion string
+}
+
+func (e *PatternError) Error() string {
+	msg := fmt.Sprintf("pattern uses %s %q, which Go regular expressions do not support", e.Construct, e.Text)
+	if e.Suggestion != "" {
+		msg += "; " + e.Suggestion
+	}
+	return msg
+}
+
+// PatternWarning flags a rule pattern that is slow to evaluate. Variant
+// names the pattern the way RuleIssue details do.
+type PatternWarning struct {
+	Pattern    string `json:"pattern"`
+	Variant    string `json:"variant"`
+	DurationMs int64  `json:"durationMs"`
+	Reason     string `json:"reason"`
+}
+
+// CompilePattern compiles a rule pattern, rejecting Perl-only constructs
+// with a PatternError that names the construct instead of a bare syntax
+// error
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	if err := findUnsupportedConstruct(pattern); err != nil {
+		return nil, err
+	}
+	return regexp.Compile(pattern)
+}
+
+// ValidateRulePatterns checks a rule's patterns at authoring time. Patterns
+// that do not compile are rejected; patterns that take longer than the slow
+// pattern threshold to compile and match a large synthetic config are
+// returned as warnings.
+func ValidateRulePatterns(rule SecurityRule) ([]PatternWarning, error) {
+	patterns := []struct{ variant, pattern string }{{"expected pattern", rule.ExpectedPattern}}
+	if rule.AllMatch && rule.SectionPattern != "" {
+		patterns = append(patterns, struct{ variant, pattern string }{"section pattern", rule.SectionPattern})
+	}
+	if rule.Precondition != nil {
+		patterns = append(patterns, struct{ variant, pattern string }{"precondition pattern", rule.Precondition.Pattern})
+	}
+	for _, override := range rule.VendorOverrides {
+		if override.ExpectedPattern != "" {
+			patterns = append(patterns, struct{ variant, pattern string }{
+				fmt.Sprintf("vendor override %q", override.Vendor), override.ExpectedPattern})
+		}
+	}
+
+	var warnings []PatternWarning
+	for _, p := range patterns {
+		elapsed, err := timePattern(p.pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.variant, err)
+		}
+		if elapsed > slowPatternThreshold {
+			warnings = append(warnings, PatternWarning{
+				Pattern:    p.pattern,
+				Variant:    p.variant,
+				DurationMs: elapsed.Milliseconds(),
+				Reason: fmt.Sprintf("took %s to match a %d KiB config, over the %s threshold",
+					elapsed.Round(time.Millisecond), worstCaseInputSize>>10, slowPatternThreshold),
+			})
+		}
+	}
+	return warnings, nil
+}
+
+// timePattern measures compiling a pattern and matching it against the
+// worst-case input
+func timePattern(pattern string) (time.Duration, error) {
+	input := worstCaseInput()
+	start := time.Now()
+	regex, err := CompilePattern(pattern)
+	if err != nil {
+		return 0, err
+	}
+	regex.MatchString(input)
+	return time.Since(start), nil
+}
+
+var (
+	worstCaseOnce sync.Once
+	worstCase     string
+)
+
+// worstCaseInput returns a large config-like output made of short repeated
+// runs, which keeps matching patterns scanning the whole input
+func worstCaseInput() string {
+	worstCaseOnce.Do(func() {
+		line := "interface GigabitEthernet0/1\n description " + strings.Repeat("ab", 32) + "\n ip address 10.0.0.1 255.255.255.0\n"
+		worstCase = strings.Repeat(line, worstCaseInputSize/len(line))
+	})
+	return worstCase
+}
+
+// lookaroundConstructs are the group openings RE2 has no equivalent for
+var lookaroundConstructs = []struct {
+	prefix, construct, suggestion string
+}{
+	{"(?<=", "a lookbehind", "match the preceding text as part of the pattern instead"},
+	{"(?<!", "a negative lookbehind", "check for the unwanted text in a separate rule instead"},
+	{"(?=", "a lookahead", "match the following text as part of the pattern, or split the check into one rule per requirement"},
+	{"(?!", "a negative lookahead", "check for the unwanted text in a separate rule instead"},
+	{"(?>", "an atomic group", ""},
+}
+
+// findUnsupportedConstruct scans a pattern for lookarounds, atomic groups,
+// backreferences and possessive quantifiers, skipping escapes, \Q...\E
+// quotes and character classes. Atomic groups and possessive quantifiers
+// can always be dropped since RE2 never backtracks, so their suggestion is
+// the rewritten pattern.
+func findUnsupportedConstruct(pattern string) *PatternError {
+	found := scanPattern(pattern)
+	if found == nil {
+		return nil
+	}
+
+	err := &PatternError{Pattern: pattern, Construct: found.construct, Text: found.text, Suggestion: found.suggestion}
+	if found.rewrite == nil {
+		return err
+	}
+
+	// Apply every rewritable fix, and suggest the result if nothing else
+	// stands in the way
+	rewritten := pattern
+	for found != nil && found.rewrite != nil {
+		rewritten = found.rewrite(rewritten)
+		found = scanPattern(rewritten)
+	}
+	if found == nil {
+		if _, compileErr := regexp.Compile(rewritten); compileErr == nil {
+			err.Suggestion = fmt.Sprintf("write it as %q, since RE2 never backtracks", rewritten)
+		}
+	}
+	return err
+}
+
+// unsupportedConstruct is one construct found by scanPattern; rewrite, when
+// set, removes it from the pattern
+type unsupportedConstruct struct {
+	construct  string
+	text       string
+	suggestion string
+	rewrite    func(string) string
+}
+
+// scanPattern returns the first unsupported construct in a pattern
+func scanPattern(pattern string) *unsupportedConstruct {
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			if i+1 >= len(pattern) {
+				return nil
+			}
+			switch next := pattern[i+1]; {
+			case next == 'Q':
+				end := strings.Index(pattern[i+2:], `\E`)
+				if end < 0 {
+					return nil
+				}
+				i += 2 + end + 1
+				continue
+			case next >= '1' && next <= '9':
+				return &unsupportedConstruct{construct: "a backreference", text: pattern[i : i+2],
+					suggestion: "repeat the referenced group's pattern in its place"}
+			case next == 'k' && i+2 < len(pattern) && (pattern[i+2] == '<' || pattern[i+2] == '{' || pattern[i+2] == '\''):
+				return &unsupportedConstruct{construct: "a backreference", text: pattern[i : i+3],
+					suggestion: "repeat the referenced group's pattern in its place"}
+			}
+			i++
+		case '[':
+			i = classEnd(pattern, i)
+		case '(':
+			for _, lookaround := range lookaroundConstructs {
+				if strings.HasPrefix(pattern[i:], lookaround.prefix) {
+					found := &unsupportedConstruct{construct: lookaround.construct, text: lookaround.prefix,
+						suggestion: lookaround.suggestion}
+					if lookaround.prefix == "(?>" {
+						at := i
+						found.rewrite = func(p string) string { return p[:at] + "(?:" + p[at+3:] }
+					}
+					return found
+				}
+			}
+			// The ? of a group flag is not a quantifier
+			if i+1 < len(pattern) && pattern[i+1] == '?' {
+				i++
+			}
+		case '*', '+', '?', '{':
+			end := i
+			if c == '{' {
+				if end = repetitionEnd(pattern, i); end < 0 {
+					continue
+				}
+			}
+			if end+1 < len(pattern) && pattern[end+1] == '+' {
+				at := end + 1
+				return &unsupportedConstruct{construct: "a possessive quantifier", text: pattern[i : at+1],
+					rewrite: func(p string) string { return p[:at] + p[at+1:] }}
+			}
+			// Skip a lazy marker so a+?+ is read as one quantifier
+			if end+1 < len(pattern) && pattern[end+1] == '?' {
+				end++
+			}
+			i = end
+		}
+	}
+	return nil
+}
+
+// classEnd returns the index of the ] closing the character class opened
+// at start, or the end of the pattern when it is unterminated
+func classEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	// A ] first in the class is a literal
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\':
+			i++
+		case strings.HasPrefix(pattern[i:], "[:"):
+			if end := strings.Index(pattern[i+2:], ":]"); end >= 0 {
+				i += 2 + end + 1
+			}
+		case pattern[i] == ']':
+			return i
+		}
+	}
+	return len(pattern)
+}
+
+// repetitionEnd returns the index of the } closing a {n}, {n,} or {n,m}
+// repetition opened at start, or -1 when the brace is a literal
+func repetitionEnd(pattern string, start int) int {
+	end := strings.IndexByte(pattern[start:], '}')
+	if end < 0 {
+		return -1
+	}
+	body := pattern[start+1 : start+end]
+	low, high, hasComma := strings.Cut(body, ",")
+	if !isDigits(low) || (hasComma && high != "" && !isDigits(high)) {
+		return -1
+	}
+	return start + end
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package checker
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/catalog"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompilePattern_UnsupportedConstructs(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		construct  string
+		text       string
+		suggestion string
+	}{
+		{`password(?=\s+7)`, "a lookahead", "(?=", "rule per requirement"},
+		{`^username (?!admin)`, "a negative lookahead", "(?!", "separate rule"},
+		{`(?<=snmp-server )community`, "a lookbehind", "(?<=", "preceding text"},
+		{`(?<!no )shutdown`, "a negative lookbehind", "(?<!", "separate rule"},
+		{`(\w+) \1`, "a backreference", `\1`, "repeat the referenced group"},
+		{`(?P<name>\w+) \k<name>`, "a backreference", `\k<`, "repeat the referenced group"},
+		{`version \d++`, "a possessive quantifier", "++", `"version \\d+"`},
+		{`ip ssh .*+$`, "a possessive quantifier", "*+", `"ip ssh .*$"`},
+		{`vlan \d{2,4}+`, "a possessive quantifier", "{2,4}+", `"vlan \\d{2,4}"`},
+		{`(?>enable|disable)d?+`, "an atomic group", "(?>", `"(?:enable|disable)d?"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			_, err := CompilePattern(tt.pattern)
+			var patternErr *PatternError
+			require.True(t, errors.As(err, &patternErr), "got %v", err)
+			assert.Equal(t, tt.construct, patternErr.Construct)
+			assert.Equal(t, tt.text, patternErr.Text)
+			assert.Contains(t, patternErr.Error(), tt.suggestion)
+		})
+	}
+}
+
+func TestCompilePattern_SupportedSyntax(t *testing.T) {
+	for _, pattern := range []string{
+		`(?i)transport input (ssh|none)`,
+		`(?m)^\s*login local\s*$`,
+		`(?P<line>vty \d+ \d+)`,
+		`[(?=]+ \\1 []*+]`,
+		`\Q(?!literal)\E`,
+		`a+? b*? c{1,2}?`,
+		`{not a repetition}+`,
+		`[[:alpha:]]+`,
+	} {
+		_, err := CompilePattern(pattern)
+		assert.NoError(t, err, pattern)
+	}
+
+	for _, rule := range GetPredefinedRules() {
+		_, err := CompilePattern(rule.ExpectedPattern)
+		assert.NoError(t, err, rule.Name)
+	}
+
+	_, err := CompilePattern(`unclosed (group`)
+	var patternErr *PatternError
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &patternErr), "plain syntax errors come from regexp")
+}
+
+func TestValidateRulePatterns(t *testing.T) {
+	rule := SecurityRule{Name: "SSH", Command: "show ip ssh", ExpectedPattern: "version 2",
+		VendorOverrides: []VendorOverride{{Vendor: "juniper", Command: "show system services", ExpectedPattern: `ssh\s*\{`}}}
+	warnings, err := ValidateRulePatterns(rule)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	broken := rule
+	broken.VendorOverrides = []VendorOverride{{Vendor: "juniper", Command: "show system services", ExpectedPattern: `ssh(?=\s)`}}
+	_, err = ValidateRulePatterns(broken)
+	assert.ErrorContains(t, err, `vendor override "juniper"`)
+	assert.ErrorContains(t, err, "lookahead")
+
+	t.Run("slow pattern", func(t *testing.T) {
+		threshold := slowPatternThreshold
+		slowPatternThreshold = time.Nanosecond
+		defer func() { slowPatternThreshold = threshold }()
+
+		warnings, err := ValidateRulePatterns(rule)
+		require.NoError(t, err)
+		require.Len(t, warnings, 2)
+		assert.Equal(t, "expected pattern", warnings[0].Variant)
+		assert.Equal(t, `vendor override "juniper"`, warnings[1].Variant)
+		assert.Contains(t, warnings[0].Reason, "1024 KiB")
+	})
+}
+
+func TestEngine_PatternTimeout(t *testing.T) {
+	engine := NewEngineWithSSHClient(&fakeRuleManager{}, &stubSSHClient{})
+	release := make(chan struct{})
+	defer close(release)
+	engine.matchPattern = func(regex *regexp.Regexp, text string) bool {
+		<-release
+		return true
+	}
+	engine.SetPatternTimeout(20 * time.Millisecond)
+
+	rule := SecurityRule{ExpectedPattern: "version 2"}
+	status, message := engine.evaluateRule("SSH Enabled - version 2.0", rule)
+	assert.Equal(t, StatusError, status)
+	assert.Equal(t, catalog.MsgPatternTimeout, message.ID)
+	assert.Equal(t, "version 2", message.Params["pattern"])
+	assert.Equal(t, "20ms", message.Params["timeout"])
+
+	allMatch := SecurityRule{ExpectedPattern: "shutdown", AllMatch: true, SectionPattern: "^interface"}
+	status, _ = engine.evaluateRule("interface Gi0/1\n shutdown", allMatch)
+	assert.Equal(t, StatusError, status, "section matches are bounded too")
+
+	// Without a timeout the evaluation waits for the match
+	unbounded := NewEngineWithSSHClient(&fakeRuleManager{}, &stubSSHClient{})
+	unbounded.SetPatternTimeout(0)
+	status, _ = unbounded.evaluateRule("SSH Enabled - version 2.0", rule)
+	assert.Equal(t, StatusPass, status)
+}
+
+func TestEngine_EvaluateRule_UnsupportedPattern(t *testing.T) {
+	engine := NewEngineWithSSHClient(&fakeRuleManager{}, &stubSSHClient{})
+	status, message := engine.evaluateRuleResult("username admin", SecurityRule{ExpectedPattern: `^username (?!admin)`})
+	assert.Equal(t, StatusError, status)
+	assert.Contains(t, message, "negative lookahead")
+}
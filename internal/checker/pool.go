@@ -0,0 +1,123 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// workerScaleInterval is how often the adaptive pool checks the job queue
+	workerScaleInterval = 100 * time.Millisecond
+	// workerIdleTimeout is how long a worker above the minimum waits for a
+	// job before it exits
+	workerIdleTimeout = 2 * time.Second
+)
+
+// SetAdaptiveWorkerCount makes bulk runs start with min workers and add more,
+// up to max, while devices are waiting in the job queue. Workers above min
+// exit once they have been idle for a while. SetWorkerCount returns the
+// engine to a fixed-size pool. It must not be called while checks are running.
+func (e *Engine) SetAdaptiveWorkerCount(min, max int) error {
+	if min <= 0 {
+		return fmt.Errorf("minimum worker count must be positive")
+	}
+	if max < min {
+		return fmt.Errorf("maximum worker count %d is below minimum %d", max, min)
+	}
+
+	e.workerCount = min
+	e.maxWorkers = max
+	return nil
+}
+
+// GetWorkerCountRange returns the minimum and maximum worker count and
+// whether the pool is adaptive. A fixed pool reports its size as both bounds.
+func (e *Engine) GetWorkerCountRange() (min, max int, adaptive bool) {
+	if e.maxWorkers > 0 {
+		return e.workerCount, e.maxWorkers, true
+	}
+	return e.workerCount, e.workerCount, false
+}
+
+// GetActiveWorkerCount returns how many adaptive pool workers are running
+func (e *Engine) GetActiveWorkerCount() int {
+	return int(e.activeWorkers.Load())
+}
+
+// runAdaptivePool runs jobs on a pool that grows while jobs are queued and
+// shrinks back to min when idle. It returns once every job has been handled
+// or handle asks the workers to stop.
+func (e *Engine) runAdaptivePool(ctx context.Context, jobs <-chan CheckJob, min, max int, handle func(CheckJob) bool) {
+	var coreWG, extraWG sync.WaitGroup
+
+	for i := 0; i < min; i++ {
+		coreWG.Add(1)
+		e.activeWorkers.Add(1)
+		go func() {
+			defer coreWG.Done()
+			defer e.activeWorkers.Add(-1)
+
+			for job := range jobs {
+				if !handle(job) {
+					return
+				}
+			}
+		}()
+	}
+
+	// The core workers only finish once the queue is drained or the run is
+	// cancelled, so no extra worker is needed after that
+	coreDone := make(chan struct{})
+	go func() {
+		coreWG.Wait()
+		close(coreDone)
+	}()
+
+	ticker := time.NewTicker(workerScaleInterval)
+	defer ticker.Stop()
+
+	for running := true; running; {
+		select {
+		case <-coreDone:
+			running = false
+		case <-ctx.Done():
+			running = false
+		case <-ticker.C:
+			backlog := len(jobs)
+			for backlog > 0 && int(e.activeWorkers.Load()) < max {
+				extraWG.Add(1)
+				e.activeWorkers.Add(1)
+				go func() {
+					defer extraWG.Done()
+					defer e.activeWorkers.Add(-1)
+					extraWorker(jobs, handle)
+				}()
+				backlog--
+			}
+		}
+	}
+
+	coreWG.Wait()
+	extraWG.Wait()
+}
+
+// extraWorker handles jobs until the queue is closed or no job arrives
+// within workerIdleTimeout
+func extraWorker(jobs <-chan CheckJob, handle func(CheckJob) bool) {
+	idle := time.NewTimer(workerIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case job, ok := <-jobs:
+			if !ok || !handle(job) {
+				return
+			}
+			idle.Reset(workerIdleTimeout)
+		case <-idle.C:
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slowHostSSHClient takes longer to connect to one host than to the others
+type slowHostSSHClient struct {
+	countingSSHClient
+	slowHost  string
+	slowDelay time.Duration
+	afterSlow func()
+}
+
+func (c *slowHostSSHClient) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	if connInfo.Host == c.slowHost {
+		time.Sleep(c.slowDelay)
+		if c.afterSlow != nil {
+			c.afterSlow()
+		}
+	}
+	return c.countingSSHClient.Connect(ctx, connInfo)
+}
+
+func poolTestDevices(n int) []device.Device {
+	var devices []device.Device
+	for i := 0; i < n; i++ {
+		devices = append(devices, device.Device{ID: fmt.Sprintf("d%d", i), Name: fmt.Sprintf("Device %d", i),
+			IPAddress: fmt.Sprintf("10.0.1.%d", i+1), DeviceType: string(device.TypeRouter),
+			Vendor: "cisco", Username: "admin", SSHPort: 22})
+	}
+	return devices
+}
+
+func TestEngine_SetAdaptiveWorkerCount(t *testing.T) {
+	engine := NewEngine(setupTestRuleManager(t))
+
+	min, max, adaptive := engine.GetWorkerCountRange()
+	assert.False(t, adaptive, "fixed pool is the default")
+	assert.Equal(t, 5, min)
+	assert.Equal(t, 5, max)
+
+	assert.Error(t, engine.SetAdaptiveWorkerCount(0, 4))
+	assert.Error(t, engine.SetAdaptiveWorkerCount(4, 2))
+
+	assert.NoError(t, engine.SetAdaptiveWorkerCount(2, 8))
+	min, max, adaptive = engine.GetWorkerCountRange()
+	assert.True(t, adaptive)
+	assert.Equal(t, 2, min)
+	assert.Equal(t, 8, max)
+
+	engine.SetWorkerCount(3)
+	min, max, adaptive = engine.GetWorkerCountRange()
+	assert.False(t, adaptive)
+	assert.Equal(t, 3, min)
+	assert.Equal(t, 3, max)
+}
+
+func TestEngine_AdaptiveWorkerPool(t *testing.T) {
+	scaleInterval, idleTimeout := workerScaleInterval, workerIdleTimeout
+	workerScaleInterval, workerIdleTimeout = 5*time.Millisecond, 50*time.Millisecond
+	defer func() { workerScaleInterval, workerIdleTimeout = scaleInterval, idleTimeout }()
+
+	devices := poolTestDevices(30)
+
+	client := &slowHostSSHClient{
+		countingSSHClient: countingSSHClient{
+			stubSSHClient: stubSSHClient{outputs: map[string]string{"show version": "uptime is 5 days"}},
+			delay:         10 * time.Millisecond,
+		},
+		slowHost:  devices[len(devices)-1].IPAddress,
+		slowDelay: 500 * time.Millisecond,
+	}
+	engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+	assert.NoError(t, engine.SetAdaptiveWorkerCount(1, 6))
+
+	err := engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "Rule 1", Vendor: "generic", Command: "show version", ExpectedPattern: "uptime",
+			Severity: string(SeverityLow), Enabled: true},
+	})
+	assert.NoError(t, err)
+
+	// Sample the pool size during the run and once the slow device has held
+	// up the end of it long enough for idle workers to exit
+	var mu sync.Mutex
+	var peak, tail int
+	client.afterSlow = func() {
+		mu.Lock()
+		tail = engine.GetActiveWorkerCount()
+		mu.Unlock()
+	}
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				active := engine.GetActiveWorkerCount()
+				mu.Lock()
+				if active > peak {
+					peak = active
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+
+	results, err := engine.RunBulkChecks(devices)
+	close(stop)
+	<-sampled
+
+	assert.NoError(t, err)
+	assert.Len(t, results, len(devices))
+	for _, deviceResults := range results {
+		assert.Len(t, deviceResults, 1)
+		assert.Equal(t, string(StatusPass), deviceResults[0].Status)
+	}
+
+	assert.Greater(t, peak, 1, "pool should grow while jobs are queued")
+	assert.LessOrEqual(t, peak, 6)
+	assert.Equal(t, 1, tail, "idle workers should exit while the slow device finishes")
+	assert.Equal(t, 0, engine.GetActiveWorkerCount())
+}
@@ -0,0 +1,59 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/catalog"
+	"invictux-demo/internal/device"
+
+	"github.com/google/uuid"
+)
+
+// PortExposureCheckName names the predefined check that only the SSH port is open
+const PortExposureCheckName = "Only SSH Port Exposed"
+
+// EvaluatePortExposure evaluates a port scan against the ports a device is
+// allowed to expose. Any other open port fails the check.
+func EvaluatePortExposure(scan *device.PortScanResult, allowedPorts []int) CheckResult {
+	result := CheckResult{
+		ID:              uuid.New().String(),
+		DeviceID:        scan.DeviceID,
+		CheckName:       PortExposureCheckName,
+		CheckType:       "network",
+		Severity:        string(SeverityHigh),
+		FindingCategory: FindingRemoteAccess,
+		FindingKey:      "unexpected-open-ports",
+		Evidence: fmt.Sprintf("open: %s; closed: %s; filtered: %s",
+			formatPorts(scan.OpenPorts), formatPorts(scan.ClosedPorts), formatPorts(scan.FilteredPorts)),
+		CheckedAt: time.Now(),
+		RunID:     uuid.New().String(),
+	}
+
+	message := catalog.NewMessage(catalog.MsgAllowedPortsOnly, catalog.Params{"ports": formatPorts(allowedPorts)})
+	result.Status = string(StatusPass)
+
+	if unexpected := scan.UnexpectedOpenPorts(allowedPorts); len(unexpected) > 0 {
+		message = catalog.NewMessage(catalog.MsgUnexpectedPorts, catalog.Params{"ports": formatPorts(unexpected)})
+		result.Status = string(StatusFail)
+	}
+
+	result.MessageID = message.ID
+	result.MessageParams = message.Params
+	result.Message = message.Render(catalog.DefaultLocale)
+	return result
+}
+
+// formatPorts renders a port list for messages
+func formatPorts(ports []int) string {
+	if len(ports) == 0 {
+		return "none"
+	}
+
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = fmt.Sprintf("%d", port)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,31 @@
+package checker
+
+import (
+	"testing"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluatePortExposure(t *testing.T) {
+	t.Run("only ssh open", func(t *testing.T) {
+		scan := &device.PortScanResult{DeviceID: "d1", OpenPorts: []int{22}, ClosedPorts: []int{23, 80}}
+
+		result := EvaluatePortExposure(scan, []int{22})
+		assert.Equal(t, string(StatusPass), result.Status)
+		assert.Equal(t, PortExposureCheckName, result.CheckName)
+		assert.Equal(t, "d1", result.DeviceID)
+		assert.NotEmpty(t, result.RunID)
+		assert.Equal(t, "open: 22; closed: 23, 80; filtered: none", result.Evidence)
+	})
+
+	t.Run("telnet and http open", func(t *testing.T) {
+		scan := &device.PortScanResult{DeviceID: "d1", OpenPorts: []int{22, 23, 80}}
+
+		result := EvaluatePortExposure(scan, []int{22})
+		assert.Equal(t, string(StatusFail), result.Status)
+		assert.Equal(t, string(SeverityHigh), result.Severity)
+		assert.Equal(t, "Unexpected open ports: 23, 80", result.Message)
+	})
+}
@@ -0,0 +1,376 @@
+package checker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/catalog"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/google/uuid"
+)
+
+// WeakSSHCheckName names the synthetic check built from each device's SSH
+// handshake
+const WeakSSHCheckName = "Weak SSH Negotiation"
+
+// ErrPostureNotFound is returned when no SSH handshake was seen for a device
+var ErrPostureNotFound = errors.New("SSH posture not found")
+
+// defaultWeakSSHAlgorithms lists SHA-1 and MD5 based key exchanges, MACs
+// and host key signatures, and CBC and RC4 ciphers
+var defaultWeakSSHAlgorithms = []string{
+	"diffie-hellman-group1-sha1",
+	"diffie-hellman-group14-sha1",
+	"diffie-hellman-group-exchange-sha1",
+	"ssh-rsa",
+	"ssh-dss",
+	"aes128-cbc",
+	"aes192-cbc",
+	"aes256-cbc",
+	"3des-cbc",
+	"blowfish-cbc",
+	"cast128-cbc",
+	"arcfour",
+	"arcfour128",
+	"arcfour256",
+	"hmac-sha1",
+	"hmac-sha1-96",
+	"hmac-md5",
+	"hmac-md5-96",
+}
+
+// DefaultWeakSSHAlgorithms returns the algorithms flagged as weak unless
+// the engine is configured otherwise
+func DefaultWeakSSHAlgorithms() []string {
+	return append([]string(nil), defaultWeakSSHAlgorithms...)
+}
+
+// SSHPosture is the latest SSH handshake seen for a device
+type SSHPosture struct {
+	DeviceID      string    `json:"deviceId"`
+	KeyExchange   string    `json:"keyExchange"`
+	HostKey       string    `json:"hostKey"`
+	Cipher        string    `json:"cipher"`
+	MAC           string    `json:"mac"`
+	ServerVersion string    `json:"serverVersion"`
+	ObservedAt    time.Time `json:"observedAt"`
+	// Weak lists the negotiated algorithms in the weak set, filled in reports
+	Weak []string `json:"weak,omitempty"`
+}
+
+// newSSHPosture builds a device posture from a handshake
+func newSSHPosture(deviceID string, handshake ssh.Handshake, observedAt time.Time) SSHPosture {
+	return SSHPosture{
+		DeviceID:      deviceID,
+		KeyExchange:   handshake.KeyExchange,
+		HostKey:       handshake.HostKey,
+		Cipher:        handshake.Cipher,
+		MAC:           handshake.MAC,
+		ServerVersion: handshake.ServerVersion,
+		ObservedAt:    observedAt,
+	}
+}
+
+// weakAlgorithms returns the negotiated algorithms found in weak
+func (p SSHPosture) weakAlgorithms(weak map[string]bool) []string {
+	var found []string
+	for _, algorithm := range []string{p.KeyExchange, p.HostKey, p.Cipher, p.MAC} {
+		if algorithm != "" && weak[algorithm] {
+			found = append(found, algorithm)
+		}
+	}
+	return found
+}
+
+// evidence renders the handshake for a check result
+func (p SSHPosture) evidence() string {
+	return fmt.Sprintf("kex: %s\nhost key: %s\ncipher: %s\nmac: %s\nserver: %s",
+		p.KeyExchange, p.HostKey, p.Cipher, p.MAC, p.ServerVersion)
+}
+
+// SSHAlgorithmUsage lists the devices that negotiated an algorithm
+type SSHAlgorithmUsage struct {
+	Algorithm string   `json:"algorithm"`
+	Weak      bool     `json:"weak"`
+	DeviceIDs []string `json:"deviceIds"`
+}
+
+// SSHPostureReport aggregates the latest handshake of every device by
+// negotiated algorithm
+type SSHPostureReport struct {
+	GeneratedAt  time.Time           `json:"generatedAt"`
+	DeviceCount  int                 `json:"deviceCount"`
+	KeyExchanges []SSHAlgorithmUsage `json:"keyExchanges"`
+	HostKeys     []SSHAlgorithmUsage `json:"hostKeys"`
+	Ciphers      []SSHAlgorithmUsage `json:"ciphers"`
+	MACs         []SSHAlgorithmUsage `json:"macs"`
+	// WeakDevices holds the devices that negotiated at least one weak
+	// algorithm, with Weak filled in
+	WeakDevices    []SSHPosture `json:"weakDevices"`
+	WeakAlgorithms []string     `json:"weakAlgorithms"`
+}
+
+// BuildSSHPostureReport aggregates postures, flagging the algorithms in weak
+func BuildSSHPostureReport(postures []SSHPosture, weak []string) *SSHPostureReport {
+	weakSet := make(map[string]bool, len(weak))
+	for _, algorithm := range weak {
+		weakSet[algorithm] = true
+	}
+
+	report := &SSHPostureReport{
+		GeneratedAt:    time.Now(),
+		DeviceCount:    len(postures),
+		WeakDevices:    []SSHPosture{},
+		WeakAlgorithms: append([]string(nil), weak...),
+	}
+
+	keyExchanges := make(map[string][]string)
+	hostKeys := make(map[string][]string)
+	ciphers := make(map[string][]string)
+	macs := make(map[string][]string)
+	for _, posture := range postures {
+		keyExchanges[posture.KeyExchange] = append(keyExchanges[posture.KeyExchange], posture.DeviceID)
+		hostKeys[posture.HostKey] = append(hostKeys[posture.HostKey], posture.DeviceID)
+		ciphers[posture.Cipher] = append(ciphers[posture.Cipher], posture.DeviceID)
+		macs[posture.MAC] = append(macs[posture.MAC], posture.DeviceID)
+
+		if found := posture.weakAlgorithms(weakSet); len(found) > 0 {
+			posture.Weak = found
+			report.WeakDevices = append(report.WeakDevices, posture)
+		}
+	}
+
+	report.KeyExchanges = algorithmUsage(keyExchanges, weakSet)
+	report.HostKeys = algorithmUsage(hostKeys, weakSet)
+	report.Ciphers = algorithmUsage(ciphers, weakSet)
+	report.MACs = algorithmUsage(macs, weakSet)
+	return report
+}
+
+// algorithmUsage orders usage by device count, most used first
+func algorithmUsage(devices map[string][]string, weak map[string]bool) []SSHAlgorithmUsage {
+	usage := make([]SSHAlgorithmUsage, 0, len(devices))
+	for algorithm, deviceIDs := range devices {
+		if algorithm == "" {
+			continue
+		}
+		sort.Strings(deviceIDs)
+		usage = append(usage, SSHAlgorithmUsage{Algorithm: algorithm, Weak: weak[algorithm], DeviceIDs: deviceIDs})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if len(usage[i].DeviceIDs) != len(usage[j].DeviceIDs) {
+			return len(usage[i].DeviceIDs) > len(usage[j].DeviceIDs)
+		}
+		return usage[i].Algorithm < usage[j].Algorithm
+	})
+	return usage
+}
+
+// PostureStore keeps the latest SSH handshake seen for each device
+type PostureStore struct {
+	db *sql.DB
+}
+
+// NewPostureStore creates a new posture store
+func NewPostureStore(db *sql.DB) *PostureStore {
+	return &PostureStore{db: db}
+}
+
+// Save replaces the stored posture of the device
+func (ps *PostureStore) Save(posture SSHPosture) error {
+	if strings.TrimSpace(posture.DeviceID) == "" {
+		return fmt.Errorf("device ID cannot be empty")
+	}
+
+	query := `
+		INSERT INTO device_ssh_posture (device_id, key_exchange, host_key, cipher, mac, server_version, observed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(device_id) DO UPDATE SET
+			key_exchange = excluded.key_exchange,
+			host_key = excluded.host_key,
+			cipher = excluded.cipher,
+			mac = excluded.mac,
+			server_version = excluded.server_version,
+			observed_at = excluded.observed_at
+	`
+	if _, err := ps.db.Exec(query, posture.DeviceID, posture.KeyExchange, posture.HostKey, posture.Cipher,
+		posture.MAC, posture.ServerVersion, posture.ObservedAt); err != nil {
+		return fmt.Errorf("failed to save SSH posture: %w", err)
+	}
+	return nil
+}
+
+// Get returns the stored posture of a device
+func (ps *PostureStore) Get(deviceID string) (*SSHPosture, error) {
+	postures, err := ps.query(`WHERE device_id = ?`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(postures) == 0 {
+		return nil, ErrPostureNotFound
+	}
+	return &postures[0], nil
+}
+
+// GetAll returns the stored posture of every device
+func (ps *PostureStore) GetAll() ([]SSHPosture, error) {
+	return ps.query(``)
+}
+
+// query reads postures matching a WHERE clause
+func (ps *PostureStore) query(where string, args ...interface{}) ([]SSHPosture, error) {
+	rows, err := ps.db.Query(`
+		SELECT device_id, key_exchange, host_key, cipher, mac, server_version, observed_at
+		FROM device_ssh_posture `+where+`
+		ORDER BY device_id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query SSH posture: %w", err)
+	}
+	defer rows.Close()
+
+	postures := []SSHPosture{}
+	for rows.Next() {
+		var posture SSHPosture
+		if err := rows.Scan(&posture.DeviceID, &posture.KeyExchange, &posture.HostKey, &posture.Cipher,
+			&posture.MAC, &posture.ServerVersion, &posture.ObservedAt); err != nil {
+			return nil, err
+		}
+		postures = append(postures, posture)
+	}
+	return postures, rows.Err()
+}
+
+// postureCapture is the last handshake the engine saw for a device
+type postureCapture struct {
+	conn     *ssh.SSHConnection
+	posture  SSHPosture
+	lastSeen time.Time
+}
+
+// SetPostureStore persists the SSH handshake of each device when set
+func (e *Engine) SetPostureStore(store *PostureStore) {
+	e.postureStore = store
+}
+
+// SetWeakSSHAlgorithms sets the algorithms flagged by the weak SSH check and
+// posture report. nil restores DefaultWeakSSHAlgorithms.
+func (e *Engine) SetWeakSSHAlgorithms(algorithms []string) {
+	e.postureMutex.Lock()
+	defer e.postureMutex.Unlock()
+	if algorithms == nil {
+		e.weakSSHAlgorithms = nil
+		return
+	}
+	e.weakSSHAlgorithms = append([]string{}, algorithms...)
+}
+
+// WeakSSHAlgorithms returns the algorithms flagged as weak
+func (e *Engine) WeakSSHAlgorithms() []string {
+	e.postureMutex.Lock()
+	defer e.postureMutex.Unlock()
+	if e.weakSSHAlgorithms == nil {
+		return DefaultWeakSSHAlgorithms()
+	}
+	return append([]string{}, e.weakSSHAlgorithms...)
+}
+
+// GetSSHPostureReport aggregates the stored postures of all devices
+func (e *Engine) GetSSHPostureReport() (*SSHPostureReport, error) {
+	if e.postureStore == nil {
+		return BuildSSHPostureReport(nil, e.WeakSSHAlgorithms()), nil
+	}
+	postures, err := e.postureStore.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return BuildSSHPostureReport(postures, e.WeakSSHAlgorithms()), nil
+}
+
+// connect opens a connection to a device and records its SSH handshake
+func (e *Engine) connect(ctx context.Context, client ssh.SSHClientInterface, device *device.Device) (*ssh.SSHConnection, error) {
+	conn, err := client.Connect(ctx, deviceConnectionInfo(device))
+	if err != nil {
+		return nil, err
+	}
+	e.observeHandshake(device, conn)
+	return conn, nil
+}
+
+// observeHandshake records the handshake of a connection. A connection is
+// only stored once, so pooled connections do not rewrite the same posture.
+func (e *Engine) observeHandshake(device *device.Device, conn *ssh.SSHConnection) {
+	if conn == nil || conn.Handshake().IsZero() {
+		return
+	}
+
+	now := time.Now()
+	e.postureMutex.Lock()
+	if e.postures == nil {
+		e.postures = make(map[string]*postureCapture)
+	}
+	capture, ok := e.postures[device.ID]
+	if ok && capture.conn == conn {
+		capture.lastSeen = now
+		e.postureMutex.Unlock()
+		return
+	}
+	capture = &postureCapture{conn: conn, posture: newSSHPosture(device.ID, conn.Handshake(), now), lastSeen: now}
+	e.postures[device.ID] = capture
+	e.postureMutex.Unlock()
+
+	if e.postureStore != nil {
+		if err := e.postureStore.Save(capture.posture); err != nil {
+			log.Printf("Failed to save SSH posture of device %s: %v", device.ID, err)
+		}
+	}
+}
+
+// postureResult builds the weak SSH negotiation result of a device from the
+// handshake seen since a run started; ok is false when there was none
+func (e *Engine) postureResult(device *device.Device, since time.Time) (CheckResult, bool) {
+	e.postureMutex.Lock()
+	capture, ok := e.postures[device.ID]
+	var posture SSHPosture
+	if ok {
+		ok = !capture.lastSeen.Before(since)
+		posture = capture.posture
+	}
+	e.postureMutex.Unlock()
+	if !ok {
+		return CheckResult{}, false
+	}
+
+	weak := make(map[string]bool)
+	for _, algorithm := range e.WeakSSHAlgorithms() {
+		weak[algorithm] = true
+	}
+
+	result := CheckResult{
+		ID:              uuid.New().String(),
+		DeviceID:        device.ID,
+		CheckName:       WeakSSHCheckName,
+		CheckType:       "ssh_posture",
+		Severity:        string(SeverityHigh),
+		FindingCategory: FindingRemoteAccess,
+		FindingKey:      "weak-ssh-algorithms",
+		Status:          string(StatusPass),
+		Evidence:        posture.evidence(),
+		CheckedAt:       time.Now(),
+	}
+	message := catalog.NewMessage(catalog.MsgSSHNegotiationOK, nil)
+	if found := posture.weakAlgorithms(weak); len(found) > 0 {
+		result.Status = string(StatusFail)
+		message = catalog.NewMessage(catalog.MsgWeakSSHNegotiation, catalog.Params{"algorithms": strings.Join(found, ", ")})
+	}
+	e.setMessage(&result, message)
+	return result, true
+}
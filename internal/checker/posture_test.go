@@ -0,0 +1,178 @@
+package checker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handshakeClient opens a new connection carrying handshake on every Connect
+type handshakeClient struct {
+	stubSSHClient
+	mu        sync.Mutex
+	handshake ssh.Handshake
+}
+
+func (c *handshakeClient) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ssh.NewConnectionForTesting(c.handshake), nil
+}
+
+func (c *handshakeClient) setHandshake(handshake ssh.Handshake) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handshake = handshake
+}
+
+var (
+	legacyHandshake = ssh.Handshake{KeyExchange: "diffie-hellman-group14-sha1", HostKey: "ssh-rsa",
+		Cipher: "aes128-cbc", MAC: "hmac-sha1", ServerVersion: "SSH-2.0-Cisco-1.25"}
+	modernHandshake = ssh.Handshake{KeyExchange: "curve25519-sha256", HostKey: "ssh-ed25519",
+		Cipher: "aes256-gcm@openssh.com", MAC: "hmac-sha2-256-etm@openssh.com", ServerVersion: "SSH-2.0-OpenSSH_9.6"}
+)
+
+// findResult returns the result of a named check
+func findResult(results []CheckResult, name string) *CheckResult {
+	for i := range results {
+		if results[i].CheckName == name {
+			return &results[i]
+		}
+	}
+	return nil
+}
+
+func TestEngine_SSHPosture(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	store := NewPostureStore(rm.db)
+	client := &handshakeClient{stubSSHClient: stubSSHClient{outputs: map[string]string{"show ip ssh": "version 2.0"}}}
+	engine := NewEngineWithSSHClient(rm, client)
+	engine.SetPostureStore(store)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "ssh", Name: "SSH version", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+	}))
+
+	router := &device.Device{ID: "r1", Name: "Router", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22}
+
+	t.Run("weak negotiation is captured and fails", func(t *testing.T) {
+		client.setHandshake(legacyHandshake)
+		results, err := engine.RunChecks(router)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+
+		result := findResult(results, WeakSSHCheckName)
+		require.NotNil(t, result)
+		assert.Equal(t, string(StatusFail), result.Status)
+		assert.Equal(t, results[0].RunID, result.RunID)
+		assert.Contains(t, result.Message, "diffie-hellman-group14-sha1, ssh-rsa, aes128-cbc, hmac-sha1")
+		assert.Contains(t, result.Evidence, "server: SSH-2.0-Cisco-1.25")
+
+		posture, err := store.Get("r1")
+		require.NoError(t, err)
+		assert.Equal(t, legacyHandshake.KeyExchange, posture.KeyExchange)
+		assert.Equal(t, legacyHandshake.HostKey, posture.HostKey)
+		assert.Equal(t, legacyHandshake.Cipher, posture.Cipher)
+		assert.Equal(t, legacyHandshake.MAC, posture.MAC)
+		assert.Equal(t, legacyHandshake.ServerVersion, posture.ServerVersion)
+	})
+
+	t.Run("reconnection updates the snapshot", func(t *testing.T) {
+		before, err := store.Get("r1")
+		require.NoError(t, err)
+
+		client.setHandshake(modernHandshake)
+		results, err := engine.RunBulkChecks([]device.Device{*router})
+		require.NoError(t, err)
+
+		result := findResult(results["r1"], WeakSSHCheckName)
+		require.NotNil(t, result)
+		assert.Equal(t, string(StatusPass), result.Status)
+
+		after, err := store.Get("r1")
+		require.NoError(t, err)
+		assert.Equal(t, modernHandshake.Cipher, after.Cipher)
+		assert.Equal(t, modernHandshake.ServerVersion, after.ServerVersion)
+		assert.False(t, after.ObservedAt.Before(before.ObservedAt))
+
+		all, err := store.GetAll()
+		require.NoError(t, err)
+		assert.Len(t, all, 1, "only the latest snapshot is kept")
+	})
+
+	t.Run("custom weak set", func(t *testing.T) {
+		engine.SetWeakSSHAlgorithms([]string{"aes256-gcm@openssh.com"})
+		defer engine.SetWeakSSHAlgorithms(nil)
+
+		results, err := engine.RunChecks(router)
+		require.NoError(t, err)
+		result := findResult(results, WeakSSHCheckName)
+		require.NotNil(t, result)
+		assert.Equal(t, string(StatusFail), result.Status)
+		assert.Contains(t, result.Message, "aes256-gcm@openssh.com")
+	})
+
+	t.Run("simulated connections emit no result", func(t *testing.T) {
+		client.setHandshake(ssh.Handshake{})
+		other := &device.Device{ID: "r2", Name: "Other", IPAddress: "192.168.1.2", Vendor: "cisco",
+			Username: "admin", SSHPort: 22}
+		results, err := engine.RunChecks(other)
+		require.NoError(t, err)
+		assert.Nil(t, findResult(results, WeakSSHCheckName))
+		_, err = store.Get("r2")
+		assert.ErrorIs(t, err, ErrPostureNotFound)
+	})
+}
+
+func TestEngine_GetSSHPostureReport(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	store := NewPostureStore(rm.db)
+	engine := NewEngine(rm)
+	engine.SetPostureStore(store)
+
+	now := time.Now()
+	require.NoError(t, store.Save(newSSHPosture("d1", legacyHandshake, now)))
+	require.NoError(t, store.Save(newSSHPosture("d2", modernHandshake, now)))
+	mixed := modernHandshake
+	mixed.KeyExchange = "diffie-hellman-group14-sha1"
+	require.NoError(t, store.Save(newSSHPosture("d3", mixed, now)))
+
+	report, err := engine.GetSSHPostureReport()
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.DeviceCount)
+	assert.Equal(t, DefaultWeakSSHAlgorithms(), report.WeakAlgorithms)
+
+	require.Len(t, report.KeyExchanges, 2)
+	assert.Equal(t, SSHAlgorithmUsage{Algorithm: "diffie-hellman-group14-sha1", Weak: true,
+		DeviceIDs: []string{"d1", "d3"}}, report.KeyExchanges[0])
+	assert.Equal(t, SSHAlgorithmUsage{Algorithm: "curve25519-sha256", Weak: false,
+		DeviceIDs: []string{"d2"}}, report.KeyExchanges[1])
+
+	require.Len(t, report.Ciphers, 2)
+	assert.Equal(t, "aes256-gcm@openssh.com", report.Ciphers[0].Algorithm)
+	assert.False(t, report.Ciphers[0].Weak)
+	assert.Equal(t, "aes128-cbc", report.Ciphers[1].Algorithm)
+	assert.True(t, report.Ciphers[1].Weak)
+
+	require.Len(t, report.WeakDevices, 2)
+	assert.Equal(t, "d1", report.WeakDevices[0].DeviceID)
+	assert.Equal(t, []string{"diffie-hellman-group14-sha1", "ssh-rsa", "aes128-cbc", "hmac-sha1"}, report.WeakDevices[0].Weak)
+	assert.Equal(t, "d3", report.WeakDevices[1].DeviceID)
+	assert.Equal(t, []string{"diffie-hellman-group14-sha1"}, report.WeakDevices[1].Weak)
+
+	// The weak set decides what is flagged
+	engine.SetWeakSSHAlgorithms([]string{"ssh-ed25519"})
+	report, err = engine.GetSSHPostureReport()
+	require.NoError(t, err)
+	require.Len(t, report.WeakDevices, 2)
+	assert.Equal(t, "d2", report.WeakDevices[0].DeviceID)
+	assert.Equal(t, "d3", report.WeakDevices[1].DeviceID)
+}
@@ -0,0 +1,59 @@
+package checker
+
+import (
+	"fmt"
+	"strings"
+
+	"invictux-demo/internal/catalog"
+)
+
+// validatePrecondition rejects a precondition without a command or with a
+// pattern that does not compile
+func validatePrecondition(precondition *RulePrecondition) error {
+	if precondition == nil {
+		return nil
+	}
+	if strings.TrimSpace(precondition.Command) == "" {
+		return fmt.Errorf("precondition command cannot be empty")
+	}
+	if strings.TrimSpace(precondition.Pattern) == "" {
+		return fmt.Errorf("precondition pattern cannot be empty")
+	}
+	if _, err := CompilePattern(precondition.Pattern); err != nil {
+		return fmt.Errorf("invalid precondition pattern: %w", err)
+	}
+	return nil
+}
+
+// preconditionColumns returns the precondition_command and
+// precondition_pattern values stored for the rule
+func (r SecurityRule) preconditionColumns() (command, pattern interface{}) {
+	if r.Precondition == nil {
+		return nil, nil
+	}
+	return nullableString(r.Precondition.Command), nullableString(r.Precondition.Pattern)
+}
+
+// preconditionHolds matches the output of a rule's precondition command
+// against its pattern. A pattern that does not compile or times out is
+// reported as an error message rather than as the rule not applying.
+func (e *Engine) preconditionHolds(output string, precondition *RulePrecondition) (bool, *catalog.Message) {
+	status, message := e.evaluateRule(output, SecurityRule{ExpectedPattern: precondition.Pattern})
+	switch status {
+	case StatusPass:
+		return true, nil
+	case StatusFail:
+		return false, nil
+	}
+	return false, &message
+}
+
+// setNotApplicable reports a rule whose precondition does not match as
+// not applicable to the device
+func (e *Engine) setNotApplicable(result *CheckResult, precondition *RulePrecondition) {
+	result.Status = string(StatusNotApplicable)
+	e.setMessage(result, catalog.NewMessage(catalog.MsgNotApplicable, catalog.Params{
+		"command": precondition.Command,
+		"pattern": precondition.Pattern,
+	}))
+}
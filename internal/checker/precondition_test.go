@@ -0,0 +1,136 @@
+package checker
+
+import (
+	"testing"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snmpRules are two rules that only apply where SNMP is configured, and one
+// that always applies
+func snmpRules() []SecurityRule {
+	snmp := &RulePrecondition{Command: "show run | include snmp-server", Pattern: `(?m)^snmp-server`}
+	return []SecurityRule{
+		{ID: "v3", Name: "SNMPv3 only", Vendor: "generic", Command: "show snmp group", ExpectedPattern: "v3 priv",
+			Severity: string(SeverityHigh), Enabled: true, Precondition: snmp},
+		{ID: "acl", Name: "SNMP ACL", Vendor: "generic", Command: "show snmp community", ExpectedPattern: "access-list",
+			Severity: string(SeverityMedium), Enabled: true, Precondition: snmp},
+		{ID: "ssh", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+	}
+}
+
+func resultsByName(results []CheckResult) map[string]CheckResult {
+	byName := make(map[string]CheckResult, len(results))
+	for _, result := range results {
+		byName[result.CheckName] = result
+	}
+	return byName
+}
+
+func TestEngine_Precondition(t *testing.T) {
+	testDevice := &device.Device{ID: "d1", Name: "One", IPAddress: "192.168.1.50", DeviceType: string(device.TypeRouter),
+		Vendor: "generic", Username: "admin", SSHPort: 22}
+
+	t.Run("applicable", func(t *testing.T) {
+		client := &stubSSHClient{outputs: map[string]string{
+			"show run | include snmp-server": "snmp-server group ops v3 priv",
+			"show snmp group":                "groupname: ops  security model:v3 priv",
+			"show snmp community":            "Community name: public",
+			"show ip ssh":                    "SSH Enabled - version 2.0",
+		}}
+		engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+		require.NoError(t, engine.LoadCustomRules(snmpRules()))
+
+		results, err := engine.RunChecks(testDevice)
+		require.NoError(t, err)
+		byName := resultsByName(results)
+		assert.Equal(t, string(StatusPass), byName["SNMPv3 only"].Status)
+		assert.Equal(t, string(StatusFail), byName["SNMP ACL"].Status, "applicable rules still fail")
+		assert.Equal(t, string(StatusPass), byName["SSH v2"].Status)
+
+		// Rules sharing a precondition run it once
+		count := 0
+		for _, command := range client.executed {
+			if command == "show run | include snmp-server" {
+				count++
+			}
+		}
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("not applicable", func(t *testing.T) {
+		client := &stubSSHClient{outputs: map[string]string{
+			"show run | include snmp-server": "",
+			"show ip ssh":                    "SSH Enabled - version 2.0",
+		}}
+		engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+		require.NoError(t, engine.LoadCustomRules(snmpRules()))
+
+		results, err := engine.RunChecks(testDevice)
+		require.NoError(t, err)
+		byName := resultsByName(results)
+		for _, name := range []string{"SNMPv3 only", "SNMP ACL"} {
+			assert.Equal(t, string(StatusNotApplicable), byName[name].Status, name)
+			assert.Contains(t, byName[name].Message, "Not applicable", name)
+		}
+		assert.Equal(t, string(StatusPass), byName["SSH v2"].Status)
+		assert.NotContains(t, client.executed, "show snmp group", "rules that do not apply do not run their command")
+		assert.NotContains(t, client.executed, "show snmp community")
+	})
+
+	t.Run("batched", func(t *testing.T) {
+		client := &shellSSHClient{stubSSHClient: stubSSHClient{outputs: map[string]string{
+			"show run | include snmp-server": "",
+			"show ip ssh":                    "SSH Enabled - version 2.0",
+		}}}
+		engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+		require.NoError(t, engine.LoadCustomRules(snmpRules()))
+		require.NoError(t, engine.EnableCommandBatching("generic", ""))
+
+		results, err := engine.RunChecks(testDevice)
+		require.NoError(t, err)
+		assert.Equal(t, string(StatusNotApplicable), resultsByName(results)["SNMP ACL"].Status)
+		assert.Len(t, client.executed, 1, "preconditions are fetched in the batch")
+	})
+}
+
+func TestRuleManager_Precondition(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	rm := NewRuleManager(db)
+
+	rule := snmpRules()[0]
+	require.NoError(t, rm.CreateRule(rule))
+	stored, err := rm.GetRule(rule.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.Precondition)
+	assert.Equal(t, *rule.Precondition, *stored.Precondition)
+
+	stored.Precondition = nil
+	require.NoError(t, rm.UpdateRule(*stored))
+	stored, err = rm.GetRule(rule.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.Precondition)
+
+	stored.Precondition = &RulePrecondition{Command: "show run", Pattern: "(?<=snmp)"}
+	assert.Error(t, rm.UpdateRule(*stored), "precondition patterns must compile")
+	stored.Precondition = &RulePrecondition{Pattern: "snmp"}
+	assert.Error(t, rm.UpdateRule(*stored), "preconditions need a command")
+}
+
+func TestCheckRuleHealth_Precondition(t *testing.T) {
+	rule := snmpRules()[0]
+	assert.Empty(t, CheckRuleHealth(rule, nil))
+
+	rule.Precondition = &RulePrecondition{Command: "configure terminal", Pattern: "("}
+	issues := CheckRuleHealth(rule, nil)
+	kinds := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	assert.ElementsMatch(t, []string{RuleIssueInvalidPattern, RuleIssueRiskyCommand}, kinds)
+}
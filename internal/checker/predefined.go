@@ -0,0 +1,492 @@
+package checker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// predefinedRuleNamespace is the UUID namespace of predefined rule IDs.
+// Changing it would give every predefined rule a new identity.
+var predefinedRuleNamespace = uuid.MustParse("3b8f5a2e-7c1d-4e96-a0b4-59d2c6e81f37")
+
+// predefinedRuleID returns the ID of the predefined rule of vendor whose
+// findings are keyed slug. It is the same in every install and release, so
+// a rule keeps its ID, and its history, when it is renamed or reworded.
+func predefinedRuleID(vendor, slug string) string {
+	return uuid.NewSHA1(predefinedRuleNamespace, []byte(vendor+"/"+slug)).String()
+}
+
+// PredefinedRulesReport summarizes what reconciling the stored rules with
+// the predefined ones changed. Rules are listed by name.
+type PredefinedRulesReport struct {
+	Created []string `json:"created,omitempty"`
+	Updated []string `json:"updated,omitempty"`
+	// Renamed maps the old names of renamed rules to their new ones
+	Renamed map[string]string `json:"renamed,omitempty"`
+	// Preserved lists rules the user modified, which an upstream change
+	// was not applied to
+	Preserved []string `json:"preserved,omitempty"`
+	// Retired lists rules no longer shipped. They are kept as custom rules,
+	// disabled unless the user modified them.
+	Retired []string `json:"retired,omitempty"`
+	// Adopted maps the IDs of rules stored before predefined rules had
+	// stable IDs to the IDs they were moved to
+	Adopted map[string]string `json:"adopted,omitempty"`
+}
+
+// Changed reports whether reconciling changed the stored rules or found an
+// upstream change it did not apply
+func (r *PredefinedRulesReport) Changed() bool {
+	return len(r.Created) > 0 || len(r.Updated) > 0 || len(r.Renamed) > 0 ||
+		len(r.Preserved) > 0 || len(r.Retired) > 0 || len(r.Adopted) > 0
+}
+
+// String summarizes the report on one line
+func (r *PredefinedRulesReport) String() string {
+	return fmt.Sprintf("%d created, %d updated, %d renamed, %d preserved, %d retired, %d adopted",
+		len(r.Created), len(r.Updated), len(r.Renamed), len(r.Preserved), len(r.Retired), len(r.Adopted))
+}
+
+// LoadPredefinedRules loads predefined security rules for all vendors.
+// See ReconcilePredefinedRules.
+func (rm *RuleManager) LoadPredefinedRules() error {
+	_, err := rm.ReconcilePredefinedRules()
+	return err
+}
+
+// ReconcilePredefinedRules brings the stored predefined rules in line with
+// the ones this release ships, matching them by their stable IDs. Missing
+// rules are created unless they were merged away. Rules with a newer
+// version or a new name are updated, keeping the user's enabled choice,
+// unless the user modified them. Rules no longer shipped are retired
+// rather than deleted, so their results keep pointing at a rule.
+func (rm *RuleManager) ReconcilePredefinedRules() (*PredefinedRulesReport, error) {
+	return rm.reconcilePredefinedRules(GetPredefinedRules())
+}
+
+// loadPredefinedRules reconciles the stored rules with rules as the
+// predefined ones
+func (rm *RuleManager) loadPredefinedRules(rules []SecurityRule) error {
+	_, err := rm.reconcilePredefinedRules(rules)
+	return err
+}
+
+func (rm *RuleManager) reconcilePredefinedRules(rules []SecurityRule) (*PredefinedRulesReport, error) {
+	merged, err := readMergedRules(rm.db)
+	if err != nil {
+		return nil, err
+	}
+
+	legacy, err := rm.legacyRuleIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PredefinedRulesReport{}
+	shipped := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.RuleVersion == 0 {
+			rule.RuleVersion = 1
+		}
+		shipped[rule.ID] = true
+
+		// Rules merged into another rule stay deleted
+		if _, ok := merged[rule.ID]; ok {
+			continue
+		}
+		if _, ok := merged[rule.Vendor+"/"+rule.Name]; ok {
+			continue
+		}
+
+		stored, err := rm.storedPredefinedRule(rule, legacy, report)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if rule exists: %w", err)
+		}
+
+		if stored == nil {
+			rule.Predefined = true
+			rule.SourceVersion = rule.RuleVersion
+			if err := rm.createRule(rule); err != nil {
+				return nil, fmt.Errorf("failed to create rule %s: %w", rule.Name, err)
+			}
+			report.Created = append(report.Created, rule.Name)
+			continue
+		}
+
+		if err := rm.reconcileRule(*stored, rule, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rm.retirePredefinedRules(shipped, report); err != nil {
+		return nil, err
+	}
+
+	// Every legacy rule has its stable ID now
+	if _, err := rm.db.Exec("DELETE FROM app_settings WHERE key = ?", loadedRuleVersionsKey); err != nil {
+		return nil, fmt.Errorf("failed to clear loaded rule versions: %w", err)
+	}
+	return report, nil
+}
+
+// storedPredefinedRule returns the stored copy of a predefined rule, or nil
+// when there is none. A copy stored by a release before predefined rules
+// had stable IDs is moved to the stable ID. It is found by name and vendor,
+// or, when the rule has been renamed since, by its finding key among the
+// rules legacyIDs records as loaded from the predefined ones.
+func (rm *RuleManager) storedPredefinedRule(rule SecurityRule, legacyIDs map[string]bool,
+	report *PredefinedRulesReport) (*SecurityRule, error) {
+	stored, err := rm.ruleByID(rule.ID)
+	if err != nil || stored != nil {
+		return stored, err
+	}
+
+	legacy, err := rm.findRule(rule.Name, rule.Vendor)
+	if err != nil {
+		return nil, err
+	}
+	if legacy == nil || legacy.Predefined {
+		legacy, err = rm.findLegacyRule(rule.Vendor, normalizeFindingKey(rule.FindingKey), legacyIDs)
+		if err != nil || legacy == nil {
+			return nil, err
+		}
+	}
+	if err := rm.moveRule(legacy.ID, rule.ID); err != nil {
+		return nil, fmt.Errorf("failed to adopt rule %s: %w", legacy.Name, err)
+	}
+
+	if report.Adopted == nil {
+		report.Adopted = make(map[string]string)
+	}
+	report.Adopted[legacy.ID] = rule.ID
+	legacy.ID = rule.ID
+	return legacy, nil
+}
+
+// reconcileRule brings the stored copy of a predefined rule up to date
+func (rm *RuleManager) reconcileRule(stored, rule SecurityRule, report *PredefinedRulesReport) error {
+	// Copies stored without the flag, adopted or imported, are taken to
+	// be of the version they carry
+	if !stored.Predefined {
+		if _, err := rm.db.Exec("UPDATE security_rules SET predefined = TRUE, source_version = rule_version WHERE id = ?",
+			stored.ID); err != nil {
+			return fmt.Errorf("failed to mark rule %s as predefined: %w", stored.Name, err)
+		}
+		rm.rulesChanged()
+		stored.SourceVersion = stored.RuleVersion
+	}
+
+	// An older predefined version never downgrades the stored rule
+	if rule.RuleVersion < stored.SourceVersion {
+		return nil
+	}
+	changed := rule.RuleVersion > stored.SourceVersion || rule.Name != stored.Name
+
+	if stored.UserModified {
+		if changed {
+			report.Preserved = append(report.Preserved, stored.Name)
+		}
+		return nil
+	}
+	if !changed {
+		return rm.backfillRule(stored, rule)
+	}
+
+	// Keep the stored identity and the user's enabled choice
+	rule.ID = stored.ID
+	rule.CreatedAt = stored.CreatedAt
+	rule.Enabled = stored.Enabled
+	if err := rm.updateRule(rule, false); err != nil {
+		return fmt.Errorf("failed to update rule %s: %w", rule.Name, err)
+	}
+
+	if rule.Name == stored.Name {
+		report.Updated = append(report.Updated, rule.Name)
+		return nil
+	}
+	if report.Renamed == nil {
+		report.Renamed = make(map[string]string)
+	}
+	report.Renamed[stored.Name] = rule.Name
+	return nil
+}
+
+// RestorePredefinedRule discards the user's changes to a predefined rule,
+// restoring the version this release ships so upgrades apply to it again.
+// The rule stays enabled or disabled as it is.
+func (rm *RuleManager) RestorePredefinedRule(id string) (*SecurityRule, error) {
+	stored, err := rm.ruleByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, fmt.Errorf("rule with ID %s not found", id)
+	}
+	if !stored.Predefined {
+		return nil, fmt.Errorf("rule %s is not a predefined rule", stored.Name)
+	}
+
+	for _, rule := range GetPredefinedRules() {
+		if rule.ID != id {
+			continue
+		}
+		rule.CreatedAt = stored.CreatedAt
+		rule.Enabled = stored.Enabled
+		if err := rm.updateRule(rule, false); err != nil {
+			return nil, fmt.Errorf("failed to restore rule %s: %w", rule.Name, err)
+		}
+		return rm.GetRule(id)
+	}
+	return nil, fmt.Errorf("rule %s is no longer shipped", stored.Name)
+}
+
+// backfillRule fills in the fields a stored predefined rule predates
+func (rm *RuleManager) backfillRule(stored, rule SecurityRule) error {
+	// Rules stored before categories existed take the predefined one
+	if stored.Category == "" && rule.Category != "" {
+		if _, err := rm.db.Exec("UPDATE security_rules SET category = ? WHERE id = ?", rule.Category, stored.ID); err != nil {
+			return fmt.Errorf("failed to set category of rule %s: %w", rule.Name, err)
+		}
+		rm.rulesChanged()
+	}
+
+	// Likewise for rules stored before findings were categorized
+	if stored.FindingCategory == "" && rule.FindingCategory != "" {
+		if _, err := rm.db.Exec("UPDATE security_rules SET finding_category = ?, finding_key = ? WHERE id = ?",
+			rule.FindingCategory, normalizeFindingKey(rule.FindingKey), stored.ID); err != nil {
+			return fmt.Errorf("failed to set finding category of rule %s: %w", rule.Name, err)
+		}
+		rm.rulesChanged()
+	}
+
+	// And for rules stored before they were documented
+	if stored.Rationale == "" && len(stored.References) == 0 && (rule.Rationale != "" || len(rule.References) > 0) {
+		references, err := encodeReferences(rule.References)
+		if err != nil {
+			return err
+		}
+		if _, err := rm.db.Exec("UPDATE security_rules SET rationale = ?, rule_references = ? WHERE id = ?",
+			rule.Rationale, references, stored.ID); err != nil {
+			return fmt.Errorf("failed to document rule %s: %w", rule.Name, err)
+		}
+		rm.rulesChanged()
+	}
+
+	return nil
+}
+
+// retirePredefinedRules turns the stored predefined rules that are no
+// longer shipped into custom rules, disabling those the user did not modify
+func (rm *RuleManager) retirePredefinedRules(shipped map[string]bool, report *PredefinedRulesReport) error {
+	rows, err := rm.db.Query("SELECT id, name FROM security_rules WHERE predefined = TRUE ORDER BY vendor, name")
+	if err != nil {
+		return fmt.Errorf("failed to read predefined rules: %w", err)
+	}
+
+	var retired, names []string
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		if !shipped[id] {
+			retired = append(retired, id)
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, id := range retired {
+		if _, err := rm.db.Exec(`
+			UPDATE security_rules
+			SET enabled = enabled AND user_modified, predefined = FALSE
+			WHERE id = ?
+		`, id); err != nil {
+			return fmt.Errorf("failed to retire rule %s: %w", names[i], err)
+		}
+		rm.rulesChanged()
+		report.Retired = append(report.Retired, names[i])
+	}
+	return nil
+}
+
+// moveRule changes the ID of a stored rule along with every reference to it
+func (rm *RuleManager) moveRule(oldID, newID string) error {
+	defer rm.rulesChanged()
+
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The tables referencing the rule only match it again once all of them
+	// are updated, so foreign keys are checked on commit
+	if _, err := tx.Exec("PRAGMA defer_foreign_keys = ON"); err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"UPDATE security_rules SET id = ? WHERE id = ?",
+		"UPDATE rule_vendor_overrides SET rule_id = ? WHERE rule_id = ?",
+		"UPDATE skipped_rules SET rule_id = ? WHERE rule_id = ?",
+		"UPDATE severity_overrides SET rule_id = ? WHERE rule_id = ?",
+	} {
+		if _, err := tx.Exec(query, newID, oldID); err != nil {
+			return err
+		}
+	}
+
+	if err := moveDependencies(tx, oldID, newID); err != nil {
+		return err
+	}
+
+	merged, err := readMergedRules(tx)
+	if err != nil {
+		return err
+	}
+	moved := false
+	for key, keptID := range merged {
+		if keptID == oldID {
+			merged[key] = newID
+			moved = true
+		}
+	}
+	if moved {
+		if err := saveMergedRules(tx, merged); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// moveDependencies points the rules depending on oldID at newID
+func moveDependencies(tx *sql.Tx, oldID, newID string) error {
+	rows, err := tx.Query("SELECT id, depends_on FROM security_rules WHERE depends_on IS NOT NULL")
+	if err != nil {
+		return fmt.Errorf("failed to read rule dependencies: %w", err)
+	}
+
+	updated := make(map[string][]string)
+	for rows.Next() {
+		var id, encoded string
+		if err := rows.Scan(&id, &encoded); err != nil {
+			rows.Close()
+			return err
+		}
+		var dependsOn []string
+		if err := json.Unmarshal([]byte(encoded), &dependsOn); err != nil {
+			rows.Close()
+			return fmt.Errorf("invalid dependencies for rule %s: %w", id, err)
+		}
+		for i, prerequisite := range dependsOn {
+			if prerequisite == oldID {
+				dependsOn[i] = newID
+				updated[id] = dependsOn
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(updated))
+	for id := range updated {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		encoded, err := encodeDependsOn(normalizeDependsOn(updated[id]))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE security_rules SET depends_on = ? WHERE id = ?", encoded, id); err != nil {
+			return fmt.Errorf("failed to repoint dependencies of rule %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// findLegacyRule returns the rule of vendor with the finding key among the
+// legacy rules, or nil if none exists. Copies the user made of them carry
+// the same key but are not legacy rules.
+func (rm *RuleManager) findLegacyRule(vendor, findingKey string, legacyIDs map[string]bool) (*SecurityRule, error) {
+	if findingKey == "" || len(legacyIDs) == 0 {
+		return nil, nil
+	}
+
+	query := "SELECT " + ruleColumns + ` FROM security_rules
+		WHERE vendor = ? AND finding_key = ? AND predefined = FALSE
+		ORDER BY created_at`
+
+	rows, err := rm.db.Query(query, vendor, findingKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		if legacyIDs[rule.ID] {
+			return &rule, nil
+		}
+	}
+	return nil, rows.Err()
+}
+
+// loadedRuleVersionsKey is the app_settings key where releases before
+// predefined rules had stable IDs recorded the rules loaded from the
+// predefined ones, as a JSON map of rule ID to version
+const loadedRuleVersionsKey = "loaded_rule_versions"
+
+// legacyRuleIDs returns the IDs of the rules recorded under
+// loadedRuleVersionsKey
+func (rm *RuleManager) legacyRuleIDs() (map[string]bool, error) {
+	var value string
+	err := rm.db.QueryRow("SELECT value FROM app_settings WHERE key = ?", loadedRuleVersionsKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read loaded rule versions: %w", err)
+	}
+
+	var versions map[string]int
+	if err := json.Unmarshal([]byte(value), &versions); err != nil {
+		return nil, fmt.Errorf("invalid loaded rule versions: %w", err)
+	}
+
+	ids := make(map[string]bool, len(versions))
+	for id := range versions {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// ruleByID returns the rule with the given ID, or nil if none exists
+func (rm *RuleManager) ruleByID(id string) (*SecurityRule, error) {
+	query := "SELECT " + ruleColumns + " FROM security_rules WHERE id = ?"
+
+	rule, err := scanRule(rm.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
@@ -0,0 +1,206 @@
+package checker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// catalogRule is a predefined cisco rule keyed slug, as a release ships it
+func catalogRule(slug, name, pattern string, version int) SecurityRule {
+	return SecurityRule{ID: predefinedRuleID("cisco", slug), Name: name, Vendor: "cisco", Command: "show " + slug,
+		ExpectedPattern: pattern, Severity: string(SeverityMedium), Enabled: true, FindingKey: slug, RuleVersion: version}
+}
+
+// countResults returns how many stored results of the rule carry the check name
+func countResults(t *testing.T, rm *RuleManager, ruleID, checkName string) int {
+	t.Helper()
+
+	var count int
+	require.NoError(t, rm.db.QueryRow("SELECT COUNT(*) FROM check_results WHERE rule_id = ? AND check_name = ?",
+		ruleID, checkName).Scan(&count))
+	return count
+}
+
+func TestGetPredefinedRules_StableIDs(t *testing.T) {
+	first, second := GetPredefinedRules(), GetPredefinedRules()
+	require.Equal(t, len(first), len(second))
+
+	seen := make(map[string]bool, len(first))
+	for i, rule := range first {
+		assert.Equal(t, rule.ID, second[i].ID, "rules come in the same order with the same IDs")
+		assert.Equal(t, predefinedRuleID(rule.Vendor, rule.FindingKey), rule.ID, rule.Name)
+		assert.False(t, seen[rule.ID], "duplicate ID for %s", rule.Name)
+		seen[rule.ID] = true
+	}
+	assert.NotEqual(t, predefinedRuleID("cisco", "telnet-enabled"), predefinedRuleID("generic", "telnet-enabled"))
+}
+
+func TestRuleManager_ReconcilePredefinedRulesUpgrade(t *testing.T) {
+	rm := setupTestRuleManager(t)
+
+	release1 := []SecurityRule{
+		catalogRule("ssh-disabled", "Check SSH", "SSH Enabled", 1),
+		catalogRule("banner-missing", "Check Banner", "banner motd", 1),
+		catalogRule("http-enabled", "Check HTTP Server", "no ip http server", 1),
+		catalogRule("cdp-enabled", "Check CDP", "no cdp run", 1),
+	}
+	report, err := rm.reconcilePredefinedRules(release1)
+	require.NoError(t, err)
+	assert.Len(t, report.Created, 4)
+
+	_, err = rm.db.Exec(`INSERT INTO check_results (id, device_id, check_name, check_type, severity, status, rule_id)
+		VALUES ('r1', 'd1', 'Check Banner', 'configuration', 'Medium', 'FAIL', ?)`, release1[1].ID)
+	require.NoError(t, err)
+
+	// The user disables one rule and edits another
+	sshID, httpID := release1[0].ID, release1[2].ID
+	require.NoError(t, rm.DisableRule(sshID))
+	edited, err := rm.GetRule(httpID)
+	require.NoError(t, err)
+	edited.ExpectedPattern = "our own pattern"
+	require.NoError(t, rm.UpdateRule(*edited))
+
+	// The next release changes a pattern, renames a rule, reworks the
+	// edited rule and drops the CDP rule
+	release2 := []SecurityRule{
+		catalogRule("ssh-disabled", "Check SSH", "SSH Enabled - version 2", 2),
+		catalogRule("banner-missing", "Check Login Banner", "banner (login|motd)", 2),
+		catalogRule("http-enabled", "Check HTTP Server", "no ip http (secure-)?server", 2),
+	}
+	report, err = rm.reconcilePredefinedRules(release2)
+	require.NoError(t, err)
+	assert.Empty(t, report.Created)
+	assert.Equal(t, []string{"Check SSH"}, report.Updated)
+	assert.Equal(t, map[string]string{"Check Banner": "Check Login Banner"}, report.Renamed)
+	assert.Equal(t, []string{"Check HTTP Server"}, report.Preserved)
+	assert.Equal(t, []string{"Check CDP"}, report.Retired)
+
+	rules, err := rm.GetAllRules()
+	require.NoError(t, err)
+	assert.Len(t, rules, 4, "no rule is duplicated or deleted")
+
+	ssh, err := rm.GetRule(sshID)
+	require.NoError(t, err)
+	assert.Equal(t, "SSH Enabled - version 2", ssh.ExpectedPattern)
+	assert.Equal(t, 2, ssh.SourceVersion)
+	assert.False(t, ssh.Enabled, "the user's disabled choice is kept")
+
+	// The renamed rule keeps its ID, so its results follow it under the
+	// name they were checked with
+	banner, err := rm.GetRule(release1[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Check Login Banner", banner.Name)
+	assert.Equal(t, 1, countResults(t, rm, banner.ID, "Check Banner"))
+	assert.Zero(t, countResults(t, rm, banner.ID, "Check Login Banner"))
+
+	http, err := rm.GetRule(httpID)
+	require.NoError(t, err)
+	assert.Equal(t, "our own pattern", http.ExpectedPattern)
+	assert.True(t, http.UserModified)
+	assert.Equal(t, 1, http.SourceVersion)
+
+	cdp, err := rm.GetRule(release1[3].ID)
+	require.NoError(t, err)
+	assert.False(t, cdp.Enabled)
+	assert.False(t, cdp.Predefined, "retired rules become custom rules")
+
+	// Reconciling again only reports the change still not applied
+	report, err = rm.reconcilePredefinedRules(release2)
+	require.NoError(t, err)
+	assert.Equal(t, &PredefinedRulesReport{Preserved: []string{"Check HTTP Server"}}, report)
+}
+
+func TestRuleManager_RestorePredefinedRule(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	require.NoError(t, rm.LoadPredefinedRules())
+
+	predefined := GetPredefinedRules()[0]
+	stored, err := rm.GetRule(predefined.ID)
+	require.NoError(t, err)
+	stored.Command = "show running-config"
+	stored.Enabled = false
+	require.NoError(t, rm.UpdateRule(*stored))
+
+	restored, err := rm.RestorePredefinedRule(predefined.ID)
+	require.NoError(t, err)
+	assert.Equal(t, predefined.Command, restored.Command)
+	assert.False(t, restored.Enabled)
+	assert.False(t, restored.UserModified)
+
+	custom := SecurityRule{ID: "custom", Name: "Custom", Vendor: "cisco", Command: "show version",
+		Severity: string(SeverityLow), Enabled: true, Predefined: true}
+	require.NoError(t, rm.CreateRule(custom))
+	stored, err = rm.GetRule("custom")
+	require.NoError(t, err)
+	assert.False(t, stored.Predefined, "only the loader creates predefined rules")
+	_, err = rm.RestorePredefinedRule("custom")
+	assert.Error(t, err)
+}
+
+func TestRuleManager_ReconcileAdoptsLegacyRules(t *testing.T) {
+	rm := setupTestRuleManager(t)
+
+	// Rules stored by a release before predefined rules had stable IDs,
+	// one of them renamed since, and a copy the user made of it
+	legacySSH := catalogRule("ssh-disabled", "Check SSH", "SSH Enabled", 1)
+	legacySSH.ID = "legacy-ssh"
+	legacySSH.VendorOverrides = []VendorOverride{{Vendor: "juniper", Command: "show system services"}}
+	legacyBanner := catalogRule("banner-missing", "Check Banner", "banner motd", 1)
+	legacyBanner.ID = "legacy-banner"
+	require.NoError(t, rm.CreateRule(legacySSH))
+	require.NoError(t, rm.CreateRule(legacyBanner))
+	_, err := rm.CloneRule("legacy-banner", "cisco")
+	require.NoError(t, err)
+	_, err = rm.db.Exec(`INSERT INTO app_settings (key, value) VALUES (?, '{"legacy-ssh": 1, "legacy-banner": 1}')`,
+		loadedRuleVersionsKey)
+	require.NoError(t, err)
+
+	// And what refers to them
+	require.NoError(t, rm.CreateRule(dependencyRule("dependent", "Dependent", "show dependent", "legacy-ssh")))
+	require.NoError(t, rm.SaveSkippedRules([]SkippedRule{{DeviceID: "d1", RuleID: "legacy-ssh", RuleName: "Check SSH",
+		Reason: SkipReasonDisabled}}))
+	_, err = rm.db.Exec(`INSERT INTO severity_overrides (id, scope, target, rule_id, severity, reason)
+		VALUES ('o1', 'device', 'd1', 'legacy-ssh', 'Low', 'lab')`)
+	require.NoError(t, err)
+
+	release := []SecurityRule{
+		catalogRule("ssh-disabled", "Check SSH", "SSH Enabled", 1),
+		catalogRule("banner-missing", "Check Login Banner", "banner (login|motd)", 2),
+	}
+	sshID, bannerID := release[0].ID, release[1].ID
+	report, err := rm.reconcilePredefinedRules(release)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"legacy-ssh": sshID, "legacy-banner": bannerID}, report.Adopted)
+	assert.Equal(t, map[string]string{"Check Banner": "Check Login Banner"}, report.Renamed)
+	assert.Empty(t, report.Created)
+
+	rules, err := rm.GetAllRules()
+	require.NoError(t, err)
+	assert.Len(t, rules, 4)
+
+	ssh, err := rm.GetRule(sshID)
+	require.NoError(t, err)
+	assert.True(t, ssh.Predefined)
+	assert.Len(t, ssh.VendorOverrides, 1)
+	dependent, err := rm.GetRule("dependent")
+	require.NoError(t, err)
+	assert.Equal(t, []string{sshID}, dependent.DependsOn)
+	skipped, err := rm.GetSkippedRules("d1")
+	require.NoError(t, err)
+	require.Len(t, skipped, 1)
+	assert.Equal(t, sshID, skipped[0].RuleID)
+	var overridden string
+	require.NoError(t, rm.db.QueryRow("SELECT rule_id FROM severity_overrides WHERE id = 'o1'").Scan(&overridden))
+	assert.Equal(t, sshID, overridden)
+
+	copied, err := rm.findRule("Check Banner (copy)", "cisco")
+	require.NoError(t, err)
+	require.NotNil(t, copied)
+	assert.False(t, copied.Predefined, "the user's copy is left alone")
+
+	legacy, err := rm.legacyRuleIDs()
+	require.NoError(t, err)
+	assert.Empty(t, legacy)
+}
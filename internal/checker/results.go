@@ -0,0 +1,554 @@
+package checker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Default and maximum number of rows returned by result queries
+const (
+	DefaultResultLimit = 50
+	MaxResultLimit     = 1000
+)
+
+// evidenceCompressionThreshold is the evidence size in bytes from which
+// SaveResults stores evidence gzip-compressed; smaller evidence is kept as
+// text since compression would barely save anything
+const evidenceCompressionThreshold = 1024
+
+// Comment errors
+var (
+	ErrCommentNotFound  = errors.New("comment not found")
+	ErrNotCommentAuthor = errors.New("only the author can delete a comment")
+)
+
+// ErrResultNotFound is returned when no stored result has the ID asked for
+var ErrResultNotFound = errors.New("check result not found")
+
+// resultColumns are the check_results columns read by scanResults
+const resultColumns = `id, device_id, check_name, check_type, severity, status, message, evidence, evidence_gzip,
+			checked_at, run_id, command_variant, message_id, message_params,
+			original_severity, severity_override_reason, duration_ms, evidence_stream, evidence_full_path,
+			finding_category, finding_key, fingerprint, carried_forward, evaluated_at, carried_from_run_id, rule_id, command`
+
+// ResultStore persists check results
+type ResultStore struct {
+	db *sql.DB
+
+	// onTransition is called for each saved result whose status differs
+	// from the last one stored for its device and check
+	onTransition StatusTransitionFunc
+}
+
+// StatusTransitionFunc is told that a check on a device went from the old
+// status to the new one
+type StatusTransitionFunc func(deviceID string, rule string, old, new CheckStatus)
+
+// RunSummary aggregates the results of one check run
+type RunSummary struct {
+	RunID         string    `json:"runId"`
+	DeviceCount   int       `json:"deviceCount"`
+	DeviceID      string    `json:"deviceId,omitempty"`
+	Total         int       `json:"total"`
+	Passed        int       `json:"passed"`
+	Failed        int       `json:"failed"`
+	Warnings      int       `json:"warnings"`
+	Errors        int       `json:"errors"`
+	NotApplicable int       `json:"notApplicable"`
+	StartedAt     time.Time `json:"startedAt"`
+	FinishedAt    time.Time `json:"finishedAt"`
+	Label         string    `json:"label,omitempty"`
+	Note          string    `json:"note,omitempty"`
+
+	// CheckDurationMs adds up how long the run's checks took in milliseconds
+	CheckDurationMs int64 `json:"checkDurationMs"`
+}
+
+// CheckDuration profiles how long one check takes across the results
+// stored for every device
+type CheckDuration struct {
+	CheckName string `json:"checkName"`
+	Runs      int    `json:"runs"`
+	AverageMs int64  `json:"averageMs"`
+	MaxMs     int64  `json:"maxMs"`
+}
+
+// NewResultStore creates a new result store
+func NewResultStore(db *sql.DB) *ResultStore {
+	return &ResultStore{db: db}
+}
+
+// SetStatusTransitionHook registers fn to be called after SaveResults for
+// each result whose status differs from the last one stored for the same
+// device and check. Checks without a stored result are not transitions.
+// Not applicable results say nothing about the check, so they are neither
+// reported nor compared with: a check that fails, is skipped for a failed
+// prerequisite and fails again has not changed. It must not be called
+// while results are being saved.
+func (rs *ResultStore) SetStatusTransitionHook(fn StatusTransitionFunc) {
+	rs.onTransition = fn
+}
+
+// statusTransition is a change of a check's status found by SaveResults
+type statusTransition struct {
+	deviceID string
+	rule     string
+	old, new CheckStatus
+}
+
+// SaveResults persists the results of a check run in one transaction, setting
+// the Fingerprint of results that name a finding, then reports status
+// transitions to the registered hook
+func (rs *ResultStore) SaveResults(results []CheckResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	tx, err := rs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var transitions []statusTransition
+	if rs.onTransition != nil {
+		if transitions, err = findStatusTransitions(tx, results); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO check_results (id, device_id, check_name, check_type, severity, status,
+			message, evidence, evidence_gzip, checked_at, run_id, command_variant, message_id, message_params,
+			original_severity, severity_override_reason, duration_ms, evidence_stream, evidence_full_path,
+			finding_category, finding_key, fingerprint, carried_forward, evaluated_at, carried_from_run_id, rule_id, command)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	if err := fingerprintResults(tx, results); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.CheckedAt.IsZero() {
+			result.CheckedAt = time.Now()
+		}
+
+		var params interface{}
+		if len(result.MessageParams) > 0 {
+			encoded, err := json.Marshal(result.MessageParams)
+			if err != nil {
+				return fmt.Errorf("failed to encode message parameters for check %s: %w", result.CheckName, err)
+			}
+			params = string(encoded)
+		}
+
+		evidence, compressed, err := encodeEvidence(result.Evidence)
+		if err != nil {
+			return fmt.Errorf("failed to encode evidence for check %s: %w", result.CheckName, err)
+		}
+
+		if _, err := tx.Exec(query, result.ID, result.DeviceID, result.CheckName, result.CheckType,
+			result.Severity, result.Status, result.Message, evidence, compressed, result.CheckedAt,
+			nullableString(result.RunID), nullableString(result.CommandVariant),
+			nullableString(result.MessageID), params,
+			nullableString(result.OriginalSeverity), nullableString(result.OverrideReason),
+			result.Duration.Milliseconds(), nullableString(result.EvidenceStream),
+			nullableString(result.EvidenceFullPath), nullableString(result.FindingCategory),
+			nullableString(result.FindingKey), nullableString(result.Fingerprint), result.CarriedForward,
+			result.EvaluatedAt, nullableString(result.CarriedFromRunID), nullableString(result.RuleID),
+			nullableString(result.Command)); err != nil {
+			return fmt.Errorf("failed to save result for check %s: %w", result.CheckName, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, transition := range transitions {
+		rs.onTransition(transition.deviceID, transition.rule, transition.old, transition.new)
+	}
+	return nil
+}
+
+// findStatusTransitions compares evaluated results with the last evaluated
+// result stored for their device and check, and with earlier results of
+// the same batch. Checks are matched by rule ID, so renamed and merged
+// rules keep their history, or by name for results without one.
+func findStatusTransitions(tx *sql.Tx, results []CheckResult) ([]statusTransition, error) {
+	type checkKey struct{ deviceID, check string }
+	last := make(map[checkKey]CheckStatus)
+
+	var transitions []statusTransition
+	for _, result := range results {
+		status := CheckStatus(result.Status)
+		if status == StatusNotApplicable {
+			continue
+		}
+		column, check := "check_name", result.CheckName
+		if result.RuleID != "" {
+			column, check = "rule_id", result.RuleID
+		}
+		key := checkKey{result.DeviceID, column + ":" + check}
+		previous, seen := last[key]
+		if !seen {
+			var stored string
+			err := tx.QueryRow(`
+				SELECT status FROM check_results
+				WHERE device_id = ? AND `+column+` = ? AND status != ?
+				ORDER BY checked_at DESC, rowid DESC
+				LIMIT 1
+			`, result.DeviceID, check, string(StatusNotApplicable)).Scan(&stored)
+			switch {
+			case err == nil:
+				previous, seen = CheckStatus(stored), true
+			case err != sql.ErrNoRows:
+				return nil, fmt.Errorf("failed to get previous result for check %s: %w", result.CheckName, err)
+			}
+		}
+
+		if seen && previous != status {
+			transitions = append(transitions, statusTransition{
+				deviceID: result.DeviceID, rule: result.CheckName, old: previous, new: status,
+			})
+		}
+		last[key] = status
+	}
+	return transitions, nil
+}
+
+// GetDeviceResults retrieves the most recent results for a device, newest first
+func (rs *ResultStore) GetDeviceResults(deviceID string, limit int) ([]CheckResult, error) {
+	query := `
+		SELECT ` + resultColumns + `
+		FROM check_results
+		WHERE device_id = ?
+		ORDER BY checked_at DESC, id
+		LIMIT ?
+	`
+
+	rows, err := rs.db.Query(query, deviceID, clampResultLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanResults(rows)
+}
+
+// GetResult retrieves a stored result by ID
+func (rs *ResultStore) GetResult(id string) (*CheckResult, error) {
+	rows, err := rs.db.Query(`SELECT `+resultColumns+` FROM check_results WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results, err := scanResults(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrResultNotFound
+	}
+	return &results[0], nil
+}
+
+// scanResults reads the results selected with resultColumns
+func scanResults(rows *sql.Rows) ([]CheckResult, error) {
+	var results []CheckResult
+	for rows.Next() {
+		var result CheckResult
+		var message, evidence, runID, variant, messageID, params, originalSeverity, overrideReason sql.NullString
+		var evidenceStream, evidenceFullPath, findingCategory, findingKey, fingerprint, carriedFrom, ruleID, command sql.NullString
+		var evaluatedAt sql.NullTime
+		var compressed []byte
+		var durationMs int64
+		if err := rows.Scan(&result.ID, &result.DeviceID, &result.CheckName, &result.CheckType,
+			&result.Severity, &result.Status, &message, &evidence, &compressed, &result.CheckedAt,
+			&runID, &variant, &messageID, &params, &originalSeverity, &overrideReason, &durationMs,
+			&evidenceStream, &evidenceFullPath, &findingCategory, &findingKey, &fingerprint,
+			&result.CarriedForward, &evaluatedAt, &carriedFrom, &ruleID, &command); err != nil {
+			return nil, err
+		}
+		result.Duration = time.Duration(durationMs) * time.Millisecond
+		result.Message = message.String
+		result.Evidence = evidence.String
+		if compressed != nil {
+			decoded, err := gunzipBytes(compressed)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress evidence of result %s: %w", result.ID, err)
+			}
+			result.Evidence = string(decoded)
+		}
+		result.RunID = runID.String
+		result.CommandVariant = variant.String
+		result.MessageID = messageID.String
+		result.OriginalSeverity = originalSeverity.String
+		result.OverrideReason = overrideReason.String
+		result.EvidenceStream = evidenceStream.String
+		result.EvidenceFullPath = evidenceFullPath.String
+		result.FindingCategory = findingCategory.String
+		result.FindingKey = findingKey.String
+		result.Fingerprint = fingerprint.String
+		result.CarriedFromRunID = carriedFrom.String
+		result.RuleID = ruleID.String
+		result.Command = command.String
+		if evaluatedAt.Valid {
+			evaluated := evaluatedAt.Time
+			result.EvaluatedAt = &evaluated
+		}
+		if params.String != "" {
+			if err := json.Unmarshal([]byte(params.String), &result.MessageParams); err != nil {
+				return nil, fmt.Errorf("failed to decode message parameters of result %s: %w", result.ID, err)
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// GetRecentRuns summarizes the most recent check runs, newest first.
+// Results saved without a run ID are not included.
+func (rs *ResultStore) GetRecentRuns(limit int) ([]RunSummary, error) {
+	return rs.queryRuns("", "", nil, clampResultLimit(limit))
+}
+
+// queryRuns summarizes the runs matching an extra filter over check_results c
+// and check_runs r, and an extra condition on the aggregated run, newest
+// first. args holds the arguments of filter followed by those of having.
+func (rs *ResultStore) queryRuns(filter, having string, args []interface{}, limit int) ([]RunSummary, error) {
+	query := `
+		SELECT c.run_id,
+			COUNT(DISTINCT c.device_id),
+			MIN(c.device_id),
+			COUNT(*),
+			SUM(CASE WHEN c.status = ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN c.status = ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN c.status = ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN c.status = ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN c.status = ? THEN 1 ELSE 0 END),
+			MIN(c.checked_at),
+			MAX(c.checked_at),
+			MAX(r.label),
+			MAX(r.note),
+			SUM(c.duration_ms)
+		FROM check_results c
+		LEFT JOIN check_runs r ON r.run_id = c.run_id
+		WHERE c.run_id IS NOT NULL AND c.run_id != '' ` + filter + `
+		GROUP BY c.run_id ` + having + `
+		ORDER BY MAX(c.checked_at) DESC
+		LIMIT ?
+	`
+
+	queryArgs := []interface{}{string(StatusPass), string(StatusFail), string(StatusWarning), string(StatusError),
+		string(StatusNotApplicable)}
+	queryArgs = append(queryArgs, args...)
+	queryArgs = append(queryArgs, limit)
+
+	rows, err := rs.db.Query(query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunSummary
+	for rows.Next() {
+		var run RunSummary
+		var startedAt, finishedAt string
+		var label, note sql.NullString
+		if err := rows.Scan(&run.RunID, &run.DeviceCount, &run.DeviceID, &run.Total, &run.Passed,
+			&run.Failed, &run.Warnings, &run.Errors, &run.NotApplicable, &startedAt, &finishedAt, &label, &note,
+			&run.CheckDurationMs); err != nil {
+			return nil, err
+		}
+		// Aggregates lose the column type, so the timestamps come back as text
+		if run.StartedAt, err = parseTimestamp(startedAt); err != nil {
+			return nil, err
+		}
+		if run.FinishedAt, err = parseTimestamp(finishedAt); err != nil {
+			return nil, err
+		}
+		if run.DeviceCount != 1 {
+			run.DeviceID = ""
+		}
+		run.Label = label.String
+		run.Note = note.String
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// GetSlowestChecks returns up to limit checks with the highest average
+// duration across every stored result, slowest first. Results stored
+// before durations were recorded are left out.
+func (rs *ResultStore) GetSlowestChecks(limit int) ([]CheckDuration, error) {
+	query := `
+		SELECT check_name, COUNT(*), CAST(AVG(duration_ms) AS INTEGER), MAX(duration_ms)
+		FROM check_results
+		WHERE duration_ms > 0
+		GROUP BY check_name
+		ORDER BY AVG(duration_ms) DESC, check_name
+		LIMIT ?
+	`
+
+	rows, err := rs.db.Query(query, clampResultLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	durations := []CheckDuration{}
+	for rows.Next() {
+		var duration CheckDuration
+		if err := rows.Scan(&duration.CheckName, &duration.Runs, &duration.AverageMs, &duration.MaxMs); err != nil {
+			return nil, err
+		}
+		durations = append(durations, duration)
+	}
+
+	return durations, rows.Err()
+}
+
+// AddComment attaches an analyst note to a check result
+func (rs *ResultStore) AddComment(checkResultID, authorID, body string) (*CheckComment, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, fmt.Errorf("comment body cannot be empty")
+	}
+	if strings.TrimSpace(authorID) == "" {
+		return nil, fmt.Errorf("comment author cannot be empty")
+	}
+
+	var count int
+	if err := rs.db.QueryRow("SELECT COUNT(*) FROM check_results WHERE id = ?", checkResultID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("check result with ID %s not found", checkResultID)
+	}
+
+	comment := &CheckComment{
+		ID:            uuid.New().String(),
+		CheckResultID: checkResultID,
+		AuthorID:      authorID,
+		Body:          body,
+		CreatedAt:     time.Now(),
+	}
+
+	query := `
+		INSERT INTO check_result_comments (id, check_result_id, author_id, body, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	if _, err := rs.db.Exec(query, comment.ID, comment.CheckResultID, comment.AuthorID,
+		comment.Body, comment.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to add comment: %w", err)
+	}
+
+	return comment, nil
+}
+
+// GetComments retrieves the comments on a check result, oldest first
+func (rs *ResultStore) GetComments(checkResultID string) ([]CheckComment, error) {
+	query := `
+		SELECT id, check_result_id, author_id, body, created_at
+		FROM check_result_comments
+		WHERE check_result_id = ?
+		ORDER BY created_at, rowid
+	`
+
+	rows, err := rs.db.Query(query, checkResultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []CheckComment{}
+	for rows.Next() {
+		var comment CheckComment
+		if err := rows.Scan(&comment.ID, &comment.CheckResultID, &comment.AuthorID,
+			&comment.Body, &comment.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// DeleteComment removes a comment. Only the comment's author may delete it.
+func (rs *ResultStore) DeleteComment(commentID, authorID string) error {
+	var owner string
+	err := rs.db.QueryRow("SELECT author_id FROM check_result_comments WHERE id = ?", commentID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return ErrCommentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if owner != authorID {
+		return ErrNotCommentAuthor
+	}
+
+	if _, err := rs.db.Exec("DELETE FROM check_result_comments WHERE id = ? AND author_id = ?",
+		commentID, authorID); err != nil {
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+
+	return nil
+}
+
+// encodeEvidence returns the evidence and evidence_gzip values to store.
+// Evidence from the threshold up is stored only compressed.
+func encodeEvidence(evidence string) (interface{}, interface{}, error) {
+	if len(evidence) < evidenceCompressionThreshold {
+		return evidence, nil, nil
+	}
+
+	compressed, err := gzipBytes([]byte(evidence))
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, compressed, nil
+}
+
+// clampResultLimit applies the default and maximum result limits
+func clampResultLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultResultLimit
+	}
+	if limit > MaxResultLimit {
+		return MaxResultLimit
+	}
+	return limit
+}
+
+// timestampFormats are the layouts SQLite may hold a DATETIME value in
+var timestampFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339Nano,
+}
+
+// parseTimestamp parses a DATETIME value read back as text
+func parseTimestamp(value string) (time.Time, error) {
+	for _, layout := range timestampFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+}
@@ -0,0 +1,499 @@
+package checker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/catalog"
+	"invictux-demo/internal/device"
+
+	"github.com/google/uuid"
+)
+
+func newTestResult(deviceID, runID string, status CheckStatus, checkedAt time.Time) CheckResult {
+	return CheckResult{
+		ID:             uuid.New().String(),
+		DeviceID:       deviceID,
+		CheckName:      "Check " + string(status),
+		CheckType:      "configuration",
+		Severity:       string(SeverityHigh),
+		Status:         string(status),
+		Message:        "message",
+		Evidence:       "evidence",
+		CheckedAt:      checkedAt,
+		RunID:          runID,
+		CommandVariant: VariantBase,
+		Command:        "show running-config",
+	}
+}
+
+func TestResultStore_SaveAndGetDeviceResults(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	base := time.Now().Add(-time.Hour)
+
+	results := []CheckResult{
+		newTestResult("device1", "run1", StatusPass, base),
+		newTestResult("device1", "run1", StatusFail, base.Add(time.Second)),
+		newTestResult("device2", "run1", StatusPass, base.Add(2*time.Second)),
+	}
+
+	if err := store.SaveResults(results); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+
+	stored, err := store.GetDeviceResults("device1", 0)
+	if err != nil {
+		t.Fatalf("Failed to get device results: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("Expected 2 results for device1, got %d", len(stored))
+	}
+
+	// Newest first
+	if stored[0].Status != string(StatusFail) {
+		t.Errorf("Expected newest result first, got status %s", stored[0].Status)
+	}
+	if stored[0].RunID != "run1" || stored[0].CommandVariant != VariantBase {
+		t.Errorf("Expected run ID and variant to round-trip, got %q and %q", stored[0].RunID, stored[0].CommandVariant)
+	}
+	if stored[0].Command != "show running-config" {
+		t.Errorf("Expected command to round-trip, got %q", stored[0].Command)
+	}
+	if stored[0].Evidence != "evidence" {
+		t.Errorf("Expected evidence to round-trip, got %q", stored[0].Evidence)
+	}
+
+	limited, err := store.GetDeviceResults("device1", 1)
+	if err != nil {
+		t.Fatalf("Failed to get device results: %v", err)
+	}
+	if len(limited) != 1 {
+		t.Errorf("Expected limit to apply, got %d results", len(limited))
+	}
+
+	// Saving nothing is a no-op
+	if err := store.SaveResults(nil); err != nil {
+		t.Errorf("Expected no error saving empty results, got %v", err)
+	}
+}
+
+func TestResultStore_EvidenceCompression(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	large := strings.Repeat("interface GigabitEthernet0/1\n no shutdown\n", 200)
+	small := newTestResult("device1", "run", StatusPass, time.Now())
+	big := newTestResult("device1", "run", StatusFail, time.Now().Add(time.Second))
+	big.Evidence = large
+	empty := newTestResult("device1", "run", StatusError, time.Now().Add(2*time.Second))
+	empty.Evidence = ""
+
+	if err := store.SaveResults([]CheckResult{small, big, empty}); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+
+	// Large evidence is stored only compressed, small evidence only as text
+	var text sql.NullString
+	var compressed []byte
+	if err := db.QueryRow("SELECT evidence, evidence_gzip FROM check_results WHERE id = ?", big.ID).Scan(&text, &compressed); err != nil {
+		t.Fatalf("Failed to read stored evidence: %v", err)
+	}
+	if text.Valid || len(compressed) == 0 || len(compressed) >= len(large)/4 {
+		t.Errorf("Expected large evidence to be stored compressed, got text %v and %d compressed bytes", text.Valid, len(compressed))
+	}
+	if err := db.QueryRow("SELECT evidence, evidence_gzip FROM check_results WHERE id = ?", small.ID).Scan(&text, &compressed); err != nil {
+		t.Fatalf("Failed to read stored evidence: %v", err)
+	}
+	if text.String != "evidence" || compressed != nil {
+		t.Errorf("Expected small evidence to be stored as text, got %q and %d compressed bytes", text.String, len(compressed))
+	}
+
+	stored, err := store.GetDeviceResults("device1", 10)
+	if err != nil {
+		t.Fatalf("Failed to get device results: %v", err)
+	}
+	evidence := make(map[string]string)
+	for _, result := range stored {
+		evidence[result.ID] = result.Evidence
+	}
+	if evidence[big.ID] != large {
+		t.Errorf("Expected large evidence to round-trip, got %d bytes", len(evidence[big.ID]))
+	}
+	if evidence[small.ID] != "evidence" || evidence[empty.ID] != "" {
+		t.Errorf("Expected small evidence to round-trip, got %q and %q", evidence[small.ID], evidence[empty.ID])
+	}
+}
+
+func TestResultStore_GetRecentRuns(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	base := time.Now().Add(-time.Hour)
+
+	results := []CheckResult{
+		// Bulk run over two devices
+		newTestResult("device1", "bulk", StatusPass, base),
+		newTestResult("device2", "bulk", StatusFail, base.Add(time.Second)),
+		newTestResult("device2", "bulk", StatusError, base.Add(2*time.Second)),
+		// Later single-device run
+		newTestResult("device1", "single", StatusWarning, base.Add(time.Minute)),
+		newTestResult("device1", "single", StatusPass, base.Add(time.Minute+time.Second)),
+		// Results without a run are not summarized
+		newTestResult("device3", "", StatusPass, base.Add(2*time.Minute)),
+	}
+
+	if err := store.SaveResults(results); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+
+	runs, err := store.GetRecentRuns(10)
+	if err != nil {
+		t.Fatalf("Failed to get recent runs: %v", err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(runs))
+	}
+
+	single := runs[0]
+	if single.RunID != "single" {
+		t.Fatalf("Expected newest run first, got %s", single.RunID)
+	}
+	if single.DeviceCount != 1 || single.DeviceID != "device1" {
+		t.Errorf("Expected single-device run on device1, got %d devices (%q)", single.DeviceCount, single.DeviceID)
+	}
+	if single.Total != 2 || single.Passed != 1 || single.Warnings != 1 {
+		t.Errorf("Unexpected single run counts: %+v", single)
+	}
+	if !single.FinishedAt.After(single.StartedAt) {
+		t.Errorf("Expected finish after start, got %v and %v", single.StartedAt, single.FinishedAt)
+	}
+
+	bulk := runs[1]
+	if bulk.DeviceCount != 2 || bulk.DeviceID != "" {
+		t.Errorf("Expected bulk run over 2 devices without a device ID, got %d (%q)", bulk.DeviceCount, bulk.DeviceID)
+	}
+	if bulk.Total != 3 || bulk.Passed != 1 || bulk.Failed != 1 || bulk.Errors != 1 {
+		t.Errorf("Unexpected bulk run counts: %+v", bulk)
+	}
+
+	limited, err := store.GetRecentRuns(1)
+	if err != nil {
+		t.Fatalf("Failed to get recent runs: %v", err)
+	}
+	if len(limited) != 1 {
+		t.Errorf("Expected limit to apply, got %d runs", len(limited))
+	}
+}
+
+func TestResultStore_Comments(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	result := newTestResult("device1", "run1", StatusFail, time.Now())
+	if err := store.SaveResults([]CheckResult{result}); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+
+	bodies := []string{
+		"Investigated on 2025-01-15",
+		"Remediation planned for Q2",
+		"Change request filed",
+	}
+	var added []*CheckComment
+	for i, body := range bodies {
+		author := "alice"
+		if i == 1 {
+			author = "bob"
+		}
+		comment, err := store.AddComment(result.ID, author, body)
+		if err != nil {
+			t.Fatalf("Failed to add comment: %v", err)
+		}
+		added = append(added, comment)
+	}
+
+	comments, err := store.GetComments(result.ID)
+	if err != nil {
+		t.Fatalf("Failed to get comments: %v", err)
+	}
+	if len(comments) != len(bodies) {
+		t.Fatalf("Expected %d comments, got %d", len(bodies), len(comments))
+	}
+	for i, comment := range comments {
+		if comment.Body != bodies[i] {
+			t.Errorf("Expected comment %d to be %q, got %q", i, bodies[i], comment.Body)
+		}
+		if comment.CheckResultID != result.ID {
+			t.Errorf("Expected comment on result %s, got %s", result.ID, comment.CheckResultID)
+		}
+	}
+
+	// Another user cannot delete alice's comment
+	if err := store.DeleteComment(added[0].ID, "bob"); err != ErrNotCommentAuthor {
+		t.Errorf("Expected ErrNotCommentAuthor, got %v", err)
+	}
+
+	if err := store.DeleteComment(added[0].ID, "alice"); err != nil {
+		t.Fatalf("Failed to delete own comment: %v", err)
+	}
+	if err := store.DeleteComment(added[0].ID, "alice"); err != ErrCommentNotFound {
+		t.Errorf("Expected ErrCommentNotFound, got %v", err)
+	}
+
+	comments, err = store.GetComments(result.ID)
+	if err != nil {
+		t.Fatalf("Failed to get comments: %v", err)
+	}
+	if len(comments) != 2 || comments[0].Body != bodies[1] {
+		t.Errorf("Expected the two remaining comments in order, got %+v", comments)
+	}
+
+	// Comments need a body and an existing result
+	if _, err := store.AddComment(result.ID, "alice", "   "); err == nil {
+		t.Error("Expected error for empty comment body")
+	}
+	if _, err := store.AddComment("missing", "alice", "note"); err == nil {
+		t.Error("Expected error for unknown check result")
+	}
+}
+
+func TestResultStore_RerenderStoredMessages(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &stubSSHClient{outputs: map[string]string{"show ip ssh": "SSH Enabled - version 1.99"}}
+	engine := NewEngineWithSSHClient(rm, client)
+	engine.SetLocale("es")
+
+	err := engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "SSH v2", Vendor: "generic", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+
+	dev := &device.Device{ID: "d1", Name: "One", IPAddress: "192.168.1.50", DeviceType: string(device.TypeRouter),
+		Vendor: "cisco", Username: "admin", SSHPort: 22}
+	results, err := engine.RunChecks(dev)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Expected one result, got %d (%v)", len(results), err)
+	}
+
+	spanish := "La configuración no coincide con el patrón esperado: version 2"
+	if results[0].Message != spanish {
+		t.Errorf("Expected message rendered in the engine locale, got %q", results[0].Message)
+	}
+	if results[0].MessageID != catalog.MsgPatternMismatch || results[0].MessageParams["pattern"] != "version 2" {
+		t.Errorf("Expected message ID and parameters, got %q %v", results[0].MessageID, results[0].MessageParams)
+	}
+
+	// A legacy result without an ID keeps its stored text
+	legacy := newTestResult("d1", "", StatusPass, time.Now().Add(-time.Hour))
+
+	store := NewResultStore(rm.db)
+	if err := store.SaveResults(append(results, legacy)); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+
+	stored, err := store.GetDeviceResults("d1", 0)
+	if err != nil || len(stored) != 2 {
+		t.Fatalf("Expected two stored results, got %d (%v)", len(stored), err)
+	}
+
+	if got := stored[0].RenderMessage("en"); got != "Configuration does not match expected pattern: version 2" {
+		t.Errorf("Expected stored result to re-render in English, got %q", got)
+	}
+	if got := stored[0].RenderMessage("es"); got != spanish {
+		t.Errorf("Expected stored result to re-render in Spanish, got %q", got)
+	}
+	if got := stored[1].RenderMessage("es"); got != "message" {
+		t.Errorf("Expected legacy result to keep its message, got %q", got)
+	}
+}
+
+func TestResultStore_CheckDurations(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	base := time.Now().Add(-time.Hour)
+
+	slow := newTestResult("device1", "run1", StatusPass, base)
+	slow.CheckName = "Slow check"
+	slow.Duration = 1500 * time.Millisecond
+	slowAgain := newTestResult("device2", "run1", StatusPass, base.Add(time.Second))
+	slowAgain.CheckName = "Slow check"
+	slowAgain.Duration = 2500 * time.Millisecond
+	fast := newTestResult("device1", "run1", StatusFail, base.Add(2*time.Second))
+	fast.CheckName = "Fast check"
+	fast.Duration = 40 * time.Millisecond
+	unmeasured := newTestResult("device1", "run1", StatusFail, base.Add(3*time.Second))
+	unmeasured.CheckName = "Old check"
+
+	if err := store.SaveResults([]CheckResult{slow, slowAgain, fast, unmeasured}); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+
+	stored, err := store.GetDeviceResults("device2", 0)
+	if err != nil {
+		t.Fatalf("Failed to get device results: %v", err)
+	}
+	if len(stored) != 1 || stored[0].Duration != 2500*time.Millisecond {
+		t.Errorf("Expected the stored duration to be 2.5s, got %+v", stored)
+	}
+
+	runs, err := store.GetRecentRuns(0)
+	if err != nil {
+		t.Fatalf("Failed to get runs: %v", err)
+	}
+	if len(runs) != 1 || runs[0].CheckDurationMs != 4040 {
+		t.Errorf("Expected the run to add up 4040ms of checks, got %+v", runs)
+	}
+
+	slowest, err := store.GetSlowestChecks(0)
+	if err != nil {
+		t.Fatalf("Failed to get slowest checks: %v", err)
+	}
+	expected := []CheckDuration{
+		{CheckName: "Slow check", Runs: 2, AverageMs: 2000, MaxMs: 2500},
+		{CheckName: "Fast check", Runs: 1, AverageMs: 40, MaxMs: 40},
+	}
+	if len(slowest) != len(expected) {
+		t.Fatalf("Expected %d checks, got %+v", len(expected), slowest)
+	}
+	for i := range expected {
+		if slowest[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], slowest[i])
+		}
+	}
+}
+
+func TestResultStore_StatusTransitionHook(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	base := time.Now().Add(-time.Hour)
+	result := func(deviceID, checkName string, status CheckStatus, offset time.Duration) CheckResult {
+		r := newTestResult(deviceID, "run", status, base.Add(offset))
+		r.CheckName = checkName
+		return r
+	}
+
+	var transitions []string
+	store.SetStatusTransitionHook(func(deviceID, rule string, old, new CheckStatus) {
+		transitions = append(transitions, deviceID+"/"+rule+": "+string(old)+" -> "+string(new))
+	})
+
+	// Nothing stored yet, so nothing transitions
+	if err := store.SaveResults([]CheckResult{
+		result("device1", "SSH Version", StatusPass, 0),
+		result("device1", "Telnet Disabled", StatusFail, 0),
+		result("device2", "SSH Version", StatusPass, 0),
+	}); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+	if len(transitions) != 0 {
+		t.Fatalf("Expected no transitions for first results, got %v", transitions)
+	}
+
+	if err := store.SaveResults([]CheckResult{
+		result("device1", "SSH Version", StatusFail, time.Minute),
+		result("device1", "Telnet Disabled", StatusFail, time.Minute),
+		result("device2", "SSH Version", StatusPass, time.Minute),
+		result("device2", "SSH Version", StatusError, time.Minute+time.Second),
+	}); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+	want := []string{
+		"device1/SSH Version: PASS -> FAIL",
+		"device2/SSH Version: PASS -> ERROR",
+	}
+	if strings.Join(transitions, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected transitions %v, got %v", want, transitions)
+	}
+
+	// The hook is told once the results are stored
+	transitions = nil
+	store.SetStatusTransitionHook(func(deviceID, rule string, old, new CheckStatus) {
+		stored, err := store.GetDeviceResults(deviceID, 1)
+		if err != nil || len(stored) != 1 || CheckStatus(stored[0].Status) != new {
+			t.Errorf("Expected the new result to be stored before the hook runs, got %v, %v", stored, err)
+		}
+		transitions = append(transitions, rule)
+	})
+	if err := store.SaveResults([]CheckResult{result("device1", "SSH Version", StatusPass, 2*time.Minute)}); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+	if len(transitions) != 1 {
+		t.Errorf("Expected one transition back to PASS, got %v", transitions)
+	}
+}
+
+func TestResultStore_StatusTransitionsSkipNotApplicable(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	base := time.Now().Add(-time.Hour)
+	var transitions []string
+	store.SetStatusTransitionHook(func(deviceID, rule string, old, new CheckStatus) {
+		transitions = append(transitions, string(old)+" -> "+string(new))
+	})
+	save := func(status CheckStatus, offset time.Duration) {
+		t.Helper()
+		r := newTestResult("device1", "run", status, base.Add(offset))
+		r.CheckName = "SSH Timeout"
+		if err := store.SaveResults([]CheckResult{r}); err != nil {
+			t.Fatalf("Failed to save results: %v", err)
+		}
+	}
+
+	// Skipping a check for a failed prerequisite does not change it, so
+	// failing again afterwards is no transition either
+	save(StatusFail, 0)
+	save(StatusNotApplicable, time.Minute)
+	save(StatusFail, 2*time.Minute)
+	if len(transitions) != 0 {
+		t.Fatalf("Expected no transitions around a not applicable result, got %v", transitions)
+	}
+
+	// Coming back evaluated compares with the last evaluated result
+	save(StatusNotApplicable, 3*time.Minute)
+	save(StatusPass, 4*time.Minute)
+	if want := []string{"FAIL -> PASS"}; strings.Join(transitions, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected transitions %v, got %v", want, transitions)
+	}
+}
+
+func TestCheckResult_DurationJSON(t *testing.T) {
+	result := newTestResult("device1", "run1", StatusPass, time.Now())
+	result.Duration = 1250 * time.Millisecond
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Failed to encode result: %v", err)
+	}
+	if !strings.Contains(string(data), `"durationMs":1250`) {
+		t.Errorf("Expected the duration in milliseconds, got %s", data)
+	}
+	if !strings.Contains(string(data), `"checkName":"Check PASS"`) {
+		t.Errorf("Expected the other fields to be kept, got %s", data)
+	}
+
+	var decoded CheckResult
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	if decoded.Duration != result.Duration || decoded.ID != result.ID {
+		t.Errorf("Expected the result to round-trip, got %+v", decoded)
+	}
+}
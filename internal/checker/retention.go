@@ -0,0 +1,189 @@
+package checker
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// protectedRuns selects the runs retention never touches: the latest run
+// of each device, which holds its current state, and the runs incremental
+// checks carry results forward from
+const protectedRuns = `
+	SELECT r.run_id FROM check_results r
+	WHERE r.run_id IS NOT NULL
+		AND r.checked_at = (SELECT MAX(checked_at) FROM check_results WHERE device_id = r.device_id)
+	UNION
+	SELECT run_id FROM device_change_indicators
+`
+
+// TrimEvidence drops the evidence of results outside the protected runs,
+// deleting the full output files they point to, and returns the number of
+// results trimmed. The results themselves are kept.
+func (rs *ResultStore) TrimEvidence() (int, error) {
+	tx, err := rs.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	where := `(evidence IS NOT NULL OR evidence_gzip IS NOT NULL OR evidence_full_path IS NOT NULL)
+		AND (run_id IS NULL OR run_id NOT IN (` + protectedRuns + `))`
+	paths, err := evidencePaths(tx, where)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(`UPDATE check_results SET evidence = NULL, evidence_gzip = NULL, evidence_full_path = NULL
+		WHERE ` + where)
+	if err != nil {
+		return 0, fmt.Errorf("failed to trim evidence: %w", err)
+	}
+	trimmed, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	removeEvidenceFiles(paths)
+	return int(trimmed), nil
+}
+
+// DeleteOldestRuns deletes the results and metadata of up to limit of the
+// oldest runs outside the protected runs, with their full output files, in
+// one transaction. It returns the IDs deleted, oldest first, which are none
+// when no run can be deleted.
+func (rs *ResultStore) DeleteOldestRuns(limit int) ([]string, error) {
+	tx, err := rs.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT run_id FROM check_results
+		WHERE run_id IS NOT NULL AND run_id NOT IN (`+protectedRuns+`)
+		GROUP BY run_id
+		ORDER BY MIN(checked_at), run_id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find oldest runs: %w", err)
+	}
+	var runIDs []string
+	for rows.Next() {
+		var runID string
+		if err := rows.Scan(&runID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		runIDs = append(runIDs, runID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find oldest runs: %w", err)
+	}
+
+	var paths []string
+	for _, runID := range runIDs {
+		runPaths, err := evidencePaths(tx, "run_id = ?", runID)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, runPaths...)
+		if _, err := tx.Exec("DELETE FROM check_results WHERE run_id = ?", runID); err != nil {
+			return nil, fmt.Errorf("failed to delete results of run %s: %w", runID, err)
+		}
+		if _, err := tx.Exec("DELETE FROM check_runs WHERE run_id = ?", runID); err != nil {
+			return nil, fmt.Errorf("failed to delete run %s: %w", runID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	removeEvidenceFiles(paths)
+	return runIDs, nil
+}
+
+// evidencePaths returns the full output files of the results matching where
+func evidencePaths(tx *sql.Tx, where string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(`SELECT evidence_full_path FROM check_results
+		WHERE evidence_full_path IS NOT NULL AND `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find evidence files: %w", err)
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// removeEvidenceFiles deletes full output files once no result points to
+// them. Files already gone are ignored and other failures only logged, as
+// the results no longer refer to them.
+func removeEvidenceFiles(paths []string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove evidence file %s: %v", path, err)
+		}
+	}
+}
+
+// DiscardFullEvidence deletes the full output files of results that will
+// not be saved, which nothing else would remove
+func DiscardFullEvidence(results []CheckResult) {
+	var paths []string
+	for _, result := range results {
+		if result.EvidenceFullPath != "" {
+			paths = append(paths, result.EvidenceFullPath)
+		}
+	}
+	removeEvidenceFiles(paths)
+}
+
+// RemoveOrphanedEvidence deletes the full output files in dir that no
+// stored result points to, left behind by runs that were never saved, and
+// returns the number deleted. It must not be called while checks are
+// running, as their files are not saved yet.
+func (rs *ResultStore) RemoveOrphanedEvidence(dir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil || len(files) == 0 {
+		return 0, err
+	}
+
+	rows, err := rs.db.Query(`SELECT evidence_full_path FROM check_results WHERE evidence_full_path IS NOT NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find evidence files: %w", err)
+	}
+	defer rows.Close()
+
+	referenced := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return 0, err
+		}
+		referenced[filepath.Clean(path)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var orphans []string
+	for _, file := range files {
+		if !referenced[filepath.Clean(file)] {
+			orphans = append(orphans, file)
+		}
+	}
+	removeEvidenceFiles(orphans)
+	return len(orphans), nil
+}
@@ -0,0 +1,164 @@
+package checker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seedRetentionRuns saves four runs of device1, the oldest first, each with
+// an evidence file, and records the second as the run incremental checks
+// carry forward from. It returns the evidence file of each run.
+func seedRetentionRuns(t *testing.T, store *ResultStore) map[string]string {
+	t.Helper()
+	dir := t.TempDir()
+	base := time.Now().Add(-96 * time.Hour)
+
+	files := make(map[string]string)
+	for i, runID := range []string{"run1", "run2", "run3", "run4"} {
+		result := newTestResult("device1", runID, StatusPass, base.Add(time.Duration(i)*24*time.Hour))
+		result.Evidence = strings.Repeat("x", 2*evidenceCompressionThreshold)
+		result.EvidenceFullPath = filepath.Join(dir, result.ID+".txt")
+		if err := os.WriteFile(result.EvidenceFullPath, []byte("full output"), 0600); err != nil {
+			t.Fatalf("Failed to write evidence file: %v", err)
+		}
+		files[runID] = result.EvidenceFullPath
+		if err := store.SaveResults([]CheckResult{result}); err != nil {
+			t.Fatalf("Failed to save results: %v", err)
+		}
+		if err := store.SaveRunMetadata(runID, "run "+runID, ""); err != nil {
+			t.Fatalf("Failed to save metadata: %v", err)
+		}
+	}
+	if _, err := store.db.Exec(`INSERT INTO device_change_indicators (device_id, run_id, indicator)
+		VALUES ('device1', 'run2', 'abc')`); err != nil {
+		t.Fatalf("Failed to record change indicator: %v", err)
+	}
+	return files
+}
+
+func TestResultStore_TrimEvidence(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	store := NewResultStore(db)
+	files := seedRetentionRuns(t, store)
+
+	trimmed, err := store.TrimEvidence()
+	if err != nil {
+		t.Fatalf("Failed to trim evidence: %v", err)
+	}
+	if trimmed != 2 {
+		t.Errorf("Expected the evidence of two runs to be trimmed, got %d", trimmed)
+	}
+
+	for runID, kept := range map[string]bool{"run1": false, "run2": true, "run3": false, "run4": true} {
+		results, err := store.GetRunResults(runID, "device1")
+		if err != nil || len(results) != 1 {
+			t.Fatalf("Expected run %s to keep its result, got %v, %v", runID, results, err)
+		}
+		if hasEvidence := results[0].Evidence != "" && results[0].EvidenceFullPath != ""; hasEvidence != kept {
+			t.Errorf("Run %s: expected evidence kept %v, got %+v", runID, kept, results[0])
+		}
+		if _, err := os.Stat(files[runID]); os.IsNotExist(err) == kept {
+			t.Errorf("Run %s: expected evidence file kept %v", runID, kept)
+		}
+	}
+
+	if trimmed, err := store.TrimEvidence(); err != nil || trimmed != 0 {
+		t.Errorf("Expected nothing left to trim, got %d, %v", trimmed, err)
+	}
+}
+
+func TestResultStore_DeleteOldestRuns(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	store := NewResultStore(db)
+	files := seedRetentionRuns(t, store)
+
+	// The latest run and the carried-forward run are never deleted
+	for _, want := range []string{"run1", "run3", ""} {
+		runIDs, err := store.DeleteOldestRuns(1)
+		if err != nil {
+			t.Fatalf("Failed to delete oldest run: %v", err)
+		}
+		if strings.Join(runIDs, ",") != want {
+			t.Fatalf("Expected runs %q to be deleted, got %v", want, runIDs)
+		}
+	}
+
+	runs, err := store.GetRecentRuns(10)
+	if err != nil {
+		t.Fatalf("Failed to get recent runs: %v", err)
+	}
+	var remaining []string
+	for _, run := range runs {
+		remaining = append(remaining, run.RunID)
+	}
+	if strings.Join(remaining, ",") != "run4,run2" {
+		t.Errorf("Expected run4 and run2 to remain, got %v", remaining)
+	}
+
+	for runID, kept := range map[string]bool{"run1": false, "run2": true, "run3": false, "run4": true} {
+		if _, err := store.GetRunMetadata(runID); (err == nil) != kept {
+			t.Errorf("Run %s: expected metadata kept %v, got %v", runID, kept, err)
+		}
+		if _, err := os.Stat(files[runID]); os.IsNotExist(err) == kept {
+			t.Errorf("Run %s: expected evidence file kept %v", runID, kept)
+		}
+	}
+}
+
+func TestResultStore_DeleteOldestRunsBatch(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	store := NewResultStore(db)
+	seedRetentionRuns(t, store)
+
+	runIDs, err := store.DeleteOldestRuns(10)
+	if err != nil {
+		t.Fatalf("Failed to delete oldest runs: %v", err)
+	}
+	if strings.Join(runIDs, ",") != "run1,run3" {
+		t.Errorf("Expected run1 and run3 to be deleted in one batch, got %v", runIDs)
+	}
+}
+
+func TestResultStore_RemoveOrphanedEvidence(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	store := NewResultStore(db)
+	files := seedRetentionRuns(t, store)
+	dir := filepath.Dir(files["run1"])
+
+	orphan := filepath.Join(dir, "unsaved.txt")
+	if err := os.WriteFile(orphan, []byte("full output"), 0600); err != nil {
+		t.Fatalf("Failed to write evidence file: %v", err)
+	}
+
+	removed, err := store.RemoveOrphanedEvidence(dir)
+	if err != nil {
+		t.Fatalf("Failed to remove orphaned evidence: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected one orphaned file removed, got %d", removed)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("Expected the orphaned file to be removed")
+	}
+	for runID, path := range files {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Run %s: expected evidence file kept, got %v", runID, err)
+		}
+	}
+
+	unsaved := CheckResult{ID: "unsaved", EvidenceFullPath: orphan}
+	if err := os.WriteFile(orphan, []byte("full output"), 0600); err != nil {
+		t.Fatalf("Failed to write evidence file: %v", err)
+	}
+	DiscardFullEvidence([]CheckResult{unsaved, {ID: "no-file"}})
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("Expected the discarded result's file to be removed")
+	}
+}
@@ -488,6 +488,88 @@ func TestRuleEvaluationEdgeCases(t *testing.T) {
 	}
 }
 
+func TestEngine_EvaluateAllMatchSections(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	engine := NewEngine(rm)
+
+	vtyRule := SecurityRule{
+		Name:            "Check Telnet VTY Lines",
+		ExpectedPattern: `(?m)transport input (ssh|none)\s*$`,
+		AllMatch:        true,
+		SectionPattern:  `^line vty`,
+	}
+
+	tests := []struct {
+		name           string
+		output         string
+		rule           SecurityRule
+		expectedStatus CheckStatus
+		expectedMsg    string
+	}{
+		{
+			name: "All VTY Blocks Secure",
+			output: "line vty 0 4\n login local\n transport input ssh\n" +
+				"line vty 5 15\n login local\n transport input none\n",
+			rule:           vtyRule,
+			expectedStatus: StatusPass,
+			expectedMsg:    "All 2 sections passed",
+		},
+		{
+			name: "One VTY Block Allows Telnet",
+			output: "line vty 0 4\n login local\n transport input ssh\n" +
+				"line vty 5 15\n login local\n transport input telnet ssh\n",
+			rule:           vtyRule,
+			expectedStatus: StatusFail,
+			expectedMsg:    "1 of 2 sections do not match expected pattern (?m)transport input (ssh|none)\\s*$: line vty 5 15",
+		},
+		{
+			name:           "One VTY Block Missing Transport",
+			output:         "line vty 0 4\r\n transport input ssh\r\nline vty 5 15\r\n login local\r\n",
+			rule:           vtyRule,
+			expectedStatus: StatusFail,
+			expectedMsg:    "1 of 2 sections do not match expected pattern (?m)transport input (ssh|none)\\s*$: line vty 5 15",
+		},
+		{
+			name:           "Lines Before First Section Are Ignored",
+			output:         "Building configuration...\ntransport input telnet\nline vty 0 15\n transport input ssh",
+			rule:           vtyRule,
+			expectedStatus: StatusPass,
+			expectedMsg:    "All 1 sections passed",
+		},
+		{
+			name:           "No Sections",
+			output:         "line con 0\n login local",
+			rule:           vtyRule,
+			expectedStatus: StatusWarning,
+			expectedMsg:    "No sections match section pattern: ^line vty",
+		},
+		{
+			name:   "Missing Section Pattern",
+			output: "line vty 0 4\n transport input ssh",
+			rule: SecurityRule{
+				ExpectedPattern: `transport input ssh`,
+				AllMatch:        true,
+			},
+			expectedStatus: StatusError,
+			expectedMsg:    "All-match rule has no section pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, msg := engine.evaluateRuleResult(tt.output, tt.rule)
+
+			if status != tt.expectedStatus {
+				t.Errorf("Expected status %s, got %s", tt.expectedStatus, status)
+			}
+
+			if msg != tt.expectedMsg {
+				t.Errorf("Expected message %q, got %q", tt.expectedMsg, msg)
+			}
+		})
+	}
+}
+
 // generateLongString creates a string of specified length for testing
 func generateLongString(length int) string {
 	result := make([]byte, length)
@@ -505,22 +587,9 @@ func BenchmarkRuleEvaluation(b *testing.B) {
 	}
 	defer db.Close()
 
-	// Create table
-	createTableSQL := `
-		CREATE TABLE security_rules (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			description TEXT,
-			vendor TEXT NOT NULL,
-			command TEXT NOT NULL,
-			expected_pattern TEXT,
-			severity TEXT NOT NULL,
-			enabled BOOLEAN DEFAULT TRUE,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-	`
-	if _, err := db.Exec(createTableSQL); err != nil {
-		b.Fatalf("Failed to create test table: %v", err)
+	// Create tables
+	if _, err := db.Exec(testRulesSchema); err != nil {
+		b.Fatalf("Failed to create test tables: %v", err)
 	}
 
 	rm := NewRuleManager(db)
@@ -547,22 +616,9 @@ func BenchmarkComplexRegexEvaluation(b *testing.B) {
 	}
 	defer db.Close()
 
-	// Create table
-	createTableSQL := `
-		CREATE TABLE security_rules (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			description TEXT,
-			vendor TEXT NOT NULL,
-			command TEXT NOT NULL,
-			expected_pattern TEXT,
-			severity TEXT NOT NULL,
-			enabled BOOLEAN DEFAULT TRUE,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-	`
-	if _, err := db.Exec(createTableSQL); err != nil {
-		b.Fatalf("Failed to create test table: %v", err)
+	// Create tables
+	if _, err := db.Exec(testRulesSchema); err != nil {
+		b.Fatalf("Failed to create test tables: %v", err)
 	}
 
 	rm := NewRuleManager(db)
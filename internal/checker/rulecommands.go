@@ -0,0 +1,117 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrEmptyRuleCommand is returned when a rule has no command to run
+var ErrEmptyRuleCommand = errors.New("rule command cannot be empty")
+
+// CommandWarning flags a rule command that looks like it changes the device.
+// Variant uses the CheckResult.CommandVariant labels, VariantPrecondition or
+// VariantMacro.
+type CommandWarning struct {
+	Command string `json:"command"`
+	Variant string `json:"variant"`
+	Keyword string `json:"keyword"`
+	Reason  string `json:"reason"`
+}
+
+// VariantPrecondition labels warnings about a rule's precondition command,
+// and VariantMacro, followed by the macro name, those about a macro
+const (
+	VariantPrecondition = "precondition"
+	VariantMacro        = "macro:"
+)
+
+// riskyCommandPatterns match the start of a command line that writes,
+// reloads or deletes rather than reads
+var riskyCommandPatterns = []struct {
+	pattern *regexp.Regexp
+	reason  string
+}{
+	{regexp.MustCompile(`(?i)^conf(igure)?\b`), "enters configuration mode"},
+	{regexp.MustCompile(`(?i)^wr(ite)?\b`), "writes the configuration"},
+	{regexp.MustCompile(`(?i)^copy\b.*\bstartup`), "overwrites the startup configuration"},
+	{regexp.MustCompile(`(?i)^reload\b`), "reboots the device"},
+	{regexp.MustCompile(`(?i)^request\s+system\s+(reboot|halt|power-off)\b`), "reboots the device"},
+	{regexp.MustCompile(`(?i)^execute\s+(reboot|shutdown|factoryreset)\b`), "reboots or resets the device"},
+	{regexp.MustCompile(`(?i)^delete\b`), "deletes files or configuration"},
+	{regexp.MustCompile(`(?i)^erase\b`), "erases storage"},
+	{regexp.MustCompile(`(?i)^format\b`), "formats storage"},
+}
+
+// ValidateRuleCommands checks a rule's commands at authoring time. A rule
+// without a base command is rejected; commands that look like writes are
+// returned as warnings for the author to confirm. Macro references are
+// expanded with macros first, so the commands checked are those sent to
+// devices, and a reference to a macro not in macros is an error.
+func ValidateRuleCommands(rule SecurityRule, macros map[string]string) ([]CommandWarning, error) {
+	if strings.TrimSpace(rule.Command) == "" {
+		return nil, ErrEmptyRuleCommand
+	}
+
+	var warnings []CommandWarning
+	check := func(command, variant string) error {
+		expanded, err := ExpandMacros(command, macros)
+		if err != nil {
+			return fmt.Errorf("%s command: %w", variant, err)
+		}
+		warnings = append(warnings, commandWarnings(expanded, variant)...)
+		return nil
+	}
+
+	if err := check(rule.Command, VariantBase); err != nil {
+		return nil, err
+	}
+
+	deviceTypes := make([]string, 0, len(rule.CommandOverrides))
+	for deviceType := range rule.CommandOverrides {
+		deviceTypes = append(deviceTypes, deviceType)
+	}
+	sort.Strings(deviceTypes)
+	for _, deviceType := range deviceTypes {
+		if err := check(rule.CommandOverrides[deviceType], VariantDeviceType+deviceType); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, override := range rule.VendorOverrides {
+		if err := check(override.Command, VariantVendor+override.Vendor); err != nil {
+			return nil, err
+		}
+	}
+
+	if rule.Precondition != nil {
+		if err := check(rule.Precondition.Command, VariantPrecondition); err != nil {
+			return nil, err
+		}
+	}
+
+	return warnings, nil
+}
+
+// commandWarnings checks every line of a command, since a rule command may
+// hold several lines or ;-separated commands
+func commandWarnings(command, variant string) []CommandWarning {
+	var warnings []CommandWarning
+	for _, line := range strings.FieldsFunc(command, func(r rune) bool { return r == '\n' || r == ';' }) {
+		line = strings.TrimSpace(line)
+		for _, risky := range riskyCommandPatterns {
+			if keyword := risky.pattern.FindString(line); keyword != "" {
+				warnings = append(warnings, CommandWarning{
+					Command: line,
+					Variant: variant,
+					Keyword: keyword,
+					Reason:  risky.reason,
+				})
+				break
+			}
+		}
+	}
+	return warnings
+}
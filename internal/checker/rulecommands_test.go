@@ -0,0 +1,93 @@
+package checker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRuleCommands(t *testing.T) {
+	t.Run("empty command", func(t *testing.T) {
+		_, err := ValidateRuleCommands(SecurityRule{Name: "Empty", Command: "  "}, nil)
+		assert.ErrorIs(t, err, ErrEmptyRuleCommand)
+	})
+
+	t.Run("read-only commands", func(t *testing.T) {
+		for _, command := range []string{
+			"show running-config | include write-memory",
+			"show configuration | display set",
+			"display current-configuration",
+			"/export",
+			"show version\nshow clock",
+		} {
+			warnings, err := ValidateRuleCommands(SecurityRule{Command: command}, nil)
+			require.NoError(t, err)
+			assert.Empty(t, warnings, command)
+		}
+	})
+
+	t.Run("write verbs", func(t *testing.T) {
+		tests := []struct {
+			command string
+			keyword string
+		}{
+			{"conf t", "conf"},
+			{"configure terminal", "configure"},
+			{"write memory", "write"},
+			{"wr", "wr"},
+			{"reload in 5", "reload"},
+			{"delete flash:config.old", "delete"},
+			{"erase startup-config", "erase"},
+			{"copy running-config startup-config", "copy running-config startup"},
+			{"request system reboot", "request system reboot"},
+			{"  WRITE ERASE", "WRITE"},
+		}
+		for _, tt := range tests {
+			warnings, err := ValidateRuleCommands(SecurityRule{Command: tt.command}, nil)
+			require.NoError(t, err)
+			require.Len(t, warnings, 1, tt.command)
+			assert.Equal(t, tt.keyword, warnings[0].Keyword)
+			assert.Equal(t, VariantBase, warnings[0].Variant)
+			assert.NotEmpty(t, warnings[0].Reason)
+		}
+	})
+
+	t.Run("every line and variant is checked", func(t *testing.T) {
+		warnings, err := ValidateRuleCommands(SecurityRule{
+			Command:          "show version; reload",
+			CommandOverrides: map[string]string{"firewall": "get system status\nexecute reboot", "switch": "write mem"},
+			VendorOverrides:  []VendorOverride{{Vendor: "juniper", Command: "request system reboot"}},
+		}, nil)
+		require.NoError(t, err)
+		require.Len(t, warnings, 4)
+		assert.Equal(t, CommandWarning{Command: "reload", Variant: VariantBase, Keyword: "reload",
+			Reason: "reboots the device"}, warnings[0])
+		assert.Equal(t, CommandWarning{Command: "execute reboot", Variant: VariantDeviceType + "firewall",
+			Keyword: "execute reboot", Reason: "reboots or resets the device"}, warnings[1])
+		assert.Equal(t, VariantDeviceType+"switch", warnings[2].Variant)
+		assert.Equal(t, VariantVendor+"juniper", warnings[3].Variant)
+	})
+
+	t.Run("predefined rules are read-only", func(t *testing.T) {
+		for _, rule := range GetPredefinedRules() {
+			warnings, err := ValidateRuleCommands(rule, nil)
+			require.NoError(t, err, rule.Name)
+			assert.Empty(t, warnings, rule.Name)
+		}
+	})
+}
+
+func TestRuleManager_RejectsEmptyCommand(t *testing.T) {
+	rm := setupTestRuleManager(t)
+
+	err := rm.CreateRule(SecurityRule{Name: "Empty", Vendor: "cisco", Severity: string(SeverityLow), Enabled: true})
+	assert.ErrorIs(t, err, ErrEmptyRuleCommand)
+
+	require.NoError(t, rm.CreateRule(SecurityRule{ID: "r1", Name: "Version", Vendor: "cisco", Command: "show version",
+		Severity: string(SeverityLow), Enabled: true}))
+	rule, err := rm.GetRule("r1")
+	require.NoError(t, err)
+	rule.Command = ""
+	assert.ErrorIs(t, rm.UpdateRule(*rule), ErrEmptyRuleCommand)
+}
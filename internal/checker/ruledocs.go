@@ -0,0 +1,41 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RuleIssueMissingRationale is reported by CheckRuleDocumentation for an
+// enabled rule that does not explain why it matters
+const RuleIssueMissingRationale = "missing_rationale"
+
+// encodeReferences serializes a rule's references for storage, dropping
+// blank entries and using NULL when none are left
+func encodeReferences(references []string) (interface{}, error) {
+	kept := make([]string, 0, len(references))
+	for _, reference := range references {
+		if reference = strings.TrimSpace(reference); reference != "" {
+			kept = append(kept, reference)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode references: %w", err)
+	}
+	return string(data), nil
+}
+
+// CheckRuleDocumentation returns the gaps in an enabled rule's
+// documentation. Unlike CheckRuleHealth these do not keep the rule from
+// running; they only leave auditors without an answer to why it matters.
+func CheckRuleDocumentation(rule SecurityRule) []RuleIssue {
+	if !rule.Enabled || strings.TrimSpace(rule.Rationale) != "" {
+		return nil
+	}
+	return []RuleIssue{{Kind: RuleIssueMissingRationale, Detail: "enabled rule has no rationale"}}
+}
@@ -0,0 +1,111 @@
+package checker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleManager_RuleDocumentation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	rm := NewRuleManager(db)
+
+	rule := SecurityRule{ID: "ssh", Name: "SSH v2", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+		Severity: string(SeverityHigh), Enabled: true, Rationale: "  SSH v1 is broken.  ",
+		References: []string{"https://example.com/ssh", " ", "NIST SP 800-53 Rev. 5 AC-17: Remote Access"}}
+	require.NoError(t, rm.CreateRule(rule))
+
+	stored, err := rm.GetRule(rule.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "SSH v1 is broken.", stored.Rationale)
+	assert.Equal(t, []string{"https://example.com/ssh", "NIST SP 800-53 Rev. 5 AC-17: Remote Access"}, stored.References,
+		"blank references are dropped")
+
+	// The fields survive the JSON rules are exchanged in
+	data, err := json.Marshal(stored)
+	require.NoError(t, err)
+	var decoded SecurityRule
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, stored.Rationale, decoded.Rationale)
+	assert.Equal(t, stored.References, decoded.References)
+
+	stored.Rationale = ""
+	stored.References = nil
+	require.NoError(t, rm.UpdateRule(*stored))
+	stored, err = rm.GetRule(rule.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Rationale)
+	assert.Nil(t, stored.References)
+
+	cloned, err := rm.CloneRule(rule.ID, "arista")
+	require.NoError(t, err)
+	assert.Empty(t, cloned.References)
+}
+
+func TestGetPredefinedRules_Documented(t *testing.T) {
+	for _, rule := range GetPredefinedRules() {
+		assert.NotEmpty(t, rule.Rationale, rule.Name)
+		assert.NotEmpty(t, rule.References, rule.Name)
+		assert.Empty(t, CheckRuleDocumentation(rule), rule.Name)
+	}
+}
+
+func TestRuleManager_LoadPredefinedRulesSetsDocumentation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	rm := NewRuleManager(db)
+
+	predefined := GetPredefinedRules()[0]
+	stored := predefined
+	stored.Rationale, stored.References = "", nil
+	require.NoError(t, rm.CreateRule(stored))
+
+	require.NoError(t, rm.loadPredefinedRules([]SecurityRule{predefined}))
+	loaded, err := rm.GetRule(predefined.ID)
+	require.NoError(t, err)
+	assert.Equal(t, predefined.Rationale, loaded.Rationale)
+	assert.Equal(t, predefined.References, loaded.References)
+
+	// A rationale the user wrote is kept
+	loaded.Rationale = "Our own policy"
+	loaded.References = nil
+	require.NoError(t, rm.UpdateRule(*loaded))
+	require.NoError(t, rm.loadPredefinedRules([]SecurityRule{predefined}))
+	loaded, err = rm.GetRule(predefined.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Our own policy", loaded.Rationale)
+}
+
+func TestRuleManager_ValidateAllRulesWarnsOnMissingRationale(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	rm := NewRuleManager(db)
+
+	documented := SecurityRule{ID: "documented", Name: "Documented", Vendor: "cisco", Command: "show ip ssh",
+		ExpectedPattern: "version 2", Severity: string(SeverityHigh), Enabled: true, Rationale: "SSH v1 is broken."}
+	undocumented := SecurityRule{ID: "undocumented", Name: "Undocumented", Vendor: "cisco", Command: "show version",
+		ExpectedPattern: "IOS", Severity: string(SeverityLow), Enabled: true}
+	disabled := SecurityRule{ID: "disabled", Name: "Disabled", Vendor: "cisco", Command: "show clock",
+		ExpectedPattern: ".", Severity: string(SeverityLow)}
+	for _, rule := range []SecurityRule{documented, undocumented, disabled} {
+		require.NoError(t, rm.CreateRule(rule))
+	}
+
+	report, err := rm.ValidateAllRules()
+	require.NoError(t, err)
+	assert.True(t, report.Healthy(), "missing documentation does not break a rule")
+	require.Len(t, report.Warnings, 1)
+	assert.Equal(t, "undocumented", report.Warnings[0].RuleID)
+	assert.Equal(t, RuleIssueMissingRationale, report.Warnings[0].Issues[0].Kind)
+
+	stored, err := rm.GetRule("undocumented")
+	require.NoError(t, err)
+	assert.False(t, stored.NeedsAttention)
+
+	assert.Empty(t, CheckRuleDocumentation(disabled), "disabled rules are not linted")
+	undocumented.Rationale = "   "
+	assert.Len(t, CheckRuleDocumentation(undocumented), 1, "a blank rationale is missing")
+}
@@ -0,0 +1,282 @@
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/device"
+)
+
+// Rule issue kinds reported by CheckRuleHealth
+const (
+	RuleIssueInvalidPattern        = "invalid_pattern"
+	RuleIssueInvalidSectionPattern = "invalid_section_pattern"
+	RuleIssueMissingCommand        = "missing_command"
+	RuleIssueRiskyCommand          = "risky_command"
+	RuleIssueUnknownVendor         = "unknown_vendor"
+	RuleIssueUnknownDeviceType     = "unknown_device_type"
+)
+
+// RuleIssue is one reason a rule is broken. Risky command issues name the
+// Command, which AcknowledgeRuleCommand can accept as intended.
+type RuleIssue struct {
+	Kind    string `json:"kind"`
+	Detail  string `json:"detail"`
+	Command string `json:"command,omitempty"`
+}
+
+// RuleHealth lists the issues found in one rule
+type RuleHealth struct {
+	RuleID   string      `json:"ruleId"`
+	RuleName string      `json:"ruleName"`
+	Vendor   string      `json:"vendor"`
+	Issues   []RuleIssue `json:"issues"`
+}
+
+// RuleHealthReport is the result of validating every enabled rule. Broken
+// holds only the rules with issues, so a clean report has none. Warnings
+// holds the rules with documentation gaps, which do not make a rule broken.
+type RuleHealthReport struct {
+	CheckedRules int          `json:"checkedRules"`
+	Broken       []RuleHealth `json:"broken"`
+	Warnings     []RuleHealth `json:"warnings"`
+	CheckedAt    time.Time    `json:"checkedAt"`
+}
+
+// Healthy reports whether no broken rules were found
+func (r RuleHealthReport) Healthy() bool {
+	return len(r.Broken) == 0
+}
+
+// CheckRuleHealth returns the issues that keep a rule from running as
+// written: patterns that do not compile, missing or write-like commands,
+// and vendors or device types that do not exist. Commands are checked with
+// their macro references expanded from macros.
+func CheckRuleHealth(rule SecurityRule, macros map[string]string) []RuleIssue {
+	var issues []RuleIssue
+
+	if _, err := CompilePattern(rule.ExpectedPattern); err != nil {
+		issues = append(issues, RuleIssue{Kind: RuleIssueInvalidPattern, Detail: err.Error()})
+	}
+	if rule.AllMatch {
+		if strings.TrimSpace(rule.SectionPattern) == "" {
+			issues = append(issues, RuleIssue{Kind: RuleIssueInvalidSectionPattern, Detail: "all-match rules need a section pattern"})
+		} else if _, err := CompilePattern(rule.SectionPattern); err != nil {
+			issues = append(issues, RuleIssue{Kind: RuleIssueInvalidSectionPattern, Detail: err.Error()})
+		}
+	}
+
+	if rule.Precondition != nil {
+		if strings.TrimSpace(rule.Precondition.Command) == "" {
+			issues = append(issues, RuleIssue{Kind: RuleIssueMissingCommand, Detail: "precondition has no command"})
+		}
+		if _, err := CompilePattern(rule.Precondition.Pattern); err != nil {
+			issues = append(issues, RuleIssue{Kind: RuleIssueInvalidPattern, Detail: fmt.Sprintf("precondition: %v", err)})
+		}
+	}
+
+	warnings, err := ValidateRuleCommands(rule, macros)
+	if err != nil {
+		issues = append(issues, RuleIssue{Kind: RuleIssueMissingCommand, Detail: err.Error()})
+	}
+	for _, warning := range warnings {
+		issues = append(issues, RuleIssue{
+			Kind:    RuleIssueRiskyCommand,
+			Detail:  fmt.Sprintf("%s command %q %s", warning.Variant, warning.Command, warning.Reason),
+			Command: warning.Command,
+		})
+	}
+
+	if !isRuleVendor(rule.Vendor) {
+		issues = append(issues, RuleIssue{Kind: RuleIssueUnknownVendor, Detail: fmt.Sprintf("vendor %q does not exist", rule.Vendor)})
+	}
+	for _, override := range rule.VendorOverrides {
+		if !isRuleVendor(override.Vendor) {
+			issues = append(issues, RuleIssue{Kind: RuleIssueUnknownVendor,
+				Detail: fmt.Sprintf("vendor override %q does not exist", override.Vendor)})
+		}
+		if strings.TrimSpace(override.Command) == "" {
+			issues = append(issues, RuleIssue{Kind: RuleIssueMissingCommand,
+				Detail: fmt.Sprintf("vendor override %q has no command", override.Vendor)})
+		}
+		if _, err := CompilePattern(override.ExpectedPattern); err != nil {
+			issues = append(issues, RuleIssue{Kind: RuleIssueInvalidPattern,
+				Detail: fmt.Sprintf("vendor override %q: %v", override.Vendor, err)})
+		}
+	}
+
+	deviceTypes := make([]string, 0, len(rule.CommandOverrides))
+	for deviceType := range rule.CommandOverrides {
+		deviceTypes = append(deviceTypes, deviceType)
+	}
+	sort.Strings(deviceTypes)
+	for _, deviceType := range deviceTypes {
+		if !device.IsValidDeviceType(deviceType) {
+			issues = append(issues, RuleIssue{Kind: RuleIssueUnknownDeviceType,
+				Detail: fmt.Sprintf("device type override %q does not exist", deviceType)})
+		}
+		if strings.TrimSpace(rule.CommandOverrides[deviceType]) == "" {
+			issues = append(issues, RuleIssue{Kind: RuleIssueMissingCommand,
+				Detail: fmt.Sprintf("device type override %q has no command", deviceType)})
+		}
+	}
+
+	return issues
+}
+
+// ValidateAllRules checks every enabled rule with CheckRuleHealth and stores
+// the outcome in each rule's needs_attention flag, setting it on broken rules
+// and clearing it on rules that were fixed. Risky commands acknowledged with
+// AcknowledgeRuleCommand are not issues. Disabled rules keep their flag.
+func (rm *RuleManager) ValidateAllRules() (*RuleHealthReport, error) {
+	rules, err := rm.GetAllRules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+	acknowledged, err := rm.acknowledgedCommands()
+	if err != nil {
+		return nil, err
+	}
+	macros, err := NewMacroManager(rm.db).Commands()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RuleHealthReport{Broken: []RuleHealth{}, Warnings: []RuleHealth{}, CheckedAt: time.Now()}
+	changed := make(map[string]bool)
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		report.CheckedRules++
+
+		issues := withoutAcknowledged(CheckRuleHealth(rule, macros), acknowledged[rule.ID])
+		if len(issues) > 0 {
+			report.Broken = append(report.Broken, RuleHealth{
+				RuleID:   rule.ID,
+				RuleName: rule.Name,
+				Vendor:   rule.Vendor,
+				Issues:   issues,
+			})
+		}
+		if warnings := CheckRuleDocumentation(rule); len(warnings) > 0 {
+			report.Warnings = append(report.Warnings, RuleHealth{
+				RuleID:   rule.ID,
+				RuleName: rule.Name,
+				Vendor:   rule.Vendor,
+				Issues:   warnings,
+			})
+		}
+		if broken := len(issues) > 0; broken != rule.NeedsAttention {
+			changed[rule.ID] = broken
+		}
+	}
+
+	if len(changed) == 0 {
+		return report, nil
+	}
+	defer rm.rulesChanged()
+
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for id, broken := range changed {
+		if _, err := tx.Exec("UPDATE security_rules SET needs_attention = ? WHERE id = ?", broken, id); err != nil {
+			return nil, fmt.Errorf("failed to flag rule %s: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// AcknowledgeRuleCommand accepts a risky command of a rule as intended, so
+// ValidateAllRules no longer counts it against the rule. The acknowledgement
+// covers the command exactly as written; editing it makes it an issue again.
+func (rm *RuleManager) AcknowledgeRuleCommand(ruleID, command string) error {
+	rule, err := rm.GetRule(ruleID)
+	if err != nil {
+		return err
+	}
+	macros, err := NewMacroManager(rm.db).Commands()
+	if err != nil {
+		return err
+	}
+
+	risky := false
+	for _, issue := range CheckRuleHealth(*rule, macros) {
+		if issue.Kind == RuleIssueRiskyCommand && issue.Command == command {
+			risky = true
+			break
+		}
+	}
+	if !risky {
+		return fmt.Errorf("rule %s has no risky command %q", ruleID, command)
+	}
+
+	_, err = rm.db.Exec("INSERT OR IGNORE INTO rule_command_acknowledgements (rule_id, command) VALUES (?, ?)", ruleID, command)
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge command of rule %s: %w", ruleID, err)
+	}
+	return nil
+}
+
+// acknowledgedCommands returns the acknowledged risky commands by rule ID
+func (rm *RuleManager) acknowledgedCommands() (map[string]map[string]bool, error) {
+	rows, err := rm.db.Query("SELECT rule_id, command FROM rule_command_acknowledgements")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load acknowledged commands: %w", err)
+	}
+	defer rows.Close()
+
+	acknowledged := make(map[string]map[string]bool)
+	for rows.Next() {
+		var ruleID, command string
+		if err := rows.Scan(&ruleID, &command); err != nil {
+			return nil, err
+		}
+		if acknowledged[ruleID] == nil {
+			acknowledged[ruleID] = make(map[string]bool)
+		}
+		acknowledged[ruleID][command] = true
+	}
+	return acknowledged, rows.Err()
+}
+
+// withoutAcknowledged drops the risky command issues whose command was acknowledged
+func withoutAcknowledged(issues []RuleIssue, acknowledged map[string]bool) []RuleIssue {
+	if len(acknowledged) == 0 {
+		return issues
+	}
+	kept := issues[:0]
+	for _, issue := range issues {
+		if issue.Kind == RuleIssueRiskyCommand && acknowledged[issue.Command] {
+			continue
+		}
+		kept = append(kept, issue)
+	}
+	return kept
+}
+
+// SetExcludeBrokenRules makes runs skip rules flagged as needing attention,
+// recording them with SkipReasonNeedsAttention. By default they still run.
+func (e *Engine) SetExcludeBrokenRules(exclude bool) {
+	e.excludeBrokenRules.Store(exclude)
+}
+
+// ExcludesBrokenRules reports whether runs skip rules needing attention
+func (e *Engine) ExcludesBrokenRules() bool {
+	return e.excludeBrokenRules.Load()
+}
+
+// excludedAsBroken reports whether a rule is skipped for needing attention
+func (e *Engine) excludedAsBroken(rule SecurityRule) bool {
+	return rule.NeedsAttention && e.excludeBrokenRules.Load()
+}
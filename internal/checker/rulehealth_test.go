@@ -0,0 +1,177 @@
+package checker
+
+import (
+	"testing"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRuleHealth(t *testing.T) {
+	valid := SecurityRule{ID: "ok", Name: "SSH version", Vendor: "cisco", Command: "show ip ssh",
+		ExpectedPattern: "version 2", Severity: string(SeverityHigh), Enabled: true}
+	assert.Empty(t, CheckRuleHealth(valid, nil))
+
+	tests := []struct {
+		name   string
+		modify func(*SecurityRule)
+		kind   string
+	}{
+		{"invalid pattern", func(r *SecurityRule) { r.ExpectedPattern = "version (2" }, RuleIssueInvalidPattern},
+		{"invalid section pattern", func(r *SecurityRule) { r.AllMatch, r.SectionPattern = true, "^interface [" }, RuleIssueInvalidSectionPattern},
+		{"missing section pattern", func(r *SecurityRule) { r.AllMatch = true }, RuleIssueInvalidSectionPattern},
+		{"missing command", func(r *SecurityRule) { r.Command = " " }, RuleIssueMissingCommand},
+		{"risky command", func(r *SecurityRule) { r.Command = "write memory" }, RuleIssueRiskyCommand},
+		{"unknown vendor", func(r *SecurityRule) { r.Vendor = "acme" }, RuleIssueUnknownVendor},
+		{"unknown override vendor", func(r *SecurityRule) {
+			r.VendorOverrides = []VendorOverride{{Vendor: "acme", Command: "show ssh"}}
+		}, RuleIssueUnknownVendor},
+		{"invalid override pattern", func(r *SecurityRule) {
+			r.VendorOverrides = []VendorOverride{{Vendor: "juniper", Command: "show ssh", ExpectedPattern: "*v2"}}
+		}, RuleIssueInvalidPattern},
+		{"unknown device type", func(r *SecurityRule) {
+			r.CommandOverrides = map[string]string{"toaster": "show ip ssh"}
+		}, RuleIssueUnknownDeviceType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.modify(&rule)
+			issues := CheckRuleHealth(rule, nil)
+			require.Len(t, issues, 1)
+			assert.Equal(t, tt.kind, issues[0].Kind)
+			assert.NotEmpty(t, issues[0].Detail)
+		})
+	}
+}
+
+func TestRuleManager_ValidateAllRules(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	require.NoError(t, rm.CreateRule(SecurityRule{ID: "good", Name: "SSH version", Vendor: "cisco",
+		Command: "show ip ssh", ExpectedPattern: "version 2", Severity: string(SeverityHigh), Enabled: true}))
+	require.NoError(t, rm.CreateRule(SecurityRule{ID: "pattern", Name: "Broken pattern", Vendor: "cisco",
+		Command: "show ip ssh", ExpectedPattern: "version (2", Severity: string(SeverityHigh), Enabled: true}))
+	require.NoError(t, rm.CreateRule(SecurityRule{ID: "vendor", Name: "Unknown vendor", Vendor: "acme",
+		Command: "show ssh", ExpectedPattern: "v2", Severity: string(SeverityLow), Enabled: true}))
+	require.NoError(t, rm.CreateRule(SecurityRule{ID: "disabled", Name: "Disabled", Vendor: "cisco",
+		Command: "show ip ssh", ExpectedPattern: "(", Severity: string(SeverityLow), Enabled: false}))
+
+	generation := rm.Generation()
+	report, err := rm.ValidateAllRules()
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.CheckedRules, "disabled rules are not checked")
+	assert.False(t, report.Healthy())
+
+	broken := make(map[string]RuleHealth)
+	for _, health := range report.Broken {
+		broken[health.RuleID] = health
+	}
+	require.Len(t, broken, 2)
+	assert.Equal(t, RuleIssueInvalidPattern, broken["pattern"].Issues[0].Kind)
+	assert.Equal(t, RuleIssueUnknownVendor, broken["vendor"].Issues[0].Kind)
+	assert.NotEqual(t, generation, rm.Generation(), "flag changes invalidate cached rules")
+
+	flagged := func() map[string]bool {
+		rules, err := rm.GetAllRules()
+		require.NoError(t, err)
+		flags := make(map[string]bool)
+		for _, rule := range rules {
+			flags[rule.ID] = rule.NeedsAttention
+		}
+		return flags
+	}
+	assert.Equal(t, map[string]bool{"good": false, "pattern": true, "vendor": true, "disabled": false}, flagged())
+
+	// Fixing the rules clears their flags and the report
+	fixed, err := rm.GetRule("pattern")
+	require.NoError(t, err)
+	fixed.ExpectedPattern = "version 2"
+	require.NoError(t, rm.UpdateRule(*fixed))
+	_, err = rm.db.Exec("UPDATE security_rules SET vendor = 'juniper' WHERE id = 'vendor'")
+	require.NoError(t, err)
+
+	report, err = rm.ValidateAllRules()
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+	assert.Empty(t, report.Broken)
+	assert.Equal(t, map[string]bool{"good": false, "pattern": false, "vendor": false, "disabled": false}, flagged())
+
+	generation = rm.Generation()
+	_, err = rm.ValidateAllRules()
+	require.NoError(t, err)
+	assert.Equal(t, generation, rm.Generation(), "unchanged flags are not rewritten")
+}
+
+func TestRuleManager_AcknowledgeRuleCommand(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	require.NoError(t, rm.CreateRule(SecurityRule{ID: "risky", Name: "Saved config", Vendor: "cisco",
+		Command: "write terminal", ExpectedPattern: "hostname", Severity: string(SeverityLow), Enabled: true}))
+
+	report, err := rm.ValidateAllRules()
+	require.NoError(t, err)
+	require.Len(t, report.Broken, 1)
+	issue := report.Broken[0].Issues[0]
+	assert.Equal(t, RuleIssueRiskyCommand, issue.Kind)
+	assert.Equal(t, "write terminal", issue.Command)
+
+	assert.Error(t, rm.AcknowledgeRuleCommand("risky", "show running-config"), "only risky commands are acknowledged")
+	assert.Error(t, rm.AcknowledgeRuleCommand("missing", "write terminal"))
+	require.NoError(t, rm.AcknowledgeRuleCommand("risky", "write terminal"))
+
+	report, err = rm.ValidateAllRules()
+	require.NoError(t, err)
+	assert.True(t, report.Healthy())
+	rule, err := rm.GetRule("risky")
+	require.NoError(t, err)
+	assert.False(t, rule.NeedsAttention)
+
+	// A different risky command needs its own acknowledgement
+	rule.Command = "write memory"
+	require.NoError(t, rm.UpdateRule(*rule))
+	report, err = rm.ValidateAllRules()
+	require.NoError(t, err)
+	require.Len(t, report.Broken, 1)
+	assert.Equal(t, "write memory", report.Broken[0].Issues[0].Command)
+}
+
+func TestEngine_ExcludeBrokenRules(t *testing.T) {
+	rm := setupTestRuleManager(t)
+	client := &stubSSHClient{outputs: map[string]string{"show ip ssh": "SSH Enabled - version 2.0"}}
+	engine := NewEngineWithSSHClient(rm, client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "good", Name: "SSH version", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "broken", Name: "Broken pattern", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version (2",
+			Severity: string(SeverityHigh), Enabled: true},
+	}))
+	_, err := rm.ValidateAllRules()
+	require.NoError(t, err)
+
+	dev := &device.Device{ID: "r1", Name: "Router", IPAddress: "192.168.1.1", Vendor: "cisco",
+		Username: "admin", SSHPort: 22}
+
+	results, err := engine.RunChecks(dev)
+	require.NoError(t, err)
+	assert.Len(t, results, 2, "flagged rules still run by default")
+	assert.Empty(t, engine.GetSkippedRules(dev))
+
+	engine.SetExcludeBrokenRules(true)
+	assert.True(t, engine.ExcludesBrokenRules())
+
+	results, err = engine.RunChecks(dev)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "SSH version", results[0].CheckName)
+
+	skipped := engine.GetSkippedRules(dev)
+	require.Len(t, skipped, 1)
+	assert.Equal(t, "broken", skipped[0].RuleID)
+	assert.Equal(t, SkipReasonNeedsAttention, skipped[0].Reason)
+
+	bulk, err := engine.RunBulkChecks([]device.Device{*dev})
+	require.NoError(t, err)
+	assert.Len(t, bulk[dev.ID], 1)
+}
@@ -1,74 +1,280 @@
 package checker
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"invictux-demo/internal/device"
+
 	"github.com/google/uuid"
 )
 
 // RuleManager handles security rule operations
 type RuleManager struct {
 	db *sql.DB
+
+	// generation changes after every rule mutation so caches of the rules
+	// can tell they are stale
+	generation atomic.Uint64
 }
 
-// NewRuleManager creates a new rule manager
-func NewRuleManager(db *sql.DB) *RuleManager {
-	return &RuleManager{db: db}
+// RuleManagerInterface defines the rule operations the engine depends on.
+// *RuleManager implements it; tests can substitute an in-memory fake. The
+// engine passes its run context so a cancelled run stops its queries.
+type RuleManagerInterface interface {
+	GetRulesByVendorContext(ctx context.Context, vendor string) ([]SecurityRule, error)
+	GetAllRulesContext(ctx context.Context) ([]SecurityRule, error)
+	CreateRule(rule SecurityRule) error
+	SaveSkippedRulesContext(ctx context.Context, skipped []SkippedRule) error
+	// Generation changes whenever the rules change
+	Generation() uint64
 }
 
-// LoadPredefinedRules loads predefined security rules for all vendors
-func (rm *RuleManager) LoadPredefinedRules() error {
-	rules := GetPredefinedRules()
+// ruleColumns lists the security_rules columns in the order scanned by scanRule
+const ruleColumns = `id, name, description, vendor, command, expected_pattern, severity, enabled, created_at,
+		command_overrides, rule_version, all_match, section_pattern, needs_attention, expected_exit_code, stream_target,
+		category, remediation, evidence_lines, finding_category, finding_key, precondition_command, precondition_pattern,
+		rationale, rule_references, depends_on, predefined, source_version, user_modified`
 
-	for _, rule := range rules {
-		// Check if rule already exists
-		exists, err := rm.ruleExists(rule.Name, rule.Vendor)
-		if err != nil {
-			return fmt.Errorf("failed to check if rule exists: %w", err)
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRule scans a security rule selected with ruleColumns
+func scanRule(scanner rowScanner) (SecurityRule, error) {
+	var rule SecurityRule
+	var overrides, sectionPattern, preconditionCommand, preconditionPattern, references, dependsOn sql.NullString
+	var allMatch, needsAttention sql.NullBool
+	var expectedExitCode sql.NullInt64
+
+	err := scanner.Scan(&rule.ID, &rule.Name, &rule.Description, &rule.Vendor,
+		&rule.Command, &rule.ExpectedPattern, &rule.Severity, &rule.Enabled, &rule.CreatedAt,
+		&overrides, &rule.RuleVersion, &allMatch, &sectionPattern, &needsAttention,
+		&expectedExitCode, &rule.StreamTarget, &rule.Category, &rule.Remediation,
+		&rule.EvidenceLines, &rule.FindingCategory, &rule.FindingKey, &preconditionCommand, &preconditionPattern,
+		&rule.Rationale, &references, &dependsOn, &rule.Predefined, &rule.SourceVersion, &rule.UserModified)
+	if err != nil {
+		return rule, err
+	}
+
+	if preconditionCommand.Valid && preconditionCommand.String != "" {
+		rule.Precondition = &RulePrecondition{Command: preconditionCommand.String, Pattern: preconditionPattern.String}
+	}
+
+	if expectedExitCode.Valid {
+		code := int(expectedExitCode.Int64)
+		rule.ExpectedExitCode = &code
+	}
+
+	rule.AllMatch = allMatch.Bool
+	rule.SectionPattern = sectionPattern.String
+	rule.NeedsAttention = needsAttention.Bool
+
+	if overrides.Valid && overrides.String != "" {
+		if err := json.Unmarshal([]byte(overrides.String), &rule.CommandOverrides); err != nil {
+			return rule, fmt.Errorf("invalid command overrides for rule %s: %w", rule.ID, err)
 		}
+	}
 
-		if !exists {
-			if err := rm.CreateRule(rule); err != nil {
-				return fmt.Errorf("failed to create rule %s: %w", rule.Name, err)
-			}
+	if references.Valid && references.String != "" {
+		if err := json.Unmarshal([]byte(references.String), &rule.References); err != nil {
+			return rule, fmt.Errorf("invalid references for rule %s: %w", rule.ID, err)
 		}
 	}
 
-	return nil
+	if dependsOn.Valid && dependsOn.String != "" {
+		if err := json.Unmarshal([]byte(dependsOn.String), &rule.DependsOn); err != nil {
+			return rule, fmt.Errorf("invalid dependencies for rule %s: %w", rule.ID, err)
+		}
+	}
+
+	return rule, nil
 }
 
-// CreateRule creates a new security rule
+// encodeCommandOverrides serializes command overrides for storage, using NULL when empty
+func encodeCommandOverrides(overrides map[string]string) (interface{}, error) {
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode command overrides: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// NewRuleManager creates a new rule manager
+func NewRuleManager(db *sql.DB) *RuleManager {
+	return &RuleManager{db: db}
+}
+
+// Generation returns a counter that changes whenever a rule is created,
+// updated, deleted, enabled, disabled or has its vendor overrides changed
+func (rm *RuleManager) Generation() uint64 {
+	return rm.generation.Load()
+}
+
+// rulesChanged marks cached rules as stale. Mutations defer it so it runs
+// after their transaction commits.
+func (rm *RuleManager) rulesChanged() {
+	rm.generation.Add(1)
+}
+
+// CreateRule creates a new security rule. Rules without a command are
+// rejected; use ValidateRuleCommands to find commands that need confirming.
+// The rule is a custom rule even when copied from a predefined one.
 func (rm *RuleManager) CreateRule(rule SecurityRule) error {
+	rule.Predefined, rule.SourceVersion, rule.UserModified = false, 0, false
+	return rm.createRule(rule)
+}
+
+// createRule stores a new rule, predefined or not
+func (rm *RuleManager) createRule(rule SecurityRule) error {
+	defer rm.rulesChanged()
+
+	if strings.TrimSpace(rule.Command) == "" {
+		return ErrEmptyRuleCommand
+	}
+	if !isStreamTarget(rule.StreamTarget) {
+		return fmt.Errorf("invalid stream target %q", rule.StreamTarget)
+	}
+	if rule.Category != "" && !IsRuleCategory(rule.Category) {
+		return fmt.Errorf("unknown rule category %q", rule.Category)
+	}
+	if rule.FindingCategory != "" && !IsFindingCategory(rule.FindingCategory) {
+		return fmt.Errorf("unknown finding category %q", rule.FindingCategory)
+	}
+	rule.FindingKey = normalizeFindingKey(rule.FindingKey)
+	if err := validatePrecondition(rule.Precondition); err != nil {
+		return err
+	}
+
 	if rule.ID == "" {
 		rule.ID = uuid.New().String()
 	}
+	rule.DependsOn = normalizeDependsOn(rule.DependsOn)
+	if err := rm.checkDependencies(rule); err != nil {
+		return err
+	}
 
 	if rule.CreatedAt.IsZero() {
 		rule.CreatedAt = time.Now()
 	}
 
+	if rule.RuleVersion == 0 {
+		rule.RuleVersion = 1
+	}
+
+	overrides, err := encodeCommandOverrides(rule.CommandOverrides)
+	if err != nil {
+		return err
+	}
+	references, err := encodeReferences(rule.References)
+	if err != nil {
+		return err
+	}
+	dependsOn, err := encodeDependsOn(rule.DependsOn)
+	if err != nil {
+		return err
+	}
+
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO security_rules (id, name, description, vendor, command, expected_pattern, severity, enabled, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO security_rules (id, name, description, vendor, command, expected_pattern, severity, enabled, created_at,
+			command_overrides, rule_version, all_match, section_pattern, expected_exit_code, stream_target, category,
+			remediation, evidence_lines, finding_category, finding_key, precondition_command, precondition_pattern,
+			rationale, rule_references, depends_on, predefined, source_version, user_modified)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
+	preconditionCommand, preconditionPattern := rule.preconditionColumns()
 
-	_, err := rm.db.Exec(query, rule.ID, rule.Name, rule.Description, rule.Vendor,
-		rule.Command, rule.ExpectedPattern, rule.Severity, rule.Enabled, rule.CreatedAt)
+	_, err = tx.Exec(query, rule.ID, rule.Name, rule.Description, rule.Vendor,
+		rule.Command, rule.ExpectedPattern, rule.Severity, rule.Enabled, rule.CreatedAt,
+		overrides, rule.RuleVersion, rule.AllMatch, nullableString(rule.SectionPattern),
+		rule.ExpectedExitCode, rule.StreamTarget, rule.Category, rule.Remediation, rule.EvidenceLines,
+		rule.FindingCategory, rule.FindingKey, preconditionCommand, preconditionPattern,
+		strings.TrimSpace(rule.Rationale), references, dependsOn, rule.Predefined, rule.SourceVersion, rule.UserModified)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if err := insertVendorOverrides(tx, rule.ID, rule.VendorOverrides); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // GetAllRules retrieves all security rules
 func (rm *RuleManager) GetAllRules() ([]SecurityRule, error) {
+	return rm.GetAllRulesContext(context.Background())
+}
+
+// GetAllRulesContext is GetAllRules, stopping when ctx ends
+func (rm *RuleManager) GetAllRulesContext(ctx context.Context) ([]SecurityRule, error) {
+	query := `
+		SELECT ` + ruleColumns + `
+		FROM security_rules
+		ORDER BY vendor, name
+	`
+
+	rows, err := rm.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []SecurityRule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := rm.attachVendorOverrides(ctx, rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// GetRulesByCategory retrieves the security rules of one category
+func (rm *RuleManager) GetRulesByCategory(category string) ([]SecurityRule, error) {
+	return rm.GetRulesByCategoryContext(context.Background(), category)
+}
+
+// GetRulesByCategoryContext is GetRulesByCategory, stopping when ctx ends
+func (rm *RuleManager) GetRulesByCategoryContext(ctx context.Context, category string) ([]SecurityRule, error) {
+	if !IsRuleCategory(category) {
+		return nil, fmt.Errorf("unknown rule category %q", category)
+	}
+
 	query := `
-		SELECT id, name, description, vendor, command, expected_pattern, severity, enabled, created_at
+		SELECT ` + ruleColumns + `
 		FROM security_rules
+		WHERE category = ?
 		ORDER BY vendor, name
 	`
 
-	rows, err := rm.db.Query(query)
+	rows, err := rm.db.QueryContext(ctx, query, category)
 	if err != nil {
 		return nil, err
 	}
@@ -76,28 +282,40 @@ func (rm *RuleManager) GetAllRules() ([]SecurityRule, error) {
 
 	var rules []SecurityRule
 	for rows.Next() {
-		var rule SecurityRule
-		err := rows.Scan(&rule.ID, &rule.Name, &rule.Description, &rule.Vendor,
-			&rule.Command, &rule.ExpectedPattern, &rule.Severity, &rule.Enabled, &rule.CreatedAt)
+		rule, err := scanRule(rows)
 		if err != nil {
 			return nil, err
 		}
 		rules = append(rules, rule)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := rm.attachVendorOverrides(ctx, rules); err != nil {
+		return nil, err
+	}
 
 	return rules, nil
 }
 
-// GetRulesByVendor retrieves security rules for a specific vendor
+// GetRulesByVendor retrieves security rules for a specific vendor, including
+// generic rules and rules that carry an override for the vendor
 func (rm *RuleManager) GetRulesByVendor(vendor string) ([]SecurityRule, error) {
+	return rm.GetRulesByVendorContext(context.Background(), vendor)
+}
+
+// GetRulesByVendorContext is GetRulesByVendor, stopping when ctx ends
+func (rm *RuleManager) GetRulesByVendorContext(ctx context.Context, vendor string) ([]SecurityRule, error) {
 	query := `
-		SELECT id, name, description, vendor, command, expected_pattern, severity, enabled, created_at
+		SELECT ` + ruleColumns + `
 		FROM security_rules
 		WHERE vendor = ? OR vendor = 'generic'
+			OR id IN (SELECT rule_id FROM rule_vendor_overrides WHERE vendor = ?)
 		ORDER BY name
 	`
 
-	rows, err := rm.db.Query(query, vendor)
+	rows, err := rm.db.QueryContext(ctx, query, vendor, vendor)
 	if err != nil {
 		return nil, err
 	}
@@ -105,28 +323,95 @@ func (rm *RuleManager) GetRulesByVendor(vendor string) ([]SecurityRule, error) {
 
 	var rules []SecurityRule
 	for rows.Next() {
-		var rule SecurityRule
-		err := rows.Scan(&rule.ID, &rule.Name, &rule.Description, &rule.Vendor,
-			&rule.Command, &rule.ExpectedPattern, &rule.Severity, &rule.Enabled, &rule.CreatedAt)
+		rule, err := scanRule(rows)
 		if err != nil {
 			return nil, err
 		}
 		rules = append(rules, rule)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := rm.attachVendorOverrides(ctx, rules); err != nil {
+		return nil, err
+	}
 
 	return rules, nil
 }
 
-// UpdateRule updates an existing security rule
+// UpdateRule updates an existing security rule. A predefined rule is
+// marked as modified by the user, so upgrades no longer overwrite it.
 func (rm *RuleManager) UpdateRule(rule SecurityRule) error {
+	return rm.updateRule(rule, true)
+}
+
+// updateRule stores the changes to a rule. Edits by the user mark
+// predefined rules as modified; otherwise the rule is taken to be the
+// predefined rule of its RuleVersion.
+func (rm *RuleManager) updateRule(rule SecurityRule, userEdit bool) error {
+	defer rm.rulesChanged()
+
+	if strings.TrimSpace(rule.Command) == "" {
+		return ErrEmptyRuleCommand
+	}
+	if !isStreamTarget(rule.StreamTarget) {
+		return fmt.Errorf("invalid stream target %q", rule.StreamTarget)
+	}
+	if rule.Category != "" && !IsRuleCategory(rule.Category) {
+		return fmt.Errorf("unknown rule category %q", rule.Category)
+	}
+	if rule.FindingCategory != "" && !IsFindingCategory(rule.FindingCategory) {
+		return fmt.Errorf("unknown finding category %q", rule.FindingCategory)
+	}
+	rule.FindingKey = normalizeFindingKey(rule.FindingKey)
+	if err := validatePrecondition(rule.Precondition); err != nil {
+		return err
+	}
+	rule.DependsOn = normalizeDependsOn(rule.DependsOn)
+	if err := rm.checkDependencies(rule); err != nil {
+		return err
+	}
+
+	overrides, err := encodeCommandOverrides(rule.CommandOverrides)
+	if err != nil {
+		return err
+	}
+	references, err := encodeReferences(rule.References)
+	if err != nil {
+		return err
+	}
+	dependsOn, err := encodeDependsOn(rule.DependsOn)
+	if err != nil {
+		return err
+	}
+
+	if rule.RuleVersion == 0 {
+		rule.RuleVersion = 1
+	}
+
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		UPDATE security_rules 
-		SET name = ?, description = ?, vendor = ?, command = ?, expected_pattern = ?, severity = ?, enabled = ?
+		SET name = ?, description = ?, vendor = ?, command = ?, expected_pattern = ?, severity = ?, enabled = ?,
+			command_overrides = ?, rule_version = ?, all_match = ?, section_pattern = ?,
+			expected_exit_code = ?, stream_target = ?, category = ?, remediation = ?,
+			evidence_lines = ?, finding_category = ?, finding_key = ?, precondition_command = ?,
+			precondition_pattern = ?, rationale = ?, rule_references = ?, depends_on = ?
 		WHERE id = ?
 	`
+	preconditionCommand, preconditionPattern := rule.preconditionColumns()
 
-	result, err := rm.db.Exec(query, rule.Name, rule.Description, rule.Vendor,
-		rule.Command, rule.ExpectedPattern, rule.Severity, rule.Enabled, rule.ID)
+	result, err := tx.Exec(query, rule.Name, rule.Description, rule.Vendor,
+		rule.Command, rule.ExpectedPattern, rule.Severity, rule.Enabled, overrides, rule.RuleVersion,
+		rule.AllMatch, nullableString(rule.SectionPattern), rule.ExpectedExitCode, rule.StreamTarget, rule.Category,
+		rule.Remediation, rule.EvidenceLines, rule.FindingCategory, rule.FindingKey, preconditionCommand,
+		preconditionPattern, strings.TrimSpace(rule.Rationale), references, dependsOn, rule.ID)
 	if err != nil {
 		return err
 	}
@@ -140,14 +425,39 @@ func (rm *RuleManager) UpdateRule(rule SecurityRule) error {
 		return fmt.Errorf("rule with ID %s not found", rule.ID)
 	}
 
-	return nil
+	if userEdit {
+		_, err = tx.Exec("UPDATE security_rules SET user_modified = predefined WHERE id = ?", rule.ID)
+	} else {
+		_, err = tx.Exec("UPDATE security_rules SET predefined = TRUE, source_version = ?, user_modified = FALSE WHERE id = ?",
+			rule.RuleVersion, rule.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Vendor overrides are replaced as a whole with the rule
+	if _, err := tx.Exec("DELETE FROM rule_vendor_overrides WHERE rule_id = ?", rule.ID); err != nil {
+		return err
+	}
+
+	if err := insertVendorOverrides(tx, rule.ID, rule.VendorOverrides); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // DeleteRule deletes a security rule
 func (rm *RuleManager) DeleteRule(id string) error {
-	query := "DELETE FROM security_rules WHERE id = ?"
+	defer rm.rulesChanged()
 
-	result, err := rm.db.Exec(query, id)
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM security_rules WHERE id = ?", id)
 	if err != nil {
 		return err
 	}
@@ -161,11 +471,286 @@ func (rm *RuleManager) DeleteRule(id string) error {
 		return fmt.Errorf("rule with ID %s not found", id)
 	}
 
+	if _, err := tx.Exec("DELETE FROM rule_vendor_overrides WHERE rule_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM rule_command_acknowledgements WHERE rule_id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRule retrieves a security rule by ID
+func (rm *RuleManager) GetRule(id string) (*SecurityRule, error) {
+	return rm.GetRuleContext(context.Background(), id)
+}
+
+// GetRuleContext is GetRule, stopping when ctx ends
+func (rm *RuleManager) GetRuleContext(ctx context.Context, id string) (*SecurityRule, error) {
+	query := "SELECT " + ruleColumns + " FROM security_rules WHERE id = ?"
+
+	rule, err := scanRule(rm.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("rule with ID %s not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []SecurityRule{rule}
+	if err := rm.attachVendorOverrides(ctx, rules); err != nil {
+		return nil, err
+	}
+
+	return &rules[0], nil
+}
+
+// CloneRule copies a rule to another vendor under a new ID. When the source
+// rule has a vendor override for the target vendor, its command and pattern
+// become the clone's own; overrides for other vendors are dropped unless the
+// clone is generic. A name already used by the target vendor gets a "(copy)"
+// suffix. The clone is an ordinary custom rule and can be edited afterwards.
+func (rm *RuleManager) CloneRule(id, newVendor string) (*SecurityRule, error) {
+	newVendor = strings.TrimSpace(newVendor)
+	if !isRuleVendor(newVendor) {
+		return nil, fmt.Errorf("invalid vendor: %s", newVendor)
+	}
+
+	source, err := rm.GetRule(id)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := *source
+	clone.ID = uuid.New().String()
+	clone.Vendor = newVendor
+	clone.CreatedAt = time.Now()
+	clone.RuleVersion = 1
+
+	if source.CommandOverrides != nil {
+		clone.CommandOverrides = make(map[string]string, len(source.CommandOverrides))
+		for deviceType, command := range source.CommandOverrides {
+			clone.CommandOverrides[deviceType] = command
+		}
+	}
+
+	clone.VendorOverrides = nil
+	for _, override := range source.VendorOverrides {
+		switch {
+		case override.Vendor == newVendor:
+			clone.Command = override.Command
+			if override.ExpectedPattern != "" {
+				clone.ExpectedPattern = override.ExpectedPattern
+			}
+		case newVendor == "generic":
+			clone.VendorOverrides = append(clone.VendorOverrides, override)
+		}
+	}
+
+	clone.Name, err = rm.uniqueRuleName(source.Name, newVendor)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rm.CreateRule(clone); err != nil {
+		return nil, fmt.Errorf("failed to create cloned rule: %w", err)
+	}
+
+	// CreateRule stores the clone as a custom rule, so return what it stored
+	return rm.GetRule(clone.ID)
+}
+
+// isRuleVendor reports whether a rule can target a vendor
+func isRuleVendor(vendor string) bool {
+	if vendor == "generic" {
+		return true
+	}
+	for _, valid := range device.ValidVendors() {
+		if vendor == string(valid) {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueRuleName returns name, or name with a copy suffix, such that no rule
+// of the vendor already uses it
+func (rm *RuleManager) uniqueRuleName(name, vendor string) (string, error) {
+	candidate := name
+	for i := 1; ; i++ {
+		existing, err := rm.findRule(candidate, vendor)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return candidate, nil
+		}
+
+		if i == 1 {
+			candidate = fmt.Sprintf("%s (copy)", name)
+		} else {
+			candidate = fmt.Sprintf("%s (copy %d)", name, i)
+		}
+	}
+}
+
+// SetVendorOverride adds or replaces the override of a rule for one vendor
+func (rm *RuleManager) SetVendorOverride(ruleID string, override VendorOverride) error {
+	defer rm.rulesChanged()
+
+	if override.Vendor == "" || override.Command == "" {
+		return fmt.Errorf("vendor override requires a vendor and a command")
+	}
+
+	exists, err := rm.ruleIDExists(ruleID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("rule with ID %s not found", ruleID)
+	}
+
+	query := `
+		INSERT INTO rule_vendor_overrides (rule_id, vendor, command, expected_pattern)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(rule_id, vendor) DO UPDATE SET command = excluded.command, expected_pattern = excluded.expected_pattern
+	`
+
+	if _, err := rm.db.Exec(query, ruleID, override.Vendor, override.Command,
+		nullableString(override.ExpectedPattern)); err != nil {
+		return err
+	}
+	return rm.markUserModified(ruleID)
+}
+
+// GetVendorOverrides retrieves the vendor overrides of a rule
+func (rm *RuleManager) GetVendorOverrides(ruleID string) ([]VendorOverride, error) {
+	query := `
+		SELECT vendor, command, expected_pattern
+		FROM rule_vendor_overrides
+		WHERE rule_id = ?
+		ORDER BY vendor
+	`
+
+	rows, err := rm.db.Query(query, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []VendorOverride
+	for rows.Next() {
+		var override VendorOverride
+		var pattern sql.NullString
+		if err := rows.Scan(&override.Vendor, &override.Command, &pattern); err != nil {
+			return nil, err
+		}
+		override.ExpectedPattern = pattern.String
+		overrides = append(overrides, override)
+	}
+
+	return overrides, rows.Err()
+}
+
+// DeleteVendorOverride removes the override of a rule for one vendor
+func (rm *RuleManager) DeleteVendorOverride(ruleID, vendor string) error {
+	defer rm.rulesChanged()
+
+	result, err := rm.db.Exec("DELETE FROM rule_vendor_overrides WHERE rule_id = ? AND vendor = ?", ruleID, vendor)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("vendor override %s for rule %s not found", vendor, ruleID)
+	}
+
+	return rm.markUserModified(ruleID)
+}
+
+// markUserModified marks a predefined rule as edited by the user, so
+// upgrades no longer overwrite it
+func (rm *RuleManager) markUserModified(id string) error {
+	_, err := rm.db.Exec("UPDATE security_rules SET user_modified = predefined WHERE id = ?", id)
+	return err
+}
+
+// attachVendorOverrides loads the vendor overrides for a set of rules
+func (rm *RuleManager) attachVendorOverrides(ctx context.Context, rules []SecurityRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	rows, err := rm.db.QueryContext(ctx, `
+		SELECT rule_id, vendor, command, expected_pattern
+		FROM rule_vendor_overrides
+		ORDER BY rule_id, vendor
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byRule := make(map[string][]VendorOverride)
+	for rows.Next() {
+		var ruleID string
+		var override VendorOverride
+		var pattern sql.NullString
+		if err := rows.Scan(&ruleID, &override.Vendor, &override.Command, &pattern); err != nil {
+			return err
+		}
+		override.ExpectedPattern = pattern.String
+		byRule[ruleID] = append(byRule[ruleID], override)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range rules {
+		rules[i].VendorOverrides = byRule[rules[i].ID]
+	}
+
+	return nil
+}
+
+// insertVendorOverrides stores the vendor overrides of a rule within a transaction
+func insertVendorOverrides(tx *sql.Tx, ruleID string, overrides []VendorOverride) error {
+	query := `
+		INSERT INTO rule_vendor_overrides (rule_id, vendor, command, expected_pattern)
+		VALUES (?, ?, ?, ?)
+	`
+
+	for _, override := range overrides {
+		if override.Vendor == "" || override.Command == "" {
+			return fmt.Errorf("vendor override requires a vendor and a command")
+		}
+		if _, err := tx.Exec(query, ruleID, override.Vendor, override.Command,
+			nullableString(override.ExpectedPattern)); err != nil {
+			return fmt.Errorf("failed to store %s override: %w", override.Vendor, err)
+		}
+	}
+
 	return nil
 }
 
+// nullableString stores empty strings as NULL
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
 // EnableRule enables a security rule
 func (rm *RuleManager) EnableRule(id string) error {
+	defer rm.rulesChanged()
+
 	query := "UPDATE security_rules SET enabled = TRUE WHERE id = ?"
 
 	result, err := rm.db.Exec(query, id)
@@ -187,6 +772,8 @@ func (rm *RuleManager) EnableRule(id string) error {
 
 // DisableRule disables a security rule
 func (rm *RuleManager) DisableRule(id string) error {
+	defer rm.rulesChanged()
+
 	query := "UPDATE security_rules SET enabled = FALSE WHERE id = ?"
 
 	result, err := rm.db.Exec(query, id)
@@ -206,12 +793,90 @@ func (rm *RuleManager) DisableRule(id string) error {
 	return nil
 }
 
-// ruleExists checks if a rule with the given name and vendor already exists
-func (rm *RuleManager) ruleExists(name, vendor string) (bool, error) {
-	query := "SELECT COUNT(*) FROM security_rules WHERE name = ? AND vendor = ?"
+// SaveSkippedRules persists the rules that were skipped during a device run
+func (rm *RuleManager) SaveSkippedRules(skipped []SkippedRule) error {
+	return rm.SaveSkippedRulesContext(context.Background(), skipped)
+}
+
+// SaveSkippedRulesContext is SaveSkippedRules, rolling back when ctx ends
+func (rm *RuleManager) SaveSkippedRulesContext(ctx context.Context, skipped []SkippedRule) error {
+	if len(skipped) == 0 {
+		return nil
+	}
 
+	tx, err := rm.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO skipped_rules (run_id, device_id, rule_id, rule_name, reason, skipped_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	for _, skip := range skipped {
+		if skip.SkippedAt.IsZero() {
+			skip.SkippedAt = time.Now()
+		}
+		if _, err := tx.ExecContext(ctx, query, skip.RunID, skip.DeviceID, skip.RuleID, skip.RuleName,
+			string(skip.Reason), skip.SkippedAt); err != nil {
+			return fmt.Errorf("failed to record skipped rule %s: %w", skip.RuleName, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetSkippedRules retrieves the skipped rule records for a device, newest first
+func (rm *RuleManager) GetSkippedRules(deviceID string) ([]SkippedRule, error) {
+	return rm.GetSkippedRulesContext(context.Background(), deviceID)
+}
+
+// GetSkippedRulesContext is GetSkippedRules, stopping when ctx ends
+func (rm *RuleManager) GetSkippedRulesContext(ctx context.Context, deviceID string) ([]SkippedRule, error) {
+	return rm.querySkippedRules(ctx, "device_id = ?", deviceID)
+}
+
+// GetRunSkippedRulesContext retrieves the rules skipped on every device of
+// one run, newest first
+func (rm *RuleManager) GetRunSkippedRulesContext(ctx context.Context, runID string) ([]SkippedRule, error) {
+	return rm.querySkippedRules(ctx, "run_id = ?", runID)
+}
+
+// querySkippedRules returns the skipped rule records matching a condition
+func (rm *RuleManager) querySkippedRules(ctx context.Context, condition string, args ...interface{}) ([]SkippedRule, error) {
+	query := `
+		SELECT run_id, device_id, rule_id, rule_name, reason, skipped_at
+		FROM skipped_rules
+		WHERE ` + condition + `
+		ORDER BY skipped_at DESC, id DESC
+	`
+
+	rows, err := rm.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var skipped []SkippedRule
+	for rows.Next() {
+		var skip SkippedRule
+		var reason string
+		if err := rows.Scan(&skip.RunID, &skip.DeviceID, &skip.RuleID, &skip.RuleName, &reason, &skip.SkippedAt); err != nil {
+			return nil, err
+		}
+		skip.Reason = SkipReason(reason)
+		skipped = append(skipped, skip)
+	}
+
+	return skipped, rows.Err()
+}
+
+// ruleIDExists checks if a rule with the given ID exists
+func (rm *RuleManager) ruleIDExists(id string) (bool, error) {
 	var count int
-	err := rm.db.QueryRow(query, name, vendor).Scan(&count)
+	err := rm.db.QueryRow("SELECT COUNT(*) FROM security_rules WHERE id = ?", id).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -219,7 +884,24 @@ func (rm *RuleManager) ruleExists(name, vendor string) (bool, error) {
 	return count > 0, nil
 }
 
-// GetPredefinedRules returns predefined security rules for various vendors
+// findRule returns the rule with the given name and vendor, or nil if none exists
+func (rm *RuleManager) findRule(name, vendor string) (*SecurityRule, error) {
+	query := "SELECT " + ruleColumns + " FROM security_rules WHERE name = ? AND vendor = ? LIMIT 1"
+
+	rule, err := scanRule(rm.db.QueryRow(query, name, vendor))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+// GetPredefinedRules returns predefined security rules for various vendors,
+// Cisco rules before generic ones. Every call returns them in the same order
+// with the same IDs, derived from each rule's vendor and finding key.
 func GetPredefinedRules() []SecurityRule {
 	var rules []SecurityRule
 
@@ -232,12 +914,26 @@ func GetPredefinedRules() []SecurityRule {
 	return rules
 }
 
+// snmpCommunityPattern matches community strings other than the well-known
+// defaults public, private, cisco and snmp. Version 2 added cisco and snmp.
+const snmpCommunityPattern = `^$|snmp-server community [^pcs].*|` +
+	`snmp-server community p[^ru].*|snmp-server community pr[^i].*|snmp-server community pri[^v].*|` +
+	`snmp-server community c[^i].*|snmp-server community ci[^s].*|snmp-server community cis[^c].*|snmp-server community cisc[^o].*|` +
+	`snmp-server community s[^n].*|snmp-server community sn[^m].*|snmp-server community snm[^p].*`
+
+// ciscoHardeningGuide is Cisco's guide to hardening IOS devices, which the
+// predefined Cisco rules follow
+const ciscoHardeningGuide = "https://www.cisco.com/c/en/us/support/docs/ip/access-lists/13608-21.html"
+
 // getCiscoIOSRules returns Cisco IOS specific security rules
 func getCiscoIOSRules() []SecurityRule {
 	return []SecurityRule{
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "default-enable-password"),
 			Name:            "Check Default Enable Password",
+			Category:        CategoryAuthentication,
+			FindingCategory: FindingAuthentication,
+			FindingKey:      "default-enable-password",
 			Description:     "Verify that the default enable password is not being used",
 			Vendor:          "cisco",
 			Command:         "show running-config | include enable password",
@@ -245,10 +941,21 @@ func getCiscoIOSRules() []SecurityRule {
 			Severity:        string(SeverityCritical),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			Rationale: "The enable password grants privileged EXEC access to the whole device. A default or " +
+				"clear-text enable password is the first thing an attacker with CLI access tries, and one " +
+				"stored with reversible encoding can be read from any copy of the config.",
+			References: []string{
+				ciscoHardeningGuide,
+				"CIS Cisco IOS Benchmark, Management Plane: Password Rules",
+				"NIST SP 800-53 Rev. 5 IA-5: Authenticator Management",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "ssh-disabled"),
 			Name:            "Check SSH vs Telnet Configuration",
+			Category:        CategoryManagementAccess,
+			FindingCategory: FindingRemoteAccess,
+			FindingKey:      "ssh-disabled",
 			Description:     "Ensure SSH is enabled and Telnet is disabled for secure remote access",
 			Vendor:          "cisco",
 			Command:         "show ip ssh",
@@ -256,21 +963,46 @@ func getCiscoIOSRules() []SecurityRule {
 			Severity:        string(SeverityHigh),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			Rationale: "Telnet sends credentials and the whole management session in clear text, where anyone on " +
+				"the path can capture them. SSH encrypts the session and authenticates the device to the " +
+				"administrator.",
+			References: []string{
+				ciscoHardeningGuide,
+				"CIS Cisco IOS Benchmark, Management Plane: Access Rules",
+				"NIST SP 800-53 Rev. 5 AC-17: Remote Access",
+				"NIST SP 800-53 Rev. 5 SC-8: Transmission Confidentiality and Integrity",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "telnet-enabled"),
 			Name:            "Check Telnet VTY Lines",
-			Description:     "Verify that Telnet access is disabled on VTY lines",
+			Category:        CategoryManagementAccess,
+			FindingCategory: FindingRemoteAccess,
+			FindingKey:      "telnet-enabled",
+			Description:     "Verify that Telnet access is disabled on every block of VTY lines",
 			Vendor:          "cisco",
 			Command:         "show running-config | section line vty",
-			ExpectedPattern: `transport input ssh|transport input none`,
+			ExpectedPattern: `(?m)transport input (ssh|none)\s*$`,
 			Severity:        string(SeverityHigh),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			RuleVersion:     2,
+			AllMatch:        true,
+			SectionPattern:  `^line vty`,
+			Rationale: "VTY lines accept Telnet unless their transport input is restricted, and a single block " +
+				"of lines left open is enough to expose clear-text logins even when SSH is enabled.",
+			References: []string{
+				ciscoHardeningGuide,
+				"CIS Cisco IOS Benchmark, Management Plane: Access Rules",
+				"NIST SP 800-53 Rev. 5 AC-17: Remote Access",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "unused-interfaces-up"),
 			Name:            "Check Unused Interfaces",
+			Category:        CategoryNetworkServices,
+			FindingCategory: FindingManagementPlane,
+			FindingKey:      "unused-interfaces-up",
 			Description:     "Identify interfaces that are administratively up but not in use",
 			Vendor:          "cisco",
 			Command:         "show interfaces status | include notconnect",
@@ -278,10 +1010,19 @@ func getCiscoIOSRules() []SecurityRule {
 			Severity:        string(SeverityMedium),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			Rationale: "Interfaces left enabled without a connection let anyone with physical access plug into " +
+				"the network, often straight into a production VLAN. Shutting them down removes that " +
+				"entry point.",
+			References: []string{
+				"NIST SP 800-53 Rev. 5 CM-7: Least Functionality",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "console-password-missing"),
 			Name:            "Check Console Password",
+			Category:        CategoryAuthentication,
+			FindingCategory: FindingAuthentication,
+			FindingKey:      "console-password-missing",
 			Description:     "Verify that console access is password protected",
 			Vendor:          "cisco",
 			Command:         "show running-config | section line con",
@@ -289,21 +1030,43 @@ func getCiscoIOSRules() []SecurityRule {
 			Severity:        string(SeverityHigh),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			Rationale: "Anyone with physical access to the console port gets a CLI session, and without a " +
+				"password or local login that session is unauthenticated.",
+			References: []string{
+				ciscoHardeningGuide,
+				"CIS Cisco IOS Benchmark, Management Plane: Access Rules",
+				"NIST SP 800-53 Rev. 5 IA-2: Identification and Authentication (Organizational Users)",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "snmp-default-community"),
 			Name:            "Check SNMP Community Strings",
+			Category:        CategoryNetworkServices,
+			FindingCategory: FindingSNMP,
+			FindingKey:      "snmp-default-community",
 			Description:     "Verify that default SNMP community strings are not in use",
 			Vendor:          "cisco",
 			Command:         "show running-config | include snmp-server community",
-			ExpectedPattern: `^$|snmp-server community [^p].*|snmp-server community p[^ru].*|snmp-server community pr[^i].*|snmp-server community pri[^v].*`,
+			ExpectedPattern: snmpCommunityPattern,
 			Severity:        string(SeverityCritical),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			RuleVersion:     2,
+			Rationale: "Default community strings such as public and private are tried by every scanner. With " +
+				"one configured, anyone who can reach the device reads its configuration and state, or " +
+				"changes it through a read-write community.",
+			References: []string{
+				ciscoHardeningGuide,
+				"CIS Cisco IOS Benchmark, Management Plane: SNMP Rules",
+				"NIST SP 800-53 Rev. 5 IA-5: Authenticator Management",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "password-encryption-disabled"),
 			Name:            "Check Service Password Encryption",
+			Category:        CategoryAuthentication,
+			FindingCategory: FindingAuthentication,
+			FindingKey:      "password-encryption-disabled",
 			Description:     "Ensure password encryption service is enabled",
 			Vendor:          "cisco",
 			Command:         "show running-config | include service password-encryption",
@@ -311,10 +1074,20 @@ func getCiscoIOSRules() []SecurityRule {
 			Severity:        string(SeverityMedium),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			Rationale: "Without password encryption, line and local user passwords are stored in clear text and " +
+				"show up in every config backup, screen share and support case.",
+			References: []string{
+				ciscoHardeningGuide,
+				"CIS Cisco IOS Benchmark, Management Plane: Password Rules",
+				"NIST SP 800-53 Rev. 5 IA-5: Authenticator Management",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "login-banner-missing"),
 			Name:            "Check Login Banner",
+			Category:        CategoryManagementAccess,
+			FindingCategory: FindingManagementPlane,
+			FindingKey:      "login-banner-missing",
 			Description:     "Verify that a login banner is configured for legal compliance",
 			Vendor:          "cisco",
 			Command:         "show running-config | include banner",
@@ -322,10 +1095,20 @@ func getCiscoIOSRules() []SecurityRule {
 			Severity:        string(SeverityLow),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			Rationale: "A banner warning against unauthorized access is required by most security policies and " +
+				"supports legal action against intruders, who cannot claim they were not told.",
+			References: []string{
+				ciscoHardeningGuide,
+				"CIS Cisco IOS Benchmark, Management Plane: Banner Rules",
+				"NIST SP 800-53 Rev. 5 AC-8: System Use Notification",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "http-server-enabled"),
 			Name:            "Check HTTP/HTTPS Server Status",
+			Category:        CategoryManagementAccess,
+			FindingCategory: FindingRemoteAccess,
+			FindingKey:      "http-server-enabled",
 			Description:     "Verify that HTTP server is disabled and HTTPS is used if web management is needed",
 			Vendor:          "cisco",
 			Command:         "show running-config | include ip http",
@@ -333,10 +1116,21 @@ func getCiscoIOSRules() []SecurityRule {
 			Severity:        string(SeverityHigh),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			Rationale: "The HTTP server exposes web management with credentials in clear text and widens the " +
+				"device's attack surface. It should be disabled, with HTTPS used where web management is " +
+				"needed.",
+			References: []string{
+				ciscoHardeningGuide,
+				"NIST SP 800-53 Rev. 5 CM-7: Least Functionality",
+				"NIST SP 800-53 Rev. 5 SC-8: Transmission Confidentiality and Integrity",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("cisco", "cdp-enabled"),
 			Name:            "Check CDP Configuration",
+			Category:        CategoryNetworkServices,
+			FindingCategory: FindingManagementPlane,
+			FindingKey:      "cdp-enabled",
 			Description:     "Verify CDP is disabled on interfaces facing untrusted networks",
 			Vendor:          "cisco",
 			Command:         "show cdp neighbors",
@@ -344,6 +1138,14 @@ func getCiscoIOSRules() []SecurityRule {
 			Severity:        string(SeverityMedium),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			Rationale: "CDP advertises the device's platform, software version and addresses to every neighbor, " +
+				"which helps an attacker choose exploits. It should be off on interfaces facing untrusted " +
+				"networks.",
+			References: []string{
+				ciscoHardeningGuide,
+				"CIS Cisco IOS Benchmark, Control Plane: Global Service Rules",
+				"NIST SP 800-53 Rev. 5 CM-7: Least Functionality",
+			},
 		},
 	}
 }
@@ -352,8 +1154,11 @@ func getCiscoIOSRules() []SecurityRule {
 func getGenericRules() []SecurityRule {
 	return []SecurityRule{
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("generic", "uptime-review"),
 			Name:            "Check System Uptime",
+			Category:        CategorySystem,
+			FindingCategory: FindingFirmware,
+			FindingKey:      "uptime-review",
 			Description:     "Monitor system uptime to identify devices that may need updates",
 			Vendor:          "generic",
 			Command:         "show version | include uptime",
@@ -361,10 +1166,22 @@ func getGenericRules() []SecurityRule {
 			Severity:        string(SeverityLow),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			VendorOverrides: []VendorOverride{
+				{Vendor: "juniper", Command: "show system uptime", ExpectedPattern: `(?i)system booted|up \d+`},
+				{Vendor: "mikrotik", Command: "/system resource print", ExpectedPattern: `uptime:`},
+			},
+			Rationale: "A device that has not restarted in years has most likely not had its software updated, " +
+				"and still runs with every vulnerability fixed since.",
+			References: []string{
+				"NIST SP 800-53 Rev. 5 SI-2: Flaw Remediation",
+			},
 		},
 		{
-			ID:              uuid.New().String(),
+			ID:              predefinedRuleID("generic", "running-config-inaccessible"),
 			Name:            "Check Running Configuration",
+			Category:        CategorySystem,
+			FindingCategory: FindingManagementPlane,
+			FindingKey:      "running-config-inaccessible",
 			Description:     "Verify that running configuration can be accessed",
 			Vendor:          "generic",
 			Command:         "show running-config | head -5",
@@ -372,6 +1189,11 @@ func getGenericRules() []SecurityRule {
 			Severity:        string(SeverityLow),
 			Enabled:         true,
 			CreatedAt:       time.Now(),
+			Rationale: "Every other check reads the configuration. A device whose configuration cannot be read " +
+				"cannot be audited, usually because the account used lacks privileges.",
+			References: []string{
+				"NIST SP 800-53 Rev. 5 CM-6: Configuration Settings",
+			},
 		},
 	}
 }
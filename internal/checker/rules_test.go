@@ -1,7 +1,11 @@
 package checker
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"reflect"
+	"regexp"
 	"testing"
 	"time"
 
@@ -9,6 +13,177 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// testRulesSchema mirrors the checker tables created by the database migrations
+const testRulesSchema = `
+	CREATE TABLE devices (
+		id TEXT PRIMARY KEY,
+		ip_address TEXT NOT NULL,
+		is_sandbox BOOLEAN NOT NULL DEFAULT FALSE
+	);
+	CREATE TABLE security_rules (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT,
+		vendor TEXT NOT NULL,
+		command TEXT NOT NULL,
+		expected_pattern TEXT,
+		severity TEXT NOT NULL,
+		enabled BOOLEAN DEFAULT TRUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		command_overrides TEXT,
+		rule_version INTEGER DEFAULT 1,
+		all_match BOOLEAN DEFAULT FALSE,
+		section_pattern TEXT,
+		needs_attention BOOLEAN NOT NULL DEFAULT FALSE,
+		expected_exit_code INTEGER,
+		stream_target TEXT NOT NULL DEFAULT '',
+		category TEXT NOT NULL DEFAULT '',
+		remediation TEXT NOT NULL DEFAULT '',
+		evidence_lines INTEGER NOT NULL DEFAULT 0,
+		finding_category TEXT NOT NULL DEFAULT '',
+		finding_key TEXT NOT NULL DEFAULT '',
+		precondition_command TEXT,
+		precondition_pattern TEXT,
+		rationale TEXT NOT NULL DEFAULT '',
+		rule_references TEXT,
+		depends_on TEXT,
+		predefined BOOLEAN NOT NULL DEFAULT FALSE,
+		source_version INTEGER NOT NULL DEFAULT 0,
+		user_modified BOOLEAN NOT NULL DEFAULT FALSE
+	);
+	CREATE TABLE skipped_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id TEXT NOT NULL DEFAULT '',
+		device_id TEXT NOT NULL,
+		rule_id TEXT NOT NULL,
+		rule_name TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		skipped_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE rule_vendor_overrides (
+		rule_id TEXT NOT NULL,
+		vendor TEXT NOT NULL,
+		command TEXT NOT NULL,
+		expected_pattern TEXT,
+		PRIMARY KEY (rule_id, vendor)
+	);
+	CREATE TABLE rule_command_acknowledgements (
+		rule_id TEXT NOT NULL,
+		command TEXT NOT NULL,
+		acknowledged_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (rule_id, command)
+	);
+	CREATE TABLE app_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE check_results (
+		id TEXT PRIMARY KEY,
+		device_id TEXT NOT NULL,
+		check_name TEXT NOT NULL,
+		check_type TEXT NOT NULL,
+		severity TEXT NOT NULL,
+		status TEXT NOT NULL,
+		message TEXT,
+		evidence TEXT,
+		checked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		run_id TEXT,
+		command_variant TEXT,
+		command TEXT,
+		message_id TEXT,
+		message_params TEXT,
+		evidence_gzip BLOB,
+		original_severity TEXT,
+		severity_override_reason TEXT,
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		evidence_stream TEXT,
+		evidence_full_path TEXT,
+		finding_category TEXT,
+		finding_key TEXT,
+		fingerprint TEXT,
+		carried_forward BOOLEAN NOT NULL DEFAULT FALSE,
+		evaluated_at DATETIME,
+		carried_from_run_id TEXT,
+		rule_id TEXT
+	);
+	CREATE TABLE device_change_indicators (
+		device_id TEXT PRIMARY KEY,
+		run_id TEXT NOT NULL,
+		indicator TEXT NOT NULL,
+		rule_states TEXT NOT NULL DEFAULT '{}',
+		recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE check_result_comments (
+		id TEXT PRIMARY KEY,
+		check_result_id TEXT NOT NULL,
+		author_id TEXT NOT NULL,
+		body TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE config_snapshots (
+		id TEXT PRIMARY KEY,
+		device_id TEXT NOT NULL,
+		config_gzip BLOB NOT NULL,
+		config_size INTEGER NOT NULL,
+		config_hash TEXT NOT NULL,
+		captured_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE device_ssh_posture (
+		device_id TEXT PRIMARY KEY,
+		key_exchange TEXT NOT NULL DEFAULT '',
+		host_key TEXT NOT NULL DEFAULT '',
+		cipher TEXT NOT NULL DEFAULT '',
+		mac TEXT NOT NULL DEFAULT '',
+		server_version TEXT NOT NULL DEFAULT '',
+		observed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE severity_overrides (
+		id TEXT PRIMARY KEY,
+		scope TEXT NOT NULL,
+		target TEXT NOT NULL,
+		rule_id TEXT NOT NULL,
+		severity TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		expires_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (scope, target, rule_id)
+	);
+	CREATE TABLE command_macros (
+		name TEXT PRIMARY KEY,
+		command TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE check_runs (
+		run_id TEXT PRIMARY KEY,
+		label TEXT NOT NULL DEFAULT '',
+		note TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE device_connection_metrics (
+		device_id TEXT NOT NULL,
+		phase TEXT NOT NULL,
+		samples INTEGER NOT NULL DEFAULT 0,
+		total_ms INTEGER NOT NULL DEFAULT 0,
+		max_ms INTEGER NOT NULL DEFAULT 0,
+		buckets TEXT NOT NULL DEFAULT '[]',
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (device_id, phase)
+	);
+	CREATE TABLE compliance_snapshots (
+		id TEXT PRIMARY KEY,
+		device_id TEXT NOT NULL,
+		score REAL NOT NULL,
+		pass_count INTEGER NOT NULL DEFAULT 0,
+		fail_count INTEGER NOT NULL DEFAULT 0,
+		snapshot_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+`
+
 // setupTestDB creates an in-memory SQLite database for testing
 func setupTestDB(t *testing.T) *sql.DB {
 	db, err := sql.Open("sqlite3", ":memory:")
@@ -16,23 +191,11 @@ func setupTestDB(t *testing.T) *sql.DB {
 		t.Fatalf("Failed to open test database: %v", err)
 	}
 
-	// Create security_rules table
-	createTableSQL := `
-		CREATE TABLE security_rules (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			description TEXT,
-			vendor TEXT NOT NULL,
-			command TEXT NOT NULL,
-			expected_pattern TEXT,
-			severity TEXT NOT NULL,
-			enabled BOOLEAN DEFAULT TRUE,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-	`
+	// Each in-memory connection is a separate database, so keep a single one
+	db.SetMaxOpenConns(1)
 
-	if _, err := db.Exec(createTableSQL); err != nil {
-		t.Fatalf("Failed to create test table: %v", err)
+	if _, err := db.Exec(testRulesSchema); err != nil {
+		t.Fatalf("Failed to create test tables: %v", err)
 	}
 
 	return db
@@ -199,6 +362,7 @@ func TestRuleManager_UpdateRule(t *testing.T) {
 	rule.Name = "Updated Rule"
 	rule.Description = "Updated Description"
 	rule.Severity = string(SeverityCritical)
+	rule.Remediation = "Run 'ip ssh version 2' in configuration mode"
 
 	if err := rm.UpdateRule(rule); err != nil {
 		t.Fatalf("Failed to update rule: %v", err)
@@ -224,6 +388,9 @@ func TestRuleManager_UpdateRule(t *testing.T) {
 	if updatedRule.Severity != string(SeverityCritical) {
 		t.Errorf("Expected severity %s, got %s", string(SeverityCritical), updatedRule.Severity)
 	}
+	if updatedRule.Remediation != rule.Remediation {
+		t.Errorf("Expected remediation %q, got %q", rule.Remediation, updatedRule.Remediation)
+	}
 }
 
 func TestRuleManager_DeleteRule(t *testing.T) {
@@ -379,6 +546,139 @@ func TestRuleManager_LoadPredefinedRules(t *testing.T) {
 	}
 }
 
+func TestRuleManager_CommandOverrides(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rm := NewRuleManager(db)
+
+	rule := SecurityRule{
+		ID:              "override-rule",
+		Name:            "Check Running Configuration",
+		Vendor:          "generic",
+		Command:         "show running-config | head -5",
+		ExpectedPattern: ".*",
+		Severity:        string(SeverityLow),
+		Enabled:         true,
+		CommandOverrides: map[string]string{
+			"firewall":      "show full-configuration",
+			"load_balancer": "show running-config sys",
+		},
+	}
+
+	if err := rm.CreateRule(rule); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+
+	rules, err := rm.GetRulesByVendor("fortinet")
+	if err != nil {
+		t.Fatalf("Failed to get rules: %v", err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("Expected 1 rule, got %d", len(rules))
+	}
+
+	stored := rules[0]
+	if len(stored.CommandOverrides) != 2 {
+		t.Fatalf("Expected 2 command overrides, got %d", len(stored.CommandOverrides))
+	}
+	if got := stored.CommandFor("firewall"); got != "show full-configuration" {
+		t.Errorf("Expected firewall override, got %s", got)
+	}
+	if got := stored.CommandFor("router"); got != rule.Command {
+		t.Errorf("Expected default command for router, got %s", got)
+	}
+
+	// Clearing the overrides falls back to the default command everywhere
+	stored.CommandOverrides = nil
+	if err := rm.UpdateRule(stored); err != nil {
+		t.Fatalf("Failed to update rule: %v", err)
+	}
+
+	rules, err = rm.GetAllRules()
+	if err != nil {
+		t.Fatalf("Failed to get rules: %v", err)
+	}
+	if len(rules[0].CommandOverrides) != 0 {
+		t.Errorf("Expected overrides to be cleared, got %v", rules[0].CommandOverrides)
+	}
+	if got := rules[0].CommandFor("firewall"); got != rule.Command {
+		t.Errorf("Expected default command after clearing overrides, got %s", got)
+	}
+}
+
+func TestRuleManager_SaveSkippedRules(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rm := NewRuleManager(db)
+
+	reasons := []SkipReason{
+		SkipReasonDisabled,
+		SkipReasonVendor,
+		SkipReasonSuppressed,
+		SkipReasonFiltered,
+		SkipReasonMaintenance,
+		SkipReasonCircuitOpen,
+	}
+
+	var skipped []SkippedRule
+	for i, reason := range reasons {
+		skipped = append(skipped, SkippedRule{
+			RunID:     "run1",
+			DeviceID:  "device1",
+			RuleID:    uuid.New().String(),
+			RuleName:  string(reason),
+			Reason:    reason,
+			SkippedAt: time.Now().Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	if err := rm.SaveSkippedRules(skipped); err != nil {
+		t.Fatalf("Failed to save skipped rules: %v", err)
+	}
+
+	stored, err := rm.GetSkippedRules("device1")
+	if err != nil {
+		t.Fatalf("Failed to get skipped rules: %v", err)
+	}
+
+	if len(stored) != len(reasons) {
+		t.Fatalf("Expected %d skipped rules, got %d", len(reasons), len(stored))
+	}
+
+	// Newest first, so the order is the reverse of insertion
+	for i, skip := range stored {
+		expected := reasons[len(reasons)-1-i]
+		if skip.Reason != expected {
+			t.Errorf("Expected reason %s at position %d, got %s", expected, i, skip.Reason)
+		}
+		if skip.RuleName != string(expected) {
+			t.Errorf("Expected rule name %s, got %s", expected, skip.RuleName)
+		}
+		if skip.RunID != "run1" {
+			t.Errorf("Expected run ID run1, got %q", skip.RunID)
+		}
+	}
+
+	byRun, err := rm.GetRunSkippedRulesContext(context.Background(), "run1")
+	if err != nil {
+		t.Fatalf("Failed to get skipped rules of run: %v", err)
+	}
+	if len(byRun) != len(reasons) {
+		t.Errorf("Expected %d skipped rules in run1, got %d", len(reasons), len(byRun))
+	}
+
+	// Other devices are unaffected
+	other, err := rm.GetSkippedRules("device2")
+	if err != nil {
+		t.Fatalf("Failed to get skipped rules: %v", err)
+	}
+	if len(other) != 0 {
+		t.Errorf("Expected no skipped rules for device2, got %d", len(other))
+	}
+}
+
 func TestGetPredefinedRules(t *testing.T) {
 	rules := GetPredefinedRules()
 
@@ -498,3 +798,448 @@ func TestGetGenericRules(t *testing.T) {
 		}
 	}
 }
+
+func TestRuleManager_VendorOverrides(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rm := NewRuleManager(db)
+
+	rule := SecurityRule{
+		ID:              "uptime",
+		Name:            "Check System Uptime",
+		Vendor:          "generic",
+		Command:         "show version | include uptime",
+		ExpectedPattern: ".*uptime.*",
+		Severity:        string(SeverityLow),
+		Enabled:         true,
+		VendorOverrides: []VendorOverride{
+			{Vendor: "juniper", Command: "show system uptime", ExpectedPattern: "System booted"},
+		},
+	}
+	if err := rm.CreateRule(rule); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+
+	if err := rm.SetVendorOverride("uptime", VendorOverride{Vendor: "mikrotik", Command: "/system resource print"}); err != nil {
+		t.Fatalf("Failed to set vendor override: %v", err)
+	}
+
+	// Setting an override again replaces it
+	if err := rm.SetVendorOverride("uptime", VendorOverride{Vendor: "juniper", Command: "show system uptime | no-more"}); err != nil {
+		t.Fatalf("Failed to replace vendor override: %v", err)
+	}
+
+	overrides, err := rm.GetVendorOverrides("uptime")
+	if err != nil {
+		t.Fatalf("Failed to get vendor overrides: %v", err)
+	}
+	expected := []VendorOverride{
+		{Vendor: "juniper", Command: "show system uptime | no-more"},
+		{Vendor: "mikrotik", Command: "/system resource print"},
+	}
+	if !reflect.DeepEqual(overrides, expected) {
+		t.Errorf("Expected overrides %v, got %v", expected, overrides)
+	}
+
+	rules, err := rm.GetAllRules()
+	if err != nil {
+		t.Fatalf("Failed to get rules: %v", err)
+	}
+	if len(rules) != 1 || !reflect.DeepEqual(rules[0].VendorOverrides, expected) {
+		t.Errorf("Expected overrides to be loaded with the rule, got %v", rules)
+	}
+
+	if err := rm.SetVendorOverride("missing", VendorOverride{Vendor: "juniper", Command: "show"}); err == nil {
+		t.Error("Expected error when setting an override on a non-existent rule")
+	}
+
+	if err := rm.DeleteVendorOverride("uptime", "mikrotik"); err != nil {
+		t.Fatalf("Failed to delete vendor override: %v", err)
+	}
+	if err := rm.DeleteVendorOverride("uptime", "mikrotik"); err == nil {
+		t.Error("Expected error when deleting a missing override")
+	}
+
+	// Updating the rule replaces its overrides
+	rule.VendorOverrides = []VendorOverride{{Vendor: "arista", Command: "show uptime"}}
+	if err := rm.UpdateRule(rule); err != nil {
+		t.Fatalf("Failed to update rule: %v", err)
+	}
+	overrides, err = rm.GetVendorOverrides("uptime")
+	if err != nil {
+		t.Fatalf("Failed to get vendor overrides: %v", err)
+	}
+	if len(overrides) != 1 || overrides[0].Vendor != "arista" {
+		t.Errorf("Expected only the arista override after update, got %v", overrides)
+	}
+
+	if err := rm.DeleteRule("uptime"); err != nil {
+		t.Fatalf("Failed to delete rule: %v", err)
+	}
+	overrides, err = rm.GetVendorOverrides("uptime")
+	if err != nil {
+		t.Fatalf("Failed to get vendor overrides: %v", err)
+	}
+	if len(overrides) != 0 {
+		t.Errorf("Expected overrides to be removed with the rule, got %v", overrides)
+	}
+}
+
+func TestRuleManager_GetRulesByVendorWithOverrides(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rm := NewRuleManager(db)
+
+	rules := []SecurityRule{
+		{ID: "generic", Name: "Generic Rule", Vendor: "generic", Command: "show version",
+			Severity: string(SeverityLow), Enabled: true,
+			VendorOverrides: []VendorOverride{{Vendor: "juniper", Command: "show version brief"}}},
+		{ID: "cisco", Name: "Cisco Rule", Vendor: "cisco", Command: "show ip ssh",
+			Severity: string(SeverityHigh), Enabled: true,
+			VendorOverrides: []VendorOverride{{Vendor: "juniper", Command: "show system services ssh"}}},
+		{ID: "arista", Name: "Arista Rule", Vendor: "arista", Command: "show management ssh",
+			Severity: string(SeverityHigh), Enabled: true},
+	}
+	for _, rule := range rules {
+		if err := rm.CreateRule(rule); err != nil {
+			t.Fatalf("Failed to create rule %s: %v", rule.ID, err)
+		}
+	}
+
+	juniperRules, err := rm.GetRulesByVendor("juniper")
+	if err != nil {
+		t.Fatalf("Failed to get rules by vendor: %v", err)
+	}
+
+	// The generic rule applies both as generic and through its override, but is returned once
+	var ids []string
+	for _, rule := range juniperRules {
+		ids = append(ids, rule.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"cisco", "generic"}) {
+		t.Errorf("Expected rules [cisco generic] for juniper, got %v", ids)
+	}
+}
+
+func TestRuleManager_AllMatchRule(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rm := NewRuleManager(db)
+
+	rule := SecurityRule{
+		ID:              "vty",
+		Name:            "Check Telnet VTY Lines",
+		Vendor:          "cisco",
+		Command:         "show running-config | section line vty",
+		ExpectedPattern: "transport input ssh",
+		Severity:        string(SeverityHigh),
+		Enabled:         true,
+		AllMatch:        true,
+		SectionPattern:  "^line vty",
+	}
+	if err := rm.CreateRule(rule); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+
+	rules, err := rm.GetRulesByVendor("cisco")
+	if err != nil {
+		t.Fatalf("Failed to get rules: %v", err)
+	}
+	if len(rules) != 1 || !rules[0].AllMatch || rules[0].SectionPattern != "^line vty" {
+		t.Fatalf("Expected all-match settings to be stored, got %+v", rules)
+	}
+
+	rule.AllMatch = false
+	rule.SectionPattern = ""
+	if err := rm.UpdateRule(rule); err != nil {
+		t.Fatalf("Failed to update rule: %v", err)
+	}
+
+	rules, err = rm.GetAllRules()
+	if err != nil {
+		t.Fatalf("Failed to get rules: %v", err)
+	}
+	if rules[0].AllMatch || rules[0].SectionPattern != "" {
+		t.Errorf("Expected all-match settings to be cleared, got %+v", rules[0])
+	}
+}
+
+func TestSecurityRule_ForDevice(t *testing.T) {
+	rule := SecurityRule{
+		Command:          "show version | include uptime",
+		ExpectedPattern:  ".*uptime.*",
+		CommandOverrides: map[string]string{"firewall": "get system status"},
+		VendorOverrides: []VendorOverride{
+			{Vendor: "juniper", Command: "show system uptime", ExpectedPattern: "System booted"},
+			{Vendor: "mikrotik", Command: "/system resource print"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		vendor     string
+		deviceType string
+		command    string
+		pattern    string
+		variant    string
+	}{
+		{"vendor override with pattern", "juniper", "firewall", "show system uptime", "System booted", "vendor:juniper"},
+		{"vendor override keeps base pattern", "mikrotik", "router", "/system resource print", ".*uptime.*", "vendor:mikrotik"},
+		{"device type override", "fortinet", "firewall", "get system status", ".*uptime.*", "device-type:firewall"},
+		{"base", "cisco", "router", "show version | include uptime", ".*uptime.*", "base"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			effective, variant := rule.ForDevice(tt.vendor, tt.deviceType)
+			if effective.Command != tt.command {
+				t.Errorf("Expected command %q, got %q", tt.command, effective.Command)
+			}
+			if effective.ExpectedPattern != tt.pattern {
+				t.Errorf("Expected pattern %q, got %q", tt.pattern, effective.ExpectedPattern)
+			}
+			if variant != tt.variant {
+				t.Errorf("Expected variant %q, got %q", tt.variant, variant)
+			}
+		})
+	}
+}
+
+func TestRuleManager_LoadPredefinedRulesUpdatesVersion(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rm := NewRuleManager(db)
+
+	// A rule loaded by an older release, disabled by the user since
+	stored := SecurityRule{
+		ID:              "snmp",
+		Name:            "Check SNMP Community Strings",
+		Vendor:          "cisco",
+		Command:         "show running-config | include snmp-server community",
+		ExpectedPattern: "old-pattern",
+		Severity:        string(SeverityCritical),
+		Enabled:         false,
+		RuleVersion:     1,
+	}
+	if err := rm.CreateRule(stored); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+
+	predefined := stored
+	predefined.ID = uuid.New().String()
+	predefined.ExpectedPattern = "new-pattern"
+	predefined.Enabled = true
+	predefined.RuleVersion = 2
+
+	if err := rm.loadPredefinedRules([]SecurityRule{predefined}); err != nil {
+		t.Fatalf("Failed to load predefined rules: %v", err)
+	}
+
+	rules, err := rm.GetAllRules()
+	if err != nil {
+		t.Fatalf("Failed to get rules: %v", err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("Expected 1 rule, got %d", len(rules))
+	}
+
+	rule := rules[0]
+	if rule.ID != predefined.ID {
+		t.Errorf("Expected the stored rule to move to the predefined ID, got %s", rule.ID)
+	}
+	if !rule.Predefined || rule.UserModified {
+		t.Errorf("Expected an unmodified predefined rule, got predefined %v, modified %v", rule.Predefined, rule.UserModified)
+	}
+	if rule.ExpectedPattern != "new-pattern" {
+		t.Errorf("Expected pattern to be updated, got %s", rule.ExpectedPattern)
+	}
+	if rule.RuleVersion != 2 {
+		t.Errorf("Expected rule version 2, got %d", rule.RuleVersion)
+	}
+	if rule.Enabled {
+		t.Error("Expected the user's disabled state to be kept")
+	}
+
+	if rule.SourceVersion != 2 {
+		t.Errorf("Expected source version 2, got %d", rule.SourceVersion)
+	}
+
+	// An older predefined version never downgrades the stored rule
+	predefined.ExpectedPattern = "older-pattern"
+	predefined.RuleVersion = 1
+	if err := rm.loadPredefinedRules([]SecurityRule{predefined}); err != nil {
+		t.Fatalf("Failed to load predefined rules: %v", err)
+	}
+
+	rules, err = rm.GetAllRules()
+	if err != nil {
+		t.Fatalf("Failed to get rules: %v", err)
+	}
+	if rules[0].ExpectedPattern != "new-pattern" {
+		t.Errorf("Expected pattern to stay at version 2, got %s", rules[0].ExpectedPattern)
+	}
+}
+
+func TestSNMPCommunityPattern(t *testing.T) {
+	regex := regexp.MustCompile(snmpCommunityPattern)
+
+	tests := map[string]bool{
+		"": true,
+		"snmp-server community MySecureString RO": true,
+		"snmp-server community corp-ro RO":        true,
+		"snmp-server community public RO":         false,
+		"snmp-server community private RW":        false,
+		"snmp-server community cisco RO":          false,
+		"snmp-server community snmp RO":           false,
+	}
+
+	for input, expected := range tests {
+		if got := regex.MatchString(input); got != expected {
+			t.Errorf("Pattern match for %q: expected %v, got %v", input, expected, got)
+		}
+	}
+}
+
+func TestRuleManager_CloneRule(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rm := NewRuleManager(db)
+
+	source := SecurityRule{
+		ID:               "ssh-v2",
+		Name:             "SSH Version 2",
+		Description:      "Only SSH version 2 is allowed",
+		Vendor:           "cisco",
+		Command:          "show ip ssh",
+		ExpectedPattern:  "version 2",
+		Severity:         string(SeverityHigh),
+		Enabled:          true,
+		CommandOverrides: map[string]string{"switch": "show ip ssh | include version"},
+		VendorOverrides: []VendorOverride{
+			{Vendor: "arista", Command: "show management ssh", ExpectedPattern: "SSHv2"},
+			{Vendor: "juniper", Command: "show configuration system services ssh"},
+		},
+	}
+	if err := rm.CreateRule(source); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+
+	clone, err := rm.CloneRule("ssh-v2", "arista")
+	if err != nil {
+		t.Fatalf("Failed to clone rule: %v", err)
+	}
+	if clone.ID == source.ID || clone.Vendor != "arista" || clone.Name != source.Name {
+		t.Errorf("Unexpected clone identity: %+v", clone)
+	}
+	// The arista override becomes the clone's own command and pattern
+	if clone.Command != "show management ssh" || clone.ExpectedPattern != "SSHv2" || len(clone.VendorOverrides) != 0 {
+		t.Errorf("Expected arista override to be promoted, got %+v", clone)
+	}
+
+	stored, err := rm.GetRule(clone.ID)
+	if err != nil {
+		t.Fatalf("Failed to get clone: %v", err)
+	}
+	if stored.Severity != source.Severity || !stored.Enabled ||
+		!reflect.DeepEqual(stored.CommandOverrides, source.CommandOverrides) {
+		t.Errorf("Expected clone to keep severity, state and device type overrides, got %+v", stored)
+	}
+
+	// The clone is edited independently of its source
+	stored.Command = "show management ssh | include version"
+	if err := rm.UpdateRule(*stored); err != nil {
+		t.Fatalf("Failed to update clone: %v", err)
+	}
+	original, err := rm.GetRule("ssh-v2")
+	if err != nil {
+		t.Fatalf("Failed to get source rule: %v", err)
+	}
+	if original.Command != "show ip ssh" || len(original.VendorOverrides) != 2 {
+		t.Errorf("Expected source rule to be unchanged, got %+v", original)
+	}
+
+	// Cloning into a vendor that already has the name adds a suffix
+	copy1, err := rm.CloneRule("ssh-v2", "cisco")
+	if err != nil {
+		t.Fatalf("Failed to duplicate rule: %v", err)
+	}
+	copy2, err := rm.CloneRule("ssh-v2", "cisco")
+	if err != nil {
+		t.Fatalf("Failed to duplicate rule again: %v", err)
+	}
+	if copy1.Name != "SSH Version 2 (copy)" || copy2.Name != "SSH Version 2 (copy 2)" {
+		t.Errorf("Expected copy suffixes, got %q and %q", copy1.Name, copy2.Name)
+	}
+
+	// A generic clone keeps every vendor override
+	generic, err := rm.CloneRule("ssh-v2", "generic")
+	if err != nil {
+		t.Fatalf("Failed to clone to generic: %v", err)
+	}
+	if len(generic.VendorOverrides) != 2 {
+		t.Errorf("Expected generic clone to keep vendor overrides, got %v", generic.VendorOverrides)
+	}
+
+	if _, err := rm.CloneRule("ssh-v2", "acme"); err == nil {
+		t.Error("Expected error for an unknown vendor")
+	}
+	if _, err := rm.CloneRule("missing", "arista"); err == nil {
+		t.Error("Expected error for a missing rule")
+	}
+}
+
+func TestRuleManager_ContextCancelled(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rm := NewRuleManager(db)
+	rule := SecurityRule{ID: "r1", Name: "SSH version", Vendor: "cisco", Command: "show ip ssh",
+		ExpectedPattern: "version 2", Severity: string(SeverityHigh), Enabled: true}
+	if err := rm.CreateRule(rule); err != nil {
+		t.Fatalf("Failed to create rule: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := rm.GetRulesByVendorContext(ctx, "cisco"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected GetRulesByVendorContext to stop, got %v", err)
+	}
+	if _, err := rm.GetRulesByCategoryContext(ctx, CategorySystem); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected GetRulesByCategoryContext to stop, got %v", err)
+	}
+	if _, err := rm.GetAllRulesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected GetAllRulesContext to stop, got %v", err)
+	}
+	if _, err := rm.GetRuleContext(ctx, "r1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected GetRuleContext to stop, got %v", err)
+	}
+	if _, err := rm.GetSkippedRulesContext(ctx, "dev1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected GetSkippedRulesContext to stop, got %v", err)
+	}
+	skip := []SkippedRule{{DeviceID: "dev1", RuleID: "r1", RuleName: "SSH version", Reason: SkipReasonDisabled}}
+	if err := rm.SaveSkippedRulesContext(ctx, skip); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected SaveSkippedRulesContext to stop, got %v", err)
+	}
+
+	// The wrappers run without a deadline
+	rules, err := rm.GetRulesByVendor("cisco")
+	if err != nil || len(rules) != 1 {
+		t.Fatalf("Expected one cisco rule, got %d, %v", len(rules), err)
+	}
+
+	// Skip records of a cancelled run are still kept for the audit trail
+	NewEngine(rm).recordSkippedRules(ctx, "run1", skip)
+	skipped, err := rm.GetSkippedRules("dev1")
+	if err != nil || len(skipped) != 1 {
+		t.Fatalf("Expected the skip record to be saved, got %d, %v", len(skipped), err)
+	}
+	if skipped[0].RunID != "run1" {
+		t.Errorf("Expected the skip record to carry the run ID, got %q", skipped[0].RunID)
+	}
+}
@@ -0,0 +1,61 @@
+package checker
+
+import (
+	"time"
+)
+
+// rulesCacheEntry holds the enabled rules of one vendor with the rule
+// generation they were read at
+type rulesCacheEntry struct {
+	rules      []SecurityRule
+	generation uint64
+	fetchedAt  time.Time
+}
+
+// SetRulesCacheTTL makes cached rules expire after ttl so long-running
+// processes pick up rule changes made outside this engine's rule manager.
+// Zero keeps them until the rules change or the cache is invalidated.
+func (e *Engine) SetRulesCacheTTL(ttl time.Duration) {
+	if ttl < 0 {
+		ttl = 0
+	}
+	e.rulesCacheTTL.Store(int64(ttl))
+}
+
+// InvalidateRulesCache drops every cached rule so the next lookup reads the
+// database. Changes made through the engine's rule manager invalidate the
+// cache on their own.
+func (e *Engine) InvalidateRulesCache() {
+	e.rulesCache.Range(func(key, _ interface{}) bool {
+		e.rulesCache.Delete(key)
+		return true
+	})
+}
+
+// cachedRules returns the cached rules of a vendor while they are current
+func (e *Engine) cachedRules(vendorType string) ([]SecurityRule, bool) {
+	value, ok := e.rulesCache.Load(vendorType)
+	if !ok {
+		return nil, false
+	}
+
+	entry := value.(*rulesCacheEntry)
+	if entry.generation != e.ruleManager.Generation() {
+		return nil, false
+	}
+	if ttl := time.Duration(e.rulesCacheTTL.Load()); ttl > 0 && time.Since(entry.fetchedAt) > ttl {
+		return nil, false
+	}
+
+	// Callers get their own slice so they cannot reorder the cached one
+	return append([]SecurityRule(nil), entry.rules...), true
+}
+
+// cacheRules stores the rules of a vendor read at generation
+func (e *Engine) cacheRules(vendorType string, rules []SecurityRule, generation uint64) {
+	e.rulesCache.Store(vendorType, &rulesCacheEntry{
+		rules:      append([]SecurityRule(nil), rules...),
+		generation: generation,
+		fetchedAt:  time.Now(),
+	})
+}
@@ -0,0 +1,141 @@
+package checker
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ruleQueries counts the statements prepared against security_rules on
+// connections opened through the countingSQLite driver
+var (
+	ruleQueries        atomic.Int64
+	registerCountingDB sync.Once
+)
+
+// countingDriver wraps the SQLite driver. Its connections expose only
+// Prepare, so every statement passes through the counter.
+type countingDriver struct {
+	sqlite3.SQLiteDriver
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn}, nil
+}
+
+type countingConn struct {
+	driver.Conn
+}
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.Contains(query, "FROM security_rules") {
+		ruleQueries.Add(1)
+	}
+	return c.Conn.Prepare(query)
+}
+
+// setupCountingRuleManager returns a rule manager whose rule queries are
+// counted in ruleQueries
+func setupCountingRuleManager(t *testing.T) *RuleManager {
+	registerCountingDB.Do(func() {
+		sql.Register("sqlite3_counting", &countingDriver{})
+	})
+
+	db, err := sql.Open("sqlite3_counting", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	// Prepared statements run one at a time, so the schema is split up
+	for _, statement := range strings.Split(testRulesSchema, ";") {
+		if strings.TrimSpace(statement) != "" {
+			_, err = db.Exec(statement)
+			require.NoError(t, err)
+		}
+	}
+	return NewRuleManager(db)
+}
+
+func TestEngine_RulesCache(t *testing.T) {
+	rm := setupCountingRuleManager(t)
+	engine := NewEngine(rm)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "SSH", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r2", Name: "NTP", Vendor: "juniper", Command: "show ntp", ExpectedPattern: "synced",
+			Severity: string(SeverityLow), Enabled: true},
+	}))
+
+	t.Run("one query per vendor", func(t *testing.T) {
+		start := ruleQueries.Load()
+		for i := 0; i < 100; i++ {
+			assert.Len(t, engine.GetSecurityRules("cisco"), 1)
+		}
+		assert.Equal(t, int64(1), ruleQueries.Load()-start)
+
+		// Each vendor is cached separately
+		assert.Len(t, engine.GetSecurityRules("juniper"), 1)
+		assert.Equal(t, int64(2), ruleQueries.Load()-start)
+	})
+
+	t.Run("rule changes invalidate", func(t *testing.T) {
+		engine.GetSecurityRules("cisco")
+
+		rule, err := rm.GetRule("r1")
+		require.NoError(t, err)
+		rule.Enabled = false
+		require.NoError(t, rm.UpdateRule(*rule))
+		assert.Empty(t, engine.GetSecurityRules("cisco"))
+
+		require.NoError(t, rm.EnableRule("r1"))
+		assert.Len(t, engine.GetSecurityRules("cisco"), 1)
+
+		require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+			{ID: "r3", Name: "Telnet", Vendor: "cisco", Command: "show telnet", ExpectedPattern: "disabled",
+				Severity: string(SeverityHigh), Enabled: true},
+		}))
+		assert.Len(t, engine.GetSecurityRules("cisco"), 2)
+	})
+
+	t.Run("explicit invalidation", func(t *testing.T) {
+		engine.GetSecurityRules("cisco")
+		start := ruleQueries.Load()
+
+		engine.InvalidateRulesCache()
+		engine.GetSecurityRules("cisco")
+		assert.Equal(t, int64(1), ruleQueries.Load()-start)
+	})
+
+	t.Run("ttl expiry", func(t *testing.T) {
+		engine.SetRulesCacheTTL(20 * time.Millisecond)
+		defer engine.SetRulesCacheTTL(0)
+
+		engine.GetSecurityRules("cisco")
+		start := ruleQueries.Load()
+		engine.GetSecurityRules("cisco")
+		assert.Equal(t, int64(0), ruleQueries.Load()-start)
+
+		time.Sleep(30 * time.Millisecond)
+		engine.GetSecurityRules("cisco")
+		assert.Equal(t, int64(1), ruleQueries.Load()-start)
+	})
+
+	t.Run("callers cannot change the cache", func(t *testing.T) {
+		rules := engine.GetSecurityRules("cisco")
+		require.NotEmpty(t, rules)
+		rules[0] = SecurityRule{}
+		assert.NotEmpty(t, engine.GetSecurityRules("cisco")[0].ID)
+	})
+}
@@ -0,0 +1,255 @@
+package checker
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Length limits of run metadata, in characters
+const (
+	MaxRunLabelLength = 80
+	MaxRunNoteLength  = 2000
+)
+
+// ErrRunNotFound is returned when a run has neither results nor metadata
+var ErrRunNotFound = errors.New("check run not found")
+
+// RunMetadata is the operator label and note attached to a check run, for
+// example to tie a scan to a change ticket
+type RunMetadata struct {
+	RunID     string    `json:"runId"`
+	Label     string    `json:"label"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// RunQuery filters FindRuns. Text matches label or note substrings, case
+// insensitively; From and To bound when the run took place and are ignored
+// when zero.
+type RunQuery struct {
+	Text  string    `json:"text"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Limit int       `json:"limit"`
+}
+
+// NormalizeRunMetadata trims a run label and note and removes characters
+// that are unsafe in reports. Labels are reduced to a single line without
+// markup characters; notes keep line breaks and tabs. It fails when either
+// is longer than its limit.
+func NormalizeRunMetadata(label, note string) (string, string, error) {
+	label = strings.Join(strings.Fields(strings.Map(func(r rune) rune {
+		switch {
+		case r == '<' || r == '>' || r == '"' || r == '`':
+			return -1
+		case unicode.IsControl(r):
+			return ' '
+		}
+		return r
+	}, label)), " ")
+	if n := utf8.RuneCountInString(label); n > MaxRunLabelLength {
+		return "", "", fmt.Errorf("run label is %d characters, the limit is %d", n, MaxRunLabelLength)
+	}
+
+	note = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, strings.ReplaceAll(note, "\r\n", "\n")))
+	if n := utf8.RuneCountInString(note); n > MaxRunNoteLength {
+		return "", "", fmt.Errorf("run note is %d characters, the limit is %d", n, MaxRunNoteLength)
+	}
+
+	return label, note, nil
+}
+
+// validate rejects run metadata SaveRunMetadata would refuse, so a run with
+// an invalid label fails before it contacts any device
+func (o CheckOptions) validate() error {
+	_, _, err := NormalizeRunMetadata(o.Label, o.Note)
+	return err
+}
+
+// SaveRunMetadata records the label and note given when a run was
+// triggered. Nothing is stored when both are empty.
+func (rs *ResultStore) SaveRunMetadata(runID, label, note string) error {
+	label, note, err := NormalizeRunMetadata(label, note)
+	if err != nil {
+		return err
+	}
+	if label == "" && note == "" {
+		return nil
+	}
+	if strings.TrimSpace(runID) == "" {
+		return fmt.Errorf("run ID cannot be empty")
+	}
+
+	return rs.upsertRunMetadata(runID, label, note)
+}
+
+// UpdateRunMetadata replaces the label and note of an existing run
+func (rs *ResultStore) UpdateRunMetadata(runID, label, note string) (*RunMetadata, error) {
+	label, note, err := NormalizeRunMetadata(label, note)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := rs.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM check_results WHERE run_id = ?) + (SELECT COUNT(*) FROM check_runs WHERE run_id = ?)
+	`, runID, runID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrRunNotFound
+	}
+
+	if err := rs.upsertRunMetadata(runID, label, note); err != nil {
+		return nil, err
+	}
+	return rs.GetRunMetadata(runID)
+}
+
+// upsertRunMetadata stores normalized run metadata, keeping the creation time
+// of an existing row
+func (rs *ResultStore) upsertRunMetadata(runID, label, note string) error {
+	now := time.Now()
+	query := `
+		INSERT INTO check_runs (run_id, label, note, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(run_id) DO UPDATE SET label = excluded.label, note = excluded.note, updated_at = excluded.updated_at
+	`
+	if _, err := rs.db.Exec(query, runID, label, note, now, now); err != nil {
+		return fmt.Errorf("failed to save metadata of run %s: %w", runID, err)
+	}
+	return nil
+}
+
+// GetRunMetadata returns the label and note of a run
+func (rs *ResultStore) GetRunMetadata(runID string) (*RunMetadata, error) {
+	var metadata RunMetadata
+	err := rs.db.QueryRow(`
+		SELECT run_id, label, note, created_at, updated_at FROM check_runs WHERE run_id = ?
+	`, runID).Scan(&metadata.RunID, &metadata.Label, &metadata.Note, &metadata.CreatedAt, &metadata.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// FindRuns returns the runs matching a query, newest first
+func (rs *ResultStore) FindRuns(query RunQuery) ([]RunSummary, error) {
+	text := strings.ToLower(strings.TrimSpace(query.Text))
+	filter := "AND (? = '' OR instr(lower(COALESCE(r.label, '') || char(10) || COALESCE(r.note, '')), ?) > 0)"
+	args := []interface{}{text, text}
+
+	// Timestamps are stored as text with their zone offset, so they are
+	// compared as Julian days
+	var bounds []string
+	if !query.From.IsZero() {
+		bounds = append(bounds, "julianday(MAX(c.checked_at)) >= julianday(?)")
+		args = append(args, query.From)
+	}
+	if !query.To.IsZero() {
+		bounds = append(bounds, "julianday(MIN(c.checked_at)) <= julianday(?)")
+		args = append(args, query.To)
+	}
+	having := ""
+	if len(bounds) > 0 {
+		having = "HAVING " + strings.Join(bounds, " AND ")
+	}
+
+	runs, err := rs.queryRuns(filter, having, args, clampResultLimit(query.Limit))
+	if err != nil {
+		return nil, err
+	}
+	if runs == nil {
+		runs = []RunSummary{}
+	}
+	return runs, nil
+}
+
+// GetLatestRunResults returns the results of a device's most recent check
+// run, newest first. Results saved without a run ID are all returned.
+func (rs *ResultStore) GetLatestRunResults(deviceID string) ([]CheckResult, error) {
+	results, err := rs.GetDeviceResults(deviceID, MaxResultLimit)
+	if err != nil {
+		return nil, err
+	}
+	return latestRun(results), nil
+}
+
+// latestRun keeps the results of the newest run from results sorted newest
+// first. Results saved without a run ID are kept as they are.
+func latestRun(results []CheckResult) []CheckResult {
+	if len(results) == 0 || results[0].RunID == "" {
+		return results
+	}
+
+	var latest []CheckResult
+	for _, result := range results {
+		if result.RunID == results[0].RunID {
+			latest = append(latest, result)
+		}
+	}
+	return latest
+}
+
+// GetAllRunResults returns the results every device produced in a run, in
+// the order they were checked. An unknown run returns ErrRunNotFound.
+func (rs *ResultStore) GetAllRunResults(runID string) ([]CheckResult, error) {
+	rows, err := rs.db.Query(`
+		SELECT `+resultColumns+`
+		FROM check_results
+		WHERE run_id = ?
+		ORDER BY checked_at, id
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results, err := scanResults(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrRunNotFound
+	}
+	return results, nil
+}
+
+// GetRunResults returns the results one device produced in a run, in the
+// order they were checked. A run without results for the device returns
+// ErrRunNotFound.
+func (rs *ResultStore) GetRunResults(runID, deviceID string) ([]CheckResult, error) {
+	rows, err := rs.db.Query(`
+		SELECT `+resultColumns+`
+		FROM check_results
+		WHERE run_id = ? AND device_id = ?
+		ORDER BY checked_at, id
+	`, runID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results, err := scanResults(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrRunNotFound
+	}
+	return results, nil
+}
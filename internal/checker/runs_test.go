@@ -0,0 +1,168 @@
+package checker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeRunMetadata(t *testing.T) {
+	label, note, err := NormalizeRunMetadata("  post-change\t<b>CHG-5521</b>\n ", " line one\r\nline two\x00 ")
+	if err != nil {
+		t.Fatalf("Failed to normalize metadata: %v", err)
+	}
+	if label != "post-change bCHG-5521/b" {
+		t.Errorf("Unexpected label %q", label)
+	}
+	if note != "line one\nline two" {
+		t.Errorf("Unexpected note %q", note)
+	}
+
+	if _, _, err := NormalizeRunMetadata(strings.Repeat("x", MaxRunLabelLength+1), ""); err == nil {
+		t.Error("Expected an error for a long label")
+	}
+	if _, _, err := NormalizeRunMetadata("", strings.Repeat("x", MaxRunNoteLength+1)); err == nil {
+		t.Error("Expected an error for a long note")
+	}
+
+	engine := NewEngine(setupTestRuleManager(t))
+	if _, err := engine.RunChecksWithOptions(nil, CheckOptions{Label: strings.Repeat("x", MaxRunLabelLength+1)}, nil); err == nil {
+		t.Error("Expected a run with a long label to be rejected")
+	}
+}
+
+func TestResultStore_RunMetadata(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	base := time.Now().Add(-48 * time.Hour)
+
+	results := []CheckResult{
+		newTestResult("device1", "pre", StatusFail, base),
+		newTestResult("device1", "post", StatusPass, base.Add(24*time.Hour)),
+		newTestResult("device1", "plain", StatusPass, base.Add(25*time.Hour)),
+	}
+	if err := store.SaveResults(results); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+
+	if err := store.SaveRunMetadata("pre", "pre-change", ""); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+	if err := store.SaveRunMetadata("post", "post-change CHG-5521", "After the core upgrade"); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+	// Empty metadata is not stored
+	if err := store.SaveRunMetadata("plain", "", " "); err != nil {
+		t.Fatalf("Failed to save metadata: %v", err)
+	}
+	if _, err := store.GetRunMetadata("plain"); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("Expected no metadata for an unlabeled run, got %v", err)
+	}
+
+	runs, err := store.GetRecentRuns(10)
+	if err != nil {
+		t.Fatalf("Failed to get recent runs: %v", err)
+	}
+	if len(runs) != 3 || runs[1].Label != "post-change CHG-5521" || runs[1].Note != "After the core upgrade" {
+		t.Errorf("Expected run summaries to carry metadata, got %+v", runs)
+	}
+
+	// Post-hoc edits keep the creation time
+	before, _ := store.GetRunMetadata("pre")
+	updated, err := store.UpdateRunMetadata("pre", "pre-change CHG-5521", "Baseline")
+	if err != nil {
+		t.Fatalf("Failed to update metadata: %v", err)
+	}
+	if updated.Label != "pre-change CHG-5521" || updated.Note != "Baseline" {
+		t.Errorf("Unexpected updated metadata %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(before.CreatedAt) {
+		t.Errorf("Expected creation time %v to be kept, got %v", before.CreatedAt, updated.CreatedAt)
+	}
+	if _, err := store.UpdateRunMetadata("plain", "labeled later", ""); err != nil {
+		t.Errorf("Expected an unlabeled run to be labeled, got %v", err)
+	}
+	if _, err := store.UpdateRunMetadata("missing", "label", ""); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("Expected ErrRunNotFound, got %v", err)
+	}
+
+	found, err := store.FindRuns(RunQuery{Text: "chg-5521"})
+	if err != nil {
+		t.Fatalf("Failed to find runs: %v", err)
+	}
+	if len(found) != 2 || found[0].RunID != "post" || found[1].RunID != "pre" {
+		t.Errorf("Expected both CHG-5521 runs newest first, got %+v", found)
+	}
+
+	found, err = store.FindRuns(RunQuery{Text: "core upgrade"})
+	if err != nil || len(found) != 1 || found[0].RunID != "post" {
+		t.Errorf("Expected the note to match, got %+v (%v)", found, err)
+	}
+
+	found, err = store.FindRuns(RunQuery{From: base.Add(time.Hour)})
+	if err != nil || len(found) != 2 {
+		t.Errorf("Expected the two later runs, got %+v (%v)", found, err)
+	}
+
+	// Bounds in another zone compare by instant
+	found, err = store.FindRuns(RunQuery{From: base.Add(24 * time.Hour).In(time.FixedZone("east", 5*3600))})
+	if err != nil || len(found) != 2 {
+		t.Errorf("Expected the two later runs, got %+v (%v)", found, err)
+	}
+
+	found, err = store.FindRuns(RunQuery{Text: "CHG", To: base.Add(time.Hour)})
+	if err != nil || len(found) != 1 || found[0].RunID != "pre" {
+		t.Errorf("Expected only the earlier labeled run, got %+v (%v)", found, err)
+	}
+
+	found, err = store.FindRuns(RunQuery{Limit: 1})
+	if err != nil || len(found) != 1 {
+		t.Errorf("Expected limit to apply, got %+v (%v)", found, err)
+	}
+}
+
+func TestResultStore_GetRunResults(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	base := time.Now().Add(-time.Hour)
+	if err := store.SaveResults([]CheckResult{
+		{ID: "b", DeviceID: "router", RunID: "run1", CheckName: "Banner", Status: string(StatusPass), CheckedAt: base.Add(time.Second)},
+		{ID: "a", DeviceID: "router", RunID: "run1", CheckName: "SSH", Status: string(StatusFail), CheckedAt: base,
+			Evidence: strings.Repeat("ip ssh version 1\n", 200)},
+		{ID: "c", DeviceID: "switch", RunID: "run1", CheckName: "SSH", Status: string(StatusPass), CheckedAt: base},
+		{ID: "d", DeviceID: "router", RunID: "run2", CheckName: "SSH", Status: string(StatusPass), CheckedAt: base},
+	}); err != nil {
+		t.Fatalf("Failed to save results: %v", err)
+	}
+
+	results, err := store.GetRunResults("run1", "router")
+	if err != nil {
+		t.Fatalf("Failed to get run results: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != "a" || results[1].ID != "b" {
+		t.Fatalf("Expected results a and b in check order, got %+v", results)
+	}
+	if results[0].Evidence != strings.Repeat("ip ssh version 1\n", 200) {
+		t.Error("Expected compressed evidence to be read back in full")
+	}
+
+	if _, err := store.GetRunResults("run1", "firewall"); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("Expected ErrRunNotFound, got %v", err)
+	}
+
+	all, err := store.GetAllRunResults("run1")
+	if err != nil {
+		t.Fatalf("Failed to get all run results: %v", err)
+	}
+	if len(all) != 3 || all[2].ID != "b" {
+		t.Errorf("Expected the three results of run1 in check order, got %+v", all)
+	}
+	if _, err := store.GetAllRunResults("run3"); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("Expected ErrRunNotFound, got %v", err)
+	}
+}
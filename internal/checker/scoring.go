@@ -0,0 +1,93 @@
+package checker
+
+import "strings"
+
+// DefaultSeverityWeights are the weights WeightedScore uses when none are
+// given: a failed critical check costs ten times a failed low one, and info
+// results are not scored
+var DefaultSeverityWeights = map[string]float64{
+	string(SeverityCritical): 10,
+	string(SeverityHigh):     5,
+	string(SeverityMedium):   3,
+	string(SeverityLow):      1,
+	string(SeverityInfo):     0,
+}
+
+// ComplianceSummary counts a set of results and scores them. Score is the
+// share of scored results that passed, as CalculateComplianceScore returns;
+// WeightedScore weights each result by its severity.
+type ComplianceSummary struct {
+	Total         int     `json:"total"`
+	Passed        int     `json:"passed"`
+	Failed        int     `json:"failed"`
+	Warnings      int     `json:"warnings"`
+	Errors        int     `json:"errors"`
+	NotApplicable int     `json:"notApplicable"`
+	Score         float64 `json:"score"`
+	WeightedScore float64 `json:"weightedScore"`
+}
+
+// SummarizeCompliance counts and scores results, weighting them by weights
+// or by DefaultSeverityWeights when weights is nil
+func SummarizeCompliance(results []CheckResult, weights map[string]float64) ComplianceSummary {
+	summary := ComplianceSummary{
+		Total:         len(results),
+		Score:         CalculateComplianceScore(results),
+		WeightedScore: WeightedScore(results, weights),
+	}
+	for _, result := range results {
+		switch CheckStatus(result.Status) {
+		case StatusPass:
+			summary.Passed++
+		case StatusFail:
+			summary.Failed++
+		case StatusWarning:
+			summary.Warnings++
+		case StatusError:
+			summary.Errors++
+		case StatusNotApplicable:
+			summary.NotApplicable++
+		}
+	}
+	return summary
+}
+
+// WeightedScore returns the weighted share of results that passed, from 0
+// to 100, so a failed critical check lowers the score more than a failed
+// low one. weights maps severities to weights, ignoring case; a nil map
+// uses DefaultSeverityWeights. Severities missing from weights weigh as
+// low. Results that did not pass, including warnings and errors, count as
+// failures, results not applicable for a failed prerequisite are left out,
+// and with nothing of positive weight the score is 100.
+func WeightedScore(results []CheckResult, weights map[string]float64) float64 {
+	if weights == nil {
+		weights = DefaultSeverityWeights
+	}
+	normalized := make(map[string]float64, len(weights))
+	for severity, weight := range weights {
+		normalized[strings.ToLower(severity)] = weight
+	}
+	fallback, ok := normalized[strings.ToLower(string(SeverityLow))]
+	if !ok {
+		fallback = DefaultSeverityWeights[string(SeverityLow)]
+	}
+
+	var total, passed float64
+	for _, result := range results {
+		weight, ok := normalized[strings.ToLower(result.Severity)]
+		if !ok {
+			weight = fallback
+		}
+		if weight <= 0 || result.Status == string(StatusNotApplicable) {
+			continue
+		}
+		total += weight
+		if result.Status == string(StatusPass) {
+			passed += weight
+		}
+	}
+	if total == 0 {
+		return 100
+	}
+	return passed * 100 / total
+}
@@ -0,0 +1,46 @@
+package checker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeightedScore(t *testing.T) {
+	results := []CheckResult{
+		{Severity: string(SeverityCritical), Status: string(StatusFail)},
+		{Severity: string(SeverityLow), Status: string(StatusPass)},
+		{Severity: string(SeverityLow), Status: string(StatusPass)},
+		{Severity: string(SeverityInfo), Status: string(StatusFail)},
+	}
+	// One failed critical outweighs two passed lows, where the plain
+	// score sees two of three passing
+	assert.InDelta(t, 66.67, CalculateComplianceScore(results), 0.01)
+	assert.InDelta(t, 2.0/12*100, WeightedScore(results, nil), 0.01)
+
+	// A failed low costs little
+	results[0] = CheckResult{Severity: string(SeverityLow), Status: string(StatusFail)}
+	results = append(results, CheckResult{Severity: string(SeverityCritical), Status: string(StatusPass)})
+	assert.InDelta(t, 12.0/13*100, WeightedScore(results, nil), 0.01)
+
+	equal := map[string]float64{"critical": 1, "high": 1, "medium": 1, "low": 1}
+	assert.InDelta(t, 60.0, WeightedScore(results, equal), 0.01, "info weighs as low when missing")
+
+	assert.Equal(t, 100.0, WeightedScore(nil, nil))
+	assert.Equal(t, 100.0, WeightedScore([]CheckResult{{Severity: string(SeverityInfo), Status: string(StatusFail)}}, nil))
+	assert.Equal(t, 0.0, WeightedScore([]CheckResult{{Severity: "Unknown", Status: string(StatusError)}}, nil),
+		"unknown severities weigh as low")
+}
+
+func TestSummarizeCompliance(t *testing.T) {
+	summary := SummarizeCompliance([]CheckResult{
+		{Severity: string(SeverityHigh), Status: string(StatusPass)},
+		{Severity: string(SeverityHigh), Status: string(StatusFail)},
+		{Severity: string(SeverityMedium), Status: string(StatusWarning)},
+		{Severity: string(SeverityLow), Status: string(StatusError)},
+		{Severity: string(SeverityCritical), Status: string(StatusNotApplicable)},
+	}, nil)
+
+	assert.Equal(t, ComplianceSummary{Total: 5, Passed: 1, Failed: 1, Warnings: 1, Errors: 1, NotApplicable: 1,
+		Score: 25, WeightedScore: 5.0 / 14 * 100}, summary)
+}
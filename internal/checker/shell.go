@@ -0,0 +1,228 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+)
+
+// shellClient is implemented by SSH clients that can keep a device's
+// interactive shell open across commands
+type shellClient interface {
+	OpenShell(ctx context.Context, conn *ssh.SSHConnection, config ssh.ShellConfig, enableSecret string) (ssh.Shell, error)
+}
+
+// EnableSecretFunc returns the secret that takes a device's shell into
+// privileged mode, or an empty string when none is set
+type EnableSecretFunc func(device *device.Device) (string, error)
+
+// EnableShellSessions makes the engine keep one interactive shell open for
+// each run on a vendor's devices: it logs in, enters privileged mode and
+// turns paging off once, then sends every rule command to that shell. A
+// config without a prompt pattern uses the vendor's entry in
+// ssh.DefaultShellConfigs. Shell sessions are off for every vendor by
+// default and must not be changed while checks are running.
+func (e *Engine) EnableShellSessions(vendor string, config ssh.ShellConfig) error {
+	if config.PromptPattern == "" {
+		defaults, ok := ssh.DefaultShellConfigs()[vendor]
+		if !ok {
+			return fmt.Errorf("vendor %s has no default shell settings, a prompt pattern is required", vendor)
+		}
+		config = defaults
+	}
+	if err := ssh.ValidateShellConfig(config); err != nil {
+		return fmt.Errorf("shell of vendor %s: %w", vendor, err)
+	}
+
+	if e.shellConfigs == nil {
+		e.shellConfigs = make(map[string]ssh.ShellConfig)
+	}
+	e.shellConfigs[vendor] = config
+	return nil
+}
+
+// SetEnableSecretFunc sets how the engine looks up the enable secret of a
+// device whose shell asks for one. The login password is never sent in its
+// place; without a secret such a shell is not used. It must not be called
+// while checks are running.
+func (e *Engine) SetEnableSecretFunc(fn EnableSecretFunc) {
+	e.enableSecret = fn
+}
+
+// DisableShellSessions returns a vendor to running each rule command on its
+// own connection
+func (e *Engine) DisableShellSessions(vendor string) {
+	delete(e.shellConfigs, vendor)
+}
+
+// ShellSessionsEnabled reports whether runs on a vendor's devices keep a
+// shell open
+func (e *Engine) ShellSessionsEnabled(vendor string) bool {
+	_, enabled := e.shellConfigs[vendor]
+	return enabled
+}
+
+// deviceShell runs the commands of one device run in a single interactive
+// shell. It stands in for the run's SSH client: Connect hands out the
+// shell's connection and commands sent on it run in the shell. The shell
+// holds its session slot for the whole run. Once a command fails in the
+// shell, the shell is closed and the rest of the run uses the client it
+// wraps as usual.
+type deviceShell struct {
+	ssh.SSHClientInterface
+
+	conn    *ssh.SSHConnection
+	shell   ssh.Shell
+	release func()
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// openDeviceShell opens the shell of a device run, or returns nil when
+// shell sessions are off for the device's vendor, the client cannot open
+// one or opening fails, leaving every command to run on its own
+func (e *Engine) openDeviceShell(client ssh.SSHClientInterface, device *device.Device) *deviceShell {
+	config, enabled := e.shellConfigs[device.Vendor]
+	if !enabled || device.IsInMaintenance() {
+		return nil
+	}
+	opener, ok := client.(shellClient)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	release, ok := e.acquireSession(ctx, client)
+	if !ok {
+		return nil
+	}
+
+	// Rules report a connection failure themselves
+	conn, err := e.connect(ctx, client, device)
+	if err != nil {
+		release()
+		return nil
+	}
+
+	shell, err := opener.OpenShell(ctx, conn, config, e.deviceEnableSecret(device))
+	if err != nil {
+		log.Printf("Could not open a shell on %s, running commands individually: %v", device.Name, err)
+		client.Disconnect(conn)
+		release()
+		return nil
+	}
+
+	e.recordTimings(device, conn.Timings())
+	return &deviceShell{SSHClientInterface: client, conn: conn, shell: shell, release: release}
+}
+
+// deviceEnableSecret returns the enable secret of a device, or an empty
+// string when none is set or it cannot be read
+func (e *Engine) deviceEnableSecret(device *device.Device) string {
+	if e.enableSecret == nil {
+		return ""
+	}
+	secret, err := e.enableSecret(device)
+	if err != nil {
+		log.Printf("Failed to read the enable secret of %s: %v", device.Name, err)
+		return ""
+	}
+	return secret
+}
+
+// connectionTimings returns the phases of opening conn that a command sent
+// with client waited for. A device shell's connection was opened once for
+// the whole run, so commands sent to the shell waited for none.
+func connectionTimings(client ssh.SSHClientInterface, conn *ssh.SSHConnection) ssh.PhaseTimings {
+	if shell, isShell := client.(*deviceShell); isShell && conn == shell.conn {
+		return ssh.PhaseTimings{}
+	}
+	return conn.Timings()
+}
+
+// open reports whether commands still run in the shell
+func (s *deviceShell) open() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.closed
+}
+
+// Connect returns the shell's connection while the shell is open
+func (s *deviceShell) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	if s.open() {
+		return s.conn, nil
+	}
+	return s.SSHClientInterface.Connect(ctx, connInfo)
+}
+
+// ExecuteCommand runs a command in the shell when it is sent on the shell's
+// connection
+func (s *deviceShell) ExecuteCommand(ctx context.Context, conn *ssh.SSHConnection, command string) (*ssh.CommandResult, error) {
+	if conn != s.conn {
+		return s.SSHClientInterface.ExecuteCommand(ctx, conn, command)
+	}
+	if !s.open() {
+		return nil, fmt.Errorf("device shell was closed after an earlier failure")
+	}
+
+	result, err := s.shell.Run(ctx, command)
+	if err != nil {
+		s.close()
+	}
+	return result, err
+}
+
+// ExecuteCommandWithPager runs commands on the shell's connection in the
+// shell, whose setup turned paging off, and others through the wrapped
+// client's pager handling
+func (s *deviceShell) ExecuteCommandWithPager(ctx context.Context, conn *ssh.SSHConnection, command string,
+	pager ssh.VendorPagerConfig) (*ssh.CommandResult, error) {
+	if paging, ok := s.SSHClientInterface.(pagingClient); ok && conn != s.conn {
+		return paging.ExecuteCommandWithPager(ctx, conn, command, pager)
+	}
+	return s.ExecuteCommand(ctx, conn, command)
+}
+
+// ExecuteCommands runs commands one after another
+func (s *deviceShell) ExecuteCommands(ctx context.Context, conn *ssh.SSHConnection, commands []string) ([]*ssh.CommandResult, error) {
+	results := make([]*ssh.CommandResult, 0, len(commands))
+	for _, command := range commands {
+		result, _ := s.ExecuteCommand(ctx, conn, command)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Disconnect keeps the shell's connection open until the run ends
+func (s *deviceShell) Disconnect(conn *ssh.SSHConnection) error {
+	if conn == s.conn {
+		return nil
+	}
+	return s.SSHClientInterface.Disconnect(conn)
+}
+
+// Close leaves the wrapped client open, as it outlives the run
+func (s *deviceShell) Close() error {
+	return nil
+}
+
+// close ends the shell, disconnects it and frees its session slot
+func (s *deviceShell) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	s.shell.Close()
+	s.SSHClientInterface.Disconnect(s.conn)
+	s.release()
+}
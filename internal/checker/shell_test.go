@@ -0,0 +1,172 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shellOpeningSSHClient opens fake shells that answer from the canned
+// outputs and records the commands each shell ran
+type shellOpeningSSHClient struct {
+	stubSSHClient
+	connects  int
+	opened    int
+	shellRuns []string
+	openErr   error
+	failOn    string
+	secret    string
+}
+
+func (c *shellOpeningSSHClient) Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	c.mu.Lock()
+	c.connects++
+	c.mu.Unlock()
+	return c.stubSSHClient.Connect(ctx, connInfo)
+}
+
+func (c *shellOpeningSSHClient) OpenShell(ctx context.Context, conn *ssh.SSHConnection, config ssh.ShellConfig,
+	enableSecret string) (ssh.Shell, error) {
+	if c.openErr != nil {
+		return nil, c.openErr
+	}
+	c.mu.Lock()
+	c.opened++
+	c.secret = enableSecret
+	c.mu.Unlock()
+	return &fakeShell{client: c}, nil
+}
+
+type fakeShell struct {
+	client *shellOpeningSSHClient
+}
+
+func (s *fakeShell) Run(ctx context.Context, command string) (*ssh.CommandResult, error) {
+	s.client.mu.Lock()
+	defer s.client.mu.Unlock()
+	s.client.shellRuns = append(s.client.shellRuns, command)
+	if command == s.client.failOn {
+		return &ssh.CommandResult{Command: command, ExitCode: -1}, fmt.Errorf("shell closed by device")
+	}
+	return &ssh.CommandResult{Command: command, Output: s.client.outputs[command], ExecutedAt: time.Now()}, nil
+}
+
+func (s *fakeShell) Close() error {
+	return nil
+}
+
+func setupShellEngine(t *testing.T) (*Engine, *shellOpeningSSHClient) {
+	t.Helper()
+	client := &shellOpeningSSHClient{stubSSHClient: stubSSHClient{outputs: map[string]string{
+		"show version": "uptime is 5 days",
+		"show ip ssh":  "SSH version 2",
+		"show users":   "admin vty 0",
+	}}}
+	engine := NewEngineWithSSHClient(setupTestRuleManager(t), client)
+	require.NoError(t, engine.LoadCustomRules([]SecurityRule{
+		{ID: "r1", Name: "Uptime", Vendor: "cisco", Command: "show version", ExpectedPattern: "uptime",
+			Severity: string(SeverityLow), Enabled: true},
+		{ID: "r2", Name: "SSH v2", Vendor: "cisco", Command: "show ip ssh", ExpectedPattern: "version 2",
+			Severity: string(SeverityHigh), Enabled: true},
+		{ID: "r3", Name: "Users", Vendor: "cisco", Command: "show users", ExpectedPattern: "admin",
+			Severity: string(SeverityMedium), Enabled: true},
+	}))
+	return engine, client
+}
+
+func shellTestDevice() *device.Device {
+	return &device.Device{ID: "d1", Name: "Core", IPAddress: "192.168.1.60", DeviceType: string(device.TypeRouter),
+		Vendor: string(device.VendorCisco), Username: "admin", SSHPort: 22}
+}
+
+func TestEngine_ShellSessions(t *testing.T) {
+	engine, client := setupShellEngine(t)
+	// The shell holds the only session slot for the whole run
+	engine.MaxConcurrentSSHSessions = 1
+
+	results, err := engine.RunChecks(shellTestDevice())
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, 3, client.connects, "without a shell every rule connects")
+	assert.Zero(t, client.opened)
+
+	assert.False(t, engine.ShellSessionsEnabled("cisco"))
+	require.NoError(t, engine.EnableShellSessions("cisco", ssh.ShellConfig{}))
+	assert.True(t, engine.ShellSessionsEnabled("cisco"))
+
+	client.connects = 0
+	client.executed = nil
+	results, err = engine.RunChecks(shellTestDevice())
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for _, result := range results {
+		assert.Equal(t, string(StatusPass), result.Status, result.CheckName)
+		assert.True(t, result.Phases.IsZero(), "rules in the shell did not connect")
+	}
+	assert.Equal(t, 1, client.connects)
+	assert.Equal(t, 1, client.opened)
+	assert.ElementsMatch(t, []string{"show version", "show ip ssh", "show users"}, client.shellRuns)
+	assert.Empty(t, client.executed)
+	assert.Empty(t, client.secret, "the login password is never sent as the enable secret")
+
+	engine.SetEnableSecretFunc(func(dev *device.Device) (string, error) {
+		return "secret-of-" + dev.ID, nil
+	})
+	_, err = engine.RunChecks(shellTestDevice())
+	require.NoError(t, err)
+	assert.Equal(t, "secret-of-"+shellTestDevice().ID, client.secret)
+
+	engine.DisableShellSessions("cisco")
+	assert.False(t, engine.ShellSessionsEnabled("cisco"))
+	_, err = engine.RunChecks(shellTestDevice())
+	require.NoError(t, err)
+	assert.Equal(t, 2, client.opened)
+}
+
+func TestEngine_ShellSessionFallsBack(t *testing.T) {
+	engine, client := setupShellEngine(t)
+	engine.MaxConcurrentSSHSessions = 1
+	require.NoError(t, engine.EnableShellSessions("cisco", ssh.ShellConfig{}))
+
+	// A failed command closes the shell and later rules connect on their own
+	client.failOn = "show ip ssh"
+	results, err := engine.RunChecks(shellTestDevice())
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for _, result := range results {
+		if result.CheckName == "SSH v2" {
+			assert.Equal(t, string(StatusError), result.Status)
+			continue
+		}
+		assert.Equal(t, string(StatusPass), result.Status, result.CheckName)
+	}
+	require.NotEmpty(t, client.shellRuns)
+	assert.Equal(t, "show ip ssh", client.shellRuns[len(client.shellRuns)-1], "nothing runs in the shell after it failed")
+	ranInShell := client.shellRuns[:len(client.shellRuns)-1]
+	assert.ElementsMatch(t, []string{"show version", "show users"}, append(ranInShell, client.executed...))
+
+	// A shell that cannot be opened leaves every rule to run on its own
+	client.shellRuns, client.executed = nil, nil
+	client.openErr = fmt.Errorf("no prompt from shell")
+	results, err = engine.RunChecks(shellTestDevice())
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Empty(t, client.shellRuns)
+	assert.ElementsMatch(t, []string{"show version", "show ip ssh", "show users"}, client.executed)
+}
+
+func TestEngine_EnableShellSessionsValidates(t *testing.T) {
+	engine := NewEngineWithSSHClient(setupTestRuleManager(t), &stubSSHClient{})
+
+	assert.Error(t, engine.EnableShellSessions("generic", ssh.ShellConfig{}), "generic has no default shell")
+	assert.Error(t, engine.EnableShellSessions("generic", ssh.ShellConfig{PromptPattern: "("}))
+	require.NoError(t, engine.EnableShellSessions("generic", ssh.ShellConfig{PromptPattern: `\S+[>#]`}))
+	assert.True(t, engine.ShellSessionsEnabled("generic"))
+}
@@ -0,0 +1,276 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/google/uuid"
+)
+
+// ErrSnapshotNotFound is returned when a device has no config snapshot
+var ErrSnapshotNotFound = errors.New("config snapshot not found")
+
+// defaultSnapshotCommands holds the command that prints the full running
+// configuration of each vendor
+var defaultSnapshotCommands = map[string]string{
+	"cisco":     "show running-config",
+	"arista":    "show running-config",
+	"juniper":   "show configuration",
+	"huawei":    "display current-configuration",
+	"hp":        "show running-config",
+	"dell":      "show running-config",
+	"fortinet":  "show full-configuration",
+	"palo_alto": "show config running",
+	"mikrotik":  "/export",
+}
+
+// ConfigSnapshot is an archived copy of a device's full configuration
+type ConfigSnapshot struct {
+	ID       string `json:"id"`
+	DeviceID string `json:"deviceId"`
+	Config   string `json:"config"`
+	Size     int    `json:"size"`
+	Hash     string `json:"hash"`
+	// CapturedAt is when this config was first seen and LastSeenAt when it
+	// was last captured unchanged
+	CapturedAt time.Time `json:"capturedAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+// SnapshotStore persists gzip-compressed device config snapshots
+type SnapshotStore struct {
+	db *sql.DB
+}
+
+// NewSnapshotStore creates a new snapshot store
+func NewSnapshotStore(db *sql.DB) *SnapshotStore {
+	return &SnapshotStore{db: db}
+}
+
+// Save archives a device config. A config identical to the device's latest
+// snapshot only refreshes that snapshot's LastSeenAt, so unchanged configs
+// are stored once.
+func (ss *SnapshotStore) Save(deviceID string, config []byte) (*ConfigSnapshot, error) {
+	if strings.TrimSpace(deviceID) == "" {
+		return nil, fmt.Errorf("device ID cannot be empty")
+	}
+	if len(bytes.TrimSpace(config)) == 0 {
+		return nil, fmt.Errorf("config cannot be empty")
+	}
+
+	sum := sha256.Sum256(config)
+	hash := hex.EncodeToString(sum[:])
+	now := time.Now()
+
+	latest, err := ss.GetLatest(deviceID)
+	if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+		return nil, err
+	}
+	if latest != nil && latest.Hash == hash {
+		if _, err := ss.db.Exec("UPDATE config_snapshots SET last_seen_at = ? WHERE id = ?", now, latest.ID); err != nil {
+			return nil, fmt.Errorf("failed to update config snapshot: %w", err)
+		}
+		latest.LastSeenAt = now
+		return latest, nil
+	}
+
+	compressed, err := gzipBytes(config)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &ConfigSnapshot{
+		ID:         uuid.New().String(),
+		DeviceID:   deviceID,
+		Config:     string(config),
+		Size:       len(config),
+		Hash:       hash,
+		CapturedAt: now,
+		LastSeenAt: now,
+	}
+
+	query := `
+		INSERT INTO config_snapshots (id, device_id, config_gzip, config_size, config_hash, captured_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	if _, err := ss.db.Exec(query, snapshot.ID, snapshot.DeviceID, compressed, snapshot.Size,
+		snapshot.Hash, snapshot.CapturedAt, snapshot.LastSeenAt); err != nil {
+		return nil, fmt.Errorf("failed to save config snapshot: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+// GetLatest returns the most recent config snapshot of a device
+func (ss *SnapshotStore) GetLatest(deviceID string) (*ConfigSnapshot, error) {
+	snapshots, err := ss.GetHistory(deviceID, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, ErrSnapshotNotFound
+	}
+	return &snapshots[0], nil
+}
+
+// GetHistory returns the config snapshots of a device, newest first
+func (ss *SnapshotStore) GetHistory(deviceID string, limit int) ([]ConfigSnapshot, error) {
+	query := `
+		SELECT id, device_id, config_gzip, config_size, config_hash, captured_at, last_seen_at
+		FROM config_snapshots
+		WHERE device_id = ?
+		ORDER BY captured_at DESC, rowid DESC
+		LIMIT ?
+	`
+
+	rows, err := ss.db.Query(query, deviceID, clampResultLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []ConfigSnapshot
+	for rows.Next() {
+		var snapshot ConfigSnapshot
+		var compressed []byte
+		if err := rows.Scan(&snapshot.ID, &snapshot.DeviceID, &compressed, &snapshot.Size,
+			&snapshot.Hash, &snapshot.CapturedAt, &snapshot.LastSeenAt); err != nil {
+			return nil, err
+		}
+
+		config, err := gunzipBytes(compressed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress config snapshot %s: %w", snapshot.ID, err)
+		}
+		snapshot.Config = string(config)
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+// SetSnapshotStore enables config snapshots: every check run archives the
+// device's full config in store. nil disables snapshots.
+func (e *Engine) SetSnapshotStore(store *SnapshotStore) {
+	e.snapshotStore = store
+}
+
+// SetSnapshotCommand sets the command that prints a vendor's full config.
+// An empty command disables snapshots for the vendor.
+func (e *Engine) SetSnapshotCommand(vendor, command string) {
+	if e.snapshotCommands == nil {
+		e.snapshotCommands = make(map[string]string)
+	}
+	e.snapshotCommands[vendor] = strings.TrimSpace(command)
+}
+
+// SnapshotCommand returns the command whose output config snapshots of a
+// vendor archive, or an empty string when the vendor has none
+func (e *Engine) SnapshotCommand(vendor string) string {
+	return e.snapshotCommand(vendor)
+}
+
+// snapshotCommand returns the full config command of a vendor, or an empty
+// string when the vendor has none
+func (e *Engine) snapshotCommand(vendor string) string {
+	if command, ok := e.snapshotCommands[vendor]; ok {
+		return command
+	}
+	return defaultSnapshotCommands[vendor]
+}
+
+// recordSnapshotOutput keeps the output of the vendor's full config command
+// so a snapshot taken after the run reuses it instead of fetching it again
+func (e *Engine) recordSnapshotOutput(outputs map[string]string, vendor, command, output string) {
+	if outputs == nil || e.snapshotStore == nil {
+		return
+	}
+	if snapshot := e.snapshotCommand(vendor); snapshot != "" && snapshot == command {
+		outputs[command] = output
+	}
+}
+
+// archiveConfig saves a snapshot at the end of a check run, reusing the
+// config fetched by a rule when there was one. Failures are only logged so
+// they never fail the run.
+func (e *Engine) archiveConfig(client ssh.SSHClientInterface, device *device.Device, outputs map[string]string) {
+	if e.snapshotStore == nil || device.IsInMaintenance() {
+		return
+	}
+	command := e.snapshotCommand(device.Vendor)
+	if command == "" {
+		return
+	}
+
+	output, ok := outputs[command]
+	if !ok {
+		var err error
+		if output, err = e.fetchConfig(client, device, command); err != nil {
+			log.Printf("Failed to fetch config snapshot of device %s: %v", device.ID, err)
+			return
+		}
+	}
+
+	if _, err := e.snapshotStore.Save(device.ID, []byte(ssh.RedactOutput(output))); err != nil {
+		log.Printf("Failed to save config snapshot of device %s: %v", device.ID, err)
+	}
+}
+
+// CaptureConfigSnapshot fetches a device's full config with the vendor's
+// snapshot command and archives it
+func (e *Engine) CaptureConfigSnapshot(device *device.Device, opts CheckOptions) (*ConfigSnapshot, error) {
+	if e.snapshotStore == nil {
+		return nil, fmt.Errorf("config snapshots are not enabled")
+	}
+	command := e.snapshotCommand(device.Vendor)
+	if command == "" {
+		return nil, fmt.Errorf("no snapshot command for vendor: %s", device.Vendor)
+	}
+
+	client, err := e.clientFor(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := e.fetchConfig(client, device, command)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.snapshotStore.Save(device.ID, []byte(ssh.RedactOutput(output)))
+}
+
+// fetchConfig runs the snapshot command on its own connection
+func (e *Engine) fetchConfig(client ssh.SSHClientInterface, device *device.Device, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	release, ok := e.acquireSession(ctx, client)
+	if !ok {
+		return "", fmt.Errorf("timed out after %s waiting for a free SSH session", e.timeout)
+	}
+	defer release()
+
+	conn, err := e.connect(ctx, client, device)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer client.Disconnect(conn)
+
+	result, err := client.ExecuteCommand(ctx, conn, command)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute %q: %w", command, err)
+	}
+	return result.Output, nil
+}
@@ -0,0 +1,129 @@
+package checker
+
+import (
+	"testing"
+
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotStore_SaveAndHistory(t *testing.T) {
+	db := setupTestDB(t)
+	store := NewSnapshotStore(db)
+
+	_, err := store.GetLatest("dev-1")
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+
+	_, err = store.Save("dev-1", []byte("  \n"))
+	assert.Error(t, err)
+
+	first, err := store.Save("dev-1", []byte("hostname r1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, len("hostname r1\n"), first.Size)
+
+	// An unchanged config refreshes the latest snapshot instead of adding one
+	again, err := store.Save("dev-1", []byte("hostname r1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+	assert.False(t, again.LastSeenAt.Before(first.LastSeenAt))
+
+	second, err := store.Save("dev-1", []byte("hostname r2\n"))
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.NotEqual(t, first.Hash, second.Hash)
+
+	// Configs are stored compressed
+	var compressed []byte
+	require.NoError(t, db.QueryRow("SELECT config_gzip FROM config_snapshots WHERE id = ?", first.ID).Scan(&compressed))
+	assert.Equal(t, []byte{0x1f, 0x8b}, compressed[:2])
+
+	latest, err := store.GetLatest("dev-1")
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, latest.ID)
+	assert.Equal(t, "hostname r2\n", latest.Config)
+
+	history, err := store.GetHistory("dev-1", 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "hostname r2\n", history[0].Config)
+	assert.Equal(t, "hostname r1\n", history[1].Config)
+
+	history, err = store.GetHistory("dev-2", 10)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestEngine_ConfigSnapshots(t *testing.T) {
+	dev := &device.Device{ID: "dev-1", Name: "r1", IPAddress: "192.0.2.1", Vendor: "cisco", SSHPort: 22}
+	outputs := map[string]string{
+		"show version":        "Cisco IOS",
+		"show running-config": "hostname r1\nenable secret 5 $1$abcd\n",
+	}
+
+	setup := func(t *testing.T, rules []SecurityRule) (*Engine, *stubSSHClient, *SnapshotStore) {
+		rm := setupTestRuleManager(t)
+		client := &stubSSHClient{outputs: outputs}
+		engine := NewEngineWithSSHClient(rm, client)
+		require.NoError(t, engine.LoadCustomRules(rules))
+		store := NewSnapshotStore(rm.db)
+		engine.SetSnapshotStore(store)
+		return engine, client, store
+	}
+
+	t.Run("reuses config fetched by a rule", func(t *testing.T) {
+		engine, client, store := setup(t, []SecurityRule{
+			{ID: "r1", Name: "Version", Vendor: "cisco", Command: "show version", ExpectedPattern: "IOS",
+				Severity: string(SeverityLow), Enabled: true},
+			{ID: "r2", Name: "Secret", Vendor: "cisco", Command: "show running-config", ExpectedPattern: "enable secret",
+				Severity: string(SeverityHigh), Enabled: true},
+		})
+
+		_, err := engine.RunChecks(dev)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"show version", "show running-config"}, client.executed)
+
+		snapshot, err := store.GetLatest(dev.ID)
+		require.NoError(t, err)
+		assert.Contains(t, snapshot.Config, "hostname r1")
+		assert.NotContains(t, snapshot.Config, "$1$abcd")
+	})
+
+	t.Run("fetches config when no rule does", func(t *testing.T) {
+		engine, client, store := setup(t, []SecurityRule{
+			{ID: "r1", Name: "Version", Vendor: "cisco", Command: "show version", ExpectedPattern: "IOS",
+				Severity: string(SeverityLow), Enabled: true},
+		})
+
+		_, err := engine.RunBulkChecks([]device.Device{*dev})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"show version", "show running-config"}, client.executed)
+
+		history, err := store.GetHistory(dev.ID, 10)
+		require.NoError(t, err)
+		assert.Len(t, history, 1)
+	})
+
+	t.Run("dedicated capture and overrides", func(t *testing.T) {
+		engine, client, store := setup(t, nil)
+
+		engine.SetSnapshotCommand("cisco", "")
+		_, err := engine.CaptureConfigSnapshot(dev, CheckOptions{})
+		assert.Error(t, err)
+
+		engine.SetSnapshotCommand("cisco", "show version")
+		snapshot, err := engine.CaptureConfigSnapshot(dev, CheckOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "Cisco IOS", snapshot.Config)
+		assert.Equal(t, []string{"show version"}, client.executed)
+
+		latest, err := store.GetLatest(dev.ID)
+		require.NoError(t, err)
+		assert.Equal(t, snapshot.ID, latest.ID)
+
+		engine.SetSnapshotStore(nil)
+		_, err = engine.CaptureConfigSnapshot(dev, CheckOptions{})
+		assert.Error(t, err)
+	})
+}
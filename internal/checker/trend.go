@@ -0,0 +1,107 @@
+package checker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Default and maximum number of days a compliance trend covers
+const (
+	DefaultTrendDays = 30
+	MaxTrendDays     = 365
+)
+
+// ComplianceSnapshot is a device's compliance score after one bulk run.
+// Score is CalculateComplianceScore of the run's results.
+type ComplianceSnapshot struct {
+	ID         string    `json:"id"`
+	DeviceID   string    `json:"deviceId"`
+	Score      float64   `json:"score"`
+	PassCount  int       `json:"passCount"`
+	FailCount  int       `json:"failCount"`
+	SnapshotAt time.Time `json:"snapshotAt"`
+}
+
+// NewComplianceSnapshot scores the results of one device taken at a time
+func NewComplianceSnapshot(deviceID string, results []CheckResult, at time.Time) ComplianceSnapshot {
+	snapshot := ComplianceSnapshot{
+		ID:         uuid.New().String(),
+		DeviceID:   deviceID,
+		Score:      CalculateComplianceScore(results),
+		SnapshotAt: at,
+	}
+	for _, result := range results {
+		switch CheckStatus(result.Status) {
+		case StatusPass:
+			snapshot.PassCount++
+		case StatusFail:
+			snapshot.FailCount++
+		}
+	}
+	return snapshot
+}
+
+// SaveComplianceSnapshots stores a snapshot for each device of a bulk run
+// with results, all taken at the same time, in one transaction
+func (rs *ResultStore) SaveComplianceSnapshots(results map[string][]CheckResult, at time.Time) error {
+	tx, err := rs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO compliance_snapshots (id, device_id, score, pass_count, fail_count, snapshot_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+
+	for deviceID, deviceResults := range results {
+		if len(deviceResults) == 0 {
+			continue
+		}
+		snapshot := NewComplianceSnapshot(deviceID, deviceResults, at)
+		if _, err := tx.Exec(query, snapshot.ID, snapshot.DeviceID, snapshot.Score,
+			snapshot.PassCount, snapshot.FailCount, snapshot.SnapshotAt); err != nil {
+			return fmt.Errorf("failed to save compliance snapshot of device %s: %w", deviceID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetComplianceTrend returns a device's compliance snapshots of the last
+// days, oldest first. days defaults to DefaultTrendDays and is capped at
+// MaxTrendDays.
+func (rs *ResultStore) GetComplianceTrend(deviceID string, days int) ([]ComplianceSnapshot, error) {
+	if days <= 0 {
+		days = DefaultTrendDays
+	}
+	if days > MaxTrendDays {
+		days = MaxTrendDays
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	rows, err := rs.db.Query(`
+		SELECT id, device_id, score, pass_count, fail_count, snapshot_at
+		FROM compliance_snapshots
+		WHERE device_id = ? AND snapshot_at >= ?
+		ORDER BY snapshot_at ASC
+	`, deviceID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query compliance trend: %w", err)
+	}
+	defer rows.Close()
+
+	trend := []ComplianceSnapshot{}
+	for rows.Next() {
+		var snapshot ComplianceSnapshot
+		if err := rows.Scan(&snapshot.ID, &snapshot.DeviceID, &snapshot.Score,
+			&snapshot.PassCount, &snapshot.FailCount, &snapshot.SnapshotAt); err != nil {
+			return nil, fmt.Errorf("failed to scan compliance snapshot: %w", err)
+		}
+		trend = append(trend, snapshot)
+	}
+	return trend, rows.Err()
+}
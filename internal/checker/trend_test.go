@@ -0,0 +1,92 @@
+package checker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResultStore_ComplianceTrend(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	store := NewResultStore(db)
+	now := time.Now()
+	checkSet := func(statuses ...CheckStatus) []CheckResult {
+		results := make([]CheckResult, len(statuses))
+		for i, status := range statuses {
+			results[i] = newTestResult("device1", "run", status, now)
+		}
+		return results
+	}
+
+	runs := []struct {
+		at      time.Time
+		results []CheckResult
+	}{
+		{now.AddDate(0, 0, -40), checkSet(StatusFail, StatusFail)},
+		{now.AddDate(0, 0, -3), checkSet(StatusPass, StatusFail, StatusFail, StatusFail)},
+		{now.AddDate(0, 0, -2), checkSet(StatusPass, StatusPass, StatusFail, StatusFail)},
+		// A previous failure is resolved and an error is scored as not passed
+		{now.AddDate(0, 0, -1), checkSet(StatusPass, StatusPass, StatusPass, StatusError)},
+	}
+	for _, run := range runs {
+		if err := store.SaveComplianceSnapshots(map[string][]CheckResult{
+			"device1": run.results,
+			"device2": nil,
+		}, run.at); err != nil {
+			t.Fatalf("Failed to save compliance snapshots: %v", err)
+		}
+	}
+
+	trend, err := store.GetComplianceTrend("device1", 7)
+	if err != nil {
+		t.Fatalf("Failed to get compliance trend: %v", err)
+	}
+	if len(trend) != 3 {
+		t.Fatalf("Expected 3 snapshots within 7 days, got %d", len(trend))
+	}
+
+	wantScores := []float64{25, 50, 75}
+	wantPass := []int{1, 2, 3}
+	wantFail := []int{3, 2, 0}
+	for i, snapshot := range trend {
+		if snapshot.Score != wantScores[i] || snapshot.PassCount != wantPass[i] || snapshot.FailCount != wantFail[i] {
+			t.Errorf("Snapshot %d: expected score %v with %d passed and %d failed, got %+v",
+				i, wantScores[i], wantPass[i], wantFail[i], snapshot)
+		}
+		if snapshot.DeviceID != "device1" {
+			t.Errorf("Expected snapshots of device1, got %s", snapshot.DeviceID)
+		}
+	}
+	if !trend[0].SnapshotAt.Before(trend[2].SnapshotAt) {
+		t.Errorf("Expected the oldest snapshot first")
+	}
+	if trend[2].Score <= trend[1].Score {
+		t.Errorf("Expected resolving a failure to raise the score, got %v after %v", trend[2].Score, trend[1].Score)
+	}
+
+	// The default window reaches back 30 days, which still leaves out the first run
+	defaultTrend, err := store.GetComplianceTrend("device1", 0)
+	if err != nil {
+		t.Fatalf("Failed to get compliance trend: %v", err)
+	}
+	if len(defaultTrend) != 3 {
+		t.Errorf("Expected 3 snapshots in the default window, got %d", len(defaultTrend))
+	}
+	longTrend, err := store.GetComplianceTrend("device1", 90)
+	if err != nil {
+		t.Fatalf("Failed to get compliance trend: %v", err)
+	}
+	if len(longTrend) != 4 || longTrend[0].Score != 0 {
+		t.Errorf("Expected all 4 snapshots over 90 days, got %+v", longTrend)
+	}
+
+	// Devices without results get no snapshot
+	empty, err := store.GetComplianceTrend("device2", 90)
+	if err != nil {
+		t.Fatalf("Failed to get compliance trend: %v", err)
+	}
+	if len(empty) != 0 {
+		t.Errorf("Expected no snapshots for a device without results, got %d", len(empty))
+	}
+}
@@ -0,0 +1,299 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+)
+
+// FailOnNone disables failing the check command on findings
+const FailOnNone = "none"
+
+// unknownCommandOutput answers commands missing from a recorded session,
+// as the desktop app's simulation mode does
+const unknownCommandOutput = "% Invalid input detected"
+
+// CheckReport is the outcome of the check command, as written in JSON mode.
+// Devices lists each selected device with its results at or above the
+// severity filter; Summary counts every result.
+type CheckReport struct {
+	RunID      string         `json:"runId"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	FailOn     string         `json:"failOn"`
+	Cancelled  bool           `json:"cancelled"`
+	Devices    []DeviceReport `json:"devices"`
+	Summary    CheckSummary   `json:"summary"`
+}
+
+// CheckSummary counts the results of a check run. Blocking counts the
+// failed results at or above the fail threshold; Passed is false when
+// there are any.
+type CheckSummary struct {
+	Devices  int            `json:"devices"`
+	Results  int            `json:"results"`
+	ByStatus map[string]int `json:"byStatus"`
+	Errors   int            `json:"errors"`
+	Blocking int            `json:"blocking"`
+	Passed   bool           `json:"passed"`
+}
+
+// checkOptions holds the flags of the check command
+type checkOptions struct {
+	options
+	concurrency  int
+	severity     string
+	failOn       string
+	tags         string
+	devices      string
+	simulate     string
+	replayDelays bool
+	label        string
+	note         string
+	incremental  bool
+	forceFull    bool
+	shells       string
+	quiet        bool
+}
+
+// runCheck runs the security checks on the selected devices, stores the
+// results like a run from the desktop app and exits with ExitFailures when
+// a check failed at or above the fail threshold. Suppressed rules are
+// skipped and produce no result, and severity overrides apply before the
+// threshold, so only findings that still stand fail the run.
+func runCheck(ctx context.Context, c *cli, args []string) int {
+	var opts checkOptions
+	fs := c.newFlags("check", &opts.options)
+	fs.IntVar(&opts.concurrency, "concurrency", fs.envInt(EnvConcurrency, 5), "devices checked at once")
+	fs.StringVar(&opts.severity, "severity", fs.env(EnvSeverity, string(checker.SeverityInfo)),
+		"only list results of at least this severity")
+	fs.StringVar(&opts.failOn, "fail-on", fs.env(EnvFailOn, string(checker.SeverityHigh)),
+		"exit nonzero when a check of at least this severity fails, or none")
+	fs.StringVar(&opts.tags, "tag", fs.env(EnvTags, ""), "only check devices with one of these comma-separated tags")
+	fs.StringVar(&opts.devices, "device", fs.env(EnvDevices, ""), "only check the devices with these comma-separated IDs")
+	fs.StringVar(&opts.simulate, "simulate", fs.env(EnvSimulate, ""),
+		"answer commands from the sessions recorded in this directory instead of the network")
+	fs.BoolVar(&opts.replayDelays, "replay-delays", false, "with -simulate, take as long as each recorded command did")
+	fs.StringVar(&opts.label, "label", "", "label stored with the run, such as a change number")
+	fs.StringVar(&opts.note, "note", "", "note stored with the run")
+	fs.BoolVar(&opts.incremental, "incremental", false,
+		"carry forward the previous results of devices whose config has not changed")
+	fs.BoolVar(&opts.forceFull, "force-full", false, "with -incremental, evaluate every rule but record the configs")
+	fs.StringVar(&opts.shells, "shell-sessions", fs.env(EnvShells, ""),
+		"keep one shell open per device for these comma-separated vendors; "+EnvEnableSecret+" answers enable prompts")
+	fs.BoolVar(&opts.quiet, "quiet", false, "do not report progress on stderr")
+	if code, ok := fs.parse(c, args, &opts.options); !ok {
+		return code
+	}
+
+	if opts.concurrency < 1 {
+		return c.errorf(ExitError, "concurrency must be at least 1")
+	}
+	minimum, err := checker.ParseSeverity(opts.severity)
+	if err != nil {
+		return c.errorf(ExitError, "invalid -severity: %v", err)
+	}
+	threshold := -1
+	if !strings.EqualFold(opts.failOn, FailOnNone) {
+		failOn, err := checker.ParseSeverity(opts.failOn)
+		if err != nil {
+			return c.errorf(ExitError, "invalid -fail-on: %v", err)
+		}
+		opts.failOn = string(failOn)
+		threshold = checker.SeverityRank(opts.failOn)
+	} else {
+		opts.failOn = FailOnNone
+	}
+
+	s, err := openStore(opts.dataDir)
+	if err != nil {
+		return c.errorf(ExitError, "%v", err)
+	}
+	defer s.Close()
+
+	devices, err := s.selectDevices(splitList(opts.devices), splitList(opts.tags))
+	if err != nil {
+		return c.errorf(ExitError, "%v", err)
+	}
+	if len(devices) == 0 {
+		fmt.Fprintln(c.stderr, "ncc-cli: no devices selected")
+	}
+
+	engine := s.newEngine()
+	engine.SetWorkerCount(opts.concurrency)
+	for _, vendor := range splitList(opts.shells) {
+		if err := engine.EnableShellSessions(strings.ToLower(vendor), ssh.ShellConfig{}); err != nil {
+			return c.errorf(ExitError, "invalid -shell-sessions: %v", err)
+		}
+	}
+	if secret := fs.getenv(EnvEnableSecret); secret != "" {
+		engine.SetEnableSecretFunc(func(*device.Device) (string, error) { return secret, nil })
+	}
+	runOpts := checker.CheckOptions{Label: opts.label, Note: opts.note,
+		Incremental: opts.incremental, ForceFull: opts.forceFull}
+	if opts.simulate != "" {
+		simulator, err := ssh.NewSimulatedClientFromDir(opts.simulate, ssh.SimulationConfig{
+			UnknownCommandOutput: unknownCommandOutput,
+			ReplayDelays:         opts.replayDelays,
+		})
+		if err != nil {
+			return c.errorf(ExitError, "failed to load recorded sessions: %v", err)
+		}
+		engine.SetSimulator(simulator)
+		runOpts.Simulate = true
+	}
+
+	var progress checker.ProgressCallback
+	if !opts.quiet {
+		progress = c.reportProgress
+	}
+
+	runCtx, cancel := opts.withTimeout(ctx)
+	defer cancel()
+
+	report := CheckReport{StartedAt: time.Now(), FailOn: opts.failOn, Devices: []DeviceReport{}}
+	bulk, err := engine.RunBulkChecksContext(runCtx, devices, runOpts, progress)
+	report.FinishedAt = time.Now()
+	if err != nil && bulk == nil {
+		return c.errorf(ExitError, "%v", err)
+	}
+	report.Cancelled = err != nil
+
+	// Whatever finished before a cancellation is stored
+	for _, deviceResults := range bulk.DeviceResults {
+		if err := s.results.SaveResults(deviceResults); err != nil {
+			return c.errorf(ExitError, "failed to save results: %v", err)
+		}
+		if report.RunID == "" && len(deviceResults) > 0 {
+			report.RunID = deviceResults[0].RunID
+		}
+	}
+	if report.RunID != "" {
+		if err := s.results.SaveRunMetadata(report.RunID, opts.label, opts.note); err != nil {
+			return c.errorf(ExitError, "failed to save run metadata: %v", err)
+		}
+	}
+
+	report.Summary = summarize(devices, bulk, threshold)
+	for _, dev := range devices {
+		report.Devices = append(report.Devices, deviceReport(dev, bulk, minimum))
+	}
+
+	if opts.format == FormatJSON {
+		err = writeJSON(c.stdout, report)
+	} else {
+		err = writeCheckTable(c, report)
+	}
+	if err != nil {
+		return c.errorf(ExitError, "failed to write results: %v", err)
+	}
+
+	switch {
+	case report.Cancelled:
+		return c.cancelled(runCtx, opts.options)
+	case !report.Summary.Passed:
+		return c.errorf(ExitFailures, "%d checks of at least %s severity failed", report.Summary.Blocking, opts.failOn)
+	}
+	return ExitOK
+}
+
+// reportProgress writes a line to stderr as each device starts a rule and
+// when it ends
+func (c *cli) reportProgress(p *checker.CheckProgress) {
+	switch p.Status {
+	case "running":
+		if p.CurrentRule != "" {
+			fmt.Fprintf(c.stderr, "%s: [%d/%d] %s\n", p.DeviceName, p.Progress+1, p.Total, p.CurrentRule)
+		}
+	case "queued":
+	default:
+		if p.Error != "" {
+			fmt.Fprintf(c.stderr, "%s: %s: %s\n", p.DeviceName, p.Status, p.Error)
+			return
+		}
+		fmt.Fprintf(c.stderr, "%s: %s\n", p.DeviceName, p.Status)
+	}
+}
+
+// summarize counts the results of a run; threshold is the severity rank at
+// or above which failures block, or negative when none do
+func summarize(devices []device.Device, bulk *checker.BulkCheckResult, threshold int) CheckSummary {
+	summary := CheckSummary{Devices: len(devices), ByStatus: make(map[string]int), Errors: len(bulk.Errors)}
+	for _, results := range bulk.DeviceResults {
+		for _, result := range results {
+			summary.Results++
+			summary.ByStatus[result.Status]++
+			if result.Status == string(checker.StatusFail) && checker.SeverityRank(result.Severity) <= threshold {
+				summary.Blocking++
+			}
+		}
+	}
+	summary.Passed = summary.Blocking == 0
+	return summary
+}
+
+// deviceReport describes one device of a run, listing its results of at
+// least the minimum severity
+func deviceReport(dev device.Device, bulk *checker.BulkCheckResult, minimum checker.Severity) DeviceReport {
+	report := DeviceReport{
+		ID:        dev.ID,
+		Name:      dev.Name,
+		IPAddress: dev.IPAddress,
+		Results:   atOrAbove(bulk.DeviceResults[dev.ID], minimum),
+	}
+	if progress, ok := bulk.Progress[dev.ID]; ok {
+		report.Status = progress.Status
+	}
+	if err, ok := bulk.Errors[dev.ID]; ok {
+		report.Error = &err
+	}
+	return report
+}
+
+// writeCheckTable writes the results of a run as a table followed by the
+// totals
+func writeCheckTable(c *cli, report CheckReport) error {
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tCHECK\tSEVERITY\tSTATUS\tMESSAGE")
+	for _, dev := range report.Devices {
+		if dev.Error != nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t%s\t%s\n", dev.Name, strings.ToUpper(dev.Status), dev.Error.Message)
+		}
+		for _, result := range dev.Results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", dev.Name, result.CheckName, result.Severity, result.Status,
+				firstLine(result.Message))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stdout, "\n%d devices, %d results", report.Summary.Devices, report.Summary.Results)
+	for _, status := range sortedKeys(report.Summary.ByStatus) {
+		fmt.Fprintf(c.stdout, ", %d %s", report.Summary.ByStatus[status], status)
+	}
+	fmt.Fprintln(c.stdout)
+	if report.RunID != "" {
+		fmt.Fprintf(c.stdout, "Run %s\n", report.RunID)
+	}
+	if report.FailOn == FailOnNone {
+		_, err := fmt.Fprintln(c.stdout, "Failed checks do not fail the run")
+		return err
+	}
+	_, err := fmt.Fprintf(c.stdout, "Failed at %s or above: %d\n", report.FailOn, report.Summary.Blocking)
+	return err
+}
+
+// firstLine returns the first line of a message, for table cells
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,421 @@
+// Package cli runs the check engine without the desktop shell, for CI
+// pipelines. It implements the ncc-cli command in cmd/ncc-cli.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+)
+
+// Exit codes returned by Run
+const (
+	ExitOK = 0
+
+	// ExitFailures means the command ran and found problems: a failed check
+	// at or above the fail threshold, a broken rule or a device that could
+	// not be imported
+	ExitFailures = 1
+
+	// ExitError means bad usage or configuration, or the command could not run
+	ExitError = 2
+
+	// ExitCancelled means the command timed out or was interrupted
+	ExitCancelled = 3
+)
+
+// Environment variables that set flag defaults. Flags given on the command
+// line take precedence.
+const (
+	EnvDataDir     = "NCC_DATA_DIR" // the variable the desktop app reads too
+	EnvFormat      = "NCC_FORMAT"
+	EnvTimeout     = "NCC_TIMEOUT"
+	EnvConcurrency = "NCC_CONCURRENCY"
+	EnvSeverity    = "NCC_SEVERITY"
+	EnvFailOn      = "NCC_FAIL_ON"
+	EnvTags        = "NCC_TAGS"
+	EnvDevices     = "NCC_DEVICES"
+	EnvSimulate    = "NCC_SIMULATE"
+	EnvNetBoxURL   = "NCC_NETBOX_URL"
+	EnvNetBoxToken = "NCC_NETBOX_TOKEN"
+	EnvShells      = "NCC_SHELL_SESSIONS"
+)
+
+// EnvEnableSecret holds the secret that takes device shells into privileged
+// mode during check -shell-sessions. It has no flag so it stays out of the
+// process list.
+const EnvEnableSecret = "NCC_ENABLE_SECRET"
+
+// Output formats. Every command writes FormatTable and FormatJSON;
+// export-results also writes FormatCEF and FormatPDF.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCEF   = "cef"
+	FormatPDF   = "pdf"
+)
+
+// command is one ncc-cli subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, c *cli, args []string) int
+}
+
+// commands lists the subcommands in the order usage shows them
+var commands = []command{
+	{"check", "run security checks and exit nonzero on failures", runCheck},
+	{"import-devices", "add devices from a JSON file or NetBox", runImportDevices},
+	{"export-results", "write the latest results of each device", runExportResults},
+	{"validate-rules", "report rules that cannot run as written", runValidateRules},
+}
+
+// cli holds what every command writes to and reads its configuration from
+type cli struct {
+	getenv func(string) string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Run runs the command named by args[0] with the remaining arguments and
+// returns the process exit code. Output goes to stdout; progress, warnings
+// and errors go to stderr. getenv supplies the environment, as os.Getenv
+// does. Cancelling ctx stops the command as a timeout does.
+func Run(ctx context.Context, args []string, getenv func(string) string, stdout, stderr io.Writer) int {
+	c := &cli{getenv: getenv, stdout: stdout, stderr: &lockedWriter{w: stderr}}
+
+	if len(args) == 0 {
+		c.usage()
+		return ExitError
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		c.usage()
+		return ExitOK
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(ctx, c, args[1:])
+		}
+	}
+	fmt.Fprintf(c.stderr, "unknown command %q\n\n", args[0])
+	c.usage()
+	return ExitError
+}
+
+// usage lists the commands
+func (c *cli) usage() {
+	fmt.Fprintln(c.stderr, "Usage: ncc-cli <command> [flags]")
+	fmt.Fprintln(c.stderr)
+	fmt.Fprintln(c.stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(c.stderr, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(c.stderr)
+	fmt.Fprintln(c.stderr, "Run ncc-cli <command> -h for the flags of a command.")
+}
+
+// errorf reports a failure on stderr and returns code
+func (c *cli) errorf(code int, format string, args ...interface{}) int {
+	fmt.Fprintf(c.stderr, "ncc-cli: "+format+"\n", args...)
+	return code
+}
+
+// options holds the flags shared by every command
+type options struct {
+	dataDir string
+	format  string
+	timeout time.Duration
+}
+
+// flags builds the flag set of a command with the shared flags, taking
+// their defaults from the environment
+type flags struct {
+	*flag.FlagSet
+	getenv func(string) string
+	errs   []error
+}
+
+// newFlags creates the flag set of the named command and registers the
+// shared flags into opts
+func (c *cli) newFlags(name string, opts *options) *flags {
+	fs := &flags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError), getenv: c.getenv}
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&opts.dataDir, "data-dir", fs.env(EnvDataDir, ""),
+		"data directory holding the database (default: the desktop app's)")
+	fs.StringVar(&opts.format, "format", fs.env(EnvFormat, FormatTable), "output format: table or json")
+	fs.DurationVar(&opts.timeout, "timeout", fs.envDuration(EnvTimeout, 0),
+		"cancel the command after this long, e.g. 10m (default: no limit)")
+	return fs
+}
+
+// env returns the environment variable name, or fallback when it is unset
+func (fs *flags) env(name, fallback string) string {
+	if value := strings.TrimSpace(fs.getenv(name)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envInt returns the environment variable name as an integer
+func (fs *flags) envInt(name string, fallback int) int {
+	value := fs.env(name, "")
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		fs.errs = append(fs.errs, fmt.Errorf("%s must be a number, got %q", name, value))
+		return fallback
+	}
+	return n
+}
+
+// envDuration returns the environment variable name as a duration
+func (fs *flags) envDuration(name string, fallback time.Duration) time.Duration {
+	value := fs.env(name, "")
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fs.errs = append(fs.errs, fmt.Errorf("%s must be a duration such as 30s or 10m, got %q", name, value))
+		return fallback
+	}
+	return d
+}
+
+// parse parses args, returning the exit code to stop with when the flags
+// or their environment defaults are invalid, or when help was asked for
+func (fs *flags) parse(c *cli, args []string, opts *options, formats ...string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK, false
+		}
+		return ExitError, false
+	}
+	if len(fs.errs) > 0 {
+		return c.errorf(ExitError, "%v", errors.Join(fs.errs...)), false
+	}
+	if fs.NArg() > 0 {
+		return c.errorf(ExitError, "unexpected argument %q", fs.Arg(0)), false
+	}
+
+	opts.format = strings.ToLower(opts.format)
+	if len(formats) == 0 {
+		formats = []string{FormatTable, FormatJSON}
+	}
+	for _, format := range formats {
+		if opts.format == format {
+			return ExitOK, true
+		}
+	}
+	return c.errorf(ExitError, "unsupported format %q, expected one of %s", opts.format, strings.Join(formats, ", ")), false
+}
+
+// withTimeout bounds ctx by the --timeout flag when one is set
+func (opts options) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if opts.timeout > 0 {
+		return context.WithTimeout(ctx, opts.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// cancelled reports a command stopped by ctx and returns ExitCancelled
+func (c *cli) cancelled(ctx context.Context, opts options) int {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return c.errorf(ExitCancelled, "timed out after %s", opts.timeout)
+	}
+	return c.errorf(ExitCancelled, "interrupted")
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// store holds the components commands work with, opened on a data directory
+type store struct {
+	db      *database.DB
+	devices *device.Manager
+	rules   *checker.RuleManager
+	results *checker.ResultStore
+}
+
+// openStore opens the database in dataDir, migrating it to the current
+// schema, and loads the predefined rules, as the desktop app does on start
+func openStore(dataDir string) (*store, error) {
+	if dataDir == "" {
+		dir, err := database.GetDefaultDataDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get data directory: %w", err)
+		}
+		dataDir = dir
+	}
+
+	db, err := database.NewSQLiteDB(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := database.RunMigrations(db.DB); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	s := &store{
+		db:      db,
+		devices: device.NewManager(db.DB),
+		rules:   checker.NewRuleManager(db.DB),
+		results: checker.NewResultStore(db.DB),
+	}
+	if err := s.rules.LoadPredefinedRules(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load predefined rules: %w", err)
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// newEngine creates a check engine wired to the stores the desktop app
+// gives it, so host key pins, severity overrides and macros apply and
+// snapshots, SSH posture, connection timings and device inventory are
+// recorded
+func (s *store) newEngine() *checker.Engine {
+	client := ssh.NewSSHClient(nil)
+	client.SetHostKeyPins(s.devices.HostKeyPin)
+	engine := checker.NewEngineWithSSHClient(s.rules, client)
+	engine.SetOverrideManager(checker.NewOverrideManager(s.db.DB))
+	engine.SetMacroManager(checker.NewMacroManager(s.db.DB))
+	engine.SetSnapshotStore(checker.NewSnapshotStore(s.db.DB))
+	engine.SetPostureStore(checker.NewPostureStore(s.db.DB))
+	engine.SetConnectionMetricsStore(checker.NewConnectionMetricsStore(s.db.DB))
+	engine.SetResultStore(s.results)
+	engine.SetInventoryHook(s.recordInventory)
+	return engine
+}
+
+// recordInventory stores the model and OS version a check run found
+func (s *store) recordInventory(deviceID string, inventory checker.DeviceInventory) error {
+	return s.devices.UpdateDeviceMetadata(deviceID, inventory.Model, inventory.OSVersion)
+}
+
+// selectDevices returns the devices with the given IDs that carry any of
+// the given tags. No IDs selects every device and no tags any tag. Unknown
+// IDs are an error so a typo does not silently check nothing.
+func (s *store) selectDevices(ids, tags []string) ([]device.Device, error) {
+	var candidates []device.Device
+	if len(ids) == 0 {
+		all, err := s.devices.GetAllDevices()
+		if err != nil {
+			return nil, err
+		}
+		candidates = all
+	} else {
+		for _, id := range ids {
+			dev, err := s.devices.GetDevice(id)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, *dev)
+		}
+	}
+
+	if len(tags) == 0 {
+		return candidates, nil
+	}
+	var selected []device.Device
+	for _, dev := range candidates {
+		if hasAnyTag(dev, tags) {
+			selected = append(selected, dev)
+		}
+	}
+	return selected, nil
+}
+
+// hasAnyTag reports whether a device carries one of tags, ignoring case
+func hasAnyTag(dev device.Device, tags []string) bool {
+	for _, have := range dev.TagList() {
+		for _, want := range tags {
+			if strings.EqualFold(have, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DeviceReport is one device and its results, as written in JSON mode
+type DeviceReport struct {
+	ID        string                `json:"id"`
+	Name      string                `json:"name"`
+	IPAddress string                `json:"ipAddress"`
+	Status    string                `json:"status,omitempty"`
+	Error     *checker.CheckError   `json:"error,omitempty"`
+	Results   []checker.CheckResult `json:"results"`
+}
+
+// atOrAbove keeps the results whose severity is at least minimum
+func atOrAbove(results []checker.CheckResult, minimum checker.Severity) []checker.CheckResult {
+	limit := checker.SeverityRank(string(minimum))
+	kept := []checker.CheckResult{}
+	for _, result := range results {
+		if checker.SeverityRank(result.Severity) <= limit {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// sortedKeys returns the keys of counts in order
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lockedWriter serializes writes, since progress is reported from the
+// engine's workers
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,275 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runCLI runs the CLI with env as its environment and returns the exit code
+// and what it wrote to stdout and stderr
+func runCLI(t *testing.T, ctx context.Context, env map[string]string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := Run(ctx, args, func(name string) string { return env[name] }, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// setupCLITest imports a router into a fresh data directory and records a
+// session for it in which every command takes delay and returns nothing
+func setupCLITest(t *testing.T, delay time.Duration) (env map[string]string, simDir string) {
+	t.Helper()
+	dataDir := t.TempDir()
+	env = map[string]string{EnvDataDir: dataDir}
+
+	devicesFile := filepath.Join(t.TempDir(), "devices.json")
+	devices := `[{"name": "core-rtr-1", "ipAddress": "10.0.0.1", "deviceType": "router", "vendor": "cisco",
+		"username": "admin", "tags": "core,prod"},
+		{"name": "lab-rtr-1", "ipAddress": "10.0.9.1", "deviceType": "router", "vendor": "cisco",
+		"username": "admin", "tags": "lab"}]`
+	require.NoError(t, os.WriteFile(devicesFile, []byte(devices), 0644))
+
+	code, _, stderr := runCLI(t, context.Background(), env, "import-devices", "-file", devicesFile)
+	require.Equal(t, ExitOK, code, stderr)
+
+	s, err := openStore(dataDir)
+	require.NoError(t, err)
+	defer s.Close()
+	all, err := s.devices.GetAllDevices()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+
+	simDir = t.TempDir()
+	engine := s.newEngine()
+	for _, dev := range all {
+		fixture := &ssh.SessionFixture{Version: ssh.FixtureFormatVersion, Host: dev.IPAddress, Port: dev.SSHPort,
+			RecordedAt: time.Now()}
+		commands, err := engine.CommandsForDevice(&dev, checker.CheckOptions{})
+		require.NoError(t, err)
+		for _, cmd := range commands {
+			fixture.Commands = append(fixture.Commands, ssh.RecordedCommand{Command: cmd, Duration: delay})
+		}
+		_, err = ssh.SaveFixture(simDir, fixture)
+		require.NoError(t, err)
+	}
+	return env, simDir
+}
+
+func TestRun_Usage(t *testing.T) {
+	code, _, stderr := runCLI(t, context.Background(), nil)
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, "Usage: ncc-cli")
+
+	code, _, _ = runCLI(t, context.Background(), nil, "help")
+	assert.Equal(t, ExitOK, code)
+
+	code, _, stderr = runCLI(t, context.Background(), nil, "launch")
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, `unknown command "launch"`)
+
+	code, _, stderr = runCLI(t, context.Background(), map[string]string{EnvTimeout: "abc"}, "validate-rules")
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, EnvTimeout)
+
+	code, _, _ = runCLI(t, context.Background(), nil, "check", "-format", "xml")
+	assert.Equal(t, ExitError, code)
+}
+
+func TestRun_Check(t *testing.T) {
+	env, simDir := setupCLITest(t, 0)
+
+	code, stdout, stderr := runCLI(t, context.Background(), env, "check", "-simulate", simDir, "-format", "json",
+		"-tag", "prod", "-label", "CHG-1")
+	require.Equal(t, ExitFailures, code, stderr)
+	assert.Contains(t, stderr, "core-rtr-1: [1/")
+
+	var report CheckReport
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report), stdout)
+	assert.NotEmpty(t, report.RunID)
+	assert.Equal(t, string(checker.SeverityHigh), report.FailOn)
+	assert.False(t, report.Cancelled)
+	require.Len(t, report.Devices, 1)
+	assert.Equal(t, "core-rtr-1", report.Devices[0].Name)
+	assert.NotEmpty(t, report.Devices[0].Results)
+	assert.Equal(t, 1, report.Summary.Devices)
+	assert.Positive(t, report.Summary.Blocking)
+	assert.False(t, report.Summary.Passed)
+
+	// The schema keys are what pipelines parse
+	var raw map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &raw))
+	for _, key := range []string{"runId", "startedAt", "finishedAt", "failOn", "cancelled", "devices", "summary"} {
+		assert.Contains(t, raw, key)
+	}
+	summary := raw["summary"].(map[string]interface{})
+	for _, key := range []string{"devices", "results", "byStatus", "errors", "blocking", "passed"} {
+		assert.Contains(t, summary, key)
+	}
+
+	s, err := openStore(env[EnvDataDir])
+	require.NoError(t, err)
+	metadata, err := s.results.GetRunMetadata(report.RunID)
+	s.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "CHG-1", metadata.Label)
+
+	code, stdout, stderr = runCLI(t, context.Background(), env, "check", "-simulate", simDir, "-fail-on", "none",
+		"-severity", "critical", "-quiet")
+	assert.Equal(t, ExitOK, code, stderr)
+	assert.Empty(t, stderr)
+	assert.Contains(t, stdout, "Failed checks do not fail the run")
+
+	code, _, stderr = runCLI(t, context.Background(), env, "check", "-simulate", simDir, "-device", "missing")
+	assert.Equal(t, ExitError, code)
+	assert.NotEmpty(t, stderr)
+
+	code, _, stderr = runCLI(t, context.Background(), env, "check", "-simulate", simDir, "-fail-on", "none",
+		"-shell-sessions", "Cisco,arista", "-quiet")
+	assert.Equal(t, ExitOK, code, stderr)
+	code, _, stderr = runCLI(t, context.Background(), env, "check", "-simulate", simDir, "-shell-sessions", "acme")
+	assert.Equal(t, ExitError, code)
+	assert.Contains(t, stderr, "-shell-sessions")
+
+	env[EnvFailOn] = "urgent"
+	code, _, _ = runCLI(t, context.Background(), env, "check", "-simulate", simDir)
+	assert.Equal(t, ExitError, code)
+}
+
+func TestRun_CheckTimeout(t *testing.T) {
+	env, simDir := setupCLITest(t, 100*time.Millisecond)
+
+	start := time.Now()
+	code, stdout, stderr := runCLI(t, context.Background(), env, "check", "-simulate", simDir, "-replay-delays",
+		"-timeout", "300ms", "-format", "json", "-quiet")
+	assert.Equal(t, ExitCancelled, code, stderr)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Contains(t, stderr, "timed out after 300ms")
+
+	var report CheckReport
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report), stdout)
+	assert.True(t, report.Cancelled)
+	assert.Len(t, report.Devices, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	code, _, stderr = runCLI(t, ctx, env, "check", "-simulate", simDir, "-quiet")
+	assert.Equal(t, ExitCancelled, code)
+	assert.Contains(t, stderr, "interrupted")
+}
+
+func TestRun_ImportDevices(t *testing.T) {
+	env := map[string]string{EnvDataDir: t.TempDir()}
+	devicesFile := filepath.Join(t.TempDir(), "devices.json")
+	devices := `[{"name": "edge-fw-1", "ipAddress": "10.1.0.1", "deviceType": "firewall", "vendor": "fortinet",
+		"username": "admin"},
+		{"name": "bad", "ipAddress": "not-an-ip", "deviceType": "router", "vendor": "cisco", "username": "admin"}]`
+	require.NoError(t, os.WriteFile(devicesFile, []byte(devices), 0644))
+
+	code, stdout, _ := runCLI(t, context.Background(), env, "import-devices", "-file", devicesFile, "-format", "json")
+	assert.Equal(t, ExitFailures, code)
+	var result device.ImportResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result), stdout)
+	assert.Equal(t, 1, result.Imported)
+	require.Len(t, result.Failed, 1)
+	assert.Equal(t, "bad", result.Failed[0].Name)
+
+	// Importing again skips the device already there
+	code, stdout, _ = runCLI(t, context.Background(), env, "import-devices", "-file", devicesFile)
+	assert.Equal(t, ExitFailures, code)
+	assert.Contains(t, stdout, "Skipped:  1")
+
+	code, _, _ = runCLI(t, context.Background(), env, "import-devices")
+	assert.Equal(t, ExitError, code, "a source is required")
+}
+
+func TestRun_ExportResults(t *testing.T) {
+	env, simDir := setupCLITest(t, 0)
+	code, _, stderr := runCLI(t, context.Background(), env, "check", "-simulate", simDir, "-quiet")
+	require.Equal(t, ExitFailures, code, stderr)
+
+	code, stdout, stderr := runCLI(t, context.Background(), env, "export-results", "-format", "json",
+		"-severity", "high", "-tag", "lab")
+	require.Equal(t, ExitOK, code, stderr)
+	var export ResultsExport
+	require.NoError(t, json.Unmarshal([]byte(stdout), &export), stdout)
+	require.Len(t, export.Devices, 1)
+	assert.Equal(t, "lab-rtr-1", export.Devices[0].Name)
+	require.NotEmpty(t, export.Devices[0].Results)
+	for _, result := range export.Devices[0].Results {
+		assert.LessOrEqual(t, checker.SeverityRank(result.Severity), checker.SeverityRank(string(checker.SeverityHigh)))
+	}
+
+	code, stdout, stderr = runCLI(t, context.Background(), env, "export-results", "-format", "cef")
+	require.Equal(t, ExitOK, code, stderr)
+	assert.True(t, strings.HasPrefix(stdout, "CEF:0|"), stdout)
+
+	output := filepath.Join(t.TempDir(), "report.pdf")
+	code, stdout, stderr = runCLI(t, context.Background(), env, "export-results", "-format", "pdf", "-output", output)
+	require.Equal(t, ExitOK, code, stderr)
+	assert.Empty(t, stdout)
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("%PDF")))
+
+	// Sandbox devices are only exported when asked for
+	s, err := openStore(env[EnvDataDir])
+	require.NoError(t, err)
+	_, err = s.db.Exec(`UPDATE devices SET is_sandbox = 1 WHERE name = 'lab-rtr-1'`)
+	s.Close()
+	require.NoError(t, err)
+	code, stdout, stderr = runCLI(t, context.Background(), env, "export-results", "-format", "json", "-tag", "lab")
+	require.Equal(t, ExitOK, code, stderr)
+	require.NoError(t, json.Unmarshal([]byte(stdout), &export), stdout)
+	assert.Empty(t, export.Devices)
+	code, stdout, stderr = runCLI(t, context.Background(), env, "export-results", "-format", "json", "-tag", "lab",
+		"-include-sandbox")
+	require.Equal(t, ExitOK, code, stderr)
+	require.NoError(t, json.Unmarshal([]byte(stdout), &export), stdout)
+	require.Len(t, export.Devices, 1)
+}
+
+func TestRun_ValidateRules(t *testing.T) {
+	env := map[string]string{EnvDataDir: t.TempDir()}
+
+	code, stdout, stderr := runCLI(t, context.Background(), env, "validate-rules", "-format", "json")
+	require.Equal(t, ExitOK, code, stderr)
+	var report checker.RuleHealthReport
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report), stdout)
+	assert.Positive(t, report.CheckedRules)
+	assert.Empty(t, report.Broken)
+	assert.Empty(t, report.Warnings, "predefined rules are documented")
+
+	s, err := openStore(env[EnvDataDir])
+	require.NoError(t, err)
+	_, err = s.db.Exec(`UPDATE security_rules SET expected_pattern = '([' WHERE id = (SELECT id FROM security_rules WHERE enabled = 1 LIMIT 1)`)
+	s.Close()
+	require.NoError(t, err)
+
+	code, stdout, _ = runCLI(t, context.Background(), env, "validate-rules")
+	assert.Equal(t, ExitFailures, code)
+	assert.Contains(t, stdout, "1 broken")
+	assert.Contains(t, stdout, "RULE")
+
+	s, err = openStore(env[EnvDataDir])
+	require.NoError(t, err)
+	_, err = s.db.Exec(`UPDATE security_rules SET expected_pattern = '.', rationale = '' WHERE expected_pattern = '(['`)
+	s.Close()
+	require.NoError(t, err)
+
+	code, stdout, _ = runCLI(t, context.Background(), env, "validate-rules")
+	assert.Equal(t, ExitOK, code, "undocumented rules only warn")
+	assert.Contains(t, stdout, "0 broken, 1 with warnings")
+	assert.Contains(t, stdout, checker.RuleIssueMissingRationale)
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"invictux-demo/internal/device"
+)
+
+// importOptions holds the flags of the import-devices command
+type importOptions struct {
+	options
+	file        string
+	netBoxURL   string
+	netBoxToken string
+	filter      device.DeviceFilter
+}
+
+// runImportDevices adds devices from a JSON file or a NetBox instance and
+// exits with ExitFailures when any could not be imported
+func runImportDevices(ctx context.Context, c *cli, args []string) int {
+	var opts importOptions
+	fs := c.newFlags("import-devices", &opts.options)
+	fs.StringVar(&opts.file, "file", "", "JSON file holding an array of devices")
+	fs.StringVar(&opts.netBoxURL, "netbox-url", fs.env(EnvNetBoxURL, ""), "NetBox URL to list devices from")
+	fs.StringVar(&opts.netBoxToken, "netbox-token", fs.env(EnvNetBoxToken, ""), "NetBox API token")
+	fs.StringVar(&opts.filter.Site, "site", "", "NetBox site slug to import")
+	fs.StringVar(&opts.filter.Role, "role", "", "NetBox device role slug to import")
+	fs.StringVar(&opts.filter.Tag, "tag", "", "NetBox tag slug to import")
+	fs.StringVar(&opts.filter.Status, "status", "", "NetBox device status to import")
+	fs.StringVar(&opts.filter.Username, "username", "", "SSH username given to devices imported from NetBox")
+	if code, ok := fs.parse(c, args, &opts.options); !ok {
+		return code
+	}
+	if (opts.file == "") == (opts.netBoxURL == "") {
+		return c.errorf(ExitError, "give either -file or -netbox-url")
+	}
+
+	var devices []device.Device
+	if opts.file != "" {
+		data, err := os.ReadFile(opts.file)
+		if err != nil {
+			return c.errorf(ExitError, "failed to read devices: %v", err)
+		}
+		if err := json.Unmarshal(data, &devices); err != nil {
+			return c.errorf(ExitError, "failed to parse %s: %v", opts.file, err)
+		}
+	}
+
+	s, err := openStore(opts.dataDir)
+	if err != nil {
+		return c.errorf(ExitError, "%v", err)
+	}
+	defer s.Close()
+
+	// An import runs to the end once started, NetBox requests being bounded
+	// by their own timeout, so the deadline is only checked before it
+	runCtx, cancel := opts.withTimeout(ctx)
+	defer cancel()
+	if runCtx.Err() != nil {
+		return c.cancelled(runCtx, opts.options)
+	}
+
+	var result *device.ImportResult
+	if opts.file != "" {
+		result, err = s.devices.ImportDevices(devices)
+	} else {
+		result, err = s.devices.ImportFromNetBox(opts.netBoxURL, opts.netBoxToken, opts.filter)
+	}
+	if err != nil {
+		return c.errorf(ExitError, "import failed: %v", err)
+	}
+
+	if opts.format == FormatJSON {
+		err = writeJSON(c.stdout, result)
+	} else {
+		err = writeImportTable(c, result)
+	}
+	if err != nil {
+		return c.errorf(ExitError, "failed to write import result: %v", err)
+	}
+
+	if len(result.Failed) > 0 {
+		return c.errorf(ExitFailures, "%d devices could not be imported", len(result.Failed))
+	}
+	return ExitOK
+}
+
+// writeImportTable writes the counts of an import and why devices failed
+func writeImportTable(c *cli, result *device.ImportResult) error {
+	fmt.Fprintf(c.stdout, "Imported: %d\nSkipped:  %d\nFailed:   %d\n", result.Imported, result.Skipped, len(result.Failed))
+	if len(result.Failed) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(c.stdout)
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tREASON")
+	for _, failure := range result.Failed {
+		fmt.Fprintf(tw, "%s\t%s\n", failure.Name, failure.Reason)
+	}
+	return tw.Flush()
+}
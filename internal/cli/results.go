@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/report"
+)
+
+// ResultsExport is the output of the export-results command in JSON mode
+type ResultsExport struct {
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Devices     []DeviceReport `json:"devices"`
+}
+
+// exportOptions holds the flags of the export-results command
+type exportOptions struct {
+	options
+	output   string
+	severity string
+	tags     string
+	devices  string
+	title    string
+
+	includeSandbox bool
+}
+
+// runExportResults writes the results of each selected device's latest run
+// as a table, JSON, CEF events or a PDF report
+func runExportResults(ctx context.Context, c *cli, args []string) int {
+	var opts exportOptions
+	fs := c.newFlags("export-results", &opts.options)
+	fs.StringVar(&opts.output, "output", "-", "file to write, or - for stdout")
+	fs.StringVar(&opts.severity, "severity", fs.env(EnvSeverity, string(checker.SeverityInfo)),
+		"only export results of at least this severity")
+	fs.StringVar(&opts.tags, "tag", fs.env(EnvTags, ""), "only export devices with one of these comma-separated tags")
+	fs.StringVar(&opts.devices, "device", fs.env(EnvDevices, ""), "only export the devices with these comma-separated IDs")
+	fs.StringVar(&opts.title, "title", "", "title of a PDF report")
+	fs.BoolVar(&opts.includeSandbox, "include-sandbox", false, "also export sandbox devices")
+	if code, ok := fs.parse(c, args, &opts.options, FormatTable, FormatJSON, FormatCEF, FormatPDF); !ok {
+		return code
+	}
+	minimum, err := checker.ParseSeverity(opts.severity)
+	if err != nil {
+		return c.errorf(ExitError, "invalid -severity: %v", err)
+	}
+
+	s, err := openStore(opts.dataDir)
+	if err != nil {
+		return c.errorf(ExitError, "%v", err)
+	}
+	defer s.Close()
+
+	runCtx, cancel := opts.withTimeout(ctx)
+	defer cancel()
+
+	devices, err := s.selectDevices(splitList(opts.devices), splitList(opts.tags))
+	if err != nil {
+		return c.errorf(ExitError, "%v", err)
+	}
+	if !opts.includeSandbox {
+		devices = device.WithoutSandbox(devices)
+	}
+
+	export := ResultsExport{GeneratedAt: time.Now(), Devices: []DeviceReport{}}
+	var results []checker.CheckResult
+	for _, dev := range devices {
+		if runCtx.Err() != nil {
+			return c.cancelled(runCtx, opts.options)
+		}
+		latest, err := s.results.GetLatestRunResults(dev.ID)
+		if err != nil {
+			return c.errorf(ExitError, "failed to load results of device %s: %v", dev.Name, err)
+		}
+		latest = atOrAbove(latest, minimum)
+		results = append(results, latest...)
+		export.Devices = append(export.Devices, DeviceReport{ID: dev.ID, Name: dev.Name, IPAddress: dev.IPAddress, Results: latest})
+	}
+
+	// The whole export is rendered before anything is written, so a failed
+	// report leaves no partial file
+	var out bytes.Buffer
+	generator := report.NewGenerator("")
+	switch opts.format {
+	case FormatJSON:
+		err = writeJSON(&out, export)
+	case FormatCEF:
+		err = generator.GenerateCEF(results, devices, &out)
+	case FormatPDF:
+		err = generator.GeneratePDF(results, devices, report.PDFOptions{Title: opts.title, GeneratedAt: export.GeneratedAt}, &out)
+	default:
+		err = writeResultsTable(&out, export)
+	}
+	if err != nil {
+		return c.errorf(ExitError, "failed to export results: %v", err)
+	}
+
+	if err := writeOutput(c.stdout, opts.output, out.Bytes()); err != nil {
+		return c.errorf(ExitError, "%v", err)
+	}
+	return ExitOK
+}
+
+// writeResultsTable writes exported results as a table
+func writeResultsTable(w io.Writer, export ResultsExport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tCHECK\tSEVERITY\tSTATUS\tCHECKED AT\tFINGERPRINT")
+	for _, dev := range export.Devices {
+		for _, result := range dev.Results {
+			// Carried forward results show when the rule was evaluated
+			checkedAt := result.CheckedAt.UTC().Format(time.RFC3339)
+			if result.CarriedForward && result.EvaluatedAt != nil {
+				checkedAt = result.EvaluatedAt.UTC().Format(time.RFC3339) + " (carried)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", dev.Name, result.CheckName, result.Severity, result.Status,
+				checkedAt, result.Fingerprint)
+		}
+	}
+	return tw.Flush()
+}
+
+// writeOutput writes data to path, or to stdout when path is -
+func writeOutput(stdout io.Writer, path string, data []byte) error {
+	if path == "-" || path == "" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
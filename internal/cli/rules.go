@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"invictux-demo/internal/checker"
+)
+
+// runValidateRules checks every enabled rule, as the desktop app's rule
+// health check does, and exits with ExitFailures when any cannot run.
+// Undocumented rules are listed as warnings without failing.
+func runValidateRules(ctx context.Context, c *cli, args []string) int {
+	var opts options
+	fs := c.newFlags("validate-rules", &opts)
+	if code, ok := fs.parse(c, args, &opts); !ok {
+		return code
+	}
+
+	s, err := openStore(opts.dataDir)
+	if err != nil {
+		return c.errorf(ExitError, "%v", err)
+	}
+	defer s.Close()
+
+	runCtx, cancel := opts.withTimeout(ctx)
+	defer cancel()
+	if runCtx.Err() != nil {
+		return c.cancelled(runCtx, opts)
+	}
+
+	report, err := s.rules.ValidateAllRules()
+	if err != nil {
+		return c.errorf(ExitError, "%v", err)
+	}
+
+	if opts.format == FormatJSON {
+		err = writeJSON(c.stdout, report)
+	} else {
+		err = writeRuleHealthTable(c, report)
+	}
+	if err != nil {
+		return c.errorf(ExitError, "failed to write rule health: %v", err)
+	}
+
+	if !report.Healthy() {
+		return c.errorf(ExitFailures, "%d rules cannot run as written", len(report.Broken))
+	}
+	return ExitOK
+}
+
+// writeRuleHealthTable writes one row per issue of each broken rule,
+// followed by one per warning
+func writeRuleHealthTable(c *cli, report *checker.RuleHealthReport) error {
+	fmt.Fprintf(c.stdout, "Checked %d rules, %d broken, %d with warnings\n", report.CheckedRules,
+		len(report.Broken), len(report.Warnings))
+	if report.Healthy() && len(report.Warnings) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(c.stdout)
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tVENDOR\tLEVEL\tISSUE\tDETAIL")
+	for _, rule := range report.Broken {
+		for _, issue := range rule.Issues {
+			fmt.Fprintf(tw, "%s\t%s\terror\t%s\t%s\n", rule.RuleName, rule.Vendor, issue.Kind, issue.Detail)
+		}
+	}
+	for _, rule := range report.Warnings {
+		for _, issue := range rule.Issues {
+			fmt.Fprintf(tw, "%s\t%s\twarning\t%s\t%s\n", rule.RuleName, rule.Vendor, issue.Kind, issue.Detail)
+		}
+	}
+	return tw.Flush()
+}
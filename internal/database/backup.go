@@ -0,0 +1,156 @@
+package database
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CompressedBackupExtension is the recommended extension of compressed backups
+const CompressedBackupExtension = ".db.gz"
+
+// BackupCompressed writes a gzip-compressed backup of the database to path.
+// The database is first copied with Backup to a temporary file next to path.
+func (db *DB) BackupCompressed(path string) (err error) {
+	backupDir := filepath.Dir(path)
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	tempDir, err := os.MkdirTemp(backupDir, ".backup-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary backup directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// VACUUM INTO refuses to overwrite, so the copy goes to a fresh name
+	copyPath := filepath.Join(tempDir, databaseFileName)
+	if err := db.Backup(copyPath); err != nil {
+		return err
+	}
+
+	source, err := os.Open(copyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup copy: %w", err)
+	}
+	defer source.Close()
+
+	target, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed backup: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			target.Close()
+			os.Remove(path)
+		}
+	}()
+
+	writer := gzip.NewWriter(target)
+	writer.Name = databaseFileName
+	if _, err = io.Copy(writer, source); err != nil {
+		return fmt.Errorf("failed to compress backup: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress backup: %w", err)
+	}
+	if err = target.Close(); err != nil {
+		return fmt.Errorf("failed to write compressed backup: %w", err)
+	}
+	return nil
+}
+
+// RestoreFromCompressed replaces the live database with a backup written by
+// BackupCompressed. The backup is decompressed and checked before the live
+// file is touched. Every connection of the pool is closed and the pool is
+// reopened on the restored file, so *sql.DB values taken from db before the
+// restore are closed. It must not be called while the database is in use.
+func (db *DB) RestoreFromCompressed(path string) error {
+	if db.dataDir == "" || db.config == nil {
+		return fmt.Errorf("database was not opened from a data directory")
+	}
+
+	restored, err := decompressBackup(path, db.dataDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(restored)
+
+	if err := checkDatabaseFile(restored); err != nil {
+		return err
+	}
+
+	if err := db.DB.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+
+	livePath := filepath.Join(db.dataDir, databaseFileName)
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(livePath + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", livePath+suffix, err)
+		}
+	}
+	if err := os.Rename(restored, livePath); err != nil {
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+
+	pool, err := openPool(livePath, db.config, &db.leaks)
+	if err != nil {
+		return fmt.Errorf("failed to reopen restored database: %w", err)
+	}
+	db.DB = pool
+	return nil
+}
+
+// decompressBackup decompresses a gzip backup into a temporary file in dir,
+// on the same file system as the live database so it can be renamed over it
+func decompressBackup(path, dir string) (string, error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer source.Close()
+
+	reader, err := gzip.NewReader(source)
+	if err != nil {
+		return "", fmt.Errorf("backup is not gzip-compressed: %w", err)
+	}
+	defer reader.Close()
+
+	target, err := os.CreateTemp(dir, databaseFileName+".restore-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create restore file: %w", err)
+	}
+
+	if _, err := io.Copy(target, reader); err != nil {
+		target.Close()
+		os.Remove(target.Name())
+		return "", fmt.Errorf("failed to decompress backup: %w", err)
+	}
+	if err := target.Close(); err != nil {
+		os.Remove(target.Name())
+		return "", fmt.Errorf("failed to write restore file: %w", err)
+	}
+	return target.Name(), nil
+}
+
+// checkDatabaseFile verifies that path holds an intact SQLite database
+func checkDatabaseFile(path string) error {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup database: %w", err)
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return fmt.Errorf("backup is not a valid database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup database is damaged: %s", result)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newBackupTestDB returns a migrated database holding one setting
+func newBackupTestDB(t *testing.T, value string) *DB {
+	t.Helper()
+
+	db, err := NewSQLiteDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := RunMigrations(db.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO app_settings (key, value) VALUES ('test_key', ?)`, value); err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	return db
+}
+
+// settingValue reads the test setting from db
+func settingValue(t *testing.T, db *DB) string {
+	t.Helper()
+
+	var value string
+	if err := db.QueryRow(`SELECT value FROM app_settings WHERE key = 'test_key'`).Scan(&value); err != nil {
+		t.Fatalf("Failed to read test data: %v", err)
+	}
+	return value
+}
+
+func TestBackupCompressed(t *testing.T) {
+	db := newBackupTestDB(t, "original")
+
+	backupPath := filepath.Join(t.TempDir(), "backups", "backup"+CompressedBackupExtension)
+	if err := db.BackupCompressed(backupPath); err != nil {
+		t.Fatalf("BackupCompressed failed: %v", err)
+	}
+
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatalf("Failed to read backup: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("\x1f\x8b")) {
+		t.Fatalf("Backup does not start with the gzip magic bytes: % x", data[:2])
+	}
+
+	// The decompressed backup is a database holding the data
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decompress backup: %v", err)
+	}
+	restoredDir := t.TempDir()
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decompress backup: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(restoredDir, databaseFileName), decompressed, 0600); err != nil {
+		t.Fatalf("Failed to write decompressed backup: %v", err)
+	}
+
+	restored, err := NewSQLiteDB(restoredDir)
+	if err != nil {
+		t.Fatalf("Failed to open decompressed backup: %v", err)
+	}
+	defer restored.Close()
+	if value := settingValue(t, restored); value != "original" {
+		t.Errorf("Expected backed up value 'original', got %q", value)
+	}
+
+	// Only the compressed file is left behind
+	entries, err := os.ReadDir(filepath.Dir(backupPath))
+	if err != nil {
+		t.Fatalf("Failed to list backup directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the backup file, found %d entries", len(entries))
+	}
+}
+
+func TestRestoreFromCompressed(t *testing.T) {
+	db := newBackupTestDB(t, "original")
+
+	backupPath := filepath.Join(t.TempDir(), "backup"+CompressedBackupExtension)
+	if err := db.BackupCompressed(backupPath); err != nil {
+		t.Fatalf("BackupCompressed failed: %v", err)
+	}
+
+	if _, err := db.Exec(`UPDATE app_settings SET value = 'changed' WHERE key = 'test_key'`); err != nil {
+		t.Fatalf("Failed to change test data: %v", err)
+	}
+
+	if err := db.RestoreFromCompressed(backupPath); err != nil {
+		t.Fatalf("RestoreFromCompressed failed: %v", err)
+	}
+	if value := settingValue(t, db); value != "original" {
+		t.Errorf("Expected restored value 'original', got %q", value)
+	}
+	if err := db.HealthCheck(); err != nil {
+		t.Errorf("Restored database failed its health check: %v", err)
+	}
+}
+
+func TestRestoreFromCompressedRejectsInvalidBackup(t *testing.T) {
+	db := newBackupTestDB(t, "original")
+	dir := t.TempDir()
+
+	// Not gzip at all
+	plainPath := filepath.Join(dir, "plain.db.gz")
+	if err := os.WriteFile(plainPath, []byte("not a backup"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := db.RestoreFromCompressed(plainPath); err == nil {
+		t.Error("Expected an error restoring a file that is not gzip")
+	}
+
+	// gzip, but not a database
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write(bytes.Repeat([]byte("garbage "), 1024))
+	writer.Close()
+	garbagePath := filepath.Join(dir, "garbage.db.gz")
+	if err := os.WriteFile(garbagePath, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := db.RestoreFromCompressed(garbagePath); err == nil {
+		t.Error("Expected an error restoring a file that is not a database")
+	}
+
+	// The live database is untouched
+	if value := settingValue(t, db); value != "original" {
+		t.Errorf("Expected live value 'original', got %q", value)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(db.GetDataDir(), databaseFileName+".restore-*")); len(leftovers) != 0 {
+		t.Errorf("Expected restore files to be removed, found %v", leftovers)
+	}
+}
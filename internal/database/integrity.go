@@ -0,0 +1,488 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// IntegrityMode selects how thoroughly CheckIntegrity examines the database
+type IntegrityMode string
+
+// Integrity check modes
+const (
+	// IntegrityQuick runs PRAGMA quick_check, which skips index contents
+	// and is fast enough for every startup
+	IntegrityQuick IntegrityMode = "quick"
+	// IntegrityFull runs PRAGMA integrity_check, which also verifies that
+	// indexes match their tables
+	IntegrityFull IntegrityMode = "full"
+)
+
+// BackupDirName is the directory under the data directory searched for
+// compressed backups when a corrupt database is recovered
+const BackupDirName = "backups"
+
+// Recovery methods reported by RecoveryReport
+const (
+	RecoveryFromBackup = "backup"
+	RecoverySalvage    = "salvage"
+)
+
+// corruptSuffix is inserted between the database file name and the time a
+// corrupt database was set aside
+const corruptSuffix = ".corrupt-"
+
+// IntegrityReport is the outcome of an integrity check. Problems holds the
+// lines SQLite reported, or the error that stopped the check when the file
+// is too damaged to be checked at all.
+type IntegrityReport struct {
+	Mode       IntegrityMode `json:"mode"`
+	OK         bool          `json:"ok"`
+	Problems   []string      `json:"problems,omitempty"`
+	CheckedAt  time.Time     `json:"checkedAt"`
+	DurationMs int64         `json:"durationMs"`
+}
+
+// RecoveryStep is one step of a recovery, in the order it was taken
+type RecoveryStep struct {
+	Action string    `json:"action"`
+	OK     bool      `json:"ok"`
+	Detail string    `json:"detail,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// RecoveryReport describes the recovery of a corrupt database. Integrity is
+// the check that found the corruption and PreservedPath is where the corrupt
+// file was moved. A salvage lists the rows copied per table in SalvagedRows
+// and the tables that could not be read in full in DamagedTables.
+type RecoveryReport struct {
+	Integrity     *IntegrityReport `json:"integrity"`
+	Recovered     bool             `json:"recovered"`
+	Method        string           `json:"method,omitempty"`
+	BackupPath    string           `json:"backupPath,omitempty"`
+	PreservedPath string           `json:"preservedPath,omitempty"`
+	SalvagedRows  map[string]int   `json:"salvagedRows,omitempty"`
+	DamagedTables []string         `json:"damagedTables,omitempty"`
+	Steps         []RecoveryStep   `json:"steps"`
+}
+
+// step records a recovery step
+func (r *RecoveryReport) step(action string, err error, detail string) {
+	step := RecoveryStep{Action: action, OK: err == nil, Detail: detail, At: time.Now()}
+	if err != nil {
+		step.Detail = err.Error()
+	}
+	r.Steps = append(r.Steps, step)
+}
+
+// RecoveryOptions configures RecoverDatabase
+type RecoveryOptions struct {
+	// BackupDir holds compressed backups to restore from, newest first.
+	// Empty skips straight to salvaging the corrupt file.
+	BackupDir string
+}
+
+// DefaultRecoveryOptions returns the options used at startup: backups are
+// taken from BackupDirName under the data directory
+func DefaultRecoveryOptions(dataDir string) RecoveryOptions {
+	return RecoveryOptions{BackupDir: filepath.Join(dataDir, BackupDirName)}
+}
+
+// CheckIntegrity checks the open database
+func (db *DB) CheckIntegrity(ctx context.Context, mode IntegrityMode) (*IntegrityReport, error) {
+	return checkIntegrity(ctx, db.DB, mode)
+}
+
+// CheckFileIntegrity checks the database file at path without opening a
+// pool on it. The file is opened read-write so a leftover WAL is applied.
+func CheckFileIntegrity(path string, mode IntegrityMode) (*IntegrityReport, error) {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=rw")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+
+	return checkIntegrity(context.Background(), conn, mode)
+}
+
+// checkIntegrity runs the integrity pragma of mode on conn. Corruption is
+// reported in the result; errors are only returned when the check could not
+// run for another reason.
+func checkIntegrity(ctx context.Context, conn *sql.DB, mode IntegrityMode) (*IntegrityReport, error) {
+	pragma := "PRAGMA quick_check"
+	switch mode {
+	case IntegrityQuick:
+	case IntegrityFull:
+		pragma = "PRAGMA integrity_check"
+	default:
+		return nil, fmt.Errorf("unknown integrity check mode %q", mode)
+	}
+
+	report := &IntegrityReport{Mode: mode, CheckedAt: time.Now()}
+	defer func() { report.DurationMs = time.Since(report.CheckedAt).Milliseconds() }()
+
+	problems, err := queryStrings(ctx, conn, pragma)
+	if err != nil {
+		if !isCorruption(err) {
+			return nil, fmt.Errorf("failed to check database integrity: %w", err)
+		}
+		problems = append(problems, err.Error())
+	}
+	for _, problem := range problems {
+		if problem != "ok" {
+			report.Problems = append(report.Problems, problem)
+		}
+	}
+	report.OK = len(report.Problems) == 0
+	return report, nil
+}
+
+// isCorruption reports whether err is SQLite finding a damaged file
+func isCorruption(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB
+	}
+	return false
+}
+
+// queryStrings returns the single string column of every row of query,
+// keeping the rows read before an error
+func queryStrings(ctx context.Context, conn *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// CheckAndRecover quick-checks the database file in dataDir before it is
+// opened and recovers it with RecoverDatabase when it is corrupt. It returns
+// nil reports when there is no database file yet, and a nil RecoveryReport
+// when the file is intact. A failed recovery is returned with its report
+// and leaves the corrupt file in place.
+func CheckAndRecover(dataDir string, opts RecoveryOptions) (*IntegrityReport, *RecoveryReport, error) {
+	livePath := filepath.Join(dataDir, databaseFileName)
+	if _, err := os.Stat(livePath); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+
+	integrity, err := CheckFileIntegrity(livePath, IntegrityQuick)
+	if err != nil {
+		return nil, nil, err
+	}
+	if integrity.OK {
+		return integrity, nil, nil
+	}
+
+	recovery, err := RecoverDatabase(dataDir, integrity, opts)
+	return integrity, recovery, err
+}
+
+// RecoverDatabase replaces the corrupt database file in dataDir, which must
+// not be open. The newest compressed backup in opts.BackupDir that passes a
+// quick check is restored; without one, every readable row of the corrupt
+// file is copied into a freshly migrated database. The replacement is built
+// and checked before the corrupt file is touched, then the corrupt file is
+// kept next to it under a timestamped name. integrity is the check that
+// found the corruption.
+func RecoverDatabase(dataDir string, integrity *IntegrityReport, opts RecoveryOptions) (*RecoveryReport, error) {
+	report := &RecoveryReport{Integrity: integrity}
+	livePath := filepath.Join(dataDir, databaseFileName)
+
+	replacement := restoreNewestBackup(dataDir, opts.BackupDir, report)
+	if replacement == "" {
+		var err error
+		replacement, err = salvageDatabase(livePath, dataDir, report)
+		report.step("salvage readable rows", err, report.salvageSummary())
+		if err != nil {
+			return report, fmt.Errorf("failed to salvage corrupt database: %w", err)
+		}
+		report.Method = RecoverySalvage
+	}
+	defer os.Remove(replacement)
+
+	if err := checkDatabaseFile(replacement); err != nil {
+		report.step("verify recovered database", err, "")
+		return report, fmt.Errorf("recovered database is not intact: %w", err)
+	}
+	report.step("verify recovered database", nil, "")
+
+	preservedPath := livePath + corruptSuffix + time.Now().UTC().Format("20060102T150405Z")
+	if err := moveDatabaseFile(livePath, preservedPath); err != nil {
+		report.step("preserve corrupt database", err, "")
+		return report, err
+	}
+	report.PreservedPath = preservedPath
+	report.step("preserve corrupt database", nil, preservedPath)
+
+	if err := os.Rename(replacement, livePath); err != nil {
+		report.step("replace database", err, "")
+		return report, fmt.Errorf("failed to replace database: %w", err)
+	}
+	report.step("replace database", nil, livePath)
+
+	report.Recovered = true
+	return report, nil
+}
+
+// restoreNewestBackup decompresses the newest intact backup in backupDir
+// into dataDir and returns the decompressed file, or empty when none is
+// usable
+func restoreNewestBackup(dataDir, backupDir string, report *RecoveryReport) string {
+	if backupDir == "" {
+		return ""
+	}
+
+	backups, err := compressedBackups(backupDir)
+	if err != nil {
+		report.step("find backups", err, "")
+		return ""
+	}
+	if len(backups) == 0 {
+		report.step("find backups", nil, "no backups in "+backupDir)
+		return ""
+	}
+
+	for _, backup := range backups {
+		restored, err := decompressBackup(backup, dataDir)
+		if err == nil {
+			if err = checkDatabaseFile(restored); err != nil {
+				os.Remove(restored)
+			}
+		}
+		report.step("restore backup", err, backup)
+		if err == nil {
+			report.Method = RecoveryFromBackup
+			report.BackupPath = backup
+			return restored
+		}
+	}
+	return ""
+}
+
+// compressedBackups lists the compressed backups in dir, newest first
+func compressedBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	type backup struct {
+		path    string
+		modTime time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), CompressedBackupExtension) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{filepath.Join(dir, entry.Name()), info.ModTime()})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].modTime.After(backups[j].modTime) })
+
+	paths := make([]string, len(backups))
+	for i, b := range backups {
+		paths[i] = b.path
+	}
+	return paths, nil
+}
+
+// salvageSummary describes what a salvage copied
+func (r *RecoveryReport) salvageSummary() string {
+	rows := 0
+	for _, count := range r.SalvagedRows {
+		rows += count
+	}
+	summary := fmt.Sprintf("%d rows from %d tables", rows, len(r.SalvagedRows))
+	if len(r.DamagedTables) > 0 {
+		summary += fmt.Sprintf("; damaged: %s", strings.Join(r.DamagedTables, ", "))
+	}
+	return summary
+}
+
+// salvageDatabase copies every readable row of the corrupt database at
+// corruptPath into a new, fully migrated database file in dir and returns
+// its path. Columns are matched by name, so a corrupt database on an older
+// schema is carried forward. A table is read until its first unreadable
+// page; the rows before it are kept and the table is listed as damaged.
+func salvageDatabase(corruptPath, dir string, report *RecoveryReport) (string, error) {
+	source, err := sql.Open("sqlite3", "file:"+corruptPath+"?mode=ro")
+	if err != nil {
+		return "", fmt.Errorf("failed to open corrupt database: %w", err)
+	}
+	defer source.Close()
+	source.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	tables, err := queryStrings(ctx, source,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return "", fmt.Errorf("failed to read schema of corrupt database: %w", err)
+	}
+
+	target, err := os.CreateTemp(dir, databaseFileName+".salvage-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create salvage file: %w", err)
+	}
+	targetPath := target.Name()
+	target.Close()
+
+	fresh, err := sql.Open("sqlite3", targetPath)
+	if err != nil {
+		os.Remove(targetPath)
+		return "", fmt.Errorf("failed to open salvage database: %w", err)
+	}
+	fresh.SetMaxOpenConns(1)
+
+	if err := RunMigrations(fresh); err != nil {
+		fresh.Close()
+		os.Remove(targetPath)
+		return "", fmt.Errorf("failed to create salvage schema: %w", err)
+	}
+
+	report.SalvagedRows = make(map[string]int)
+	for _, table := range tables {
+		// The fresh database records its own migrations
+		if table == "schema_migrations" {
+			continue
+		}
+		copied, err := salvageTable(ctx, source, fresh, table)
+		if copied > 0 || err == nil {
+			report.SalvagedRows[table] = copied
+		}
+		if err != nil {
+			report.DamagedTables = append(report.DamagedTables, table)
+		}
+	}
+
+	if err := fresh.Close(); err != nil {
+		os.Remove(targetPath)
+		return "", fmt.Errorf("failed to write salvage database: %w", err)
+	}
+	return targetPath, nil
+}
+
+// salvageTable copies the readable rows of table from source into target,
+// returning how many were copied. Tables the current schema no longer has
+// are skipped.
+func salvageTable(ctx context.Context, source, target *sql.DB, table string) (int, error) {
+	targetColumns, err := queryStrings(ctx, target, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return 0, err
+	}
+	if len(targetColumns) == 0 {
+		return 0, nil
+	}
+	sourceColumns, err := queryStrings(ctx, source, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return 0, err
+	}
+
+	known := make(map[string]bool, len(targetColumns))
+	for _, column := range targetColumns {
+		known[column] = true
+	}
+	var columns []string
+	for _, column := range sourceColumns {
+		if known[column] {
+			columns = append(columns, quoteIdentifier(column))
+		}
+	}
+	if len(columns) == 0 {
+		return 0, nil
+	}
+
+	tx, err := target.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Salvaged rows replace the defaults the migrations inserted
+	insert, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (%s)",
+		quoteIdentifier(table), strings.Join(columns, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")))
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	rows, err := source.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), quoteIdentifier(table)))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	copied := 0
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	var readErr error
+	for rows.Next() {
+		if readErr = rows.Scan(pointers...); readErr != nil {
+			break
+		}
+		if _, err := insert.ExecContext(ctx, values...); err != nil {
+			readErr = err
+			break
+		}
+		copied++
+	}
+	if readErr == nil {
+		readErr = rows.Err()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return copied, readErr
+}
+
+// quoteIdentifier quotes a table or column name for SQLite
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// moveDatabaseFile renames a database file together with its WAL and
+// shared-memory files
+func moveDatabaseFile(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return fmt.Errorf("failed to move %s: %w", from, err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Rename(from+suffix, to+suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move %s: %w", from+suffix, err)
+		}
+	}
+	return nil
+}
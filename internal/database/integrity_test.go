@@ -0,0 +1,316 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newIntegrityTestDir returns a data directory holding a closed, migrated
+// database with a setting and deviceCount devices
+func newIntegrityTestDir(t *testing.T, deviceCount int) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := NewSQLiteDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := RunMigrations(db.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO app_settings (key, value) VALUES ('test_key', 'kept')`); err != nil {
+		t.Fatalf("Failed to insert setting: %v", err)
+	}
+	for i := 0; i < deviceCount; i++ {
+		if _, err := db.Exec(`INSERT INTO devices (id, name, ip_address, device_type, vendor, username, password_encrypted)
+			VALUES (?, ?, ?, 'router', 'cisco', 'admin', X'00')`,
+			fmt.Sprintf("device-%d", i), fmt.Sprintf("Device %d", i), fmt.Sprintf("10.0.%d.%d", i/250, i%250+1)); err != nil {
+			t.Fatalf("Failed to insert device: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+	return dir
+}
+
+// damageTable overwrites the root page of a table in the closed database in
+// dir with garbage
+func damageTable(t *testing.T, dir, table string) {
+	t.Helper()
+
+	path := filepath.Join(dir, databaseFileName)
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	var rootPage, pageSize int64
+	if err := conn.QueryRow(`SELECT rootpage FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&rootPage); err != nil {
+		t.Fatalf("Failed to find root page of %s: %v", table, err)
+	}
+	if err := conn.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		t.Fatalf("Failed to read page size: %v", err)
+	}
+	conn.Close()
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open database file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt(bytes.Repeat([]byte{0xA5}, int(pageSize)), (rootPage-1)*pageSize); err != nil {
+		t.Fatalf("Failed to damage database file: %v", err)
+	}
+}
+
+// openRecovered opens the database left in dir by a recovery
+func openRecovered(t *testing.T, dir string) *DB {
+	t.Helper()
+
+	db, err := NewSQLiteDB(dir)
+	if err != nil {
+		t.Fatalf("Failed to open recovered database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// countRows counts the rows of a table
+func countRows(t *testing.T, db *DB, table string) int {
+	t.Helper()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatalf("Failed to count %s: %v", table, err)
+	}
+	return count
+}
+
+func TestCheckFileIntegrity(t *testing.T) {
+	dir := newIntegrityTestDir(t, 3)
+	path := filepath.Join(dir, databaseFileName)
+
+	for _, mode := range []IntegrityMode{IntegrityQuick, IntegrityFull} {
+		report, err := CheckFileIntegrity(path, mode)
+		if err != nil {
+			t.Fatalf("%s check failed: %v", mode, err)
+		}
+		if !report.OK || len(report.Problems) != 0 || report.Mode != mode {
+			t.Errorf("Expected an intact database from the %s check, got %+v", mode, report)
+		}
+	}
+
+	damageTable(t, dir, "devices")
+	report, err := CheckFileIntegrity(path, IntegrityQuick)
+	if err != nil {
+		t.Fatalf("Expected corruption to be reported rather than returned, got %v", err)
+	}
+	if report.OK || len(report.Problems) == 0 {
+		t.Errorf("Expected the damaged table to be detected, got %+v", report)
+	}
+
+	if _, err := CheckFileIntegrity(path, IntegrityMode("thorough")); err == nil {
+		t.Error("Expected an unknown mode to be rejected")
+	}
+}
+
+func TestCheckFileIntegrity_TruncatedAndHeader(t *testing.T) {
+	dir := newIntegrityTestDir(t, 200)
+	path := filepath.Join(dir, databaseFileName)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat database: %v", err)
+	}
+	if err := os.Truncate(path, info.Size()/2); err != nil {
+		t.Fatalf("Failed to truncate database: %v", err)
+	}
+	report, err := CheckFileIntegrity(path, IntegrityQuick)
+	if err != nil {
+		t.Fatalf("Expected the truncation to be reported, got error %v", err)
+	}
+	if report.OK {
+		t.Error("Expected a truncated database to fail the check")
+	}
+
+	// A flipped header is not recognized as a database at all
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open database file: %v", err)
+	}
+	file.WriteAt([]byte("not a database!!"), 0)
+	file.Close()
+
+	report, err = CheckFileIntegrity(path, IntegrityQuick)
+	if err != nil {
+		t.Fatalf("Expected the damaged header to be reported, got error %v", err)
+	}
+	if report.OK || !strings.Contains(strings.Join(report.Problems, " "), "not a database") {
+		t.Errorf("Expected the damaged header to be reported, got %+v", report)
+	}
+}
+
+func TestDB_CheckIntegrity(t *testing.T) {
+	db := openRecovered(t, newIntegrityTestDir(t, 1))
+
+	report, err := db.CheckIntegrity(context.Background(), IntegrityFull)
+	if err != nil {
+		t.Fatalf("CheckIntegrity failed: %v", err)
+	}
+	if !report.OK || report.Mode != IntegrityFull || report.CheckedAt.IsZero() {
+		t.Errorf("Expected an intact full check, got %+v", report)
+	}
+}
+
+func TestCheckAndRecover_Intact(t *testing.T) {
+	integrity, recovery, err := CheckAndRecover(t.TempDir(), RecoveryOptions{})
+	if err != nil || integrity != nil || recovery != nil {
+		t.Errorf("Expected nothing to check without a database, got %+v, %+v, %v", integrity, recovery, err)
+	}
+
+	dir := newIntegrityTestDir(t, 2)
+	integrity, recovery, err = CheckAndRecover(dir, DefaultRecoveryOptions(dir))
+	if err != nil {
+		t.Fatalf("CheckAndRecover failed: %v", err)
+	}
+	if integrity == nil || !integrity.OK || recovery != nil {
+		t.Errorf("Expected an intact database to be left alone, got %+v, %+v", integrity, recovery)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*"+corruptSuffix+"*")); len(matches) != 0 {
+		t.Errorf("Expected no preserved copies of an intact database, got %v", matches)
+	}
+}
+
+func TestCheckAndRecover_Salvage(t *testing.T) {
+	dir := newIntegrityTestDir(t, 5)
+	damageTable(t, dir, "devices")
+	corrupt, err := os.ReadFile(filepath.Join(dir, databaseFileName))
+	if err != nil {
+		t.Fatalf("Failed to read corrupt database: %v", err)
+	}
+
+	integrity, recovery, err := CheckAndRecover(dir, DefaultRecoveryOptions(dir))
+	if err != nil {
+		t.Fatalf("Expected the database to be salvaged, got %v (steps %+v)", err, recovery.Steps)
+	}
+	if integrity.OK || recovery.Integrity != integrity {
+		t.Errorf("Expected the failed check to be reported, got %+v", integrity)
+	}
+	if !recovery.Recovered || recovery.Method != RecoverySalvage {
+		t.Fatalf("Expected a salvage, got %+v", recovery)
+	}
+
+	// The damaged table is reported and the intact ones are carried over
+	if len(recovery.DamagedTables) != 1 || recovery.DamagedTables[0] != "devices" {
+		t.Errorf("Expected the devices table to be reported damaged, got %v", recovery.DamagedTables)
+	}
+	if recovery.SalvagedRows["app_settings"] == 0 {
+		t.Errorf("Expected the settings to be salvaged, got %v", recovery.SalvagedRows)
+	}
+	db := openRecovered(t, dir)
+	var value string
+	if err := db.QueryRow(`SELECT value FROM app_settings WHERE key = 'test_key'`).Scan(&value); err != nil || value != "kept" {
+		t.Errorf("Expected the salvaged setting, got %q, %v", value, err)
+	}
+	if count := countRows(t, db, "devices"); count != 0 {
+		t.Errorf("Expected the unreadable devices to be lost, got %d", count)
+	}
+	if version, err := SchemaVersion(db.DB); err != nil || version != LatestSchemaVersion() {
+		t.Errorf("Expected the salvaged database to be fully migrated, got %d, %v", version, err)
+	}
+	if report, err := db.CheckIntegrity(context.Background(), IntegrityFull); err != nil || !report.OK {
+		t.Errorf("Expected the salvaged database to be intact, got %+v, %v", report, err)
+	}
+
+	// The corrupt original is kept byte for byte
+	if !strings.HasPrefix(filepath.Base(recovery.PreservedPath), databaseFileName+corruptSuffix) {
+		t.Errorf("Expected a timestamped name for the corrupt original, got %s", recovery.PreservedPath)
+	}
+	preserved, err := os.ReadFile(recovery.PreservedPath)
+	if err != nil {
+		t.Fatalf("Failed to read preserved database: %v", err)
+	}
+	if !bytes.Equal(preserved, corrupt) {
+		t.Error("Expected the corrupt original to be preserved unchanged")
+	}
+
+	var actions []string
+	for _, step := range recovery.Steps {
+		if !step.OK && step.Action != "find backups" {
+			t.Errorf("Expected step %s to succeed, got %+v", step.Action, step)
+		}
+		actions = append(actions, step.Action)
+	}
+	expected := []string{"find backups", "salvage readable rows", "verify recovered database", "preserve corrupt database", "replace database"}
+	if strings.Join(actions, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected steps %v, got %v", expected, actions)
+	}
+}
+
+func TestCheckAndRecover_FromBackup(t *testing.T) {
+	dir := newIntegrityTestDir(t, 4)
+	opts := DefaultRecoveryOptions(dir)
+
+	db := openRecovered(t, dir)
+	older := filepath.Join(opts.BackupDir, "older"+CompressedBackupExtension)
+	if err := db.BackupCompressed(older); err != nil {
+		t.Fatalf("BackupCompressed failed: %v", err)
+	}
+	db.Close()
+
+	// A newer backup that is damaged is passed over
+	newer := filepath.Join(opts.BackupDir, "newer"+CompressedBackupExtension)
+	if err := os.WriteFile(newer, []byte("not gzip"), 0600); err != nil {
+		t.Fatalf("Failed to write damaged backup: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(newer, later, later)
+
+	damageTable(t, dir, "devices")
+
+	_, recovery, err := CheckAndRecover(dir, opts)
+	if err != nil {
+		t.Fatalf("Expected the backup to be restored, got %v", err)
+	}
+	if recovery.Method != RecoveryFromBackup || recovery.BackupPath != older {
+		t.Fatalf("Expected the older intact backup to be restored, got %+v", recovery)
+	}
+	if len(recovery.Steps) < 2 || recovery.Steps[0].OK || recovery.Steps[0].Detail == "" {
+		t.Errorf("Expected the damaged backup to be reported as a failed step, got %+v", recovery.Steps)
+	}
+	if _, err := os.Stat(recovery.PreservedPath); err != nil {
+		t.Errorf("Expected the corrupt original to be preserved: %v", err)
+	}
+
+	restored := openRecovered(t, dir)
+	if count := countRows(t, restored, "devices"); count != 4 {
+		t.Errorf("Expected the backed up devices, got %d", count)
+	}
+}
+
+func TestCheckAndRecover_UnreadableSchema(t *testing.T) {
+	dir := newIntegrityTestDir(t, 1)
+	path := filepath.Join(dir, databaseFileName)
+	if err := os.WriteFile(path, bytes.Repeat([]byte{0x5A}, 8192), 0600); err != nil {
+		t.Fatalf("Failed to overwrite database: %v", err)
+	}
+
+	_, recovery, err := CheckAndRecover(dir, RecoveryOptions{})
+	if err == nil || recovery == nil || recovery.Recovered {
+		t.Fatalf("Expected the recovery to fail, got %+v, %v", recovery, err)
+	}
+	// The corrupt file is left where it was
+	if data, err := os.ReadFile(path); err != nil || data[0] != 0x5A {
+		t.Errorf("Expected the corrupt database to stay in place, got %v", err)
+	}
+	if last := recovery.Steps[len(recovery.Steps)-1]; last.OK || last.Action != "salvage readable rows" {
+		t.Errorf("Expected the failed salvage to be reported, got %+v", last)
+	}
+}
@@ -0,0 +1,204 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"log"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// OpenStatement is a query whose rows have not been closed yet, or a
+// statement still executing
+type OpenStatement struct {
+	ID          uint64    `json:"id"`
+	Query       string    `json:"query"`
+	GoroutineID uint64    `json:"goroutineId"`
+	OpenedAt    time.Time `json:"openedAt"`
+	// Stack is the stack trace of the goroutine that opened the statement
+	Stack string `json:"stack"`
+}
+
+// LeakDetector records the statements open on the connections of a DB and
+// warns about those left open longer than LeakThreshold, typically rows a
+// goroutine never closed after returning early or panicking. Queries and
+// executions are tracked; prepared statements are not.
+type LeakDetector struct {
+	LeakThreshold time.Duration
+
+	mutex  sync.Mutex
+	nextID uint64
+	open   map[uint64]*trackedStatement
+
+	// logf reports leaks; tests replace it
+	logf func(format string, args ...interface{})
+}
+
+// trackedStatement is an open statement with the timer that reports it
+type trackedStatement struct {
+	OpenStatement
+	timer *time.Timer
+}
+
+// NewLeakDetector creates a leak detector warning about statements open
+// longer than threshold
+func NewLeakDetector(threshold time.Duration) *LeakDetector {
+	return &LeakDetector{
+		LeakThreshold: threshold,
+		open:          make(map[uint64]*trackedStatement),
+		logf:          log.Printf,
+	}
+}
+
+// GetOpenStatements returns the statements open now, oldest first
+func (d *LeakDetector) GetOpenStatements() []OpenStatement {
+	return d.statements(time.Time{})
+}
+
+// GetLeakedStatements returns the statements open longer than the
+// threshold, oldest first
+func (d *LeakDetector) GetLeakedStatements() []OpenStatement {
+	return d.statements(time.Now().Add(-d.LeakThreshold))
+}
+
+// statements returns the open statements opened before cutoff, or every
+// open statement for a zero cutoff
+func (d *LeakDetector) statements(cutoff time.Time) []OpenStatement {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	statements := make([]OpenStatement, 0, len(d.open))
+	for _, statement := range d.open {
+		if cutoff.IsZero() || statement.OpenedAt.Before(cutoff) {
+			statements = append(statements, statement.OpenStatement)
+		}
+	}
+	sort.Slice(statements, func(i, j int) bool { return statements[i].ID < statements[j].ID })
+	return statements
+}
+
+// track records a statement opened by the calling goroutine and returns
+// the function that forgets it
+func (d *LeakDetector) track(query string) (release func()) {
+	stack := make([]byte, 16<<10)
+	stack = stack[:runtime.Stack(stack, false)]
+
+	d.mutex.Lock()
+	d.nextID++
+	statement := &trackedStatement{OpenStatement: OpenStatement{
+		ID:          d.nextID,
+		Query:       query,
+		GoroutineID: goroutineID(stack),
+		OpenedAt:    time.Now(),
+		Stack:       string(stack),
+	}}
+	d.open[statement.ID] = statement
+	statement.timer = time.AfterFunc(d.LeakThreshold, func() { d.report(statement.ID) })
+	d.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			statement.timer.Stop()
+			delete(d.open, statement.ID)
+		})
+	}
+}
+
+// report warns about a statement still open at the threshold
+func (d *LeakDetector) report(id uint64) {
+	d.mutex.Lock()
+	statement, open := d.open[id]
+	d.mutex.Unlock()
+	if !open {
+		return
+	}
+
+	d.logf("Database statement open for more than %s, opened by goroutine %d: %s\n%s",
+		d.LeakThreshold, statement.GoroutineID, statement.Query, statement.Stack)
+}
+
+// goroutineID parses the goroutine ID from the first line of a stack trace,
+// "goroutine 123 [running]:"
+func goroutineID(stack []byte) uint64 {
+	line, _, _ := bytes.Cut(stack, []byte("\n"))
+	fields := bytes.Fields(line)
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return id
+}
+
+// EnableLeakDetection starts recording the statements open on the
+// database's connections and warning about any open longer than threshold.
+// Statements already open are not tracked. A threshold of zero or less
+// turns detection off.
+func (db *DB) EnableLeakDetection(threshold time.Duration) {
+	if threshold <= 0 {
+		db.leaks.Store(nil)
+		return
+	}
+	db.leaks.Store(NewLeakDetector(threshold))
+}
+
+// GetLeakedStatements returns the statements open longer than the leak
+// detection threshold, or nil when leak detection is off
+func (db *DB) GetLeakedStatements() []OpenStatement {
+	if detector := db.leaks.Load(); detector != nil {
+		return detector.GetLeakedStatements()
+	}
+	return nil
+}
+
+// leakTrackingConn is a SQLite connection reporting its statements to the
+// leak detector of its DB while one is enabled
+type leakTrackingConn struct {
+	*sqlite3.SQLiteConn
+	connector *sqliteConnector
+}
+
+func (c *leakTrackingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	detector := c.connector.leakDetector()
+	if detector == nil {
+		return c.SQLiteConn.QueryContext(ctx, query, args)
+	}
+
+	release := detector.track(query)
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if sqliteRows, ok := rows.(*sqlite3.SQLiteRows); ok {
+		return &leakTrackingRows{SQLiteRows: sqliteRows, release: release}, nil
+	}
+	release()
+	return rows, nil
+}
+
+func (c *leakTrackingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if detector := c.connector.leakDetector(); detector != nil {
+		defer detector.track(query)()
+	}
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+// leakTrackingRows forgets its statement when closed
+type leakTrackingRows struct {
+	*sqlite3.SQLiteRows
+	release func()
+}
+
+func (r *leakTrackingRows) Close() error {
+	r.release()
+	return r.SQLiteRows.Close()
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLeakDetection(t *testing.T) {
+	db, err := NewSQLiteDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if leaked := db.GetLeakedStatements(); leaked != nil {
+		t.Errorf("Expected no leaks while detection is off, got %v", leaked)
+	}
+
+	db.EnableLeakDetection(50 * time.Millisecond)
+	detector := db.leaks.Load()
+	var mutex sync.Mutex
+	var warnings []string
+	detector.logf = func(format string, args ...interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	ctx := context.Background()
+
+	// Statements closed in time are forgotten
+	if _, err := db.ExecContext(ctx, "CREATE TABLE items (id INTEGER)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	closed, err := db.QueryContext(ctx, "SELECT id FROM items")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	closed.Close()
+
+	const leakyQuery = "SELECT 1 UNION ALL SELECT 2"
+	rows, err := db.QueryContext(ctx, leakyQuery)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	// rows is deliberately left open past the threshold
+
+	if open := detector.GetOpenStatements(); len(open) != 1 || open[0].Query != leakyQuery {
+		t.Fatalf("Expected only the unclosed query to be open, got %+v", open)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mutex.Lock()
+		logged := len(warnings)
+		mutex.Unlock()
+		if logged > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	leaked := db.GetLeakedStatements()
+	if len(leaked) != 1 {
+		t.Fatalf("Expected one leaked statement, got %+v", leaked)
+	}
+	stack := make([]byte, 1024)
+	if want := goroutineID(stack[:runtime.Stack(stack, false)]); leaked[0].GoroutineID != want {
+		t.Errorf("Expected the leak to name goroutine %d, got %d", want, leaked[0].GoroutineID)
+	}
+	if !strings.Contains(leaked[0].Stack, "TestLeakDetection") {
+		t.Errorf("Expected the opener's stack, got %s", leaked[0].Stack)
+	}
+
+	mutex.Lock()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], leakyQuery) || !strings.Contains(warnings[0], "TestLeakDetection") {
+		t.Errorf("Expected one warning with the query and stack, got %q", warnings)
+	}
+	mutex.Unlock()
+
+	rows.Close()
+	if open := detector.GetOpenStatements(); len(open) != 0 {
+		t.Errorf("Expected closing the rows to forget them, got %+v", open)
+	}
+
+	db.EnableLeakDetection(0)
+	if leaked := db.GetLeakedStatements(); leaked != nil {
+		t.Errorf("Expected detection to be off, got %v", leaked)
+	}
+}
+
+func TestGoroutineID(t *testing.T) {
+	if id := goroutineID([]byte("goroutine 42 [running]:\nmain.main()")); id != 42 {
+		t.Errorf("Expected goroutine 42, got %d", id)
+	}
+	if id := goroutineID([]byte("garbage")); id != 0 {
+		t.Errorf("Expected 0 for an unparseable stack, got %d", id)
+	}
+}
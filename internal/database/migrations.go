@@ -10,8 +10,36 @@ type Migration struct {
 	Version int
 	Name    string
 	SQL     string
+
+	// Requires names the SQLite compile option the SQL needs, if any. On a
+	// build without it the migration is recorded without running.
+	Requires string
 }
 
+// SearchIndexOption is the SQLite compile option the device search index
+// needs; builds get it from the sqlite_fts5 build tag
+const SearchIndexOption = "ENABLE_FTS5"
+
+// SearchIndexSQL creates the full-text device search index and the triggers
+// keeping it in step with the devices table. Rows are linked by device ID
+// rather than rowid, which VACUUM may renumber. The device manager runs it
+// again when a build with FTS5 opens a database migrated without it.
+const SearchIndexSQL = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS devices_fts USING fts5(name, tags, ip_address, id UNINDEXED);
+	CREATE TRIGGER IF NOT EXISTS devices_fts_ai AFTER INSERT ON devices BEGIN
+		INSERT INTO devices_fts (name, tags, ip_address, id) VALUES (new.name, new.tags, new.ip_address, new.id);
+	END;
+	CREATE TRIGGER IF NOT EXISTS devices_fts_ad AFTER DELETE ON devices BEGIN
+		DELETE FROM devices_fts WHERE id = old.id;
+	END;
+	CREATE TRIGGER IF NOT EXISTS devices_fts_au AFTER UPDATE OF name, tags, ip_address ON devices BEGIN
+		DELETE FROM devices_fts WHERE id = old.id;
+		INSERT INTO devices_fts (name, tags, ip_address, id) VALUES (new.name, new.tags, new.ip_address, new.id);
+	END;
+	INSERT INTO devices_fts (name, tags, ip_address, id)
+		SELECT name, tags, ip_address, id FROM devices WHERE id NOT IN (SELECT id FROM devices_fts);
+`
+
 // GetMigrations returns all database migrations
 func GetMigrations() []Migration {
 	return []Migration{
@@ -92,6 +120,485 @@ func GetMigrations() []Migration {
 				);
 			`,
 		},
+		{
+			Version: 6,
+			Name:    "create_skipped_rules_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS skipped_rules (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					device_id TEXT NOT NULL,
+					rule_id TEXT NOT NULL,
+					rule_name TEXT NOT NULL,
+					reason TEXT NOT NULL,
+					skipped_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+				);
+				CREATE INDEX IF NOT EXISTS idx_skipped_rules_device ON skipped_rules(device_id, skipped_at);
+			`,
+		},
+		{
+			Version: 7,
+			Name:    "add_security_rules_command_overrides",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN command_overrides TEXT;
+			`,
+		},
+		{
+			Version: 8,
+			Name:    "create_rule_vendor_overrides_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS rule_vendor_overrides (
+					rule_id TEXT NOT NULL,
+					vendor TEXT NOT NULL,
+					command TEXT NOT NULL,
+					expected_pattern TEXT,
+					PRIMARY KEY (rule_id, vendor),
+					FOREIGN KEY (rule_id) REFERENCES security_rules(id) ON DELETE CASCADE
+				);
+				CREATE INDEX IF NOT EXISTS idx_rule_vendor_overrides_vendor ON rule_vendor_overrides(vendor);
+			`,
+		},
+		{
+			Version: 9,
+			Name:    "add_security_rules_rule_version",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN rule_version INTEGER DEFAULT 1;
+			`,
+		},
+		{
+			Version: 10,
+			Name:    "add_devices_status_columns",
+			SQL: `
+				ALTER TABLE devices ADD COLUMN status TEXT;
+				ALTER TABLE devices ADD COLUMN last_checked DATETIME;
+			`,
+		},
+		{
+			Version: 11,
+			Name:    "add_devices_version",
+			SQL: `
+				ALTER TABLE devices ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+			`,
+		},
+		{
+			Version: 12,
+			Name:    "add_security_rules_section_matching",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN all_match BOOLEAN DEFAULT FALSE;
+				ALTER TABLE security_rules ADD COLUMN section_pattern TEXT;
+			`,
+		},
+		{
+			Version: 13,
+			Name:    "add_check_results_run_columns",
+			SQL: `
+				ALTER TABLE check_results ADD COLUMN run_id TEXT;
+				ALTER TABLE check_results ADD COLUMN command_variant TEXT;
+				CREATE INDEX IF NOT EXISTS idx_check_results_run ON check_results(run_id);
+				CREATE INDEX IF NOT EXISTS idx_check_results_device ON check_results(device_id, checked_at);
+				CREATE INDEX IF NOT EXISTS idx_check_results_checked_at ON check_results(checked_at);
+			`,
+		},
+		{
+			Version: 14,
+			Name:    "create_audit_log_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS audit_log (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+					user_id TEXT NOT NULL,
+					action_type TEXT NOT NULL,
+					entity_type TEXT NOT NULL,
+					entity_id TEXT,
+					details TEXT
+				);
+				CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);
+				CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity_type, entity_id);
+			`,
+		},
+		{
+			Version: 15,
+			Name:    "create_check_result_comments_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS check_result_comments (
+					id TEXT PRIMARY KEY,
+					check_result_id TEXT NOT NULL,
+					author_id TEXT NOT NULL,
+					body TEXT NOT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (check_result_id) REFERENCES check_results(id) ON DELETE CASCADE
+				);
+				CREATE INDEX IF NOT EXISTS idx_check_result_comments_result ON check_result_comments(check_result_id, created_at);
+			`,
+		},
+		{
+			Version: 16,
+			Name:    "create_credential_rotation_tables",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS credential_rotations (
+					id TEXT PRIMARY KEY,
+					status TEXT NOT NULL,
+					new_password_encrypted BLOB,
+					created_by TEXT NOT NULL,
+					started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					finished_at DATETIME
+				);
+				CREATE TABLE IF NOT EXISTS credential_rotation_devices (
+					rotation_id TEXT NOT NULL,
+					device_id TEXT NOT NULL,
+					device_name TEXT NOT NULL,
+					vendor TEXT NOT NULL,
+					outcome TEXT NOT NULL,
+					message TEXT,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (rotation_id, device_id),
+					FOREIGN KEY (rotation_id) REFERENCES credential_rotations(id) ON DELETE CASCADE
+				);
+			`,
+		},
+		{
+			Version: 17,
+			Name:    "add_check_results_message_columns",
+			SQL: `
+				ALTER TABLE check_results ADD COLUMN message_id TEXT;
+				ALTER TABLE check_results ADD COLUMN message_params TEXT;
+			`,
+		},
+		{
+			Version: 18,
+			Name:    "create_config_snapshots_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS config_snapshots (
+					id TEXT PRIMARY KEY,
+					device_id TEXT NOT NULL,
+					config_gzip BLOB NOT NULL,
+					config_size INTEGER NOT NULL,
+					config_hash TEXT NOT NULL,
+					captured_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+				);
+				CREATE INDEX IF NOT EXISTS idx_config_snapshots_device ON config_snapshots(device_id, captured_at);
+			`,
+		},
+		{
+			Version: 19,
+			Name:    "create_check_runs_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS check_runs (
+					run_id TEXT PRIMARY KEY,
+					label TEXT NOT NULL DEFAULT '',
+					note TEXT NOT NULL DEFAULT '',
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+			`,
+		},
+		{
+			Version: 20,
+			Name:    "add_check_results_evidence_gzip_column",
+			SQL: `
+				ALTER TABLE check_results ADD COLUMN evidence_gzip BLOB;
+			`,
+		},
+		{
+			Version: 21,
+			Name:    "add_devices_maintenance_windows_column",
+			SQL: `
+				ALTER TABLE devices ADD COLUMN maintenance_windows TEXT;
+			`,
+		},
+		{
+			Version: 22,
+			Name:    "add_audit_log_entity_user_index",
+			SQL: `
+				CREATE INDEX IF NOT EXISTS idx_audit_log_entity_user ON audit_log(entity_type, user_id, timestamp);
+			`,
+		},
+		{
+			Version: 23,
+			Name:    "create_device_ssh_posture_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS device_ssh_posture (
+					device_id TEXT PRIMARY KEY,
+					key_exchange TEXT NOT NULL DEFAULT '',
+					host_key TEXT NOT NULL DEFAULT '',
+					cipher TEXT NOT NULL DEFAULT '',
+					mac TEXT NOT NULL DEFAULT '',
+					server_version TEXT NOT NULL DEFAULT '',
+					observed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+				);
+			`,
+		},
+		{
+			Version: 24,
+			Name:    "create_severity_overrides_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS severity_overrides (
+					id TEXT PRIMARY KEY,
+					scope TEXT NOT NULL,
+					target TEXT NOT NULL,
+					rule_id TEXT NOT NULL,
+					severity TEXT NOT NULL,
+					reason TEXT NOT NULL,
+					expires_at DATETIME,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					UNIQUE (scope, target, rule_id),
+					FOREIGN KEY (rule_id) REFERENCES security_rules(id) ON DELETE CASCADE
+				);
+				ALTER TABLE check_results ADD COLUMN original_severity TEXT;
+				ALTER TABLE check_results ADD COLUMN severity_override_reason TEXT;
+			`,
+		},
+		{
+			Version: 25,
+			Name:    "add_rule_needs_attention",
+			SQL:     `ALTER TABLE security_rules ADD COLUMN needs_attention BOOLEAN NOT NULL DEFAULT FALSE;`,
+		},
+		{
+			Version: 26,
+			Name:    "add_check_results_duration_column",
+			SQL:     `ALTER TABLE check_results ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0;`,
+		},
+		{
+			Version: 27,
+			Name:    "create_device_connectivity_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS device_connectivity (
+					device_id TEXT PRIMARY KEY,
+					network_reachable BOOLEAN NOT NULL DEFAULT FALSE,
+					ssh_port_open BOOLEAN NOT NULL DEFAULT FALSE,
+					response_time_ms INTEGER NOT NULL DEFAULT 0,
+					error_code TEXT NOT NULL DEFAULT '',
+					error_message TEXT NOT NULL DEFAULT '',
+					tested_at DATETIME,
+					FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+				);
+			`,
+		},
+		{
+			Version: 28,
+			Name:    "create_device_connection_metrics_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS device_connection_metrics (
+					device_id TEXT NOT NULL,
+					phase TEXT NOT NULL,
+					samples INTEGER NOT NULL DEFAULT 0,
+					total_ms INTEGER NOT NULL DEFAULT 0,
+					max_ms INTEGER NOT NULL DEFAULT 0,
+					buckets TEXT NOT NULL DEFAULT '[]',
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (device_id, phase),
+					FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+				);
+			`,
+		},
+		{
+			Version: 29,
+			Name:    "create_command_macros_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS command_macros (
+					name TEXT PRIMARY KEY,
+					command TEXT NOT NULL,
+					description TEXT NOT NULL DEFAULT '',
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+				);
+			`,
+		},
+		{
+			Version: 30,
+			Name:    "add_rule_exit_code_and_stream_columns",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN expected_exit_code INTEGER;
+				ALTER TABLE security_rules ADD COLUMN stream_target TEXT NOT NULL DEFAULT '';
+				ALTER TABLE check_results ADD COLUMN evidence_stream TEXT;
+			`,
+		},
+		{
+			Version: 31,
+			Name:    "add_security_rules_category",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN category TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			Version: 32,
+			Name:    "add_devices_output_charset_column",
+			SQL: `
+				ALTER TABLE devices ADD COLUMN output_charset TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			Version: 33,
+			Name:    "add_security_rules_remediation",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN remediation TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			Version: 34,
+			Name:    "add_evidence_snippet_columns",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN evidence_lines INTEGER NOT NULL DEFAULT 0;
+				ALTER TABLE check_results ADD COLUMN evidence_full_path TEXT;
+			`,
+		},
+		{
+			Version: 35,
+			Name:    "create_compliance_snapshots_table",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS compliance_snapshots (
+					id TEXT PRIMARY KEY,
+					device_id TEXT NOT NULL,
+					score REAL NOT NULL,
+					pass_count INTEGER NOT NULL DEFAULT 0,
+					fail_count INTEGER NOT NULL DEFAULT 0,
+					snapshot_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+				);
+				CREATE INDEX IF NOT EXISTS idx_compliance_snapshots_device ON compliance_snapshots(device_id, snapshot_at);
+			`,
+		},
+		{
+			Version: 36,
+			Name:    "add_finding_fingerprint_columns",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN finding_category TEXT NOT NULL DEFAULT '';
+				ALTER TABLE security_rules ADD COLUMN finding_key TEXT NOT NULL DEFAULT '';
+				ALTER TABLE check_results ADD COLUMN finding_category TEXT;
+				ALTER TABLE check_results ADD COLUMN finding_key TEXT;
+				ALTER TABLE check_results ADD COLUMN fingerprint TEXT;
+				CREATE INDEX IF NOT EXISTS idx_check_results_fingerprint ON check_results(fingerprint, checked_at);
+			`,
+		},
+		{
+			Version: 37,
+			Name:    "add_devices_is_sandbox_column",
+			SQL: `
+				ALTER TABLE devices ADD COLUMN is_sandbox BOOLEAN NOT NULL DEFAULT FALSE;
+			`,
+		},
+		{
+			Version: 38,
+			Name:    "add_security_rules_precondition_columns",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN precondition_command TEXT;
+				ALTER TABLE security_rules ADD COLUMN precondition_pattern TEXT;
+			`,
+		},
+		{
+			Version: 39,
+			Name:    "add_incremental_check_columns",
+			SQL: `
+				ALTER TABLE check_results ADD COLUMN carried_forward BOOLEAN NOT NULL DEFAULT FALSE;
+				ALTER TABLE check_results ADD COLUMN evaluated_at DATETIME;
+				ALTER TABLE check_results ADD COLUMN carried_from_run_id TEXT;
+				CREATE TABLE IF NOT EXISTS device_change_indicators (
+					device_id TEXT PRIMARY KEY,
+					run_id TEXT NOT NULL,
+					indicator TEXT NOT NULL,
+					rule_states TEXT NOT NULL DEFAULT '{}',
+					recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+				);
+			`,
+		},
+		{
+			Version: 40,
+			Name:    "add_security_rules_documentation_columns",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN rationale TEXT NOT NULL DEFAULT '';
+				ALTER TABLE security_rules ADD COLUMN rule_references TEXT;
+			`,
+		},
+		{
+			Version: 41,
+			Name:    "add_device_host_key_policy_columns",
+			SQL: `
+				ALTER TABLE devices ADD COLUMN host_key_policy TEXT NOT NULL DEFAULT 'tofu';
+				ALTER TABLE devices ADD COLUMN host_key_fingerprint TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			Version: 42,
+			Name:    "add_device_keyboard_responses_column",
+			SQL: `
+				ALTER TABLE devices ADD COLUMN keyboard_responses TEXT;
+			`,
+		},
+		{
+			Version: 43,
+			Name:    "add_device_model_os_version_columns",
+			SQL: `
+				ALTER TABLE devices ADD COLUMN model TEXT NOT NULL DEFAULT '';
+				ALTER TABLE devices ADD COLUMN os_version TEXT NOT NULL DEFAULT '';
+			`,
+		},
+		{
+			Version: 44,
+			Name:    "add_security_rules_depends_on",
+			SQL:     `ALTER TABLE security_rules ADD COLUMN depends_on TEXT;`,
+		},
+		{
+			Version: 45,
+			Name:    "add_audit_log_role",
+			SQL:     `ALTER TABLE audit_log ADD COLUMN role TEXT NOT NULL DEFAULT '';`,
+		},
+		{
+			Version: 46,
+			Name:    "add_security_rules_predefined_columns",
+			SQL: `
+				ALTER TABLE security_rules ADD COLUMN predefined BOOLEAN NOT NULL DEFAULT FALSE;
+				ALTER TABLE security_rules ADD COLUMN source_version INTEGER NOT NULL DEFAULT 0;
+				ALTER TABLE security_rules ADD COLUMN user_modified BOOLEAN NOT NULL DEFAULT FALSE;
+			`,
+		},
+		{
+			Version: 47,
+			Name:    "add_skipped_rules_run_id",
+			SQL: `
+				ALTER TABLE skipped_rules ADD COLUMN run_id TEXT NOT NULL DEFAULT '';
+				CREATE INDEX IF NOT EXISTS idx_skipped_rules_run ON skipped_rules(run_id);
+			`,
+		},
+		{
+			Version:  48,
+			Name:     "create_devices_search_index",
+			SQL:      SearchIndexSQL,
+			Requires: SearchIndexOption,
+		},
+		{
+			Version: 49,
+			Name:    "add_check_results_rule_id",
+			SQL: `
+				ALTER TABLE check_results ADD COLUMN rule_id TEXT;
+				CREATE INDEX IF NOT EXISTS idx_check_results_rule ON check_results(device_id, rule_id);
+				UPDATE check_results SET rule_id = (
+					SELECT s.id FROM security_rules s WHERE s.name = check_results.check_name
+				)
+				WHERE (SELECT COUNT(*) FROM security_rules s WHERE s.name = check_results.check_name) = 1;
+			`,
+		},
+		{
+			Version: 50,
+			Name:    "create_rule_command_acknowledgements",
+			SQL: `
+				CREATE TABLE IF NOT EXISTS rule_command_acknowledgements (
+					rule_id TEXT NOT NULL,
+					command TEXT NOT NULL,
+					acknowledged_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (rule_id, command),
+					FOREIGN KEY (rule_id) REFERENCES security_rules(id) ON DELETE CASCADE
+				);
+			`,
+		},
+		{
+			Version: 51,
+			Name:    "add_check_results_command",
+			SQL:     `ALTER TABLE check_results ADD COLUMN command TEXT;`,
+		},
 	}
 }
 
@@ -164,6 +671,11 @@ func getAppliedMigrations(db *sql.DB) ([]int, error) {
 
 // runMigration executes a single migration
 func runMigration(db *sql.DB, migration Migration) error {
+	supported, err := migrationSupported(db, migration)
+	if err != nil {
+		return err
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -171,8 +683,10 @@ func runMigration(db *sql.DB, migration Migration) error {
 	defer tx.Rollback()
 
 	// Execute the migration SQL
-	if _, err := tx.Exec(migration.SQL); err != nil {
-		return err
+	if supported {
+		if _, err := tx.Exec(migration.SQL); err != nil {
+			return err
+		}
 	}
 
 	// Record the migration as applied
@@ -184,6 +698,24 @@ func runMigration(db *sql.DB, migration Migration) error {
 	return tx.Commit()
 }
 
+// migrationSupported reports whether SQLite was built with the compile
+// option a migration requires
+func migrationSupported(db *sql.DB, migration Migration) (bool, error) {
+	if migration.Requires == "" {
+		return true, nil
+	}
+	return CompileOptionUsed(db, migration.Requires)
+}
+
+// CompileOptionUsed reports whether SQLite was built with a compile option
+func CompileOptionUsed(db *sql.DB, option string) (bool, error) {
+	var used bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used(?)`, option).Scan(&used); err != nil {
+		return false, fmt.Errorf("failed to read SQLite compile option %s: %w", option, err)
+	}
+	return used, nil
+}
+
 // contains checks if a slice contains a value
 func contains(slice []int, value int) bool {
 	for _, item := range slice {
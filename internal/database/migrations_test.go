@@ -72,6 +72,19 @@ func TestRunMigrations(t *testing.T) {
 		"security_rules",
 		"app_settings",
 		"schema_migrations",
+		"skipped_rules",
+		"rule_vendor_overrides",
+		"audit_log",
+		"check_result_comments",
+		"credential_rotations",
+		"credential_rotation_devices",
+		"config_snapshots",
+		"check_runs",
+		"device_ssh_posture",
+		"severity_overrides",
+		"device_connectivity",
+		"device_connection_metrics",
+		"command_macros",
 	}
 
 	for _, tableName := range expectedTables {
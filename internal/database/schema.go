@@ -0,0 +1,210 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SchemaDrift describes a table or column the migrations create that is
+// missing from a database
+type SchemaDrift struct {
+	Table  string `json:"table"`
+	Column string `json:"column,omitempty"`
+}
+
+func (d SchemaDrift) String() string {
+	if d.Column == "" {
+		return fmt.Sprintf("missing table %s", d.Table)
+	}
+	return fmt.Sprintf("missing column %s.%s", d.Table, d.Column)
+}
+
+var (
+	expectedSchemaOnce sync.Once
+	expectedSchema     map[string][]string
+	expectedSchemaErr  error
+)
+
+// LatestSchemaVersion returns the version of the newest migration
+func LatestSchemaVersion() int {
+	latest := 0
+	for _, migration := range GetMigrations() {
+		if migration.Version > latest {
+			latest = migration.Version
+		}
+	}
+	return latest
+}
+
+// SchemaVersion returns the newest migration version applied to a database,
+// or zero when none has been
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// VerifySchema checks that every table and column created by the migrations
+// exists. Tables and columns the migrations do not know about are ignored.
+func VerifySchema(db *sql.DB) ([]SchemaDrift, error) {
+	expected, err := getExpectedSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	actual, err := readSchema(db)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]string, 0, len(expected))
+	for table := range expected {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var drift []SchemaDrift
+	for _, table := range tables {
+		columns, exists := actual[table]
+		if !exists {
+			drift = append(drift, SchemaDrift{Table: table})
+			continue
+		}
+
+		present := make(map[string]bool, len(columns))
+		for _, column := range columns {
+			present[column] = true
+		}
+		for _, column := range expected[table] {
+			if !present[column] {
+				drift = append(drift, SchemaDrift{Table: table, Column: column})
+			}
+		}
+	}
+
+	return drift, nil
+}
+
+// RepairSchema re-applies every migration statement by statement, skipping
+// the ones whose table or column already exists, and records each migration
+// as applied. It recovers databases where a migration failed halfway or a
+// table was dropped after its migration ran. This is only safe while
+// migrations stay schema-only, with no data changes.
+func RepairSchema(db *sql.DB) error {
+	migrations := GetMigrations()
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for _, migration := range migrations {
+		supported, err := migrationSupported(db, migration)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			migration.SQL = ""
+		}
+		for _, statement := range splitStatements(migration.SQL) {
+			if _, err := db.Exec(statement); err != nil && !isAlreadyApplied(err) {
+				return fmt.Errorf("failed to repair migration %s: %w", migration.Name, err)
+			}
+		}
+
+		if _, err := db.Exec("INSERT OR IGNORE INTO schema_migrations (version, name) VALUES (?, ?)",
+			migration.Version, migration.Name); err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// getExpectedSchema returns the tables and columns a fully migrated database
+// has, found by migrating an empty in-memory database
+func getExpectedSchema() (map[string][]string, error) {
+	expectedSchemaOnce.Do(func() {
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			expectedSchemaErr = err
+			return
+		}
+		defer db.Close()
+		db.SetMaxOpenConns(1)
+
+		if err := RunMigrations(db); err != nil {
+			expectedSchemaErr = fmt.Errorf("failed to build expected schema: %w", err)
+			return
+		}
+		expectedSchema, expectedSchemaErr = readSchema(db)
+	})
+
+	return expectedSchema, expectedSchemaErr
+}
+
+// readSchema returns the columns of every table in a database
+func readSchema(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query(`
+		SELECT m.name, p.name
+		FROM sqlite_master m, pragma_table_info(m.name) p
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%' AND m.sql NOT LIKE 'CREATE VIRTUAL TABLE%'
+			AND NOT EXISTS (
+				SELECT 1 FROM sqlite_master v
+				WHERE v.type = 'table' AND v.sql LIKE 'CREATE VIRTUAL TABLE%' AND m.name LIKE v.name || '\_%' ESCAPE '\'
+			)
+		ORDER BY m.name, p.cid
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	defer rows.Close()
+
+	schema := make(map[string][]string)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, fmt.Errorf("failed to read schema: %w", err)
+		}
+		schema[table] = append(schema[table], column)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	return schema, nil
+}
+
+// splitStatements splits migration SQL into its statements. A trigger is
+// one statement up to the END of its body.
+func splitStatements(sqlText string) []string {
+	var statements []string
+	statement := ""
+	for _, part := range strings.Split(sqlText, ";") {
+		statement += part
+		trimmed := strings.TrimSpace(statement)
+		upper := strings.ToUpper(trimmed)
+		if strings.HasPrefix(upper, "CREATE TRIGGER") && !strings.HasSuffix(upper, "END") {
+			statement += ";"
+			continue
+		}
+		if trimmed != "" {
+			statements = append(statements, trimmed)
+		}
+		statement = ""
+	}
+	return statements
+}
+
+// isAlreadyApplied reports whether a migration statement failed only
+// because its change is already in place
+func isAlreadyApplied(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "duplicate column name") || strings.Contains(message, "already exists")
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newMigratedDB returns a fully migrated database in a temporary directory
+func newMigratedDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := NewSQLiteDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := RunMigrations(db.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	return db
+}
+
+// migrationVersion returns the version of the named migration
+func migrationVersion(t *testing.T, name string) int {
+	t.Helper()
+
+	for _, migration := range GetMigrations() {
+		if migration.Name == name {
+			return migration.Version
+		}
+	}
+	t.Fatalf("Migration %s not found", name)
+	return 0
+}
+
+func TestSchemaVersion(t *testing.T) {
+	db, err := NewSQLiteDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	version, err := SchemaVersion(db.DB)
+	if err != nil || version != 0 {
+		t.Errorf("Expected version 0 before migrations, got %d (%v)", version, err)
+	}
+
+	if err := RunMigrations(db.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	version, err = SchemaVersion(db.DB)
+	if err != nil || version != LatestSchemaVersion() {
+		t.Errorf("Expected version %d, got %d (%v)", LatestSchemaVersion(), version, err)
+	}
+	if LatestSchemaVersion() != len(GetMigrations()) {
+		t.Errorf("Expected latest version %d, got %d", len(GetMigrations()), LatestSchemaVersion())
+	}
+}
+
+func TestVerifySchema(t *testing.T) {
+	db := newMigratedDB(t)
+
+	drift, err := VerifySchema(db.DB)
+	if err != nil {
+		t.Fatalf("Failed to verify schema: %v", err)
+	}
+	if len(drift) != 0 {
+		t.Errorf("Expected no drift after migrations, got %v", drift)
+	}
+
+	// Tables the migrations do not know about are not drift
+	if _, err := db.Exec("CREATE TABLE extra (id TEXT)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	if _, err := db.Exec("DROP TABLE security_rules"); err != nil {
+		t.Fatalf("Failed to drop table: %v", err)
+	}
+	if _, err := db.Exec("ALTER TABLE check_results DROP COLUMN message_params"); err != nil {
+		t.Fatalf("Failed to drop column: %v", err)
+	}
+
+	drift, err = VerifySchema(db.DB)
+	if err != nil {
+		t.Fatalf("Failed to verify schema: %v", err)
+	}
+
+	expected := []SchemaDrift{
+		{Table: "check_results", Column: "message_params"},
+		{Table: "security_rules"},
+	}
+	if !reflect.DeepEqual(drift, expected) {
+		t.Errorf("Expected drift %v, got %v", expected, drift)
+	}
+	if drift[0].String() != "missing column check_results.message_params" {
+		t.Errorf("Unexpected drift description %q", drift[0].String())
+	}
+	if drift[1].String() != "missing table security_rules" {
+		t.Errorf("Unexpected drift description %q", drift[1].String())
+	}
+}
+
+func TestRepairSchema(t *testing.T) {
+	t.Run("dropped table", func(t *testing.T) {
+		db := newMigratedDB(t)
+		if _, err := db.Exec("DROP TABLE security_rules"); err != nil {
+			t.Fatalf("Failed to drop table: %v", err)
+		}
+
+		// The migration is recorded, so running migrations again does not help
+		if err := RunMigrations(db.DB); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+		if drift, _ := VerifySchema(db.DB); len(drift) == 0 {
+			t.Fatal("Expected drift before repair")
+		}
+
+		if err := RepairSchema(db.DB); err != nil {
+			t.Fatalf("Failed to repair schema: %v", err)
+		}
+		if drift, err := VerifySchema(db.DB); err != nil || len(drift) != 0 {
+			t.Errorf("Expected no drift after repair, got %v (%v)", drift, err)
+		}
+	})
+
+	t.Run("half-applied migration", func(t *testing.T) {
+		db := newMigratedDB(t)
+
+		// The message columns migration's first column exists but the migration
+		// was never recorded, so running it again fails
+		latest := LatestSchemaVersion()
+		if _, err := db.Exec("DELETE FROM schema_migrations WHERE version >= ?",
+			migrationVersion(t, "add_check_results_message_columns")); err != nil {
+			t.Fatalf("Failed to unrecord migration: %v", err)
+		}
+		if _, err := db.Exec("ALTER TABLE check_results DROP COLUMN message_params"); err != nil {
+			t.Fatalf("Failed to drop column: %v", err)
+		}
+
+		if err := RunMigrations(db.DB); err == nil {
+			t.Fatal("Expected the half-applied migration to fail")
+		}
+
+		if err := RepairSchema(db.DB); err != nil {
+			t.Fatalf("Failed to repair schema: %v", err)
+		}
+		if drift, err := VerifySchema(db.DB); err != nil || len(drift) != 0 {
+			t.Errorf("Expected no drift after repair, got %v (%v)", drift, err)
+		}
+		if version, _ := SchemaVersion(db.DB); version != latest {
+			t.Errorf("Expected version %d after repair, got %d", latest, version)
+		}
+		if err := RunMigrations(db.DB); err != nil {
+			t.Errorf("Expected migrations to succeed after repair: %v", err)
+		}
+	})
+}
+
+func TestSplitStatementsKeepsTriggerBodies(t *testing.T) {
+	statements := splitStatements(SearchIndexSQL)
+	if len(statements) != 5 {
+		t.Fatalf("Expected 5 statements, got %d: %q", len(statements), statements)
+	}
+	for _, statement := range statements[1:4] {
+		if !strings.HasPrefix(statement, "CREATE TRIGGER") || !strings.HasSuffix(statement, "END") {
+			t.Errorf("Expected a whole trigger, got %q", statement)
+		}
+	}
+}
+
+func TestSearchIndexMigration(t *testing.T) {
+	db := newMigratedDB(t)
+
+	version := migrationVersion(t, "create_devices_search_index")
+	applied, err := getAppliedMigrations(db.DB)
+	if err != nil {
+		t.Fatalf("Failed to read applied migrations: %v", err)
+	}
+	if !contains(applied, version) {
+		t.Errorf("Expected migration %d to be recorded with or without FTS5", version)
+	}
+
+	available, err := CompileOptionUsed(db.DB, SearchIndexOption)
+	if err != nil {
+		t.Fatalf("Failed to read compile option: %v", err)
+	}
+	var triggers int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'devices_fts_%'`).Scan(&triggers); err != nil {
+		t.Fatalf("Failed to count triggers: %v", err)
+	}
+	if want := map[bool]int{true: 3, false: 0}[available]; triggers != want {
+		t.Errorf("Expected %d search index triggers, got %d", want, triggers)
+	}
+
+	// The index's shadow tables are not schema drift
+	if drift, err := VerifySchema(db.DB); err != nil || len(drift) != 0 {
+		t.Errorf("Expected no drift, got %v (%v)", drift, err)
+	}
+	if err := RepairSchema(db.DB); err != nil {
+		t.Errorf("Failed to repair schema: %v", err)
+	}
+}
@@ -3,38 +3,136 @@ package database
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 // DB wraps the sql.DB with additional functionality
 type DB struct {
 	*sql.DB
 	dataDir string
+	config  *ConnectionConfig
+
+	// leaks is the leak detector watching the pool's connections, if any.
+	// It outlives the pool, which is reopened by a restore.
+	leaks atomic.Pointer[LeakDetector]
 }
 
+// databaseFileName is the SQLite file kept in the data directory
+const databaseFileName = "network_checker.db"
+
+// Default SQLite locking settings
+const (
+	DefaultBusyTimeout       = 5 * time.Second
+	DefaultWALAutoCheckpoint = 1000 // pages, the SQLite default
+)
+
 // ConnectionConfig holds database connection configuration
 type ConnectionConfig struct {
 	MaxOpenConns    int
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
 	ConnMaxIdleTime time.Duration
+
+	// BusyTimeout is how long a connection waits for a lock held by another
+	// writer before failing. Zero uses DefaultBusyTimeout.
+	BusyTimeout time.Duration
+
+	// WALAutoCheckpoint is the WAL size in pages that triggers an automatic
+	// checkpoint. Zero uses DefaultWALAutoCheckpoint and a negative value
+	// disables automatic checkpoints.
+	WALAutoCheckpoint int
 }
 
 // DefaultConnectionConfig returns default connection configuration
 func DefaultConnectionConfig() *ConnectionConfig {
 	return &ConnectionConfig{
-		MaxOpenConns:    25,
-		MaxIdleConns:    5,
-		ConnMaxLifetime: 5 * time.Minute,
-		ConnMaxIdleTime: 1 * time.Minute,
+		MaxOpenConns:      25,
+		MaxIdleConns:      5,
+		ConnMaxLifetime:   5 * time.Minute,
+		ConnMaxIdleTime:   1 * time.Minute,
+		BusyTimeout:       DefaultBusyTimeout,
+		WALAutoCheckpoint: DefaultWALAutoCheckpoint,
 	}
 }
 
+// connectionPragmas returns the pragmas applied to every new connection.
+// Pragmas are per connection, so they cannot be set once on the pool.
+func (c *ConnectionConfig) connectionPragmas() []string {
+	busyTimeout := c.BusyTimeout
+	if busyTimeout <= 0 {
+		busyTimeout = DefaultBusyTimeout
+	}
+
+	walAutoCheckpoint := c.WALAutoCheckpoint
+	if walAutoCheckpoint == 0 {
+		walAutoCheckpoint = DefaultWALAutoCheckpoint
+	} else if walAutoCheckpoint < 0 {
+		walAutoCheckpoint = 0
+	}
+
+	return []string{
+		fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeout.Milliseconds()),
+		fmt.Sprintf("PRAGMA wal_autocheckpoint = %d", walAutoCheckpoint),
+		"PRAGMA temp_store = MEMORY",   // Store temporary tables in memory
+		"PRAGMA mmap_size = 268435456", // 256MB memory-mapped I/O
+	}
+}
+
+// sqliteConnector opens SQLite connections and applies the configured
+// pragmas to each one
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+	leaks  *atomic.Pointer[LeakDetector]
+}
+
+func newSQLiteConnector(dsn string, pragmas []string, leaks *atomic.Pointer[LeakDetector]) *sqliteConnector {
+	return &sqliteConnector{
+		dsn:   dsn,
+		leaks: leaks,
+		driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				for _, pragma := range pragmas {
+					if _, err := conn.Exec(pragma, nil); err != nil {
+						return fmt.Errorf("failed to set pragma %s: %w", pragma, err)
+					}
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (c *sqliteConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if sqliteConn, ok := conn.(*sqlite3.SQLiteConn); ok && c.leaks != nil {
+		return &leakTrackingConn{SQLiteConn: sqliteConn, connector: c}, nil
+	}
+	return conn, nil
+}
+
+// leakDetector returns the enabled leak detector, or nil
+func (c *sqliteConnector) leakDetector() *LeakDetector {
+	if c.leaks == nil {
+		return nil
+	}
+	return c.leaks.Load()
+}
+
+func (c *sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
 // NewSQLiteDB creates a new SQLite database connection with proper configuration
 func NewSQLiteDB(dataDir string) (*DB, error) {
 	return NewSQLiteDBWithConfig(dataDir, DefaultConnectionConfig())
@@ -47,15 +145,26 @@ func NewSQLiteDBWithConfig(dataDir string, config *ConnectionConfig) (*DB, error
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	dbPath := filepath.Join(dataDir, "network_checker.db")
+	db := &DB{
+		dataDir: dataDir,
+		config:  config,
+	}
+	pool, err := openPool(filepath.Join(dataDir, databaseFileName), config, &db.leaks)
+	if err != nil {
+		return nil, err
+	}
+	db.DB = pool
+
+	return db, nil
+}
 
+// openPool opens and pings a connection pool on the database file at dbPath.
+// Its connections report their statements to the detector held by leaks.
+func openPool(dbPath string, config *ConnectionConfig, leaks *atomic.Pointer[LeakDetector]) (*sql.DB, error) {
 	// SQLite connection string with optimizations
 	connectionString := fmt.Sprintf("%s?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=1000&_foreign_keys=ON", dbPath)
 
-	db, err := sql.Open("sqlite3", connectionString)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
+	db := sql.OpenDB(newSQLiteConnector(connectionString, config.connectionPragmas(), leaks))
 
 	// Configure connection pool
 	db.SetMaxOpenConns(config.MaxOpenConns)
@@ -69,24 +178,7 @@ func NewSQLiteDBWithConfig(dataDir string, config *ConnectionConfig) (*DB, error
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Set additional SQLite pragmas for performance and reliability
-	pragmas := []string{
-		"PRAGMA busy_timeout = 30000",  // 30 second timeout for busy database
-		"PRAGMA temp_store = MEMORY",   // Store temporary tables in memory
-		"PRAGMA mmap_size = 268435456", // 256MB memory-mapped I/O
-	}
-
-	for _, pragma := range pragmas {
-		if _, err := db.Exec(pragma); err != nil {
-			db.Close()
-			return nil, fmt.Errorf("failed to set pragma %s: %w", pragma, err)
-		}
-	}
-
-	return &DB{
-		DB:      db,
-		dataDir: dataDir,
-	}, nil
+	return db, nil
 }
 
 // Close closes the database connection
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -288,6 +289,82 @@ func TestDatabasePragmas(t *testing.T) {
 	}
 }
 
+func TestConnectionPragmasApplyToEveryConnection(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_busy_timeout_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	config := DefaultConnectionConfig()
+	config.BusyTimeout = 2500 * time.Millisecond
+	config.WALAutoCheckpoint = 500
+
+	db, err := NewSQLiteDBWithConfig(tempDir, config)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Hold several connections open at once so each one is a separate
+	// connection from the pool
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		defer conn.Close()
+
+		var busyTimeout int
+		if err := conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+			t.Fatalf("Failed to query busy_timeout pragma: %v", err)
+		}
+		if busyTimeout != 2500 {
+			t.Errorf("Expected busy_timeout 2500 on connection %d, got %d", i, busyTimeout)
+		}
+
+		var walAutoCheckpoint int
+		if err := conn.QueryRowContext(ctx, "PRAGMA wal_autocheckpoint").Scan(&walAutoCheckpoint); err != nil {
+			t.Fatalf("Failed to query wal_autocheckpoint pragma: %v", err)
+		}
+		if walAutoCheckpoint != 500 {
+			t.Errorf("Expected wal_autocheckpoint 500 on connection %d, got %d", i, walAutoCheckpoint)
+		}
+	}
+}
+
+func TestConnectionPragmaDefaults(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "test_pragma_defaults_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Zero values fall back to the defaults
+	db, err := NewSQLiteDBWithConfig(tempDir, &ConnectionConfig{MaxOpenConns: 1, MaxIdleConns: 1})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	var busyTimeout int
+	if err := db.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		t.Fatalf("Failed to query busy_timeout pragma: %v", err)
+	}
+	if busyTimeout != int(DefaultBusyTimeout.Milliseconds()) {
+		t.Errorf("Expected default busy_timeout %d, got %d", DefaultBusyTimeout.Milliseconds(), busyTimeout)
+	}
+
+	var walAutoCheckpoint int
+	if err := db.QueryRow("PRAGMA wal_autocheckpoint").Scan(&walAutoCheckpoint); err != nil {
+		t.Fatalf("Failed to query wal_autocheckpoint pragma: %v", err)
+	}
+	if walAutoCheckpoint != DefaultWALAutoCheckpoint {
+		t.Errorf("Expected default wal_autocheckpoint %d, got %d", DefaultWALAutoCheckpoint, walAutoCheckpoint)
+	}
+}
+
 func TestConcurrentConnections(t *testing.T) {
 	tempDir, err := os.MkdirTemp("", "test_concurrent_*")
 	if err != nil {
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ways TableUsage measures the size of tables
+const (
+	// UsageMethodDBStat sums the pages the dbstat virtual table reports
+	UsageMethodDBStat = "dbstat"
+	// UsageMethodPayload sums the stored bytes of every column, for SQLite
+	// builds without dbstat; it leaves out page and index overhead
+	UsageMethodPayload = "payload"
+)
+
+// DiskUsage is the space the data directory takes, split by what uses it
+type DiskUsage struct {
+	DatabaseBytes int64 `json:"databaseBytes"`
+	// WALBytes covers the write-ahead log and its shared-memory file
+	WALBytes    int64 `json:"walBytes"`
+	BackupBytes int64 `json:"backupBytes"`
+	// OtherBytes is everything else, such as evidence files
+	OtherBytes int64 `json:"otherBytes"`
+	TotalBytes int64 `json:"totalBytes"`
+}
+
+// TableUsage is the space one table and its indexes take in the database
+type TableUsage struct {
+	Name  string `json:"name"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes"`
+}
+
+// TableUsageReport breaks the database file down by table
+type TableUsageReport struct {
+	Method    string       `json:"method"`
+	PageSize  int64        `json:"pageSize"`
+	PageCount int64        `json:"pageCount"`
+	FreePages int64        `json:"freePages"`
+	Tables    []TableUsage `json:"tables"`
+}
+
+// DiskUsage measures the files in the data directory
+func (db *DB) DiskUsage() (*DiskUsage, error) {
+	usage := &DiskUsage{}
+	databasePath := filepath.Join(db.dataDir, databaseFileName)
+	backupDir := filepath.Join(db.dataDir, BackupDirName)
+
+	err := filepath.WalkDir(db.dataDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Files removed while walking are no longer in use
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		size := info.Size()
+		switch {
+		case path == databasePath:
+			usage.DatabaseBytes += size
+		case path == databasePath+"-wal" || path == databasePath+"-shm":
+			usage.WALBytes += size
+		case strings.HasPrefix(path, backupDir+string(filepath.Separator)):
+			usage.BackupBytes += size
+		default:
+			usage.OtherBytes += size
+		}
+		usage.TotalBytes += size
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure data directory: %w", err)
+	}
+	return usage, nil
+}
+
+// CompactedUsage estimates the usage Compact would leave without running
+// it: the database file shrinks to its pages in use and the write-ahead
+// log is emptied, while the other files stay as they are
+func (db *DB) CompactedUsage(ctx context.Context) (*DiskUsage, error) {
+	usage, err := db.DiskUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	var pageSize, pageCount, freePages int64
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return nil, fmt.Errorf("failed to read page size: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return nil, fmt.Errorf("failed to read page count: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return nil, fmt.Errorf("failed to read free pages: %w", err)
+	}
+
+	usage.DatabaseBytes = (pageCount - freePages) * pageSize
+	usage.WALBytes = 0
+	usage.TotalBytes = usage.DatabaseBytes + usage.BackupBytes + usage.OtherBytes
+	return usage, nil
+}
+
+// TableUsage reports the rows and bytes of every table, largest first.
+// Bytes come from dbstat when SQLite was built with it, otherwise from the
+// column payload of each table.
+func (db *DB) TableUsage(ctx context.Context) (*TableUsageReport, error) {
+	report := &TableUsageReport{}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&report.PageSize); err != nil {
+		return nil, fmt.Errorf("failed to read page size: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&report.PageCount); err != nil {
+		return nil, fmt.Errorf("failed to read page count: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&report.FreePages); err != nil {
+		return nil, fmt.Errorf("failed to read free pages: %w", err)
+	}
+
+	tables, err := queryStrings(ctx, db.DB,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	sizes, err := db.dbstatSizes(ctx)
+	report.Method = UsageMethodDBStat
+	if err != nil {
+		report.Method = UsageMethodPayload
+		sizes = nil
+	}
+
+	for _, table := range tables {
+		usage := TableUsage{Name: table}
+		if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", quoteIdentifier(table))).
+			Scan(&usage.Rows); err != nil {
+			return nil, fmt.Errorf("failed to count rows of %s: %w", table, err)
+		}
+		if sizes != nil {
+			usage.Bytes = sizes[table]
+		} else if usage.Bytes, err = db.payloadSize(ctx, table); err != nil {
+			return nil, err
+		}
+		report.Tables = append(report.Tables, usage)
+	}
+
+	sort.SliceStable(report.Tables, func(i, j int) bool {
+		return report.Tables[i].Bytes > report.Tables[j].Bytes
+	})
+	return report, nil
+}
+
+// dbstatSizes sums the pages of each table and its indexes, failing when
+// SQLite was built without the dbstat virtual table
+func (db *DB) dbstatSizes(ctx context.Context) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.tbl_name, SUM(s.pgsize)
+		FROM dbstat s JOIN sqlite_master m ON m.name = s.name
+		GROUP BY m.tbl_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var table string
+		var bytes int64
+		if err := rows.Scan(&table, &bytes); err != nil {
+			return nil, err
+		}
+		sizes[table] = bytes
+	}
+	return sizes, rows.Err()
+}
+
+// payloadSize sums the bytes stored in every column of a table
+func (db *DB) payloadSize(ctx context.Context, table string) (int64, error) {
+	columns, err := queryStrings(ctx, db.DB, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	if len(columns) == 0 {
+		return 0, nil
+	}
+
+	lengths := make([]string, len(columns))
+	for i, column := range columns {
+		lengths[i] = fmt.Sprintf("COALESCE(LENGTH(CAST(%s AS BLOB)), 0)", quoteIdentifier(column))
+	}
+	var bytes int64
+	query := fmt.Sprintf("SELECT COALESCE(SUM(%s), 0) FROM %s", strings.Join(lengths, " + "), quoteIdentifier(table))
+	if err := db.QueryRowContext(ctx, query).Scan(&bytes); err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", table, err)
+	}
+	return bytes, nil
+}
+
+// Compact returns the space of deleted rows to the file system: it
+// rebuilds the database file and then empties the write-ahead log
+func (db *DB) Compact(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("failed to checkpoint write-ahead log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	db := newBackupTestDB(t, "value")
+
+	if err := os.MkdirAll(filepath.Join(db.GetDataDir(), BackupDirName), 0755); err != nil {
+		t.Fatalf("Failed to create backup directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(db.GetDataDir(), BackupDirName, "old.db"), make([]byte, 3000), 0600); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(db.GetDataDir(), "evidence"), 0700); err != nil {
+		t.Fatalf("Failed to create evidence directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(db.GetDataDir(), "evidence", "r1.txt"), make([]byte, 500), 0600); err != nil {
+		t.Fatalf("Failed to write evidence: %v", err)
+	}
+
+	usage, err := db.DiskUsage()
+	if err != nil {
+		t.Fatalf("Failed to measure data directory: %v", err)
+	}
+	if usage.DatabaseBytes == 0 {
+		t.Error("Expected the database file to be measured")
+	}
+	if usage.BackupBytes != 3000 {
+		t.Errorf("Expected 3000 backup bytes, got %d", usage.BackupBytes)
+	}
+	if usage.OtherBytes != 500 {
+		t.Errorf("Expected the evidence file as other bytes, got %d", usage.OtherBytes)
+	}
+	if sum := usage.DatabaseBytes + usage.WALBytes + usage.BackupBytes + usage.OtherBytes; sum != usage.TotalBytes {
+		t.Errorf("Expected the parts to add up to %d, got %d", usage.TotalBytes, sum)
+	}
+}
+
+func TestTableUsage(t *testing.T) {
+	db := newBackupTestDB(t, strings.Repeat("x", 200*1024))
+	ctx := context.Background()
+
+	report, err := db.TableUsage(ctx)
+	if err != nil {
+		t.Fatalf("Failed to measure tables: %v", err)
+	}
+	if report.Method != UsageMethodDBStat && report.Method != UsageMethodPayload {
+		t.Errorf("Unexpected method %q", report.Method)
+	}
+	if report.PageSize == 0 || report.PageCount == 0 {
+		t.Errorf("Expected page counts, got %+v", report)
+	}
+	if len(report.Tables) == 0 {
+		t.Fatal("Expected tables to be reported")
+	}
+
+	largest := report.Tables[0]
+	if largest.Name != "app_settings" {
+		t.Errorf("Expected app_settings to be the largest table, got %s", largest.Name)
+	}
+	if largest.Bytes < 200*1024 {
+		t.Errorf("Expected app_settings to hold at least the setting, got %d bytes", largest.Bytes)
+	}
+	if largest.Bytes > report.PageSize*report.PageCount {
+		t.Errorf("Expected app_settings to fit in the file, got %d bytes", largest.Bytes)
+	}
+
+	var rows int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM app_settings").Scan(&rows); err != nil {
+		t.Fatalf("Failed to count settings: %v", err)
+	}
+	if largest.Rows != rows {
+		t.Errorf("Expected %d rows, got %d", rows, largest.Rows)
+	}
+	for _, table := range report.Tables {
+		if strings.HasPrefix(table.Name, "sqlite_") {
+			t.Errorf("Expected internal table %s to be left out", table.Name)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	db := newBackupTestDB(t, strings.Repeat("x", 1024*1024))
+	ctx := context.Background()
+
+	if err := db.Compact(ctx); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	before, err := db.DiskUsage()
+	if err != nil {
+		t.Fatalf("Failed to measure data directory: %v", err)
+	}
+
+	if _, err := db.Exec("DELETE FROM app_settings WHERE key = 'test_key'"); err != nil {
+		t.Fatalf("Failed to delete setting: %v", err)
+	}
+	if err := db.Compact(ctx); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	after, err := db.DiskUsage()
+	if err != nil {
+		t.Fatalf("Failed to measure data directory: %v", err)
+	}
+
+	if before.DatabaseBytes-after.DatabaseBytes < 1024*1024 {
+		t.Errorf("Expected compaction to free the deleted setting, went from %d to %d bytes",
+			before.DatabaseBytes, after.DatabaseBytes)
+	}
+	if after.WALBytes > 64*1024 {
+		t.Errorf("Expected the write-ahead log to be emptied, got %d bytes", after.WALBytes)
+	}
+}
+
+func TestCompactedUsage(t *testing.T) {
+	db := newBackupTestDB(t, strings.Repeat("x", 1024*1024))
+	ctx := context.Background()
+
+	if _, err := db.Exec("DELETE FROM app_settings WHERE key = 'test_key'"); err != nil {
+		t.Fatalf("Failed to delete setting: %v", err)
+	}
+	estimate, err := db.CompactedUsage(ctx)
+	if err != nil {
+		t.Fatalf("Failed to estimate compacted usage: %v", err)
+	}
+	if err := db.Compact(ctx); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+	after, err := db.DiskUsage()
+	if err != nil {
+		t.Fatalf("Failed to measure data directory: %v", err)
+	}
+
+	if estimate.WALBytes != 0 {
+		t.Errorf("Expected no write-ahead log in the estimate, got %d bytes", estimate.WALBytes)
+	}
+	if diff := estimate.TotalBytes - after.TotalBytes; diff < -64*1024 || diff > 64*1024 {
+		t.Errorf("Expected the estimate %d to be close to the compacted usage %d",
+			estimate.TotalBytes, after.TotalBytes)
+	}
+}
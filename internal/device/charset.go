@@ -0,0 +1,78 @@
+package device
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// CharsetUTF8 is the default output charset; output is passed through
+const CharsetUTF8 = "utf-8"
+
+// outputCharsets are the charsets device output can be transcoded from,
+// keyed by lowercase name
+var outputCharsets = map[string]encoding.Encoding{
+	"gbk":          simplifiedchinese.GBK,
+	"gb18030":      simplifiedchinese.GB18030,
+	"big5":         traditionalchinese.Big5,
+	"shift_jis":    japanese.ShiftJIS,
+	"euc-jp":       japanese.EUCJP,
+	"euc-kr":       korean.EUCKR,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"windows-1251": charmap.Windows1251,
+	"windows-1252": charmap.Windows1252,
+}
+
+// SupportedCharsets returns the output charsets a device can be set to,
+// UTF-8 first
+func SupportedCharsets() []string {
+	charsets := make([]string, 0, len(outputCharsets))
+	for name := range outputCharsets {
+		charsets = append(charsets, name)
+	}
+	sort.Strings(charsets)
+	return append([]string{CharsetUTF8}, charsets...)
+}
+
+// ValidateOutputCharset validates a device's output charset; empty means UTF-8
+func ValidateOutputCharset(charset string) error {
+	name := normalizeCharset(charset)
+	if name == CharsetUTF8 {
+		return nil
+	}
+	if _, ok := outputCharsets[name]; !ok {
+		return ValidationError{Field: "outputCharset", Message: "unsupported output charset: " + charset}
+	}
+	return nil
+}
+
+// DecodeOutput transcodes device output from charset to UTF-8. UTF-8 output
+// is passed through. Bytes that are invalid in the charset become U+FFFD,
+// so the result is always valid UTF-8 and safe to match and marshal.
+func DecodeOutput(output, charset string) string {
+	if enc, ok := outputCharsets[normalizeCharset(charset)]; ok {
+		if decoded, err := enc.NewDecoder().String(output); err == nil {
+			output = decoded
+		}
+	}
+	if utf8.ValidString(output) {
+		return output
+	}
+	return strings.ToValidUTF8(output, string(utf8.RuneError))
+}
+
+// normalizeCharset lowercases a charset name, mapping empty to UTF-8
+func normalizeCharset(charset string) string {
+	name := strings.ToLower(strings.TrimSpace(charset))
+	if name == "" || name == "utf8" {
+		return CharsetUTF8
+	}
+	return name
+}
@@ -0,0 +1,86 @@
+package device
+
+import (
+	"encoding/json"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDecodeOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		charset string
+		want    string
+	}{
+		{"utf-8 passes through", "hostname 路由器", "", "hostname 路由器"},
+		{"gbk", "\xc2\xb7\xd3\xc9\xc6\xf7", "GBK", "路由器"},
+		{"big5", "\xb8\xf4\xa5\xd1\xbe\xb9", "big5", "路由器"},
+		{"latin-1", "caf\xe9", "iso-8859-1", "café"},
+		{"invalid utf-8 is replaced", "ok \xff\xfe", "utf-8", "ok �"},
+		{"invalid gbk is replaced", "ok \x81", "gbk", "ok �"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DecodeOutput(tt.output, tt.charset)
+			if got != tt.want {
+				t.Errorf("DecodeOutput(%q, %q) = %q, want %q", tt.output, tt.charset, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("DecodeOutput(%q, %q) returned invalid UTF-8", tt.output, tt.charset)
+			}
+		})
+	}
+}
+
+func TestValidateOutputCharset(t *testing.T) {
+	for _, charset := range append(SupportedCharsets(), "", "UTF8", " GBK ") {
+		if err := ValidateOutputCharset(charset); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", charset, err)
+		}
+	}
+	if err := ValidateOutputCharset("ebcdic"); err == nil {
+		t.Error("Expected an unsupported charset to be rejected")
+	}
+	if charsets := SupportedCharsets(); charsets[0] != CharsetUTF8 {
+		t.Errorf("Expected UTF-8 first, got %v", charsets)
+	}
+}
+
+// TestManager_OutputCharsetSurvivesEdit saves a device the way the device
+// form does, from the DTO it was shown
+func TestManager_OutputCharsetSurvivesEdit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	device := createTestDevice()
+	device.OutputCharset = "gbk"
+	if err := manager.AddDevice(device); err != nil {
+		t.Fatalf("Failed to add device: %v", err)
+	}
+
+	data, err := json.Marshal(device.ToDTO())
+	if err != nil {
+		t.Fatalf("Failed to encode DTO: %v", err)
+	}
+	var edited Device
+	if err := json.Unmarshal(data, &edited); err != nil {
+		t.Fatalf("Failed to decode DTO: %v", err)
+	}
+	// Credentials are not part of the DTO
+	edited.PasswordEncrypted = device.PasswordEncrypted
+	edited.Name = "Renamed Router"
+	if err := manager.UpdateDevice(&edited); err != nil {
+		t.Fatalf("Failed to update device: %v", err)
+	}
+
+	stored, err := manager.GetDevice(device.ID)
+	if err != nil {
+		t.Fatalf("Failed to get device: %v", err)
+	}
+	if stored.OutputCharset != "gbk" {
+		t.Errorf("Expected the output charset to survive the edit, got %q", stored.OutputCharset)
+	}
+}
@@ -0,0 +1,487 @@
+package device
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device export formats. JSON exports are a single array of objects.
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatJSON = "json"
+)
+
+// Files kept next to a device export: the checkpoint of an unfinished
+// export, and the footer describing a finished one
+const (
+	ExportCheckpointSuffix = ".checkpoint"
+	ExportFooterSuffix     = ".footer.json"
+)
+
+// deviceExportHeader is the header row of CSV device exports. Credentials
+// and SNMP communities are never exported.
+var deviceExportHeader = []string{
+	"id", "name", "ip_address", "device_type", "vendor", "username", "ssh_port",
+	"tags", "status", "last_checked", "model", "os_version", "is_sandbox",
+	"created_at", "updated_at",
+}
+
+// exportedDevice is one object of a JSON device export, with the fields of
+// a CSV row
+type exportedDevice struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	IPAddress   string `json:"ipAddress"`
+	DeviceType  string `json:"deviceType"`
+	Vendor      string `json:"vendor"`
+	Username    string `json:"username"`
+	SSHPort     int    `json:"sshPort"`
+	Tags        string `json:"tags"`
+	Status      string `json:"status"`
+	LastChecked string `json:"lastChecked,omitempty"`
+	Model       string `json:"model"`
+	OSVersion   string `json:"osVersion"`
+	IsSandbox   bool   `json:"isSandbox"`
+	CreatedAt   string `json:"createdAt"`
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+// DeviceExportOptions selects the format and devices of an export.
+// Progress, when set, is called after each batch is written. Resume
+// continues an interrupted export at the same path instead of starting
+// over.
+type DeviceExportOptions struct {
+	Format   string
+	Filter   DeviceSearchRequest
+	Progress func(ExportProgress)
+	Resume   bool
+}
+
+// ExportProgress is how far an export has got. Total is the number of
+// matching devices when the export started, so it is an estimate.
+type ExportProgress struct {
+	Rows  int `json:"rows"`
+	Total int `json:"total"`
+}
+
+// DeviceExportFooter describes a finished export so it can be verified. It
+// is written next to the export, at its path plus ExportFooterSuffix.
+type DeviceExportFooter struct {
+	Format      string    `json:"format"`
+	Rows        int       `json:"rows"`
+	Bytes       int64     `json:"bytes"`
+	SHA256      string    `json:"sha256"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// exportCheckpoint records how much of an export has been written. Only
+// whole batches are recorded, so the export file is cut back to Bytes
+// before it is continued. SHA256 is the hash of those bytes.
+type exportCheckpoint struct {
+	Format string              `json:"format"`
+	Filter DeviceSearchRequest `json:"filter"`
+	LastID string              `json:"lastId"`
+	Rows   int                 `json:"rows"`
+	Bytes  int64               `json:"bytes"`
+	SHA256 string              `json:"sha256"`
+}
+
+// ExportDevices writes the devices matching opts.Filter to path in
+// opts.Format, one batch at a time, so the fleet is never held in memory.
+// The file is flushed after every batch and a checkpoint is kept next to
+// it. With opts.Resume, an interrupted export of the same format and filter
+// is continued from the checkpoint, once the part already written is found
+// unchanged, and the finished file is the same as an uninterrupted export
+// of the same devices; an export that cannot be continued is an error.
+// Otherwise the export starts over. On success the checkpoint is replaced
+// by the footer, which is also returned. Files are readable by the owner
+// only.
+func (m *Manager) ExportDevices(ctx context.Context, path string, opts DeviceExportOptions) (*DeviceExportFooter, error) {
+	format := strings.ToLower(strings.TrimSpace(opts.Format))
+	if format != ExportFormatCSV && format != ExportFormatJSON {
+		return nil, &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "format",
+			Message: fmt.Sprintf("unsupported export format %q; use csv or json", opts.Format),
+		}
+	}
+	if err := os.Remove(path + ExportFooterSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove old export footer: %w", err)
+	}
+
+	total, err := m.CountDevicesContext(ctx, opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	export, err := openDeviceExport(path, format, opts.Filter, opts.Resume)
+	if err != nil {
+		return nil, err
+	}
+	defer export.file.Close()
+
+	err = m.StreamDevicesContext(ctx, opts.Filter, export.checkpoint.LastID, func(batch []Device) error {
+		for i := range batch {
+			if err := export.writeDevice(&batch[i]); err != nil {
+				return err
+			}
+		}
+		if err := export.commit(batch[len(batch)-1].ID); err != nil {
+			return err
+		}
+		if opts.Progress != nil {
+			opts.Progress(ExportProgress{Rows: export.checkpoint.Rows, Total: total})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return export.finish()
+}
+
+// VerifyDeviceExport checks a finished export against its footer: its
+// size, its SHA-256 and the number of devices it holds
+func VerifyDeviceExport(path string) (*DeviceExportFooter, error) {
+	data, err := os.ReadFile(path + ExportFooterSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export footer: %w", err)
+	}
+	var footer DeviceExportFooter
+	if err := json.Unmarshal(data, &footer); err != nil {
+		return nil, fmt.Errorf("invalid export footer: %w", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export: %w", err)
+	}
+	if size != footer.Bytes {
+		return nil, exportMismatch("export is %d bytes but its footer records %d", size, footer.Bytes)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != footer.SHA256 {
+		return nil, exportMismatch("export SHA-256 %s does not match its footer", sum)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read export: %w", err)
+	}
+	rows, err := countExportRows(bufio.NewReader(file), footer.Format)
+	if err != nil {
+		return nil, exportMismatch("export cannot be read as %s: %v", footer.Format, err)
+	}
+	if rows != footer.Rows {
+		return nil, exportMismatch("export holds %d devices but its footer records %d", rows, footer.Rows)
+	}
+	return &footer, nil
+}
+
+// deviceExport is an export file being written
+type deviceExport struct {
+	path       string
+	file       *os.File
+	hash       hash.Hash
+	out        *bufio.Writer
+	csv        *csv.Writer
+	written    int64
+	checkpoint exportCheckpoint
+}
+
+// Write passes export bytes to the file and the running hash
+func (e *deviceExport) Write(p []byte) (int, error) {
+	n, err := e.file.Write(p)
+	e.hash.Write(p[:n])
+	e.written += int64(n)
+	return n, err
+}
+
+// openDeviceExport continues the export at path described by its
+// checkpoint when resume is set, or starts a new one
+func openDeviceExport(path, format string, filter DeviceSearchRequest, resume bool) (*deviceExport, error) {
+	export := &deviceExport{path: path, hash: sha256.New()}
+
+	if resume {
+		checkpoint, ok := readExportCheckpoint(path)
+		if !ok {
+			return nil, fmt.Errorf("no interrupted export to resume at %s", path)
+		}
+		if checkpoint.Format != format || checkpoint.Filter != filter {
+			return nil, fmt.Errorf("interrupted export at %s has another format or filter", path)
+		}
+		if err := export.resume(checkpoint); err != nil {
+			if export.file != nil {
+				export.file.Close()
+			}
+			return nil, fmt.Errorf("failed to resume export: %w", err)
+		}
+		return export, nil
+	}
+
+	if err := export.start(format, filter); err != nil {
+		if export.file != nil {
+			export.file.Close()
+		}
+		return nil, err
+	}
+	return export, nil
+}
+
+// start creates the export file and writes what comes before the devices
+func (e *deviceExport) start(format string, filter DeviceSearchRequest) error {
+	file, err := os.OpenFile(e.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create export: %w", err)
+	}
+	e.file = file
+	// An existing file keeps its mode when truncated
+	if err := file.Chmod(0600); err != nil {
+		return fmt.Errorf("failed to create export: %w", err)
+	}
+	e.written = 0
+	e.checkpoint = exportCheckpoint{Format: format, Filter: filter}
+	e.startWriters()
+
+	if format == ExportFormatCSV {
+		err = e.csv.Write(deviceExportHeader)
+	} else {
+		_, err = e.out.WriteString("[")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return e.commit("")
+}
+
+// resume reopens the export file at the end of the last batch its
+// checkpoint records, rehashing what was written before it. A file shorter
+// than the checkpoint, or whose checkpointed part has changed, cannot be
+// continued and is left as it is.
+func (e *deviceExport) resume(checkpoint exportCheckpoint) error {
+	file, err := os.OpenFile(e.path, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	e.file = file
+
+	if info, err := file.Stat(); err != nil {
+		return err
+	} else if info.Size() < checkpoint.Bytes {
+		return fmt.Errorf("export is shorter than its checkpoint")
+	}
+	if _, err := io.Copy(e.hash, io.LimitReader(file, checkpoint.Bytes)); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(e.hash.Sum(nil)); sum != checkpoint.SHA256 {
+		return fmt.Errorf("export changed since its checkpoint")
+	}
+	// Rows written after the last checkpoint are written again
+	if err := file.Truncate(checkpoint.Bytes); err != nil {
+		return err
+	}
+	if _, err := file.Seek(checkpoint.Bytes, io.SeekStart); err != nil {
+		return err
+	}
+
+	e.written = checkpoint.Bytes
+	e.checkpoint = checkpoint
+	e.startWriters()
+	return nil
+}
+
+// startWriters buffers writes to the export
+func (e *deviceExport) startWriters() {
+	e.out = bufio.NewWriter(e)
+	e.csv = csv.NewWriter(e.out)
+}
+
+// writeDevice writes one device to the export buffer
+func (e *deviceExport) writeDevice(d *Device) error {
+	var lastChecked string
+	if d.LastChecked != nil {
+		lastChecked = exportTime(*d.LastChecked)
+	}
+
+	var err error
+	if e.checkpoint.Format == ExportFormatCSV {
+		err = e.csv.Write([]string{
+			d.ID, d.Name, d.IPAddress, d.DeviceType, d.Vendor, d.Username, strconv.Itoa(d.SSHPort),
+			d.Tags, d.Status, lastChecked, d.Model, d.OSVersion, strconv.FormatBool(d.IsSandbox),
+			exportTime(d.CreatedAt), exportTime(d.UpdatedAt),
+		})
+	} else {
+		var data []byte
+		data, err = json.Marshal(exportedDevice{
+			ID: d.ID, Name: d.Name, IPAddress: d.IPAddress, DeviceType: d.DeviceType, Vendor: d.Vendor,
+			Username: d.Username, SSHPort: d.SSHPort, Tags: d.Tags, Status: d.Status, LastChecked: lastChecked,
+			Model: d.Model, OSVersion: d.OSVersion, IsSandbox: d.IsSandbox,
+			CreatedAt: exportTime(d.CreatedAt), UpdatedAt: exportTime(d.UpdatedAt),
+		})
+		if err == nil {
+			separator := ","
+			if e.checkpoint.Rows == 0 {
+				separator = ""
+			}
+			_, err = e.out.WriteString(separator + "\n" + string(data))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write device %s to export: %w", d.Name, err)
+	}
+
+	e.checkpoint.Rows++
+	return nil
+}
+
+// commit flushes the buffered rows to disk and records them in the
+// checkpoint, ending with the device with ID lastID
+func (e *deviceExport) commit(lastID string) error {
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	if err := e.out.Flush(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	if err := e.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	e.checkpoint.LastID = lastID
+	e.checkpoint.Bytes = e.written
+	e.checkpoint.SHA256 = hex.EncodeToString(e.hash.Sum(nil))
+	data, err := json.Marshal(e.checkpoint)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(e.path+ExportCheckpointSuffix, data); err != nil {
+		return fmt.Errorf("failed to write export checkpoint: %w", err)
+	}
+	return nil
+}
+
+// finish closes a JSON export's array, then writes the footer and drops
+// the checkpoint
+func (e *deviceExport) finish() (*DeviceExportFooter, error) {
+	if e.checkpoint.Format == ExportFormatJSON {
+		if _, err := e.out.WriteString("\n]\n"); err != nil {
+			return nil, fmt.Errorf("failed to write export: %w", err)
+		}
+	}
+	if err := e.out.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write export: %w", err)
+	}
+	if err := e.file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to write export: %w", err)
+	}
+
+	footer := &DeviceExportFooter{
+		Format:      e.checkpoint.Format,
+		Rows:        e.checkpoint.Rows,
+		Bytes:       e.written,
+		SHA256:      hex.EncodeToString(e.hash.Sum(nil)),
+		CompletedAt: time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(footer, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(e.path+ExportFooterSuffix, data); err != nil {
+		return nil, fmt.Errorf("failed to write export footer: %w", err)
+	}
+	if err := os.Remove(e.path + ExportCheckpointSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove export checkpoint: %w", err)
+	}
+	return footer, nil
+}
+
+// readExportCheckpoint returns the checkpoint of an unfinished export at
+// path, if there is a readable one
+func readExportCheckpoint(path string) (exportCheckpoint, bool) {
+	var checkpoint exportCheckpoint
+	data, err := os.ReadFile(path + ExportCheckpointSuffix)
+	if err != nil {
+		return checkpoint, false
+	}
+	return checkpoint, json.Unmarshal(data, &checkpoint) == nil
+}
+
+// countExportRows counts the devices in an export of format
+func countExportRows(r io.Reader, format string) (int, error) {
+	switch format {
+	case ExportFormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(deviceExportHeader)
+		rows := -1 // the header row
+		for {
+			_, err := reader.Read()
+			if err == io.EOF {
+				return max(rows, 0), nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			rows++
+		}
+	case ExportFormatJSON:
+		decoder := json.NewDecoder(r)
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return 0, fmt.Errorf("not a JSON array")
+		}
+		rows := 0
+		for decoder.More() {
+			var row exportedDevice
+			if err := decoder.Decode(&row); err != nil {
+				return 0, err
+			}
+			rows++
+		}
+		if _, err := decoder.Token(); err != nil {
+			return 0, err
+		}
+		return rows, nil
+	}
+	return 0, fmt.Errorf("unknown export format %q", format)
+}
+
+// writeFileAtomic replaces path with data, readable by the owner only, so
+// readers never see it half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// exportMismatch reports an export that does not match its footer
+func exportMismatch(format string, args ...interface{}) error {
+	return &DeviceError{
+		Type:    ErrorTypeValidation,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// exportTime formats a time as exports write it, in UTC RFC 3339
+func exportTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,324 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportFleetSize is the number of devices the export tests stream
+const exportFleetSize = 20000
+
+// seedExportFleet inserts count devices with tags and statuses
+func seedExportFleet(t *testing.T, db *sql.DB, count int) {
+	t.Helper()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO devices (id, name, ip_address, device_type, vendor, username,
+			password_encrypted, ssh_port, snmp_community, tags, created_at, updated_at, status, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	require.NoError(t, err)
+	defer stmt.Close()
+
+	vendors := ValidVendors()
+	statuses := []DeviceStatus{StatusOnline, StatusOffline, StatusWarning, StatusError}
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < count; i++ {
+		createdAt := base.Add(time.Duration(i) * time.Minute)
+		_, err := stmt.Exec(
+			fmt.Sprintf("export-%05d", i),
+			fmt.Sprintf("Edge \"%d\", rack %d", i, i%40),
+			fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256),
+			string(TypeRouter), string(vendors[i%len(vendors)]), "admin", []byte("encrypted_password"),
+			22, "secret-community", fmt.Sprintf("site-%d,core", i%50),
+			createdAt, createdAt, string(statuses[i%len(statuses)]), 1,
+		)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+}
+
+func TestManager_StreamDevices(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+	seedExportFleet(t, db, exportFleetSize)
+
+	var batches, streamed int
+	var lastID string
+	var first *Device
+	var baseline, peak uint64
+	err := manager.StreamDevices(DeviceSearchRequest{}, func(batch []Device) error {
+		require.NotEmpty(t, batch)
+		require.LessOrEqual(t, len(batch), StreamBatchSize)
+		for _, d := range batch {
+			require.Greater(t, d.ID, lastID, "devices stream in ID order")
+			lastID = d.ID
+		}
+		streamed += len(batch)
+		batches++
+
+		// Every batch reuses the same backing array
+		if first == nil {
+			first = &batch[0]
+		}
+		assert.Same(t, first, &batch[0])
+
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		if baseline == 0 {
+			baseline = stats.HeapAlloc
+		}
+		peak = max(peak, stats.HeapAlloc)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, exportFleetSize, streamed)
+	assert.Equal(t, exportFleetSize/StreamBatchSize, batches)
+	// Holding the whole fleet would take several times this
+	assert.Less(t, peak-baseline, uint64(4<<20), "heap grew while streaming")
+}
+
+func TestManager_StreamDevicesFilterAndResume(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+	seedExportFleet(t, db, 1200)
+
+	vendors := ValidVendors()
+	count, err := manager.CountDevicesContext(context.Background(), DeviceSearchRequest{Vendor: string(vendors[0])})
+	require.NoError(t, err)
+	assert.Equal(t, (1200+len(vendors)-1)/len(vendors), count)
+
+	filter := DeviceSearchRequest{Tag: "core", Sandbox: SandboxExclude}
+	count, err = manager.CountDevicesContext(context.Background(), filter)
+	require.NoError(t, err)
+	assert.Equal(t, 1200, count)
+
+	var ids []string
+	require.NoError(t, manager.StreamDevices(filter, func(batch []Device) error {
+		for _, d := range batch {
+			ids = append(ids, d.ID)
+		}
+		return nil
+	}))
+	assert.Len(t, ids, count)
+
+	var after []string
+	require.NoError(t, manager.StreamDevicesContext(context.Background(), filter, ids[699], func(batch []Device) error {
+		for _, d := range batch {
+			after = append(after, d.ID)
+		}
+		return nil
+	}))
+	assert.Equal(t, ids[700:], after)
+
+	stop := fmt.Errorf("stop")
+	assert.ErrorIs(t, manager.StreamDevices(DeviceSearchRequest{}, func([]Device) error { return stop }), stop)
+
+	err = manager.StreamDevices(DeviceSearchRequest{Sandbox: "maybe"}, func([]Device) error { return nil })
+	var deviceErr *DeviceError
+	require.ErrorAs(t, err, &deviceErr)
+	assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+}
+
+func TestManager_ExportDevicesResume(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+	seedExportFleet(t, db, exportFleetSize)
+
+	for _, format := range []string{ExportFormatCSV, ExportFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+
+			complete := filepath.Join(dir, "complete."+format)
+			footer, err := manager.ExportDevices(context.Background(), complete, DeviceExportOptions{Format: format})
+			require.NoError(t, err)
+			assert.Equal(t, exportFleetSize, footer.Rows)
+			want, err := os.ReadFile(complete)
+			require.NoError(t, err)
+			for _, name := range []string{complete, complete + ExportFooterSuffix} {
+				info, err := os.Stat(name)
+				require.NoError(t, err)
+				assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), name)
+			}
+			assert.NotContains(t, string(want), "secret-community")
+			assert.NotContains(t, string(want), "encrypted_password")
+
+			// Interrupt the export halfway through
+			path := filepath.Join(dir, "resumed."+format)
+			ctx, cancel := context.WithCancel(context.Background())
+			var progress []ExportProgress
+			_, err = manager.ExportDevices(ctx, path, DeviceExportOptions{Format: format, Progress: func(p ExportProgress) {
+				progress = append(progress, p)
+				if p.Rows >= exportFleetSize/2 {
+					cancel()
+				}
+			}})
+			cancel()
+			require.ErrorIs(t, err, context.Canceled)
+			require.NotEmpty(t, progress)
+			assert.Equal(t, exportFleetSize/2, progress[len(progress)-1].Rows)
+			assert.Equal(t, exportFleetSize, progress[0].Total)
+			assert.FileExists(t, path+ExportCheckpointSuffix)
+			assert.NoFileExists(t, path+ExportFooterSuffix)
+
+			// Rows written after the last checkpoint are thrown away
+			partial, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			require.NoError(t, err)
+			_, err = partial.WriteString("half a batch")
+			require.NoError(t, err)
+			require.NoError(t, partial.Close())
+
+			progress = nil
+			resumed, err := manager.ExportDevices(context.Background(), path, DeviceExportOptions{Format: format, Resume: true,
+				Progress: func(p ExportProgress) {
+					progress = append(progress, p)
+				}})
+			require.NoError(t, err)
+			assert.Equal(t, exportFleetSize/2+StreamBatchSize, progress[0].Rows, "resumes after the checkpoint")
+
+			got, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.True(t, string(want) == string(got), "resumed export differs from an uninterrupted one")
+			assert.Equal(t, footer.SHA256, resumed.SHA256)
+			assert.Equal(t, footer.Bytes, resumed.Bytes)
+			assert.NoFileExists(t, path+ExportCheckpointSuffix)
+
+			verified, err := VerifyDeviceExport(path)
+			require.NoError(t, err)
+			assert.Equal(t, exportFleetSize, verified.Rows)
+		})
+	}
+}
+
+func TestManager_ExportDevicesResumeChecks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+	seedExportFleet(t, db, 3*StreamBatchSize)
+	dir := t.TempDir()
+
+	// interrupt starts an export and stops it after its first batch
+	interrupt := func(t *testing.T, path string) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		_, err := manager.ExportDevices(ctx, path, DeviceExportOptions{Format: ExportFormatCSV,
+			Progress: func(ExportProgress) { cancel() }})
+		require.ErrorIs(t, err, context.Canceled)
+	}
+
+	t.Run("nothing to resume", func(t *testing.T) {
+		_, err := manager.ExportDevices(context.Background(), filepath.Join(dir, "new.csv"),
+			DeviceExportOptions{Format: ExportFormatCSV, Resume: true})
+		assert.ErrorContains(t, err, "no interrupted export")
+	})
+
+	t.Run("other format", func(t *testing.T) {
+		path := filepath.Join(dir, "format.csv")
+		interrupt(t, path)
+		_, err := manager.ExportDevices(context.Background(), path, DeviceExportOptions{Format: ExportFormatJSON, Resume: true})
+		assert.ErrorContains(t, err, "another format")
+	})
+
+	t.Run("tampered prefix", func(t *testing.T) {
+		path := filepath.Join(dir, "tampered.csv")
+		interrupt(t, path)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		data[len(data)/2] ^= 1
+		require.NoError(t, os.WriteFile(path, data, 0600))
+
+		_, err = manager.ExportDevices(context.Background(), path, DeviceExportOptions{Format: ExportFormatCSV, Resume: true})
+		assert.ErrorContains(t, err, "changed since its checkpoint")
+		left, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, left, "a tampered export is left as it is")
+	})
+
+	t.Run("without resume the export starts over", func(t *testing.T) {
+		path := filepath.Join(dir, "restart.csv")
+		interrupt(t, path)
+		var progress []ExportProgress
+		footer, err := manager.ExportDevices(context.Background(), path, DeviceExportOptions{Format: ExportFormatCSV,
+			Progress: func(p ExportProgress) { progress = append(progress, p) }})
+		require.NoError(t, err)
+		assert.Equal(t, 3*StreamBatchSize, footer.Rows)
+		require.NotEmpty(t, progress)
+		assert.Equal(t, StreamBatchSize, progress[0].Rows)
+	})
+}
+
+func TestManager_ExportDevicesEmptyAndInvalid(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "empty.json")
+	footer, err := manager.ExportDevices(context.Background(), path, DeviceExportOptions{Format: "JSON"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, footer.Rows)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[]`, string(data))
+
+	_, err = manager.ExportDevices(context.Background(), filepath.Join(dir, "devices.xml"), DeviceExportOptions{Format: "xml"})
+	var deviceErr *DeviceError
+	require.ErrorAs(t, err, &deviceErr)
+	assert.Equal(t, "format", deviceErr.Field)
+}
+
+func TestVerifyDeviceExport(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+	seedExportFleet(t, db, 50)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "devices.csv")
+	_, err := manager.ExportDevices(context.Background(), path, DeviceExportOptions{Format: ExportFormatCSV})
+	require.NoError(t, err)
+	footer, err := VerifyDeviceExport(path)
+	require.NoError(t, err)
+	assert.Equal(t, 50, footer.Rows)
+	assert.Len(t, footer.SHA256, 64)
+
+	t.Run("tampered export", func(t *testing.T) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		data[len(data)-2] ^= 1
+		require.NoError(t, os.WriteFile(path, data, 0644))
+
+		_, err = VerifyDeviceExport(path)
+		assert.ErrorContains(t, err, "SHA-256")
+	})
+
+	t.Run("truncated export", func(t *testing.T) {
+		require.NoError(t, os.Truncate(path, 100))
+		_, err := VerifyDeviceExport(path)
+		assert.ErrorContains(t, err, "bytes")
+	})
+
+	t.Run("missing footer", func(t *testing.T) {
+		_, err := VerifyDeviceExport(filepath.Join(dir, "other.csv"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,121 @@
+package device
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// HostKeyPolicy is how a device's SSH host key is verified
+type HostKeyPolicy string
+
+const (
+	// HostKeyPolicyStrict only accepts the key matching the device's
+	// HostKeyFingerprint
+	HostKeyPolicyStrict HostKeyPolicy = "strict"
+
+	// HostKeyPolicyTOFU trusts the key presented on first use and refuses
+	// any other key afterwards. It is the default.
+	HostKeyPolicyTOFU HostKeyPolicy = "tofu"
+
+	// HostKeyPolicyInsecure accepts any key, for lab devices whose keys
+	// are regenerated often. It offers no protection against interception.
+	HostKeyPolicyInsecure HostKeyPolicy = "insecure"
+)
+
+// hostKeyPinKeyPrefix prefixes the app_settings key holding the host key
+// fingerprint pinned for a device, followed by the device ID
+const hostKeyPinKeyPrefix = "fingerprint_"
+
+// HostKeyPinKey returns the app_settings key of a device's host key pin
+func HostKeyPinKey(deviceID string) string {
+	return hostKeyPinKeyPrefix + deviceID
+}
+
+// hostKeyFingerprintPattern matches a SHA256 fingerprint in the form
+// OpenSSH prints it
+var hostKeyFingerprintPattern = regexp.MustCompile(`^SHA256:[A-Za-z0-9+/]{43}$`)
+
+// ValidHostKeyPolicies returns all valid host key policies
+func ValidHostKeyPolicies() []HostKeyPolicy {
+	return []HostKeyPolicy{HostKeyPolicyStrict, HostKeyPolicyTOFU, HostKeyPolicyInsecure}
+}
+
+// EffectiveHostKeyPolicy returns the device's host key policy, TOFU when
+// none is set
+func (d *Device) EffectiveHostKeyPolicy() HostKeyPolicy {
+	if d.HostKeyPolicy == "" {
+		return HostKeyPolicyTOFU
+	}
+	return HostKeyPolicy(d.HostKeyPolicy)
+}
+
+// ValidateHostKeyPolicy validates a device's host key policy and pinned
+// fingerprint. An empty policy means TOFU. The strict policy needs a
+// fingerprint; the others may keep one for when the device is made strict.
+func ValidateHostKeyPolicy(policy, fingerprint string) error {
+	valid := policy == ""
+	for _, p := range ValidHostKeyPolicies() {
+		if string(p) == policy {
+			valid = true
+		}
+	}
+	if !valid {
+		return ValidationError{Field: "hostKeyPolicy", Message: fmt.Sprintf("invalid host key policy: %s", policy)}
+	}
+
+	if fingerprint == "" {
+		if HostKeyPolicy(policy) == HostKeyPolicyStrict {
+			return ValidationError{Field: "hostKeyFingerprint",
+				Message: "host key fingerprint is required for the strict policy"}
+		}
+		return nil
+	}
+	if !hostKeyFingerprintPattern.MatchString(fingerprint) {
+		return ValidationError{Field: "hostKeyFingerprint",
+			Message: "host key fingerprint must be a SHA256 fingerprint as printed by ssh-keygen -l"}
+	}
+	return nil
+}
+
+// HostKeyPin returns the fingerprint pinned for the device at a host:port
+// address, or an empty string when there is no such device or pin. It is
+// the ssh.HostKeyPinFunc of every client that connects to devices.
+func (m *Manager) HostKeyPin(address string) (string, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return "", fmt.Errorf("invalid port in address %s: %w", address, err)
+	}
+
+	dev, err := m.GetDeviceByIP(host)
+	var deviceErr *DeviceError
+	if errors.As(err, &deviceErr) && deviceErr.Type == ErrorTypeNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if dev.SSHPort != port {
+		return "", nil
+	}
+
+	var fingerprint string
+	err = m.db.QueryRow("SELECT value FROM app_settings WHERE key = ?", HostKeyPinKey(dev.ID)).Scan(&fingerprint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to read host key pin: %v", err),
+		}
+	}
+	return fingerprint, nil
+}
@@ -0,0 +1,102 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testFingerprint = "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"
+
+func TestValidateHostKeyPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		fingerprint string
+		field       string
+	}{
+		{name: "default", policy: ""},
+		{name: "tofu", policy: "tofu"},
+		{name: "insecure", policy: "insecure"},
+		{name: "strict with fingerprint", policy: "strict", fingerprint: testFingerprint},
+		{name: "tofu keeps a fingerprint", policy: "tofu", fingerprint: testFingerprint},
+		{name: "strict without fingerprint", policy: "strict", field: "hostKeyFingerprint"},
+		{name: "unknown policy", policy: "trusting", field: "hostKeyPolicy"},
+		{name: "MD5 fingerprint", policy: "strict", fingerprint: "16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48",
+			field: "hostKeyFingerprint"},
+		{name: "truncated fingerprint", policy: "tofu", fingerprint: "SHA256:nThbg6kXUpJWGl7E1IGO", field: "hostKeyFingerprint"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateHostKeyPolicy(tt.policy, tt.fingerprint)
+			if tt.field == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Equal(t, tt.field, validationErr.Field)
+		})
+	}
+}
+
+func TestManager_HostKeyPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	dev := createTestDevice()
+	require.NoError(t, manager.AddDevice(dev))
+	assert.Equal(t, string(HostKeyPolicyTOFU), dev.HostKeyPolicy, "devices default to trust on first use")
+
+	stored, err := manager.GetDevice(dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, HostKeyPolicyTOFU, stored.EffectiveHostKeyPolicy())
+	assert.Empty(t, stored.HostKeyFingerprint)
+
+	stored.HostKeyPolicy = string(HostKeyPolicyStrict)
+	assert.Error(t, manager.UpdateDevice(stored), "strict needs a fingerprint")
+
+	stored.HostKeyFingerprint = " " + testFingerprint + " "
+	require.NoError(t, manager.UpdateDevice(stored))
+	stored, err = manager.GetDevice(dev.ID)
+	require.NoError(t, err)
+	assert.Equal(t, HostKeyPolicyStrict, stored.EffectiveHostKeyPolicy())
+	assert.Equal(t, testFingerprint, stored.HostKeyFingerprint)
+
+	dto := stored.ToDTO()
+	assert.Equal(t, "strict", dto.HostKeyPolicy)
+	assert.Equal(t, testFingerprint, dto.HostKeyFingerprint)
+
+	assert.Equal(t, HostKeyPolicyTOFU, (&Device{}).EffectiveHostKeyPolicy())
+}
+
+func TestManager_HostKeyPin(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	dev := createTestDevice()
+	require.NoError(t, manager.AddDevice(dev))
+
+	pin, err := manager.HostKeyPin("192.168.1.1:22")
+	require.NoError(t, err)
+	assert.Empty(t, pin, "no pin yet")
+
+	_, err = db.Exec("INSERT INTO app_settings (key, value) VALUES (?, ?)", HostKeyPinKey(dev.ID), testFingerprint)
+	require.NoError(t, err)
+	pin, err = manager.HostKeyPin("192.168.1.1:22")
+	require.NoError(t, err)
+	assert.Equal(t, testFingerprint, pin)
+
+	for _, address := range []string{"192.168.1.1:2222", "192.168.1.2:22"} {
+		pin, err = manager.HostKeyPin(address)
+		require.NoError(t, err)
+		assert.Empty(t, pin, address)
+	}
+
+	_, err = manager.HostKeyPin("192.168.1.1")
+	assert.Error(t, err)
+}
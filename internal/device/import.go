@@ -0,0 +1,206 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// importSchema is the alias the source database is attached under
+const importSchema = "import_src"
+
+// ImportDevices adds devices listed outside the app, such as in a file kept
+// with a CI pipeline. Devices whose IP address already exists are skipped and
+// devices that do not validate are reported in Failed while the rest are
+// imported. Imported devices have an empty password until one is set and use
+// port 22 when none is given.
+func (m *Manager) ImportDevices(devices []Device) (*ImportResult, error) {
+	result := &ImportResult{Failed: []ImportFailure{}}
+	for i := range devices {
+		device := devices[i]
+		device.ID = ""
+		if device.SSHPort == 0 {
+			device.SSHPort = 22
+		}
+		if device.PasswordEncrypted == nil {
+			device.PasswordEncrypted = []byte{}
+		}
+
+		name := device.Name
+		if name == "" {
+			name = device.IPAddress
+		}
+		if err := m.importDevice(result, name, &device, nil); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// ImportFromDatabase copies the devices of another Invictux database into
+// this one. Each stored password is passed through reencrypt so it can be
+// moved from the source install's key to this one; a nil reencrypt copies
+// passwords unchanged. Devices whose IP address already exists here are
+// skipped. Imported devices get new IDs and start offline.
+func (m *Manager) ImportFromDatabase(srcPath string, reencrypt func([]byte) ([]byte, error)) (int, error) {
+	if _, err := os.Stat(srcPath); err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "srcPath",
+			Message: fmt.Sprintf("source database not accessible: %v", err),
+		}
+	}
+
+	ctx := context.Background()
+
+	// ATTACH only applies to the connection it runs on, so pin one
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to get database connection: %v", err),
+		}
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+importSchema, srcPath); err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to attach source database: %v", err),
+		}
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE "+importSchema)
+
+	devices, err := readImportDevices(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to begin transaction: %v", err),
+		}
+	}
+	defer tx.Rollback()
+
+	insertQuery := `
+		INSERT INTO main.devices (id, name, ip_address, device_type, vendor, username,
+			password_encrypted, ssh_port, snmp_community, tags, created_at, updated_at, status, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	imported := 0
+	for _, device := range devices {
+		var existingID string
+		err := tx.QueryRow(`SELECT id FROM main.devices WHERE ip_address = ?`, device.IPAddress).Scan(&existingID)
+		if err == nil {
+			continue
+		} else if err != sql.ErrNoRows {
+			return 0, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to check for duplicate IP: %v", err),
+			}
+		}
+
+		if reencrypt != nil {
+			device.PasswordEncrypted, err = reencrypt(device.PasswordEncrypted)
+			if err != nil {
+				return 0, fmt.Errorf("failed to re-encrypt password for device %s: %w", device.Name, err)
+			}
+		}
+
+		device.ID = uuid.New().String()
+		device.UpdatedAt = time.Now()
+		device.Status = string(StatusOffline)
+		device.Version = 1
+
+		if _, err := tx.Exec(insertQuery, device.ID, device.Name, device.IPAddress,
+			device.DeviceType, device.Vendor, device.Username, device.PasswordEncrypted,
+			device.SSHPort, device.SNMPCommunity, device.Tags, device.CreatedAt, device.UpdatedAt,
+			device.Status, device.Version); err != nil {
+			return 0, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to insert device %s: %v", device.Name, err),
+			}
+		}
+		imported++
+	}
+
+	if imported > 0 {
+		if err := bumpDataVersion(context.Background(), tx); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to commit transaction: %v", err),
+		}
+	}
+
+	return imported, nil
+}
+
+// readImportDevices reads the devices of the attached source database. Only
+// columns present since the first schema version are read, so databases
+// from older installs can be imported too.
+func readImportDevices(ctx context.Context, conn *sql.Conn) ([]Device, error) {
+	query := `
+		SELECT name, ip_address, device_type, vendor, username, password_encrypted,
+			ssh_port, snmp_community, tags, created_at
+		FROM ` + importSchema + `.devices
+		ORDER BY created_at, id
+	`
+
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to read source devices: %v", err),
+		}
+	}
+	defer rows.Close()
+
+	var devices []Device
+	for rows.Next() {
+		var device Device
+		var sshPort sql.NullInt64
+		var snmpCommunity, tags sql.NullString
+		var createdAt sql.NullTime
+
+		if err := rows.Scan(&device.Name, &device.IPAddress, &device.DeviceType, &device.Vendor,
+			&device.Username, &device.PasswordEncrypted, &sshPort, &snmpCommunity, &tags,
+			&createdAt); err != nil {
+			return nil, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to scan source device: %v", err),
+			}
+		}
+
+		device.SSHPort = int(sshPort.Int64)
+		device.SNMPCommunity = snmpCommunity.String
+		device.Tags = tags.String
+		if createdAt.Valid {
+			device.CreatedAt = createdAt.Time
+		}
+		device.SetDefaults()
+
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to read source devices: %v", err),
+		}
+	}
+
+	return devices, nil
+}
@@ -0,0 +1,135 @@
+package device
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupSourceDB creates a source database with the first devices schema,
+// as an older install would have
+func setupSourceDB(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "source.db")
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE devices (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			ip_address TEXT NOT NULL UNIQUE,
+			device_type TEXT NOT NULL,
+			vendor TEXT NOT NULL,
+			username TEXT NOT NULL,
+			password_encrypted BLOB NOT NULL,
+			ssh_port INTEGER DEFAULT 22,
+			snmp_community TEXT,
+			tags TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO devices (id, name, ip_address, device_type, vendor, username, password_encrypted, ssh_port, tags)
+		VALUES
+			('s1', 'Edge Router', '10.1.0.1', 'router', 'cisco', 'admin', X'6F6C642D31', 2222, 'edge'),
+			('s2', 'Core Switch', '192.168.1.1', 'switch', 'cisco', 'admin', X'6F6C642D32', 22, NULL),
+			('s3', 'Firewall', '10.1.0.3', 'firewall', 'juniper', 'root', X'6F6C642D33', NULL, NULL);
+	`)
+	require.NoError(t, err)
+
+	return path
+}
+
+func TestManager_ImportFromDatabase(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	// 192.168.1.1 already exists and must not be imported again
+	existing := createTestDevice()
+	require.NoError(t, manager.AddDevice(existing))
+
+	reencrypt := func(old []byte) ([]byte, error) {
+		return bytes.Replace(old, []byte("old"), []byte("new"), 1), nil
+	}
+
+	imported, err := manager.ImportFromDatabase(setupSourceDB(t), reencrypt)
+	require.NoError(t, err)
+	assert.Equal(t, 2, imported)
+
+	edge, err := manager.GetDeviceByIP("10.1.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "Edge Router", edge.Name)
+	assert.Equal(t, []byte("new-1"), edge.PasswordEncrypted)
+	assert.Equal(t, 2222, edge.SSHPort)
+	assert.Equal(t, "edge", edge.Tags)
+	assert.Equal(t, string(StatusOffline), edge.Status)
+	assert.NotEqual(t, "s1", edge.ID)
+
+	firewall, err := manager.GetDeviceByIP("10.1.0.3")
+	require.NoError(t, err)
+	assert.Equal(t, 22, firewall.SSHPort)
+
+	kept, err := manager.GetDeviceByIP("192.168.1.1")
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, kept.ID)
+	assert.Equal(t, []byte("encrypted_password"), kept.PasswordEncrypted)
+
+	// The source is detached again, so a second import finds only duplicates
+	imported, err = manager.ImportFromDatabase(setupSourceDB(t), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, imported)
+}
+
+func TestManager_ImportFromDatabase_Errors(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	_, err := manager.ImportFromDatabase(filepath.Join(t.TempDir(), "missing.db"), nil)
+	var deviceErr *DeviceError
+	require.ErrorAs(t, err, &deviceErr)
+	assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+
+	// A failed re-encryption imports nothing
+	failing := func([]byte) ([]byte, error) { return nil, errors.New("wrong key") }
+	imported, err := manager.ImportFromDatabase(setupSourceDB(t), failing)
+	assert.ErrorContains(t, err, "wrong key")
+	assert.Equal(t, 0, imported)
+
+	devices, err := manager.GetAllDevices()
+	require.NoError(t, err)
+	assert.Empty(t, devices)
+}
+
+func TestManager_ImportDevices(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	existing := createTestDevice()
+	require.NoError(t, manager.AddDevice(existing))
+
+	result, err := manager.ImportDevices([]Device{
+		{ID: "ignored", Name: "Edge Router", IPAddress: "10.1.0.1", DeviceType: "router", Vendor: "cisco", Username: "admin"},
+		{Name: "Duplicate", IPAddress: existing.IPAddress, DeviceType: "switch", Vendor: "cisco", Username: "admin"},
+		{IPAddress: "10.1.0.2", DeviceType: "router", Vendor: "cisco", Username: "admin"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, 1, result.Skipped)
+	require.Len(t, result.Failed, 1)
+	assert.Equal(t, "10.1.0.2", result.Failed[0].Name, "devices without a name are reported by address")
+
+	edge, err := manager.GetDeviceByIP("10.1.0.1")
+	require.NoError(t, err)
+	assert.NotEqual(t, "ignored", edge.ID)
+	assert.Equal(t, 22, edge.SSHPort)
+	assert.Empty(t, edge.PasswordEncrypted)
+}
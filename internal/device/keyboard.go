@@ -0,0 +1,172 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxKeyboardResponses bounds the keyboard-interactive responses of a device
+const MaxKeyboardResponses = 10
+
+// KeyboardResponse answers the device's keyboard-interactive prompts that
+// match Prompt, a regular expression matched case-insensitively. The
+// response is stored encrypted like the password and never leaves the
+// backend. Responses are secret unless AllowEcho is set, so they are not
+// sent to prompts that echo what is typed.
+type KeyboardResponse struct {
+	Prompt            string `json:"prompt"`
+	AllowEcho         bool   `json:"allowEcho"`
+	ResponseEncrypted []byte `json:"-"`
+}
+
+// storedKeyboardResponse is a keyboard response as the keyboard_responses
+// column holds it
+type storedKeyboardResponse struct {
+	Prompt            string `json:"prompt"`
+	AllowEcho         bool   `json:"allowEcho,omitempty"`
+	ResponseEncrypted []byte `json:"response"`
+}
+
+// Validate validates a keyboard response
+func (r KeyboardResponse) Validate() error {
+	if strings.TrimSpace(r.Prompt) == "" {
+		return ValidationError{Field: "keyboardResponses", Message: "keyboard response prompt cannot be empty"}
+	}
+	if _, err := regexp.Compile("(?i)" + r.Prompt); err != nil {
+		return ValidationError{Field: "keyboardResponses",
+			Message: fmt.Sprintf("invalid keyboard response prompt %q: %v", r.Prompt, err)}
+	}
+	if len(r.ResponseEncrypted) == 0 {
+		return ValidationError{Field: "keyboardResponses",
+			Message: fmt.Sprintf("keyboard response for prompt %q has no response", r.Prompt)}
+	}
+	return nil
+}
+
+// ValidateKeyboardResponses validates a device's keyboard responses
+func ValidateKeyboardResponses(responses []KeyboardResponse) error {
+	if len(responses) > MaxKeyboardResponses {
+		return ValidationError{Field: "keyboardResponses",
+			Message: fmt.Sprintf("cannot exceed %d keyboard responses", MaxKeyboardResponses)}
+	}
+	for _, response := range responses {
+		if err := response.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeKeyboardResponses returns the JSON stored in the keyboard_responses
+// column, or NULL when there are no responses
+func encodeKeyboardResponses(responses []KeyboardResponse) (interface{}, error) {
+	if len(responses) == 0 {
+		return nil, nil
+	}
+	stored := make([]storedKeyboardResponse, len(responses))
+	for i, response := range responses {
+		stored[i] = storedKeyboardResponse(response)
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "keyboardResponses",
+			Message: fmt.Sprintf("failed to encode keyboard responses: %v", err),
+		}
+	}
+	return string(data), nil
+}
+
+// decodeKeyboardResponses parses the keyboard_responses column
+func decodeKeyboardResponses(value sql.NullString) ([]KeyboardResponse, error) {
+	if !value.Valid || strings.TrimSpace(value.String) == "" {
+		return nil, nil
+	}
+	var stored []storedKeyboardResponse
+	if err := json.Unmarshal([]byte(value.String), &stored); err != nil {
+		return nil, fmt.Errorf("invalid keyboard responses: %w", err)
+	}
+	responses := make([]KeyboardResponse, len(stored))
+	for i, response := range stored {
+		responses[i] = KeyboardResponse(response)
+	}
+	return responses, nil
+}
+
+// SetKeyboardResponses replaces the keyboard-interactive responses of a
+// device, whose responses must already be encrypted. An empty list clears
+// them. The device version advances, so an edit started before the change
+// conflicts.
+func (m *Manager) SetKeyboardResponses(id string, responses []KeyboardResponse) error {
+	if strings.TrimSpace(id) == "" {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "id",
+			Message: "device ID cannot be empty",
+		}
+	}
+
+	if err := ValidateKeyboardResponses(responses); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "keyboardResponses",
+			Message: err.Error(),
+		}
+	}
+
+	encoded, err := encodeKeyboardResponses(responses)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to begin transaction: %v", err),
+		}
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE devices SET keyboard_responses = ?, updated_at = ?, version = version + 1 WHERE id = ?`,
+		encoded, time.Now(), id)
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to update keyboard responses: %v", err),
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to get rows affected: %v", err),
+		}
+	}
+	if rowsAffected == 0 {
+		return &DeviceError{
+			Type:    ErrorTypeNotFound,
+			Message: fmt.Sprintf("device with ID %s not found", id),
+		}
+	}
+
+	if err = bumpDataVersion(context.Background(), tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to commit transaction: %v", err),
+		}
+	}
+
+	return nil
+}
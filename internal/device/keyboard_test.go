@@ -0,0 +1,74 @@
+package device
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyboardResponse_Validate(t *testing.T) {
+	assert.NoError(t, KeyboardResponse{Prompt: `verification code`, ResponseEncrypted: []byte("x")}.Validate())
+	assert.Error(t, KeyboardResponse{Prompt: " ", ResponseEncrypted: []byte("x")}.Validate())
+	assert.Error(t, KeyboardResponse{Prompt: `code[`, ResponseEncrypted: []byte("x")}.Validate())
+	assert.Error(t, KeyboardResponse{Prompt: `code`}.Validate())
+
+	tooMany := make([]KeyboardResponse, MaxKeyboardResponses+1)
+	for i := range tooMany {
+		tooMany[i] = KeyboardResponse{Prompt: "code", ResponseEncrypted: []byte("x")}
+	}
+	assert.Error(t, ValidateKeyboardResponses(tooMany))
+}
+
+func TestManager_SetKeyboardResponses(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	device := createTestDevice()
+	require.NoError(t, manager.AddDevice(device))
+
+	stored, err := manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.KeyboardResponses)
+
+	responses := []KeyboardResponse{
+		{Prompt: `verification code`, ResponseEncrypted: []byte("encrypted-code")},
+		{Prompt: `^username`, AllowEcho: true, ResponseEncrypted: []byte("encrypted-user")},
+	}
+	require.NoError(t, manager.SetKeyboardResponses(device.ID, responses))
+
+	stored, err = manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Equal(t, responses, stored.KeyboardResponses)
+	assert.Equal(t, device.Version+1, stored.Version)
+
+	// Other updates keep the responses
+	stored.Tags = "core"
+	require.NoError(t, manager.UpdateDevice(stored))
+	stored, err = manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Equal(t, responses, stored.KeyboardResponses)
+
+	// The frontend sees the prompts but never the responses
+	data, err := json.Marshal(stored.ToDTO())
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"prompt":"verification code"`)
+	assert.NotContains(t, string(data), "encrypted-code")
+
+	require.NoError(t, manager.SetKeyboardResponses(device.ID, nil))
+	stored, err = manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.KeyboardResponses)
+
+	err = manager.SetKeyboardResponses(device.ID, []KeyboardResponse{{Prompt: "code["}})
+	deviceErr, ok := err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+
+	err = manager.SetKeyboardResponses("missing", responses)
+	deviceErr, ok = err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeNotFound, deviceErr.Type)
+}
@@ -0,0 +1,356 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dataVersionKey is the app_settings key holding the device data version
+const dataVersionKey = "devices_data_version"
+
+// Page size limits for GetDevicesPage
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// DeviceDTO is the device representation sent to the frontend. It never
+// carries credential bytes.
+type DeviceDTO struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	IPAddress      string     `json:"ipAddress"`
+	DeviceType     string     `json:"deviceType"`
+	Vendor         string     `json:"vendor"`
+	Username       string     `json:"username"`
+	HasCredentials bool       `json:"hasCredentials"`
+	SSHPort        int        `json:"sshPort"`
+	SNMPCommunity  string     `json:"snmpCommunity"`
+	Tags           string     `json:"tags"`
+	Status         string     `json:"status"`
+	LastChecked    *time.Time `json:"lastChecked"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	Version        int        `json:"version"`
+	IsSandbox      bool       `json:"isSandbox"`
+	OutputCharset  string     `json:"outputCharset"`
+
+	HostKeyPolicy      string `json:"hostKeyPolicy"`
+	HostKeyFingerprint string `json:"hostKeyFingerprint"`
+
+	Model     string `json:"model"`
+	OSVersion string `json:"osVersion"`
+
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+	KeyboardResponses  []KeyboardResponse  `json:"keyboardResponses,omitempty"`
+}
+
+// ToDTO converts a device into its frontend representation
+func (d *Device) ToDTO() DeviceDTO {
+	return DeviceDTO{
+		ID:             d.ID,
+		Name:           d.Name,
+		IPAddress:      d.IPAddress,
+		DeviceType:     d.DeviceType,
+		Vendor:         d.Vendor,
+		Username:       d.Username,
+		HasCredentials: len(d.PasswordEncrypted) > 0,
+		SSHPort:        d.SSHPort,
+		SNMPCommunity:  d.SNMPCommunity,
+		Tags:           d.Tags,
+		Status:         d.Status,
+		LastChecked:    d.LastChecked,
+		CreatedAt:      d.CreatedAt,
+		UpdatedAt:      d.UpdatedAt,
+		Version:        d.Version,
+		IsSandbox:      d.IsSandbox,
+		OutputCharset:  d.OutputCharset,
+
+		HostKeyPolicy:      string(d.EffectiveHostKeyPolicy()),
+		HostKeyFingerprint: d.HostKeyFingerprint,
+
+		Model:     d.Model,
+		OSVersion: d.OSVersion,
+
+		MaintenanceWindows: d.MaintenanceWindows,
+		KeyboardResponses:  d.KeyboardResponses,
+	}
+}
+
+// ToDTOs converts a list of devices into their frontend representation
+func ToDTOs(devices []Device) []DeviceDTO {
+	dtos := make([]DeviceDTO, 0, len(devices))
+	for i := range devices {
+		dtos = append(dtos, devices[i].ToDTO())
+	}
+	return dtos
+}
+
+// DeviceListResponse is returned by GetDevicesIfChanged. When NotModified is
+// set, Devices is empty and the client keeps its copy.
+type DeviceListResponse struct {
+	NotModified bool        `json:"notModified"`
+	Token       string      `json:"token"`
+	Devices     []DeviceDTO `json:"devices,omitempty"`
+}
+
+// DevicePage is one page of devices in GetAllDevices order
+type DevicePage struct {
+	Devices    []DeviceDTO `json:"devices"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	Token      string      `json:"token"`
+}
+
+// deviceListCache is the last device list read and the data version it reflects
+type deviceListCache struct {
+	version int64
+	devices []Device
+}
+
+// pageCursor marks the last device of a page
+type pageCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        string    `json:"i"`
+}
+
+// bumpDataVersion advances the persisted device data version within a mutation
+func bumpDataVersion(ctx context.Context, tx *sql.Tx) error {
+	query := `
+		INSERT INTO app_settings (key, value, updated_at)
+		VALUES (?, '1', CURRENT_TIMESTAMP)
+		ON CONFLICT(key) DO UPDATE SET value = CAST(value AS INTEGER) + 1, updated_at = CURRENT_TIMESTAMP
+	`
+
+	if _, err := tx.ExecContext(ctx, query, dataVersionKey); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to bump device data version: %v", err),
+		}
+	}
+
+	return nil
+}
+
+// DataVersion returns the persisted device data version, which increases on every mutation
+func (m *Manager) DataVersion() (int64, error) {
+	var value string
+	err := m.db.QueryRow("SELECT value FROM app_settings WHERE key = ?", dataVersionKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to read device data version: %v", err),
+		}
+	}
+
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("invalid device data version %q", value),
+		}
+	}
+
+	return version, nil
+}
+
+// ChangeToken returns the opaque token describing the current device data
+func (m *Manager) ChangeToken() (string, error) {
+	version, err := m.DataVersion()
+	if err != nil {
+		return "", err
+	}
+	return formatChangeToken(version), nil
+}
+
+// GetDevicesIfChanged returns the device list only when it differs from the
+// data described by clientToken. Unchanged lists short-circuit without
+// reading any device rows.
+func (m *Manager) GetDevicesIfChanged(clientToken string) (*DeviceListResponse, error) {
+	version, err := m.DataVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	token := formatChangeToken(version)
+	if clientToken != "" && clientToken == token {
+		return &DeviceListResponse{NotModified: true, Token: token}, nil
+	}
+
+	devices, err := m.cachedDevices(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeviceListResponse{Token: token, Devices: ToDTOs(devices)}, nil
+}
+
+// cachedDevices returns the device list for a data version, reading it from
+// the database only when the cached copy is older
+func (m *Manager) cachedDevices(version int64) ([]Device, error) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	if m.cache != nil && m.cache.version == version {
+		return m.cache.devices, nil
+	}
+
+	devices, err := m.GetAllDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	m.cache = &deviceListCache{version: version, devices: devices}
+	return devices, nil
+}
+
+// GetDevicesPage returns up to limit devices following the cursor, in
+// GetAllDevices order. An empty cursor starts at the first page.
+func (m *Manager) GetDevicesPage(cursor string, limit int) (*DevicePage, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	token, err := m.ChangeToken()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + deviceColumns + ` FROM devices`
+	var args []interface{}
+
+	if cursor != "" {
+		after, err := decodePageCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		query += ` WHERE created_at < ? OR (created_at = ? AND id < ?)`
+		args = append(args, after.CreatedAt, after.CreatedAt, after.ID)
+	}
+
+	// Fetch one extra row to know whether another page follows
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to query devices: %v", err),
+		}
+	}
+	defer rows.Close()
+
+	var devices []Device
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to scan device row: %v", err),
+			}
+		}
+		devices = append(devices, device)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("error iterating over device rows: %v", err),
+		}
+	}
+
+	page := &DevicePage{Token: token}
+	if len(devices) > limit {
+		devices = devices[:limit]
+		last := devices[len(devices)-1]
+		page.NextCursor = encodePageCursor(pageCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	page.Devices = ToDTOs(devices)
+
+	return page, nil
+}
+
+// GetRecentlyChangedDevices returns up to limit devices ordered by their last
+// add or edit, most recent first. Status updates from checks do not count as edits.
+func (m *Manager) GetRecentlyChangedDevices(limit int) ([]Device, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	query := `SELECT ` + deviceColumns + ` FROM devices ORDER BY updated_at DESC, id DESC LIMIT ?`
+
+	rows, err := m.db.Query(query, limit)
+	if err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to query devices: %v", err),
+		}
+	}
+	defer rows.Close()
+
+	var devices []Device
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to scan device row: %v", err),
+			}
+		}
+		devices = append(devices, device)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("error iterating over device rows: %v", err),
+		}
+	}
+
+	return devices, nil
+}
+
+// formatChangeToken renders a data version as an opaque change token
+func formatChangeToken(version int64) string {
+	return "v" + strconv.FormatInt(version, 10)
+}
+
+// encodePageCursor serializes a cursor for the frontend
+func encodePageCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageCursor parses a cursor produced by encodePageCursor
+func decodePageCursor(cursor string) (pageCursor, error) {
+	var decoded pageCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(cursor))
+	if err == nil {
+		err = json.Unmarshal(data, &decoded)
+	}
+	if err != nil || decoded.ID == "" {
+		return decoded, &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "cursor",
+			Message: "invalid page cursor",
+		}
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,212 @@
+package device
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_ChangeTokenBumpsOnMutations(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	token, err := manager.ChangeToken()
+	require.NoError(t, err)
+	assert.Equal(t, "v0", token)
+
+	device := createTestDevice()
+
+	mutations := []struct {
+		name   string
+		mutate func() error
+	}{
+		{"add", func() error { return manager.AddDevice(device) }},
+		{"update", func() error {
+			device.Tags = "updated"
+			return manager.UpdateDevice(device)
+		}},
+		{"status", func() error {
+			return manager.UpdateDeviceStatus(device.ID, string(StatusOnline), time.Now())
+		}},
+		{"delete", func() error { return manager.DeleteDevice(device.ID) }},
+	}
+
+	for i, mutation := range mutations {
+		require.NoError(t, mutation.mutate(), mutation.name)
+
+		next, err := manager.ChangeToken()
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("v%d", i+1), next, "token after %s", mutation.name)
+		assert.NotEqual(t, token, next)
+		token = next
+	}
+
+	// Failed mutations leave the token alone
+	assert.Error(t, manager.DeleteDevice(device.ID))
+	unchanged, err := manager.ChangeToken()
+	require.NoError(t, err)
+	assert.Equal(t, token, unchanged)
+
+	// The version is persisted, so a new manager on the same database sees it
+	restarted, err := NewManager(db).ChangeToken()
+	require.NoError(t, err)
+	assert.Equal(t, token, restarted)
+}
+
+func TestManager_GetDevicesIfChanged(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	require.NoError(t, manager.AddDevice(createTestDevice()))
+
+	first, err := manager.GetDevicesIfChanged("")
+	require.NoError(t, err)
+	assert.False(t, first.NotModified)
+	assert.Len(t, first.Devices, 1)
+	assert.Equal(t, int64(1), manager.listQueries.Load())
+
+	t.Run("not modified short-circuits", func(t *testing.T) {
+		response, err := manager.GetDevicesIfChanged(first.Token)
+		require.NoError(t, err)
+		assert.True(t, response.NotModified)
+		assert.Equal(t, first.Token, response.Token)
+		assert.Empty(t, response.Devices)
+		assert.Equal(t, int64(1), manager.listQueries.Load())
+	})
+
+	t.Run("stale token served from cache", func(t *testing.T) {
+		response, err := manager.GetDevicesIfChanged("v-stale")
+		require.NoError(t, err)
+		assert.False(t, response.NotModified)
+		assert.Len(t, response.Devices, 1)
+		assert.Equal(t, int64(1), manager.listQueries.Load())
+	})
+
+	t.Run("mutation returns the new list", func(t *testing.T) {
+		device := createTestDevice()
+		device.IPAddress = "192.168.1.2"
+		require.NoError(t, manager.AddDevice(device))
+
+		response, err := manager.GetDevicesIfChanged(first.Token)
+		require.NoError(t, err)
+		assert.False(t, response.NotModified)
+		assert.NotEqual(t, first.Token, response.Token)
+		assert.Len(t, response.Devices, 2)
+		assert.Equal(t, int64(2), manager.listQueries.Load())
+	})
+}
+
+func TestDeviceDTO_OmitsCredentials(t *testing.T) {
+	device := createTestDevice()
+	device.PasswordEncrypted = []byte("super-secret-ciphertext")
+
+	dto := device.ToDTO()
+	assert.True(t, dto.HasCredentials)
+
+	data, err := json.Marshal(dto)
+	require.NoError(t, err)
+
+	serialized := string(data)
+	assert.NotContains(t, strings.ToLower(serialized), "password")
+	assert.NotContains(t, serialized, "super-secret-ciphertext")
+	assert.NotContains(t, serialized, base64.StdEncoding.EncodeToString(device.PasswordEncrypted))
+
+	device.PasswordEncrypted = nil
+	assert.False(t, device.ToDTO().HasCredentials)
+}
+
+func TestManager_GetDevicesPage(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	for i := 0; i < 5; i++ {
+		device := createTestDevice()
+		device.Name = fmt.Sprintf("Device %d", i+1)
+		device.IPAddress = fmt.Sprintf("10.0.0.%d", i+1)
+		require.NoError(t, manager.AddDevice(device))
+	}
+
+	all, err := manager.GetAllDevices()
+	require.NoError(t, err)
+
+	var paged []string
+	cursor := ""
+	pages := 0
+	for {
+		page, err := manager.GetDevicesPage(cursor, 2)
+		require.NoError(t, err)
+		assert.Equal(t, "v5", page.Token)
+		pages++
+
+		for _, dto := range page.Devices {
+			paged = append(paged, dto.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	assert.Equal(t, 3, pages)
+	require.Len(t, paged, len(all))
+	for i, device := range all {
+		assert.Equal(t, device.ID, paged[i])
+	}
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := manager.GetDevicesPage("not-a-cursor", 2)
+		deviceErr, ok := err.(*DeviceError)
+		require.True(t, ok)
+		assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+		assert.Equal(t, "cursor", deviceErr.Field)
+	})
+
+	t.Run("limit defaults and caps", func(t *testing.T) {
+		page, err := manager.GetDevicesPage("", 0)
+		require.NoError(t, err)
+		assert.Len(t, page.Devices, 5)
+		assert.Empty(t, page.NextCursor)
+	})
+}
+
+func TestManager_GetRecentlyChangedDevices(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	var devices []*Device
+	for i := 0; i < 3; i++ {
+		device := createTestDevice()
+		device.Name = fmt.Sprintf("Router %d", i)
+		device.IPAddress = fmt.Sprintf("192.168.10.%d", i+1)
+		require.NoError(t, manager.AddDevice(device))
+		devices = append(devices, device)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Editing the oldest device moves it to the front
+	devices[0].Tags = "edited"
+	require.NoError(t, manager.UpdateDevice(devices[0]))
+
+	// Status updates are not edits
+	require.NoError(t, manager.UpdateDeviceStatus(devices[1].ID, string(StatusOnline), time.Now()))
+
+	recent, err := manager.GetRecentlyChangedDevices(2)
+	require.NoError(t, err)
+	require.Len(t, recent, 2)
+	assert.Equal(t, devices[0].ID, recent[0].ID)
+	assert.Equal(t, devices[2].ID, recent[1].ID)
+
+	all, err := manager.GetRecentlyChangedDevices(0)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+}
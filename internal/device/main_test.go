@@ -0,0 +1,111 @@
+package device
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/database"
+)
+
+// benchDeviceCount is the fleet size the scale benchmarks run against
+const benchDeviceCount = 10000
+
+// benchDBPath is the seeded scale database, or empty when benchmarks are not
+// running or -short is set. The file lives in the OS temp directory and is
+// reused by later runs as long as it holds the expected fleet and was
+// built at the current schema version.
+var benchDBPath string
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	if bench := flag.Lookup("test.bench"); bench != nil && bench.Value.String() != "" && !testing.Short() {
+		version := database.LatestSchemaVersion()
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("invictux-device-bench-%d-v%d.db", benchDeviceCount, version))
+		if err := ensureBenchDB(path, version); err != nil {
+			log.Printf("Skipping scale benchmarks: %v", err)
+		} else {
+			benchDBPath = path
+		}
+	}
+
+	os.Exit(m.Run())
+}
+
+// ensureBenchDB creates the scale database at schema version unless a
+// complete one built at that version exists
+func ensureBenchDB(path string, version int) error {
+	if db, err := sql.Open("sqlite3", path); err == nil {
+		var count, built int
+		err = db.QueryRow("PRAGMA user_version").Scan(&built)
+		if err == nil {
+			err = db.QueryRow("SELECT COUNT(*) FROM devices").Scan(&count)
+		}
+		db.Close()
+		if err == nil && built == version && count == benchDeviceCount {
+			return nil
+		}
+	}
+
+	os.Remove(path)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(testDeviceSchema); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO devices (id, name, ip_address, device_type, vendor, username,
+			password_encrypted, ssh_port, snmp_community, tags, created_at, updated_at, status, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	vendors := ValidVendors()
+	types := ValidDeviceTypes()
+	statuses := []DeviceStatus{StatusOnline, StatusOffline, StatusWarning, StatusError}
+	base := time.Now().Add(-benchDeviceCount * time.Minute)
+
+	for i := 0; i < benchDeviceCount; i++ {
+		vendor := vendors[i%len(vendors)]
+		deviceType := types[i%len(types)]
+		createdAt := base.Add(time.Duration(i) * time.Minute)
+
+		if _, err := stmt.Exec(
+			fmt.Sprintf("bench-%05d", i),
+			fmt.Sprintf("%s-%s-%05d", vendor, deviceType, i),
+			fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256),
+			string(deviceType), string(vendor), "admin", []byte("encrypted_password"),
+			22, "", fmt.Sprintf("site-%d,%s", i%50, deviceType),
+			createdAt, createdAt, string(statuses[i%len(statuses)]), 1,
+		); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Stamped last, so an interrupted build is not reused
+	_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	return err
+}
@@ -0,0 +1,186 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxMaintenanceWindows caps the windows a single device can hold
+const MaxMaintenanceWindows = 50
+
+// MaintenanceWindow is a weekly period in which a device is under
+// maintenance. DayOfWeek follows time.Weekday (0 is Sunday) and the window
+// covers the hours from StartHour up to, but not including, EndHour in local
+// time. A window whose EndHour is before its StartHour runs past midnight
+// into the next day.
+type MaintenanceWindow struct {
+	DayOfWeek int `json:"dayOfWeek"`
+	StartHour int `json:"startHour"`
+	EndHour   int `json:"endHour"`
+}
+
+// Validate checks that the window names a real day and hour range
+func (w MaintenanceWindow) Validate() error {
+	if w.DayOfWeek < 0 || w.DayOfWeek > 6 {
+		return ValidationError{Field: "maintenanceWindows", Message: fmt.Sprintf("day of week %d must be between 0 and 6", w.DayOfWeek)}
+	}
+	if w.StartHour < 0 || w.StartHour > 23 {
+		return ValidationError{Field: "maintenanceWindows", Message: fmt.Sprintf("start hour %d must be between 0 and 23", w.StartHour)}
+	}
+	if w.EndHour < 0 || w.EndHour > 24 {
+		return ValidationError{Field: "maintenanceWindows", Message: fmt.Sprintf("end hour %d must be between 0 and 24", w.EndHour)}
+	}
+	if w.StartHour == w.EndHour {
+		return ValidationError{Field: "maintenanceWindows", Message: "start and end hour cannot be equal"}
+	}
+	return nil
+}
+
+// Contains reports whether t falls inside the window
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	day, hour := int(t.Weekday()), t.Hour()
+	if w.StartHour < w.EndHour {
+		return day == w.DayOfWeek && hour >= w.StartHour && hour < w.EndHour
+	}
+
+	// The window wraps past midnight
+	if day == w.DayOfWeek && hour >= w.StartHour {
+		return true
+	}
+	return day == (w.DayOfWeek+1)%7 && hour < w.EndHour
+}
+
+// ValidateMaintenanceWindows validates a device's maintenance windows
+func ValidateMaintenanceWindows(windows []MaintenanceWindow) error {
+	if len(windows) > MaxMaintenanceWindows {
+		return ValidationError{Field: "maintenanceWindows", Message: fmt.Sprintf("cannot exceed %d maintenance windows", MaxMaintenanceWindows)}
+	}
+	for _, window := range windows {
+		if err := window.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsInMaintenance reports whether the device is inside one of its
+// maintenance windows right now
+func (d *Device) IsInMaintenance() bool {
+	return d.InMaintenanceAt(time.Now())
+}
+
+// InMaintenanceAt reports whether t falls inside one of the device's
+// maintenance windows
+func (d *Device) InMaintenanceAt(t time.Time) bool {
+	for _, window := range d.MaintenanceWindows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeMaintenanceWindows returns the JSON stored in the
+// maintenance_windows column, or NULL when there are no windows
+func encodeMaintenanceWindows(windows []MaintenanceWindow) (interface{}, error) {
+	if len(windows) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(windows)
+	if err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "maintenanceWindows",
+			Message: fmt.Sprintf("failed to encode maintenance windows: %v", err),
+		}
+	}
+	return string(data), nil
+}
+
+// decodeMaintenanceWindows parses the maintenance_windows column
+func decodeMaintenanceWindows(value sql.NullString) ([]MaintenanceWindow, error) {
+	if !value.Valid || strings.TrimSpace(value.String) == "" {
+		return nil, nil
+	}
+	var windows []MaintenanceWindow
+	if err := json.Unmarshal([]byte(value.String), &windows); err != nil {
+		return nil, fmt.Errorf("invalid maintenance windows: %w", err)
+	}
+	return windows, nil
+}
+
+// SetMaintenanceWindows replaces the maintenance windows of a device. An
+// empty list clears them. The device version advances, so an edit started
+// before the change conflicts.
+func (m *Manager) SetMaintenanceWindows(id string, windows []MaintenanceWindow) error {
+	if strings.TrimSpace(id) == "" {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "id",
+			Message: "device ID cannot be empty",
+		}
+	}
+
+	if err := ValidateMaintenanceWindows(windows); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "maintenanceWindows",
+			Message: err.Error(),
+		}
+	}
+
+	encoded, err := encodeMaintenanceWindows(windows)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to begin transaction: %v", err),
+		}
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE devices SET maintenance_windows = ?, updated_at = ?, version = version + 1 WHERE id = ?`,
+		encoded, time.Now(), id)
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to update maintenance windows: %v", err),
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to get rows affected: %v", err),
+		}
+	}
+
+	if rowsAffected == 0 {
+		return &DeviceError{
+			Type:    ErrorTypeNotFound,
+			Message: fmt.Sprintf("device with ID %s not found", id),
+		}
+	}
+
+	if err = bumpDataVersion(context.Background(), tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to commit transaction: %v", err),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceWindow_Contains(t *testing.T) {
+	// 2024-06-03 is a Monday
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 6, 2+day, hour, 30, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name   string
+		window MaintenanceWindow
+		time   time.Time
+		want   bool
+	}{
+		{"inside", MaintenanceWindow{DayOfWeek: 1, StartHour: 2, EndHour: 4}, at(1, 3), true},
+		{"start hour included", MaintenanceWindow{DayOfWeek: 1, StartHour: 2, EndHour: 4}, at(1, 2), true},
+		{"end hour excluded", MaintenanceWindow{DayOfWeek: 1, StartHour: 2, EndHour: 4}, at(1, 4), false},
+		{"other day", MaintenanceWindow{DayOfWeek: 1, StartHour: 2, EndHour: 4}, at(2, 3), false},
+		{"until midnight", MaintenanceWindow{DayOfWeek: 1, StartHour: 20, EndHour: 24}, at(1, 23), true},
+		{"wraps before midnight", MaintenanceWindow{DayOfWeek: 6, StartHour: 22, EndHour: 2}, at(6, 23), true},
+		{"wraps after midnight", MaintenanceWindow{DayOfWeek: 6, StartHour: 22, EndHour: 2}, at(7, 1), true},
+		{"wraps ends", MaintenanceWindow{DayOfWeek: 6, StartHour: 22, EndHour: 2}, at(7, 2), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.window.Contains(tt.time))
+		})
+	}
+}
+
+func TestMaintenanceWindow_Validate(t *testing.T) {
+	assert.NoError(t, MaintenanceWindow{DayOfWeek: 0, StartHour: 0, EndHour: 24}.Validate())
+	assert.NoError(t, MaintenanceWindow{DayOfWeek: 6, StartHour: 23, EndHour: 1}.Validate())
+	assert.Error(t, MaintenanceWindow{DayOfWeek: 7, StartHour: 1, EndHour: 2}.Validate())
+	assert.Error(t, MaintenanceWindow{DayOfWeek: 1, StartHour: 24, EndHour: 2}.Validate())
+	assert.Error(t, MaintenanceWindow{DayOfWeek: 1, StartHour: 1, EndHour: 25}.Validate())
+	assert.Error(t, MaintenanceWindow{DayOfWeek: 1, StartHour: 3, EndHour: 3}.Validate())
+}
+
+func TestManager_SetMaintenanceWindows(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	device := createTestDevice()
+	require.NoError(t, manager.AddDevice(device))
+
+	stored, err := manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.MaintenanceWindows)
+	assert.False(t, stored.IsInMaintenance())
+
+	now := time.Now()
+	windows := []MaintenanceWindow{{DayOfWeek: int(now.Weekday()), StartHour: now.Hour(), EndHour: (now.Hour() + 2) % 24}}
+	require.NoError(t, manager.SetMaintenanceWindows(device.ID, windows))
+
+	stored, err = manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Equal(t, windows, stored.MaintenanceWindows)
+	assert.Equal(t, device.Version+1, stored.Version)
+	assert.True(t, stored.IsInMaintenance())
+
+	// Other updates keep the windows
+	stored.Tags = "core"
+	require.NoError(t, manager.UpdateDevice(stored))
+	stored, err = manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Equal(t, windows, stored.MaintenanceWindows)
+
+	require.NoError(t, manager.SetMaintenanceWindows(device.ID, nil))
+	stored, err = manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.MaintenanceWindows)
+
+	err = manager.SetMaintenanceWindows(device.ID, []MaintenanceWindow{{DayOfWeek: 9, StartHour: 1, EndHour: 2}})
+	deviceErr, ok := err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+
+	err = manager.SetMaintenanceWindows("missing", windows)
+	deviceErr, ok = err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeNotFound, deviceErr.Type)
+}
@@ -1,9 +1,12 @@
 package device
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,6 +16,16 @@ import (
 // Manager handles device CRUD operations
 type Manager struct {
 	db *sql.DB
+
+	// cache holds the last device list read together with its data version
+	cacheMutex sync.Mutex
+	cache      *deviceListCache
+
+	// listQueries counts full device list scans
+	listQueries atomic.Int64
+
+	// fullTextSearch is set when the FTS5 device index is available
+	fullTextSearch bool
 }
 
 // ManagerInterface defines the interface for device management operations
@@ -23,7 +36,29 @@ type ManagerInterface interface {
 	GetDeviceByIP(ipAddress string) (*Device, error)
 	UpdateDevice(device *Device) error
 	DeleteDevice(id string) error
+	UpdateDeviceStatus(id, status string, checkedAt time.Time) error
+	UpdateDeviceCredentials(id string, passwordEncrypted []byte) error
+	SetMaintenanceWindows(id string, windows []MaintenanceWindow) error
+	GetDeviceStats() (*DeviceStats, error)
+	GetDevicesIfChanged(clientToken string) (*DeviceListResponse, error)
+	GetDevicesPage(cursor string, limit int) (*DevicePage, error)
+	GetRecentlyChangedDevices(limit int) ([]Device, error)
+	SearchDevices(req DeviceSearchRequest) (*DevicePage, error)
+	ImportFromDatabase(srcPath string, reencrypt func([]byte) ([]byte, error)) (int, error)
+	ImportFromNetBox(apiURL, apiToken string, filter DeviceFilter) (*ImportResult, error)
+	ImportDevices(devices []Device) (*ImportResult, error)
 	TestConnectivity(device *Device) error
+
+	// Context variants stop their database work when ctx ends
+	AddDeviceContext(ctx context.Context, device *Device) error
+	GetAllDevicesContext(ctx context.Context) ([]Device, error)
+	GetDeviceContext(ctx context.Context, id string) (*Device, error)
+	GetDeviceByIPContext(ctx context.Context, ipAddress string) (*Device, error)
+	UpdateDeviceContext(ctx context.Context, device *Device) error
+	DeleteDeviceContext(ctx context.Context, id string) error
+	UpdateDeviceStatusContext(ctx context.Context, id, status string, checkedAt time.Time) error
+	UpdateDeviceCredentialsContext(ctx context.Context, id string, passwordEncrypted []byte) error
+	GetDeviceStatsContext(ctx context.Context) (*DeviceStats, error)
 }
 
 // DeviceError represents device-specific errors
@@ -31,6 +66,9 @@ type DeviceError struct {
 	Type    string
 	Message string
 	Field   string
+
+	// Current holds the stored device when Type is ErrorTypeConflict
+	Current *Device
 }
 
 func (e *DeviceError) Error() string {
@@ -46,15 +84,71 @@ const (
 	ErrorTypeDuplicate  = "duplicate"
 	ErrorTypeNotFound   = "not_found"
 	ErrorTypeDatabase   = "database"
+	ErrorTypeConflict   = "conflict"
 )
 
+// deviceColumns lists the devices columns in the order scanned by scanDevice
+const deviceColumns = `id, name, ip_address, device_type, vendor, username,
+			password_encrypted, ssh_port, snmp_community, tags, created_at, updated_at,
+			status, last_checked, version, maintenance_windows, output_charset, is_sandbox,
+			host_key_policy, host_key_fingerprint, keyboard_responses, model, os_version`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDevice scans a device selected with deviceColumns
+func scanDevice(scanner rowScanner) (Device, error) {
+	var device Device
+	var status sql.NullString
+	var lastChecked sql.NullTime
+	var windows sql.NullString
+	var keyboard sql.NullString
+
+	err := scanner.Scan(&device.ID, &device.Name, &device.IPAddress,
+		&device.DeviceType, &device.Vendor, &device.Username,
+		&device.PasswordEncrypted, &device.SSHPort, &device.SNMPCommunity,
+		&device.Tags, &device.CreatedAt, &device.UpdatedAt,
+		&status, &lastChecked, &device.Version, &windows, &device.OutputCharset, &device.IsSandbox,
+		&device.HostKeyPolicy, &device.HostKeyFingerprint, &keyboard, &device.Model, &device.OSVersion)
+	if err != nil {
+		return device, err
+	}
+
+	if device.MaintenanceWindows, err = decodeMaintenanceWindows(windows); err != nil {
+		return device, err
+	}
+	if device.KeyboardResponses, err = decodeKeyboardResponses(keyboard); err != nil {
+		return device, err
+	}
+
+	device.Status = status.String
+	if device.Status == "" {
+		device.Status = string(StatusOffline)
+	}
+	if lastChecked.Valid {
+		checked := lastChecked.Time
+		device.LastChecked = &checked
+	}
+
+	return device, nil
+}
+
 // NewManager creates a new device manager
 func NewManager(db *sql.DB) *Manager {
-	return &Manager{db: db}
+	m := &Manager{db: db}
+	m.initSearchIndex()
+	return m
 }
 
 // AddDevice adds a new network device with proper validation and duplicate checking
 func (m *Manager) AddDevice(device *Device) error {
+	return m.AddDeviceContext(context.Background(), device)
+}
+
+// AddDeviceContext is AddDevice, stopping when ctx ends
+func (m *Manager) AddDeviceContext(ctx context.Context, device *Device) error {
 	// Validate the device
 	if err := device.Validate(); err != nil {
 		return &DeviceError{
@@ -68,9 +162,10 @@ func (m *Manager) AddDevice(device *Device) error {
 	device.ID = uuid.New().String()
 	device.CreatedAt = time.Now()
 	device.UpdatedAt = time.Now()
+	device.Version = 1
 
 	// Start transaction for atomic operation
-	tx, err := m.db.Begin()
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &DeviceError{
 			Type:    ErrorTypeDatabase,
@@ -82,7 +177,7 @@ func (m *Manager) AddDevice(device *Device) error {
 	// Check for duplicate IP address
 	var existingID string
 	checkQuery := `SELECT id FROM devices WHERE ip_address = ?`
-	err = tx.QueryRow(checkQuery, device.IPAddress).Scan(&existingID)
+	err = tx.QueryRowContext(ctx, checkQuery, device.IPAddress).Scan(&existingID)
 	if err == nil {
 		return &DeviceError{
 			Type:    ErrorTypeDuplicate,
@@ -96,16 +191,29 @@ func (m *Manager) AddDevice(device *Device) error {
 		}
 	}
 
+	windows, err := encodeMaintenanceWindows(device.MaintenanceWindows)
+	if err != nil {
+		return err
+	}
+	keyboard, err := encodeKeyboardResponses(device.KeyboardResponses)
+	if err != nil {
+		return err
+	}
+
 	// Insert the device
 	insertQuery := `
 		INSERT INTO devices (id, name, ip_address, device_type, vendor, username, 
-			password_encrypted, ssh_port, snmp_community, tags, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			password_encrypted, ssh_port, snmp_community, tags, created_at, updated_at, status, version,
+			maintenance_windows, output_charset, is_sandbox, host_key_policy, host_key_fingerprint, keyboard_responses,
+			model, os_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err = tx.Exec(insertQuery, device.ID, device.Name, device.IPAddress,
+	_, err = tx.ExecContext(ctx, insertQuery, device.ID, device.Name, device.IPAddress,
 		device.DeviceType, device.Vendor, device.Username, device.PasswordEncrypted,
-		device.SSHPort, device.SNMPCommunity, device.Tags, device.CreatedAt, device.UpdatedAt)
+		device.SSHPort, device.SNMPCommunity, device.Tags, device.CreatedAt, device.UpdatedAt,
+		device.Status, device.Version, windows, device.OutputCharset, device.IsSandbox,
+		device.HostKeyPolicy, device.HostKeyFingerprint, keyboard, device.Model, device.OSVersion)
 
 	if err != nil {
 		// Check if it's a SQLite constraint error
@@ -124,6 +232,10 @@ func (m *Manager) AddDevice(device *Device) error {
 		}
 	}
 
+	if err = bumpDataVersion(ctx, tx); err != nil {
+		return err
+	}
+
 	// Commit the transaction
 	if err = tx.Commit(); err != nil {
 		return &DeviceError{
@@ -137,14 +249,19 @@ func (m *Manager) AddDevice(device *Device) error {
 
 // GetAllDevices retrieves all devices with proper error handling
 func (m *Manager) GetAllDevices() ([]Device, error) {
+	return m.GetAllDevicesContext(context.Background())
+}
+
+// GetAllDevicesContext is GetAllDevices, stopping when ctx ends
+func (m *Manager) GetAllDevicesContext(ctx context.Context) ([]Device, error) {
 	query := `
-		SELECT id, name, ip_address, device_type, vendor, username, 
-			password_encrypted, ssh_port, snmp_community, tags, created_at, updated_at
+		SELECT ` + deviceColumns + `
 		FROM devices
 		ORDER BY created_at DESC
 	`
 
-	rows, err := m.db.Query(query)
+	m.listQueries.Add(1)
+	rows, err := m.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, &DeviceError{
 			Type:    ErrorTypeDatabase,
@@ -155,11 +272,7 @@ func (m *Manager) GetAllDevices() ([]Device, error) {
 
 	var devices []Device
 	for rows.Next() {
-		var device Device
-		err := rows.Scan(&device.ID, &device.Name, &device.IPAddress,
-			&device.DeviceType, &device.Vendor, &device.Username,
-			&device.PasswordEncrypted, &device.SSHPort, &device.SNMPCommunity,
-			&device.Tags, &device.CreatedAt, &device.UpdatedAt)
+		device, err := scanDevice(rows)
 		if err != nil {
 			return nil, &DeviceError{
 				Type:    ErrorTypeDatabase,
@@ -182,6 +295,11 @@ func (m *Manager) GetAllDevices() ([]Device, error) {
 
 // GetDevice retrieves a device by ID with proper error handling
 func (m *Manager) GetDevice(id string) (*Device, error) {
+	return m.GetDeviceContext(context.Background(), id)
+}
+
+// GetDeviceContext is GetDevice, stopping when ctx ends
+func (m *Manager) GetDeviceContext(ctx context.Context, id string) (*Device, error) {
 	if strings.TrimSpace(id) == "" {
 		return nil, &DeviceError{
 			Type:    ErrorTypeValidation,
@@ -191,17 +309,12 @@ func (m *Manager) GetDevice(id string) (*Device, error) {
 	}
 
 	query := `
-		SELECT id, name, ip_address, device_type, vendor, username, 
-			password_encrypted, ssh_port, snmp_community, tags, created_at, updated_at
+		SELECT ` + deviceColumns + `
 		FROM devices
 		WHERE id = ?
 	`
 
-	var device Device
-	err := m.db.QueryRow(query, id).Scan(&device.ID, &device.Name, &device.IPAddress,
-		&device.DeviceType, &device.Vendor, &device.Username,
-		&device.PasswordEncrypted, &device.SSHPort, &device.SNMPCommunity,
-		&device.Tags, &device.CreatedAt, &device.UpdatedAt)
+	device, err := scanDevice(m.db.QueryRowContext(ctx, query, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -221,6 +334,11 @@ func (m *Manager) GetDevice(id string) (*Device, error) {
 
 // GetDeviceByIP retrieves a device by IP address
 func (m *Manager) GetDeviceByIP(ipAddress string) (*Device, error) {
+	return m.GetDeviceByIPContext(context.Background(), ipAddress)
+}
+
+// GetDeviceByIPContext is GetDeviceByIP, stopping when ctx ends
+func (m *Manager) GetDeviceByIPContext(ctx context.Context, ipAddress string) (*Device, error) {
 	if strings.TrimSpace(ipAddress) == "" {
 		return nil, &DeviceError{
 			Type:    ErrorTypeValidation,
@@ -230,17 +348,12 @@ func (m *Manager) GetDeviceByIP(ipAddress string) (*Device, error) {
 	}
 
 	query := `
-		SELECT id, name, ip_address, device_type, vendor, username, 
-			password_encrypted, ssh_port, snmp_community, tags, created_at, updated_at
+		SELECT ` + deviceColumns + `
 		FROM devices
 		WHERE ip_address = ?
 	`
 
-	var device Device
-	err := m.db.QueryRow(query, ipAddress).Scan(&device.ID, &device.Name, &device.IPAddress,
-		&device.DeviceType, &device.Vendor, &device.Username,
-		&device.PasswordEncrypted, &device.SSHPort, &device.SNMPCommunity,
-		&device.Tags, &device.CreatedAt, &device.UpdatedAt)
+	device, err := scanDevice(m.db.QueryRowContext(ctx, query, ipAddress))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -258,8 +371,16 @@ func (m *Manager) GetDeviceByIP(ipAddress string) (*Device, error) {
 	return &device, nil
 }
 
-// UpdateDevice updates an existing device with proper validation and duplicate checking
+// UpdateDevice updates an existing device with proper validation and duplicate checking.
+// The device must carry the version it was loaded with; if the stored version has
+// advanced since, the update fails with an ErrorTypeConflict error holding the
+// stored device. On success the version is incremented.
 func (m *Manager) UpdateDevice(device *Device) error {
+	return m.UpdateDeviceContext(context.Background(), device)
+}
+
+// UpdateDeviceContext is UpdateDevice, stopping when ctx ends
+func (m *Manager) UpdateDeviceContext(ctx context.Context, device *Device) error {
 	if strings.TrimSpace(device.ID) == "" {
 		return &DeviceError{
 			Type:    ErrorTypeValidation,
@@ -279,7 +400,7 @@ func (m *Manager) UpdateDevice(device *Device) error {
 	device.UpdateTimestamp()
 
 	// Start transaction for atomic operation
-	tx, err := m.db.Begin()
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &DeviceError{
 			Type:    ErrorTypeDatabase,
@@ -288,10 +409,8 @@ func (m *Manager) UpdateDevice(device *Device) error {
 	}
 	defer tx.Rollback()
 
-	// Check if device exists
-	var existingID string
-	checkExistsQuery := `SELECT id FROM devices WHERE id = ?`
-	err = tx.QueryRow(checkExistsQuery, device.ID).Scan(&existingID)
+	// Check if device exists and load it for the version check
+	current, err := scanDevice(tx.QueryRowContext(ctx, `SELECT `+deviceColumns+` FROM devices WHERE id = ?`, device.ID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return &DeviceError{
@@ -305,10 +424,22 @@ func (m *Manager) UpdateDevice(device *Device) error {
 		}
 	}
 
+	if device.Version < 1 {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "version",
+			Message: "device version is required for updates",
+		}
+	}
+
+	if current.Version != device.Version {
+		return newConflictError(&current, device.Version)
+	}
+
 	// Check for duplicate IP address (excluding current device)
 	var duplicateID string
 	checkDuplicateQuery := `SELECT id FROM devices WHERE ip_address = ? AND id != ?`
-	err = tx.QueryRow(checkDuplicateQuery, device.IPAddress, device.ID).Scan(&duplicateID)
+	err = tx.QueryRowContext(ctx, checkDuplicateQuery, device.IPAddress, device.ID).Scan(&duplicateID)
 	if err == nil {
 		return &DeviceError{
 			Type:    ErrorTypeDuplicate,
@@ -322,17 +453,19 @@ func (m *Manager) UpdateDevice(device *Device) error {
 		}
 	}
 
-	// Update the device
+	// Update the device, guarding on the version so a concurrent write cannot be lost
 	updateQuery := `
 		UPDATE devices 
 		SET name = ?, ip_address = ?, device_type = ?, vendor = ?, username = ?,
-			password_encrypted = ?, ssh_port = ?, snmp_community = ?, tags = ?, updated_at = ?
-		WHERE id = ?
+			password_encrypted = ?, ssh_port = ?, snmp_community = ?, tags = ?, output_charset = ?,
+			is_sandbox = ?, host_key_policy = ?, host_key_fingerprint = ?, updated_at = ?, version = version + 1
+		WHERE id = ? AND version = ?
 	`
 
-	result, err := tx.Exec(updateQuery, device.Name, device.IPAddress, device.DeviceType,
+	result, err := tx.ExecContext(ctx, updateQuery, device.Name, device.IPAddress, device.DeviceType,
 		device.Vendor, device.Username, device.PasswordEncrypted, device.SSHPort,
-		device.SNMPCommunity, device.Tags, device.UpdatedAt, device.ID)
+		device.SNMPCommunity, device.Tags, device.OutputCharset, device.IsSandbox, device.HostKeyPolicy,
+		device.HostKeyFingerprint, device.UpdatedAt, device.ID, device.Version)
 
 	if err != nil {
 		// Check if it's a SQLite constraint error
@@ -361,10 +494,11 @@ func (m *Manager) UpdateDevice(device *Device) error {
 	}
 
 	if rowsAffected == 0 {
-		return &DeviceError{
-			Type:    ErrorTypeNotFound,
-			Message: fmt.Sprintf("device with ID %s not found", device.ID),
-		}
+		return newConflictError(&current, device.Version)
+	}
+
+	if err = bumpDataVersion(ctx, tx); err != nil {
+		return err
 	}
 
 	// Commit the transaction
@@ -375,11 +509,27 @@ func (m *Manager) UpdateDevice(device *Device) error {
 		}
 	}
 
+	device.Version++
 	return nil
 }
 
+// newConflictError reports that a device was modified since the caller loaded it
+func newConflictError(current *Device, version int) *DeviceError {
+	return &DeviceError{
+		Type:    ErrorTypeConflict,
+		Field:   "version",
+		Message: fmt.Sprintf("device %s was modified by someone else (version %d, now %d)", current.ID, version, current.Version),
+		Current: current,
+	}
+}
+
 // DeleteDevice removes a device with proper error handling and transaction support
 func (m *Manager) DeleteDevice(id string) error {
+	return m.DeleteDeviceContext(context.Background(), id)
+}
+
+// DeleteDeviceContext is DeleteDevice, stopping when ctx ends
+func (m *Manager) DeleteDeviceContext(ctx context.Context, id string) error {
 	if strings.TrimSpace(id) == "" {
 		return &DeviceError{
 			Type:    ErrorTypeValidation,
@@ -389,7 +539,7 @@ func (m *Manager) DeleteDevice(id string) error {
 	}
 
 	// Start transaction for atomic operation
-	tx, err := m.db.Begin()
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &DeviceError{
 			Type:    ErrorTypeDatabase,
@@ -400,7 +550,7 @@ func (m *Manager) DeleteDevice(id string) error {
 
 	// Delete the device (CASCADE will handle related records)
 	deleteQuery := `DELETE FROM devices WHERE id = ?`
-	result, err := tx.Exec(deleteQuery, id)
+	result, err := tx.ExecContext(ctx, deleteQuery, id)
 	if err != nil {
 		return &DeviceError{
 			Type:    ErrorTypeDatabase,
@@ -423,6 +573,10 @@ func (m *Manager) DeleteDevice(id string) error {
 		}
 	}
 
+	if err = bumpDataVersion(ctx, tx); err != nil {
+		return err
+	}
+
 	// Commit the transaction
 	if err = tx.Commit(); err != nil {
 		return &DeviceError{
@@ -434,7 +588,416 @@ func (m *Manager) DeleteDevice(id string) error {
 	return nil
 }
 
-// TestConnectivity tests the connectivity to a device using the connectivity scanner
+// UpdateDeviceStatus records the connectivity status of a device. Only the
+// status columns are written, so it never conflicts with user edits and does
+// not advance the device version.
+func (m *Manager) UpdateDeviceStatus(id, status string, checkedAt time.Time) error {
+	return m.UpdateDeviceStatusContext(context.Background(), id, status, checkedAt)
+}
+
+// UpdateDeviceStatusContext is UpdateDeviceStatus, stopping when ctx ends
+func (m *Manager) UpdateDeviceStatusContext(ctx context.Context, id, status string, checkedAt time.Time) error {
+	if strings.TrimSpace(id) == "" {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "id",
+			Message: "device ID cannot be empty",
+		}
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to begin transaction: %v", err),
+		}
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE devices SET status = ?, last_checked = ? WHERE id = ?`, status, checkedAt, id)
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to update device status: %v", err),
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to get rows affected: %v", err),
+		}
+	}
+
+	if rowsAffected == 0 {
+		return &DeviceError{
+			Type:    ErrorTypeNotFound,
+			Message: fmt.Sprintf("device with ID %s not found", id),
+		}
+	}
+
+	if err = bumpDataVersion(ctx, tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to commit transaction: %v", err),
+		}
+	}
+
+	return nil
+}
+
+// MaxDeviceMetadataLength caps the model and OS version stored on a device
+const MaxDeviceMetadataLength = 100
+
+// UpdateDeviceMetadata records the model and OS version a device reported.
+// They describe the device rather than its settings, so like the status the
+// device version does not advance.
+func (m *Manager) UpdateDeviceMetadata(id, model, version string) error {
+	if strings.TrimSpace(id) == "" {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "id",
+			Message: "device ID cannot be empty",
+		}
+	}
+
+	model, version = strings.TrimSpace(model), strings.TrimSpace(version)
+	if len(model) > MaxDeviceMetadataLength {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "model",
+			Message: fmt.Sprintf("model cannot exceed %d characters", MaxDeviceMetadataLength),
+		}
+	}
+	if len(version) > MaxDeviceMetadataLength {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "osVersion",
+			Message: fmt.Sprintf("OS version cannot exceed %d characters", MaxDeviceMetadataLength),
+		}
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to begin transaction: %v", err),
+		}
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE devices SET model = ?, os_version = ? WHERE id = ?`, model, version, id)
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to update device metadata: %v", err),
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to get rows affected: %v", err),
+		}
+	}
+	if rowsAffected == 0 {
+		return &DeviceError{
+			Type:    ErrorTypeNotFound,
+			Message: fmt.Sprintf("device with ID %s not found", id),
+		}
+	}
+
+	if err = bumpDataVersion(context.Background(), tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to commit transaction: %v", err),
+		}
+	}
+
+	return nil
+}
+
+// UpdateDeviceCredentials replaces the stored encrypted password of a device.
+// The device version advances, so an edit started before the change conflicts.
+func (m *Manager) UpdateDeviceCredentials(id string, passwordEncrypted []byte) error {
+	return m.UpdateDeviceCredentialsContext(context.Background(), id, passwordEncrypted)
+}
+
+// UpdateDeviceCredentialsContext is UpdateDeviceCredentials, stopping when ctx ends
+func (m *Manager) UpdateDeviceCredentialsContext(ctx context.Context, id string, passwordEncrypted []byte) error {
+	if strings.TrimSpace(id) == "" {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "id",
+			Message: "device ID cannot be empty",
+		}
+	}
+
+	if len(passwordEncrypted) == 0 {
+		return &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "passwordEncrypted",
+			Message: "encrypted password cannot be empty",
+		}
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to begin transaction: %v", err),
+		}
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE devices SET password_encrypted = ?, updated_at = ?, version = version + 1 WHERE id = ?`,
+		passwordEncrypted, time.Now(), id)
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to update device credentials: %v", err),
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to get rows affected: %v", err),
+		}
+	}
+
+	if rowsAffected == 0 {
+		return &DeviceError{
+			Type:    ErrorTypeNotFound,
+			Message: fmt.Sprintf("device with ID %s not found", id),
+		}
+	}
+
+	if err = bumpDataVersion(ctx, tx); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to commit transaction: %v", err),
+		}
+	}
+
+	return nil
+}
+
+// ReencryptFunc returns a stored secret encrypted again, or changed false
+// to keep the secret as it is
+type ReencryptFunc func(ciphertext []byte) (updated []byte, changed bool)
+
+// ReencryptSecretsContext passes the encrypted password and keyboard
+// responses of every device to reencrypt and stores the ones it changes,
+// all in one transaction. It returns the number of devices changed. Device
+// versions do not advance, as the secrets still decrypt to the same values.
+func (m *Manager) ReencryptSecretsContext(ctx context.Context, reencrypt ReencryptFunc) (int, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to begin transaction: %v", err),
+		}
+	}
+	defer tx.Rollback()
+
+	type deviceSecrets struct {
+		id        string
+		password  []byte
+		responses sql.NullString
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, password_encrypted, keyboard_responses FROM devices`)
+	if err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to query device secrets: %v", err),
+		}
+	}
+	var devices []deviceSecrets
+	for rows.Next() {
+		var secrets deviceSecrets
+		if err := rows.Scan(&secrets.id, &secrets.password, &secrets.responses); err != nil {
+			rows.Close()
+			return 0, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to scan device secrets: %v", err),
+			}
+		}
+		devices = append(devices, secrets)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("error iterating over device secrets: %v", err),
+		}
+	}
+
+	changed := 0
+	for _, secrets := range devices {
+		password, passwordChanged := reencrypt(secrets.password)
+
+		responses, err := decodeKeyboardResponses(secrets.responses)
+		if err != nil {
+			return 0, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("device %s: %v", secrets.id, err),
+			}
+		}
+		responsesChanged := false
+		for i := range responses {
+			if updated, ok := reencrypt(responses[i].ResponseEncrypted); ok {
+				responses[i].ResponseEncrypted = updated
+				responsesChanged = true
+			}
+		}
+		if !passwordChanged && !responsesChanged {
+			continue
+		}
+
+		encoded, err := encodeKeyboardResponses(responses)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE devices SET password_encrypted = ?, keyboard_responses = ? WHERE id = ?`,
+			password, encoded, secrets.id); err != nil {
+			return 0, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to update device secrets: %v", err),
+			}
+		}
+		changed++
+	}
+
+	if changed > 0 {
+		if err := bumpDataVersion(ctx, tx); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to commit transaction: %v", err),
+		}
+	}
+	return changed, nil
+}
+
+// GetDeviceStats returns aggregate device counts by type, vendor, status and
+// age, leaving sandbox devices out of everything but their own count
+func (m *Manager) GetDeviceStats() (*DeviceStats, error) {
+	return m.GetDeviceStatsContext(context.Background())
+}
+
+// GetDeviceStatsContext is GetDeviceStats, stopping when ctx ends
+func (m *Manager) GetDeviceStatsContext(ctx context.Context) (*DeviceStats, error) {
+	stats := &DeviceStats{
+		ByType:   make(map[string]int),
+		ByVendor: make(map[string]int),
+		ByStatus: make(map[string]int),
+	}
+
+	now := time.Now()
+	totalsQuery := `
+		SELECT COUNT(CASE WHEN is_sandbox = 0 THEN 1 END),
+			COUNT(CASE WHEN is_sandbox = 0 AND created_at > ? THEN 1 END),
+			COUNT(CASE WHEN is_sandbox = 0 AND created_at > ? THEN 1 END),
+			COUNT(CASE WHEN is_sandbox = 1 THEN 1 END)
+		FROM devices
+	`
+
+	err := m.db.QueryRowContext(ctx, totalsQuery, now.AddDate(0, 0, -7), now.AddDate(0, 0, -30)).
+		Scan(&stats.TotalCount, &stats.AddedLast7Days, &stats.AddedLast30Days, &stats.SandboxCount)
+	if err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to count devices: %v", err),
+		}
+	}
+
+	// One round-trip for all breakdowns; devices never checked count as offline
+	breakdownQuery := `
+		SELECT 'type', device_type, COUNT(*) FROM devices WHERE is_sandbox = 0 GROUP BY device_type
+		UNION ALL
+		SELECT 'vendor', vendor, COUNT(*) FROM devices WHERE is_sandbox = 0 GROUP BY vendor
+		UNION ALL
+		SELECT 'status', COALESCE(NULLIF(status, ''), ?), COUNT(*) FROM devices WHERE is_sandbox = 0
+			GROUP BY COALESCE(NULLIF(status, ''), ?)
+	`
+
+	rows, err := m.db.QueryContext(ctx, breakdownQuery, string(StatusOffline), string(StatusOffline))
+	if err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to query device breakdown: %v", err),
+		}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dimension, key string
+		var count int
+		if err := rows.Scan(&dimension, &key, &count); err != nil {
+			return nil, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to scan device breakdown: %v", err),
+			}
+		}
+
+		switch dimension {
+		case "type":
+			stats.ByType[key] = count
+		case "vendor":
+			stats.ByVendor[key] = count
+		case "status":
+			stats.ByStatus[key] = count
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("error iterating over device breakdown: %v", err),
+		}
+	}
+
+	return stats, nil
+}
+
+// recordConnectivity stamps the device as checked now and stores its status
+func (m *Manager) recordConnectivity(device *Device) error {
+	now := time.Now()
+	device.LastChecked = &now
+
+	// Only update the device in the database if it has an ID (i.e., it's already persisted)
+	if device.ID != "" {
+		if err := m.UpdateDeviceStatus(device.ID, device.Status, now); err != nil {
+			return &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to update device status: %v", err),
+			}
+		}
+	}
+	return nil
+}
+
+// TestConnectivity tests the connectivity to a device using the connectivity
+// scanner, preferring an SNMP poll for devices with an SNMP community
 func (m *Manager) TestConnectivity(device *Device) error {
 	if device == nil {
 		return &DeviceError{
@@ -453,6 +1016,19 @@ func (m *Manager) TestConnectivity(device *Device) error {
 	// Create a connectivity scanner
 	scanner := NewConnectivityScanner()
 
+	// An SNMP poll is lighter than the TCP probe and works with SSH
+	// disabled, so devices with a community are polled first. Devices that
+	// do not answer soon fall back to the probe, which tells an unreachable
+	// device from one whose SSH port is closed.
+	if device.SNMPCommunity != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), snmpStatusPollTimeout)
+		poll, err := scanner.SNMPPoll(ctx, device)
+		cancel()
+		if err == nil && poll.Reachable {
+			return m.recordConnectivity(device)
+		}
+	}
+
 	// Test connectivity
 	result, err := scanner.TestConnectivity(device)
 	if err != nil {
@@ -471,18 +1047,8 @@ func (m *Manager) TestConnectivity(device *Device) error {
 		device.Status = string(StatusOffline)
 	}
 
-	// Update the last checked timestamp
-	now := time.Now()
-	device.LastChecked = &now
-
-	// Only update the device in the database if it has an ID (i.e., it's already persisted)
-	if device.ID != "" {
-		if err := m.UpdateDevice(device); err != nil {
-			return &DeviceError{
-				Type:    ErrorTypeDatabase,
-				Message: fmt.Sprintf("failed to update device status: %v", err),
-			}
-		}
+	if err := m.recordConnectivity(device); err != nil {
+		return err
 	}
 
 	// Return error if connectivity test found issues
@@ -1,9 +1,12 @@
 package device
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +15,50 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// testDeviceSchema creates the tables the device manager uses
+const testDeviceSchema = `
+	CREATE TABLE devices (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		ip_address TEXT NOT NULL UNIQUE,
+		device_type TEXT NOT NULL,
+		vendor TEXT NOT NULL,
+		username TEXT NOT NULL,
+		password_encrypted BLOB NOT NULL,
+		ssh_port INTEGER DEFAULT 22,
+		snmp_community TEXT,
+		tags TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		status TEXT,
+		last_checked DATETIME,
+		version INTEGER NOT NULL DEFAULT 1,
+		maintenance_windows TEXT,
+		output_charset TEXT NOT NULL DEFAULT '',
+		is_sandbox BOOLEAN NOT NULL DEFAULT FALSE,
+		host_key_policy TEXT NOT NULL DEFAULT 'tofu',
+		host_key_fingerprint TEXT NOT NULL DEFAULT '',
+		keyboard_responses TEXT,
+		model TEXT NOT NULL DEFAULT '',
+		os_version TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE app_settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE device_connectivity (
+		device_id TEXT PRIMARY KEY,
+		network_reachable BOOLEAN NOT NULL DEFAULT FALSE,
+		ssh_port_open BOOLEAN NOT NULL DEFAULT FALSE,
+		response_time_ms INTEGER NOT NULL DEFAULT 0,
+		error_code TEXT NOT NULL DEFAULT '',
+		error_message TEXT NOT NULL DEFAULT '',
+		tested_at DATETIME,
+		FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+	);
+`
+
 // setupTestDB creates a test database for testing
 func setupTestDB(t *testing.T) *sql.DB {
 	// Create temporary directory for test database
@@ -21,24 +68,7 @@ func setupTestDB(t *testing.T) *sql.DB {
 	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=ON")
 	require.NoError(t, err)
 
-	// Create devices table
-	createTableSQL := `
-		CREATE TABLE devices (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			ip_address TEXT NOT NULL UNIQUE,
-			device_type TEXT NOT NULL,
-			vendor TEXT NOT NULL,
-			username TEXT NOT NULL,
-			password_encrypted BLOB NOT NULL,
-			ssh_port INTEGER DEFAULT 22,
-			snmp_community TEXT,
-			tags TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-	`
-	_, err = db.Exec(createTableSQL)
+	_, err = db.Exec(testDeviceSchema)
 	require.NoError(t, err)
 
 	return db
@@ -468,6 +498,250 @@ func TestManager_TestConnectivity(t *testing.T) {
 	})
 }
 
+func TestManager_UpdateDeviceOptimisticLocking(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	device := createTestDevice()
+	require.NoError(t, manager.AddDevice(device))
+	assert.Equal(t, 1, device.Version)
+
+	t.Run("interleaved updates conflict", func(t *testing.T) {
+		first, err := manager.GetDevice(device.ID)
+		require.NoError(t, err)
+		second, err := manager.GetDevice(device.ID)
+		require.NoError(t, err)
+
+		first.Tags = "first,editor"
+		require.NoError(t, manager.UpdateDevice(first))
+		assert.Equal(t, 2, first.Version)
+
+		second.Tags = "second,editor"
+		err = manager.UpdateDevice(second)
+		require.Error(t, err)
+
+		deviceErr, ok := err.(*DeviceError)
+		require.True(t, ok)
+		assert.Equal(t, ErrorTypeConflict, deviceErr.Type)
+		assert.Equal(t, "version", deviceErr.Field)
+		require.NotNil(t, deviceErr.Current)
+		assert.Equal(t, "first,editor", deviceErr.Current.Tags)
+		assert.Equal(t, 2, deviceErr.Current.Version)
+		assert.Equal(t, 1, second.Version)
+
+		// The first editor's change survives
+		stored, err := manager.GetDevice(device.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "first,editor", stored.Tags)
+
+		// Retrying with the current version succeeds
+		second.Version = deviceErr.Current.Version
+		require.NoError(t, manager.UpdateDevice(second))
+		assert.Equal(t, 3, second.Version)
+	})
+
+	t.Run("status updates never conflict", func(t *testing.T) {
+		loaded, err := manager.GetDevice(device.ID)
+		require.NoError(t, err)
+
+		require.NoError(t, manager.UpdateDeviceStatus(device.ID, string(StatusOnline), time.Now()))
+		require.NoError(t, manager.UpdateDeviceStatus(device.ID, string(StatusOffline), time.Now()))
+
+		stored, err := manager.GetDevice(device.ID)
+		require.NoError(t, err)
+		assert.Equal(t, loaded.Version, stored.Version)
+
+		loaded.Name = "Renamed Router"
+		require.NoError(t, manager.UpdateDevice(loaded))
+	})
+
+	t.Run("versions increment on every write", func(t *testing.T) {
+		loaded, err := manager.GetDevice(device.ID)
+		require.NoError(t, err)
+		start := loaded.Version
+
+		for i := 1; i <= 3; i++ {
+			loaded.Tags = fmt.Sprintf("write,%d", i)
+			require.NoError(t, manager.UpdateDevice(loaded))
+			assert.Equal(t, start+i, loaded.Version)
+		}
+
+		stored, err := manager.GetDevice(device.ID)
+		require.NoError(t, err)
+		assert.Equal(t, start+3, stored.Version)
+	})
+
+	t.Run("missing version", func(t *testing.T) {
+		loaded, err := manager.GetDevice(device.ID)
+		require.NoError(t, err)
+		loaded.Version = 0
+
+		err = manager.UpdateDevice(loaded)
+		deviceErr, ok := err.(*DeviceError)
+		require.True(t, ok)
+		assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+		assert.Equal(t, "version", deviceErr.Field)
+	})
+}
+
+func TestManager_UpdateDeviceStatus(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	device := createTestDevice()
+	require.NoError(t, manager.AddDevice(device))
+
+	checkedAt := time.Now().Truncate(time.Second)
+	require.NoError(t, manager.UpdateDeviceStatus(device.ID, string(StatusOnline), checkedAt))
+
+	stored, err := manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Equal(t, string(StatusOnline), stored.Status)
+	require.NotNil(t, stored.LastChecked)
+	assert.True(t, checkedAt.Equal(*stored.LastChecked))
+
+	err = manager.UpdateDeviceStatus("missing", string(StatusOnline), checkedAt)
+	deviceErr, ok := err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeNotFound, deviceErr.Type)
+}
+
+func TestManager_UpdateDeviceMetadata(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	device := createTestDevice()
+	require.NoError(t, manager.AddDevice(device))
+
+	require.NoError(t, manager.UpdateDeviceMetadata(device.ID, " ISR4451-X/K9 ", "16.09.04"))
+	stored, err := manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "ISR4451-X/K9", stored.Model)
+	assert.Equal(t, "16.09.04", stored.OSVersion)
+	assert.Equal(t, device.Version, stored.Version, "metadata does not conflict with edits")
+	assert.Equal(t, "ISR4451-X/K9", stored.ToDTO().Model)
+
+	// Edits keep the metadata
+	stored.Tags = "core"
+	require.NoError(t, manager.UpdateDevice(stored))
+	stored, err = manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "16.09.04", stored.OSVersion)
+
+	err = manager.UpdateDeviceMetadata(device.ID, strings.Repeat("x", MaxDeviceMetadataLength+1), "")
+	deviceErr, ok := err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+
+	err = manager.UpdateDeviceMetadata("missing", "model", "1.0")
+	deviceErr, ok = err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeNotFound, deviceErr.Type)
+}
+
+func TestManager_UpdateDeviceCredentials(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	device := createTestDevice()
+	require.NoError(t, manager.AddDevice(device))
+
+	require.NoError(t, manager.UpdateDeviceCredentials(device.ID, []byte("rotated")))
+
+	stored, err := manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("rotated"), stored.PasswordEncrypted)
+	assert.Equal(t, device.Version+1, stored.Version)
+
+	// An edit based on the old version now conflicts
+	device.Tags = "stale"
+	err = manager.UpdateDevice(device)
+	deviceErr, ok := err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeConflict, deviceErr.Type)
+
+	err = manager.UpdateDeviceCredentials(device.ID, nil)
+	deviceErr, ok = err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+
+	err = manager.UpdateDeviceCredentials("missing", []byte("rotated"))
+	deviceErr, ok = err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeNotFound, deviceErr.Type)
+}
+
+func TestManager_GetDeviceStats(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	t.Run("empty", func(t *testing.T) {
+		stats, err := manager.GetDeviceStats()
+		require.NoError(t, err)
+		assert.Equal(t, 0, stats.TotalCount)
+		assert.Empty(t, stats.ByType)
+		assert.Empty(t, stats.ByVendor)
+		assert.Empty(t, stats.ByStatus)
+	})
+
+	distribution := []struct {
+		deviceType string
+		vendor     string
+		status     DeviceStatus
+		ageDays    int
+	}{
+		{string(TypeRouter), string(VendorCisco), StatusOnline, 1},
+		{string(TypeRouter), string(VendorCisco), StatusOnline, 3},
+		{string(TypeRouter), string(VendorJuniper), StatusOffline, 10},
+		{string(TypeSwitch), string(VendorCisco), StatusWarning, 20},
+		{string(TypeSwitch), string(VendorArista), "", 45},
+		{string(TypeFirewall), string(VendorFortinet), StatusOnline, 90},
+	}
+
+	for i, d := range distribution {
+		device := createTestDevice()
+		device.Name = fmt.Sprintf("Device %d", i+1)
+		device.IPAddress = fmt.Sprintf("10.0.0.%d", i+1)
+		device.DeviceType = d.deviceType
+		device.Vendor = d.vendor
+		require.NoError(t, manager.AddDevice(device))
+
+		createdAt := time.Now().AddDate(0, 0, -d.ageDays)
+		_, err := db.Exec("UPDATE devices SET created_at = ?, status = ? WHERE id = ?",
+			createdAt, sql.NullString{String: string(d.status), Valid: d.status != ""}, device.ID)
+		require.NoError(t, err)
+	}
+
+	stats, err := manager.GetDeviceStats()
+	require.NoError(t, err)
+
+	assert.Equal(t, 6, stats.TotalCount)
+	assert.Equal(t, map[string]int{
+		string(TypeRouter):   3,
+		string(TypeSwitch):   2,
+		string(TypeFirewall): 1,
+	}, stats.ByType)
+	assert.Equal(t, map[string]int{
+		string(VendorCisco):    3,
+		string(VendorJuniper):  1,
+		string(VendorArista):   1,
+		string(VendorFortinet): 1,
+	}, stats.ByVendor)
+	// Devices without a recorded status count as offline
+	assert.Equal(t, map[string]int{
+		string(StatusOnline):  3,
+		string(StatusOffline): 2,
+		string(StatusWarning): 1,
+	}, stats.ByStatus)
+	assert.Equal(t, 2, stats.AddedLast7Days)
+	assert.Equal(t, 4, stats.AddedLast30Days)
+}
+
 // Test transaction rollback behavior
 func TestManager_TransactionRollback(t *testing.T) {
 	db := setupTestDB(t)
@@ -503,6 +777,52 @@ func TestManager_TransactionRollback(t *testing.T) {
 	})
 }
 
+func TestManager_ContextCancelled(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	stored := createTestDevice()
+	require.NoError(t, manager.AddDevice(stored))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Every call fails as a database error naming the cancellation
+	cancelled := func(err error) {
+		t.Helper()
+		var deviceErr *DeviceError
+		require.ErrorAs(t, err, &deviceErr)
+		assert.Equal(t, ErrorTypeDatabase, deviceErr.Type)
+		assert.Contains(t, deviceErr.Message, context.Canceled.Error())
+	}
+
+	_, err := manager.GetAllDevicesContext(ctx)
+	cancelled(err)
+	_, err = manager.GetDeviceContext(ctx, stored.ID)
+	cancelled(err)
+	_, err = manager.GetDeviceByIPContext(ctx, stored.IPAddress)
+	cancelled(err)
+	_, err = manager.GetDeviceStatsContext(ctx)
+	cancelled(err)
+
+	added := createTestDevice()
+	added.IPAddress = "192.168.1.60"
+	cancelled(manager.AddDeviceContext(ctx, added))
+	stored.Name = "Renamed"
+	cancelled(manager.UpdateDeviceContext(ctx, stored))
+	cancelled(manager.UpdateDeviceStatusContext(ctx, stored.ID, string(StatusOnline), time.Now()))
+	cancelled(manager.UpdateDeviceCredentialsContext(ctx, stored.ID, []byte("new")))
+	cancelled(manager.DeleteDeviceContext(ctx, stored.ID))
+
+	// Nothing was written
+	devices, err := manager.GetAllDevices()
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "Test Router", devices[0].Name)
+	assert.Equal(t, 1, devices[0].Version)
+}
+
 // Benchmark tests
 func BenchmarkManager_AddDevice(b *testing.B) {
 	db := setupTestDB(&testing.T{})
@@ -543,3 +863,164 @@ func BenchmarkManager_GetAllDevices(b *testing.B) {
 		}
 	}
 }
+
+// openBenchDB opens the seeded scale database, or a private copy of it when
+// the benchmark writes
+func openBenchDB(b *testing.B, writable bool) *sql.DB {
+	b.Helper()
+	if benchDBPath == "" {
+		b.Skip("scale database not seeded; run benchmarks without -short")
+	}
+
+	path := benchDBPath
+	if writable {
+		data, err := os.ReadFile(benchDBPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+		path = filepath.Join(b.TempDir(), "bench.db")
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+// deviceBytes approximates the payload size of devices for b.SetBytes
+func deviceBytes(devices ...Device) int64 {
+	var size int64
+	for _, d := range devices {
+		size += int64(len(d.ID) + len(d.Name) + len(d.IPAddress) + len(d.DeviceType) + len(d.Vendor) +
+			len(d.Username) + len(d.PasswordEncrypted) + len(d.SNMPCommunity) + len(d.Tags) + len(d.Status))
+	}
+	return size
+}
+
+func BenchmarkManager_GetAllDevices_10k(b *testing.B) {
+	manager := NewManager(openBenchDB(b, false))
+
+	devices, err := manager.GetAllDevices()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(devices) != benchDeviceCount {
+		b.Fatalf("Expected %d seeded devices, got %d", benchDeviceCount, len(devices))
+	}
+	b.SetBytes(deviceBytes(devices...))
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := manager.GetAllDevices(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkManager_GetDevicesPage_10k(b *testing.B) {
+	manager := NewManager(openBenchDB(b, false))
+
+	// Start from the middle of the fleet so the cursor seek is exercised
+	first, err := manager.GetDevicesPage("", benchDeviceCount/2)
+	if err != nil {
+		b.Fatal(err)
+	}
+	page, err := manager.GetDevicesPage(first.NextCursor, DefaultPageSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var size int64
+	for _, dto := range page.Devices {
+		size += int64(len(dto.ID) + len(dto.Name) + len(dto.IPAddress) + len(dto.Tags))
+	}
+	b.SetBytes(size)
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := manager.GetDevicesPage(first.NextCursor, DefaultPageSize); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkManager_GetDeviceByIP_10k(b *testing.B) {
+	manager := NewManager(openBenchDB(b, false))
+
+	device, err := manager.GetDeviceByIP("10.0.39.15")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(deviceBytes(*device))
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := manager.GetDeviceByIP("10.0.39.15"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkManager_GetRecentlyChangedDevices_10k(b *testing.B) {
+	manager := NewManager(openBenchDB(b, false))
+
+	devices, err := manager.GetRecentlyChangedDevices(DefaultPageSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(deviceBytes(devices...))
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := manager.GetRecentlyChangedDevices(DefaultPageSize); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkManager_SearchDevices_10k(b *testing.B) {
+	manager := NewManager(openBenchDB(b, false))
+	req := DeviceSearchRequest{FreeText: "router site-10", Vendor: string(VendorCisco)}
+
+	page, err := manager.SearchDevices(req)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(page.Devices) == 0 {
+		b.Fatal("search matched no devices")
+	}
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := manager.SearchDevices(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkManager_AddDevice_10k(b *testing.B) {
+	manager := NewManager(openBenchDB(b, true))
+
+	b.SetBytes(deviceBytes(*createTestDevice()))
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		device := createTestDevice()
+		device.IPAddress = fmt.Sprintf("172.%d.%d.%d", 16+i/65536%16, (i/256)%256, i%256)
+		device.Name = fmt.Sprintf("Bench Device %d", i)
+
+		if err := manager.AddDevice(device); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
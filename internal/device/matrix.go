@@ -0,0 +1,255 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// connectivityMatrixHeader is the header row written by
+// WriteConnectivityMatrixCSV
+var connectivityMatrixHeader = []string{
+	"device_id", "device_name", "ip_address", "ssh_port", "network_reachable",
+	"ssh_port_open", "response_time_ms", "error_code", "error", "tested_at",
+}
+
+// TestConnectivityMatrix tests every device, at most GetMaxParallel at a time,
+// and returns the results keyed by device ID. Unlike BulkTestConnectivity a
+// cancelled context does not discard the results gathered so far: devices
+// not yet tested get a timeout result carrying the context error.
+func (s *ConnectivityScanner) TestConnectivityMatrix(ctx context.Context, devices []*Device) map[string]*ConnectivityResult {
+	results := make(map[string]*ConnectivityResult, len(devices))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, s.maxParallel)
+
+	record := func(id string, result *ConnectivityResult) {
+		mu.Lock()
+		results[id] = result
+		mu.Unlock()
+	}
+
+	for _, dev := range devices {
+		if dev == nil {
+			continue
+		}
+
+		cancelled := ctx.Err() != nil
+		if !cancelled {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				cancelled = true
+			}
+		}
+		if cancelled {
+			record(dev.ID, &ConnectivityResult{
+				Device:    dev,
+				Error:     ctx.Err(),
+				ErrorCode: ConnectivityErrorTimeout,
+				TestedAt:  time.Now(),
+			})
+			continue
+		}
+
+		wg.Add(1)
+		go func(dev *Device) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result, err := s.TestConnectivityWithContext(ctx, dev)
+			if err != nil {
+				result = &ConnectivityResult{
+					Device:    dev,
+					Error:     err,
+					ErrorCode: ConnectivityErrorInvalidDevice,
+					TestedAt:  time.Now(),
+				}
+			}
+			record(dev.ID, result)
+		}(dev)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// WriteConnectivityMatrixCSV writes a connectivity matrix as CSV, one row per
+// device sorted by device name, with response times in milliseconds and test
+// times in UTC RFC 3339
+func WriteConnectivityMatrixCSV(w io.Writer, results map[string]*ConnectivityResult) error {
+	ids := make([]string, 0, len(results))
+	for id, result := range results {
+		if result != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := matrixDeviceName(results[ids[i]]), matrixDeviceName(results[ids[j]])
+		if a != b {
+			return a < b
+		}
+		return ids[i] < ids[j]
+	})
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(connectivityMatrixHeader); err != nil {
+		return fmt.Errorf("failed to write connectivity matrix: %w", err)
+	}
+
+	for _, id := range ids {
+		result := results[id]
+		var name, ip, port string
+		if result.Device != nil {
+			name, ip, port = result.Device.Name, result.Device.IPAddress, strconv.Itoa(result.Device.SSHPort)
+		}
+		var message string
+		if result.Error != nil {
+			message = result.Error.Error()
+		}
+		testedAt := ""
+		if !result.TestedAt.IsZero() {
+			testedAt = result.TestedAt.UTC().Format(time.RFC3339)
+		}
+
+		row := []string{
+			id, name, ip, port,
+			strconv.FormatBool(result.NetworkReachable),
+			strconv.FormatBool(result.SSHPortOpen),
+			strconv.FormatInt(result.ResponseTime.Milliseconds(), 10),
+			result.ErrorCode, message, testedAt,
+		}
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("failed to write connectivity matrix: %w", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write connectivity matrix: %w", err)
+	}
+	return nil
+}
+
+// matrixDeviceName returns the name rows of the matrix are sorted by
+func matrixDeviceName(result *ConnectivityResult) string {
+	if result.Device == nil {
+		return ""
+	}
+	return strings.ToLower(result.Device.Name)
+}
+
+// SaveConnectivityMatrix stores the results of TestConnectivityMatrix,
+// replacing the last stored result of each device. Results of devices that
+// no longer exist are skipped.
+func (m *Manager) SaveConnectivityMatrix(results map[string]*ConnectivityResult) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to begin transaction: %v", err),
+		}
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO device_connectivity (device_id, network_reachable, ssh_port_open, response_time_ms, error_code, error_message, tested_at)
+		SELECT id, ?, ?, ?, ?, ?, ? FROM devices WHERE id = ?
+		ON CONFLICT(device_id) DO UPDATE SET
+			network_reachable = excluded.network_reachable,
+			ssh_port_open = excluded.ssh_port_open,
+			response_time_ms = excluded.response_time_ms,
+			error_code = excluded.error_code,
+			error_message = excluded.error_message,
+			tested_at = excluded.tested_at
+	`
+	for id, result := range results {
+		if result == nil {
+			continue
+		}
+		var message string
+		if result.Error != nil {
+			message = result.Error.Error()
+		}
+		if _, err := tx.Exec(query, result.NetworkReachable, result.SSHPortOpen, result.ResponseTime.Milliseconds(),
+			result.ErrorCode, message, result.TestedAt, id); err != nil {
+			return &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to save connectivity of device %s: %v", id, err),
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to commit transaction: %v", err),
+		}
+	}
+	return nil
+}
+
+// GetConnectivityMatrix returns the last stored connectivity result of every
+// device that has one, keyed by device ID
+func (m *Manager) GetConnectivityMatrix() (map[string]*ConnectivityResult, error) {
+	devices, err := m.GetAllDevices()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Device, len(devices))
+	for i := range devices {
+		byID[devices[i].ID] = &devices[i]
+	}
+
+	rows, err := m.db.Query(`
+		SELECT device_id, network_reachable, ssh_port_open, response_time_ms, error_code, error_message, tested_at
+		FROM device_connectivity
+	`)
+	if err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to query connectivity matrix: %v", err),
+		}
+	}
+	defer rows.Close()
+
+	results := make(map[string]*ConnectivityResult)
+	for rows.Next() {
+		var (
+			id             string
+			result         ConnectivityResult
+			responseTimeMs int64
+			message        string
+			testedAt       sql.NullTime
+		)
+		if err := rows.Scan(&id, &result.NetworkReachable, &result.SSHPortOpen, &responseTimeMs,
+			&result.ErrorCode, &message, &testedAt); err != nil {
+			return nil, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to scan connectivity result: %v", err),
+			}
+		}
+		result.Device = byID[id]
+		result.ResponseTime = time.Duration(responseTimeMs) * time.Millisecond
+		result.TestedAt = testedAt.Time
+		if message != "" {
+			result.Error = errors.New(message)
+		}
+		results[id] = &result
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to read connectivity matrix: %v", err),
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,119 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectivityScanner_TestConnectivityMatrix(t *testing.T) {
+	scanner := NewConnectivityScannerWithConfig(time.Second, 1, time.Millisecond)
+	scanner.SetMaxParallel(2)
+
+	invalid := []*Device{
+		{ID: "no-address", Name: "No Address"},
+		nil,
+		{ID: "loopback", Name: "Loopback", IPAddress: "127.0.0.1", DeviceType: string(TypeRouter),
+			Vendor: string(VendorCisco), Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22},
+		{ID: "bad-port", Name: "Bad Port", IPAddress: "10.0.0.1", SSHPort: -1},
+	}
+
+	results := scanner.TestConnectivityMatrix(context.Background(), invalid)
+	require.Len(t, results, 3, "nil devices are skipped")
+	for _, dev := range []*Device{invalid[0], invalid[2], invalid[3]} {
+		require.Contains(t, results, dev.ID)
+		assert.Same(t, dev, results[dev.ID].Device)
+		assert.Equal(t, ConnectivityErrorInvalidDevice, results[dev.ID].ErrorCode)
+		assert.Error(t, results[dev.ID].Error)
+	}
+
+	devices := []*Device{
+		{ID: "a", Name: "A", IPAddress: "10.0.0.1", DeviceType: string(TypeRouter), Vendor: string(VendorCisco),
+			Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22},
+		{ID: "b", Name: "B", IPAddress: "10.0.0.2", DeviceType: string(TypeSwitch), Vendor: string(VendorCisco),
+			Username: "admin", PasswordEncrypted: []byte("x"), SSHPort: 22},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = scanner.TestConnectivityMatrix(ctx, devices)
+	require.Len(t, results, 2, "cancelled runs still report every device")
+	for _, result := range results {
+		assert.False(t, result.NetworkReachable)
+		assert.Equal(t, ConnectivityErrorTimeout, result.ErrorCode)
+		assert.ErrorIs(t, result.Error, context.Canceled)
+	}
+}
+
+func TestWriteConnectivityMatrixCSV(t *testing.T) {
+	testedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	results := map[string]*ConnectivityResult{
+		"2": {Device: &Device{ID: "2", Name: "switch", IPAddress: "10.0.0.2", SSHPort: 2222},
+			Error: errors.New("host appears to be unreachable"), ErrorCode: ConnectivityErrorUnreachable, TestedAt: testedAt},
+		"1": {Device: &Device{ID: "1", Name: "Router", IPAddress: "10.0.0.1", SSHPort: 22},
+			NetworkReachable: true, SSHPortOpen: true, ResponseTime: 42 * time.Millisecond, TestedAt: testedAt},
+		"3": nil,
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, WriteConnectivityMatrixCSV(&out, results))
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		connectivityMatrixHeader,
+		{"1", "Router", "10.0.0.1", "22", "true", "true", "42", "", "", "2024-03-01T11:00:00Z"},
+		{"2", "switch", "10.0.0.2", "2222", "false", "false", "0", "unreachable", "host appears to be unreachable", "2024-03-01T11:00:00Z"},
+	}, rows)
+}
+
+func TestManager_ConnectivityMatrix(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	router := createTestDevice()
+	require.NoError(t, manager.AddDevice(router))
+
+	testedAt := time.Now().Truncate(time.Second)
+	require.NoError(t, manager.SaveConnectivityMatrix(map[string]*ConnectivityResult{
+		router.ID: {Device: router, Error: errors.New("ssh port closed"), ErrorCode: ConnectivityErrorSSHPortClosed,
+			NetworkReachable: true, ResponseTime: 15 * time.Millisecond, TestedAt: testedAt},
+		"deleted": {ErrorCode: ConnectivityErrorUnreachable, TestedAt: testedAt},
+	}))
+
+	matrix, err := manager.GetConnectivityMatrix()
+	require.NoError(t, err)
+	require.Len(t, matrix, 1, "results of unknown devices are not stored")
+	stored := matrix[router.ID]
+	require.NotNil(t, stored)
+	require.NotNil(t, stored.Device)
+	assert.Equal(t, router.Name, stored.Device.Name)
+	assert.True(t, stored.NetworkReachable)
+	assert.False(t, stored.SSHPortOpen)
+	assert.Equal(t, 15*time.Millisecond, stored.ResponseTime)
+	assert.Equal(t, ConnectivityErrorSSHPortClosed, stored.ErrorCode)
+	assert.EqualError(t, stored.Error, "ssh port closed")
+	assert.True(t, testedAt.Equal(stored.TestedAt))
+
+	// A later test replaces the stored result
+	require.NoError(t, manager.SaveConnectivityMatrix(map[string]*ConnectivityResult{
+		router.ID: {Device: router, NetworkReachable: true, SSHPortOpen: true, TestedAt: testedAt.Add(time.Minute)},
+	}))
+	matrix, err = manager.GetConnectivityMatrix()
+	require.NoError(t, err)
+	assert.True(t, matrix[router.ID].SSHPortOpen)
+	assert.Empty(t, matrix[router.ID].ErrorCode)
+	assert.NoError(t, matrix[router.ID].Error)
+
+	require.NoError(t, manager.DeleteDevice(router.ID))
+	matrix, err = manager.GetConnectivityMatrix()
+	require.NoError(t, err)
+	assert.Empty(t, matrix, "results are removed with their device")
+}
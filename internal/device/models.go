@@ -10,20 +10,64 @@ import (
 
 // Device represents a network device
 type Device struct {
-	ID                string     `json:"id" db:"id"`
-	Name              string     `json:"name" db:"name"`
-	IPAddress         string     `json:"ipAddress" db:"ip_address"`
-	DeviceType        string     `json:"deviceType" db:"device_type"`
-	Vendor            string     `json:"vendor" db:"vendor"`
-	Username          string     `json:"username" db:"username"`
-	PasswordEncrypted []byte     `json:"-" db:"password_encrypted"`
-	SSHPort           int        `json:"sshPort" db:"ssh_port"`
-	SNMPCommunity     string     `json:"snmpCommunity" db:"snmp_community"`
-	Tags              string     `json:"tags" db:"tags"`
-	Status            string     `json:"status"`
-	LastChecked       *time.Time `json:"lastChecked"`
-	CreatedAt         time.Time  `json:"createdAt" db:"created_at"`
-	UpdatedAt         time.Time  `json:"updatedAt" db:"updated_at"`
+	ID                string `json:"id" db:"id"`
+	Name              string `json:"name" db:"name"`
+	IPAddress         string `json:"ipAddress" db:"ip_address"`
+	DeviceType        string `json:"deviceType" db:"device_type"`
+	Vendor            string `json:"vendor" db:"vendor"`
+	Username          string `json:"username" db:"username"`
+	PasswordEncrypted []byte `json:"-" db:"password_encrypted"`
+	SSHPort           int    `json:"sshPort" db:"ssh_port"`
+	SNMPCommunity     string `json:"snmpCommunity" db:"snmp_community"`
+	// OutputCharset is the charset the device's command output is decoded
+	// from; empty passes UTF-8 through
+	OutputCharset string     `json:"outputCharset" db:"output_charset"`
+	Tags          string     `json:"tags" db:"tags"`
+	Status        string     `json:"status"`
+	LastChecked   *time.Time `json:"lastChecked"`
+	CreatedAt     time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updatedAt" db:"updated_at"`
+	Version       int        `json:"version" db:"version"`
+
+	// IsSandbox marks lab and honeypot devices. They are checked like any
+	// other device but left out of reports, scores, dashboards and
+	// notifications unless a caller asks for them.
+	IsSandbox bool `json:"isSandbox" db:"is_sandbox"`
+
+	// HostKeyPolicy is how the device's SSH host key is verified, one of
+	// the HostKeyPolicy values; empty means TOFU. HostKeyFingerprint is the
+	// SHA256 fingerprint the strict policy only accepts.
+	HostKeyPolicy      string `json:"hostKeyPolicy" db:"host_key_policy"`
+	HostKeyFingerprint string `json:"hostKeyFingerprint" db:"host_key_fingerprint"`
+
+	// MaintenanceWindows are the weekly periods in which checks skip the
+	// device. They are set with Manager.SetMaintenanceWindows; UpdateDevice
+	// leaves them unchanged.
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty" db:"maintenance_windows"`
+
+	// Model and OSVersion are the hardware model and software version the
+	// device reported in its version command output during the last check
+	// that ran it. They are set with Manager.UpdateDeviceMetadata;
+	// UpdateDevice leaves them unchanged.
+	Model     string `json:"model" db:"model"`
+	OSVersion string `json:"osVersion" db:"os_version"`
+
+	// KeyboardResponses answer the device's keyboard-interactive prompts,
+	// such as one-time passcodes. They are set with
+	// Manager.SetKeyboardResponses; UpdateDevice leaves them unchanged.
+	KeyboardResponses []KeyboardResponse `json:"keyboardResponses,omitempty" db:"keyboard_responses"`
+}
+
+// DeviceStats holds aggregate device counts for analytics. Sandbox devices
+// are only counted in SandboxCount.
+type DeviceStats struct {
+	TotalCount      int            `json:"totalCount"`
+	SandboxCount    int            `json:"sandboxCount"`
+	ByType          map[string]int `json:"byType"`
+	ByVendor        map[string]int `json:"byVendor"`
+	ByStatus        map[string]int `json:"byStatus"`
+	AddedLast7Days  int            `json:"addedLast7Days"`
+	AddedLast30Days int            `json:"addedLast30Days"`
 }
 
 // DeviceStatus represents the status of a device
@@ -143,10 +187,12 @@ func (d *Device) Validate() error {
 		return err
 	}
 
-	// Validate IP address
-	if err := ValidateIPAddress(d.IPAddress); err != nil {
+	// Validate IP address, keeping the normalized form
+	ip, err := NormalizeHost(d.IPAddress)
+	if err != nil {
 		return err
 	}
+	d.IPAddress = ip
 
 	// Validate device type
 	if err := ValidateDeviceType(d.DeviceType); err != nil {
@@ -173,6 +219,29 @@ func (d *Device) Validate() error {
 		return err
 	}
 
+	// Validate maintenance windows
+	if err := ValidateMaintenanceWindows(d.MaintenanceWindows); err != nil {
+		return err
+	}
+
+	// Validate output charset
+	if err := ValidateOutputCharset(d.OutputCharset); err != nil {
+		return err
+	}
+
+	// Validate keyboard-interactive responses
+	if err := ValidateKeyboardResponses(d.KeyboardResponses); err != nil {
+		return err
+	}
+
+	// Validate host key policy, storing TOFU when none is set
+	d.HostKeyPolicy = strings.TrimSpace(d.HostKeyPolicy)
+	d.HostKeyFingerprint = strings.TrimSpace(d.HostKeyFingerprint)
+	if err := ValidateHostKeyPolicy(d.HostKeyPolicy, d.HostKeyFingerprint); err != nil {
+		return err
+	}
+	d.HostKeyPolicy = string(d.EffectiveHostKeyPolicy())
+
 	return nil
 }
 
@@ -221,6 +290,40 @@ func ValidateIPAddress(ipAddress string) error {
 	return nil
 }
 
+// NormalizeHost cleans up an IP address pasted from another tool: it trims
+// whitespace, strips a host prefix length (/32 or /128) and writes the
+// address in canonical form, then validates it like ValidateIPAddress.
+// Addresses with a port or a network prefix are rejected.
+func NormalizeHost(input string) (ip string, err error) {
+	host := strings.TrimSpace(input)
+	if host == "" {
+		return "", ValidationError{Field: "ipAddress", Message: "IP address cannot be empty"}
+	}
+
+	if addr, port, err := net.SplitHostPort(host); err == nil && net.ParseIP(addr) != nil {
+		return "", ValidationError{Field: "ipAddress",
+			Message: fmt.Sprintf("IP address cannot include a port; enter %s in the SSH port field instead", port)}
+	}
+
+	if addr, prefix, found := strings.Cut(host, "/"); found {
+		parsed := net.ParseIP(addr)
+		switch {
+		case parsed == nil:
+			return "", ValidationError{Field: "ipAddress", Message: "invalid IP address format"}
+		case (parsed.To4() != nil && prefix == "32") || (parsed.To4() == nil && prefix == "128"):
+			host = addr
+		default:
+			return "", ValidationError{Field: "ipAddress",
+				Message: fmt.Sprintf("%s is a network, not a device address; enter the device's own IP address", host)}
+		}
+	}
+
+	if err := ValidateIPAddress(host); err != nil {
+		return "", err
+	}
+	return net.ParseIP(host).String(), nil
+}
+
 // ValidateDeviceType validates the device type
 func ValidateDeviceType(deviceType string) error {
 	deviceType = strings.TrimSpace(deviceType)
@@ -309,6 +412,18 @@ func ValidateTags(tags string) error {
 	return nil
 }
 
+// TagList returns the device's tags with surrounding whitespace and empty
+// entries removed
+func (d *Device) TagList() []string {
+	var tags []string
+	for _, tag := range strings.Split(d.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
 // SetDefaults sets default values for optional fields
 func (d *Device) SetDefaults() {
 	if d.SSHPort == 0 {
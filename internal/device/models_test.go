@@ -170,6 +170,64 @@ func TestValidateIPAddress(t *testing.T) {
 	}
 }
 
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		want   string
+		errMsg string
+	}{
+		{"plain IPv4", "192.168.1.1", "192.168.1.1", ""},
+		{"surrounding whitespace", " \t10.0.0.1\n", "10.0.0.1", ""},
+		{"IPv4 host prefix", "10.0.0.1/32", "10.0.0.1", ""},
+		{"IPv6 host prefix", "2001:DB8::1/128", "2001:db8::1", ""},
+		{"IPv6 canonical form", "2001:0db8:0000::0001", "2001:db8::1", ""},
+		{"IPv4 port", "10.0.0.1:2222", "", "enter 2222 in the SSH port field"},
+		{"IPv6 port", "[2001:db8::1]:22", "", "enter 22 in the SSH port field"},
+		{"network prefix", "10.0.0.0/24", "", "10.0.0.0/24 is a network"},
+		{"IPv4 with IPv6 host prefix", "10.0.0.1/128", "", "is a network"},
+		{"invalid prefixed address", "router/32", "", "invalid IP address format"},
+		{"empty", "  ", "", "IP address cannot be empty"},
+		{"hostname with port", "router:22", "", "invalid IP address format"},
+		{"loopback", "127.0.0.1/32", "", "loopback addresses are not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeHost(tt.input)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("NormalizeHost(%q) error = %v, expected to contain %q", tt.input, err, tt.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeHost(%q) unexpected error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeHost(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDevice_ValidateNormalizesIPAddress(t *testing.T) {
+	d := Device{
+		Name:       "Test Router",
+		IPAddress:  " 192.168.1.1/32 ",
+		DeviceType: string(TypeRouter),
+		Vendor:     string(VendorCisco),
+		Username:   "admin",
+		SSHPort:    22,
+	}
+	if err := d.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
+	if d.IPAddress != "192.168.1.1" {
+		t.Errorf("Expected the normalized IP address to be kept, got %q", d.IPAddress)
+	}
+}
+
 func TestValidateDeviceType(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -0,0 +1,420 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultImportUsername is the SSH username given to imported devices when
+// the import does not name one, as NetBox holds no credentials
+const DefaultImportUsername = "admin"
+
+// NetBoxTag is added to the tags of every device imported from NetBox
+const NetBoxTag = "netbox"
+
+// maxNetBoxRetries bounds how often one page is requested again after
+// NetBox asked the client to slow down
+const maxNetBoxRetries = 5
+
+// maxNetBoxRetryWait caps the wait a Retry-After header can ask for
+const maxNetBoxRetryWait = 2 * time.Minute
+
+// netBoxHTTPClient is the client NetBox requests are made with. The API
+// token header goes along with redirects, so they must stay on the origin
+// of the request.
+var netBoxHTTPClient = &http.Client{
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after %d redirects", len(via))
+		}
+		return checkNetBoxOrigin(via[0].URL, req.URL, "redirect")
+	},
+}
+
+// netBoxSleep waits before a request is retried; tests replace it
+var netBoxSleep = time.Sleep
+
+// netBoxVendors maps NetBox manufacturer slugs to vendors where they differ
+var netBoxVendors = map[string]Vendor{
+	"cisco-systems":              VendorCisco,
+	"juniper-networks":           VendorJuniper,
+	"hpe":                        VendorHP,
+	"hewlett-packard":            VendorHP,
+	"hewlett-packard-enterprise": VendorHP,
+	"aruba":                      VendorHP,
+	"aruba-networks":             VendorHP,
+	"arista-networks":            VendorArista,
+	"palo-alto":                  VendorPaloAlto,
+	"palo-alto-networks":         VendorPaloAlto,
+	"check-point":                VendorCheckPoint,
+	"f5-networks":                VendorF5,
+	"dell-emc":                   VendorDell,
+	"huawei-technologies":        VendorHuawei,
+	"ubiquiti-networks":          VendorUbiquiti,
+}
+
+// netBoxDeviceTypes maps NetBox device role slugs to device types where
+// they differ
+var netBoxDeviceTypes = map[string]DeviceType{
+	"core-router":         TypeRouter,
+	"edge-router":         TypeRouter,
+	"wan-router":          TypeRouter,
+	"access-switch":       TypeSwitch,
+	"core-switch":         TypeSwitch,
+	"distribution-switch": TypeSwitch,
+	"leaf":                TypeSwitch,
+	"spine":               TypeSwitch,
+	"ap":                  TypeAccessPoint,
+	"wireless-ap":         TypeAccessPoint,
+	"wlc":                 TypeWirelessController,
+	"vpn-gateway":         TypeVPN,
+}
+
+// DeviceFilter narrows a NetBox import to matching devices. Site, Role, Tag
+// and Status are NetBox slugs passed on as query filters; empty fields do
+// not filter. Username is the SSH username given to the imported devices,
+// DefaultImportUsername when empty.
+type DeviceFilter struct {
+	Site     string `json:"site"`
+	Role     string `json:"role"`
+	Tag      string `json:"tag"`
+	Status   string `json:"status"`
+	Username string `json:"username"`
+}
+
+// ImportFailure is a device that could not be imported and why
+type ImportFailure struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ImportResult reports the outcome of an import. Skipped counts devices
+// whose IP address already exists.
+type ImportResult struct {
+	Imported int             `json:"imported"`
+	Skipped  int             `json:"skipped"`
+	Failed   []ImportFailure `json:"failed"`
+}
+
+// netBoxPage is one page of the NetBox device list
+type netBoxPage struct {
+	Count   int            `json:"count"`
+	Next    *string        `json:"next"`
+	Results []netBoxDevice `json:"results"`
+}
+
+// netBoxSlug is a NetBox object reference, of which only the slug is used
+type netBoxSlug struct {
+	Slug string `json:"slug"`
+}
+
+// netBoxDevice holds the fields of a NetBox device that are imported.
+// NetBox 4 renamed device_role to role, so both are read.
+type netBoxDevice struct {
+	Name       *string `json:"name"`
+	DeviceType struct {
+		Manufacturer netBoxSlug `json:"manufacturer"`
+	} `json:"device_type"`
+	DeviceRole *netBoxSlug `json:"device_role"`
+	Role       *netBoxSlug `json:"role"`
+	PrimaryIP  *struct {
+		Address string `json:"address"`
+	} `json:"primary_ip"`
+	Tags []netBoxSlug `json:"tags"`
+}
+
+// ImportFromNetBox adds the devices listed by the NetBox DCIM API at apiURL
+// that match filter. Every page of the list is read before any device is
+// added. Devices whose IP address already exists are skipped, and devices
+// NetBox lacks a name or primary IP for, or whose fields do not validate,
+// are reported in Failed while the rest are imported. Imported devices have
+// an empty password until one is set, and carry NetBoxTag.
+func (m *Manager) ImportFromNetBox(apiURL, apiToken string, filter DeviceFilter) (*ImportResult, error) {
+	pageURL, err := netBoxDevicesURL(apiURL, filter)
+	if err != nil {
+		return nil, &DeviceError{Type: ErrorTypeValidation, Field: "apiURL", Message: err.Error()}
+	}
+	if strings.TrimSpace(apiToken) == "" {
+		return nil, &DeviceError{Type: ErrorTypeValidation, Field: "apiToken", Message: "API token cannot be empty"}
+	}
+
+	username := strings.TrimSpace(filter.Username)
+	if username == "" {
+		username = DefaultImportUsername
+	}
+	if err := ValidateUsername(username); err != nil {
+		return nil, &DeviceError{Type: ErrorTypeValidation, Field: "username", Message: err.Error()}
+	}
+
+	var listed []netBoxDevice
+	for pageURL != nil {
+		page, err := fetchNetBoxPage(pageURL, apiToken)
+		if err != nil {
+			return nil, err
+		}
+		listed = append(listed, page.Results...)
+
+		pageURL = nil
+		if page.Next != nil && *page.Next != "" {
+			if pageURL, err = netBoxNextURL(apiURL, *page.Next); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := &ImportResult{Failed: []ImportFailure{}}
+	for _, nb := range listed {
+		device, err := nb.toDevice(username)
+		if err := m.importDevice(result, nb.displayName(), device, err); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// importDevice adds a device to the inventory and counts it in result as
+// imported, skipped as a duplicate or failed. A non-nil mapErr reports that
+// the device could not be built from its source and fails it. Only
+// database errors are returned.
+func (m *Manager) importDevice(result *ImportResult, name string, device *Device, mapErr error) error {
+	err := mapErr
+	if err == nil {
+		err = m.AddDevice(device)
+	}
+
+	var deviceErr *DeviceError
+	switch {
+	case err == nil:
+		result.Imported++
+	case errors.As(err, &deviceErr) && deviceErr.Type == ErrorTypeDuplicate:
+		result.Skipped++
+	case errors.As(err, &deviceErr) && deviceErr.Type == ErrorTypeDatabase:
+		return err
+	default:
+		result.Failed = append(result.Failed, ImportFailure{Name: name, Reason: importFailureReason(err)})
+	}
+	return nil
+}
+
+// toDevice maps a NetBox device to a device
+func (nb netBoxDevice) toDevice(username string) (*Device, error) {
+	if nb.Name == nil || strings.TrimSpace(*nb.Name) == "" {
+		return nil, fmt.Errorf("device has no name in NetBox")
+	}
+	if nb.PrimaryIP == nil || nb.PrimaryIP.Address == "" {
+		return nil, fmt.Errorf("device has no primary IP in NetBox")
+	}
+
+	// NetBox addresses carry their prefix length, as in 10.0.0.1/24
+	address := nb.PrimaryIP.Address
+	if ip, _, err := net.ParseCIDR(address); err == nil {
+		address = ip.String()
+	}
+
+	role := nb.Role
+	if role == nil {
+		role = nb.DeviceRole
+	}
+	roleSlug := ""
+	if role != nil {
+		roleSlug = role.Slug
+	}
+
+	tags := []string{NetBoxTag}
+	for _, tag := range nb.Tags {
+		if tag.Slug != "" && tag.Slug != NetBoxTag {
+			tags = append(tags, tag.Slug)
+		}
+	}
+
+	return &Device{
+		Name:              strings.TrimSpace(*nb.Name),
+		IPAddress:         address,
+		DeviceType:        string(netBoxDeviceType(roleSlug)),
+		Vendor:            string(netBoxVendor(nb.DeviceType.Manufacturer.Slug)),
+		Username:          username,
+		PasswordEncrypted: []byte{},
+		SSHPort:           22,
+		Tags:              strings.Join(tags, ","),
+	}, nil
+}
+
+// displayName names a NetBox device in import failures
+func (nb netBoxDevice) displayName() string {
+	if nb.Name != nil && *nb.Name != "" {
+		return *nb.Name
+	}
+	if nb.PrimaryIP != nil && nb.PrimaryIP.Address != "" {
+		return nb.PrimaryIP.Address
+	}
+	return "(unnamed)"
+}
+
+// importFailureReason returns the message of an import error without the
+// error type prefix added by DeviceError
+func importFailureReason(err error) string {
+	var deviceErr *DeviceError
+	if errors.As(err, &deviceErr) {
+		return deviceErr.Message
+	}
+	return err.Error()
+}
+
+// netBoxVendor maps a NetBox manufacturer slug to a vendor, falling back to
+// VendorOther for manufacturers that are not supported
+func netBoxVendor(slug string) Vendor {
+	slug = strings.ToLower(slug)
+	if vendor, ok := netBoxVendors[slug]; ok {
+		return vendor
+	}
+	if vendor := strings.ReplaceAll(slug, "-", "_"); IsValidVendor(vendor) {
+		return Vendor(vendor)
+	}
+	return VendorOther
+}
+
+// netBoxDeviceType maps a NetBox device role slug to a device type, falling
+// back to TypeOther for roles that have no matching type
+func netBoxDeviceType(slug string) DeviceType {
+	slug = strings.ToLower(slug)
+	if deviceType, ok := netBoxDeviceTypes[slug]; ok {
+		return deviceType
+	}
+	if deviceType := strings.ReplaceAll(slug, "-", "_"); IsValidDeviceType(deviceType) {
+		return DeviceType(deviceType)
+	}
+	return TypeOther
+}
+
+// netBoxDevicesURL builds the URL of the first page of the device list
+func netBoxDevicesURL(apiURL string, filter DeviceFilter) (*url.URL, error) {
+	base, err := url.Parse(strings.TrimSpace(apiURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid NetBox URL: %v", err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("NetBox URL must be an http or https address")
+	}
+
+	// The API may be given as the NetBox address or as its /api root
+	path := strings.TrimSuffix(base.Path, "/")
+	path = strings.TrimSuffix(path, "/api")
+	base.Path = path + "/api/dcim/devices/"
+
+	query := url.Values{"format": {"json"}}
+	for name, value := range map[string]string{
+		"site": filter.Site, "role": filter.Role, "tag": filter.Tag, "status": filter.Status,
+	} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	base.RawQuery = query.Encode()
+	return base, nil
+}
+
+// netBoxNextURL parses the next link of a page. The API token is sent with
+// every page, so links to another host or scheme are refused.
+func netBoxNextURL(apiURL, next string) (*url.URL, error) {
+	base, err := url.Parse(strings.TrimSpace(apiURL))
+	if err != nil {
+		return nil, err
+	}
+	nextURL, err := base.Parse(next)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NetBox next page link: %v", err)
+	}
+	if err := checkNetBoxOrigin(base, nextURL, "next page link"); err != nil {
+		return nil, err
+	}
+	return nextURL, nil
+}
+
+// checkNetBoxOrigin refuses a URL the API token would be sent to that is
+// not on the scheme and host of the configured NetBox, so the token never
+// leaves over plain HTTP or to another server
+func checkNetBoxOrigin(base, target *url.URL, what string) error {
+	if !strings.EqualFold(target.Scheme, base.Scheme) {
+		return fmt.Errorf("NetBox %s changes the scheme from %s to %s", what, base.Scheme, target.Scheme)
+	}
+	if !strings.EqualFold(target.Host, base.Host) {
+		return fmt.Errorf("NetBox %s points to another host: %s", what, target.Host)
+	}
+	return nil
+}
+
+// fetchNetBoxPage requests one page of the device list, waiting and trying
+// again while NetBox answers that it is rate limiting or unavailable
+func fetchNetBoxPage(pageURL *url.URL, apiToken string) (*netBoxPage, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, pageURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Token "+apiToken)
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := netBoxHTTPClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reach NetBox: %w", err)
+		}
+
+		if (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) &&
+			attempt < maxNetBoxRetries {
+			wait := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+			resp.Body.Close()
+			netBoxSleep(wait)
+			continue
+		}
+
+		page, err := decodeNetBoxPage(resp)
+		resp.Body.Close()
+		return page, err
+	}
+}
+
+// decodeNetBoxPage reads a device list page from a response
+func decodeNetBoxPage(resp *http.Response) (*netBoxPage, error) {
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		message := strings.TrimSpace(string(body))
+		if message == "" {
+			message = resp.Status
+		}
+		return nil, fmt.Errorf("NetBox request failed with status %d: %s", resp.StatusCode, message)
+	}
+
+	var page netBoxPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode NetBox response: %w", err)
+	}
+	return &page, nil
+}
+
+// retryAfter returns how long a Retry-After header asks to wait, given in
+// seconds or as an HTTP date. Missing or invalid values wait one second.
+func retryAfter(header string, now time.Time) time.Duration {
+	wait := time.Second
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		wait = date.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+	}
+	if wait > maxNetBoxRetryWait {
+		wait = maxNetBoxRetryWait
+	}
+	return wait
+}
@@ -0,0 +1,236 @@
+package device
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// netBoxFirstPage is the first device list page; %s is the server address
+const netBoxFirstPage = `{
+	"count": 5,
+	"next": "%s/api/dcim/devices/?format=json&limit=3&offset=3",
+	"previous": null,
+	"results": [
+		{
+			"id": 1,
+			"name": "edge-rtr-01",
+			"device_type": {"id": 7, "model": "ISR4331", "manufacturer": {"id": 1, "name": "Cisco", "slug": "cisco"}},
+			"device_role": {"id": 2, "name": "Edge Router", "slug": "edge-router"},
+			"primary_ip": {"id": 10, "family": 4, "address": "10.20.0.1/24"},
+			"tags": [{"id": 1, "name": "DC1", "slug": "dc1"}]
+		},
+		{
+			"id": 2,
+			"name": "fw-01",
+			"device_type": {"id": 8, "model": "PA-3220", "manufacturer": {"id": 2, "name": "Palo Alto Networks", "slug": "palo-alto-networks"}},
+			"role": {"id": 3, "name": "Firewall", "slug": "firewall"},
+			"primary_ip": {"id": 11, "family": 4, "address": "10.20.0.2/32"},
+			"tags": []
+		},
+		{
+			"id": 3,
+			"name": "patch-panel-01",
+			"device_type": {"id": 9, "model": "PP-24", "manufacturer": {"id": 3, "name": "Generic", "slug": "generic"}},
+			"device_role": {"id": 4, "name": "Patch Panel", "slug": "patch-panel"},
+			"primary_ip": null,
+			"tags": []
+		}
+	]
+}`
+
+// netBoxSecondPage is the last device list page
+const netBoxSecondPage = `{
+	"count": 5,
+	"next": null,
+	"previous": "/api/dcim/devices/?format=json&limit=3",
+	"results": [
+		{
+			"id": 4,
+			"name": "leaf-01",
+			"device_type": {"id": 10, "model": "7050SX", "manufacturer": {"id": 4, "name": "Arista", "slug": "arista-networks"}},
+			"role": {"id": 5, "name": "Leaf", "slug": "leaf"},
+			"primary_ip": {"id": 12, "family": 6, "address": "2001:db8::4/64"},
+			"tags": [{"id": 2, "name": "Fabric", "slug": "fabric"}]
+		},
+		{
+			"id": 5,
+			"name": "Core Switch",
+			"device_type": {"id": 11, "model": "C9500", "manufacturer": {"id": 1, "name": "Cisco", "slug": "cisco"}},
+			"device_role": {"id": 6, "name": "Core Switch", "slug": "core-switch"},
+			"primary_ip": {"id": 13, "family": 4, "address": "192.168.1.1/24"},
+			"tags": []
+		}
+	]
+}`
+
+// newNetBoxServer serves the canned device list, rate limiting the first
+// request of the second page
+func newNetBoxServer(t *testing.T) (*httptest.Server, *[]string) {
+	var requests []string
+	limited := false
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+		assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "/api/dcim/devices/", r.URL.Path)
+
+		if r.URL.Query().Get("offset") == "" {
+			fmt.Fprintf(w, netBoxFirstPage, server.URL)
+			return
+		}
+		if !limited {
+			limited = true
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, netBoxSecondPage)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestManager_ImportFromNetBox(t *testing.T) {
+	var waits []time.Duration
+	netBoxSleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { netBoxSleep = time.Sleep }()
+
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	// 192.168.1.1 already exists and is skipped
+	require.NoError(t, manager.AddDevice(createTestDevice()))
+
+	server, requests := newNetBoxServer(t)
+	result, err := manager.ImportFromNetBox(server.URL+"/api/", "secret", DeviceFilter{Site: "dc1", Username: "netops"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, result.Imported)
+	assert.Equal(t, 1, result.Skipped)
+	assert.Equal(t, []ImportFailure{{Name: "patch-panel-01", Reason: "device has no primary IP in NetBox"}}, result.Failed)
+
+	require.Len(t, *requests, 3)
+	assert.Equal(t, "/api/dcim/devices/?format=json&site=dc1", (*requests)[0])
+	assert.Equal(t, []time.Duration{7 * time.Second}, waits, "the rate limited page is retried after Retry-After")
+
+	devices, err := manager.GetAllDevices()
+	require.NoError(t, err)
+	imported := make(map[string]Device)
+	for _, d := range devices {
+		imported[d.Name] = d
+	}
+	require.Len(t, imported, 4)
+
+	expected := []Device{
+		{Name: "edge-rtr-01", IPAddress: "10.20.0.1", DeviceType: "router", Vendor: "cisco", Tags: "netbox,dc1"},
+		{Name: "fw-01", IPAddress: "10.20.0.2", DeviceType: "firewall", Vendor: "palo_alto", Tags: "netbox"},
+		{Name: "leaf-01", IPAddress: "2001:db8::4", DeviceType: "switch", Vendor: "arista", Tags: "netbox,fabric"},
+	}
+	for _, want := range expected {
+		got, ok := imported[want.Name]
+		require.True(t, ok, "device %s imported", want.Name)
+		assert.Equal(t, want.IPAddress, got.IPAddress, want.Name)
+		assert.Equal(t, want.DeviceType, got.DeviceType, want.Name)
+		assert.Equal(t, want.Vendor, got.Vendor, want.Name)
+		assert.Equal(t, want.Tags, got.Tags, want.Name)
+		assert.Equal(t, "netops", got.Username, want.Name)
+		assert.Equal(t, 22, got.SSHPort, want.Name)
+		assert.Equal(t, string(StatusOffline), got.Status, want.Name)
+	}
+}
+
+func TestManager_ImportFromNetBox_Errors(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	_, err := manager.ImportFromNetBox("ftp://netbox", "secret", DeviceFilter{})
+	assert.Error(t, err)
+	_, err = manager.ImportFromNetBox("https://netbox.example.com", " ", DeviceFilter{})
+	assert.Error(t, err)
+
+	denied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"detail": "Invalid token"}`)
+	}))
+	defer denied.Close()
+	_, err = manager.ImportFromNetBox(denied.URL, "wrong", DeviceFilter{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid token")
+
+	// The token is not sent to another host named by a next link
+	redirecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"count": 1, "next": "https://elsewhere.example.com/api/dcim/devices/?offset=1", "results": []}`)
+	}))
+	defer redirecting.Close()
+	_, err = manager.ImportFromNetBox(redirecting.URL, "secret", DeviceFilter{})
+	assert.Error(t, err)
+
+	devices, err := manager.GetAllDevices()
+	require.NoError(t, err)
+	assert.Empty(t, devices, "failed imports add no device")
+}
+
+func TestNetBoxNextURL(t *testing.T) {
+	next, err := netBoxNextURL("https://netbox.example.com", "/api/dcim/devices/?offset=50")
+	require.NoError(t, err)
+	assert.Equal(t, "https://netbox.example.com/api/dcim/devices/?offset=50", next.String())
+
+	_, err = netBoxNextURL("https://netbox.example.com", "http://netbox.example.com/api/dcim/devices/?offset=50")
+	assert.ErrorContains(t, err, "scheme", "the token is not sent over plain HTTP")
+	_, err = netBoxNextURL("https://netbox.example.com", "https://netbox.example.com:8443/api/dcim/devices/")
+	assert.ErrorContains(t, err, "another host")
+
+	// Redirects to plain HTTP are not followed with the token
+	redirecting := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://"+r.Host+r.URL.RequestURI(), http.StatusFound)
+	}))
+	defer redirecting.Close()
+	client := *netBoxHTTPClient
+	client.Transport = redirecting.Client().Transport
+	req, err := http.NewRequest(http.MethodGet, redirecting.URL+"/api/dcim/devices/", nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	assert.ErrorContains(t, err, "scheme")
+}
+
+func TestNetBoxMappings(t *testing.T) {
+	vendors := map[string]Vendor{
+		"cisco": VendorCisco, "juniper-networks": VendorJuniper, "hpe": VendorHP, "palo-alto": VendorPaloAlto,
+		"mikrotik": VendorMikroTik, "Fortinet": VendorFortinet, "supermicro": VendorOther, "": VendorOther,
+	}
+	for slug, want := range vendors {
+		assert.Equal(t, want, netBoxVendor(slug), slug)
+	}
+
+	types := map[string]DeviceType{
+		"router": TypeRouter, "core-switch": TypeSwitch, "load-balancer": TypeLoadBalancer,
+		"wlc": TypeWirelessController, "server": TypeOther, "": TypeOther,
+	}
+	for slug, want := range types {
+		assert.Equal(t, want, netBoxDeviceType(slug), slug)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 30*time.Second, retryAfter("30", now))
+	assert.Equal(t, 90*time.Second, retryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Second, retryAfter("", now))
+	assert.Equal(t, maxNetBoxRetryWait, retryAfter("86400", now))
+
+	keys := make([]string, 0, len(netBoxVendors))
+	for slug := range netBoxVendors {
+		keys = append(keys, slug)
+	}
+	sort.Strings(keys)
+	for _, slug := range keys {
+		assert.True(t, IsValidVendor(string(netBoxVendors[slug])), slug)
+	}
+}
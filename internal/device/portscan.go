@@ -0,0 +1,186 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultScanPorts are the TCP ports probed when a scan names none:
+// FTP, SSH, Telnet, HTTP, HTTPS, NETCONF and the alternate web ports
+var DefaultScanPorts = []int{21, 22, 23, 80, 443, 830, 8080, 8443}
+
+// Port scan limits
+const (
+	DefaultMaxParallel = 16
+	portProbeTimeout   = 3 * time.Second
+)
+
+// PortScanResult reports the state of each probed TCP port of a device.
+// Duration is encoded in milliseconds by MarshalJSON.
+type PortScanResult struct {
+	DeviceID      string        `json:"deviceId"`
+	IPAddress     string        `json:"ipAddress"`
+	OpenPorts     []int         `json:"openPorts"`
+	ClosedPorts   []int         `json:"closedPorts"`
+	FilteredPorts []int         `json:"filteredPorts"`
+	Duration      time.Duration `json:"-"`
+	ScannedAt     time.Time     `json:"scannedAt"`
+}
+
+// MarshalJSON encodes the scan duration in milliseconds
+func (r PortScanResult) MarshalJSON() ([]byte, error) {
+	type result PortScanResult
+	return json.Marshal(struct {
+		result
+		DurationMs int64 `json:"durationMs"`
+	}{result: result(r), DurationMs: r.Duration.Milliseconds()})
+}
+
+// UnexpectedOpenPorts returns the open ports that are not in expected
+func (r *PortScanResult) UnexpectedOpenPorts(expected []int) []int {
+	allowed := make(map[int]bool, len(expected))
+	for _, port := range expected {
+		allowed[port] = true
+	}
+
+	unexpected := []int{}
+	for _, port := range r.OpenPorts {
+		if !allowed[port] {
+			unexpected = append(unexpected, port)
+		}
+	}
+	return unexpected
+}
+
+// portState is the outcome of probing one port
+type portState int
+
+const (
+	portOpen portState = iota
+	portClosed
+	portFiltered
+)
+
+// ScanPorts probes TCP ports of a device concurrently, at most maxParallel at
+// a time. A refused connection marks a port closed, and a timeout marks it
+// filtered since a firewall is likely dropping the packets.
+func (s *ConnectivityScanner) ScanPorts(ctx context.Context, device *Device, ports []int) (*PortScanResult, error) {
+	if device == nil {
+		return nil, fmt.Errorf("device cannot be nil")
+	}
+	if device.IPAddress == "" {
+		return nil, fmt.Errorf("device IP address cannot be empty")
+	}
+
+	if len(ports) == 0 {
+		ports = DefaultScanPorts
+	}
+
+	seen := make(map[int]bool, len(ports))
+	var unique []int
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", port)
+		}
+		if !seen[port] {
+			seen[port] = true
+			unique = append(unique, port)
+		}
+	}
+
+	maxParallel := s.maxParallel
+	if maxParallel <= 0 {
+		maxParallel = DefaultMaxParallel
+	}
+
+	result := &PortScanResult{
+		DeviceID:      device.ID,
+		IPAddress:     device.IPAddress,
+		OpenPorts:     []int{},
+		ClosedPorts:   []int{},
+		FilteredPorts: []int{},
+		ScannedAt:     time.Now(),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxParallel)
+
+	for _, port := range unique {
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			state := s.probePort(ctx, device.IPAddress, port)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch state {
+			case portOpen:
+				result.OpenPorts = append(result.OpenPorts, port)
+			case portClosed:
+				result.ClosedPorts = append(result.ClosedPorts, port)
+			default:
+				result.FilteredPorts = append(result.FilteredPorts, port)
+			}
+		}(port)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("port scan cancelled: %w", err)
+	}
+
+	sort.Ints(result.OpenPorts)
+	sort.Ints(result.ClosedPorts)
+	sort.Ints(result.FilteredPorts)
+	result.Duration = time.Since(result.ScannedAt)
+
+	return result, nil
+}
+
+// probePort attempts a single TCP connection to a port
+func (s *ConnectivityScanner) probePort(ctx context.Context, ipAddress string, port int) portState {
+	timeout := portProbeTimeout
+	if s.timeout > 0 && s.timeout < timeout {
+		timeout = s.timeout
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ipAddress, fmt.Sprintf("%d", port)))
+	if err == nil {
+		conn.Close()
+		return portOpen
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return portFiltered
+	}
+	return portClosed
+}
+
+// SetMaxParallel sets how many ports ScanPorts probes at once
+func (s *ConnectivityScanner) SetMaxParallel(maxParallel int) {
+	if maxParallel > 0 {
+		s.maxParallel = maxParallel
+	}
+}
+
+// GetMaxParallel returns how many ports ScanPorts probes at once
+func (s *ConnectivityScanner) GetMaxParallel() int {
+	return s.maxParallel
+}
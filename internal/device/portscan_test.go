@@ -0,0 +1,146 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// startListener accepts and immediately closes connections on a random port
+func startListener(t *testing.T) (net.Listener, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start listener: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener, listener.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort returns a port that nothing listens on
+func closedPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func TestConnectivityScanner_ScanPorts(t *testing.T) {
+	sshListener, sshPort := startListener(t)
+	defer sshListener.Close()
+	webListener, webPort := startListener(t)
+	defer webListener.Close()
+	closed := closedPort(t)
+
+	scanner := NewConnectivityScanner()
+	scanner.SetMaxParallel(2)
+
+	device := &Device{ID: "dev1", IPAddress: "127.0.0.1", SSHPort: sshPort}
+
+	result, err := scanner.ScanPorts(context.Background(), device, []int{webPort, sshPort, closed, sshPort})
+	if err != nil {
+		t.Fatalf("ScanPorts failed: %v", err)
+	}
+
+	expectedOpen := []int{sshPort, webPort}
+	if sshPort > webPort {
+		expectedOpen = []int{webPort, sshPort}
+	}
+	if !reflect.DeepEqual(result.OpenPorts, expectedOpen) {
+		t.Errorf("Expected open ports %v, got %v", expectedOpen, result.OpenPorts)
+	}
+	if !reflect.DeepEqual(result.ClosedPorts, []int{closed}) {
+		t.Errorf("Expected closed ports [%d], got %v", closed, result.ClosedPorts)
+	}
+	if len(result.FilteredPorts) != 0 {
+		t.Errorf("Expected no filtered ports, got %v", result.FilteredPorts)
+	}
+	if result.DeviceID != "dev1" {
+		t.Errorf("Expected device ID dev1, got %s", result.DeviceID)
+	}
+
+	unexpected := result.UnexpectedOpenPorts([]int{sshPort})
+	if !reflect.DeepEqual(unexpected, []int{webPort}) {
+		t.Errorf("Expected unexpected ports [%d], got %v", webPort, unexpected)
+	}
+}
+
+func TestConnectivityScanner_ScanPortsValidation(t *testing.T) {
+	scanner := NewConnectivityScanner()
+	ctx := context.Background()
+
+	if _, err := scanner.ScanPorts(ctx, nil, []int{22}); err == nil {
+		t.Error("Expected error for nil device")
+	}
+
+	device := &Device{IPAddress: "127.0.0.1"}
+	for _, port := range []int{0, -1, 65536} {
+		if _, err := scanner.ScanPorts(ctx, device, []int{port}); err == nil {
+			t.Errorf("Expected error for port %d", port)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := scanner.ScanPorts(cancelled, device, []int{closedPort(t)}); err == nil {
+		t.Error("Expected error for cancelled context")
+	}
+}
+
+func TestPortScanResult_UnexpectedOpenPorts(t *testing.T) {
+	result := &PortScanResult{OpenPorts: []int{22, 23, 80}}
+
+	if got := result.UnexpectedOpenPorts([]int{22}); !reflect.DeepEqual(got, []int{23, 80}) {
+		t.Errorf("Expected [23 80], got %v", got)
+	}
+	if got := result.UnexpectedOpenPorts([]int{22, 23, 80}); len(got) != 0 {
+		t.Errorf("Expected no unexpected ports, got %v", got)
+	}
+}
+
+func TestConnectivityScanner_MaxParallel(t *testing.T) {
+	scanner := NewConnectivityScannerWithConfig(time.Second, 0, time.Millisecond)
+	if scanner.GetMaxParallel() != DefaultMaxParallel {
+		t.Errorf("Expected default max parallel %d, got %d", DefaultMaxParallel, scanner.GetMaxParallel())
+	}
+
+	scanner.SetMaxParallel(4)
+	scanner.SetMaxParallel(0)
+	if scanner.GetMaxParallel() != 4 {
+		t.Errorf("Expected max parallel 4, got %d", scanner.GetMaxParallel())
+	}
+}
+
+func TestPortScanResult_JSON(t *testing.T) {
+	data, err := json.Marshal(&PortScanResult{DeviceID: "device1", OpenPorts: []int{22}, Duration: 250 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded["durationMs"] != float64(250) {
+		t.Errorf("Expected duration 250ms, got %v", decoded["durationMs"])
+	}
+	if decoded["deviceId"] != "device1" {
+		t.Errorf("Expected device ID, got %v", decoded["deviceId"])
+	}
+	if _, ok := decoded["duration"]; ok {
+		t.Error("Expected no nanosecond duration field")
+	}
+}
@@ -0,0 +1,143 @@
+package device
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SandboxFilter selects devices by their sandbox flag in device searches
+type SandboxFilter string
+
+const (
+	// SandboxInclude lists sandbox devices with the others; it is the default
+	SandboxInclude SandboxFilter = "include"
+	// SandboxExclude leaves sandbox devices out
+	SandboxExclude SandboxFilter = "exclude"
+	// SandboxOnly lists only sandbox devices
+	SandboxOnly SandboxFilter = "only"
+)
+
+// Validate checks that the filter is known; empty means SandboxInclude
+func (f SandboxFilter) Validate() error {
+	switch f {
+	case "", SandboxInclude, SandboxExclude, SandboxOnly:
+		return nil
+	}
+	return &DeviceError{
+		Type:    ErrorTypeValidation,
+		Field:   "sandbox",
+		Message: fmt.Sprintf("unknown sandbox filter %q; use include, exclude or only", string(f)),
+	}
+}
+
+// Matches reports whether a device passes the filter
+func (f SandboxFilter) Matches(d *Device) bool {
+	switch f {
+	case SandboxExclude:
+		return !d.IsSandbox
+	case SandboxOnly:
+		return d.IsSandbox
+	}
+	return true
+}
+
+// condition returns the SQL condition on the devices table selecting the
+// filter's devices, or "" when every device passes
+func (f SandboxFilter) condition() string {
+	switch f {
+	case SandboxExclude:
+		return `is_sandbox = 0`
+	case SandboxOnly:
+		return `is_sandbox = 1`
+	}
+	return ""
+}
+
+// WithoutSandbox returns the devices that are not sandbox devices
+func WithoutSandbox(devices []Device) []Device {
+	kept := make([]Device, 0, len(devices))
+	for _, d := range devices {
+		if !d.IsSandbox {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// SetSandbox sets the sandbox flag of several devices at once. Devices
+// already carrying the flag are left alone. It returns how many devices
+// changed; unknown IDs fail the whole update.
+func (m *Manager) SetSandbox(ids []string, sandbox bool) (int, error) {
+	return m.SetSandboxContext(context.Background(), ids, sandbox)
+}
+
+// SetSandboxContext is SetSandbox, stopping when ctx ends
+func (m *Manager) SetSandboxContext(ctx context.Context, ids []string, sandbox bool) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to begin transaction: %v", err),
+		}
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	now := time.Now()
+	for _, id := range ids {
+		if strings.TrimSpace(id) == "" {
+			return 0, &DeviceError{
+				Type:    ErrorTypeValidation,
+				Field:   "id",
+				Message: "device ID cannot be empty",
+			}
+		}
+
+		var current bool
+		if err := tx.QueryRowContext(ctx, `SELECT is_sandbox FROM devices WHERE id = ?`, id).Scan(&current); err != nil {
+			if err == sql.ErrNoRows {
+				return 0, &DeviceError{
+					Type:    ErrorTypeNotFound,
+					Message: fmt.Sprintf("device with ID %s not found", id),
+				}
+			}
+			return 0, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to load device %s: %v", id, err),
+			}
+		}
+		if current == sandbox {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE devices SET is_sandbox = ?, updated_at = ?, version = version + 1 WHERE id = ?`,
+			sandbox, now, id); err != nil {
+			return 0, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to update sandbox flag of device %s: %v", id, err),
+			}
+		}
+		changed++
+	}
+
+	if changed > 0 {
+		if err = bumpDataVersion(ctx, tx); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to commit transaction: %v", err),
+		}
+	}
+	return changed, nil
+}
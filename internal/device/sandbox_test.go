@@ -0,0 +1,119 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_SearchDevicesSandboxFilter(t *testing.T) {
+	searchModes(t, func(t *testing.T, manager *Manager) {
+		lab := mustDeviceByIP(t, manager, "172.16.0.1")
+		changed, err := manager.SetSandbox([]string{lab.ID}, true)
+		require.NoError(t, err)
+		assert.Equal(t, 1, changed)
+
+		names := searchNames(t, manager, DeviceSearchRequest{FreeText: "core"})
+		assert.ElementsMatch(t, []string{"core-sw-01", "core-sw-02", "lab-core-01"}, names, "sandbox devices are listed by default")
+		names = searchNames(t, manager, DeviceSearchRequest{FreeText: "core", Sandbox: SandboxInclude})
+		assert.ElementsMatch(t, []string{"core-sw-01", "core-sw-02", "lab-core-01"}, names)
+		names = searchNames(t, manager, DeviceSearchRequest{FreeText: "core", Sandbox: SandboxExclude})
+		assert.ElementsMatch(t, []string{"core-sw-01", "core-sw-02"}, names)
+		names = searchNames(t, manager, DeviceSearchRequest{Sandbox: SandboxOnly})
+		assert.Equal(t, []string{"lab-core-01"}, names)
+
+		page, err := manager.SearchDevices(DeviceSearchRequest{Sandbox: SandboxOnly})
+		require.NoError(t, err)
+		require.Len(t, page.Devices, 1)
+		assert.True(t, page.Devices[0].IsSandbox)
+
+		_, err = manager.SearchDevices(DeviceSearchRequest{Sandbox: "maybe"})
+		deviceErr, ok := err.(*DeviceError)
+		require.True(t, ok)
+		assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+		assert.Equal(t, "sandbox", deviceErr.Field)
+	})
+}
+
+func TestManager_SetSandbox(t *testing.T) {
+	manager := setupSearchManager(t)
+	core := mustDeviceByIP(t, manager, "10.1.0.1")
+	lab := mustDeviceByIP(t, manager, "172.16.0.1")
+
+	changed, err := manager.SetSandbox([]string{core.ID, lab.ID}, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, changed)
+
+	// Devices already flagged are left alone
+	changed, err = manager.SetSandbox([]string{core.ID, lab.ID}, true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, changed)
+
+	changed, err = manager.SetSandbox([]string{core.ID}, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	stored, err := manager.GetDevice(core.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.IsSandbox)
+	assert.Greater(t, stored.Version, core.Version, "changing the flag bumps the device version")
+	stored, err = manager.GetDevice(lab.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.IsSandbox)
+
+	// An unknown device fails the whole update
+	_, err = manager.SetSandbox([]string{core.ID, "missing"}, true)
+	deviceErr, ok := err.(*DeviceError)
+	require.True(t, ok)
+	assert.Equal(t, ErrorTypeNotFound, deviceErr.Type)
+	stored, err = manager.GetDevice(core.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.IsSandbox)
+}
+
+func TestManager_UpdateDeviceSandbox(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	manager := NewManager(db)
+
+	device := createTestDevice()
+	device.IsSandbox = true
+	require.NoError(t, manager.AddDevice(device))
+	stored, err := manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.IsSandbox)
+	assert.True(t, stored.ToDTO().IsSandbox)
+
+	stored.IsSandbox = false
+	require.NoError(t, manager.UpdateDevice(stored))
+	stored, err = manager.GetDevice(device.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.IsSandbox)
+}
+
+func TestManager_GetDeviceStatsExcludesSandbox(t *testing.T) {
+	manager := setupSearchManager(t)
+	lab := mustDeviceByIP(t, manager, "172.16.0.1")
+	_, err := manager.SetSandbox([]string{lab.ID}, true)
+	require.NoError(t, err)
+
+	stats, err := manager.GetDeviceStats()
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.TotalCount)
+	assert.Equal(t, 1, stats.SandboxCount)
+	assert.Equal(t, 2, stats.ByVendor[string(VendorCisco)], "the sandbox device is left out of the breakdowns")
+}
+
+func TestWithoutSandbox(t *testing.T) {
+	devices := []Device{{ID: "a"}, {ID: "b", IsSandbox: true}, {ID: "c"}}
+	kept := WithoutSandbox(devices)
+	require.Len(t, kept, 2)
+	assert.Equal(t, "a", kept[0].ID)
+	assert.Equal(t, "c", kept[1].ID)
+
+	assert.True(t, SandboxExclude.Matches(&devices[0]))
+	assert.False(t, SandboxExclude.Matches(&devices[1]))
+	assert.True(t, SandboxOnly.Matches(&devices[1]))
+	assert.True(t, SandboxFilter("").Matches(&devices[1]))
+}
@@ -2,26 +2,65 @@ package device
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"time"
 )
 
-// ConnectivityResult represents the result of a connectivity test
+// Connectivity error codes reported in ConnectivityResult.ErrorCode
+const (
+	ConnectivityErrorUnreachable   = "unreachable"
+	ConnectivityErrorSSHPortClosed = "ssh_port_closed"
+	ConnectivityErrorTimeout       = "timeout"
+	ConnectivityErrorInvalidDevice = "invalid_device"
+)
+
+// ConnectivityResult represents the result of a connectivity test. Error and
+// ResponseTime are encoded by MarshalJSON, as an error value would reach the
+// frontend as {} and a duration as bare nanoseconds.
 type ConnectivityResult struct {
 	Device           *Device       `json:"device"`
 	NetworkReachable bool          `json:"networkReachable"`
 	SSHPortOpen      bool          `json:"sshPortOpen"`
-	ResponseTime     time.Duration `json:"responseTime"`
-	Error            error         `json:"error,omitempty"`
+	ResponseTime     time.Duration `json:"-"`
+	Error            error         `json:"-"`
+	ErrorCode        string        `json:"errorCode,omitempty"`
 	TestedAt         time.Time     `json:"testedAt"`
 }
 
+// MarshalJSON encodes the error as its message and the response time in
+// milliseconds
+func (r ConnectivityResult) MarshalJSON() ([]byte, error) {
+	type result ConnectivityResult
+	encoded := struct {
+		result
+		ResponseTimeMs int64  `json:"responseTimeMs"`
+		Error          string `json:"error,omitempty"`
+	}{result: result(r), ResponseTimeMs: r.ResponseTime.Milliseconds()}
+	if r.Error != nil {
+		encoded.Error = r.Error.Error()
+	}
+	return json.Marshal(encoded)
+}
+
+// connectivityErrorCode classifies a failed connectivity step
+func connectivityErrorCode(err error, fallback string) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ConnectivityErrorTimeout
+	}
+	return fallback
+}
+
 // ConnectivityScanner handles device connectivity testing
 type ConnectivityScanner struct {
 	timeout        time.Duration
 	maxRetries     int
 	baseRetryDelay time.Duration
+	maxParallel    int
+	snmpPort       int
 }
 
 // ScannerInterface defines the interface for connectivity scanning
@@ -30,6 +69,9 @@ type ScannerInterface interface {
 	TestConnectivityWithContext(ctx context.Context, device *Device) (*ConnectivityResult, error)
 	BulkTestConnectivity(devices []*Device) ([]*ConnectivityResult, error)
 	BulkTestConnectivityWithContext(ctx context.Context, devices []*Device) ([]*ConnectivityResult, error)
+	ScanPorts(ctx context.Context, device *Device, ports []int) (*PortScanResult, error)
+	SNMPPoll(ctx context.Context, device *Device) (*SNMPPollResult, error)
+	TestConnectivityMatrix(ctx context.Context, devices []*Device) map[string]*ConnectivityResult
 }
 
 // NewConnectivityScanner creates a new connectivity scanner with default settings
@@ -38,6 +80,8 @@ func NewConnectivityScanner() *ConnectivityScanner {
 		timeout:        10 * time.Second,
 		maxRetries:     3,
 		baseRetryDelay: 1 * time.Second,
+		maxParallel:    DefaultMaxParallel,
+		snmpPort:       DefaultSNMPPort,
 	}
 }
 
@@ -47,6 +91,8 @@ func NewConnectivityScannerWithConfig(timeout time.Duration, maxRetries int, bas
 		timeout:        timeout,
 		maxRetries:     maxRetries,
 		baseRetryDelay: baseRetryDelay,
+		maxParallel:    DefaultMaxParallel,
+		snmpPort:       DefaultSNMPPort,
 	}
 }
 
@@ -81,6 +127,7 @@ func (s *ConnectivityScanner) TestConnectivityWithContext(ctx context.Context, d
 
 	if err != nil {
 		result.Error = fmt.Errorf("network reachability test failed: %w", err)
+		result.ErrorCode = connectivityErrorCode(err, ConnectivityErrorUnreachable)
 		result.ResponseTime = time.Since(startTime)
 		return result, nil
 	}
@@ -92,6 +139,7 @@ func (s *ConnectivityScanner) TestConnectivityWithContext(ctx context.Context, d
 
 		if err != nil {
 			result.Error = fmt.Errorf("SSH port test failed: %w", err)
+			result.ErrorCode = connectivityErrorCode(err, ConnectivityErrorSSHPortClosed)
 		}
 	}
 
@@ -139,9 +187,10 @@ func (s *ConnectivityScanner) BulkTestConnectivityWithContext(ctx context.Contex
 			if res.err != nil {
 				// Create error result for failed tests
 				results[res.index] = &ConnectivityResult{
-					Device:   devices[res.index],
-					Error:    res.err,
-					TestedAt: time.Now(),
+					Device:    devices[res.index],
+					Error:     res.err,
+					ErrorCode: ConnectivityErrorInvalidDevice,
+					TestedAt:  time.Now(),
 				}
 			} else {
 				results[res.index] = res.result
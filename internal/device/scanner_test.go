@@ -2,6 +2,8 @@ package device
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -454,3 +456,82 @@ func BenchmarkConnectivityScanner_BulkTestConnectivity(b *testing.B) {
 		}
 	}
 }
+
+// TestConnectivityResult_JSON checks that the error message, its code and the
+// response time survive the round trip to the frontend
+func TestConnectivityResult_JSON(t *testing.T) {
+	result := &ConnectivityResult{
+		Device:       &Device{ID: "device1", Name: "Test Device"},
+		ResponseTime: 1500 * time.Millisecond,
+		Error:        fmt.Errorf("network reachability test failed: %w", context.DeadlineExceeded),
+		ErrorCode:    ConnectivityErrorTimeout,
+		TestedAt:     time.Now(),
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if decoded["error"] != "network reachability test failed: context deadline exceeded" {
+		t.Errorf("Expected error message, got %v", decoded["error"])
+	}
+	if decoded["errorCode"] != ConnectivityErrorTimeout {
+		t.Errorf("Expected error code %q, got %v", ConnectivityErrorTimeout, decoded["errorCode"])
+	}
+	if decoded["responseTimeMs"] != float64(1500) {
+		t.Errorf("Expected response time 1500ms, got %v", decoded["responseTimeMs"])
+	}
+	if _, ok := decoded["device"].(map[string]interface{}); !ok {
+		t.Errorf("Expected device object, got %v", decoded["device"])
+	}
+
+	// A successful test has no error fields
+	data, err = json.Marshal(ConnectivityResult{NetworkReachable: true, SSHPortOpen: true})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	decoded = nil
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if _, ok := decoded["error"]; ok {
+		t.Errorf("Expected no error field, got %v", decoded["error"])
+	}
+	if _, ok := decoded["errorCode"]; ok {
+		t.Errorf("Expected no error code field, got %v", decoded["errorCode"])
+	}
+}
+
+// TestConnectivityResult_JSONInSlice checks that errors stay readable when
+// results are returned as a list, as bulk scans do
+func TestConnectivityResult_JSONInSlice(t *testing.T) {
+	results := []*ConnectivityResult{
+		{Device: &Device{ID: "device1"}, Error: fmt.Errorf("SSH port test failed: connection refused")},
+		{Device: &Device{ID: "device2"}, NetworkReachable: true, SSHPortOpen: true},
+	}
+
+	data, err := json.Marshal(results)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(decoded) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(decoded))
+	}
+	if decoded[0]["error"] != "SSH port test failed: connection refused" {
+		t.Errorf("Expected error message, got %v", decoded[0]["error"])
+	}
+	if _, ok := decoded[1]["error"]; ok {
+		t.Errorf("Expected no error field, got %v", decoded[1]["error"])
+	}
+}
@@ -0,0 +1,311 @@
+package device
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"invictux-demo/internal/database"
+)
+
+// searchIndexTable is the FTS5 table indexing device names, tags and IP addresses
+const searchIndexTable = "devices_fts"
+
+// searchIndexTriggers are dropped when FTS5 is unavailable, so a database
+// indexed by another build still accepts device writes
+var searchIndexTriggers = []string{"devices_fts_ai", "devices_fts_ad", "devices_fts_au"}
+
+// DeviceSearchRequest combines a free-text search with structured filters.
+// Every non-empty field must match.
+type DeviceSearchRequest struct {
+	// FreeText is matched against name, tags and IP address. Each word
+	// matches as a prefix and all words must match.
+	FreeText string `json:"freeText"`
+	// Name matches devices whose name contains it
+	Name string `json:"name"`
+	// Tag matches devices carrying this exact tag
+	Tag string `json:"tag"`
+	// IPAddress matches devices whose address starts with it
+	IPAddress  string `json:"ipAddress"`
+	Vendor     string `json:"vendor"`
+	DeviceType string `json:"deviceType"`
+	Status     string `json:"status"`
+	// Sandbox includes, excludes or selects only sandbox devices; empty
+	// includes them
+	Sandbox SandboxFilter `json:"sandbox"`
+	Cursor  string        `json:"cursor"`
+	Limit   int           `json:"limit"`
+}
+
+// searchCursor marks where the next page of search results starts
+type searchCursor struct {
+	Offset int `json:"o"`
+}
+
+// initSearchIndex enables the full-text device index when SQLite was built
+// with FTS5. The index is created by a migration; a database migrated by a
+// build without FTS5 gets it here. Without FTS5, searches fall back to LIKE
+// matching.
+func (m *Manager) initSearchIndex() {
+	available, err := database.CompileOptionUsed(m.db, database.SearchIndexOption)
+	if err != nil {
+		log.Printf("Device search index unavailable: %v", err)
+		return
+	}
+
+	if !available {
+		for _, trigger := range searchIndexTriggers {
+			m.db.Exec("DROP TRIGGER IF EXISTS " + trigger)
+		}
+		return
+	}
+
+	var triggers int
+	err = m.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'devices_fts_%'`).Scan(&triggers)
+	if err != nil {
+		log.Printf("Device search index unavailable: %v", err)
+		return
+	}
+
+	if _, err := m.db.Exec(database.SearchIndexSQL); err != nil {
+		log.Printf("Device search index unavailable: %v", err)
+		return
+	}
+
+	// Without every trigger in place the index may have missed writes
+	if triggers != len(searchIndexTriggers) {
+		if err := m.rebuildSearchIndex(); err != nil {
+			log.Printf("Device search index unavailable: %v", err)
+			return
+		}
+	}
+
+	m.fullTextSearch = true
+}
+
+// rebuildSearchIndex reindexes every device
+func (m *Manager) rebuildSearchIndex() error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM ` + searchIndexTable); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO ` + searchIndexTable + ` (name, tags, ip_address, id)
+		SELECT name, tags, ip_address, id FROM devices`); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SearchDevices returns one page of devices matching every field of the
+// request. Free-text results are ranked by relevance; otherwise devices come
+// in GetAllDevices order.
+func (m *Manager) SearchDevices(req DeviceSearchRequest) (*DevicePage, error) {
+	if err := req.Sandbox.Validate(); err != nil {
+		return nil, err
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	var offset int
+	if req.Cursor != "" {
+		cursor, err := decodeSearchCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		offset = cursor.Offset
+	}
+
+	token, err := m.ChangeToken()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + deviceColumns + ` FROM devices`
+	var conditions []string
+	var args []interface{}
+	orderBy := ` ORDER BY created_at DESC, id DESC`
+
+	if terms := searchTerms(req.FreeText); len(terms) > 0 {
+		if m.fullTextSearch {
+			query += ` JOIN (
+				SELECT id AS fts_id, bm25(` + searchIndexTable + `, 10.0, 5.0, 1.0) AS fts_rank
+				FROM ` + searchIndexTable + ` WHERE ` + searchIndexTable + ` MATCH ?
+			) ON fts_id = devices.id`
+			args = append(args, ftsQuery(terms))
+			orderBy = ` ORDER BY fts_rank, created_at DESC, id DESC`
+		} else {
+			termConditions, termArgs := likeTermConditions(terms)
+			conditions = append(conditions, termConditions...)
+			args = append(args, termArgs...)
+		}
+	}
+
+	fieldConditions, fieldArgs := req.fieldConditions()
+	conditions = append(conditions, fieldConditions...)
+	args = append(args, fieldArgs...)
+
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+
+	// Fetch one extra row to know whether another page follows
+	query += orderBy + ` LIMIT ? OFFSET ?`
+	args = append(args, limit+1, offset)
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to search devices: %v", err),
+		}
+	}
+	defer rows.Close()
+
+	var devices []Device
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to scan device row: %v", err),
+			}
+		}
+		devices = append(devices, device)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("error iterating over device rows: %v", err),
+		}
+	}
+
+	page := &DevicePage{Token: token}
+	if len(devices) > limit {
+		devices = devices[:limit]
+		page.NextCursor = encodeSearchCursor(searchCursor{Offset: offset + limit})
+	}
+	page.Devices = ToDTOs(devices)
+
+	return page, nil
+}
+
+// fieldConditions returns the SQL conditions on the devices table, and
+// their arguments, selecting devices that match the structured fields of
+// the request. FreeText, Cursor and Limit are not included.
+func (req DeviceSearchRequest) fieldConditions() ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if name := strings.TrimSpace(req.Name); name != "" {
+		conditions = append(conditions, `name LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(name)+"%")
+	}
+	if tag := strings.TrimSpace(req.Tag); tag != "" {
+		conditions = append(conditions, `(',' || REPLACE(COALESCE(tags, ''), ' ', '') || ',') LIKE ? ESCAPE '\'`)
+		args = append(args, "%,"+escapeLike(tag)+",%")
+	}
+	if ip := strings.TrimSpace(req.IPAddress); ip != "" {
+		conditions = append(conditions, `ip_address LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(ip)+"%")
+	}
+	if req.Vendor != "" {
+		conditions = append(conditions, `vendor = ?`)
+		args = append(args, req.Vendor)
+	}
+	if req.DeviceType != "" {
+		conditions = append(conditions, `device_type = ?`)
+		args = append(args, req.DeviceType)
+	}
+	if req.Status != "" {
+		conditions = append(conditions, `status = ?`)
+		args = append(args, req.Status)
+	}
+	if condition := req.Sandbox.condition(); condition != "" {
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, args
+}
+
+// searchTerms splits free text into words, dropping those without any
+// letter or digit since the index cannot match them
+func searchTerms(text string) []string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		if strings.IndexFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// likeTermConditions returns the LIKE conditions, and their arguments,
+// matching every term anywhere in a device's name, tags or IP address. They
+// stand in for the full-text index when it is unavailable.
+func likeTermConditions(terms []string) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, term := range terms {
+		conditions = append(conditions,
+			`(name LIKE ? ESCAPE '\' OR tags LIKE ? ESCAPE '\' OR ip_address LIKE ? ESCAPE '\')`)
+		pattern := "%" + escapeLike(term) + "%"
+		args = append(args, pattern, pattern, pattern)
+	}
+	return conditions, args
+}
+
+// ftsQuery builds an FTS5 query matching every term as a prefix. Terms are
+// quoted so operators and punctuation in user input are taken literally.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// escapeLike escapes the LIKE wildcards in a value matched with ESCAPE '\'
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// encodeSearchCursor serializes a search cursor for the frontend
+func encodeSearchCursor(cursor searchCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSearchCursor parses a cursor produced by encodeSearchCursor
+func decodeSearchCursor(cursor string) (searchCursor, error) {
+	var decoded searchCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(cursor))
+	if err == nil {
+		err = json.Unmarshal(data, &decoded)
+	}
+	if err != nil || decoded.Offset <= 0 {
+		return decoded, &DeviceError{
+			Type:    ErrorTypeValidation,
+			Field:   "cursor",
+			Message: "invalid search cursor",
+		}
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,188 @@
+package device
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupSearchManager adds a small mixed fleet to a new manager
+func setupSearchManager(t *testing.T) *Manager {
+	t.Helper()
+
+	db := setupTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	manager := NewManager(db)
+
+	fleet := []struct {
+		name, ip, vendor, tags string
+	}{
+		{"core-sw-01", "10.1.0.1", "cisco", "production,core"},
+		{"core-sw-02", "10.1.0.2", "juniper", "production,core"},
+		{"edge-rtr-01", "10.2.0.1", "cisco", "production,edge"},
+		{"lab-core-01", "172.16.0.1", "cisco", "lab"},
+		{"branch-fw-01", "192.168.50.1", "fortinet", "branch_office"},
+	}
+	for _, d := range fleet {
+		device := createTestDevice()
+		device.Name = d.name
+		device.IPAddress = d.ip
+		device.Vendor = d.vendor
+		device.Tags = d.tags
+		require.NoError(t, manager.AddDevice(device))
+	}
+
+	return manager
+}
+
+func searchNames(t *testing.T, manager *Manager, req DeviceSearchRequest) []string {
+	t.Helper()
+
+	page, err := manager.SearchDevices(req)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, dto := range page.Devices {
+		names = append(names, dto.Name)
+	}
+	return names
+}
+
+// searchModes runs a test against the FTS5 index when this build has it and
+// always against the LIKE fallback
+func searchModes(t *testing.T, test func(t *testing.T, manager *Manager)) {
+	t.Run("fallback", func(t *testing.T) {
+		manager := setupSearchManager(t)
+		manager.fullTextSearch = false
+		test(t, manager)
+	})
+
+	t.Run("fts5", func(t *testing.T) {
+		manager := setupSearchManager(t)
+		if !manager.fullTextSearch {
+			t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+		}
+		test(t, manager)
+	})
+}
+
+func TestManager_SearchDevices(t *testing.T) {
+	searchModes(t, func(t *testing.T, manager *Manager) {
+		// Free text combined with a structured filter
+		names := searchNames(t, manager, DeviceSearchRequest{FreeText: "core", Vendor: "cisco"})
+		assert.ElementsMatch(t, []string{"core-sw-01", "lab-core-01"}, names)
+
+		names = searchNames(t, manager, DeviceSearchRequest{Name: "core", Tag: "production", Vendor: "cisco"})
+		assert.Equal(t, []string{"core-sw-01"}, names)
+
+		// Free text covers IP addresses and tags too
+		names = searchNames(t, manager, DeviceSearchRequest{FreeText: "10.1.0"})
+		assert.ElementsMatch(t, []string{"core-sw-01", "core-sw-02"}, names)
+
+		names = searchNames(t, manager, DeviceSearchRequest{FreeText: "edge"})
+		assert.Equal(t, []string{"edge-rtr-01"}, names)
+
+		// Tag matches whole tags, including ones with LIKE wildcards
+		names = searchNames(t, manager, DeviceSearchRequest{Tag: "prod"})
+		assert.Empty(t, names)
+		names = searchNames(t, manager, DeviceSearchRequest{Tag: "branch_office"})
+		assert.Equal(t, []string{"branch-fw-01"}, names)
+
+		names = searchNames(t, manager, DeviceSearchRequest{IPAddress: "10.2."})
+		assert.Equal(t, []string{"edge-rtr-01"}, names)
+
+		require.NoError(t, manager.UpdateDeviceStatus(mustDeviceByIP(t, manager, "10.1.0.2").ID,
+			string(StatusOnline), time.Now()))
+		names = searchNames(t, manager, DeviceSearchRequest{FreeText: "core", Status: string(StatusOnline)})
+		assert.Equal(t, []string{"core-sw-02"}, names)
+
+		// Query syntax in user input is taken literally
+		names = searchNames(t, manager, DeviceSearchRequest{FreeText: `core" OR "edge`})
+		assert.Empty(t, names)
+
+		// No filters lists every device in GetAllDevices order
+		names = searchNames(t, manager, DeviceSearchRequest{FreeText: "  -  "})
+		assert.Len(t, names, 5)
+		assert.Equal(t, "branch-fw-01", names[0])
+	})
+}
+
+func TestManager_SearchDevicesPaging(t *testing.T) {
+	searchModes(t, func(t *testing.T, manager *Manager) {
+		var seen []string
+		req := DeviceSearchRequest{FreeText: "01", Limit: 2}
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 5)
+
+			page, err := manager.SearchDevices(req)
+			require.NoError(t, err)
+			assert.Equal(t, "v5", page.Token)
+			for _, dto := range page.Devices {
+				seen = append(seen, dto.Name)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			req.Cursor = page.NextCursor
+		}
+		assert.ElementsMatch(t, []string{"core-sw-01", "edge-rtr-01", "lab-core-01", "branch-fw-01"}, seen)
+
+		_, err := manager.SearchDevices(DeviceSearchRequest{Cursor: "not-a-cursor"})
+		deviceErr, ok := err.(*DeviceError)
+		require.True(t, ok)
+		assert.Equal(t, ErrorTypeValidation, deviceErr.Type)
+		assert.Equal(t, "cursor", deviceErr.Field)
+	})
+}
+
+func TestManager_SearchIndexFollowsEdits(t *testing.T) {
+	searchModes(t, func(t *testing.T, manager *Manager) {
+		device := mustDeviceByIP(t, manager, "10.2.0.1")
+		device.Name = "spine-01"
+		require.NoError(t, manager.UpdateDevice(device))
+
+		assert.Empty(t, searchNames(t, manager, DeviceSearchRequest{FreeText: "edge-rtr"}))
+		assert.Equal(t, []string{"spine-01"}, searchNames(t, manager, DeviceSearchRequest{FreeText: "spine"}))
+
+		require.NoError(t, manager.DeleteDevice(device.ID))
+		assert.Empty(t, searchNames(t, manager, DeviceSearchRequest{FreeText: "spine"}))
+	})
+}
+
+func TestManager_SearchIndexRebuild(t *testing.T) {
+	manager := setupSearchManager(t)
+	if !manager.fullTextSearch {
+		t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+	}
+
+	// Devices written while the triggers were missing are picked up by the
+	// next manager
+	for _, trigger := range searchIndexTriggers {
+		_, err := manager.db.Exec("DROP TRIGGER " + trigger)
+		require.NoError(t, err)
+	}
+	device := createTestDevice()
+	device.Name = "unindexed-01"
+	device.IPAddress = "10.9.9.9"
+	require.NoError(t, manager.AddDevice(device))
+
+	restarted := NewManager(manager.db)
+	assert.Equal(t, []string{"unindexed-01"}, searchNames(t, restarted, DeviceSearchRequest{FreeText: "unindexed"}))
+}
+
+func mustDeviceByIP(t *testing.T, manager *Manager, ip string) *Device {
+	t.Helper()
+	device, err := manager.GetDeviceByIP(ip)
+	require.NoError(t, err)
+	return device
+}
+
+func TestFTSQuery(t *testing.T) {
+	assert.Equal(t, `"core"* "10.1"*`, ftsQuery(searchTerms("core  10.1 - ")))
+	assert.Equal(t, `"a""b"*`, ftsQuery(searchTerms(`a"b`)))
+	assert.Equal(t, `100\%\_x\\`, escapeLike(`100%_x\`))
+	assert.Equal(t, fmt.Sprint([]string(nil)), fmt.Sprint(searchTerms(" ... ")))
+}
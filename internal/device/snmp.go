@@ -0,0 +1,479 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SNMP polling defaults
+const (
+	// SysUpTimeOID is sysUpTime.0, the time since the agent last started in
+	// hundredths of a second
+	SysUpTimeOID = "1.3.6.1.2.1.1.3.0"
+
+	DefaultSNMPPort = 161
+
+	// snmpStatusPollTimeout bounds the SNMP poll TestConnectivity tries
+	// before the TCP probe, so devices without an agent are not held up long
+	snmpStatusPollTimeout = 2 * time.Second
+)
+
+// SNMP connectivity error codes reported in SNMPPollResult.ErrorCode
+const (
+	ConnectivityErrorNoCommunity  = "no_snmp_community"
+	ConnectivityErrorSNMPResponse = "snmp_error"
+)
+
+// BER tags used by SNMPv2c GET requests and responses
+const (
+	berInteger        = 0x02
+	berOctetString    = 0x04
+	berNull           = 0x05
+	berOID            = 0x06
+	berSequence       = 0x30
+	berTimeTicks      = 0x43
+	berNoSuchObject   = 0x80
+	berNoSuchInstance = 0x81
+	berEndOfMibView   = 0x82
+	pduGetRequest     = 0xA0
+	pduGetResponse    = 0xA2
+	snmpVersion2c     = 1
+)
+
+// SNMPPollResult is the outcome of reading sysUpTime from a device's SNMP
+// agent. Error and UptimeDuration are encoded by MarshalJSON as the error
+// message and milliseconds.
+type SNMPPollResult struct {
+	DeviceID       string        `json:"deviceId"`
+	Reachable      bool          `json:"reachable"`
+	UptimeTicks    uint32        `json:"uptimeTicks"`
+	UptimeDuration time.Duration `json:"-"`
+	Error          error         `json:"-"`
+	ErrorCode      string        `json:"errorCode,omitempty"`
+	PolledAt       time.Time     `json:"polledAt"`
+}
+
+// MarshalJSON encodes the error as its message and the uptime in
+// milliseconds
+func (r SNMPPollResult) MarshalJSON() ([]byte, error) {
+	type result SNMPPollResult
+	encoded := struct {
+		result
+		UptimeMs int64  `json:"uptimeMs"`
+		Error    string `json:"error,omitempty"`
+	}{result: result(r), UptimeMs: r.UptimeDuration.Milliseconds()}
+	if r.Error != nil {
+		encoded.Error = r.Error.Error()
+	}
+	return json.Marshal(encoded)
+}
+
+// SNMPPoll reads sysUpTime from a device with an SNMPv2c GET using the
+// device's community. It is lighter than an SSH probe and works on devices
+// with SSH disabled. A device that answers is marked online; one that does
+// not is reported in the result's Error rather than as an error, as
+// TestConnectivityWithContext does. Requests are resent up to maxRetries
+// times, since UDP datagrams can be dropped.
+func (s *ConnectivityScanner) SNMPPoll(ctx context.Context, device *Device) (*SNMPPollResult, error) {
+	if device == nil {
+		return nil, fmt.Errorf("device cannot be nil")
+	}
+	if device.IPAddress == "" {
+		return nil, fmt.Errorf("device IP address cannot be empty")
+	}
+
+	result := &SNMPPollResult{DeviceID: device.ID, PolledAt: time.Now()}
+	if device.SNMPCommunity == "" {
+		result.Error = fmt.Errorf("device %s has no SNMP community", device.Name)
+		result.ErrorCode = ConnectivityErrorNoCommunity
+		return result, nil
+	}
+
+	ticks, err := s.getSysUpTime(ctx, device.IPAddress, device.SNMPCommunity)
+	if err != nil {
+		var agentErr *snmpAgentError
+		if errors.As(err, &agentErr) {
+			// The agent answered, so the device is up even though it would
+			// not give its uptime
+			result.Reachable = true
+			result.ErrorCode = ConnectivityErrorSNMPResponse
+		} else {
+			result.ErrorCode = connectivityErrorCode(err, ConnectivityErrorUnreachable)
+		}
+		result.Error = fmt.Errorf("SNMP poll failed: %w", err)
+	} else {
+		result.Reachable = true
+		result.UptimeTicks = ticks
+		result.UptimeDuration = ticksToDuration(ticks)
+	}
+
+	if result.Reachable {
+		device.Status = string(StatusOnline)
+	}
+	return result, nil
+}
+
+// SetSNMPPort sets the UDP port SNMP polls are sent to
+func (s *ConnectivityScanner) SetSNMPPort(port int) {
+	s.snmpPort = port
+}
+
+// ticksToDuration converts TimeTicks, in hundredths of a second, to a
+// duration
+func ticksToDuration(ticks uint32) time.Duration {
+	return time.Duration(ticks) * 10 * time.Millisecond
+}
+
+// snmpAgentError is an error the agent answered with, as opposed to no
+// answer at all
+type snmpAgentError struct {
+	message string
+}
+
+func (e *snmpAgentError) Error() string {
+	return e.message
+}
+
+// getSysUpTime sends GET requests for sysUpTime.0 until one is answered,
+// the retries run out or ctx ends
+func (s *ConnectivityScanner) getSysUpTime(ctx context.Context, ipAddress, community string) (uint32, error) {
+	port := s.snmpPort
+	if port == 0 {
+		port = DefaultSNMPPort
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(ipAddress, strconv.Itoa(port)))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	// Unblock reads when ctx ends before an attempt times out
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	var lastErr error
+	for attempt := 0; attempt <= s.maxRetries; attempt++ {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		requestID, err := newRequestID()
+		if err != nil {
+			return 0, err
+		}
+		request, err := encodeGetRequest(community, requestID, SysUpTimeOID)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := conn.Write(request); err != nil {
+			return 0, err
+		}
+
+		deadline := time.Now().Add(s.timeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		conn.SetReadDeadline(deadline)
+
+		ticks, err := readSysUpTime(conn, requestID)
+		if err == nil {
+			return ticks, nil
+		}
+		var agentErr *snmpAgentError
+		if errors.As(err, &agentErr) {
+			return 0, err
+		}
+		lastErr = err
+	}
+
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	return 0, lastErr
+}
+
+// readSysUpTime reads datagrams until the response to requestID arrives,
+// ignoring stray ones such as late answers to an earlier attempt
+func readSysUpTime(conn net.Conn, requestID int32) (uint32, error) {
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		response, err := decodeGetResponse(buf[:n])
+		if err != nil || response.requestID != requestID {
+			continue
+		}
+		if response.errorStatus != 0 {
+			return 0, &snmpAgentError{message: fmt.Sprintf("agent returned error status %d", response.errorStatus)}
+		}
+		switch response.valueTag {
+		case berTimeTicks:
+			return uint32(decodeUnsigned(response.value)), nil
+		case berNoSuchObject, berNoSuchInstance, berEndOfMibView:
+			return 0, &snmpAgentError{message: "agent does not expose sysUpTime"}
+		default:
+			return 0, &snmpAgentError{message: fmt.Sprintf("unexpected sysUpTime type 0x%02x", response.valueTag)}
+		}
+	}
+}
+
+// newRequestID returns a random positive request ID
+func newRequestID() (int32, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(b[:]) & 0x7fffffff), nil
+}
+
+// snmpResponse is the part of a GetResponse an uptime poll reads: the
+// request it answers and the first variable binding
+type snmpResponse struct {
+	community   string
+	requestID   int32
+	errorStatus int64
+	oid         string
+	valueTag    byte
+	value       []byte
+}
+
+// encodeGetRequest encodes an SNMPv2c GetRequest for one OID
+func encodeGetRequest(community string, requestID int32, oid string) ([]byte, error) {
+	encodedOID, err := encodeOID(oid)
+	if err != nil {
+		return nil, err
+	}
+	binding := berTLV(berSequence, berTLV(berOID, encodedOID), berTLV(berNull))
+	pdu := berTLV(pduGetRequest,
+		berTLV(berInteger, encodeInteger(int64(requestID))),
+		berTLV(berInteger, encodeInteger(0)),
+		berTLV(berInteger, encodeInteger(0)),
+		berTLV(berSequence, binding))
+	return berTLV(berSequence,
+		berTLV(berInteger, encodeInteger(snmpVersion2c)),
+		berTLV(berOctetString, []byte(community)),
+		pdu), nil
+}
+
+// decodeGetResponse decodes an SNMPv2c GetResponse
+func decodeGetResponse(data []byte) (*snmpResponse, error) {
+	message, _, err := readValue(data, berSequence)
+	if err != nil {
+		return nil, err
+	}
+	version, message, err := readValue(message, berInteger)
+	if err != nil {
+		return nil, err
+	}
+	if decodeInteger(version) != snmpVersion2c {
+		return nil, fmt.Errorf("unsupported SNMP version %d", decodeInteger(version))
+	}
+	community, message, err := readValue(message, berOctetString)
+	if err != nil {
+		return nil, err
+	}
+	pdu, _, err := readValue(message, pduGetResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &snmpResponse{community: string(community)}
+	requestID, pdu, err := readValue(pdu, berInteger)
+	if err != nil {
+		return nil, err
+	}
+	response.requestID = int32(decodeInteger(requestID))
+	errorStatus, pdu, err := readValue(pdu, berInteger)
+	if err != nil {
+		return nil, err
+	}
+	response.errorStatus = decodeInteger(errorStatus)
+	if _, pdu, err = readValue(pdu, berInteger); err != nil {
+		return nil, err
+	}
+	bindings, _, err := readValue(pdu, berSequence)
+	if err != nil {
+		return nil, err
+	}
+	if len(bindings) == 0 {
+		if response.errorStatus != 0 {
+			return response, nil
+		}
+		return nil, fmt.Errorf("response has no variable bindings")
+	}
+	binding, _, err := readValue(bindings, berSequence)
+	if err != nil {
+		return nil, err
+	}
+	oid, binding, err := readValue(binding, berOID)
+	if err != nil {
+		return nil, err
+	}
+	response.oid = decodeOID(oid)
+	tag, value, _, err := readTLV(binding)
+	if err != nil {
+		return nil, err
+	}
+	response.valueTag = tag
+	response.value = value
+	return response, nil
+}
+
+// berTLV encodes a tag, the length of the joined contents and the contents
+func berTLV(tag byte, contents ...[]byte) []byte {
+	var body []byte
+	for _, content := range contents {
+		body = append(body, content...)
+	}
+	out := []byte{tag}
+	if len(body) < 0x80 {
+		out = append(out, byte(len(body)))
+	} else {
+		var length []byte
+		for n := len(body); n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, body...)
+}
+
+// readTLV splits the first element off data
+func readTLV(data []byte) (tag byte, value, rest []byte, err error) {
+	if len(data) < 2 {
+		return 0, nil, nil, fmt.Errorf("truncated BER element")
+	}
+	tag = data[0]
+	length := int(data[1])
+	offset := 2
+	if length&0x80 != 0 {
+		octets := length & 0x7f
+		if octets == 0 || octets > 4 || len(data) < offset+octets {
+			return 0, nil, nil, fmt.Errorf("invalid BER length")
+		}
+		length = 0
+		for _, b := range data[offset : offset+octets] {
+			length = length<<8 | int(b)
+		}
+		offset += octets
+	}
+	if length < 0 || len(data) < offset+length {
+		return 0, nil, nil, fmt.Errorf("truncated BER element")
+	}
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+// readValue splits the first element off data, which must have the tag want
+func readValue(data []byte, want byte) (value, rest []byte, err error) {
+	tag, value, rest, err := readTLV(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tag != want {
+		return nil, nil, fmt.Errorf("expected BER tag 0x%02x, got 0x%02x", want, tag)
+	}
+	return value, rest, nil
+}
+
+// encodeInteger encodes a signed integer in the fewest two's complement octets
+func encodeInteger(v int64) []byte {
+	out := []byte{byte(v)}
+	for v >>= 8; ; v >>= 8 {
+		last := out[0]
+		if (v == 0 && last&0x80 == 0) || (v == -1 && last&0x80 != 0) {
+			return out
+		}
+		out = append([]byte{byte(v)}, out...)
+	}
+}
+
+// decodeInteger decodes a signed two's complement integer
+func decodeInteger(data []byte) int64 {
+	var v int64
+	for i, b := range data {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+// decodeUnsigned decodes an unsigned integer such as TimeTicks, which
+// carries a leading zero octet when its top bit is set
+func decodeUnsigned(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// encodeOID encodes a dotted object identifier
+func encodeOID(oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+	arcs := make([]uint64, len(parts))
+	for i, part := range parts {
+		arc, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", oid)
+		}
+		arcs[i] = arc
+	}
+	if arcs[0] > 2 || (arcs[0] < 2 && arcs[1] > 39) {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+
+	out := encodeArc(arcs[0]*40 + arcs[1])
+	for _, arc := range arcs[2:] {
+		out = append(out, encodeArc(arc)...)
+	}
+	return out, nil
+}
+
+// encodeArc encodes an OID arc in base 128, high bit set on all but the last
+func encodeArc(arc uint64) []byte {
+	out := []byte{byte(arc & 0x7f)}
+	for arc >>= 7; arc > 0; arc >>= 7 {
+		out = append([]byte{byte(arc&0x7f) | 0x80}, out...)
+	}
+	return out
+}
+
+// decodeOID decodes an encoded object identifier to dotted form
+func decodeOID(data []byte) string {
+	var arcs []string
+	var arc uint64
+	for _, b := range data {
+		arc = arc<<7 | uint64(b&0x7f)
+		if b&0x80 != 0 {
+			continue
+		}
+		if len(arcs) == 0 {
+			first := arc / 40
+			if first > 2 {
+				first = 2
+			}
+			arcs = append(arcs, strconv.FormatUint(first, 10), strconv.FormatUint(arc-first*40, 10))
+		} else {
+			arcs = append(arcs, strconv.FormatUint(arc, 10))
+		}
+		arc = 0
+	}
+	return strings.Join(arcs, ".")
+}
@@ -0,0 +1,207 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockSNMPAgent answers SNMPv2c GetRequests on a random UDP port. respond
+// returns the tag and value bound to the requested OID; requests with
+// another community are dropped, as real agents do.
+type mockSNMPAgent struct {
+	conn      net.PacketConn
+	community string
+	respond   func(oid string) (byte, []byte)
+}
+
+func startSNMPAgent(t *testing.T, community string, respond func(oid string) (byte, []byte)) (*mockSNMPAgent, int) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start SNMP agent: %v", err)
+	}
+	agent := &mockSNMPAgent{conn: conn, community: community, respond: respond}
+	t.Cleanup(func() { conn.Close() })
+	return agent, conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// serve answers requests until the agent is closed, ignoring the first
+// dropped requests as a lossy network would
+func (a *mockSNMPAgent) serve(dropped int) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if dropped > 0 {
+			dropped--
+			continue
+		}
+		if response := a.answer(buf[:n]); response != nil {
+			a.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+// answer decodes a GetRequest and encodes the GetResponse to it
+func (a *mockSNMPAgent) answer(request []byte) []byte {
+	message, _, err := readValue(request, berSequence)
+	if err != nil {
+		return nil
+	}
+	_, message, _ = readValue(message, berInteger)
+	community, message, err := readValue(message, berOctetString)
+	if err != nil || string(community) != a.community {
+		return nil
+	}
+	pdu, _, err := readValue(message, pduGetRequest)
+	if err != nil {
+		return nil
+	}
+	requestID, pdu, _ := readValue(pdu, berInteger)
+	_, pdu, _ = readValue(pdu, berInteger)
+	_, pdu, _ = readValue(pdu, berInteger)
+	bindings, _, _ := readValue(pdu, berSequence)
+	binding, _, _ := readValue(bindings, berSequence)
+	oid, _, err := readValue(binding, berOID)
+	if err != nil {
+		return nil
+	}
+
+	tag, value := a.respond(decodeOID(oid))
+	return berTLV(berSequence,
+		berTLV(berInteger, encodeInteger(snmpVersion2c)),
+		berTLV(berOctetString, community),
+		berTLV(pduGetResponse,
+			berTLV(berInteger, requestID),
+			berTLV(berInteger, encodeInteger(0)),
+			berTLV(berInteger, encodeInteger(0)),
+			berTLV(berSequence, berTLV(berSequence, berTLV(berOID, oid), berTLV(tag, value)))))
+}
+
+func TestConnectivityScanner_SNMPPoll(t *testing.T) {
+	requested := make(chan string, 4)
+	// 0x80000000 ticks needs a leading zero octet to stay unsigned
+	agent, port := startSNMPAgent(t, "s3cret", func(oid string) (byte, []byte) {
+		requested <- oid
+		return berTimeTicks, []byte{0x00, 0x80, 0x00, 0x00, 0x00}
+	})
+	go agent.serve(1)
+
+	scanner := NewConnectivityScannerWithConfig(200*time.Millisecond, 2, 0)
+	scanner.SetSNMPPort(port)
+	device := &Device{ID: "dev1", Name: "Core Switch", IPAddress: "127.0.0.1", SNMPCommunity: "s3cret",
+		Status: string(StatusOffline)}
+
+	result, err := scanner.SNMPPoll(context.Background(), device)
+	if err != nil {
+		t.Fatalf("SNMPPoll failed: %v", err)
+	}
+	if result.Error != nil {
+		t.Fatalf("Expected a successful poll after a dropped request, got %v", result.Error)
+	}
+	if oid := <-requested; oid != SysUpTimeOID {
+		t.Errorf("Expected a GET for %s, got %s", SysUpTimeOID, oid)
+	}
+	if !result.Reachable || result.UptimeTicks != 0x80000000 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if want := time.Duration(0x80000000) * 10 * time.Millisecond; result.UptimeDuration != want {
+		t.Errorf("Expected uptime %v, got %v", want, result.UptimeDuration)
+	}
+	if device.Status != string(StatusOnline) {
+		t.Errorf("Expected the device to be marked online, got %s", device.Status)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("Failed to marshal result: %v", err)
+	}
+	if !strings.Contains(string(data), `"uptimeMs":21474836480`) || strings.Contains(string(data), `"error"`) {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+}
+
+func TestConnectivityScanner_SNMPPoll_Failures(t *testing.T) {
+	agent, port := startSNMPAgent(t, "public", func(oid string) (byte, []byte) {
+		return berNoSuchObject, nil
+	})
+	go agent.serve(0)
+
+	scanner := NewConnectivityScannerWithConfig(100*time.Millisecond, 1, 0)
+	scanner.SetSNMPPort(port)
+
+	// An agent without sysUpTime still shows the device is up
+	device := &Device{Name: "Edge", IPAddress: "127.0.0.1", SNMPCommunity: "public"}
+	result, err := scanner.SNMPPoll(context.Background(), device)
+	if err != nil {
+		t.Fatalf("SNMPPoll failed: %v", err)
+	}
+	if !result.Reachable || result.Error == nil || result.ErrorCode != ConnectivityErrorSNMPResponse {
+		t.Errorf("Expected a reachable device with an SNMP error, got %+v", result)
+	}
+
+	// A wrong community goes unanswered
+	device = &Device{Name: "Edge", IPAddress: "127.0.0.1", SNMPCommunity: "private", Status: string(StatusOffline)}
+	result, err = scanner.SNMPPoll(context.Background(), device)
+	if err != nil {
+		t.Fatalf("SNMPPoll failed: %v", err)
+	}
+	if result.Reachable || result.ErrorCode != ConnectivityErrorTimeout {
+		t.Errorf("Expected an unanswered poll to time out, got %+v", result)
+	}
+	if device.Status != string(StatusOffline) {
+		t.Errorf("Expected the status to be left alone, got %s", device.Status)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = scanner.SNMPPoll(ctx, device)
+	if err != nil || result.Reachable {
+		t.Errorf("Expected a cancelled poll to be unreachable, got %+v, %v", result, err)
+	}
+
+	result, err = scanner.SNMPPoll(context.Background(), &Device{Name: "Edge", IPAddress: "127.0.0.1"})
+	if err != nil || result.ErrorCode != ConnectivityErrorNoCommunity {
+		t.Errorf("Expected a device without a community to be reported, got %+v, %v", result, err)
+	}
+	if _, err := scanner.SNMPPoll(context.Background(), nil); err == nil {
+		t.Error("Expected an error for a nil device")
+	}
+}
+
+func TestSNMPEncoding(t *testing.T) {
+	for _, oid := range []string{SysUpTimeOID, "1.3.6.1.4.1.9.9.109.1.1.1.1.8.1", "2.999.3"} {
+		encoded, err := encodeOID(oid)
+		if err != nil {
+			t.Fatalf("encodeOID(%s) failed: %v", oid, err)
+		}
+		if got := decodeOID(encoded); got != oid {
+			t.Errorf("Expected %s to round trip, got %s", oid, got)
+		}
+	}
+	if _, err := encodeOID("1.40.1"); err == nil {
+		t.Error("Expected an invalid OID to be refused")
+	}
+
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 2147483647} {
+		if got := decodeInteger(encodeInteger(v)); got != v {
+			t.Errorf("Expected %d to round trip, got %d", v, got)
+		}
+	}
+	if got := encodeInteger(128); len(got) != 2 {
+		t.Errorf("Expected 128 to take two octets, got %x", got)
+	}
+
+	long := berTLV(berOctetString, make([]byte, 300))
+	if long[1] != 0x82 {
+		t.Errorf("Expected a long-form length, got %x", long[:4])
+	}
+	if _, value, _, err := readTLV(long); err != nil || len(value) != 300 {
+		t.Errorf("Expected to read 300 octets back, got %d, %v", len(value), err)
+	}
+}
@@ -0,0 +1,133 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// StreamBatchSize is the number of devices StreamDevices passes at a time
+const StreamBatchSize = MaxPageSize
+
+// StreamDevices passes every device matching filter to fn in batches of up
+// to StreamBatchSize, in ID order. Only one batch is held in memory at a
+// time, so fn must not keep the batch after returning. An error from fn
+// stops the stream and is returned.
+func (m *Manager) StreamDevices(filter DeviceSearchRequest, fn func(batch []Device) error) error {
+	return m.StreamDevicesContext(context.Background(), filter, "", fn)
+}
+
+// StreamDevicesContext is StreamDevices starting after the device with ID
+// afterID, or at the first device when afterID is empty. Each batch is read
+// with its own query keyed on the last ID passed, so devices added or
+// removed while streaming do not shift later batches. The stream stops
+// between batches when ctx ends. Free text matches as in SearchDevices;
+// the filter's Cursor and Limit are ignored.
+func (m *Manager) StreamDevicesContext(ctx context.Context, filter DeviceSearchRequest, afterID string, fn func(batch []Device) error) error {
+	conditions, args, err := m.streamConditions(filter)
+	if err != nil {
+		return err
+	}
+
+	query := `SELECT ` + deviceColumns + ` FROM devices WHERE ` +
+		strings.Join(append(conditions, `id > ?`), " AND ") + ` ORDER BY id LIMIT ?`
+
+	batch := make([]Device, 0, StreamBatchSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch = batch[:0]
+		if err := m.readStreamBatch(ctx, &batch, query, append(args, afterID, StreamBatchSize)...); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < StreamBatchSize {
+			return nil
+		}
+		afterID = batch[len(batch)-1].ID
+	}
+}
+
+// CountDevicesContext returns the number of devices matching filter, as
+// streamed by StreamDevicesContext
+func (m *Manager) CountDevicesContext(ctx context.Context, filter DeviceSearchRequest) (int, error) {
+	conditions, args, err := m.streamConditions(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `SELECT COUNT(*) FROM devices`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+
+	var count int
+	if err := m.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to count devices: %v", err),
+		}
+	}
+	return count, nil
+}
+
+// streamConditions returns the SQL conditions on the devices table, and
+// their arguments, selecting the devices a stream passes
+func (m *Manager) streamConditions(filter DeviceSearchRequest) ([]string, []interface{}, error) {
+	if err := filter.Sandbox.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	var conditions []string
+	var args []interface{}
+	if terms := searchTerms(filter.FreeText); len(terms) > 0 {
+		if m.fullTextSearch {
+			conditions = append(conditions, `id IN (SELECT id FROM `+searchIndexTable+` WHERE `+searchIndexTable+` MATCH ?)`)
+			args = append(args, ftsQuery(terms))
+		} else {
+			conditions, args = likeTermConditions(terms)
+		}
+	}
+
+	fieldConditions, fieldArgs := filter.fieldConditions()
+	return append(conditions, fieldConditions...), append(args, fieldArgs...), nil
+}
+
+// readStreamBatch appends the devices selected by query to batch
+func (m *Manager) readStreamBatch(ctx context.Context, batch *[]Device, query string, args ...interface{}) error {
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("failed to query devices: %v", err),
+		}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return &DeviceError{
+				Type:    ErrorTypeDatabase,
+				Message: fmt.Sprintf("failed to scan device row: %v", err),
+			}
+		}
+		*batch = append(*batch, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return &DeviceError{
+			Type:    ErrorTypeDatabase,
+			Message: fmt.Sprintf("error iterating over device rows: %v", err),
+		}
+	}
+	return nil
+}
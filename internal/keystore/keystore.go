@@ -0,0 +1,49 @@
+package keystore
+
+import (
+	"errors"
+
+	"github.com/zalando/go-keyring"
+)
+
+// ErrNotFound is returned when no secret is stored for a service and key
+var ErrNotFound = errors.New("secret not found in keystore")
+
+// Store provides access to secrets kept in OS-level secure storage
+type Store interface {
+	Get(service, key string) (string, error)
+	Set(service, key, value string) error
+	Delete(service, key string) error
+}
+
+// KeyringStore stores secrets in the macOS Keychain, the Windows Credential
+// Manager or the Secret Service on Linux
+type KeyringStore struct{}
+
+// NewKeyringStore creates a keystore backed by the OS keychain
+func NewKeyringStore() *KeyringStore {
+	return &KeyringStore{}
+}
+
+// Get retrieves a secret, returning ErrNotFound when none is stored
+func (s *KeyringStore) Get(service, key string) (string, error) {
+	value, err := keyring.Get(service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+// Set stores a secret, replacing any existing value
+func (s *KeyringStore) Set(service, key, value string) error {
+	return keyring.Set(service, key, value)
+}
+
+// Delete removes a secret, returning ErrNotFound when none is stored
+func (s *KeyringStore) Delete(service, key string) error {
+	err := keyring.Delete(service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
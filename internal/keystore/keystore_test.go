@@ -0,0 +1,35 @@
+package keystore
+
+import (
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestKeyringStore(t *testing.T) {
+	keyring.MockInit()
+	store := NewKeyringStore()
+
+	if _, err := store.Get("invictux", "encryption_key"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound before a secret is stored, got %v", err)
+	}
+
+	if err := store.Set("invictux", "encryption_key", "secret"); err != nil {
+		t.Fatalf("Failed to store secret: %v", err)
+	}
+
+	value, err := store.Get("invictux", "encryption_key")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if value != "secret" {
+		t.Errorf("Expected stored secret, got %q", value)
+	}
+
+	if err := store.Delete("invictux", "encryption_key"); err != nil {
+		t.Fatalf("Failed to delete secret: %v", err)
+	}
+	if err := store.Delete("invictux", "encryption_key"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound when deleting a missing secret, got %v", err)
+	}
+}
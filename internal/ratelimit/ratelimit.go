@@ -0,0 +1,228 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Methods with a default limit, named after the App bindings they guard
+const (
+	MethodRunSecurityCheck       = "RunSecurityCheck"
+	MethodRunBulkSecurityChecks  = "RunBulkSecurityChecks"
+	MethodTestDeviceConnectivity = "TestDeviceConnectivity"
+	MethodImportFromNetBox       = "ImportFromNetBox"
+)
+
+// Limit is the token bucket of one method: a call takes a token, a token is
+// added every IntervalMs and at most Burst are kept. A zero interval leaves
+// the method unlimited. PerKey gives every key, such as a device ID, its own
+// bucket instead of one shared by all calls.
+type Limit struct {
+	IntervalMs int64 `json:"intervalMs"`
+	Burst      int   `json:"burst"`
+	PerKey     bool  `json:"perKey,omitempty"`
+}
+
+// Validate checks that the limit can be applied
+func (l Limit) Validate() error {
+	if l.IntervalMs < 0 {
+		return fmt.Errorf("rate limit interval cannot be negative")
+	}
+	if l.IntervalMs > 0 && l.Burst < 1 {
+		return fmt.Errorf("rate limit burst must be at least 1")
+	}
+	return nil
+}
+
+// interval returns the time between tokens
+func (l Limit) interval() time.Duration {
+	return time.Duration(l.IntervalMs) * time.Millisecond
+}
+
+// DefaultLimits returns the limits of the expensive App bindings
+func DefaultLimits() map[string]Limit {
+	return map[string]Limit{
+		MethodRunSecurityCheck:       {IntervalMs: 1000, Burst: 1, PerKey: true},
+		MethodRunBulkSecurityChecks:  {IntervalMs: 60 * 1000, Burst: 1},
+		MethodTestDeviceConnectivity: {IntervalMs: 200, Burst: 5},
+		MethodImportFromNetBox:       {IntervalMs: 5 * 60 * 1000, Burst: 1},
+	}
+}
+
+// ErrRateLimited is returned for a call made before its method's bucket
+// holds a token. RetryAfter is how long until the call would be allowed.
+type ErrRateLimited struct {
+	Method     string        `json:"method"`
+	Key        string        `json:"key,omitempty"`
+	RetryAfter time.Duration `json:"retryAfter"`
+}
+
+func (e *ErrRateLimited) Error() string {
+	retryAfter := e.RetryAfter.Round(time.Millisecond)
+	if e.Key != "" {
+		return fmt.Sprintf("%s for %s is rate limited, retry in %s", e.Method, e.Key, retryAfter)
+	}
+	return fmt.Sprintf("%s is rate limited, retry in %s", e.Method, retryAfter)
+}
+
+// RateLimitStatus is the state of one bucket. NextRefill is when the next
+// token is added and is zero while the bucket is full.
+type RateLimitStatus struct {
+	Method     string    `json:"method"`
+	Key        string    `json:"key,omitempty"`
+	Limit      Limit     `json:"limit"`
+	Tokens     float64   `json:"tokens"`
+	NextRefill time.Time `json:"nextRefill,omitempty"`
+}
+
+// bucketKey identifies the bucket of a method and key
+type bucketKey struct {
+	method string
+	key    string
+}
+
+// AppRateLimiter keeps a token bucket per rate-limited App method, or per
+// method and key for PerKey limits. It is safe for concurrent use.
+type AppRateLimiter struct {
+	mu      sync.Mutex
+	limits  map[string]Limit
+	buckets map[bucketKey]*rate.Limiter
+
+	// now returns the current time; tests replace it
+	now func() time.Time
+}
+
+// NewAppRateLimiter creates a limiter applying limits. Methods without a
+// limit are never limited.
+func NewAppRateLimiter(limits map[string]Limit) *AppRateLimiter {
+	l := &AppRateLimiter{
+		limits:  make(map[string]Limit, len(limits)),
+		buckets: make(map[bucketKey]*rate.Limiter),
+		now:     time.Now,
+	}
+	for method, limit := range limits {
+		l.limits[method] = limit
+	}
+	return l
+}
+
+// Allow takes a token for a call of method with key, returning an
+// *ErrRateLimited without taking one when the bucket is empty. The key is
+// ignored unless the method's limit is PerKey.
+func (l *AppRateLimiter) Allow(method, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit, ok := l.limits[method]
+	if !ok || limit.IntervalMs == 0 {
+		return nil
+	}
+	if !limit.PerKey {
+		key = ""
+	}
+
+	bucket := l.bucket(method, key, limit)
+	now := l.now()
+	reservation := bucket.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return &ErrRateLimited{Method: method, Key: key, RetryAfter: delay}
+	}
+	return nil
+}
+
+// bucket returns the bucket of a method and key, creating a full one. mu
+// must be held.
+func (l *AppRateLimiter) bucket(method, key string, limit Limit) *rate.Limiter {
+	id := bucketKey{method, key}
+	bucket, ok := l.buckets[id]
+	if !ok {
+		bucket = rate.NewLimiter(rate.Every(limit.interval()), limit.Burst)
+		l.buckets[id] = bucket
+	}
+	return bucket
+}
+
+// SetLimit replaces the limit of a method and refills its buckets
+func (l *AppRateLimiter) SetLimit(method string, limit Limit) error {
+	if err := limit.Validate(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits[method] = limit
+	for id := range l.buckets {
+		if id.method == method {
+			delete(l.buckets, id)
+		}
+	}
+	return nil
+}
+
+// Limits returns the limit of every method
+func (l *AppRateLimiter) Limits() map[string]Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits := make(map[string]Limit, len(l.limits))
+	for method, limit := range l.limits {
+		limits[method] = limit
+	}
+	return limits
+}
+
+// Status returns the state of every limited method's buckets, keyed by
+// method or by "method:key" for the buckets of PerKey limits. Methods
+// without a bucket yet are reported full. Full per-key buckets are dropped,
+// as a new bucket for their key would be the same.
+func (l *AppRateLimiter) Status() map[string]RateLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	statuses := make(map[string]RateLimitStatus)
+	for method, limit := range l.limits {
+		if limit.IntervalMs == 0 {
+			continue
+		}
+		statuses[method] = RateLimitStatus{Method: method, Limit: limit, Tokens: float64(limit.Burst)}
+	}
+
+	ids := make([]bucketKey, 0, len(l.buckets))
+	for id := range l.buckets {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].method < ids[j].method || ids[i].method == ids[j].method && ids[i].key < ids[j].key
+	})
+
+	for _, id := range ids {
+		limit := l.limits[id.method]
+		tokens := l.buckets[id].TokensAt(now)
+		if tokens > float64(limit.Burst) {
+			tokens = float64(limit.Burst)
+		}
+		status := RateLimitStatus{Method: id.method, Key: id.key, Limit: limit, Tokens: tokens}
+		if tokens < float64(limit.Burst) {
+			missing := math.Floor(tokens) + 1 - tokens
+			status.NextRefill = now.Add(time.Duration(missing * float64(limit.interval())))
+		}
+
+		if id.key == "" {
+			statuses[id.method] = status
+			continue
+		}
+		if tokens >= float64(limit.Burst) {
+			delete(l.buckets, id)
+			continue
+		}
+		statuses[id.method+":"+id.key] = status
+	}
+	return statuses
+}
@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter with the default limits on a clock the
+// test moves with advance
+func newTestLimiter() (*AppRateLimiter, func(time.Duration)) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewAppRateLimiter(DefaultLimits())
+	limiter.now = func() time.Time { return now }
+	return limiter, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAppRateLimiter_SecondCallLimited(t *testing.T) {
+	limiter, advance := newTestLimiter()
+
+	if err := limiter.Allow(MethodRunBulkSecurityChecks, ""); err != nil {
+		t.Fatalf("Expected the first call to be allowed, got %v", err)
+	}
+
+	err := limiter.Allow(MethodRunBulkSecurityChecks, "")
+	var limited *ErrRateLimited
+	if !errors.As(err, &limited) {
+		t.Fatalf("Expected the second call within a minute to be rate limited, got %v", err)
+	}
+	if limited.Method != MethodRunBulkSecurityChecks || limited.RetryAfter != time.Minute {
+		t.Errorf("Expected to retry the bulk run in a minute, got %+v", limited)
+	}
+
+	// A refused call does not take a token
+	advance(30 * time.Second)
+	if err := limiter.Allow(MethodRunBulkSecurityChecks, ""); !errors.As(err, &limited) || limited.RetryAfter != 30*time.Second {
+		t.Errorf("Expected 30s left after half the interval, got %v", err)
+	}
+	advance(30 * time.Second)
+	if err := limiter.Allow(MethodRunBulkSecurityChecks, ""); err != nil {
+		t.Errorf("Expected the call to be allowed after the interval, got %v", err)
+	}
+}
+
+func TestAppRateLimiter_PerKey(t *testing.T) {
+	limiter, advance := newTestLimiter()
+
+	if err := limiter.Allow(MethodRunSecurityCheck, "device-1"); err != nil {
+		t.Fatalf("Expected the first check to be allowed, got %v", err)
+	}
+	if err := limiter.Allow(MethodRunSecurityCheck, "device-2"); err != nil {
+		t.Errorf("Expected another device to have its own bucket, got %v", err)
+	}
+	var limited *ErrRateLimited
+	if err := limiter.Allow(MethodRunSecurityCheck, "device-1"); !errors.As(err, &limited) || limited.Key != "device-1" {
+		t.Errorf("Expected the second check of device-1 to be rate limited, got %v", err)
+	}
+
+	advance(time.Second)
+	if err := limiter.Allow(MethodRunSecurityCheck, "device-1"); err != nil {
+		t.Errorf("Expected the check to be allowed a second later, got %v", err)
+	}
+}
+
+func TestAppRateLimiter_Burst(t *testing.T) {
+	limiter, advance := newTestLimiter()
+
+	for i := 0; i < 5; i++ {
+		if err := limiter.Allow(MethodTestDeviceConnectivity, "device-1"); err != nil {
+			t.Fatalf("Expected call %d of the burst to be allowed, got %v", i+1, err)
+		}
+	}
+	if err := limiter.Allow(MethodTestDeviceConnectivity, "device-2"); err == nil {
+		t.Error("Expected the sixth call in the same instant to be rate limited")
+	}
+	advance(200 * time.Millisecond)
+	if err := limiter.Allow(MethodTestDeviceConnectivity, ""); err != nil {
+		t.Errorf("Expected a token after 200ms, got %v", err)
+	}
+}
+
+func TestAppRateLimiter_Unlimited(t *testing.T) {
+	limiter, _ := newTestLimiter()
+
+	for i := 0; i < 10; i++ {
+		if err := limiter.Allow("GetDevices", ""); err != nil {
+			t.Fatalf("Expected a method without a limit to be allowed, got %v", err)
+		}
+	}
+
+	if err := limiter.SetLimit(MethodRunBulkSecurityChecks, Limit{}); err != nil {
+		t.Fatalf("SetLimit failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := limiter.Allow(MethodRunBulkSecurityChecks, ""); err != nil {
+			t.Fatalf("Expected a zero interval to remove the limit, got %v", err)
+		}
+	}
+}
+
+func TestAppRateLimiter_SetLimit(t *testing.T) {
+	limiter, _ := newTestLimiter()
+
+	if err := limiter.Allow(MethodImportFromNetBox, ""); err != nil {
+		t.Fatalf("Expected the first import to be allowed, got %v", err)
+	}
+	if err := limiter.SetLimit(MethodImportFromNetBox, Limit{IntervalMs: 1000, Burst: 2}); err != nil {
+		t.Fatalf("SetLimit failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := limiter.Allow(MethodImportFromNetBox, ""); err != nil {
+			t.Errorf("Expected a new limit to start with a full bucket, got %v", err)
+		}
+	}
+	if limits := limiter.Limits(); limits[MethodImportFromNetBox].Burst != 2 {
+		t.Errorf("Expected the new limit to be returned, got %+v", limits[MethodImportFromNetBox])
+	}
+
+	for _, invalid := range []Limit{{IntervalMs: -1, Burst: 1}, {IntervalMs: 1000}} {
+		if err := limiter.SetLimit(MethodImportFromNetBox, invalid); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestAppRateLimiter_Status(t *testing.T) {
+	limiter, advance := newTestLimiter()
+
+	status := limiter.Status()
+	if len(status) != len(DefaultLimits()) {
+		t.Fatalf("Expected a status per limited method, got %+v", status)
+	}
+	if bulk := status[MethodRunBulkSecurityChecks]; bulk.Tokens != 1 || !bulk.NextRefill.IsZero() {
+		t.Errorf("Expected an unused bucket to be full, got %+v", bulk)
+	}
+
+	start := limiter.now()
+	for i := 0; i < 3; i++ {
+		limiter.Allow(MethodTestDeviceConnectivity, "")
+	}
+	limiter.Allow(MethodRunSecurityCheck, "device-1")
+	advance(100 * time.Millisecond)
+
+	status = limiter.Status()
+	connectivity := status[MethodTestDeviceConnectivity]
+	if connectivity.Tokens != 2.5 {
+		t.Errorf("Expected 2.5 tokens after half an interval, got %v", connectivity.Tokens)
+	}
+	if want := start.Add(200 * time.Millisecond); !connectivity.NextRefill.Equal(want) {
+		t.Errorf("Expected the next token at %v, got %v", want, connectivity.NextRefill)
+	}
+	device := status[MethodRunSecurityCheck+":device-1"]
+	if device.Key != "device-1" || math.Abs(device.Tokens-0.1) > 1e-9 {
+		t.Errorf("Expected the device bucket to be reported, got %+v", device)
+	}
+	if !device.NextRefill.Equal(start.Add(time.Second)) {
+		t.Errorf("Expected the device bucket to refill a second after the check, got %v", device.NextRefill)
+	}
+
+	// Per-key buckets that refilled are dropped
+	advance(time.Second)
+	if _, ok := limiter.Status()[MethodRunSecurityCheck+":device-1"]; ok {
+		t.Error("Expected the refilled device bucket to be dropped")
+	}
+}
@@ -0,0 +1,291 @@
+package report
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+)
+
+// Names of the fixed files of an evidence bundle
+const (
+	BundleManifestFile     = "manifest.json"
+	BundleConnectivityFile = "connectivity.txt"
+	BundlePostureFile      = "ssh-posture.txt"
+	bundleRulesDir         = "rules/"
+)
+
+// bundleTruncationNote heads evidence taken from the stored result rather
+// than the full command output
+const bundleTruncationNote = "Note: the full command output was not kept; this is the evidence stored with the result, which may be truncated."
+
+// bundleFileNameUnsafe matches runs of characters left out of file names
+var bundleFileNameUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// EvidenceBundle is the data an evidence bundle is built from: one device
+// and the results it produced in one run
+type EvidenceBundle struct {
+	Device     device.Device
+	RunID      string
+	Run        *checker.RunMetadata
+	AppVersion string
+	Results    []checker.CheckResult
+
+	// Commands holds the command each rule ran on the device, by check name
+	Commands map[string]string
+
+	// FullOutputs holds the complete output of commands whose output was
+	// kept in full, such as the archived configuration, by command
+	FullOutputs map[string]string
+
+	// Connectivity and Posture are written when present
+	Connectivity *device.ConnectivityResult
+	Posture      *checker.SSHPosture
+
+	// IncludeSecrets leaves passwords, keys and SNMP communities in the
+	// bundle. They are redacted otherwise.
+	IncludeSecrets bool
+	GeneratedAt    time.Time
+}
+
+// BundleManifest describes the contents of an evidence bundle
+type BundleManifest struct {
+	GeneratedAt     time.Time      `json:"generatedAt"`
+	AppVersion      string         `json:"appVersion"`
+	SecretsIncluded bool           `json:"secretsIncluded"`
+	Device          BundleDevice   `json:"device"`
+	Run             BundleRun      `json:"run"`
+	Files           []string       `json:"files"`
+	Statuses        map[string]int `json:"statuses"`
+}
+
+// BundleDevice is the device described in a manifest, without credentials
+type BundleDevice struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	IPAddress     string `json:"ipAddress"`
+	DeviceType    string `json:"deviceType"`
+	Vendor        string `json:"vendor"`
+	SSHPort       int    `json:"sshPort"`
+	Tags          string `json:"tags,omitempty"`
+	SNMPCommunity string `json:"snmpCommunity,omitempty"`
+}
+
+// BundleRun is the run described in a manifest
+type BundleRun struct {
+	RunID      string    `json:"runId"`
+	Label      string    `json:"label,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Checks     int       `json:"checks"`
+}
+
+// GenerateEvidenceBundle writes a zip archive of the evidence a device
+// produced in a run, for sharing with vendor support: a manifest, one text
+// file per executed rule under rules/, and the connectivity and SSH posture
+// detail when present. Evidence is the full command output where it was
+// kept and the stored evidence with a truncation note otherwise.
+func (g *Generator) GenerateEvidenceBundle(bundle EvidenceBundle, w io.Writer) error {
+	redact := ssh.RedactOutput
+	if bundle.IncludeSecrets {
+		redact = func(s string) string { return s }
+	}
+
+	manifest := BundleManifest{
+		GeneratedAt:     bundle.GeneratedAt,
+		AppVersion:      bundle.AppVersion,
+		SecretsIncluded: bundle.IncludeSecrets,
+		Device: BundleDevice{
+			ID:         bundle.Device.ID,
+			Name:       bundle.Device.Name,
+			IPAddress:  bundle.Device.IPAddress,
+			DeviceType: bundle.Device.DeviceType,
+			Vendor:     bundle.Device.Vendor,
+			SSHPort:    bundle.Device.SSHPort,
+			Tags:       bundle.Device.Tags,
+		},
+		Run:      BundleRun{RunID: bundle.RunID, Checks: len(bundle.Results)},
+		Files:    []string{},
+		Statuses: make(map[string]int),
+	}
+	if bundle.IncludeSecrets {
+		manifest.Device.SNMPCommunity = bundle.Device.SNMPCommunity
+	}
+	if bundle.Run != nil {
+		manifest.Run.Label = bundle.Run.Label
+		manifest.Run.Note = bundle.Run.Note
+	}
+
+	files := make(map[string]string)
+	var order []string
+	add := func(name, content string) {
+		files[name] = content
+		order = append(order, name)
+	}
+
+	for i, result := range bundle.Results {
+		if manifest.Run.StartedAt.IsZero() || result.CheckedAt.Before(manifest.Run.StartedAt) {
+			manifest.Run.StartedAt = result.CheckedAt
+		}
+		if result.CheckedAt.After(manifest.Run.FinishedAt) {
+			manifest.Run.FinishedAt = result.CheckedAt
+		}
+		manifest.Statuses[result.Status]++
+
+		if result.CheckType == "network" {
+			continue
+		}
+		add(bundleRuleFileName(i+1, result.CheckName), g.bundleRuleFile(bundle, result, redact))
+	}
+
+	if connectivity := bundleConnectivityFile(bundle, redact); connectivity != "" {
+		add(BundleConnectivityFile, connectivity)
+	}
+	if bundle.Posture != nil {
+		add(BundlePostureFile, bundlePostureFile(*bundle.Posture))
+	}
+	manifest.Files = append(manifest.Files, order...)
+
+	archive := zip.NewWriter(w)
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeBundleFile(archive, BundleManifestFile, string(encoded), bundle.GeneratedAt); err != nil {
+		return err
+	}
+	for _, name := range order {
+		if err := writeBundleFile(archive, name, files[name], bundle.GeneratedAt); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to write evidence bundle: %w", err)
+	}
+	return nil
+}
+
+// bundleRuleFile describes one rule's result and its evidence
+func (g *Generator) bundleRuleFile(bundle EvidenceBundle, result checker.CheckResult, redact func(string) string) string {
+	var b strings.Builder
+	command := bundle.Commands[result.CheckName]
+
+	fmt.Fprintf(&b, "Rule: %s\n", result.CheckName)
+	fmt.Fprintf(&b, "Status: %s\n", result.Status)
+	fmt.Fprintf(&b, "Severity: %s\n", result.Severity)
+	if result.Fingerprint != "" {
+		fmt.Fprintf(&b, "Fingerprint: %s\n", result.Fingerprint)
+		fmt.Fprintf(&b, "Finding category: %s\n", result.FindingCategory)
+	}
+	if command != "" {
+		fmt.Fprintf(&b, "Command: %s\n", redact(command))
+	}
+	if result.CommandVariant != "" {
+		fmt.Fprintf(&b, "Command variant: %s\n", result.CommandVariant)
+	}
+	fmt.Fprintf(&b, "Checked at: %s\n", result.CheckedAt.UTC().Format(time.RFC3339Nano))
+	if result.CarriedForward {
+		fmt.Fprintf(&b, "Carried forward from run: %s\n", result.CarriedFromRunID)
+		if result.EvaluatedAt != nil {
+			fmt.Fprintf(&b, "Evaluated at: %s\n", result.EvaluatedAt.UTC().Format(time.RFC3339Nano))
+		}
+	}
+	fmt.Fprintf(&b, "Duration: %s\n", result.Duration)
+	fmt.Fprintf(&b, "Message: %s\n", redact(result.RenderMessage(g.locale)))
+	if result.EvidenceStream != "" {
+		fmt.Fprintf(&b, "Evidence stream: %s\n", result.EvidenceStream)
+	}
+
+	b.WriteString("\n--- Evidence ---\n")
+	if output, ok := bundle.FullOutputs[command]; ok && command != "" {
+		b.WriteString(redact(output))
+	} else if output, ok := result.FullEvidence(); ok {
+		b.WriteString(redact(output))
+	} else {
+		b.WriteString(bundleTruncationNote + "\n\n")
+		b.WriteString(redact(result.Evidence))
+	}
+	if !strings.HasSuffix(b.String(), "\n") {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// bundleConnectivityFile describes the connectivity probe and any network
+// check results of the run, or returns an empty string when there are none
+func bundleConnectivityFile(bundle EvidenceBundle, redact func(string) string) string {
+	var b strings.Builder
+	if probe := bundle.Connectivity; probe != nil {
+		b.WriteString("Connectivity probe\n")
+		fmt.Fprintf(&b, "Tested at: %s\n", probe.TestedAt.UTC().Format(time.RFC3339Nano))
+		fmt.Fprintf(&b, "Network reachable: %t\n", probe.NetworkReachable)
+		fmt.Fprintf(&b, "SSH port open: %t\n", probe.SSHPortOpen)
+		fmt.Fprintf(&b, "Response time: %s\n", probe.ResponseTime)
+		if probe.Error != nil {
+			fmt.Fprintf(&b, "Error: %s\n", redact(probe.Error.Error()))
+		}
+		if probe.ErrorCode != "" {
+			fmt.Fprintf(&b, "Error code: %s\n", probe.ErrorCode)
+		}
+	}
+
+	for _, result := range bundle.Results {
+		if result.CheckType != "network" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s\n", result.CheckName)
+		fmt.Fprintf(&b, "Status: %s\n", result.Status)
+		fmt.Fprintf(&b, "Checked at: %s\n", result.CheckedAt.UTC().Format(time.RFC3339Nano))
+		fmt.Fprintf(&b, "Evidence: %s\n", redact(result.Evidence))
+	}
+	return b.String()
+}
+
+// bundlePostureFile describes the SSH algorithms the device negotiated
+func bundlePostureFile(posture checker.SSHPosture) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Observed at: %s\n", posture.ObservedAt.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "Server version: %s\n", posture.ServerVersion)
+	fmt.Fprintf(&b, "Key exchange: %s\n", posture.KeyExchange)
+	fmt.Fprintf(&b, "Host key: %s\n", posture.HostKey)
+	fmt.Fprintf(&b, "Cipher: %s\n", posture.Cipher)
+	fmt.Fprintf(&b, "MAC: %s\n", posture.MAC)
+	if len(posture.Weak) > 0 {
+		fmt.Fprintf(&b, "Weak algorithms: %s\n", strings.Join(posture.Weak, ", "))
+	}
+	return b.String()
+}
+
+// bundleRuleFileName names the file of the nth rule, keeping the rules in
+// run order when listed
+func bundleRuleFileName(n int, checkName string) string {
+	slug := strings.Trim(bundleFileNameUnsafe.ReplaceAllString(strings.ToLower(checkName), "-"), "-")
+	if slug == "" {
+		slug = "rule"
+	}
+	return fmt.Sprintf("%s%03d-%s.txt", bundleRulesDir, n, slug)
+}
+
+// writeBundleFile adds one file to the archive
+func writeBundleFile(archive *zip.Writer, name, content string, modified time.Time) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified}
+	f, err := archive.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to evidence bundle: %w", name, err)
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		return fmt.Errorf("failed to write %s to evidence bundle: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bundleFixture returns a bundle of a run with a config rule whose full
+// output was kept, one with only stored evidence and a port scan
+func bundleFixture() EvidenceBundle {
+	started := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	evaluated := started.Add(-24 * time.Hour)
+	return EvidenceBundle{
+		Device: device.Device{ID: "router1", Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: "router",
+			Vendor: "cisco", Username: "admin", PasswordEncrypted: []byte("ciphertext"), SSHPort: 22,
+			SNMPCommunity: "c0mmunity", Tags: "core"},
+		RunID:      "run-1",
+		Run:        &checker.RunMetadata{RunID: "run-1", Label: "CHG-1234", Note: "TAC case 6912"},
+		AppVersion: "1.0.0",
+		Results: []checker.CheckResult{
+			{ID: "r1", DeviceID: "router1", CheckName: "Check Service Password Encryption", CheckType: "configuration",
+				Severity: "High", Status: string(checker.StatusFail), Message: "Pattern not found",
+				Evidence: "enable secret 5 $1$abc", CheckedAt: started, Duration: 1200 * time.Millisecond,
+				CommandVariant: checker.VariantBase, EvidenceStream: checker.StreamCombined, RunID: "run-1",
+				FindingCategory: checker.FindingAuthentication, Fingerprint: "5e1f"},
+			{ID: "r2", DeviceID: "router1", CheckName: "Check SNMP / Community", CheckType: "configuration",
+				Severity: "Medium", Status: string(checker.StatusPass), Message: "Pattern found",
+				Evidence: "snmp-server community c0mmunity RO", CheckedAt: started.Add(2 * time.Second), RunID: "run-1",
+				CarriedForward: true, CarriedFromRunID: "run-0", EvaluatedAt: &evaluated},
+			{ID: "r3", DeviceID: "router1", CheckName: checker.PortExposureCheckName, CheckType: "network",
+				Severity: "High", Status: string(checker.StatusPass), Evidence: "open: 22; closed: 23; filtered: none",
+				CheckedAt: started.Add(3 * time.Second), RunID: "run-1"},
+		},
+		Commands: map[string]string{
+			"Check Service Password Encryption": "show running-config",
+			"Check SNMP / Community":            "show snmp community",
+		},
+		FullOutputs: map[string]string{
+			"show running-config": "hostname core\nenable secret 5 $1$abc\nusername admin password 0 hunter2\nservice timestamps\n",
+		},
+		Connectivity: &device.ConnectivityResult{NetworkReachable: true, SSHPortOpen: false,
+			ResponseTime: 12 * time.Millisecond, Error: errors.New("SSH port test failed: password hunter2 rejected"),
+			ErrorCode: "port_closed", TestedAt: started.Add(-time.Second)},
+		Posture: &checker.SSHPosture{DeviceID: "router1", KeyExchange: "diffie-hellman-group1-sha1",
+			HostKey: "ssh-rsa", Cipher: "aes128-ctr", MAC: "hmac-sha1", ServerVersion: "SSH-2.0-Cisco-1.25",
+			ObservedAt: started.Add(-time.Second), Weak: []string{"diffie-hellman-group1-sha1"}},
+		GeneratedAt: started.Add(time.Hour),
+	}
+}
+
+// readBundle unzips a bundle into its files, in archive order
+func readBundle(t *testing.T, data []byte) ([]string, map[string]string) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	var names []string
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		names = append(names, f.Name)
+		files[f.Name] = string(content)
+	}
+	return names, files
+}
+
+func TestGenerator_GenerateEvidenceBundle_EvidenceFile(t *testing.T) {
+	bundle := bundleFixture()
+	bundle.Results[1].EvidenceFullPath = filepath.Join(t.TempDir(), "r2.txt")
+	require.NoError(t, os.WriteFile(bundle.Results[1].EvidenceFullPath,
+		[]byte("snmp-server community c0mmunity RO\nsnmp-server location rack 4\n"), 0600))
+
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GenerateEvidenceBundle(bundle, &out))
+	_, files := readBundle(t, out.Bytes())
+
+	snmp := files["rules/002-check-snmp-community.txt"]
+	assert.Contains(t, snmp, "snmp-server location rack 4\n", "the full output file is read")
+	assert.NotContains(t, snmp, bundleTruncationNote)
+	assert.NotContains(t, snmp, "c0mmunity")
+
+	// A file deleted by retention falls back to the stored evidence
+	require.NoError(t, os.Remove(bundle.Results[1].EvidenceFullPath))
+	out.Reset()
+	require.NoError(t, NewGenerator("").GenerateEvidenceBundle(bundle, &out))
+	_, files = readBundle(t, out.Bytes())
+	assert.Contains(t, files["rules/002-check-snmp-community.txt"], bundleTruncationNote)
+}
+
+func TestGenerator_GenerateEvidenceBundle(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GenerateEvidenceBundle(bundleFixture(), &out))
+	names, files := readBundle(t, out.Bytes())
+
+	assert.Equal(t, []string{
+		BundleManifestFile,
+		"rules/001-check-service-password-encryption.txt",
+		"rules/002-check-snmp-community.txt",
+		BundleConnectivityFile,
+		BundlePostureFile,
+	}, names)
+
+	var manifest BundleManifest
+	require.NoError(t, json.Unmarshal([]byte(files[BundleManifestFile]), &manifest))
+	assert.Equal(t, "1.0.0", manifest.AppVersion)
+	assert.False(t, manifest.SecretsIncluded)
+	assert.Equal(t, BundleDevice{ID: "router1", Name: "Core Router", IPAddress: "10.0.0.1", DeviceType: "router",
+		Vendor: "cisco", SSHPort: 22, Tags: "core"}, manifest.Device)
+	assert.Equal(t, "run-1", manifest.Run.RunID)
+	assert.Equal(t, "CHG-1234", manifest.Run.Label)
+	assert.Equal(t, 3, manifest.Run.Checks)
+	assert.Equal(t, bundleFixture().Results[0].CheckedAt, manifest.Run.StartedAt)
+	assert.Equal(t, bundleFixture().Results[2].CheckedAt, manifest.Run.FinishedAt)
+	assert.Equal(t, names[1:], manifest.Files)
+	assert.Equal(t, map[string]int{"FAIL": 1, "PASS": 2}, manifest.Statuses)
+	assert.NotContains(t, files[BundleManifestFile], "admin", "credentials are left out of the manifest")
+	assert.NotContains(t, files[BundleManifestFile], "c0mmunity")
+
+	full := files["rules/001-check-service-password-encryption.txt"]
+	assert.Contains(t, full, "Rule: Check Service Password Encryption\n")
+	assert.Contains(t, full, "Status: FAIL\n")
+	assert.Contains(t, full, "Command: show running-config\n")
+	assert.Contains(t, full, "Checked at: 2024-03-01T09:00:00Z\n")
+	assert.Contains(t, full, "Duration: 1.2s\n")
+	assert.Contains(t, full, "Fingerprint: 5e1f\nFinding category: authentication\n")
+	assert.Contains(t, full, "hostname core\n", "the full output is used when kept")
+	assert.Contains(t, full, "username admin password 0 "+ssh.RedactedValue)
+	assert.NotContains(t, full, "hunter2")
+	assert.NotContains(t, full, bundleTruncationNote)
+	assert.NotContains(t, full, "Carried forward")
+
+	stored := files["rules/002-check-snmp-community.txt"]
+	assert.NotContains(t, stored, "Fingerprint:", "results without a finding have no fingerprint")
+	assert.Contains(t, stored, "Carried forward from run: run-0\nEvaluated at: 2024-02-29T09:00:00Z\n")
+	assert.Contains(t, stored, bundleTruncationNote, "stored evidence is marked as possibly truncated")
+	assert.Contains(t, stored, "snmp-server community "+ssh.RedactedValue)
+	assert.NotContains(t, stored, "c0mmunity")
+
+	connectivity := files[BundleConnectivityFile]
+	assert.Contains(t, connectivity, "SSH port open: false\n")
+	assert.Contains(t, connectivity, "Error code: port_closed\n")
+	assert.Contains(t, connectivity, checker.PortExposureCheckName+"\n")
+	assert.NotContains(t, connectivity, "hunter2")
+
+	assert.Contains(t, files[BundlePostureFile], "Key exchange: diffie-hellman-group1-sha1\n")
+	assert.Contains(t, files[BundlePostureFile], "Weak algorithms: diffie-hellman-group1-sha1\n")
+}
+
+func TestGenerator_GenerateEvidenceBundle_IncludeSecrets(t *testing.T) {
+	bundle := bundleFixture()
+	bundle.IncludeSecrets = true
+
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GenerateEvidenceBundle(bundle, &out))
+	_, files := readBundle(t, out.Bytes())
+
+	var manifest BundleManifest
+	require.NoError(t, json.Unmarshal([]byte(files[BundleManifestFile]), &manifest))
+	assert.True(t, manifest.SecretsIncluded)
+	assert.Equal(t, "c0mmunity", manifest.Device.SNMPCommunity)
+	assert.NotContains(t, files[BundleManifestFile], "ciphertext", "the stored password is never included")
+
+	assert.Contains(t, files["rules/001-check-service-password-encryption.txt"], "username admin password 0 hunter2")
+	assert.Contains(t, files["rules/002-check-snmp-community.txt"], "snmp-server community c0mmunity RO")
+	assert.Contains(t, files[BundleConnectivityFile], "password hunter2 rejected")
+}
+
+func TestGenerator_GenerateEvidenceBundle_Minimal(t *testing.T) {
+	bundle := bundleFixture()
+	bundle.Results = bundle.Results[:1]
+	bundle.Commands = nil
+	bundle.Connectivity = nil
+	bundle.Posture = nil
+	bundle.Run = nil
+
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GenerateEvidenceBundle(bundle, &out))
+	names, files := readBundle(t, out.Bytes())
+
+	assert.Equal(t, []string{BundleManifestFile, "rules/001-check-service-password-encryption.txt"}, names)
+	rule := files[names[1]]
+	assert.NotContains(t, rule, "Command:", "rules that no longer exist have no command")
+	assert.Contains(t, rule, bundleTruncationNote)
+	assert.Contains(t, rule, "enable secret 5 "+ssh.RedactedValue)
+
+	assert.Equal(t, "rules/012-rule.txt", bundleRuleFileName(12, "???"))
+}
@@ -0,0 +1,208 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/checker"
+)
+
+// RuleCatalogOptions describe the heading of a rule catalog
+type RuleCatalogOptions struct {
+	// Title defaults to "Security Rule Catalog"
+	Title string `json:"title,omitempty"`
+
+	// GeneratedAt is printed under the title when set
+	GeneratedAt time.Time `json:"generatedAt"`
+}
+
+const defaultCatalogTitle = "Security Rule Catalog"
+
+// uncategorizedName heads the rules without a category, listed last
+const uncategorizedName = "Uncategorized"
+
+// catalogVendor is one vendor's section of the catalog
+type catalogVendor struct {
+	Vendor     string
+	Categories []catalogCategory
+}
+
+// catalogCategory is the rules of one category within a vendor
+type catalogCategory struct {
+	Name  string
+	Rules []catalogRule
+}
+
+// catalogRule is a rule as the catalog shows it
+type catalogRule struct {
+	checker.SecurityRule
+	Anchor     string
+	References []catalogReference
+}
+
+// catalogReference is a citation, linked when it is a URL
+type catalogReference struct {
+	Text string
+	URL  string
+}
+
+// GenerateRuleCatalog writes an HTML page documenting every enabled rule,
+// grouped by vendor and then by category: what it runs, what it expects,
+// why it matters, how to fix a failure and which standards require it.
+// Disabled rules are left out.
+func (g *Generator) GenerateRuleCatalog(rules []checker.SecurityRule, opts RuleCatalogOptions, w io.Writer) error {
+	title := opts.Title
+	if strings.TrimSpace(title) == "" {
+		title = defaultCatalogTitle
+	}
+	generated := ""
+	if !opts.GeneratedAt.IsZero() {
+		generated = formatReportTime(opts.GeneratedAt)
+	}
+
+	vendors := catalogGroups(rules)
+	count := 0
+	for _, vendor := range vendors {
+		for _, category := range vendor.Categories {
+			count += len(category.Rules)
+		}
+	}
+
+	if err := catalogTemplate.Execute(w, map[string]interface{}{
+		"Title":     title,
+		"Generated": generated,
+		"Count":     count,
+		"Vendors":   vendors,
+	}); err != nil {
+		return fmt.Errorf("failed to render rule catalog: %w", err)
+	}
+	return nil
+}
+
+// catalogGroups sorts the enabled rules by vendor, category in
+// checker.RuleCategories order with uncategorized rules last, and name
+func catalogGroups(rules []checker.SecurityRule) []catalogVendor {
+	categoryRank := make(map[string]int, len(checker.RuleCategories))
+	categoryNames := make(map[string]string, len(checker.RuleCategories))
+	for i, category := range checker.RuleCategories {
+		categoryRank[category.ID] = i
+		categoryNames[category.ID] = category.Name
+	}
+	rank := func(id string) int {
+		if r, ok := categoryRank[id]; ok {
+			return r
+		}
+		return len(categoryRank)
+	}
+
+	enabled := make([]checker.SecurityRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enabled {
+			enabled = append(enabled, rule)
+		}
+	}
+	sort.SliceStable(enabled, func(i, j int) bool {
+		a, b := enabled[i], enabled[j]
+		if a.Vendor != b.Vendor {
+			return a.Vendor < b.Vendor
+		}
+		if rank(a.Category) != rank(b.Category) {
+			return rank(a.Category) < rank(b.Category)
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+
+	var vendors []catalogVendor
+	for _, rule := range enabled {
+		if len(vendors) == 0 || vendors[len(vendors)-1].Vendor != rule.Vendor {
+			vendors = append(vendors, catalogVendor{Vendor: rule.Vendor})
+		}
+		vendor := &vendors[len(vendors)-1]
+
+		name, ok := categoryNames[rule.Category]
+		if !ok {
+			name = uncategorizedName
+		}
+		if len(vendor.Categories) == 0 || vendor.Categories[len(vendor.Categories)-1].Name != name {
+			vendor.Categories = append(vendor.Categories, catalogCategory{Name: name})
+		}
+		category := &vendor.Categories[len(vendor.Categories)-1]
+		category.Rules = append(category.Rules, newCatalogRule(rule))
+	}
+	return vendors
+}
+
+// newCatalogRule links the rule's references that are web addresses
+func newCatalogRule(rule checker.SecurityRule) catalogRule {
+	entry := catalogRule{SecurityRule: rule, Anchor: "rule-" + rule.ID}
+	for _, reference := range rule.References {
+		link := catalogReference{Text: reference}
+		if strings.HasPrefix(reference, "https://") || strings.HasPrefix(reference, "http://") {
+			link.URL = reference
+		}
+		entry.References = append(entry.References, link)
+	}
+	return entry
+}
+
+var catalogTemplate = template.Must(template.New("catalog").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+nav ul { columns: 2; }
+section.rule { border-top: 1px solid #ccc; padding: 0.5em 0; }
+dt { font-weight: bold; margin-top: 0.4em; }
+code { background: #f4f4f4; padding: 0 0.2em; white-space: pre-wrap; }
+.severity { font-size: 0.9em; padding: 0 0.4em; border: 1px solid #999; border-radius: 3px; }
+.missing { color: #a00; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Generated}}<p>Generated {{.Generated}}</p>{{end}}
+<p>{{.Count}} enabled rules</p>
+<nav>
+<ul>
+{{- range .Vendors}}
+<li><a href="#vendor-{{.Vendor}}">{{.Vendor}}</a></li>
+{{- end}}
+</ul>
+</nav>
+{{- range .Vendors}}
+<h2 id="vendor-{{.Vendor}}">{{.Vendor}}</h2>
+{{- range .Categories}}
+<h3>{{.Name}}</h3>
+{{- range .Rules}}
+<section class="rule" id="{{.Anchor}}">
+<h4>{{.Name}} <span class="severity">{{.Severity}}</span></h4>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<dl>
+<dt>Command</dt><dd><code>{{.Command}}</code></dd>
+<dt>Expected pattern</dt><dd><code>{{.ExpectedPattern}}</code></dd>
+<dt>Rationale</dt><dd>{{if .Rationale}}{{.Rationale}}{{else}}<span class="missing">Not documented</span>{{end}}</dd>
+{{- if .Remediation}}
+<dt>Remediation</dt><dd>{{.Remediation}}</dd>
+{{- end}}
+{{- if .References}}
+<dt>References</dt>
+<dd><ul>
+{{- range .References}}
+<li>{{if .URL}}<a href="{{.URL}}">{{.Text}}</a>{{else}}{{.Text}}{{end}}</li>
+{{- end}}
+</ul></dd>
+{{- end}}
+</dl>
+</section>
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,87 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func catalogRules() []checker.SecurityRule {
+	return []checker.SecurityRule{
+		{ID: "banner", Name: "Login Banner", Vendor: "cisco", Category: checker.CategoryManagementAccess,
+			Command: "show run | include banner", ExpectedPattern: "banner (login|motd)", Severity: "Low", Enabled: true,
+			Rationale: "Warns intruders.", References: []string{"NIST SP 800-53 Rev. 5 AC-8: System Use Notification"}},
+		{ID: "enable", Name: "Enable Secret", Vendor: "cisco", Category: checker.CategoryAuthentication,
+			Command: "show run | include enable", ExpectedPattern: `enable secret \$`, Severity: "Critical", Enabled: true,
+			Rationale: "Protects <privileged> access.", Remediation: "enable secret <password>",
+			References: []string{"https://www.cisco.com/hardening"}},
+		{ID: "custom", Name: "Custom Check", Vendor: "cisco", Command: "show clock", ExpectedPattern: ".",
+			Severity: "Info", Enabled: true},
+		{ID: "uptime", Name: "Uptime", Vendor: "generic", Category: checker.CategorySystem,
+			Command: "show version", ExpectedPattern: "uptime", Severity: "Low", Enabled: true, Rationale: "Patching."},
+		{ID: "ssh", Name: "SSH", Vendor: "arista", Category: checker.CategoryManagementAccess,
+			Command: "show management ssh", ExpectedPattern: "enabled", Severity: "High", Enabled: true},
+		{ID: "off", Name: "Disabled Rule", Vendor: "cisco", Category: checker.CategorySystem,
+			Command: "show users", ExpectedPattern: ".", Severity: "Low"},
+	}
+}
+
+func TestCatalogGroups(t *testing.T) {
+	var got []string
+	for _, vendor := range catalogGroups(catalogRules()) {
+		for _, category := range vendor.Categories {
+			for _, rule := range category.Rules {
+				got = append(got, vendor.Vendor+"/"+category.Name+"/"+rule.Name)
+			}
+		}
+	}
+	assert.Equal(t, []string{
+		"arista/Management Access/SSH",
+		"cisco/Authentication/Enable Secret",
+		"cisco/Management Access/Login Banner",
+		"cisco/Uncategorized/Custom Check",
+		"generic/System/Uptime",
+	}, got)
+}
+
+func TestGenerator_GenerateRuleCatalog(t *testing.T) {
+	var out bytes.Buffer
+	generated := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, NewGenerator("").GenerateRuleCatalog(catalogRules(),
+		RuleCatalogOptions{GeneratedAt: generated}, &out))
+	html := out.String()
+
+	assert.Contains(t, html, "<title>Security Rule Catalog</title>")
+	assert.Contains(t, html, "<p>5 enabled rules</p>")
+	for _, rule := range catalogRules() {
+		count := strings.Count(html, `id="rule-`+rule.ID+`"`)
+		if rule.Enabled {
+			assert.Equal(t, 1, count, "%s is listed exactly once", rule.Name)
+		} else {
+			assert.Zero(t, count, "disabled rules are left out")
+		}
+	}
+
+	// Vendors and their categories appear in catalog order
+	order := []string{`id="vendor-arista"`, "<h3>Management Access</h3>", `id="vendor-cisco"`, "<h3>Authentication</h3>",
+		"<h3>Management Access</h3>", "<h3>Uncategorized</h3>", `id="vendor-generic"`, "<h3>System</h3>"}
+	rest := html
+	for _, marker := range order {
+		index := strings.Index(rest, marker)
+		require.NotEqual(t, -1, index, "%s is missing or out of order", marker)
+		rest = rest[index+len(marker):]
+	}
+
+	assert.Contains(t, html, `<a href="https://www.cisco.com/hardening">https://www.cisco.com/hardening</a>`)
+	assert.Contains(t, html, "<li>NIST SP 800-53 Rev. 5 AC-8: System Use Notification</li>")
+	assert.Contains(t, html, "Protects &lt;privileged&gt; access.", "rule text is escaped")
+	assert.Contains(t, html, "<dt>Remediation</dt><dd>enable secret &lt;password&gt;</dd>")
+	assert.Contains(t, html, "<code>show run | include banner</code>")
+	assert.Contains(t, html, "Not documented", "missing rationales are called out")
+}
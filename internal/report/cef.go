@@ -0,0 +1,133 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+)
+
+// CEF header fields identifying the product that produced an event
+const (
+	cefVendor  = "Invictux"
+	cefProduct = "NetworkConfigChecker"
+	cefVersion = "1.0"
+)
+
+// cefSeverities maps rule severities to the CEF 0-10 severity scale
+var cefSeverities = map[string]int{
+	string(checker.SeverityCritical): 10,
+	string(checker.SeverityHigh):     7,
+	string(checker.SeverityMedium):   5,
+	string(checker.SeverityLow):      2,
+}
+
+// GenerateCEF writes one ArcSight CEF event per check result, for SIEMs such
+// as ArcSight and QRadar. devices supplies the source address of each result.
+func (g *Generator) GenerateCEF(results []checker.CheckResult, devices []device.Device, w io.Writer) error {
+	addresses := make(map[string]string, len(devices))
+	for _, dev := range devices {
+		addresses[dev.ID] = dev.IPAddress
+	}
+
+	out := bufio.NewWriter(w)
+	for _, result := range results {
+		if _, err := fmt.Fprintln(out, g.cefLine(result, addresses[result.DeviceID])); err != nil {
+			return fmt.Errorf("failed to write CEF event: %w", err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("failed to write CEF events: %w", err)
+	}
+	return nil
+}
+
+// cefLine formats one check result as a CEF event
+func (g *Generator) cefLine(result checker.CheckResult, address string) string {
+	header := []string{
+		"CEF:0",
+		cefHeaderEscape(cefVendor),
+		cefHeaderEscape(cefProduct),
+		cefHeaderEscape(cefVersion),
+		cefHeaderEscape(cefSignatureID(result)),
+		cefHeaderEscape(result.CheckName),
+		fmt.Sprintf("%d", cefSeverity(result.Severity)),
+	}
+
+	extension := []string{"deviceExternalId=" + cefExtensionEscape(result.DeviceID)}
+	if address != "" {
+		extension = append(extension, "src="+cefExtensionEscape(address))
+	}
+	extension = append(extension,
+		"outcome="+cefExtensionEscape(result.Status),
+		"msg="+cefExtensionEscape(result.RenderMessage(g.locale)),
+	)
+	if result.Duration > 0 {
+		extension = append(extension,
+			"cn1Label=Duration ms", fmt.Sprintf("cn1=%d", result.Duration.Milliseconds()))
+	}
+	if result.OriginalSeverity != "" {
+		extension = append(extension,
+			"cs1Label=Original severity", "cs1="+cefExtensionEscape(result.OriginalSeverity),
+			"cs2Label=Override reason", "cs2="+cefExtensionEscape(result.OverrideReason),
+		)
+	}
+	if result.Fingerprint != "" {
+		extension = append(extension,
+			"cs3Label=Fingerprint", "cs3="+cefExtensionEscape(result.Fingerprint),
+			"cs4Label=Finding category", "cs4="+cefExtensionEscape(result.FindingCategory),
+		)
+	}
+	if label := g.runLabel(result.RunID); label != "" {
+		extension = append(extension,
+			"flexString1Label=Run label", "flexString1="+cefExtensionEscape(label))
+	}
+	if result.CarriedForward {
+		extension = append(extension,
+			"cs5Label=Carried forward from run", "cs5="+cefExtensionEscape(result.CarriedFromRunID))
+		if result.EvaluatedAt != nil {
+			extension = append(extension,
+				"cs6Label=Evaluated at", "cs6="+cefExtensionEscape(result.EvaluatedAt.UTC().Format(time.RFC3339)))
+		}
+	}
+
+	return strings.Join(header, "|") + "|" + strings.Join(extension, " ")
+}
+
+// cefSignatureID returns the CEF signature ID of a result, which SIEM
+// correlation rules key on. It is the ID of the rule that produced the
+// result, which survives renames, or for results saved before results
+// recorded their rule, the finding key, then the check name.
+func cefSignatureID(result checker.CheckResult) string {
+	switch {
+	case result.RuleID != "":
+		return result.RuleID
+	case result.FindingKey != "":
+		return result.FindingKey
+	default:
+		return result.CheckName
+	}
+}
+
+// cefSeverity returns the CEF severity of a rule severity; unknown
+// severities are reported as 0
+func cefSeverity(severity string) int {
+	for name, value := range cefSeverities {
+		if strings.EqualFold(name, severity) {
+			return value
+		}
+	}
+	return 0
+}
+
+// cefHeaderEscape escapes backslashes and pipes, which delimit header fields
+var cefHeaderEscape = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ").Replace
+
+// cefExtensionEscape escapes backslashes, equals signs and line breaks, which
+// delimit extension values, and pipes so no field can be read as a header
+// delimiter
+var cefExtensionEscape = strings.NewReplacer(`\`, `\\`, `=`, `\=`, `|`, `\|`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`).Replace
@@ -0,0 +1,142 @@
+package report
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cefPattern matches a CEF header: version, vendor, product, version,
+// signature ID, name and a numeric severity
+var cefPattern = regexp.MustCompile(`^CEF:0\|.*\|.*\|.*\|.*\|.*\|[0-9]+\|`)
+
+func TestGenerator_GenerateCEF(t *testing.T) {
+	devices := []device.Device{
+		{ID: "router1", Name: "Core Router", IPAddress: "10.0.0.1"},
+		{ID: "switch1", Name: "Access Switch", IPAddress: "10.0.0.2"},
+	}
+	now := time.Now()
+	results := []checker.CheckResult{
+		{DeviceID: "router1", RuleID: "cisco-ssh-v2", CheckName: "SSH Version 2", Severity: string(checker.SeverityCritical),
+			Status: string(checker.StatusFail), Message: "Pattern not found", CheckedAt: now},
+		{DeviceID: "router1", CheckName: "Login Banner", FindingKey: "login-banner", Severity: "High",
+			Status: string(checker.StatusPass), Message: "Pattern found", CheckedAt: now},
+		{DeviceID: "switch1", CheckName: "Telnet | disabled", Severity: "Medium", Status: string(checker.StatusError),
+			Message: "output a=b | c\nsecond line", CheckedAt: now},
+		{DeviceID: "switch1", CheckName: "NTP", Severity: "Low", Status: string(checker.StatusWarning), CheckedAt: now},
+		{DeviceID: "missing", CheckName: "Uptime", Severity: "Info", Status: string(checker.StatusPass), CheckedAt: now},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GenerateCEF(results, devices, &out))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, len(results))
+	for _, line := range lines {
+		assert.Regexp(t, cefPattern, line)
+	}
+
+	// The signature ID is the rule ID, which survives renames
+	assert.Equal(t, `CEF:0|Invictux|NetworkConfigChecker|1.0|cisco-ssh-v2|SSH Version 2|10|`+
+		`deviceExternalId=router1 src=10.0.0.1 outcome=FAIL msg=Pattern not found`, lines[0])
+	assert.Contains(t, lines[1], "|login-banner|Login Banner|7|")
+
+	// Pipes, equals signs and line breaks are escaped
+	assert.Contains(t, lines[2], `|Telnet \| disabled|Telnet \| disabled|5|`)
+	assert.Contains(t, lines[2], `msg=output a\=b \| c\nsecond line`)
+	assert.Contains(t, lines[3], "|2|")
+
+	// Unknown severities and devices still produce an event
+	assert.Contains(t, lines[4], "|0|deviceExternalId=missing outcome=PASS")
+	assert.NotContains(t, lines[4], "src=")
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestGenerator_GenerateCEFWriteError(t *testing.T) {
+	results := []checker.CheckResult{{DeviceID: "router1", CheckName: "NTP", Severity: "Low", Status: string(checker.StatusPass)}}
+	assert.Error(t, NewGenerator("").GenerateCEF(results, nil, failingWriter{}))
+}
+
+func TestGenerator_GenerateCEFSeverityOverride(t *testing.T) {
+	results := []checker.CheckResult{{DeviceID: "lab1", CheckName: "Telnet disabled", Severity: string(checker.SeverityInfo),
+		OriginalSeverity: string(checker.SeverityHigh), OverrideReason: "Lab exercises", Status: string(checker.StatusFail)}}
+
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GenerateCEF(results, nil, &out))
+
+	line := strings.TrimSpace(out.String())
+	assert.Regexp(t, cefPattern, line)
+	assert.Contains(t, line, "|Telnet disabled|0|", "the effective severity is reported")
+	assert.Contains(t, line, "cs1=High")
+	assert.Contains(t, line, "cs2=Lab exercises")
+}
+
+func TestGenerator_GenerateCEFFingerprint(t *testing.T) {
+	fingerprint := checker.FindingFingerprint("10.0.0.1", checker.FindingRemoteAccess, "telnet-enabled")
+	results := []checker.CheckResult{
+		{DeviceID: "router1", CheckName: "Telnet disabled", Severity: string(checker.SeverityHigh), Status: string(checker.StatusFail),
+			FindingCategory: checker.FindingRemoteAccess, FindingKey: "telnet-enabled", Fingerprint: fingerprint},
+		{DeviceID: "router1", CheckName: "NTP", Severity: string(checker.SeverityLow), Status: string(checker.StatusPass)},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GenerateCEF(results, nil, &out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Regexp(t, cefPattern, lines[0])
+	assert.Contains(t, lines[0], "cs3Label=Fingerprint cs3="+fingerprint)
+	assert.Contains(t, lines[0], "cs4Label=Finding category cs4=remote-access")
+	assert.NotContains(t, lines[1], "cs3Label", "results without a finding have no fingerprint")
+}
+
+func TestGenerator_GenerateCEFCarriedForward(t *testing.T) {
+	evaluated := time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)
+	results := []checker.CheckResult{
+		{DeviceID: "router1", CheckName: "Telnet disabled", Severity: string(checker.SeverityHigh), Status: string(checker.StatusFail),
+			CarriedForward: true, CarriedFromRunID: "run1", EvaluatedAt: &evaluated},
+		{DeviceID: "router1", CheckName: "NTP", Severity: string(checker.SeverityLow), Status: string(checker.StatusPass)},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GenerateCEF(results, nil, &out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Regexp(t, cefPattern, lines[0])
+	assert.Contains(t, lines[0], "cs5Label=Carried forward from run cs5=run1")
+	assert.Contains(t, lines[0], "cs6Label=Evaluated at cs6=2024-03-01T02:00:00Z")
+	assert.NotContains(t, lines[1], "cs5Label", "evaluated results are not marked")
+}
+
+func TestGenerator_GenerateCEFRunLabel(t *testing.T) {
+	results := []checker.CheckResult{
+		{DeviceID: "router1", RunID: "run1", CheckName: "Telnet disabled", Severity: string(checker.SeverityHigh), Status: string(checker.StatusFail)},
+		{DeviceID: "router1", RunID: "run2", CheckName: "NTP", Severity: string(checker.SeverityLow), Status: string(checker.StatusPass)},
+	}
+
+	var out bytes.Buffer
+	generator := NewGenerator("").WithRunLabels(map[string]string{"run1": "post-change CHG-5521"})
+	require.NoError(t, generator.GenerateCEF(results, nil, &out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Regexp(t, cefPattern, lines[0])
+	assert.Contains(t, lines[0], "flexString1Label=Run label flexString1=post-change CHG-5521")
+	assert.NotContains(t, lines[1], "flexString1Label", "unlabeled runs have no label")
+}
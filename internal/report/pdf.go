@@ -0,0 +1,284 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Page sizes accepted by GeneratePDF
+const (
+	PageSizeA4     = "A4"
+	PageSizeLetter = "Letter"
+)
+
+// pdfPageSize is a page's width and height in points
+type pdfPageSize struct {
+	width, height float64
+}
+
+var pdfPageSizes = map[string]pdfPageSize{
+	PageSizeA4:     {595.28, 841.89},
+	PageSizeLetter: {612, 792},
+}
+
+// Resource names of the standard fonts every page can use
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+	fontMono    = "F3"
+)
+
+// pdfFonts maps the font resource names to the standard PDF fonts they use,
+// in the order their objects are written
+var pdfFonts = []struct {
+	name, base string
+}{
+	{fontRegular, "Helvetica"},
+	{fontBold, "Helvetica-Bold"},
+	{fontMono, "Courier"},
+}
+
+// helveticaWidths holds the Helvetica glyph widths of the printable ASCII
+// characters in thousandths of the font size, starting at the space
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// textWidth returns the width in points of s set in font at size. Bold text
+// is estimated from the regular widths with some slack so it never
+// overflows; Courier is monospaced.
+func textWidth(font string, size float64, s string) float64 {
+	if font == fontMono {
+		return float64(len([]rune(s))) * 0.6 * size
+	}
+
+	total := 0
+	for _, r := range s {
+		if r >= ' ' && r <= '~' {
+			total += helveticaWidths[r-' ']
+		} else {
+			total += 556
+		}
+	}
+	width := float64(total) * size / 1000
+	if font == fontBold {
+		width *= 1.1
+	}
+	return width
+}
+
+// wrapText breaks s into lines no wider than width, at spaces where
+// possible. Line breaks in s are kept.
+func wrapText(font string, size float64, s string, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if textWidth(font, size, candidate) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			// Words wider than a line are split between characters
+			for textWidth(font, size, word) > width {
+				cut := fitRunes(font, size, word, width)
+				lines = append(lines, word[:cut])
+				word = word[cut:]
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fitRunes returns the byte length of the longest prefix of s, at least one
+// rune, that fits in width
+func fitRunes(font string, size float64, s string, width float64) int {
+	cut := 0
+	for i, r := range s {
+		end := i + len(string(r))
+		if cut > 0 && textWidth(font, size, s[:end]) > width {
+			break
+		}
+		cut = end
+	}
+	return cut
+}
+
+// pdfString encodes s as a PDF literal string in WinAnsiEncoding. Control
+// characters become spaces and characters outside Latin-1 become '?'.
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < ' ' || r == 0x7f:
+			b.WriteByte(' ')
+		case r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfNumber formats a coordinate with at most two decimals
+func pdfNumber(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "" || s == "-" {
+		return "0"
+	}
+	return s
+}
+
+// pdfColor is an RGB color with components from 0 to 1
+type pdfColor struct {
+	r, g, b float64
+}
+
+// pdfCanvas collects the content stream of a page or form
+type pdfCanvas struct {
+	buf bytes.Buffer
+}
+
+// op writes one content stream operation
+func (c *pdfCanvas) op(parts ...string) {
+	c.buf.WriteString(strings.Join(parts, " "))
+	c.buf.WriteByte('\n')
+}
+
+// text draws s with its baseline starting at x, y
+func (c *pdfCanvas) text(font string, size, x, y float64, s string) {
+	c.op("BT", "/"+font, pdfNumber(size), "Tf", pdfNumber(x), pdfNumber(y), "Td", pdfString(s), "Tj", "ET")
+}
+
+// fill sets the color of filled shapes and text
+func (c *pdfCanvas) fill(color pdfColor) {
+	c.op(pdfNumber(color.r), pdfNumber(color.g), pdfNumber(color.b), "rg")
+}
+
+// stroke sets the color of lines
+func (c *pdfCanvas) stroke(color pdfColor) {
+	c.op(pdfNumber(color.r), pdfNumber(color.g), pdfNumber(color.b), "RG")
+}
+
+// rect fills a rectangle whose lower left corner is at x, y
+func (c *pdfCanvas) rect(x, y, width, height float64) {
+	c.op(pdfNumber(x), pdfNumber(y), pdfNumber(width), pdfNumber(height), "re", "f")
+}
+
+// line strokes a line from x1, y1 to x2, y2
+func (c *pdfCanvas) line(x1, y1, x2, y2 float64) {
+	c.op("0.5", "w", pdfNumber(x1), pdfNumber(y1), "m", pdfNumber(x2), pdfNumber(y2), "l", "S")
+}
+
+// form draws the named form XObject with its origin at x, y
+func (c *pdfCanvas) form(name string, x, y float64) {
+	c.op("q", "1 0 0 1", pdfNumber(x), pdfNumber(y), "cm", "/"+name, "Do", "Q")
+}
+
+// pdfForm is a form XObject, a drawing reused through pdfCanvas.form
+type pdfForm struct {
+	name          string
+	width, height float64
+	content       *pdfCanvas
+}
+
+// pdfWriter numbers objects and records their offsets for the xref table
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+// object writes the next object and returns its number
+func (pw *pdfWriter) object(body string) int {
+	pw.offsets = append(pw.offsets, pw.buf.Len())
+	number := len(pw.offsets)
+	fmt.Fprintf(&pw.buf, "%d 0 obj\n%s\nendobj\n", number, body)
+	return number
+}
+
+// stream writes a stream object with extra dictionary entries
+func (pw *pdfWriter) stream(dict string, content []byte) int {
+	return pw.object(fmt.Sprintf("<< %s/Length %d >>\nstream\n%s\nendstream", dict, len(content), content))
+}
+
+// writePDF writes a document of the given pages. Nothing time-dependent is
+// written, so the same pages always produce the same bytes.
+func writePDF(w io.Writer, size pdfPageSize, title string, pages []*pdfCanvas, forms []pdfForm) error {
+	pw := &pdfWriter{}
+	pw.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 and 2 are the catalog and page tree, written first with the
+	// page numbers known in advance: fonts, forms, then a page and its
+	// content per page
+	firstPage := 2 + len(pdfFonts) + len(forms) + 1
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	pw.object("<< /Type /Catalog /Pages 2 0 R >>")
+	pw.object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+
+	var fonts []string
+	for _, font := range pdfFonts {
+		number := pw.object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", font.base))
+		fonts = append(fonts, fmt.Sprintf("/%s %d 0 R", font.name, number))
+	}
+	fontResources := "/Font << " + strings.Join(fonts, " ") + " >>"
+
+	var xobjects []string
+	for _, form := range forms {
+		number := pw.stream(fmt.Sprintf("/Type /XObject /Subtype /Form /BBox [0 0 %s %s] /Resources << %s >> ",
+			pdfNumber(form.width), pdfNumber(form.height), fontResources), form.content.buf.Bytes())
+		xobjects = append(xobjects, fmt.Sprintf("/%s %d 0 R", form.name, number))
+	}
+	resources := fontResources
+	if len(xobjects) > 0 {
+		resources += " /XObject << " + strings.Join(xobjects, " ") + " >>"
+	}
+
+	for i, page := range pages {
+		pw.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << %s >> /Contents %d 0 R >>",
+			pdfNumber(size.width), pdfNumber(size.height), resources, firstPage+2*i+1))
+		pw.stream("", page.buf.Bytes())
+	}
+
+	info := pw.object(fmt.Sprintf("<< /Title %s /Producer (Invictux Network Configuration Checker) >>", pdfString(title)))
+
+	xref := pw.buf.Len()
+	fmt.Fprintf(&pw.buf, "xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1)
+	for _, offset := range pw.offsets {
+		fmt.Fprintf(&pw.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pw.buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(pw.offsets)+1, info, xref)
+
+	if _, err := w.Write(pw.buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write PDF: %w", err)
+	}
+	return nil
+}
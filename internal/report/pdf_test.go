@@ -0,0 +1,231 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pdfStreamPattern matches the content of every stream object
+var pdfStreamPattern = regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`)
+
+// pdfTextPattern matches a literal string shown with Tj
+var pdfTextPattern = regexp.MustCompile(`\(((?:\\.|[^\\)])*)\) Tj`)
+
+// extractPages returns the text shown on each page, in drawing order
+func extractPages(t *testing.T, pdf []byte) [][]string {
+	t.Helper()
+
+	// Streams are written as the chart form first, then one per page
+	streams := pdfStreamPattern.FindAllSubmatch(pdf, -1)
+	require.NotEmpty(t, streams)
+
+	var pages [][]string
+	for _, stream := range streams[1:] {
+		var texts []string
+		for _, match := range pdfTextPattern.FindAllSubmatch(stream[1], -1) {
+			texts = append(texts, unescapePDFString(string(match[1])))
+		}
+		pages = append(pages, texts)
+	}
+	return pages
+}
+
+func unescapePDFString(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\(`, `(`, `\)`, `)`).Replace(s)
+}
+
+func pdfFixture(count int) ([]checker.CheckResult, []device.Device) {
+	devices := []device.Device{
+		{ID: "router1", Name: "Core Router", IPAddress: "10.0.0.1"},
+		{ID: "switch1", Name: "Access Switch", IPAddress: "10.0.0.2"},
+	}
+	severities := []checker.Severity{checker.SeverityCritical, checker.SeverityHigh, checker.SeverityMedium, checker.SeverityLow}
+	checkedAt := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+
+	var results []checker.CheckResult
+	for i := 0; i < count; i++ {
+		status := checker.StatusPass
+		if i%3 == 0 {
+			status = checker.StatusFail
+		}
+		results = append(results, checker.CheckResult{
+			DeviceID:  devices[i%2].ID,
+			CheckName: fmt.Sprintf("Check %03d", i),
+			Severity:  string(severities[i%len(severities)]),
+			Status:    string(status),
+			Message:   "Expected pattern (enable secret) not found in the running configuration",
+			Evidence:  "hostname core\nservice password-encryption",
+			CheckedAt: checkedAt.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	return results, devices
+}
+
+func TestGenerator_GeneratePDF(t *testing.T) {
+	results, devices := pdfFixture(120)
+
+	for i := range results {
+		results[i].RunID = "run1"
+	}
+
+	var out bytes.Buffer
+	generator := NewGenerator("").WithRunLabels(map[string]string{"run1": "post-change CHG-5521"})
+	require.NoError(t, generator.GeneratePDF(results, devices, PDFOptions{
+		Operator:    "Jordan Lee",
+		GeneratedAt: time.Date(2026, 3, 3, 8, 0, 0, 0, time.UTC),
+	}, &out))
+	pdf := out.Bytes()
+
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(pdf, []byte("%%EOF\n")))
+
+	pageCount := bytes.Count(pdf, []byte("/Type /Page /Parent"))
+	assert.GreaterOrEqual(t, pageCount, 4)
+	assert.LessOrEqual(t, pageCount, 12)
+	assert.Contains(t, string(pdf), fmt.Sprintf("/Count %d", pageCount))
+	assert.Contains(t, string(pdf), "/MediaBox [0 0 595.28 841.89]", "A4 by default")
+
+	pages := extractPages(t, pdf)
+	require.Len(t, pages, pageCount)
+
+	cover := strings.Join(pages[0], "\n")
+	assert.Contains(t, cover, defaultPDFTitle)
+	assert.Contains(t, cover, "Date range: 2026-03-02 09:30 UTC to 2026-03-02 11:29 UTC")
+	assert.Contains(t, cover, "Operator: Jordan Lee")
+	assert.Contains(t, cover, "Runs: post-change CHG-5521")
+	assert.Contains(t, cover, "Generated: 2026-03-03 08:00 UTC")
+	assert.Contains(t, cover, "Checks: 120")
+	assert.Contains(t, cover, "Failed: 40")
+	assert.Contains(t, cover, "Findings by severity")
+
+	for i, page := range pages {
+		assert.Contains(t, page, fmt.Sprintf("Page %d of %d", i+1, pageCount))
+		if i > 0 {
+			assert.Contains(t, page, "Severity", "the table header repeats on page %d", i+1)
+		}
+	}
+
+	body := strings.Join(flatten(pages[1:]), "\n")
+	for _, result := range results {
+		assert.Contains(t, body, result.CheckName)
+	}
+	assert.Contains(t, body, "Expected pattern (enable secret) not found in", "parentheses survive escaping")
+	assert.Contains(t, body, "service password-encryption", "evidence of failed checks is included")
+
+	// The chart is a form with a bar per severity that has findings
+	streams := pdfStreamPattern.FindAllSubmatch(pdf, -1)
+	chart := string(streams[0][1])
+	assert.Contains(t, string(pdf), "/Subtype /Form")
+	assert.Contains(t, chart, "(Critical) Tj")
+	assert.Equal(t, 4, strings.Count(chart, " re f"), "one bar per severity with failures")
+	assert.Contains(t, string(pdf), "/"+pdfChartName+" Do")
+}
+
+func flatten(pages [][]string) []string {
+	var all []string
+	for _, page := range pages {
+		all = append(all, page...)
+	}
+	return all
+}
+
+func TestGenerator_GeneratePDFSkippedRules(t *testing.T) {
+	results, devices := pdfFixture(4)
+
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GeneratePDF(results, devices, PDFOptions{}, &out))
+	for _, page := range extractPages(t, out.Bytes()) {
+		assert.NotContains(t, page, "Appendix: Skipped Rules", "no appendix without skipped rules")
+	}
+
+	out.Reset()
+	generator := NewGenerator("").WithSkippedRules([]checker.SkippedRule{
+		{DeviceID: "switch1", RuleID: "snmp", RuleName: "SNMPv3 only", Reason: checker.SkipReasonMaintenance},
+		{DeviceID: "router1", RuleID: "ntp", RuleName: "NTP servers", Reason: checker.SkipReasonNeedsAttention},
+		{DeviceID: "router1", RuleID: "junos", RuleName: "Junos root login", Reason: checker.SkipReasonVendor},
+	})
+	require.NoError(t, generator.GeneratePDF(results, devices, PDFOptions{}, &out))
+
+	pages := extractPages(t, out.Bytes())
+	appendix := pages[len(pages)-1]
+	require.Contains(t, appendix, "Appendix: Skipped Rules")
+	body := strings.Join(appendix, "\n")
+	assert.Less(t, strings.Index(body, "Access Switch"), strings.Index(body, "Core Router"), "sorted by device name")
+	assert.Contains(t, body, "NTP servers\nneeds-attention")
+	assert.Contains(t, body, "SNMPv3 only\nmaintenance-window")
+	assert.NotContains(t, body, "Junos root login", "rules for other vendors are not gaps")
+}
+
+func TestGenerator_GeneratePDFDeterministic(t *testing.T) {
+	results, devices := pdfFixture(30)
+	opts := PDFOptions{Title: "Quarterly audit", PageSize: PageSizeLetter}
+
+	var first, second bytes.Buffer
+	require.NoError(t, NewGenerator("").GeneratePDF(results, devices, opts, &first))
+	require.NoError(t, NewGenerator("").GeneratePDF(results, devices, opts, &second))
+	assert.Equal(t, first.Bytes(), second.Bytes())
+	assert.Contains(t, first.String(), "/MediaBox [0 0 612 792]")
+	assert.Contains(t, first.String(), "/Title (Quarterly audit)")
+}
+
+func TestGenerator_GeneratePDFLargeEvidence(t *testing.T) {
+	var evidence strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&evidence, "interface GigabitEthernet0/%d description uplink-%s\n", i, strings.Repeat("x", 150))
+	}
+	results := []checker.CheckResult{{
+		DeviceID:  "router1",
+		CheckName: "Interface descriptions",
+		Severity:  string(checker.SeverityLow),
+		Status:    string(checker.StatusFail),
+		Message:   strings.Repeat("long-message-without-spaces", 20),
+		Evidence:  evidence.String(),
+		CheckedAt: time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC),
+	}}
+
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GeneratePDF(results, nil, PDFOptions{}, &out))
+
+	pages := extractPages(t, out.Bytes())
+	require.Len(t, pages, 2)
+	findings := pages[1]
+	assert.Contains(t, strings.Join(findings, "\n"), "more lines truncated")
+
+	bodyWidth := pdfPageSizes[PageSizeA4].width - 2*pdfMargin
+	for _, text := range findings {
+		assert.LessOrEqual(t, textWidth(fontMono, pdfEvidenceSize, text), bodyWidth, "%q overflows", text)
+	}
+}
+
+func TestGenerator_GeneratePDFPageSize(t *testing.T) {
+	var out bytes.Buffer
+	assert.Error(t, NewGenerator("").GeneratePDF(nil, nil, PDFOptions{PageSize: "A3"}, &out))
+
+	require.NoError(t, NewGenerator("").GeneratePDF(nil, nil, PDFOptions{}, &out))
+	pages := extractPages(t, out.Bytes())
+	require.Len(t, pages, 2)
+	assert.Contains(t, pages[0], "Date range: no results")
+	assert.Contains(t, pages[1], "No check results.")
+}
+
+func TestWrapText(t *testing.T) {
+	lines := wrapText(fontRegular, 10, "the quick brown fox jumps over the lazy dog", 80)
+	assert.Greater(t, len(lines), 1)
+	for _, line := range lines {
+		assert.LessOrEqual(t, textWidth(fontRegular, 10, line), 80.0)
+	}
+	assert.Equal(t, "the quick brown fox jumps over the lazy dog", strings.Join(lines, " "))
+
+	assert.Equal(t, []string{"first", "second"}, wrapText(fontRegular, 10, "first\nsecond", 200))
+	assert.Equal(t, `(a\(b\)c\\ ?)`, pdfString("a(b)c\\\t世"))
+}
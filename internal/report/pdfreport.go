@@ -0,0 +1,521 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+)
+
+// PDFOptions describe the cover page and paper of a PDF report
+type PDFOptions struct {
+	// Title defaults to "Network Configuration Compliance Report"
+	Title    string `json:"title,omitempty"`
+	Operator string `json:"operator,omitempty"`
+
+	// PageSize is PageSizeA4 or PageSizeLetter; empty is A4
+	PageSize string `json:"pageSize,omitempty"`
+
+	// GeneratedAt is printed on the cover page when set. The report holds no
+	// other time of its own, so identical input gives identical output.
+	GeneratedAt time.Time `json:"generatedAt"`
+}
+
+const defaultPDFTitle = "Network Configuration Compliance Report"
+
+// Page layout in points
+const (
+	pdfMargin       = 50
+	pdfFooterHeight = 30
+	pdfBodySize     = 9
+	pdfLineHeight   = 11
+	pdfCellPadding  = 4
+)
+
+// Evidence is set small and cut after pdfMaxEvidenceLines wrapped lines so
+// one device's output cannot take over the report
+const (
+	pdfEvidenceSize     = 7
+	pdfEvidenceLeading  = 8.5
+	pdfMaxEvidenceLines = 15
+	pdfChartBarHeight   = 16
+	pdfChartBarGap      = 6
+	pdfChartLabelWidth  = 70
+	pdfChartCountWidth  = 40
+	pdfChartName        = "SeverityChart"
+)
+
+// pdfSeverities lists the severities in report order with their colors
+var pdfSeverities = []struct {
+	severity checker.Severity
+	color    pdfColor
+}{
+	{checker.SeverityCritical, pdfColor{0.6, 0.08, 0.08}},
+	{checker.SeverityHigh, pdfColor{0.86, 0.3, 0.1}},
+	{checker.SeverityMedium, pdfColor{0.93, 0.64, 0.1}},
+	{checker.SeverityLow, pdfColor{0.2, 0.5, 0.8}},
+	{checker.SeverityInfo, pdfColor{0.55, 0.55, 0.55}},
+}
+
+var (
+	pdfBlack      = pdfColor{0, 0, 0}
+	pdfGray       = pdfColor{0.4, 0.4, 0.4}
+	pdfRule       = pdfColor{0.75, 0.75, 0.75}
+	pdfHeaderBg   = pdfColor{0.88, 0.9, 0.93}
+	pdfEvidenceBg = pdfColor{0.95, 0.95, 0.95}
+)
+
+// pdfColumn is a table column taking a share of the body width
+type pdfColumn struct {
+	title string
+	share float64
+}
+
+// pdfColumns are the findings table columns
+var pdfColumns = []pdfColumn{
+	{"Device", 0.17},
+	{"Check", 0.23},
+	{"Severity", 0.11},
+	{"Status", 0.1},
+	{"Message", 0.39},
+}
+
+// pdfSkippedColumns are the columns of the skipped rules appendix
+var pdfSkippedColumns = []pdfColumn{
+	{"Device", 0.25},
+	{"Rule", 0.5},
+	{"Reason", 0.25},
+}
+
+// GeneratePDF writes a PDF report of check results for auditors: a cover
+// page with the fleet summary and a findings-by-severity chart, then every
+// result in a table whose header repeats on each page, with the fingerprint
+// and evidence of results that did not pass. Rules set with
+// WithSkippedRules follow in an appendix. Every page is numbered. devices
+// supplies the device names.
+func (g *Generator) GeneratePDF(results []checker.CheckResult, devices []device.Device, opts PDFOptions, w io.Writer) error {
+	if opts.PageSize == "" {
+		opts.PageSize = PageSizeA4
+	}
+	size, ok := pdfPageSizes[opts.PageSize]
+	if !ok {
+		return fmt.Errorf("unsupported page size %q: use %s or %s", opts.PageSize, PageSizeA4, PageSizeLetter)
+	}
+	if strings.TrimSpace(opts.Title) == "" {
+		opts.Title = defaultPDFTitle
+	}
+
+	names := make(map[string]string, len(devices))
+	for _, dev := range devices {
+		names[dev.ID] = dev.Name
+	}
+
+	layout := &pdfLayout{generator: g, size: size, names: names}
+	chart := layout.coverPage(results, devices, opts)
+	layout.findings(sortedForReport(results, names))
+	layout.skippedRules(g.skipped)
+	layout.numberPages(opts.Title)
+
+	return writePDF(w, size, opts.Title, layout.pages, []pdfForm{chart})
+}
+
+// sortedForReport orders results by device name, then severity, then check
+func sortedForReport(results []checker.CheckResult, names map[string]string) []checker.CheckResult {
+	sorted := append([]checker.CheckResult(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if nameA, nameB := deviceLabel(a.DeviceID, names), deviceLabel(b.DeviceID, names); nameA != nameB {
+			return nameA < nameB
+		}
+		if rankA, rankB := severityRank(a.Severity), severityRank(b.Severity); rankA != rankB {
+			return rankA < rankB
+		}
+		return a.CheckName < b.CheckName
+	})
+	return sorted
+}
+
+// deviceLabel names a device, falling back to its ID
+func deviceLabel(deviceID string, names map[string]string) string {
+	if name := names[deviceID]; name != "" {
+		return name
+	}
+	return deviceID
+}
+
+// severityRank orders severities from critical to info; unknown ones last
+func severityRank(severity string) int {
+	for i, s := range pdfSeverities {
+		if strings.EqualFold(string(s.severity), severity) {
+			return i
+		}
+	}
+	return len(pdfSeverities)
+}
+
+// pdfLayout places content on pages from top to bottom
+type pdfLayout struct {
+	generator *Generator
+	size      pdfPageSize
+	names     map[string]string
+	pages     []*pdfCanvas
+	page      *pdfCanvas
+	y         float64
+
+	// tableHeader redraws the table header on each new page when set
+	tableHeader func()
+}
+
+func (l *pdfLayout) bodyWidth() float64 {
+	return l.size.width - 2*pdfMargin
+}
+
+// newPage starts a page and repeats the table header on it
+func (l *pdfLayout) newPage() {
+	l.page = &pdfCanvas{}
+	l.pages = append(l.pages, l.page)
+	l.y = l.size.height - pdfMargin
+	if l.tableHeader != nil {
+		l.tableHeader()
+	}
+}
+
+// ensure starts a new page unless height fits above the footer
+func (l *pdfLayout) ensure(height float64) {
+	if l.page == nil || l.y-height < pdfMargin+pdfFooterHeight {
+		l.newPage()
+	}
+}
+
+// textLine writes one line and moves down
+func (l *pdfLayout) textLine(font string, size float64, color pdfColor, s string) {
+	l.page.fill(color)
+	l.page.text(font, size, pdfMargin, l.y-size, s)
+	l.y -= size * 1.4
+}
+
+// coverPage draws the title, date range, operator and fleet summary, and
+// returns the severity chart placed on it
+func (l *pdfLayout) coverPage(results []checker.CheckResult, devices []device.Device, opts PDFOptions) pdfForm {
+	l.newPage()
+	l.y -= 60
+	for _, line := range wrapText(fontBold, 24, opts.Title, l.bodyWidth()) {
+		l.textLine(fontBold, 24, pdfBlack, line)
+	}
+	l.y -= 12
+
+	l.textLine(fontRegular, 12, pdfGray, "Date range: "+dateRange(results))
+	operator := opts.Operator
+	if strings.TrimSpace(operator) == "" {
+		operator = "-"
+	}
+	l.textLine(fontRegular, 12, pdfGray, "Operator: "+operator)
+	if labels := l.generator.reportRunLabels(results); len(labels) > 0 {
+		for _, line := range wrapText(fontRegular, 12, "Runs: "+strings.Join(labels, ", "), l.bodyWidth()) {
+			l.textLine(fontRegular, 12, pdfGray, line)
+		}
+	}
+	if !opts.GeneratedAt.IsZero() {
+		l.textLine(fontRegular, 12, pdfGray, "Generated: "+formatReportTime(opts.GeneratedAt))
+	}
+	l.y -= 24
+
+	l.textLine(fontBold, 14, pdfBlack, "Fleet summary")
+	counts := make(map[string]int)
+	reported := make(map[string]bool)
+	for _, result := range results {
+		counts[result.Status]++
+		reported[result.DeviceID] = true
+	}
+	summary := []string{
+		fmt.Sprintf("Devices: %d (%d with results)", len(devices), len(reported)),
+		fmt.Sprintf("Checks: %d", len(results)),
+		fmt.Sprintf("Passed: %d", counts[string(checker.StatusPass)]),
+		fmt.Sprintf("Failed: %d", counts[string(checker.StatusFail)]),
+		fmt.Sprintf("Warnings: %d", counts[string(checker.StatusWarning)]),
+		fmt.Sprintf("Errors: %d", counts[string(checker.StatusError)]),
+		fmt.Sprintf("Not applicable: %d", counts[string(checker.StatusNotApplicable)]),
+		fmt.Sprintf("Compliance score: %.1f%%", checker.CalculateComplianceScore(results)),
+		fmt.Sprintf("Severity-weighted score: %.1f%%", checker.WeightedScore(results, nil)),
+	}
+	for _, line := range summary {
+		l.textLine(fontRegular, 11, pdfBlack, line)
+	}
+	l.y -= 24
+
+	l.textLine(fontBold, 14, pdfBlack, "Findings by severity")
+	chart := severityChart(results, l.bodyWidth())
+	l.y -= 6
+	l.page.form(chart.name, pdfMargin, l.y-chart.height)
+	l.y -= chart.height
+	return chart
+}
+
+// reportRunLabels returns the sorted labels of the runs results came from
+func (g *Generator) reportRunLabels(results []checker.CheckResult) []string {
+	seen := make(map[string]bool)
+	var labels []string
+	for _, result := range results {
+		if label := g.runLabel(result.RunID); label != "" && !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// dateRange spans the check times of results
+func dateRange(results []checker.CheckResult) string {
+	var first, last time.Time
+	for _, result := range results {
+		if first.IsZero() || result.CheckedAt.Before(first) {
+			first = result.CheckedAt
+		}
+		if result.CheckedAt.After(last) {
+			last = result.CheckedAt
+		}
+	}
+	if first.IsZero() {
+		return "no results"
+	}
+	return formatReportTime(first) + " to " + formatReportTime(last)
+}
+
+// formatReportTime prints times in UTC so the report reads the same anywhere
+func formatReportTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// severityChart draws a horizontal bar per severity with the number of
+// failed results, as a vector form
+func severityChart(results []checker.CheckResult, width float64) pdfForm {
+	counts := make(map[int]int)
+	highest := 0
+	for _, result := range results {
+		if result.Status != string(checker.StatusFail) {
+			continue
+		}
+		rank := severityRank(result.Severity)
+		counts[rank]++
+		if counts[rank] > highest {
+			highest = counts[rank]
+		}
+	}
+
+	height := float64(len(pdfSeverities))*(pdfChartBarHeight+pdfChartBarGap) + pdfChartBarGap
+	barSpace := width - pdfChartLabelWidth - pdfChartCountWidth
+	content := &pdfCanvas{}
+	content.stroke(pdfRule)
+	content.line(pdfChartLabelWidth, 0, pdfChartLabelWidth, height)
+
+	for i, s := range pdfSeverities {
+		y := height - float64(i+1)*(pdfChartBarHeight+pdfChartBarGap)
+		content.fill(pdfBlack)
+		content.text(fontRegular, pdfBodySize, 0, y+5, string(s.severity))
+
+		barWidth := 0.0
+		if highest > 0 {
+			barWidth = barSpace * float64(counts[i]) / float64(highest)
+		}
+		if barWidth > 0 {
+			content.fill(s.color)
+			content.rect(pdfChartLabelWidth, y, barWidth, pdfChartBarHeight)
+		}
+		content.fill(pdfBlack)
+		content.text(fontRegular, pdfBodySize, pdfChartLabelWidth+barWidth+6, y+5, fmt.Sprintf("%d", counts[i]))
+	}
+
+	return pdfForm{name: pdfChartName, width: width, height: height, content: content}
+}
+
+// findings draws the results table starting on a new page
+func (l *pdfLayout) findings(results []checker.CheckResult) {
+	l.tableHeader = nil
+	l.newPage()
+	l.textLine(fontBold, 16, pdfBlack, "Findings")
+	l.y -= 4
+
+	if len(results) == 0 {
+		l.textLine(fontRegular, 11, pdfGray, "No check results.")
+		return
+	}
+
+	widths := l.startTable(pdfColumns)
+	for _, result := range results {
+		cells := []string{
+			deviceLabel(result.DeviceID, l.names),
+			result.CheckName,
+			result.Severity,
+			result.Status,
+			result.RenderMessage(l.generator.locale),
+		}
+		wrapped := make([][]string, len(cells))
+		lines := 1
+		for i, cell := range cells {
+			wrapped[i] = wrapText(fontRegular, pdfBodySize, cell, widths[i]-2*pdfCellPadding)
+			if len(wrapped[i]) > lines {
+				lines = len(wrapped[i])
+			}
+		}
+		rowHeight := float64(lines)*pdfLineHeight + 2*pdfCellPadding
+
+		var evidence []string
+		if result.Status != string(checker.StatusPass) && strings.TrimSpace(result.Evidence) != "" {
+			evidence = evidenceLines(result.Evidence, l.bodyWidth()-2*pdfCellPadding)
+		}
+		if result.Status != string(checker.StatusPass) && result.Fingerprint != "" {
+			evidence = append([]string{"Fingerprint: " + result.Fingerprint}, evidence...)
+		}
+		if note := result.CarriedForwardNote(); note != "" {
+			evidence = append([]string{note}, evidence...)
+		}
+		evidenceHeight := 0.0
+		if len(evidence) > 0 {
+			evidenceHeight = float64(len(evidence))*pdfEvidenceLeading + 2*pdfCellPadding
+		}
+
+		// A row stays on one page together with its evidence
+		l.ensure(rowHeight + evidenceHeight)
+
+		x := float64(pdfMargin)
+		for i := range cells {
+			if i == 2 {
+				l.page.fill(severityColor(result.Severity))
+			} else {
+				l.page.fill(pdfBlack)
+			}
+			for j, line := range wrapped[i] {
+				l.page.text(fontRegular, pdfBodySize, x+pdfCellPadding,
+					l.y-pdfCellPadding-pdfBodySize-float64(j)*pdfLineHeight, line)
+			}
+			x += widths[i]
+		}
+		l.y -= rowHeight
+
+		if len(evidence) > 0 {
+			l.page.fill(pdfEvidenceBg)
+			l.page.rect(pdfMargin, l.y-evidenceHeight, l.bodyWidth(), evidenceHeight)
+			l.page.fill(pdfGray)
+			for j, line := range evidence {
+				l.page.text(fontMono, pdfEvidenceSize, pdfMargin+pdfCellPadding,
+					l.y-pdfCellPadding-pdfEvidenceSize-float64(j)*pdfEvidenceLeading, line)
+			}
+			l.y -= evidenceHeight
+		}
+
+		l.page.stroke(pdfRule)
+		l.page.line(pdfMargin, l.y, pdfMargin+l.bodyWidth(), l.y)
+	}
+}
+
+// skippedRules draws the appendix of rules the runs did not evaluate,
+// starting on a new page. Rules skipped because they do not apply to the
+// device's vendor are left out, as they are not gaps in the checks.
+func (l *pdfLayout) skippedRules(skipped []checker.SkippedRule) {
+	var rows []checker.SkippedRule
+	for _, skip := range skipped {
+		if skip.Reason != checker.SkipReasonVendor {
+			rows = append(rows, skip)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if nameA, nameB := deviceLabel(rows[i].DeviceID, l.names), deviceLabel(rows[j].DeviceID, l.names); nameA != nameB {
+			return nameA < nameB
+		}
+		return rows[i].RuleName < rows[j].RuleName
+	})
+
+	l.tableHeader = nil
+	l.newPage()
+	l.textLine(fontBold, 16, pdfBlack, "Appendix: Skipped Rules")
+	l.textLine(fontRegular, pdfBodySize, pdfGray, "These rules were not evaluated, so the findings say nothing about them.")
+	l.y -= 4
+
+	widths := l.startTable(pdfSkippedColumns)
+	for _, skip := range rows {
+		cells := []string{deviceLabel(skip.DeviceID, l.names), skip.RuleName, string(skip.Reason)}
+		wrapped := make([][]string, len(cells))
+		lines := 1
+		for i, cell := range cells {
+			wrapped[i] = wrapText(fontRegular, pdfBodySize, cell, widths[i]-2*pdfCellPadding)
+			lines = max(lines, len(wrapped[i]))
+		}
+		rowHeight := float64(lines)*pdfLineHeight + 2*pdfCellPadding
+		l.ensure(rowHeight)
+
+		l.page.fill(pdfBlack)
+		x := float64(pdfMargin)
+		for i := range cells {
+			for j, line := range wrapped[i] {
+				l.page.text(fontRegular, pdfBodySize, x+pdfCellPadding,
+					l.y-pdfCellPadding-pdfBodySize-float64(j)*pdfLineHeight, line)
+			}
+			x += widths[i]
+		}
+		l.y -= rowHeight
+
+		l.page.stroke(pdfRule)
+		l.page.line(pdfMargin, l.y, pdfMargin+l.bodyWidth(), l.y)
+	}
+}
+
+// startTable draws the header of a table with columns, repeated on each
+// new page, and returns the column widths
+func (l *pdfLayout) startTable(columns []pdfColumn) []float64 {
+	widths := make([]float64, len(columns))
+	for i, column := range columns {
+		widths[i] = l.bodyWidth() * column.share
+	}
+
+	l.tableHeader = func() {
+		height := float64(pdfLineHeight + 2*pdfCellPadding)
+		l.page.fill(pdfHeaderBg)
+		l.page.rect(pdfMargin, l.y-height, l.bodyWidth(), height)
+		l.page.fill(pdfBlack)
+		x := float64(pdfMargin)
+		for i, column := range columns {
+			l.page.text(fontBold, pdfBodySize, x+pdfCellPadding, l.y-pdfCellPadding-pdfBodySize, column.title)
+			x += widths[i]
+		}
+		l.y -= height
+	}
+	l.tableHeader()
+	return widths
+}
+
+// evidenceLines wraps evidence to width and cuts it after
+// pdfMaxEvidenceLines lines, saying how much was left out
+func evidenceLines(evidence string, width float64) []string {
+	lines := wrapText(fontMono, pdfEvidenceSize, strings.TrimRight(evidence, "\r\n"), width)
+	if len(lines) <= pdfMaxEvidenceLines {
+		return lines
+	}
+	omitted := len(lines) - (pdfMaxEvidenceLines - 1)
+	return append(lines[:pdfMaxEvidenceLines-1], fmt.Sprintf("... %d more lines truncated", omitted))
+}
+
+// severityColor returns the chart color of a severity
+func severityColor(severity string) pdfColor {
+	if rank := severityRank(severity); rank < len(pdfSeverities) {
+		return pdfSeverities[rank].color
+	}
+	return pdfBlack
+}
+
+// numberPages adds the title and "Page n of m" to the footer of every page
+func (l *pdfLayout) numberPages(title string) {
+	for i, page := range l.pages {
+		page.fill(pdfGray)
+		page.text(fontRegular, 8, pdfMargin, pdfFooterHeight, title)
+		label := fmt.Sprintf("Page %d of %d", i+1, len(l.pages))
+		page.text(fontRegular, 8, l.size.width-pdfMargin-textWidth(fontRegular, 8, label), pdfFooterHeight, label)
+	}
+}
@@ -0,0 +1,38 @@
+// Package report renders check results into formats consumed outside the
+// app, such as SIEM event feeds and PDF audit reports.
+package report
+
+import "invictux-demo/internal/checker"
+
+// Generator renders check results. Messages are rendered in the
+// generator's locale.
+type Generator struct {
+	locale    string
+	runLabels map[string]string
+	skipped   []checker.SkippedRule
+}
+
+// NewGenerator creates a report generator rendering messages in locale. An
+// empty locale uses the catalog default.
+func NewGenerator(locale string) *Generator {
+	return &Generator{locale: locale}
+}
+
+// WithRunLabels sets the operator labels of check runs, by run ID, shown
+// with the results of those runs
+func (g *Generator) WithRunLabels(labels map[string]string) *Generator {
+	g.runLabels = labels
+	return g
+}
+
+// WithSkippedRules sets the rules the reported runs did not evaluate,
+// which PDF reports list in an appendix
+func (g *Generator) WithSkippedRules(skipped []checker.SkippedRule) *Generator {
+	g.skipped = skipped
+	return g
+}
+
+// runLabel returns the operator label of a run, if it has one
+func (g *Generator) runLabel(runID string) string {
+	return g.runLabels[runID]
+}
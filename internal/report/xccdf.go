@@ -0,0 +1,421 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+)
+
+// XCCDF 1.2 names. IDs take the form xccdf_<namespace>_<type>_<name>,
+// with a reverse-DNS namespace free of underscores.
+const (
+	xccdfNamespace     = "http://checklists.nist.gov/xccdf/1.2"
+	xccdfIDNamespace   = "com.invictux"
+	xccdfBenchmarkName = "network-config"
+	xccdfScoringSystem = "urn:xccdf:scoring:default"
+
+	// xccdfFingerprintSystem names the ident system of finding fingerprints
+	xccdfFingerprintSystem = "urn:invictux:finding-fingerprint"
+)
+
+// XCCDF rule-result values
+const (
+	XCCDFPass          = "pass"
+	XCCDFFail          = "fail"
+	XCCDFError         = "error"
+	XCCDFNotApplicable = "notapplicable"
+	XCCDFNotChecked    = "notchecked"
+	XCCDFNotSelected   = "notselected"
+)
+
+// xccdfIDUnsafe matches characters not allowed in the name part of an
+// XCCDF ID, which must be an XML NCName
+var xccdfIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// SCAPExport is the data an XCCDF document is built from: the results and
+// skipped rules of one run, with the rules and devices they refer to
+type SCAPExport struct {
+	RunID      string
+	AppVersion string
+	Rules      []checker.SecurityRule
+	Devices    []device.Device
+	Results    []checker.CheckResult
+	Skipped    []checker.SkippedRule
+
+	GeneratedAt time.Time
+}
+
+// runTitle names a run by its ID and, when it has one, its label
+func (g *Generator) runTitle(runID string) string {
+	if label := g.runLabel(runID); label != "" {
+		return fmt.Sprintf("%s (%s)", runID, label)
+	}
+	return runID
+}
+
+// XCCDFRuleID returns the XCCDF ID of a rule. It is derived from the rule
+// ID alone, so findings keep their ID across exports.
+func XCCDFRuleID(ruleID string) string {
+	return xccdfID("rule", ruleID)
+}
+
+// XCCDFResult maps a check status to an XCCDF rule-result value. Warnings,
+// such as rules without a pattern or devices under maintenance, were not
+// checked, and rules whose prerequisite failed did not apply.
+func XCCDFResult(status checker.CheckStatus) string {
+	switch status {
+	case checker.StatusPass:
+		return XCCDFPass
+	case checker.StatusFail:
+		return XCCDFFail
+	case checker.StatusError:
+		return XCCDFError
+	case checker.StatusNotApplicable:
+		return XCCDFNotApplicable
+	default:
+		return XCCDFNotChecked
+	}
+}
+
+// XCCDFSkipResult maps the reason a rule was skipped to an XCCDF
+// rule-result value
+func XCCDFSkipResult(reason checker.SkipReason) string {
+	switch reason {
+	case checker.SkipReasonVendor:
+		return XCCDFNotApplicable
+	case checker.SkipReasonDisabled, checker.SkipReasonFiltered:
+		return XCCDFNotSelected
+	default:
+		return XCCDFNotChecked
+	}
+}
+
+// xccdfSeverity maps a rule severity to the XCCDF severity scale, which
+// has no level above high
+func xccdfSeverity(severity string) string {
+	switch checker.Severity(severity) {
+	case checker.SeverityCritical, checker.SeverityHigh:
+		return "high"
+	case checker.SeverityMedium:
+		return "medium"
+	case checker.SeverityLow:
+		return "low"
+	case checker.SeverityInfo:
+		return "info"
+	default:
+		return "unknown"
+	}
+}
+
+func xccdfID(kind, name string) string {
+	return fmt.Sprintf("xccdf_%s_%s_%s", xccdfIDNamespace, kind, xccdfIDUnsafe.ReplaceAllString(name, "_"))
+}
+
+type xccdfBenchmark struct {
+	XMLName     xml.Name          `xml:"Benchmark"`
+	Namespace   string            `xml:"xmlns,attr"`
+	ID          string            `xml:"id,attr"`
+	Resolved    string            `xml:"resolved,attr"`
+	Lang        string            `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Status      xccdfStatus       `xml:"status"`
+	Title       string            `xml:"title"`
+	Description string            `xml:"description"`
+	Version     string            `xml:"version"`
+	Rules       []xccdfRule       `xml:"Rule"`
+	TestResults []xccdfTestResult `xml:"TestResult"`
+}
+
+type xccdfStatus struct {
+	Date  string `xml:"date,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xccdfRule struct {
+	ID          string   `xml:"id,attr"`
+	Selected    bool     `xml:"selected,attr"`
+	Severity    string   `xml:"severity,attr"`
+	Title       string   `xml:"title"`
+	Description string   `xml:"description,omitempty"`
+	References  []string `xml:"reference,omitempty"`
+	Rationale   string   `xml:"rationale,omitempty"`
+	FixText     string   `xml:"fixtext,omitempty"`
+}
+
+type xccdfTestResult struct {
+	ID            string            `xml:"id,attr"`
+	StartTime     string            `xml:"start-time,attr,omitempty"`
+	EndTime       string            `xml:"end-time,attr"`
+	Benchmark     xccdfBenchmarkRef `xml:"benchmark"`
+	Title         string            `xml:"title"`
+	Target        string            `xml:"target"`
+	TargetAddress string            `xml:"target-address,omitempty"`
+	RuleResults   []xccdfRuleResult `xml:"rule-result"`
+	Score         xccdfScore        `xml:"score"`
+}
+
+type xccdfBenchmarkRef struct {
+	Href string `xml:"href,attr"`
+	ID   string `xml:"id,attr"`
+}
+
+type xccdfRuleResult struct {
+	IDRef    string         `xml:"idref,attr"`
+	Severity string         `xml:"severity,attr"`
+	Time     string         `xml:"time,attr"`
+	Result   string         `xml:"result"`
+	Idents   []xccdfIdent   `xml:"ident,omitempty"`
+	Messages []xccdfMessage `xml:"message,omitempty"`
+}
+
+type xccdfIdent struct {
+	System string `xml:"system,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type xccdfMessage struct {
+	Severity string `xml:"severity,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type xccdfScore struct {
+	System  string `xml:"system,attr"`
+	Maximum string `xml:"maximum,attr"`
+	Value   string `xml:",chardata"`
+}
+
+// GenerateXCCDF writes a run as an XCCDF 1.2 Benchmark for SCAP tooling.
+// Each rule becomes a Rule, and each device a TestResult holding a
+// rule-result per check result and per skipped rule. Results match rules
+// by check name, preferring a rule of the device's vendor; results no rule
+// produced, such as port scans, get a Rule of their own.
+func (g *Generator) GenerateXCCDF(export SCAPExport, w io.Writer) error {
+	benchmarkID := xccdfID("benchmark", xccdfBenchmarkName)
+	benchmark := xccdfBenchmark{
+		Namespace:   xccdfNamespace,
+		ID:          benchmarkID,
+		Resolved:    "1",
+		Lang:        "en",
+		Status:      xccdfStatus{Date: export.GeneratedAt.Format("2006-01-02"), Value: "accepted"},
+		Title:       "Network device configuration checks",
+		Description: fmt.Sprintf("Results of check run %s", g.runTitle(export.RunID)),
+		Version:     export.AppVersion,
+	}
+
+	rules := newXCCDFRuleIndex(export.Rules)
+
+	devices := make(map[string]device.Device, len(export.Devices))
+	for _, dev := range export.Devices {
+		devices[dev.ID] = dev
+	}
+
+	resultsByDevice := make(map[string][]checker.CheckResult)
+	skippedByDevice := make(map[string][]checker.SkippedRule)
+	var deviceIDs []string
+	for _, result := range export.Results {
+		if _, seen := resultsByDevice[result.DeviceID]; !seen {
+			if _, seen := skippedByDevice[result.DeviceID]; !seen {
+				deviceIDs = append(deviceIDs, result.DeviceID)
+			}
+		}
+		resultsByDevice[result.DeviceID] = append(resultsByDevice[result.DeviceID], result)
+	}
+	for _, skip := range export.Skipped {
+		if _, seen := resultsByDevice[skip.DeviceID]; !seen {
+			if _, seen := skippedByDevice[skip.DeviceID]; !seen {
+				deviceIDs = append(deviceIDs, skip.DeviceID)
+			}
+		}
+		skippedByDevice[skip.DeviceID] = append(skippedByDevice[skip.DeviceID], skip)
+	}
+	sort.Strings(deviceIDs)
+
+	for _, deviceID := range deviceIDs {
+		dev, ok := devices[deviceID]
+		if !ok {
+			dev = device.Device{ID: deviceID, Name: deviceID}
+		}
+		testResult := xccdfTestResult{
+			ID:            xccdfID("testresult", export.RunID+"_"+deviceID),
+			Benchmark:     xccdfBenchmarkRef{Href: "#" + benchmarkID, ID: benchmarkID},
+			Title:         fmt.Sprintf("Run %s on %s", g.runTitle(export.RunID), dev.Name),
+			Target:        dev.Name,
+			TargetAddress: dev.IPAddress,
+		}
+
+		var started, finished time.Time
+		observe := func(start time.Time, duration time.Duration) {
+			if start.IsZero() {
+				return
+			}
+			if started.IsZero() || start.Before(started) {
+				started = start
+			}
+			if end := start.Add(duration); end.After(finished) {
+				finished = end
+			}
+		}
+
+		results := resultsByDevice[deviceID]
+		for _, result := range results {
+			observe(result.CheckedAt, result.Duration)
+			ruleResult := xccdfRuleResult{
+				IDRef:    rules.resultRuleID(result, dev.Vendor),
+				Severity: xccdfSeverity(result.Severity),
+				Time:     result.CheckedAt.Format(time.RFC3339),
+				Result:   XCCDFResult(checker.CheckStatus(result.Status)),
+			}
+			if result.Fingerprint != "" {
+				ruleResult.Idents = []xccdfIdent{{System: xccdfFingerprintSystem, Value: result.Fingerprint}}
+			}
+			if message := result.RenderMessage(g.locale); message != "" {
+				ruleResult.Messages = []xccdfMessage{{Severity: "info", Value: message}}
+			}
+			// A carried forward result is dated when the rule was evaluated
+			if note := result.CarriedForwardNote(); note != "" {
+				if result.EvaluatedAt != nil {
+					ruleResult.Time = result.EvaluatedAt.Format(time.RFC3339)
+				}
+				ruleResult.Messages = append(ruleResult.Messages, xccdfMessage{Severity: "info", Value: note})
+			}
+			testResult.RuleResults = append(testResult.RuleResults, ruleResult)
+		}
+		for _, skip := range skippedByDevice[deviceID] {
+			observe(skip.SkippedAt, 0)
+			testResult.RuleResults = append(testResult.RuleResults, xccdfRuleResult{
+				IDRef:    rules.skippedRuleID(skip),
+				Severity: xccdfSeverity(rules.severity(skip.RuleID)),
+				Time:     skip.SkippedAt.Format(time.RFC3339),
+				Result:   XCCDFSkipResult(skip.Reason),
+				Messages: []xccdfMessage{{Severity: "info", Value: "Skipped: " + string(skip.Reason)}},
+			})
+		}
+
+		if !started.IsZero() {
+			testResult.StartTime = started.Format(time.RFC3339)
+			testResult.EndTime = finished.Format(time.RFC3339)
+		} else {
+			testResult.EndTime = export.GeneratedAt.Format(time.RFC3339)
+		}
+		testResult.Score = xccdfScore{
+			System:  xccdfScoringSystem,
+			Maximum: "100",
+			Value:   fmt.Sprintf("%.2f", checker.CalculateComplianceScore(results)),
+		}
+		benchmark.TestResults = append(benchmark.TestResults, testResult)
+	}
+
+	benchmark.Rules = rules.xccdfRules()
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write XCCDF document: %w", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(benchmark); err != nil {
+		return fmt.Errorf("failed to write XCCDF document: %w", err)
+	}
+	return encoder.Close()
+}
+
+// xccdfRuleIndex resolves results and skipped rules to XCCDF rules,
+// collecting the rules the document declares
+type xccdfRuleIndex struct {
+	rules  []checker.SecurityRule
+	byID   map[string]checker.SecurityRule
+	byName map[string][]checker.SecurityRule
+
+	// extra holds the Rules made up for results no rule produced, by ID
+	extra map[string]xccdfRule
+}
+
+func newXCCDFRuleIndex(rules []checker.SecurityRule) *xccdfRuleIndex {
+	index := &xccdfRuleIndex{
+		rules:  rules,
+		byID:   make(map[string]checker.SecurityRule, len(rules)),
+		byName: make(map[string][]checker.SecurityRule),
+		extra:  make(map[string]xccdfRule),
+	}
+	for _, rule := range rules {
+		index.byID[rule.ID] = rule
+		index.byName[rule.Name] = append(index.byName[rule.Name], rule)
+	}
+	return index
+}
+
+// resultRuleID returns the XCCDF ID of the rule that produced a result,
+// declaring a Rule named after the check when no rule did
+func (x *xccdfRuleIndex) resultRuleID(result checker.CheckResult, vendor string) string {
+	if _, ok := x.byID[result.RuleID]; ok {
+		return XCCDFRuleID(result.RuleID)
+	}
+
+	// Results saved before they recorded their rule are matched by name
+	candidates := x.byName[result.CheckName]
+	for _, preferred := range []string{vendor, "generic"} {
+		for _, rule := range candidates {
+			if rule.Vendor == preferred {
+				return XCCDFRuleID(rule.ID)
+			}
+		}
+	}
+	if len(candidates) > 0 {
+		return XCCDFRuleID(candidates[0].ID)
+	}
+
+	id := XCCDFRuleID("check-" + result.CheckType + "-" + result.CheckName)
+	if _, ok := x.extra[id]; !ok {
+		x.extra[id] = xccdfRule{ID: id, Selected: true, Severity: xccdfSeverity(result.Severity),
+			Title: result.CheckName}
+	}
+	return id
+}
+
+// skippedRuleID returns the XCCDF ID of a skipped rule, declaring it when
+// the rule is not among the exported rules
+func (x *xccdfRuleIndex) skippedRuleID(skip checker.SkippedRule) string {
+	id := XCCDFRuleID(skip.RuleID)
+	if _, ok := x.byID[skip.RuleID]; !ok {
+		if _, ok := x.extra[id]; !ok {
+			x.extra[id] = xccdfRule{ID: id, Selected: true, Severity: "unknown", Title: skip.RuleName}
+		}
+	}
+	return id
+}
+
+// severity returns the severity of a rule, or empty when it is unknown
+func (x *xccdfRuleIndex) severity(ruleID string) string {
+	return x.byID[ruleID].Severity
+}
+
+// xccdfRules returns the exported rules followed by the made-up ones,
+// each in ID order so exports compare cleanly
+func (x *xccdfRuleIndex) xccdfRules() []xccdfRule {
+	rules := make([]xccdfRule, 0, len(x.rules)+len(x.extra))
+	for _, rule := range x.rules {
+		rules = append(rules, xccdfRule{
+			ID:          XCCDFRuleID(rule.ID),
+			Selected:    rule.Enabled,
+			Severity:    xccdfSeverity(rule.Severity),
+			Title:       rule.Name,
+			Description: strings.TrimSpace(rule.Description),
+			References:  rule.References,
+			Rationale:   strings.TrimSpace(rule.Rationale),
+			FixText:     strings.TrimSpace(rule.Remediation),
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	extra := make([]xccdfRule, 0, len(x.extra))
+	for _, rule := range x.extra {
+		extra = append(extra, rule)
+	}
+	sort.Slice(extra, func(i, j int) bool { return extra[i].ID < extra[j].ID })
+	return append(rules, extra...)
+}
@@ -0,0 +1,306 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/checker"
+	"invictux-demo/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// XCCDF 1.2 ID patterns, from the xccdf:BenchmarkIdType, RuleIdType and
+// TestResultIdType types of the XCCDF 1.2 schema
+var (
+	xccdfBenchmarkIDPattern  = regexp.MustCompile(`^xccdf_[^_]+_benchmark_.+$`)
+	xccdfRuleIDPattern       = regexp.MustCompile(`^xccdf_[^_]+_rule_.+$`)
+	xccdfTestResultIDPattern = regexp.MustCompile(`^xccdf_[^_]+_testresult_.+$`)
+	xccdfNCNamePattern       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+)
+
+// xccdfResultValues is the xccdf:resultEnumType enumeration
+var xccdfResultValues = map[string]bool{"pass": true, "fail": true, "error": true, "unknown": true,
+	"notapplicable": true, "notchecked": true, "notselected": true, "informational": true, "fixed": true}
+
+// xccdfSeverityValues is the xccdf:severityEnumType enumeration
+var xccdfSeverityValues = map[string]bool{"unknown": true, "info": true, "low": true, "medium": true, "high": true}
+
+// scapFixture returns a run over two devices with a result of every
+// status, a port scan no rule produced and a skipped rule
+func scapFixture() SCAPExport {
+	at := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	return SCAPExport{
+		RunID:      "run-1",
+		AppVersion: "1.0.0",
+		Rules: []checker.SecurityRule{
+			{ID: "cisco-ssh-v2", Name: "SSH Version 2", Vendor: "cisco", Severity: string(checker.SeverityCritical),
+				Description: "Only SSH version 2 is allowed", Remediation: "ip ssh version 2", Enabled: true,
+				Rationale: "SSH version 1 is broken", References: []string{"NIST SP 800-53 Rev. 5 AC-17"}},
+			{ID: "juniper-ssh-v2", Name: "SSH Version 2", Vendor: "juniper", Severity: string(checker.SeverityHigh),
+				Enabled: true},
+			{ID: "generic-banner", Name: "Login Banner", Vendor: "generic", Severity: string(checker.SeverityLow),
+				Enabled: true},
+			{ID: "7f0c 2e/custom", Name: "NTP Servers", Vendor: "cisco", Severity: string(checker.SeverityMedium)},
+			{ID: "cisco-telnet", Name: "Telnet Disabled", Vendor: "cisco", Severity: string(checker.SeverityInfo),
+				Enabled: true},
+		},
+		Devices: []device.Device{
+			{ID: "router1", Name: "Core Router", IPAddress: "10.0.0.1", Vendor: "cisco"},
+			{ID: "fw1", Name: "Edge Firewall", IPAddress: "10.0.0.2", Vendor: "juniper"},
+		},
+		Results: []checker.CheckResult{
+			{DeviceID: "router1", CheckName: "SSH Version 2", CheckType: "configuration",
+				Severity: string(checker.SeverityCritical), Status: string(checker.StatusFail),
+				Message: "Pattern <not> found & more", CheckedAt: at, Duration: time.Second,
+				FindingCategory: checker.FindingRemoteAccess, FindingKey: "ssh-v1-allowed",
+				Fingerprint: checker.FindingFingerprint("10.0.0.1", checker.FindingRemoteAccess, "ssh-v1-allowed")},
+			{DeviceID: "router1", CheckName: "Login Banner", CheckType: "configuration",
+				Severity: string(checker.SeverityLow), Status: string(checker.StatusPass), CheckedAt: at.Add(time.Second)},
+			{DeviceID: "router1", CheckName: "Telnet Disabled", CheckType: "configuration",
+				Severity: string(checker.SeverityInfo), Status: string(checker.StatusWarning), CheckedAt: at.Add(2 * time.Second)},
+			{DeviceID: "router1", CheckName: "Unexpected Open Ports", CheckType: "network",
+				Severity: string(checker.SeverityHigh), Status: string(checker.StatusFail), CheckedAt: at.Add(3 * time.Second)},
+			{DeviceID: "fw1", CheckName: "SSH Version 2", CheckType: "configuration",
+				Severity: string(checker.SeverityHigh), Status: string(checker.StatusError), CheckedAt: at},
+		},
+		Skipped: []checker.SkippedRule{
+			{DeviceID: "fw1", RuleID: "cisco-telnet", RuleName: "Telnet Disabled", Reason: checker.SkipReasonVendor,
+				SkippedAt: at},
+		},
+		GeneratedAt: at.Add(time.Hour),
+	}
+}
+
+// parsedXCCDF is the subset of an XCCDF document the tests inspect, with
+// element order kept so it can be checked against the schema's sequences
+type parsedXCCDF struct {
+	XMLName  xml.Name
+	ID       string `xml:"id,attr"`
+	Lang     string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Resolved string `xml:"resolved,attr"`
+	Children []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+	Status  string `xml:"status"`
+	Version string `xml:"version"`
+	Rules   []struct {
+		ID         string   `xml:"id,attr"`
+		Selected   string   `xml:"selected,attr"`
+		Severity   string   `xml:"severity,attr"`
+		Title      string   `xml:"title"`
+		References []string `xml:"reference"`
+		Rationale  string   `xml:"rationale"`
+		FixText    string   `xml:"fixtext"`
+	} `xml:"Rule"`
+	TestResults []struct {
+		ID            string `xml:"id,attr"`
+		EndTime       string `xml:"end-time,attr"`
+		Target        string `xml:"target"`
+		TargetAddress string `xml:"target-address"`
+		Benchmark     struct {
+			Href string `xml:"href,attr"`
+		} `xml:"benchmark"`
+		RuleResults []struct {
+			IDRef    string `xml:"idref,attr"`
+			Severity string `xml:"severity,attr"`
+			Result   string `xml:"result"`
+			Idents   []struct {
+				System string `xml:"system,attr"`
+				Value  string `xml:",chardata"`
+			} `xml:"ident"`
+			Messages []string `xml:"message"`
+		} `xml:"rule-result"`
+		Score []struct {
+			System string `xml:"system,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"score"`
+	} `xml:"TestResult"`
+}
+
+func generateXCCDF(t *testing.T, export SCAPExport) (string, parsedXCCDF) {
+	t.Helper()
+	var out bytes.Buffer
+	require.NoError(t, NewGenerator("").GenerateXCCDF(export, &out))
+
+	var doc parsedXCCDF
+	require.NoError(t, xml.Unmarshal(out.Bytes(), &doc))
+	return out.String(), doc
+}
+
+func TestGenerator_GenerateXCCDF_SchemaConformance(t *testing.T) {
+	raw, doc := generateXCCDF(t, scapFixture())
+
+	assert.True(t, strings.HasPrefix(raw, xml.Header))
+	assert.Equal(t, xml.Name{Space: "http://checklists.nist.gov/xccdf/1.2", Local: "Benchmark"}, doc.XMLName)
+	assert.Regexp(t, xccdfBenchmarkIDPattern, doc.ID)
+	assert.Equal(t, "en", doc.Lang)
+	assert.Equal(t, "1", doc.Resolved)
+	assert.Equal(t, "accepted", doc.Status)
+	assert.Equal(t, "1.0.0", doc.Version)
+
+	// Benchmark children follow the schema's sequence: status, title,
+	// description and version, then Rules, then TestResults
+	order := map[string]int{"status": 0, "title": 1, "description": 2, "version": 3, "Rule": 4, "TestResult": 5}
+	last := -1
+	for _, child := range doc.Children {
+		rank, known := order[child.XMLName.Local]
+		require.True(t, known, "unexpected element %s", child.XMLName.Local)
+		assert.GreaterOrEqual(t, rank, last, "%s is out of order", child.XMLName.Local)
+		last = rank
+	}
+
+	ruleIDs := make(map[string]bool)
+	for _, rule := range doc.Rules {
+		assert.Regexp(t, xccdfRuleIDPattern, rule.ID)
+		assert.Regexp(t, xccdfNCNamePattern, rule.ID)
+		assert.False(t, ruleIDs[rule.ID], "rule %s is declared twice", rule.ID)
+		ruleIDs[rule.ID] = true
+		assert.True(t, xccdfSeverityValues[rule.Severity], rule.Severity)
+		assert.NotEmpty(t, rule.Title)
+	}
+	assert.Len(t, doc.Rules, 6, "every rule plus the port scan")
+	for _, rule := range doc.Rules {
+		if rule.ID == "xccdf_com.invictux_rule_cisco-ssh-v2" {
+			assert.Equal(t, "ip ssh version 2", rule.FixText, "remediation becomes the fix text")
+			assert.Equal(t, "SSH version 1 is broken", rule.Rationale)
+			assert.Equal(t, []string{"NIST SP 800-53 Rev. 5 AC-17"}, rule.References)
+		}
+	}
+	assert.True(t, ruleIDs["xccdf_com.invictux_rule_7f0c_2e_custom"], "unsafe ID characters are replaced")
+	assert.Regexp(t, `</description>\s*<reference>[^<]*</reference>\s*<rationale>[^<]*</rationale>\s*<fixtext>`, raw,
+		"references and the rationale sit between the description and the fix text")
+
+	require.Len(t, doc.TestResults, 2)
+	for _, testResult := range doc.TestResults {
+		assert.Regexp(t, xccdfTestResultIDPattern, testResult.ID)
+		assert.Regexp(t, xccdfNCNamePattern, testResult.ID)
+		assert.NotEmpty(t, testResult.Target)
+		assert.NotEmpty(t, testResult.EndTime)
+		assert.Equal(t, "#"+doc.ID, testResult.Benchmark.Href)
+		require.Len(t, testResult.Score, 1)
+		assert.Equal(t, "urn:xccdf:scoring:default", testResult.Score[0].System)
+		for _, ruleResult := range testResult.RuleResults {
+			assert.True(t, ruleIDs[ruleResult.IDRef], "rule-result refers to undeclared rule %s", ruleResult.IDRef)
+			assert.True(t, xccdfResultValues[ruleResult.Result], ruleResult.Result)
+			assert.True(t, xccdfSeverityValues[ruleResult.Severity], ruleResult.Severity)
+		}
+	}
+
+	firewall, router := doc.TestResults[0], doc.TestResults[1]
+	assert.Equal(t, "Core Router", router.Target)
+	assert.Equal(t, "10.0.0.1", router.TargetAddress)
+	require.Len(t, router.RuleResults, 4)
+	assert.Equal(t, "xccdf_com.invictux_rule_cisco-ssh-v2", router.RuleResults[0].IDRef)
+	assert.Equal(t, []string{"Pattern <not> found & more"}, router.RuleResults[0].Messages)
+	require.Len(t, router.RuleResults[0].Idents, 1, "the finding fingerprint is an ident")
+	assert.Equal(t, "urn:invictux:finding-fingerprint", router.RuleResults[0].Idents[0].System)
+	assert.Equal(t, scapFixture().Results[0].Fingerprint, router.RuleResults[0].Idents[0].Value)
+	assert.Empty(t, router.RuleResults[1].Idents)
+	assert.Regexp(t, `</result>\s*<ident [^>]*>[0-9a-f]{64}</ident>\s*<message `, raw, "idents follow the result and precede messages")
+	assert.Equal(t, "xccdf_com.invictux_rule_check-network-Unexpected_Open_Ports", router.RuleResults[3].IDRef)
+	assert.Equal(t, "33.33", router.Score[0].Value)
+
+	require.Len(t, firewall.RuleResults, 2)
+	assert.Equal(t, "xccdf_com.invictux_rule_juniper-ssh-v2", firewall.RuleResults[0].IDRef,
+		"results match the rule of the device's vendor")
+	assert.Equal(t, "notapplicable", firewall.RuleResults[1].Result)
+	assert.Equal(t, []string{"Skipped: not-applicable-vendor"}, firewall.RuleResults[1].Messages)
+}
+
+// TestGenerator_GenerateXCCDF_ValidatesAgainstSchema validates an export
+// against testdata/xccdf_1.2.xsd with xmllint, which must be installed
+func TestGenerator_GenerateXCCDF_ValidatesAgainstSchema(t *testing.T) {
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		t.Skip("xmllint is not installed")
+	}
+	schema, err := filepath.Abs(filepath.Join("testdata", "xccdf_1.2.xsd"))
+	require.NoError(t, err)
+
+	validate := func(document string) (string, error) {
+		path := filepath.Join(t.TempDir(), "export.xml")
+		require.NoError(t, os.WriteFile(path, []byte(document), 0600))
+		out, err := exec.Command(xmllint, "--noout", "--nonet", "--schema", schema, path).CombinedOutput()
+		return string(out), err
+	}
+
+	raw, _ := generateXCCDF(t, scapFixture())
+	out, err := validate(raw)
+	assert.NoError(t, err, out)
+
+	// The schema catches what the exporter could get wrong
+	out, err = validate(strings.Replace(raw, "<result>fail</result>", "<result>failed</result>", 1))
+	assert.Error(t, err, out)
+}
+
+func TestXCCDFResult(t *testing.T) {
+	tests := []struct {
+		status checker.CheckStatus
+		want   string
+	}{
+		{checker.StatusPass, "pass"},
+		{checker.StatusFail, "fail"},
+		{checker.StatusError, "error"},
+		{checker.StatusWarning, "notchecked"},
+		{checker.StatusNotApplicable, "notapplicable"},
+		{checker.CheckStatus("UNKNOWN"), "notchecked"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, XCCDFResult(tt.status), string(tt.status))
+	}
+
+	skips := []struct {
+		reason checker.SkipReason
+		want   string
+	}{
+		{checker.SkipReasonVendor, "notapplicable"},
+		{checker.SkipReasonDisabled, "notselected"},
+		{checker.SkipReasonFiltered, "notselected"},
+		{checker.SkipReasonSuppressed, "notchecked"},
+		{checker.SkipReasonMaintenance, "notchecked"},
+		{checker.SkipReasonCircuitOpen, "notchecked"},
+		{checker.SkipReasonNeedsAttention, "notchecked"},
+	}
+	for _, tt := range skips {
+		assert.Equal(t, tt.want, XCCDFSkipResult(tt.reason), string(tt.reason))
+	}
+}
+
+func TestGenerator_GenerateXCCDF_StableIDs(t *testing.T) {
+	first, firstDoc := generateXCCDF(t, scapFixture())
+	again, _ := generateXCCDF(t, scapFixture())
+	assert.Equal(t, first, again, "exporting the same run twice gives the same document")
+
+	// IDs do not depend on when the export ran or the order of its input
+	later := scapFixture()
+	later.GeneratedAt = later.GeneratedAt.Add(24 * time.Hour)
+	for i, j := 0, len(later.Rules)-1; i < j; i, j = i+1, j-1 {
+		later.Rules[i], later.Rules[j] = later.Rules[j], later.Rules[i]
+	}
+	_, laterDoc := generateXCCDF(t, later)
+
+	ids := func(doc parsedXCCDF) []string {
+		var ids []string
+		for _, rule := range doc.Rules {
+			ids = append(ids, rule.ID)
+		}
+		for _, testResult := range doc.TestResults {
+			ids = append(ids, testResult.ID)
+			for _, ruleResult := range testResult.RuleResults {
+				ids = append(ids, ruleResult.IDRef)
+			}
+		}
+		return ids
+	}
+	assert.Equal(t, ids(firstDoc), ids(laterDoc))
+	assert.Equal(t, "xccdf_com.invictux_rule_cisco-ssh-v2", XCCDFRuleID("cisco-ssh-v2"))
+}
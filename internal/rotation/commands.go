@@ -0,0 +1,88 @@
+package rotation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"invictux-demo/internal/device"
+)
+
+// MinPasswordLength is the shortest password a rotation accepts
+const MinPasswordLength = 8
+
+// forbiddenChars cannot appear in a username or password because they would
+// end the quoted value or the command on at least one vendor CLI
+const forbiddenChars = "\"'\\;[]{}|`$"
+
+// commandBuilders produce the password change sequence for each supported vendor
+var commandBuilders = map[device.Vendor]func(username, password string) []string{
+	device.VendorCisco:    iosPasswordCommands,
+	device.VendorArista:   iosPasswordCommands,
+	device.VendorJuniper:  junosPasswordCommands,
+	device.VendorMikroTik: routerOSPasswordCommands,
+}
+
+// iosPasswordCommands changes a local user's secret on IOS style CLIs
+func iosPasswordCommands(username, password string) []string {
+	return []string{
+		"configure terminal",
+		fmt.Sprintf("username %s secret %s", username, password),
+		"end",
+		"write memory",
+	}
+}
+
+// junosPasswordCommands changes a login user's password on Junos
+func junosPasswordCommands(username, password string) []string {
+	return []string{
+		"configure",
+		fmt.Sprintf("set system login user %s authentication plain-text-password-value \"%s\"", username, password),
+		"commit and-quit",
+	}
+}
+
+// routerOSPasswordCommands changes a user's password on RouterOS
+func routerOSPasswordCommands(username, password string) []string {
+	return []string{
+		fmt.Sprintf("/user set [find name=\"%s\"] password=\"%s\"", username, password),
+	}
+}
+
+// SupportsVendor reports whether rotation has a command sequence for a vendor
+func SupportsVendor(vendor string) bool {
+	_, ok := commandBuilders[device.Vendor(vendor)]
+	return ok
+}
+
+// passwordChangeCommands returns the command sequence that sets username's
+// password on a device of the given vendor
+func passwordChangeCommands(vendor, username, password string) ([]string, bool) {
+	build, ok := commandBuilders[device.Vendor(vendor)]
+	if !ok {
+		return nil, false
+	}
+	return build(username, password), true
+}
+
+// ValidatePassword checks that a new password is long enough and can be
+// passed to every supported CLI without quoting problems
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("new password must be at least %d characters", MinPasswordLength)
+	}
+	if !safeCLIValue(password) {
+		return fmt.Errorf("new password contains whitespace, control or quote characters")
+	}
+	return nil
+}
+
+// safeCLIValue reports whether a value can be embedded in a vendor command
+func safeCLIValue(value string) bool {
+	for _, r := range value {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(forbiddenChars, r) {
+			return false
+		}
+	}
+	return value != ""
+}
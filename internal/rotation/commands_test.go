@@ -0,0 +1,48 @@
+package rotation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordChangeCommands(t *testing.T) {
+	tests := []struct {
+		vendor   string
+		contains string
+	}{
+		{"cisco", "username svc secret Passw0rd!"},
+		{"arista", "username svc secret Passw0rd!"},
+		{"juniper", `set system login user svc authentication plain-text-password-value "Passw0rd!"`},
+		{"mikrotik", `/user set [find name="svc"] password="Passw0rd!"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.vendor, func(t *testing.T) {
+			commands, ok := passwordChangeCommands(tt.vendor, "svc", "Passw0rd!")
+			assert.True(t, ok)
+			assert.True(t, SupportsVendor(tt.vendor))
+			assert.Contains(t, strings.Join(commands, "\n"), tt.contains)
+		})
+	}
+
+	_, ok := passwordChangeCommands("hp", "svc", "Passw0rd!")
+	assert.False(t, ok)
+	assert.False(t, SupportsVendor("hp"))
+}
+
+func TestValidatePassword(t *testing.T) {
+	assert.NoError(t, ValidatePassword("Passw0rd!"))
+
+	for _, password := range []string{
+		"short",
+		"has space1",
+		"quote\"d123",
+		"semi;colon1",
+		"new\nline12",
+		"$(reboot)1",
+	} {
+		assert.Error(t, ValidatePassword(password), password)
+	}
+}
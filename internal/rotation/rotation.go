@@ -0,0 +1,584 @@
+package rotation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+
+	"github.com/google/uuid"
+)
+
+// Defaults for a rotation run
+const (
+	DefaultConcurrency   = 5
+	MaxConcurrency       = 50
+	DefaultTimeLimit     = 30 * time.Minute
+	DefaultDeviceTimeout = 2 * time.Minute
+)
+
+// Outcome is the result of rotating the credential of one device
+type Outcome string
+
+const (
+	// OutcomePending devices have not been rotated yet and are retried on resume
+	OutcomePending Outcome = "pending"
+	// OutcomeChanged devices accepted the new password and it is now stored
+	OutcomeChanged Outcome = "changed"
+	// OutcomeVerifyFailed devices did not accept the new password after the
+	// change, so the old stored credential was kept
+	OutcomeVerifyFailed Outcome = "verify_failed"
+	// OutcomeUnreachable devices could not be connected to
+	OutcomeUnreachable Outcome = "unreachable"
+	// OutcomeUnsupportedVendor devices have no known password change sequence
+	OutcomeUnsupportedVendor Outcome = "unsupported_vendor"
+	// OutcomeFailed devices rejected the stored credential or failed otherwise
+	OutcomeFailed Outcome = "failed"
+)
+
+// Run status values
+const (
+	StatusRunning     = "running"
+	StatusInterrupted = "interrupted"
+	StatusCompleted   = "completed"
+)
+
+// DeviceStore reads devices and replaces their stored credential
+type DeviceStore interface {
+	GetDevice(id string) (*device.Device, error)
+	UpdateDeviceCredentials(id string, passwordEncrypted []byte) error
+}
+
+// Cipher encrypts and decrypts stored credentials
+type Cipher interface {
+	Encrypt(plaintext string) ([]byte, error)
+	Decrypt(ciphertext []byte) (string, error)
+}
+
+// SSHClient is the subset of the SSH client used by a rotation
+type SSHClient interface {
+	Connect(ctx context.Context, connInfo *ssh.ConnectionInfo) (*ssh.SSHConnection, error)
+	ExecuteCommands(ctx context.Context, conn *ssh.SSHConnection, commands []string) ([]*ssh.CommandResult, error)
+	Disconnect(conn *ssh.SSHConnection) error
+	TestAuthentication(ctx context.Context, connInfo *ssh.ConnectionInfo) error
+}
+
+// Auditor records audit entries
+type Auditor interface {
+	Log(entry security.AuditEntry) error
+}
+
+// Request starts a rotation over a selection of devices
+type Request struct {
+	DeviceIDs   []string      `json:"deviceIds"`
+	NewPassword string        `json:"newPassword"`
+	Concurrency int           `json:"concurrency"`
+	TimeLimit   time.Duration `json:"timeLimit"`
+}
+
+// DeviceReport is the rotation outcome for one device
+type DeviceReport struct {
+	DeviceID   string    `json:"deviceId"`
+	DeviceName string    `json:"deviceName"`
+	Vendor     string    `json:"vendor"`
+	Outcome    Outcome   `json:"outcome"`
+	Message    string    `json:"message,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// Report describes a rotation run and the outcome for each device
+type Report struct {
+	RunID      string          `json:"runId"`
+	Status     string          `json:"status"`
+	CreatedBy  string          `json:"createdBy"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	Devices    []DeviceReport  `json:"devices"`
+	Summary    map[Outcome]int `json:"summary"`
+}
+
+// RotationManager rotates device passwords. The new password is stored only
+// after the device accepts it on a fresh login, and every run is persisted
+// so an interrupted run can be resumed.
+type RotationManager struct {
+	db            *sql.DB
+	devices       DeviceStore
+	cipher        Cipher
+	client        SSHClient
+	auditor       Auditor
+	userID        string
+	deviceTimeout time.Duration
+
+	// hostKeyCallback, when set, returns the host key verification of a
+	// device, as its host key policy asks
+	hostKeyCallback func(dev *device.Device) ssh.HostKeyCallback
+}
+
+// NewRotationManager creates a new rotation manager acting as userID
+func NewRotationManager(db *sql.DB, devices DeviceStore, cipher Cipher, client SSHClient, auditor Auditor, userID string) *RotationManager {
+	return &RotationManager{
+		db:            db,
+		devices:       devices,
+		cipher:        cipher,
+		client:        client,
+		auditor:       auditor,
+		userID:        userID,
+		deviceTimeout: DefaultDeviceTimeout,
+	}
+}
+
+// ReencryptPasswordsContext passes the new password of every rotation run
+// to reencrypt and stores the ones it changes, returning how many changed
+func ReencryptPasswordsContext(ctx context.Context, db *sql.DB, reencrypt device.ReencryptFunc) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, new_password_encrypted FROM credential_rotations WHERE new_password_encrypted IS NOT NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query rotation passwords: %w", err)
+	}
+	updated := make(map[string][]byte)
+	for rows.Next() {
+		var id string
+		var password []byte
+		if err := rows.Scan(&id, &password); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan rotation password: %w", err)
+		}
+		if password, changed := reencrypt(password); changed {
+			updated[id] = password
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating over rotation passwords: %w", err)
+	}
+
+	for id, password := range updated {
+		if _, err := tx.ExecContext(ctx, `UPDATE credential_rotations SET new_password_encrypted = ? WHERE id = ?`, password, id); err != nil {
+			return 0, fmt.Errorf("failed to update rotation password: %w", err)
+		}
+	}
+	return len(updated), tx.Commit()
+}
+
+// SetDeviceTimeout sets how long a single device may take
+func (rm *RotationManager) SetDeviceTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		rm.deviceTimeout = timeout
+	}
+}
+
+// SetHostKeyCallbacks sets how the host key verification of each device is
+// chosen; without it the SSH client's default verification applies
+func (rm *RotationManager) SetHostKeyCallbacks(callback func(dev *device.Device) ssh.HostKeyCallback) {
+	rm.hostKeyCallback = callback
+}
+
+// Start creates a rotation run for the selected devices and processes it
+// until every device has an outcome or the time limit is reached
+func (rm *RotationManager) Start(ctx context.Context, req Request) (*Report, error) {
+	if err := ValidatePassword(req.NewPassword); err != nil {
+		return nil, err
+	}
+	if len(req.DeviceIDs) == 0 {
+		return nil, fmt.Errorf("no devices selected for rotation")
+	}
+
+	var devices []*device.Device
+	seen := make(map[string]bool)
+	for _, id := range req.DeviceIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		dev, err := rm.devices.GetDevice(id)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, dev)
+	}
+
+	encrypted, err := rm.cipher.Encrypt(req.NewPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt new password: %w", err)
+	}
+
+	runID := uuid.New().String()
+	if err := rm.createRun(runID, encrypted, devices); err != nil {
+		return nil, err
+	}
+
+	rm.audit(security.EntityCredentialRotation, runID,
+		fmt.Sprintf("Started credential rotation on %d devices", len(devices)))
+
+	return rm.process(ctx, runID, req.NewPassword, req.Concurrency, req.TimeLimit)
+}
+
+// Resume continues a run that was interrupted, rotating only the devices that
+// are still pending
+func (rm *RotationManager) Resume(ctx context.Context, runID string, concurrency int, timeLimit time.Duration) (*Report, error) {
+	var status string
+	var encrypted []byte
+	err := rm.db.QueryRow("SELECT status, new_password_encrypted FROM credential_rotations WHERE id = ?", runID).
+		Scan(&status, &encrypted)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("credential rotation %s not found", runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credential rotation: %w", err)
+	}
+	if status == StatusCompleted {
+		return rm.GetReport(runID)
+	}
+
+	newPassword, err := rm.cipher.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt rotation password: %w", err)
+	}
+
+	if _, err := rm.db.Exec("UPDATE credential_rotations SET status = ? WHERE id = ?", StatusRunning, runID); err != nil {
+		return nil, fmt.Errorf("failed to update credential rotation: %w", err)
+	}
+
+	rm.audit(security.EntityCredentialRotation, runID, "Resumed credential rotation")
+
+	return rm.process(ctx, runID, newPassword, concurrency, timeLimit)
+}
+
+// GetReport returns the current state of a rotation run
+func (rm *RotationManager) GetReport(runID string) (*Report, error) {
+	report := &Report{RunID: runID, Summary: make(map[Outcome]int)}
+
+	var finishedAt sql.NullTime
+	err := rm.db.QueryRow("SELECT status, created_by, started_at, finished_at FROM credential_rotations WHERE id = ?", runID).
+		Scan(&report.Status, &report.CreatedBy, &report.StartedAt, &finishedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("credential rotation %s not found", runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credential rotation: %w", err)
+	}
+	if finishedAt.Valid {
+		report.FinishedAt = &finishedAt.Time
+	}
+
+	rows, err := rm.db.Query(`
+		SELECT device_id, device_name, vendor, outcome, message, updated_at
+		FROM credential_rotation_devices
+		WHERE rotation_id = ?
+		ORDER BY device_name, device_id
+	`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rotation devices: %w", err)
+	}
+	defer rows.Close()
+
+	report.Devices = []DeviceReport{}
+	for rows.Next() {
+		var entry DeviceReport
+		var outcome string
+		var message sql.NullString
+		if err := rows.Scan(&entry.DeviceID, &entry.DeviceName, &entry.Vendor, &outcome,
+			&message, &entry.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan rotation device: %w", err)
+		}
+		entry.Outcome = Outcome(outcome)
+		entry.Message = message.String
+		report.Devices = append(report.Devices, entry)
+		report.Summary[entry.Outcome]++
+	}
+
+	return report, rows.Err()
+}
+
+// createRun persists a new run with every device pending
+func (rm *RotationManager) createRun(runID string, encrypted []byte, devices []*device.Device) error {
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO credential_rotations (id, status, new_password_encrypted, created_by, started_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, runID, StatusRunning, encrypted, rm.userID, time.Now()); err != nil {
+		return fmt.Errorf("failed to create credential rotation: %w", err)
+	}
+
+	query := `
+		INSERT INTO credential_rotation_devices (rotation_id, device_id, device_name, vendor, outcome, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	for _, dev := range devices {
+		if _, err := tx.Exec(query, runID, dev.ID, dev.Name, dev.Vendor, string(OutcomePending), time.Now()); err != nil {
+			return fmt.Errorf("failed to add device %s to rotation: %w", dev.Name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pendingDevices returns the IDs of the devices of a run without an outcome
+func (rm *RotationManager) pendingDevices(runID string) ([]string, error) {
+	rows, err := rm.db.Query(`
+		SELECT device_id FROM credential_rotation_devices
+		WHERE rotation_id = ? AND outcome = ?
+		ORDER BY device_name, device_id
+	`, runID, string(OutcomePending))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pending devices: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// process rotates the pending devices of a run with bounded concurrency.
+// Devices not started before the time limit stay pending.
+func (rm *RotationManager) process(ctx context.Context, runID, newPassword string, concurrency int, timeLimit time.Duration) (*Report, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	if concurrency > MaxConcurrency {
+		concurrency = MaxConcurrency
+	}
+	if timeLimit <= 0 {
+		timeLimit = DefaultTimeLimit
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeLimit)
+	defer cancel()
+
+	pending, err := rm.pendingDevices(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+dispatch:
+	for _, id := range pending {
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(deviceID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			rm.rotateDevice(ctx, runID, deviceID, newPassword)
+		}(id)
+	}
+	wg.Wait()
+
+	if err := rm.finishRun(runID); err != nil {
+		return nil, err
+	}
+
+	return rm.GetReport(runID)
+}
+
+// finishRun marks a run completed when no device is pending, and interrupted otherwise
+func (rm *RotationManager) finishRun(runID string) error {
+	pending, err := rm.pendingDevices(runID)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		if _, err := rm.db.Exec("UPDATE credential_rotations SET status = ? WHERE id = ?", StatusInterrupted, runID); err != nil {
+			return fmt.Errorf("failed to update credential rotation: %w", err)
+		}
+		rm.audit(security.EntityCredentialRotation, runID,
+			fmt.Sprintf("Credential rotation interrupted with %d devices pending", len(pending)))
+		return nil
+	}
+
+	// The new password is no longer needed once every device has an outcome
+	if _, err := rm.db.Exec(`
+		UPDATE credential_rotations SET status = ?, finished_at = ?, new_password_encrypted = NULL WHERE id = ?
+	`, StatusCompleted, time.Now(), runID); err != nil {
+		return fmt.Errorf("failed to update credential rotation: %w", err)
+	}
+	rm.audit(security.EntityCredentialRotation, runID, "Completed credential rotation")
+
+	return nil
+}
+
+// rotateDevice changes, verifies and stores the password of one device and
+// records the outcome. When the run is cancelled before an outcome is known
+// the device stays pending.
+func (rm *RotationManager) rotateDevice(ctx context.Context, runID, deviceID, newPassword string) {
+	deviceCtx, cancel := context.WithTimeout(ctx, rm.deviceTimeout)
+	defer cancel()
+
+	outcome, message := rm.changePassword(deviceCtx, deviceID, newPassword)
+	if outcome == OutcomePending && message == "" {
+		if ctx.Err() != nil {
+			// The run's time limit was reached, a resumed run retries the device
+			return
+		}
+		message = "device did not finish within the device timeout"
+	}
+
+	rm.recordOutcome(runID, deviceID, outcome, message)
+}
+
+// changePassword runs the rotation steps for one device
+func (rm *RotationManager) changePassword(ctx context.Context, deviceID, newPassword string) (Outcome, string) {
+	dev, err := rm.devices.GetDevice(deviceID)
+	if err != nil {
+		return OutcomeFailed, fmt.Sprintf("device could not be loaded: %v", err)
+	}
+
+	if !safeCLIValue(dev.Username) {
+		return OutcomeFailed, "device username cannot be used in a password change command"
+	}
+	commands, ok := passwordChangeCommands(dev.Vendor, dev.Username, newPassword)
+	if !ok {
+		return OutcomeUnsupportedVendor, fmt.Sprintf("no password change sequence for vendor %s", dev.Vendor)
+	}
+
+	oldPassword, err := rm.cipher.Decrypt(dev.PasswordEncrypted)
+	if err != nil {
+		return OutcomeFailed, "stored credential could not be decrypted"
+	}
+
+	oldInfo := rm.connectionInfo(dev, oldPassword)
+	newInfo := rm.connectionInfo(dev, newPassword)
+
+	conn, err := rm.client.Connect(ctx, oldInfo)
+	if err != nil {
+		if ctx.Err() != nil {
+			return OutcomePending, ""
+		}
+		switch ssh.ErrorKindOf(err) {
+		case ssh.ErrorKindAuth:
+			// An interrupted run may have changed the password without storing it
+			if rm.client.TestAuthentication(ctx, newInfo) == nil {
+				return rm.storeNewPassword(dev, newPassword, "device already had the new password")
+			}
+			return OutcomeFailed, "device rejected the stored credential"
+		case ssh.ErrorKindUnreachable, ssh.ErrorKindTimeout:
+			return OutcomeUnreachable, "device could not be reached"
+		default:
+			return OutcomeFailed, fmt.Sprintf("connection failed: %v", err)
+		}
+	}
+
+	results, execErr := rm.client.ExecuteCommands(ctx, conn, commands)
+	rm.client.Disconnect(conn)
+	if execErr == nil {
+		execErr = firstCommandError(results)
+	}
+
+	// Verify on a fresh login, never on the session that made the change
+	if err := rm.client.TestAuthentication(ctx, newInfo); err != nil {
+		if ctx.Err() != nil {
+			return OutcomePending, ""
+		}
+		if execErr != nil {
+			return OutcomeFailed, fmt.Sprintf("password change failed: %v", execErr)
+		}
+		return OutcomeVerifyFailed, "device did not accept the new password, stored credential unchanged"
+	}
+
+	return rm.storeNewPassword(dev, newPassword, "password changed and verified")
+}
+
+// storeNewPassword replaces the stored credential once the device accepts it.
+// A failure leaves the device pending so a resumed run stores it later.
+func (rm *RotationManager) storeNewPassword(dev *device.Device, newPassword, message string) (Outcome, string) {
+	encrypted, err := rm.cipher.Encrypt(newPassword)
+	if err == nil {
+		err = rm.devices.UpdateDeviceCredentials(dev.ID, encrypted)
+	}
+	if err != nil {
+		return OutcomePending, fmt.Sprintf("new password verified but not stored: %v", err)
+	}
+	return OutcomeChanged, message
+}
+
+// recordOutcome persists and audits the outcome for one device
+func (rm *RotationManager) recordOutcome(runID, deviceID string, outcome Outcome, message string) {
+	if _, err := rm.db.Exec(`
+		UPDATE credential_rotation_devices SET outcome = ?, message = ?, updated_at = ?
+		WHERE rotation_id = ? AND device_id = ?
+	`, string(outcome), message, time.Now(), runID, deviceID); err != nil {
+		log.Printf("Failed to record rotation outcome for device %s: %v", deviceID, err)
+	}
+
+	rm.audit(security.EntityDevice, deviceID,
+		fmt.Sprintf("Credential rotation %s: %s (%s)", runID, outcome, message))
+}
+
+// audit records a rotation audit entry. Failures are logged only.
+func (rm *RotationManager) audit(entityType, entityID, details string) {
+	if rm.auditor == nil {
+		return
+	}
+
+	err := rm.auditor.Log(security.AuditEntry{
+		UserID:     rm.userID,
+		ActionType: security.ActionRotate,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Details:    details,
+	})
+	if err != nil {
+		log.Printf("Failed to record rotation audit entry: %v", err)
+	}
+}
+
+// connectionInfo builds password connection details for a device
+func (rm *RotationManager) connectionInfo(dev *device.Device, password string) *ssh.ConnectionInfo {
+	info := &ssh.ConnectionInfo{
+		Host:       dev.IPAddress,
+		Port:       dev.SSHPort,
+		Username:   dev.Username,
+		Password:   password,
+		AuthMethod: ssh.AuthPassword,
+	}
+	if rm.hostKeyCallback != nil {
+		info.HostKeyCallback = rm.hostKeyCallback(dev)
+	}
+	return info
+}
+
+// firstCommandError returns an error for the first command that failed.
+// Command text is never included since it contains the new password.
+func firstCommandError(results []*ssh.CommandResult) error {
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		if result.ExitCode != 0 || result.Error != "" {
+			return fmt.Errorf("step %d exited with status %d", i+1, result.ExitCode)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package rotation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+
+	"invictux-demo/internal/database"
+	"invictux-demo/internal/device"
+	"invictux-demo/internal/security"
+	"invictux-demo/internal/ssh"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oldPassword = "OldPassw0rd!"
+	newPassword = "NewPassw0rd!"
+)
+
+// fakeDevice is the password state of one simulated device
+type fakeDevice struct {
+	password     string
+	unreachable  bool
+	ignoreChange bool
+}
+
+// fakeSSHClient simulates devices that accept the password change commands
+type fakeSSHClient struct {
+	mu        sync.Mutex
+	devices   map[string]*fakeDevice
+	conns     map[*ssh.SSHConnection]string
+	onExecute func(host string)
+}
+
+var newPasswordPattern = regexp.MustCompile(`(?:secret |plain-text-password-value "|password=")([^"\s]+)`)
+
+func newFakeSSHClient() *fakeSSHClient {
+	return &fakeSSHClient{
+		devices: make(map[string]*fakeDevice),
+		conns:   make(map[*ssh.SSHConnection]string),
+	}
+}
+
+func (c *fakeSSHClient) login(ctx context.Context, info *ssh.ConnectionInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dev, ok := c.devices[info.Host]
+	if !ok || dev.unreachable {
+		return &ssh.SSHError{Kind: ssh.ErrorKindUnreachable, Host: info.Host, Err: errors.New("connection refused")}
+	}
+	if dev.password != info.Password {
+		return &ssh.SSHError{Kind: ssh.ErrorKindAuth, Host: info.Host, Err: errors.New("unable to authenticate")}
+	}
+	return nil
+}
+
+func (c *fakeSSHClient) Connect(ctx context.Context, info *ssh.ConnectionInfo) (*ssh.SSHConnection, error) {
+	if err := c.login(ctx, info); err != nil {
+		return nil, err
+	}
+
+	conn := &ssh.SSHConnection{}
+	c.mu.Lock()
+	c.conns[conn] = info.Host
+	c.mu.Unlock()
+	return conn, nil
+}
+
+func (c *fakeSSHClient) ExecuteCommands(ctx context.Context, conn *ssh.SSHConnection, commands []string) ([]*ssh.CommandResult, error) {
+	c.mu.Lock()
+	host := c.conns[conn]
+	dev := c.devices[host]
+	results := make([]*ssh.CommandResult, 0, len(commands))
+	for _, command := range commands {
+		if match := newPasswordPattern.FindStringSubmatch(command); match != nil && !dev.ignoreChange {
+			dev.password = match[1]
+		}
+		results = append(results, &ssh.CommandResult{Command: command})
+	}
+	hook := c.onExecute
+	c.mu.Unlock()
+
+	if hook != nil {
+		hook(host)
+	}
+	return results, nil
+}
+
+func (c *fakeSSHClient) Disconnect(conn *ssh.SSHConnection) error {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *fakeSSHClient) TestAuthentication(ctx context.Context, info *ssh.ConnectionInfo) error {
+	return c.login(ctx, info)
+}
+
+type rotationFixture struct {
+	db      *sql.DB
+	devices *device.Manager
+	cipher  *security.EncryptionManager
+	client  *fakeSSHClient
+	manager *RotationManager
+}
+
+func newRotationFixture(t *testing.T) *rotationFixture {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.RunMigrations(db))
+
+	f := &rotationFixture{
+		db:      db,
+		devices: device.NewManager(db),
+		cipher:  security.NewEncryptionManager("rotation-test"),
+		client:  newFakeSSHClient(),
+	}
+	f.manager = NewRotationManager(db, f.devices, f.cipher, f.client, security.NewAuditLogger(db), "tester")
+	return f
+}
+
+// addDevice stores a device with the old password and simulates it
+func (f *rotationFixture) addDevice(t *testing.T, name, ip string, vendor device.Vendor, state *fakeDevice) string {
+	encrypted, err := f.cipher.Encrypt(oldPassword)
+	require.NoError(t, err)
+
+	dev := &device.Device{Name: name, IPAddress: ip, DeviceType: string(device.TypeRouter), Vendor: string(vendor),
+		Username: "svc-netops", PasswordEncrypted: encrypted, SSHPort: 22}
+	require.NoError(t, f.devices.AddDevice(dev))
+
+	state.password = oldPassword
+	f.client.devices[ip] = state
+	return dev.ID
+}
+
+// storedPassword returns the decrypted credential stored for a device
+func (f *rotationFixture) storedPassword(t *testing.T, id string) string {
+	dev, err := f.devices.GetDevice(id)
+	require.NoError(t, err)
+	password, err := f.cipher.Decrypt(dev.PasswordEncrypted)
+	require.NoError(t, err)
+	return password
+}
+
+func outcomes(report *Report) map[string]Outcome {
+	result := make(map[string]Outcome)
+	for _, entry := range report.Devices {
+		result[entry.DeviceName] = entry.Outcome
+	}
+	return result
+}
+
+func TestRotationManager_Start(t *testing.T) {
+	f := newRotationFixture(t)
+
+	cisco := f.addDevice(t, "cisco", "10.0.0.1", device.VendorCisco, &fakeDevice{})
+	juniper := f.addDevice(t, "juniper", "10.0.0.2", device.VendorJuniper, &fakeDevice{})
+	mikrotik := f.addDevice(t, "mikrotik", "10.0.0.3", device.VendorMikroTik, &fakeDevice{})
+	hp := f.addDevice(t, "hp", "10.0.0.4", device.VendorHP, &fakeDevice{})
+	offline := f.addDevice(t, "offline", "10.0.0.5", device.VendorCisco, &fakeDevice{unreachable: true})
+
+	report, err := f.manager.Start(context.Background(), Request{
+		DeviceIDs:   []string{cisco, juniper, mikrotik, hp, offline, cisco},
+		NewPassword: newPassword,
+		Concurrency: 2,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, StatusCompleted, report.Status)
+	assert.NotNil(t, report.FinishedAt)
+	assert.Equal(t, "tester", report.CreatedBy)
+	assert.Len(t, report.Devices, 5)
+	assert.Equal(t, map[string]Outcome{
+		"cisco":    OutcomeChanged,
+		"juniper":  OutcomeChanged,
+		"mikrotik": OutcomeChanged,
+		"hp":       OutcomeUnsupportedVendor,
+		"offline":  OutcomeUnreachable,
+	}, outcomes(report))
+	assert.Equal(t, 3, report.Summary[OutcomeChanged])
+
+	for _, id := range []string{cisco, juniper, mikrotik} {
+		assert.Equal(t, newPassword, f.storedPassword(t, id))
+	}
+	for _, id := range []string{hp, offline} {
+		assert.Equal(t, oldPassword, f.storedPassword(t, id))
+	}
+
+	// The new password is discarded once the run completes
+	var encrypted []byte
+	require.NoError(t, f.db.QueryRow("SELECT new_password_encrypted FROM credential_rotations WHERE id = ?",
+		report.RunID).Scan(&encrypted))
+	assert.Nil(t, encrypted)
+
+	// Every device outcome and the run itself are audited
+	entries, err := security.NewAuditLogger(f.db).GetAuditLog("", 0)
+	require.NoError(t, err)
+	var deviceEntries, runEntries int
+	for _, entry := range entries {
+		assert.Equal(t, security.ActionRotate, entry.ActionType)
+		assert.NotContains(t, entry.Details, newPassword)
+		switch entry.EntityType {
+		case security.EntityDevice:
+			deviceEntries++
+		case security.EntityCredentialRotation:
+			runEntries++
+		}
+	}
+	assert.Equal(t, 5, deviceEntries)
+	assert.Equal(t, 2, runEntries)
+}
+
+func TestRotationManager_VerifyFailureKeepsOldCredential(t *testing.T) {
+	f := newRotationFixture(t)
+
+	stubborn := f.addDevice(t, "stubborn", "10.0.1.1", device.VendorCisco, &fakeDevice{ignoreChange: true})
+
+	report, err := f.manager.Start(context.Background(), Request{
+		DeviceIDs:   []string{stubborn},
+		NewPassword: newPassword,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, report.Devices, 1)
+	assert.Equal(t, OutcomeVerifyFailed, report.Devices[0].Outcome)
+	assert.Equal(t, oldPassword, f.storedPassword(t, stubborn))
+	assert.Equal(t, oldPassword, f.client.devices["10.0.1.1"].password)
+}
+
+func TestRotationManager_Resume(t *testing.T) {
+	f := newRotationFixture(t)
+
+	first := f.addDevice(t, "a-first", "10.0.2.1", device.VendorCisco, &fakeDevice{})
+	second := f.addDevice(t, "b-second", "10.0.2.2", device.VendorCisco, &fakeDevice{})
+
+	// Interrupt the run right after the first device changed its password,
+	// before the change is verified and stored
+	ctx, cancel := context.WithCancel(context.Background())
+	f.client.onExecute = func(host string) { cancel() }
+
+	report, err := f.manager.Start(ctx, Request{
+		DeviceIDs:   []string{first, second},
+		NewPassword: newPassword,
+		Concurrency: 1,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, StatusInterrupted, report.Status)
+	assert.Nil(t, report.FinishedAt)
+	assert.Equal(t, 2, report.Summary[OutcomePending])
+	assert.Equal(t, newPassword, f.client.devices["10.0.2.1"].password)
+	assert.Equal(t, oldPassword, f.storedPassword(t, first))
+
+	f.client.onExecute = nil
+	resumed, err := f.manager.Resume(context.Background(), report.RunID, 1, 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, StatusCompleted, resumed.Status)
+	assert.Equal(t, map[string]Outcome{
+		"a-first":  OutcomeChanged,
+		"b-second": OutcomeChanged,
+	}, outcomes(resumed))
+	assert.Equal(t, newPassword, f.storedPassword(t, first))
+	assert.Equal(t, newPassword, f.storedPassword(t, second))
+
+	// Resuming a completed run returns its report unchanged
+	again, err := f.manager.Resume(context.Background(), report.RunID, 1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, resumed.Summary, again.Summary)
+
+	_, err = f.manager.Resume(context.Background(), "missing", 1, 0)
+	assert.Error(t, err)
+}
+
+func TestRotationManager_StartValidation(t *testing.T) {
+	f := newRotationFixture(t)
+	id := f.addDevice(t, "cisco", "10.0.3.1", device.VendorCisco, &fakeDevice{})
+
+	_, err := f.manager.Start(context.Background(), Request{DeviceIDs: []string{id}, NewPassword: "short"})
+	assert.Error(t, err)
+
+	_, err = f.manager.Start(context.Background(), Request{NewPassword: newPassword})
+	assert.Error(t, err)
+
+	_, err = f.manager.Start(context.Background(), Request{DeviceIDs: []string{"missing"}, NewPassword: newPassword})
+	assert.Error(t, err)
+}
+
+func TestRotationManager_HostKeyCallbacks(t *testing.T) {
+	f := newRotationFixture(t)
+	dev := &device.Device{IPAddress: "10.0.0.1", SSHPort: 22, Username: "admin"}
+	assert.Nil(t, f.manager.connectionInfo(dev, oldPassword).HostKeyCallback,
+		"without callbacks the client's verification applies")
+
+	var asked *device.Device
+	f.manager.SetHostKeyCallbacks(func(d *device.Device) ssh.HostKeyCallback {
+		asked = d
+		return ssh.InsecureHostKeyCallback()
+	})
+	info := f.manager.connectionInfo(dev, newPassword)
+	assert.NotNil(t, info.HostKeyCallback)
+	assert.Same(t, dev, asked)
+	assert.Equal(t, newPassword, info.Password)
+}
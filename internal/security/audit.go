@@ -0,0 +1,204 @@
+package security
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit action types
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionRotate = "rotate"
+	ActionExport = "export"
+)
+
+// Audit entity types
+const (
+	EntityDevice             = "device"
+	EntityRule               = "rule"
+	EntityCredentialRotation = "credential_rotation"
+	EntityDatabase           = "database"
+	EntityCheckRun           = "check_run"
+	EntitySeverityOverride   = "severity_override"
+	EntityCommandMacro       = "command_macro"
+	EntitySession            = "session"
+)
+
+// Default and maximum number of entries returned by one audit log query
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// AuditEntry records a change made through the application
+type AuditEntry struct {
+	ID         int64     `json:"id" db:"id"`
+	Timestamp  time.Time `json:"timestamp" db:"timestamp"`
+	UserID     string    `json:"userId" db:"user_id"`
+	Role       string    `json:"role" db:"role"`
+	ActionType string    `json:"actionType" db:"action_type"`
+	EntityType string    `json:"entityType" db:"entity_type"`
+	EntityID   string    `json:"entityId" db:"entity_id"`
+	Details    string    `json:"details" db:"details"`
+}
+
+// AuditLogger writes and reads the audit log
+type AuditLogger struct {
+	db         *sql.DB
+	roleSource func() string
+}
+
+// NewAuditLogger creates a new audit logger
+func NewAuditLogger(db *sql.DB) *AuditLogger {
+	return &AuditLogger{db: db}
+}
+
+// SetRoleSource makes entries logged without a role record the role fn
+// returns, so every change is attributed to the session role that made it
+func (al *AuditLogger) SetRoleSource(fn func() string) {
+	al.roleSource = fn
+}
+
+// Log appends an entry to the audit log
+func (al *AuditLogger) Log(entry AuditEntry) error {
+	if entry.UserID == "" || entry.ActionType == "" || entry.EntityType == "" {
+		return fmt.Errorf("audit entry requires a user, action and entity type")
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	if entry.Role == "" && al.roleSource != nil {
+		entry.Role = al.roleSource()
+	}
+
+	query := `
+		INSERT INTO audit_log (timestamp, user_id, role, action_type, entity_type, entity_id, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	if _, err := al.db.Exec(query, entry.Timestamp, entry.UserID, entry.Role, entry.ActionType,
+		entry.EntityType, entry.EntityID, entry.Details); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// AuditQuery filters the audit log. Empty strings and zero times match
+// every entry; Since is inclusive and Until exclusive.
+type AuditQuery struct {
+	EntityType string    `json:"entityType"`
+	EntityID   string    `json:"entityId"`
+	UserID     string    `json:"userId"`
+	Role       string    `json:"role"`
+	ActionType string    `json:"actionType"`
+	Since      time.Time `json:"since"`
+	Until      time.Time `json:"until"`
+	Limit      int       `json:"limit"`
+	Offset     int       `json:"offset"`
+}
+
+// AuditPage is one page of audit entries. Total counts every entry matching
+// the query, not just those on the page.
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+}
+
+// where builds the WHERE clause of the query. Only the fixed columns below
+// are filtered on, and every value is bound as a parameter.
+func (q AuditQuery) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	for _, filter := range []struct{ column, value string }{
+		{"entity_type", q.EntityType},
+		{"entity_id", q.EntityID},
+		{"user_id", q.UserID},
+		{"role", q.Role},
+		{"action_type", q.ActionType},
+	} {
+		if filter.value != "" {
+			conditions = append(conditions, filter.column+" = ?")
+			args = append(args, filter.value)
+		}
+	}
+	if !q.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, q.Since)
+	}
+	if !q.Until.IsZero() {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, q.Until)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// QueryAuditLog returns one page of the audit entries matching q, newest
+// first, together with the number of matching entries
+func (al *AuditLogger) QueryAuditLog(q AuditQuery) (*AuditPage, error) {
+	if !q.Since.IsZero() && !q.Until.IsZero() && q.Until.Before(q.Since) {
+		return nil, fmt.Errorf("audit query until %s is before since %s",
+			q.Until.Format(time.RFC3339), q.Since.Format(time.RFC3339))
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultAuditLimit
+	}
+	if q.Limit > MaxAuditLimit {
+		q.Limit = MaxAuditLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+
+	where, args := q.where()
+
+	page := &AuditPage{Entries: []AuditEntry{}}
+	if err := al.db.QueryRow(`SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+
+	query := `
+		SELECT id, timestamp, user_id, role, action_type, entity_type, entity_id, details
+		FROM audit_log` + where + `
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ? OFFSET ?
+	`
+	rows, err := al.db.Query(query, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		var entityID, details sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.UserID, &entry.Role, &entry.ActionType,
+			&entry.EntityType, &entityID, &details); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entry.EntityID = entityID.String
+		entry.Details = details.String
+		page.Entries = append(page.Entries, entry)
+	}
+
+	return page, rows.Err()
+}
+
+// GetAuditLog returns the most recent audit entries, newest first. An empty
+// entityType returns entries for every entity type.
+func (al *AuditLogger) GetAuditLog(entityType string, limit int) ([]AuditEntry, error) {
+	page, err := al.QueryAuditLog(AuditQuery{EntityType: entityType, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return page.Entries, nil
+}
@@ -0,0 +1,265 @@
+package security
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testAuditSchema mirrors the audit_log table created by the database migrations
+const testAuditSchema = `
+	CREATE TABLE audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL DEFAULT '',
+		action_type TEXT NOT NULL,
+		entity_type TEXT NOT NULL,
+		entity_id TEXT,
+		details TEXT
+	);
+`
+
+func setupAuditDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(testAuditSchema); err != nil {
+		t.Fatalf("Failed to create audit table: %v", err)
+	}
+
+	return db
+}
+
+func TestAuditLogger_LogAndGet(t *testing.T) {
+	db := setupAuditDB(t)
+	defer db.Close()
+
+	logger := NewAuditLogger(db)
+	base := time.Now().Add(-time.Hour)
+
+	entries := []AuditEntry{
+		{Timestamp: base, UserID: "local", ActionType: ActionCreate, EntityType: EntityDevice, EntityID: "d1", Details: "Added Router"},
+		{Timestamp: base.Add(time.Minute), UserID: "local", ActionType: ActionUpdate, EntityType: EntityRule, EntityID: "r1"},
+		{Timestamp: base.Add(2 * time.Minute), UserID: "local", ActionType: ActionDelete, EntityType: EntityDevice, EntityID: "d1"},
+	}
+	for _, entry := range entries {
+		if err := logger.Log(entry); err != nil {
+			t.Fatalf("Failed to log entry: %v", err)
+		}
+	}
+
+	all, err := logger.GetAuditLog("", 0)
+	if err != nil {
+		t.Fatalf("Failed to get audit log: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(all))
+	}
+	if all[0].ActionType != ActionDelete {
+		t.Errorf("Expected newest entry first, got %s", all[0].ActionType)
+	}
+	if all[2].Details != "Added Router" {
+		t.Errorf("Expected details to round-trip, got %q", all[2].Details)
+	}
+
+	devices, err := logger.GetAuditLog(EntityDevice, 0)
+	if err != nil {
+		t.Fatalf("Failed to get audit log: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Errorf("Expected 2 device entries, got %d", len(devices))
+	}
+
+	limited, err := logger.GetAuditLog("", 1)
+	if err != nil {
+		t.Fatalf("Failed to get audit log: %v", err)
+	}
+	if len(limited) != 1 {
+		t.Errorf("Expected limit to apply, got %d entries", len(limited))
+	}
+}
+
+func TestAuditLogger_LogValidation(t *testing.T) {
+	db := setupAuditDB(t)
+	defer db.Close()
+
+	logger := NewAuditLogger(db)
+
+	if err := logger.Log(AuditEntry{ActionType: ActionCreate, EntityType: EntityDevice}); err == nil {
+		t.Error("Expected error for entry without a user")
+	}
+
+	// Missing timestamps default to now
+	if err := logger.Log(AuditEntry{UserID: "local", ActionType: ActionCreate, EntityType: EntityDevice}); err != nil {
+		t.Fatalf("Failed to log entry: %v", err)
+	}
+	entries, err := logger.GetAuditLog("", 0)
+	if err != nil {
+		t.Fatalf("Failed to get audit log: %v", err)
+	}
+	if len(entries) != 1 || time.Since(entries[0].Timestamp) > time.Minute {
+		t.Errorf("Expected one entry stamped now, got %+v", entries)
+	}
+}
+
+func TestAuditLogger_Role(t *testing.T) {
+	db := setupAuditDB(t)
+	defer db.Close()
+
+	logger := NewAuditLogger(db)
+	if err := logger.Log(AuditEntry{UserID: "local", ActionType: ActionCreate, EntityType: EntityDevice}); err != nil {
+		t.Fatalf("Failed to log entry: %v", err)
+	}
+
+	// Entries without a role take the current one
+	role := "operator"
+	logger.SetRoleSource(func() string { return role })
+	if err := logger.Log(AuditEntry{UserID: "local", ActionType: ActionUpdate, EntityType: EntityDevice}); err != nil {
+		t.Fatalf("Failed to log entry: %v", err)
+	}
+	if err := logger.Log(AuditEntry{UserID: "local", Role: "admin", ActionType: ActionDelete, EntityType: EntityDevice}); err != nil {
+		t.Fatalf("Failed to log entry: %v", err)
+	}
+
+	page, err := logger.QueryAuditLog(AuditQuery{})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	roles := map[string]string{}
+	for _, entry := range page.Entries {
+		roles[entry.ActionType] = entry.Role
+	}
+	want := map[string]string{ActionCreate: "", ActionUpdate: "operator", ActionDelete: "admin"}
+	if fmt.Sprint(roles) != fmt.Sprint(want) {
+		t.Errorf("Expected roles %v, got %v", want, roles)
+	}
+
+	page, err = logger.QueryAuditLog(AuditQuery{Role: "operator"})
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	if page.Total != 1 || page.Entries[0].ActionType != ActionUpdate {
+		t.Errorf("Expected only the operator entry, got %+v", page.Entries)
+	}
+}
+
+func TestAuditLogger_QueryAuditLog(t *testing.T) {
+	db := setupAuditDB(t)
+	defer db.Close()
+
+	logger := NewAuditLogger(db)
+	users := []string{"alice", "bob", "carol"}
+	entities := []string{EntityDevice, EntityRule}
+	actions := []string{ActionCreate, ActionUpdate}
+	base := time.Now().Add(-50 * 24 * time.Hour)
+
+	// Entry i is i days after base, so the last 30 days hold entries 20-49
+	var all []AuditEntry
+	for i := 0; i < 50; i++ {
+		entry := AuditEntry{
+			Timestamp:  base.Add(time.Duration(i) * 24 * time.Hour),
+			UserID:     users[i%len(users)],
+			ActionType: actions[(i/2)%len(actions)],
+			EntityType: entities[i%len(entities)],
+			EntityID:   fmt.Sprintf("e%d", i%5),
+		}
+		if err := logger.Log(entry); err != nil {
+			t.Fatalf("Failed to log entry: %v", err)
+		}
+		all = append(all, entry)
+	}
+
+	since := time.Now().Add(-30 * 24 * time.Hour)
+	tests := []struct {
+		name  string
+		query AuditQuery
+	}{
+		{"everything", AuditQuery{}},
+		{"entity type", AuditQuery{EntityType: EntityDevice}},
+		{"entity id", AuditQuery{EntityID: "e3"}},
+		{"user", AuditQuery{UserID: "bob"}},
+		{"action", AuditQuery{ActionType: ActionUpdate}},
+		{"since", AuditQuery{Since: since}},
+		{"until", AuditQuery{Until: base.Add(10 * 24 * time.Hour)}},
+		{"date range", AuditQuery{Since: base.Add(5 * 24 * time.Hour), Until: base.Add(15 * 24 * time.Hour)}},
+		{"user and entity type", AuditQuery{UserID: "alice", EntityType: EntityRule}},
+		{"device changes by user in 30 days", AuditQuery{EntityType: EntityDevice, UserID: "carol", Since: since}},
+		{"all filters", AuditQuery{EntityType: EntityDevice, EntityID: "e0", UserID: "alice",
+			ActionType: ActionCreate, Since: base, Until: time.Now()}},
+		{"no match", AuditQuery{UserID: "mallory"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := 0
+			for _, entry := range all {
+				if auditEntryMatches(entry, tt.query) {
+					want++
+				}
+			}
+
+			page, err := logger.QueryAuditLog(tt.query)
+			if err != nil {
+				t.Fatalf("Failed to query audit log: %v", err)
+			}
+			if page.Total != want || len(page.Entries) != want {
+				t.Fatalf("Expected %d entries, got %d (total %d)", want, len(page.Entries), page.Total)
+			}
+			for i, entry := range page.Entries {
+				if !auditEntryMatches(entry, tt.query) {
+					t.Errorf("Entry %+v does not match the query", entry)
+				}
+				if i > 0 && entry.Timestamp.After(page.Entries[i-1].Timestamp) {
+					t.Errorf("Expected newest entries first")
+				}
+			}
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		query := AuditQuery{UserID: "alice", Limit: 5}
+		seen := make(map[int64]bool)
+		for offset := 0; offset < 20; offset += 5 {
+			query.Offset = offset
+			page, err := logger.QueryAuditLog(query)
+			if err != nil {
+				t.Fatalf("Failed to query audit log: %v", err)
+			}
+			if page.Total != 17 {
+				t.Errorf("Expected total 17, got %d", page.Total)
+			}
+			for _, entry := range page.Entries {
+				if seen[entry.ID] {
+					t.Errorf("Entry %d returned on two pages", entry.ID)
+				}
+				seen[entry.ID] = true
+			}
+		}
+		if len(seen) != 17 {
+			t.Errorf("Expected 17 entries across pages, got %d", len(seen))
+		}
+	})
+
+	t.Run("invalid range", func(t *testing.T) {
+		if _, err := logger.QueryAuditLog(AuditQuery{Since: time.Now(), Until: base}); err == nil {
+			t.Error("Expected error when until is before since")
+		}
+	})
+}
+
+// auditEntryMatches applies an audit query to an entry in memory
+func auditEntryMatches(entry AuditEntry, q AuditQuery) bool {
+	return (q.EntityType == "" || entry.EntityType == q.EntityType) &&
+		(q.EntityID == "" || entry.EntityID == q.EntityID) &&
+		(q.UserID == "" || entry.UserID == q.UserID) &&
+		(q.ActionType == "" || entry.ActionType == q.ActionType) &&
+		(q.Since.IsZero() || !entry.Timestamp.Before(q.Since)) &&
+		(q.Until.IsZero() || entry.Timestamp.Before(q.Until))
+}
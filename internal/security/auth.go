@@ -1,20 +1,58 @@
 package security
 
 import (
+	"crypto/rand"
 	"crypto/subtle"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
+
+	"golang.org/x/crypto/argon2"
 )
 
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrSessionExpired     = errors.New("session expired")
+	ErrInvalidRole        = errors.New("invalid role")
+)
+
+// Role decides what a session may do
+type Role string
+
+// Session roles. Viewers only read, operators also run checks, and admins
+// may change anything.
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
 )
 
+// roleRanks orders the roles from least to most privileged
+var roleRanks = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// ParseRole returns the role named by s, ignoring case and surrounding space
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("%w %q", ErrInvalidRole, s)
+	}
+	return role, nil
+}
+
+// Allows reports whether the role may do what required may. Unknown roles
+// are allowed nothing.
+func (r Role) Allows(required Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[required]
+}
+
 // Session represents an application session
 type Session struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"userId"`
+	Role      Role      `json:"role"`
 	CreatedAt time.Time `json:"createdAt"`
 	ExpiresAt time.Time `json:"expiresAt"`
 }
@@ -33,8 +71,18 @@ func NewSessionManager(timeout time.Duration) *SessionManager {
 	}
 }
 
-// CreateSession creates a new session for a user
+// CreateSession creates a new admin session for a user
 func (sm *SessionManager) CreateSession(userID string) (*Session, error) {
+	return sm.CreateSessionWithRole(userID, RoleAdmin)
+}
+
+// CreateSessionWithRole creates a new session for a user with a role. The
+// role is fixed for the life of the session.
+func (sm *SessionManager) CreateSessionWithRole(userID string, role Role) (*Session, error) {
+	if _, ok := roleRanks[role]; !ok {
+		return nil, fmt.Errorf("%w %q", ErrInvalidRole, role)
+	}
+
 	sessionID, err := generateSessionID()
 	if err != nil {
 		return nil, err
@@ -43,6 +91,7 @@ func (sm *SessionManager) CreateSession(userID string) (*Session, error) {
 	session := &Session{
 		ID:        sessionID,
 		UserID:    userID,
+		Role:      role,
 		CreatedAt: time.Now(),
 		ExpiresAt: time.Now().Add(sm.sessionTimeout),
 	}
@@ -112,3 +161,44 @@ func generateSessionID() (string, error) {
 func SecureCompare(a, b string) bool {
 	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
 }
+
+// Argon2id parameters of HashPassphrase
+const (
+	passphraseHashPrefix = "argon2id"
+	passphraseSaltSize   = 16
+	passphraseTime       = 2
+	passphraseMemory     = 19 * 1024
+	passphraseThreads    = 1
+	passphraseKeySize    = 32
+)
+
+// HashPassphrase returns a salted Argon2id hash of passphrase to store in
+// its place, as "argon2id$<salt>$<hash>" with both parts in base64
+func HashPassphrase(passphrase string) (string, error) {
+	salt := make([]byte, passphraseSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	hash := argon2.IDKey([]byte(passphrase), salt, passphraseTime, passphraseMemory, passphraseThreads, passphraseKeySize)
+	return strings.Join([]string{passphraseHashPrefix,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)}, "$"), nil
+}
+
+// VerifyPassphrase reports whether passphrase matches a hash returned by
+// HashPassphrase, comparing in constant time. Malformed hashes match nothing.
+func VerifyPassphrase(encoded, passphrase string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 3 || parts[0] != passphraseHashPrefix {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil || len(salt) == 0 {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(want) != passphraseKeySize {
+		return false
+	}
+	hash := argon2.IDKey([]byte(passphrase), salt, passphraseTime, passphraseMemory, passphraseThreads, passphraseKeySize)
+	return subtle.ConstantTimeCompare(hash, want) == 1
+}
@@ -1,6 +1,7 @@
 package security
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -318,3 +319,93 @@ func BenchmarkValidateSession(b *testing.B) {
 		}
 	}
 }
+
+func TestParseRole(t *testing.T) {
+	for input, want := range map[string]Role{"viewer": RoleViewer, " Operator ": RoleOperator, "ADMIN": RoleAdmin} {
+		role, err := ParseRole(input)
+		if err != nil || role != want {
+			t.Errorf("ParseRole(%q) = %q, %v; expected %q", input, role, err, want)
+		}
+	}
+
+	for _, input := range []string{"", "root", "viewers"} {
+		if _, err := ParseRole(input); !errors.Is(err, ErrInvalidRole) {
+			t.Errorf("ParseRole(%q): expected ErrInvalidRole, got %v", input, err)
+		}
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		want     bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleOperator, false},
+		{RoleViewer, RoleAdmin, false},
+		{RoleOperator, RoleViewer, true},
+		{RoleOperator, RoleOperator, true},
+		{RoleOperator, RoleAdmin, false},
+		{RoleAdmin, RoleAdmin, true},
+		{Role(""), RoleViewer, false},
+		{Role("root"), RoleViewer, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.want {
+			t.Errorf("%q.Allows(%q) = %v, expected %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestCreateSessionWithRole(t *testing.T) {
+	sm := NewSessionManager(30 * time.Minute)
+
+	session, err := sm.CreateSessionWithRole("noc", RoleViewer)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	validated, err := sm.ValidateSession(session.ID)
+	if err != nil {
+		t.Fatalf("Failed to validate session: %v", err)
+	}
+	if validated.Role != RoleViewer {
+		t.Errorf("Expected role %s, got %s", RoleViewer, validated.Role)
+	}
+
+	if session, err := sm.CreateSession("local"); err != nil || session.Role != RoleAdmin {
+		t.Errorf("Expected CreateSession to create an admin session, got %+v, %v", session, err)
+	}
+
+	if _, err := sm.CreateSessionWithRole("noc", Role("root")); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("Expected ErrInvalidRole, got %v", err)
+	}
+}
+
+func TestHashPassphrase(t *testing.T) {
+	hash, err := HashPassphrase("admin passphrase")
+	if err != nil {
+		t.Fatalf("Failed to hash passphrase: %v", err)
+	}
+	if !VerifyPassphrase(hash, "admin passphrase") {
+		t.Error("Expected the passphrase to match its hash")
+	}
+	if VerifyPassphrase(hash, "other passphrase") {
+		t.Error("Expected another passphrase not to match")
+	}
+
+	again, err := HashPassphrase("admin passphrase")
+	if err != nil {
+		t.Fatalf("Failed to hash passphrase: %v", err)
+	}
+	if again == hash {
+		t.Error("Expected each hash to have its own salt")
+	}
+
+	for _, malformed := range []string{"", "admin passphrase", "bcrypt$c2FsdA$aGFzaA", "argon2id$$"} {
+		if VerifyPassphrase(malformed, "admin passphrase") {
+			t.Errorf("Expected malformed hash %q to match nothing", malformed)
+		}
+	}
+}
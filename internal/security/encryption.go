@@ -5,6 +5,7 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"io"
@@ -23,13 +24,24 @@ type EncryptionManager struct {
 
 // NewEncryptionManager creates a new encryption manager with a derived key
 func NewEncryptionManager(passphrase string) *EncryptionManager {
-	// Derive a 32-byte key from the passphrase using SHA-256
-	hash := sha256.Sum256([]byte(passphrase))
 	return &EncryptionManager{
-		key: hash[:],
+		key: DeriveKey(passphrase),
 	}
 }
 
+// DeriveKey derives the 32-byte AES key used for a passphrase
+func DeriveKey(passphrase string) []byte {
+	// Derive a 32-byte key from the passphrase using SHA-256
+	hash := sha256.Sum256([]byte(passphrase))
+	return hash[:]
+}
+
+// MatchesPassphrase reports whether the key was derived from passphrase,
+// comparing in constant time
+func (em *EncryptionManager) MatchesPassphrase(passphrase string) bool {
+	return subtle.ConstantTimeCompare(DeriveKey(passphrase), em.key) == 1
+}
+
 // NewEncryptionManagerWithKey creates a new encryption manager with a provided key
 func NewEncryptionManagerWithKey(key []byte) (*EncryptionManager, error) {
 	if len(key) != 32 {
@@ -104,6 +116,28 @@ func (em *EncryptionManager) Decrypt(ciphertext []byte) (string, error) {
 	return string(plaintext), nil
 }
 
+// Reencrypt returns ciphertext that old encrypted, encrypted with em
+// instead. Ciphertext em already decrypts is returned as is with changed
+// false, so re-encrypting the same data twice is harmless.
+func (em *EncryptionManager) Reencrypt(ciphertext []byte, old *EncryptionManager) (updated []byte, changed bool, err error) {
+	if len(ciphertext) == 0 {
+		return ciphertext, false, nil
+	}
+	if _, err := em.Decrypt(ciphertext); err == nil {
+		return ciphertext, false, nil
+	}
+
+	plaintext, err := old.Decrypt(ciphertext)
+	if err != nil {
+		return ciphertext, false, err
+	}
+	updated, err = em.Encrypt(plaintext)
+	if err != nil {
+		return ciphertext, false, err
+	}
+	return updated, true, nil
+}
+
 // GenerateKey generates a new 32-byte encryption key
 func GenerateKey() ([]byte, error) {
 	key := make([]byte, 32)
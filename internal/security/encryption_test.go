@@ -42,6 +42,48 @@ func TestNewEncryptionManagerWithKey(t *testing.T) {
 	}
 }
 
+func TestMatchesPassphrase(t *testing.T) {
+	em := NewEncryptionManager("correct horse")
+
+	if !em.MatchesPassphrase("correct horse") {
+		t.Error("Expected the passphrase the key was derived from to match")
+	}
+	for _, passphrase := range []string{"", "correct horse ", "Correct horse"} {
+		if em.MatchesPassphrase(passphrase) {
+			t.Errorf("Expected passphrase %q not to match", passphrase)
+		}
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	old := NewEncryptionManager("old passphrase")
+	em := NewEncryptionManager("new passphrase")
+
+	ciphertext, err := old.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	updated, changed, err := em.Reencrypt(ciphertext, old)
+	if err != nil || !changed {
+		t.Fatalf("Expected the ciphertext to be re-encrypted, got changed %v, %v", changed, err)
+	}
+	if plaintext, err := em.Decrypt(updated); err != nil || plaintext != "secret" {
+		t.Errorf("Expected the new key to decrypt the secret, got %q, %v", plaintext, err)
+	}
+
+	// Data the new key already decrypts is kept as it is
+	again, changed, err := em.Reencrypt(updated, old)
+	if err != nil || changed || string(again) != string(updated) {
+		t.Errorf("Expected re-encrypting twice to change nothing, got changed %v, %v", changed, err)
+	}
+
+	other := NewEncryptionManager("other passphrase")
+	foreign, _ := other.Encrypt("secret")
+	if _, changed, err := em.Reencrypt(foreign, old); err == nil || changed {
+		t.Errorf("Expected ciphertext of neither key to fail, got changed %v, %v", changed, err)
+	}
+}
+
 func TestEncryptDecrypt(t *testing.T) {
 	em := NewEncryptionManager("test-passphrase")
 
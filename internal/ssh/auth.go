@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// authChain returns the authentication methods connInfo tries, in order:
+// AuthMethods when set, otherwise AuthMethod alone. Repeated methods are
+// dropped.
+func (connInfo *ConnectionInfo) authChain() []AuthMethod {
+	if len(connInfo.AuthMethods) == 0 {
+		return []AuthMethod{connInfo.AuthMethod}
+	}
+
+	var chain []AuthMethod
+	for _, method := range connInfo.AuthMethods {
+		if !containsAuthMethod(chain, method) {
+			chain = append(chain, method)
+		}
+	}
+	return chain
+}
+
+// authLabel names a chain of authentication methods, such as
+// "password,keyboard-interactive"
+func authLabel(methods []AuthMethod) string {
+	names := make([]string, len(methods))
+	for i, method := range methods {
+		names[i] = method.String()
+	}
+	return strings.Join(names, ",")
+}
+
+func containsAuthMethod(methods []AuthMethod, method AuthMethod) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// authTracker notes the methods of a chain the client got to try during a
+// handshake, in order. x/crypto stops at the first method the server
+// accepts, so after a successful handshake the last one tried succeeded.
+type authTracker struct {
+	tried []AuthMethod
+}
+
+func (t *authTracker) try(method AuthMethod) {
+	if !containsAuthMethod(t.tried, method) {
+		t.tried = append(t.tried, method)
+	}
+}
+
+// succeeded returns the name of the method that logged in, or an empty
+// string when the server asked for none
+func (t *authTracker) succeeded() string {
+	if len(t.tried) == 0 {
+		return ""
+	}
+	return t.tried[len(t.tried)-1].String()
+}
+
+// untried returns the methods of chain the handshake never got to
+func (t *authTracker) untried(chain []AuthMethod) []AuthMethod {
+	var rest []AuthMethod
+	for _, method := range chain {
+		if !containsAuthMethod(t.tried, method) {
+			rest = append(rest, method)
+		}
+	}
+	return rest
+}
+
+// authMethods builds the x/crypto methods trying chain in order within one
+// handshake, noting each attempt in tracker. Password logins fall back to
+// keyboard-interactive when responses or an answer function are set and the
+// chain does not try it already.
+func authMethods(connInfo *ConnectionInfo, chain []AuthMethod, challenge ssh.KeyboardInteractiveChallenge,
+	tracker *authTracker) ([]ssh.AuthMethod, error) {
+	keyboard := ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		tracker.try(AuthKeyboard)
+		return challenge(user, instruction, questions, echos)
+	})
+
+	var auths []ssh.AuthMethod
+	for _, method := range chain {
+		switch method {
+		case AuthPassword:
+			auths = append(auths, ssh.PasswordCallback(func() (string, error) {
+				tracker.try(AuthPassword)
+				return connInfo.Password, nil
+			}))
+			fallback := len(connInfo.KeyboardResponses) > 0 || connInfo.InteractiveAnswerFn != nil
+			if fallback && !containsAuthMethod(chain, AuthKeyboard) {
+				auths = append(auths, keyboard)
+			}
+		case AuthPublicKey:
+			signer, err := ssh.ParsePrivateKey(connInfo.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse private key: %w", err)
+			}
+			auths = append(auths, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				tracker.try(AuthPublicKey)
+				return []ssh.Signer{signer}, nil
+			}))
+		case AuthKeyboard:
+			auths = append(auths, keyboard)
+		}
+	}
+	return auths, nil
+}
@@ -0,0 +1,153 @@
+package ssh
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"invictux-demo/internal/ssh/sshtest"
+)
+
+// newAuthChainServer accepts testuser by password and kbd-user only over
+// keyboard-interactive, answering "Password:" with testpass
+func newAuthChainServer(t *testing.T) *sshtest.Server {
+	t.Helper()
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	server.SetKeyboardInteractive("", []string{"Password: "}, []string{"testpass"})
+	return server
+}
+
+func newAuthChainClient(t *testing.T) *SSHClient {
+	t.Helper()
+	config := DefaultClientConfig()
+	config.MaxRetries = 0
+	client := NewSSHClientWithHostKeyCheck(config, CreateInsecureHostKeyCallbackForTesting())
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestSSHClient_AuthMethodChain(t *testing.T) {
+	server := newAuthChainServer(t)
+	chain := []AuthMethod{AuthPassword, AuthKeyboard}
+
+	tests := []struct {
+		name     string
+		username string
+		want     string
+	}{
+		{"password device", "testuser", "password"},
+		{"keyboard-interactive device", "kbd-user", "keyboard-interactive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newAuthChainClient(t)
+			conn, err := client.Connect(context.Background(), &ConnectionInfo{
+				Host:        server.GetAddress(),
+				Port:        server.GetPort(),
+				Username:    tt.username,
+				Password:    "testpass",
+				AuthMethods: chain,
+			})
+			if err != nil {
+				t.Fatalf("Expected the chain to log in, got error: %v", err)
+			}
+			defer client.Disconnect(conn)
+
+			if conn.AuthMethod() != tt.want {
+				t.Errorf("Expected %s to be reported, got %q", tt.want, conn.AuthMethod())
+			}
+			timings := client.RecentConnectionTimings(server.GetAddress(), server.GetPort())
+			if len(timings) != 1 || timings[0].AuthMethod != tt.want {
+				t.Errorf("Expected the attempt to record %s, got %+v", tt.want, timings)
+			}
+		})
+	}
+
+	t.Run("password only", func(t *testing.T) {
+		client := newAuthChainClient(t)
+		_, err := client.Connect(context.Background(), &ConnectionInfo{
+			Host:       server.GetAddress(),
+			Port:       server.GetPort(),
+			Username:   "kbd-user",
+			Password:   "testpass",
+			AuthMethod: AuthPassword,
+		})
+		if kind := ErrorKindOf(err); kind != ErrorKindAuth {
+			t.Errorf("Expected error kind %q without the chain, got %q (%v)", ErrorKindAuth, kind, err)
+		}
+	})
+}
+
+func TestSSHClient_AuthMethodChainAfterDisconnect(t *testing.T) {
+	server := newAuthChainServer(t)
+	// The server hangs up on the first rejected method, so the rest of the
+	// chain needs a new connection
+	server.SetMaxAuthTries(1)
+
+	client := newAuthChainClient(t)
+	method, err := client.ProbeAuthentication(context.Background(), &ConnectionInfo{
+		Host:        server.GetAddress(),
+		Port:        server.GetPort(),
+		Username:    "kbd-user",
+		Password:    "testpass",
+		AuthMethods: []AuthMethod{AuthPassword, AuthKeyboard},
+	})
+	if err != nil {
+		t.Fatalf("Expected keyboard-interactive on a new connection, got error: %v", err)
+	}
+	if method != "keyboard-interactive" {
+		t.Errorf("Expected keyboard-interactive to be reported, got %q", method)
+	}
+	if answers := server.KeyboardAnswers(); strings.Join(answers, "|") != "testpass" {
+		t.Errorf("Expected one keyboard-interactive login, got answers %q", answers)
+	}
+
+	// Nothing is left to try once every method was rejected
+	_, err = client.ProbeAuthentication(context.Background(), &ConnectionInfo{
+		Host:        server.GetAddress(),
+		Port:        server.GetPort(),
+		Username:    "kbd-user",
+		Password:    "wrongpass",
+		AuthMethods: []AuthMethod{AuthPassword, AuthKeyboard},
+	})
+	if err == nil {
+		t.Error("Expected the login to fail when every method is rejected")
+	}
+}
+
+func TestConnectionInfo_AuthChain(t *testing.T) {
+	single := &ConnectionInfo{AuthMethod: AuthKeyboard}
+	if got := authLabel(single.authChain()); got != "keyboard-interactive" {
+		t.Errorf("Expected AuthMethod alone, got %q", got)
+	}
+
+	chain := &ConnectionInfo{AuthMethod: AuthKeyboard, AuthMethods: []AuthMethod{AuthPublicKey, AuthPassword, AuthPublicKey}}
+	if got := authLabel(chain.authChain()); got != "publickey,password" {
+		t.Errorf("Expected AuthMethods in order without repeats, got %q", got)
+	}
+
+	base := ConnectionInfo{Host: "10.0.0.1", Port: 22, Username: "admin", Password: "secret"}
+	withChain := base
+	withChain.AuthMethods = []AuthMethod{AuthPassword, AuthKeyboard}
+	if poolKey(&base) == poolKey(&withChain) {
+		t.Error("Expected a chain to get a pool of its own")
+	}
+
+	client := NewSSHClient(nil)
+	defer client.Close()
+	missingKey := base
+	missingKey.AuthMethods = []AuthMethod{AuthPassword, AuthPublicKey}
+	if err := client.validateConnectionInfo(&missingKey); err == nil {
+		t.Error("Expected every method of the chain to be validated")
+	}
+	unknown := base
+	unknown.AuthMethods = []AuthMethod{AuthPassword, AuthMethod(9)}
+	if err := client.validateConnectionInfo(&unknown); err == nil {
+		t.Error("Expected an unsupported method in the chain to be rejected")
+	}
+}
@@ -0,0 +1,180 @@
+package ssh
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Bounds of the adaptive retry delay when ClientConfig leaves them unset
+const (
+	DefaultMinRetryDelay = 500 * time.Millisecond
+	DefaultMaxRetryDelay = 30 * time.Second
+)
+
+// retryJitter is the fraction a retry delay is randomly moved by either way
+const retryJitter = 0.2
+
+// healthSmoothing is the weight of the newest attempt in a host's averages
+const healthSmoothing = 0.3
+
+// referenceLatency is the connection time at which the adaptive backoff
+// base equals ClientConfig.RetryDelay; slower hosts wait longer and faster
+// ones less
+const referenceLatency = 500 * time.Millisecond
+
+// failureWeight is how many RetryDelays a host whose every recent attempt
+// failed has added to its backoff base
+const failureWeight = 1.0
+
+// hostHealth holds exponentially weighted averages of a host's recent
+// connection attempts
+type hostHealth struct {
+	latency     time.Duration
+	failureRate float64
+	samples     int
+}
+
+// observe folds an attempt into the averages
+func (h *hostHealth) observe(attempt ConnectionAttempt) {
+	latency := attemptDuration(attempt)
+	failed := 0.0
+	if attempt.FailedPhase != "" {
+		failed = 1
+	}
+
+	if h.samples == 0 {
+		h.latency, h.failureRate = latency, failed
+	} else {
+		h.latency = time.Duration(healthSmoothing*float64(latency) + (1-healthSmoothing)*float64(h.latency))
+		h.failureRate = healthSmoothing*failed + (1-healthSmoothing)*h.failureRate
+	}
+	h.samples++
+}
+
+// attemptDuration is the time an attempt took across its phases
+func attemptDuration(attempt ConnectionAttempt) time.Duration {
+	var total time.Duration
+	for _, phase := range Phases {
+		total += attempt.Phases.Get(phase)
+	}
+	return total
+}
+
+// HostBackoff describes how the client waits between connection attempts
+// to a host. BaseDelayMs is the wait before the first retry, later retries
+// waiting a multiple of it; with adaptive retry it is scaled by the host's
+// average connection latency and failure rate and moved by jitter.
+type HostBackoff struct {
+	Host        string  `json:"host"`
+	Adaptive    bool    `json:"adaptive"`
+	BaseDelayMs int64   `json:"baseDelayMs"`
+	MinDelayMs  int64   `json:"minDelayMs"`
+	MaxDelayMs  int64   `json:"maxDelayMs"`
+	LatencyMs   int64   `json:"latencyMs"`
+	FailureRate float64 `json:"failureRate"`
+	Samples     int     `json:"samples"`
+
+	// MaxRetryTimeMs caps the time spent retrying a connection; zero means
+	// retries are only limited by ClientConfig.MaxRetries
+	MaxRetryTimeMs int64 `json:"maxRetryTimeMs"`
+}
+
+// SetAdaptiveRetry switches between retry delays adapted to each host and
+// the fixed ClientConfig.RetryDelay
+func (c *SSHClient) SetAdaptiveRetry(enabled bool) {
+	c.timingMutex.Lock()
+	defer c.timingMutex.Unlock()
+	c.adaptiveRetry = enabled
+}
+
+// AdaptiveRetry reports whether retry delays adapt to each host
+func (c *SSHClient) AdaptiveRetry() bool {
+	c.timingMutex.Lock()
+	defer c.timingMutex.Unlock()
+	return c.adaptiveRetry
+}
+
+// RetryBackoff returns the backoff the client currently uses for a host
+func (c *SSHClient) RetryBackoff(host string, port int) HostBackoff {
+	address := hostAddress(host, port)
+	minDelay, maxDelay := c.retryDelayBounds()
+
+	c.timingMutex.Lock()
+	defer c.timingMutex.Unlock()
+	health := c.health[address]
+	backoff := HostBackoff{
+		Host:           address,
+		Adaptive:       c.adaptiveRetry,
+		BaseDelayMs:    c.retryBase(address).Milliseconds(),
+		MinDelayMs:     minDelay.Milliseconds(),
+		MaxDelayMs:     maxDelay.Milliseconds(),
+		MaxRetryTimeMs: c.config.MaxRetryTime.Milliseconds(),
+	}
+	if health != nil {
+		backoff.LatencyMs = health.latency.Milliseconds()
+		backoff.FailureRate = health.failureRate
+		backoff.Samples = health.samples
+	}
+	return backoff
+}
+
+// retryDelay returns how long to wait before retry number attempt of a host
+func (c *SSHClient) retryDelay(host string, attempt int) time.Duration {
+	c.timingMutex.Lock()
+	adaptive := c.adaptiveRetry
+	base := c.retryBase(host)
+	c.timingMutex.Unlock()
+
+	delay := time.Duration(attempt) * base
+	if !adaptive {
+		return delay
+	}
+	if _, maxDelay := c.retryDelayBounds(); delay > maxDelay {
+		delay = maxDelay
+	}
+	return jitterDelay(delay, rand.Float64())
+}
+
+// retryBase returns the delay before a host's first retry. A host without
+// recorded attempts uses RetryDelay. timingMutex must be held.
+func (c *SSHClient) retryBase(host string) time.Duration {
+	health := c.health[host]
+	if !c.adaptiveRetry || health == nil {
+		return c.config.RetryDelay
+	}
+
+	// Latency scales the delay linearly; failures add up to RetryDelay, so
+	// a host refusing connections quickly still waits as long as before
+	scale := float64(health.latency)/float64(referenceLatency) + failureWeight*health.failureRate
+	base := time.Duration(float64(c.config.RetryDelay) * scale)
+
+	minDelay, maxDelay := c.retryDelayBounds()
+	if base < minDelay {
+		return minDelay
+	}
+	if base > maxDelay {
+		return maxDelay
+	}
+	return base
+}
+
+// retryDelayBounds returns the configured bounds of adaptive retry delays
+func (c *SSHClient) retryDelayBounds() (time.Duration, time.Duration) {
+	minDelay, maxDelay := c.config.MinRetryDelay, c.config.MaxRetryDelay
+	if minDelay <= 0 {
+		minDelay = DefaultMinRetryDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxRetryDelay
+	}
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	return minDelay, maxDelay
+}
+
+// jitterDelay moves d by up to retryJitter either way; r in [0, 1) picks
+// where, 0.5 leaving d as it is
+func jitterDelay(d time.Duration, r float64) time.Duration {
+	return time.Duration(float64(d) * (1 - retryJitter + 2*retryJitter*r))
+}
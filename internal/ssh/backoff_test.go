@@ -0,0 +1,171 @@
+package ssh
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/ssh/sshtest"
+)
+
+// adaptiveConfig returns a client config with adaptive retry and short delays
+func adaptiveConfig() *ClientConfig {
+	return &ClientConfig{
+		ConnectTimeout: 5 * time.Second,
+		CommandTimeout: 5 * time.Second,
+		MaxRetries:     2,
+		RetryDelay:     100 * time.Millisecond,
+		MaxConnections: 5,
+		ConnectionTTL:  5 * time.Minute,
+		AdaptiveRetry:  true,
+		MinRetryDelay:  10 * time.Millisecond,
+		MaxRetryDelay:  5 * time.Second,
+	}
+}
+
+// mockConnectionInfo returns the test account of a mock server
+func mockConnectionInfo(server *sshtest.Server) *ConnectionInfo {
+	return &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	}
+}
+
+func TestSSHClient_RetryBackoff_SlowAndFastHosts(t *testing.T) {
+	slow, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer slow.Close()
+	slow.SetHandshakeDelays(300*time.Millisecond, 0, 300*time.Millisecond)
+
+	fast, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer fast.Close()
+
+	client := NewSSHClient(adaptiveConfig())
+	defer client.Close()
+
+	for _, server := range []*sshtest.Server{slow, fast} {
+		conn, err := client.Connect(context.Background(), mockConnectionInfo(server))
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		client.Disconnect(conn)
+	}
+
+	slowBackoff := client.RetryBackoff(slow.GetAddress(), slow.GetPort())
+	fastBackoff := client.RetryBackoff(fast.GetAddress(), fast.GetPort())
+	if !slowBackoff.Adaptive || slowBackoff.Samples != 1 || fastBackoff.Samples != 1 {
+		t.Fatalf("Expected one adaptive sample per host, got %+v and %+v", slowBackoff, fastBackoff)
+	}
+	if slowBackoff.LatencyMs < 600 {
+		t.Errorf("Expected the slow host's latency to include its handshake delays, got %dms", slowBackoff.LatencyMs)
+	}
+	// 600ms of latency against the 500ms reference scales the 100ms delay
+	if slowBackoff.BaseDelayMs < 120 {
+		t.Errorf("Expected the slow host's backoff to grow past RetryDelay, got %dms", slowBackoff.BaseDelayMs)
+	}
+	if fastBackoff.BaseDelayMs >= slowBackoff.BaseDelayMs/5 {
+		t.Errorf("Expected the fast host's backoff to be far shorter, got %dms against %dms",
+			fastBackoff.BaseDelayMs, slowBackoff.BaseDelayMs)
+	}
+	if fastBackoff.BaseDelayMs < fastBackoff.MinDelayMs {
+		t.Errorf("Expected the backoff to stay above the minimum, got %+v", fastBackoff)
+	}
+
+	client.SetAdaptiveRetry(false)
+	fixed := client.RetryBackoff(slow.GetAddress(), slow.GetPort())
+	if fixed.Adaptive || fixed.BaseDelayMs != 100 {
+		t.Errorf("Expected the fixed RetryDelay without adaptive retry, got %+v", fixed)
+	}
+}
+
+func TestSSHClient_RetryBackoff_Bounds(t *testing.T) {
+	config := adaptiveConfig()
+	config.MaxRetryDelay = 250 * time.Millisecond
+	client := NewSSHClient(config)
+
+	client.recordAttempt(ConnectionAttempt{Host: "10.0.0.1:22", Phases: PhaseTimings{Dial: 20 * time.Second}, FailedPhase: PhaseDial})
+	if backoff := client.RetryBackoff("10.0.0.1", 22); backoff.BaseDelayMs != 250 {
+		t.Errorf("Expected the backoff to be capped at the maximum, got %+v", backoff)
+	}
+	for attempt := 1; attempt <= 4; attempt++ {
+		if delay := client.retryDelay("10.0.0.1:22", attempt); delay > 300*time.Millisecond {
+			t.Errorf("Expected retry %d to wait at most the maximum plus jitter, got %v", attempt, delay)
+		}
+	}
+
+	client.recordAttempt(ConnectionAttempt{Host: "10.0.0.2:22", Phases: PhaseTimings{Dial: time.Millisecond}})
+	if backoff := client.RetryBackoff("10.0.0.2", 22); backoff.BaseDelayMs != 10 {
+		t.Errorf("Expected the backoff to be raised to the minimum, got %+v", backoff)
+	}
+
+	// Quick refusals still back off for the failures
+	for i := 0; i < 5; i++ {
+		client.recordAttempt(ConnectionAttempt{Host: "10.0.0.3:22", Phases: PhaseTimings{Dial: time.Millisecond}, FailedPhase: PhaseDial})
+	}
+	if backoff := client.RetryBackoff("10.0.0.3", 22); backoff.BaseDelayMs < 100 || backoff.FailureRate != 1 {
+		t.Errorf("Expected a failing host to wait at least RetryDelay, got %+v", backoff)
+	}
+}
+
+func TestJitterDelay(t *testing.T) {
+	delay := time.Second
+	if got := jitterDelay(delay, 0); got != 800*time.Millisecond {
+		t.Errorf("Expected the lowest jitter to take 20%% off, got %v", got)
+	}
+	if got := jitterDelay(delay, 0.5); got != delay {
+		t.Errorf("Expected the middle jitter to keep the delay, got %v", got)
+	}
+	if got := jitterDelay(delay, 0.999999); got > 1200*time.Millisecond || got < 1199*time.Millisecond {
+		t.Errorf("Expected the highest jitter to add just under 20%%, got %v", got)
+	}
+
+	client := NewSSHClient(adaptiveConfig())
+	for i := 0; i < 200; i++ {
+		got := client.retryDelay("10.0.0.9:22", 1)
+		if got < 80*time.Millisecond || got > 120*time.Millisecond {
+			t.Fatalf("Expected a jittered delay within 20%% of 100ms, got %v", got)
+		}
+	}
+}
+
+func TestSSHClient_Connect_MaxRetryTime(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	server.SetShouldFail(true)
+
+	config := adaptiveConfig()
+	config.MaxRetries = 10
+	config.MinRetryDelay = 100 * time.Millisecond
+	config.MaxRetryTime = 500 * time.Millisecond
+	client := NewSSHClient(config)
+	defer client.Close()
+
+	for run := 0; run < 2; run++ {
+		started := time.Now()
+		_, err := client.Connect(context.Background(), mockConnectionInfo(server))
+		elapsed := time.Since(started)
+		if err == nil || !strings.Contains(err.Error(), "retry time limit of 500ms reached") {
+			t.Fatalf("Expected the retry time limit to stop the retries, got %v", err)
+		}
+		if elapsed > config.MaxRetryTime+200*time.Millisecond {
+			t.Errorf("Expected the retries to stop within the limit, took %v", elapsed)
+		}
+	}
+
+	attempts := client.RecentConnectionTimings(server.GetAddress(), server.GetPort())
+	if len(attempts) >= 2*(config.MaxRetries+1) {
+		t.Errorf("Expected the limit to cut the retries short, got %d attempts", len(attempts))
+	}
+}
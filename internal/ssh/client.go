@@ -1,11 +1,15 @@
 package ssh
 
 import (
+	"bytes"
 	"context"
-	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
+	"log"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -13,10 +17,24 @@ import (
 
 // SSHClient represents an SSH client with connection pooling and security features
 type SSHClient struct {
-	config       *ClientConfig
+	config *ClientConfig
+
+	// connections holds a pool per account and device, keyed by poolKey
 	connections  map[string]*ConnectionPool
 	mutex        sync.RWMutex
 	hostKeyCheck ssh.HostKeyCallback
+
+	// hostKeyPins looks up the fingerprint pinned for a host:port, if any
+	hostKeyPins HostKeyPinFunc
+
+	// timings holds the last connection attempts per host:port
+	timings     map[string][]ConnectionAttempt
+	timingMutex sync.Mutex
+
+	// health holds the averaged latency and failure rate per host:port
+	// that adaptive retry delays are scaled by, under timingMutex
+	health        map[string]*hostHealth
+	adaptiveRetry bool
 }
 
 // ClientConfig holds configuration for the SSH client
@@ -28,24 +46,125 @@ type ClientConfig struct {
 	MaxConnections    int
 	ConnectionTTL     time.Duration
 	KeepAliveInterval time.Duration
+
+	// LegacyAlgorithms also offers the key exchanges, ciphers, MACs and host
+	// key algorithms x/crypto marks insecure, after the secure ones, so old
+	// devices that only speak CBC or SHA-1 can still be reached and reported
+	LegacyAlgorithms bool
+
+	// TimingWindow is how many connection attempts per host
+	// RecentConnectionTimings keeps; zero means DefaultTimingWindow
+	TimingWindow int
+
+	// AdaptiveRetry scales the retry delay of each host by its recent
+	// connection latency and failure rate, within MinRetryDelay and
+	// MaxRetryDelay, and adds jitter. Zero bounds mean DefaultMinRetryDelay
+	// and DefaultMaxRetryDelay.
+	AdaptiveRetry bool
+	MinRetryDelay time.Duration
+	MaxRetryDelay time.Duration
+
+	// MaxRetryTime caps the time spent retrying one connection, so a bulk
+	// run has a predictable worst case per device; zero means no cap
+	MaxRetryTime time.Duration
 }
 
-// ConnectionPool manages SSH connections for a specific host
+// ConnectionPool manages SSH connections for one account on a specific
+// host. Connections handed out are active until Disconnect returns them to
+// the idle connections channel.
 type ConnectionPool struct {
 	host        string
+	owner       ConnectionInfo
 	connections chan *SSHConnection
 	active      map[*SSHConnection]bool
 	mutex       sync.RWMutex
 	config      *ClientConfig
+
+	// closed is set by closeAll, after which returned connections are
+	// closed instead of kept
+	closed bool
+
+	// rejectedHandouts counts pooled connections of another account that
+	// getConnection refused
+	rejectedHandouts int64
 }
 
 // SSHConnection wraps an SSH client connection with metadata
 type SSHConnection struct {
 	client    *ssh.Client
+	handshake Handshake
+	timings   PhaseTimings
 	createdAt time.Time
 	lastUsed  time.Time
 	inUse     bool
 	mutex     sync.RWMutex
+
+	// hostKeyFingerprint is the fingerprint of the key the device presented
+	hostKeyFingerprint string
+
+	// owner is the account the connection authenticated as, without its
+	// secrets, so a pool can check a connection before handing it out
+	owner ConnectionInfo
+
+	// authMethod names the authentication method the device accepted
+	authMethod string
+
+	// credentials is the credentialDigest of the login, so a pooled
+	// connection is not handed out after the password or key changed
+	credentials [sha256.Size]byte
+
+	// pool is the pool Disconnect returns the connection to, nil for
+	// connections that are not pooled
+	pool *ConnectionPool
+}
+
+// Handshake holds the algorithms negotiated when a connection was opened and
+// the version banner the server sent. Cipher and MAC are those used from
+// client to server.
+type Handshake struct {
+	KeyExchange   string `json:"keyExchange"`
+	HostKey       string `json:"hostKey"`
+	Cipher        string `json:"cipher"`
+	MAC           string `json:"mac"`
+	ServerVersion string `json:"serverVersion"`
+}
+
+// IsZero reports whether nothing was captured, as for simulated connections
+func (h Handshake) IsZero() bool {
+	return h == Handshake{}
+}
+
+// Handshake returns what was negotiated when the connection was opened
+func (c *SSHConnection) Handshake() Handshake {
+	return c.handshake
+}
+
+// Timings returns how long each phase of opening the connection took
+func (c *SSHConnection) Timings() PhaseTimings {
+	return c.timings
+}
+
+// AuthMethod names the authentication method the device accepted, such as
+// "keyboard-interactive", or is empty when it asked for none
+func (c *SSHConnection) AuthMethod() string {
+	return c.authMethod
+}
+
+// NewConnectionForTesting returns an unconnected SSHConnection carrying a
+// handshake, for test doubles of SSHClientInterface
+// WARNING: Commands cannot run on the returned connection
+func NewConnectionForTesting(handshake Handshake) *SSHConnection {
+	now := time.Now()
+	return &SSHConnection{handshake: handshake, createdAt: now, lastUsed: now}
+}
+
+// NewTimedConnectionForTesting is NewConnectionForTesting with the phase
+// timings of opening the connection
+// WARNING: Commands cannot run on the returned connection
+func NewTimedConnectionForTesting(handshake Handshake, timings PhaseTimings) *SSHConnection {
+	conn := NewConnectionForTesting(handshake)
+	conn.timings = timings
+	return conn
 }
 
 // AuthMethod represents different SSH authentication methods
@@ -57,6 +176,20 @@ const (
 	AuthKeyboard
 )
 
+// String names an authentication method
+func (m AuthMethod) String() string {
+	switch m {
+	case AuthPassword:
+		return "password"
+	case AuthPublicKey:
+		return "publickey"
+	case AuthKeyboard:
+		return "keyboard-interactive"
+	default:
+		return fmt.Sprintf("AuthMethod(%d)", int(m))
+	}
+}
+
 // ConnectionInfo holds information needed to establish an SSH connection
 type ConnectionInfo struct {
 	Host       string
@@ -65,16 +198,63 @@ type ConnectionInfo struct {
 	Password   string
 	PrivateKey []byte
 	AuthMethod AuthMethod
+
+	// AuthMethods, when set, replaces AuthMethod with methods tried in
+	// order, for fleets where it is not known which a device accepts. They
+	// are offered within one handshake; when the device drops the
+	// connection after rejecting one, the rest are tried on a new one.
+	AuthMethods []AuthMethod
+
+	// InteractiveAnswerFn answers keyboard-interactive prompts, such as Duo
+	// or SecurID second factors. When set it replaces answering every prompt
+	// with Password, and password logins fall back to it when the device
+	// asks for more than the password.
+	InteractiveAnswerFn InteractiveAnswerFunc `json:"-"`
+
+	// KeyboardResponses answer keyboard-interactive prompts by pattern, in
+	// order, followed by Password for password prompts. Prompts none of
+	// them match go to InteractiveAnswerFn, or fail the login.
+	KeyboardResponses []KeyboardResponse `json:"-"`
+
+	// HostKeyCallback verifies the device's host key in place of the
+	// client's trust-on-first-use verification, for devices with a host key
+	// policy of their own; see StrictHostKeyCallback
+	HostKeyCallback HostKeyCallback `json:"-"`
 }
 
+// InteractiveAnswerFunc returns one answer per keyboard-interactive question
+type InteractiveAnswerFunc func(user, instruction string, questions []string) ([]string, error)
+
 // CommandResult represents the result of an SSH command execution
+//
+// Stdout and Stderr are captured separately; Output is the combined view,
+// standard output followed by standard error. ExitCode is the command's exit
+// status, or -1 when it did not run to completion.
 type CommandResult struct {
 	Command    string
 	Output     string
+	Stdout     string
+	Stderr     string
 	Error      string
 	ExitCode   int
 	Duration   time.Duration
 	ExecutedAt time.Time
+
+	// Timings holds the session and command phases
+	Timings PhaseTimings
+}
+
+// setOutput records the captured streams and their combined view
+func (r *CommandResult) setOutput(stdout, stderr string) {
+	r.Stdout = stdout
+	r.Stderr = stderr
+	r.Output = stdout + stderr
+}
+
+// NonZeroExit reports whether the command ran to completion and exited with
+// a nonzero status, as opposed to not running at all
+func (r *CommandResult) NonZeroExit() bool {
+	return r != nil && r.ExitCode > 0
 }
 
 // SSHClientInterface defines the interface for SSH client operations
@@ -87,29 +267,80 @@ type SSHClientInterface interface {
 	GetConnectionStats() map[string]ConnectionStats
 }
 
-// Global known hosts storage for Trust-On-First-Use (TOFU) approach
+var _ SSHClientInterface = (*SSHClient)(nil)
+
+// Global known hosts storage for Trust-On-First-Use (TOFU) approach, keyed
+// by host:port so devices sharing an address on different ports are told apart
 var knownHosts = make(map[string]ssh.PublicKey)
 var knownHostsMutex sync.RWMutex
 
-// createSecureHostKeyCallback creates a secure host key callback using TOFU approach
-func createSecureHostKeyCallback() ssh.HostKeyCallback {
+// HostKeyCallback verifies the host key a device presents, so callers can
+// pass verification along without importing x/crypto/ssh
+type HostKeyCallback = ssh.HostKeyCallback
+
+// HostKeyPinFunc returns the host key fingerprint pinned for a host:port
+// address, or an empty string when the address has no pin
+type HostKeyPinFunc func(address string) (string, error)
+
+// HostKeyFingerprint returns the SHA256 fingerprint of a host key, in the
+// form OpenSSH prints it
+func HostKeyFingerprint(key ssh.PublicKey) string {
+	return ssh.FingerprintSHA256(key)
+}
+
+// ForgetHostKey drops the host key trusted for host:port, so the next
+// connection trusts whatever key the device presents, as on first use
+func ForgetHostKey(host string, port int) {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+	delete(knownHosts, hostAddress(host, port))
+}
+
+// hostAddress joins a host and port into the host:port form used to dial,
+// bracketing IPv6 addresses
+func hostAddress(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// createSecureHostKeyCallback creates a secure host key callback using TOFU
+// approach. Addresses with a pinned fingerprint only accept the pinned key.
+// The hostname given to the callback is the host:port that was dialled.
+func createSecureHostKeyCallback(pinned HostKeyPinFunc) ssh.HostKeyCallback {
 	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := HostKeyFingerprint(key)
+
+		if pinned != nil {
+			pin, err := pinned(hostname)
+			if err != nil {
+				return fmt.Errorf("failed to read host key pin for %s: %w", hostname, err)
+			}
+			if pin != "" {
+				if pin != fingerprint {
+					return fmt.Errorf("host key verification failed for %s: presented %s, pinned %s: %w",
+						hostname, fingerprint, pin, ErrHostKeyMismatch)
+				}
+				knownHostsMutex.Lock()
+				knownHosts[hostname] = key
+				knownHostsMutex.Unlock()
+				return nil
+			}
+		}
+
 		knownHostsMutex.Lock()
 		defer knownHostsMutex.Unlock()
 
-		// Check if we have a known host key for this hostname
+		// Check if we have a known host key for this host:port
 		if knownKey, exists := knownHosts[hostname]; exists {
 			// Compare the provided key with the known key
 			if string(key.Marshal()) == string(knownKey.Marshal()) {
 				return nil // Key matches, connection is secure
 			}
-			return fmt.Errorf("host key verification failed for %s: key mismatch", hostname)
+			return fmt.Errorf("host key verification failed for %s: %w", hostname, ErrHostKeyMismatch)
 		}
 
 		// For new hosts, implement Trust-On-First-Use (TOFU) approach
-		keyFingerprint := md5.Sum(key.Marshal())
-		fmt.Printf("WARNING: Unknown host %s with key fingerprint %x\n", hostname, keyFingerprint)
-		fmt.Printf("Adding host key to known hosts (Trust-On-First-Use)\n")
+		log.Printf("WARNING: Unknown host %s with key fingerprint %s", hostname, fingerprint)
+		log.Printf("Adding host key to known hosts (Trust-On-First-Use)")
 
 		// Store the key for future connections
 		knownHosts[hostname] = key
@@ -118,24 +349,63 @@ func createSecureHostKeyCallback() ssh.HostKeyCallback {
 	}
 }
 
+// StrictHostKeyCallback only accepts the host key with the given SHA256
+// fingerprint. Unlike a pin, which applies on top of trust on first use, it
+// needs no key to have been seen before.
+func StrictHostKeyCallback(fingerprint string) HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if fingerprint == "" {
+			return fmt.Errorf("host key verification failed for %s: no fingerprint to verify against: %w",
+				hostname, ErrHostKeyMismatch)
+		}
+		if presented := HostKeyFingerprint(key); presented != fingerprint {
+			return fmt.Errorf("host key verification failed for %s: presented %s, expected %s: %w",
+				hostname, presented, fingerprint, ErrHostKeyMismatch)
+		}
+
+		// Trust the key for later connections, in case the device falls
+		// back to trust on first use
+		knownHostsMutex.Lock()
+		knownHosts[hostname] = key
+		knownHostsMutex.Unlock()
+		return nil
+	}
+}
+
+// InsecureHostKeyCallback accepts any host key, logging a warning for each
+// connection. It is for devices explicitly configured to skip verification.
+func InsecureHostKeyCallback() HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		log.Printf("WARNING: Host key verification is disabled for %s; accepting key %s",
+			hostname, HostKeyFingerprint(key))
+		return nil
+	}
+}
+
 // CreateInsecureHostKeyCallbackForTesting creates an insecure callback for testing
 // WARNING: This should ONLY be used in development/testing environments
 func CreateInsecureHostKeyCallbackForTesting() ssh.HostKeyCallback {
 	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-		fmt.Printf("WARNING: Using insecure host key verification for %s - this should only be used in development\n", hostname)
+		log.Printf("WARNING: Using insecure host key verification for %s - this should only be used in development", hostname)
 		return nil
 	}
 }
 
-// ConnectionStats provides statistics about connection pools
+// ConnectionStats provides statistics about connection pools. Pools are
+// kept per account, so Username and AuthMethod tell apart the pools of one
+// host. RejectedHandouts counts pooled connections that belonged to another
+// account and were closed instead of being handed out; it should stay zero.
 type ConnectionStats struct {
 	Host             string
+	Username         string
+	AuthMethod       string
 	ActiveConns      int
 	AvailableConns   int
 	TotalConns       int
 	CreatedConns     int64
 	FailedConns      int64
 	CommandsExecuted int64
+	RejectedHandouts int64
 }
 
 // DefaultClientConfig returns a default SSH client configuration
@@ -148,6 +418,10 @@ func DefaultClientConfig() *ClientConfig {
 		MaxConnections:    5,
 		ConnectionTTL:     10 * time.Minute,
 		KeepAliveInterval: 30 * time.Second,
+		AdaptiveRetry:     true,
+		MinRetryDelay:     DefaultMinRetryDelay,
+		MaxRetryDelay:     DefaultMaxRetryDelay,
+		MaxRetryTime:      2 * time.Minute,
 	}
 }
 
@@ -157,12 +431,14 @@ func NewSSHClient(config *ClientConfig) *SSHClient {
 		config = DefaultClientConfig()
 	}
 
-	return &SSHClient{
-		config:      config,
-		connections: make(map[string]*ConnectionPool),
-		// Use secure host key verification by default
-		hostKeyCheck: createSecureHostKeyCallback(),
+	client := &SSHClient{
+		config:        config,
+		connections:   make(map[string]*ConnectionPool),
+		adaptiveRetry: config.AdaptiveRetry,
 	}
+	// Use secure host key verification by default
+	client.hostKeyCheck = createSecureHostKeyCallback(client.pinnedFingerprint)
+	return client
 }
 
 // NewSSHClientWithHostKeyCheck creates a new SSH client with custom host key verification
@@ -172,9 +448,10 @@ func NewSSHClientWithHostKeyCheck(config *ClientConfig, hostKeyCallback ssh.Host
 	}
 
 	return &SSHClient{
-		config:       config,
-		connections:  make(map[string]*ConnectionPool),
-		hostKeyCheck: hostKeyCallback,
+		config:        config,
+		connections:   make(map[string]*ConnectionPool),
+		hostKeyCheck:  hostKeyCallback,
+		adaptiveRetry: config.AdaptiveRetry,
 	}
 }
 
@@ -185,16 +462,14 @@ func (c *SSHClient) Connect(ctx context.Context, connInfo *ConnectionInfo) (*SSH
 	}
 
 	if err := c.validateConnectionInfo(connInfo); err != nil {
-		return nil, fmt.Errorf("invalid connection info: %w", err)
+		return nil, &SSHError{Kind: ErrorKindConfig, Host: connInfo.Host, Err: fmt.Errorf("invalid connection info: %w", err)}
 	}
 
-	hostKey := fmt.Sprintf("%s:%d", connInfo.Host, connInfo.Port)
-
-	// Get or create connection pool for this host
-	pool := c.getOrCreatePool(hostKey)
+	// Get or create connection pool for this account on the host
+	pool := c.getOrCreatePool(connInfo)
 
 	// Try to get an existing connection from the pool
-	if conn := pool.getConnection(); conn != nil {
+	if conn := pool.getConnection(connInfo); conn != nil {
 		return conn, nil
 	}
 
@@ -202,6 +477,128 @@ func (c *SSHClient) Connect(ctx context.Context, connInfo *ConnectionInfo) (*SSH
 	return c.createConnectionWithRetry(ctx, connInfo, pool)
 }
 
+// TestAuthentication performs a single SSH handshake and authentication without
+// running any command. The connection is closed straight away and never pooled.
+func (c *SSHClient) TestAuthentication(ctx context.Context, connInfo *ConnectionInfo) error {
+	_, err := c.ProbeAuthentication(ctx, connInfo)
+	return err
+}
+
+// ProbeAuthentication is TestAuthentication returning the name of the
+// authentication method the device accepted
+func (c *SSHClient) ProbeAuthentication(ctx context.Context, connInfo *ConnectionInfo) (string, error) {
+	if connInfo == nil {
+		return "", fmt.Errorf("connection info cannot be nil")
+	}
+
+	if err := c.validateConnectionInfo(connInfo); err != nil {
+		return "", &SSHError{Kind: ErrorKindConfig, Host: connInfo.Host, Err: fmt.Errorf("invalid connection info: %w", err)}
+	}
+
+	conn, attempt, err := c.createConnection(ctx, connInfo)
+	c.recordAttempt(attempt)
+	if err != nil {
+		return "", err
+	}
+
+	return conn.authMethod, conn.client.Close()
+}
+
+// ReadHostKeyFingerprint connects and authenticates like TestAuthentication
+// and returns the fingerprint of the host key the device presented. The key
+// must pass the usual verification, so a device whose key no longer
+// matches its pin or the key trusted before is refused.
+func (c *SSHClient) ReadHostKeyFingerprint(ctx context.Context, connInfo *ConnectionInfo) (string, error) {
+	if connInfo == nil {
+		return "", fmt.Errorf("connection info cannot be nil")
+	}
+
+	if err := c.validateConnectionInfo(connInfo); err != nil {
+		return "", &SSHError{Kind: ErrorKindConfig, Host: connInfo.Host, Err: fmt.Errorf("invalid connection info: %w", err)}
+	}
+
+	conn, attempt, err := c.createConnection(ctx, connInfo)
+	c.recordAttempt(attempt)
+	if err != nil {
+		return "", err
+	}
+	defer conn.client.Close()
+
+	return conn.hostKeyFingerprint, nil
+}
+
+// CheckHostKey verifies the host key a device presents, the way connecting
+// to it would, and returns the key's fingerprint. It stops before logging
+// in, so no credentials are needed and a rejected login does not fail it.
+// A refused key fails with an ErrorKindHostKey error.
+func (c *SSHClient) CheckHostKey(ctx context.Context, connInfo *ConnectionInfo) (string, error) {
+	if connInfo == nil {
+		return "", fmt.Errorf("connection info cannot be nil")
+	}
+	if connInfo.Host == "" || connInfo.Port <= 0 || connInfo.Port > 65535 {
+		return "", &SSHError{Kind: ErrorKindConfig, Host: connInfo.Host,
+			Err: fmt.Errorf("invalid connection info: host and a port between 1 and 65535 are required")}
+	}
+
+	address := hostAddress(connInfo.Host, connInfo.Port)
+	hostKeyCheck := c.hostKeyCheck
+	if connInfo.HostKeyCallback != nil {
+		hostKeyCheck = connInfo.HostKeyCallback
+	}
+
+	var fingerprint string
+	config := &ssh.ClientConfig{
+		User: connInfo.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if err := hostKeyCheck(hostname, remote, key); err != nil {
+				return &SSHError{Kind: ErrorKindHostKey, Host: address, Err: err}
+			}
+			fingerprint = HostKeyFingerprint(key)
+			return nil
+		},
+		Timeout: c.config.ConnectTimeout,
+	}
+	if c.config.LegacyAlgorithms {
+		setLegacyAlgorithms(config)
+	}
+
+	dialer := &net.Dialer{Timeout: c.config.ConnectTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", classifyDialError(address, fmt.Errorf("failed to dial %s: %w", address, err))
+	}
+	defer netConn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+
+	// Without authentication methods the handshake ends once the server
+	// asks for a login, after the key was checked
+	sshConn, _, _, err := ssh.NewClientConn(netConn, address, config)
+	if err == nil {
+		sshConn.Close()
+	}
+	if fingerprint != "" {
+		return fingerprint, nil
+	}
+	return "", classifyHandshakeError(address, fmt.Errorf("failed to create SSH connection: %w", err))
+}
+
+// SetHostKeyPins sets how the client looks up pinned host key fingerprints.
+// Pins are only enforced by the default host key verification; call it
+// before connecting.
+func (c *SSHClient) SetHostKeyPins(pins HostKeyPinFunc) {
+	c.hostKeyPins = pins
+}
+
+// pinnedFingerprint returns the fingerprint pinned for address, if any
+func (c *SSHClient) pinnedFingerprint(address string) (string, error) {
+	if c.hostKeyPins == nil {
+		return "", nil
+	}
+	return c.hostKeyPins(address)
+}
+
 // ExecuteCommand executes a single command on the SSH connection
 func (c *SSHClient) ExecuteCommand(ctx context.Context, conn *SSHConnection, command string) (*CommandResult, error) {
 	if conn == nil {
@@ -233,6 +630,7 @@ func (c *SSHClient) ExecuteCommand(ctx context.Context, conn *SSHConnection, com
 
 	// Create a new session for command execution
 	session, err := conn.client.NewSession()
+	result.Timings.Session = time.Since(startTime)
 	if err != nil {
 		result.Error = fmt.Sprintf("failed to create session: %v", err)
 		return result, err
@@ -244,31 +642,35 @@ func (c *SSHClient) ExecuteCommand(ctx context.Context, conn *SSHConnection, com
 	defer cancel()
 
 	// Execute command with timeout
-	outputChan := make(chan []byte, 1)
-	errorChan := make(chan error, 1)
+	commandStart := time.Now()
+	defer func() {
+		result.Timings.Command = time.Since(commandStart)
+	}()
+	// Separate buffers keep standard error apart from standard output; Run
+	// returns only after both have been copied
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	done := make(chan error, 1)
 
 	go func() {
-		output, err := session.CombinedOutput(command)
-		if err != nil {
-			errorChan <- err
-		} else {
-			outputChan <- output
-		}
+		done <- session.Run(command)
 	}()
 
 	select {
-	case output := <-outputChan:
-		result.Output = string(output)
+	case err := <-done:
+		result.setOutput(stdout.String(), stderr.String())
+		if err != nil {
+			result.Error = err.Error()
+			if exitErr, ok := err.(*ssh.ExitError); ok {
+				result.ExitCode = exitErr.ExitStatus()
+			} else {
+				result.ExitCode = -1
+			}
+			return result, err
+		}
 		result.ExitCode = 0
 		return result, nil
-	case err := <-errorChan:
-		result.Error = err.Error()
-		if exitErr, ok := err.(*ssh.ExitError); ok {
-			result.ExitCode = exitErr.ExitStatus()
-		} else {
-			result.ExitCode = -1
-		}
-		return result, err
 	case <-cmdCtx.Done():
 		result.Error = "command execution timeout"
 		result.ExitCode = -1
@@ -298,23 +700,23 @@ func (c *SSHClient) ExecuteCommands(ctx context.Context, conn *SSHConnection, co
 	return results, nil
 }
 
-// Disconnect closes an SSH connection and returns it to the pool or closes it
+// Disconnect returns an SSH connection to its pool for the next Connect of
+// the same account, or closes it when it has expired, is not pooled or the
+// pool is full
 func (c *SSHClient) Disconnect(conn *SSHConnection) error {
 	if conn == nil {
 		return nil
 	}
 
 	conn.mutex.Lock()
-	defer conn.mutex.Unlock()
+	pool := conn.pool
+	expired := time.Since(conn.createdAt) > c.config.ConnectionTTL
+	conn.mutex.Unlock()
 
-	// Check if connection is still valid and not expired
-	if time.Since(conn.createdAt) > c.config.ConnectionTTL {
+	if pool == nil || !pool.release(conn, !expired) {
 		return conn.client.Close()
 	}
-
-	// Connection is still valid, could be returned to pool
-	// For now, we'll close it. In a full implementation, we'd return it to the pool
-	return conn.client.Close()
+	return nil
 }
 
 // Close closes all connections and cleans up resources
@@ -333,14 +735,15 @@ func (c *SSHClient) Close() error {
 	return lastErr
 }
 
-// GetConnectionStats returns statistics about all connection pools
+// GetConnectionStats returns statistics about all connection pools, keyed
+// by username@host:port/auth-method
 func (c *SSHClient) GetConnectionStats() map[string]ConnectionStats {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
 
 	stats := make(map[string]ConnectionStats)
-	for host, pool := range c.connections {
-		stats[host] = pool.getStats()
+	for key, pool := range c.connections {
+		stats[key] = pool.getStats()
 	}
 
 	return stats
@@ -360,52 +763,117 @@ func (c *SSHClient) validateConnectionInfo(connInfo *ConnectionInfo) error {
 		return fmt.Errorf("username cannot be empty")
 	}
 
-	switch connInfo.AuthMethod {
-	case AuthPassword:
-		if connInfo.Password == "" {
-			return fmt.Errorf("password cannot be empty for password authentication")
-		}
-	case AuthPublicKey:
-		if len(connInfo.PrivateKey) == 0 {
-			return fmt.Errorf("private key cannot be empty for public key authentication")
+	for _, method := range connInfo.authChain() {
+		switch method {
+		case AuthPassword:
+			if connInfo.Password == "" {
+				return fmt.Errorf("password cannot be empty for password authentication")
+			}
+		case AuthPublicKey:
+			if len(connInfo.PrivateKey) == 0 {
+				return fmt.Errorf("private key cannot be empty for public key authentication")
+			}
+		case AuthKeyboard:
+			// Keyboard interactive authentication doesn't require additional validation here
+		default:
+			return fmt.Errorf("unsupported authentication method")
 		}
-	case AuthKeyboard:
-		// Keyboard interactive authentication doesn't require additional validation here
-	default:
-		return fmt.Errorf("unsupported authentication method")
 	}
 
 	return nil
 }
 
-// getOrCreatePool gets an existing connection pool or creates a new one
-func (c *SSHClient) getOrCreatePool(hostKey string) *ConnectionPool {
+// poolKey identifies the pool of an account on a device. Connections are
+// only shared between requests for the same username and authentication
+// methods, so two device records for one address with, say, a read-only and
+// an admin account never get each other's sessions.
+func poolKey(connInfo *ConnectionInfo) string {
+	return connInfo.Username + "@" + hostAddress(connInfo.Host, connInfo.Port) + "/" + authLabel(connInfo.authChain())
+}
+
+// connectionOwner returns the account connInfo logs in as, without the
+// password, key or answer function
+func connectionOwner(connInfo *ConnectionInfo) ConnectionInfo {
+	return ConnectionInfo{
+		Host:        connInfo.Host,
+		Port:        connInfo.Port,
+		Username:    connInfo.Username,
+		AuthMethod:  connInfo.AuthMethod,
+		AuthMethods: append([]AuthMethod(nil), connInfo.AuthMethods...),
+	}
+}
+
+// credentialDigest hashes the password and private key connInfo logs in
+// with
+func credentialDigest(connInfo *ConnectionInfo) [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s", len(connInfo.Password), connInfo.Password)
+	h.Write(connInfo.PrivateKey)
+	var digest [sha256.Size]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// ownedBy reports whether the connection logged in as the account connInfo
+// asks for
+func (c *SSHConnection) ownedBy(connInfo *ConnectionInfo) bool {
+	return c.owner.Host == connInfo.Host && c.owner.Port == connInfo.Port &&
+		c.owner.Username == connInfo.Username && authLabel(c.owner.authChain()) == authLabel(connInfo.authChain())
+}
+
+// getOrCreatePool gets the connection pool of an account or creates a new one
+func (c *SSHClient) getOrCreatePool(connInfo *ConnectionInfo) *ConnectionPool {
+	key := poolKey(connInfo)
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if pool, exists := c.connections[hostKey]; exists {
+	if pool, exists := c.connections[key]; exists {
 		return pool
 	}
 
 	pool := &ConnectionPool{
-		host:        hostKey,
+		host:        hostAddress(connInfo.Host, connInfo.Port),
+		owner:       connectionOwner(connInfo),
 		connections: make(chan *SSHConnection, c.config.MaxConnections),
 		active:      make(map[*SSHConnection]bool),
 		config:      c.config,
 	}
 
-	c.connections[hostKey] = pool
+	c.connections[key] = pool
 	return pool
 }
 
-// createConnectionWithRetry creates a new SSH connection with retry logic
+// createConnectionWithRetry creates a new SSH connection with retry logic.
+// Failures after several attempts list where each attempt spent its time.
+// No retry is started that would wait past ClientConfig.MaxRetryTime.
 func (c *SSHClient) createConnectionWithRetry(ctx context.Context, connInfo *ConnectionInfo, pool *ConnectionPool) (*SSHConnection, error) {
 	var lastErr error
+	var attempts []ConnectionAttempt
+	address := hostAddress(connInfo.Host, connInfo.Port)
+	started := time.Now()
+
+	// Once the user has been asked for a second factor the login is not
+	// retried: one-time passcodes cannot be replayed, and every retry would
+	// ask the user again
+	var asked atomic.Bool
+	if answer := connInfo.InteractiveAnswerFn; answer != nil {
+		withAnswer := *connInfo
+		withAnswer.InteractiveAnswerFn = func(user, instruction string, questions []string) ([]string, error) {
+			asked.Store(true)
+			return answer(user, instruction, questions)
+		}
+		connInfo = &withAnswer
+	}
 
 	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
 		if attempt > 0 {
-			// Wait before retrying with exponential backoff
-			delay := time.Duration(attempt) * c.config.RetryDelay
+			// Wait before retrying with a backoff growing per attempt
+			delay := c.retryDelay(address, attempt)
+			if c.config.MaxRetryTime > 0 && time.Since(started)+delay > c.config.MaxRetryTime {
+				return nil, fmt.Errorf("failed to connect after %d attempts, retry time limit of %s reached (%s): %w",
+					attempt, c.config.MaxRetryTime, describeAttempts(attempts), lastErr)
+			}
 			select {
 			case <-time.After(delay):
 			case <-ctx.Done():
@@ -413,7 +881,10 @@ func (c *SSHClient) createConnectionWithRetry(ctx context.Context, connInfo *Con
 			}
 		}
 
-		conn, err := c.createConnection(ctx, connInfo)
+		conn, timing, err := c.createConnection(ctx, connInfo)
+		timing.Attempt = attempt + 1
+		attempts = append(attempts, timing)
+		c.recordAttempt(timing)
 		if err == nil {
 			pool.addConnection(conn)
 			return conn, nil
@@ -421,54 +892,90 @@ func (c *SSHClient) createConnectionWithRetry(ctx context.Context, connInfo *Con
 
 		lastErr = err
 
+		if !isRetryable(err) || asked.Load() {
+			return nil, err
+		}
+
 		// Check if context was cancelled
 		if ctx.Err() != nil {
 			return nil, ctx.Err()
 		}
 	}
 
-	return nil, fmt.Errorf("failed to connect after %d attempts: %w", c.config.MaxRetries+1, lastErr)
+	return nil, fmt.Errorf("failed to connect after %d attempts (%s): %w",
+		c.config.MaxRetries+1, describeAttempts(attempts), lastErr)
 }
 
-// createConnection creates a new SSH connection
-func (c *SSHClient) createConnection(ctx context.Context, connInfo *ConnectionInfo) (*SSHConnection, error) {
+// createConnection creates a new SSH connection and times each phase of
+// opening it. The attempt is returned whether or not it succeeded. When the
+// device drops the connection after rejecting some of connInfo's
+// authentication methods, the methods it never got to are tried on a new
+// connection, and the attempt describes the last one.
+func (c *SSHClient) createConnection(ctx context.Context, connInfo *ConnectionInfo) (*SSHConnection, ConnectionAttempt, error) {
+	chain := connInfo.authChain()
+	for {
+		tracker := &authTracker{}
+		conn, attempt, err := c.openConnection(ctx, connInfo, chain, tracker)
+		if err == nil || ErrorKindOf(err) != ErrorKindProtocol || len(tracker.tried) == 0 || ctx.Err() != nil {
+			return conn, attempt, err
+		}
+		rest := tracker.untried(chain)
+		if len(rest) == 0 {
+			return conn, attempt, err
+		}
+		log.Printf("SSH server %s closed the connection after rejecting %s, trying %s",
+			attempt.Host, authLabel(tracker.tried), authLabel(rest))
+		chain = rest
+	}
+}
+
+// openConnection opens one SSH connection offering the authentication
+// methods of chain in order, noting those tried in tracker
+func (c *SSHClient) openConnection(ctx context.Context, connInfo *ConnectionInfo, chain []AuthMethod,
+	tracker *authTracker) (*SSHConnection, ConnectionAttempt, error) {
+	address := hostAddress(connInfo.Host, connInfo.Port)
+	attempt := ConnectionAttempt{Host: address, Attempt: 1, StartedAt: time.Now()}
+	clock := newPhaseClock()
+	fail := func(err error) (*SSHConnection, ConnectionAttempt, error) {
+		attempt.Phases, attempt.FailedPhase = clock.stop()
+		attempt.Error = err.Error()
+		return nil, attempt, err
+	}
+
 	// Prepare SSH client configuration
+	var hostKeyFingerprint string
+	hostKeyCheck := c.hostKeyCheck
+	if connInfo.HostKeyCallback != nil {
+		hostKeyCheck = connInfo.HostKeyCallback
+	}
 	config := &ssh.ClientConfig{
-		User:            connInfo.Username,
-		HostKeyCallback: c.hostKeyCheck,
-		Timeout:         c.config.ConnectTimeout,
+		User: connInfo.Username,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if err := hostKeyCheck(hostname, remote, key); err != nil {
+				return &SSHError{Kind: ErrorKindHostKey, Host: address, Err: err}
+			}
+			hostKeyFingerprint = HostKeyFingerprint(key)
+			clock.end(PhaseKeyExchange, PhaseAuth)
+			return nil
+		},
+		Timeout: c.config.ConnectTimeout,
+	}
+	if c.config.LegacyAlgorithms {
+		setLegacyAlgorithms(config)
 	}
 
-	// Set up authentication method
-	switch connInfo.AuthMethod {
-	case AuthPassword:
-		config.Auth = []ssh.AuthMethod{
-			ssh.Password(connInfo.Password),
-		}
-	case AuthPublicKey:
-		signer, err := ssh.ParsePrivateKey(connInfo.PrivateKey)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
-		}
-		config.Auth = []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		}
-	case AuthKeyboard:
-		config.Auth = []ssh.AuthMethod{
-			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
-				// For keyboard interactive, we'll use the password for now
-				// In a full implementation, this would be more sophisticated
-				answers := make([]string, len(questions))
-				for i := range answers {
-					answers[i] = connInfo.Password
-				}
-				return answers, nil
-			}),
-		}
+	// Keyboard-interactive prompts are answered from the configured
+	// responses; password logins fall back to them when any are set
+	challenge, err := keyboardChallenge(address, keyboardResponses(connInfo), connInfo.InteractiveAnswerFn)
+	if err != nil {
+		return fail(&SSHError{Kind: ErrorKindConfig, Host: address, Err: err})
 	}
 
-	// Create connection with timeout
-	address := fmt.Sprintf("%s:%d", connInfo.Host, connInfo.Port)
+	// Set up the authentication methods, tried in order
+	config.Auth, err = authMethods(connInfo, chain, challenge, tracker)
+	if err != nil {
+		return fail(&SSHError{Kind: ErrorKindConfig, Host: address, Err: err})
+	}
 
 	// Use context for connection timeout
 	dialer := &net.Dialer{
@@ -477,50 +984,157 @@ func (c *SSHClient) createConnection(ctx context.Context, connInfo *ConnectionIn
 
 	netConn, err := dialer.DialContext(ctx, "tcp", address)
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial %s: %w", address, err)
+		return fail(classifyDialError(address, fmt.Errorf("failed to dial %s: %w", address, err)))
 	}
+	clock.end(PhaseDial, PhaseBanner)
 
-	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, address, config)
+	sshConn, chans, reqs, err := ssh.NewClientConn(&timedConn{Conn: netConn, clock: clock}, address, config)
 	if err != nil {
 		netConn.Close()
-		return nil, fmt.Errorf("failed to create SSH connection: %w", err)
+		return fail(classifyHandshakeError(address, fmt.Errorf("failed to create SSH connection: %w", err)))
 	}
+	clock.end(PhaseAuth, "")
+	attempt.Phases, _ = clock.stop()
+	attempt.AuthMethod = tracker.succeeded()
 
 	client := ssh.NewClient(sshConn, chans, reqs)
 
 	return &SSHConnection{
-		client:    client,
-		createdAt: time.Now(),
-		lastUsed:  time.Now(),
-		inUse:     false,
-	}, nil
+		client:             client,
+		handshake:          negotiatedHandshake(sshConn),
+		hostKeyFingerprint: hostKeyFingerprint,
+		owner:              connectionOwner(connInfo),
+		credentials:        credentialDigest(connInfo),
+		authMethod:         attempt.AuthMethod,
+		timings:            attempt.Phases,
+		createdAt:          time.Now(),
+		lastUsed:           time.Now(),
+		inUse:              false,
+	}, attempt, nil
+}
+
+// interactiveChallenge adapts an InteractiveAnswerFunc to x/crypto,
+// checking that every question gets an answer
+func interactiveChallenge(answer InteractiveAnswerFunc) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		// Servers may send an empty round, e.g. to show an instruction
+		if len(questions) == 0 {
+			return []string{}, nil
+		}
+		answers, err := answer(user, instruction, questions)
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) != len(questions) {
+			return nil, fmt.Errorf("got %d answers for %d questions", len(answers), len(questions))
+		}
+		return answers, nil
+	}
+}
+
+// setLegacyAlgorithms offers the insecure algorithms after the secure ones
+func setLegacyAlgorithms(config *ssh.ClientConfig) {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	config.KeyExchanges = append(supported.KeyExchanges, insecure.KeyExchanges...)
+	config.Ciphers = append(supported.Ciphers, insecure.Ciphers...)
+	config.MACs = append(supported.MACs, insecure.MACs...)
+	config.HostKeyAlgorithms = append(supported.HostKeys, insecure.HostKeys...)
+}
+
+// negotiatedHandshake reads the negotiated algorithms and server banner off
+// an established connection
+func negotiatedHandshake(conn ssh.Conn) Handshake {
+	handshake := Handshake{ServerVersion: string(conn.ServerVersion())}
+	if meta, ok := conn.(ssh.AlgorithmsConnMetadata); ok {
+		algorithms := meta.Algorithms()
+		handshake.KeyExchange = algorithms.KeyExchange
+		handshake.HostKey = algorithms.HostKey
+		handshake.Cipher = algorithms.Write.Cipher
+		handshake.MAC = algorithms.Write.MAC
+	}
+	return handshake
 }
 
 // ConnectionPool methods
 
-// getConnection gets an available connection from the pool
-func (p *ConnectionPool) getConnection() *SSHConnection {
-	select {
-	case conn := <-p.connections:
-		// Check if connection is still valid
+// getConnection hands out an idle connection of the pool, or returns nil
+// when there is none. Idle connections that expired or that the device
+// dropped are closed on the way.
+func (p *ConnectionPool) getConnection(connInfo *ConnectionInfo) *SSHConnection {
+	for {
+		var conn *SSHConnection
+		select {
+		case conn = <-p.connections:
+		default:
+			return nil
+		}
+
 		if time.Since(conn.createdAt) > p.config.ConnectionTTL {
 			conn.client.Close()
-			return nil
+			continue
+		}
+		// Pools are per account, so this only fails on a bug; running
+		// commands as the wrong account is worse than reconnecting
+		if !conn.ownedBy(connInfo) {
+			atomic.AddInt64(&p.rejectedHandouts, 1)
+			log.Printf("SSH pool for %s held a connection of %s, not %s; closing it",
+				p.host, conn.owner.Username, connInfo.Username)
+			conn.client.Close()
+			continue
+		}
+		// A connection opened with credentials that have since changed
+		// would hide that the new ones are wrong
+		if conn.credentials != credentialDigest(connInfo) {
+			conn.client.Close()
+			continue
 		}
+		// A device may close an idle connection; a keepalive finds out
+		// before a command fails on it
+		if _, _, err := conn.client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			conn.client.Close()
+			continue
+		}
+
+		p.addConnection(conn)
 		return conn
-	default:
-		return nil
 	}
 }
 
-// addConnection adds a connection to the pool
+// addConnection marks a connection handed out by the pool as active
 func (p *ConnectionPool) addConnection(conn *SSHConnection) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
+	conn.mutex.Lock()
+	conn.pool = p
+	conn.mutex.Unlock()
 	p.active[conn] = true
 }
 
+// release takes back an active connection, keeping it idle when keep is
+// set. It returns false when the connection is not kept, because keep is
+// false, it is not active in the pool, or the pool is closed or full, and
+// the caller must close it.
+func (p *ConnectionPool) release(conn *SSHConnection, keep bool) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.active[conn] {
+		return false
+	}
+	delete(p.active, conn)
+	if !keep || p.closed {
+		return false
+	}
+
+	select {
+	case p.connections <- conn:
+		return true
+	default:
+		return false
+	}
+}
+
 // closeAll closes all connections in the pool
 func (p *ConnectionPool) closeAll() error {
 	p.mutex.Lock()
@@ -549,6 +1163,7 @@ closeActive:
 	}
 
 	p.active = make(map[*SSHConnection]bool)
+	p.closed = true
 	return lastErr
 }
 
@@ -558,9 +1173,12 @@ func (p *ConnectionPool) getStats() ConnectionStats {
 	defer p.mutex.RUnlock()
 
 	return ConnectionStats{
-		Host:           p.host,
-		ActiveConns:    len(p.active),
-		AvailableConns: len(p.connections),
-		TotalConns:     len(p.active) + len(p.connections),
+		Host:             p.host,
+		Username:         p.owner.Username,
+		AuthMethod:       authLabel(p.owner.authChain()),
+		ActiveConns:      len(p.active),
+		AvailableConns:   len(p.connections),
+		TotalConns:       len(p.active) + len(p.connections),
+		RejectedHandouts: atomic.LoadInt64(&p.rejectedHandouts),
 	}
 }
@@ -2,175 +2,23 @@ package ssh
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
 	"testing"
 	"time"
 
+	"invictux-demo/internal/ssh/sshtest"
+
 	"golang.org/x/crypto/ssh"
 )
 
-// MockSSHServer represents a mock SSH server for testing
-type MockSSHServer struct {
-	listener   net.Listener
-	config     *ssh.ServerConfig
-	address    string
-	port       int
-	commands   map[string]string // command -> response mapping
-	shouldFail bool
-	delay      time.Duration
-}
-
-// NewMockSSHServer creates a new mock SSH server
-func NewMockSSHServer() (*MockSSHServer, error) {
-	// Generate a test host key
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate private key: %w", err)
-	}
-
-	signer, err := ssh.NewSignerFromKey(privateKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create signer: %w", err)
-	}
-
-	config := &ssh.ServerConfig{
-		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-			if c.User() == "testuser" && string(pass) == "testpass" {
-				return nil, nil
-			}
-			return nil, fmt.Errorf("invalid credentials")
-		},
-	}
-	config.AddHostKey(signer)
-
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		return nil, fmt.Errorf("failed to listen: %w", err)
-	}
-
-	address := listener.Addr().String()
-	host, portStr, _ := net.SplitHostPort(address)
-	port := 0
-	fmt.Sscanf(portStr, "%d", &port)
-
-	server := &MockSSHServer{
-		listener: listener,
-		config:   config,
-		address:  host,
-		port:     port,
-		commands: make(map[string]string),
-	}
-
-	go server.serve()
-	return server, nil
-}
-
-// SetCommandResponse sets the response for a specific command
-func (s *MockSSHServer) SetCommandResponse(command, response string) {
-	s.commands[command] = response
-}
-
-// SetShouldFail sets whether the server should fail connections
-func (s *MockSSHServer) SetShouldFail(shouldFail bool) {
-	s.shouldFail = shouldFail
-}
-
-// SetDelay sets a delay for command execution
-func (s *MockSSHServer) SetDelay(delay time.Duration) {
-	s.delay = delay
-}
-
-// GetAddress returns the server address
-func (s *MockSSHServer) GetAddress() string {
-	return s.address
-}
-
-// GetPort returns the server port
-func (s *MockSSHServer) GetPort() int {
-	return s.port
-}
-
-// Close closes the mock server
-func (s *MockSSHServer) Close() error {
-	return s.listener.Close()
-}
-
-// serve handles incoming connections
-func (s *MockSSHServer) serve() {
-	for {
-		conn, err := s.listener.Accept()
-		if err != nil {
-			return
-		}
-
-		go s.handleConnection(conn)
-	}
-}
-
-// handleConnection handles a single SSH connection
-func (s *MockSSHServer) handleConnection(netConn net.Conn) {
-	defer netConn.Close()
-
-	if s.shouldFail {
-		return
-	}
-
-	sshConn, chans, reqs, err := ssh.NewServerConn(netConn, s.config)
-	if err != nil {
-		return
-	}
-	defer sshConn.Close()
-
-	go ssh.DiscardRequests(reqs)
-
-	for newChannel := range chans {
-		if newChannel.ChannelType() != "session" {
-			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
-			continue
-		}
-
-		channel, requests, err := newChannel.Accept()
-		if err != nil {
-			continue
-		}
-
-		go s.handleSession(channel, requests)
-	}
-}
-
-// handleSession handles a single SSH session
-func (s *MockSSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
-	defer channel.Close()
-
-	for req := range requests {
-		switch req.Type {
-		case "exec":
-			if s.delay > 0 {
-				time.Sleep(s.delay)
-			}
-
-			command := string(req.Payload[4:]) // Skip the length prefix
-			response, exists := s.commands[command]
-			if !exists {
-				response = fmt.Sprintf("Command not found: %s", command)
-			}
-
-			channel.Write([]byte(response))
-			channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
-			req.Reply(true, nil)
-			return
-		default:
-			req.Reply(false, nil)
-		}
-	}
-}
-
 // Test helper functions
 
 func generateTestPrivateKey() ([]byte, error) {
@@ -241,10 +89,14 @@ func TestDefaultClientConfig(t *testing.T) {
 	if config.MaxRetries != 3 {
 		t.Errorf("Expected default MaxRetries 3, got %d", config.MaxRetries)
 	}
+
+	if !config.AdaptiveRetry || config.MaxRetryTime != 2*time.Minute {
+		t.Errorf("Expected adaptive retry capped at 2m by default, got %v and %v", config.AdaptiveRetry, config.MaxRetryTime)
+	}
 }
 
 func TestSSHClient_Connect_Success(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -278,7 +130,7 @@ func TestSSHClient_Connect_Success(t *testing.T) {
 }
 
 func TestSSHClient_Connect_InvalidCredentials(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -405,7 +257,7 @@ func TestSSHClient_Connect_InvalidConnectionInfo(t *testing.T) {
 }
 
 func TestSSHClient_ExecuteCommand_Success(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -464,6 +316,56 @@ func TestSSHClient_ExecuteCommand_Success(t *testing.T) {
 	}
 }
 
+func TestSSHClient_ExecuteCommand_SeparateStreams(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	server.SetCommandResult("show version", "Cisco IOS\n", "% deprecated keyword\n", 0)
+	server.SetCommandResult("login test", "", "% Authentication failed\n", 1)
+
+	client := NewSSHClient(nil)
+	defer client.Close()
+
+	conn, err := client.Connect(context.Background(), &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect(conn)
+
+	result, err := client.ExecuteCommand(context.Background(), conn, "show version")
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.Stdout != "Cisco IOS\n" || result.Stderr != "% deprecated keyword\n" {
+		t.Errorf("Expected separate streams, got stdout %q and stderr %q", result.Stdout, result.Stderr)
+	}
+	if result.Output != "Cisco IOS\n% deprecated keyword\n" {
+		t.Errorf("Expected standard error after standard output in Output, got %q", result.Output)
+	}
+	if result.NonZeroExit() {
+		t.Error("Expected a zero exit status")
+	}
+
+	result, err = client.ExecuteCommand(context.Background(), conn, "login test")
+	if err == nil {
+		t.Fatal("Expected a nonzero exit status to be returned as an error")
+	}
+	if result.ExitCode != 1 || !result.NonZeroExit() {
+		t.Errorf("Expected exit code 1, got %d", result.ExitCode)
+	}
+	if result.Stderr != "% Authentication failed\n" {
+		t.Errorf("Expected standard error of a failed command to be kept, got %q", result.Stderr)
+	}
+}
+
 func TestSSHClient_ExecuteCommand_NilConnection(t *testing.T) {
 	client := NewSSHClient(nil)
 	defer client.Close()
@@ -486,7 +388,7 @@ func TestSSHClient_ExecuteCommand_NilConnection(t *testing.T) {
 }
 
 func TestSSHClient_ExecuteCommand_EmptyCommand(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -527,7 +429,7 @@ func TestSSHClient_ExecuteCommand_EmptyCommand(t *testing.T) {
 }
 
 func TestSSHClient_ExecuteCommands_Success(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -653,7 +555,7 @@ func TestSSHClient_Connect_WithRetry(t *testing.T) {
 }
 
 func TestSSHClient_CommandTimeout(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -722,87 +624,954 @@ func TestSSHClient_GetConnectionStats(t *testing.T) {
 	}
 }
 
-func TestSSHClient_Close(t *testing.T) {
+// poolOf returns the pool holding connections for connInfo
+func poolOf(t *testing.T, client *SSHClient, connInfo *ConnectionInfo) *ConnectionPool {
+	t.Helper()
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	pool, ok := client.connections[poolKey(connInfo)]
+	if !ok {
+		t.Fatalf("No pool for %s", poolKey(connInfo))
+	}
+	return pool
+}
+
+func TestSSHClient_PoolsPerAccount(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	server.AddUser("readonly", "ropass")
+
 	client := NewSSHClient(nil)
+	defer client.Close()
 
-	err := client.Close()
+	admin := &ConnectionInfo{Host: server.GetAddress(), Port: server.GetPort(), Username: "testuser",
+		Password: "testpass", AuthMethod: AuthPassword}
+	readOnly := &ConnectionInfo{Host: server.GetAddress(), Port: server.GetPort(), Username: "readonly",
+		Password: "ropass", AuthMethod: AuthPassword}
 
+	ctx := context.Background()
+	adminConn, err := client.Connect(ctx, admin)
 	if err != nil {
-		t.Errorf("Expected no error on close, got: %v", err)
+		t.Fatalf("Failed to connect as admin: %v", err)
+	}
+	readOnlyConn, err := client.Connect(ctx, readOnly)
+	if err != nil {
+		t.Fatalf("Failed to connect as readonly: %v", err)
+	}
+	if adminConn.client.User() != "testuser" || readOnlyConn.client.User() != "readonly" {
+		t.Fatalf("Expected connections as testuser and readonly, got %s and %s",
+			adminConn.client.User(), readOnlyConn.client.User())
 	}
 
-	// Verify connections map is reset
-	if len(client.connections) != 0 {
-		t.Error("Expected connections map to be empty after close")
+	stats := client.GetConnectionStats()
+	if len(stats) != 2 {
+		t.Fatalf("Expected a pool per account, got %d: %v", len(stats), stats)
+	}
+	for _, connInfo := range []*ConnectionInfo{admin, readOnly} {
+		got, ok := stats[poolKey(connInfo)]
+		if !ok {
+			t.Fatalf("Expected stats for %s", poolKey(connInfo))
+		}
+		if got.Username != connInfo.Username || got.AuthMethod != "password" || got.ActiveConns != 1 {
+			t.Errorf("Unexpected stats for %s: %+v", connInfo.Username, got)
+		}
 	}
-}
 
-// Benchmark tests
+	// A disconnected admin connection is only handed back to the admin account
+	if err := client.Disconnect(adminConn); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	if got := client.GetConnectionStats()[poolKey(admin)]; got.ActiveConns != 0 || got.AvailableConns != 1 {
+		t.Errorf("Expected the admin connection to be idle in its pool, got %+v", got)
+	}
+	again, err := client.Connect(ctx, readOnly)
+	if err != nil {
+		t.Fatalf("Failed to connect as readonly: %v", err)
+	}
+	if again == adminConn || again.client.User() != "readonly" {
+		t.Errorf("Expected a readonly connection, got one as %s", again.client.User())
+	}
+	reused, err := client.Connect(ctx, admin)
+	if err != nil {
+		t.Fatalf("Failed to connect as admin: %v", err)
+	}
+	if reused != adminConn {
+		t.Error("Expected the pooled admin connection to be reused")
+	}
+}
 
-func BenchmarkSSHClient_Connect(b *testing.B) {
-	server, err := NewMockSSHServer()
+// TestSSHClient_PoolRejectsOtherAccounts reproduces pools keyed only on
+// host:port, where a connection opened as one account sat in the pool a
+// request for another account drew from
+func TestSSHClient_PoolRejectsOtherAccounts(t *testing.T) {
+	server, err := sshtest.NewServer()
 	if err != nil {
-		b.Fatalf("Failed to create mock server: %v", err)
+		t.Fatalf("Failed to create mock server: %v", err)
 	}
 	defer server.Close()
+	server.AddUser("readonly", "ropass")
 
 	client := NewSSHClient(nil)
 	defer client.Close()
 
-	connInfo := &ConnectionInfo{
-		Host:       server.GetAddress(),
-		Port:       server.GetPort(),
-		Username:   "testuser",
-		Password:   "testpass",
-		AuthMethod: AuthPassword,
-	}
+	admin := &ConnectionInfo{Host: server.GetAddress(), Port: server.GetPort(), Username: "testuser",
+		Password: "testpass", AuthMethod: AuthPassword}
+	readOnly := &ConnectionInfo{Host: server.GetAddress(), Port: server.GetPort(), Username: "readonly",
+		Password: "ropass", AuthMethod: AuthPassword}
 
 	ctx := context.Background()
+	adminConn, err := client.Connect(ctx, admin)
+	if err != nil {
+		t.Fatalf("Failed to connect as admin: %v", err)
+	}
+	readOnlyConn, err := client.Connect(ctx, readOnly)
+	if err != nil {
+		t.Fatalf("Failed to connect as readonly: %v", err)
+	}
+	defer client.Disconnect(readOnlyConn)
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		conn, err := client.Connect(ctx, connInfo)
-		if err != nil {
-			b.Errorf("Connection failed: %v", err)
-		}
-		if conn != nil {
-			client.Disconnect(conn)
-		}
+	poolOf(t, client, readOnly).connections <- adminConn
+	conn, err := client.Connect(ctx, readOnly)
+	if err != nil {
+		t.Fatalf("Failed to connect as readonly: %v", err)
+	}
+	if conn == adminConn || conn.client.User() != "readonly" {
+		t.Fatalf("Expected a new readonly connection, got one as %s", conn.client.User())
+	}
+
+	stats := client.GetConnectionStats()[poolKey(readOnly)]
+	if stats.RejectedHandouts != 1 {
+		t.Errorf("Expected the admin connection to be rejected once, got %d", stats.RejectedHandouts)
+	}
+	if stats.AvailableConns != 0 {
+		t.Errorf("Expected the rejected connection to leave the pool, %d remain", stats.AvailableConns)
+	}
+	if _, _, err := adminConn.client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+		t.Error("Expected the rejected connection to be closed")
 	}
 }
 
-func BenchmarkSSHClient_ExecuteCommand(b *testing.B) {
-	server, err := NewMockSSHServer()
+func TestSSHClient_DisconnectReturnsToPool(t *testing.T) {
+	server, err := sshtest.NewServer()
 	if err != nil {
-		b.Fatalf("Failed to create mock server: %v", err)
+		t.Fatalf("Failed to create mock server: %v", err)
 	}
 	defer server.Close()
+	server.SetCommandResponse("show version", "Cisco IOS Version 15.1")
 
-	server.SetCommandResponse("test command", "test response")
+	config := DefaultClientConfig()
+	client := NewSSHClient(config)
+	connInfo := &ConnectionInfo{Host: server.GetAddress(), Port: server.GetPort(), Username: "testuser",
+		Password: "testpass", AuthMethod: AuthPassword}
+
+	ctx := context.Background()
+	conn, err := client.Connect(ctx, connInfo)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if err := client.Disconnect(conn); err != nil {
+		t.Fatalf("Failed to disconnect: %v", err)
+	}
+	reused, err := client.Connect(ctx, connInfo)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if reused != conn {
+		t.Fatal("Expected the disconnected connection to be reused")
+	}
+	if _, err := client.ExecuteCommand(ctx, reused, "show version"); err != nil {
+		t.Errorf("Expected the reused connection to run commands, got %v", err)
+	}
+
+	// Nor is it handed out once the password changed, which would hide
+	// that the new one is wrong
+	client.Disconnect(reused)
+	wrong := *connInfo
+	wrong.Password = "changed"
+	if _, err := client.Connect(ctx, &wrong); err == nil {
+		t.Fatal("Expected the changed password to be tried")
+	}
+	reused, err = client.Connect(ctx, connInfo)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	// A connection the device dropped while idle is not handed out
+	client.Disconnect(reused)
+	reused.client.Close()
+	fresh, err := client.Connect(ctx, connInfo)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if fresh == reused {
+		t.Error("Expected a dropped connection to be replaced")
+	}
+
+	// Expired connections are closed instead of pooled
+	config.ConnectionTTL = 0
+	client.Disconnect(fresh)
+	if got := client.GetConnectionStats()[poolKey(connInfo)]; got.ActiveConns != 0 || got.AvailableConns != 0 {
+		t.Errorf("Expected the expired connection to be closed, got %+v", got)
+	}
+	config.ConnectionTTL = DefaultClientConfig().ConnectionTTL
+
+	// Connections returned after Close are closed too
+	last, err := client.Connect(ctx, connInfo)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	pool := poolOf(t, client, connInfo)
+	client.Close()
+	client.Disconnect(last)
+	if len(pool.connections) != 0 {
+		t.Error("Expected a closed pool to keep no connections")
+	}
+	if _, _, err := last.client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+		t.Error("Expected the connection to be closed")
+	}
+}
 
+func TestSSHClient_Close(t *testing.T) {
 	client := NewSSHClient(nil)
-	defer client.Close()
 
-	connInfo := &ConnectionInfo{
-		Host:       server.GetAddress(),
-		Port:       server.GetPort(),
-		Username:   "testuser",
-		Password:   "testpass",
-		AuthMethod: AuthPassword,
+	err := client.Close()
+
+	if err != nil {
+		t.Errorf("Expected no error on close, got: %v", err)
 	}
 
-	ctx := context.Background()
-	conn, err := client.Connect(ctx, connInfo)
+	// Verify connections map is reset
+	if len(client.connections) != 0 {
+		t.Error("Expected connections map to be empty after close")
+	}
+}
+
+func TestSSHClient_TestAuthentication(t *testing.T) {
+	server, err := sshtest.NewServer()
 	if err != nil {
-		b.Fatalf("Failed to connect: %v", err)
+		t.Fatalf("Failed to create mock server: %v", err)
 	}
-	defer client.Disconnect(conn)
+	defer server.Close()
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		_, err := client.ExecuteCommand(ctx, conn, "test command")
-		if err != nil {
-			b.Errorf("Command execution failed: %v", err)
-		}
+	// A free local port with nothing listening on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	closedPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	rejectHostKey := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return fmt.Errorf("host key verification failed for %s: key mismatch", hostname)
+	}
+
+	testCases := []struct {
+		name     string
+		client   *SSHClient
+		port     int
+		username string
+		password string
+		expected ErrorKind
+	}{
+		{"success", NewSSHClientWithHostKeyCheck(nil, CreateInsecureHostKeyCallbackForTesting()), server.GetPort(), "testuser", "testpass", ""},
+		{"wrong password", NewSSHClientWithHostKeyCheck(nil, CreateInsecureHostKeyCallbackForTesting()), server.GetPort(), "testuser", "wrongpass", ErrorKindAuth},
+		{"host key rejected", NewSSHClientWithHostKeyCheck(nil, rejectHostKey), server.GetPort(), "testuser", "testpass", ErrorKindHostKey},
+		{"port closed", NewSSHClient(nil), closedPort, "testuser", "testpass", ErrorKindUnreachable},
+		{"invalid settings", NewSSHClient(nil), server.GetPort(), "testuser", "", ErrorKindConfig},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer tc.client.Close()
+
+			err := tc.client.TestAuthentication(context.Background(), &ConnectionInfo{
+				Host:       server.GetAddress(),
+				Port:       tc.port,
+				Username:   tc.username,
+				Password:   tc.password,
+				AuthMethod: AuthPassword,
+			})
+
+			if tc.expected == "" {
+				if err != nil {
+					t.Errorf("Expected successful authentication, got error: %v", err)
+				}
+				return
+			}
+
+			if kind := ErrorKindOf(err); kind != tc.expected {
+				t.Errorf("Expected error kind %q, got %q (%v)", tc.expected, kind, err)
+			}
+		})
+	}
+}
+
+// newTestHostKey returns a fresh host public key
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("Failed to wrap host key: %v", err)
+	}
+	return key
+}
+
+func TestSecureHostKeyCallback_Pins(t *testing.T) {
+	original, rotated := newTestHostKey(t), newTestHostKey(t)
+	pins := map[string]string{"10.0.0.1:22": HostKeyFingerprint(original)}
+	callback := createSecureHostKeyCallback(func(address string) (string, error) {
+		return pins[address], nil
+	})
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	if err := callback("10.0.0.1:22", remote, original); err != nil {
+		t.Errorf("Expected the pinned key to be accepted, got: %v", err)
+	}
+	if err := callback("10.0.0.1:22", remote, rotated); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("Expected ErrHostKeyMismatch for a key other than the pinned one, got: %v", err)
+	}
+
+	// Another port of the same host is known separately
+	if err := callback("10.0.0.1:2222", remote, rotated); err != nil {
+		t.Errorf("Expected the first key of another port to be trusted, got: %v", err)
+	}
+	if err := callback("10.0.0.1:2222", remote, original); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("Expected ErrHostKeyMismatch for a changed key, got: %v", err)
+	}
+
+	// Clearing the pin and the trusted key trusts the next key again
+	delete(pins, "10.0.0.1:22")
+	ForgetHostKey("10.0.0.1", 22)
+	if err := callback("10.0.0.1:22", remote, rotated); err != nil {
+		t.Errorf("Expected the new key to be trusted after clearing the pin, got: %v", err)
+	}
+	if err := callback("10.0.0.1:22", remote, original); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("Expected the re-trusted key to be enforced, got: %v", err)
+	}
+
+	failing := createSecureHostKeyCallback(func(string) (string, error) { return "", errors.New("database closed") })
+	if err := failing("10.0.0.9:22", remote, original); err == nil {
+		t.Error("Expected a failed pin lookup to refuse the key")
+	}
+
+	ForgetHostKey("10.0.0.1", 22)
+	ForgetHostKey("10.0.0.1", 2222)
+	if got := hostAddress("2001:db8::1", 22); got != "[2001:db8::1]:22" {
+		t.Errorf("Expected a bracketed IPv6 address, got %q", got)
+	}
+}
+
+func TestSSHClient_HostKeyPin(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer ForgetHostKey(server.GetAddress(), server.GetPort())
+
+	pin := ""
+	client := NewSSHClient(nil)
+	defer client.Close()
+	client.SetHostKeyPins(func(address string) (string, error) { return pin, nil })
+
+	connInfo := &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	}
+
+	fingerprint, err := client.ReadHostKeyFingerprint(context.Background(), connInfo)
+	if err != nil {
+		t.Fatalf("Failed to read host key fingerprint: %v", err)
+	}
+	if !strings.HasPrefix(fingerprint, "SHA256:") {
+		t.Errorf("Expected a SHA256 fingerprint, got %q", fingerprint)
+	}
+
+	pin = fingerprint
+	if err := client.TestAuthentication(context.Background(), connInfo); err != nil {
+		t.Errorf("Expected the pinned key to be accepted, got: %v", err)
+	}
+
+	pin = "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	err = client.TestAuthentication(context.Background(), connInfo)
+	if !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("Expected ErrHostKeyMismatch, got: %v", err)
+	}
+	if kind := ErrorKindOf(err); kind != ErrorKindHostKey {
+		t.Errorf("Expected error kind %q, got %q", ErrorKindHostKey, kind)
+	}
+	if _, err := client.Connect(context.Background(), connInfo); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("Expected a mismatched key not to be retried into a connection, got: %v", err)
+	}
+}
+
+func TestSSHClient_CheckHostKey(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer ForgetHostKey(server.GetAddress(), server.GetPort())
+
+	client := NewSSHClient(nil)
+	defer client.Close()
+
+	// No credentials are needed and the refused login is not an error
+	connInfo := &ConnectionInfo{Host: server.GetAddress(), Port: server.GetPort(), Username: "nobody"}
+	fingerprint, err := client.CheckHostKey(context.Background(), connInfo)
+	if err != nil {
+		t.Fatalf("Failed to check host key: %v", err)
+	}
+
+	connInfo.Username, connInfo.Password, connInfo.AuthMethod = "testuser", "testpass", AuthPassword
+	read, err := client.ReadHostKeyFingerprint(context.Background(), connInfo)
+	if err != nil {
+		t.Fatalf("Failed to read host key fingerprint: %v", err)
+	}
+	if fingerprint != read {
+		t.Errorf("Expected fingerprint %s, got %s", read, fingerprint)
+	}
+
+	connInfo.HostKeyCallback = StrictHostKeyCallback("SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+	_, err = client.CheckHostKey(context.Background(), connInfo)
+	if kind := ErrorKindOf(err); kind != ErrorKindHostKey {
+		t.Errorf("Expected error kind %q, got %q (%v)", ErrorKindHostKey, kind, err)
+	}
+
+	server.Close()
+	_, err = client.CheckHostKey(context.Background(), connInfo)
+	if err == nil || ErrorKindOf(err) == ErrorKindHostKey {
+		t.Errorf("Expected a connection error, got: %v", err)
+	}
+}
+
+func TestSSHClient_Handshake(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	server.SetAlgorithms([]string{ssh.InsecureKeyExchangeDH14SHA1}, []string{ssh.InsecureCipherAES128CBC},
+		[]string{ssh.HMACSHA1}, "SSH-2.0-Cisco-1.25")
+
+	connInfo := &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	}
+
+	config := DefaultClientConfig()
+	config.MaxRetries = 0
+
+	// CBC is not offered unless legacy algorithms are enabled
+	strict := NewSSHClientWithHostKeyCheck(config, CreateInsecureHostKeyCallbackForTesting())
+	defer strict.Close()
+	if _, err := strict.Connect(context.Background(), connInfo); err == nil {
+		t.Fatal("Expected connection without a common cipher to fail")
+	}
+
+	legacyConfig := *config
+	legacyConfig.LegacyAlgorithms = true
+	client := NewSSHClientWithHostKeyCheck(&legacyConfig, CreateInsecureHostKeyCallbackForTesting())
+	defer client.Close()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		conn, err := client.Connect(context.Background(), connInfo)
+		if err != nil {
+			t.Fatalf("Expected successful connection, got error: %v", err)
+		}
+
+		handshake := conn.Handshake()
+		want := Handshake{
+			KeyExchange:   ssh.InsecureKeyExchangeDH14SHA1,
+			HostKey:       handshake.HostKey,
+			Cipher:        ssh.InsecureCipherAES128CBC,
+			MAC:           ssh.HMACSHA1,
+			ServerVersion: "SSH-2.0-Cisco-1.25",
+		}
+		if handshake != want {
+			t.Errorf("Expected handshake %+v, got %+v", want, handshake)
+		}
+		if !strings.HasPrefix(handshake.HostKey, "rsa-sha2") {
+			t.Errorf("Expected an RSA SHA-2 host key algorithm, got %q", handshake.HostKey)
+		}
+		client.Disconnect(conn)
+	}
+
+	if !NewConnectionForTesting(Handshake{}).Handshake().IsZero() {
+		t.Error("Expected an empty handshake to be zero")
+	}
+}
+
+func TestSSHClient_InteractiveAnswerFn(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	questions := []string{"Password: ", "Duo passcode: "}
+	server.SetKeyboardInteractive("Two-factor login", questions, []string{"testpass", "123456"})
+
+	config := DefaultClientConfig()
+	config.MaxRetries = 0
+
+	for _, method := range []AuthMethod{AuthKeyboard, AuthPassword} {
+		var asked []string
+		var instruction string
+		answerFn := func(user, instr string, qs []string) ([]string, error) {
+			instruction = instr
+			asked = append(asked, qs...)
+			return []string{"testpass", "123456"}, nil
+		}
+
+		client := NewSSHClientWithHostKeyCheck(config, CreateInsecureHostKeyCallbackForTesting())
+		conn, err := client.Connect(context.Background(), &ConnectionInfo{
+			Host:                server.GetAddress(),
+			Port:                server.GetPort(),
+			Username:            "2fa-user",
+			Password:            "wrong-first-factor",
+			AuthMethod:          method,
+			InteractiveAnswerFn: answerFn,
+		})
+		if err != nil {
+			t.Fatalf("Expected keyboard-interactive login with method %d, got error: %v", method, err)
+		}
+		client.Disconnect(conn)
+		client.Close()
+
+		if strings.Join(asked, "|") != strings.Join(questions, "|") {
+			t.Errorf("Expected both questions to reach the callback, got %q", asked)
+		}
+		if instruction != "Two-factor login" {
+			t.Errorf("Expected the instruction to reach the callback, got %q", instruction)
+		}
+	}
+
+	t.Run("wrong answer", func(t *testing.T) {
+		client := NewSSHClientWithHostKeyCheck(config, CreateInsecureHostKeyCallbackForTesting())
+		defer client.Close()
+		_, err := client.Connect(context.Background(), &ConnectionInfo{
+			Host:       server.GetAddress(),
+			Port:       server.GetPort(),
+			Username:   "2fa-user",
+			AuthMethod: AuthKeyboard,
+			InteractiveAnswerFn: func(user, instruction string, questions []string) ([]string, error) {
+				return []string{"testpass", "000000"}, nil
+			},
+		})
+		if kind := ErrorKindOf(err); kind != ErrorKindAuth {
+			t.Errorf("Expected error kind %q, got %q (%v)", ErrorKindAuth, kind, err)
+		}
+	})
+
+	t.Run("missing answers", func(t *testing.T) {
+		client := NewSSHClientWithHostKeyCheck(config, CreateInsecureHostKeyCallbackForTesting())
+		defer client.Close()
+		_, err := client.Connect(context.Background(), &ConnectionInfo{
+			Host:       server.GetAddress(),
+			Port:       server.GetPort(),
+			Username:   "2fa-user",
+			AuthMethod: AuthKeyboard,
+			InteractiveAnswerFn: func(user, instruction string, questions []string) ([]string, error) {
+				return []string{"testpass"}, nil
+			},
+		})
+		if err == nil {
+			t.Error("Expected login to fail when a question is left unanswered")
+		}
+	})
+}
+
+func TestSSHClient_InteractiveLoginNotRetried(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	server.SetKeyboardInteractive("Two-factor login", []string{"Duo passcode: "}, []string{"123456"})
+
+	config := DefaultClientConfig()
+	config.MaxRetries = 2
+	config.RetryDelay = time.Millisecond
+	client := NewSSHClientWithHostKeyCheck(config, CreateInsecureHostKeyCallbackForTesting())
+	defer client.Close()
+
+	// Abandoning the prompt fails the handshake with an error that would
+	// otherwise be retried
+	asked := 0
+	_, err = client.Connect(context.Background(), &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "2fa-user",
+		AuthMethod: AuthKeyboard,
+		InteractiveAnswerFn: func(user, instruction string, questions []string) ([]string, error) {
+			asked++
+			return nil, fmt.Errorf("login cancelled")
+		},
+	})
+	if err == nil {
+		t.Fatal("Expected the cancelled login to fail")
+	}
+	if asked != 1 {
+		t.Errorf("Expected the user to be asked once, got %d", asked)
+	}
+}
+
+func TestSSHClient_Connect_AuthFailureNotRetried(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	config := DefaultClientConfig()
+	config.RetryDelay = time.Second
+	client := NewSSHClient(config)
+	defer client.Close()
+
+	startTime := time.Now()
+	_, err = client.Connect(context.Background(), &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "wrongpass",
+		AuthMethod: AuthPassword,
+	})
+
+	if ErrorKindOf(err) != ErrorKindAuth {
+		t.Errorf("Expected auth error, got %v", err)
+	}
+	if duration := time.Since(startTime); duration >= config.RetryDelay {
+		t.Errorf("Expected auth failure to return without retrying, took %v", duration)
+	}
+}
+
+// Benchmark tests
+
+func BenchmarkSSHClient_Connect(b *testing.B) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		b.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	client := NewSSHClient(nil)
+	defer client.Close()
+
+	connInfo := &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	}
+
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn, err := client.Connect(ctx, connInfo)
+		if err != nil {
+			b.Errorf("Connection failed: %v", err)
+		}
+		if conn != nil {
+			client.Disconnect(conn)
+		}
+	}
+}
+
+func BenchmarkSSHClient_ExecuteCommand(b *testing.B) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		b.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	server.SetCommandResponse("test command", "test response")
+
+	client := NewSSHClient(nil)
+	defer client.Close()
+
+	connInfo := &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	}
+
+	ctx := context.Background()
+	conn, err := client.Connect(ctx, connInfo)
+	if err != nil {
+		b.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Disconnect(conn)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := client.ExecuteCommand(ctx, conn, "test command")
+		if err != nil {
+			b.Errorf("Command execution failed: %v", err)
+		}
+	}
+}
+
+func TestSSHClient_PhaseTimings(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	banner, kex, auth := 50*time.Millisecond, 150*time.Millisecond, 300*time.Millisecond
+	server.SetHandshakeDelays(banner, kex, auth)
+	server.SetDelay(100 * time.Millisecond)
+	server.SetCommandResponse("show version", "Cisco IOS")
+
+	client := NewSSHClient(nil)
+	defer client.Close()
+
+	conn, err := client.Connect(context.Background(), &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect(conn)
+
+	// Each delay must land in its own phase and not spill into the next
+	timings := conn.Timings()
+	within := func(phase string, min, max time.Duration) {
+		if got := timings.Get(phase); got < min || got >= max {
+			t.Errorf("Expected %s between %v and %v, got %v", phase, min, max, got)
+		}
+	}
+	within(PhaseDial, 0, banner)
+	within(PhaseBanner, banner, kex)
+	within(PhaseKeyExchange, kex, auth)
+	within(PhaseAuth, auth, 2*auth)
+	if timings.Dial <= 0 {
+		t.Error("Expected the dial to be timed")
+	}
+	if timings.Session != 0 || timings.Command != 0 {
+		t.Errorf("Connection timings should not include command phases: %v", timings)
+	}
+
+	result, err := client.ExecuteCommand(context.Background(), conn, "show version")
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.Timings.Session <= 0 {
+		t.Error("Expected the session open to be timed")
+	}
+	if result.Timings.Command < 100*time.Millisecond {
+		t.Errorf("Expected the command phase to include the server delay, got %v", result.Timings.Command)
+	}
+	if result.Timings.Session+result.Timings.Command > result.Duration {
+		t.Errorf("Phases %v exceed the command duration %v", result.Timings, result.Duration)
+	}
+
+	recent := client.RecentConnectionTimings(server.GetAddress(), server.GetPort())
+	if len(recent) != 1 {
+		t.Fatalf("Expected 1 recorded attempt, got %d", len(recent))
+	}
+	if recent[0].Phases != timings || recent[0].Attempt != 1 || recent[0].FailedPhase != "" {
+		t.Errorf("Unexpected recorded attempt: %+v", recent[0])
+	}
+}
+
+func TestSSHClient_RetryErrorListsAttempts(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	server.SetShouldFail(true)
+
+	client := NewSSHClient(&ClientConfig{
+		ConnectTimeout: 2 * time.Second,
+		MaxRetries:     1,
+		RetryDelay:     time.Millisecond,
+		MaxConnections: 1,
+		ConnectionTTL:  time.Minute,
+	})
+	defer client.Close()
+
+	_, err = client.Connect(context.Background(), &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	})
+	if err == nil {
+		t.Fatal("Expected connection to fail")
+	}
+	for _, want := range []string{"failed to connect after 2 attempts", "attempt 1: dial=", "attempt 2: dial=", "failed in banner"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got: %v", want, err)
+		}
+	}
+
+	recent := client.RecentConnectionTimings(server.GetAddress(), server.GetPort())
+	if len(recent) != 2 {
+		t.Fatalf("Expected 2 recorded attempts, got %d", len(recent))
+	}
+	for i, attempt := range recent {
+		if attempt.Attempt != i+1 || attempt.FailedPhase != PhaseBanner || attempt.Error == "" {
+			t.Errorf("Unexpected attempt %d: %+v", i+1, attempt)
+		}
+	}
+}
+
+func TestSSHClient_TimingWindow(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+
+	config := DefaultClientConfig()
+	config.TimingWindow = 2
+	client := NewSSHClient(config)
+	defer client.Close()
+
+	connInfo := &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	}
+	var started []time.Time
+	for i := 0; i < 3; i++ {
+		started = append(started, time.Now())
+		if err := client.TestAuthentication(context.Background(), connInfo); err != nil {
+			t.Fatalf("TestAuthentication failed: %v", err)
+		}
+	}
+
+	recent := client.RecentConnectionTimings(server.GetAddress(), server.GetPort())
+	if len(recent) != 2 {
+		t.Fatalf("Expected the window to keep 2 attempts, got %d", len(recent))
+	}
+	if recent[0].StartedAt.Before(started[1]) {
+		t.Error("Expected the oldest attempt to be dropped")
+	}
+	if other := client.RecentConnectionTimings("10.0.0.1", 22); len(other) != 0 {
+		t.Errorf("Expected no attempts for another host, got %d", len(other))
+	}
+}
+
+func TestSSHClient_ExecuteCommandWithPager(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	server.SetPagedResponse("show running-config", " --More-- ",
+		"hostname core\ninterface Gi0/1\n", " description uplink\n!\n", "end\n")
+
+	client := NewSSHClient(nil)
+	defer client.Close()
+
+	conn, err := client.Connect(context.Background(), &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect(conn)
+
+	result, err := client.ExecuteCommandWithPager(context.Background(), conn, "show running-config",
+		DefaultPagerConfigs()["cisco"])
+	if err != nil {
+		t.Fatalf("ExecuteCommandWithPager failed: %v", err)
+	}
+
+	want := "hostname core\ninterface Gi0/1\n description uplink\n!\nend\n"
+	if result.Output != want {
+		t.Errorf("Expected output without pager prompts %q, got %q", want, result.Output)
+	}
+	if answers := server.PagerAnswers(); len(answers) != 2 || answers[0] != " " || answers[1] != " " {
+		t.Errorf("Expected each prompt to be answered with a space, got %q", answers)
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("Expected exit code 0, got %d", result.ExitCode)
+	}
+
+	if _, err := client.ExecuteCommandWithPager(context.Background(), conn, "show version",
+		VendorPagerConfig{PromptPattern: "(", ClearCommand: " "}); err == nil {
+		t.Error("Expected an invalid prompt pattern to be rejected")
+	}
+}
+
+func TestSSHClient_ConnectionHostKeyCallback(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer ForgetHostKey(server.GetAddress(), server.GetPort())
+
+	// The client's own verification refuses every key, so only the
+	// connection's callback can let a connection through
+	client := NewSSHClient(nil)
+	defer client.Close()
+	client.SetHostKeyPins(func(address string) (string, error) {
+		return "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", nil
+	})
+
+	connInfo := &ConnectionInfo{
+		Host:            server.GetAddress(),
+		Port:            server.GetPort(),
+		Username:        "testuser",
+		Password:        "testpass",
+		AuthMethod:      AuthPassword,
+		HostKeyCallback: InsecureHostKeyCallback(),
+	}
+	fingerprint, err := client.ReadHostKeyFingerprint(context.Background(), connInfo)
+	if err != nil {
+		t.Fatalf("Expected the insecure policy to accept any key, got: %v", err)
+	}
+
+	connInfo.HostKeyCallback = StrictHostKeyCallback(fingerprint)
+	if err := client.TestAuthentication(context.Background(), connInfo); err != nil {
+		t.Errorf("Expected the strict policy to accept the expected key, got: %v", err)
+	}
+
+	for _, expected := range []string{"SHA256:BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB", ""} {
+		connInfo.HostKeyCallback = StrictHostKeyCallback(expected)
+		err := client.TestAuthentication(context.Background(), connInfo)
+		if !errors.Is(err, ErrHostKeyMismatch) {
+			t.Errorf("Expected ErrHostKeyMismatch for expected fingerprint %q, got: %v", expected, err)
+		}
+		if kind := ErrorKindOf(err); kind != ErrorKindHostKey {
+			t.Errorf("Expected error kind %q, got %q", ErrorKindHostKey, kind)
+		}
+	}
+
+	connInfo.HostKeyCallback = nil
+	if err := client.TestAuthentication(context.Background(), connInfo); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("Expected the client's verification without a callback, got: %v", err)
 	}
 }
@@ -0,0 +1,85 @@
+package ssh
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrorKind classifies why an SSH operation failed
+type ErrorKind string
+
+const (
+	ErrorKindConfig      ErrorKind = "config"
+	ErrorKindUnreachable ErrorKind = "unreachable"
+	ErrorKindTimeout     ErrorKind = "timeout"
+	ErrorKindAuth        ErrorKind = "auth"
+	ErrorKindHostKey     ErrorKind = "host_key"
+	ErrorKindProtocol    ErrorKind = "protocol"
+)
+
+// ErrHostKeyMismatch is returned when a device presents a host key other
+// than the one pinned for it or trusted on first use
+var ErrHostKeyMismatch = errors.New("host key mismatch")
+
+// SSHError wraps an SSH failure with its kind
+type SSHError struct {
+	Kind ErrorKind
+	Host string
+	Err  error
+}
+
+func (e *SSHError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SSHError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKindOf returns the kind of an SSH error, or an empty kind for other errors
+func ErrorKindOf(err error) ErrorKind {
+	var sshErr *SSHError
+	if errors.As(err, &sshErr) {
+		return sshErr.Kind
+	}
+	return ""
+}
+
+// isRetryable reports whether another connection attempt could succeed.
+// Retrying bad credentials or a rejected host key only risks locking the account.
+func isRetryable(err error) bool {
+	switch ErrorKindOf(err) {
+	case ErrorKindAuth, ErrorKindHostKey, ErrorKindConfig:
+		return false
+	default:
+		return true
+	}
+}
+
+// classifyDialError maps a TCP dial failure to an SSH error kind
+func classifyDialError(host string, err error) *SSHError {
+	kind := ErrorKindUnreachable
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		kind = ErrorKindTimeout
+	}
+	return &SSHError{Kind: kind, Host: host, Err: err}
+}
+
+// classifyHandshakeError maps an SSH handshake failure to an SSH error kind
+func classifyHandshakeError(host string, err error) *SSHError {
+	if kind := ErrorKindOf(err); kind != "" {
+		return &SSHError{Kind: kind, Host: host, Err: err}
+	}
+
+	kind := ErrorKindProtocol
+	var netErr net.Error
+	switch {
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		kind = ErrorKindAuth
+	case errors.As(err, &netErr) && netErr.Timeout():
+		kind = ErrorKindTimeout
+	}
+	return &SSHError{Kind: kind, Host: host, Err: err}
+}
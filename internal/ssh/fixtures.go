@@ -0,0 +1,137 @@
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FixtureFormatVersion is the version of the session fixture file format.
+// Bump it whenever a change to SessionFixture would be misread by older code.
+const FixtureFormatVersion = 1
+
+// fixtureExt is the file extension of session fixture files
+const fixtureExt = ".json"
+
+// RedactedValue replaces secrets found in recorded command output
+const RedactedValue = "<redacted>"
+
+// SessionFixture holds the commands recorded from one device
+type SessionFixture struct {
+	Version    int               `json:"version"`
+	Host       string            `json:"host"`
+	Port       int               `json:"port"`
+	RecordedAt time.Time         `json:"recordedAt"`
+	Commands   []RecordedCommand `json:"commands"`
+}
+
+// RecordedCommand is one command and the output the device returned for it.
+// Output is the combined output and Stderr the part of it that came from
+// standard error, at its end.
+type RecordedCommand struct {
+	Command  string        `json:"command"`
+	Output   string        `json:"output"`
+	Stderr   string        `json:"stderr,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	ExitCode int           `json:"exitCode"`
+	Duration time.Duration `json:"duration"`
+}
+
+// redactionPatterns match secrets in device output. The first group is kept
+// and the rest of the match is replaced with RedactedValue.
+var redactionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(\b(?:secret|password)[ \t]+(?:\d+[ \t]+)?)\S+`),
+	regexp.MustCompile(`(\bsnmp-server community[ \t]+)\S+`),
+	regexp.MustCompile(`(\b(?:encrypted-password|pre-shared-key)[ \t]+)\S+`),
+	regexp.MustCompile(`(\bauthentication-key[ \t]+\d+[ \t]+md5[ \t]+)\S+`),
+}
+
+// RedactOutput masks passwords, secrets and keys in command output
+func RedactOutput(output string) string {
+	for _, pattern := range redactionPatterns {
+		output = pattern.ReplaceAllString(output, "${1}"+RedactedValue)
+	}
+	return output
+}
+
+// fixtureKey identifies the device a fixture belongs to
+func fixtureKey(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// FixtureFileName returns the file name a device's fixture is stored under
+func FixtureFileName(host string, port int) string {
+	name := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(host)
+	return fmt.Sprintf("%s_%d%s", name, port, fixtureExt)
+}
+
+// SaveFixture writes a fixture to dir and returns the file path
+func SaveFixture(dir string, fixture *SessionFixture) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode fixture: %w", err)
+	}
+
+	path := filepath.Join(dir, FixtureFileName(fixture.Host, fixture.Port))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write fixture: %w", err)
+	}
+
+	return path, nil
+}
+
+// LoadFixture reads a fixture file, rejecting versions this build cannot read
+func LoadFixture(path string) (*SessionFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var fixture SessionFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to decode fixture %s: %w", path, err)
+	}
+
+	if fixture.Version < 1 || fixture.Version > FixtureFormatVersion {
+		return nil, fmt.Errorf("fixture %s has unsupported format version %d (supported: 1 to %d)",
+			path, fixture.Version, FixtureFormatVersion)
+	}
+	if fixture.Host == "" || fixture.Port <= 0 {
+		return nil, fmt.Errorf("fixture %s does not name a device", path)
+	}
+
+	return &fixture, nil
+}
+
+// LoadFixtures reads every fixture file in dir
+func LoadFixtures(dir string) ([]*SessionFixture, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture directory: %w", err)
+	}
+
+	var fixtures []*SessionFixture
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != fixtureExt {
+			continue
+		}
+
+		fixture, err := LoadFixture(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, fixture)
+	}
+
+	return fixtures, nil
+}
@@ -0,0 +1,165 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyboardResponse answers the keyboard-interactive prompts that match
+// Prompt, a regular expression matched case-insensitively against the
+// prompt text. Responses are secret unless AllowEcho is set, and a secret
+// is never sent to a prompt that echoes what is typed.
+type KeyboardResponse struct {
+	Prompt    string `json:"prompt"`
+	Response  string `json:"-"`
+	AllowEcho bool   `json:"allowEcho"`
+}
+
+// ErrUnexpectedPrompt is returned when a device asks a keyboard-interactive
+// question that no response matches and nothing else can answer
+var ErrUnexpectedPrompt = errors.New("unexpected keyboard-interactive prompt")
+
+// ErrEchoedSecret is returned instead of sending a secret response to a
+// prompt that echoes input
+var ErrEchoedSecret = errors.New("refusing to send a secret to a prompt that echoes input")
+
+// defaultPasswordPrompt is the prompt the login password answers when no
+// configured response matches first
+const defaultPasswordPrompt = `password`
+
+// maxPromptLength bounds the prompt text quoted in errors
+const maxPromptLength = 64
+
+// terminalEscape matches the terminal escape sequences a prompt may carry,
+// such as colours
+var terminalEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// keyboardMatcher is a keyboard response with its prompt compiled
+type keyboardMatcher struct {
+	prompt   *regexp.Regexp
+	response KeyboardResponse
+}
+
+// keyboardResponses returns the responses answering connInfo's prompts: the
+// configured ones, then the password for password prompts. When only an
+// answer function is configured, the user answers every prompt instead.
+func keyboardResponses(connInfo *ConnectionInfo) []KeyboardResponse {
+	responses := connInfo.KeyboardResponses
+	if connInfo.Password == "" || (len(responses) == 0 && connInfo.InteractiveAnswerFn != nil) {
+		return responses
+	}
+	password := KeyboardResponse{Prompt: defaultPasswordPrompt, Response: connInfo.Password}
+	return append(append([]KeyboardResponse{}, responses...), password)
+}
+
+// ValidateKeyboardResponses checks that every response has a prompt pattern
+// that compiles
+func ValidateKeyboardResponses(responses []KeyboardResponse) error {
+	_, err := compileKeyboardResponses(responses)
+	return err
+}
+
+// compileKeyboardResponses compiles the prompt pattern of each response
+func compileKeyboardResponses(responses []KeyboardResponse) ([]keyboardMatcher, error) {
+	matchers := make([]keyboardMatcher, 0, len(responses))
+	for i, response := range responses {
+		if strings.TrimSpace(response.Prompt) == "" {
+			return nil, fmt.Errorf("keyboard response %d has no prompt", i+1)
+		}
+		prompt, err := regexp.Compile("(?i)" + response.Prompt)
+		if err != nil {
+			return nil, fmt.Errorf("keyboard response %d has an invalid prompt pattern: %w", i+1, err)
+		}
+		matchers = append(matchers, keyboardMatcher{prompt: prompt, response: response})
+	}
+	return matchers, nil
+}
+
+// keyboardChallenge answers keyboard-interactive questions from the
+// configured responses, the first matching response answering each
+// question. Questions no response matches go to the answer function, or
+// fail the login when there is none.
+func keyboardChallenge(address string, responses []KeyboardResponse, answer InteractiveAnswerFunc) (ssh.KeyboardInteractiveChallenge, error) {
+	matchers, err := compileKeyboardResponses(responses)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		// Servers may send an empty round, e.g. to show an instruction
+		if len(questions) == 0 {
+			return []string{}, nil
+		}
+
+		answers := make([]string, len(questions))
+		var unanswered []int
+		for i, question := range questions {
+			response, ok := matchKeyboardResponse(matchers, question)
+			if !ok {
+				unanswered = append(unanswered, i)
+				continue
+			}
+			if i < len(echos) && echos[i] && !response.AllowEcho {
+				return nil, &SSHError{Kind: ErrorKindAuth, Host: address,
+					Err: fmt.Errorf("device asked %s: %w", sanitizePrompt(question), ErrEchoedSecret)}
+			}
+			answers[i] = response.Response
+		}
+		if len(unanswered) == 0 {
+			return answers, nil
+		}
+
+		if answer == nil {
+			return nil, &SSHError{Kind: ErrorKindAuth, Host: address,
+				Err: fmt.Errorf("device asked %s: %w", sanitizePrompt(questions[unanswered[0]]), ErrUnexpectedPrompt)}
+		}
+		asked := make([]string, len(unanswered))
+		for i, index := range unanswered {
+			asked[i] = questions[index]
+		}
+		given, err := interactiveChallenge(answer)(user, instruction, asked, nil)
+		if err != nil {
+			return nil, err
+		}
+		for i, index := range unanswered {
+			answers[index] = given[i]
+		}
+		return answers, nil
+	}, nil
+}
+
+// matchKeyboardResponse returns the first response whose prompt matches
+func matchKeyboardResponse(matchers []keyboardMatcher, question string) (KeyboardResponse, bool) {
+	question = strings.TrimSpace(question)
+	for _, matcher := range matchers {
+		if matcher.prompt.MatchString(question) {
+			return matcher.response, true
+		}
+	}
+	return KeyboardResponse{}, false
+}
+
+// sanitizePrompt quotes prompt text for an error message, dropping escape
+// sequences and control characters, collapsing whitespace and shortening
+// long prompts
+func sanitizePrompt(prompt string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, terminalEscape.ReplaceAllString(prompt, ""))
+	cleaned = strings.Join(strings.Fields(cleaned), " ")
+	if runes := []rune(cleaned); len(runes) > maxPromptLength {
+		cleaned = string(runes[:maxPromptLength]) + "..."
+	}
+	return fmt.Sprintf("%q", cleaned)
+}
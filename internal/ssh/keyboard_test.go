@@ -0,0 +1,157 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"invictux-demo/internal/ssh/sshtest"
+)
+
+// keyboardLogin connects to server with method as 2fa-user without retrying
+func keyboardLogin(t *testing.T, server *sshtest.Server, method AuthMethod, connInfo ConnectionInfo) error {
+	t.Helper()
+	config := DefaultClientConfig()
+	config.MaxRetries = 0
+	client := NewSSHClientWithHostKeyCheck(config, CreateInsecureHostKeyCallbackForTesting())
+	defer client.Close()
+
+	connInfo.Host = server.GetAddress()
+	connInfo.Port = server.GetPort()
+	connInfo.Username = "2fa-user"
+	connInfo.AuthMethod = method
+	conn, err := client.Connect(context.Background(), &connInfo)
+	if err == nil {
+		client.Disconnect(conn)
+	}
+	return err
+}
+
+func newKeyboardServer(t *testing.T, rounds ...sshtest.KeyboardRound) *sshtest.Server {
+	t.Helper()
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	server.SetKeyboardScript(rounds...)
+	return server
+}
+
+func TestSSHClient_KeyboardResponsesMultiPrompt(t *testing.T) {
+	server := newKeyboardServer(t,
+		sshtest.KeyboardRound{Questions: []string{"Password: "}, Answers: []string{"testpass"}},
+		sshtest.KeyboardRound{Instruction: "Second factor", Questions: []string{"Account: ", "Verification code: "},
+			Echos: []bool{true, false}, Answers: []string{"ops", "123456"}},
+	)
+
+	err := keyboardLogin(t, server, AuthKeyboard, ConnectionInfo{
+		Password: "testpass",
+		KeyboardResponses: []KeyboardResponse{
+			{Prompt: `^verification code`, Response: "123456"},
+			{Prompt: `^account`, Response: "ops", AllowEcho: true},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected the scripted login to succeed, got: %v", err)
+	}
+	if got := strings.Join(server.KeyboardAnswers(), "|"); got != "testpass|ops|123456" {
+		t.Errorf("Expected each prompt to get its own response, got %q", got)
+	}
+
+	// Password logins fall back to keyboard-interactive when responses are set
+	server = newKeyboardServer(t, sshtest.KeyboardRound{Questions: []string{"Verification code: "}, Answers: []string{"654321"}})
+	err = keyboardLogin(t, server, AuthPassword, ConnectionInfo{
+		Password:          "wrong-first-factor",
+		KeyboardResponses: []KeyboardResponse{{Prompt: `code`, Response: "654321"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected the password login to fall back to keyboard-interactive, got: %v", err)
+	}
+}
+
+func TestSSHClient_KeyboardUnexpectedPrompt(t *testing.T) {
+	server := newKeyboardServer(t, sshtest.KeyboardRound{
+		Questions: []string{"\x1b[31mSecurity\tquestion:\x07 first pet? "},
+		Answers:   []string{"rex"},
+	})
+
+	err := keyboardLogin(t, server, AuthKeyboard, ConnectionInfo{
+		Password:          "testpass",
+		KeyboardResponses: []KeyboardResponse{{Prompt: `code`, Response: "123456"}},
+	})
+	if !errors.Is(err, ErrUnexpectedPrompt) {
+		t.Fatalf("Expected ErrUnexpectedPrompt, got: %v", err)
+	}
+	if kind := ErrorKindOf(err); kind != ErrorKindAuth {
+		t.Errorf("Expected error kind %q, got %q", ErrorKindAuth, kind)
+	}
+	if !strings.Contains(err.Error(), `"Security question: first pet?"`) {
+		t.Errorf("Expected the sanitized prompt in the error, got: %v", err)
+	}
+	if answers := server.KeyboardAnswers(); len(answers) != 0 {
+		t.Errorf("Expected nothing to be sent to an unexpected prompt, got %q", answers)
+	}
+}
+
+func TestSSHClient_KeyboardEchoProtection(t *testing.T) {
+	server := newKeyboardServer(t, sshtest.KeyboardRound{
+		Questions: []string{"Password: "},
+		Echos:     []bool{true},
+		Answers:   []string{"testpass"},
+	})
+
+	err := keyboardLogin(t, server, AuthKeyboard, ConnectionInfo{Password: "testpass"})
+	if !errors.Is(err, ErrEchoedSecret) {
+		t.Fatalf("Expected ErrEchoedSecret, got: %v", err)
+	}
+	if answers := server.KeyboardAnswers(); len(answers) != 0 {
+		t.Errorf("Expected the password not to be sent to an echoing prompt, got %q", answers)
+	}
+
+	err = keyboardLogin(t, server, AuthKeyboard, ConnectionInfo{
+		KeyboardResponses: []KeyboardResponse{{Prompt: `password`, Response: "testpass"}},
+	})
+	if !errors.Is(err, ErrEchoedSecret) {
+		t.Errorf("Expected configured responses to be secret by default, got: %v", err)
+	}
+}
+
+func TestSSHClient_KeyboardPasswordOnly(t *testing.T) {
+	server := newKeyboardServer(t, sshtest.KeyboardRound{Questions: []string{"Password: "}, Answers: []string{"testpass"}})
+
+	if err := keyboardLogin(t, server, AuthKeyboard, ConnectionInfo{Password: "testpass"}); err != nil {
+		t.Fatalf("Expected the password to answer the password prompt, got: %v", err)
+	}
+
+	// The password no longer answers prompts that are not password prompts
+	server = newKeyboardServer(t, sshtest.KeyboardRound{Questions: []string{"Passcode: "}, Answers: []string{"testpass"}})
+	if err := keyboardLogin(t, server, AuthKeyboard, ConnectionInfo{Password: "testpass"}); !errors.Is(err, ErrUnexpectedPrompt) {
+		t.Errorf("Expected ErrUnexpectedPrompt for a passcode prompt, got: %v", err)
+	}
+}
+
+func TestSSHClient_KeyboardInvalidPrompt(t *testing.T) {
+	server := newKeyboardServer(t)
+	err := keyboardLogin(t, server, AuthKeyboard, ConnectionInfo{KeyboardResponses: []KeyboardResponse{{Prompt: `([`, Response: "x"}}})
+	if kind := ErrorKindOf(err); kind != ErrorKindConfig {
+		t.Errorf("Expected error kind %q for an invalid prompt pattern, got %q (%v)", ErrorKindConfig, kind, err)
+	}
+	if err := ValidateKeyboardResponses([]KeyboardResponse{{Prompt: " "}}); err == nil {
+		t.Error("Expected a response without a prompt to be rejected")
+	}
+}
+
+func TestSanitizePrompt(t *testing.T) {
+	tests := map[string]string{
+		"Password: ":                      `"Password:"`,
+		"\x1b[1;32mToken\x1b[0m\r\n code": `"Token code"`,
+		strings.Repeat("x", 100):          `"` + strings.Repeat("x", maxPromptLength) + `..."`,
+	}
+	for prompt, want := range tests {
+		if got := sanitizePrompt(prompt); got != want {
+			t.Errorf("sanitizePrompt(%q) = %s, want %s", prompt, got, want)
+		}
+	}
+}
@@ -19,6 +19,9 @@ type DeviceConnection struct {
 	Port     int
 	Username string
 	Password string
+
+	// KeyboardResponses answer the device's keyboard-interactive prompts
+	KeyboardResponses []KeyboardResponse
 }
 
 // DeviceSSHManagerInterface defines the interface for device SSH operations
@@ -57,6 +60,8 @@ func (m *DeviceSSHManager) ConnectToDevice(ctx context.Context, device *DeviceCo
 		Username:   device.Username,
 		Password:   device.Password,
 		AuthMethod: AuthPassword, // Default to password authentication
+
+		KeyboardResponses: device.KeyboardResponses,
 	}
 
 	return m.client.Connect(ctx, connInfo)
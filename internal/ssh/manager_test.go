@@ -5,6 +5,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"invictux-demo/internal/ssh/sshtest"
 )
 
 func TestNewDeviceSSHManager(t *testing.T) {
@@ -33,7 +35,7 @@ func TestNewDeviceSSHManagerWithDefaults(t *testing.T) {
 }
 
 func TestDeviceSSHManager_ConnectToDevice_Success(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -90,7 +92,7 @@ func TestDeviceSSHManager_ConnectToDevice_NilDevice(t *testing.T) {
 }
 
 func TestDeviceSSHManager_ExecuteDeviceCommand_Success(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -137,7 +139,7 @@ func TestDeviceSSHManager_ExecuteDeviceCommand_Success(t *testing.T) {
 }
 
 func TestDeviceSSHManager_ExecuteDeviceCommands_Success(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -185,7 +187,7 @@ func TestDeviceSSHManager_ExecuteDeviceCommands_Success(t *testing.T) {
 }
 
 func TestDeviceSSHManager_TestDeviceConnectivity_Success(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -241,13 +243,13 @@ func TestDeviceSSHManager_TestDeviceConnectivity_ConnectionFailure(t *testing.T)
 }
 
 func TestDeviceSSHManager_BatchExecuteOnDevices_Success(t *testing.T) {
-	server1, err := NewMockSSHServer()
+	server1, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server 1: %v", err)
 	}
 	defer server1.Close()
 
-	server2, err := NewMockSSHServer()
+	server2, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server 2: %v", err)
 	}
@@ -372,7 +374,7 @@ func TestDeviceSSHManager_BatchExecuteOnDevices_EmptyCommands(t *testing.T) {
 }
 
 func TestDeviceSSHManager_ExecuteCommandWithTimeout(t *testing.T) {
-	server, err := NewMockSSHServer()
+	server, err := sshtest.NewServer()
 	if err != nil {
 		t.Fatalf("Failed to create mock server: %v", err)
 	}
@@ -0,0 +1,289 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phases of opening a connection and running a command
+const (
+	PhaseDial        = "dial"
+	PhaseBanner      = "banner"
+	PhaseKeyExchange = "key_exchange"
+	PhaseAuth        = "auth"
+	PhaseSession     = "session"
+	PhaseCommand     = "command"
+)
+
+// Phases lists the phases in the order they happen
+var Phases = []string{PhaseDial, PhaseBanner, PhaseKeyExchange, PhaseAuth, PhaseSession, PhaseCommand}
+
+// DefaultTimingWindow is how many connection attempts per host are kept in
+// memory when ClientConfig.TimingWindow is not set
+const DefaultTimingWindow = 20
+
+// PhaseTimings holds how long each phase took. Dial includes resolving the
+// host name, and key exchange ends when the host key has been verified.
+// Phases that were not reached are zero. MarshalJSON encodes the durations
+// in milliseconds.
+type PhaseTimings struct {
+	Dial        time.Duration
+	Banner      time.Duration
+	KeyExchange time.Duration
+	Auth        time.Duration
+	Session     time.Duration
+	Command     time.Duration
+}
+
+// Get returns the duration of a phase
+func (t PhaseTimings) Get(phase string) time.Duration {
+	if field := t.field(phase); field != nil {
+		return *field
+	}
+	return 0
+}
+
+// field returns a pointer to the duration of a phase, or nil for an unknown phase
+func (t *PhaseTimings) field(phase string) *time.Duration {
+	switch phase {
+	case PhaseDial:
+		return &t.Dial
+	case PhaseBanner:
+		return &t.Banner
+	case PhaseKeyExchange:
+		return &t.KeyExchange
+	case PhaseAuth:
+		return &t.Auth
+	case PhaseSession:
+		return &t.Session
+	case PhaseCommand:
+		return &t.Command
+	}
+	return nil
+}
+
+// Handshake returns the time from the end of the dial until the login was
+// accepted: the banner, key exchange and auth phases together
+func (t PhaseTimings) Handshake() time.Duration {
+	return t.Banner + t.KeyExchange + t.Auth
+}
+
+// Merge returns t with the phases timed in other replacing its own
+func (t PhaseTimings) Merge(other PhaseTimings) PhaseTimings {
+	for _, phase := range Phases {
+		if d := other.Get(phase); d != 0 {
+			*t.field(phase) = d
+		}
+	}
+	return t
+}
+
+// IsZero reports whether no phase was timed
+func (t PhaseTimings) IsZero() bool {
+	return t == PhaseTimings{}
+}
+
+// String lists the timed phases, as in "dial=3ms banner=1.2s"
+func (t PhaseTimings) String() string {
+	var parts []string
+	for _, phase := range Phases {
+		if d := t.Get(phase); d != 0 {
+			parts = append(parts, fmt.Sprintf("%s=%s", phase, d.Round(time.Millisecond)))
+		}
+	}
+	if len(parts) == 0 {
+		return "no phases timed"
+	}
+	return strings.Join(parts, " ")
+}
+
+// phaseTimingsJSON is the JSON encoding of PhaseTimings
+type phaseTimingsJSON struct {
+	DialMs        int64 `json:"dialMs"`
+	BannerMs      int64 `json:"bannerMs"`
+	KeyExchangeMs int64 `json:"keyExchangeMs"`
+	AuthMs        int64 `json:"authMs"`
+	SessionMs     int64 `json:"sessionMs"`
+	CommandMs     int64 `json:"commandMs"`
+}
+
+// MarshalJSON encodes each phase in milliseconds
+func (t PhaseTimings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(phaseTimingsJSON{
+		DialMs:        t.Dial.Milliseconds(),
+		BannerMs:      t.Banner.Milliseconds(),
+		KeyExchangeMs: t.KeyExchange.Milliseconds(),
+		AuthMs:        t.Auth.Milliseconds(),
+		SessionMs:     t.Session.Milliseconds(),
+		CommandMs:     t.Command.Milliseconds(),
+	})
+}
+
+// UnmarshalJSON decodes timings encoded by MarshalJSON
+func (t *PhaseTimings) UnmarshalJSON(data []byte) error {
+	var decoded phaseTimingsJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*t = PhaseTimings{
+		Dial:        time.Duration(decoded.DialMs) * time.Millisecond,
+		Banner:      time.Duration(decoded.BannerMs) * time.Millisecond,
+		KeyExchange: time.Duration(decoded.KeyExchangeMs) * time.Millisecond,
+		Auth:        time.Duration(decoded.AuthMs) * time.Millisecond,
+		Session:     time.Duration(decoded.SessionMs) * time.Millisecond,
+		Command:     time.Duration(decoded.CommandMs) * time.Millisecond,
+	}
+	return nil
+}
+
+// ConnectionAttempt is the timing of one attempt to open a connection.
+// FailedPhase names the phase a failed attempt stopped in.
+type ConnectionAttempt struct {
+	Host        string       `json:"host"`
+	Attempt     int          `json:"attempt"`
+	StartedAt   time.Time    `json:"startedAt"`
+	Phases      PhaseTimings `json:"phases"`
+	FailedPhase string       `json:"failedPhase,omitempty"`
+	Error       string       `json:"error,omitempty"`
+
+	// AuthMethod names the authentication method the device accepted
+	AuthMethod string `json:"authMethod,omitempty"`
+}
+
+// String describes where the attempt spent its time
+func (a ConnectionAttempt) String() string {
+	s := fmt.Sprintf("attempt %d: %s", a.Attempt, a.Phases)
+	if a.FailedPhase != "" {
+		s += ", failed in " + a.FailedPhase
+	}
+	return s
+}
+
+// describeAttempts joins the descriptions of several attempts
+func describeAttempts(attempts []ConnectionAttempt) string {
+	parts := make([]string, len(attempts))
+	for i, attempt := range attempts {
+		parts[i] = attempt.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// phaseClock times the phases of a connection attempt. Phases end in order;
+// ending a phase that is not the current one does nothing, as the banner and
+// host key are seen from the handshake goroutines of x/crypto.
+type phaseClock struct {
+	mutex   sync.Mutex
+	current string
+	since   time.Time
+	timings PhaseTimings
+}
+
+// newPhaseClock starts timing the dial phase
+func newPhaseClock() *phaseClock {
+	return &phaseClock{current: PhaseDial, since: time.Now()}
+}
+
+// end records the current phase if it is phase and starts next
+func (c *phaseClock) end(phase, next string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.current != phase {
+		return
+	}
+	now := time.Now()
+	*c.timings.field(phase) = now.Sub(c.since)
+	c.current, c.since = next, now
+}
+
+// stop records the time spent in the current phase and returns the timings
+// and the phase that was cut short, if any
+func (c *phaseClock) stop() (PhaseTimings, string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	phase := c.current
+	if phase != "" {
+		*c.timings.field(phase) = time.Since(c.since)
+		c.current = ""
+	}
+	return c.timings, phase
+}
+
+// timedConn ends the banner phase once the server's version line has been
+// read. Lines sent before the version, which RFC 4253 allows, are skipped.
+type timedConn struct {
+	net.Conn
+	clock *phaseClock
+	line  []byte
+	done  bool
+}
+
+func (c *timedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.done {
+		c.observe(p[:n])
+	}
+	return n, err
+}
+
+// observe scans received bytes for the end of the version line
+func (c *timedConn) observe(data []byte) {
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			c.line = append(c.line, data...)
+			return
+		}
+		c.line = append(c.line, data[:i]...)
+		if bytes.HasPrefix(c.line, []byte("SSH-")) {
+			c.done = true
+			c.line = nil
+			c.clock.end(PhaseBanner, PhaseKeyExchange)
+			return
+		}
+		c.line = c.line[:0]
+		data = data[i+1:]
+	}
+}
+
+// recordAttempt keeps an attempt in the rolling window of its host
+func (c *SSHClient) recordAttempt(attempt ConnectionAttempt) {
+	window := c.config.TimingWindow
+	if window <= 0 {
+		window = DefaultTimingWindow
+	}
+
+	c.timingMutex.Lock()
+	defer c.timingMutex.Unlock()
+	if c.timings == nil {
+		c.timings = make(map[string][]ConnectionAttempt)
+	}
+	attempts := append(c.timings[attempt.Host], attempt)
+	if len(attempts) > window {
+		attempts = append([]ConnectionAttempt(nil), attempts[len(attempts)-window:]...)
+	}
+	c.timings[attempt.Host] = attempts
+
+	if c.health == nil {
+		c.health = make(map[string]*hostHealth)
+	}
+	health := c.health[attempt.Host]
+	if health == nil {
+		health = &hostHealth{}
+		c.health[attempt.Host] = health
+	}
+	health.observe(attempt)
+}
+
+// RecentConnectionTimings returns the last connection attempts to a host,
+// oldest first. Only the last ClientConfig.TimingWindow attempts are kept,
+// and only in memory.
+func (c *SSHClient) RecentConnectionTimings(host string, port int) []ConnectionAttempt {
+	c.timingMutex.Lock()
+	defer c.timingMutex.Unlock()
+	return append([]ConnectionAttempt{}, c.timings[hostAddress(host, port)]...)
+}
@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPhaseTimings(t *testing.T) {
+	connect := PhaseTimings{Dial: 3 * time.Millisecond, Banner: 20 * time.Millisecond,
+		KeyExchange: 40 * time.Millisecond, Auth: 1200 * time.Millisecond}
+	command := PhaseTimings{Session: 5 * time.Millisecond, Command: 250 * time.Millisecond}
+
+	merged := connect.Merge(command)
+	if merged.Dial != connect.Dial || merged.Command != command.Command {
+		t.Errorf("Merge lost phases: %+v", merged)
+	}
+	if got := merged.Handshake(); got != 1260*time.Millisecond {
+		t.Errorf("Expected handshake 1.26s, got %v", got)
+	}
+	if got := merged.String(); got != "dial=3ms banner=20ms key_exchange=40ms auth=1.2s session=5ms command=250ms" {
+		t.Errorf("Unexpected description: %s", got)
+	}
+	if got := (PhaseTimings{}).String(); got != "no phases timed" {
+		t.Errorf("Unexpected description of empty timings: %s", got)
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := `{"dialMs":3,"bannerMs":20,"keyExchangeMs":40,"authMs":1200,"sessionMs":5,"commandMs":250}`
+	if string(data) != want {
+		t.Errorf("Expected %s, got %s", want, data)
+	}
+
+	var decoded PhaseTimings
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded != merged {
+		t.Errorf("Round trip changed timings: %+v", decoded)
+	}
+}
+
+func TestTimedConn_SkipsPreBannerLines(t *testing.T) {
+	clock := newPhaseClock()
+	clock.end(PhaseDial, PhaseBanner)
+
+	conn := &timedConn{clock: clock}
+	for _, chunk := range []string{"Welcome\r\n", "SSH-2.0-", "Cisco\r", "\n"} {
+		if conn.done {
+			t.Fatalf("Banner ended early, before %q", chunk)
+		}
+		conn.observe([]byte(chunk))
+	}
+	if !conn.done {
+		t.Fatal("Expected the banner to end at the version line")
+	}
+	if _, phase := clock.stop(); phase != PhaseKeyExchange {
+		t.Errorf("Expected key exchange to be running, got %q", phase)
+	}
+}
@@ -0,0 +1,213 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// VendorPagerConfig describes the pager of a vendor CLI: PromptPattern is a
+// regular expression matching the prompt shown when output fills a page, and
+// ClearCommand is what is sent to move past it
+type VendorPagerConfig struct {
+	PromptPattern string `json:"promptPattern"`
+	ClearCommand  string `json:"clearCommand"`
+}
+
+// DefaultPagerConfigs returns the pager settings of the vendors known to page
+// command output
+func DefaultPagerConfigs() map[string]VendorPagerConfig {
+	return map[string]VendorPagerConfig{
+		"cisco":   {PromptPattern: `--More--`, ClearCommand: " "},
+		"juniper": {PromptPattern: `\{master\}`, ClearCommand: "q"},
+	}
+}
+
+// maxPagerPrompts bounds how many pager prompts one command may answer, so a
+// prompt pattern that matches the device's own answer cannot loop forever
+const maxPagerPrompts = 10000
+
+// pagerEraseSequence matches the backspaces and blanks devices send to wipe
+// a pager prompt off the terminal once it has been answered
+var pagerEraseSequence = regexp.MustCompile("\x08+ *\x08*")
+
+// pagerFilter removes pager prompts from output as it arrives. Prompts are
+// only looked for in the trailing partial line, as a device showing one
+// waits for an answer before it sends the next line.
+type pagerFilter struct {
+	prompt  *regexp.Regexp
+	pending []byte
+	output  bytes.Buffer
+}
+
+// newPagerFilter compiles a pager's prompt pattern
+func newPagerFilter(pager VendorPagerConfig) (*pagerFilter, error) {
+	if pager.PromptPattern == "" {
+		return nil, fmt.Errorf("pager prompt pattern cannot be empty")
+	}
+	prompt, err := regexp.Compile(`[ \t]*(?:` + pager.PromptPattern + `)[ \t]*`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pager prompt pattern: %w", err)
+	}
+	return &pagerFilter{prompt: prompt}, nil
+}
+
+// feed adds received output and reports whether it ended in a pager prompt,
+// which has then been removed and must be answered
+func (f *pagerFilter) feed(data []byte) bool {
+	f.pending = append(f.pending, data...)
+
+	lineStart := bytes.LastIndexByte(f.pending, '\n') + 1
+	f.write(f.pending[:lineStart])
+	f.pending = append([]byte{}, f.pending[lineStart:]...)
+
+	loc := f.prompt.FindIndex(f.pending)
+	if loc == nil {
+		return false
+	}
+	f.pending = append(f.pending[:loc[0]:loc[0]], f.pending[loc[1]:]...)
+	return true
+}
+
+// write keeps complete output without erase sequences
+func (f *pagerFilter) write(data []byte) {
+	f.output.Write(pagerEraseSequence.ReplaceAll(data, nil))
+}
+
+// finish flushes the last partial line and returns the clean output
+func (f *pagerFilter) finish() string {
+	f.write(f.pending)
+	f.pending = nil
+	return f.output.String()
+}
+
+// ExecuteCommandWithPager runs a command on a device whose CLI pages long
+// output. The output is read as it arrives, every pager prompt is answered
+// with the pager's clear command, and the prompts and the sequences erasing
+// them are left out of the result. Standard error is kept apart in Stderr and
+// follows standard output in Output instead of being interleaved with it.
+func (c *SSHClient) ExecuteCommandWithPager(ctx context.Context, conn *SSHConnection, command string, pager VendorPagerConfig) (*CommandResult, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection cannot be nil")
+	}
+	if command == "" {
+		return nil, fmt.Errorf("command cannot be empty")
+	}
+	filter, err := newPagerFilter(pager)
+	if err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	result := &CommandResult{
+		Command:    command,
+		ExecutedAt: startTime,
+	}
+
+	conn.mutex.Lock()
+	conn.inUse = true
+	conn.lastUsed = time.Now()
+	conn.mutex.Unlock()
+
+	defer func() {
+		conn.mutex.Lock()
+		conn.inUse = false
+		conn.mutex.Unlock()
+		result.Duration = time.Since(startTime)
+	}()
+
+	session, err := conn.client.NewSession()
+	result.Timings.Session = time.Since(startTime)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to create session: %v", err)
+		return result, err
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to open output: %v", err)
+		return result, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to open input: %v", err)
+		return result, err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	cmdCtx, cancel := context.WithTimeout(ctx, c.config.CommandTimeout)
+	defer cancel()
+
+	commandStart := time.Now()
+	defer func() {
+		result.Timings.Command = time.Since(commandStart)
+	}()
+
+	if err := session.Start(command); err != nil {
+		result.Error = err.Error()
+		result.ExitCode = -1
+		return result, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- readPaged(stdout, stdin, filter, pager.ClearCommand)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			err = session.Wait()
+		}
+		result.setOutput(filter.finish(), stderr.String())
+		if err != nil {
+			result.Error = err.Error()
+			if exitErr, ok := err.(*ssh.ExitError); ok {
+				result.ExitCode = exitErr.ExitStatus()
+			} else {
+				result.ExitCode = -1
+			}
+			return result, err
+		}
+		return result, nil
+	case <-cmdCtx.Done():
+		// Closing the session unblocks the reader
+		session.Close()
+		<-done
+		result.Error = "command execution timeout"
+		result.ExitCode = -1
+		return result, fmt.Errorf("command execution timeout")
+	}
+}
+
+// readPaged copies output into the filter until the device closes it,
+// answering each pager prompt with clear
+func readPaged(stdout io.Reader, stdin io.Writer, filter *pagerFilter, clear string) error {
+	buf := make([]byte, 4096)
+	prompts := 0
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 && filter.feed(buf[:n]) {
+			prompts++
+			if prompts > maxPagerPrompts {
+				return fmt.Errorf("gave up after %d pager prompts", maxPagerPrompts)
+			}
+			if _, werr := io.WriteString(stdin, clear); werr != nil {
+				return fmt.Errorf("failed to answer pager prompt: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
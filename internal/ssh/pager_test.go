@@ -0,0 +1,53 @@
+package ssh
+
+import (
+	"testing"
+)
+
+func TestPagerFilter(t *testing.T) {
+	filter, err := newPagerFilter(DefaultPagerConfigs()["cisco"])
+	if err != nil {
+		t.Fatalf("newPagerFilter failed: %v", err)
+	}
+
+	// The prompt arrives split across reads, and the erase sequence
+	// answering it is split as well
+	chunks := []struct {
+		data   string
+		prompt bool
+	}{
+		{"line one\nline ", false},
+		{"two\n --Mo", false},
+		{"re-- ", true},
+		{"\b\b\b\b\b\b\b\b\b\b", false},
+		{"          \b\b\b\b\b\b\b\b\b\bline three\n", false},
+		{"last line without newline", false},
+	}
+	for _, chunk := range chunks {
+		if got := filter.feed([]byte(chunk.data)); got != chunk.prompt {
+			t.Errorf("feed(%q) reported prompt %v, want %v", chunk.data, got, chunk.prompt)
+		}
+	}
+
+	want := "line one\nline two\nline three\nlast line without newline"
+	if got := filter.finish(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestPagerFilter_Juniper(t *testing.T) {
+	filter, err := newPagerFilter(DefaultPagerConfigs()["juniper"])
+	if err != nil {
+		t.Fatalf("newPagerFilter failed: %v", err)
+	}
+	if !filter.feed([]byte("set system host-name edge\n{master}")) {
+		t.Error("Expected the {master} prompt to be detected")
+	}
+	if got := filter.finish(); got != "set system host-name edge\n" {
+		t.Errorf("Unexpected output %q", got)
+	}
+
+	if _, err := newPagerFilter(VendorPagerConfig{}); err == nil {
+		t.Error("Expected an empty prompt pattern to be rejected")
+	}
+}
@@ -0,0 +1,364 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ShellConfig describes how to prepare the interactive shell of a vendor CLI
+// so commands can run in it one after another
+type ShellConfig struct {
+	// PromptPattern matches the prompt the CLI shows when it is ready for
+	// the next command
+	PromptPattern string `json:"promptPattern"`
+
+	// EnableCommand enters privileged mode; empty stays in the login mode
+	EnableCommand string `json:"enableCommand"`
+
+	// EnablePasswordPattern matches the prompt EnableCommand shows when it
+	// wants a password, which is answered with the device's enable secret
+	EnablePasswordPattern string `json:"enablePasswordPattern"`
+
+	// SetupCommands run once the shell is privileged, such as turning
+	// paging off
+	SetupCommands []string `json:"setupCommands"`
+}
+
+// DefaultShellConfigs returns the shell settings of the vendors whose CLI
+// can be kept open across commands
+func DefaultShellConfigs() map[string]ShellConfig {
+	return map[string]ShellConfig{
+		"cisco": {PromptPattern: `[\w.()/:-]+[>#]`, EnableCommand: "enable",
+			EnablePasswordPattern: `[Pp]assword:`, SetupCommands: []string{"terminal length 0"}},
+		"arista": {PromptPattern: `[\w.()/:-]+[>#]`, EnableCommand: "enable",
+			EnablePasswordPattern: `[Pp]assword:`, SetupCommands: []string{"terminal length 0"}},
+		"juniper": {PromptPattern: `[\w.@-]+[>#%]`, SetupCommands: []string{"set cli screen-length 0"}},
+	}
+}
+
+// shellTerminalWidth is the terminal width requested for shells, wide
+// enough that devices do not wrap the commands they echo
+const shellTerminalWidth = 511
+
+// Shell runs commands one after another in a device's interactive shell
+type Shell interface {
+	Run(ctx context.Context, command string) (*CommandResult, error)
+	Close() error
+}
+
+// ShellSession is an interactive shell opened on a connection. Commands run
+// in it keep the privilege and terminal settings established when it was
+// opened. A command that fails to complete closes the session, as the shell
+// is then in an unknown state.
+type ShellSession struct {
+	conn    *SSHConnection
+	session *ssh.Session
+	stdin   io.Writer
+	timeout time.Duration
+	prompt  *regexp.Regexp
+
+	// chunks carries output as it arrives and is closed when the device
+	// closes the shell; pending holds output not yet returned. done stops
+	// the reader once the session is closed.
+	chunks  chan []byte
+	pending []byte
+	done    chan struct{}
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+var _ Shell = (*ShellSession)(nil)
+
+// ValidateShellConfig checks that a shell config has a prompt pattern and
+// that its patterns compile
+func ValidateShellConfig(config ShellConfig) error {
+	if config.PromptPattern == "" {
+		return fmt.Errorf("shell prompt pattern cannot be empty")
+	}
+	if _, err := tailPattern(config.PromptPattern); err != nil {
+		return fmt.Errorf("invalid shell prompt pattern: %w", err)
+	}
+	if config.EnablePasswordPattern != "" {
+		if _, err := tailPattern(config.EnablePasswordPattern); err != nil {
+			return fmt.Errorf("invalid enable password pattern: %w", err)
+		}
+	}
+	return nil
+}
+
+// tailPattern compiles a pattern matched against the last, unfinished line
+// of output, where a device shows its prompts
+func tailPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^[ \t]*(?:` + pattern + `)[ \t]*$`)
+}
+
+// OpenShell opens an interactive shell on a connection and prepares it as
+// config describes: it waits for the first prompt, enters privileged mode,
+// answering a password prompt with enableSecret, and runs the setup
+// commands. The shell keeps the connection busy until it is closed.
+func (c *SSHClient) OpenShell(ctx context.Context, conn *SSHConnection, config ShellConfig, enableSecret string) (Shell, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection cannot be nil")
+	}
+	if err := ValidateShellConfig(config); err != nil {
+		return nil, err
+	}
+	prompt, _ := tailPattern(config.PromptPattern)
+
+	session, err := conn.client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open input: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open output: %w", err)
+	}
+	if err := session.RequestPty("vt100", 0, shellTerminalWidth, ssh.TerminalModes{}); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to request a terminal: %w", err)
+	}
+	if err := session.Shell(); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start shell: %w", err)
+	}
+
+	conn.mutex.Lock()
+	conn.inUse = true
+	conn.lastUsed = time.Now()
+	conn.mutex.Unlock()
+
+	shell := &ShellSession{
+		conn:    conn,
+		session: session,
+		stdin:   stdin,
+		timeout: c.config.CommandTimeout,
+		prompt:  prompt,
+		chunks:  make(chan []byte, 16),
+		done:    make(chan struct{}),
+	}
+	go shell.read(stdout)
+
+	if err := shell.prepare(ctx, config, enableSecret); err != nil {
+		shell.Close()
+		return nil, err
+	}
+	return shell, nil
+}
+
+// read forwards output until the device closes the shell
+func (s *ShellSession) read(stdout io.Reader) {
+	defer close(s.chunks)
+	buf := make([]byte, 4096)
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			select {
+			case s.chunks <- append([]byte{}, buf[:n]...):
+			case <-s.done:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// prepare waits for the login prompt, enters privileged mode and runs the
+// setup commands
+func (s *ShellSession) prepare(ctx context.Context, config ShellConfig, enableSecret string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if _, _, err := s.readUntil(ctx, s.prompt); err != nil {
+		return fmt.Errorf("no prompt from shell: %w", err)
+	}
+
+	if config.EnableCommand != "" {
+		patterns := []*regexp.Regexp{s.prompt}
+		if config.EnablePasswordPattern != "" {
+			enablePassword, _ := tailPattern(config.EnablePasswordPattern)
+			patterns = append(patterns, enablePassword)
+		}
+
+		if err := s.send(config.EnableCommand); err != nil {
+			return err
+		}
+		_, matched, err := s.readUntil(ctx, patterns...)
+		if err != nil {
+			return fmt.Errorf("failed to enter privileged mode: %w", err)
+		}
+		if matched == 1 {
+			if enableSecret == "" {
+				return fmt.Errorf("device wants an enable secret but none is set")
+			}
+			if err := s.send(enableSecret); err != nil {
+				return err
+			}
+			if _, matched, err = s.readUntil(ctx, patterns...); err != nil {
+				return fmt.Errorf("failed to enter privileged mode: %w", err)
+			}
+			if matched == 1 {
+				return fmt.Errorf("device rejected the enable secret")
+			}
+		}
+	}
+
+	for _, command := range config.SetupCommands {
+		if err := s.send(command); err != nil {
+			return err
+		}
+		if _, _, err := s.readUntil(ctx, s.prompt); err != nil {
+			return fmt.Errorf("setup command %q did not complete: %w", command, err)
+		}
+	}
+	return nil
+}
+
+// Run sends a command to the shell and returns its output once the device
+// shows its prompt again. A shell has no exit status, so a command the CLI
+// rejects still succeeds with the CLI's error message as its output.
+func (s *ShellSession) Run(ctx context.Context, command string) (*CommandResult, error) {
+	if command == "" {
+		return nil, fmt.Errorf("command cannot be empty")
+	}
+	if strings.ContainsAny(command, "\r\n") {
+		return nil, fmt.Errorf("shell commands must be a single line")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil, fmt.Errorf("shell session is closed")
+	}
+
+	startTime := time.Now()
+	result := &CommandResult{
+		Command:    command,
+		ExecutedAt: startTime,
+	}
+	defer func() {
+		result.Duration = time.Since(startTime)
+		result.Timings.Command = result.Duration
+	}()
+
+	s.conn.mutex.Lock()
+	s.conn.lastUsed = startTime
+	s.conn.mutex.Unlock()
+
+	cmdCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if err := s.send(command); err != nil {
+		s.closeLocked()
+		result.Error = err.Error()
+		result.ExitCode = -1
+		return result, err
+	}
+	output, _, err := s.readUntil(cmdCtx, s.prompt)
+	if err != nil {
+		s.closeLocked()
+		if cmdCtx.Err() != nil {
+			err = fmt.Errorf("command execution timeout")
+		}
+		result.Error = err.Error()
+		result.ExitCode = -1
+		return result, err
+	}
+
+	result.setOutput(shellOutput(output, command), "")
+	return result, nil
+}
+
+// Close ends the shell and frees its connection. It does not close the
+// connection itself.
+func (s *ShellSession) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closeLocked()
+}
+
+// closeLocked closes the shell; the caller holds s.mutex
+func (s *ShellSession) closeLocked() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+
+	s.conn.mutex.Lock()
+	s.conn.inUse = false
+	s.conn.mutex.Unlock()
+
+	err := s.session.Close()
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// send writes a line to the shell
+func (s *ShellSession) send(line string) error {
+	if _, err := io.WriteString(s.stdin, line+"\n"); err != nil {
+		return fmt.Errorf("failed to write to shell: %w", err)
+	}
+	return nil
+}
+
+// readUntil collects output until its last line matches one of patterns.
+// It returns the output before that line and the index of the pattern.
+func (s *ShellSession) readUntil(ctx context.Context, patterns ...*regexp.Regexp) (string, int, error) {
+	for {
+		lineStart := bytes.LastIndexByte(s.pending, '\n') + 1
+		tail := s.pending[lineStart:]
+		// Devices redraw a prompt after a carriage return
+		tail = tail[bytes.LastIndexByte(tail, '\r')+1:]
+		for i, pattern := range patterns {
+			if pattern.Match(tail) {
+				output := string(s.pending[:lineStart])
+				s.pending = nil
+				return output, i, nil
+			}
+		}
+
+		select {
+		case chunk, ok := <-s.chunks:
+			if !ok {
+				return "", -1, fmt.Errorf("shell closed by device")
+			}
+			s.pending = append(s.pending, chunk...)
+		case <-ctx.Done():
+			return "", -1, ctx.Err()
+		}
+	}
+}
+
+// shellOutput turns terminal output into plain lines and drops the echo of
+// the command that produced it
+func shellOutput(output, command string) string {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	output = strings.ReplaceAll(output, "\r", "")
+	first, rest, found := strings.Cut(output, "\n")
+	if strings.TrimSpace(first) == command {
+		if !found {
+			return ""
+		}
+		return rest
+	}
+	return output
+}
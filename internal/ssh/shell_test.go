@@ -0,0 +1,202 @@
+package ssh
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/ssh/sshtest"
+)
+
+// newShellServer returns a mock server running a switch CLI as router
+func newShellServer(t testing.TB, enableDelay time.Duration) *sshtest.Server {
+	t.Helper()
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+	server.SetShell("router", "testpass", enableDelay)
+	server.SetCommandResponse("show version", "Cisco IOS Software\nuptime is 5 days")
+	server.SetCommandResponse("show users", "admin vty 0")
+	return server
+}
+
+// shellConnection connects to server as testuser
+func shellConnection(t testing.TB, server *sshtest.Server, config *ClientConfig) (*SSHClient, *SSHConnection) {
+	t.Helper()
+	client := NewSSHClientWithHostKeyCheck(config, CreateInsecureHostKeyCallbackForTesting())
+	t.Cleanup(func() { client.Close() })
+
+	conn, err := client.Connect(context.Background(), &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(conn) })
+	return client, conn
+}
+
+func TestShellSession_Run(t *testing.T) {
+	server := newShellServer(t, 0)
+	client, conn := shellConnection(t, server, nil)
+	ctx := context.Background()
+
+	shell, err := client.OpenShell(ctx, conn, DefaultShellConfigs()["cisco"], "testpass")
+	if err != nil {
+		t.Fatalf("Failed to open shell: %v", err)
+	}
+
+	result, err := shell.Run(ctx, "show version")
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if result.Output != "Cisco IOS Software\nuptime is 5 days\n" {
+		t.Errorf("Expected the output without echo or prompt, got %q", result.Output)
+	}
+	if result.Stdout != result.Output || result.ExitCode != 0 {
+		t.Errorf("Expected a clean result on stdout, got %+v", result)
+	}
+
+	result, err = shell.Run(ctx, "show bogus")
+	if err != nil {
+		t.Fatalf("Expected a rejected command to still complete, got: %v", err)
+	}
+	if !strings.Contains(result.Output, "Invalid input") {
+		t.Errorf("Expected the CLI error as output, got %q", result.Output)
+	}
+
+	if _, err := shell.Run(ctx, "show version\nreload"); err == nil {
+		t.Error("Expected multi-line commands to be refused")
+	}
+
+	want := "enable|terminal length 0|show version|show bogus"
+	if got := strings.Join(server.ShellLines(), "|"); got != want {
+		t.Errorf("Expected one enable and setup before the commands, got %q", got)
+	}
+
+	if err := shell.Close(); err != nil {
+		t.Errorf("Failed to close shell: %v", err)
+	}
+	if _, err := shell.Run(ctx, "show version"); err == nil {
+		t.Error("Expected a closed shell to refuse commands")
+	}
+
+	// The connection outlives the shell
+	if _, err := client.ExecuteCommand(ctx, conn, "show users"); err != nil {
+		t.Errorf("Expected the connection to stay usable, got: %v", err)
+	}
+}
+
+func TestShellSession_EnableRejected(t *testing.T) {
+	server := newShellServer(t, 0)
+	client, conn := shellConnection(t, server, nil)
+
+	_, err := client.OpenShell(context.Background(), conn, DefaultShellConfigs()["cisco"], "wrong")
+	if err == nil || !strings.Contains(err.Error(), "rejected the enable secret") {
+		t.Errorf("Expected the enable secret to be rejected, got: %v", err)
+	}
+
+	_, err = client.OpenShell(context.Background(), conn, DefaultShellConfigs()["cisco"], "")
+	if err == nil || !strings.Contains(err.Error(), "none is set") {
+		t.Errorf("Expected a missing enable secret to fail, got: %v", err)
+	}
+}
+
+func TestShellSession_NoPrompt(t *testing.T) {
+	server := newShellServer(t, 0)
+	config := DefaultClientConfig()
+	config.CommandTimeout = 200 * time.Millisecond
+	client, conn := shellConnection(t, server, config)
+
+	_, err := client.OpenShell(context.Background(), conn, ShellConfig{PromptPattern: `never\$`}, "")
+	if err == nil || !strings.Contains(err.Error(), "no prompt") {
+		t.Errorf("Expected opening to time out waiting for the prompt, got: %v", err)
+	}
+}
+
+func TestValidateShellConfig(t *testing.T) {
+	for vendor, config := range DefaultShellConfigs() {
+		if err := ValidateShellConfig(config); err != nil {
+			t.Errorf("Default shell config of %s is invalid: %v", vendor, err)
+		}
+	}
+	if err := ValidateShellConfig(ShellConfig{}); err == nil {
+		t.Error("Expected a missing prompt pattern to be refused")
+	}
+	if err := ValidateShellConfig(ShellConfig{PromptPattern: "("}); err == nil {
+		t.Error("Expected an invalid prompt pattern to be refused")
+	}
+	if err := ValidateShellConfig(ShellConfig{PromptPattern: "#", EnablePasswordPattern: "["}); err == nil {
+		t.Error("Expected an invalid enable password pattern to be refused")
+	}
+}
+
+func TestShellOutput(t *testing.T) {
+	tests := []struct {
+		output, command, want string
+	}{
+		{"show clock\r\n12:00 UTC\r\n", "show clock", "12:00 UTC\n"},
+		{"show clock\r\n", "show clock", ""},
+		{"show clock", "show clock", ""},
+		{"12:00 UTC\r\n", "show clock", "12:00 UTC\n"},
+	}
+	for _, tt := range tests {
+		if got := shellOutput(tt.output, tt.command); got != tt.want {
+			t.Errorf("shellOutput(%q, %q) = %q, want %q", tt.output, tt.command, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkShellSession compares logging in and entering enable mode for
+// every rule command with keeping one privileged shell for all of them, on
+// a device whose enable takes 5ms
+func BenchmarkShellSession(b *testing.B) {
+	server := newShellServer(b, 5*time.Millisecond)
+	commands := []string{"show version", "show users", "show version", "show users", "show version",
+		"show users", "show version", "show users", "show version", "show users"}
+	config := DefaultShellConfigs()["cisco"]
+	ctx := context.Background()
+
+	client := NewSSHClientWithHostKeyCheck(nil, CreateInsecureHostKeyCallbackForTesting())
+	defer client.Close()
+	connInfo := &ConnectionInfo{Host: server.GetAddress(), Port: server.GetPort(), Username: "testuser",
+		Password: "testpass", AuthMethod: AuthPassword}
+
+	run := func(b *testing.B, commands []string) {
+		conn, err := client.Connect(ctx, connInfo)
+		if err != nil {
+			b.Fatalf("Failed to connect: %v", err)
+		}
+		defer client.Disconnect(conn)
+		shell, err := client.OpenShell(ctx, conn, config, "testpass")
+		if err != nil {
+			b.Fatalf("Failed to open shell: %v", err)
+		}
+		defer shell.Close()
+		for _, command := range commands {
+			if _, err := shell.Run(ctx, command); err != nil {
+				b.Fatalf("Command failed: %v", err)
+			}
+		}
+	}
+
+	b.Run("shell per command", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, command := range commands {
+				run(b, []string{command})
+			}
+		}
+	})
+	b.Run("shell per device", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			run(b, commands)
+		}
+	})
+}
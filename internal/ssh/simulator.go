@@ -0,0 +1,377 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecordingClient wraps an SSH client and records every command it runs,
+// per device, so the session can be replayed by a SimulatedClient
+type RecordingClient struct {
+	client   SSHClientInterface
+	mutex    sync.Mutex
+	sessions map[*SSHConnection]*SessionFixture
+	fixtures map[string]*SessionFixture
+}
+
+// NewRecordingClient creates a recording client around an existing client
+func NewRecordingClient(client SSHClientInterface) *RecordingClient {
+	return &RecordingClient{
+		client:   client,
+		sessions: make(map[*SSHConnection]*SessionFixture),
+		fixtures: make(map[string]*SessionFixture),
+	}
+}
+
+// Connect connects through the wrapped client and starts recording the device
+func (r *RecordingClient) Connect(ctx context.Context, connInfo *ConnectionInfo) (*SSHConnection, error) {
+	conn, err := r.client.Connect(ctx, connInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := fixtureKey(connInfo.Host, connInfo.Port)
+	fixture, exists := r.fixtures[key]
+	if !exists {
+		fixture = &SessionFixture{
+			Version:    FixtureFormatVersion,
+			Host:       connInfo.Host,
+			Port:       connInfo.Port,
+			RecordedAt: time.Now(),
+			Commands:   []RecordedCommand{},
+		}
+		r.fixtures[key] = fixture
+	}
+	r.sessions[conn] = fixture
+
+	return conn, nil
+}
+
+// ExecuteCommand runs a command through the wrapped client and records the
+// redacted output
+func (r *RecordingClient) ExecuteCommand(ctx context.Context, conn *SSHConnection, command string) (*CommandResult, error) {
+	result, err := r.client.ExecuteCommand(ctx, conn, command)
+	if result == nil {
+		return result, err
+	}
+
+	r.mutex.Lock()
+	if fixture, exists := r.sessions[conn]; exists {
+		fixture.Commands = append(fixture.Commands, RecordedCommand{
+			Command:  command,
+			Output:   RedactOutput(result.Output),
+			Stderr:   RedactOutput(result.Stderr),
+			Error:    result.Error,
+			ExitCode: result.ExitCode,
+			Duration: result.Duration,
+		})
+	}
+	r.mutex.Unlock()
+
+	return result, err
+}
+
+// ExecuteCommands runs and records multiple commands sequentially
+func (r *RecordingClient) ExecuteCommands(ctx context.Context, conn *SSHConnection, commands []string) ([]*CommandResult, error) {
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("commands list cannot be empty")
+	}
+
+	results := make([]*CommandResult, 0, len(commands))
+	for _, command := range commands {
+		result, _ := r.ExecuteCommand(ctx, conn, command)
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Disconnect closes the connection through the wrapped client
+func (r *RecordingClient) Disconnect(conn *SSHConnection) error {
+	r.mutex.Lock()
+	delete(r.sessions, conn)
+	r.mutex.Unlock()
+
+	return r.client.Disconnect(conn)
+}
+
+// Close closes the wrapped client
+func (r *RecordingClient) Close() error {
+	return r.client.Close()
+}
+
+// GetConnectionStats returns the wrapped client's statistics
+func (r *RecordingClient) GetConnectionStats() map[string]ConnectionStats {
+	return r.client.GetConnectionStats()
+}
+
+// Fixtures returns the sessions recorded so far
+func (r *RecordingClient) Fixtures() []*SessionFixture {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	fixtures := make([]*SessionFixture, 0, len(r.fixtures))
+	for _, fixture := range r.fixtures {
+		copied := *fixture
+		copied.Commands = append([]RecordedCommand(nil), fixture.Commands...)
+		fixtures = append(fixtures, &copied)
+	}
+	return fixtures
+}
+
+// SaveFixtures writes one fixture file per recorded device to dir
+func (r *RecordingClient) SaveFixtures(dir string) ([]string, error) {
+	var paths []string
+	for _, fixture := range r.Fixtures() {
+		path, err := SaveFixture(dir, fixture)
+		if err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// ErrUnknownCommand is returned by a SimulatedClient for a command that was
+// not recorded when FailUnknownCommands is set
+var ErrUnknownCommand = errors.New("command not recorded in fixture")
+
+// SimulationConfig controls how a SimulatedClient answers
+type SimulationConfig struct {
+	// UnknownCommandOutput is returned for commands missing from a fixture
+	UnknownCommandOutput string
+	// FailUnknownCommands returns ErrUnknownCommand instead of the canned output
+	FailUnknownCommands bool
+	// ReplayDelays waits for each command's recorded duration before answering
+	ReplayDelays bool
+}
+
+// SimulatedClient serves recorded command output in place of real devices.
+// It implements SSHClientInterface so the check engine cannot tell it apart
+// from the network client.
+type SimulatedClient struct {
+	config   SimulationConfig
+	mutex    sync.RWMutex
+	devices  map[string]map[string]RecordedCommand
+	sessions map[*SSHConnection]string
+	stats    map[string]*ConnectionStats
+}
+
+// NewSimulatedClient creates a simulated client serving the given fixtures.
+// When a command was recorded more than once the last recording wins. A
+// fixture without a port serves its host on any port it has no fixture for.
+func NewSimulatedClient(fixtures []*SessionFixture, config SimulationConfig) *SimulatedClient {
+	client := &SimulatedClient{
+		config:   config,
+		devices:  make(map[string]map[string]RecordedCommand),
+		sessions: make(map[*SSHConnection]string),
+		stats:    make(map[string]*ConnectionStats),
+	}
+
+	for _, fixture := range fixtures {
+		key := fixtureKey(fixture.Host, fixture.Port)
+		commands, exists := client.devices[key]
+		if !exists {
+			commands = make(map[string]RecordedCommand)
+			client.devices[key] = commands
+		}
+		for _, recorded := range fixture.Commands {
+			commands[recorded.Command] = recorded
+		}
+	}
+
+	return client
+}
+
+// NewSimulatedClientFromDir creates a simulated client from the fixture files in dir
+func NewSimulatedClientFromDir(dir string, config SimulationConfig) (*SimulatedClient, error) {
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewSimulatedClient(fixtures, config), nil
+}
+
+// Connect opens a simulated session. Devices without a fixture are reported
+// as unreachable, as the network client would for a missing host.
+func (s *SimulatedClient) Connect(ctx context.Context, connInfo *ConnectionInfo) (*SSHConnection, error) {
+	if connInfo == nil {
+		return nil, &SSHError{Kind: ErrorKindConfig, Err: fmt.Errorf("connection info cannot be nil")}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, &SSHError{Kind: ErrorKindTimeout, Host: connInfo.Host, Err: err}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, exists := s.deviceKey(connInfo.Host, connInfo.Port)
+	stats := s.statsFor(key)
+	if !exists {
+		stats.FailedConns++
+		return nil, &SSHError{
+			Kind: ErrorKindUnreachable,
+			Host: key,
+			Err:  fmt.Errorf("no recorded session for %s", key),
+		}
+	}
+
+	now := time.Now()
+	conn := &SSHConnection{createdAt: now, lastUsed: now}
+	s.sessions[conn] = key
+	stats.CreatedConns++
+	stats.ActiveConns++
+	stats.TotalConns++
+
+	return conn, nil
+}
+
+// ExecuteCommand answers a command from the device's fixture
+func (s *SimulatedClient) ExecuteCommand(ctx context.Context, conn *SSHConnection, command string) (*CommandResult, error) {
+	if conn == nil {
+		return nil, fmt.Errorf("connection cannot be nil")
+	}
+	if command == "" {
+		return nil, fmt.Errorf("command cannot be empty")
+	}
+
+	s.mutex.Lock()
+	key, open := s.sessions[conn]
+	recorded, known := s.devices[key][command]
+	if open {
+		s.statsFor(key).CommandsExecuted++
+	}
+	s.mutex.Unlock()
+
+	if !open {
+		return nil, fmt.Errorf("connection is closed")
+	}
+
+	result := &CommandResult{Command: command, ExecutedAt: time.Now()}
+
+	if !known {
+		if s.config.FailUnknownCommands {
+			result.Error = ErrUnknownCommand.Error()
+			result.ExitCode = -1
+			return result, fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+		}
+		result.Output = s.config.UnknownCommandOutput
+		return result, nil
+	}
+
+	if s.config.ReplayDelays && recorded.Duration > 0 {
+		select {
+		case <-time.After(recorded.Duration):
+		case <-ctx.Done():
+			result.Error = "command execution timeout"
+			result.ExitCode = -1
+			result.Duration = time.Since(result.ExecutedAt)
+			return result, fmt.Errorf("command execution timeout")
+		}
+	}
+
+	result.setOutput(strings.TrimSuffix(recorded.Output, recorded.Stderr), recorded.Stderr)
+	result.Error = recorded.Error
+	result.ExitCode = recorded.ExitCode
+	result.Duration = recorded.Duration
+
+	if recorded.Error != "" {
+		return result, errors.New(recorded.Error)
+	}
+	return result, nil
+}
+
+// ExecuteCommands answers multiple commands sequentially
+func (s *SimulatedClient) ExecuteCommands(ctx context.Context, conn *SSHConnection, commands []string) ([]*CommandResult, error) {
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("commands list cannot be empty")
+	}
+
+	results := make([]*CommandResult, 0, len(commands))
+	for _, command := range commands {
+		result, _ := s.ExecuteCommand(ctx, conn, command)
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Disconnect closes a simulated session
+func (s *SimulatedClient) Disconnect(conn *SSHConnection) error {
+	if conn == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if key, open := s.sessions[conn]; open {
+		delete(s.sessions, conn)
+		s.statsFor(key).ActiveConns--
+	}
+	return nil
+}
+
+// Close closes every simulated session
+func (s *SimulatedClient) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sessions = make(map[*SSHConnection]string)
+	for _, stats := range s.stats {
+		stats.ActiveConns = 0
+	}
+	return nil
+}
+
+// GetConnectionStats returns per-device statistics of simulated sessions
+func (s *SimulatedClient) GetConnectionStats() map[string]ConnectionStats {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stats := make(map[string]ConnectionStats, len(s.stats))
+	for key, deviceStats := range s.stats {
+		stats[key] = *deviceStats
+	}
+	return stats
+}
+
+// HasDevice reports whether a fixture was loaded for a device
+func (s *SimulatedClient) HasDevice(host string, port int) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, exists := s.deviceKey(host, port)
+	return exists
+}
+
+// deviceKey returns the key of the fixture serving a device, falling back to
+// one recorded for the host without a port. The caller must hold the mutex.
+func (s *SimulatedClient) deviceKey(host string, port int) (string, bool) {
+	key := fixtureKey(host, port)
+	if _, exists := s.devices[key]; exists {
+		return key, true
+	}
+	if _, exists := s.devices[fixtureKey(host, 0)]; exists {
+		return fixtureKey(host, 0), true
+	}
+	return key, false
+}
+
+// statsFor returns the statistics of a device, creating them if needed.
+// The caller must hold the mutex.
+func (s *SimulatedClient) statsFor(key string) *ConnectionStats {
+	stats, exists := s.stats[key]
+	if !exists {
+		stats = &ConnectionStats{Host: key}
+		s.stats[key] = stats
+	}
+	return stats
+}
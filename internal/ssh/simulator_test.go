@@ -0,0 +1,213 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"invictux-demo/internal/ssh/sshtest"
+)
+
+func TestRecordAndReplaySession(t *testing.T) {
+	server, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+
+	server.SetCommandResponse("show version", "Cisco IOS Version 15.1\r\nuptime is 5 days\n")
+	server.SetCommandResponse("show ip ssh", "SSH Enabled - version 2.0")
+	server.SetCommandResponse("show running-config", "hostname edge\nusername admin secret 5 $1$abcd$xyz\n")
+
+	connInfo := &ConnectionInfo{
+		Host:       server.GetAddress(),
+		Port:       server.GetPort(),
+		Username:   "testuser",
+		Password:   "testpass",
+		AuthMethod: AuthPassword,
+	}
+	commands := []string{"show version", "show ip ssh", "show running-config"}
+
+	client := NewSSHClient(nil)
+	recorder := NewRecordingClient(client)
+	ctx := context.Background()
+
+	conn, err := recorder.Connect(ctx, connInfo)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	live, err := recorder.ExecuteCommands(ctx, conn, commands)
+	if err != nil {
+		t.Fatalf("Failed to execute commands: %v", err)
+	}
+	recorder.Disconnect(conn)
+	recorder.Close()
+
+	dir := t.TempDir()
+	paths, err := recorder.SaveFixtures(dir)
+	if err != nil {
+		t.Fatalf("Failed to save fixtures: %v", err)
+	}
+	if len(paths) != 1 || filepath.Base(paths[0]) != FixtureFileName(connInfo.Host, connInfo.Port) {
+		t.Fatalf("Expected one fixture for the device, got %v", paths)
+	}
+
+	// Replay with the device gone
+	server.Close()
+
+	simulator, err := NewSimulatedClientFromDir(dir, SimulationConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load fixtures: %v", err)
+	}
+
+	conn, err = simulator.Connect(ctx, connInfo)
+	if err != nil {
+		t.Fatalf("Failed to connect to simulated device: %v", err)
+	}
+	defer simulator.Disconnect(conn)
+
+	replayed, err := simulator.ExecuteCommands(ctx, conn, commands[:2])
+	if err != nil {
+		t.Fatalf("Failed to replay commands: %v", err)
+	}
+	for i, result := range replayed {
+		if result.Output != live[i].Output {
+			t.Errorf("Command %q: replayed output %q differs from recorded %q", commands[i], result.Output, live[i].Output)
+		}
+		if result.Duration != live[i].Duration {
+			t.Errorf("Command %q: expected recorded duration %v, got %v", commands[i], live[i].Duration, result.Duration)
+		}
+	}
+
+	// Secrets never reach the fixture
+	config, err := simulator.ExecuteCommand(ctx, conn, "show running-config")
+	if err != nil {
+		t.Fatalf("Failed to replay running config: %v", err)
+	}
+	if strings.Contains(config.Output, "$1$abcd$xyz") {
+		t.Errorf("Expected secret to be redacted, got %q", config.Output)
+	}
+	if !strings.Contains(config.Output, "username admin secret 5 "+RedactedValue) {
+		t.Errorf("Expected redacted secret line, got %q", config.Output)
+	}
+
+	stats := simulator.GetConnectionStats()[fixtureKey(connInfo.Host, connInfo.Port)]
+	if stats.CommandsExecuted != 3 || stats.ActiveConns != 1 {
+		t.Errorf("Unexpected simulated connection stats: %+v", stats)
+	}
+}
+
+func TestSimulatedClient_UnknownCommandsAndDevices(t *testing.T) {
+	fixture := &SessionFixture{
+		Version: FixtureFormatVersion,
+		Host:    "10.0.0.1",
+		Port:    22,
+		Commands: []RecordedCommand{
+			{Command: "show version", Output: "old"},
+			{Command: "show version", Output: "new", Duration: 20 * time.Millisecond},
+			{Command: "show bogus", Error: "Process exited with status 1", ExitCode: 1},
+		},
+	}
+	ctx := context.Background()
+
+	canned := NewSimulatedClient([]*SessionFixture{fixture}, SimulationConfig{
+		UnknownCommandOutput: "% Invalid input",
+		ReplayDelays:         true,
+	})
+
+	if _, err := canned.Connect(ctx, &ConnectionInfo{Host: "10.0.0.2", Port: 22}); ErrorKindOf(err) != ErrorKindUnreachable {
+		t.Errorf("Expected unreachable error for an unrecorded device, got %v", err)
+	}
+
+	conn, err := canned.Connect(ctx, &ConnectionInfo{Host: "10.0.0.1", Port: 22})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	start := time.Now()
+	result, err := canned.ExecuteCommand(ctx, conn, "show version")
+	if err != nil || result.Output != "new" {
+		t.Errorf("Expected the last recording to win, got %q (%v)", result.Output, err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("Expected the recorded delay to be replayed")
+	}
+
+	result, err = canned.ExecuteCommand(ctx, conn, "show bogus")
+	if err == nil || err.Error() != "Process exited with status 1" || result.ExitCode != 1 {
+		t.Errorf("Expected the recorded failure to be replayed, got %+v (%v)", result, err)
+	}
+
+	result, err = canned.ExecuteCommand(ctx, conn, "show clock")
+	if err != nil || result.Output != "% Invalid input" {
+		t.Errorf("Expected canned output for an unknown command, got %q (%v)", result.Output, err)
+	}
+
+	strict := NewSimulatedClient([]*SessionFixture{fixture}, SimulationConfig{FailUnknownCommands: true})
+	conn, err = strict.Connect(ctx, &ConnectionInfo{Host: "10.0.0.1", Port: 22})
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if _, err := strict.ExecuteCommand(ctx, conn, "show clock"); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("Expected ErrUnknownCommand, got %v", err)
+	}
+
+	strict.Disconnect(conn)
+	if _, err := strict.ExecuteCommand(ctx, conn, "show version"); err == nil {
+		t.Error("Expected an error on a disconnected session")
+	}
+}
+
+func TestLoadFixture_Versions(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write fixture: %v", err)
+		}
+		return path
+	}
+
+	current := write("current.json", `{"version": 1, "host": "10.0.0.1", "port": 22, "commands": []}`)
+	if _, err := LoadFixture(current); err != nil {
+		t.Errorf("Expected current version to load, got %v", err)
+	}
+
+	for name, content := range map[string]string{
+		"future.json":      `{"version": 2, "host": "10.0.0.1", "port": 22}`,
+		"unversioned.json": `{"host": "10.0.0.1", "port": 22}`,
+		"nohost.json":      `{"version": 1, "port": 22}`,
+		"broken.json":      `{"version": `,
+	} {
+		if _, err := LoadFixture(write(name, content)); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+
+	// A directory with an unreadable fixture fails as a whole
+	if _, err := LoadFixtures(dir); err == nil {
+		t.Error("Expected LoadFixtures to fail on an unsupported fixture")
+	}
+}
+
+func TestRedactOutput(t *testing.T) {
+	tests := map[string]string{
+		"enable secret 5 $1$abc":                "enable secret 5 " + RedactedValue,
+		"username admin password 0 hunter2":     "username admin password 0 " + RedactedValue,
+		"snmp-server community public RO":       "snmp-server community " + RedactedValue + " RO",
+		"encrypted-password \"$6$salt$hash\"":   "encrypted-password " + RedactedValue,
+		"service password-encryption":           "service password-encryption",
+		"no password\nhostname edge":            "no password\nhostname edge",
+		"ntp authentication-key 1 md5 0x1234 7": "ntp authentication-key 1 md5 " + RedactedValue + " 7",
+	}
+
+	for input, expected := range tests {
+		if got := RedactOutput(input); got != expected {
+			t.Errorf("RedactOutput(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+// Package sshtest provides an SSH server for tests of code that connects
+// to devices.
+package sshtest
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Server is an SSH server on a local port that answers commands with
+// canned responses. It accepts testuser with password testpass. Its
+// setters may be called while clients connect; a connection negotiates
+// with the settings in force when it was accepted.
+type Server struct {
+	listener net.Listener
+	address  string
+	port     int
+
+	// mu guards the settings below, which the setters change while
+	// connections are being handled
+	mu         sync.RWMutex
+	config     *ssh.ServerConfig
+	commands   map[string]string // command -> response mapping
+	stderr     map[string]string // command -> standard error
+	exitCodes  map[string]uint32 // command -> exit status, 0 when unset
+	shouldFail bool
+	delay      time.Duration
+
+	// users maps the usernames the server accepts to their passwords
+	users map[string]string
+
+	// bannerDelay, kexDelay and authDelay slow down the matching phase of
+	// the handshake
+	bannerDelay time.Duration
+	kexDelay    time.Duration
+	authDelay   time.Duration
+
+	// pages holds paged command output, sent one page at a time with
+	// pagerPrompt between pages; pagerAnswers records what the client sent
+	// to move past each prompt
+	pages        map[string][]string
+	pagerPrompt  string
+	pagerMutex   sync.Mutex
+	pagerAnswers []string
+
+	// keyboardAnswers records the keyboard-interactive answers the server
+	// received, across logins
+	keyboardMutex   sync.Mutex
+	keyboardAnswers []string
+
+	// shellHostname, when set, makes the server run a switch-like CLI for
+	// sessions asking for a shell. Its enable command wants enableSecret and
+	// takes enableDelay; shellLines records the commands the client typed.
+	shellHostname string
+	enableSecret  string
+	enableDelay   time.Duration
+	shellMutex    sync.Mutex
+	shellLines    []string
+}
+
+// delayedSigner signs the key exchange hash after the server's key
+// exchange delay, stretching the key exchange phase
+type delayedSigner struct {
+	ssh.AlgorithmSigner
+	server *Server
+}
+
+func (s delayedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	time.Sleep(s.server.kexDelayNow())
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s delayedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	time.Sleep(s.server.kexDelayNow())
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+// kexDelayNow returns the key exchange delay currently set
+func (s *Server) kexDelayNow() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.kexDelay
+}
+
+// NewServer starts a Server on a free local port
+func NewServer() (*Server, error) {
+	// Generate a test host key
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signer: %w", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("invalid credentials")
+		},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	address := listener.Addr().String()
+	host, portStr, _ := net.SplitHostPort(address)
+	port := 0
+	fmt.Sscanf(portStr, "%d", &port)
+
+	server := &Server{
+		listener: listener,
+		config:   config,
+		address:  host,
+		port:     port,
+		commands: make(map[string]string),
+		users:    map[string]string{"testuser": "testpass"},
+	}
+
+	passwordCallback := config.PasswordCallback
+	config.PasswordCallback = func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+		server.mu.RLock()
+		delay := server.authDelay
+		want, ok := server.users[c.User()]
+		server.mu.RUnlock()
+
+		time.Sleep(delay)
+		if ok && string(pass) == want {
+			return nil, nil
+		}
+		return passwordCallback(c, pass)
+	}
+	config.AddHostKey(delayedSigner{AlgorithmSigner: signer.(ssh.AlgorithmSigner), server: server})
+
+	go server.serve()
+	return server, nil
+}
+
+// SetCommandResponse sets the response for a specific command
+func (s *Server) SetCommandResponse(command, response string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands[command] = response
+}
+
+// SetCommandResult sets what a command writes to standard output and
+// standard error and the status it exits with
+func (s *Server) SetCommandResult(command, stdout, stderr string, exitStatus uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stderr == nil {
+		s.stderr = make(map[string]string)
+		s.exitCodes = make(map[string]uint32)
+	}
+	s.commands[command] = stdout
+	s.stderr[command] = stderr
+	s.exitCodes[command] = exitStatus
+}
+
+// AddUser makes the server accept another username and password from the
+// next login on
+func (s *Server) AddUser(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[username] = password
+}
+
+// SetShouldFail sets whether the server should fail connections
+func (s *Server) SetShouldFail(shouldFail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shouldFail = shouldFail
+}
+
+// SetDelay sets a delay for command execution
+func (s *Server) SetDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = delay
+}
+
+// SetHandshakeDelays slows down sending the version banner, signing the key
+// exchange and checking passwords. It applies to connections accepted
+// afterwards.
+func (s *Server) SetHandshakeDelays(banner, kex, auth time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bannerDelay = banner
+	s.kexDelay = kex
+	s.authDelay = auth
+}
+
+// SetPagedResponse makes the server send a command's output one page at a
+// time, showing prompt after each page but the last and waiting for the
+// client to answer it, then wiping the prompt like an IOS terminal does
+func (s *Server) SetPagedResponse(command, prompt string, pages ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pages == nil {
+		s.pages = make(map[string][]string)
+	}
+	s.pages[command] = pages
+	s.pagerPrompt = prompt
+}
+
+// PagerAnswers returns what the client sent at each pager prompt
+func (s *Server) PagerAnswers() []string {
+	s.pagerMutex.Lock()
+	defer s.pagerMutex.Unlock()
+	return append([]string{}, s.pagerAnswers...)
+}
+
+// SetAlgorithms restricts the algorithms the server negotiates and sets its
+// version banner. It applies to connections accepted afterwards.
+func (s *Server) SetAlgorithms(kex, ciphers, macs []string, version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.KeyExchanges = kex
+	s.config.Ciphers = ciphers
+	s.config.MACs = macs
+	s.config.ServerVersion = version
+}
+
+// SetMaxAuthTries makes the server disconnect after n failed
+// authentication attempts, as OpenSSH does with MaxAuthTries
+func (s *Server) SetMaxAuthTries(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.MaxAuthTries = n
+}
+
+// SetKeyboardInteractive makes the server ask questions over
+// keyboard-interactive and accept the login only when they are answered
+// with answers
+func (s *Server) SetKeyboardInteractive(instruction string, questions, answers []string) {
+	s.SetKeyboardScript(KeyboardRound{Instruction: instruction, Questions: questions, Answers: answers})
+}
+
+// KeyboardRound is one round of keyboard-interactive questions the
+// server asks, with the answers it expects. Echos marks the questions that
+// echo input; unset means none do.
+type KeyboardRound struct {
+	Instruction string
+	Questions   []string
+	Echos       []bool
+	Answers     []string
+}
+
+// SetKeyboardScript makes the server ask the rounds in turn over
+// keyboard-interactive and accept the login only when every round is
+// answered as expected. It applies to connections accepted afterwards.
+func (s *Server) SetKeyboardScript(rounds ...KeyboardRound) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.KeyboardInteractiveCallback = func(c ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		for _, round := range rounds {
+			echos := round.Echos
+			if echos == nil {
+				echos = make([]bool, len(round.Questions))
+			}
+			got, err := challenge(c.User(), round.Instruction, round.Questions, echos)
+			if err != nil {
+				return nil, err
+			}
+			s.recordKeyboardAnswers(got)
+			if strings.Join(got, "\x00") != strings.Join(round.Answers, "\x00") {
+				return nil, fmt.Errorf("wrong answers")
+			}
+		}
+		return nil, nil
+	}
+}
+
+// recordKeyboardAnswers keeps what the client answered in a round
+func (s *Server) recordKeyboardAnswers(answers []string) {
+	s.keyboardMutex.Lock()
+	defer s.keyboardMutex.Unlock()
+	s.keyboardAnswers = append(s.keyboardAnswers, answers...)
+}
+
+// KeyboardAnswers returns every keyboard-interactive answer the server got
+func (s *Server) KeyboardAnswers() []string {
+	s.keyboardMutex.Lock()
+	defer s.keyboardMutex.Unlock()
+	return append([]string{}, s.keyboardAnswers...)
+}
+
+// SetShell makes the server run a switch-like CLI with the given hostname
+// for sessions asking for a shell. Commands are answered from the command
+// responses and enable wants secret, taking delay to complete.
+func (s *Server) SetShell(hostname, secret string, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shellHostname = hostname
+	s.enableSecret = secret
+	s.enableDelay = delay
+}
+
+// ShellLines returns the commands typed into shells, across sessions
+func (s *Server) ShellLines() []string {
+	s.shellMutex.Lock()
+	defer s.shellMutex.Unlock()
+	return append([]string{}, s.shellLines...)
+}
+
+// GetAddress returns the server address
+func (s *Server) GetAddress() string {
+	return s.address
+}
+
+// GetPort returns the server port
+func (s *Server) GetPort() int {
+	return s.port
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// serve handles incoming connections
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handleConnection(conn)
+	}
+}
+
+// handleConnection handles a single SSH connection
+func (s *Server) handleConnection(netConn net.Conn) {
+	defer netConn.Close()
+
+	// The connection keeps the settings it was accepted with
+	s.mu.RLock()
+	shouldFail, bannerDelay := s.shouldFail, s.bannerDelay
+	config := *s.config
+	s.mu.RUnlock()
+
+	if shouldFail {
+		return
+	}
+	time.Sleep(bannerDelay)
+
+	sshConn, chans, reqs, err := ssh.NewServerConn(netConn, &config)
+	if err != nil {
+		return
+	}
+	defer sshConn.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go s.handleSession(channel, requests)
+	}
+}
+
+// handleSession handles a single SSH session
+func (s *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			command := string(req.Payload[4:]) // Skip the length prefix
+			s.mu.RLock()
+			delay := s.delay
+			pages, paged := s.pages[command]
+			prompt := s.pagerPrompt
+			response, exists := s.commands[command]
+			stderr, exitCode := s.stderr[command], s.exitCodes[command]
+			s.mu.RUnlock()
+
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+			if paged {
+				req.Reply(true, nil)
+				s.writePages(channel, prompt, pages)
+				channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
+				return
+			}
+			if !exists {
+				response = fmt.Sprintf("Command not found: %s", command)
+			}
+
+			channel.Write([]byte(response))
+			if stderr != "" {
+				channel.Stderr().Write([]byte(stderr))
+			}
+			status := make([]byte, 4)
+			binary.BigEndian.PutUint32(status, exitCode)
+			channel.SendRequest("exit-status", false, status)
+			req.Reply(true, nil)
+			return
+		case "pty-req":
+			req.Reply(true, nil)
+		case "shell":
+			s.mu.RLock()
+			hostname, secret, enableDelay := s.shellHostname, s.enableSecret, s.enableDelay
+			s.mu.RUnlock()
+			if hostname == "" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			s.runShell(channel, hostname, secret, enableDelay)
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// runShell acts like a switch CLI named hostname: it echoes each line, asks
+// enable for secret, taking enableDelay to complete, and answers other
+// commands from the command responses
+func (s *Server) runShell(channel ssh.Channel, hostname, secret string, enableDelay time.Duration) {
+	reader := bufio.NewReader(channel)
+	readLine := func() (string, bool) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimRight(line, "\r\n"), true
+	}
+	privileged := false
+	prompt := func() {
+		mark := ">"
+		if privileged {
+			mark = "#"
+		}
+		channel.Write([]byte("\r\n" + hostname + mark))
+	}
+
+	channel.Write([]byte("Welcome\r\n"))
+	prompt()
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		s.shellMutex.Lock()
+		s.shellLines = append(s.shellLines, line)
+		s.shellMutex.Unlock()
+		channel.Write([]byte(line + "\r\n"))
+
+		switch line {
+		case "exit":
+			return
+		case "":
+		case "enable":
+			for !privileged {
+				channel.Write([]byte("Password: "))
+				answer, ok := readLine()
+				if !ok {
+					return
+				}
+				if answer != secret {
+					channel.Write([]byte("\r\n% Bad secrets\r\n"))
+					continue
+				}
+				time.Sleep(enableDelay)
+				privileged = true
+			}
+		default:
+			s.mu.RLock()
+			response, exists := s.commands[line]
+			s.mu.RUnlock()
+			if !exists {
+				response = "% Invalid input detected"
+			}
+			channel.Write([]byte(strings.ReplaceAll(response, "\n", "\r\n")))
+		}
+		prompt()
+	}
+}
+
+// writePages sends paged output, waiting for an answer at each prompt
+func (s *Server) writePages(channel ssh.Channel, prompt string, pages []string) {
+	erase := strings.Repeat("\b", len(prompt)) + strings.Repeat(" ", len(prompt)) +
+		strings.Repeat("\b", len(prompt))
+	for i, page := range pages {
+		if i > 0 {
+			channel.Write([]byte(prompt))
+			answer := make([]byte, 16)
+			n, err := channel.Read(answer)
+			if err != nil {
+				return
+			}
+			s.pagerMutex.Lock()
+			s.pagerAnswers = append(s.pagerAnswers, string(answer[:n]))
+			s.pagerMutex.Unlock()
+			channel.Write([]byte(erase))
+		}
+		channel.Write([]byte(page))
+	}
+}
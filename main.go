@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"encoding/json"
 	"log"
 
 	"invictux-demo/internal/app"
@@ -20,6 +21,9 @@ var assets embed.FS
 //go:embed assets/appicon.png
 var icon []byte
 
+//go:embed wails.json
+var wailsConfig []byte
+
 // // AppEnvironment is set at build time through ldflags
 var AppEnvironment string
 
@@ -30,6 +34,20 @@ func main() {
 	}
 	log.Printf("Application starting in '%s' mode", AppEnvironment)
 
+	// The version is productVersion in wails.json, the one Wails stamps on
+	// the binary
+	var config struct {
+		Info struct {
+			ProductVersion string `json:"productVersion"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(wailsConfig, &config); err != nil {
+		log.Fatalf("Failed to read wails.json: %v", err)
+	}
+	if config.Info.ProductVersion != "" {
+		app.AppVersion = config.Info.ProductVersion
+	}
+
 	// Create an instance of the app structure
 	application := app.NewApp(AppEnvironment)
 